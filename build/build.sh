@@ -21,5 +21,5 @@ go generate -x "${PKG}/pkg/smartcitizen/"
 # outside the build container
 go install \
     -v \
-    -ldflags "-X ${PKG}/pkg/version.Version=${VERSION} -X \"${PKG}/pkg/version.BuildDate=${BUILD_DATE}\" -X ${PKG}/pkg/version.BinaryName=${BINARY_NAME}" \
+    -ldflags "-X ${PKG}/pkg/version.Version=${VERSION} -X \"${PKG}/pkg/version.BuildDate=${BUILD_DATE}\" -X ${PKG}/pkg/version.GitCommit=${GIT_COMMIT} -X ${PKG}/pkg/version.BinaryName=${BINARY_NAME}" \
     ./...
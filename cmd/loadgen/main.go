@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/DECODEproject/iotencoder/pkg/loadgen"
+	"github.com/DECODEproject/iotencoder/pkg/logger"
+)
+
+var rootCmd = &cobra.Command{
+	Use:   "loadgen",
+	Short: "Measure the throughput and latency of a running encoder",
+	Long: `This tool publishes synthetic SmartCitizen readings to an MQTT broker at a
+configurable rate, and measures the end-to-end latency of each reading from
+being published to being written by the encoder to a stub datastore served by
+the tool.
+
+A stream writing to the stub datastore is created on the encoder for each
+device, and deleted once the run is over. The encoder must be subscribed to the
+same broker, and able to reach the stub datastore at --datastore-url. Once
+readings have been published for --duration, the tool waits up to --drain for
+the remaining readings to be written, and then writes a report to stdout as
+JSON.
+
+For example:
+
+    $ loadgen --broker-addr tcp://localhost:1883 --encoder-addr http://localhost:8081 \
+        --devices 50 --rate 200 --duration 1m`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		flags := cmd.Flags()
+
+		config := &loadgen.Config{}
+		config.BrokerAddr, _ = flags.GetString("broker-addr")
+		config.BrokerUsername, _ = flags.GetString("broker-username")
+		config.EncoderAddr, _ = flags.GetString("encoder-addr")
+		config.DatastoreAddr, _ = flags.GetString("datastore-addr")
+		config.DatastoreURL, _ = flags.GetString("datastore-url")
+		config.CommunityID, _ = flags.GetString("community-id")
+		config.PublicKey, _ = flags.GetString("public-key")
+		config.Devices, _ = flags.GetInt("devices")
+		config.Rate, _ = flags.GetFloat64("rate")
+		config.Duration, _ = flags.GetDuration("duration")
+		config.Drain, _ = flags.GetDuration("drain")
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		stopChan := make(chan os.Signal, 1)
+		signal.Notify(stopChan, os.Interrupt, syscall.SIGTERM)
+
+		go func() {
+			<-stopChan
+			cancel()
+		}()
+
+		report, err := loadgen.NewGenerator(config, logger.NewLogger()).Run(ctx)
+		if err != nil {
+			return err
+		}
+
+		encoder := json.NewEncoder(cmd.OutOrStdout())
+		encoder.SetIndent("", "  ")
+
+		return encoder.Encode(report)
+	},
+}
+
+func init() {
+	rootCmd.Flags().String("broker-addr", "tcp://localhost:1883", "Address of the MQTT broker to which readings are published")
+	rootCmd.Flags().String("broker-username", "", "Username with which we connect to the MQTT broker")
+	rootCmd.Flags().String("encoder-addr", "http://localhost:8081", "Address at which the encoder is listening")
+	rootCmd.Flags().String("datastore-addr", ":8090", "Address on which the stub datastore listens")
+	rootCmd.Flags().String("datastore-url", "http://localhost:8090", "Address at which the encoder reaches the stub datastore")
+	rootCmd.Flags().String("community-id", loadgen.DefaultCommunityID, "Id of the community of the streams created")
+	rootCmd.Flags().String("public-key", loadgen.DefaultPublicKey, "Public key with which the streams created encrypt data")
+	rootCmd.Flags().Int("devices", 10, "Number of devices for which readings are published")
+	rootCmd.Flags().Float64("rate", 10, "Number of readings published per second, across all devices")
+	rootCmd.Flags().Duration("duration", 30*time.Second, "How long readings are published for")
+	rootCmd.Flags().Duration("drain", 30*time.Second, "How long to wait for readings to be written once publishing stops")
+}
+
+func main() {
+	if err := rootCmd.Execute(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,1399 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	encoder "github.com/thingful/twirp-encoder-go"
+	"github.com/twitchtv/twirp"
+	goji "goji.io"
+	"goji.io/pat"
+
+	"github.com/DECODEproject/iotencoder/pkg/conversion"
+	"github.com/DECODEproject/iotencoder/pkg/ingest"
+	"github.com/DECODEproject/iotencoder/pkg/labels"
+	"github.com/DECODEproject/iotencoder/pkg/logger"
+	"github.com/DECODEproject/iotencoder/pkg/logger/level"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/pubkey"
+	"github.com/DECODEproject/iotencoder/pkg/replay"
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+	"github.com/DECODEproject/iotencoder/pkg/smartcitizen"
+	"github.com/DECODEproject/iotencoder/pkg/stats"
+)
+
+const (
+	// PathPrefix is the path under which the admin API is mounted.
+	PathPrefix = "/admin/"
+
+	// DefaultAuditLimit is the number of audit entries returned by AuditLog if
+	// no limit is requested.
+	DefaultAuditLimit = 100
+
+	// MaxAuditLimit is the maximum number of audit entries which may be returned
+	// by a single call to AuditLog.
+	MaxAuditLimit = 1000
+)
+
+// StatsProvider is the interface we require of a type able to return the
+// current stats for a stream and status of a device. We define it here where we
+// need it, and it is satisfied by the stats.Store type.
+type StatsProvider interface {
+	Get(streamID string) *postgres.StreamStats
+	GetDevice(deviceToken secret.Secret) *postgres.DeviceStatus
+}
+
+// Replayer is the interface we require of a type able to replay retained
+// payloads for a stream and report on progress. It is satisfied by the
+// replay.Replayer type.
+type Replayer interface {
+	Replay(streamID, token string, start, end time.Time) (*replay.Job, error)
+	Status(jobID string) *replay.Job
+}
+
+// Ingester is the interface we require of a type able to process a batch of
+// historical readings for a stream. It is satisfied by the ingest.Ingester
+// type.
+type Ingester interface {
+	WriteReadings(ctx context.Context, streamID, token string, readings []smartcitizen.SensorData) (*ingest.Result, error)
+}
+
+// StreamSource is the interface we require of a type able to list and load
+// streams. It is satisfied by the postgres.DB type.
+type StreamSource interface {
+	ListStreams() ([]*postgres.Stream, error)
+	ListDeviceStreams(deviceToken secret.Secret) ([]*postgres.Stream, error)
+	GetStream(streamID, token string) (*postgres.Stream, error)
+}
+
+// ConversionSetter is the interface we require of a type able to replace the
+// conversion expressions of a stream. It is satisfied by the postgres.DB type.
+type ConversionSetter interface {
+	SetConversions(streamID, token string, conversions postgres.Conversions) error
+}
+
+// IngestSecretSetter is the interface we require of a type able to replace the
+// secret used to verify payloads pushed over HTTP for a stream. It is
+// satisfied by the postgres.DB type.
+type IngestSecretSetter interface {
+	SetIngestSecret(streamID, token string, ingestSecret secret.Secret) error
+}
+
+// StreamRestorer is the interface we require of a type able to restore a
+// deleted stream, resubscribing to its device. Returned errors are expected to
+// be twirp errors. It is satisfied by the encoder implementation in the rpc
+// package.
+type StreamRestorer interface {
+	RestoreStream(ctx context.Context, streamID, token string) (*postgres.Stream, error)
+}
+
+// StreamExporter is the interface we require of a type able to read every live
+// stream along with its token and ingest secret. It is satisfied by the
+// postgres.DB type.
+type StreamExporter interface {
+	ExportStreams(fn func(stream *postgres.Stream) error) error
+}
+
+// StreamImporter is the interface we require of a type able to register a
+// stream exported from another encoder, subscribing to its device. Returned
+// errors are expected to be twirp errors. It is satisfied by the encoder
+// implementation in the rpc package.
+type StreamImporter interface {
+	ImportStream(ctx context.Context, stream *postgres.Stream) (*postgres.Stream, error)
+}
+
+// StreamDeleter is the interface we require of a type able to delete a stream,
+// unsubscribing from its device. Returned errors are expected to be twirp
+// errors. It is satisfied by the encoder implementation in the rpc package.
+type StreamDeleter interface {
+	DeleteStream(ctx context.Context, req *encoder.DeleteStreamRequest) (*encoder.DeleteStreamResponse, error)
+}
+
+// Auditor is the interface we call to record calls which mutate streams in the
+// audit log. It is satisfied by the audit.Recorder type.
+type Auditor interface {
+	Record(ctx context.Context, method, streamID string, params interface{}, err error)
+}
+
+// AuditLog is the interface we require of a type able to read back the audit
+// log. It is satisfied by the postgres.DB type.
+type AuditLog interface {
+	GetAuditEntries(streamID string, start, end time.Time, afterID int64, limit int) ([]*postgres.AuditEntry, error)
+}
+
+// LevelSetter is the interface we require of a type able to report and change
+// the current log level at runtime. It is satisfied by the logger.Leveler type.
+type LevelSetter interface {
+	Level() level.Level
+	SetLevel(lvl level.Level)
+}
+
+// MaintenanceSetter is the interface we require of a type able to report and
+// toggle maintenance mode at runtime. It is satisfied by the rpc.Maintenance
+// type.
+type MaintenanceSetter interface {
+	Enabled() bool
+	SetEnabled(enabled bool)
+}
+
+// SchemaRegistry is the interface we require of a type able to register and
+// resolve payload schemas. It is satisfied by the schema.Registry type.
+type SchemaRegistry interface {
+	Register(kind string, channels postgres.Channels) (*postgres.Schema, error)
+	Resolve(ref string) (*postgres.Schema, error)
+	List() ([]*postgres.Schema, error)
+}
+
+// Admin exposes operational RPCs that sit alongside the Encoder twirp service.
+// As the Encoder protocol buffer definition lives in an external package, these
+// methods are exposed as JSON over HTTP using the same request and error
+// conventions as twirp's JSON API.
+type Admin struct {
+	logger      kitlog.Logger
+	token       secret.Secret
+	exportKey   secret.Secret
+	stats       StatsProvider
+	replay      Replayer
+	ingester    Ingester
+	streams     StreamSource
+	conversions ConversionSetter
+	secrets     IngestSecretSetter
+	restorer    StreamRestorer
+	exporter    StreamExporter
+	importer    StreamImporter
+	deleter     StreamDeleter
+	client      HTTPClient
+	auditor     Auditor
+	auditLog    AuditLog
+	levels      LevelSetter
+	maintenance MaintenanceSetter
+	schemas     SchemaRegistry
+	events      EventLog
+	analytics   AnalyticsExporter
+	settings    SettingsReloader
+	profiling   bool
+}
+
+// Config is a struct used to pass in configuration when creating the admin
+// component.
+type Config struct {
+	Token       secret.Secret
+	ExportKey   secret.Secret
+	Stats       StatsProvider
+	Replay      Replayer
+	Ingester    Ingester
+	Streams     StreamSource
+	Conversions ConversionSetter
+	Secrets     IngestSecretSetter
+	Restorer    StreamRestorer
+	Exporter    StreamExporter
+	Importer    StreamImporter
+	Deleter     StreamDeleter
+	HTTPClient  HTTPClient
+	Auditor     Auditor
+	AuditLog    AuditLog
+	Levels      LevelSetter
+	Maintenance MaintenanceSetter
+	Schemas     SchemaRegistry
+	Events      EventLog
+	Analytics   AnalyticsExporter
+	Settings    SettingsReloader
+	Profiling   bool
+}
+
+// NewAdmin returns a newly instantiated Admin instance. It takes as parameters
+// a Config object containing our dependencies, and a logger.
+func NewAdmin(config *Config, logger kitlog.Logger) *Admin {
+	logger = kitlog.With(logger, "module", "admin")
+
+	logger.Log("msg", "creating admin")
+
+	return &Admin{
+		logger:      logger,
+		token:       config.Token,
+		exportKey:   config.ExportKey,
+		stats:       config.Stats,
+		replay:      config.Replay,
+		ingester:    config.Ingester,
+		streams:     config.Streams,
+		conversions: config.Conversions,
+		secrets:     config.Secrets,
+		restorer:    config.Restorer,
+		exporter:    config.Exporter,
+		importer:    config.Importer,
+		deleter:     config.Deleter,
+		client:      config.HTTPClient,
+		auditor:     config.Auditor,
+		auditLog:    config.AuditLog,
+		levels:      config.Levels,
+		maintenance: config.Maintenance,
+		schemas:     config.Schemas,
+		events:      config.Events,
+		analytics:   config.Analytics,
+		settings:    config.Settings,
+		profiling:   config.Profiling,
+	}
+}
+
+// Handler returns an http.Handler that exposes the admin methods to callers
+// presenting our token as a bearer token. The handler is intended to be
+// mounted under PathPrefix. If profiling is enabled the net/http/pprof
+// handlers are also exposed under ProfilingPath.
+func (a *Admin) Handler() http.Handler {
+	mux := goji.SubMux()
+
+	mux.HandleFunc(pat.Post("/StreamStats"), a.handleStreamStats)
+	mux.HandleFunc(pat.Post("/DeviceStatus"), a.handleDeviceStatus)
+	mux.HandleFunc(pat.Post("/ReplayStream"), a.handleReplayStream)
+	mux.HandleFunc(pat.Post("/ReplayStatus"), a.handleReplayStatus)
+	mux.HandleFunc(pat.Post("/WriteReadings"), a.handleWriteReadings)
+	mux.HandleFunc(pat.Post("/ListStreams"), a.handleListStreams)
+	mux.HandleFunc(pat.Post("/GetStream"), a.handleGetStream)
+	mux.HandleFunc(pat.Post("/SetConversions"), a.handleSetConversions)
+	mux.HandleFunc(pat.Post("/RotateIngestSecret"), a.handleRotateIngestSecret)
+	mux.HandleFunc(pat.Post("/RestoreStream"), a.handleRestoreStream)
+	mux.HandleFunc(pat.Post("/ExportStreams"), a.handleExportStreams)
+	mux.HandleFunc(pat.Post("/ImportStreams"), a.handleImportStreams)
+	mux.HandleFunc(pat.Post("/MigrateStreams"), a.handleMigrateStreams)
+	mux.HandleFunc(pat.Post("/AuditLog"), a.handleAuditLog)
+	mux.HandleFunc(pat.Post("/GetLogLevel"), a.handleGetLogLevel)
+	mux.HandleFunc(pat.Post("/SetLogLevel"), a.handleSetLogLevel)
+	mux.HandleFunc(pat.Post("/GetMaintenance"), a.handleGetMaintenance)
+	mux.HandleFunc(pat.Post("/SetMaintenance"), a.handleSetMaintenance)
+	mux.HandleFunc(pat.Post("/RegisterSchema"), a.handleRegisterSchema)
+	mux.HandleFunc(pat.Post("/ListSchemas"), a.handleListSchemas)
+	mux.HandleFunc(pat.Post("/GetSchema"), a.handleGetSchema)
+	mux.HandleFunc(pat.Post("/RecentEvents"), a.handleRecentEvents)
+	mux.HandleFunc(pat.Post("/ExportAnalytics"), a.handleExportAnalytics)
+	mux.HandleFunc(pat.Post("/ReloadSettings"), a.handleReloadSettings)
+
+	if a.profiling {
+		handleProfiling(mux)
+	}
+
+	return a.authenticate(mux)
+}
+
+// authenticate wraps the given handler so that it is only called for requests
+// presenting our token as a bearer token. If no token is configured every
+// request is refused, so the admin API is never exposed without
+// authentication.
+func (a *Admin) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if a.token == "" || !strings.HasPrefix(auth, "Bearer ") || subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(a.token.Reveal())) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			a.writeError(w, twirp.NewError(twirp.Unauthenticated, "a valid admin token is required"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// StreamStatsRequest is the request type for the StreamStats method.
+type StreamStatsRequest struct {
+	StreamUid string `json:"stream_uid"`
+}
+
+// StreamStatsResponse is the response type for the StreamStats method,
+// containing the current counters we hold for a stream.
+type StreamStatsResponse struct {
+	StreamUid        string     `json:"stream_uid"`
+	MessagesReceived uint64     `json:"messages_received"`
+	BytesEncrypted   uint64     `json:"bytes_encrypted"`
+	WritesSucceeded  uint64     `json:"writes_succeeded"`
+	WritesFailed     uint64     `json:"writes_failed"`
+	Throttled        uint64     `json:"messages_throttled"`
+	Dropped          uint64     `json:"messages_dropped"`
+	LastMessageAt    *time.Time `json:"last_message_at,omitempty"`
+	AverageLatencyMs float64    `json:"average_latency_ms"`
+}
+
+// StreamStats returns the current counters held for the requested stream.
+// Counters are maintained in memory and periodically persisted, so values are
+// approximate across restarts.
+func (a *Admin) StreamStats(ctx context.Context, req *StreamStatsRequest) (*StreamStatsResponse, error) {
+	if req.StreamUid == "" {
+		return nil, twirp.RequiredArgumentError("stream_uid")
+	}
+
+	st := a.stats.Get(req.StreamUid)
+	if st == nil {
+		return nil, twirp.NotFoundError("no stats recorded for stream")
+	}
+
+	resp := &StreamStatsResponse{
+		StreamUid:        st.StreamID,
+		MessagesReceived: st.MessagesReceived,
+		BytesEncrypted:   st.BytesEncrypted,
+		WritesSucceeded:  st.WritesSucceeded,
+		WritesFailed:     st.WritesFailed,
+		Throttled:        st.Throttled,
+		Dropped:          st.Dropped,
+		AverageLatencyMs: st.AverageLatency().Seconds() * 1e3,
+	}
+
+	if st.LastMessageAt.Valid {
+		resp.LastMessageAt = &st.LastMessageAt.Time
+	}
+
+	return resp, nil
+}
+
+func (a *Admin) handleStreamStats(w http.ResponseWriter, r *http.Request) {
+	var req StreamStatsRequest
+
+	err := decodeRequest(r, &req)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	resp, err := a.StreamStats(r.Context(), &req)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	a.writeResponse(w, resp)
+}
+
+// DeviceStatusRequest is the request type for the DeviceStatus method.
+type DeviceStatusRequest struct {
+	DeviceToken string `json:"device_token"`
+}
+
+// DeviceStatusResponse is the response type for the DeviceStatus method,
+// describing how recently and how often we have received messages from a
+// device, and whether processing its last message failed.
+type DeviceStatusResponse struct {
+	LastSeenAt        *time.Time `json:"last_seen_at,omitempty"`
+	MessagesLastHour  uint64     `json:"messages_last_hour"`
+	MessagesPerMinute float64    `json:"messages_per_minute"`
+	Error             string     `json:"error,omitempty"`
+	ErrorAt           *time.Time `json:"error_at,omitempty"`
+}
+
+// DeviceStatus returns the current status of the requested device, intended
+// for dashboards displaying the freshness of a community's devices. As with
+// StreamStats the status is maintained in memory and periodically persisted.
+func (a *Admin) DeviceStatus(ctx context.Context, req *DeviceStatusRequest) (*DeviceStatusResponse, error) {
+	if req.DeviceToken == "" {
+		return nil, twirp.RequiredArgumentError("device_token")
+	}
+
+	st := a.stats.GetDevice(secret.Secret(req.DeviceToken))
+	if st == nil {
+		return nil, twirp.NotFoundError("no messages received from device")
+	}
+
+	messages := st.MessageCounts.Total()
+
+	resp := &DeviceStatusResponse{
+		MessagesLastHour:  messages,
+		MessagesPerMinute: float64(messages) / stats.RateWindow.Minutes(),
+		Error:             st.Error.String,
+	}
+
+	if st.LastSeenAt.Valid {
+		resp.LastSeenAt = &st.LastSeenAt.Time
+	}
+
+	if st.ErrorAt.Valid {
+		resp.ErrorAt = &st.ErrorAt.Time
+	}
+
+	return resp, nil
+}
+
+func (a *Admin) handleDeviceStatus(w http.ResponseWriter, r *http.Request) {
+	var req DeviceStatusRequest
+
+	err := decodeRequest(r, &req)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	resp, err := a.DeviceStatus(r.Context(), &req)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	a.writeResponse(w, resp)
+}
+
+// ReplayStreamRequest is the request type for the ReplayStream method. The
+// stream's token must be supplied, and if no end time is given we replay up to
+// the current time.
+type ReplayStreamRequest struct {
+	StreamUid string    `json:"stream_uid"`
+	Token     string    `json:"token"`
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+}
+
+// ReplayStreamResponse is the response type for the ReplayStream method,
+// containing the id of the job which can be passed to ReplayStatus.
+type ReplayStreamResponse struct {
+	JobId string `json:"job_id"`
+	Total int    `json:"total"`
+}
+
+// ReplayStatusRequest is the request type for the ReplayStatus method.
+type ReplayStatusRequest struct {
+	JobId string `json:"job_id"`
+}
+
+// ReplayStatusResponse is the response type for the ReplayStatus method,
+// reporting the progress of a replay.
+type ReplayStatusResponse struct {
+	JobId      string     `json:"job_id"`
+	StreamUid  string     `json:"stream_uid"`
+	State      string     `json:"state"`
+	Total      int        `json:"total"`
+	Processed  int        `json:"processed"`
+	Failed     int        `json:"failed"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// ReplayStream starts re-processing all raw payloads retained for a stream's
+// device within the requested interval, encrypting them using the stream's
+// current keys and writing them to the datastore. This is used when a
+// community rotates its keys and needs historic data re-encrypted. The replay
+// runs asynchronously; progress can be polled via ReplayStatus.
+func (a *Admin) ReplayStream(ctx context.Context, req *ReplayStreamRequest) (*ReplayStreamResponse, error) {
+	if a.replay == nil {
+		return nil, twirp.NewError(twirp.Unimplemented, "replay is not enabled")
+	}
+
+	if req.StreamUid == "" {
+		return nil, twirp.RequiredArgumentError("stream_uid")
+	}
+
+	if req.Token == "" {
+		return nil, twirp.RequiredArgumentError("token")
+	}
+
+	if req.StartTime.IsZero() {
+		return nil, twirp.RequiredArgumentError("start_time")
+	}
+
+	endTime := req.EndTime
+	if endTime.IsZero() {
+		endTime = time.Now()
+	}
+
+	if !endTime.After(req.StartTime) {
+		return nil, twirp.InvalidArgumentError("end_time", "must be after start_time")
+	}
+
+	job, err := a.replay.Replay(req.StreamUid, req.Token, req.StartTime, endTime)
+	if err != nil {
+		if errors.Cause(err) == postgres.ErrStreamNotFound {
+			return nil, twirp.NotFoundError("stream not found")
+		}
+		return nil, twirp.InternalErrorWith(err)
+	}
+
+	return &ReplayStreamResponse{
+		JobId: job.ID,
+		Total: job.Total,
+	}, nil
+}
+
+// ReplayStatus returns the current progress of a replay job.
+func (a *Admin) ReplayStatus(ctx context.Context, req *ReplayStatusRequest) (*ReplayStatusResponse, error) {
+	if a.replay == nil {
+		return nil, twirp.NewError(twirp.Unimplemented, "replay is not enabled")
+	}
+
+	if req.JobId == "" {
+		return nil, twirp.RequiredArgumentError("job_id")
+	}
+
+	job := a.replay.Status(req.JobId)
+	if job == nil {
+		return nil, twirp.NotFoundError("replay job not found")
+	}
+
+	resp := &ReplayStatusResponse{
+		JobId:     job.ID,
+		StreamUid: job.StreamID,
+		State:     job.State,
+		Total:     job.Total,
+		Processed: job.Processed,
+		Failed:    job.Failed,
+		StartedAt: job.StartedAt,
+		Error:     job.Err,
+	}
+
+	if !job.FinishedAt.IsZero() {
+		resp.FinishedAt = &job.FinishedAt
+	}
+
+	return resp, nil
+}
+
+func (a *Admin) handleReplayStream(w http.ResponseWriter, r *http.Request) {
+	var req ReplayStreamRequest
+
+	err := decodeRequest(r, &req)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	resp, err := a.ReplayStream(r.Context(), &req)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	a.writeResponse(w, resp)
+}
+
+func (a *Admin) handleReplayStatus(w http.ResponseWriter, r *http.Request) {
+	var req ReplayStatusRequest
+
+	err := decodeRequest(r, &req)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	resp, err := a.ReplayStatus(r.Context(), &req)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	a.writeResponse(w, resp)
+}
+
+// WriteReadingsRequest is the request type for the WriteReadings method. Each
+// reading has the same form as the data published by SmartCitizen devices over
+// MQTT, i.e. a recorded_at timestamp and a list of sensor ids and values.
+type WriteReadingsRequest struct {
+	StreamUid string                    `json:"stream_uid"`
+	Token     string                    `json:"token"`
+	Readings  []smartcitizen.SensorData `json:"readings"`
+}
+
+// WriteReadingsResponse is the response type for the WriteReadings method,
+// reporting how many readings were processed and how many of those failed.
+type WriteReadingsResponse struct {
+	Processed int `json:"processed"`
+	Failed    int `json:"failed"`
+}
+
+// WriteReadings accepts a batch of historical readings for a stream, as
+// uploaded by devices which buffer data while offline, and passes each through
+// the same processing and encryption pipeline as live MQTT messages before
+// writing it to the stream's datastore. The batch is processed before we
+// respond, so large backfills should be split into several requests.
+func (a *Admin) WriteReadings(ctx context.Context, req *WriteReadingsRequest) (*WriteReadingsResponse, error) {
+	if a.ingester == nil {
+		return nil, twirp.NewError(twirp.Unimplemented, "ingestion is not enabled")
+	}
+
+	if req.StreamUid == "" {
+		return nil, twirp.RequiredArgumentError("stream_uid")
+	}
+
+	if req.Token == "" {
+		return nil, twirp.RequiredArgumentError("token")
+	}
+
+	if len(req.Readings) == 0 {
+		return nil, twirp.RequiredArgumentError("readings")
+	}
+
+	for _, reading := range req.Readings {
+		if reading.RecordedAt.IsZero() {
+			return nil, twirp.InvalidArgumentError("readings", "must all have a recorded_at time")
+		}
+	}
+
+	result, err := a.ingester.WriteReadings(ctx, req.StreamUid, req.Token, req.Readings)
+	if err != nil {
+		switch errors.Cause(err) {
+		case postgres.ErrStreamNotFound:
+			return nil, twirp.NotFoundError("stream not found")
+		case ingest.ErrBatchTooLarge:
+			return nil, twirp.InvalidArgumentError("readings", "contains too many readings")
+		}
+		return nil, twirp.InternalErrorWith(err)
+	}
+
+	return &WriteReadingsResponse{
+		Processed: result.Processed,
+		Failed:    result.Failed,
+	}, nil
+}
+
+func (a *Admin) handleWriteReadings(w http.ResponseWriter, r *http.Request) {
+	var req WriteReadingsRequest
+
+	err := decodeRequest(r, &req)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	resp, err := a.WriteReadings(r.Context(), &req)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	a.writeResponse(w, resp)
+}
+
+// Stream is the representation of a stream returned by the ListStreams and
+// GetStream methods. Stream tokens are never returned, and device tokens are
+// returned only as the hash we log them by, so that operators can correlate
+// streams with their devices without the admin API disclosing the tokens.
+type Stream struct {
+	StreamUid            string                `json:"stream_uid"`
+	CommunityId          string                `json:"community_id"`
+	RecipientPublicKey   string                `json:"recipient_public_key"`
+	PublicKeyFingerprint string                `json:"public_key_fingerprint,omitempty"`
+	DeviceHash           string                `json:"device_hash"`
+	DeviceLabel          string                `json:"device_label"`
+	Longitude            float64               `json:"longitude"`
+	Latitude             float64               `json:"latitude"`
+	Exposure             string                `json:"exposure"`
+	Operations           []*postgres.Operation `json:"operations"`
+	DatastoreAddr        string                `json:"datastore_addr,omitempty"`
+	Conversions          map[uint32]string     `json:"conversions,omitempty"`
+	Source               string                `json:"source,omitempty"`
+	Compression          string                `json:"compression,omitempty"`
+	TimestampPolicy      string                `json:"timestamp_policy,omitempty"`
+	PolicyID             string                `json:"policy_id,omitempty"`
+	Labels               map[string]string     `json:"labels,omitempty"`
+	DatastoreTimeout     string                `json:"datastore_timeout,omitempty"`
+	Schema               string                `json:"schema,omitempty"`
+	Dispositions         postgres.Dispositions `json:"dispositions,omitempty"`
+	Privacy              string                `json:"privacy,omitempty"`
+	GeoPrivacy           string                `json:"geo_privacy,omitempty"`
+	RateLimit            string                `json:"rate_limit,omitempty"`
+	Sampling             string                `json:"sampling,omitempty"`
+	Schedule             string                `json:"schedule,omitempty"`
+	Geofence             string                `json:"geofence,omitempty"`
+	Archive              bool                  `json:"archive,omitempty"`
+	Priority             string                `json:"priority,omitempty"`
+	TopicTemplate        string                `json:"topic_template,omitempty"`
+	QoS                  int                   `json:"qos"`
+}
+
+// ListStreamsRequest is the request type for the ListStreams method. If
+// DeviceToken is set only the streams of that device are returned, and if
+// Selector is set only those streams whose labels match it, in the form parsed
+// by labels.ParseSelector.
+type ListStreamsRequest struct {
+	DeviceToken string `json:"device_token,omitempty"`
+	Selector    string `json:"selector,omitempty"`
+}
+
+// ListStreamsResponse is the response type for the ListStreams method.
+type ListStreamsResponse struct {
+	Streams []*Stream `json:"streams"`
+}
+
+// GetStreamRequest is the request type for the GetStream method. As with
+// DeleteStream the stream's token must be supplied.
+type GetStreamRequest struct {
+	StreamUid string `json:"stream_uid"`
+	Token     string `json:"token"`
+}
+
+// ListStreams returns all streams registered with the encoder, or those of a
+// single device if a device token is given, filtered by any label selector.
+func (a *Admin) ListStreams(ctx context.Context, req *ListStreamsRequest) (*ListStreamsResponse, error) {
+	if a.streams == nil {
+		return nil, twirp.NewError(twirp.Unimplemented, "streams are not available")
+	}
+
+	selector, err := labels.ParseSelector(req.Selector)
+	if err != nil {
+		return nil, twirp.InvalidArgumentError("selector", err.Error())
+	}
+
+	var streams []*postgres.Stream
+
+	if req.DeviceToken != "" {
+		streams, err = a.streams.ListDeviceStreams(secret.Secret(req.DeviceToken))
+	} else {
+		streams, err = a.streams.ListStreams()
+	}
+
+	if err != nil {
+		return nil, twirp.InternalErrorWith(err)
+	}
+
+	resp := &ListStreamsResponse{
+		Streams: []*Stream{},
+	}
+
+	for _, s := range streams {
+		if !selector.Matches(s.Labels) {
+			continue
+		}
+
+		resp.Streams = append(resp.Streams, newStream(s))
+	}
+
+	return resp, nil
+}
+
+// GetStream returns a single stream identified by its uid and token.
+func (a *Admin) GetStream(ctx context.Context, req *GetStreamRequest) (*Stream, error) {
+	if a.streams == nil {
+		return nil, twirp.NewError(twirp.Unimplemented, "streams are not available")
+	}
+
+	if req.StreamUid == "" {
+		return nil, twirp.RequiredArgumentError("stream_uid")
+	}
+
+	if req.Token == "" {
+		return nil, twirp.RequiredArgumentError("token")
+	}
+
+	stream, err := a.streams.GetStream(req.StreamUid, req.Token)
+	if err != nil {
+		if errors.Cause(err) == postgres.ErrStreamNotFound {
+			return nil, twirp.NotFoundError("stream not found")
+		}
+		return nil, twirp.InternalErrorWith(err)
+	}
+
+	return newStream(stream), nil
+}
+
+func (a *Admin) handleListStreams(w http.ResponseWriter, r *http.Request) {
+	var req ListStreamsRequest
+
+	err := decodeRequest(r, &req)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	resp, err := a.ListStreams(r.Context(), &req)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	a.writeResponse(w, resp)
+}
+
+func (a *Admin) handleGetStream(w http.ResponseWriter, r *http.Request) {
+	var req GetStreamRequest
+
+	err := decodeRequest(r, &req)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	resp, err := a.GetStream(r.Context(), &req)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	a.writeResponse(w, resp)
+}
+
+// SetConversionsRequest is the request type for the SetConversions method. The
+// supplied conversions replace any existing conversions for the stream, so an
+// empty map removes them all.
+type SetConversionsRequest struct {
+	StreamUid   string            `json:"stream_uid"`
+	Token       string            `json:"token"`
+	Conversions map[uint32]string `json:"conversions"`
+}
+
+// SetConversions replaces the conversion expressions applied to readings of
+// the stream's sensors before any operations are applied and the data is
+// encrypted. Each expression is validated before being saved, and the updated
+// stream is returned. As the device is loaded for every message received the
+// new conversions apply from the next message onwards.
+func (a *Admin) SetConversions(ctx context.Context, req *SetConversionsRequest) (_ *Stream, err error) {
+	defer func() {
+		a.audit(ctx, "SetConversions", req.StreamUid, req, err)
+	}()
+
+	if a.streams == nil || a.conversions == nil {
+		return nil, twirp.NewError(twirp.Unimplemented, "conversions are not available")
+	}
+
+	if req.StreamUid == "" {
+		return nil, twirp.RequiredArgumentError("stream_uid")
+	}
+
+	if req.Token == "" {
+		return nil, twirp.RequiredArgumentError("token")
+	}
+
+	for sensorID, expr := range req.Conversions {
+		_, err := conversion.Parse(expr)
+		if err != nil {
+			return nil, twirp.InvalidArgumentError("conversions", fmt.Sprintf("sensor %v: %v", sensorID, err))
+		}
+	}
+
+	err = a.conversions.SetConversions(req.StreamUid, req.Token, postgres.Conversions(req.Conversions))
+	if err != nil {
+		if errors.Cause(err) == postgres.ErrStreamNotFound {
+			return nil, twirp.NotFoundError("stream not found")
+		}
+		return nil, twirp.InternalErrorWith(err)
+	}
+
+	return a.GetStream(ctx, &GetStreamRequest{StreamUid: req.StreamUid, Token: req.Token})
+}
+
+func (a *Admin) handleSetConversions(w http.ResponseWriter, r *http.Request) {
+	var req SetConversionsRequest
+
+	err := decodeRequest(r, &req)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	resp, err := a.SetConversions(r.Context(), &req)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	a.writeResponse(w, resp)
+}
+
+// RotateIngestSecretRequest is the request type for the RotateIngestSecret
+// method.
+type RotateIngestSecretRequest struct {
+	StreamUid string `json:"stream_uid"`
+	Token     string `json:"token"`
+}
+
+// RotateIngestSecretResponse is the response type for the RotateIngestSecret
+// method. The secret is only ever returned here, so must be recorded by the
+// caller.
+type RotateIngestSecretResponse struct {
+	StreamUid    string `json:"stream_uid"`
+	IngestSecret string `json:"ingest_secret"`
+}
+
+// RotateIngestSecret generates a new secret with which payloads pushed over
+// HTTP for the stream must be signed, replacing any existing secret. This both
+// enables HTTP push for a stream and revokes a leaked secret, and as the device
+// is loaded for every request the new secret applies immediately.
+func (a *Admin) RotateIngestSecret(ctx context.Context, req *RotateIngestSecretRequest) (_ *RotateIngestSecretResponse, err error) {
+	defer func() {
+		a.audit(ctx, "RotateIngestSecret", req.StreamUid, req, err)
+	}()
+
+	if a.secrets == nil {
+		return nil, twirp.NewError(twirp.Unimplemented, "ingest secrets are not available")
+	}
+
+	if req.StreamUid == "" {
+		return nil, twirp.RequiredArgumentError("stream_uid")
+	}
+
+	if req.Token == "" {
+		return nil, twirp.RequiredArgumentError("token")
+	}
+
+	ingestSecret, err := postgres.GenerateToken(postgres.TokenLength)
+	if err != nil {
+		return nil, twirp.InternalErrorWith(err)
+	}
+
+	err = a.secrets.SetIngestSecret(req.StreamUid, req.Token, secret.Secret(ingestSecret))
+	if err != nil {
+		if errors.Cause(err) == postgres.ErrStreamNotFound {
+			return nil, twirp.NotFoundError("stream not found")
+		}
+		return nil, twirp.InternalErrorWith(err)
+	}
+
+	a.logger.Log("msg", "rotated ingest secret", "stream_uid", req.StreamUid)
+
+	return &RotateIngestSecretResponse{
+		StreamUid:    req.StreamUid,
+		IngestSecret: ingestSecret,
+	}, nil
+}
+
+func (a *Admin) handleRotateIngestSecret(w http.ResponseWriter, r *http.Request) {
+	var req RotateIngestSecretRequest
+
+	err := decodeRequest(r, &req)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	resp, err := a.RotateIngestSecret(r.Context(), &req)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	a.writeResponse(w, resp)
+}
+
+// RestoreStreamRequest is the request type for the RestoreStream method.
+type RestoreStreamRequest struct {
+	StreamUid string `json:"stream_uid"`
+	Token     string `json:"token"`
+}
+
+// RestoreStreamResponse is the response type for the RestoreStream method.
+type RestoreStreamResponse struct {
+	StreamUid string `json:"stream_uid"`
+}
+
+// RestoreStream restores a deleted stream whose grace period has not yet
+// expired, after which readings from its device are once again processed for
+// it. The stream's existing token remains valid.
+func (a *Admin) RestoreStream(ctx context.Context, req *RestoreStreamRequest) (*RestoreStreamResponse, error) {
+	if a.restorer == nil {
+		return nil, twirp.NewError(twirp.Unimplemented, "stream restore is not available")
+	}
+
+	stream, err := a.restorer.RestoreStream(ctx, req.StreamUid, req.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	a.logger.Log("msg", "restored stream", "stream_uid", stream.StreamID)
+
+	return &RestoreStreamResponse{
+		StreamUid: stream.StreamID,
+	}, nil
+}
+
+func (a *Admin) handleRestoreStream(w http.ResponseWriter, r *http.Request) {
+	var req RestoreStreamRequest
+
+	err := decodeRequest(r, &req)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	resp, err := a.RestoreStream(r.Context(), &req)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	a.writeResponse(w, resp)
+}
+
+// AuditLogRequest is the request type for the AuditLog method. All fields are
+// optional: if no stream is given entries for all streams are returned, if no
+// end time is given entries up to the current time are returned, and if no
+// limit is given DefaultAuditLimit is used. Further pages are requested by
+// passing the id of the last entry received as after_id.
+type AuditLogRequest struct {
+	StreamUid string    `json:"stream_uid"`
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+	AfterId   int64     `json:"after_id"`
+	Limit     int       `json:"limit"`
+}
+
+// AuditLogResponse is the response type for the AuditLog method.
+type AuditLogResponse struct {
+	Entries []*AuditEntry `json:"entries"`
+}
+
+// AuditEntry is our API representation of a single entry of the audit log.
+// Secret request parameters are redacted before being recorded.
+type AuditEntry struct {
+	Id         int64                  `json:"id"`
+	RecordedAt time.Time              `json:"recorded_at"`
+	Caller     string                 `json:"caller"`
+	RequestId  string                 `json:"request_id,omitempty"`
+	Method     string                 `json:"method"`
+	StreamUid  string                 `json:"stream_uid,omitempty"`
+	Params     map[string]interface{} `json:"params"`
+	Result     string                 `json:"result"`
+	Error      string                 `json:"error,omitempty"`
+}
+
+// AuditLog returns a page of the audit trail of calls which created, deleted,
+// restored or updated streams, oldest first.
+func (a *Admin) AuditLog(ctx context.Context, req *AuditLogRequest) (*AuditLogResponse, error) {
+	if a.auditLog == nil {
+		return nil, twirp.NewError(twirp.Unimplemented, "audit log is not available")
+	}
+
+	limit := req.Limit
+	if limit == 0 {
+		limit = DefaultAuditLimit
+	}
+
+	if limit < 0 || limit > MaxAuditLimit {
+		return nil, twirp.InvalidArgumentError("limit", fmt.Sprintf("must be between 1 and %d", MaxAuditLimit))
+	}
+
+	endTime := req.EndTime
+	if endTime.IsZero() {
+		endTime = time.Now()
+	}
+
+	if !req.StartTime.Before(endTime) {
+		return nil, twirp.InvalidArgumentError("start_time", "must be before end_time")
+	}
+
+	entries, err := a.auditLog.GetAuditEntries(req.StreamUid, req.StartTime, endTime, req.AfterId, limit)
+	if err != nil {
+		return nil, twirp.InternalErrorWith(err)
+	}
+
+	resp := &AuditLogResponse{
+		Entries: []*AuditEntry{},
+	}
+
+	for _, e := range entries {
+		resp.Entries = append(resp.Entries, &AuditEntry{
+			Id:         e.ID,
+			RecordedAt: e.RecordedAt,
+			Caller:     e.Caller,
+			RequestId:  e.RequestID.String,
+			Method:     e.Method,
+			StreamUid:  e.StreamID.String,
+			Params:     e.Params,
+			Result:     e.Result,
+			Error:      e.Error.String,
+		})
+	}
+
+	return resp, nil
+}
+
+func (a *Admin) handleAuditLog(w http.ResponseWriter, r *http.Request) {
+	var req AuditLogRequest
+
+	err := decodeRequest(r, &req)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	resp, err := a.AuditLog(r.Context(), &req)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	a.writeResponse(w, resp)
+}
+
+// GetLogLevelRequest is the request type for the GetLogLevel method.
+type GetLogLevelRequest struct{}
+
+// SetLogLevelRequest is the request type for the SetLogLevel method. Level
+// must be one of debug, info, warn or error.
+type SetLogLevelRequest struct {
+	Level string `json:"level"`
+}
+
+// LogLevelResponse is the response type for the GetLogLevel and SetLogLevel
+// methods, containing the current log level.
+type LogLevelResponse struct {
+	Level string `json:"level"`
+}
+
+// GetLogLevel returns the current log level.
+func (a *Admin) GetLogLevel(ctx context.Context, req *GetLogLevelRequest) (*LogLevelResponse, error) {
+	if a.levels == nil {
+		return nil, twirp.NewError(twirp.Unimplemented, "log levels are not available")
+	}
+
+	return &LogLevelResponse{
+		Level: a.levels.Level().String(),
+	}, nil
+}
+
+// SetLogLevel changes the log level of the running service. The change is not
+// persisted, so the configured level is restored on restart.
+func (a *Admin) SetLogLevel(ctx context.Context, req *SetLogLevelRequest) (*LogLevelResponse, error) {
+	if a.levels == nil {
+		return nil, twirp.NewError(twirp.Unimplemented, "log levels are not available")
+	}
+
+	if req.Level == "" {
+		return nil, twirp.RequiredArgumentError("level")
+	}
+
+	lvl, err := level.Parse(req.Level)
+	if err != nil {
+		return nil, twirp.InvalidArgumentError("level", "must be one of debug, info, warn or error")
+	}
+
+	previous := a.levels.Level()
+	a.levels.SetLevel(lvl)
+
+	level.Info(a.logger).Log("msg", "log level changed", "previous", previous, "current", lvl)
+
+	return &LogLevelResponse{
+		Level: lvl.String(),
+	}, nil
+}
+
+func (a *Admin) handleGetLogLevel(w http.ResponseWriter, r *http.Request) {
+	var req GetLogLevelRequest
+
+	err := decodeRequest(r, &req)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	resp, err := a.GetLogLevel(r.Context(), &req)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	a.writeResponse(w, resp)
+}
+
+func (a *Admin) handleSetLogLevel(w http.ResponseWriter, r *http.Request) {
+	var req SetLogLevelRequest
+
+	err := decodeRequest(r, &req)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	resp, err := a.SetLogLevel(r.Context(), &req)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	a.writeResponse(w, resp)
+}
+
+// GetMaintenanceRequest is the request type for the GetMaintenance method.
+type GetMaintenanceRequest struct{}
+
+// SetMaintenanceRequest is the request type for the SetMaintenance method.
+type SetMaintenanceRequest struct {
+	Enabled bool `json:"enabled"`
+}
+
+// MaintenanceResponse is the response type for the GetMaintenance and
+// SetMaintenance methods, reporting whether maintenance mode is enabled.
+type MaintenanceResponse struct {
+	Enabled bool `json:"enabled"`
+}
+
+// GetMaintenance returns whether maintenance mode is currently enabled.
+func (a *Admin) GetMaintenance(ctx context.Context, req *GetMaintenanceRequest) (*MaintenanceResponse, error) {
+	if a.maintenance == nil {
+		return nil, twirp.NewError(twirp.Unimplemented, "maintenance mode is not available")
+	}
+
+	return &MaintenanceResponse{
+		Enabled: a.maintenance.Enabled(),
+	}, nil
+}
+
+// SetMaintenance enables or disables maintenance mode. While enabled streams
+// cannot be created or deleted, but existing subscriptions continue to be
+// processed. The change is not persisted, so the configured state is restored
+// on restart.
+func (a *Admin) SetMaintenance(ctx context.Context, req *SetMaintenanceRequest) (*MaintenanceResponse, error) {
+	if a.maintenance == nil {
+		return nil, twirp.NewError(twirp.Unimplemented, "maintenance mode is not available")
+	}
+
+	a.maintenance.SetEnabled(req.Enabled)
+
+	level.Info(a.logger).Log("msg", "maintenance mode changed", "enabled", req.Enabled)
+
+	return &MaintenanceResponse{
+		Enabled: req.Enabled,
+	}, nil
+}
+
+func (a *Admin) handleGetMaintenance(w http.ResponseWriter, r *http.Request) {
+	var req GetMaintenanceRequest
+
+	err := decodeRequest(r, &req)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	resp, err := a.GetMaintenance(r.Context(), &req)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	a.writeResponse(w, resp)
+}
+
+func (a *Admin) handleSetMaintenance(w http.ResponseWriter, r *http.Request) {
+	var req SetMaintenanceRequest
+
+	err := decodeRequest(r, &req)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	resp, err := a.SetMaintenance(r.Context(), &req)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	a.writeResponse(w, resp)
+}
+
+// audit records a call which mutated a stream if we have an auditor.
+func (a *Admin) audit(ctx context.Context, method, streamID string, params interface{}, err error) {
+	if a.auditor == nil {
+		return
+	}
+
+	a.auditor.Record(ctx, method, streamID, params, err)
+}
+
+// newStream converts a postgres.Stream into our API representation.
+func newStream(s *postgres.Stream) *Stream {
+	stream := &Stream{
+		StreamUid:            s.StreamID,
+		CommunityId:          s.CommunityID,
+		RecipientPublicKey:   s.PublicKey,
+		PublicKeyFingerprint: s.PublicKeyFingerprint,
+		Operations:           s.Operations,
+		DatastoreAddr:        s.DatastoreAddr,
+		Source:               s.Source,
+		Compression:          s.Compression,
+		TimestampPolicy:      s.TimestampPolicy,
+		PolicyID:             s.PolicyID,
+		DatastoreTimeout:     s.DatastoreTimeout,
+		Schema:               s.Schema,
+		Privacy:              s.Privacy,
+		GeoPrivacy:           s.GeoPrivacy,
+		RateLimit:            s.RateLimit,
+		Sampling:             s.Sampling,
+		Schedule:             s.Schedule,
+		Geofence:             s.Geofence,
+		Archive:              s.Archive,
+		Priority:             s.Priority,
+		TopicTemplate:        s.Topic,
+		QoS:                  s.QoS,
+	}
+
+	// streams created before fingerprints were stored have them computed
+	if stream.PublicKeyFingerprint == "" {
+		stream.PublicKeyFingerprint = pubkey.Fingerprint(s.PublicKey)
+	}
+
+	if len(s.Conversions) > 0 {
+		stream.Conversions = s.Conversions
+	}
+
+	if len(s.Labels) > 0 {
+		stream.Labels = s.Labels
+	}
+
+	if len(s.Dispositions) > 0 {
+		stream.Dispositions = s.Dispositions
+	}
+
+	if stream.Operations == nil {
+		stream.Operations = []*postgres.Operation{}
+	}
+
+	if s.Device != nil {
+		stream.DeviceHash = logger.HashToken(s.Device.DeviceToken)
+		stream.DeviceLabel = s.Device.Label
+		stream.Longitude = s.Device.Longitude
+		stream.Latitude = s.Device.Latitude
+		stream.Exposure = s.Device.Exposure
+	}
+
+	return stream
+}
+
+// decodeRequest reads the JSON body of the incoming request into the given
+// request object, returning a twirp error if the body is malformed.
+func decodeRequest(r *http.Request, req interface{}) error {
+	defer r.Body.Close()
+
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		return twirp.NewError(twirp.InvalidArgument, "the json request could not be decoded")
+	}
+
+	return nil
+}
+
+// writeResponse writes the given response object to the client as JSON.
+func (a *Admin) writeResponse(w http.ResponseWriter, resp interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err := json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		level.Error(a.logger).Log("msg", "failed to write response", "err", err)
+	}
+}
+
+// writeError writes the given error to the client using the same JSON
+// representation as twirp, so clients can handle errors from both APIs
+// uniformly.
+func (a *Admin) writeError(w http.ResponseWriter, err error) {
+	twerr, ok := err.(twirp.Error)
+	if !ok {
+		twerr = twirp.InternalErrorWith(err)
+	}
+
+	body := struct {
+		Code string            `json:"code"`
+		Msg  string            `json:"msg"`
+		Meta map[string]string `json:"meta,omitempty"`
+	}{
+		Code: string(twerr.Code()),
+		Msg:  twerr.Msg(),
+		Meta: twerr.MetaMap(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(twirp.ServerHTTPStatusFromErrorCode(twerr.Code()))
+
+	err = json.NewEncoder(w).Encode(body)
+	if err != nil {
+		level.Error(a.logger).Log("msg", "failed to write error response", "err", err)
+	}
+}
@@ -0,0 +1,873 @@
+package admin_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/twitchtv/twirp"
+	goji "goji.io"
+	"goji.io/pat"
+
+	"github.com/DECODEproject/iotencoder/pkg/admin"
+	"github.com/DECODEproject/iotencoder/pkg/analytics"
+	"github.com/DECODEproject/iotencoder/pkg/audit"
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/events"
+	"github.com/DECODEproject/iotencoder/pkg/ingest"
+	loggerpkg "github.com/DECODEproject/iotencoder/pkg/logger"
+	"github.com/DECODEproject/iotencoder/pkg/logger/level"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/postgres/postgrestest"
+	"github.com/DECODEproject/iotencoder/pkg/quota"
+	"github.com/DECODEproject/iotencoder/pkg/replay"
+	"github.com/DECODEproject/iotencoder/pkg/rpc"
+	"github.com/DECODEproject/iotencoder/pkg/schema"
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+	"github.com/DECODEproject/iotencoder/pkg/settings"
+	"github.com/DECODEproject/iotencoder/pkg/smartcitizen"
+	"github.com/DECODEproject/iotencoder/pkg/stats"
+)
+
+// adminToken is the token with which the admin API is called in our tests.
+const adminToken = "admin-token"
+
+func newAdmin() (*admin.Admin, *stats.Store) {
+	logger := kitlog.NewNopLogger()
+	store := stats.NewStore(nil, time.Minute, clock.New(), logger)
+
+	return admin.NewAdmin(&admin.Config{Token: adminToken, Stats: store}, logger), store
+}
+
+func TestStreamStats(t *testing.T) {
+	a, store := newAdmin()
+
+	store.RecordMessage("abc")
+	store.RecordEncrypted("abc", 512)
+	store.RecordWrite("abc", nil)
+	store.RecordLatency("abc", 10*time.Millisecond)
+
+	resp, err := a.StreamStats(context.Background(), &admin.StreamStatsRequest{StreamUid: "abc"})
+	assert.Nil(t, err)
+	assert.Equal(t, "abc", resp.StreamUid)
+	assert.Equal(t, uint64(1), resp.MessagesReceived)
+	assert.Equal(t, uint64(512), resp.BytesEncrypted)
+	assert.Equal(t, uint64(1), resp.WritesSucceeded)
+	assert.Equal(t, 10.0, resp.AverageLatencyMs)
+	assert.NotNil(t, resp.LastMessageAt)
+}
+
+func TestStreamStatsInvalid(t *testing.T) {
+	a, _ := newAdmin()
+
+	testcases := []struct {
+		label       string
+		request     *admin.StreamStatsRequest
+		expectedErr string
+	}{
+		{
+			label:       "missing stream_uid",
+			request:     &admin.StreamStatsRequest{},
+			expectedErr: "twirp error invalid_argument: stream_uid is required",
+		},
+		{
+			label:       "unknown stream",
+			request:     &admin.StreamStatsRequest{StreamUid: "foo"},
+			expectedErr: "twirp error not_found: no stats recorded for stream",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			_, err := a.StreamStats(context.Background(), tc.request)
+			assert.NotNil(t, err)
+			assert.Equal(t, tc.expectedErr, err.Error())
+		})
+	}
+}
+
+func TestHandlerAuthentication(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	store := stats.NewStore(nil, time.Minute, clock.New(), logger)
+	store.RecordMessage("abc")
+
+	testcases := []struct {
+		label         string
+		token         string
+		authorization string
+		expected      int
+	}{
+		{"valid token", adminToken, "Bearer " + adminToken, http.StatusOK},
+		{"missing token", adminToken, "", http.StatusUnauthorized},
+		{"wrong token", adminToken, "Bearer wrong", http.StatusUnauthorized},
+		{"wrong scheme", adminToken, "Basic " + adminToken, http.StatusUnauthorized},
+		{"no token configured", "", "Bearer ", http.StatusUnauthorized},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			a := admin.NewAdmin(&admin.Config{Token: secret.Secret(tc.token), Stats: store}, logger)
+
+			mux := goji.NewMux()
+			mux.Handle(pat.New(admin.PathPrefix+"*"), a.Handler())
+
+			req, err := http.NewRequest(http.MethodPost, "/admin/StreamStats", strings.NewReader(`{"stream_uid":"abc"}`))
+			assert.Nil(t, err)
+
+			if tc.authorization != "" {
+				req.Header.Set("Authorization", tc.authorization)
+			}
+
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, req)
+
+			assert.Equal(t, tc.expected, rr.Code)
+
+			if tc.expected == http.StatusUnauthorized {
+				assert.Equal(t, "Bearer", rr.Header().Get("WWW-Authenticate"))
+				assert.Contains(t, rr.Body.String(), `"code":"unauthenticated"`)
+			}
+		})
+	}
+}
+
+func TestStreamStatsHandler(t *testing.T) {
+	a, store := newAdmin()
+	store.RecordMessage("abc")
+
+	mux := goji.NewMux()
+	mux.Handle(pat.New(admin.PathPrefix+"*"), a.Handler())
+
+	req, err := http.NewRequest(http.MethodPost, "/admin/StreamStats", strings.NewReader(`{"stream_uid":"abc"}`))
+	assert.Nil(t, err)
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var resp admin.StreamStatsResponse
+	err = json.Unmarshal(rr.Body.Bytes(), &resp)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), resp.MessagesReceived)
+
+	req, err = http.NewRequest(http.MethodPost, "/admin/StreamStats", strings.NewReader(`{"stream_uid":"unknown"}`))
+	assert.Nil(t, err)
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"code":"not_found"`)
+}
+
+type replayer struct {
+	jobs map[string]*replay.Job
+}
+
+func TestDeviceStatus(t *testing.T) {
+	a, store := newAdmin()
+
+	store.RecordDeviceMessage("abc123")
+	store.RecordDeviceResult("abc123", nil)
+	store.RecordDeviceMessage("abc123")
+	store.RecordDeviceResult("abc123", errors.New("failed to write data"))
+
+	resp, err := a.DeviceStatus(context.Background(), &admin.DeviceStatusRequest{DeviceToken: "abc123"})
+	assert.Nil(t, err)
+	assert.NotNil(t, resp.LastSeenAt)
+	assert.Equal(t, uint64(2), resp.MessagesLastHour)
+	assert.InDelta(t, 2.0/60, resp.MessagesPerMinute, 1e-9)
+	assert.Equal(t, "failed to write data", resp.Error)
+	assert.NotNil(t, resp.ErrorAt)
+
+	_, err = a.DeviceStatus(context.Background(), &admin.DeviceStatusRequest{DeviceToken: "unknown"})
+	assert.Equal(t, "twirp error not_found: no messages received from device", err.Error())
+
+	_, err = a.DeviceStatus(context.Background(), &admin.DeviceStatusRequest{})
+	assert.Equal(t, "twirp error invalid_argument: device_token is required", err.Error())
+}
+
+func (r *replayer) Replay(streamID, token string, start, end time.Time) (*replay.Job, error) {
+	if streamID != "abc" || token != "secret" {
+		return nil, postgres.ErrStreamNotFound
+	}
+
+	job := &replay.Job{ID: "job-1", StreamID: streamID, State: replay.Running, Total: 10, StartedAt: start}
+	r.jobs[job.ID] = job
+
+	return job, nil
+}
+
+func (r *replayer) Status(jobID string) *replay.Job {
+	return r.jobs[jobID]
+}
+
+func TestReplay(t *testing.T) {
+	a := admin.NewAdmin(&admin.Config{
+		Replay: &replayer{jobs: map[string]*replay.Job{}},
+	}, kitlog.NewNopLogger())
+
+	start := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	resp, err := a.ReplayStream(context.Background(), &admin.ReplayStreamRequest{
+		StreamUid: "abc",
+		Token:     "secret",
+		StartTime: start,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "job-1", resp.JobId)
+	assert.Equal(t, 10, resp.Total)
+
+	status, err := a.ReplayStatus(context.Background(), &admin.ReplayStatusRequest{JobId: "job-1"})
+	assert.Nil(t, err)
+	assert.Equal(t, "abc", status.StreamUid)
+	assert.Equal(t, replay.Running, status.State)
+	assert.Nil(t, status.FinishedAt)
+}
+
+func TestReplayInvalid(t *testing.T) {
+	a := admin.NewAdmin(&admin.Config{
+		Replay: &replayer{jobs: map[string]*replay.Job{}},
+	}, kitlog.NewNopLogger())
+
+	start := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	testcases := []struct {
+		label       string
+		request     *admin.ReplayStreamRequest
+		expectedErr string
+	}{
+		{
+			label:       "missing stream_uid",
+			request:     &admin.ReplayStreamRequest{},
+			expectedErr: "twirp error invalid_argument: stream_uid is required",
+		},
+		{
+			label:       "missing token",
+			request:     &admin.ReplayStreamRequest{StreamUid: "abc"},
+			expectedErr: "twirp error invalid_argument: token is required",
+		},
+		{
+			label:       "missing start_time",
+			request:     &admin.ReplayStreamRequest{StreamUid: "abc", Token: "secret"},
+			expectedErr: "twirp error invalid_argument: start_time is required",
+		},
+		{
+			label:       "end before start",
+			request:     &admin.ReplayStreamRequest{StreamUid: "abc", Token: "secret", StartTime: start, EndTime: start.Add(-time.Hour)},
+			expectedErr: "twirp error invalid_argument: end_time must be after start_time",
+		},
+		{
+			label:       "unknown stream",
+			request:     &admin.ReplayStreamRequest{StreamUid: "abc", Token: "wrong", StartTime: start},
+			expectedErr: "twirp error not_found: stream not found",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			_, err := a.ReplayStream(context.Background(), tc.request)
+			assert.NotNil(t, err)
+			assert.Equal(t, tc.expectedErr, err.Error())
+		})
+	}
+
+	_, err := a.ReplayStatus(context.Background(), &admin.ReplayStatusRequest{JobId: "unknown"})
+	assert.NotNil(t, err)
+	assert.Equal(t, "twirp error not_found: replay job not found", err.Error())
+}
+
+type streamSource struct{}
+
+func (s *streamSource) ListStreams() ([]*postgres.Stream, error) {
+	stream, _ := s.GetStream("abc", "secret")
+	return []*postgres.Stream{stream}, nil
+}
+
+func (s *streamSource) ListDeviceStreams(deviceToken secret.Secret) ([]*postgres.Stream, error) {
+	streams, _ := s.ListStreams()
+	if deviceToken != streams[0].Device.DeviceToken {
+		return []*postgres.Stream{}, nil
+	}
+
+	return streams, nil
+}
+
+func (s *streamSource) GetStream(streamID, token string) (*postgres.Stream, error) {
+	if streamID != "abc" || token != "secret" {
+		return nil, postgres.ErrStreamNotFound
+	}
+
+	return &postgres.Stream{
+		StreamID:             "abc",
+		CommunityID:          "community",
+		PublicKey:            "public",
+		PublicKeyFingerprint: "fingerprint",
+		Operations: postgres.Operations{
+			{SensorID: 12, Action: postgres.Share},
+		},
+		Labels: postgres.Labels{"pilot": "barcelona"},
+		Device: &postgres.Device{
+			DeviceToken: "device-token",
+			Label:       "device",
+			Longitude:   2.13,
+			Latitude:    41.4,
+			Exposure:    "indoor",
+		},
+	}, nil
+}
+
+func TestStreams(t *testing.T) {
+	a := admin.NewAdmin(&admin.Config{
+		Streams: &streamSource{},
+	}, kitlog.NewNopLogger())
+
+	resp, err := a.ListStreams(context.Background(), &admin.ListStreamsRequest{})
+	assert.Nil(t, err)
+	assert.Len(t, resp.Streams, 1)
+
+	resp, err = a.ListStreams(context.Background(), &admin.ListStreamsRequest{DeviceToken: "device-token"})
+	assert.Nil(t, err)
+	assert.Len(t, resp.Streams, 1)
+
+	resp, err = a.ListStreams(context.Background(), &admin.ListStreamsRequest{DeviceToken: "other"})
+	assert.Nil(t, err)
+	assert.Len(t, resp.Streams, 0)
+
+	resp, err = a.ListStreams(context.Background(), &admin.ListStreamsRequest{Selector: "pilot=barcelona"})
+	assert.Nil(t, err)
+	assert.Len(t, resp.Streams, 1)
+	assert.Equal(t, map[string]string{"pilot": "barcelona"}, resp.Streams[0].Labels)
+
+	resp, err = a.ListStreams(context.Background(), &admin.ListStreamsRequest{Selector: "pilot!=barcelona"})
+	assert.Nil(t, err)
+	assert.Len(t, resp.Streams, 0)
+
+	_, err = a.ListStreams(context.Background(), &admin.ListStreamsRequest{Selector: "pilot=barcelona,=noise"})
+	assert.NotNil(t, err)
+	assert.Equal(t, `twirp error invalid_argument: selector invalid selector requirement "=noise"`, err.Error())
+
+	stream, err := a.GetStream(context.Background(), &admin.GetStreamRequest{StreamUid: "abc", Token: "secret"})
+	assert.Nil(t, err)
+	assert.Equal(t, "community", stream.CommunityId)
+	assert.Equal(t, "public", stream.RecipientPublicKey)
+	assert.Equal(t, "fingerprint", stream.PublicKeyFingerprint)
+	assert.Equal(t, loggerpkg.HashToken("device-token"), stream.DeviceHash)
+	assert.Equal(t, 2.13, stream.Longitude)
+	assert.Len(t, stream.Operations, 1)
+
+	_, err = a.GetStream(context.Background(), &admin.GetStreamRequest{StreamUid: "abc"})
+	assert.NotNil(t, err)
+	assert.Equal(t, "twirp error invalid_argument: token is required", err.Error())
+
+	_, err = a.GetStream(context.Background(), &admin.GetStreamRequest{StreamUid: "abc", Token: "wrong"})
+	assert.NotNil(t, err)
+	assert.Equal(t, "twirp error not_found: stream not found", err.Error())
+}
+
+type processor struct {
+	payloads int
+}
+
+func (p *processor) Process(ctx context.Context, device *postgres.Device, payload []byte) error {
+	p.payloads++
+	return nil
+}
+
+func TestWriteReadings(t *testing.T) {
+	p := &processor{}
+
+	a := admin.NewAdmin(&admin.Config{
+		Ingester: ingest.NewIngester(&ingest.Config{
+			Streams:      &streamSource{},
+			Processor:    p,
+			Clock:        clock.New(),
+			MaxBatchSize: 2,
+		}, kitlog.NewNopLogger()),
+	}, kitlog.NewNopLogger())
+
+	recordedAt := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	resp, err := a.WriteReadings(context.Background(), &admin.WriteReadingsRequest{
+		StreamUid: "abc",
+		Token:     "secret",
+		Readings: []smartcitizen.SensorData{
+			{RecordedAt: recordedAt, Sensors: []smartcitizen.RawSensor{{ID: 13, Value: 51}}},
+			{RecordedAt: recordedAt.Add(time.Minute), Sensors: []smartcitizen.RawSensor{{ID: 13, Value: 52}}},
+		},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, &admin.WriteReadingsResponse{Processed: 2}, resp)
+	assert.Equal(t, 2, p.payloads)
+
+	reading := smartcitizen.SensorData{RecordedAt: recordedAt}
+
+	testcases := []struct {
+		label       string
+		request     *admin.WriteReadingsRequest
+		expectedErr string
+	}{
+		{
+			label:       "missing readings",
+			request:     &admin.WriteReadingsRequest{StreamUid: "abc", Token: "secret"},
+			expectedErr: "twirp error invalid_argument: readings is required",
+		},
+		{
+			label: "missing recorded at",
+			request: &admin.WriteReadingsRequest{
+				StreamUid: "abc",
+				Token:     "secret",
+				Readings:  []smartcitizen.SensorData{{}},
+			},
+			expectedErr: "twirp error invalid_argument: readings must all have a recorded_at time",
+		},
+		{
+			label: "too many readings",
+			request: &admin.WriteReadingsRequest{
+				StreamUid: "abc",
+				Token:     "secret",
+				Readings:  []smartcitizen.SensorData{reading, reading, reading},
+			},
+			expectedErr: "twirp error invalid_argument: readings contains too many readings",
+		},
+		{
+			label: "wrong token",
+			request: &admin.WriteReadingsRequest{
+				StreamUid: "abc",
+				Token:     "wrong",
+				Readings:  []smartcitizen.SensorData{reading},
+			},
+			expectedErr: "twirp error not_found: stream not found",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			_, err := a.WriteReadings(context.Background(), tc.request)
+			assert.NotNil(t, err)
+			assert.Equal(t, tc.expectedErr, err.Error())
+		})
+	}
+}
+
+func TestSetConversions(t *testing.T) {
+	db := postgrestest.NewDB()
+
+	stream, err := db.CreateStream(&postgres.Stream{
+		CommunityID: "community",
+		PublicKey:   "public",
+		Device: &postgres.Device{
+			DeviceToken: "device-token",
+		},
+	})
+	assert.Nil(t, err)
+
+	a := admin.NewAdmin(&admin.Config{
+		Streams:     db,
+		Conversions: db,
+	}, kitlog.NewNopLogger())
+
+	got, err := a.SetConversions(context.Background(), &admin.SetConversionsRequest{
+		StreamUid:   stream.StreamID,
+		Token:       stream.Token.Reveal(),
+		Conversions: map[uint32]string{12: "x * 0.0625 - 40"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, map[uint32]string{12: "x * 0.0625 - 40"}, got.Conversions)
+
+	testcases := []struct {
+		label       string
+		request     *admin.SetConversionsRequest
+		expectedErr string
+	}{
+		{
+			label:       "missing token",
+			request:     &admin.SetConversionsRequest{StreamUid: stream.StreamID},
+			expectedErr: "twirp error invalid_argument: token is required",
+		},
+		{
+			label: "invalid expression",
+			request: &admin.SetConversionsRequest{
+				StreamUid:   stream.StreamID,
+				Token:       stream.Token.Reveal(),
+				Conversions: map[uint32]string{12: "x +"},
+			},
+			expectedErr: "twirp error invalid_argument: conversions sensor 12: invalid conversion expression \"x +\": unexpected end of expression at position 3",
+		},
+		{
+			label: "wrong token",
+			request: &admin.SetConversionsRequest{
+				StreamUid: stream.StreamID,
+				Token:     "wrong",
+			},
+			expectedErr: "twirp error not_found: stream not found",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			_, err := a.SetConversions(context.Background(), tc.request)
+			assert.NotNil(t, err)
+			assert.Equal(t, tc.expectedErr, err.Error())
+		})
+	}
+
+	got, err = a.SetConversions(context.Background(), &admin.SetConversionsRequest{
+		StreamUid: stream.StreamID,
+		Token:     stream.Token.Reveal(),
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, got.Conversions)
+}
+
+func TestRotateIngestSecret(t *testing.T) {
+	db := postgrestest.NewDB()
+
+	stream, err := db.CreateStream(&postgres.Stream{
+		CommunityID: "community",
+		PublicKey:   "public",
+		Device: &postgres.Device{
+			DeviceToken: "device-token",
+		},
+	})
+	assert.Nil(t, err)
+
+	a := admin.NewAdmin(&admin.Config{
+		Secrets: db,
+	}, kitlog.NewNopLogger())
+
+	got, err := a.RotateIngestSecret(context.Background(), &admin.RotateIngestSecretRequest{
+		StreamUid: stream.StreamID,
+		Token:     stream.Token.Reveal(),
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, stream.StreamID, got.StreamUid)
+	assert.NotEqual(t, "", got.IngestSecret)
+
+	device, err := db.GetDevice("device-token")
+	assert.Nil(t, err)
+	assert.Equal(t, got.IngestSecret, device.Streams[0].IngestSecret.Reveal())
+
+	_, err = a.RotateIngestSecret(context.Background(), &admin.RotateIngestSecretRequest{
+		StreamUid: stream.StreamID,
+		Token:     "wrong",
+	})
+	assert.NotNil(t, err)
+	assert.Equal(t, "twirp error not_found: stream not found", err.Error())
+
+	_, err = admin.NewAdmin(&admin.Config{}, kitlog.NewNopLogger()).RotateIngestSecret(context.Background(), &admin.RotateIngestSecretRequest{})
+	assert.NotNil(t, err)
+	assert.Equal(t, "twirp error unimplemented: ingest secrets are not available", err.Error())
+}
+
+func TestAuditLog(t *testing.T) {
+	db := postgrestest.NewDB()
+
+	stream, err := db.CreateStream(&postgres.Stream{
+		CommunityID: "community",
+		PublicKey:   "public",
+		Device: &postgres.Device{
+			DeviceToken: "device-token",
+		},
+	})
+	assert.Nil(t, err)
+
+	a := admin.NewAdmin(&admin.Config{
+		Secrets:  db,
+		AuditLog: db,
+		Auditor: audit.NewRecorder(&audit.Config{
+			Store: db,
+			Clock: clock.New(),
+		}, kitlog.NewNopLogger()),
+	}, kitlog.NewNopLogger())
+
+	ctx := audit.WithCaller(context.Background(), "10.0.0.1")
+
+	_, err = a.RotateIngestSecret(ctx, &admin.RotateIngestSecretRequest{
+		StreamUid: stream.StreamID,
+		Token:     stream.Token.Reveal(),
+	})
+	assert.Nil(t, err)
+
+	_, err = a.RotateIngestSecret(ctx, &admin.RotateIngestSecretRequest{
+		StreamUid: stream.StreamID,
+		Token:     "wrong",
+	})
+	assert.NotNil(t, err)
+
+	resp, err := a.AuditLog(context.Background(), &admin.AuditLogRequest{StreamUid: stream.StreamID})
+	assert.Nil(t, err)
+	assert.Len(t, resp.Entries, 2)
+
+	assert.Equal(t, "RotateIngestSecret", resp.Entries[0].Method)
+	assert.Equal(t, "10.0.0.1", resp.Entries[0].Caller)
+	assert.Equal(t, "ok", resp.Entries[0].Result)
+	assert.Equal(t, audit.Redacted, resp.Entries[0].Params["token"])
+	assert.Equal(t, "not_found", resp.Entries[1].Result)
+	assert.Equal(t, "twirp error not_found: stream not found", resp.Entries[1].Error)
+
+	resp, err = a.AuditLog(context.Background(), &admin.AuditLogRequest{
+		StreamUid: stream.StreamID,
+		AfterId:   resp.Entries[0].Id,
+	})
+	assert.Nil(t, err)
+	assert.Len(t, resp.Entries, 1)
+
+	resp, err = a.AuditLog(context.Background(), &admin.AuditLogRequest{StreamUid: "unknown"})
+	assert.Nil(t, err)
+	assert.Len(t, resp.Entries, 0)
+
+	_, err = a.AuditLog(context.Background(), &admin.AuditLogRequest{Limit: admin.MaxAuditLimit + 1})
+	assert.NotNil(t, err)
+	assert.Equal(t, "twirp error invalid_argument: limit must be between 1 and 1000", err.Error())
+
+	_, err = admin.NewAdmin(&admin.Config{}, kitlog.NewNopLogger()).AuditLog(context.Background(), &admin.AuditLogRequest{})
+	assert.NotNil(t, err)
+	assert.Equal(t, "twirp error unimplemented: audit log is not available", err.Error())
+}
+
+func TestLogLevel(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	leveler := loggerpkg.NewLeveler(level.InfoLevel)
+
+	a := admin.NewAdmin(&admin.Config{Levels: leveler}, logger)
+
+	resp, err := a.GetLogLevel(context.Background(), &admin.GetLogLevelRequest{})
+	assert.Nil(t, err)
+	assert.Equal(t, "info", resp.Level)
+
+	resp, err = a.SetLogLevel(context.Background(), &admin.SetLogLevelRequest{Level: "debug"})
+	assert.Nil(t, err)
+	assert.Equal(t, "debug", resp.Level)
+	assert.Equal(t, level.DebugLevel, leveler.Level())
+
+	_, err = a.SetLogLevel(context.Background(), &admin.SetLogLevelRequest{})
+	assert.NotNil(t, err)
+	assert.Equal(t, "twirp error invalid_argument: level is required", err.Error())
+
+	_, err = a.SetLogLevel(context.Background(), &admin.SetLogLevelRequest{Level: "loud"})
+	assert.NotNil(t, err)
+	assert.Equal(t, "twirp error invalid_argument: level must be one of debug, info, warn or error", err.Error())
+
+	a, _ = newAdmin()
+
+	_, err = a.GetLogLevel(context.Background(), &admin.GetLogLevelRequest{})
+	assert.NotNil(t, err)
+	assert.Equal(t, "twirp error unimplemented: log levels are not available", err.Error())
+}
+
+func TestMaintenance(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	maintenance := rpc.NewMaintenance(false)
+
+	a := admin.NewAdmin(&admin.Config{Maintenance: maintenance}, logger)
+
+	resp, err := a.GetMaintenance(context.Background(), &admin.GetMaintenanceRequest{})
+	assert.Nil(t, err)
+	assert.False(t, resp.Enabled)
+
+	resp, err = a.SetMaintenance(context.Background(), &admin.SetMaintenanceRequest{Enabled: true})
+	assert.Nil(t, err)
+	assert.True(t, resp.Enabled)
+	assert.True(t, maintenance.Enabled())
+
+	resp, err = a.SetMaintenance(context.Background(), &admin.SetMaintenanceRequest{Enabled: false})
+	assert.Nil(t, err)
+	assert.False(t, resp.Enabled)
+	assert.False(t, maintenance.Enabled())
+
+	a, _ = newAdmin()
+
+	_, err = a.GetMaintenance(context.Background(), &admin.GetMaintenanceRequest{})
+	assert.NotNil(t, err)
+	assert.Equal(t, "twirp error unimplemented: maintenance mode is not available", err.Error())
+}
+
+func TestSchemas(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	registry := schema.NewRegistry(&schema.Config{Store: postgrestest.NewDB()}, logger)
+
+	a := admin.NewAdmin(&admin.Config{Schemas: registry}, logger)
+
+	channels := []*postgres.Channel{
+		{SensorID: 12, Name: "temperature", Unit: "celsius", Type: postgres.NumberType},
+	}
+
+	registered, err := a.RegisterSchema(context.Background(), &admin.RegisterSchemaRequest{Kind: "noise", Channels: channels})
+	assert.Nil(t, err)
+	assert.Equal(t, "noise@1", registered.Ref)
+
+	registered, err = a.RegisterSchema(context.Background(), &admin.RegisterSchemaRequest{Kind: "noise", Channels: channels})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, registered.Version)
+
+	got, err := a.GetSchema(context.Background(), &admin.GetSchemaRequest{Ref: "noise"})
+	assert.Nil(t, err)
+	assert.Equal(t, "noise@2", got.Ref)
+	assert.Equal(t, "celsius", got.Channels[0].Unit)
+
+	list, err := a.ListSchemas(context.Background(), &admin.ListSchemasRequest{})
+	assert.Nil(t, err)
+	assert.Len(t, list.Schemas, 2)
+
+	_, err = a.GetSchema(context.Background(), &admin.GetSchemaRequest{Ref: "noise@3"})
+	assert.NotNil(t, err)
+	assert.Equal(t, "twirp error not_found: schema not found", err.Error())
+
+	_, err = a.RegisterSchema(context.Background(), &admin.RegisterSchemaRequest{Kind: "noise"})
+	assert.NotNil(t, err)
+	assert.Equal(t, "twirp error invalid_argument: channels a schema must declare at least one channel", err.Error())
+
+	_, err = a.RegisterSchema(context.Background(), &admin.RegisterSchemaRequest{Kind: "Noise", Channels: channels})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "twirp error invalid_argument: kind invalid schema kind")
+
+	a, _ = newAdmin()
+
+	_, err = a.ListSchemas(context.Background(), &admin.ListSchemasRequest{})
+	assert.NotNil(t, err)
+	assert.Equal(t, "twirp error unimplemented: schemas are not available", err.Error())
+}
+
+func TestProfiling(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+
+	testcases := []struct {
+		label    string
+		enabled  bool
+		path     string
+		expected int
+	}{
+		{"disabled", false, "/admin/debug/pprof/heap", http.StatusNotFound},
+		{"index", true, "/admin/debug/pprof/", http.StatusOK},
+		{"heap", true, "/admin/debug/pprof/heap", http.StatusOK},
+		{"cmdline", true, "/admin/debug/pprof/cmdline", http.StatusOK},
+		{"unknown", true, "/admin/debug/pprof/unknown", http.StatusNotFound},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			a := admin.NewAdmin(&admin.Config{Profiling: tc.enabled, Token: adminToken}, logger)
+
+			mux := goji.NewMux()
+			mux.Handle(pat.New(admin.PathPrefix+"*"), a.Handler())
+
+			req, err := http.NewRequest(http.MethodGet, tc.path, nil)
+			assert.Nil(t, err)
+			req.Header.Set("Authorization", "Bearer "+adminToken)
+
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, req)
+
+			assert.Equal(t, tc.expected, rr.Code)
+		})
+	}
+}
+
+func TestRecentEvents(t *testing.T) {
+	recorder := events.NewRecorder(10)
+	recorder.Record(&events.Event{Type: events.StreamCreated, StreamID: "abc"})
+	recorder.Record(&events.Event{Type: events.SubscriptionLost, Broker: "tcp://broker:1883"})
+
+	a := admin.NewAdmin(&admin.Config{
+		Events: recorder,
+	}, kitlog.NewNopLogger())
+
+	resp, err := a.RecentEvents(context.Background(), &admin.RecentEventsRequest{})
+	assert.Nil(t, err)
+	assert.Len(t, resp.Events, 2)
+	assert.Equal(t, events.SubscriptionLost, resp.Events[0].Type)
+
+	resp, err = a.RecentEvents(context.Background(), &admin.RecentEventsRequest{Type: "stream_created"})
+	assert.Nil(t, err)
+	assert.Len(t, resp.Events, 1)
+	assert.Equal(t, "abc", resp.Events[0].StreamID)
+
+	_, err = a.RecentEvents(context.Background(), &admin.RecentEventsRequest{Limit: -1})
+	assert.NotNil(t, err)
+
+	// without a recorder events are unavailable
+	_, err = admin.NewAdmin(&admin.Config{}, kitlog.NewNopLogger()).RecentEvents(context.Background(), &admin.RecentEventsRequest{})
+	assert.NotNil(t, err)
+}
+
+func TestExportAnalytics(t *testing.T) {
+	dir, err := ioutil.TempDir("", "analytics")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	db := postgrestest.NewDB()
+
+	_, err = db.CreateStream(&postgres.Stream{
+		CommunityID: "smartcitizen",
+		PublicKey:   "public",
+		Device:      &postgres.Device{DeviceToken: "abc123"},
+	})
+	assert.Nil(t, err)
+
+	exporter := analytics.NewExporter(&analytics.Config{
+		Streams: db,
+		Stats:   stats.NewStore(nil, time.Minute, clock.New(), kitlog.NewNopLogger()),
+		Dir:     dir,
+		Clock:   clock.New(),
+	}, kitlog.NewNopLogger())
+
+	a := admin.NewAdmin(&admin.Config{
+		Analytics: exporter,
+	}, kitlog.NewNopLogger())
+
+	resp, err := a.ExportAnalytics(context.Background(), &admin.ExportAnalyticsRequest{})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, resp.Streams)
+	assert.Len(t, resp.Files, 2)
+
+	// without an exporter analytics are unavailable
+	_, err = admin.NewAdmin(&admin.Config{}, kitlog.NewNopLogger()).ExportAnalytics(context.Background(), &admin.ExportAnalyticsRequest{})
+	assert.NotNil(t, err)
+}
+
+func TestReloadSettings(t *testing.T) {
+	loaded := &settings.Settings{
+		LogLevel:        level.WarnLevel,
+		CommunityQuotas: map[string]*quota.Limits{"smartcitizen": {Streams: 10, MessagesPerMinute: 60}},
+		OutboxBatchSize: 100,
+	}
+
+	reloader := settings.NewReloader(&settings.Config{
+		Loader: func() (*settings.Settings, error) {
+			return loaded, nil
+		},
+	}, kitlog.NewNopLogger())
+
+	a := admin.NewAdmin(&admin.Config{
+		Settings: reloader,
+	}, kitlog.NewNopLogger())
+
+	resp, err := a.ReloadSettings(context.Background(), &admin.ReloadSettingsRequest{})
+	assert.Nil(t, err)
+	assert.Equal(t, &admin.ReloadSettingsResponse{
+		LogLevel:        "warn",
+		CommunityQuotas: map[string]string{"smartcitizen": "streams=10,messages=60"},
+		OutboxBatchSize: 100,
+	}, resp)
+
+	// invalid settings are refused
+	loaded = &settings.Settings{}
+
+	_, err = a.ReloadSettings(context.Background(), &admin.ReloadSettingsRequest{})
+	if assert.NotNil(t, err) {
+		assert.Equal(t, twirp.FailedPrecondition, err.(twirp.Error).Code())
+	}
+}
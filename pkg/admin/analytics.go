@@ -0,0 +1,77 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/twitchtv/twirp"
+
+	"github.com/DECODEproject/iotencoder/pkg/analytics"
+)
+
+// AnalyticsExporter is the interface we require of a type able to export the
+// metadata and statistics of our streams for analytics. It is satisfied by the
+// analytics.Exporter type.
+type AnalyticsExporter interface {
+	Export(ctx context.Context) (*analytics.Export, error)
+}
+
+// ExportAnalyticsRequest is the request type for the ExportAnalytics method.
+type ExportAnalyticsRequest struct{}
+
+// ExportAnalyticsResponse is the response type for the ExportAnalytics method,
+// listing the files written.
+type ExportAnalyticsResponse struct {
+	Day     string   `json:"day"`
+	Streams int      `json:"streams"`
+	Files   []string `json:"files"`
+}
+
+// ExportAnalytics writes an export of the metadata and statistics of every
+// live stream for analytics now, rather than waiting for the next scheduled
+// export, replacing any export already written today.
+func (a *Admin) ExportAnalytics(ctx context.Context, req *ExportAnalyticsRequest) (_ *ExportAnalyticsResponse, err error) {
+	var export *analytics.Export
+
+	defer func() {
+		params := map[string]int{}
+		if export != nil {
+			params["streams"] = export.Streams
+		}
+
+		a.audit(ctx, "ExportAnalytics", "", params, err)
+	}()
+
+	if a.analytics == nil {
+		return nil, twirp.NewError(twirp.Unimplemented, "analytics export is not available")
+	}
+
+	export, err = a.analytics.Export(ctx)
+	if err != nil {
+		return nil, twirp.InternalErrorWith(err)
+	}
+
+	return &ExportAnalyticsResponse{
+		Day:     export.Day,
+		Streams: export.Streams,
+		Files:   export.Files,
+	}, nil
+}
+
+func (a *Admin) handleExportAnalytics(w http.ResponseWriter, r *http.Request) {
+	var req ExportAnalyticsRequest
+
+	err := decodeRequest(r, &req)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	resp, err := a.ExportAnalytics(r.Context(), &req)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	a.writeResponse(w, resp)
+}
@@ -0,0 +1,421 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/twitchtv/twirp"
+
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+)
+
+// HTTPClient is the interface used by the admin client to send requests. It is
+// satisfied by *http.Client.
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Client is a client for the admin API exposed by a running encoder. Errors
+// returned by the server are returned as twirp.Error values.
+type Client struct {
+	addr   string
+	token  secret.Secret
+	client HTTPClient
+}
+
+// NewClient returns a new admin client which sends requests to the admin API of
+// the encoder listening at addr (e.g. http://localhost:8082), presenting the
+// given admin token as a bearer token.
+func NewClient(addr string, token secret.Secret, client HTTPClient) *Client {
+	return &Client{
+		addr:   strings.TrimSuffix(addr, "/"),
+		token:  token,
+		client: client,
+	}
+}
+
+// StreamStats calls the StreamStats method.
+func (c *Client) StreamStats(ctx context.Context, req *StreamStatsRequest) (*StreamStatsResponse, error) {
+	var resp StreamStatsResponse
+
+	err := c.call(ctx, "StreamStats", req, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+// DeviceStatus calls the DeviceStatus method.
+func (c *Client) DeviceStatus(ctx context.Context, req *DeviceStatusRequest) (*DeviceStatusResponse, error) {
+	var resp DeviceStatusResponse
+
+	err := c.call(ctx, "DeviceStatus", req, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+// ReplayStream calls the ReplayStream method.
+func (c *Client) ReplayStream(ctx context.Context, req *ReplayStreamRequest) (*ReplayStreamResponse, error) {
+	var resp ReplayStreamResponse
+
+	err := c.call(ctx, "ReplayStream", req, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+// ReplayStatus calls the ReplayStatus method.
+func (c *Client) ReplayStatus(ctx context.Context, req *ReplayStatusRequest) (*ReplayStatusResponse, error) {
+	var resp ReplayStatusResponse
+
+	err := c.call(ctx, "ReplayStatus", req, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+// WriteReadings calls the WriteReadings method.
+func (c *Client) WriteReadings(ctx context.Context, req *WriteReadingsRequest) (*WriteReadingsResponse, error) {
+	var resp WriteReadingsResponse
+
+	err := c.call(ctx, "WriteReadings", req, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+// ListStreams calls the ListStreams method.
+func (c *Client) ListStreams(ctx context.Context, req *ListStreamsRequest) (*ListStreamsResponse, error) {
+	var resp ListStreamsResponse
+
+	err := c.call(ctx, "ListStreams", req, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+// GetStream calls the GetStream method.
+func (c *Client) GetStream(ctx context.Context, req *GetStreamRequest) (*Stream, error) {
+	var resp Stream
+
+	err := c.call(ctx, "GetStream", req, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+// SetConversions calls the SetConversions method.
+func (c *Client) SetConversions(ctx context.Context, req *SetConversionsRequest) (*Stream, error) {
+	var resp Stream
+
+	err := c.call(ctx, "SetConversions", req, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+// RotateIngestSecret calls the RotateIngestSecret method.
+func (c *Client) RotateIngestSecret(ctx context.Context, req *RotateIngestSecretRequest) (*RotateIngestSecretResponse, error) {
+	var resp RotateIngestSecretResponse
+
+	err := c.call(ctx, "RotateIngestSecret", req, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+// RestoreStream calls the RestoreStream method.
+func (c *Client) RestoreStream(ctx context.Context, req *RestoreStreamRequest) (*RestoreStreamResponse, error) {
+	var resp RestoreStreamResponse
+
+	err := c.call(ctx, "RestoreStream", req, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+// AuditLog calls the AuditLog method.
+func (c *Client) AuditLog(ctx context.Context, req *AuditLogRequest) (*AuditLogResponse, error) {
+	var resp AuditLogResponse
+
+	err := c.call(ctx, "AuditLog", req, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+// ExportAnalytics calls the ExportAnalytics method.
+func (c *Client) ExportAnalytics(ctx context.Context, req *ExportAnalyticsRequest) (*ExportAnalyticsResponse, error) {
+	var resp ExportAnalyticsResponse
+
+	err := c.call(ctx, "ExportAnalytics", req, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+// ReloadSettings calls the ReloadSettings method.
+func (c *Client) ReloadSettings(ctx context.Context, req *ReloadSettingsRequest) (*ReloadSettingsResponse, error) {
+	var resp ReloadSettingsResponse
+
+	err := c.call(ctx, "ReloadSettings", req, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+// GetLogLevel calls the GetLogLevel method.
+func (c *Client) GetLogLevel(ctx context.Context, req *GetLogLevelRequest) (*LogLevelResponse, error) {
+	var resp LogLevelResponse
+
+	err := c.call(ctx, "GetLogLevel", req, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+// SetLogLevel calls the SetLogLevel method.
+func (c *Client) SetLogLevel(ctx context.Context, req *SetLogLevelRequest) (*LogLevelResponse, error) {
+	var resp LogLevelResponse
+
+	err := c.call(ctx, "SetLogLevel", req, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+// GetMaintenance calls the GetMaintenance method.
+func (c *Client) GetMaintenance(ctx context.Context, req *GetMaintenanceRequest) (*MaintenanceResponse, error) {
+	var resp MaintenanceResponse
+
+	err := c.call(ctx, "GetMaintenance", req, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+// SetMaintenance calls the SetMaintenance method.
+func (c *Client) SetMaintenance(ctx context.Context, req *SetMaintenanceRequest) (*MaintenanceResponse, error) {
+	var resp MaintenanceResponse
+
+	err := c.call(ctx, "SetMaintenance", req, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+// RegisterSchema calls the RegisterSchema method.
+func (c *Client) RegisterSchema(ctx context.Context, req *RegisterSchemaRequest) (*Schema, error) {
+	var resp Schema
+
+	err := c.call(ctx, "RegisterSchema", req, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+// ListSchemas calls the ListSchemas method.
+func (c *Client) ListSchemas(ctx context.Context, req *ListSchemasRequest) (*ListSchemasResponse, error) {
+	var resp ListSchemasResponse
+
+	err := c.call(ctx, "ListSchemas", req, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+// GetSchema calls the GetSchema method.
+func (c *Client) GetSchema(ctx context.Context, req *GetSchemaRequest) (*Schema, error) {
+	var resp Schema
+
+	err := c.call(ctx, "GetSchema", req, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+// MigrateStreams calls the MigrateStreams method.
+func (c *Client) MigrateStreams(ctx context.Context, req *MigrateStreamsRequest) (*MigrateStreamsResponse, error) {
+	var resp MigrateStreamsResponse
+
+	err := c.call(ctx, "MigrateStreams", req, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+// ExportStreams calls the ExportStreams method, copying the export to w as it
+// is received.
+func (c *Client) ExportStreams(ctx context.Context, req *ExportStreamsRequest, w io.Writer) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return twirp.InternalErrorWith(err)
+	}
+
+	httpResp, err := c.send(ctx, "ExportStreams", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+
+	_, err = io.Copy(w, httpResp.Body)
+	if err != nil {
+		return twirp.InternalErrorWith(err)
+	}
+
+	return nil
+}
+
+// ImportStreams calls the ImportStreams method, sending the export read from r
+// after the request.
+func (c *Client) ImportStreams(ctx context.Context, req *ImportStreamsRequest, r io.Reader) (*ImportStreamsResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, twirp.InternalErrorWith(err)
+	}
+
+	httpResp, err := c.send(ctx, "ImportStreams", io.MultiReader(bytes.NewReader(body), strings.NewReader("\n"), r))
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+
+	var resp ImportStreamsResponse
+
+	err = json.NewDecoder(httpResp.Body).Decode(&resp)
+	if err != nil {
+		return nil, twirp.InternalErrorWith(err)
+	}
+
+	return &resp, nil
+}
+
+// call sends the JSON encoded request to the named method, decoding the
+// response into resp.
+func (c *Client) call(ctx context.Context, method string, req, resp interface{}) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return twirp.InternalErrorWith(err)
+	}
+
+	httpResp, err := c.send(ctx, method, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+
+	b, err := ioutil.ReadAll(httpResp.Body)
+	if err != nil {
+		return twirp.InternalErrorWith(err)
+	}
+
+	err = json.Unmarshal(b, resp)
+	if err != nil {
+		return twirp.InternalErrorWith(err)
+	}
+
+	return nil
+}
+
+// send posts the given body to the named method, returning the response if
+// successful. The caller must close the body of the response.
+func (c *Client) send(ctx context.Context, method string, body io.Reader) (*http.Response, error) {
+	url := c.addr + PathPrefix + method
+
+	httpReq, err := http.NewRequest(http.MethodPost, url, body)
+	if err != nil {
+		return nil, twirp.InternalErrorWith(err)
+	}
+
+	httpReq = httpReq.WithContext(ctx)
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+c.token.Reveal())
+
+	httpResp, err := c.client.Do(httpReq)
+	if err != nil {
+		return nil, twirp.InternalErrorWith(err)
+	}
+
+	if httpResp.StatusCode != http.StatusOK {
+		defer httpResp.Body.Close()
+
+		b, err := ioutil.ReadAll(httpResp.Body)
+		if err != nil {
+			return nil, twirp.InternalErrorWith(err)
+		}
+
+		return nil, errorFromResponse(httpResp.StatusCode, b)
+	}
+
+	return httpResp, nil
+}
+
+// errorFromResponse converts an error response body written by writeError back
+// into a twirp.Error.
+func errorFromResponse(statusCode int, body []byte) twirp.Error {
+	var tj struct {
+		Code string            `json:"code"`
+		Msg  string            `json:"msg"`
+		Meta map[string]string `json:"meta"`
+	}
+
+	err := json.Unmarshal(body, &tj)
+	if err != nil || !twirp.IsValidErrorCode(twirp.ErrorCode(tj.Code)) {
+		return twirp.InternalError(fmt.Sprintf("unexpected response with HTTP status code %d", statusCode))
+	}
+
+	twerr := twirp.NewError(twirp.ErrorCode(tj.Code), tj.Msg)
+	for k, v := range tj.Meta {
+		twerr = twerr.WithMeta(k, v)
+	}
+
+	return twerr
+}
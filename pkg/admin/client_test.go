@@ -0,0 +1,60 @@
+package admin_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/twitchtv/twirp"
+	goji "goji.io"
+	"goji.io/pat"
+
+	"github.com/DECODEproject/iotencoder/pkg/admin"
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/stats"
+)
+
+func TestClient(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	store := stats.NewStore(nil, time.Minute, clock.New(), logger)
+	store.RecordMessage("abc")
+
+	a := admin.NewAdmin(&admin.Config{
+		Token:   adminToken,
+		Stats:   store,
+		Streams: &streamSource{},
+	}, logger)
+
+	mux := goji.NewMux()
+	mux.Handle(pat.New(admin.PathPrefix+"*"), a.Handler())
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	client := admin.NewClient(ts.URL, adminToken, &http.Client{})
+
+	resp, err := client.StreamStats(context.Background(), &admin.StreamStatsRequest{StreamUid: "abc"})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), resp.MessagesReceived)
+
+	_, err = client.StreamStats(context.Background(), &admin.StreamStatsRequest{})
+	assert.NotNil(t, err)
+
+	twerr, ok := err.(twirp.Error)
+	assert.True(t, ok)
+	assert.Equal(t, twirp.InvalidArgument, twerr.Code())
+	assert.Equal(t, "stream_uid", twerr.Meta("argument"))
+
+	streams, err := client.ListStreams(context.Background(), &admin.ListStreamsRequest{})
+	assert.Nil(t, err)
+	assert.Len(t, streams.Streams, 1)
+	assert.Equal(t, "abc", streams.Streams[0].StreamUid)
+
+	stream, err := client.GetStream(context.Background(), &admin.GetStreamRequest{StreamUid: "abc", Token: "secret"})
+	assert.Nil(t, err)
+	assert.Equal(t, "device", stream.DeviceLabel)
+}
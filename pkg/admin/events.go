@@ -0,0 +1,70 @@
+package admin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/twitchtv/twirp"
+
+	"github.com/DECODEproject/iotencoder/pkg/events"
+)
+
+// MaxEventsLimit is the maximum number of events which may be returned by a
+// single call to RecentEvents.
+const MaxEventsLimit = 1000
+
+// EventLog is the interface we require of a type able to return the events
+// recently published on our event bus. It is satisfied by the events.Recorder
+// type.
+type EventLog interface {
+	Recent(typ events.Type, limit int) []*events.Event
+}
+
+// RecentEventsRequest is the request type for the RecentEvents method. Type is
+// optional, and if set only events of that type are returned. If Limit is zero
+// every matching event held is returned.
+type RecentEventsRequest struct {
+	Type  string `json:"type"`
+	Limit int    `json:"limit"`
+}
+
+// RecentEventsResponse is the response type for the RecentEvents method.
+type RecentEventsResponse struct {
+	Events []*events.Event `json:"events"`
+}
+
+// RecentEvents returns the events recently published on our event bus, most
+// recent first. Events are held in memory, so only those published since the
+// encoder started are returned.
+func (a *Admin) RecentEvents(ctx context.Context, req *RecentEventsRequest) (*RecentEventsResponse, error) {
+	if a.events == nil {
+		return nil, twirp.NewError(twirp.Unimplemented, "events are not available")
+	}
+
+	if req.Limit < 0 || req.Limit > MaxEventsLimit {
+		return nil, twirp.InvalidArgumentError("limit", fmt.Sprintf("must be between 0 and %d", MaxEventsLimit))
+	}
+
+	return &RecentEventsResponse{
+		Events: a.events.Recent(events.Type(req.Type), req.Limit),
+	}, nil
+}
+
+func (a *Admin) handleRecentEvents(w http.ResponseWriter, r *http.Request) {
+	var req RecentEventsRequest
+
+	err := decodeRequest(r, &req)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	resp, err := a.RecentEvents(r.Context(), &req)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	a.writeResponse(w, resp)
+}
@@ -0,0 +1,610 @@
+package admin
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/twitchtv/twirp"
+	"golang.org/x/crypto/scrypt"
+
+	"github.com/DECODEproject/iotencoder/pkg/logger/level"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+)
+
+const (
+	// ExportVersion is the version of the format written by ExportStreams. It
+	// is recorded in the header of each export, and ImportStreams rejects
+	// exports of any other version.
+	ExportVersion = 1
+
+	// MinExportKeyLength is the minimum length of the export key configured
+	// for an encoder, under which exported secrets are encrypted.
+	MinExportKeyLength = 16
+
+	// saltLength is the length of the random salt combined with the export key
+	// to derive the key of each export.
+	saltLength = 16
+
+	// scryptN, scryptR and scryptP are the scrypt cost parameters with which
+	// the key of each export is derived, and maxScryptMemory bounds the memory
+	// (128 * N * r bytes) the parameters recorded in an imported export may
+	// make us use.
+	scryptN         = 1 << 15
+	scryptR         = 8
+	scryptP         = 1
+	maxScryptMemory = 256 << 20
+)
+
+// ExportStreamsRequest is the request type for the ExportStreams method.
+type ExportStreamsRequest struct {
+	ExportKey string `json:"export_key"`
+}
+
+// ExportHeader is the first line of an export, identifying its format and the
+// salt and scrypt parameters with which its encryption key was derived.
+type ExportHeader struct {
+	Version    int          `json:"version"`
+	Salt       string       `json:"salt"`
+	KDF        ScryptParams `json:"kdf"`
+	ExportedAt time.Time    `json:"exported_at"`
+}
+
+// ScryptParams are the scrypt cost parameters with which the encryption key of
+// an export was derived from the export key.
+type ScryptParams struct {
+	N int `json:"n"`
+	R int `json:"r"`
+	P int `json:"p"`
+}
+
+// valid returns true if the parameters are accepted by scrypt and within the
+// cost we are prepared to spend deriving the key of an imported export.
+func (p ScryptParams) valid() bool {
+	if p.N <= 1 || p.N&(p.N-1) != 0 || p.R < 1 || p.P < 1 || p.P > 16 {
+		return false
+	}
+
+	return p.R <= maxScryptMemory/128/p.N
+}
+
+// ExportedStream is the representation of a stream written by ExportStreams,
+// one per line following the header. The device token, stream token and
+// ingest secret are encrypted under the export key, while the rest of the
+// stream's configuration is in the clear.
+type ExportedStream struct {
+	StreamUid          string                `json:"stream_uid"`
+	CommunityId        string                `json:"community_id"`
+	RecipientPublicKey string                `json:"recipient_public_key"`
+	DeviceToken        string                `json:"device_token"`
+	DeviceLabel        string                `json:"device_label"`
+	Longitude          float64               `json:"longitude"`
+	Latitude           float64               `json:"latitude"`
+	Exposure           string                `json:"exposure"`
+	Operations         []*postgres.Operation `json:"operations"`
+	DatastoreAddr      string                `json:"datastore_addr,omitempty"`
+	Conversions        map[uint32]string     `json:"conversions,omitempty"`
+	Source             string                `json:"source,omitempty"`
+	Compression        string                `json:"compression,omitempty"`
+	TimestampPolicy    string                `json:"timestamp_policy,omitempty"`
+	PolicyID           string                `json:"policy_id,omitempty"`
+	Labels             map[string]string     `json:"labels,omitempty"`
+	DatastoreTimeout   string                `json:"datastore_timeout,omitempty"`
+	Schema             string                `json:"schema,omitempty"`
+	Dispositions       postgres.Dispositions `json:"dispositions,omitempty"`
+	Privacy            string                `json:"privacy,omitempty"`
+	GeoPrivacy         string                `json:"geo_privacy,omitempty"`
+	RateLimit          string                `json:"rate_limit,omitempty"`
+	Sampling           string                `json:"sampling,omitempty"`
+	Schedule           string                `json:"schedule,omitempty"`
+	Geofence           string                `json:"geofence,omitempty"`
+	Archive            bool                  `json:"archive,omitempty"`
+	Priority           string                `json:"priority,omitempty"`
+	TopicTemplate      string                `json:"topic_template,omitempty"`
+	QoS                int                   `json:"qos,omitempty"`
+	Token              string                `json:"token"`
+	IngestSecret       string                `json:"ingest_secret,omitempty"`
+}
+
+// ImportStreamsRequest is the request type for the ImportStreams method. It is
+// sent as the first line of the request body, followed by the lines of an
+// export exactly as written by ExportStreams.
+type ImportStreamsRequest struct {
+	ExportKey string `json:"export_key"`
+}
+
+// ImportStreamsResponse is the response type for the ImportStreams method,
+// reporting how many streams were imported, how many were skipped as they
+// already exist, and any which could not be imported.
+type ImportStreamsResponse struct {
+	Imported int              `json:"imported"`
+	Skipped  int              `json:"skipped"`
+	Failed   []*ImportFailure `json:"failed"`
+}
+
+// ImportFailure describes a stream which could not be imported or migrated.
+type ImportFailure struct {
+	StreamUid string `json:"stream_uid"`
+	Error     string `json:"error"`
+}
+
+// ExportStreams writes the configuration of every live stream to w as
+// newline delimited JSON, so that a replacement encoder may be rebuilt after
+// data loss using ImportStreams. The first line is an ExportHeader, followed
+// by an ExportedStream per stream. Stream secrets are re-encrypted under the
+// export key configured by the operator rather than the encoder's encryption
+// password, so the export must be kept as safe as that key. The request must
+// present the configured key, and exports are refused if none is configured.
+func (a *Admin) ExportStreams(ctx context.Context, req *ExportStreamsRequest, w io.Writer) (err error) {
+	var count int
+
+	defer func() {
+		a.audit(ctx, "ExportStreams", "", map[string]int{"streams": count}, err)
+	}()
+
+	if a.exporter == nil {
+		return twirp.NewError(twirp.Unimplemented, "stream export is not available")
+	}
+
+	err = a.checkExportKey(req.ExportKey)
+	if err != nil {
+		return err
+	}
+
+	count, err = writeExport(w, req.ExportKey, func(fn func(stream *postgres.Stream) error) error {
+		return a.exporter.ExportStreams(func(stream *postgres.Stream) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			return fn(stream)
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	a.logger.Log("msg", "exported streams", "streams", count)
+
+	return nil
+}
+
+// writeExport writes an export of the streams passed to fn by the given
+// export function to w, encrypting their secrets under the given export key,
+// and returns the number of streams written.
+func writeExport(w io.Writer, exportKey string, export func(fn func(stream *postgres.Stream) error) error) (int, error) {
+	salt := make([]byte, saltLength)
+
+	_, err := rand.Read(salt)
+	if err != nil {
+		return 0, twirp.InternalErrorWith(err)
+	}
+
+	params := ScryptParams{N: scryptN, R: scryptR, P: scryptP}
+
+	s, err := newSealer(exportKey, salt, params)
+	if err != nil {
+		return 0, twirp.InternalErrorWith(err)
+	}
+
+	enc := json.NewEncoder(w)
+
+	err = enc.Encode(&ExportHeader{
+		Version:    ExportVersion,
+		Salt:       base64.StdEncoding.EncodeToString(salt),
+		KDF:        params,
+		ExportedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to write export header")
+	}
+
+	var count int
+
+	err = export(func(stream *postgres.Stream) error {
+		exported, err := s.exportStream(stream)
+		if err != nil {
+			return err
+		}
+
+		count++
+
+		return enc.Encode(exported)
+	})
+	if err != nil {
+		return count, errors.Wrap(err, "failed to export streams")
+	}
+
+	return count, nil
+}
+
+// ImportStreams reads an export written by ExportStreams from r, registering
+// each stream with its original id, token and ingest secret and subscribing to
+// its device. The request must present our configured export key, so exports
+// may only be imported by encoders sharing the key of the exporting encoder.
+// Streams which already exist are skipped, so an interrupted import may safely
+// be repeated, while streams which cannot be imported are reported in the
+// response rather than aborting the import.
+func (a *Admin) ImportStreams(ctx context.Context, req *ImportStreamsRequest, r io.Reader) (*ImportStreamsResponse, error) {
+	if a.importer == nil {
+		return nil, twirp.NewError(twirp.Unimplemented, "stream import is not available")
+	}
+
+	err := a.checkExportKey(req.ExportKey)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(r)
+
+	var header ExportHeader
+
+	err = dec.Decode(&header)
+	if err != nil {
+		return nil, twirp.InvalidArgumentError("export", "must begin with an export header")
+	}
+
+	if header.Version != ExportVersion {
+		return nil, twirp.InvalidArgumentError("export", fmt.Sprintf("has unsupported version %d", header.Version))
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(header.Salt)
+	if err != nil || len(salt) != saltLength {
+		return nil, twirp.InvalidArgumentError("export", "has an invalid salt")
+	}
+
+	if !header.KDF.valid() {
+		return nil, twirp.InvalidArgumentError("export", "has invalid key derivation parameters")
+	}
+
+	s, err := newSealer(req.ExportKey, salt, header.KDF)
+	if err != nil {
+		return nil, twirp.InternalErrorWith(err)
+	}
+
+	resp := &ImportStreamsResponse{
+		Failed: []*ImportFailure{},
+	}
+
+	for line := 2; ; line++ {
+		if err := ctx.Err(); err != nil {
+			return nil, twirp.InternalErrorWith(err)
+		}
+
+		var exported ExportedStream
+
+		err = dec.Decode(&exported)
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, twirp.InvalidArgumentError("export", fmt.Sprintf("line %d could not be decoded", line))
+		}
+
+		stream, err := s.importStream(&exported)
+		if err != nil {
+			return nil, twirp.InvalidArgumentError("export_key", "cannot decrypt the exported streams")
+		}
+
+		_, err = a.importer.ImportStream(ctx, stream)
+		if err != nil {
+			if twerr, ok := err.(twirp.Error); ok {
+				switch {
+				case twerr.Code() == twirp.AlreadyExists && twerr.Msg() == postgres.ErrStreamExists.Error():
+					resp.Skipped++
+					continue
+				case twerr.Code() == twirp.Unavailable:
+					return nil, twerr
+				}
+			}
+
+			resp.Failed = append(resp.Failed, &ImportFailure{
+				StreamUid: exported.StreamUid,
+				Error:     err.Error(),
+			})
+
+			continue
+		}
+
+		resp.Imported++
+	}
+
+	a.logger.Log("msg", "imported streams", "imported", resp.Imported, "skipped", resp.Skipped, "failed", len(resp.Failed))
+
+	return resp, nil
+}
+
+func (a *Admin) handleExportStreams(w http.ResponseWriter, r *http.Request) {
+	var req ExportStreamsRequest
+
+	err := decodeRequest(r, &req)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	sw := &streamWriter{w: w}
+
+	err = a.ExportStreams(r.Context(), &req, sw)
+	if err != nil {
+		if !sw.started {
+			a.writeError(w, err)
+			return
+		}
+
+		// the status has already been sent, so the only way to tell the client
+		// the export is incomplete is to abort the response
+		level.Error(a.logger).Log("msg", "failed to export streams", "err", err)
+		panic(http.ErrAbortHandler)
+	}
+
+	if !sw.started {
+		sw.start()
+	}
+}
+
+func (a *Admin) handleImportStreams(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	dec := json.NewDecoder(r.Body)
+
+	var req ImportStreamsRequest
+
+	err := dec.Decode(&req)
+	if err != nil {
+		a.writeError(w, twirp.NewError(twirp.InvalidArgument, "the json request could not be decoded"))
+		return
+	}
+
+	resp, err := a.ImportStreams(r.Context(), &req, io.MultiReader(dec.Buffered(), r.Body))
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	a.writeResponse(w, resp)
+}
+
+// streamWriter writes a newline delimited JSON response, sending the response
+// headers when first written to so that errors returned before then may still
+// be written as twirp errors.
+type streamWriter struct {
+	w       http.ResponseWriter
+	started bool
+}
+
+// Write implements io.Writer, flushing each write so that clients receive
+// streams as they are exported.
+func (s *streamWriter) Write(p []byte) (int, error) {
+	if !s.started {
+		s.start()
+	}
+
+	n, err := s.w.Write(p)
+
+	if f, ok := s.w.(http.Flusher); ok {
+		f.Flush()
+	}
+
+	return n, err
+}
+
+// start writes the response headers.
+func (s *streamWriter) start() {
+	s.started = true
+	s.w.Header().Set("Content-Type", "application/x-ndjson")
+	s.w.WriteHeader(http.StatusOK)
+}
+
+// checkExportKey returns a twirp error if no export key is configured, or if
+// the given export key is missing or does not match the configured key. Keys
+// are compared in constant time so the configured key cannot be recovered by
+// timing requests.
+func (a *Admin) checkExportKey(exportKey string) error {
+	if a.exportKey == "" {
+		return twirp.NewError(twirp.FailedPrecondition, "no export key is configured")
+	}
+
+	if exportKey == "" {
+		return twirp.RequiredArgumentError("export_key")
+	}
+
+	if subtle.ConstantTimeCompare([]byte(exportKey), []byte(a.exportKey.Reveal())) != 1 {
+		return twirp.NewError(twirp.PermissionDenied, "export_key does not match the configured export key")
+	}
+
+	return nil
+}
+
+// sealer encrypts and decrypts the secrets of exported streams using AES-GCM,
+// with a key derived by scrypt from the export key and the salt of the export.
+// Each secret is bound to the id of its stream, so secrets cannot be swapped
+// between streams within an export.
+type sealer struct {
+	aead cipher.AEAD
+}
+
+// newSealer returns a sealer for the given export key, salt and scrypt
+// parameters.
+func newSealer(exportKey string, salt []byte, params ScryptParams) (*sealer, error) {
+	key, err := scrypt.Key([]byte(exportKey), salt, params.N, params.R, params.P, 32)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to derive key")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create cipher")
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create AEAD")
+	}
+
+	return &sealer{aead: aead}, nil
+}
+
+// seal encrypts the given secret of the given stream, returning the nonce and
+// ciphertext base64 encoded.
+func (s *sealer) seal(streamID string, value secret.Secret) (string, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to generate nonce")
+	}
+
+	sealed := s.aead.Seal(nonce, nonce, []byte(value.Reveal()), []byte(streamID))
+
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// open decrypts a secret of the given stream previously encrypted by seal.
+func (s *sealer) open(streamID, value string) (secret.Secret, error) {
+	sealed, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to decode secret")
+	}
+
+	if len(sealed) < s.aead.NonceSize() {
+		return "", errors.New("sealed secret is too short")
+	}
+
+	nonce, ciphertext := sealed[:s.aead.NonceSize()], sealed[s.aead.NonceSize():]
+
+	plaintext, err := s.aead.Open(nil, nonce, ciphertext, []byte(streamID))
+	if err != nil {
+		return "", errors.Wrap(err, "failed to decrypt secret")
+	}
+
+	return secret.Secret(plaintext), nil
+}
+
+// exportStream converts the given stream into its exported representation,
+// encrypting its secrets.
+func (s *sealer) exportStream(stream *postgres.Stream) (*ExportedStream, error) {
+	st := newStream(stream)
+
+	exported := &ExportedStream{
+		StreamUid:          st.StreamUid,
+		CommunityId:        st.CommunityId,
+		RecipientPublicKey: st.RecipientPublicKey,
+		DeviceLabel:        st.DeviceLabel,
+		Longitude:          st.Longitude,
+		Latitude:           st.Latitude,
+		Exposure:           st.Exposure,
+		Operations:         st.Operations,
+		DatastoreAddr:      st.DatastoreAddr,
+		Conversions:        st.Conversions,
+		Source:             st.Source,
+		Compression:        st.Compression,
+		TimestampPolicy:    st.TimestampPolicy,
+		PolicyID:           st.PolicyID,
+		Labels:             st.Labels,
+		DatastoreTimeout:   st.DatastoreTimeout,
+		Schema:             st.Schema,
+		Dispositions:       st.Dispositions,
+		Privacy:            st.Privacy,
+		GeoPrivacy:         st.GeoPrivacy,
+		RateLimit:          st.RateLimit,
+		Sampling:           st.Sampling,
+		Schedule:           st.Schedule,
+		Geofence:           st.Geofence,
+		Archive:            st.Archive,
+		Priority:           st.Priority,
+		TopicTemplate:      st.TopicTemplate,
+		QoS:                st.QoS,
+	}
+
+	var err error
+
+	exported.DeviceToken, err = s.seal(stream.StreamID, stream.Device.DeviceToken)
+	if err != nil {
+		return nil, err
+	}
+
+	exported.Token, err = s.seal(stream.StreamID, stream.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	if stream.IngestSecret != "" {
+		exported.IngestSecret, err = s.seal(stream.StreamID, stream.IngestSecret)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return exported, nil
+}
+
+// importStream converts the given exported stream back into a stream,
+// decrypting its secrets.
+func (s *sealer) importStream(exported *ExportedStream) (*postgres.Stream, error) {
+	deviceToken, err := s.open(exported.StreamUid, exported.DeviceToken)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := s.open(exported.StreamUid, exported.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	var ingestSecret secret.Secret
+
+	if exported.IngestSecret != "" {
+		ingestSecret, err = s.open(exported.StreamUid, exported.IngestSecret)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &postgres.Stream{
+		StreamID:         exported.StreamUid,
+		Token:            token,
+		IngestSecret:     ingestSecret,
+		CommunityID:      exported.CommunityId,
+		PublicKey:        exported.RecipientPublicKey,
+		Operations:       postgres.Operations(exported.Operations),
+		DatastoreAddr:    exported.DatastoreAddr,
+		Conversions:      postgres.Conversions(exported.Conversions),
+		Source:           exported.Source,
+		Compression:      exported.Compression,
+		TimestampPolicy:  exported.TimestampPolicy,
+		PolicyID:         exported.PolicyID,
+		Labels:           postgres.Labels(exported.Labels),
+		DatastoreTimeout: exported.DatastoreTimeout,
+		Schema:           exported.Schema,
+		Dispositions:     exported.Dispositions,
+		Privacy:          exported.Privacy,
+		GeoPrivacy:       exported.GeoPrivacy,
+		RateLimit:        exported.RateLimit,
+		Sampling:         exported.Sampling,
+		Schedule:         exported.Schedule,
+		Geofence:         exported.Geofence,
+		Archive:          exported.Archive,
+		Priority:         exported.Priority,
+		Topic:            exported.TopicTemplate,
+		QoS:              exported.QoS,
+		Device: &postgres.Device{
+			DeviceToken: deviceToken,
+			Label:       exported.DeviceLabel,
+			Longitude:   exported.Longitude,
+			Latitude:    exported.Latitude,
+			Exposure:    exported.Exposure,
+		},
+	}, nil
+}
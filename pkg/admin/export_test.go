@@ -0,0 +1,242 @@
+package admin_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/twitchtv/twirp"
+	goji "goji.io"
+	"goji.io/pat"
+
+	"github.com/DECODEproject/iotencoder/pkg/admin"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/postgres/postgrestest"
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+)
+
+// importer imports streams into an in-memory DB, returning twirp errors as the
+// encoder does.
+type importer struct {
+	db *postgrestest.DB
+}
+
+func (i *importer) ImportStream(ctx context.Context, stream *postgres.Stream) (*postgres.Stream, error) {
+	imported, err := i.db.ImportStream(stream)
+	if err != nil {
+		switch err {
+		case postgres.ErrStreamExists, postgres.ErrStreamConflict:
+			return nil, twirp.NewError(twirp.AlreadyExists, err.Error())
+		}
+		return nil, twirp.InternalErrorWith(err)
+	}
+
+	return imported, nil
+}
+
+// exportKey is the export key configured for the encoders under test.
+const exportKey = "correct horse battery staple"
+
+func newExportClient(t *testing.T, config *admin.Config) (*admin.Client, func()) {
+	t.Helper()
+
+	config.Token = adminToken
+
+	if config.ExportKey == "" {
+		config.ExportKey = exportKey
+	}
+
+	a := admin.NewAdmin(config, kitlog.NewNopLogger())
+
+	mux := goji.NewMux()
+	mux.Handle(pat.New(admin.PathPrefix+"*"), a.Handler())
+
+	ts := httptest.NewServer(mux)
+
+	return admin.NewClient(ts.URL, adminToken, &http.Client{}), ts.Close
+}
+
+func TestExportImportStreams(t *testing.T) {
+	ctx := context.Background()
+
+	src := postgrestest.NewDB()
+
+	first, err := src.CreateStream(&postgres.Stream{
+		CommunityID: "community-1",
+		PublicKey:   "public-key",
+		Operations:  postgres.Operations{{SensorID: 12, Action: postgres.Share}},
+		Conversions: postgres.Conversions{12: "x * 2"},
+		Device: &postgres.Device{
+			DeviceToken: "device-token",
+			Label:       "device",
+			Longitude:   2.1,
+			Latitude:    41.4,
+			Exposure:    "outdoor",
+		},
+	})
+	assert.Nil(t, err)
+
+	err = src.SetIngestSecret(first.StreamID, first.Token.Reveal(), "ingest-secret")
+	assert.Nil(t, err)
+
+	second, err := src.CreateStream(&postgres.Stream{
+		CommunityID: "community-2",
+		PublicKey:   "public-key-2",
+		Device: &postgres.Device{
+			DeviceToken: "device-token",
+			Label:       "device",
+			Exposure:    "outdoor",
+		},
+	})
+	assert.Nil(t, err)
+
+	client, cleanup := newExportClient(t, &admin.Config{Exporter: src})
+	defer cleanup()
+
+	var export bytes.Buffer
+
+	err = client.ExportStreams(ctx, &admin.ExportStreamsRequest{ExportKey: exportKey}, &export)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, bytes.Count(export.Bytes(), []byte("\n")))
+	assert.False(t, bytes.Contains(export.Bytes(), []byte(first.Token.Reveal())))
+	assert.False(t, bytes.Contains(export.Bytes(), []byte("ingest-secret")))
+	assert.False(t, bytes.Contains(export.Bytes(), []byte("device-token")))
+
+	dst := postgrestest.NewDB()
+
+	client, cleanup = newExportClient(t, &admin.Config{Importer: &importer{db: dst}})
+	defer cleanup()
+
+	_, err = client.ImportStreams(ctx, &admin.ImportStreamsRequest{ExportKey: "the wrong export key"}, bytes.NewReader(export.Bytes()))
+	assert.NotNil(t, err)
+	assert.Equal(t, twirp.PermissionDenied, err.(twirp.Error).Code())
+
+	// an export cannot be imported by an encoder with a different key
+	other, otherCleanup := newExportClient(t, &admin.Config{
+		Importer:  &importer{db: dst},
+		ExportKey: "a different export key",
+	})
+	defer otherCleanup()
+
+	_, err = other.ImportStreams(ctx, &admin.ImportStreamsRequest{ExportKey: "a different export key"}, bytes.NewReader(export.Bytes()))
+	assert.NotNil(t, err)
+	assert.Equal(t, "export_key", err.(twirp.Error).Meta("argument"))
+
+	resp, err := client.ImportStreams(ctx, &admin.ImportStreamsRequest{ExportKey: exportKey}, bytes.NewReader(export.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, resp.Imported)
+	assert.Equal(t, 0, resp.Skipped)
+	assert.Len(t, resp.Failed, 0)
+
+	// streams keep their ids, tokens and secrets
+	stream, err := dst.GetStream(first.StreamID, first.Token.Reveal())
+	assert.Nil(t, err)
+	assert.Equal(t, "community-1", stream.CommunityID)
+	assert.Equal(t, postgres.Conversions{12: "x * 2"}, stream.Conversions)
+	assert.Equal(t, "outdoor", stream.Device.Exposure)
+
+	_, err = dst.GetStream(second.StreamID, second.Token.Reveal())
+	assert.Nil(t, err)
+
+	device, err := dst.GetDevice(secret.Secret("device-token"))
+	assert.Nil(t, err)
+	assert.Len(t, device.Streams, 2)
+	assert.Equal(t, secret.Secret("ingest-secret"), device.Streams[0].IngestSecret)
+
+	// importing again skips the existing streams
+	resp, err = client.ImportStreams(ctx, &admin.ImportStreamsRequest{ExportKey: exportKey}, bytes.NewReader(export.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, resp.Imported)
+	assert.Equal(t, 2, resp.Skipped)
+}
+
+func TestExportStreamsErrors(t *testing.T) {
+	ctx := context.Background()
+
+	client, cleanup := newExportClient(t, &admin.Config{Exporter: postgrestest.NewDB()})
+	defer cleanup()
+
+	testcases := []struct {
+		label     string
+		exportKey string
+		code      twirp.ErrorCode
+	}{
+		{
+			label:     "missing key",
+			exportKey: "",
+			code:      twirp.InvalidArgument,
+		},
+		{
+			label:     "wrong key",
+			exportKey: "the wrong export key",
+			code:      twirp.PermissionDenied,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			var export bytes.Buffer
+
+			err := client.ExportStreams(ctx, &admin.ExportStreamsRequest{ExportKey: tc.exportKey}, &export)
+			assert.NotNil(t, err)
+			assert.Equal(t, tc.code, err.(twirp.Error).Code())
+			assert.Equal(t, 0, export.Len())
+		})
+	}
+
+	// exports are refused by an encoder with no export key configured
+	var export bytes.Buffer
+
+	err := admin.NewAdmin(&admin.Config{Exporter: postgrestest.NewDB()}, kitlog.NewNopLogger()).ExportStreams(ctx, &admin.ExportStreamsRequest{ExportKey: exportKey}, &export)
+	assert.NotNil(t, err)
+	assert.Equal(t, twirp.FailedPrecondition, err.(twirp.Error).Code())
+
+	_, err = client.ImportStreams(ctx, &admin.ImportStreamsRequest{ExportKey: exportKey}, bytes.NewReader(nil))
+	assert.NotNil(t, err)
+	assert.Equal(t, twirp.Unimplemented, err.(twirp.Error).Code())
+}
+
+func TestImportStreamsHeader(t *testing.T) {
+	ctx := context.Background()
+
+	client, cleanup := newExportClient(t, &admin.Config{Importer: &importer{db: postgrestest.NewDB()}})
+	defer cleanup()
+
+	testcases := []struct {
+		label  string
+		header string
+	}{
+		{
+			label:  "unsupported version",
+			header: `{"version":2,"salt":"AAAAAAAAAAAAAAAAAAAAAA==","kdf":{"n":32768,"r":8,"p":1}}`,
+		},
+		{
+			label:  "missing parameters",
+			header: `{"version":1,"salt":"AAAAAAAAAAAAAAAAAAAAAA=="}`,
+		},
+		{
+			label:  "N not a power of two",
+			header: `{"version":1,"salt":"AAAAAAAAAAAAAAAAAAAAAA==","kdf":{"n":1000,"r":8,"p":1}}`,
+		},
+		{
+			label:  "excessive cost",
+			header: `{"version":1,"salt":"AAAAAAAAAAAAAAAAAAAAAA==","kdf":{"n":1073741824,"r":8,"p":1}}`,
+		},
+		{
+			label:  "invalid salt",
+			header: `{"version":1,"salt":"AAAA","kdf":{"n":32768,"r":8,"p":1}}`,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			_, err := client.ImportStreams(ctx, &admin.ImportStreamsRequest{ExportKey: exportKey}, bytes.NewReader([]byte(tc.header+"\n")))
+			assert.NotNil(t, err)
+			assert.Equal(t, twirp.InvalidArgument, err.(twirp.Error).Code())
+		})
+	}
+}
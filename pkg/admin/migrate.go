@@ -0,0 +1,295 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	encoder "github.com/thingful/twirp-encoder-go"
+	"github.com/twitchtv/twirp"
+
+	"github.com/DECODEproject/iotencoder/pkg/logger/level"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+)
+
+const (
+	// DefaultVerifyTimeout is how long MigrateStreams waits for the target
+	// encoder to receive a message from each device if no timeout is requested.
+	DefaultVerifyTimeout = 5 * time.Minute
+
+	// verifyInterval is how often MigrateStreams asks the target encoder whether
+	// it has received messages from the migrated devices.
+	verifyInterval = 5 * time.Second
+)
+
+// MigrateStreamsRequest is the request type for the MigrateStreams method.
+// TargetToken is the admin token of the target encoder. VerifyTimeout is a
+// duration such as "90s"; if empty DefaultVerifyTimeout is used.
+type MigrateStreamsRequest struct {
+	TargetAddr    string   `json:"target_addr"`
+	TargetToken   string   `json:"target_token"`
+	StreamUids    []string `json:"stream_uids"`
+	VerifyTimeout string   `json:"verify_timeout,omitempty"`
+}
+
+// MigrateStreamsResponse is the response type for the MigrateStreams method,
+// listing the streams which were migrated and deleted locally, and those which
+// were not along with the reason. Streams which failed verification have been
+// imported by the target but are still processed by this encoder too.
+type MigrateStreamsResponse struct {
+	Migrated []string         `json:"migrated"`
+	Failed   []*ImportFailure `json:"failed"`
+}
+
+// MigrateStreams moves the requested streams to the encoder whose admin API is
+// listening at the target address, so that streams can be moved between
+// clusters without readings being lost. Streams are first imported into the
+// target with their existing ids, tokens and ingest secrets, and only once the
+// target has received a message from a stream's device is the stream deleted
+// locally. Until then both encoders process the device's readings, so some
+// readings may be written twice. Streams are exported under our configured
+// export key, so the target must be configured with the same key.
+func (a *Admin) MigrateStreams(ctx context.Context, req *MigrateStreamsRequest) (*MigrateStreamsResponse, error) {
+	if a.exporter == nil || a.deleter == nil || a.client == nil {
+		return nil, twirp.NewError(twirp.Unimplemented, "stream migration is not available")
+	}
+
+	if a.exportKey == "" {
+		return nil, twirp.NewError(twirp.FailedPrecondition, "no export key is configured")
+	}
+
+	if req.TargetAddr == "" {
+		return nil, twirp.RequiredArgumentError("target_addr")
+	}
+
+	if req.TargetToken == "" {
+		return nil, twirp.RequiredArgumentError("target_token")
+	}
+
+	if len(req.StreamUids) == 0 {
+		return nil, twirp.RequiredArgumentError("stream_uids")
+	}
+
+	verifyTimeout := DefaultVerifyTimeout
+
+	if req.VerifyTimeout != "" {
+		d, err := time.ParseDuration(req.VerifyTimeout)
+		if err != nil || d <= 0 {
+			return nil, twirp.InvalidArgumentError("verify_timeout", "must be a positive duration")
+		}
+		verifyTimeout = d
+	}
+
+	requested := map[string]bool{}
+	for _, streamID := range req.StreamUids {
+		requested[streamID] = true
+	}
+
+	streams := map[string]*postgres.Stream{}
+
+	err := a.exporter.ExportStreams(func(stream *postgres.Stream) error {
+		if requested[stream.StreamID] {
+			streams[stream.StreamID] = stream
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, twirp.InternalErrorWith(err)
+	}
+
+	resp := &MigrateStreamsResponse{
+		Migrated: []string{},
+		Failed:   []*ImportFailure{},
+	}
+
+	fail := func(streamID string, err error) {
+		a.audit(ctx, "MigrateStreams", streamID, map[string]string{"target_addr": req.TargetAddr}, err)
+
+		resp.Failed = append(resp.Failed, &ImportFailure{
+			StreamUid: streamID,
+			Error:     err.Error(),
+		})
+	}
+
+	selected := []*postgres.Stream{}
+	added := map[string]bool{}
+
+	for _, streamID := range req.StreamUids {
+		if added[streamID] {
+			continue
+		}
+		added[streamID] = true
+
+		stream, ok := streams[streamID]
+		if !ok {
+			fail(streamID, errors.New("stream not found"))
+			continue
+		}
+
+		selected = append(selected, stream)
+	}
+
+	if len(selected) == 0 {
+		return resp, nil
+	}
+
+	target := NewClient(req.TargetAddr, secret.Secret(req.TargetToken), a.client)
+
+	// the last message the target received from each device before the import,
+	// so that we only accept messages received since as verification
+	lastSeen := map[string]*time.Time{}
+
+	for _, stream := range selected {
+		lastSeen[stream.Device.DeviceToken.Reveal()] = a.lastSeenAt(ctx, target, stream.Device.DeviceToken.Reveal())
+	}
+
+	imported, err := a.importInto(ctx, target, selected)
+	if err != nil {
+		return nil, err
+	}
+
+	importFailures := map[string]string{}
+	for _, failure := range imported.Failed {
+		importFailures[failure.StreamUid] = failure.Error
+	}
+
+	verifying := []*postgres.Stream{}
+	pending := map[string]*time.Time{}
+
+	for _, stream := range selected {
+		if msg, ok := importFailures[stream.StreamID]; ok {
+			fail(stream.StreamID, errors.Errorf("failed to import stream into target: %s", msg))
+			continue
+		}
+
+		verifying = append(verifying, stream)
+		pending[stream.Device.DeviceToken.Reveal()] = lastSeen[stream.Device.DeviceToken.Reveal()]
+	}
+
+	verified := a.verifyDevices(ctx, target, pending, verifyTimeout)
+
+	for _, stream := range verifying {
+		if !verified[stream.Device.DeviceToken.Reveal()] {
+			fail(stream.StreamID, errors.New("target received no messages from device within verify timeout"))
+			continue
+		}
+
+		_, err := a.deleter.DeleteStream(ctx, &encoder.DeleteStreamRequest{
+			StreamUid: stream.StreamID,
+			Token:     stream.Token.Reveal(),
+		})
+		if err != nil {
+			fail(stream.StreamID, errors.Wrap(err, "failed to delete migrated stream"))
+			continue
+		}
+
+		a.audit(ctx, "MigrateStreams", stream.StreamID, map[string]string{"target_addr": req.TargetAddr}, nil)
+
+		resp.Migrated = append(resp.Migrated, stream.StreamID)
+	}
+
+	a.logger.Log("msg", "migrated streams", "target_addr", req.TargetAddr, "migrated", len(resp.Migrated), "failed", len(resp.Failed))
+
+	return resp, nil
+}
+
+// importInto imports the given streams into the target encoder, exporting them
+// under our configured export key.
+func (a *Admin) importInto(ctx context.Context, target *Client, streams []*postgres.Stream) (*ImportStreamsResponse, error) {
+	var buf bytes.Buffer
+
+	_, err := writeExport(&buf, a.exportKey.Reveal(), func(fn func(stream *postgres.Stream) error) error {
+		for _, stream := range streams {
+			err := fn(stream)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, twirp.InternalErrorWith(err)
+	}
+
+	resp, err := target.ImportStreams(ctx, &ImportStreamsRequest{ExportKey: a.exportKey.Reveal()}, &buf)
+	if err != nil {
+		return nil, twirp.NewError(twirp.Unavailable, fmt.Sprintf("target failed to import streams: %s", err))
+	}
+
+	return resp, nil
+}
+
+// verifyDevices polls the target encoder until it has received a message from
+// each of the given devices more recent than the one recorded against it,
+// returning the set of devices for which it has. Devices from which no message
+// is received before the timeout are not returned.
+func (a *Admin) verifyDevices(ctx context.Context, target *Client, lastSeen map[string]*time.Time, timeout time.Duration) map[string]bool {
+	verified := map[string]bool{}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	ticker := time.NewTicker(verifyInterval)
+	defer ticker.Stop()
+
+	for {
+		for deviceToken, previous := range lastSeen {
+			if verified[deviceToken] {
+				continue
+			}
+
+			seen := a.lastSeenAt(ctx, target, deviceToken)
+			if seen != nil && (previous == nil || seen.After(*previous)) {
+				verified[deviceToken] = true
+			}
+		}
+
+		if len(verified) == len(lastSeen) {
+			return verified
+		}
+
+		select {
+		case <-ticker.C:
+		case <-timer.C:
+			return verified
+		case <-ctx.Done():
+			return verified
+		}
+	}
+}
+
+// lastSeenAt returns when the target encoder last received a message from the
+// given device, or nil if it has not or its status could not be read.
+func (a *Admin) lastSeenAt(ctx context.Context, target *Client, deviceToken string) *time.Time {
+	status, err := target.DeviceStatus(ctx, &DeviceStatusRequest{DeviceToken: deviceToken})
+	if err != nil {
+		if twerr, ok := err.(twirp.Error); !ok || twerr.Code() != twirp.NotFound {
+			level.Warn(a.logger).Log("msg", "failed to read device status from target", "err", err)
+		}
+		return nil
+	}
+
+	return status.LastSeenAt
+}
+
+func (a *Admin) handleMigrateStreams(w http.ResponseWriter, r *http.Request) {
+	var req MigrateStreamsRequest
+
+	err := decodeRequest(r, &req)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	resp, err := a.MigrateStreams(r.Context(), &req)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	a.writeResponse(w, resp)
+}
@@ -0,0 +1,206 @@
+package admin_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	encoder "github.com/thingful/twirp-encoder-go"
+	"github.com/twitchtv/twirp"
+	goji "goji.io"
+	"goji.io/pat"
+
+	"github.com/DECODEproject/iotencoder/pkg/admin"
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/postgres/postgrestest"
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+	"github.com/DECODEproject/iotencoder/pkg/stats"
+)
+
+// receivingImporter imports streams into an in-memory DB, and records a
+// message from the device of each imported stream which is sending, as if the
+// encoder had subscribed and received one.
+type receivingImporter struct {
+	importer
+	stats   *stats.Store
+	sending map[string]bool
+}
+
+func (r *receivingImporter) ImportStream(ctx context.Context, stream *postgres.Stream) (*postgres.Stream, error) {
+	imported, err := r.importer.ImportStream(ctx, stream)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.sending[stream.Device.DeviceToken.Reveal()] {
+		r.stats.RecordDeviceMessage(stream.Device.DeviceToken)
+	}
+
+	return imported, nil
+}
+
+// deleter deletes streams from an in-memory DB.
+type deleter struct {
+	db *postgrestest.DB
+}
+
+func (d *deleter) DeleteStream(ctx context.Context, req *encoder.DeleteStreamRequest) (*encoder.DeleteStreamResponse, error) {
+	_, err := d.db.DeleteStream(&postgres.Stream{StreamID: req.StreamUid, Token: secret.Secret(req.Token)})
+	if err != nil {
+		return nil, twirp.InternalErrorWith(err)
+	}
+
+	return &encoder.DeleteStreamResponse{}, nil
+}
+
+func TestMigrateStreams(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+
+	src := postgrestest.NewDB()
+
+	sending, err := src.CreateStream(&postgres.Stream{
+		CommunityID: "community-1",
+		PublicKey:   "public-key",
+		Device:      &postgres.Device{DeviceToken: "sending"},
+	})
+	assert.Nil(t, err)
+
+	silent, err := src.CreateStream(&postgres.Stream{
+		CommunityID: "community-1",
+		PublicKey:   "public-key",
+		Device:      &postgres.Device{DeviceToken: "silent"},
+	})
+	assert.Nil(t, err)
+
+	dst := postgrestest.NewDB()
+
+	store := stats.NewStore(nil, time.Minute, clock.New(), logger)
+
+	target := admin.NewAdmin(&admin.Config{
+		Token:     "target-token",
+		ExportKey: exportKey,
+		Stats:     store,
+		Importer: &receivingImporter{
+			importer: importer{db: dst},
+			stats:    store,
+			sending:  map[string]bool{"sending": true},
+		},
+	}, logger)
+
+	mux := goji.NewMux()
+	mux.Handle(pat.New(admin.PathPrefix+"*"), target.Handler())
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	a := admin.NewAdmin(&admin.Config{
+		Exporter:   src,
+		Deleter:    &deleter{db: src},
+		HTTPClient: &http.Client{},
+		ExportKey:  exportKey,
+	}, logger)
+
+	resp, err := a.MigrateStreams(context.Background(), &admin.MigrateStreamsRequest{
+		TargetAddr:    ts.URL,
+		TargetToken:   "target-token",
+		StreamUids:    []string{sending.StreamID, silent.StreamID, "unknown"},
+		VerifyTimeout: "50ms",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{sending.StreamID}, resp.Migrated)
+	assert.Equal(t, []*admin.ImportFailure{
+		{StreamUid: "unknown", Error: "stream not found"},
+		{StreamUid: silent.StreamID, Error: "target received no messages from device within verify timeout"},
+	}, resp.Failed)
+
+	// the verified stream is only processed by the target
+	_, err = src.GetStream(sending.StreamID, sending.Token.Reveal())
+	assert.Equal(t, postgres.ErrStreamNotFound, err)
+
+	_, err = dst.GetStream(sending.StreamID, sending.Token.Reveal())
+	assert.Nil(t, err)
+
+	// the unverified stream is processed by both
+	_, err = src.GetStream(silent.StreamID, silent.Token.Reveal())
+	assert.Nil(t, err)
+
+	_, err = dst.GetStream(silent.StreamID, silent.Token.Reveal())
+	assert.Nil(t, err)
+}
+
+func TestMigrateStreamsInvalid(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+
+	a := admin.NewAdmin(&admin.Config{
+		Exporter:   postgrestest.NewDB(),
+		Deleter:    &deleter{db: postgrestest.NewDB()},
+		HTTPClient: &http.Client{},
+		ExportKey:  exportKey,
+	}, logger)
+
+	testcases := []struct {
+		label       string
+		admin       *admin.Admin
+		request     *admin.MigrateStreamsRequest
+		expectedErr string
+	}{
+		{
+			label:       "not configured",
+			admin:       admin.NewAdmin(&admin.Config{}, logger),
+			request:     &admin.MigrateStreamsRequest{},
+			expectedErr: "twirp error unimplemented: stream migration is not available",
+		},
+		{
+			label: "no export key",
+			admin: admin.NewAdmin(&admin.Config{
+				Exporter:   postgrestest.NewDB(),
+				Deleter:    &deleter{db: postgrestest.NewDB()},
+				HTTPClient: &http.Client{},
+			}, logger),
+			request:     &admin.MigrateStreamsRequest{},
+			expectedErr: "twirp error failed_precondition: no export key is configured",
+		},
+		{
+			label:       "missing target_addr",
+			admin:       a,
+			request:     &admin.MigrateStreamsRequest{StreamUids: []string{"abc"}},
+			expectedErr: "twirp error invalid_argument: target_addr is required",
+		},
+		{
+			label:       "missing target_token",
+			admin:       a,
+			request:     &admin.MigrateStreamsRequest{TargetAddr: "http://localhost:8081", StreamUids: []string{"abc"}},
+			expectedErr: "twirp error invalid_argument: target_token is required",
+		},
+		{
+			label:       "missing stream_uids",
+			admin:       a,
+			request:     &admin.MigrateStreamsRequest{TargetAddr: "http://localhost:8081", TargetToken: "target-token"},
+			expectedErr: "twirp error invalid_argument: stream_uids is required",
+		},
+		{
+			label: "invalid verify_timeout",
+			admin: a,
+			request: &admin.MigrateStreamsRequest{
+				TargetAddr:    "http://localhost:8081",
+				TargetToken:   "target-token",
+				StreamUids:    []string{"abc"},
+				VerifyTimeout: "-1s",
+			},
+			expectedErr: "twirp error invalid_argument: verify_timeout must be a positive duration",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			_, err := tc.admin.MigrateStreams(context.Background(), tc.request)
+			assert.NotNil(t, err)
+			assert.Equal(t, tc.expectedErr, err.Error())
+		})
+	}
+}
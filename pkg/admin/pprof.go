@@ -0,0 +1,30 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/pprof"
+
+	goji "goji.io"
+	"goji.io/pat"
+)
+
+// ProfilingPath is the path relative to PathPrefix under which the
+// net/http/pprof handlers are exposed when profiling is enabled, so that CPU
+// and heap profiles can be captured from a running encoder via its admin
+// listener, e.g. at http://localhost:8082/admin/debug/pprof/heap.
+const ProfilingPath = "/debug/pprof/"
+
+// handleProfiling registers the net/http/pprof handlers on the given mux. Named
+// profiles such as heap are looked up from the path ourselves, as pprof.Index
+// only finds them beneath /debug/pprof/ at the root of the server.
+func handleProfiling(mux *goji.Mux) {
+	mux.HandleFunc(pat.Get(ProfilingPath+"cmdline"), pprof.Cmdline)
+	mux.HandleFunc(pat.Get(ProfilingPath+"profile"), pprof.Profile)
+	mux.HandleFunc(pat.Get(ProfilingPath+"symbol"), pprof.Symbol)
+	mux.HandleFunc(pat.Post(ProfilingPath+"symbol"), pprof.Symbol)
+	mux.HandleFunc(pat.Get(ProfilingPath+"trace"), pprof.Trace)
+	mux.HandleFunc(pat.Get(ProfilingPath+":name"), func(w http.ResponseWriter, r *http.Request) {
+		pprof.Handler(pat.Param(r, "name")).ServeHTTP(w, r)
+	})
+	mux.HandleFunc(pat.Get(ProfilingPath), pprof.Index)
+}
@@ -0,0 +1,189 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/twitchtv/twirp"
+
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+)
+
+// Schema is our API representation of a version of a payload schema. Ref is
+// the reference by which streams refer to this version.
+type Schema struct {
+	Kind      string              `json:"kind"`
+	Version   int                 `json:"version"`
+	Ref       string              `json:"ref"`
+	Channels  []*postgres.Channel `json:"channels"`
+	CreatedAt time.Time           `json:"created_at"`
+}
+
+// RegisterSchemaRequest is the request type for the RegisterSchema method.
+type RegisterSchemaRequest struct {
+	Kind     string              `json:"kind"`
+	Channels []*postgres.Channel `json:"channels"`
+}
+
+// ListSchemasRequest is the request type for the ListSchemas method.
+type ListSchemasRequest struct{}
+
+// ListSchemasResponse is the response type for the ListSchemas method.
+type ListSchemasResponse struct {
+	Schemas []*Schema `json:"schemas"`
+}
+
+// GetSchemaRequest is the request type for the GetSchema method. Ref is either
+// kind@version, or just kind for the latest version of the kind.
+type GetSchemaRequest struct {
+	Ref string `json:"ref"`
+}
+
+// RegisterSchema registers a new version of the payload schema of a kind of
+// device, one greater than its latest existing version. Registered versions
+// are never changed, so streams referencing an earlier version are unaffected.
+func (a *Admin) RegisterSchema(ctx context.Context, req *RegisterSchemaRequest) (_ *Schema, err error) {
+	defer func() {
+		a.audit(ctx, "RegisterSchema", "", req, err)
+	}()
+
+	if a.schemas == nil {
+		return nil, twirp.NewError(twirp.Unimplemented, "schemas are not available")
+	}
+
+	if req.Kind == "" {
+		return nil, twirp.RequiredArgumentError("kind")
+	}
+
+	err = postgres.ValidateKind(req.Kind)
+	if err != nil {
+		return nil, twirp.InvalidArgumentError("kind", err.Error())
+	}
+
+	err = postgres.Channels(req.Channels).Validate()
+	if err != nil {
+		return nil, twirp.InvalidArgumentError("channels", err.Error())
+	}
+
+	schema, err := a.schemas.Register(req.Kind, postgres.Channels(req.Channels))
+	if err != nil {
+		return nil, twirp.InternalErrorWith(err)
+	}
+
+	return newSchema(schema), nil
+}
+
+// ListSchemas returns every version of every registered payload schema.
+func (a *Admin) ListSchemas(ctx context.Context, req *ListSchemasRequest) (*ListSchemasResponse, error) {
+	if a.schemas == nil {
+		return nil, twirp.NewError(twirp.Unimplemented, "schemas are not available")
+	}
+
+	schemas, err := a.schemas.List()
+	if err != nil {
+		return nil, twirp.InternalErrorWith(err)
+	}
+
+	resp := &ListSchemasResponse{
+		Schemas: make([]*Schema, 0, len(schemas)),
+	}
+
+	for _, s := range schemas {
+		resp.Schemas = append(resp.Schemas, newSchema(s))
+	}
+
+	return resp, nil
+}
+
+// GetSchema returns the referenced version of a payload schema.
+func (a *Admin) GetSchema(ctx context.Context, req *GetSchemaRequest) (*Schema, error) {
+	if a.schemas == nil {
+		return nil, twirp.NewError(twirp.Unimplemented, "schemas are not available")
+	}
+
+	if req.Ref == "" {
+		return nil, twirp.RequiredArgumentError("ref")
+	}
+
+	_, _, err := postgres.ParseSchemaRef(req.Ref)
+	if err != nil {
+		return nil, twirp.InvalidArgumentError("ref", err.Error())
+	}
+
+	schema, err := a.schemas.Resolve(req.Ref)
+	if err != nil {
+		if errors.Cause(err) == postgres.ErrSchemaNotFound {
+			return nil, twirp.NotFoundError("schema not found")
+		}
+		return nil, twirp.InternalErrorWith(err)
+	}
+
+	return newSchema(schema), nil
+}
+
+func (a *Admin) handleRegisterSchema(w http.ResponseWriter, r *http.Request) {
+	var req RegisterSchemaRequest
+
+	err := decodeRequest(r, &req)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	resp, err := a.RegisterSchema(r.Context(), &req)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	a.writeResponse(w, resp)
+}
+
+func (a *Admin) handleListSchemas(w http.ResponseWriter, r *http.Request) {
+	var req ListSchemasRequest
+
+	err := decodeRequest(r, &req)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	resp, err := a.ListSchemas(r.Context(), &req)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	a.writeResponse(w, resp)
+}
+
+func (a *Admin) handleGetSchema(w http.ResponseWriter, r *http.Request) {
+	var req GetSchemaRequest
+
+	err := decodeRequest(r, &req)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	resp, err := a.GetSchema(r.Context(), &req)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	a.writeResponse(w, resp)
+}
+
+// newSchema converts a postgres.Schema into our API representation.
+func newSchema(s *postgres.Schema) *Schema {
+	return &Schema{
+		Kind:      s.Kind,
+		Version:   s.Version,
+		Ref:       s.Ref(),
+		Channels:  s.Channels,
+		CreatedAt: s.CreatedAt,
+	}
+}
@@ -0,0 +1,78 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/twitchtv/twirp"
+
+	"github.com/DECODEproject/iotencoder/pkg/settings"
+)
+
+// SettingsReloader is the interface we require of a type able to reload the
+// settings which may be changed while the encoder is running. It is satisfied
+// by the settings.Reloader type.
+type SettingsReloader interface {
+	Reload() (*settings.Settings, error)
+}
+
+// ReloadSettingsRequest is the request type for the ReloadSettings method.
+type ReloadSettingsRequest struct{}
+
+// ReloadSettingsResponse is the response type for the ReloadSettings method,
+// containing the settings applied. Community quotas are given in the form
+// accepted by --community-quota.
+type ReloadSettingsResponse struct {
+	LogLevel        string            `json:"log_level"`
+	CommunityQuotas map[string]string `json:"community_quotas"`
+	IngestBatchSize int               `json:"ingest_batch_size"`
+	OutboxBatchSize int               `json:"outbox_batch_size"`
+}
+
+// ReloadSettings reloads the settings which may be changed while the encoder
+// is running, as on SIGHUP. If the new settings are invalid a failed
+// precondition error is returned and the current settings are kept.
+func (a *Admin) ReloadSettings(ctx context.Context, req *ReloadSettingsRequest) (_ *ReloadSettingsResponse, err error) {
+	defer func() {
+		a.audit(ctx, "ReloadSettings", "", nil, err)
+	}()
+
+	if a.settings == nil {
+		return nil, twirp.NewError(twirp.Unimplemented, "settings reload is not available")
+	}
+
+	s, err := a.settings.Reload()
+	if err != nil {
+		return nil, twirp.NewError(twirp.FailedPrecondition, err.Error())
+	}
+
+	quotas := make(map[string]string, len(s.CommunityQuotas))
+	for community, limits := range s.CommunityQuotas {
+		quotas[community] = limits.String()
+	}
+
+	return &ReloadSettingsResponse{
+		LogLevel:        s.LogLevel.String(),
+		CommunityQuotas: quotas,
+		IngestBatchSize: s.IngestBatchSize,
+		OutboxBatchSize: s.OutboxBatchSize,
+	}, nil
+}
+
+func (a *Admin) handleReloadSettings(w http.ResponseWriter, r *http.Request) {
+	var req ReloadSettingsRequest
+
+	err := decodeRequest(r, &req)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	resp, err := a.ReloadSettings(r.Context(), &req)
+	if err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	a.writeResponse(w, resp)
+}
@@ -0,0 +1,286 @@
+package amqp
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sync"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/streadway/amqp"
+
+	"github.com/DECODEproject/iotencoder/pkg/logger"
+	"github.com/DECODEproject/iotencoder/pkg/logger/level"
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+	"github.com/DECODEproject/iotencoder/pkg/version"
+)
+
+const (
+	// DefaultExchange is the exchange to which device readings are published if
+	// no exchange is configured. It is the exchange used by RabbitMQ's MQTT
+	// plugin, so devices publishing to RabbitMQ over MQTT are received without
+	// further configuration.
+	DefaultExchange = "amq.topic"
+
+	// DefaultPrefetch is the number of unacknowledged deliveries we allow if no
+	// prefetch count is configured.
+	DefaultPrefetch = 10
+)
+
+var (
+	// MessageCounter is a prometheus counter recording the number of messages
+	// received from the AMQP broker.
+	MessageCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "amqp_messages_received",
+			Help:      "Count of AMQP messages received",
+		},
+	)
+
+	// routingKeyPattern matches the routing key of a device's readings.
+	routingKeyPattern = regexp.MustCompile(`^device\.sck\.(\w+)\.readings$`)
+)
+
+// Config is used to pass in configuration when creating a Consumer. URL is the
+// amqp:// or amqps:// URL of the broker. If Queue is empty an exclusive queue
+// is declared which is deleted when we disconnect, else the named queue is
+// declared as durable so that readings published while the encoder is down are
+// delivered once it restarts.
+type Config struct {
+	URL      string
+	Exchange string
+	Queue    string
+	Prefetch int
+}
+
+// Consumer receives device readings from an AMQP broker such as RabbitMQ, as an
+// alternative to subscribing to each device on an MQTT broker. Readings are
+// expected on a topic exchange with the routing key
+// device.sck.<device_token>.readings, which is how RabbitMQ's MQTT plugin
+// routes messages published to the equivalent MQTT topic. Subscribing to a
+// device binds our queue to its routing key, and deliveries are acknowledged
+// once the subscribed callback returns.
+type Consumer struct {
+	url      string
+	exchange string
+	queue    string
+	prefetch int
+	logger   kitlog.Logger
+
+	conn     *amqp.Connection
+	channel  *amqp.Channel
+	wg       sync.WaitGroup
+	stopping bool
+
+	sync.RWMutex
+	callbacks map[string]func(payload []byte, metadata map[string]string, done func())
+}
+
+// NewConsumer returns a new Consumer configured with the given Config.
+func NewConsumer(config *Config, logger kitlog.Logger) *Consumer {
+	logger = kitlog.With(logger, "module", "amqp")
+
+	exchange := config.Exchange
+	if exchange == "" {
+		exchange = DefaultExchange
+	}
+
+	prefetch := config.Prefetch
+	if prefetch <= 0 {
+		prefetch = DefaultPrefetch
+	}
+
+	return &Consumer{
+		url:       config.URL,
+		exchange:  exchange,
+		queue:     config.Queue,
+		prefetch:  prefetch,
+		logger:    logger,
+		callbacks: make(map[string]func(payload []byte, metadata map[string]string, done func())),
+	}
+}
+
+// RoutingKey returns the routing key with which readings for the given device
+// token are published.
+func RoutingKey(deviceToken secret.Secret) string {
+	return fmt.Sprintf("device.sck.%s.readings", deviceToken.Reveal())
+}
+
+// Start connects to the broker, declares our queue and starts consuming from
+// it. It must be called before any devices are subscribed.
+func (c *Consumer) Start() error {
+	c.logger.Log("msg", "starting amqp consumer", "exchange", c.exchange, "queue", c.queue)
+
+	conn, err := amqp.DialConfig(c.url, amqp.Config{
+		Properties: amqp.Table{"connection_name": fmt.Sprintf("%s-DECODE", version.BinaryName)},
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to connect to amqp broker")
+	}
+
+	channel, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return errors.Wrap(err, "failed to open amqp channel")
+	}
+
+	err = channel.Qos(c.prefetch, 0, false)
+	if err != nil {
+		conn.Close()
+		return errors.Wrap(err, "failed to set amqp prefetch count")
+	}
+
+	durable := c.queue != ""
+
+	queue, err := channel.QueueDeclare(c.queue, durable, !durable, !durable, false, nil)
+	if err != nil {
+		conn.Close()
+		return errors.Wrap(err, "failed to declare amqp queue")
+	}
+
+	deliveries, err := channel.Consume(queue.Name, "", false, false, false, false, nil)
+	if err != nil {
+		conn.Close()
+		return errors.Wrap(err, "failed to consume from amqp queue")
+	}
+
+	c.Lock()
+	c.conn = conn
+	c.channel = channel
+	c.queue = queue.Name
+	c.Unlock()
+
+	// as with our MQTT client we exit if the connection is lost, relying on
+	// being restarted to reconnect and recreate all subscriptions
+	closed := conn.NotifyClose(make(chan *amqp.Error, 1))
+
+	go func() {
+		err, ok := <-closed
+		if !ok || c.isStopping() {
+			return
+		}
+
+		level.Error(c.logger).Log("msg", "connection lost", "err", err)
+		os.Exit(1)
+	}()
+
+	c.wg.Add(1)
+	go c.consume(deliveries)
+
+	return nil
+}
+
+// Stop closes the connection to the broker, waiting for the delivery being
+// processed to be acknowledged.
+func (c *Consumer) Stop() error {
+	c.logger.Log("msg", "stopping amqp consumer")
+
+	c.Lock()
+	c.stopping = true
+	conn := c.conn
+	c.Unlock()
+
+	if conn == nil {
+		return nil
+	}
+
+	err := conn.Close()
+	c.wg.Wait()
+
+	return err
+}
+
+// Subscribe binds our queue to the routing key of the given device, with
+// readings subsequently received for the device passed to the callback.
+func (c *Consumer) Subscribe(deviceToken secret.Secret, callback func(payload []byte, metadata map[string]string, done func())) error {
+	level.Debug(c.logger).Log("device_hash", logger.HashToken(deviceToken), "msg", "subscribing")
+
+	c.Lock()
+	defer c.Unlock()
+
+	if c.channel == nil {
+		return errors.New("amqp consumer not started")
+	}
+
+	err := c.channel.QueueBind(c.queue, RoutingKey(deviceToken), c.exchange, false, nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to bind amqp queue")
+	}
+
+	c.callbacks[deviceToken.Reveal()] = callback
+
+	return nil
+}
+
+// Unsubscribe removes the binding of our queue to the routing key of the given
+// device.
+func (c *Consumer) Unsubscribe(deviceToken secret.Secret) error {
+	level.Debug(c.logger).Log("device_hash", logger.HashToken(deviceToken), "msg", "unsubscribing")
+
+	c.Lock()
+	defer c.Unlock()
+
+	delete(c.callbacks, deviceToken.Reveal())
+
+	if c.channel == nil {
+		return errors.New("amqp consumer not started")
+	}
+
+	err := c.channel.QueueUnbind(c.queue, RoutingKey(deviceToken), c.exchange, nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to unbind amqp queue")
+	}
+
+	return nil
+}
+
+// consume reads deliveries until the channel is closed, passing each to the
+// callback of the device it belongs to before acknowledging it.
+func (c *Consumer) consume(deliveries <-chan amqp.Delivery) {
+	defer c.wg.Done()
+
+	for d := range deliveries {
+		MessageCounter.Inc()
+
+		c.deliver(d.RoutingKey, d.Body)
+
+		err := d.Ack(false)
+		if err != nil {
+			level.Error(c.logger).Log("err", err, "msg", "failed to acknowledge delivery")
+		}
+	}
+}
+
+// deliver passes the payload to the callback subscribed for the device whose
+// routing key it was published with. Deliveries for devices we are not
+// subscribed to, which may still be queued after unsubscribing, are dropped.
+func (c *Consumer) deliver(routingKey string, payload []byte) {
+	matches := routingKeyPattern.FindStringSubmatch(routingKey)
+	if matches == nil {
+		level.Warn(c.logger).Log("msg", "dropping delivery with unexpected routing key")
+		return
+	}
+
+	c.RLock()
+	callback, ok := c.callbacks[matches[1]]
+	c.RUnlock()
+
+	if !ok {
+		level.Debug(c.logger).Log("device_hash", logger.HashToken(secret.Secret(matches[1])), "msg", "dropping delivery for unsubscribed device")
+		return
+	}
+
+	callback(payload, nil, func() {})
+}
+
+// isStopping returns true once Stop has been called.
+func (c *Consumer) isStopping() bool {
+	c.RLock()
+	defer c.RUnlock()
+
+	return c.stopping
+}
@@ -0,0 +1,30 @@
+package amqp_test
+
+import (
+	"testing"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/amqp"
+)
+
+func TestRoutingKey(t *testing.T) {
+	assert.Equal(t, "device.sck.abc123.readings", amqp.RoutingKey("abc123"))
+}
+
+func TestConsumerNotStarted(t *testing.T) {
+	c := amqp.NewConsumer(&amqp.Config{URL: "amqp://localhost:5672"}, kitlog.NewNopLogger())
+
+	err := c.Subscribe("abc123", func(payload []byte, metadata map[string]string, done func()) {})
+	assert.NotNil(t, err)
+
+	assert.Nil(t, c.Stop())
+}
+
+func TestConsumerInvalidURL(t *testing.T) {
+	c := amqp.NewConsumer(&amqp.Config{URL: "http://localhost"}, kitlog.NewNopLogger())
+
+	err := c.Start()
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,445 @@
+// Package analytics exports the metadata of our streams and a daily snapshot
+// of their cumulative statistics as Parquet files, so that the coverage and
+// throughput of devices may be analysed by a data platform without connecting
+// to our database. Files are written to a local directory and/or object storage,
+// partitioned Hive style by the day of the export, e.g.
+// stream_daily_stats/day=2026-10-15/stream_daily_stats.parquet, so an export
+// repeated within a day replaces the earlier one. No secrets are exported,
+// devices being identified by the hash of their token.
+package analytics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/logger"
+	"github.com/DECODEproject/iotencoder/pkg/logger/level"
+	"github.com/DECODEproject/iotencoder/pkg/parquet"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+)
+
+const (
+	// StreamsTable is the name of the table holding the metadata of each
+	// stream.
+	StreamsTable = "streams"
+
+	// StreamStatsTable is the name of the table holding the statistics of each
+	// stream on the day of the export.
+	StreamStatsTable = "stream_daily_stats"
+
+	// Succeeded is the result of an export which was written to every
+	// destination.
+	Succeeded = "succeeded"
+
+	// Failed is the result of an export which could not be written.
+	Failed = "failed"
+
+	// contentType is the content type with which files are uploaded.
+	contentType = "application/vnd.apache.parquet"
+
+	// dayFormat is the format of the day by which exports are partitioned.
+	dayFormat = "2006-01-02"
+)
+
+var (
+	// ExportsCounter is a prometheus counter vec recording the number of
+	// analytics exports, labelled by the result.
+	ExportsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "analytics_exports",
+			Help:      "Count of exports of stream metadata and statistics for analytics, by result",
+		},
+		[]string{"result"},
+	)
+)
+
+// streamColumns are the columns of the streams table.
+var streamColumns = []parquet.Column{
+	{Name: "exported_at", Type: parquet.Timestamp},
+	{Name: "stream_uid", Type: parquet.String},
+	{Name: "community_id", Type: parquet.String},
+	{Name: "device_hash", Type: parquet.String},
+	{Name: "device_label", Type: parquet.String},
+	{Name: "longitude", Type: parquet.Double},
+	{Name: "latitude", Type: parquet.Double},
+	{Name: "exposure", Type: parquet.String},
+	{Name: "source", Type: parquet.String},
+	{Name: "operations", Type: parquet.Int64},
+	{Name: "labels", Type: parquet.String},
+	{Name: "policy_id", Type: parquet.String},
+	{Name: "compression", Type: parquet.String},
+	{Name: "timestamp_policy", Type: parquet.String},
+	{Name: "rate_limit", Type: parquet.String},
+	{Name: "sampling", Type: parquet.String},
+	{Name: "schedule", Type: parquet.String},
+	{Name: "privacy", Type: parquet.String},
+	{Name: "geo_privacy", Type: parquet.String},
+	{Name: "geofence", Type: parquet.String},
+	{Name: "archive", Type: parquet.Boolean},
+	{Name: "public_key_fingerprint", Type: parquet.String},
+}
+
+// streamStatsColumns are the columns of the stream statistics table.
+var streamStatsColumns = []parquet.Column{
+	{Name: "day", Type: parquet.Date},
+	{Name: "stream_uid", Type: parquet.String},
+	{Name: "community_id", Type: parquet.String},
+	{Name: "device_hash", Type: parquet.String},
+	{Name: "messages_received", Type: parquet.Int64},
+	{Name: "bytes_encrypted", Type: parquet.Int64},
+	{Name: "writes_succeeded", Type: parquet.Int64},
+	{Name: "writes_failed", Type: parquet.Int64},
+	{Name: "messages_throttled", Type: parquet.Int64},
+	{Name: "messages_dropped", Type: parquet.Int64},
+	{Name: "average_latency_ms", Type: parquet.Double},
+	{Name: "last_message_at", Type: parquet.Timestamp, Optional: true},
+}
+
+// Streams is the interface we require of a type able to list our streams. It
+// is satisfied by our postgres.DB and boltdb.DB types.
+type Streams interface {
+	ListStreams() ([]*postgres.Stream, error)
+}
+
+// Stats is the interface we require of a type holding the statistics of our
+// streams. It is satisfied by the stats.Store type.
+type Stats interface {
+	Get(streamID string) *postgres.StreamStats
+}
+
+// Uploader is the interface we require of a type able to write files to
+// object storage. It is satisfied by the archive.Archiver type.
+type Uploader interface {
+	Put(ctx context.Context, key, contentType string, data []byte) error
+}
+
+// Config is used to pass in configuration when creating an Exporter. Files are
+// written beneath Dir if it is set, and uploaded with Uploader if it is set.
+// Interval is the interval at which exports are written, and if zero exports
+// are only written when requested via Export.
+type Config struct {
+	Streams  Streams
+	Stats    Stats
+	Dir      string
+	Uploader Uploader
+	Interval time.Duration
+	Clock    clock.Clock
+}
+
+// Export describes an export, listing the files written, as paths for those
+// written to Dir and keys for those uploaded.
+type Export struct {
+	Day     string   `json:"day"`
+	Streams int      `json:"streams"`
+	Files   []string `json:"files"`
+}
+
+// Exporter writes exports of our streams and their statistics, periodically
+// and on request.
+type Exporter struct {
+	streams  Streams
+	stats    Stats
+	dir      string
+	uploader Uploader
+	interval time.Duration
+	clock    clock.Clock
+	logger   kitlog.Logger
+	quit     chan struct{}
+	wg       sync.WaitGroup
+
+	// exports are serialised, so that a requested export doesn't race with a
+	// scheduled one
+	sync.Mutex
+}
+
+// NewExporter returns a new Exporter configured with the given Config.
+func NewExporter(config *Config, logger kitlog.Logger) *Exporter {
+	logger = kitlog.With(logger, "module", "analytics")
+
+	return &Exporter{
+		streams:  config.Streams,
+		stats:    config.Stats,
+		dir:      config.Dir,
+		uploader: config.Uploader,
+		interval: config.Interval,
+		clock:    config.Clock,
+		logger:   logger,
+	}
+}
+
+// Start starts a goroutine which writes an export on each tick of our
+// interval, if we have one.
+func (e *Exporter) Start() error {
+	e.logger.Log("msg", "starting analytics exporter", "dir", e.dir, "upload", e.uploader != nil, "interval", e.interval)
+
+	if e.dir == "" && e.uploader == nil {
+		return errors.New("analytics exports require a directory or object storage to write to")
+	}
+
+	if e.interval < 0 {
+		return errors.New("analytics export interval must not be negative")
+	}
+
+	if e.interval == 0 {
+		return nil
+	}
+
+	e.quit = make(chan struct{})
+	e.wg.Add(1)
+
+	go e.loop()
+
+	return nil
+}
+
+// Stop stops the export goroutine.
+func (e *Exporter) Stop() error {
+	if e.quit == nil {
+		return nil
+	}
+
+	e.logger.Log("msg", "stopping analytics exporter")
+
+	close(e.quit)
+	e.wg.Wait()
+
+	return nil
+}
+
+// Export writes the metadata and statistics of every live stream as of now to
+// each of our destinations, counting the result.
+func (e *Exporter) Export(ctx context.Context) (*Export, error) {
+	e.Lock()
+	defer e.Unlock()
+
+	export, err := e.export(ctx)
+	if err != nil {
+		ExportsCounter.WithLabelValues(Failed).Inc()
+		return nil, err
+	}
+
+	ExportsCounter.WithLabelValues(Succeeded).Inc()
+
+	e.logger.Log("msg", "exported analytics", "day", export.Day, "streams", export.Streams)
+
+	return export, nil
+}
+
+// export builds the files of an export and writes them to our destinations.
+func (e *Exporter) export(ctx context.Context) (*Export, error) {
+	now := e.clock.Now().UTC()
+
+	streams, err := e.streams.ListStreams()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list streams")
+	}
+
+	streamRows := make([][]interface{}, 0, len(streams))
+	statsRows := make([][]interface{}, 0, len(streams))
+
+	for _, stream := range streams {
+		row, err := streamRow(stream, now)
+		if err != nil {
+			return nil, err
+		}
+
+		streamRows = append(streamRows, row)
+		statsRows = append(statsRows, streamStatsRow(stream, e.stats.Get(stream.StreamID), now))
+	}
+
+	tables := []struct {
+		name    string
+		columns []parquet.Column
+		rows    [][]interface{}
+	}{
+		{name: StreamsTable, columns: streamColumns, rows: streamRows},
+		{name: StreamStatsTable, columns: streamStatsColumns, rows: statsRows},
+	}
+
+	export := &Export{
+		Day:     now.Format(dayFormat),
+		Streams: len(streams),
+		Files:   []string{},
+	}
+
+	for _, table := range tables {
+		var buf bytes.Buffer
+
+		err = parquet.Write(&buf, table.columns, table.rows)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to encode %s", table.name)
+		}
+
+		key := path.Join(table.name, "day="+export.Day, table.name+".parquet")
+
+		if e.dir != "" {
+			file := filepath.Join(e.dir, filepath.FromSlash(key))
+
+			err = writeFile(file, buf.Bytes())
+			if err != nil {
+				return nil, err
+			}
+
+			export.Files = append(export.Files, file)
+		}
+
+		if e.uploader != nil {
+			err = e.uploader.Put(ctx, key, contentType, buf.Bytes())
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to upload %s", table.name)
+			}
+
+			export.Files = append(export.Files, key)
+		}
+	}
+
+	return export, nil
+}
+
+// streamRow returns the row of the streams table for the given stream.
+func streamRow(stream *postgres.Stream, now time.Time) ([]interface{}, error) {
+	labels := ""
+	if len(stream.Labels) > 0 {
+		b, err := json.Marshal(stream.Labels)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to encode stream labels")
+		}
+
+		labels = string(b)
+	}
+
+	device := stream.Device
+	if device == nil {
+		device = &postgres.Device{}
+	}
+
+	return []interface{}{
+		now,
+		stream.StreamID,
+		stream.CommunityID,
+		logger.HashToken(device.DeviceToken),
+		device.Label,
+		device.Longitude,
+		device.Latitude,
+		device.Exposure,
+		stream.Source,
+		len(stream.Operations),
+		labels,
+		stream.PolicyID,
+		stream.Compression,
+		stream.TimestampPolicy,
+		stream.RateLimit,
+		stream.Sampling,
+		stream.Schedule,
+		stream.Privacy,
+		stream.GeoPrivacy,
+		stream.Geofence,
+		stream.Archive,
+		stream.PublicKeyFingerprint,
+	}, nil
+}
+
+// streamStatsRow returns the row of the stream statistics table for the given
+// stream and its stats, which are nil if nothing has been recorded for it.
+func streamStatsRow(stream *postgres.Stream, stats *postgres.StreamStats, now time.Time) []interface{} {
+	if stats == nil {
+		stats = &postgres.StreamStats{}
+	}
+
+	var lastMessageAt interface{}
+	if stats.LastMessageAt.Valid {
+		lastMessageAt = stats.LastMessageAt.Time
+	}
+
+	deviceHash := ""
+	if stream.Device != nil {
+		deviceHash = logger.HashToken(stream.Device.DeviceToken)
+	}
+
+	return []interface{}{
+		now,
+		stream.StreamID,
+		stream.CommunityID,
+		deviceHash,
+		stats.MessagesReceived,
+		stats.BytesEncrypted,
+		stats.WritesSucceeded,
+		stats.WritesFailed,
+		stats.Throttled,
+		stats.Dropped,
+		float64(stats.AverageLatency()) / float64(time.Millisecond),
+		lastMessageAt,
+	}
+}
+
+// writeFile writes data to the given file via a temporary file, so that a
+// reader never sees a partially written file.
+func writeFile(file string, data []byte) error {
+	err := os.MkdirAll(filepath.Dir(file), 0755)
+	if err != nil {
+		return errors.Wrap(err, "failed to create analytics directory")
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(file), ".export-")
+	if err != nil {
+		return errors.Wrap(err, "failed to create analytics file")
+	}
+
+	// temporary files are created readable only by us
+	err = tmp.Chmod(0644)
+	if err == nil {
+		_, err = tmp.Write(data)
+	}
+
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		os.Remove(tmp.Name())
+		return errors.Wrap(err, "failed to write analytics file")
+	}
+
+	err = os.Rename(tmp.Name(), file)
+	if err != nil {
+		os.Remove(tmp.Name())
+		return errors.Wrap(err, "failed to write analytics file")
+	}
+
+	return nil
+}
+
+// loop is run in a goroutine and writes an export on each tick of our
+// interval until the exporter is stopped.
+func (e *Exporter) loop() {
+	defer e.wg.Done()
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			_, err := e.Export(context.Background())
+			if err != nil {
+				level.Error(e.logger).Log("msg", "failed to export analytics", "err", err)
+			}
+		case <-e.quit:
+			return
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package analytics_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/analytics"
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/postgres/postgrestest"
+	"github.com/DECODEproject/iotencoder/pkg/stats"
+)
+
+// fakeUploader records the keys of the files uploaded to it, failing with err
+// if set.
+type fakeUploader struct {
+	files map[string][]byte
+	err   error
+}
+
+func (f *fakeUploader) Put(ctx context.Context, key, contentType string, data []byte) error {
+	if f.err != nil {
+		return f.err
+	}
+
+	f.files[key] = data
+	return nil
+}
+
+func TestExport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "analytics")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	cl := clock.NewMock(time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC))
+	logger := kitlog.NewNopLogger()
+
+	db := postgrestest.NewDB()
+
+	stream, err := db.CreateStream(&postgres.Stream{
+		CommunityID: "smartcitizen",
+		PublicKey:   "public",
+		Device:      &postgres.Device{DeviceToken: "abc123", Label: "kitchen"},
+	})
+	assert.Nil(t, err)
+
+	st := stats.NewStore(nil, time.Minute, cl, logger)
+	st.RecordMessage(stream.StreamID)
+
+	uploader := &fakeUploader{files: map[string][]byte{}}
+
+	exporter := analytics.NewExporter(&analytics.Config{
+		Streams:  db,
+		Stats:    st,
+		Dir:      dir,
+		Uploader: uploader,
+		Clock:    cl,
+	}, logger)
+
+	export, err := exporter.Export(context.Background())
+	assert.Nil(t, err)
+
+	assert.Equal(t, "2026-10-15", export.Day)
+	assert.Equal(t, 1, export.Streams)
+	assert.Len(t, export.Files, 4)
+
+	for _, key := range []string{
+		"streams/day=2026-10-15/streams.parquet",
+		"stream_daily_stats/day=2026-10-15/stream_daily_stats.parquet",
+	} {
+		data, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(key)))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("PAR1"), data[:4])
+
+		assert.Equal(t, data, uploader.files[key])
+	}
+
+	// device tokens are never exported
+	for _, data := range uploader.files {
+		assert.NotContains(t, string(data), "abc123")
+	}
+	assert.Contains(t, string(uploader.files["streams/day=2026-10-15/streams.parquet"]), "kitchen")
+}
+
+func TestExportUploadFailure(t *testing.T) {
+	cl := clock.NewMock(time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC))
+	logger := kitlog.NewNopLogger()
+
+	exporter := analytics.NewExporter(&analytics.Config{
+		Streams:  postgrestest.NewDB(),
+		Stats:    stats.NewStore(nil, time.Minute, cl, logger),
+		Uploader: &fakeUploader{err: errors.New("unavailable")},
+		Clock:    cl,
+	}, logger)
+
+	_, err := exporter.Export(context.Background())
+	assert.NotNil(t, err)
+}
+
+func TestExporterInvalidConfig(t *testing.T) {
+	exporter := analytics.NewExporter(&analytics.Config{
+		Streams:  postgrestest.NewDB(),
+		Interval: time.Hour,
+		Clock:    clock.New(),
+	}, kitlog.NewNopLogger())
+
+	// there is nowhere to write exports to
+	err := exporter.Start()
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,343 @@
+// Package archive writes the encrypted payloads of streams as objects to
+// S3-compatible object storage, for retention beyond the policies of the
+// datastore. Objects are partitioned by the date on which the payload was
+// received and the device which sent it, and carry the identifiers of the
+// stream as object metadata. Only ciphertext is archived, so the archive may
+// be held by a party unable to read the readings it contains.
+package archive
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+)
+
+const (
+	// Archived is the result of a payload written to object storage.
+	Archived = "archived"
+
+	// Failed is the result of a payload which could not be written to object
+	// storage.
+	Failed = "failed"
+
+	// DefaultRegion is the region used to sign requests if none is given.
+	// S3-compatible stores which don't have regions, such as minio, accept it.
+	DefaultRegion = "us-east-1"
+
+	// algorithm is the signing algorithm of AWS signature version 4.
+	algorithm = "AWS4-HMAC-SHA256"
+
+	// service is the name of the service for which requests are signed.
+	service = "s3"
+
+	// amzDateFormat is the format of the timestamp with which requests are
+	// signed.
+	amzDateFormat = "20060102T150405Z"
+)
+
+var (
+	// ObjectsCounter is a prometheus counter vec recording the number of
+	// payloads written to object storage, labelled by the result.
+	ObjectsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "archived_objects",
+			Help:      "Count of encrypted payloads written to archival object storage, by result",
+		},
+		[]string{"result"},
+	)
+)
+
+// Config is used to pass in configuration when creating an Archiver. Endpoint
+// is the base URL of the object store, e.g. https://s3.eu-west-1.amazonaws.com
+// or http://minio:9000, to which objects are written using path-style URLs.
+// Prefix is optional, and if set is prepended to the key of every object.
+// Region is the region with which requests are signed, and if empty is
+// DefaultRegion. HTTPClient and Clock are optional, and if nil
+// http.DefaultClient and the system clock are used.
+type Config struct {
+	Endpoint        string
+	Bucket          string
+	Prefix          string
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey secret.Secret
+	HTTPClient      *http.Client
+	Clock           clock.Clock
+}
+
+// Object is an encrypted payload to be archived, along with the identifiers
+// of its stream and device. DeviceHash is the hash of the device token, as
+// the token itself is a secret. RequestID is optional.
+type Object struct {
+	StreamID    string
+	CommunityID string
+	DeviceHash  string
+	RequestID   string
+	ReceivedAt  time.Time
+	Data        []byte
+}
+
+// Archiver writes objects to a bucket of an S3-compatible object store,
+// signing its requests with AWS signature version 4.
+type Archiver struct {
+	logger   kitlog.Logger
+	endpoint *url.URL
+	bucket   string
+	prefix   string
+	region   string
+	key      string
+	secret   secret.Secret
+	client   *http.Client
+	clock    clock.Clock
+}
+
+// NewArchiver returns a new Archiver configured with the given Config, or an
+// error if the configuration is invalid.
+func NewArchiver(config *Config, logger kitlog.Logger) (*Archiver, error) {
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, errors.Errorf("invalid archive endpoint, must be an http or https URL: %s", config.Endpoint)
+	}
+
+	if config.Bucket == "" {
+		return nil, errors.New("archive bucket must be given")
+	}
+
+	if config.AccessKeyID == "" || config.SecretAccessKey == "" {
+		return nil, errors.New("archive access key id and secret access key must be given")
+	}
+
+	region := config.Region
+	if region == "" {
+		region = DefaultRegion
+	}
+
+	client := config.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	cl := config.Clock
+	if cl == nil {
+		cl = clock.New()
+	}
+
+	logger = kitlog.With(logger, "module", "archive")
+
+	logger.Log("msg", "creating archiver", "endpoint", endpoint.String(), "bucket", config.Bucket, "prefix", config.Prefix)
+
+	return &Archiver{
+		logger:   logger,
+		endpoint: endpoint,
+		bucket:   config.Bucket,
+		prefix:   strings.Trim(config.Prefix, "/"),
+		region:   region,
+		key:      config.AccessKeyID,
+		secret:   config.SecretAccessKey,
+		client:   client,
+		clock:    cl,
+	}, nil
+}
+
+// Key returns the key under which the given object is written, of the form
+// PREFIX/YYYY/MM/DD/DEVICE_HASH/STREAM_UID/TIMESTAMP-SUFFIX.bin, where the date
+// and timestamp are those at which the payload was received in UTC, and the
+// suffix is random so that payloads received at the same instant are kept
+// apart.
+func (a *Archiver) Key(obj *Object) (string, error) {
+	suffix := make([]byte, 4)
+
+	_, err := rand.Read(suffix)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to generate object key")
+	}
+
+	receivedAt := obj.ReceivedAt.UTC()
+
+	parts := []string{
+		receivedAt.Format("2006/01/02"),
+		obj.DeviceHash,
+		obj.StreamID,
+		fmt.Sprintf("%s-%s.bin", receivedAt.Format("20060102T150405.000000000Z"), hex.EncodeToString(suffix)),
+	}
+
+	if a.prefix != "" {
+		parts = append([]string{a.prefix}, parts...)
+	}
+
+	return strings.Join(parts, "/"), nil
+}
+
+// Archive writes the given object to our bucket, counting the result.
+func (a *Archiver) Archive(ctx context.Context, obj *Object) error {
+	err := a.put(ctx, obj)
+	if err != nil {
+		ObjectsCounter.WithLabelValues(Failed).Inc()
+		return err
+	}
+
+	ObjectsCounter.WithLabelValues(Archived).Inc()
+
+	return nil
+}
+
+// put writes the given object to our bucket with a signed PUT request.
+func (a *Archiver) put(ctx context.Context, obj *Object) error {
+	key, err := a.Key(obj)
+	if err != nil {
+		return err
+	}
+
+	metadata := map[string]string{
+		"Stream-Uid":   obj.StreamID,
+		"Community-Id": obj.CommunityID,
+		"Device-Hash":  obj.DeviceHash,
+		"Received-At":  obj.ReceivedAt.UTC().Format(time.RFC3339Nano),
+	}
+
+	if obj.RequestID != "" {
+		metadata["Request-Id"] = obj.RequestID
+	}
+
+	return a.putObject(ctx, key, "application/octet-stream", obj.Data, metadata)
+}
+
+// Put writes the given data to our bucket under the given key, prefixed by our
+// prefix if we have one. It allows other files, such as analytics exports, to
+// be written to object storage configured as for the archive.
+func (a *Archiver) Put(ctx context.Context, key, contentType string, data []byte) error {
+	key = strings.TrimLeft(key, "/")
+	if a.prefix != "" {
+		key = a.prefix + "/" + key
+	}
+
+	return a.putObject(ctx, key, contentType, data, nil)
+}
+
+// putObject writes data to our bucket under the given key with a signed PUT
+// request, setting the given metadata as x-amz-meta- headers.
+func (a *Archiver) putObject(ctx context.Context, key, contentType string, data []byte, metadata map[string]string) error {
+	u := *a.endpoint
+	u.Path = strings.TrimRight(u.Path, "/") + "/" + a.bucket + "/" + key
+
+	req, err := http.NewRequest(http.MethodPut, u.String(), bytes.NewReader(data))
+	if err != nil {
+		return errors.Wrap(err, "failed to build archive request")
+	}
+
+	req = req.WithContext(ctx)
+
+	req.Header.Set("Content-Type", contentType)
+	for name, value := range metadata {
+		req.Header.Set("X-Amz-Meta-"+name, value)
+	}
+
+	a.Sign(req, data)
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to write archive object")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return errors.Errorf("unexpected status writing archive object: %d %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	return nil
+}
+
+// Sign signs the given request, whose body is the given payload, with AWS
+// signature version 4, setting its Authorization header. Every header already
+// set on the request is signed, along with the host.
+func (a *Archiver) Sign(req *http.Request, payload []byte) {
+	now := a.clock.Now().UTC()
+	amzDate := now.Format(amzDateFormat)
+	date := now.Format("20060102")
+
+	payloadHash := hashHex(payload)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{
+		"host": req.URL.Host,
+	}
+	for name := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(req.Header.Get(name))
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{date, a.region, service, "aws4_request"}, "/")
+
+	stringToSign := strings.Join([]string{
+		algorithm,
+		amzDate,
+		scope,
+		hashHex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+a.secret.Reveal()), date)
+	key = hmacSHA256(key, a.region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s", algorithm, a.key, scope, signedHeaders, signature))
+}
+
+// hashHex returns the hex encoded SHA256 hash of the given data.
+func hashHex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of the given data with the given key.
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
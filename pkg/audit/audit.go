@@ -0,0 +1,207 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/DECODEproject/iotcommon/middleware"
+	raven "github.com/getsentry/raven-go"
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/twitchtv/twirp"
+	"gopkg.in/guregu/null.v3"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/logger/level"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+)
+
+const (
+	// Redacted is the value recorded in place of any secret request parameter.
+	Redacted = "[redacted]"
+
+	// ResultOK is the result recorded for calls which succeeded. Failed calls
+	// record the twirp error code returned to the caller.
+	ResultOK = "ok"
+
+	// forwardedForHeader is the header set by proxies in front of the encoder
+	// which identifies the address of the original client.
+	forwardedForHeader = "X-Forwarded-For"
+)
+
+// secretParams holds the names of request parameters whose values must never
+// be written to the audit log.
+var secretParams = map[string]bool{
+	"token":         true,
+	"device_token":  true,
+	"ingest_secret": true,
+}
+
+// Store is the interface we require of a type able to persist audit entries.
+// It is satisfied by our postgres.DB type.
+type Store interface {
+	SaveAuditEntry(entry *postgres.AuditEntry) error
+}
+
+// Config is used to pass in configuration when creating a Recorder.
+type Config struct {
+	// Store is the append only store to which entries are written.
+	Store Store
+
+	// Clock is used to timestamp entries.
+	Clock clock.Clock
+}
+
+// Recorder is a component that writes a record of each call which mutates a
+// stream to the audit log, redacting any secrets from the call's parameters.
+type Recorder struct {
+	store  Store
+	clock  clock.Clock
+	logger kitlog.Logger
+}
+
+// NewRecorder returns a new Recorder configured with the given Config.
+func NewRecorder(config *Config, logger kitlog.Logger) *Recorder {
+	logger = kitlog.With(logger, "module", "audit")
+
+	return &Recorder{
+		store:  config.Store,
+		clock:  config.Clock,
+		logger: logger,
+	}
+}
+
+// Record appends an entry to the audit log describing a call to the named
+// method. The params are any value which may be marshalled as a JSON object,
+// typically the request, and err is the error returned to the caller if any.
+// The call has already taken effect by the time it is recorded, so a failure
+// to write the entry is logged and reported rather than returned.
+func (r *Recorder) Record(ctx context.Context, method, streamID string, params interface{}, err error) {
+	entry := &postgres.AuditEntry{
+		RecordedAt: r.clock.Now(),
+		Caller:     Caller(ctx),
+		Method:     method,
+		Result:     ResultOK,
+	}
+
+	if rid, ok := ctx.Value(middleware.RequestCtxKey).(string); ok {
+		entry.RequestID = null.StringFrom(rid)
+	}
+
+	if streamID != "" {
+		entry.StreamID = null.StringFrom(streamID)
+	}
+
+	if err != nil {
+		entry.Result = string(twirp.Internal)
+		if terr, ok := err.(twirp.Error); ok {
+			entry.Result = string(terr.Code())
+		}
+		entry.Error = null.StringFrom(err.Error())
+	}
+
+	redacted, rerr := Redact(params)
+	if rerr != nil {
+		level.Warn(r.logger).Log("msg", "failed to redact audited params", "method", method, "err", rerr)
+	}
+
+	entry.Params = redacted
+
+	serr := r.store.SaveAuditEntry(entry)
+	if serr != nil {
+		raven.CaptureError(serr, map[string]string{"operation": "audit"})
+		level.Error(r.logger).Log("msg", "failed to write audit entry", "method", method, "streamID", streamID, "err", serr)
+	}
+}
+
+// Redact returns the given params converted to a JSON object, with the values
+// of any secret parameters at any depth replaced by Redacted.
+func Redact(params interface{}) (postgres.AuditParams, error) {
+	redacted := postgres.AuditParams{}
+
+	if params == nil {
+		return redacted, nil
+	}
+
+	b, err := json.Marshal(params)
+	if err != nil {
+		return redacted, errors.Wrap(err, "failed to marshal params")
+	}
+
+	err = json.Unmarshal(b, &redacted)
+	if err != nil {
+		return postgres.AuditParams{}, errors.Wrap(err, "failed to unmarshal params into object")
+	}
+
+	redactValue(map[string]interface{}(redacted))
+
+	return redacted, nil
+}
+
+// redactValue replaces secret values within the given decoded JSON value in
+// place.
+func redactValue(v interface{}) {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for k, child := range val {
+			if secretParams[k] {
+				val[k] = Redacted
+				continue
+			}
+			redactValue(child)
+		}
+	case []interface{}:
+		for _, child := range val {
+			redactValue(child)
+		}
+	}
+}
+
+// contextKey is the type of keys we add to request contexts.
+type contextKey string
+
+// callerKey is the context key under which the identity of the caller is
+// stored.
+const callerKey = contextKey("caller")
+
+// WithCaller returns a copy of the context carrying the given caller identity.
+func WithCaller(ctx context.Context, caller string) context.Context {
+	return context.WithValue(ctx, callerKey, caller)
+}
+
+// Caller returns the caller identity carried by the context, or "unknown" if
+// none was set.
+func Caller(ctx context.Context) string {
+	caller, ok := ctx.Value(callerKey).(string)
+	if !ok || caller == "" {
+		return "unknown"
+	}
+	return caller
+}
+
+// CallerMiddleware is HTTP middleware which identifies the caller of incoming
+// requests by their address, preferring the original client address reported
+// by any proxy in front of the encoder, and stores it in the request context
+// where it may be read by Record.
+func CallerMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(WithCaller(r.Context(), callerAddr(r))))
+	})
+}
+
+// callerAddr returns the address of the client which made the request.
+func callerAddr(r *http.Request) string {
+	if forwarded := r.Header.Get(forwardedForHeader); forwarded != "" {
+		return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
@@ -0,0 +1,109 @@
+package audit_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DECODEproject/iotcommon/middleware"
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/twitchtv/twirp"
+
+	"github.com/DECODEproject/iotencoder/pkg/audit"
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/postgres/postgrestest"
+)
+
+func TestRedact(t *testing.T) {
+	params, err := audit.Redact(map[string]interface{}{
+		"stream_uid": "stream-id",
+		"token":      "secret",
+		"nested": []interface{}{
+			map[string]interface{}{"ingest_secret": "secret", "sensor_id": 12},
+		},
+	})
+	assert.Nil(t, err)
+
+	assert.Equal(t, "stream-id", params["stream_uid"])
+	assert.Equal(t, audit.Redacted, params["token"])
+
+	nested := params["nested"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, audit.Redacted, nested["ingest_secret"])
+	assert.Equal(t, float64(12), nested["sensor_id"])
+}
+
+func TestRecord(t *testing.T) {
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	db := postgrestest.NewDB()
+
+	recorder := audit.NewRecorder(&audit.Config{
+		Store: db,
+		Clock: clock.NewMock(now),
+	}, kitlog.NewNopLogger())
+
+	ctx := audit.WithCaller(context.Background(), "10.0.0.1")
+	ctx = context.WithValue(ctx, middleware.RequestCtxKey, "request-id")
+
+	recorder.Record(ctx, "DeleteStream", "stream-id", map[string]string{"token": "secret"}, twirp.NotFoundError("stream not found"))
+	recorder.Record(context.Background(), "CreateStream", "", nil, nil)
+
+	entries, err := db.GetAuditEntries("", now, now.Add(time.Second), 0, 10)
+	assert.Nil(t, err)
+	assert.Len(t, entries, 2)
+
+	assert.Equal(t, "10.0.0.1", entries[0].Caller)
+	assert.Equal(t, "request-id", entries[0].RequestID.String)
+	assert.Equal(t, "stream-id", entries[0].StreamID.String)
+	assert.Equal(t, "not_found", entries[0].Result)
+	assert.Equal(t, "twirp error not_found: stream not found", entries[0].Error.String)
+	assert.Equal(t, audit.Redacted, entries[0].Params["token"])
+
+	assert.Equal(t, "unknown", entries[1].Caller)
+	assert.Equal(t, audit.ResultOK, entries[1].Result)
+	assert.False(t, entries[1].StreamID.Valid)
+	assert.False(t, entries[1].Error.Valid)
+}
+
+func TestCallerMiddleware(t *testing.T) {
+	testcases := []struct {
+		label        string
+		remoteAddr   string
+		forwardedFor string
+		expected     string
+	}{
+		{
+			label:      "remote address",
+			remoteAddr: "192.168.1.5:51234",
+			expected:   "192.168.1.5",
+		},
+		{
+			label:        "forwarded for",
+			remoteAddr:   "192.168.1.5:51234",
+			forwardedFor: "10.0.0.1, 172.16.0.1",
+			expected:     "10.0.0.1",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			var caller string
+
+			handler := audit.CallerMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				caller = audit.Caller(r.Context())
+			}))
+
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			req.RemoteAddr = tc.remoteAddr
+			if tc.forwardedFor != "" {
+				req.Header.Set("X-Forwarded-For", tc.forwardedFor)
+			}
+
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			assert.Equal(t, tc.expected, caller)
+		})
+	}
+}
@@ -0,0 +1,528 @@
+// Package boltdb provides an implementation of the storage methods of
+// postgres.DB backed by a single embedded bbolt file, intended for single node
+// and development deployments on which running Postgres is impractical. As
+// with Postgres, stream tokens and ingest secrets are encrypted at rest.
+//
+// A bbolt file may only be opened by a single process, so this backend cannot
+// be shared between partitioned instances, and does not retain raw payloads.
+//
+// Values are encrypted with a key derived by scrypt from the encryption
+// password and a random salt generated for each file, so that a stolen file
+// may only be attacked by deriving a key for each password guessed.
+package boltdb
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/crypto/scrypt"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+)
+
+var (
+	metaBucket        = []byte("meta")
+	devicesBucket     = []byte("devices")
+	streamsBucket     = []byte("streams")
+	statsBucket       = []byte("stream_stats")
+	statusBucket      = []byte("device_status")
+	checkpointsBucket = []byte("downsample_checkpoints")
+	auditBucket       = []byte("audit_log")
+	messageKeysBucket = []byte("message_keys")
+	certsBucket       = []byte("certificates")
+	schemasBucket     = []byte("payload_schemas")
+	ledgerBucket      = []byte("privacy_ledger")
+
+	// versionKey is the key within the meta bucket holding the number of
+	// migrations applied to the file.
+	versionKey = []byte("version")
+
+	// saltKey is the key within the meta bucket holding the salt from which,
+	// with the encryption password, the key encrypting values is derived.
+	saltKey = []byte("key_salt")
+)
+
+const (
+	// saltLength is the length of the random salt generated for each file.
+	saltLength = 16
+
+	// scryptN, scryptR and scryptP are the scrypt cost parameters with which
+	// the key encrypting values is derived, as recommended for interactive
+	// use, so that deriving it takes a fraction of a second on a gateway.
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// migration is a single change to the layout of the file, the equivalent of
+// one of our SQL migrations.
+type migration struct {
+	Name string
+	Up   func(tx *bolt.Tx) error
+}
+
+// migrations are applied in order by MigrateUp, the version of each being its
+// position in the list. As with our SQL migrations they must only ever be
+// appended to.
+var migrations = []*migration{
+	{
+		Name: "create_buckets",
+		Up: func(tx *bolt.Tx) error {
+			for _, name := range [][]byte{
+				devicesBucket,
+				streamsBucket,
+				statsBucket,
+				statusBucket,
+				checkpointsBucket,
+				auditBucket,
+				messageKeysBucket,
+				certsBucket,
+			} {
+				_, err := tx.CreateBucketIfNotExists(name)
+				if err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+	},
+	{
+		Name: "create_schemas_bucket",
+		Up: func(tx *bolt.Tx) error {
+			_, err := tx.CreateBucketIfNotExists(schemasBucket)
+			return err
+		},
+	},
+	{
+		Name: "create_privacy_ledger_bucket",
+		Up: func(tx *bolt.Tx) error {
+			_, err := tx.CreateBucketIfNotExists(ledgerBucket)
+			return err
+		},
+	},
+}
+
+// Config is used to carry package local configuration for the bolt DB module.
+// Path is the file in which everything is stored, which is created if it does
+// not exist.
+type Config struct {
+	Path               string
+	EncryptionPassword secret.Secret
+	Clock              clock.Clock
+}
+
+// DB is our type that wraps a bolt.DB instance and provides the same API for
+// data access as postgres.DB.
+type DB struct {
+	path               string
+	encryptionPassword secret.Secret
+	clock              clock.Clock
+	logger             kitlog.Logger
+
+	DB   *bolt.DB
+	aead cipher.AEAD
+	quit chan struct{}
+}
+
+// NewDB creates a new DB instance which will store data in the file at the
+// configured path. We also pass in a logger.
+func NewDB(config *Config, logger kitlog.Logger) *DB {
+	logger = kitlog.With(logger, "module", "boltdb")
+
+	return &DB{
+		path:               config.Path,
+		encryptionPassword: config.EncryptionPassword,
+		clock:              config.Clock,
+		logger:             logger,
+	}
+}
+
+// Start opens the file, returning an error if it is already held open by
+// another process, and derives the key with which values are encrypted.
+func (d *DB) Start() error {
+	d.logger.Log("msg", "starting bolt", "path", d.path)
+
+	db, err := bolt.Open(d.path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return errors.Wrap(err, "opening bolt file failed")
+	}
+
+	err = db.Update(d.deriveKey)
+	if err != nil {
+		db.Close()
+		return errors.Wrap(err, "failed to derive encryption key")
+	}
+
+	d.DB = db
+	d.quit = make(chan struct{})
+
+	go d.recordMetrics()
+
+	return nil
+}
+
+// Stop closes the file.
+func (d *DB) Stop() error {
+	d.logger.Log("msg", "stopping bolt")
+
+	close(d.quit)
+
+	return d.DB.Close()
+}
+
+// MigrateUp applies any migrations not yet applied to the file.
+func (d *DB) MigrateUp() error {
+	d.logger.Log("msg", "migrating DB up")
+
+	return d.DB.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(metaBucket)
+		if err != nil {
+			return errors.Wrap(err, "failed to create meta bucket")
+		}
+
+		for version := readVersion(meta); version < uint64(len(migrations)); version++ {
+			m := migrations[version]
+
+			err = m.Up(tx)
+			if err != nil {
+				return errors.Wrapf(err, "failed to apply migration %d %s", version+1, m.Name)
+			}
+
+			err = meta.Put(versionKey, itob(version+1))
+			if err != nil {
+				return errors.Wrap(err, "failed to save migration version")
+			}
+		}
+
+		return nil
+	})
+}
+
+// MigrationStatus returns the migration status of the file, in the same form
+// as that of Postgres. As migrations are applied within a single transaction
+// the file can never be dirty.
+func (d *DB) MigrationStatus() (*postgres.MigrationStatus, error) {
+	status := &postgres.MigrationStatus{
+		Migrations: []*postgres.MigrationVersion{},
+	}
+
+	err := d.DB.View(func(tx *bolt.Tx) error {
+		if meta := tx.Bucket(metaBucket); meta != nil {
+			status.Version = uint(readVersion(meta))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read current migration version")
+	}
+
+	for i, m := range migrations {
+		status.Migrations = append(status.Migrations, &postgres.MigrationVersion{
+			Version: uint(i + 1),
+			Name:    m.Name,
+			Applied: uint(i+1) <= status.Version,
+		})
+	}
+
+	return status, nil
+}
+
+// Ping verifies that the file is open and readable.
+func (d *DB) Ping() error {
+	return d.DB.View(func(tx *bolt.Tx) error {
+		return nil
+	})
+}
+
+// Check returns an error if the file cannot be read, or has not been migrated.
+func (d *DB) Check() error {
+	status, err := d.MigrationStatus()
+	if err != nil {
+		return err
+	}
+
+	if status.Version == 0 {
+		return errors.New("database has not been migrated")
+	}
+
+	return nil
+}
+
+// Get is an implementation of the Get method of the autocert.Cache interface.
+func (d *DB) Get(ctx context.Context, key string) ([]byte, error) {
+	var cert []byte
+
+	err := d.DB.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(certsBucket).Get([]byte(key)); v != nil {
+			cert = append([]byte(nil), v...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read certificate from DB")
+	}
+
+	if cert == nil {
+		return nil, autocert.ErrCacheMiss
+	}
+
+	return cert, nil
+}
+
+// Put is an implementation of the Put method of the autocert.Cache interface
+// for saving certificates
+func (d *DB) Put(ctx context.Context, key string, cert []byte) error {
+	err := d.DB.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(certsBucket).Put([]byte(key), cert)
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to insert certificate")
+	}
+
+	return nil
+}
+
+// Delete is an implementation of the Delete method of the autocert.Cache
+// interface method for deleting certificates.
+func (d *DB) Delete(ctx context.Context, key string) error {
+	err := d.DB.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(certsBucket).Delete([]byte(key))
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to delete certificate")
+	}
+
+	return nil
+}
+
+// recordMetrics starts a ticker to collect some gauge related metrics from the
+// DB on a 30 second interval, until the DB is stopped.
+func (d *DB) recordMetrics() {
+	ticker := time.NewTicker(time.Second * time.Duration(30))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			streams, err := d.ListStreams()
+			if err != nil {
+				d.logger.Log(
+					"msg", "error counting streams",
+					"err", err,
+				)
+				continue
+			}
+
+			postgres.StreamGauge.Set(float64(len(streams)))
+		case <-d.quit:
+			return
+		}
+	}
+}
+
+// deriveKey creates the cipher with which values are sealed, using a key
+// derived by scrypt from the encryption password and the salt of the file,
+// which is generated when the file is first opened. A file written before keys
+// were derived by scrypt, in which values are sealed with the SHA-256 hash of
+// the password, has them sealed again with the derived key.
+func (d *DB) deriveKey(tx *bolt.Tx) error {
+	meta, err := tx.CreateBucketIfNotExists(metaBucket)
+	if err != nil {
+		return errors.Wrap(err, "failed to create meta bucket")
+	}
+
+	salt := meta.Get(saltKey)
+	if salt != nil {
+		d.aead, err = newAEAD([]byte(d.encryptionPassword.Reveal()), salt)
+		return err
+	}
+
+	salt = make([]byte, saltLength)
+
+	_, err = io.ReadFull(rand.Reader, salt)
+	if err != nil {
+		return errors.Wrap(err, "failed to generate salt")
+	}
+
+	aead, err := newAEAD([]byte(d.encryptionPassword.Reveal()), salt)
+	if err != nil {
+		return err
+	}
+
+	if readVersion(meta) > 0 {
+		legacyKey := sha256.Sum256([]byte(d.encryptionPassword.Reveal()))
+
+		legacy, err := newCipher(legacyKey[:])
+		if err != nil {
+			return err
+		}
+
+		err = reseal(tx, legacy, aead)
+		if err != nil {
+			return errors.Wrap(err, "failed to encrypt values with derived key")
+		}
+	}
+
+	err = meta.Put(saltKey, salt)
+	if err != nil {
+		return errors.Wrap(err, "failed to save salt")
+	}
+
+	d.aead = aead
+
+	return nil
+}
+
+// reseal decrypts the sealed values of every stream with one cipher, and
+// encrypts them again with another.
+func reseal(tx *bolt.Tx, from, to cipher.AEAD) error {
+	bucket := tx.Bucket(streamsBucket)
+	if bucket == nil {
+		return nil
+	}
+
+	resealed := map[string][]byte{}
+
+	err := bucket.ForEach(func(k, v []byte) error {
+		var record streamRecord
+
+		err := json.Unmarshal(v, &record)
+		if err != nil {
+			return errors.Wrap(err, "failed to read stream")
+		}
+
+		for _, sealed := range []*[]byte{&record.Token, &record.IngestSecret} {
+			if *sealed == nil {
+				continue
+			}
+
+			value, err := open(from, *sealed)
+			if err != nil {
+				return err
+			}
+
+			*sealed, err = seal(to, value)
+			if err != nil {
+				return err
+			}
+		}
+
+		b, err := json.Marshal(&record)
+		if err != nil {
+			return errors.Wrap(err, "failed to write stream")
+		}
+
+		resealed[string(k)] = b
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// keys may not be written while iterating over a bucket
+	for k, v := range resealed {
+		err = bucket.Put([]byte(k), v)
+		if err != nil {
+			return errors.Wrap(err, "failed to save stream")
+		}
+	}
+
+	return nil
+}
+
+// newAEAD returns an AES-GCM cipher keyed with the key derived by scrypt from
+// the given password and salt.
+func newAEAD(password, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(password, salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to derive key")
+	}
+
+	return newCipher(key)
+}
+
+// newCipher returns an AES-GCM cipher keyed with the given key.
+func newCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create cipher")
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create AEAD")
+	}
+
+	return aead, nil
+}
+
+// seal encrypts the given value using AES-GCM, prefixing the random nonce.
+func (d *DB) seal(value string) ([]byte, error) {
+	return seal(d.aead, value)
+}
+
+// open decrypts a value encrypted by seal.
+func (d *DB) open(sealed []byte) (string, error) {
+	return open(d.aead, sealed)
+}
+
+// seal encrypts the given value with the given cipher, prefixing the random
+// nonce.
+func seal(aead cipher.AEAD, value string) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+
+	_, err := io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate nonce")
+	}
+
+	return aead.Seal(nonce, nonce, []byte(value), nil), nil
+}
+
+// open decrypts a value encrypted by seal with the given cipher.
+func open(aead cipher.AEAD, sealed []byte) (string, error) {
+	size := aead.NonceSize()
+	if len(sealed) < size {
+		return "", errors.New("sealed value is too short")
+	}
+
+	value, err := aead.Open(nil, sealed[:size], sealed[size:], nil)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to decrypt value")
+	}
+
+	return string(value), nil
+}
+
+// readVersion returns the number of migrations applied to the file.
+func readVersion(meta *bolt.Bucket) uint64 {
+	v := meta.Get(versionKey)
+	if v == nil {
+		return 0
+	}
+
+	return binary.BigEndian.Uint64(v)
+}
+
+// itob returns the big endian representation of v, used for keys which must
+// sort in numeric order.
+func itob(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
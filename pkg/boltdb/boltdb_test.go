@@ -0,0 +1,541 @@
+package boltdb_test
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
+	"golang.org/x/crypto/acme/autocert"
+	"gopkg.in/guregu/null.v3"
+
+	"github.com/DECODEproject/iotencoder/pkg/boltdb"
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+)
+
+// openDB returns a started and migrated DB stored in a temporary directory,
+// along with the path of its file and a function which removes the directory.
+func openDB(t *testing.T, cl clock.Clock) (*boltdb.DB, string, func()) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "boltdb")
+	assert.Nil(t, err)
+
+	path := filepath.Join(dir, "encoder.db")
+
+	db := newDB(t, path, cl)
+
+	return db, path, func() {
+		os.RemoveAll(dir)
+	}
+}
+
+// newDB returns a started and migrated DB stored at path.
+func newDB(t *testing.T, path string, cl clock.Clock) *boltdb.DB {
+	t.Helper()
+
+	db := boltdb.NewDB(&boltdb.Config{
+		Path:               path,
+		EncryptionPassword: "password",
+		Clock:              cl,
+	}, kitlog.NewNopLogger())
+
+	err := db.Start()
+	assert.Nil(t, err)
+
+	err = db.MigrateUp()
+	assert.Nil(t, err)
+
+	return db
+}
+
+func newStream(communityID string) *postgres.Stream {
+	return &postgres.Stream{
+		PublicKey:   "abc123",
+		CommunityID: communityID,
+		Operations: postgres.Operations{
+			{SensorID: 12, Action: postgres.Share},
+		},
+		Device: &postgres.Device{
+			DeviceToken: "device",
+			Longitude:   23,
+			Latitude:    23.2,
+			Exposure:    "indoor",
+		},
+	}
+}
+
+func TestMigrations(t *testing.T) {
+	db, _, cleanup := openDB(t, clock.New())
+	defer cleanup()
+	defer db.Stop()
+
+	status, err := db.MigrationStatus()
+	assert.Nil(t, err)
+	assert.Equal(t, uint(3), status.Version)
+	assert.False(t, status.Dirty)
+	assert.Len(t, status.Migrations, 3)
+	assert.True(t, status.Migrations[0].Applied)
+	assert.True(t, status.Migrations[2].Applied)
+
+	// migrating again is a no-op
+	err = db.MigrateUp()
+	assert.Nil(t, err)
+
+	assert.Nil(t, db.Check())
+	assert.Nil(t, db.Ping())
+}
+
+func TestStreamLifecycle(t *testing.T) {
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	cl := clock.NewMock(now)
+
+	db, _, cleanup := openDB(t, cl)
+	defer cleanup()
+	defer db.Stop()
+
+	stream, err := db.CreateStream(newStream("community-1"))
+	assert.Nil(t, err)
+	assert.NotEqual(t, "", stream.StreamID)
+	assert.NotEqual(t, secret.Secret(""), stream.Token)
+
+	_, err = db.CreateStream(newStream("community-1"))
+	assert.NotNil(t, err)
+	assert.Equal(t, "failed to create stream: device already registered within community", err.Error())
+
+	stream2, err := db.CreateStream(newStream("community-2"))
+	assert.Nil(t, err)
+
+	devices, err := db.GetDevices()
+	assert.Nil(t, err)
+	assert.Len(t, devices, 1)
+	assert.Equal(t, secret.Secret("device"), devices[0].DeviceToken)
+
+	device, err := db.GetDevice("device")
+	assert.Nil(t, err)
+	assert.Equal(t, 23.2, device.Latitude)
+	assert.Len(t, device.Streams, 2)
+
+	streams, err := db.ListStreams()
+	assert.Nil(t, err)
+	assert.Len(t, streams, 2)
+	assert.Equal(t, stream.StreamID, streams[0].StreamID)
+	assert.Equal(t, stream2.StreamID, streams[1].StreamID)
+	assert.Equal(t, postgres.Share, streams[0].Operations[0].Action)
+	assert.Equal(t, "indoor", streams[0].Device.Exposure)
+
+	_, err = db.GetStream(stream.StreamID, "wrong")
+	assert.Equal(t, postgres.ErrStreamNotFound, err)
+
+	got, err := db.GetStream(stream.StreamID, stream.Token.Reveal())
+	assert.Nil(t, err)
+	assert.Equal(t, "community-1", got.CommunityID)
+
+	err = db.SetIngestSecret(stream.StreamID, stream.Token.Reveal(), "shared")
+	assert.Nil(t, err)
+
+	err = db.SetConversions(stream.StreamID, "wrong", postgres.Conversions{12: "x * 2"})
+	assert.Equal(t, postgres.ErrStreamNotFound, err)
+
+	device, err = db.GetDevice("device")
+	assert.Nil(t, err)
+
+	for _, s := range device.Streams {
+		if s.StreamID == stream.StreamID {
+			assert.Equal(t, secret.Secret("shared"), s.IngestSecret)
+		}
+	}
+
+	deleted, err := db.DeleteStream(stream)
+	assert.Nil(t, err)
+	assert.Nil(t, deleted)
+
+	_, err = db.GetStream(stream.StreamID, stream.Token.Reveal())
+	assert.Equal(t, postgres.ErrStreamNotFound, err)
+
+	// deleting the device's last stream returns the device
+	deleted, err = db.DeleteStream(stream2)
+	assert.Nil(t, err)
+	assert.NotNil(t, deleted)
+	assert.Equal(t, secret.Secret("device"), deleted.DeviceToken)
+
+	_, err = db.GetDevice("device")
+	assert.NotNil(t, err)
+
+	restored, err := db.RestoreStream(stream.StreamID, stream.Token.Reveal())
+	assert.Nil(t, err)
+	assert.Equal(t, stream.StreamID, restored.StreamID)
+	assert.Equal(t, secret.Secret("device"), restored.Device.DeviceToken)
+
+	_, err = db.RestoreStream(stream.StreamID, stream.Token.Reveal())
+	assert.Equal(t, postgres.ErrStreamNotFound, err)
+
+	cl.Add(time.Hour)
+
+	purged, err := db.PurgeDeletedStreams(now.Add(time.Minute))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), purged)
+
+	_, err = db.RestoreStream(stream2.StreamID, stream2.Token.Reveal())
+	assert.Equal(t, postgres.ErrStreamNotFound, err)
+
+	streams, err = db.ListDeviceStreams("device")
+	assert.Nil(t, err)
+	assert.Len(t, streams, 1)
+}
+
+func TestInvalidOperations(t *testing.T) {
+	db, _, cleanup := openDB(t, clock.New())
+	defer cleanup()
+	defer db.Stop()
+
+	stream := newStream("community-1")
+	stream.Operations = postgres.Operations{{SensorID: 12, Action: postgres.Bin}}
+
+	_, err := db.CreateStream(stream)
+	assert.NotNil(t, err)
+}
+
+func TestPersistence(t *testing.T) {
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	db, path, cleanup := openDB(t, clock.New())
+	defer cleanup()
+
+	stream, err := db.CreateStream(newStream("community-1"))
+	assert.Nil(t, err)
+
+	err = db.SaveStreamStats([]*postgres.StreamStats{
+		{StreamID: stream.StreamID, MessagesReceived: 3, ProcessingCount: 2, LastMessageAt: null.TimeFrom(now)},
+		{StreamID: "unknown", MessagesReceived: 1},
+	})
+	assert.Nil(t, err)
+
+	err = db.SaveDeviceStatuses([]*postgres.DeviceStatus{
+		{DeviceToken: "device", LastSeenAt: null.TimeFrom(now), MessageCounts: postgres.MessageCounts{now.Unix(): 3}},
+	})
+	assert.Nil(t, err)
+
+	err = db.SaveMessageKeys([]*postgres.MessageKey{
+		{Key: "abc", SeenAt: now},
+		{Key: "def", SeenAt: now.Add(-time.Hour)},
+	})
+	assert.Nil(t, err)
+
+	err = db.Put(context.Background(), "example.com", []byte("cert"))
+	assert.Nil(t, err)
+
+	// everything survives reopening the file
+	err = db.Stop()
+	assert.Nil(t, err)
+
+	db = newDB(t, path, clock.New())
+
+	stats, err := db.GetStreamStats()
+	assert.Nil(t, err)
+	assert.Len(t, stats, 1)
+	assert.Equal(t, stream.StreamID, stats[0].StreamID)
+	assert.Equal(t, uint64(3), stats[0].MessagesReceived)
+	assert.Equal(t, uint64(2), stats[0].ProcessingCount)
+	assert.True(t, now.Equal(stats[0].LastMessageAt.Time))
+
+	statuses, err := db.GetDeviceStatuses()
+	assert.Nil(t, err)
+	assert.Len(t, statuses, 1)
+	assert.Equal(t, secret.Secret("device"), statuses[0].DeviceToken)
+	assert.Equal(t, uint64(3), statuses[0].MessageCounts.Total())
+
+	keys, err := db.GetMessageKeys(now.Add(-time.Minute))
+	assert.Nil(t, err)
+	assert.Len(t, keys, 1)
+	assert.Equal(t, "abc", keys[0].Key)
+
+	pruned, err := db.PruneMessageKeys(now.Add(-time.Minute))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), pruned)
+
+	cert, err := db.Get(context.Background(), "example.com")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("cert"), cert)
+
+	_, err = db.Get(context.Background(), "example.org")
+	assert.Equal(t, autocert.ErrCacheMiss, err)
+
+	device, err := db.GetDevice("device")
+	assert.Nil(t, err)
+	assert.Len(t, device.Streams, 1)
+
+	// the file may only be opened by one process at a time
+	other := boltdb.NewDB(&boltdb.Config{
+		Path:               path,
+		EncryptionPassword: "password",
+		Clock:              clock.New(),
+	}, kitlog.NewNopLogger())
+
+	err = other.Start()
+	assert.NotNil(t, err)
+
+	err = db.Stop()
+	assert.Nil(t, err)
+}
+
+func TestEncryptionKey(t *testing.T) {
+	db, path, cleanup := openDB(t, clock.New())
+	defer cleanup()
+
+	stream, err := db.CreateStream(newStream("community-1"))
+	assert.Nil(t, err)
+
+	err = db.Stop()
+	assert.Nil(t, err)
+
+	// rewrite the file as it was before keys were derived by scrypt, with no
+	// salt and the token sealed with the hash of the password
+	raw, err := bolt.Open(path, 0600, nil)
+	assert.Nil(t, err)
+
+	var salt []byte
+
+	err = raw.Update(func(tx *bolt.Tx) error {
+		meta := tx.Bucket([]byte("meta"))
+		salt = append([]byte(nil), meta.Get([]byte("key_salt"))...)
+
+		streams := tx.Bucket([]byte("streams"))
+
+		var record map[string]interface{}
+		err := json.Unmarshal(streams.Get([]byte(stream.StreamID)), &record)
+		assert.Nil(t, err)
+
+		key := sha256.Sum256([]byte("password"))
+		block, err := aes.NewCipher(key[:])
+		assert.Nil(t, err)
+		aead, err := cipher.NewGCM(block)
+		assert.Nil(t, err)
+
+		nonce := make([]byte, aead.NonceSize())
+		record["token"] = aead.Seal(nonce, nonce, []byte(stream.Token.Reveal()), nil)
+
+		b, err := json.Marshal(record)
+		assert.Nil(t, err)
+
+		err = streams.Put([]byte(stream.StreamID), b)
+		assert.Nil(t, err)
+
+		return meta.Delete([]byte("key_salt"))
+	})
+	assert.Nil(t, err)
+	assert.Len(t, salt, 16)
+
+	err = raw.Close()
+	assert.Nil(t, err)
+
+	// the token is sealed again under a key derived with a new salt
+	db = newDB(t, path, clock.New())
+
+	got, err := db.GetStream(stream.StreamID, stream.Token.Reveal())
+	assert.Nil(t, err)
+	assert.Equal(t, stream.StreamID, got.StreamID)
+
+	err = db.Stop()
+	assert.Nil(t, err)
+
+	raw, err = bolt.Open(path, 0600, nil)
+	assert.Nil(t, err)
+
+	err = raw.View(func(tx *bolt.Tx) error {
+		newSalt := tx.Bucket([]byte("meta")).Get([]byte("key_salt"))
+		assert.Len(t, newSalt, 16)
+		assert.NotEqual(t, salt, newSalt)
+		return nil
+	})
+	assert.Nil(t, err)
+
+	err = raw.Close()
+	assert.Nil(t, err)
+
+	// a different password can't open the token
+	other := boltdb.NewDB(&boltdb.Config{
+		Path:               path,
+		EncryptionPassword: "other",
+		Clock:              clock.New(),
+	}, kitlog.NewNopLogger())
+
+	err = other.Start()
+	assert.Nil(t, err)
+
+	_, err = other.GetStream(stream.StreamID, stream.Token.Reveal())
+	assert.NotNil(t, err)
+
+	err = other.Stop()
+	assert.Nil(t, err)
+}
+
+func TestAuditEntries(t *testing.T) {
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	db, _, cleanup := openDB(t, clock.New())
+	defer cleanup()
+	defer db.Stop()
+
+	for i := 0; i < 3; i++ {
+		entry := &postgres.AuditEntry{
+			RecordedAt: now.Add(time.Duration(i) * time.Minute),
+			Caller:     "admin",
+			Method:     "DeleteStream",
+			StreamID:   null.StringFrom("stream-1"),
+			Params:     postgres.AuditParams{"stream_uid": "stream-1"},
+			Result:     "success",
+		}
+
+		if i == 1 {
+			entry.StreamID = null.StringFrom("stream-2")
+		}
+
+		err := db.SaveAuditEntry(entry)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(i+1), entry.ID)
+	}
+
+	entries, err := db.GetAuditEntries("", now, now.Add(time.Hour), 0, 2)
+	assert.Nil(t, err)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, int64(1), entries[0].ID)
+	assert.Equal(t, int64(2), entries[1].ID)
+
+	entries, err = db.GetAuditEntries("stream-1", now, now.Add(time.Hour), 1, 10)
+	assert.Nil(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, int64(3), entries[0].ID)
+	assert.Equal(t, "stream-1", entries[0].Params["stream_uid"])
+}
+
+func TestSchemas(t *testing.T) {
+	db, _, cleanup := openDB(t, clock.New())
+	defer cleanup()
+	defer db.Stop()
+
+	_, err := db.GetSchema("noise", 0)
+	assert.Equal(t, postgres.ErrSchemaNotFound, err)
+
+	channels := postgres.Channels{
+		{SensorID: 12, Name: "temperature", Unit: "celsius", Type: postgres.NumberType},
+	}
+
+	for _, kind := range []string{"noise", "air", "noise"} {
+		_, err = db.CreateSchema(kind, channels)
+		assert.Nil(t, err)
+	}
+
+	_, err = db.CreateSchema("noise", postgres.Channels{})
+	assert.NotNil(t, err)
+
+	schema, err := db.GetSchema("noise", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "noise@2", schema.Ref())
+	assert.Equal(t, "celsius", schema.Channels[0].Unit)
+
+	schema, err = db.GetSchema("noise", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, "noise@1", schema.Ref())
+
+	_, err = db.GetSchema("noise", 3)
+	assert.Equal(t, postgres.ErrSchemaNotFound, err)
+
+	schemas, err := db.ListSchemas()
+	assert.Nil(t, err)
+	assert.Len(t, schemas, 3)
+	assert.Equal(t, "air@1", schemas[0].Ref())
+	assert.Equal(t, "noise@2", schemas[2].Ref())
+}
+
+func TestSpendPrivacyBudget(t *testing.T) {
+	db, _, cleanup := openDB(t, clock.New())
+	defer cleanup()
+	defer db.Stop()
+
+	stream, err := db.CreateStream(newStream("community-1"))
+	assert.Nil(t, err)
+
+	today := time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC)
+	tomorrow := today.Add(24 * time.Hour)
+
+	for _, cost := range []float64{1, 1, 0.5} {
+		spent, err := db.SpendPrivacyBudget(stream.StreamID, today, cost, 2.5)
+		assert.Nil(t, err)
+		assert.True(t, spent)
+	}
+
+	// the budget of the period is spent
+	spent, err := db.SpendPrivacyBudget(stream.StreamID, today, 0.5, 2.5)
+	assert.Nil(t, err)
+	assert.False(t, spent)
+
+	// while that of the next period is not
+	spent, err = db.SpendPrivacyBudget(stream.StreamID, tomorrow, 0.5, 2.5)
+	assert.Nil(t, err)
+	assert.True(t, spent)
+
+	spent, err = db.SpendPrivacyBudget("unknown", today, 0.5, 2.5)
+	assert.Nil(t, err)
+	assert.False(t, spent)
+}
+
+func TestExportImportStreams(t *testing.T) {
+	src, _, cleanup := openDB(t, clock.New())
+	defer cleanup()
+	defer src.Stop()
+
+	stream, err := src.CreateStream(newStream("community-1"))
+	assert.Nil(t, err)
+
+	err = src.SetIngestSecret(stream.StreamID, stream.Token.Reveal(), "shared")
+	assert.Nil(t, err)
+
+	exported := []*postgres.Stream{}
+
+	err = src.ExportStreams(func(s *postgres.Stream) error {
+		exported = append(exported, s)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Len(t, exported, 1)
+	assert.Equal(t, stream.Token, exported[0].Token)
+	assert.Equal(t, secret.Secret("shared"), exported[0].IngestSecret)
+
+	dst, _, cleanup := openDB(t, clock.New())
+	defer cleanup()
+	defer dst.Stop()
+
+	_, err = dst.ImportStream(exported[0])
+	assert.Nil(t, err)
+
+	_, err = dst.ImportStream(exported[0])
+	assert.Equal(t, postgres.ErrStreamExists, err)
+
+	got, err := dst.GetStream(stream.StreamID, stream.Token.Reveal())
+	assert.Nil(t, err)
+	assert.Equal(t, "community-1", got.CommunityID)
+
+	device, err := dst.GetDevice("device")
+	assert.Nil(t, err)
+	assert.Equal(t, secret.Secret("shared"), device.Streams[0].IngestSecret)
+
+	// a new stream for the same device and community conflicts
+	imported := newStream("community-1")
+	imported.StreamID = "another"
+	imported.Token = "token"
+
+	_, err = dst.ImportStream(imported)
+	assert.Equal(t, postgres.ErrStreamConflict, err)
+}
@@ -0,0 +1,602 @@
+package boltdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+	"gopkg.in/guregu/null.v3"
+
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+)
+
+// streamStatsRecord is the value stored for the stats of each stream, keyed by
+// stream id. We don't store the postgres.StreamStats itself as some of its
+// fields are omitted when marshalled.
+type streamStatsRecord struct {
+	MessagesReceived uint64    `json:"messagesReceived"`
+	BytesEncrypted   uint64    `json:"bytesEncrypted"`
+	WritesSucceeded  uint64    `json:"writesSucceeded"`
+	WritesFailed     uint64    `json:"writesFailed"`
+	Throttled        uint64    `json:"throttled,omitempty"`
+	Dropped          uint64    `json:"dropped,omitempty"`
+	LastMessageAt    null.Time `json:"lastMessageAt"`
+	ProcessingCount  uint64    `json:"processingCount"`
+	ProcessingTimeNs uint64    `json:"processingTimeNs"`
+}
+
+// deviceStatusRecord is the value stored for the status of each device, keyed
+// by device token, as the token of a postgres.DeviceStatus is redacted when
+// marshalled.
+type deviceStatusRecord struct {
+	LastSeenAt    null.Time              `json:"lastSeenAt"`
+	MessageCounts postgres.MessageCounts `json:"messageCounts"`
+	Error         null.String            `json:"error"`
+	ErrorAt       null.Time              `json:"errorAt"`
+}
+
+// SaveStreamStats writes the given stats, replacing any existing stats for
+// each stream. Stats for streams that no longer exist are silently skipped.
+func (d *DB) SaveStreamStats(stats []*postgres.StreamStats) error {
+	err := d.DB.Update(func(tx *bolt.Tx) error {
+		streams := tx.Bucket(streamsBucket)
+		bucket := tx.Bucket(statsBucket)
+
+		for _, s := range stats {
+			if streams.Get([]byte(s.StreamID)) == nil {
+				continue
+			}
+
+			err := put(bucket, []byte(s.StreamID), &streamStatsRecord{
+				MessagesReceived: s.MessagesReceived,
+				BytesEncrypted:   s.BytesEncrypted,
+				WritesSucceeded:  s.WritesSucceeded,
+				WritesFailed:     s.WritesFailed,
+				Throttled:        s.Throttled,
+				Dropped:          s.Dropped,
+				LastMessageAt:    s.LastMessageAt,
+				ProcessingCount:  s.ProcessingCount,
+				ProcessingTimeNs: s.ProcessingTimeNs,
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to save stream stats")
+	}
+
+	return nil
+}
+
+// GetStreamStats returns all persisted stream stats. This is used to seed the
+// in memory stats store when the application starts.
+func (d *DB) GetStreamStats() ([]*postgres.StreamStats, error) {
+	stats := []*postgres.StreamStats{}
+
+	err := d.DB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(statsBucket).ForEach(func(k, v []byte) error {
+			var record streamStatsRecord
+
+			err := json.Unmarshal(v, &record)
+			if err != nil {
+				return err
+			}
+
+			stats = append(stats, &postgres.StreamStats{
+				StreamID:         string(k),
+				MessagesReceived: record.MessagesReceived,
+				BytesEncrypted:   record.BytesEncrypted,
+				WritesSucceeded:  record.WritesSucceeded,
+				WritesFailed:     record.WritesFailed,
+				Throttled:        record.Throttled,
+				Dropped:          record.Dropped,
+				LastMessageAt:    record.LastMessageAt,
+				ProcessingCount:  record.ProcessingCount,
+				ProcessingTimeNs: record.ProcessingTimeNs,
+			})
+
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load stream stats")
+	}
+
+	return stats, nil
+}
+
+// SaveDeviceStatuses writes the given device statuses, replacing any existing
+// status for each device. Statuses for devices that no longer exist are
+// silently skipped.
+func (d *DB) SaveDeviceStatuses(statuses []*postgres.DeviceStatus) error {
+	err := d.DB.Update(func(tx *bolt.Tx) error {
+		devices := tx.Bucket(devicesBucket)
+		bucket := tx.Bucket(statusBucket)
+
+		for _, s := range statuses {
+			key := []byte(s.DeviceToken.Reveal())
+
+			if devices.Get(key) == nil {
+				continue
+			}
+
+			err := put(bucket, key, &deviceStatusRecord{
+				LastSeenAt:    s.LastSeenAt,
+				MessageCounts: s.MessageCounts,
+				Error:         s.Error,
+				ErrorAt:       s.ErrorAt,
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to save device statuses")
+	}
+
+	return nil
+}
+
+// GetDeviceStatuses returns all persisted device statuses. This is used to
+// seed the in memory stats store when the application starts.
+func (d *DB) GetDeviceStatuses() ([]*postgres.DeviceStatus, error) {
+	statuses := []*postgres.DeviceStatus{}
+
+	err := d.DB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(statusBucket).ForEach(func(k, v []byte) error {
+			var record deviceStatusRecord
+
+			err := json.Unmarshal(v, &record)
+			if err != nil {
+				return err
+			}
+
+			if record.MessageCounts == nil {
+				record.MessageCounts = postgres.MessageCounts{}
+			}
+
+			statuses = append(statuses, &postgres.DeviceStatus{
+				DeviceToken:   secret.Secret(k),
+				LastSeenAt:    record.LastSeenAt,
+				MessageCounts: record.MessageCounts,
+				Error:         record.Error,
+				ErrorAt:       record.ErrorAt,
+			})
+
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load device statuses")
+	}
+
+	return statuses, nil
+}
+
+// SaveDownsampleCheckpoints writes the given checkpoints, replacing any
+// existing checkpoint for each sensor. Checkpoints for streams that no longer
+// exist are silently skipped.
+func (d *DB) SaveDownsampleCheckpoints(checkpoints []*postgres.DownsampleCheckpoint) error {
+	err := d.DB.Update(func(tx *bolt.Tx) error {
+		streams := tx.Bucket(streamsBucket)
+		bucket := tx.Bucket(checkpointsBucket)
+
+		for _, c := range checkpoints {
+			if streams.Get([]byte(c.StreamID)) == nil {
+				continue
+			}
+
+			key := []byte(fmt.Sprintf("%s/%d", c.StreamID, c.SensorID))
+
+			err := put(bucket, key, c)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to save downsample checkpoints")
+	}
+
+	return nil
+}
+
+// GetDownsampleCheckpoints returns all persisted downsample checkpoints. This
+// is used to seed the in memory downsampler when the application starts.
+func (d *DB) GetDownsampleCheckpoints() ([]*postgres.DownsampleCheckpoint, error) {
+	checkpoints := []*postgres.DownsampleCheckpoint{}
+
+	err := d.DB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(checkpointsBucket).ForEach(func(k, v []byte) error {
+			var c postgres.DownsampleCheckpoint
+
+			err := json.Unmarshal(v, &c)
+			if err != nil {
+				return err
+			}
+
+			checkpoints = append(checkpoints, &c)
+
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load downsample checkpoints")
+	}
+
+	return checkpoints, nil
+}
+
+// SaveAuditEntry appends the given entry to the audit log, setting its ID.
+func (d *DB) SaveAuditEntry(entry *postgres.AuditEntry) error {
+	err := d.DB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(auditBucket)
+
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		entry.ID = int64(id)
+
+		return put(bucket, itob(id), entry)
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to save audit entry")
+	}
+
+	return nil
+}
+
+// GetAuditEntries returns a page of audit entries recorded within the given
+// time interval, ordered by insertion. If streamID is not empty only entries
+// for that stream are returned. Pages are requested by passing the ID of the
+// last entry of the previous page (or zero for the first page), and the
+// maximum number of entries to return.
+func (d *DB) GetAuditEntries(streamID string, start, end time.Time, afterID int64, limit int) ([]*postgres.AuditEntry, error) {
+	entries := []*postgres.AuditEntry{}
+
+	err := d.DB.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(auditBucket).Cursor()
+
+		for k, v := c.Seek(itob(uint64(afterID + 1))); k != nil && len(entries) < limit; k, v = c.Next() {
+			var e postgres.AuditEntry
+
+			err := json.Unmarshal(v, &e)
+			if err != nil {
+				return err
+			}
+
+			if streamID != "" && e.StreamID.String != streamID {
+				continue
+			}
+
+			if !e.RecordedAt.Before(start) && e.RecordedAt.Before(end) {
+				entries = append(entries, &e)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load audit entries")
+	}
+
+	return entries, nil
+}
+
+// SaveMessageKeys writes the given message keys, keeping the earliest time at
+// which a key was seen should it already exist.
+func (d *DB) SaveMessageKeys(keys []*postgres.MessageKey) error {
+	err := d.DB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(messageKeysBucket)
+
+		for _, k := range keys {
+			seenAt := k.SeenAt
+
+			if v := bucket.Get([]byte(k.Key)); v != nil {
+				var existing time.Time
+
+				err := existing.UnmarshalBinary(v)
+				if err != nil {
+					return err
+				}
+
+				if existing.Before(seenAt) {
+					seenAt = existing
+				}
+			}
+
+			v, err := seenAt.MarshalBinary()
+			if err != nil {
+				return err
+			}
+
+			err = bucket.Put([]byte(k.Key), v)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to save message keys")
+	}
+
+	return nil
+}
+
+// GetMessageKeys returns all persisted message keys seen at or after the given
+// time, ordered by when they were seen. This is used to seed the in memory
+// dedup cache when the application starts.
+func (d *DB) GetMessageKeys(since time.Time) ([]*postgres.MessageKey, error) {
+	keys := []*postgres.MessageKey{}
+
+	err := d.forEachMessageKey(func(key string, seenAt time.Time) error {
+		if !seenAt.Before(since) {
+			keys = append(keys, &postgres.MessageKey{Key: key, SeenAt: seenAt})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load message keys")
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].SeenAt.Before(keys[j].SeenAt)
+	})
+
+	return keys, nil
+}
+
+// PruneMessageKeys deletes all message keys seen before the given time,
+// returning the number of keys deleted.
+func (d *DB) PruneMessageKeys(before time.Time) (int64, error) {
+	var deleted int64
+
+	err := d.DB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(messageKeysBucket)
+		expired := [][]byte{}
+
+		err := bucket.ForEach(func(k, v []byte) error {
+			var seenAt time.Time
+
+			err := seenAt.UnmarshalBinary(v)
+			if err != nil {
+				return err
+			}
+
+			if seenAt.Before(before) {
+				expired = append(expired, append([]byte(nil), k...))
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, k := range expired {
+			err = bucket.Delete(k)
+			if err != nil {
+				return err
+			}
+		}
+
+		deleted = int64(len(expired))
+
+		return nil
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to delete expired message keys")
+	}
+
+	return deleted, nil
+}
+
+// forEachMessageKey calls fn with each persisted message key.
+func (d *DB) forEachMessageKey(fn func(key string, seenAt time.Time) error) error {
+	return d.DB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(messageKeysBucket).ForEach(func(k, v []byte) error {
+			var seenAt time.Time
+
+			err := seenAt.UnmarshalBinary(v)
+			if err != nil {
+				return err
+			}
+
+			return fn(string(k), seenAt)
+		})
+	})
+}
+
+// CreateSchema registers a new version of the schema of the given kind, one
+// greater than its latest existing version, returning the created schema.
+func (d *DB) CreateSchema(kind string, channels postgres.Channels) (*postgres.Schema, error) {
+	err := postgres.ValidateKind(kind)
+	if err != nil {
+		return nil, err
+	}
+
+	err = channels.Validate()
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid channels")
+	}
+
+	var schema *postgres.Schema
+
+	err = d.DB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(schemasBucket)
+
+		latest, err := latestSchema(bucket, kind)
+		if err != nil {
+			return err
+		}
+
+		schema = &postgres.Schema{
+			Kind:      kind,
+			Version:   1,
+			Channels:  channels,
+			CreatedAt: d.clock.Now().UTC(),
+		}
+
+		if latest != nil {
+			schema.Version = latest.Version + 1
+		}
+
+		return put(bucket, schemaKey(kind, schema.Version), schema)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create schema")
+	}
+
+	return schema, nil
+}
+
+// GetSchema returns the given version of the schema of the given kind, or its
+// latest version if version is zero. If no such schema exists we return
+// postgres.ErrSchemaNotFound.
+func (d *DB) GetSchema(kind string, version int) (*postgres.Schema, error) {
+	var schema *postgres.Schema
+
+	err := d.DB.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(schemasBucket)
+
+		if version == 0 {
+			var err error
+			schema, err = latestSchema(bucket, kind)
+			return err
+		}
+
+		v := bucket.Get(schemaKey(kind, version))
+		if v == nil {
+			return nil
+		}
+
+		schema = &postgres.Schema{}
+		return json.Unmarshal(v, schema)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load schema")
+	}
+
+	if schema == nil {
+		return nil, postgres.ErrSchemaNotFound
+	}
+
+	return schema, nil
+}
+
+// ListSchemas returns every version of every registered schema, ordered by
+// kind and version.
+func (d *DB) ListSchemas() ([]*postgres.Schema, error) {
+	schemas := []*postgres.Schema{}
+
+	err := d.DB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(schemasBucket).ForEach(func(k, v []byte) error {
+			var s postgres.Schema
+
+			err := json.Unmarshal(v, &s)
+			if err != nil {
+				return err
+			}
+
+			schemas = append(schemas, &s)
+
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load schemas")
+	}
+
+	return schemas, nil
+}
+
+// SpendPrivacyBudget records the spending of the given cost from the privacy
+// budget of the stream for the period starting at periodStart, returning true
+// if it was spent. If spending the cost would take the total spent in the
+// period over the budget nothing is recorded and we return false, as we do if
+// the stream does not exist.
+func (d *DB) SpendPrivacyBudget(streamID string, periodStart time.Time, cost, budget float64) (bool, error) {
+	spent := false
+
+	err := d.DB.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(streamsBucket).Get([]byte(streamID)) == nil {
+			return nil
+		}
+
+		bucket := tx.Bucket(ledgerBucket)
+		key := append([]byte(streamID+"/"), itob(uint64(periodStart.Unix()))...)
+
+		var total float64
+
+		if v := bucket.Get(key); v != nil {
+			err := json.Unmarshal(v, &total)
+			if err != nil {
+				return err
+			}
+		}
+
+		if total+cost > budget {
+			return nil
+		}
+
+		spent = true
+
+		return put(bucket, key, total+cost)
+	})
+	if err != nil {
+		return false, errors.Wrap(err, "failed to spend privacy budget")
+	}
+
+	return spent, nil
+}
+
+// schemaKey returns the key under which the given version of a schema is
+// stored. The kind is separated from the version by a zero byte, so that keys
+// sort by kind and then by version.
+func schemaKey(kind string, version int) []byte {
+	return append([]byte(kind+"\x00"), itob(uint64(version))...)
+}
+
+// latestSchema returns the latest version of the schema of the given kind, or
+// nil if none exists.
+func latestSchema(bucket *bolt.Bucket, kind string) (*postgres.Schema, error) {
+	prefix := []byte(kind + "\x00")
+
+	var latest []byte
+
+	c := bucket.Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		latest = v
+	}
+
+	if latest == nil {
+		return nil, nil
+	}
+
+	var schema postgres.Schema
+
+	err := json.Unmarshal(latest, &schema)
+	if err != nil {
+		return nil, err
+	}
+
+	return &schema, nil
+}
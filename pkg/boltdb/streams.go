@@ -0,0 +1,950 @@
+package boltdb
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/pubkey"
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+)
+
+// deviceRecord is the value stored for each device, keyed by device token.
+type deviceRecord struct {
+	ID        int     `json:"id"`
+	Label     string  `json:"label"`
+	Longitude float64 `json:"longitude"`
+	Latitude  float64 `json:"latitude"`
+	Exposure  string  `json:"exposure"`
+}
+
+// streamRecord is the value stored for each stream, keyed by stream id. Seq
+// records the order in which streams were created, and the token and ingest
+// secret are sealed. Operations are stored in the same versioned document as
+// in Postgres, so are validated and upgraded in the same way.
+type streamRecord struct {
+	Seq                  uint64                `json:"seq"`
+	DeviceToken          string                `json:"deviceToken"`
+	CommunityID          string                `json:"communityId"`
+	PublicKey            string                `json:"publicKey"`
+	Token                []byte                `json:"token"`
+	Operations           json.RawMessage       `json:"operations"`
+	DatastoreAddr        string                `json:"datastoreAddr"`
+	Conversions          postgres.Conversions  `json:"conversions"`
+	Source               string                `json:"source"`
+	Compression          string                `json:"compression"`
+	TimestampPolicy      string                `json:"timestampPolicy,omitempty"`
+	PolicyID             string                `json:"policyId,omitempty"`
+	Labels               postgres.Labels       `json:"labels,omitempty"`
+	DatastoreTimeout     string                `json:"datastoreTimeout,omitempty"`
+	Schema               string                `json:"schema,omitempty"`
+	Dispositions         postgres.Dispositions `json:"dispositions,omitempty"`
+	Privacy              string                `json:"privacy,omitempty"`
+	GeoPrivacy           string                `json:"geoPrivacy,omitempty"`
+	RateLimit            string                `json:"rateLimit,omitempty"`
+	Sampling             string                `json:"sampling,omitempty"`
+	Schedule             string                `json:"schedule,omitempty"`
+	Geofence             string                `json:"geofence,omitempty"`
+	PublicKeyFingerprint string                `json:"public_key_fingerprint,omitempty"`
+	Archive              bool                  `json:"archive,omitempty"`
+	Priority             string                `json:"priority,omitempty"`
+	Topic                string                `json:"topic,omitempty"`
+	IngestSecret         []byte                `json:"ingestSecret,omitempty"`
+	DeletedAt            *time.Time            `json:"deletedAt,omitempty"`
+}
+
+// CreateStream stores the given stream, upserting its device. As in Postgres a
+// device may only be registered once within a community. Returns the stream
+// with its id and token set.
+func (d *DB) CreateStream(stream *postgres.Stream) (*postgres.Stream, error) {
+	streamID, err := uuid.NewRandom()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate stream UUID")
+	}
+
+	token, err := postgres.GenerateToken(postgres.TokenLength)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate random token")
+	}
+
+	err = d.insertStream(stream, streamID.String(), secret.Secret(token))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create stream")
+	}
+
+	stream.StreamID = streamID.String()
+	stream.Token = secret.Secret(token)
+
+	return stream, nil
+}
+
+// ImportStream stores a stream previously returned by ExportStreams, keeping
+// its id, token and ingest secret, and upserting its device. As with Postgres
+// we return postgres.ErrStreamExists if a stream with the same id exists, or
+// postgres.ErrStreamConflict if the device is already registered within the
+// stream's community.
+func (d *DB) ImportStream(stream *postgres.Stream) (*postgres.Stream, error) {
+	err := d.insertStream(stream, stream.StreamID, stream.Token)
+	if err != nil {
+		if err == postgres.ErrStreamExists || err == postgres.ErrStreamConflict {
+			return nil, err
+		}
+		return nil, errors.Wrap(err, "failed to import stream")
+	}
+
+	return stream, nil
+}
+
+// ExportStreams calls fn with every live stream in creation order, along with
+// the device feeding it. Unlike ListStreams the stream's token and ingest
+// secret are decrypted and returned, so that the stream may be recreated
+// exactly by ImportStream.
+func (d *DB) ExportStreams(fn func(stream *postgres.Stream) error) error {
+	streams, err := d.selectStreams(func(record *streamRecord) bool {
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	return d.DB.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(streamsBucket)
+
+		for _, stream := range streams {
+			v := bucket.Get([]byte(stream.StreamID))
+			if v == nil {
+				continue
+			}
+
+			var record streamRecord
+
+			err := json.Unmarshal(v, &record)
+			if err != nil {
+				return errors.Wrap(err, "failed to read stream")
+			}
+
+			token, err := d.open(record.Token)
+			if err != nil {
+				return errors.Wrap(err, "failed to decrypt token")
+			}
+
+			stream.Token = secret.Secret(token)
+
+			if record.IngestSecret != nil {
+				ingestSecret, err := d.open(record.IngestSecret)
+				if err != nil {
+					return errors.Wrap(err, "failed to decrypt ingest secret")
+				}
+
+				stream.IngestSecret = secret.Secret(ingestSecret)
+			}
+
+			err = fn(stream)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// insertStream stores the given stream with the given id and token, upserting
+// its device. Returns postgres.ErrStreamExists if a stream with the id exists,
+// or postgres.ErrStreamConflict if the device is already registered within the
+// stream's community.
+func (d *DB) insertStream(stream *postgres.Stream, streamID string, token secret.Secret) error {
+	operations, err := stream.Operations.Value()
+	if err != nil {
+		return err
+	}
+
+	sealed, err := d.seal(token.Reveal())
+	if err != nil {
+		return errors.Wrap(err, "failed to encrypt token")
+	}
+
+	var ingestSecret []byte
+
+	if stream.IngestSecret != "" {
+		ingestSecret, err = d.seal(stream.IngestSecret.Reveal())
+		if err != nil {
+			return errors.Wrap(err, "failed to encrypt ingest secret")
+		}
+	}
+
+	deviceToken := stream.Device.DeviceToken.Reveal()
+
+	return d.DB.Update(func(tx *bolt.Tx) error {
+		devices := tx.Bucket(devicesBucket)
+		streams := tx.Bucket(streamsBucket)
+
+		if streams.Get([]byte(streamID)) != nil {
+			return postgres.ErrStreamExists
+		}
+
+		conflict, err := d.registered(streams, deviceToken, stream.CommunityID)
+		if err != nil {
+			return err
+		}
+
+		if conflict {
+			return postgres.ErrStreamConflict
+		}
+
+		var device deviceRecord
+
+		if v := devices.Get([]byte(deviceToken)); v != nil {
+			err = json.Unmarshal(v, &device)
+			if err != nil {
+				return errors.Wrap(err, "failed to read device")
+			}
+		} else {
+			id, err := devices.NextSequence()
+			if err != nil {
+				return errors.Wrap(err, "failed to allocate device id")
+			}
+
+			device.ID = int(id)
+		}
+
+		device.Label = stream.Device.Label
+		device.Longitude = stream.Device.Longitude
+		device.Latitude = stream.Device.Latitude
+		device.Exposure = stream.Device.Exposure
+
+		err = put(devices, []byte(deviceToken), &device)
+		if err != nil {
+			return errors.Wrap(err, "failed to save device")
+		}
+
+		stream.Device.ID = device.ID
+
+		seq, err := streams.NextSequence()
+		if err != nil {
+			return errors.Wrap(err, "failed to allocate stream sequence")
+		}
+
+		return put(streams, []byte(streamID), &streamRecord{
+			Seq:                  seq,
+			DeviceToken:          deviceToken,
+			CommunityID:          stream.CommunityID,
+			PublicKey:            stream.PublicKey,
+			Token:                sealed,
+			Operations:           operations.([]byte),
+			DatastoreAddr:        stream.DatastoreAddr,
+			Conversions:          stream.Conversions,
+			Source:               stream.Source,
+			Compression:          stream.Compression,
+			TimestampPolicy:      stream.TimestampPolicy,
+			PolicyID:             stream.PolicyID,
+			Labels:               stream.Labels,
+			DatastoreTimeout:     stream.DatastoreTimeout,
+			Schema:               stream.Schema,
+			Dispositions:         stream.Dispositions,
+			Privacy:              stream.Privacy,
+			GeoPrivacy:           stream.GeoPrivacy,
+			RateLimit:            stream.RateLimit,
+			Sampling:             stream.Sampling,
+			Schedule:             stream.Schedule,
+			Geofence:             stream.Geofence,
+			PublicKeyFingerprint: stream.PublicKeyFingerprint,
+			Archive:              stream.Archive,
+			Priority:             stream.Priority,
+			Topic:                stream.Topic,
+			IngestSecret:         ingestSecret,
+		})
+	})
+}
+
+// DeleteStream soft deletes the stream matching the given stream's id and
+// token, so that it may be restored until it is purged. If this was the last
+// live stream for the device, the device is returned.
+func (d *DB) DeleteStream(stream *postgres.Stream) (*postgres.Device, error) {
+	var device *postgres.Device
+
+	err := d.DB.Update(func(tx *bolt.Tx) error {
+		streams := tx.Bucket(streamsBucket)
+
+		record, err := d.findStream(streams, stream.StreamID, stream.Token.Reveal(), false)
+		if err != nil {
+			return err
+		}
+
+		if record == nil {
+			return sql.ErrNoRows
+		}
+
+		now := d.clock.Now()
+		record.DeletedAt = &now
+
+		err = put(streams, []byte(stream.StreamID), record)
+		if err != nil {
+			return err
+		}
+
+		live, err := d.live(streams, record.DeviceToken)
+		if err != nil || live {
+			return err
+		}
+
+		r, err := getDevice(tx.Bucket(devicesBucket), record.DeviceToken)
+		if err != nil {
+			return err
+		}
+
+		device = &postgres.Device{
+			ID:          r.ID,
+			DeviceToken: secret.Secret(record.DeviceToken),
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to delete stream")
+	}
+
+	return device, nil
+}
+
+// RestoreStream restores a stream previously soft deleted by DeleteStream. As
+// with Postgres we return postgres.ErrStreamNotFound if there is no such
+// deleted stream or the token does not match, or postgres.ErrStreamConflict
+// if the device has since been registered again within the stream's
+// community.
+func (d *DB) RestoreStream(streamID, token string) (*postgres.Stream, error) {
+	var stream *postgres.Stream
+
+	err := d.DB.Update(func(tx *bolt.Tx) error {
+		streams := tx.Bucket(streamsBucket)
+
+		record, err := d.findStream(streams, streamID, token, true)
+		if err != nil {
+			return err
+		}
+
+		if record == nil {
+			return postgres.ErrStreamNotFound
+		}
+
+		conflict, err := d.registered(streams, record.DeviceToken, record.CommunityID)
+		if err != nil {
+			return err
+		}
+
+		if conflict {
+			return postgres.ErrStreamConflict
+		}
+
+		record.DeletedAt = nil
+
+		err = put(streams, []byte(streamID), record)
+		if err != nil {
+			return err
+		}
+
+		device, err := getDevice(tx.Bucket(devicesBucket), record.DeviceToken)
+		if err != nil {
+			return err
+		}
+
+		stream, err = toStream(streamID, record, device)
+
+		return err
+	})
+	if err != nil {
+		if err == postgres.ErrStreamNotFound || err == postgres.ErrStreamConflict {
+			return nil, err
+		}
+		return nil, errors.Wrap(err, "failed to restore stream")
+	}
+
+	stream.Token = secret.Secret(token)
+
+	return stream, nil
+}
+
+// PurgeDeletedStreams permanently deletes all streams soft deleted before the
+// given time, along with their stats and any devices left without streams,
+// returning the number of streams deleted.
+func (d *DB) PurgeDeletedStreams(before time.Time) (int64, error) {
+	var deleted int64
+
+	err := d.DB.Update(func(tx *bolt.Tx) error {
+		streams := tx.Bucket(streamsBucket)
+
+		purged := []string{}
+		retained := map[string]bool{}
+
+		err := forEachStream(streams, func(streamID string, record *streamRecord) error {
+			if record.DeletedAt != nil && record.DeletedAt.Before(before) {
+				purged = append(purged, streamID)
+			} else {
+				retained[record.DeviceToken] = true
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, streamID := range purged {
+			err = streams.Delete([]byte(streamID))
+			if err != nil {
+				return err
+			}
+
+			err = tx.Bucket(statsBucket).Delete([]byte(streamID))
+			if err != nil {
+				return err
+			}
+
+			err = deletePrefix(tx.Bucket(checkpointsBucket), []byte(streamID+"/"))
+			if err != nil {
+				return err
+			}
+
+			err = deletePrefix(tx.Bucket(ledgerBucket), []byte(streamID+"/"))
+			if err != nil {
+				return err
+			}
+		}
+
+		deleted = int64(len(purged))
+
+		devices := tx.Bucket(devicesBucket)
+		orphaned := [][]byte{}
+
+		err = devices.ForEach(func(k, v []byte) error {
+			if !retained[string(k)] {
+				orphaned = append(orphaned, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, k := range orphaned {
+			err = devices.Delete(k)
+			if err != nil {
+				return err
+			}
+
+			err = tx.Bucket(statusBucket).Delete(k)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to purge deleted streams")
+	}
+
+	return deleted, nil
+}
+
+// GetDevices returns all devices with live streams, ordered by id. As with
+// Postgres we do not load their streams.
+func (d *DB) GetDevices() ([]*postgres.Device, error) {
+	devices := []*postgres.Device{}
+
+	err := d.DB.View(func(tx *bolt.Tx) error {
+		live := map[string]bool{}
+
+		err := forEachStream(tx.Bucket(streamsBucket), func(streamID string, record *streamRecord) error {
+			if record.DeletedAt == nil {
+				live[record.DeviceToken] = true
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		return tx.Bucket(devicesBucket).ForEach(func(k, v []byte) error {
+			if !live[string(k)] {
+				return nil
+			}
+
+			var device deviceRecord
+
+			err := json.Unmarshal(v, &device)
+			if err != nil {
+				return err
+			}
+
+			devices = append(devices, &postgres.Device{
+				ID:          device.ID,
+				DeviceToken: secret.Secret(k),
+			})
+
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load devices")
+	}
+
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].ID < devices[j].ID
+	})
+
+	return devices, nil
+}
+
+// GetDevice returns the device with the given token along with all of its live
+// streams, including their ingest secrets. A device whose streams have all
+// been deleted is treated as if it did not exist.
+func (d *DB) GetDevice(deviceToken secret.Secret) (*postgres.Device, error) {
+	var device *postgres.Device
+
+	err := d.DB.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(devicesBucket).Get([]byte(deviceToken.Reveal()))
+		if v == nil {
+			return sql.ErrNoRows
+		}
+
+		var record deviceRecord
+
+		err := json.Unmarshal(v, &record)
+		if err != nil {
+			return err
+		}
+
+		device = &postgres.Device{
+			ID:          record.ID,
+			DeviceToken: deviceToken,
+			Label:       record.Label,
+			Longitude:   record.Longitude,
+			Latitude:    record.Latitude,
+			Exposure:    record.Exposure,
+			Streams:     []*postgres.Stream{},
+		}
+
+		err = forEachStream(tx.Bucket(streamsBucket), func(streamID string, s *streamRecord) error {
+			if s.DeviceToken != deviceToken.Reveal() || s.DeletedAt != nil {
+				return nil
+			}
+
+			stream, err := toStream(streamID, s, nil)
+			if err != nil {
+				return err
+			}
+
+			if s.IngestSecret != nil {
+				ingestSecret, err := d.open(s.IngestSecret)
+				if err != nil {
+					return err
+				}
+
+				stream.IngestSecret = secret.Secret(ingestSecret)
+			}
+
+			device.Streams = append(device.Streams, stream)
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if len(device.Streams) == 0 {
+			return sql.ErrNoRows
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load device")
+	}
+
+	return device, nil
+}
+
+// ListStreams returns all live streams in creation order along with the device
+// feeding each stream. Stream tokens are not returned.
+func (d *DB) ListStreams() ([]*postgres.Stream, error) {
+	return d.selectStreams(func(record *streamRecord) bool {
+		return true
+	})
+}
+
+// ListDeviceStreams returns the live streams fed by the device with the given
+// token in creation order, along with the device. Stream tokens are not
+// returned.
+func (d *DB) ListDeviceStreams(deviceToken secret.Secret) ([]*postgres.Stream, error) {
+	return d.selectStreams(func(record *streamRecord) bool {
+		return record.DeviceToken == deviceToken.Reveal()
+	})
+}
+
+// selectStreams returns the live streams which also match the given filter,
+// in creation order.
+func (d *DB) selectStreams(filter func(record *streamRecord) bool) ([]*postgres.Stream, error) {
+	streams := []*postgres.Stream{}
+	seqs := map[*postgres.Stream]uint64{}
+
+	err := d.DB.View(func(tx *bolt.Tx) error {
+		devices := tx.Bucket(devicesBucket)
+
+		return forEachStream(tx.Bucket(streamsBucket), func(streamID string, record *streamRecord) error {
+			if record.DeletedAt != nil || !filter(record) {
+				return nil
+			}
+
+			device, err := getDevice(devices, record.DeviceToken)
+			if err != nil {
+				return err
+			}
+
+			stream, err := toStream(streamID, record, device)
+			if err != nil {
+				return err
+			}
+
+			streams = append(streams, stream)
+			seqs[stream] = record.Seq
+
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load streams")
+	}
+
+	sort.Slice(streams, func(i, j int) bool {
+		return seqs[streams[i]] < seqs[streams[j]]
+	})
+
+	return streams, nil
+}
+
+// GetStream returns a single live stream identified by its id, along with the
+// device that feeds it. The stream's token must also be supplied, and if it
+// does not match we return postgres.ErrStreamNotFound exactly as if the stream
+// did not exist.
+func (d *DB) GetStream(streamID, token string) (*postgres.Stream, error) {
+	var stream *postgres.Stream
+
+	err := d.DB.View(func(tx *bolt.Tx) error {
+		record, err := d.findStream(tx.Bucket(streamsBucket), streamID, token, false)
+		if err != nil {
+			return err
+		}
+
+		if record == nil {
+			return postgres.ErrStreamNotFound
+		}
+
+		device, err := getDevice(tx.Bucket(devicesBucket), record.DeviceToken)
+		if err != nil {
+			return err
+		}
+
+		stream, err = toStream(streamID, record, device)
+
+		return err
+	})
+	if err != nil {
+		if err == postgres.ErrStreamNotFound {
+			return nil, err
+		}
+		return nil, errors.Wrap(err, "failed to load stream")
+	}
+
+	stream.Token = secret.Secret(token)
+
+	return stream, nil
+}
+
+// SetConversions replaces the conversion expressions of the live stream
+// identified by its id. As with GetStream the stream's token must be supplied,
+// and if it does not match we return postgres.ErrStreamNotFound.
+func (d *DB) SetConversions(streamID, token string, conversions postgres.Conversions) error {
+	return d.updateStream(streamID, token, "failed to update stream conversions", func(record *streamRecord) error {
+		record.Conversions = conversions
+		return nil
+	})
+}
+
+// SetIngestSecret replaces the shared secret used to verify payloads pushed
+// over HTTP for the live stream identified by its id. The secret is encrypted
+// at rest in the same way as the stream's token. As with GetStream the
+// stream's token must be supplied, and if it does not match we return
+// postgres.ErrStreamNotFound.
+func (d *DB) SetIngestSecret(streamID, token string, ingestSecret secret.Secret) error {
+	return d.updateStream(streamID, token, "failed to update stream ingest secret", func(record *streamRecord) error {
+		sealed, err := d.seal(ingestSecret.Reveal())
+		if err != nil {
+			return err
+		}
+
+		record.IngestSecret = sealed
+
+		return nil
+	})
+}
+
+// SetPolicyPublicKey replaces the public key of every live stream created from
+// the policy with the given id whose key differs, returning the number of
+// streams updated. It is used to apply keys rotated in the policy store.
+func (d *DB) SetPolicyPublicKey(policyID, publicKey string) (int64, error) {
+	var updated int64
+
+	err := d.DB.Update(func(tx *bolt.Tx) error {
+		streams := tx.Bucket(streamsBucket)
+
+		changed := map[string]*streamRecord{}
+
+		err := forEachStream(streams, func(streamID string, record *streamRecord) error {
+			if record.PolicyID == policyID && record.PublicKey != publicKey && record.DeletedAt == nil {
+				record.PublicKey = publicKey
+				record.PublicKeyFingerprint = pubkey.Fingerprint(publicKey)
+				changed[streamID] = record
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for streamID, record := range changed {
+			err = put(streams, []byte(streamID), record)
+			if err != nil {
+				return err
+			}
+		}
+
+		updated = int64(len(changed))
+
+		return nil
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to update policy public key")
+	}
+
+	return updated, nil
+}
+
+// updateStream applies the given change to the live stream with the given id
+// and token, returning postgres.ErrStreamNotFound if there is no such stream,
+// or any other error wrapped with msg.
+func (d *DB) updateStream(streamID, token, msg string, change func(record *streamRecord) error) error {
+	err := d.DB.Update(func(tx *bolt.Tx) error {
+		streams := tx.Bucket(streamsBucket)
+
+		record, err := d.findStream(streams, streamID, token, false)
+		if err != nil {
+			return err
+		}
+
+		if record == nil {
+			return postgres.ErrStreamNotFound
+		}
+
+		err = change(record)
+		if err != nil {
+			return err
+		}
+
+		return put(streams, []byte(streamID), record)
+	})
+	if err != nil {
+		if err == postgres.ErrStreamNotFound {
+			return err
+		}
+		return errors.Wrap(err, msg)
+	}
+
+	return nil
+}
+
+// findStream returns the stream with the given id if its token matches, and it
+// is deleted or live as requested, or otherwise nil.
+func (d *DB) findStream(streams *bolt.Bucket, streamID, token string, deleted bool) (*streamRecord, error) {
+	v := streams.Get([]byte(streamID))
+	if v == nil {
+		return nil, nil
+	}
+
+	var record streamRecord
+
+	err := json.Unmarshal(v, &record)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read stream")
+	}
+
+	if (record.DeletedAt != nil) != deleted {
+		return nil, nil
+	}
+
+	t, err := d.open(record.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	if t != token {
+		return nil, nil
+	}
+
+	return &record, nil
+}
+
+// registered returns true if the device has a live stream within the given
+// community.
+func (d *DB) registered(streams *bolt.Bucket, deviceToken, communityID string) (bool, error) {
+	registered := false
+
+	err := forEachStream(streams, func(streamID string, record *streamRecord) error {
+		if record.DeviceToken == deviceToken && record.CommunityID == communityID && record.DeletedAt == nil {
+			registered = true
+		}
+		return nil
+	})
+
+	return registered, err
+}
+
+// live returns true if the device has any live streams.
+func (d *DB) live(streams *bolt.Bucket, deviceToken string) (bool, error) {
+	live := false
+
+	err := forEachStream(streams, func(streamID string, record *streamRecord) error {
+		if record.DeviceToken == deviceToken && record.DeletedAt == nil {
+			live = true
+		}
+		return nil
+	})
+
+	return live, err
+}
+
+// forEachStream calls fn with each stored stream, including deleted streams.
+// As with Postgres the number of streams is small, so we don't maintain
+// indexes, instead scanning every stream.
+func forEachStream(streams *bolt.Bucket, fn func(streamID string, record *streamRecord) error) error {
+	return streams.ForEach(func(k, v []byte) error {
+		var record streamRecord
+
+		err := json.Unmarshal(v, &record)
+		if err != nil {
+			return errors.Wrap(err, "failed to read stream")
+		}
+
+		return fn(string(k), &record)
+	})
+}
+
+// getDevice returns the device with the given token.
+func getDevice(devices *bolt.Bucket, deviceToken string) (*deviceRecord, error) {
+	v := devices.Get([]byte(deviceToken))
+	if v == nil {
+		return nil, errors.New("stream has no device")
+	}
+
+	var record deviceRecord
+
+	err := json.Unmarshal(v, &record)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read device")
+	}
+
+	return &record, nil
+}
+
+// toStream converts the stored stream into a Stream, without its token. If a
+// device is given the stream is returned with an associated Device, as
+// returned by the methods of postgres.DB.
+func toStream(streamID string, record *streamRecord, device *deviceRecord) (*postgres.Stream, error) {
+	var operations postgres.Operations
+
+	err := operations.Scan([]byte(record.Operations))
+	if err != nil {
+		return nil, err
+	}
+
+	conversions := record.Conversions
+	if conversions == nil {
+		conversions = postgres.Conversions{}
+	}
+
+	stream := &postgres.Stream{
+		StreamID:             streamID,
+		CommunityID:          record.CommunityID,
+		PublicKey:            record.PublicKey,
+		Operations:           operations,
+		DatastoreAddr:        record.DatastoreAddr,
+		Conversions:          conversions,
+		Source:               record.Source,
+		Compression:          record.Compression,
+		TimestampPolicy:      record.TimestampPolicy,
+		PolicyID:             record.PolicyID,
+		Labels:               record.Labels,
+		DatastoreTimeout:     record.DatastoreTimeout,
+		Schema:               record.Schema,
+		Dispositions:         record.Dispositions,
+		Privacy:              record.Privacy,
+		GeoPrivacy:           record.GeoPrivacy,
+		RateLimit:            record.RateLimit,
+		Sampling:             record.Sampling,
+		Schedule:             record.Schedule,
+		Geofence:             record.Geofence,
+		PublicKeyFingerprint: record.PublicKeyFingerprint,
+		Archive:              record.Archive,
+		Priority:             record.Priority,
+		Topic:                record.Topic,
+	}
+
+	if device != nil {
+		stream.Device = &postgres.Device{
+			ID:          device.ID,
+			DeviceToken: secret.Secret(record.DeviceToken),
+			Label:       device.Label,
+			Longitude:   device.Longitude,
+			Latitude:    device.Latitude,
+			Exposure:    device.Exposure,
+			Streams:     []*postgres.Stream{stream},
+		}
+	}
+
+	return stream, nil
+}
+
+// put stores the given value as JSON under key.
+func put(bucket *bolt.Bucket, key []byte, value interface{}) error {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return bucket.Put(key, b)
+}
+
+// deletePrefix deletes all keys within the bucket with the given prefix.
+func deletePrefix(bucket *bolt.Bucket, prefix []byte) error {
+	keys := [][]byte{}
+
+	c := bucket.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		keys = append(keys, append([]byte(nil), k...))
+	}
+
+	for _, k := range keys {
+		err := bucket.Delete(k)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,281 @@
+// Package cache holds recently loaded devices in memory, so that the device
+// and streams for each incoming message needn't be loaded from Postgres. The
+// cache sits in front of the DB, and every change made to streams through it
+// invalidates the affected device.
+package cache
+
+import (
+	"sync"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+)
+
+var (
+	// LookupsCounter is a prometheus counter vector recording a count of
+	// device lookups, labelled by whether the device was found in the cache,
+	// from which the hit rate may be calculated.
+	LookupsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "device_cache_lookups",
+			Help:      "Count of device lookups, labelled by cache hit or miss",
+		},
+		[]string{"result"},
+	)
+)
+
+// Store is the interface to the storage in front of which we cache devices.
+// Methods which change streams are passed through, invalidating any cached
+// device they affect, while the rest are passed through untouched so that a
+// Cache may be used in place of the Store. It is satisfied by the postgres.DB
+// type.
+type Store interface {
+	GetDevice(deviceToken secret.Secret) (*postgres.Device, error)
+	GetDevices() ([]*postgres.Device, error)
+	GetStream(streamID, token string) (*postgres.Stream, error)
+	CreateStream(stream *postgres.Stream) (*postgres.Stream, error)
+	DeleteStream(stream *postgres.Stream) (*postgres.Device, error)
+	RestoreStream(streamID, token string) (*postgres.Stream, error)
+	ImportStream(stream *postgres.Stream) (*postgres.Stream, error)
+	SetConversions(streamID, token string, conversions postgres.Conversions) error
+	SetIngestSecret(streamID, token string, ingestSecret secret.Secret) error
+	SetPolicyPublicKey(policyID, publicKey string) (int64, error)
+}
+
+// Config is used to pass in dependencies and configuration when creating a
+// Cache. TTL is the duration for which a device is cached, which bounds how
+// long changes to streams made by other encoder instances may go unnoticed.
+type Config struct {
+	Store Store
+	TTL   time.Duration
+	Clock clock.Clock
+}
+
+// entry is a cached device and the time at which it expires.
+type entry struct {
+	device    *postgres.Device
+	expiresAt time.Time
+}
+
+// Cache is a read-through cache of devices keyed by device token.
+type Cache struct {
+	store  Store
+	ttl    time.Duration
+	clock  clock.Clock
+	logger kitlog.Logger
+
+	sync.RWMutex
+	devices map[secret.Secret]*entry
+
+	// streams maps the id of each stream of a cached device to the device's
+	// token, so that changes made to a stream by id invalidate its device
+	streams map[string]secret.Secret
+
+	// generation is incremented by every invalidation, so that a device loaded
+	// while streams were being changed isn't cached
+	generation uint64
+}
+
+// NewCache returns a new empty Cache configured by the given Config.
+func NewCache(config *Config, logger kitlog.Logger) *Cache {
+	logger = kitlog.With(logger, "module", "cache")
+
+	logger.Log("msg", "creating device cache", "ttl", config.TTL)
+
+	return &Cache{
+		store:   config.Store,
+		ttl:     config.TTL,
+		clock:   config.Clock,
+		logger:  logger,
+		devices: make(map[secret.Secret]*entry),
+		streams: make(map[string]secret.Secret),
+	}
+}
+
+// GetDevice returns the device with the given token along with its streams,
+// from the cache if it was loaded within our TTL, or otherwise from the Store
+// in which case it is cached. Errors are not cached. Callers receive their
+// own copy of the device and its list of streams, but the streams themselves
+// are shared and must not be modified.
+func (c *Cache) GetDevice(deviceToken secret.Secret) (*postgres.Device, error) {
+	now := c.clock.Now()
+
+	c.RLock()
+	e, ok := c.devices[deviceToken]
+	generation := c.generation
+	c.RUnlock()
+
+	if ok && now.Before(e.expiresAt) {
+		LookupsCounter.With(prometheus.Labels{"result": "hit"}).Inc()
+		return copyDevice(e.device), nil
+	}
+
+	LookupsCounter.With(prometheus.Labels{"result": "miss"}).Inc()
+
+	device, err := c.store.GetDevice(deviceToken)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if c.generation == generation {
+		c.remove(deviceToken)
+
+		c.devices[deviceToken] = &entry{
+			device:    copyDevice(device),
+			expiresAt: now.Add(c.ttl),
+		}
+
+		for _, s := range device.Streams {
+			c.streams[s.StreamID] = deviceToken
+		}
+	}
+
+	return device, nil
+}
+
+// GetDevices passes through to the Store.
+func (c *Cache) GetDevices() ([]*postgres.Device, error) {
+	return c.store.GetDevices()
+}
+
+// GetStream passes through to the Store.
+func (c *Cache) GetStream(streamID, token string) (*postgres.Stream, error) {
+	return c.store.GetStream(streamID, token)
+}
+
+// CreateStream creates the stream in the Store, invalidating its device.
+func (c *Cache) CreateStream(stream *postgres.Stream) (*postgres.Stream, error) {
+	defer c.invalidate(stream.Device.DeviceToken, "")
+
+	return c.store.CreateStream(stream)
+}
+
+// DeleteStream deletes the stream from the Store, invalidating its device.
+func (c *Cache) DeleteStream(stream *postgres.Stream) (device *postgres.Device, err error) {
+	defer func() {
+		var deviceToken secret.Secret
+		if device != nil {
+			deviceToken = device.DeviceToken
+		}
+
+		c.invalidate(deviceToken, stream.StreamID)
+	}()
+
+	return c.store.DeleteStream(stream)
+}
+
+// RestoreStream restores the stream in the Store, invalidating its device.
+func (c *Cache) RestoreStream(streamID, token string) (stream *postgres.Stream, err error) {
+	defer func() {
+		var deviceToken secret.Secret
+		if stream != nil && stream.Device != nil {
+			deviceToken = stream.Device.DeviceToken
+		}
+
+		c.invalidate(deviceToken, streamID)
+	}()
+
+	return c.store.RestoreStream(streamID, token)
+}
+
+// ImportStream imports the stream into the Store, invalidating its device.
+func (c *Cache) ImportStream(stream *postgres.Stream) (*postgres.Stream, error) {
+	defer c.invalidate(stream.Device.DeviceToken, "")
+
+	return c.store.ImportStream(stream)
+}
+
+// SetConversions sets the conversions of the stream in the Store, invalidating
+// its device.
+func (c *Cache) SetConversions(streamID, token string, conversions postgres.Conversions) error {
+	defer c.invalidate("", streamID)
+
+	return c.store.SetConversions(streamID, token, conversions)
+}
+
+// SetIngestSecret sets the ingest secret of the stream in the Store,
+// invalidating its device.
+func (c *Cache) SetIngestSecret(streamID, token string, ingestSecret secret.Secret) error {
+	defer c.invalidate("", streamID)
+
+	return c.store.SetIngestSecret(streamID, token, ingestSecret)
+}
+
+// SetPolicyPublicKey sets the public key of the streams created from the policy
+// in the Store. As we don't index cached streams by policy every cached device
+// is invalidated, which is cheap as keys are rarely rotated.
+func (c *Cache) SetPolicyPublicKey(policyID, publicKey string) (int64, error) {
+	defer c.InvalidateAll()
+
+	return c.store.SetPolicyPublicKey(policyID, publicKey)
+}
+
+// invalidate removes the device with the given token, and the device of the
+// stream with the given id, from the cache. Either may be empty. We invalidate
+// even if the change failed, as we can't know whether it was partially made.
+func (c *Cache) invalidate(deviceToken secret.Secret, streamID string) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.generation++
+
+	if deviceToken != "" {
+		c.remove(deviceToken)
+	}
+
+	if t, ok := c.streams[streamID]; ok && streamID != "" {
+		c.remove(t)
+	}
+}
+
+// Invalidate removes the device with the given token from the cache, so that
+// changes made to its streams other than through the cache, such as directly
+// in the database, are picked up when it is next loaded.
+func (c *Cache) Invalidate(deviceToken secret.Secret) {
+	c.invalidate(deviceToken, "")
+}
+
+// InvalidateAll removes every device from the cache.
+func (c *Cache) InvalidateAll() {
+	c.Lock()
+	defer c.Unlock()
+
+	c.generation++
+
+	c.devices = make(map[secret.Secret]*entry)
+	c.streams = make(map[string]secret.Secret)
+}
+
+// remove discards the cached device with the given token, and its entries in
+// our index of streams. Callers must hold the lock.
+func (c *Cache) remove(deviceToken secret.Secret) {
+	e, ok := c.devices[deviceToken]
+	if !ok {
+		return
+	}
+
+	for _, s := range e.device.Streams {
+		delete(c.streams, s.StreamID)
+	}
+
+	delete(c.devices, deviceToken)
+}
+
+// copyDevice returns a shallow copy of the given device, with its own copy of
+// the list of streams.
+func copyDevice(device *postgres.Device) *postgres.Device {
+	d := *device
+	d.Streams = append([]*postgres.Stream{}, device.Streams...)
+
+	return &d
+}
@@ -0,0 +1,171 @@
+package cache_test
+
+import (
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/cache"
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/postgres/postgrestest"
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+)
+
+// countingStore is an in-memory store counting the devices loaded from it.
+type countingStore struct {
+	*postgrestest.DB
+	loads int
+}
+
+func (c *countingStore) GetDevice(deviceToken secret.Secret) (*postgres.Device, error) {
+	c.loads++
+	return c.DB.GetDevice(deviceToken)
+}
+
+func newStream(communityID string) *postgres.Stream {
+	return &postgres.Stream{
+		PublicKey:   "abc123",
+		CommunityID: communityID,
+		Device: &postgres.Device{
+			DeviceToken: "device",
+			Longitude:   23,
+			Latitude:    23.2,
+			Exposure:    "indoor",
+		},
+	}
+}
+
+func newCache(cl clock.Clock) (*cache.Cache, *countingStore) {
+	store := &countingStore{DB: postgrestest.NewDB()}
+
+	c := cache.NewCache(&cache.Config{
+		Store: store,
+		TTL:   time.Minute,
+		Clock: cl,
+	}, kitlog.NewNopLogger())
+
+	return c, store
+}
+
+func TestGetDevice(t *testing.T) {
+	cl := clock.NewMock(time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC))
+	c, store := newCache(cl)
+
+	_, err := c.GetDevice("device")
+	assert.NotNil(t, err)
+
+	_, err = store.CreateStream(newStream("community-1"))
+	assert.Nil(t, err)
+
+	// errors aren't cached
+	device, err := c.GetDevice("device")
+	assert.Nil(t, err)
+	assert.Len(t, device.Streams, 1)
+	assert.Equal(t, 2, store.loads)
+
+	device, err = c.GetDevice("device")
+	assert.Nil(t, err)
+	assert.Len(t, device.Streams, 1)
+	assert.Equal(t, 2, store.loads)
+
+	// callers can't modify the cached device
+	device.Streams = nil
+
+	cl.Add(59 * time.Second)
+	device, err = c.GetDevice("device")
+	assert.Nil(t, err)
+	assert.Len(t, device.Streams, 1)
+	assert.Equal(t, 2, store.loads)
+
+	cl.Add(time.Second)
+	_, err = c.GetDevice("device")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, store.loads)
+}
+
+func TestInvalidation(t *testing.T) {
+	cl := clock.NewMock(time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC))
+	c, store := newCache(cl)
+
+	stream, err := c.CreateStream(newStream("community-1"))
+	assert.Nil(t, err)
+
+	device, err := c.GetDevice("device")
+	assert.Nil(t, err)
+	assert.Len(t, device.Streams, 1)
+
+	_, err = c.CreateStream(newStream("community-2"))
+	assert.Nil(t, err)
+
+	device, err = c.GetDevice("device")
+	assert.Nil(t, err)
+	assert.Len(t, device.Streams, 2)
+	assert.Equal(t, 2, store.loads)
+
+	// changes made by stream id invalidate the stream's device
+	err = c.SetConversions(stream.StreamID, stream.Token.Reveal(), postgres.Conversions{12: "x * 2"})
+	assert.Nil(t, err)
+
+	device, err = c.GetDevice("device")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, store.loads)
+
+	for _, s := range device.Streams {
+		if s.StreamID == stream.StreamID {
+			assert.Equal(t, "x * 2", s.Conversions[12])
+		}
+	}
+
+	_, err = c.DeleteStream(stream)
+	assert.Nil(t, err)
+
+	device, err = c.GetDevice("device")
+	assert.Nil(t, err)
+	assert.Len(t, device.Streams, 1)
+	assert.Equal(t, 4, store.loads)
+
+	_, err = c.RestoreStream(stream.StreamID, stream.Token.Reveal())
+	assert.Nil(t, err)
+
+	device, err = c.GetDevice("device")
+	assert.Nil(t, err)
+	assert.Len(t, device.Streams, 2)
+	assert.Equal(t, 5, store.loads)
+}
+
+func TestInvalidate(t *testing.T) {
+	cl := clock.NewMock(time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC))
+	c, store := newCache(cl)
+
+	_, err := store.CreateStream(newStream("community-1"))
+	assert.Nil(t, err)
+
+	_, err = c.GetDevice("device")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, store.loads)
+
+	// a change made other than through the cache goes unnoticed until the
+	// device is invalidated
+	_, err = store.CreateStream(newStream("community-2"))
+	assert.Nil(t, err)
+
+	device, err := c.GetDevice("device")
+	assert.Nil(t, err)
+	assert.Len(t, device.Streams, 1)
+
+	c.Invalidate("device")
+
+	device, err = c.GetDevice("device")
+	assert.Nil(t, err)
+	assert.Len(t, device.Streams, 2)
+	assert.Equal(t, 2, store.loads)
+
+	c.InvalidateAll()
+
+	_, err = c.GetDevice("device")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, store.loads)
+}
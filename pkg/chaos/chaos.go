@@ -0,0 +1,189 @@
+// Package chaos injects faults into our calls to external dependencies, so
+// that the retry, backoff and failover behaviour of the encoder can be
+// exercised in staging without breaking the dependencies themselves. Faults
+// are only injected when enabled by configuration, each at its own
+// probability: writes to the datastore may fail as if it responded 503
+// Service Unavailable, our connection to an MQTT broker may be dropped as a
+// message is received, and zenroom executions may be delayed.
+package chaos
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	datastore "github.com/thingful/twirp-datastore-go"
+	"github.com/twitchtv/twirp"
+)
+
+const (
+	// DatastoreUnavailable is the fault of a datastore write failing as if the
+	// datastore was unavailable.
+	DatastoreUnavailable = "datastore_unavailable"
+
+	// MQTTDisconnect is the fault of our connection to an MQTT broker being
+	// lost.
+	MQTTDisconnect = "mqtt_disconnect"
+
+	// ZenroomDelay is the fault of a zenroom execution being delayed.
+	ZenroomDelay = "zenroom_delay"
+)
+
+var (
+	// FaultsCounter is a prometheus counter vec recording the number of faults
+	// injected, labelled by the fault.
+	FaultsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "chaos_faults",
+			Help:      "Count of faults injected into calls to dependencies",
+		},
+		[]string{"fault"},
+	)
+)
+
+// Datastore is the interface of the datastore clients into whose writes we
+// inject failures. It is satisfied by the twirp datastore client.
+type Datastore interface {
+	WriteData(ctx context.Context, req *datastore.WriteRequest) (*datastore.WriteResponse, error)
+	ReadData(ctx context.Context, req *datastore.ReadRequest) (*datastore.ReadResponse, error)
+}
+
+// ExecFunc is the signature of the functions executing zenroom scripts whose
+// executions we delay, matching pipeline.ExecFunc.
+type ExecFunc func(script, keys, data []byte) ([]byte, error)
+
+// Config is used to pass in the probabilities, between 0 and 1, with which
+// each fault is injected. DatastoreErrorRate is that of each datastore write
+// failing, MQTTDisconnectRate that of our connection to a broker being dropped
+// on receiving each message, and ZenroomDelayRate that of each zenroom
+// execution being delayed by ZenroomDelay. Rand is optional, and if nil faults
+// are selected using a source seeded from the current time.
+type Config struct {
+	DatastoreErrorRate float64
+	MQTTDisconnectRate float64
+	ZenroomDelayRate   float64
+	ZenroomDelay       time.Duration
+	Rand               func() float64
+}
+
+// Validate returns an error if any of the configured probabilities is not
+// between 0 and 1.
+func (c *Config) Validate() error {
+	rates := map[string]float64{
+		DatastoreUnavailable: c.DatastoreErrorRate,
+		MQTTDisconnect:       c.MQTTDisconnectRate,
+		ZenroomDelay:         c.ZenroomDelayRate,
+	}
+
+	for fault, rate := range rates {
+		if rate < 0 || rate > 1 {
+			return errors.Errorf("probability of %s must be between 0 and 1: %v", fault, rate)
+		}
+	}
+
+	if c.ZenroomDelay < 0 {
+		return errors.New("zenroom delay must not be negative")
+	}
+
+	return nil
+}
+
+// Enabled returns true if any fault may be injected.
+func (c *Config) Enabled() bool {
+	return c.DatastoreErrorRate > 0 || c.MQTTDisconnectRate > 0 || (c.ZenroomDelayRate > 0 && c.ZenroomDelay > 0)
+}
+
+// Injector decides which calls have faults injected into them.
+type Injector struct {
+	config *Config
+
+	// mu guards rand, as a math/rand source is not safe for concurrent use
+	mu   sync.Mutex
+	rand func() float64
+}
+
+// NewInjector returns a new Injector injecting faults as configured.
+func NewInjector(config *Config) *Injector {
+	r := config.Rand
+	if r == nil {
+		r = rand.New(rand.NewSource(time.Now().UnixNano())).Float64
+	}
+
+	return &Injector{
+		config: config,
+		rand:   r,
+	}
+}
+
+// inject returns true, counting the fault, if the given fault should be
+// injected at the given probability.
+func (i *Injector) inject(fault string, rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+
+	i.mu.Lock()
+	injected := i.rand() < rate
+	i.mu.Unlock()
+
+	if injected {
+		FaultsCounter.WithLabelValues(fault).Inc()
+	}
+
+	return injected
+}
+
+// DropConnection returns true if our connection to an MQTT broker should be
+// dropped as a message is received, satisfying the mqtt.Faults interface.
+func (i *Injector) DropConnection() bool {
+	return i.inject(MQTTDisconnect, i.config.MQTTDisconnectRate)
+}
+
+// Datastore returns a datastore client which fails writes as if the datastore
+// was unavailable, and otherwise passes calls through to the given client.
+func (i *Injector) Datastore(ds Datastore) Datastore {
+	if i.config.DatastoreErrorRate <= 0 {
+		return ds
+	}
+
+	return &faultyDatastore{Datastore: ds, injector: i}
+}
+
+// Exec returns a function which delays executions before passing them through
+// to the given function.
+func (i *Injector) Exec(exec ExecFunc) ExecFunc {
+	if i.config.ZenroomDelayRate <= 0 || i.config.ZenroomDelay <= 0 {
+		return exec
+	}
+
+	return func(script, keys, data []byte) ([]byte, error) {
+		if i.inject(ZenroomDelay, i.config.ZenroomDelayRate) {
+			time.Sleep(i.config.ZenroomDelay)
+		}
+
+		return exec(script, keys, data)
+	}
+}
+
+// faultyDatastore is a datastore client into whose writes failures are
+// injected. Reads are not failed, so that health checks are unaffected.
+type faultyDatastore struct {
+	Datastore
+	injector *Injector
+}
+
+// WriteData returns a twirp Unavailable error, which is the error returned
+// when the datastore responds 503 Service Unavailable, if a failure is
+// injected, and otherwise writes the given request to the datastore.
+func (f *faultyDatastore) WriteData(ctx context.Context, req *datastore.WriteRequest) (*datastore.WriteResponse, error) {
+	if f.injector.inject(DatastoreUnavailable, f.injector.config.DatastoreErrorRate) {
+		return nil, twirp.NewError(twirp.Unavailable, "fault injected")
+	}
+
+	return f.Datastore.WriteData(ctx, req)
+}
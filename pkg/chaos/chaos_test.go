@@ -0,0 +1,102 @@
+package chaos_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	datastore "github.com/thingful/twirp-datastore-go"
+	"github.com/twitchtv/twirp"
+
+	"github.com/DECODEproject/iotencoder/pkg/chaos"
+	"github.com/DECODEproject/iotencoder/pkg/mocks"
+)
+
+// sequence returns a function returning each of the given values in turn.
+func sequence(values ...float64) func() float64 {
+	return func() float64 {
+		v := values[0]
+		values = values[1:]
+		return v
+	}
+}
+
+func TestValidate(t *testing.T) {
+	assert.Nil(t, (&chaos.Config{DatastoreErrorRate: 0.5, ZenroomDelayRate: 1}).Validate())
+	assert.NotNil(t, (&chaos.Config{DatastoreErrorRate: 1.5}).Validate())
+	assert.NotNil(t, (&chaos.Config{MQTTDisconnectRate: -0.1}).Validate())
+	assert.NotNil(t, (&chaos.Config{ZenroomDelay: -time.Second}).Validate())
+
+	assert.False(t, (&chaos.Config{}).Enabled())
+	assert.False(t, (&chaos.Config{ZenroomDelayRate: 0.5}).Enabled())
+	assert.True(t, (&chaos.Config{MQTTDisconnectRate: 0.5}).Enabled())
+}
+
+func TestDatastore(t *testing.T) {
+	ds := &mocks.Datastore{}
+	ds.On("WriteData", mock.Anything, mock.Anything).Return(&datastore.WriteResponse{}, nil)
+
+	injector := chaos.NewInjector(&chaos.Config{
+		DatastoreErrorRate: 0.5,
+		Rand:               sequence(0.4, 0.6),
+	})
+
+	faulty := injector.Datastore(ds)
+
+	_, err := faulty.WriteData(context.Background(), &datastore.WriteRequest{})
+	assert.NotNil(t, err)
+
+	twerr, ok := err.(twirp.Error)
+	if assert.True(t, ok) {
+		assert.Equal(t, twirp.Unavailable, twerr.Code())
+	}
+
+	_, err = faulty.WriteData(context.Background(), &datastore.WriteRequest{})
+	assert.Nil(t, err)
+
+	ds.AssertNumberOfCalls(t, "WriteData", 1)
+}
+
+func TestDisabledFaultsPassThrough(t *testing.T) {
+	ds := &mocks.Datastore{}
+
+	injector := chaos.NewInjector(&chaos.Config{
+		MQTTDisconnectRate: 0.5,
+		Rand:               sequence(0.6, 0.4),
+	})
+
+	assert.Equal(t, ds, injector.Datastore(ds))
+	assert.False(t, injector.DropConnection())
+	assert.True(t, injector.DropConnection())
+}
+
+func TestExec(t *testing.T) {
+	calls := 0
+	exec := func(script, keys, data []byte) ([]byte, error) {
+		calls++
+		return data, nil
+	}
+
+	injector := chaos.NewInjector(&chaos.Config{
+		ZenroomDelayRate: 0.5,
+		ZenroomDelay:     50 * time.Millisecond,
+		Rand:             sequence(0.4, 0.6),
+	})
+
+	delayed := injector.Exec(exec)
+
+	start := time.Now()
+	out, err := delayed(nil, nil, []byte("data"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("data"), out)
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+
+	start = time.Now()
+	_, err = delayed(nil, nil, []byte("data"))
+	assert.Nil(t, err)
+	assert.True(t, time.Since(start) < 50*time.Millisecond)
+
+	assert.Equal(t, 2, calls)
+}
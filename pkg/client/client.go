@@ -0,0 +1,243 @@
+// Package client provides a client of the encoder's Twirp API for other
+// services, wrapping the generated client with the concerns each of them would
+// otherwise implement for itself: authenticating requests, tracing them with a
+// request id, and retrying calls the encoder was momentarily unable to serve,
+// such as while it is in maintenance mode.
+package client
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	encoder "github.com/thingful/twirp-encoder-go"
+	"github.com/twitchtv/twirp"
+
+	"github.com/DECODEproject/iotencoder/pkg/requestid"
+)
+
+const (
+	// DefaultTimeout is the timeout of each attempt at a call if not
+	// configured.
+	DefaultTimeout = 10 * time.Second
+
+	// DefaultRetries is the number of times a call failing with an unavailable
+	// error is retried if not configured.
+	DefaultRetries = 3
+
+	// DefaultBackoff is the delay before the first retry of a call if not
+	// configured, which is doubled before each subsequent retry.
+	DefaultBackoff = 500 * time.Millisecond
+
+	// maxBackoff is the longest we wait before retrying a call.
+	maxBackoff = 30 * time.Second
+)
+
+// HTTPClient is the interface used by the client to send requests. It is
+// satisfied by *http.Client.
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Config is used to pass in configuration when creating a Client.
+type Config struct {
+	// Addr is the base address of the encoder, e.g. http://iotencoder:8081.
+	Addr string
+
+	// Token is optional, and if set is sent as a bearer token in the
+	// Authorization header of every request, for encoders deployed behind an
+	// authenticating proxy or with an authenticating twirp hook.
+	Token string
+
+	// Headers are optional, and are sent with every request in addition to any
+	// set on the context of a call, e.g. the Labels with which every stream the
+	// caller creates is labelled. Headers set on the context take precedence.
+	Headers http.Header
+
+	// JSON selects the JSON encoding of requests rather than protobuf.
+	JSON bool
+
+	// Timeout is the timeout of each attempt at a call, and if zero is
+	// DefaultTimeout. It is ignored if HTTPClient is set.
+	Timeout time.Duration
+
+	// Retries is the number of times a call failing with an unavailable error
+	// is retried, and if zero is DefaultRetries. If negative calls are never
+	// retried.
+	Retries int
+
+	// Backoff is the delay before the first retry of a call, doubled before
+	// each subsequent retry, and if zero is DefaultBackoff.
+	Backoff time.Duration
+
+	// HTTPClient is optional, and if set is the client used to send requests.
+	HTTPClient HTTPClient
+}
+
+// Client is a client of the encoder's Twirp API, and satisfies the
+// encoder.Encoder interface so may be used in place of the generated client.
+// Each call is sent with the request id carried by its context, or a new one
+// if it carries none, which is kept across retries so that every attempt is
+// traced alike in the encoder's logs. Only errors with the unavailable code
+// are retried, which the encoder returns before it has made any change, so
+// that a stream is never created twice.
+type Client struct {
+	client  encoder.Encoder
+	token   string
+	headers http.Header
+	retries int
+	backoff time.Duration
+}
+
+// New returns a new Client configured with the given Config, or an error if
+// no address is given.
+func New(config *Config) (*Client, error) {
+	if config.Addr == "" {
+		return nil, errors.New("must supply the address of the encoder")
+	}
+
+	httpClient := config.HTTPClient
+	if httpClient == nil {
+		timeout := config.Timeout
+		if timeout == 0 {
+			timeout = DefaultTimeout
+		}
+
+		httpClient = &http.Client{Timeout: timeout}
+	}
+
+	addr := strings.TrimSuffix(config.Addr, "/")
+
+	var client encoder.Encoder
+	if config.JSON {
+		client = encoder.NewEncoderJSONClient(addr, httpClient)
+	} else {
+		client = encoder.NewEncoderProtobufClient(addr, httpClient)
+	}
+
+	retries := config.Retries
+	if retries == 0 {
+		retries = DefaultRetries
+	} else if retries < 0 {
+		retries = 0
+	}
+
+	backoff := config.Backoff
+	if backoff == 0 {
+		backoff = DefaultBackoff
+	}
+
+	return &Client{
+		client:  client,
+		token:   config.Token,
+		headers: config.Headers,
+		retries: retries,
+		backoff: backoff,
+	}, nil
+}
+
+// NewFromEnv returns a new Client configured from the environment, with the
+// encoder's address read from IOTENCODER_ENCODER_ADDR, from which our own
+// command line tools also read it, and the optional bearer token from
+// IOTENCODER_ENCODER_TOKEN. Other settings have their defaults.
+func NewFromEnv() (*Client, error) {
+	return New(&Config{
+		Addr:  os.Getenv("IOTENCODER_ENCODER_ADDR"),
+		Token: os.Getenv("IOTENCODER_ENCODER_TOKEN"),
+	})
+}
+
+// CreateStream calls the CreateStream method, retrying while the encoder is
+// unavailable.
+func (c *Client) CreateStream(ctx context.Context, req *encoder.CreateStreamRequest) (*encoder.CreateStreamResponse, error) {
+	var resp *encoder.CreateStreamResponse
+
+	err := c.call(ctx, func(ctx context.Context) (err error) {
+		resp, err = c.client.CreateStream(ctx, req)
+		return err
+	})
+
+	return resp, err
+}
+
+// DeleteStream calls the DeleteStream method, retrying while the encoder is
+// unavailable.
+func (c *Client) DeleteStream(ctx context.Context, req *encoder.DeleteStreamRequest) (*encoder.DeleteStreamResponse, error) {
+	var resp *encoder.DeleteStreamResponse
+
+	err := c.call(ctx, func(ctx context.Context) (err error) {
+		resp, err = c.client.DeleteStream(ctx, req)
+		return err
+	})
+
+	return resp, err
+}
+
+// call makes a call with our headers and a request id, retrying it with
+// exponential backoff while it fails with an unavailable error, until our
+// retries are exhausted or the context is done.
+func (c *Client) call(ctx context.Context, fn func(ctx context.Context) error) error {
+	ctx, err := c.outgoing(requestid.Ensure(ctx))
+	if err != nil {
+		return err
+	}
+
+	backoff := c.backoff
+
+	for attempt := 0; ; attempt++ {
+		err = fn(ctx)
+		if err == nil || attempt >= c.retries || !retryable(err) {
+			return err
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return err
+		}
+
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// outgoing returns a copy of the context with which the generated client sends
+// our headers, along with the request id and any headers the context already
+// carries.
+func (c *Client) outgoing(ctx context.Context) (context.Context, error) {
+	header := http.Header{}
+
+	for k, v := range c.headers {
+		header[k] = v
+	}
+
+	if c.token != "" {
+		header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	if existing, ok := twirp.HTTPRequestHeaders(ctx); ok {
+		for k, v := range existing {
+			header[k] = v
+		}
+	}
+
+	ctx, err := twirp.WithHTTPRequestHeaders(ctx, header)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to set request headers")
+	}
+
+	return requestid.Outgoing(ctx), nil
+}
+
+// retryable returns true if the error is a Twirp error with the unavailable
+// code, which the encoder returns, as do proxies responding 503 Service
+// Unavailable, when the call may succeed if tried again.
+func retryable(err error) bool {
+	twerr, ok := err.(twirp.Error)
+	return ok && twerr.Code() == twirp.Unavailable
+}
@@ -0,0 +1,141 @@
+package client_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	encoder "github.com/thingful/twirp-encoder-go"
+	"github.com/twitchtv/twirp"
+
+	"github.com/DECODEproject/iotencoder/pkg/client"
+	"github.com/DECODEproject/iotencoder/pkg/requestid"
+)
+
+// flakyEncoder fails the first calls made to it with the given error.
+type flakyEncoder struct {
+	sync.Mutex
+	failures int
+	err      error
+	calls    int
+}
+
+func (f *flakyEncoder) CreateStream(ctx context.Context, req *encoder.CreateStreamRequest) (*encoder.CreateStreamResponse, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	f.calls++
+	if f.calls <= f.failures {
+		return nil, f.err
+	}
+
+	return &encoder.CreateStreamResponse{StreamUid: "stream-1", Token: "token-1"}, nil
+}
+
+func (f *flakyEncoder) DeleteStream(ctx context.Context, req *encoder.DeleteStreamRequest) (*encoder.DeleteStreamResponse, error) {
+	return &encoder.DeleteStreamResponse{}, nil
+}
+
+// recordHeaders records the headers of each request before passing it on.
+func recordHeaders(headers *[]http.Header, next http.Handler) http.Handler {
+	var mu sync.Mutex
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		*headers = append(*headers, r.Header)
+		mu.Unlock()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func TestClientRetriesUnavailable(t *testing.T) {
+	enc := &flakyEncoder{failures: 2, err: twirp.NewError(twirp.Unavailable, "in maintenance")}
+
+	var headers []http.Header
+
+	srv := httptest.NewServer(recordHeaders(&headers, encoder.NewEncoderServer(enc, nil)))
+	defer srv.Close()
+
+	c, err := client.New(&client.Config{
+		Addr:    srv.URL,
+		Token:   "secret",
+		Headers: http.Header{"Labels": []string{"pilot=barcelona"}},
+		Backoff: time.Millisecond,
+	})
+	assert.Nil(t, err)
+
+	resp, err := c.CreateStream(requestid.With(context.Background(), "request-1"), &encoder.CreateStreamRequest{})
+	assert.Nil(t, err)
+	assert.Equal(t, "stream-1", resp.StreamUid)
+	assert.Equal(t, 3, enc.calls)
+
+	// every attempt is authenticated and traced by the same request id
+	assert.Len(t, headers, 3)
+	for _, h := range headers {
+		assert.Equal(t, "Bearer secret", h.Get("Authorization"))
+		assert.Equal(t, "pilot=barcelona", h.Get("Labels"))
+		assert.Equal(t, "request-1", h.Get(requestid.Header))
+	}
+}
+
+func TestClientGivesUp(t *testing.T) {
+	testcases := []struct {
+		label    string
+		err      error
+		retries  int
+		expected int
+	}{
+		{"retries exhausted", twirp.NewError(twirp.Unavailable, "in maintenance"), 2, 3},
+		{"retries disabled", twirp.NewError(twirp.Unavailable, "in maintenance"), -1, 1},
+		{"not retryable", twirp.InvalidArgumentError("device_token", "is invalid"), 2, 1},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			enc := &flakyEncoder{failures: 10, err: tc.err}
+
+			srv := httptest.NewServer(encoder.NewEncoderServer(enc, nil))
+			defer srv.Close()
+
+			c, err := client.New(&client.Config{
+				Addr:    srv.URL,
+				Retries: tc.retries,
+				Backoff: time.Millisecond,
+				JSON:    true,
+			})
+			assert.Nil(t, err)
+
+			_, err = c.CreateStream(context.Background(), &encoder.CreateStreamRequest{})
+			assert.NotNil(t, err)
+			assert.Equal(t, tc.err.(twirp.Error).Code(), err.(twirp.Error).Code())
+			assert.Equal(t, tc.expected, enc.calls)
+		})
+	}
+}
+
+func TestClientGeneratesRequestID(t *testing.T) {
+	var headers []http.Header
+
+	srv := httptest.NewServer(recordHeaders(&headers, encoder.NewEncoderServer(&flakyEncoder{}, nil)))
+	defer srv.Close()
+
+	c, err := client.New(&client.Config{Addr: srv.URL})
+	assert.Nil(t, err)
+
+	_, err = c.DeleteStream(context.Background(), &encoder.DeleteStreamRequest{})
+	assert.Nil(t, err)
+
+	assert.Len(t, headers, 1)
+	assert.NotEqual(t, "", headers[0].Get(requestid.Header))
+	assert.Equal(t, "", headers[0].Get("Authorization"))
+}
+
+func TestNewRequiresAddr(t *testing.T) {
+	_, err := client.New(&client.Config{})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,294 @@
+package coap
+
+import (
+	"encoding/binary"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Type is the type of a CoAP message.
+type Type uint8
+
+const (
+	// Confirmable messages require an acknowledgement.
+	Confirmable Type = 0
+	// NonConfirmable messages do not require an acknowledgement.
+	NonConfirmable Type = 1
+	// Acknowledgement messages acknowledge a confirmable message.
+	Acknowledgement Type = 2
+	// Reset messages indicate a message could not be processed.
+	Reset Type = 3
+)
+
+// Code is the request method or response code of a CoAP message, encoded as a
+// 3 bit class and a 5 bit detail.
+type Code uint8
+
+// The request and response codes we use, as defined in RFC 7252.
+const (
+	Empty                    Code = 0
+	GET                      Code = 1
+	POST                     Code = 2
+	PUT                      Code = 3
+	DELETE                   Code = 4
+	Changed                  Code = 2<<5 | 4
+	BadRequest               Code = 4<<5 | 0
+	BadOption                Code = 4<<5 | 2
+	NotFound                 Code = 4<<5 | 4
+	MethodNotAllowed         Code = 4<<5 | 5
+	UnsupportedContentFormat Code = 4<<5 | 15
+	InternalServerError      Code = 5<<5 | 0
+	ServiceUnavailable       Code = 5<<5 | 3
+)
+
+// String returns the code in the dotted c.dd form used by RFC 7252.
+func (c Code) String() string {
+	return string([]byte{'0' + byte(c>>5), '.', '0' + byte(c&0x1f)/10, '0' + byte(c&0x1f)%10})
+}
+
+// The option numbers we recognise, as defined in RFC 7252.
+const (
+	OptionURIHost       = 3
+	OptionURIPort       = 7
+	OptionURIPath       = 11
+	OptionContentFormat = 12
+	OptionURIQuery      = 15
+)
+
+// ContentFormatJSON is the registered content format of application/json.
+const ContentFormatJSON = 50
+
+const (
+	// version is the only CoAP protocol version.
+	version = 1
+
+	// payloadMarker separates options from the payload.
+	payloadMarker = 0xff
+
+	// maxTokenLength is the maximum length of a message token.
+	maxTokenLength = 8
+)
+
+// Option is a single option of a CoAP message.
+type Option struct {
+	Number uint16
+	Value  []byte
+}
+
+// critical returns true if the option must be understood by the recipient.
+func (o Option) critical() bool {
+	return o.Number&1 == 1
+}
+
+// Message is a CoAP message as defined in RFC 7252. We only support the subset
+// of the protocol required to accept readings from constrained devices, so
+// block-wise transfers and observe are not implemented.
+type Message struct {
+	Type      Type
+	Code      Code
+	MessageID uint16
+	Token     []byte
+	Options   []Option
+	Payload   []byte
+}
+
+// Path returns the request path built from the message's Uri-Path options,
+// without a leading slash.
+func (m *Message) Path() string {
+	segments := []string{}
+
+	for _, o := range m.Options {
+		if o.Number == OptionURIPath {
+			segments = append(segments, string(o.Value))
+		}
+	}
+
+	return strings.Join(segments, "/")
+}
+
+// ContentFormat returns the value of the message's Content-Format option, and
+// false if the option is not present.
+func (m *Message) ContentFormat() (uint32, bool) {
+	for _, o := range m.Options {
+		if o.Number == OptionContentFormat {
+			var v uint32
+			for _, b := range o.Value {
+				v = v<<8 | uint32(b)
+			}
+			return v, true
+		}
+	}
+
+	return 0, false
+}
+
+// unrecognisedOption returns the first critical option we do not understand,
+// and false if all critical options are recognised.
+func (m *Message) unrecognisedOption() (Option, bool) {
+	for _, o := range m.Options {
+		if !o.critical() {
+			continue
+		}
+
+		switch o.Number {
+		case OptionURIHost, OptionURIPort, OptionURIPath, OptionURIQuery:
+			continue
+		}
+
+		return o, true
+	}
+
+	return Option{}, false
+}
+
+// ParseMessage parses the given datagram into a Message, returning an error if
+// it is not a well formed CoAP message.
+func ParseMessage(data []byte) (*Message, error) {
+	if len(data) < 4 {
+		return nil, errors.New("message shorter than header")
+	}
+
+	if data[0]>>6 != version {
+		return nil, errors.Errorf("unsupported version %d", data[0]>>6)
+	}
+
+	tokenLength := int(data[0] & 0x0f)
+	if tokenLength > maxTokenLength {
+		return nil, errors.Errorf("invalid token length %d", tokenLength)
+	}
+
+	m := &Message{
+		Type:      Type(data[0] >> 4 & 0x03),
+		Code:      Code(data[1]),
+		MessageID: binary.BigEndian.Uint16(data[2:4]),
+	}
+
+	data = data[4:]
+
+	if len(data) < tokenLength {
+		return nil, errors.New("message shorter than token")
+	}
+
+	m.Token = append([]byte{}, data[:tokenLength]...)
+	data = data[tokenLength:]
+
+	var number uint16
+
+	for len(data) > 0 {
+		if data[0] == payloadMarker {
+			if len(data) == 1 {
+				return nil, errors.New("payload marker followed by empty payload")
+			}
+
+			m.Payload = append([]byte{}, data[1:]...)
+			break
+		}
+
+		delta, length := int(data[0]>>4), int(data[0]&0x0f)
+		data = data[1:]
+
+		var err error
+
+		delta, data, err = readExtended(delta, data)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid option delta")
+		}
+
+		length, data, err = readExtended(length, data)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid option length")
+		}
+
+		if len(data) < length {
+			return nil, errors.New("message shorter than option value")
+		}
+
+		if int(number)+delta > 0xffff {
+			return nil, errors.New("option number out of range")
+		}
+
+		number += uint16(delta)
+
+		m.Options = append(m.Options, Option{
+			Number: number,
+			Value:  append([]byte{}, data[:length]...),
+		})
+
+		data = data[length:]
+	}
+
+	return m, nil
+}
+
+// readExtended decodes the extended form of an option delta or length, where
+// the values 13 and 14 indicate that the actual value follows in one or two
+// further bytes.
+func readExtended(v int, data []byte) (int, []byte, error) {
+	switch v {
+	case 13:
+		if len(data) < 1 {
+			return 0, nil, errors.New("message truncated")
+		}
+		return int(data[0]) + 13, data[1:], nil
+	case 14:
+		if len(data) < 2 {
+			return 0, nil, errors.New("message truncated")
+		}
+		return int(binary.BigEndian.Uint16(data[:2])) + 269, data[2:], nil
+	case 15:
+		return 0, nil, errors.New("reserved value")
+	default:
+		return v, data, nil
+	}
+}
+
+// Marshal encodes the message into its wire format. Options must be sorted by
+// number.
+func (m *Message) Marshal() []byte {
+	b := []byte{
+		version<<6 | byte(m.Type)<<4 | byte(len(m.Token)),
+		byte(m.Code),
+		0, 0,
+	}
+
+	binary.BigEndian.PutUint16(b[2:4], m.MessageID)
+
+	b = append(b, m.Token...)
+
+	var number uint16
+
+	for _, o := range m.Options {
+		delta, deltaExt := encodeExtended(int(o.Number - number))
+		length, lengthExt := encodeExtended(len(o.Value))
+
+		b = append(b, byte(delta<<4|length))
+		b = append(b, deltaExt...)
+		b = append(b, lengthExt...)
+		b = append(b, o.Value...)
+
+		number = o.Number
+	}
+
+	if len(m.Payload) > 0 {
+		b = append(b, payloadMarker)
+		b = append(b, m.Payload...)
+	}
+
+	return b
+}
+
+// encodeExtended returns the nibble and any extended bytes used to encode an
+// option delta or length.
+func encodeExtended(v int) (int, []byte) {
+	switch {
+	case v < 13:
+		return v, nil
+	case v < 269:
+		return 13, []byte{byte(v - 13)}
+	default:
+		ext := make([]byte, 2)
+		binary.BigEndian.PutUint16(ext, uint16(v-269))
+		return 14, ext
+	}
+}
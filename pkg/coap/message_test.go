@@ -0,0 +1,68 @@
+package coap_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/coap"
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	longSegment := make([]byte, 300)
+	for i := range longSegment {
+		longSegment[i] = 'a'
+	}
+
+	msg := &coap.Message{
+		Type:      coap.Confirmable,
+		Code:      coap.POST,
+		MessageID: 0x1234,
+		Token:     []byte{1, 2, 3, 4},
+		Options: []coap.Option{
+			{Number: coap.OptionURIPath, Value: []byte("device")},
+			{Number: coap.OptionURIPath, Value: longSegment},
+			{Number: coap.OptionContentFormat, Value: []byte{coap.ContentFormatJSON}},
+			{Number: 2048, Value: []byte{}},
+		},
+		Payload: []byte(`{"data":[]}`),
+	}
+
+	parsed, err := coap.ParseMessage(msg.Marshal())
+	assert.Nil(t, err)
+	assert.Equal(t, msg, parsed)
+
+	assert.Equal(t, "device/"+string(longSegment), parsed.Path())
+
+	format, ok := parsed.ContentFormat()
+	assert.True(t, ok)
+	assert.Equal(t, uint32(coap.ContentFormatJSON), format)
+}
+
+func TestParseMessageInvalid(t *testing.T) {
+	testcases := []struct {
+		label string
+		data  []byte
+	}{
+		{"short header", []byte{0x40, 0x02}},
+		{"wrong version", []byte{0x80, 0x02, 0x00, 0x01}},
+		{"long token", []byte{0x49, 0x02, 0x00, 0x01}},
+		{"truncated token", []byte{0x44, 0x02, 0x00, 0x01, 0x01}},
+		{"empty payload", []byte{0x40, 0x02, 0x00, 0x01, 0xff}},
+		{"reserved delta", []byte{0x40, 0x02, 0x00, 0x01, 0xf1, 0x00}},
+		{"truncated option", []byte{0x40, 0x02, 0x00, 0x01, 0xb4, 'a'}},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			_, err := coap.ParseMessage(tc.data)
+			assert.NotNil(t, err)
+		})
+	}
+}
+
+func TestCodeString(t *testing.T) {
+	assert.Equal(t, "2.04", coap.Changed.String())
+	assert.Equal(t, "4.15", coap.UnsupportedContentFormat.String())
+	assert.Equal(t, "0.02", coap.POST.String())
+}
@@ -0,0 +1,379 @@
+package coap
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net"
+	"regexp"
+	"sync"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/logger"
+	"github.com/DECODEproject/iotencoder/pkg/logger/level"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+)
+
+var (
+	// RequestsCounter is a prometheus counter recording a count of CoAP requests
+	// received, labelled by the code of our response.
+	RequestsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "coap_requests",
+			Help:      "Count of CoAP requests received, labelled by response code",
+		},
+		[]string{"code"},
+	)
+)
+
+const (
+	// maxDatagramSize is the size of the buffer into which we read datagrams.
+	// Messages are expected to fit in a single datagram as we do not support
+	// block-wise transfers.
+	maxDatagramSize = 64 * 1024
+
+	// exchangeLifetime is the time for which we remember the response to a
+	// confirmable message, so that retransmissions are acknowledged again
+	// rather than processed twice. This is EXCHANGE_LIFETIME from RFC 7252.
+	exchangeLifetime = 247 * time.Second
+)
+
+// DeviceSource is the interface we require of a type able to load a device
+// along with its streams. It is satisfied by the postgres.DB type.
+type DeviceSource interface {
+	GetDevice(deviceToken secret.Secret) (*postgres.Device, error)
+}
+
+// Processor is the interface we call to process the readings of a device. It
+// is satisfied by the pipeline.Processor type.
+type Processor interface {
+	Process(ctx context.Context, device *postgres.Device, payload []byte) error
+}
+
+// Retainer is the interface we call to retain raw incoming payloads so that
+// they may later be replayed. It is satisfied by any retention.Store.
+type Retainer interface {
+	SaveRawPayload(deviceToken string, receivedAt time.Time, payload []byte) error
+}
+
+// Config is used to pass in configuration when creating a Server. Retainer is
+// optional, and if nil raw payloads are not retained. If MessageTimeout is
+// non-zero it bounds the processing of each incoming message.
+type Config struct {
+	Addr           string
+	Devices        DeviceSource
+	Processor      Processor
+	Retainer       Retainer
+	Clock          clock.Clock
+	MessageTimeout time.Duration
+}
+
+// exchange records our response to a confirmable message.
+type exchange struct {
+	response []byte
+	expires  time.Time
+}
+
+// Server accepts readings from constrained devices which speak CoAP rather
+// than MQTT. Devices POST or PUT the same JSON payload they would publish over
+// MQTT to the path device/sck/<device_token>/readings, mirroring the MQTT
+// topic. Readings for registered devices are acknowledged with 2.04 Changed
+// and then passed to the processing pipeline, so as with MQTT the device does
+// not learn whether encryption or the datastore write succeeded.
+type Server struct {
+	addr        string
+	devices     DeviceSource
+	processor   Processor
+	retainer    Retainer
+	clock       clock.Clock
+	timeout     time.Duration
+	logger      kitlog.Logger
+	pathPattern *regexp.Regexp
+
+	conn   net.PacketConn
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	sync.Mutex
+	exchanges map[string]*exchange
+	nextPurge time.Time
+}
+
+// NewServer returns a new Server configured with the given Config.
+func NewServer(config *Config, logger kitlog.Logger) *Server {
+	logger = kitlog.With(logger, "module", "coap")
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Server{
+		addr:        config.Addr,
+		devices:     config.Devices,
+		processor:   config.Processor,
+		retainer:    config.Retainer,
+		clock:       config.Clock,
+		timeout:     config.MessageTimeout,
+		logger:      logger,
+		pathPattern: regexp.MustCompile(`^device/sck/(\w+)/readings$`),
+		ctx:         ctx,
+		cancel:      cancel,
+		exchanges:   make(map[string]*exchange),
+	}
+}
+
+// Start binds the configured UDP address and starts serving requests.
+func (s *Server) Start() error {
+	s.logger.Log("msg", "starting coap server", "addr", s.addr)
+
+	conn, err := net.ListenPacket("udp", s.addr)
+	if err != nil {
+		return errors.Wrap(err, "failed to listen for coap requests")
+	}
+
+	s.conn = conn
+
+	s.wg.Add(1)
+	go s.serve()
+
+	return nil
+}
+
+// Stop closes the listener and waits for any requests being processed to
+// finish.
+func (s *Server) Stop() error {
+	s.logger.Log("msg", "stopping coap server")
+
+	s.cancel()
+
+	err := s.conn.Close()
+	s.wg.Wait()
+
+	return err
+}
+
+// Addr returns the address on which the server is listening, which is useful
+// when the configured port is zero.
+func (s *Server) Addr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+// serve reads datagrams until the listener is closed, handling each in its own
+// goroutine.
+func (s *Server) serve() {
+	defer s.wg.Done()
+
+	buf := make([]byte, maxDatagramSize)
+
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			if s.ctx.Err() != nil {
+				return
+			}
+
+			level.Error(s.logger).Log("err", err, "msg", "failed to read datagram")
+			continue
+		}
+
+		data := make([]byte, n)
+		copy(data, buf[:n])
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(data, addr)
+		}()
+	}
+}
+
+// handle parses a single datagram and responds to it. Confirmable requests are
+// acknowledged with a piggybacked response, while non-confirmable requests are
+// processed without a response.
+func (s *Server) handle(data []byte, addr net.Addr) {
+	msg, err := ParseMessage(data)
+	if err != nil {
+		level.Debug(s.logger).Log("err", err, "msg", "discarding malformed message", "addr", addr)
+
+		// a malformed confirmable message must be rejected if we can read its
+		// message id
+		if len(data) >= 4 && Type(data[0]>>4&0x03) == Confirmable {
+			s.write(addr, (&Message{Type: Reset, MessageID: uint16(data[2])<<8 | uint16(data[3])}).Marshal())
+		}
+		return
+	}
+
+	// we never send confirmable messages so have nothing to acknowledge, and
+	// responses are not expected
+	if msg.Type == Acknowledgement || msg.Type == Reset || msg.Code>>5 != 0 {
+		return
+	}
+
+	// an empty confirmable message is a ping, answered with a reset
+	if msg.Code == Empty {
+		if msg.Type == Confirmable {
+			s.write(addr, (&Message{Type: Reset, MessageID: msg.MessageID}).Marshal())
+		}
+		return
+	}
+
+	key := fmt.Sprintf("%s/%d", addr, msg.MessageID)
+
+	if msg.Type == Confirmable {
+		response, ok := s.lookup(key)
+		if ok {
+			if response != nil {
+				s.write(addr, response)
+			}
+			return
+		}
+
+		// record the exchange before processing so concurrent retransmissions are
+		// not processed twice, their acknowledgement being left to the client's
+		// next retransmission
+		s.remember(key, nil)
+	}
+
+	code, device := s.accept(msg)
+
+	RequestsCounter.WithLabelValues(code.String()).Inc()
+
+	if msg.Type == Confirmable {
+		response := (&Message{
+			Type:      Acknowledgement,
+			Code:      code,
+			MessageID: msg.MessageID,
+			Token:     msg.Token,
+		}).Marshal()
+
+		s.remember(key, response)
+		s.write(addr, response)
+	}
+
+	if device != nil {
+		s.process(device, msg.Payload)
+	}
+}
+
+// accept validates a request, returning the code of our response and if the
+// request is valid the device whose readings it contains.
+func (s *Server) accept(msg *Message) (Code, *postgres.Device) {
+	if msg.Code != POST && msg.Code != PUT {
+		return MethodNotAllowed, nil
+	}
+
+	if _, ok := msg.unrecognisedOption(); ok {
+		return BadOption, nil
+	}
+
+	matches := s.pathPattern.FindStringSubmatch(msg.Path())
+	if len(matches) != 2 {
+		return NotFound, nil
+	}
+
+	if format, ok := msg.ContentFormat(); ok && format != ContentFormatJSON {
+		return UnsupportedContentFormat, nil
+	}
+
+	if len(msg.Payload) == 0 {
+		return BadRequest, nil
+	}
+
+	if s.ctx.Err() != nil {
+		return ServiceUnavailable, nil
+	}
+
+	token := secret.Secret(matches[1])
+
+	device, err := s.devices.GetDevice(token)
+	if err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return NotFound, nil
+		}
+
+		level.Error(s.logger).Log("err", err, "msg", "failed to get device", "device_hash", logger.HashToken(token))
+		return InternalServerError, nil
+	}
+
+	return Changed, device
+}
+
+// process retains and then processes the payload for the given device,
+// bounded by the configured message timeout.
+func (s *Server) process(device *postgres.Device, payload []byte) {
+	log := kitlog.With(s.logger, "device_hash", logger.HashToken(device.DeviceToken))
+
+	if s.retainer != nil {
+		err := s.retainer.SaveRawPayload(device.DeviceToken.Reveal(), s.clock.Now(), payload)
+		if err != nil {
+			level.Error(log).Log("err", err, "msg", "failed to retain payload")
+		}
+	}
+
+	ctx := s.ctx
+
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+
+	err := s.processor.Process(ctx, device, payload)
+	if err != nil {
+		level.Error(log).Log("err", err, "msg", "failed to process payload")
+	}
+}
+
+// lookup returns our response to a previously received confirmable message, or
+// false if the message has not been seen within the exchange lifetime. The
+// response is nil if the message is still being handled.
+func (s *Server) lookup(key string) ([]byte, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	e, ok := s.exchanges[key]
+	if !ok || s.clock.Now().After(e.expires) {
+		return nil, false
+	}
+
+	return e.response, true
+}
+
+// remember records our response to a confirmable message, periodically
+// purging expired exchanges.
+func (s *Server) remember(key string, response []byte) {
+	s.Lock()
+	defer s.Unlock()
+
+	now := s.clock.Now()
+
+	if now.After(s.nextPurge) {
+		for k, e := range s.exchanges {
+			if now.After(e.expires) {
+				delete(s.exchanges, k)
+			}
+		}
+
+		s.nextPurge = now.Add(exchangeLifetime)
+	}
+
+	s.exchanges[key] = &exchange{
+		response: response,
+		expires:  now.Add(exchangeLifetime),
+	}
+}
+
+// write sends a datagram to the given address.
+func (s *Server) write(addr net.Addr, data []byte) {
+	_, err := s.conn.WriteTo(data, addr)
+	if err != nil {
+		level.Error(s.logger).Log("err", err, "msg", "failed to write response", "addr", addr)
+	}
+}
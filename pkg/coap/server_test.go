@@ -0,0 +1,187 @@
+package coap_test
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/coap"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/postgres/postgrestest"
+)
+
+type processor struct {
+	sync.Mutex
+	payloads []string
+}
+
+func (p *processor) Process(ctx context.Context, device *postgres.Device, payload []byte) error {
+	p.Lock()
+	defer p.Unlock()
+
+	p.payloads = append(p.payloads, device.DeviceToken.Reveal()+" "+string(payload))
+	return nil
+}
+
+func (p *processor) count() int {
+	p.Lock()
+	defer p.Unlock()
+
+	return len(p.payloads)
+}
+
+func newServer(t *testing.T) (*coap.Server, *processor) {
+	db := postgrestest.NewDB()
+
+	_, err := db.CreateStream(&postgres.Stream{
+		CommunityID: "community",
+		PublicKey:   "public",
+		Device: &postgres.Device{
+			DeviceToken: "abc123",
+		},
+	})
+	assert.Nil(t, err)
+
+	p := &processor{}
+
+	s := coap.NewServer(&coap.Config{
+		Addr:      "127.0.0.1:0",
+		Devices:   db,
+		Processor: p,
+		Clock:     clock.New(),
+	}, kitlog.NewNopLogger())
+
+	err = s.Start()
+	assert.Nil(t, err)
+
+	return s, p
+}
+
+// request sends the message to the server, returning the response.
+func request(t *testing.T, conn net.Conn, msg *coap.Message) *coap.Message {
+	_, err := conn.Write(msg.Marshal())
+	assert.Nil(t, err)
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	assert.Nil(t, err)
+
+	resp, err := coap.ParseMessage(buf[:n])
+	assert.Nil(t, err)
+
+	return resp
+}
+
+func newRequest(id uint16, code coap.Code, path string, payload string) *coap.Message {
+	msg := &coap.Message{
+		Type:      coap.Confirmable,
+		Code:      code,
+		MessageID: id,
+		Token:     []byte{0xca, 0xfe},
+		Payload:   []byte(payload),
+	}
+
+	for _, segment := range strings.Split(path, "/") {
+		msg.Options = append(msg.Options, coap.Option{Number: coap.OptionURIPath, Value: []byte(segment)})
+	}
+
+	return msg
+}
+
+// waitFor polls until the processor has received the expected number of
+// payloads, as processing happens after the response is sent.
+func waitFor(t *testing.T, p *processor, expected int) {
+	deadline := time.Now().Add(time.Second)
+
+	for time.Now().Before(deadline) {
+		if p.count() == expected {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	t.Fatalf("timed out waiting for %d payloads", expected)
+}
+
+func TestServer(t *testing.T) {
+	s, p := newServer(t)
+	defer s.Stop()
+
+	conn, err := net.Dial("udp", s.Addr().String())
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	payload := `{"data":[{"recorded_at":"2019-06-01T12:00:00Z","sensors":[{"id":13,"value":51.0}]}]}`
+
+	resp := request(t, conn, newRequest(1, coap.POST, "device/sck/abc123/readings", payload))
+	assert.Equal(t, coap.Acknowledgement, resp.Type)
+	assert.Equal(t, coap.Changed, resp.Code)
+	assert.Equal(t, uint16(1), resp.MessageID)
+	assert.Equal(t, []byte{0xca, 0xfe}, resp.Token)
+
+	waitFor(t, p, 1)
+
+	p.Lock()
+	assert.Equal(t, "abc123 "+payload, p.payloads[0])
+	p.Unlock()
+
+	// a retransmission is acknowledged again but not processed twice
+	resp = request(t, conn, newRequest(1, coap.POST, "device/sck/abc123/readings", payload))
+	assert.Equal(t, coap.Changed, resp.Code)
+
+	resp = request(t, conn, newRequest(2, coap.PUT, "device/sck/abc123/readings", payload))
+	assert.Equal(t, coap.Changed, resp.Code)
+
+	waitFor(t, p, 2)
+}
+
+func TestServerErrors(t *testing.T) {
+	s, p := newServer(t)
+	defer s.Stop()
+
+	conn, err := net.Dial("udp", s.Addr().String())
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	unsupportedFormat := newRequest(5, coap.POST, "device/sck/abc123/readings", "<data/>")
+	unsupportedFormat.Options = append(unsupportedFormat.Options, coap.Option{Number: coap.OptionContentFormat, Value: []byte{41}})
+
+	unknownOption := newRequest(6, coap.POST, "device/sck/abc123/readings", "{}")
+	unknownOption.Options = append(unknownOption.Options, coap.Option{Number: 2049, Value: []byte{1}})
+
+	testcases := []struct {
+		label    string
+		request  *coap.Message
+		expected coap.Code
+	}{
+		{"method", newRequest(1, coap.GET, "device/sck/abc123/readings", ""), coap.MethodNotAllowed},
+		{"path", newRequest(2, coap.POST, "readings", "{}"), coap.NotFound},
+		{"device", newRequest(3, coap.POST, "device/sck/unknown/readings", "{}"), coap.NotFound},
+		{"payload", newRequest(4, coap.POST, "device/sck/abc123/readings", ""), coap.BadRequest},
+		{"format", unsupportedFormat, coap.UnsupportedContentFormat},
+		{"option", unknownOption, coap.BadOption},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			resp := request(t, conn, tc.request)
+			assert.Equal(t, tc.expected, resp.Code)
+		})
+	}
+
+	// an empty confirmable message is answered with a reset
+	resp := request(t, conn, &coap.Message{Type: coap.Confirmable, MessageID: 7})
+	assert.Equal(t, coap.Reset, resp.Type)
+	assert.Equal(t, uint16(7), resp.MessageID)
+
+	assert.Equal(t, 0, p.count())
+}
@@ -0,0 +1,95 @@
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+const (
+	// Gzip is the name of the gzip content encoding.
+	Gzip = "gzip"
+
+	// Zstd is the name of the Zstandard content encoding.
+	Zstd = "zstd"
+)
+
+var (
+	// the zstd encoder and decoder may be shared between goroutines when used
+	// via EncodeAll and DecodeAll, and are expensive to create
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+// Valid returns true if the given name is a content encoding we support, or is
+// empty meaning no compression.
+func Valid(encoding string) bool {
+	switch encoding {
+	case "", Gzip, Zstd:
+		return true
+	}
+	return false
+}
+
+// Compress returns the given data compressed using the named content encoding.
+// If encoding is empty the data is returned unchanged.
+func Compress(encoding string, data []byte) ([]byte, error) {
+	switch encoding {
+	case "":
+		return data, nil
+	case Gzip:
+		var buf bytes.Buffer
+
+		w := gzip.NewWriter(&buf)
+
+		_, err := w.Write(data)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to gzip data")
+		}
+
+		err = w.Close()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to gzip data")
+		}
+
+		return buf.Bytes(), nil
+	case Zstd:
+		return zstdEncoder.EncodeAll(data, nil), nil
+	}
+
+	return nil, errors.Errorf("unsupported content encoding: %s", encoding)
+}
+
+// Decompress returns the given data decompressed using the named content
+// encoding. If encoding is empty the data is returned unchanged.
+func Decompress(encoding string, data []byte) ([]byte, error) {
+	switch encoding {
+	case "":
+		return data, nil
+	case Gzip:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read gzip data")
+		}
+		defer r.Close()
+
+		decompressed, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read gzip data")
+		}
+
+		return decompressed, nil
+	case Zstd:
+		decompressed, err := zstdDecoder.DecodeAll(data, nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read zstd data")
+		}
+
+		return decompressed, nil
+	}
+
+	return nil, errors.Errorf("unsupported content encoding: %s", encoding)
+}
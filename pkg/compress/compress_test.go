@@ -0,0 +1,40 @@
+package compress_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/compress"
+)
+
+func TestRoundTrip(t *testing.T) {
+	data := bytes.Repeat([]byte(`{"id":13,"name":"HPP828E031","value":51},`), 100)
+
+	for _, encoding := range []string{"", compress.Gzip, compress.Zstd} {
+		t.Run(encoding, func(t *testing.T) {
+			compressed, err := compress.Compress(encoding, data)
+			assert.Nil(t, err)
+
+			if encoding != "" {
+				assert.True(t, len(compressed) < len(data))
+			}
+
+			decompressed, err := compress.Decompress(encoding, compressed)
+			assert.Nil(t, err)
+			assert.Equal(t, data, decompressed)
+		})
+	}
+}
+
+func TestUnsupported(t *testing.T) {
+	assert.False(t, compress.Valid("brotli"))
+	assert.True(t, compress.Valid(compress.Zstd))
+
+	_, err := compress.Compress("brotli", []byte("data"))
+	assert.NotNil(t, err)
+
+	_, err = compress.Decompress(compress.Gzip, []byte("not gzip"))
+	assert.NotNil(t, err)
+}
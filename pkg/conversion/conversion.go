@@ -0,0 +1,296 @@
+// Package conversion implements the small expression language used to convert
+// raw sensor readings, for example ADC counts, into engineering units. An
+// expression is an arithmetic formula over the variable x, which holds the raw
+// reading, e.g. "x * 0.0625 - 40". Expressions support numeric literals, the
+// operators + - * /, unary minus and parentheses.
+package conversion
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// Expression is a parsed conversion expression, ready to be evaluated.
+type Expression struct {
+	source string
+	root   node
+}
+
+// Parse parses the given expression, returning an error if it is not valid.
+func Parse(expr string) (*Expression, error) {
+	p := &parser{input: expr}
+
+	p.next()
+
+	root, err := p.parseExpr()
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid conversion expression %q", expr)
+	}
+
+	if p.tok.kind != tokEOF {
+		return nil, errors.Errorf("invalid conversion expression %q: unexpected %s at position %d", expr, p.tok, p.tok.pos)
+	}
+
+	return &Expression{source: expr, root: root}, nil
+}
+
+// Eval evaluates the expression with x set to the given raw value. The second
+// return value is false if the result is not a finite number, for example
+// following a division by zero.
+func (e *Expression) Eval(x float64) (float64, bool) {
+	v := e.root.eval(x)
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, false
+	}
+	return v, true
+}
+
+// String returns the source of the expression.
+func (e *Expression) String() string {
+	return e.source
+}
+
+// node is a node of a parsed expression tree.
+type node interface {
+	eval(x float64) float64
+}
+
+type number float64
+
+func (n number) eval(x float64) float64 { return float64(n) }
+
+type variable struct{}
+
+func (variable) eval(x float64) float64 { return x }
+
+type negate struct{ operand node }
+
+func (n negate) eval(x float64) float64 { return -n.operand.eval(x) }
+
+type binary struct {
+	op          byte
+	left, right node
+}
+
+func (b binary) eval(x float64) float64 {
+	l, r := b.left.eval(x), b.right.eval(x)
+
+	switch b.op {
+	case '+':
+		return l + r
+	case '-':
+		return l - r
+	case '*':
+		return l * r
+	default:
+		return l / r
+	}
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokVariable
+	tokOperator
+	tokLParen
+	tokRParen
+	tokInvalid
+)
+
+type token struct {
+	kind  tokenKind
+	text  string
+	value float64
+	pos   int
+}
+
+func (t token) String() string {
+	if t.kind == tokEOF {
+		return "end of expression"
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// parser is a recursive descent parser for conversion expressions. The grammar
+// is:
+//
+//	expr    = term { ("+" | "-") term }
+//	term    = unary { ("*" | "/") unary }
+//	unary   = "-" unary | primary
+//	primary = number | "x" | "(" expr ")"
+type parser struct {
+	input string
+	pos   int
+	tok   token
+}
+
+// next advances to the next token of the input.
+func (p *parser) next() {
+	for p.pos < len(p.input) && unicode.IsSpace(rune(p.input[p.pos])) {
+		p.pos++
+	}
+
+	start := p.pos
+
+	if p.pos >= len(p.input) {
+		p.tok = token{kind: tokEOF, pos: start}
+		return
+	}
+
+	c := p.input[p.pos]
+
+	switch {
+	case c == 'x':
+		p.pos++
+		p.tok = token{kind: tokVariable, text: "x", pos: start}
+	case strings.IndexByte("+-*/", c) >= 0:
+		p.pos++
+		p.tok = token{kind: tokOperator, text: string(c), pos: start}
+	case c == '(':
+		p.pos++
+		p.tok = token{kind: tokLParen, text: "(", pos: start}
+	case c == ')':
+		p.pos++
+		p.tok = token{kind: tokRParen, text: ")", pos: start}
+	case c == '.' || (c >= '0' && c <= '9'):
+		p.pos = scanNumber(p.input, p.pos)
+		text := p.input[start:p.pos]
+
+		value, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			p.tok = token{kind: tokInvalid, text: text, pos: start}
+			return
+		}
+
+		p.tok = token{kind: tokNumber, text: text, value: value, pos: start}
+	default:
+		p.pos++
+		p.tok = token{kind: tokInvalid, text: string(c), pos: start}
+	}
+}
+
+func (p *parser) parseExpr() (node, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.tok.kind == tokOperator && (p.tok.text == "+" || p.tok.text == "-") {
+		op := p.tok.text[0]
+		p.next()
+
+		right, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+
+		left = binary{op: op, left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *parser) parseTerm() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.tok.kind == tokOperator && (p.tok.text == "*" || p.tok.text == "/") {
+		op := p.tok.text[0]
+		p.next()
+
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		left = binary{op: op, left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.tok.kind == tokOperator && p.tok.text == "-" {
+		p.next()
+
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		return negate{operand: operand}, nil
+	}
+
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	tok := p.tok
+
+	switch tok.kind {
+	case tokNumber:
+		p.next()
+		return number(tok.value), nil
+	case tokVariable:
+		p.next()
+		return variable{}, nil
+	case tokLParen:
+		p.next()
+
+		inner, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+
+		if p.tok.kind != tokRParen {
+			return nil, errors.Errorf("expected \")\" at position %d", p.tok.pos)
+		}
+
+		p.next()
+
+		return inner, nil
+	default:
+		return nil, errors.Errorf("unexpected %s at position %d", tok, tok.pos)
+	}
+}
+
+// scanNumber returns the position following the number starting at pos,
+// including any fractional part and exponent.
+func scanNumber(s string, pos int) int {
+	isDigit := func(i int) bool { return i < len(s) && s[i] >= '0' && s[i] <= '9' }
+
+	for isDigit(pos) {
+		pos++
+	}
+
+	if pos < len(s) && s[pos] == '.' {
+		pos++
+		for isDigit(pos) {
+			pos++
+		}
+	}
+
+	if pos < len(s) && (s[pos] == 'e' || s[pos] == 'E') {
+		exp := pos + 1
+		if exp < len(s) && (s[exp] == '+' || s[exp] == '-') {
+			exp++
+		}
+		if isDigit(exp) {
+			pos = exp
+			for isDigit(pos) {
+				pos++
+			}
+		}
+	}
+
+	return pos
+}
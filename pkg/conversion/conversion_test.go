@@ -0,0 +1,75 @@
+package conversion_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/conversion"
+)
+
+func TestEval(t *testing.T) {
+	testcases := []struct {
+		expr     string
+		x        float64
+		expected float64
+	}{
+		{"x", 12.5, 12.5},
+		{"42", 12.5, 42},
+		{"x * 0.0625 - 40", 1024, 24},
+		{"x*0.5+3", 4, 5},
+		{"3 + x * 2", 4, 11},
+		{"(3 + x) * 2", 4, 14},
+		{"-x", 4, -4},
+		{"- -x", 4, 4},
+		{"x / 4 / 2", 16, 2},
+		{"10 - 4 - 3", 0, 3},
+		{"1.5e2 + x", 1, 151},
+		{".5 * x", 8, 4},
+		{"(x - 32) * 5 / 9", 212, 100},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.expr, func(t *testing.T) {
+			e, err := conversion.Parse(tc.expr)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expr, e.String())
+
+			got, ok := e.Eval(tc.x)
+			assert.True(t, ok)
+			assert.InDelta(t, tc.expected, got, 1e-9)
+		})
+	}
+}
+
+func TestEvalNotFinite(t *testing.T) {
+	e, err := conversion.Parse("1 / x")
+	assert.Nil(t, err)
+
+	_, ok := e.Eval(0)
+	assert.False(t, ok)
+
+	got, ok := e.Eval(4)
+	assert.True(t, ok)
+	assert.Equal(t, 0.25, got)
+}
+
+func TestParseInvalid(t *testing.T) {
+	testcases := []string{
+		"",
+		"x +",
+		"(x + 1",
+		"x + 1)",
+		"y * 2",
+		"x ** 2",
+		"2 x",
+		"1..2",
+	}
+
+	for _, expr := range testcases {
+		t.Run(expr, func(t *testing.T) {
+			_, err := conversion.Parse(expr)
+			assert.NotNil(t, err)
+		})
+	}
+}
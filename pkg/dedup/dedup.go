@@ -0,0 +1,305 @@
+// Package dedup detects messages delivered more than once, as brokers may
+// redeliver messages which they believe were not acknowledged, so that each
+// message is only encrypted and written to the datastore once. We record a key
+// for every message received within a TTL, and a message whose key has already
+// been recorded is a duplicate. A key is held from the time its message is
+// received, so that a concurrent redelivery is also detected, but is forgotten
+// if the message is not processed, so that a redelivery of it is processed
+// instead, and is only persisted once it has been.
+package dedup
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/logger/level"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+)
+
+var (
+	// DuplicateCounter is a prometheus counter recording a count of duplicate
+	// messages detected, which are dropped.
+	DuplicateCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "duplicate_messages",
+			Help:      "Count of duplicate messages dropped",
+		},
+	)
+)
+
+// Persister is the interface we require of a type able to save and load
+// message keys. We define it here where we need it, and it is satisfied by our
+// postgres.DB type.
+type Persister interface {
+	GetMessageKeys(since time.Time) ([]*postgres.MessageKey, error)
+	SaveMessageKeys(keys []*postgres.MessageKey) error
+	PruneMessageKeys(before time.Time) (int64, error)
+}
+
+// Config is used to pass in dependencies and configuration when creating a
+// Store. Persister is optional, and if nil keys are held purely in memory so
+// are lost on restart, otherwise keys are written to it every Interval. TTL is
+// how long a key is held, so the longest delay after which a redelivered
+// message is still detected. Size is the maximum number of keys held in memory,
+// beyond which the oldest keys are discarded, and if not positive the number
+// of keys is limited only by the TTL.
+type Config struct {
+	Persister Persister
+	TTL       time.Duration
+	Size      int
+	Interval  time.Duration
+	Clock     clock.Clock
+}
+
+// entry is a key held by a Store, and the time it was first seen.
+type entry struct {
+	key    string
+	seenAt time.Time
+}
+
+// Store is our in-memory cache of the keys of recently received messages.
+// Keys are held in the order in which they were first seen, so the oldest key
+// is always the first to expire or be discarded.
+type Store struct {
+	persister Persister
+	ttl       time.Duration
+	size      int
+	interval  time.Duration
+	clock     clock.Clock
+	logger    kitlog.Logger
+	quit      chan struct{}
+	wg        sync.WaitGroup
+
+	sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+	dirty   []*postgres.MessageKey
+}
+
+// NewStore returns a new Store instance configured by the given Config.
+func NewStore(config *Config, logger kitlog.Logger) *Store {
+	logger = kitlog.With(logger, "module", "dedup")
+
+	return &Store{
+		persister: config.Persister,
+		ttl:       config.TTL,
+		size:      config.Size,
+		interval:  config.Interval,
+		clock:     config.Clock,
+		logger:    logger,
+		entries:   make(map[string]*list.Element),
+		order:     list.New(),
+	}
+}
+
+// Start loads any previously persisted keys which have not yet expired, and
+// then starts a goroutine which periodically writes new keys back to the
+// Persister and prunes expired keys from it.
+func (s *Store) Start() error {
+	if s.persister == nil {
+		return nil
+	}
+
+	s.logger.Log("msg", "starting dedup store", "interval", s.interval, "ttl", s.ttl)
+
+	if s.interval <= 0 {
+		return errors.New("dedup flush interval must be positive")
+	}
+
+	keys, err := s.persister.GetMessageKeys(s.clock.Now().Add(-s.ttl))
+	if err != nil {
+		return errors.Wrap(err, "failed to load message keys")
+	}
+
+	s.Lock()
+	for _, k := range keys {
+		if _, ok := s.entries[k.Key]; !ok {
+			s.insert(k.Key, k.SeenAt)
+		}
+	}
+	s.Unlock()
+
+	s.quit = make(chan struct{})
+	s.wg.Add(1)
+
+	go s.loop()
+
+	return nil
+}
+
+// Stop stops the flush goroutine, and writes any outstanding keys to the
+// Persister.
+func (s *Store) Stop() error {
+	if s.persister == nil || s.quit == nil {
+		return nil
+	}
+
+	s.logger.Log("msg", "stopping dedup store")
+
+	close(s.quit)
+	s.wg.Wait()
+
+	return s.Flush()
+}
+
+// Flush writes all keys committed since the last flush to the Persister. If
+// writing them fails they are kept to be written by the next flush.
+func (s *Store) Flush() error {
+	if s.persister == nil {
+		return nil
+	}
+
+	s.Lock()
+	keys := s.dirty
+	s.dirty = nil
+	s.Unlock()
+
+	if len(keys) == 0 {
+		return nil
+	}
+
+	err := s.persister.SaveMessageKeys(keys)
+	if err != nil {
+		s.Lock()
+		s.dirty = append(keys, s.dirty...)
+		s.Unlock()
+
+		return err
+	}
+
+	return nil
+}
+
+// Seen returns true if a message with the same payload has already been
+// received from the given device within our TTL, in which case the message is
+// a duplicate and should be dropped. Otherwise we record the message so that
+// any later redelivery is detected, and the caller must call Commit once the
+// message has been processed, or Forget if processing it failed. Payloads from
+// a device carry the time at which readings were recorded, so identical
+// payloads are taken to be the same message.
+func (s *Store) Seen(deviceToken secret.Secret, payload []byte) bool {
+	now := s.clock.Now()
+	k := Key(deviceToken, payload)
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.expire(now)
+
+	if _, ok := s.entries[k]; ok {
+		DuplicateCounter.Inc()
+		return true
+	}
+
+	s.insert(k, now)
+
+	return false
+}
+
+// Commit records that a message for which Seen returned false has been
+// processed, so its key is written to the Persister by the next flush.
+func (s *Store) Commit(deviceToken secret.Secret, payload []byte) {
+	k := Key(deviceToken, payload)
+
+	s.Lock()
+	defer s.Unlock()
+
+	el, ok := s.entries[k]
+	if !ok {
+		return
+	}
+
+	s.dirty = append(s.dirty, &postgres.MessageKey{Key: k, SeenAt: el.Value.(*entry).seenAt})
+}
+
+// Forget discards the key of a message for which Seen returned false but which
+// could not be processed, so that a redelivery of it is not taken to be a
+// duplicate.
+func (s *Store) Forget(deviceToken secret.Secret, payload []byte) {
+	k := Key(deviceToken, payload)
+
+	s.Lock()
+	defer s.Unlock()
+
+	if el, ok := s.entries[k]; ok {
+		s.remove(el)
+	}
+}
+
+// insert records the given key as the newest, discarding the oldest key if we
+// then hold more than our size. Callers must hold the lock.
+func (s *Store) insert(k string, seenAt time.Time) {
+	s.entries[k] = s.order.PushBack(&entry{key: k, seenAt: seenAt})
+
+	if s.size > 0 && s.order.Len() > s.size {
+		s.remove(s.order.Front())
+	}
+}
+
+// expire discards all keys which have been held for longer than our TTL.
+// Callers must hold the lock.
+func (s *Store) expire(now time.Time) {
+	for el := s.order.Front(); el != nil; el = s.order.Front() {
+		if now.Sub(el.Value.(*entry).seenAt) < s.ttl {
+			return
+		}
+
+		s.remove(el)
+	}
+}
+
+// remove discards the given element. Callers must hold the lock.
+func (s *Store) remove(el *list.Element) {
+	s.order.Remove(el)
+	delete(s.entries, el.Value.(*entry).key)
+}
+
+// loop is run in a goroutine and on each tick of our interval flushes keys to
+// the Persister and prunes those which have expired, until the store is
+// stopped.
+func (s *Store) loop() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := s.Flush()
+			if err != nil {
+				level.Error(s.logger).Log("msg", "failed to flush message keys", "err", err)
+			}
+
+			_, err = s.persister.PruneMessageKeys(s.clock.Now().Add(-s.ttl))
+			if err != nil {
+				level.Error(s.logger).Log("msg", "failed to prune message keys", "err", err)
+			}
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+// Key returns the key identifying a message with the given payload received
+// from the given device. Keys are hashes, so the device token and payload are
+// not revealed when keys are persisted.
+func Key(deviceToken secret.Secret, payload []byte) string {
+	h := sha256.New()
+	h.Write([]byte(deviceToken.Reveal()))
+	h.Write([]byte{0})
+	h.Write(payload)
+
+	return hex.EncodeToString(h.Sum(nil))
+}
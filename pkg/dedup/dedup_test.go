@@ -0,0 +1,177 @@
+package dedup_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/dedup"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+)
+
+type persister struct {
+	loaded []*postgres.MessageKey
+	saved  []*postgres.MessageKey
+	since  time.Time
+	err    error
+}
+
+func (p *persister) GetMessageKeys(since time.Time) ([]*postgres.MessageKey, error) {
+	p.since = since
+	return p.loaded, nil
+}
+
+func (p *persister) SaveMessageKeys(keys []*postgres.MessageKey) error {
+	if p.err != nil {
+		return p.err
+	}
+
+	p.saved = append(p.saved, keys...)
+	return nil
+}
+
+func (p *persister) PruneMessageKeys(before time.Time) (int64, error) {
+	return 0, nil
+}
+
+func TestSeen(t *testing.T) {
+	cl := clock.NewMock(time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC))
+
+	store := dedup.NewStore(&dedup.Config{
+		TTL:   time.Minute,
+		Clock: cl,
+	}, kitlog.NewNopLogger())
+
+	payload := []byte(`{"data":[{"recorded_at":"2019-06-01T12:00:00Z","sensors":[{"id":13,"value":51.0}]}]}`)
+
+	assert.False(t, store.Seen(secret.Secret("abc"), payload))
+	assert.True(t, store.Seen(secret.Secret("abc"), payload))
+
+	// other devices and payloads are tracked independently
+	assert.False(t, store.Seen(secret.Secret("def"), payload))
+	assert.False(t, store.Seen(secret.Secret("abc"), []byte(`{"data":[]}`)))
+
+	cl.Add(59 * time.Second)
+	assert.True(t, store.Seen(secret.Secret("abc"), payload))
+
+	// once expired the payload is treated as a new message
+	cl.Add(time.Second)
+	assert.False(t, store.Seen(secret.Secret("abc"), payload))
+	assert.True(t, store.Seen(secret.Secret("abc"), payload))
+}
+
+func TestSeenSize(t *testing.T) {
+	cl := clock.NewMock(time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC))
+
+	store := dedup.NewStore(&dedup.Config{
+		TTL:   time.Minute,
+		Size:  2,
+		Clock: cl,
+	}, kitlog.NewNopLogger())
+
+	assert.False(t, store.Seen(secret.Secret("abc"), []byte("1")))
+	assert.False(t, store.Seen(secret.Secret("abc"), []byte("2")))
+	assert.False(t, store.Seen(secret.Secret("abc"), []byte("3")))
+
+	// the oldest key was discarded to make room
+	assert.True(t, store.Seen(secret.Secret("abc"), []byte("3")))
+	assert.True(t, store.Seen(secret.Secret("abc"), []byte("2")))
+	assert.False(t, store.Seen(secret.Secret("abc"), []byte("1")))
+}
+
+func TestPersistence(t *testing.T) {
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	cl := clock.NewMock(now)
+
+	p := &persister{
+		loaded: []*postgres.MessageKey{
+			{
+				Key:    dedup.Key(secret.Secret("abc"), []byte("1")),
+				SeenAt: now.Add(-time.Minute),
+			},
+		},
+	}
+
+	store := dedup.NewStore(&dedup.Config{
+		Persister: p,
+		TTL:       time.Hour,
+		Interval:  time.Hour,
+		Clock:     cl,
+	}, kitlog.NewNopLogger())
+
+	err := store.Start()
+	assert.Nil(t, err)
+	assert.Equal(t, now.Add(-time.Hour), p.since)
+
+	// the loaded key means the message was received a minute ago
+	assert.True(t, store.Seen(secret.Secret("abc"), []byte("1")))
+	assert.False(t, store.Seen(secret.Secret("abc"), []byte("2")))
+	store.Commit(secret.Secret("abc"), []byte("2"))
+
+	// keys of messages not yet processed are not persisted
+	assert.False(t, store.Seen(secret.Secret("abc"), []byte("3")))
+
+	err = store.Stop()
+	assert.Nil(t, err)
+
+	assert.Len(t, p.saved, 1)
+	assert.Equal(t, dedup.Key(secret.Secret("abc"), []byte("2")), p.saved[0].Key)
+	assert.Equal(t, now, p.saved[0].SeenAt)
+
+	// a further flush with no new keys should write nothing
+	err = store.Flush()
+	assert.Nil(t, err)
+	assert.Len(t, p.saved, 1)
+}
+
+func TestForget(t *testing.T) {
+	store := dedup.NewStore(&dedup.Config{
+		TTL:   time.Minute,
+		Clock: clock.NewMock(time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)),
+	}, kitlog.NewNopLogger())
+
+	payload := []byte(`{"data":[]}`)
+
+	// a message which failed to be processed is not a duplicate when redelivered
+	assert.False(t, store.Seen(secret.Secret("abc"), payload))
+	store.Forget(secret.Secret("abc"), payload)
+	assert.False(t, store.Seen(secret.Secret("abc"), payload))
+	store.Commit(secret.Secret("abc"), payload)
+	assert.True(t, store.Seen(secret.Secret("abc"), payload))
+}
+
+func TestFlushFailure(t *testing.T) {
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	p := &persister{err: errors.New("connection refused")}
+
+	store := dedup.NewStore(&dedup.Config{
+		Persister: p,
+		TTL:       time.Hour,
+		Interval:  time.Hour,
+		Clock:     clock.NewMock(now),
+	}, kitlog.NewNopLogger())
+
+	assert.False(t, store.Seen(secret.Secret("abc"), []byte("1")))
+	store.Commit(secret.Secret("abc"), []byte("1"))
+
+	err := store.Flush()
+	assert.NotNil(t, err)
+	assert.Len(t, p.saved, 0)
+
+	assert.False(t, store.Seen(secret.Secret("abc"), []byte("2")))
+	store.Commit(secret.Secret("abc"), []byte("2"))
+
+	// keys which failed to be written are written by the next flush
+	p.err = nil
+
+	err = store.Flush()
+	assert.Nil(t, err)
+	assert.Len(t, p.saved, 2)
+	assert.Equal(t, dedup.Key(secret.Secret("abc"), []byte("1")), p.saved[0].Key)
+	assert.Equal(t, dedup.Key(secret.Secret("abc"), []byte("2")), p.saved[1].Key)
+}
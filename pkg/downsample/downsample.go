@@ -0,0 +1,182 @@
+// Package downsample limits the rate at which readings are forwarded for
+// downsampled streams. For each sensor of each stream we record when a reading
+// was last forwarded, and drop any reading that arrives before the stream's
+// interval has elapsed.
+package downsample
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/logger/level"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+)
+
+// Persister is the interface we require of a type able to save and load
+// downsample checkpoints. We define it here where we need it, and it is
+// satisfied by our postgres.DB type.
+type Persister interface {
+	GetDownsampleCheckpoints() ([]*postgres.DownsampleCheckpoint, error)
+	SaveDownsampleCheckpoints(checkpoints []*postgres.DownsampleCheckpoint) error
+}
+
+// Store is our in-memory store of the time at which a reading was last
+// forwarded for each stream and sensor. Checkpoints are periodically flushed to
+// the configured Persister so that they approximately survive restarts.
+type Store struct {
+	persister Persister
+	interval  time.Duration
+	clock     clock.Clock
+	logger    kitlog.Logger
+	quit      chan struct{}
+	wg        sync.WaitGroup
+
+	sync.Mutex
+	checkpoints map[string]*postgres.DownsampleCheckpoint
+	dirty       map[string]bool
+}
+
+// NewStore returns a new Store instance. It takes as parameters a Persister
+// (which may be nil in which case checkpoints are held purely in memory), the
+// interval at which checkpoints should be flushed, a clock and a logger.
+func NewStore(persister Persister, interval time.Duration, cl clock.Clock, logger kitlog.Logger) *Store {
+	logger = kitlog.With(logger, "module", "downsample")
+
+	return &Store{
+		persister:   persister,
+		interval:    interval,
+		clock:       cl,
+		logger:      logger,
+		checkpoints: make(map[string]*postgres.DownsampleCheckpoint),
+		dirty:       make(map[string]bool),
+	}
+}
+
+// Start loads any previously persisted checkpoints, and then starts a goroutine
+// which periodically writes checkpoints back to the Persister.
+func (s *Store) Start() error {
+	if s.persister == nil {
+		return nil
+	}
+
+	s.logger.Log("msg", "starting downsample store", "interval", s.interval)
+
+	if s.interval <= 0 {
+		return errors.New("downsample checkpoint interval must be positive")
+	}
+
+	checkpoints, err := s.persister.GetDownsampleCheckpoints()
+	if err != nil {
+		return errors.Wrap(err, "failed to load downsample checkpoints")
+	}
+
+	s.Lock()
+	for _, c := range checkpoints {
+		s.checkpoints[key(c.StreamID, c.SensorID)] = c
+	}
+	s.Unlock()
+
+	s.quit = make(chan struct{})
+	s.wg.Add(1)
+
+	go s.loop()
+
+	return nil
+}
+
+// Stop stops the flush goroutine, and writes any outstanding checkpoints to
+// the Persister.
+func (s *Store) Stop() error {
+	if s.persister == nil || s.quit == nil {
+		return nil
+	}
+
+	s.logger.Log("msg", "stopping downsample store")
+
+	close(s.quit)
+	s.wg.Wait()
+
+	return s.Flush()
+}
+
+// Flush writes all checkpoints modified since the last flush to the Persister.
+func (s *Store) Flush() error {
+	if s.persister == nil {
+		return nil
+	}
+
+	s.Lock()
+	checkpoints := make([]*postgres.DownsampleCheckpoint, 0, len(s.dirty))
+	for k := range s.dirty {
+		if c, ok := s.checkpoints[k]; ok {
+			cp := *c
+			checkpoints = append(checkpoints, &cp)
+		}
+	}
+	s.dirty = make(map[string]bool)
+	s.Unlock()
+
+	if len(checkpoints) == 0 {
+		return nil
+	}
+
+	return s.persister.SaveDownsampleCheckpoints(checkpoints)
+}
+
+// Allow returns true if a reading for the given stream and sensor should be
+// forwarded, that is if no reading has been forwarded within the last interval
+// seconds. When a reading is allowed we record the current time as the time
+// it was forwarded.
+func (s *Store) Allow(streamID string, sensorID int, interval uint32) bool {
+	now := s.clock.Now()
+	k := key(streamID, sensorID)
+
+	s.Lock()
+	defer s.Unlock()
+
+	c, ok := s.checkpoints[k]
+	if ok && now.Sub(c.LastForwardedAt) < time.Duration(interval)*time.Second {
+		return false
+	}
+
+	if !ok {
+		c = &postgres.DownsampleCheckpoint{StreamID: streamID, SensorID: sensorID}
+		s.checkpoints[k] = c
+	}
+
+	c.LastForwardedAt = now
+	s.dirty[k] = true
+
+	return true
+}
+
+// loop is run in a goroutine and flushes checkpoints on each tick of our
+// interval until the store is stopped.
+func (s *Store) loop() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := s.Flush()
+			if err != nil {
+				level.Error(s.logger).Log("msg", "failed to flush downsample checkpoints", "err", err)
+			}
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+// key returns the map key for the given stream and sensor.
+func key(streamID string, sensorID int) string {
+	return fmt.Sprintf("%s:%d", streamID, sensorID)
+}
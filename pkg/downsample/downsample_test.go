@@ -0,0 +1,84 @@
+package downsample_test
+
+import (
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/downsample"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+)
+
+type persister struct {
+	loaded []*postgres.DownsampleCheckpoint
+	saved  []*postgres.DownsampleCheckpoint
+}
+
+func (p *persister) GetDownsampleCheckpoints() ([]*postgres.DownsampleCheckpoint, error) {
+	return p.loaded, nil
+}
+
+func (p *persister) SaveDownsampleCheckpoints(c []*postgres.DownsampleCheckpoint) error {
+	p.saved = append(p.saved, c...)
+	return nil
+}
+
+func TestAllow(t *testing.T) {
+	cl := clock.NewMock(time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC))
+
+	store := downsample.NewStore(nil, time.Minute, cl, kitlog.NewNopLogger())
+
+	assert.True(t, store.Allow("abc", 12, 300))
+	assert.False(t, store.Allow("abc", 12, 300))
+
+	// other sensors and streams are tracked independently
+	assert.True(t, store.Allow("abc", 14, 300))
+	assert.True(t, store.Allow("def", 12, 300))
+
+	cl.Add(299 * time.Second)
+	assert.False(t, store.Allow("abc", 12, 300))
+
+	cl.Add(time.Second)
+	assert.True(t, store.Allow("abc", 12, 300))
+	assert.False(t, store.Allow("abc", 12, 300))
+}
+
+func TestPersistence(t *testing.T) {
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	cl := clock.NewMock(now)
+
+	p := &persister{
+		loaded: []*postgres.DownsampleCheckpoint{
+			{
+				StreamID:        "abc",
+				SensorID:        12,
+				LastForwardedAt: now.Add(-time.Minute),
+			},
+		},
+	}
+
+	store := downsample.NewStore(p, time.Hour, cl, kitlog.NewNopLogger())
+
+	err := store.Start()
+	assert.Nil(t, err)
+
+	// the loaded checkpoint means a reading was forwarded a minute ago
+	assert.False(t, store.Allow("abc", 12, 300))
+	assert.True(t, store.Allow("abc", 14, 300))
+
+	err = store.Stop()
+	assert.Nil(t, err)
+
+	assert.Len(t, p.saved, 1)
+	assert.Equal(t, "abc", p.saved[0].StreamID)
+	assert.Equal(t, 14, p.saved[0].SensorID)
+	assert.Equal(t, now, p.saved[0].LastForwardedAt)
+
+	// a further flush with no changes should write nothing
+	err = store.Flush()
+	assert.Nil(t, err)
+	assert.Len(t, p.saved, 1)
+}
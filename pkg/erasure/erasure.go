@@ -0,0 +1,196 @@
+// Package erasure asks datastores to delete the data written for a device,
+// e.g. when a community revokes a device and wants what it collected removed.
+// The datastore protocol definition we write with has no method for deleting
+// data, so deletion is requested from a DeleteData method served alongside
+// the datastore's twirp methods, called with JSON following twirp's
+// conventions. A datastore which doesn't serve the method responds with
+// twirp's bad_route error, which we report as deletion being unimplemented.
+package erasure
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	datastore "github.com/thingful/twirp-datastore-go"
+	"github.com/twitchtv/twirp"
+
+	"github.com/DECODEproject/iotencoder/pkg/logger"
+	"github.com/DECODEproject/iotencoder/pkg/requestid"
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+)
+
+const (
+	// Method is the name of the datastore method called to delete data.
+	Method = "DeleteData"
+
+	// Deleted is the result of a deletion accepted by the datastore.
+	Deleted = "deleted"
+
+	// Failed is the result of a deletion which the datastore could not be
+	// asked to perform, or which it refused.
+	Failed = "failed"
+)
+
+var (
+	// DeletionsCounter is a prometheus counter vec recording the number of
+	// deletions requested of datastores, labelled by the result.
+	DeletionsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "datastore_deletions",
+			Help:      "Count of requests asking a datastore to delete the data of a device, by result",
+		},
+		[]string{"result"},
+	)
+)
+
+// HTTPClient is the interface used to send deletion requests. It is satisfied
+// by *http.Client.
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Request is the body of a DeleteData request, identifying the data to be
+// deleted by the community for which it was written and the device which
+// sent it, as recorded by each WriteRequest.
+type Request struct {
+	CommunityId string `json:"community_id"`
+	DeviceToken string `json:"device_token"`
+}
+
+// Response is the body of a successful DeleteData response, reporting the
+// number of events deleted.
+type Response struct {
+	Deleted int64 `json:"deleted"`
+}
+
+// Config is used to pass in configuration when creating a Client. DefaultAddr
+// is the address of the datastore to which streams without a datastore of
+// their own are written. HTTPClient is optional, and if nil http.DefaultClient
+// is used.
+type Config struct {
+	DefaultAddr string
+	HTTPClient  HTTPClient
+}
+
+// Client sends deletion requests to datastores.
+type Client struct {
+	defaultAddr string
+	client      HTTPClient
+	logger      kitlog.Logger
+}
+
+// NewClient returns a new Client configured with the given Config.
+func NewClient(config *Config, logger kitlog.Logger) *Client {
+	client := config.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &Client{
+		defaultAddr: config.DefaultAddr,
+		client:      client,
+		logger:      kitlog.With(logger, "module", "erasure"),
+	}
+}
+
+// DeleteData asks the datastore at the given address, or our default
+// datastore if empty, to delete every event written for the given community by
+// the given device, returning the number of events deleted. Errors are twirp
+// errors, those returned by the datastore being passed through.
+func (c *Client) DeleteData(ctx context.Context, addr, communityID string, deviceToken secret.Secret) (int64, error) {
+	if addr == "" {
+		addr = c.defaultAddr
+	}
+
+	deleted, err := c.deleteData(ctx, addr, communityID, deviceToken)
+	if err != nil {
+		DeletionsCounter.WithLabelValues(Failed).Inc()
+		return 0, err
+	}
+
+	DeletionsCounter.WithLabelValues(Deleted).Inc()
+
+	c.logger.Log("msg", "deleted datastore data", "datastore", addr, "community_id", communityID, "device", logger.HashToken(deviceToken), "deleted", deleted)
+
+	return deleted, nil
+}
+
+// deleteData sends a DeleteData request to the datastore at the given address.
+func (c *Client) deleteData(ctx context.Context, addr, communityID string, deviceToken secret.Secret) (int64, error) {
+	body, err := json.Marshal(&Request{
+		CommunityId: communityID,
+		DeviceToken: deviceToken.Reveal(),
+	})
+	if err != nil {
+		return 0, twirp.InternalErrorWith(err)
+	}
+
+	url := strings.TrimSuffix(addr, "/") + datastore.DatastorePathPrefix + Method
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, twirp.InternalErrorWith(err)
+	}
+
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+
+	if id := requestid.FromContext(ctx); id != "" {
+		req.Header.Set(requestid.Header, id)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, twirp.NewError(twirp.Unavailable, "failed to connect to datastore")
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return 0, twirp.InternalErrorWith(err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, errorFromResponse(resp.StatusCode, b)
+	}
+
+	var r Response
+
+	err = json.Unmarshal(b, &r)
+	if err != nil {
+		return 0, twirp.InternalError("unexpected response from datastore")
+	}
+
+	return r.Deleted, nil
+}
+
+// errorFromResponse converts a twirp error response from a datastore into a
+// twirp.Error, reporting a datastore without a DeleteData method as not
+// implementing deletion.
+func errorFromResponse(statusCode int, body []byte) twirp.Error {
+	var tj struct {
+		Code string `json:"code"`
+		Msg  string `json:"msg"`
+	}
+
+	err := json.Unmarshal(body, &tj)
+	if err != nil || !twirp.IsValidErrorCode(twirp.ErrorCode(tj.Code)) {
+		return twirp.InternalError(fmt.Sprintf("unexpected response from datastore with HTTP status code %d", statusCode))
+	}
+
+	if twirp.ErrorCode(tj.Code) == twirp.BadRoute {
+		return twirp.NewError(twirp.Unimplemented, "datastore does not support deletion")
+	}
+
+	return twirp.NewError(twirp.ErrorCode(tj.Code), tj.Msg)
+}
@@ -0,0 +1,84 @@
+package erasure_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/twitchtv/twirp"
+
+	"github.com/DECODEproject/iotencoder/pkg/erasure"
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+)
+
+func TestDeleteData(t *testing.T) {
+	var req erasure.Request
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/twirp/decode.iot.datastore.Datastore/DeleteData", r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+
+		err := json.NewDecoder(r.Body).Decode(&req)
+		assert.Nil(t, err)
+
+		w.Write([]byte(`{"deleted":12}`))
+	}))
+	defer ts.Close()
+
+	client := erasure.NewClient(&erasure.Config{DefaultAddr: ts.URL}, kitlog.NewNopLogger())
+
+	// an empty address is our default datastore
+	deleted, err := client.DeleteData(context.Background(), "", "smartcitizen", secret.Secret("abc123"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(12), deleted)
+	assert.Equal(t, erasure.Request{CommunityId: "smartcitizen", DeviceToken: "abc123"}, req)
+}
+
+func TestDeleteDataErrors(t *testing.T) {
+	testcases := []struct {
+		label    string
+		status   int
+		response string
+		code     twirp.ErrorCode
+	}{
+		{
+			label:    "not implemented",
+			status:   http.StatusNotFound,
+			response: `{"code":"bad_route","msg":"no handler for path"}`,
+			code:     twirp.Unimplemented,
+		},
+		{
+			label:    "datastore error",
+			status:   http.StatusBadRequest,
+			response: `{"code":"invalid_argument","msg":"community_id is required"}`,
+			code:     twirp.InvalidArgument,
+		},
+		{
+			label:    "not twirp",
+			status:   http.StatusBadGateway,
+			response: `bad gateway`,
+			code:     twirp.Internal,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.status)
+				w.Write([]byte(tc.response))
+			}))
+			defer ts.Close()
+
+			client := erasure.NewClient(&erasure.Config{}, kitlog.NewNopLogger())
+
+			_, err := client.DeleteData(context.Background(), ts.URL, "smartcitizen", secret.Secret("abc123"))
+			if assert.NotNil(t, err) {
+				assert.Equal(t, tc.code, err.(twirp.Error).Code())
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+// Package events is a lightweight in-process event bus. Subsystems publish
+// events describing changes to our subscriptions and streams, and consumers
+// such as metrics and the admin API subscribe to them, rather than each
+// subsystem calling the others directly. Events are delivered asynchronously
+// and on a best effort basis: each subscriber has a buffer of its own, and
+// events which would overflow it are dropped rather than blocking the
+// publisher.
+package events
+
+import (
+	"sync"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/DECODEproject/iotencoder/pkg/logger/level"
+)
+
+// Type is the type of an event.
+type Type string
+
+const (
+	// SubscriptionCreated is published when we subscribe to a device with one
+	// of our sources.
+	SubscriptionCreated Type = "subscription_created"
+
+	// SubscriptionLost is published when the connection to an MQTT broker, and
+	// so every subscription made through it, is lost.
+	SubscriptionLost Type = "subscription_lost"
+
+	// SubscriptionRestored is published when our subscriptions are made again
+	// through a broker after the connection was lost.
+	SubscriptionRestored Type = "subscription_restored"
+
+	// StreamCreated is published when a stream is created.
+	StreamCreated Type = "stream_created"
+
+	// StreamDeleted is published when a stream is deleted.
+	StreamDeleted Type = "stream_deleted"
+
+	// StreamRestored is published when a deleted stream is restored.
+	StreamRestored Type = "stream_restored"
+
+	// StreamImported is published when a stream exported from another encoder
+	// is imported.
+	StreamImported Type = "stream_imported"
+
+	// DefaultBufferSize is the number of events buffered for each subscriber
+	// if no size is given.
+	DefaultBufferSize = 100
+)
+
+var (
+	// EventsCounter is a prometheus counter vec recording the number of events
+	// delivered to the Count subscriber, labelled by their type.
+	EventsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "events",
+			Help:      "Count of events published on the internal event bus, by type",
+		},
+		[]string{"type"},
+	)
+
+	// DroppedCounter is a prometheus counter vec recording the number of events
+	// dropped as a subscriber's buffer was full, labelled by the subscriber.
+	DroppedCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "events_dropped",
+			Help:      "Count of events dropped as a subscriber of the internal event bus fell behind",
+		},
+		[]string{"subscriber"},
+	)
+)
+
+// Event is a change published on the bus. Only the fields relevant to the type
+// of the event are set.
+type Event struct {
+	Type        Type      `json:"type"`
+	Time        time.Time `json:"time"`
+	StreamID    string    `json:"stream_uid,omitempty"`
+	CommunityID string    `json:"community_id,omitempty"`
+	DeviceHash  string    `json:"device_hash,omitempty"`
+	Source      string    `json:"source,omitempty"`
+	Broker      string    `json:"broker,omitempty"`
+	Err         string    `json:"error,omitempty"`
+}
+
+// Handler is a function called with each event delivered to a subscriber.
+type Handler func(event *Event)
+
+// subscriber is a consumer of the bus, whose events are buffered in a channel
+// and delivered to its handler by a goroutine of its own.
+type subscriber struct {
+	name    string
+	events  chan *Event
+	handler Handler
+}
+
+// Bus delivers published events to each of its subscribers. A nil Bus may be
+// published to, with events discarded, so that publishing is optional for the
+// components we configure with one.
+type Bus struct {
+	logger     kitlog.Logger
+	bufferSize int
+	wg         sync.WaitGroup
+
+	sync.RWMutex
+	subscribers map[*subscriber]bool
+	closed      bool
+}
+
+// NewBus returns a new Bus buffering the given number of events for each
+// subscriber.
+func NewBus(bufferSize int, logger kitlog.Logger) *Bus {
+	if bufferSize <= 0 {
+		bufferSize = DefaultBufferSize
+	}
+
+	return &Bus{
+		logger:      kitlog.With(logger, "module", "events"),
+		bufferSize:  bufferSize,
+		subscribers: make(map[*subscriber]bool),
+	}
+}
+
+// Start is a no-op, as subscribers are started as they subscribe. It exists
+// so that the bus may be registered as a component of our lifecycle.
+func (b *Bus) Start() error {
+	return nil
+}
+
+// Stop closes the bus, waiting for the events already buffered to be
+// delivered. Events published after the bus is stopped are discarded.
+func (b *Bus) Stop() error {
+	b.Lock()
+	if b.closed {
+		b.Unlock()
+		return nil
+	}
+
+	b.logger.Log("msg", "stopping event bus")
+
+	b.closed = true
+	for s := range b.subscribers {
+		close(s.events)
+		delete(b.subscribers, s)
+	}
+	b.Unlock()
+
+	b.wg.Wait()
+
+	return nil
+}
+
+// Subscribe registers a handler to which every event subsequently published
+// is delivered, in the order published. The name identifies the subscriber in
+// our metrics. The returned function unsubscribes the handler.
+func (b *Bus) Subscribe(name string, handler Handler) func() {
+	s := &subscriber{
+		name:    name,
+		events:  make(chan *Event, b.bufferSize),
+		handler: handler,
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	if b.closed {
+		return func() {}
+	}
+
+	b.subscribers[s] = true
+	b.wg.Add(1)
+
+	go b.deliver(s)
+
+	return func() {
+		b.Lock()
+		defer b.Unlock()
+
+		if b.subscribers[s] {
+			close(s.events)
+			delete(b.subscribers, s)
+		}
+	}
+}
+
+// Publish delivers the given event to every subscriber, setting its time if
+// not already set. Publish never blocks, an event is instead dropped for any
+// subscriber whose buffer is full.
+func (b *Bus) Publish(event *Event) {
+	if b == nil {
+		return
+	}
+
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	b.RLock()
+	defer b.RUnlock()
+
+	for s := range b.subscribers {
+		select {
+		case s.events <- event:
+		default:
+			DroppedCounter.WithLabelValues(s.name).Inc()
+			level.Warn(b.logger).Log("msg", "dropped event", "subscriber", s.name, "type", event.Type)
+		}
+	}
+}
+
+// Count is a handler which counts the events delivered to it by type, and is
+// subscribed to the bus to expose our events as metrics.
+func Count(event *Event) {
+	EventsCounter.WithLabelValues(string(event.Type)).Inc()
+}
+
+// deliver is run in a goroutine for each subscriber, and calls its handler
+// with each of its events until it is unsubscribed or the bus is stopped.
+func (b *Bus) deliver(s *subscriber) {
+	defer b.wg.Done()
+
+	for event := range s.events {
+		s.handler(event)
+	}
+}
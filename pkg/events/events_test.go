@@ -0,0 +1,103 @@
+package events_test
+
+import (
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/events"
+)
+
+func TestBus(t *testing.T) {
+	bus := events.NewBus(10, kitlog.NewNopLogger())
+
+	received := make(chan *events.Event, 10)
+	bus.Subscribe("test", func(event *events.Event) {
+		received <- event
+	})
+
+	recorder := events.NewRecorder(10)
+	unsubscribe := bus.Subscribe("recorder", recorder.Record)
+
+	bus.Publish(&events.Event{Type: events.StreamCreated, StreamID: "abc"})
+	bus.Publish(&events.Event{Type: events.StreamDeleted, StreamID: "abc"})
+
+	select {
+	case event := <-received:
+		assert.Equal(t, events.StreamCreated, event.Type)
+		assert.False(t, event.Time.IsZero())
+	case <-time.After(time.Second):
+		t.Fatal("event not delivered")
+	}
+
+	unsubscribe()
+
+	// stopping the bus waits for buffered events to be delivered
+	assert.Nil(t, bus.Stop())
+	assert.Len(t, received, 1)
+
+	// events published after the bus is stopped are discarded
+	bus.Publish(&events.Event{Type: events.StreamCreated})
+	assert.Len(t, received, 1)
+}
+
+func TestPublishNilBus(t *testing.T) {
+	var bus *events.Bus
+
+	assert.NotPanics(t, func() {
+		bus.Publish(&events.Event{Type: events.StreamCreated})
+	})
+}
+
+func TestPublishDoesNotBlock(t *testing.T) {
+	bus := events.NewBus(1, kitlog.NewNopLogger())
+
+	block := make(chan struct{})
+	bus.Subscribe("slow", func(event *events.Event) {
+		<-block
+	})
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 5; i++ {
+			bus.Publish(&events.Event{Type: events.SubscriptionLost})
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("publish blocked on a slow subscriber")
+	}
+
+	close(block)
+	assert.Nil(t, bus.Stop())
+}
+
+func TestRecorder(t *testing.T) {
+	recorder := events.NewRecorder(3)
+
+	assert.Empty(t, recorder.Recent("", 0))
+
+	recorder.Record(&events.Event{Type: events.StreamCreated, StreamID: "a"})
+	recorder.Record(&events.Event{Type: events.SubscriptionCreated, DeviceHash: "h"})
+	recorder.Record(&events.Event{Type: events.StreamCreated, StreamID: "b"})
+	recorder.Record(&events.Event{Type: events.StreamCreated, StreamID: "c"})
+
+	// the oldest event is replaced, and the most recent returned first
+	recent := recorder.Recent("", 0)
+	assert.Len(t, recent, 3)
+	assert.Equal(t, "c", recent[0].StreamID)
+	assert.Equal(t, events.SubscriptionCreated, recent[2].Type)
+
+	recent = recorder.Recent(events.StreamCreated, 0)
+	assert.Len(t, recent, 2)
+	assert.Equal(t, "b", recent[1].StreamID)
+
+	recent = recorder.Recent("", 1)
+	assert.Len(t, recent, 1)
+	assert.Equal(t, "c", recent[0].StreamID)
+}
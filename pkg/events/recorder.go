@@ -0,0 +1,77 @@
+package events
+
+import (
+	"sync"
+)
+
+// DefaultRecorderSize is the number of recent events held by a Recorder if no
+// size is given.
+const DefaultRecorderSize = 500
+
+// Recorder holds the most recent events delivered to it in memory, so that
+// they may be inspected through the admin API. It is subscribed to the bus
+// via its Record method.
+type Recorder struct {
+	sync.Mutex
+	size   int
+	events []*Event
+	next   int
+	full   bool
+}
+
+// NewRecorder returns a new Recorder holding the given number of events.
+func NewRecorder(size int) *Recorder {
+	if size <= 0 {
+		size = DefaultRecorderSize
+	}
+
+	return &Recorder{
+		size:   size,
+		events: make([]*Event, size),
+	}
+}
+
+// Record records an event, replacing the oldest event held if the recorder is
+// full.
+func (r *Recorder) Record(event *Event) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.events[r.next] = event
+	r.next = (r.next + 1) % r.size
+
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// Recent returns up to limit of the events held, most recent first, which are
+// of the given type or any type if typ is empty. A limit of zero returns every
+// matching event held.
+func (r *Recorder) Recent(typ Type, limit int) []*Event {
+	r.Lock()
+	defer r.Unlock()
+
+	n := r.next
+	if r.full {
+		n = r.size
+	}
+
+	recent := []*Event{}
+
+	for i := 1; i <= n; i++ {
+		event := r.events[(r.next-i+r.size)%r.size]
+
+		if typ != "" && event.Type != typ {
+			continue
+		}
+
+		recent = append(recent, event)
+
+		if limit > 0 && len(recent) == limit {
+			break
+		}
+	}
+
+	return recent
+}
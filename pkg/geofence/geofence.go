@@ -0,0 +1,224 @@
+// Package geofence restricts the locations from which the readings of mobile
+// devices are shared. A fence is made up of circles and polygons, and readings
+// reported from a location outside all of them are dropped.
+package geofence
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// earthRadius is the mean radius of the earth in metres.
+const earthRadius = 6371000
+
+var (
+	// OutsideCounter is a prometheus counter recording a count of messages
+	// dropped as they were reported from outside their stream's fence.
+	OutsideCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "outside_geofence_messages",
+			Help:      "Count of messages dropped as they were reported from outside their stream's geofence",
+		},
+	)
+)
+
+// Point is a location in decimal degrees.
+type Point struct {
+	Latitude  float64
+	Longitude float64
+}
+
+// Circle is an area within Radius metres of Centre.
+type Circle struct {
+	Centre Point
+	Radius float64
+}
+
+// contains returns true if the point is within the circle.
+func (c Circle) contains(p Point) bool {
+	return distance(c.Centre, p) <= c.Radius
+}
+
+// Polygon is the area enclosed by a ring of points, the last of which is
+// joined to the first. Its edges are straight lines in latitude and longitude,
+// which is accurate enough for fences of the size of a city, and it must not
+// cross the antimeridian.
+type Polygon []Point
+
+// contains returns true if the point is within the polygon, by counting the
+// edges crossed by a ray cast from it.
+func (poly Polygon) contains(p Point) bool {
+	inside := false
+
+	for i, j := 0, len(poly)-1; i < len(poly); j, i = i, i+1 {
+		a, b := poly[i], poly[j]
+
+		if (a.Latitude > p.Latitude) != (b.Latitude > p.Latitude) &&
+			p.Longitude < (b.Longitude-a.Longitude)*(p.Latitude-a.Latitude)/(b.Latitude-a.Latitude)+a.Longitude {
+			inside = !inside
+		}
+	}
+
+	return inside
+}
+
+// Spec is the fence of a stream, made up of any number of circles and
+// polygons. A location is within the fence if it is within any of them.
+type Spec struct {
+	Circles  []Circle
+	Polygons []Polygon
+}
+
+// Parse parses a spec from its string form, a semicolon separated list of
+// shapes. A circle is given by its centre and radius in metres or kilometres,
+// and a polygon by three or more points as latitude and longitude pairs, e.g.
+// "circle=41.3851,2.1734,2km;polygon=41.38,2.16,41.39,2.16,41.39,2.18".
+func Parse(s string) (*Spec, error) {
+	spec := &Spec{}
+
+	for _, shape := range strings.Split(s, ";") {
+		kv := strings.SplitN(strings.TrimSpace(shape), "=", 2)
+		if len(kv) != 2 {
+			return nil, errors.Errorf("invalid geofence shape %q, must be circle=... or polygon=...", shape)
+		}
+
+		values := strings.Split(kv[1], ",")
+
+		switch kv[0] {
+		case "circle":
+			circle, err := parseCircle(values)
+			if err != nil {
+				return nil, err
+			}
+			spec.Circles = append(spec.Circles, circle)
+		case "polygon":
+			polygon, err := parsePolygon(values)
+			if err != nil {
+				return nil, err
+			}
+			spec.Polygons = append(spec.Polygons, polygon)
+		default:
+			return nil, errors.Errorf("unknown geofence shape: %s", kv[0])
+		}
+	}
+
+	return spec, nil
+}
+
+// parseCircle parses a circle from its centre's latitude and longitude and its
+// radius.
+func parseCircle(values []string) (Circle, error) {
+	if len(values) != 3 {
+		return Circle{}, errors.New("invalid geofence circle, must be latitude,longitude,radius")
+	}
+
+	centre, err := parsePoint(values[0], values[1])
+	if err != nil {
+		return Circle{}, err
+	}
+
+	radius, err := parseDistance(values[2])
+	if err != nil {
+		return Circle{}, err
+	}
+
+	return Circle{Centre: centre, Radius: radius}, nil
+}
+
+// parsePolygon parses a polygon from a list of latitude and longitude pairs.
+func parsePolygon(values []string) (Polygon, error) {
+	if len(values)%2 != 0 || len(values) < 6 {
+		return nil, errors.New("invalid geofence polygon, must be three or more latitude,longitude pairs")
+	}
+
+	polygon := Polygon{}
+
+	for i := 0; i < len(values); i += 2 {
+		point, err := parsePoint(values[i], values[i+1])
+		if err != nil {
+			return nil, err
+		}
+
+		polygon = append(polygon, point)
+	}
+
+	return polygon, nil
+}
+
+// parsePoint parses a point from its latitude and longitude in decimal degrees.
+func parsePoint(lat, lon string) (Point, error) {
+	latitude, err := strconv.ParseFloat(strings.TrimSpace(lat), 64)
+	if err != nil || math.IsNaN(latitude) || latitude < -90 || latitude > 90 {
+		return Point{}, errors.Errorf("invalid geofence latitude: %s", lat)
+	}
+
+	longitude, err := strconv.ParseFloat(strings.TrimSpace(lon), 64)
+	if err != nil || math.IsNaN(longitude) || longitude < -180 || longitude > 180 {
+		return Point{}, errors.Errorf("invalid geofence longitude: %s", lon)
+	}
+
+	return Point{Latitude: latitude, Longitude: longitude}, nil
+}
+
+// parseDistance parses a positive distance with a unit of m or km, returning
+// the distance in metres.
+func parseDistance(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+
+	multiplier := 1.0
+	number := strings.TrimSuffix(s, "m")
+
+	if strings.HasSuffix(number, "k") {
+		multiplier = 1000
+		number = strings.TrimSuffix(number, "k")
+	}
+
+	if number == s {
+		return 0, errors.Errorf("invalid geofence radius, must be in m or km: %s", s)
+	}
+
+	distance, err := strconv.ParseFloat(number, 64)
+	if err != nil || math.IsNaN(distance) || math.IsInf(distance, 0) || distance <= 0 {
+		return 0, errors.Errorf("invalid geofence radius, must be a positive distance: %s", s)
+	}
+
+	return distance * multiplier, nil
+}
+
+// Contains returns true if the given location is within the fence.
+func (s *Spec) Contains(lat, lon float64) bool {
+	p := Point{Latitude: lat, Longitude: lon}
+
+	for _, c := range s.Circles {
+		if c.contains(p) {
+			return true
+		}
+	}
+
+	for _, poly := range s.Polygons {
+		if poly.contains(p) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// distance returns the great circle distance in metres between two points,
+// using the haversine formula.
+func distance(a, b Point) float64 {
+	lat1 := a.Latitude * math.Pi / 180
+	lat2 := b.Latitude * math.Pi / 180
+	dLat := lat2 - lat1
+	dLon := (b.Longitude - a.Longitude) * math.Pi / 180
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
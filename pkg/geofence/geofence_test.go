@@ -0,0 +1,91 @@
+package geofence_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/geofence"
+)
+
+func TestParse(t *testing.T) {
+	testcases := []struct {
+		input    string
+		expected *geofence.Spec
+	}{
+		{
+			"circle=41.3851,2.1734,2km",
+			&geofence.Spec{
+				Circles: []geofence.Circle{
+					{Centre: geofence.Point{Latitude: 41.3851, Longitude: 2.1734}, Radius: 2000},
+				},
+			},
+		},
+		{
+			"circle=41.3851,2.1734,500m; polygon=41.38,2.16,41.39,2.16,41.39,2.18",
+			&geofence.Spec{
+				Circles: []geofence.Circle{
+					{Centre: geofence.Point{Latitude: 41.3851, Longitude: 2.1734}, Radius: 500},
+				},
+				Polygons: []geofence.Polygon{
+					{
+						{Latitude: 41.38, Longitude: 2.16},
+						{Latitude: 41.39, Longitude: 2.16},
+						{Latitude: 41.39, Longitude: 2.18},
+					},
+				},
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.input, func(t *testing.T) {
+			spec, err := geofence.Parse(tc.input)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, spec)
+		})
+	}
+
+	for _, input := range []string{
+		"",
+		"circle",
+		"circle=41.3851,2.1734",
+		"circle=41.3851,2.1734,500",
+		"circle=41.3851,2.1734,-5m",
+		"circle=91,2.1734,500m",
+		"circle=41.3851,181,500m",
+		"polygon=41.38,2.16,41.39,2.16",
+		"polygon=41.38,2.16,41.39,2.16,41.39",
+		"square=41.38,2.16,1km",
+	} {
+		t.Run(input, func(t *testing.T) {
+			_, err := geofence.Parse(input)
+			assert.NotNil(t, err)
+		})
+	}
+}
+
+func TestContains(t *testing.T) {
+	spec, err := geofence.Parse("circle=41.3851,2.1734,1km;polygon=41.40,2.10,41.42,2.10,41.42,2.12,41.40,2.12")
+	assert.Nil(t, err)
+
+	testcases := []struct {
+		label    string
+		lat      float64
+		lon      float64
+		expected bool
+	}{
+		{"centre of circle", 41.3851, 2.1734, true},
+		{"within circle", 41.3901, 2.1734, true},
+		{"just outside circle", 41.3951, 2.1734, false},
+		{"within polygon", 41.41, 2.11, true},
+		{"outside polygon", 41.43, 2.11, false},
+		{"elsewhere", 40.4168, -3.7038, false},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			assert.Equal(t, tc.expected, spec.Contains(tc.lat, tc.lon))
+		})
+	}
+}
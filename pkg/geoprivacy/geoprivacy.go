@@ -0,0 +1,231 @@
+// Package geoprivacy protects the location of devices in sensitive
+// deployments. The coordinates written with a stream's readings are snapped to
+// the centre of a grid cell of a configured size, and if k-anonymity is
+// required the location is suppressed entirely unless at least k devices have
+// reported from the same cell within a window, so that no device can be
+// singled out by its location.
+package geoprivacy
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+)
+
+const (
+	// metresPerDegree is the length in metres of a degree of latitude, and of
+	// a degree of longitude at the equator.
+	metresPerDegree = 111320
+
+	// sweepInterval is the number of observations between sweeps of a
+	// Tracker for cells from which no device has reported within the window.
+	sweepInterval = 1000
+)
+
+var (
+	// SuppressedCounter is a prometheus counter recording a count of readings
+	// whose location was suppressed as fewer than k devices had reported from
+	// its cell.
+	SuppressedCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "locations_suppressed",
+			Help:      "Count of readings whose location was suppressed for k-anonymity",
+		},
+	)
+)
+
+// Spec is the geo-privacy configuration of a stream. Grid is the size in
+// metres of the cells to which coordinates are snapped. If K is greater than
+// one, locations are suppressed unless at least K devices have reported from
+// the same cell within Window.
+type Spec struct {
+	Grid   float64
+	K      int
+	Window time.Duration
+}
+
+// Parse parses a spec from its string form, a comma separated list of
+// parameters, e.g. "grid=500m,k=5,window=1h". The grid size is required, and
+// may be given in metres or kilometres, while the window is required if k is.
+func Parse(s string) (*Spec, error) {
+	spec := &Spec{}
+
+	for _, param := range strings.Split(s, ",") {
+		kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(kv) != 2 {
+			return nil, errors.Errorf("invalid geo-privacy parameter %q, must be name=value", param)
+		}
+
+		switch kv[0] {
+		case "grid":
+			grid, err := parseDistance(kv[1])
+			if err != nil {
+				return nil, err
+			}
+			spec.Grid = grid
+		case "k":
+			k, err := strconv.Atoi(kv[1])
+			if err != nil || k < 1 {
+				return nil, errors.Errorf("invalid geo-privacy k, must be a positive integer: %s", kv[1])
+			}
+			spec.K = k
+		case "window":
+			window, err := time.ParseDuration(kv[1])
+			if err != nil || window <= 0 {
+				return nil, errors.Errorf("invalid geo-privacy window: %s", kv[1])
+			}
+			spec.Window = window
+		default:
+			return nil, errors.Errorf("unknown geo-privacy parameter: %s", kv[0])
+		}
+	}
+
+	if spec.Grid == 0 {
+		return nil, errors.New("geo-privacy requires a grid size")
+	}
+
+	if spec.K > 1 && spec.Window == 0 {
+		return nil, errors.New("geo-privacy k requires a window")
+	}
+
+	return spec, nil
+}
+
+// Valid returns true if the given string is a spec Parse accepts.
+func Valid(s string) bool {
+	_, err := Parse(s)
+	return err == nil
+}
+
+// parseDistance parses a positive distance with a unit of m or km, returning
+// the distance in metres.
+func parseDistance(s string) (float64, error) {
+	multiplier := 1.0
+	number := strings.TrimSuffix(s, "m")
+
+	if strings.HasSuffix(number, "k") {
+		multiplier = 1000
+		number = strings.TrimSuffix(number, "k")
+	}
+
+	if number == s {
+		return 0, errors.Errorf("invalid geo-privacy grid size, must be in m or km: %s", s)
+	}
+
+	distance, err := strconv.ParseFloat(number, 64)
+	if err != nil || math.IsNaN(distance) || math.IsInf(distance, 0) || distance <= 0 {
+		return 0, errors.Errorf("invalid geo-privacy grid size: %s", s)
+	}
+
+	return distance * multiplier, nil
+}
+
+// Snap returns the centre of the grid cell containing the given coordinates,
+// along with an identifier of the cell. Cells are a fixed number of degrees of
+// latitude tall, while their width in degrees of longitude depends on the
+// latitude of their row, so that cells are roughly square away from the poles.
+func (s *Spec) Snap(latitude, longitude float64) (float64, float64, string) {
+	latStep := s.Grid / metresPerDegree
+	row := math.Floor((latitude + 90) / latStep)
+	lat := math.Min(-90+(row+0.5)*latStep, 90)
+
+	// the width of a cell is that of its row at its centre, though as degrees
+	// of longitude shrink to nothing at the poles no cell is wider than the
+	// whole row
+	lonStep := math.Min(latStep/math.Max(math.Cos(lat*math.Pi/180), latStep/360), 360)
+	col := math.Floor((longitude + 180) / lonStep)
+	lon := -180 + (col+0.5)*lonStep
+
+	if lon > 180 {
+		lon -= 360
+	}
+
+	return lat, lon, fmt.Sprintf("%g:%d:%d", s.Grid, int64(row), int64(col))
+}
+
+// Tracker counts the distinct devices which have reported from each cell
+// within a window. It is held in memory, so after a restart cells are
+// suppressed until enough devices have reported again, and where devices are
+// partitioned between instances each counts only the devices it owns.
+type Tracker struct {
+	sync.Mutex
+
+	clock        clock.Clock
+	cells        map[string]map[string]time.Time
+	observations int
+}
+
+// NewTracker returns a new Tracker using the given clock.
+func NewTracker(cl clock.Clock) *Tracker {
+	return &Tracker{
+		clock: cl,
+		cells: make(map[string]map[string]time.Time),
+	}
+}
+
+// Observe records that the given device reported from the given cell, and
+// returns the number of distinct devices, including this one, which have
+// reported from the cell within the window.
+func (t *Tracker) Observe(cell, device string, window time.Duration) int {
+	t.Lock()
+	defer t.Unlock()
+
+	now := t.clock.Now()
+
+	// cells observed with different windows are counted separately, so that
+	// each count only holds devices within its window
+	key := fmt.Sprintf("%s/%s", cell, window)
+
+	devices, ok := t.cells[key]
+	if !ok {
+		devices = make(map[string]time.Time)
+		t.cells[key] = devices
+	}
+
+	devices[device] = now
+
+	for d, seen := range devices {
+		if now.Sub(seen) > window {
+			delete(devices, d)
+		}
+	}
+
+	t.observations++
+	if t.observations%sweepInterval == 0 {
+		t.sweep(now)
+	}
+
+	return len(devices)
+}
+
+// sweep deletes every cell from which no device has reported within its
+// window. It must be called with the lock held.
+func (t *Tracker) sweep(now time.Time) {
+	for key, devices := range t.cells {
+		window, err := time.ParseDuration(key[strings.LastIndex(key, "/")+1:])
+		if err != nil {
+			continue
+		}
+
+		latest := time.Time{}
+		for _, seen := range devices {
+			if seen.After(latest) {
+				latest = seen
+			}
+		}
+
+		if now.Sub(latest) > window {
+			delete(t.cells, key)
+		}
+	}
+}
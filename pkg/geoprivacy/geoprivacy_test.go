@@ -0,0 +1,92 @@
+package geoprivacy_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/geoprivacy"
+)
+
+func TestParse(t *testing.T) {
+	testcases := []struct {
+		input    string
+		expected *geoprivacy.Spec
+	}{
+		{"grid=500m", &geoprivacy.Spec{Grid: 500}},
+		{"grid=1.5km", &geoprivacy.Spec{Grid: 1500}},
+		{"grid=500m,k=5,window=1h", &geoprivacy.Spec{Grid: 500, K: 5, Window: time.Hour}},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.input, func(t *testing.T) {
+			spec, err := geoprivacy.Parse(tc.input)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, spec)
+		})
+	}
+
+	for _, input := range []string{
+		"",
+		"grid",
+		"grid=500",
+		"grid=0m",
+		"grid=-1km",
+		"grid=mm",
+		"k=5,window=1h",
+		"grid=500m,k=0",
+		"grid=500m,k=5",
+		"grid=500m,k=5,window=0s",
+		"grid=500m,size=5",
+	} {
+		assert.False(t, geoprivacy.Valid(input), input)
+	}
+}
+
+func TestSnap(t *testing.T) {
+	spec, _ := geoprivacy.Parse("grid=500m")
+
+	lat, lon, cell := spec.Snap(41.3851, 2.1734)
+
+	// the snapped coordinates are within half a cell of the originals
+	assert.InDelta(t, 41.3851, lat, 250.0/111320)
+	assert.InDelta(t, 2.1734, lon, 250.0/(111320*0.75))
+
+	// nearby coordinates share a cell, snapping to the same centre
+	nearLat, nearLon, nearCell := spec.Snap(lat+0.0001, lon-0.0001)
+	assert.Equal(t, cell, nearCell)
+	assert.Equal(t, lat, nearLat)
+	assert.Equal(t, lon, nearLon)
+
+	// while distant ones don't
+	_, _, farCell := spec.Snap(41.3951, 2.1734)
+	assert.NotEqual(t, cell, farCell)
+
+	// and coordinates at the poles and antimeridian snap within range
+	for _, coords := range [][2]float64{{90, 180}, {-90, -180}, {89.9999, 179.9999}} {
+		lat, lon, _ := spec.Snap(coords[0], coords[1])
+		assert.True(t, lat >= -90 && lat <= 90, "latitude %v", lat)
+		assert.True(t, lon >= -180 && lon <= 180, "longitude %v", lon)
+	}
+}
+
+func TestTracker(t *testing.T) {
+	now := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	cl := clock.NewMock(now)
+
+	tracker := geoprivacy.NewTracker(cl)
+
+	assert.Equal(t, 1, tracker.Observe("cell-1", "device-1", time.Hour))
+	assert.Equal(t, 1, tracker.Observe("cell-1", "device-1", time.Hour))
+	assert.Equal(t, 2, tracker.Observe("cell-1", "device-2", time.Hour))
+	assert.Equal(t, 1, tracker.Observe("cell-2", "device-3", time.Hour))
+
+	cl.Set(now.Add(45 * time.Minute))
+	assert.Equal(t, 3, tracker.Observe("cell-1", "device-3", time.Hour))
+
+	// the first two devices last reported from the cell over an hour ago
+	cl.Set(now.Add(90 * time.Minute))
+	assert.Equal(t, 2, tracker.Observe("cell-1", "device-4", time.Hour))
+}
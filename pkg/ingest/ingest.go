@@ -0,0 +1,186 @@
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/logger/level"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/smartcitizen"
+)
+
+const (
+	// DefaultMaxBatchSize is the maximum number of readings we accept in a
+	// single batch if no maximum is configured.
+	DefaultMaxBatchSize = 1000
+)
+
+var (
+	// ErrBatchTooLarge is returned when a batch contains more readings than the
+	// configured maximum.
+	ErrBatchTooLarge = errors.New("batch contains too many readings")
+)
+
+// StreamSource is the interface we require of a type able to return a stream
+// along with its device. We define it here where we need it, and it is
+// satisfied by our postgres.DB type.
+type StreamSource interface {
+	GetStream(streamID, token string) (*postgres.Stream, error)
+}
+
+// Processor is the interface we call to process each uploaded reading. It is
+// satisfied by the pipeline.Processor type.
+type Processor interface {
+	Process(ctx context.Context, device *postgres.Device, payload []byte) error
+}
+
+// Retainer is the interface we call to retain uploaded readings as raw
+// payloads so that they may later be replayed. It is satisfied by any
+// retention.Store.
+type Retainer interface {
+	SaveRawPayload(deviceToken string, receivedAt time.Time, payload []byte) error
+}
+
+// Result reports the outcome of writing a batch of readings.
+type Result struct {
+	Processed int
+	Failed    int
+}
+
+// Config is used to pass in configuration when creating an Ingester. Retainer
+// is optional, and if nil uploaded readings are not retained. If
+// MessageTimeout is non-zero it bounds the processing of each reading.
+type Config struct {
+	Streams        StreamSource
+	Processor      Processor
+	Retainer       Retainer
+	Clock          clock.Clock
+	MaxBatchSize   int
+	MessageTimeout time.Duration
+}
+
+// Ingester accepts batches of historical readings for a single stream, as
+// uploaded by devices which buffer data while offline, and passes each reading
+// through the processing pipeline exactly as if it had been received over
+// MQTT. Unlike replays, batches are processed synchronously so the caller
+// learns the outcome of every reading.
+type Ingester struct {
+	streams      StreamSource
+	processor    Processor
+	retainer     Retainer
+	clock        clock.Clock
+	maxBatchSize int64
+	timeout      time.Duration
+	logger       kitlog.Logger
+}
+
+// NewIngester returns a new Ingester configured with the given Config.
+func NewIngester(config *Config, logger kitlog.Logger) *Ingester {
+	logger = kitlog.With(logger, "module", "ingest")
+
+	maxBatchSize := config.MaxBatchSize
+	if maxBatchSize <= 0 {
+		maxBatchSize = DefaultMaxBatchSize
+	}
+
+	return &Ingester{
+		streams:      config.Streams,
+		processor:    config.Processor,
+		retainer:     config.Retainer,
+		clock:        config.Clock,
+		maxBatchSize: int64(maxBatchSize),
+		timeout:      config.MessageTimeout,
+		logger:       logger,
+	}
+}
+
+// SetMaxBatchSize changes the maximum number of readings accepted in a batch,
+// or restores the default if not positive, taking effect for batches not yet
+// being processed.
+func (i *Ingester) SetMaxBatchSize(n int) {
+	if n <= 0 {
+		n = DefaultMaxBatchSize
+	}
+
+	atomic.StoreInt64(&i.maxBatchSize, int64(n))
+}
+
+// WriteReadings processes the given readings for a stream, whose token must be
+// supplied. Readings are processed in the order in which they were recorded,
+// and only for the given stream so that other streams fed by the same device
+// don't receive data they may already hold. Failures to process individual
+// readings are counted but do not stop the batch, however if the passed in
+// context is cancelled we return without processing any remaining readings.
+func (i *Ingester) WriteReadings(ctx context.Context, streamID, token string, readings []smartcitizen.SensorData) (*Result, error) {
+	if int64(len(readings)) > atomic.LoadInt64(&i.maxBatchSize) {
+		return nil, ErrBatchTooLarge
+	}
+
+	stream, err := i.streams.GetStream(streamID, token)
+	if err != nil {
+		return nil, err
+	}
+
+	device := *stream.Device
+	device.Streams = []*postgres.Stream{stream}
+
+	sorted := make([]smartcitizen.SensorData, len(readings))
+	copy(sorted, readings)
+
+	sort.SliceStable(sorted, func(a, b int) bool {
+		return sorted[a].RecordedAt.Before(sorted[b].RecordedAt)
+	})
+
+	result := &Result{}
+
+	for _, reading := range sorted {
+		if ctx.Err() != nil {
+			return result, errors.Wrap(ctx.Err(), "batch processing abandoned")
+		}
+
+		payload, err := json.Marshal(&smartcitizen.Payload{
+			Data: []smartcitizen.SensorData{reading},
+		})
+		if err != nil {
+			return result, errors.Wrap(err, "failed to marshal reading")
+		}
+
+		if i.retainer != nil {
+			err = i.retainer.SaveRawPayload(device.DeviceToken.Reveal(), i.clock.Now(), payload)
+			if err != nil {
+				level.Error(i.logger).Log("err", err, "msg", "failed to retain payload", "stream_uid", streamID)
+			}
+		}
+
+		err = i.process(ctx, &device, payload)
+
+		result.Processed++
+		if err != nil {
+			result.Failed++
+			level.Error(i.logger).Log("err", err, "msg", "failed to process reading", "stream_uid", streamID, "recorded_at", reading.RecordedAt)
+		}
+	}
+
+	i.logger.Log("msg", "batch written", "stream_uid", streamID, "processed", result.Processed, "failed", result.Failed)
+
+	return result, nil
+}
+
+// process passes a single payload to the processor, bounded by the configured
+// message timeout.
+func (i *Ingester) process(ctx context.Context, device *postgres.Device, payload []byte) error {
+	if i.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, i.timeout)
+		defer cancel()
+	}
+
+	return i.processor.Process(ctx, device, payload)
+}
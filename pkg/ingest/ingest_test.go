@@ -0,0 +1,124 @@
+package ingest_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/ingest"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/smartcitizen"
+)
+
+type source struct {
+	stream *postgres.Stream
+}
+
+func (s *source) GetStream(streamID, token string) (*postgres.Stream, error) {
+	if s.stream.StreamID != streamID || s.stream.Token.Reveal() != token {
+		return nil, postgres.ErrStreamNotFound
+	}
+	return s.stream, nil
+}
+
+type processor struct {
+	recordedAt []time.Time
+	streams    int
+}
+
+func (p *processor) Process(ctx context.Context, device *postgres.Device, payload []byte) error {
+	var parsed smartcitizen.Payload
+
+	err := json.Unmarshal(payload, &parsed)
+	if err != nil {
+		return err
+	}
+
+	p.recordedAt = append(p.recordedAt, parsed.Data[0].RecordedAt)
+	p.streams = len(device.Streams)
+
+	if len(parsed.Data[0].Sensors) == 0 {
+		return errors.New("no sensors")
+	}
+	return nil
+}
+
+type retainer struct {
+	payloads []string
+}
+
+func (r *retainer) SaveRawPayload(deviceToken string, receivedAt time.Time, payload []byte) error {
+	r.payloads = append(r.payloads, string(payload))
+	return nil
+}
+
+func newStream() *postgres.Stream {
+	stream := &postgres.Stream{
+		StreamID: "abc",
+		Token:    "secret",
+		Device: &postgres.Device{
+			DeviceToken: "device-token",
+		},
+	}
+
+	// the device feeds another stream which must not receive backfilled data
+	stream.Device.Streams = []*postgres.Stream{stream, {StreamID: "def"}}
+
+	return stream
+}
+
+func TestWriteReadings(t *testing.T) {
+	p := &processor{}
+	r := &retainer{}
+
+	ingester := ingest.NewIngester(&ingest.Config{
+		Streams:   &source{stream: newStream()},
+		Processor: p,
+		Retainer:  r,
+		Clock:     clock.New(),
+	}, kitlog.NewNopLogger())
+
+	t1 := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	t2 := t1.Add(time.Minute)
+	t3 := t2.Add(time.Minute)
+
+	result, err := ingester.WriteReadings(context.Background(), "abc", "secret", []smartcitizen.SensorData{
+		{RecordedAt: t3, Sensors: []smartcitizen.RawSensor{{ID: 13, Value: 51}}},
+		{RecordedAt: t1, Sensors: []smartcitizen.RawSensor{{ID: 13, Value: 50}}},
+		{RecordedAt: t2},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, &ingest.Result{Processed: 3, Failed: 1}, result)
+
+	assert.Equal(t, []time.Time{t1, t2, t3}, p.recordedAt)
+	assert.Equal(t, 1, p.streams)
+	assert.Len(t, r.payloads, 3)
+}
+
+func TestWriteReadingsInvalid(t *testing.T) {
+	ingester := ingest.NewIngester(&ingest.Config{
+		Streams:      &source{stream: newStream()},
+		Processor:    &processor{},
+		Clock:        clock.New(),
+		MaxBatchSize: 2,
+	}, kitlog.NewNopLogger())
+
+	_, err := ingester.WriteReadings(context.Background(), "abc", "wrong", []smartcitizen.SensorData{{}})
+	assert.Equal(t, postgres.ErrStreamNotFound, err)
+
+	_, err = ingester.WriteReadings(context.Background(), "abc", "secret", make([]smartcitizen.SensorData, 3))
+	assert.Equal(t, ingest.ErrBatchTooLarge, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	result, err := ingester.WriteReadings(ctx, "abc", "secret", []smartcitizen.SensorData{{}})
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, result.Processed)
+}
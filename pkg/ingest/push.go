@@ -0,0 +1,259 @@
+package ingest
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"goji.io/pat"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/logger"
+	"github.com/DECODEproject/iotencoder/pkg/logger/level"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+)
+
+const (
+	// PushPath is the pattern of the path to which devices push readings over
+	// HTTP, mirroring the MQTT topic.
+	PushPath = "/ingest/:device_token"
+
+	// SignatureHeader is the request header carrying the signature of a pushed
+	// payload.
+	SignatureHeader = "X-Signature"
+
+	// TimestampHeader is the request header carrying the time, in seconds since
+	// the Unix epoch, at which a pushed payload was signed.
+	TimestampHeader = "X-Timestamp"
+
+	// DefaultMaxBodySize is the maximum size in bytes of a pushed payload if no
+	// maximum is configured.
+	DefaultMaxBodySize = 1 << 20
+
+	// DefaultMaxSkew is how far the timestamp of a pushed payload may be from
+	// our clock if no maximum is configured.
+	DefaultMaxSkew = 5 * time.Minute
+
+	// signaturePrefix identifies the algorithm used to sign the payload, and is
+	// the only one we support.
+	signaturePrefix = "sha256="
+)
+
+var (
+	// PushRequestsCounter is a prometheus counter recording a count of payloads
+	// pushed over HTTP, labelled by the status code of our response. Device
+	// tokens appear in the request path, so these requests are deliberately
+	// excluded from the path labelled HTTP metrics.
+	PushRequestsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "push_requests",
+			Help:      "Count of payloads pushed over HTTP, labelled by response code",
+		},
+		[]string{"code"},
+	)
+)
+
+// DeviceSource is the interface we require of a type able to load a device
+// along with its streams and their ingest secrets. It is satisfied by the
+// postgres.DB type.
+type DeviceSource interface {
+	GetDevice(deviceToken secret.Secret) (*postgres.Device, error)
+}
+
+// PushConfig is used to pass in configuration when creating a PushHandler.
+// Retainer is optional, and if nil pushed payloads are not retained. If
+// MessageTimeout is non-zero it bounds the processing of each payload.
+// MaxSkew is how far a payload's timestamp may be from our clock,
+// DefaultMaxSkew if zero.
+type PushConfig struct {
+	Devices        DeviceSource
+	Processor      Processor
+	Retainer       Retainer
+	Clock          clock.Clock
+	MaxBodySize    int64
+	MaxSkew        time.Duration
+	MessageTimeout time.Duration
+}
+
+// PushHandler accepts readings from devices and gateways which can only make
+// HTTP requests. Clients POST the same JSON payload they would publish over
+// MQTT to /ingest/<device_token>, with an X-Timestamp header containing the
+// time at which it was signed in seconds since the Unix epoch, and an
+// X-Signature header containing "sha256=" followed by the hex encoded
+// HMAC-SHA256 of the timestamp, a period and the body, keyed with the ingest
+// secret of a stream fed by the device. The payload is processed only for those
+// streams whose secret produced the signature, so a device token alone is not
+// enough to inject data into a stream, and only if its timestamp is within the
+// maximum skew of our clock, so a captured request cannot be replayed later.
+type PushHandler struct {
+	devices     DeviceSource
+	processor   Processor
+	retainer    Retainer
+	clock       clock.Clock
+	maxBodySize int64
+	maxSkew     time.Duration
+	timeout     time.Duration
+	logger      kitlog.Logger
+}
+
+// NewPushHandler returns a new PushHandler configured with the given
+// PushConfig.
+func NewPushHandler(config *PushConfig, logger kitlog.Logger) *PushHandler {
+	logger = kitlog.With(logger, "module", "push")
+
+	maxBodySize := config.MaxBodySize
+	if maxBodySize <= 0 {
+		maxBodySize = DefaultMaxBodySize
+	}
+
+	maxSkew := config.MaxSkew
+	if maxSkew <= 0 {
+		maxSkew = DefaultMaxSkew
+	}
+
+	return &PushHandler{
+		devices:     config.Devices,
+		processor:   config.Processor,
+		retainer:    config.Retainer,
+		clock:       config.Clock,
+		maxBodySize: maxBodySize,
+		maxSkew:     maxSkew,
+		timeout:     config.MessageTimeout,
+		logger:      logger,
+	}
+}
+
+// Sign returns the value of the signature header for the given body signed
+// with the given ingest secret at the given time, the value of the timestamp
+// header.
+func Sign(ingestSecret secret.Secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(ingestSecret.Reveal()))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// ServeHTTP implements http.Handler, verifying and then processing a single
+// pushed payload. Unknown devices, missing or invalid signatures and stale
+// timestamps are all rejected with the same 401 response so that callers
+// cannot probe for registered device tokens. Unlike MQTT, processing is
+// synchronous so the caller learns whether the payload was written.
+func (h *PushHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	code := h.serve(r)
+
+	PushRequestsCounter.WithLabelValues(strconv.Itoa(code)).Inc()
+
+	if code == http.StatusNoContent {
+		w.WriteHeader(code)
+		return
+	}
+
+	http.Error(w, http.StatusText(code), code)
+}
+
+// serve handles the request, returning the status code of our response.
+func (h *PushHandler) serve(r *http.Request) int {
+	token := secret.Secret(pat.Param(r, "device_token"))
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, h.maxBodySize+1))
+	if err != nil {
+		return http.StatusBadRequest
+	}
+
+	if int64(len(body)) > h.maxBodySize {
+		return http.StatusRequestEntityTooLarge
+	}
+
+	if len(body) == 0 {
+		return http.StatusBadRequest
+	}
+
+	signature := r.Header.Get(SignatureHeader)
+	if !strings.HasPrefix(signature, signaturePrefix) {
+		return http.StatusUnauthorized
+	}
+
+	timestamp := r.Header.Get(TimestampHeader)
+
+	signedAt, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return http.StatusUnauthorized
+	}
+
+	skew := h.clock.Now().Sub(time.Unix(signedAt, 0))
+	if skew > h.maxSkew || skew < -h.maxSkew {
+		return http.StatusUnauthorized
+	}
+
+	log := kitlog.With(h.logger, "device_hash", logger.HashToken(token))
+
+	device, err := h.devices.GetDevice(token)
+	if err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return http.StatusUnauthorized
+		}
+
+		level.Error(log).Log("err", err, "msg", "failed to get device")
+		return http.StatusInternalServerError
+	}
+
+	verified := []*postgres.Stream{}
+
+	for _, stream := range device.Streams {
+		if stream.IngestSecret == "" {
+			continue
+		}
+
+		if hmac.Equal([]byte(signature), []byte(Sign(stream.IngestSecret, timestamp, body))) {
+			verified = append(verified, stream)
+		}
+	}
+
+	if len(verified) == 0 {
+		level.Debug(log).Log("msg", "rejecting payload with invalid signature")
+		return http.StatusUnauthorized
+	}
+
+	if h.retainer != nil {
+		err = h.retainer.SaveRawPayload(device.DeviceToken.Reveal(), h.clock.Now(), body)
+		if err != nil {
+			level.Error(log).Log("err", err, "msg", "failed to retain payload")
+		}
+	}
+
+	// process a copy of the device so the loaded device's streams are left
+	// untouched
+	d := *device
+	d.Streams = verified
+
+	ctx := r.Context()
+
+	if h.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+	}
+
+	err = h.processor.Process(ctx, &d, body)
+	if err != nil {
+		level.Error(log).Log("err", err, "msg", "failed to process payload")
+		return http.StatusInternalServerError
+	}
+
+	return http.StatusNoContent
+}
@@ -0,0 +1,133 @@
+package ingest_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	goji "goji.io"
+	"goji.io/pat"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/ingest"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/postgres/postgrestest"
+)
+
+func newPushHandler(t *testing.T, p *processor, r *retainer) (http.Handler, *postgres.Stream) {
+	db := postgrestest.NewDB()
+
+	stream, err := db.CreateStream(&postgres.Stream{
+		CommunityID: "community",
+		PublicKey:   "public",
+		Device: &postgres.Device{
+			DeviceToken: "abc123",
+		},
+	})
+	assert.Nil(t, err)
+
+	// a second stream for the same device without a secret must not receive
+	// pushed data
+	_, err = db.CreateStream(&postgres.Stream{
+		CommunityID: "other",
+		PublicKey:   "public",
+		Device: &postgres.Device{
+			DeviceToken: "abc123",
+		},
+	})
+	assert.Nil(t, err)
+
+	err = db.SetIngestSecret(stream.StreamID, stream.Token.Reveal(), "shared")
+	assert.Nil(t, err)
+
+	h := ingest.NewPushHandler(&ingest.PushConfig{
+		Devices:     db,
+		Processor:   p,
+		Retainer:    r,
+		Clock:       clock.New(),
+		MaxBodySize: 128,
+	}, kitlog.NewNopLogger())
+
+	mux := goji.NewMux()
+	mux.Handle(pat.Post(ingest.PushPath), h)
+
+	return mux, stream
+}
+
+func TestPushHandler(t *testing.T) {
+	p := &processor{}
+	r := &retainer{}
+
+	h, _ := newPushHandler(t, p, r)
+
+	body := []byte(`{"data":[{"recorded_at":"2019-06-01T12:00:00Z","sensors":[{"id":13,"value":51.0}]}]}`)
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req := httptest.NewRequest(http.MethodPost, "/ingest/abc123", bytes.NewReader(body))
+	req.Header.Set(ingest.TimestampHeader, timestamp)
+	req.Header.Set(ingest.SignatureHeader, ingest.Sign("shared", timestamp, body))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Len(t, p.recordedAt, 1)
+	assert.Equal(t, 1, p.streams)
+	assert.Equal(t, []string{string(body)}, r.payloads)
+}
+
+func TestPushHandlerRejected(t *testing.T) {
+	body := `{"data":[{"recorded_at":"2019-06-01T12:00:00Z","sensors":[{"id":13,"value":51.0}]}]}`
+
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	stale := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	future := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+
+	testcases := []struct {
+		label     string
+		path      string
+		body      string
+		timestamp string
+		signature string
+		expected  int
+	}{
+		{"unknown device", "/ingest/unknown", body, now, ingest.Sign("shared", now, []byte(body)), http.StatusUnauthorized},
+		{"missing signature", "/ingest/abc123", body, now, "", http.StatusUnauthorized},
+		{"wrong secret", "/ingest/abc123", body, now, ingest.Sign("other", now, []byte(body)), http.StatusUnauthorized},
+		{"tampered body", "/ingest/abc123", body + " ", now, ingest.Sign("shared", now, []byte(body)), http.StatusUnauthorized},
+		{"missing timestamp", "/ingest/abc123", body, "", ingest.Sign("shared", "", []byte(body)), http.StatusUnauthorized},
+		{"tampered timestamp", "/ingest/abc123", body, now, ingest.Sign("shared", stale, []byte(body)), http.StatusUnauthorized},
+		{"stale timestamp", "/ingest/abc123", body, stale, ingest.Sign("shared", stale, []byte(body)), http.StatusUnauthorized},
+		{"future timestamp", "/ingest/abc123", body, future, ingest.Sign("shared", future, []byte(body)), http.StatusUnauthorized},
+		{"empty body", "/ingest/abc123", "", now, ingest.Sign("shared", now, []byte{}), http.StatusBadRequest},
+		{"too large", "/ingest/abc123", strings.Repeat("a", 129), now, "sha256=00", http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			p := &processor{}
+			h, _ := newPushHandler(t, p, &retainer{})
+
+			req := httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(tc.body))
+			if tc.timestamp != "" {
+				req.Header.Set(ingest.TimestampHeader, tc.timestamp)
+			}
+			if tc.signature != "" {
+				req.Header.Set(ingest.SignatureHeader, tc.signature)
+			}
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expected, w.Code)
+			assert.Len(t, p.recordedAt, 0)
+		})
+	}
+}
@@ -0,0 +1,263 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/segmentio/kafka-go"
+
+	"github.com/DECODEproject/iotencoder/pkg/logger"
+	"github.com/DECODEproject/iotencoder/pkg/logger/level"
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+	"github.com/DECODEproject/iotencoder/pkg/version"
+)
+
+const (
+	// DefaultTopic is the topic from which device readings are consumed if no
+	// topics are configured.
+	DefaultTopic = "device-readings"
+
+	// DefaultGroupID is the consumer group we join if no group is configured.
+	// All instances of the encoder sharing a group divide the partitions of our
+	// topics between them.
+	DefaultGroupID = "iotencoder"
+)
+
+var (
+	// MessageCounter is a prometheus counter recording the number of messages
+	// consumed from Kafka.
+	MessageCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "kafka_messages_received",
+			Help:      "Count of Kafka messages received",
+		},
+	)
+)
+
+// Config is used to pass in configuration when creating a Consumer. Brokers is
+// the list of host:port addresses used to bootstrap the connection to the
+// cluster, Topics the topics from which readings are consumed and GroupID the
+// consumer group under which our offsets are committed.
+type Config struct {
+	Brokers []string
+	Topics  []string
+	GroupID string
+}
+
+// Consumer receives device readings from Kafka topics, allowing the encoder to
+// sit downstream of an existing Kafka based ingestion platform. Each message is
+// expected to be keyed by the token of the device which sent it, with the
+// device's SmartCitizen JSON payload as its value. We join a consumer group, so
+// the partitions of our topics are balanced across instances, and commit the
+// offset of each message once the subscribed callback returns, so readings
+// consumed but not processed when an instance stops are redelivered.
+//
+// Unlike the MQTT and AMQP sources the broker has no knowledge of our
+// subscriptions; every message on our topics is consumed, and those for devices
+// we are not subscribed to are committed and dropped.
+type Consumer struct {
+	brokers []string
+	topics  []string
+	groupID string
+	logger  kitlog.Logger
+
+	readers  []*kafka.Reader
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+	stopping bool
+
+	sync.RWMutex
+	callbacks map[string]func(payload []byte, metadata map[string]string, done func())
+}
+
+// NewConsumer returns a new Consumer configured with the given Config.
+func NewConsumer(config *Config, logger kitlog.Logger) *Consumer {
+	logger = kitlog.With(logger, "module", "kafka")
+
+	topics := config.Topics
+	if len(topics) == 0 {
+		topics = []string{DefaultTopic}
+	}
+
+	groupID := config.GroupID
+	if groupID == "" {
+		groupID = DefaultGroupID
+	}
+
+	return &Consumer{
+		brokers:   config.Brokers,
+		topics:    topics,
+		groupID:   groupID,
+		logger:    logger,
+		callbacks: make(map[string]func(payload []byte, metadata map[string]string, done func())),
+	}
+}
+
+// Start joins the consumer group and starts consuming from each of our topics.
+// As messages for devices with no subscription are dropped, it should be called
+// once the encoder has subscribed to its devices.
+func (c *Consumer) Start() error {
+	c.logger.Log("msg", "starting kafka consumer", "topics", fmt.Sprintf("%v", c.topics), "group", c.groupID)
+
+	if len(c.brokers) == 0 {
+		return errors.New("no kafka brokers configured")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	readers := make([]*kafka.Reader, 0, len(c.topics))
+
+	for _, topic := range c.topics {
+		readerConfig := kafka.ReaderConfig{
+			Brokers: c.brokers,
+			GroupID: c.groupID,
+			Topic:   topic,
+			// readings are small, so rather than waiting for a batch to
+			// accumulate we fetch as soon as any are available
+			MinBytes: 1,
+			Dialer: &kafka.Dialer{
+				ClientID:  fmt.Sprintf("%s-DECODE", version.BinaryName),
+				DualStack: true,
+			},
+		}
+
+		err := readerConfig.Validate()
+		if err != nil {
+			cancel()
+			return errors.Wrap(err, "invalid kafka reader config")
+		}
+
+		readers = append(readers, kafka.NewReader(readerConfig))
+	}
+
+	c.Lock()
+	c.readers = readers
+	c.cancel = cancel
+	c.Unlock()
+
+	for _, reader := range readers {
+		c.wg.Add(1)
+		go c.consume(ctx, reader)
+	}
+
+	return nil
+}
+
+// Stop stops consuming, waiting for the message being processed on each topic
+// to be committed, and leaves the consumer group.
+func (c *Consumer) Stop() error {
+	c.logger.Log("msg", "stopping kafka consumer")
+
+	c.Lock()
+	c.stopping = true
+	cancel := c.cancel
+	readers := c.readers
+	c.Unlock()
+
+	if cancel == nil {
+		return nil
+	}
+
+	cancel()
+	c.wg.Wait()
+
+	for _, reader := range readers {
+		err := reader.Close()
+		if err != nil {
+			return errors.Wrap(err, "failed to close kafka reader")
+		}
+	}
+
+	return nil
+}
+
+// Subscribe registers the callback to which messages keyed by the given device
+// token are subsequently passed.
+func (c *Consumer) Subscribe(deviceToken secret.Secret, callback func(payload []byte, metadata map[string]string, done func())) error {
+	level.Debug(c.logger).Log("device_hash", logger.HashToken(deviceToken), "msg", "subscribing")
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.callbacks[deviceToken.Reveal()] = callback
+
+	return nil
+}
+
+// Unsubscribe removes the callback registered for the given device token.
+func (c *Consumer) Unsubscribe(deviceToken secret.Secret) error {
+	level.Debug(c.logger).Log("device_hash", logger.HashToken(deviceToken), "msg", "unsubscribing")
+
+	c.Lock()
+	defer c.Unlock()
+
+	delete(c.callbacks, deviceToken.Reveal())
+
+	return nil
+}
+
+// consume fetches messages from the reader until the context is cancelled,
+// passing each to the callback of the device it is keyed by before committing
+// its offset.
+func (c *Consumer) consume(ctx context.Context, reader *kafka.Reader) {
+	defer c.wg.Done()
+
+	for {
+		msg, err := reader.FetchMessage(ctx)
+		if err != nil {
+			if c.isStopping() {
+				return
+			}
+
+			// as with our MQTT client we exit if we can no longer consume,
+			// relying on being restarted to rejoin the group
+			level.Error(c.logger).Log("msg", "failed to fetch message", "topic", reader.Config().Topic, "err", err)
+			os.Exit(1)
+		}
+
+		MessageCounter.Inc()
+
+		c.deliver(msg.Key, msg.Value)
+
+		err = reader.CommitMessages(ctx, msg)
+		if err != nil && !c.isStopping() {
+			level.Error(c.logger).Log("err", err, "msg", "failed to commit message offset")
+		}
+	}
+}
+
+// deliver passes the payload to the callback subscribed for the device token
+// the message was keyed with. Messages without a key, or for devices we are not
+// subscribed to, are dropped.
+func (c *Consumer) deliver(key, payload []byte) {
+	if len(key) == 0 {
+		level.Warn(c.logger).Log("msg", "dropping message with no device key")
+		return
+	}
+
+	c.RLock()
+	callback, ok := c.callbacks[string(key)]
+	c.RUnlock()
+
+	if !ok {
+		level.Debug(c.logger).Log("device_hash", logger.HashToken(secret.Secret(key)), "msg", "dropping message for unsubscribed device")
+		return
+	}
+
+	callback(payload, nil, func() {})
+}
+
+// isStopping returns true once Stop has been called.
+func (c *Consumer) isStopping() bool {
+	c.RLock()
+	defer c.RUnlock()
+
+	return c.stopping
+}
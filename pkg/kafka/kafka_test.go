@@ -0,0 +1,26 @@
+package kafka_test
+
+import (
+	"testing"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/kafka"
+)
+
+func TestConsumerNotStarted(t *testing.T) {
+	c := kafka.NewConsumer(&kafka.Config{Brokers: []string{"localhost:9092"}}, kitlog.NewNopLogger())
+
+	assert.Nil(t, c.Subscribe("abc123", func(payload []byte, metadata map[string]string, done func()) {}))
+	assert.Nil(t, c.Unsubscribe("abc123"))
+
+	assert.Nil(t, c.Stop())
+}
+
+func TestConsumerNoBrokers(t *testing.T) {
+	c := kafka.NewConsumer(&kafka.Config{}, kitlog.NewNopLogger())
+
+	err := c.Start()
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,169 @@
+package labels
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// MaxLabels is the maximum number of labels a stream may carry.
+const MaxLabels = 16
+
+// keyPattern is the pattern label keys must match. Keys are restricted to the
+// characters permitted in Prometheus label names, as they may be promoted onto
+// our metrics.
+var keyPattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]{0,62}$`)
+
+// Parse parses labels from their string form, a comma separated list of
+// key=value pairs, e.g. "pilot=barcelona,sensor_kind=noise". An empty string is
+// parsed as no labels.
+func Parse(s string) (map[string]string, error) {
+	labels := map[string]string{}
+
+	if strings.TrimSpace(s) == "" {
+		return labels, nil
+	}
+
+	for _, pair := range strings.Split(s, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, errors.Errorf("invalid label %q, must be key=value", pair)
+		}
+
+		key, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+
+		err := validate(key, value)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := labels[key]; ok {
+			return nil, errors.Errorf("duplicate label %q", key)
+		}
+
+		labels[key] = value
+	}
+
+	if len(labels) > MaxLabels {
+		return nil, errors.Errorf("at most %d labels may be given", MaxLabels)
+	}
+
+	return labels, nil
+}
+
+// Format returns the string form of the given labels parsed by Parse, with keys
+// in sorted order.
+func Format(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+
+	for key, value := range labels {
+		pairs = append(pairs, key+"="+value)
+	}
+
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, ",")
+}
+
+// ValidateKey returns an error if the given string may not be used as the key
+// of a label.
+func ValidateKey(key string) error {
+	if !keyPattern.MatchString(key) {
+		return errors.Errorf("invalid label key %q, must be letters, digits and underscores, not starting with a digit", key)
+	}
+
+	return nil
+}
+
+// validate returns an error if the given key or value may not be used as a
+// label.
+func validate(key, value string) error {
+	err := ValidateKey(key)
+	if err != nil {
+		return err
+	}
+
+	if value == "" {
+		return errors.Errorf("label %q has an empty value", key)
+	}
+
+	if len(value) > 255 || strings.ContainsAny(value, ",=") {
+		return errors.Errorf("invalid value for label %q, must be at most 255 characters without commas or equals signs", key)
+	}
+
+	return nil
+}
+
+// requirement is a single condition of a Selector.
+type requirement struct {
+	key    string
+	value  string
+	negate bool
+	exists bool
+}
+
+// Selector is a set of requirements on the labels of a stream, all of which
+// must hold for the stream to be selected.
+type Selector []requirement
+
+// ParseSelector parses a selector from its string form, a comma separated list
+// of requirements which are either key=value, key!=value, key (the label is
+// set) or !key (the label is not set), e.g. "pilot=barcelona,sensor_kind!=noise".
+// An empty string is parsed as a selector matching every stream.
+func ParseSelector(s string) (Selector, error) {
+	selector := Selector{}
+
+	if strings.TrimSpace(s) == "" {
+		return selector, nil
+	}
+
+	for _, term := range strings.Split(s, ",") {
+		term = strings.TrimSpace(term)
+
+		var r requirement
+
+		switch {
+		case strings.Contains(term, "!="):
+			parts := strings.SplitN(term, "!=", 2)
+			r = requirement{key: strings.TrimSpace(parts[0]), value: strings.TrimSpace(parts[1]), negate: true}
+		case strings.Contains(term, "="):
+			parts := strings.SplitN(term, "=", 2)
+			r = requirement{key: strings.TrimSpace(parts[0]), value: strings.TrimSpace(parts[1])}
+		case strings.HasPrefix(term, "!"):
+			r = requirement{key: strings.TrimSpace(strings.TrimPrefix(term, "!")), exists: true, negate: true}
+		default:
+			r = requirement{key: term, exists: true}
+		}
+
+		if !keyPattern.MatchString(r.key) {
+			return nil, errors.Errorf("invalid selector requirement %q", term)
+		}
+
+		selector = append(selector, r)
+	}
+
+	return selector, nil
+}
+
+// Matches returns true if the given labels satisfy every requirement of the
+// selector.
+func (s Selector) Matches(labels map[string]string) bool {
+	for _, r := range s {
+		value, ok := labels[r.key]
+
+		var matched bool
+		if r.exists {
+			matched = ok
+		} else {
+			matched = ok && value == r.value
+		}
+
+		if matched == r.negate {
+			return false
+		}
+	}
+
+	return true
+}
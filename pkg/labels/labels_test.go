@@ -0,0 +1,119 @@
+package labels_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/labels"
+)
+
+func TestParse(t *testing.T) {
+	testcases := []struct {
+		label    string
+		input    string
+		expected map[string]string
+	}{
+		{
+			label:    "empty",
+			input:    "",
+			expected: map[string]string{},
+		},
+		{
+			label:    "single",
+			input:    "pilot=barcelona",
+			expected: map[string]string{"pilot": "barcelona"},
+		},
+		{
+			label:    "multiple with spaces",
+			input:    "pilot=barcelona, sensor_kind = noise",
+			expected: map[string]string{"pilot": "barcelona", "sensor_kind": "noise"},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			got, err := labels.Parse(tc.input)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, got)
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	testcases := []struct {
+		label       string
+		input       string
+		expectedErr string
+	}{
+		{
+			label:       "missing value",
+			input:       "pilot",
+			expectedErr: `invalid label "pilot", must be key=value`,
+		},
+		{
+			label:       "empty value",
+			input:       "pilot=",
+			expectedErr: `label "pilot" has an empty value`,
+		},
+		{
+			label:       "invalid key",
+			input:       "sensor-kind=noise",
+			expectedErr: `invalid label key "sensor-kind", must be letters, digits and underscores, not starting with a digit`,
+		},
+		{
+			label:       "duplicate",
+			input:       "pilot=barcelona,pilot=amsterdam",
+			expectedErr: `duplicate label "pilot"`,
+		},
+		{
+			label:       "invalid value",
+			input:       "pilot=a=b",
+			expectedErr: `invalid value for label "pilot", must be at most 255 characters without commas or equals signs`,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			_, err := labels.Parse(tc.input)
+			assert.NotNil(t, err)
+			assert.Equal(t, tc.expectedErr, err.Error())
+		})
+	}
+}
+
+func TestFormat(t *testing.T) {
+	assert.Equal(t, "pilot=barcelona,sensor_kind=noise", labels.Format(map[string]string{"sensor_kind": "noise", "pilot": "barcelona"}))
+	assert.Equal(t, "", labels.Format(nil))
+}
+
+func TestSelector(t *testing.T) {
+	streamLabels := map[string]string{"pilot": "barcelona", "sensor_kind": "noise"}
+
+	testcases := []struct {
+		selector string
+		expected bool
+	}{
+		{"", true},
+		{"pilot=barcelona", true},
+		{"pilot=amsterdam", false},
+		{"pilot!=amsterdam", true},
+		{"pilot=barcelona,sensor_kind!=noise", false},
+		{"sensor_kind", true},
+		{"!sensor_kind", false},
+		{"!floor", true},
+		{"floor!=3", true},
+		{"floor=3", false},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.selector, func(t *testing.T) {
+			selector, err := labels.ParseSelector(tc.selector)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, selector.Matches(streamLabels))
+		})
+	}
+
+	_, err := labels.ParseSelector("pilot=barcelona,!")
+	assert.NotNil(t, err)
+}
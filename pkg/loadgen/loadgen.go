@@ -0,0 +1,456 @@
+package loadgen
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	datastore "github.com/thingful/twirp-datastore-go"
+	encoder "github.com/thingful/twirp-encoder-go"
+	"github.com/twitchtv/twirp"
+
+	"github.com/DECODEproject/iotencoder/pkg/logger/level"
+	mqttclient "github.com/DECODEproject/iotencoder/pkg/mqtt"
+	"github.com/DECODEproject/iotencoder/pkg/rpc"
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+	"github.com/DECODEproject/iotencoder/pkg/smartcitizen"
+)
+
+const (
+	// DefaultPublicKey is the community public key with which the streams we
+	// create are encrypted if none is configured. Nothing written to our stub
+	// datastore is ever decrypted, so any valid key will do.
+	DefaultPublicKey = `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`
+
+	// DefaultCommunityID is the community id of the streams we create if none is
+	// configured.
+	DefaultCommunityID = "loadgen"
+
+	// requestTimeout bounds each request we send to the encoder, and each
+	// publish to the broker.
+	requestTimeout = 10 * time.Second
+)
+
+// sensorIDs are the ids of the sensors for which each generated payload
+// carries a reading, a subset of those sent by a SmartCitizen kit.
+var sensorIDs = []int{12, 13, 14, 29, 53, 58, 87, 88, 89}
+
+// Config is used to pass in configuration when creating a Generator.
+// BrokerAddr is the MQTT broker to which the encoder under test is subscribed,
+// and EncoderAddr the address of its API. Our stub datastore listens on
+// DatastoreAddr, and DatastoreURL is the address at which the encoder reaches
+// it. Readings are published at Rate per second, spread across Devices devices,
+// for Duration, after which we wait up to Drain for the remaining readings to be
+// written.
+type Config struct {
+	BrokerAddr     string
+	BrokerUsername string
+	EncoderAddr    string
+	DatastoreAddr  string
+	DatastoreURL   string
+	CommunityID    string
+	PublicKey      string
+	Devices        int
+	Rate           float64
+	Duration       time.Duration
+	Drain          time.Duration
+}
+
+// Report summarises a run, with latencies given in milliseconds. A reading is
+// counted as lost if it was not written to the stub datastore before the end
+// of the drain period.
+type Report struct {
+	Devices int     `json:"devices"`
+	Sent    int     `json:"sent"`
+	Written int     `json:"written"`
+	Lost    int     `json:"lost"`
+	Rate    float64 `json:"rate"`
+	Latency Latency `json:"latency_ms"`
+}
+
+// Latency summarises the end-to-end latencies of a run, from publishing a
+// reading to its encrypted data being written to the datastore.
+type Latency struct {
+	Min  float64 `json:"min"`
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P99  float64 `json:"p99"`
+	Max  float64 `json:"max"`
+}
+
+// Generator publishes synthetic SmartCitizen readings to an MQTT broker for a
+// set of devices whose streams it creates on a running encoder, and measures
+// how long each takes to be written to a stub datastore it serves, so that the
+// throughput and latency of the whole pipeline can be measured before release.
+type Generator struct {
+	config   *Config
+	logger   kitlog.Logger
+	recorder *recorder
+	rand     *rand.Rand
+}
+
+// NewGenerator returns a new Generator configured with the given config.
+func NewGenerator(config *Config, logger kitlog.Logger) *Generator {
+	logger = kitlog.With(logger, "module", "loadgen")
+
+	if config.CommunityID == "" {
+		config.CommunityID = DefaultCommunityID
+	}
+
+	if config.PublicKey == "" {
+		config.PublicKey = DefaultPublicKey
+	}
+
+	return &Generator{
+		config:   config,
+		logger:   logger,
+		recorder: newRecorder(),
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Run starts the stub datastore, creates a stream for each device, publishes
+// readings for the configured duration and then waits for them to be written,
+// before deleting the streams and returning a report of the run.
+func (g *Generator) Run(ctx context.Context) (*Report, error) {
+	if g.config.Devices <= 0 || g.config.Rate <= 0 {
+		return nil, errors.New("devices and rate must be positive")
+	}
+
+	listener, err := net.Listen("tcp", g.config.DatastoreAddr)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to start stub datastore")
+	}
+
+	srv := &http.Server{Handler: datastore.NewDatastoreServer(g.recorder, nil)}
+	go srv.Serve(listener)
+	defer srv.Close()
+
+	client, err := g.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer client.Disconnect(250)
+
+	tokens := make([]string, g.config.Devices)
+	for i := range tokens {
+		tokens[i] = g.deviceToken()
+	}
+
+	streams, err := g.createStreams(ctx, tokens)
+	defer g.deleteStreams(streams)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	sent, err := g.publish(ctx, client, tokens)
+	if err != nil {
+		return nil, err
+	}
+	elapsed := time.Since(start)
+
+	g.drain(ctx)
+
+	latencies := g.recorder.latencies()
+
+	return &Report{
+		Devices: g.config.Devices,
+		Sent:    sent,
+		Written: len(latencies),
+		Lost:    sent - len(latencies),
+		Rate:    float64(sent) / elapsed.Seconds(),
+		Latency: Summarize(latencies),
+	}, nil
+}
+
+// connect connects our publisher to the broker.
+func (g *Generator) connect() (mqtt.Client, error) {
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(g.config.BrokerAddr)
+	opts.SetUsername(g.config.BrokerUsername)
+	opts.SetClientID("loadgen-" + g.deviceToken())
+
+	client := mqtt.NewClient(opts)
+
+	token := client.Connect()
+	if !token.WaitTimeout(requestTimeout) {
+		return nil, errors.New("timed out connecting to broker")
+	}
+
+	if token.Error() != nil {
+		return nil, errors.Wrap(token.Error(), "failed to connect to broker")
+	}
+
+	return client, nil
+}
+
+// createStreams creates a stream writing to our stub datastore for each of the
+// given devices, returning the streams created, which are returned even if we
+// fail part way through so that they can be deleted.
+func (g *Generator) createStreams(ctx context.Context, tokens []string) ([]*encoder.CreateStreamResponse, error) {
+	client := encoder.NewEncoderProtobufClient(g.config.EncoderAddr, &http.Client{})
+
+	headers := http.Header{}
+	headers.Set(rpc.DatastoreAddrHeader, g.config.DatastoreURL)
+
+	streams := []*encoder.CreateStreamResponse{}
+
+	for _, token := range tokens {
+		reqCtx, cancel := context.WithTimeout(ctx, requestTimeout)
+
+		reqCtx, err := twirp.WithHTTPRequestHeaders(reqCtx, headers)
+		if err != nil {
+			cancel()
+			return streams, errors.Wrap(err, "failed to set request headers")
+		}
+
+		resp, err := client.CreateStream(reqCtx, &encoder.CreateStreamRequest{
+			DeviceToken:        token,
+			DeviceLabel:        "loadgen",
+			CommunityId:        g.config.CommunityID,
+			RecipientPublicKey: g.config.PublicKey,
+			Location: &encoder.CreateStreamRequest_Location{
+				Longitude: 2.13,
+				Latitude:  41.4,
+			},
+			Exposure: encoder.CreateStreamRequest_INDOOR,
+		})
+		cancel()
+
+		if err != nil {
+			return streams, errors.Wrap(err, "failed to create stream")
+		}
+
+		streams = append(streams, resp)
+	}
+
+	level.Info(g.logger).Log("streams", len(streams), "msg", "created streams")
+
+	return streams, nil
+}
+
+// deleteStreams deletes the given streams, logging rather than returning any
+// errors as the run is already over.
+func (g *Generator) deleteStreams(streams []*encoder.CreateStreamResponse) {
+	client := encoder.NewEncoderProtobufClient(g.config.EncoderAddr, &http.Client{})
+
+	for _, stream := range streams {
+		ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+
+		_, err := client.DeleteStream(ctx, &encoder.DeleteStreamRequest{
+			StreamUid: stream.StreamUid,
+			Token:     stream.Token,
+		})
+		cancel()
+
+		if err != nil {
+			level.Error(g.logger).Log("stream_uid", stream.StreamUid, "err", err, "msg", "failed to delete stream")
+		}
+	}
+}
+
+// publish publishes readings for each of the devices in turn at the configured
+// rate until the configured duration has elapsed or the context is cancelled,
+// returning the number published. If publishing can't keep up with the rate
+// ticks are dropped, so the achieved rate is reported separately.
+func (g *Generator) publish(ctx context.Context, client mqtt.Client, tokens []string) (int, error) {
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / g.config.Rate))
+	defer ticker.Stop()
+
+	deadline := time.After(g.config.Duration)
+	sent := 0
+
+	for {
+		select {
+		case <-ctx.Done():
+			return sent, nil
+		case <-deadline:
+			return sent, nil
+		case <-ticker.C:
+			token := tokens[sent%len(tokens)]
+
+			payload, err := Payload(time.Now(), g.rand)
+			if err != nil {
+				return sent, err
+			}
+
+			g.recorder.sent(token, time.Now())
+
+			pub := client.Publish(mqttclient.Topic(secret.Secret(token)), 0, false, payload)
+			if pub.WaitTimeout(requestTimeout) && pub.Error() != nil {
+				return sent, errors.Wrap(pub.Error(), "failed to publish reading")
+			}
+
+			sent++
+		}
+	}
+}
+
+// drain waits until every reading published has been written, the drain period
+// has elapsed or the context is cancelled.
+func (g *Generator) drain(ctx context.Context) {
+	deadline := time.Now().Add(g.config.Drain)
+
+	for g.recorder.outstanding() > 0 && time.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// deviceToken returns a new random device token.
+func (g *Generator) deviceToken() string {
+	b := make([]byte, 8)
+	g.rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Payload returns a synthetic SmartCitizen payload recorded at the given time,
+// with random values for each sensor so that no two payloads are duplicates.
+func Payload(recordedAt time.Time, rnd *rand.Rand) ([]byte, error) {
+	sensors := make([]smartcitizen.RawSensor, len(sensorIDs))
+
+	for i, id := range sensorIDs {
+		sensors[i] = smartcitizen.RawSensor{
+			ID:    id,
+			Value: float64(rnd.Intn(100000)) / 100,
+		}
+	}
+
+	payload, err := json.Marshal(&smartcitizen.Payload{
+		Data: []smartcitizen.SensorData{
+			{
+				RecordedAt: recordedAt.UTC().Truncate(time.Second),
+				Sensors:    sensors,
+			},
+		},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal payload")
+	}
+
+	return payload, nil
+}
+
+// Summarize returns a summary of the given latencies.
+func Summarize(latencies []time.Duration) Latency {
+	if len(latencies) == 0 {
+		return Latency{}
+	}
+
+	sorted := append([]time.Duration{}, latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var total time.Duration
+	for _, l := range sorted {
+		total += l
+	}
+
+	return Latency{
+		Min:  millis(sorted[0]),
+		Mean: millis(total / time.Duration(len(sorted))),
+		P50:  millis(percentile(sorted, 0.5)),
+		P90:  millis(percentile(sorted, 0.9)),
+		P99:  millis(percentile(sorted, 0.99)),
+		Max:  millis(sorted[len(sorted)-1]),
+	}
+}
+
+// percentile returns the given percentile of the sorted latencies, by the
+// nearest rank method.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(p*float64(len(sorted))+0.999999) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
+
+// millis returns the duration in milliseconds.
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// recorder is our stub datastore. It discards the data written to it, instead
+// recording how long after being published each reading was written. Each
+// device has a single stream and readings from a device are processed in
+// order, so each write is matched with the oldest reading published for the
+// device that is yet to be written. Payloads are small enough that they are
+// never written in chunks.
+type recorder struct {
+	sync.Mutex
+	pending map[string][]time.Time
+	written []time.Duration
+}
+
+func newRecorder() *recorder {
+	return &recorder{
+		pending: make(map[string][]time.Time),
+	}
+}
+
+// sent records that a reading for the device was published at the given time.
+func (r *recorder) sent(token string, t time.Time) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.pending[token] = append(r.pending[token], t)
+}
+
+// WriteData records the latency of the reading written.
+func (r *recorder) WriteData(ctx context.Context, req *datastore.WriteRequest) (*datastore.WriteResponse, error) {
+	now := time.Now()
+
+	r.Lock()
+	defer r.Unlock()
+
+	pending := r.pending[req.DeviceToken]
+	if len(pending) == 0 {
+		return nil, twirp.NewError(twirp.FailedPrecondition, fmt.Sprintf("unexpected write for device: %s", req.DeviceToken))
+	}
+
+	r.written = append(r.written, now.Sub(pending[0]))
+	r.pending[req.DeviceToken] = pending[1:]
+
+	return &datastore.WriteResponse{}, nil
+}
+
+// ReadData returns no data, as nothing is stored.
+func (r *recorder) ReadData(ctx context.Context, req *datastore.ReadRequest) (*datastore.ReadResponse, error) {
+	return &datastore.ReadResponse{}, nil
+}
+
+// outstanding returns the number of readings published but not yet written.
+func (r *recorder) outstanding() int {
+	r.Lock()
+	defer r.Unlock()
+
+	count := 0
+	for _, pending := range r.pending {
+		count += len(pending)
+	}
+
+	return count
+}
+
+// latencies returns the latencies recorded so far.
+func (r *recorder) latencies() []time.Duration {
+	r.Lock()
+	defer r.Unlock()
+
+	return append([]time.Duration{}, r.written...)
+}
@@ -0,0 +1,52 @@
+package loadgen_test
+
+import (
+	"encoding/json"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/loadgen"
+	"github.com/DECODEproject/iotencoder/pkg/smartcitizen"
+)
+
+func TestPayload(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	recordedAt := time.Date(2026, 10, 15, 12, 0, 0, 500, time.UTC)
+
+	first, err := loadgen.Payload(recordedAt, rnd)
+	assert.Nil(t, err)
+
+	second, err := loadgen.Payload(recordedAt, rnd)
+	assert.Nil(t, err)
+
+	assert.NotEqual(t, first, second)
+
+	var payload smartcitizen.Payload
+	err = json.Unmarshal(first, &payload)
+	assert.Nil(t, err)
+
+	assert.Len(t, payload.Data, 1)
+	assert.Equal(t, recordedAt.Truncate(time.Second), payload.Data[0].RecordedAt)
+	assert.NotEmpty(t, payload.Data[0].Sensors)
+}
+
+func TestSummarize(t *testing.T) {
+	assert.Equal(t, loadgen.Latency{}, loadgen.Summarize(nil))
+
+	latencies := []time.Duration{}
+	for i := 100; i > 0; i-- {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+
+	assert.Equal(t, loadgen.Latency{
+		Min:  1,
+		Mean: 50.5,
+		P50:  50,
+		P90:  90,
+		P99:  99,
+		Max:  100,
+	}, loadgen.Summarize(latencies))
+}
@@ -0,0 +1,89 @@
+// Package level provides leveled logging on top of kitlog. Lines are tagged
+// with a level by wrapping a logger, e.g.:
+//
+//	level.Error(logger).Log("msg", "failed to write", "err", err)
+//
+// and filtered according to the current level of the logger.Leveler used when
+// the root logger was created.
+package level
+
+import (
+	"strings"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+)
+
+const (
+	// Key is the key under which the level of a log line is written.
+	Key = "level"
+)
+
+// Level is the severity of a log line.
+type Level int32
+
+const (
+	// DebugLevel is used for verbose diagnostic output.
+	DebugLevel Level = iota
+
+	// InfoLevel is the default level, used for routine operational output.
+	InfoLevel
+
+	// WarnLevel is used for unexpected but recoverable conditions.
+	WarnLevel
+
+	// ErrorLevel is used for failures.
+	ErrorLevel
+)
+
+// String returns the name of the level.
+func (l Level) String() string {
+	switch l {
+	case DebugLevel:
+		return "debug"
+	case InfoLevel:
+		return "info"
+	case WarnLevel:
+		return "warn"
+	case ErrorLevel:
+		return "error"
+	default:
+		return "unknown"
+	}
+}
+
+// Parse returns the Level with the given name.
+func Parse(name string) (Level, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return DebugLevel, nil
+	case "info":
+		return InfoLevel, nil
+	case "warn", "warning":
+		return WarnLevel, nil
+	case "error":
+		return ErrorLevel, nil
+	default:
+		return InfoLevel, errors.Errorf("unknown log level: %s", name)
+	}
+}
+
+// Debug returns a logger that writes lines at debug level.
+func Debug(logger kitlog.Logger) kitlog.Logger {
+	return kitlog.WithPrefix(logger, Key, DebugLevel)
+}
+
+// Info returns a logger that writes lines at info level.
+func Info(logger kitlog.Logger) kitlog.Logger {
+	return kitlog.WithPrefix(logger, Key, InfoLevel)
+}
+
+// Warn returns a logger that writes lines at warn level.
+func Warn(logger kitlog.Logger) kitlog.Logger {
+	return kitlog.WithPrefix(logger, Key, WarnLevel)
+}
+
+// Error returns a logger that writes lines at error level.
+func Error(logger kitlog.Logger) kitlog.Logger {
+	return kitlog.WithPrefix(logger, Key, ErrorLevel)
+}
@@ -1,21 +1,125 @@
 package logger
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
 	"os"
+	"sync/atomic"
 
-	"github.com/DECODEproject/iotencoder/pkg/version"
 	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+
+	"github.com/DECODEproject/iotencoder/pkg/logger/level"
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+	"github.com/DECODEproject/iotencoder/pkg/version"
 )
 
-// NewLogger is a simple helper function that returns a kitlog.Logger instance
-// ready for use.
-func NewLogger() kitlog.Logger {
-	logger := kitlog.NewLogfmtLogger(kitlog.NewSyncWriter(os.Stdout))
-	logger = kitlog.With(logger,
+const (
+	// FormatLogfmt is the format name for logfmt output.
+	FormatLogfmt = "logfmt"
+
+	// FormatJSON is the format name for JSON output.
+	FormatJSON = "json"
+)
+
+// Leveler holds the minimum level of lines written by a logger. It is safe for
+// concurrent use, so the level may be changed while the service is running.
+type Leveler struct {
+	level int32
+}
+
+// NewLeveler returns a new Leveler initially set to the given level.
+func NewLeveler(l level.Level) *Leveler {
+	return &Leveler{level: int32(l)}
+}
+
+// Level returns the current minimum level.
+func (l *Leveler) Level() level.Level {
+	return level.Level(atomic.LoadInt32(&l.level))
+}
+
+// SetLevel changes the current minimum level.
+func (l *Leveler) SetLevel(lvl level.Level) {
+	atomic.StoreInt32(&l.level, int32(lvl))
+}
+
+// Config is used to pass in configuration when creating a logger. Output
+// defaults to stdout.
+type Config struct {
+	Format string
+	Level  level.Level
+	Output io.Writer
+}
+
+// New returns a kitlog.Logger writing lines in the configured format, along
+// with the Leveler controlling which lines are written. Lines logged without a
+// level are treated as info.
+func New(config *Config) (kitlog.Logger, *Leveler, error) {
+	output := config.Output
+	if output == nil {
+		output = os.Stdout
+	}
+
+	var base kitlog.Logger
+
+	switch config.Format {
+	case FormatLogfmt, "":
+		base = kitlog.NewLogfmtLogger(kitlog.NewSyncWriter(output))
+	case FormatJSON:
+		base = kitlog.NewJSONLogger(kitlog.NewSyncWriter(output))
+	default:
+		return nil, nil, errors.Errorf("unknown log format: %s", config.Format)
+	}
+
+	leveler := NewLeveler(config.Level)
+
+	logger := kitlog.With(&levelFilter{next: base, leveler: leveler},
 		"service", version.BinaryName,
 		"ts", kitlog.DefaultTimestampUTC,
 		"version", version.Version,
 	)
 
+	return logger, leveler, nil
+}
+
+// NewLogger is a simple helper function that returns a kitlog.Logger instance
+// ready for use, writing logfmt at info level.
+func NewLogger() kitlog.Logger {
+	logger, _, _ := New(&Config{Format: FormatLogfmt, Level: level.InfoLevel})
 	return logger
 }
+
+// HashToken returns a short hash of a device token, so that log lines can be
+// correlated by device without revealing the token itself.
+func HashToken(token secret.Secret) string {
+	sum := sha256.Sum256([]byte(token.Reveal()))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// levelFilter is a kitlog.Logger which discards lines below the level held by
+// its Leveler.
+type levelFilter struct {
+	next    kitlog.Logger
+	leveler *Leveler
+}
+
+// Log implements kitlog.Logger.
+func (f *levelFilter) Log(keyvals ...interface{}) error {
+	lvl := level.InfoLevel
+
+	for i := 0; i < len(keyvals)-1; i += 2 {
+		if keyvals[i] == level.Key {
+			if l, ok := keyvals[i+1].(level.Level); ok {
+				lvl = l
+			}
+			break
+		}
+	}
+
+	if lvl < f.leveler.Level() {
+		return nil
+	}
+
+	return f.next.Log(keyvals...)
+}
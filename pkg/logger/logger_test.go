@@ -0,0 +1,90 @@
+package logger_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/logger"
+	"github.com/DECODEproject/iotencoder/pkg/logger/level"
+)
+
+func TestLevelFiltering(t *testing.T) {
+	var buf bytes.Buffer
+
+	l, leveler, err := logger.New(&logger.Config{
+		Format: logger.FormatLogfmt,
+		Level:  level.InfoLevel,
+		Output: &buf,
+	})
+	assert.Nil(t, err)
+
+	level.Debug(l).Log("msg", "hidden")
+	level.Info(l).Log("msg", "shown")
+	l.Log("msg", "unlevelled")
+
+	assert.NotContains(t, buf.String(), "hidden")
+	assert.Contains(t, buf.String(), "level=info")
+	assert.Contains(t, buf.String(), "msg=unlevelled")
+
+	buf.Reset()
+	leveler.SetLevel(level.DebugLevel)
+
+	level.Debug(kitlog.With(l, "module", "test")).Log("msg", "now shown")
+	assert.Contains(t, buf.String(), "level=debug")
+	assert.Contains(t, buf.String(), "now shown")
+
+	buf.Reset()
+	leveler.SetLevel(level.ErrorLevel)
+
+	level.Warn(l).Log("msg", "hidden")
+	l.Log("msg", "hidden")
+	assert.Equal(t, "", buf.String())
+}
+
+func TestJSONFormat(t *testing.T) {
+	var buf bytes.Buffer
+
+	l, _, err := logger.New(&logger.Config{
+		Format: logger.FormatJSON,
+		Level:  level.DebugLevel,
+		Output: &buf,
+	})
+	assert.Nil(t, err)
+
+	level.Error(l).Log("msg", "failed")
+
+	var line map[string]interface{}
+	err = json.Unmarshal(buf.Bytes(), &line)
+	assert.Nil(t, err)
+	assert.Equal(t, "error", line["level"])
+	assert.Equal(t, "failed", line["msg"])
+}
+
+func TestInvalidFormat(t *testing.T) {
+	_, _, err := logger.New(&logger.Config{Format: "xml"})
+	assert.NotNil(t, err)
+}
+
+func TestParseLevel(t *testing.T) {
+	for _, name := range []string{"debug", "info", "warn", "error"} {
+		lvl, err := level.Parse(strings.ToUpper(name))
+		assert.Nil(t, err)
+		assert.Equal(t, name, lvl.String())
+	}
+
+	_, err := level.Parse("loud")
+	assert.NotNil(t, err)
+}
+
+func TestHashToken(t *testing.T) {
+	hash := logger.HashToken("abc123")
+	assert.Len(t, hash, 12)
+	assert.NotContains(t, hash, "abc123")
+	assert.Equal(t, hash, logger.HashToken("abc123"))
+	assert.NotEqual(t, hash, logger.HashToken("abc124"))
+}
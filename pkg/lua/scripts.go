@@ -0,0 +1,194 @@
+package lua
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+
+	"github.com/DECODEproject/iotencoder/pkg/logger/level"
+)
+
+const (
+	// DefaultScript is the name of the script used for any processing type that
+	// has not been explicitly mapped to another script.
+	DefaultScript = "encrypt.lua"
+)
+
+// Config is used to pass in configuration when creating a Scripts instance.
+type Config struct {
+	// Dir is an optional path to a directory containing scripts. Scripts found in
+	// this directory take precedence over the embedded scripts, and changes to
+	// the directory are picked up without restarting.
+	Dir string
+
+	// Mapping is a map of processing type to script name.
+	Mapping map[string]string
+}
+
+// Scripts is a registry of the zenroom scripts used to encrypt data. Scripts
+// are selected by processing type, and are read either from an external
+// directory or from the set of scripts embedded in the binary.
+type Scripts struct {
+	dir     string
+	mapping map[string]string
+	logger  kitlog.Logger
+	watcher *fsnotify.Watcher
+
+	sync.RWMutex
+	cache map[string][]byte
+}
+
+// NewScripts returns a new Scripts instance configured with the given Config.
+func NewScripts(config *Config, logger kitlog.Logger) *Scripts {
+	logger = kitlog.With(logger, "module", "lua")
+
+	mapping := make(map[string]string)
+	for k, v := range config.Mapping {
+		mapping[k] = v
+	}
+
+	return &Scripts{
+		dir:     config.Dir,
+		mapping: mapping,
+		logger:  logger,
+		cache:   make(map[string][]byte),
+	}
+}
+
+// Start verifies that all mapped scripts can be loaded, and if an external
+// directory is configured starts watching it for changes.
+func (s *Scripts) Start() error {
+	s.logger.Log("msg", "starting scripts", "dir", s.dir)
+
+	for processingType, name := range s.mapping {
+		_, err := s.Get(name)
+		if err != nil {
+			return errors.Wrapf(err, "failed to load script for processing type: %s", processingType)
+		}
+	}
+
+	if s.dir == "" {
+		return nil
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return errors.Wrap(err, "failed to create script watcher")
+	}
+
+	err = watcher.Add(s.dir)
+	if err != nil {
+		watcher.Close()
+		return errors.Wrap(err, "failed to watch script directory")
+	}
+
+	s.watcher = watcher
+
+	go s.watch()
+
+	return nil
+}
+
+// Stop stops watching the external script directory.
+func (s *Scripts) Stop() error {
+	if s.watcher == nil {
+		return nil
+	}
+
+	s.logger.Log("msg", "stopping scripts")
+
+	return s.watcher.Close()
+}
+
+// ScriptFor returns the script to be used for the given processing type,
+// falling back to DefaultScript if no script has been mapped.
+func (s *Scripts) ScriptFor(processingType string) ([]byte, error) {
+	name, ok := s.mapping[processingType]
+	if !ok {
+		name = DefaultScript
+	}
+
+	return s.Get(name)
+}
+
+// Get returns the named script. If an external directory is configured and
+// contains a script with the given name it is returned, otherwise we return
+// the embedded script. Scripts are cached by file name, as that is all the
+// watcher knows of a changed script, so a name including a path is evicted
+// along with the file it reads.
+func (s *Scripts) Get(name string) ([]byte, error) {
+	key := filepath.Base(name)
+
+	s.RLock()
+	script, ok := s.cache[key]
+	s.RUnlock()
+
+	if ok {
+		return script, nil
+	}
+
+	script, err := s.load(name)
+	if err != nil {
+		return nil, err
+	}
+
+	s.Lock()
+	s.cache[key] = script
+	s.Unlock()
+
+	return script, nil
+}
+
+// load reads the named script from the external directory if present, or from
+// our embedded assets.
+func (s *Scripts) load(name string) ([]byte, error) {
+	if s.dir != "" {
+		script, err := ioutil.ReadFile(filepath.Join(s.dir, filepath.Base(name)))
+		if err == nil {
+			return script, nil
+		}
+
+		if !os.IsNotExist(err) {
+			return nil, errors.Wrap(err, "failed to read script")
+		}
+	}
+
+	script, err := Asset(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read embedded script")
+	}
+
+	return script, nil
+}
+
+// watch is run in a goroutine and evicts cached scripts whenever the
+// corresponding file in the external directory changes.
+func (s *Scripts) watch() {
+	for {
+		select {
+		case event, ok := <-s.watcher.Events:
+			if !ok {
+				return
+			}
+
+			name := filepath.Base(event.Name)
+
+			s.logger.Log("msg", "script changed, reloading", "script", name, "op", event.Op.String())
+
+			s.Lock()
+			delete(s.cache, name)
+			s.Unlock()
+		case err, ok := <-s.watcher.Errors:
+			if !ok {
+				return
+			}
+
+			level.Error(s.logger).Log("msg", "script watcher error", "err", err)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package lua_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/lua"
+)
+
+func TestScriptsEmbedded(t *testing.T) {
+	scripts := lua.NewScripts(&lua.Config{}, kitlog.NewNopLogger())
+
+	err := scripts.Start()
+	assert.Nil(t, err)
+	defer scripts.Stop()
+
+	embedded, err := lua.Asset("encrypt.lua")
+	assert.Nil(t, err)
+
+	script, err := scripts.ScriptFor("passthrough")
+	assert.Nil(t, err)
+	assert.Equal(t, embedded, script)
+
+	_, err = scripts.Get("unknown.lua")
+	assert.NotNil(t, err)
+}
+
+func TestScriptsUnknownMapping(t *testing.T) {
+	scripts := lua.NewScripts(&lua.Config{
+		Mapping: map[string]string{"bin": "unknown.lua"},
+	}, kitlog.NewNopLogger())
+
+	err := scripts.Start()
+	assert.NotNil(t, err)
+}
+
+func TestScriptsExternalDirWithReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scripts")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "custom.lua")
+
+	err = ioutil.WriteFile(path, []byte("print('v1')"), 0644)
+	assert.Nil(t, err)
+
+	scripts := lua.NewScripts(&lua.Config{
+		Dir:     dir,
+		Mapping: map[string]string{"average": "custom.lua"},
+	}, kitlog.NewNopLogger())
+
+	err = scripts.Start()
+	assert.Nil(t, err)
+	defer scripts.Stop()
+
+	script, err := scripts.ScriptFor("average")
+	assert.Nil(t, err)
+	assert.Equal(t, "print('v1')", string(script))
+
+	// unmapped types fall back to the embedded default
+	embedded, err := lua.Asset(lua.DefaultScript)
+	assert.Nil(t, err)
+
+	script, err = scripts.ScriptFor("passthrough")
+	assert.Nil(t, err)
+	assert.Equal(t, embedded, script)
+
+	err = ioutil.WriteFile(path, []byte("print('v2')"), 0644)
+	assert.Nil(t, err)
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		script, err = scripts.ScriptFor("average")
+		if err == nil && string(script) == "print('v2')" {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	assert.Nil(t, err)
+	assert.Equal(t, "print('v2')", string(script))
+}
+
+func TestScriptsReloadMappingWithPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scripts")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "custom.lua")
+
+	err = ioutil.WriteFile(path, []byte("print('v1')"), 0644)
+	assert.Nil(t, err)
+
+	// the script is read from the directory by its file name, so must be
+	// evicted by it when changed
+	scripts := lua.NewScripts(&lua.Config{
+		Dir:     dir,
+		Mapping: map[string]string{"average": "scripts/custom.lua"},
+	}, kitlog.NewNopLogger())
+
+	err = scripts.Start()
+	assert.Nil(t, err)
+	defer scripts.Stop()
+
+	script, err := scripts.ScriptFor("average")
+	assert.Nil(t, err)
+	assert.Equal(t, "print('v1')", string(script))
+
+	err = ioutil.WriteFile(path, []byte("print('v2')"), 0644)
+	assert.Nil(t, err)
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		script, err = scripts.ScriptFor("average")
+		if err == nil && string(script) == "print('v2')" {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	assert.Nil(t, err)
+	assert.Equal(t, "print('v2')", string(script))
+}
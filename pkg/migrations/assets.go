@@ -18,6 +18,78 @@
 // sql/20190315225536_change_stream_unique_index.up.sql (163B)
 // sql/20190512204433_add_device_label.down.sql (47B)
 // sql/20190512204433_add_device_label.up.sql (71B)
+// sql/20261015100000_add_stream_stats_table.down.sql (34B)
+// sql/20261015100000_add_stream_stats_table.up.sql (500B)
+// sql/20261015110000_add_raw_payloads_table.down.sql (34B)
+// sql/20261015110000_add_raw_payloads_table.up.sql (308B)
+// sql/20261015120000_add_partition_tables.down.sql (76B)
+// sql/20261015120000_add_partition_tables.up.sql (436B)
+// sql/20261015130000_add_stream_datastore_addr.down.sql (58B)
+// sql/20261015130000_add_stream_datastore_addr.up.sql (86B)
+// sql/20261015140000_add_downsample_checkpoints_table.down.sql (45B)
+// sql/20261015140000_add_downsample_checkpoints_table.up.sql (304B)
+// sql/20261015150000_add_stream_conversions.down.sql (55B)
+// sql/20261015150000_add_stream_conversions.up.sql (86B)
+// sql/20261015160000_add_stream_ingest_secret.down.sql (57B)
+// sql/20261015160000_add_stream_ingest_secret.up.sql (66B)
+// sql/20261015170000_add_stream_source.down.sql (50B)
+// sql/20261015170000_add_stream_source.up.sql (78B)
+// sql/20261015180000_add_device_status_table.down.sql (35B)
+// sql/20261015180000_add_device_status_table.up.sql (328B)
+// sql/20261015190000_add_stream_deleted_at.down.sql (365B)
+// sql/20261015190000_add_stream_deleted_at.up.sql (275B)
+// sql/20261015200000_add_audit_log_table.down.sql (138B)
+// sql/20261015200000_add_audit_log_table.up.sql (664B)
+// sql/20261015210000_add_stream_compression.down.sql (54B)
+// sql/20261015210000_add_stream_compression.up.sql (82B)
+// sql/20261015220000_add_message_keys_table.down.sql (35B)
+// sql/20261015220000_add_message_keys_table.up.sql (193B)
+// sql/20261015230000_add_streams_device_id_index.down.sql (44B)
+// sql/20261015230000_add_streams_device_id_index.up.sql (75B)
+// sql/20261016000000_add_stream_timestamp_policy.down.sql (59B)
+// sql/20261016000000_add_stream_timestamp_policy.up.sql (87B)
+// sql/20261017000000_add_stream_policy_id.down.sql (53B)
+// sql/20261017000000_add_stream_policy_id.up.sql (81B)
+// sql/20261018000000_add_stream_labels.down.sql (50B)
+// sql/20261018000000_add_stream_labels.up.sql (81B)
+// sql/20261019000000_add_stream_datastore_timeout.down.sql (61B)
+// sql/20261019000000_add_stream_datastore_timeout.up.sql (89B)
+// sql/20261020000000_create_payload_schemas.down.sql (97B)
+// sql/20261020000000_create_payload_schemas.up.sql (317B)
+// sql/20261021000000_add_stream_dispositions.down.sql (56B)
+// sql/20261021000000_add_stream_dispositions.up.sql (87B)
+// sql/20261022000000_add_stream_privacy.down.sql (91B)
+// sql/20261022000000_add_stream_privacy.up.sql (381B)
+// sql/20261023000000_add_stream_geo_privacy.down.sql (55B)
+// sql/20261023000000_add_stream_geo_privacy.up.sql (83B)
+// sql/20261024000000_notify_stream_changes.down.sql (164B)
+// sql/20261024000000_notify_stream_changes.up.sql (925B)
+// sql/20261025000000_add_outbox.down.sql (29B)
+// sql/20261025000000_add_outbox.up.sql (696B)
+// sql/20261026000000_add_stream_rate_limit.down.sql (54B)
+// sql/20261026000000_add_stream_rate_limit.up.sql (82B)
+// sql/20261026100000_add_stream_stats_throttled_dropped.down.sql (132B)
+// sql/20261026100000_add_stream_stats_throttled_dropped.up.sql (190B)
+// sql/20261027000000_add_stream_sampling.down.sql (52B)
+// sql/20261027000000_add_stream_sampling.up.sql (80B)
+// sql/20261028000000_add_stream_schedule.down.sql (52B)
+// sql/20261028000000_add_stream_schedule.up.sql (80B)
+// sql/20261028100000_add_outbox_deliver_after.down.sql (56B)
+// sql/20261028100000_add_outbox_deliver_after.up.sql (84B)
+// sql/20261029000000_add_stream_geofence.down.sql (52B)
+// sql/20261029000000_add_stream_geofence.up.sql (80B)
+// sql/20261030000000_add_stream_public_key_fingerprint.down.sql (66B)
+// sql/20261030000000_add_stream_public_key_fingerprint.up.sql (94B)
+// sql/20261031000000_add_stream_archive.down.sql (51B)
+// sql/20261031000000_add_stream_archive.up.sql (85B)
+// sql/20261101000000_add_stream_priority.down.sql (52B)
+// sql/20261101000000_add_stream_priority.up.sql (80B)
+// sql/20261102000000_add_stream_topic.down.sql (49B)
+// sql/20261102000000_add_stream_topic.up.sql (77B)
+// sql/20261103000000_add_stream_qos.down.sql (47B)
+// sql/20261103000000_add_stream_qos.up.sql (78B)
+// sql/20261104000000_add_outbox_dead_at.up.sql (78B)
+// sql/20261104000000_add_outbox_dead_at.down.sql (50B)
 
 package migrations
 
@@ -86,7 +158,7 @@ func (fi bindataFileInfo) Sys() interface{} {
 	return nil
 }
 
-var __20180525115614_create_device_tableDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x72\x09\xf2\x0f\x50\x08\x71\x74\xf2\x71\x55\xf0\x74\x53\x70\x8d\xf0\x0c\x0e\x09\x56\x48\x49\x2d\xcb\x4c\x4e\x2d\x56\x70\x76\x0c\x76\x76\x74\x71\xb5\xe6\xe2\x82\x28\x8b\x0c\x40\x56\x95\x5a\x51\x90\x5f\x5c\x5a\x94\x8a\xae\xcc\x35\x22\xc4\xd5\x2f\xd8\xd3\xdf\x0f\x49\x6d\x41\x7a\x72\x51\x65\x41\x49\xbe\x35\x20\x00\x00\xff\xff\xa4\x12\x3b\x91\x70\x00\x00\x00")
+var __20180525115614_create_device_tableDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x70\x00\x8f\xff\x44\x52\x4f\x50\x20\x54\x41\x42\x4c\x45\x20\x49\x46\x20\x45\x58\x49\x53\x54\x53\x20\x64\x65\x76\x69\x63\x65\x73\x20\x43\x41\x53\x43\x41\x44\x45\x3b\x0a\x0a\x44\x52\x4f\x50\x20\x54\x59\x50\x45\x20\x49\x46\x20\x45\x58\x49\x53\x54\x53\x20\x65\x78\x70\x6f\x73\x75\x72\x65\x20\x43\x41\x53\x43\x41\x44\x45\x3b\x0a\x0a\x44\x52\x4f\x50\x20\x45\x58\x54\x45\x4e\x53\x49\x4f\x4e\x20\x49\x46\x20\x45\x58\x49\x53\x54\x53\x20\x70\x67\x63\x72\x79\x70\x74\x6f\x3b\x03\x00\xa4\x12\x3b\x91\x70\x00\x00\x00")
 
 func _20180525115614_create_device_tableDownSqlBytes() ([]byte, error) {
 	return bindataRead(
@@ -101,12 +173,12 @@ func _20180525115614_create_device_tableDownSql() (*asset, error) {
 		return nil, err
 	}
 
-	info := bindataFileInfo{name: "20180525115614_create_device_table.down.sql", size: 112, mode: os.FileMode(420), modTime: time.Unix(1542929607, 0)}
+	info := bindataFileInfo{name: "20180525115614_create_device_table.down.sql", size: 112, mode: os.FileMode(420), modTime: time.Unix(1562258594, 0)}
 	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x3b, 0xff, 0x6c, 0x58, 0xd0, 0xed, 0x18, 0xff, 0x61, 0x91, 0x4d, 0x9, 0xd6, 0x88, 0xbb, 0xcd, 0xac, 0x24, 0x38, 0x5, 0xb4, 0xbc, 0x9c, 0x47, 0xd, 0x9f, 0x45, 0xb, 0x15, 0x44, 0xa1, 0x8e}}
 	return a, nil
 }
 
-var __20180525115614_create_device_tableUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x8c\x90\xcd\x6e\xc2\x30\x10\x84\xef\x79\x8a\xb9\x01\x12\x4f\x00\x27\x17\x16\xd5\x6a\xe2\xa4\xb1\x2d\x42\x2f\x88\x62\x0b\x45\x54\x36\x32\x4e\x4b\xdf\xbe\x4a\x28\x69\xfa\x73\xe8\x6d\xec\x9d\xd9\xd5\x7c\x8b\x92\x98\x22\x50\xa5\x48\x48\x9e\x0b\xf0\x15\x44\xae\x40\x15\x97\x4a\xe2\x74\xd8\x87\xf7\x53\xf4\xf3\x24\xf9\x74\xaa\x4d\x41\xb0\x97\x93\x3f\x37\xc1\x82\x49\x90\xd0\x19\xc6\xa3\xc6\x1d\x9d\x7f\x73\xa3\x29\x46\xb5\x33\xde\x87\x56\xf9\x26\x76\x72\x32\xc8\xb3\xbb\x94\x7e\x5c\x31\xf6\xb5\xde\xdb\x33\xc6\x09\x50\x1b\x48\x2a\x39\x4b\x51\x94\x3c\x63\xe5\x06\x0f\xb4\x99\x26\xc0\x73\xf0\x47\x1b\xa0\xa8\x52\x5d\x56\xe8\x34\x6d\xff\xaf\xe1\x6d\xf4\x47\xeb\x7e\x4f\x5f\xbc\x3b\xd4\xb1\x31\x16\xcb\x5c\xb7\x97\x8b\x92\x16\xbc\x6b\xfa\xcd\xb6\x8b\xff\x70\xf5\xb5\x7b\x71\x9b\x62\x49\x2b\xa6\x53\x85\x9e\xc3\x6c\x76\x33\xb5\xc9\x7d\xb0\xbb\x68\xcd\x76\x17\xa1\x78\x46\x52\xb1\xac\xc0\x9a\xab\xfb\xee\x89\xa7\x5c\x50\xbf\x42\xe4\xeb\xf1\x24\x19\x20\xd3\x82\x3f\x6a\x02\x17\x4b\xaa\xfe\x26\x77\x6d\xbf\xad\xcd\x25\x01\x72\xf1\x05\x74\x08\x67\x32\xff\x08\x00\x00\xff\xff\x32\xbc\xcf\x9a\xed\x01\x00\x00")
+var __20180525115614_create_device_tableUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x8c\x90\xcf\x6e\xf2\x30\x10\xc4\xef\x79\x8a\xb9\x11\x24\x9e\x00\x4e\xfe\x60\xd1\x67\x35\x71\xd2\xd8\x16\xa1\x17\x44\xb1\x85\x22\x2a\x1b\x19\xa7\xa5\x6f\x5f\x25\x94\x28\xfd\x73\xe8\x6d\xbc\x3b\xbb\xeb\xf9\x2d\x2b\x62\x8a\x40\xb5\x22\x21\x79\x21\xc0\xd7\x10\x85\x02\xd5\x5c\x2a\x89\xf3\xf1\x10\xde\xcf\xd1\x2f\x92\xe4\xd3\xa9\xb6\x25\xc1\x5e\xcf\xfe\xd2\x06\x0b\x26\x41\x42\xe7\x48\x27\xad\x3b\x39\xff\xe6\x26\x33\x4c\x1a\x67\xbc\x0f\x9d\xf2\x6d\xec\xe5\x74\x34\xcf\xfe\x65\xf4\xed\x8a\xb1\xaf\xcd\xc1\x5e\x90\x26\x40\x63\x20\xa9\xe2\x2c\x43\x59\xf1\x9c\x55\x5b\x3c\xd0\x76\x96\x00\xcf\xc1\x9f\x6c\x80\xa2\x5a\xf5\x3f\x14\x3a\xcb\xba\xfa\x6d\x78\x17\xfd\xc9\xba\x9f\xdd\x17\xef\x8e\x4d\x6c\x8d\xc5\xaa\xd0\xdd\xe5\xb2\xa2\x25\xef\x93\x7e\xb1\xed\xe3\x1f\x5c\x43\xec\x41\xdc\xbb\x58\xd1\x9a\xe9\x4c\x61\xe0\x30\x9f\xdf\x4d\xdd\xfe\x43\xb0\xfb\x68\xcd\x6e\x1f\xa1\x78\x4e\x52\xb1\xbc\xc4\x86\xab\xff\xfd\x13\x4f\x85\xa0\x61\x85\x28\x36\xe9\x34\x19\x21\xd3\x82\x3f\x6a\x02\x17\x2b\xaa\x7f\x27\x77\x4b\xbf\x6b\xcc\x35\x01\x0a\x71\x2f\x23\x1d\xc3\x99\x2e\x3e\x06\x00\x32\xbc\xcf\x9a\xed\x01\x00\x00")
 
 func _20180525115614_create_device_tableUpSqlBytes() ([]byte, error) {
 	return bindataRead(
@@ -121,12 +193,12 @@ func _20180525115614_create_device_tableUpSql() (*asset, error) {
 		return nil, err
 	}
 
-	info := bindataFileInfo{name: "20180525115614_create_device_table.up.sql", size: 493, mode: os.FileMode(420), modTime: time.Unix(1542929607, 0)}
+	info := bindataFileInfo{name: "20180525115614_create_device_table.up.sql", size: 493, mode: os.FileMode(420), modTime: time.Unix(1562258594, 0)}
 	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xa5, 0x2b, 0x77, 0x99, 0x65, 0x10, 0xae, 0xfa, 0x52, 0x1f, 0x37, 0x2b, 0x4c, 0xc, 0x80, 0x1, 0x8c, 0x65, 0x8b, 0x6b, 0xf0, 0xd0, 0x1e, 0x9b, 0x65, 0xdf, 0xca, 0xbd, 0xd2, 0x9b, 0x1, 0xa}}
 	return a, nil
 }
 
-var __20180526232618_add_streams_tableDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x72\x09\xf2\x0f\x50\x08\x71\x74\xf2\x71\x55\xf0\x74\x53\x70\x8d\xf0\x0c\x0e\x09\x56\x28\x2e\x29\x4a\x4d\xcc\x2d\x56\x70\x76\x0c\x76\x76\x74\x71\xb5\x06\x04\x00\x00\xff\xff\xa6\x34\x43\x4f\x25\x00\x00\x00")
+var __20180526232618_add_streams_tableDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x25\x00\xda\xff\x44\x52\x4f\x50\x20\x54\x41\x42\x4c\x45\x20\x49\x46\x20\x45\x58\x49\x53\x54\x53\x20\x73\x74\x72\x65\x61\x6d\x73\x20\x43\x41\x53\x43\x41\x44\x45\x3b\x03\x00\xa6\x34\x43\x4f\x25\x00\x00\x00")
 
 func _20180526232618_add_streams_tableDownSqlBytes() ([]byte, error) {
 	return bindataRead(
@@ -141,12 +213,12 @@ func _20180526232618_add_streams_tableDownSql() (*asset, error) {
 		return nil, err
 	}
 
-	info := bindataFileInfo{name: "20180526232618_add_streams_table.down.sql", size: 37, mode: os.FileMode(420), modTime: time.Unix(1542206352, 0)}
+	info := bindataFileInfo{name: "20180526232618_add_streams_table.down.sql", size: 37, mode: os.FileMode(420), modTime: time.Unix(1562258594, 0)}
 	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x76, 0x96, 0x77, 0xdd, 0x2, 0x53, 0x31, 0x1d, 0x8e, 0x44, 0x5b, 0x3f, 0x38, 0x8b, 0x5f, 0xed, 0x94, 0x30, 0x7a, 0x61, 0xe1, 0x1a, 0x55, 0x2, 0x76, 0x3d, 0xea, 0xf2, 0xb1, 0x75, 0xe7, 0x47}}
 	return a, nil
 }
 
-var __20180526232618_add_streams_tableUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x6c\x90\xcd\x4e\xeb\x30\x10\x85\xf7\x7e\x8a\xb3\x4c\xa4\xbe\x41\x57\x6e\x3b\xb9\xd7\xc2\x71\x8a\x3d\x51\x13\x36\x56\x88\xbd\xb0\x5a\x28\x6a\x02\xa2\x6f\x8f\x12\x95\x00\x82\xe5\xcc\xf9\xd1\x7c\xb3\xb5\x24\x99\xc0\x72\xa3\x09\xaa\x80\xa9\x18\xd4\x28\xc7\x0e\xc3\x78\x89\xdd\xd3\x80\x4c\x00\x29\xc0\x91\x55\x52\x63\x6f\x55\x29\x6d\x8b\x3b\x6a\x57\x02\x08\xf1\x2d\xf5\xd1\xa7\x00\x65\x98\xfe\x91\x9d\x1b\x4c\xad\x35\x2c\x15\x64\xc9\x6c\xc9\xdd\x5c\x43\x96\x42\x3e\x85\x5e\x5e\x1f\x4f\xa9\xf7\xc7\x78\x05\x53\xc3\x4b\x64\xd6\xce\xa7\xd4\x5f\xa7\xc2\x5f\xd2\x78\x3e\xc6\x67\x6c\x5a\x26\xf9\x63\xdf\x5f\x62\x37\xc6\xe0\xbb\x11\xac\x4a\x72\x2c\xcb\x3d\x0e\x8a\xff\xcf\x23\x1e\x2a\x43\xd8\x51\x21\x6b\x3d\xf5\x1d\xb2\x5c\xe4\x6b\x21\x6e\xe4\xb5\x51\xf7\x35\x41\x99\x1d\x35\x7f\x3f\xc0\x2f\x8c\xfe\xeb\x70\x9f\xc2\xbb\x00\x2a\xf3\xe9\xca\x16\xd7\xea\x1b\x5f\xbe\x16\x1f\x01\x00\x00\xff\xff\x54\x2c\xaa\xbe\x62\x01\x00\x00")
+var __20180526232618_add_streams_tableUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x6c\x90\xcd\x4e\xeb\x30\x10\x85\xf7\x7e\x8a\xb3\x4c\xa4\xbe\x41\x57\x6e\x3b\xb9\xd7\xc2\x71\x8a\x3d\x51\x13\x36\x56\x88\xbd\xb0\x5a\x28\x6a\x02\xa2\x6f\x8f\x12\x95\x00\x82\xa5\x7d\x7e\x66\xbe\xd9\x5a\x92\x4c\x60\xb9\xd1\x04\x55\xc0\x54\x0c\x6a\x94\x63\x87\x61\xbc\xc4\xee\x69\x40\x26\x80\x14\xe0\xc8\x2a\xa9\xb1\xb7\xaa\x94\xb6\xc5\x1d\xb5\x2b\x01\x84\xf8\x96\xfa\xe8\x53\x80\x32\x4c\xff\xc8\xce\x0d\xa6\xd6\x1a\x96\x0a\xb2\x64\xb6\xe4\x6e\xae\x21\x4b\x21\x9f\x42\x2f\xaf\x8f\xa7\xd4\xfb\x63\xbc\x82\xa9\xe1\x25\x32\x6b\xe7\x53\xea\xaf\x53\xe1\x2f\x69\x3c\x1f\xe3\x33\x36\x2d\x93\xfc\xf1\xdf\x5f\x62\x37\xc6\xe0\xbb\x11\xac\x4a\x72\x2c\xcb\x3d\x0e\x8a\xff\xcf\x4f\x3c\x54\x86\xb0\xa3\x42\xd6\x7a\x1a\x75\xc8\x72\x91\xaf\x85\xb8\x91\xd7\x46\xdd\xd7\x04\x65\x76\xd4\xfc\x7d\x00\xbf\x30\xfa\xaf\xc5\x7d\x0a\xef\x02\xa8\xcc\xa7\x2b\x5b\x5c\xab\x6f\x7c\xf9\x5a\x7c\x0c\x00\x54\x2c\xaa\xbe\x62\x01\x00\x00")
 
 func _20180526232618_add_streams_tableUpSqlBytes() ([]byte, error) {
 	return bindataRead(
@@ -161,12 +233,12 @@ func _20180526232618_add_streams_tableUpSql() (*asset, error) {
 		return nil, err
 	}
 
-	info := bindataFileInfo{name: "20180526232618_add_streams_table.up.sql", size: 354, mode: os.FileMode(420), modTime: time.Unix(1542929607, 0)}
+	info := bindataFileInfo{name: "20180526232618_add_streams_table.up.sql", size: 354, mode: os.FileMode(420), modTime: time.Unix(1562258594, 0)}
 	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xc8, 0x23, 0xef, 0x1d, 0x3, 0x67, 0xfa, 0x95, 0xba, 0xd7, 0xd6, 0xe0, 0x66, 0xb1, 0xcc, 0x11, 0x3d, 0xe, 0x73, 0x6c, 0x48, 0xde, 0xb1, 0x1f, 0xb1, 0x5, 0x58, 0x59, 0xae, 0x2e, 0xf0, 0xec}}
 	return a, nil
 }
 
-var __20181202133704_add_operationsDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x72\xf4\x09\x71\x0d\x52\x08\x71\x74\xf2\x71\x55\x28\x2e\x29\x4a\x4d\xcc\x2d\xe6\x52\x50\x70\x09\xf2\x0f\x50\x70\xf6\xf7\x09\xf5\xf5\x53\xc8\x2f\x48\x2d\x4a\x2c\xc9\xcc\xcf\x2b\xb6\x06\x04\x00\x00\xff\xff\x57\x1c\xaa\xf8\x2d\x00\x00\x00")
+var __20181202133704_add_operationsDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x2d\x00\xd2\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x73\x74\x72\x65\x61\x6d\x73\x0a\x20\x20\x44\x52\x4f\x50\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x6f\x70\x65\x72\x61\x74\x69\x6f\x6e\x73\x3b\x03\x00\x57\x1c\xaa\xf8\x2d\x00\x00\x00")
 
 func _20181202133704_add_operationsDownSqlBytes() ([]byte, error) {
 	return bindataRead(
@@ -181,12 +253,12 @@ func _20181202133704_add_operationsDownSql() (*asset, error) {
 		return nil, err
 	}
 
-	info := bindataFileInfo{name: "20181202133704_add_operations.down.sql", size: 45, mode: os.FileMode(420), modTime: time.Unix(1552671827, 0)}
+	info := bindataFileInfo{name: "20181202133704_add_operations.down.sql", size: 45, mode: os.FileMode(420), modTime: time.Unix(1562258594, 0)}
 	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xef, 0x17, 0xa5, 0xa6, 0x56, 0xf, 0xce, 0xd8, 0xfd, 0xdc, 0xfd, 0x79, 0xf4, 0x17, 0x51, 0x29, 0xb1, 0xcf, 0xb9, 0x55, 0xb1, 0x2e, 0x58, 0x16, 0x69, 0xe3, 0xa8, 0x21, 0xf5, 0xa0, 0xe6, 0x41}}
 	return a, nil
 }
 
-var __20181202133704_add_operationsUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x72\xf4\x09\x71\x0d\x52\x08\x71\x74\xf2\x71\x55\x28\x2e\x29\x4a\x4d\xcc\x2d\xe6\x52\x50\x70\x74\x71\x51\x70\xf6\xf7\x09\xf5\xf5\x53\xc8\x2f\x48\x2d\x4a\x2c\xc9\xcc\xcf\x2b\x56\xf0\x0a\xf6\xf7\x73\xb2\x06\x04\x00\x00\xff\xff\x97\xbc\x02\xc2\x32\x00\x00\x00")
+var __20181202133704_add_operationsUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x32\x00\xcd\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x73\x74\x72\x65\x61\x6d\x73\x0a\x20\x20\x41\x44\x44\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x6f\x70\x65\x72\x61\x74\x69\x6f\x6e\x73\x20\x4a\x53\x4f\x4e\x42\x3b\x03\x00\x97\xbc\x02\xc2\x32\x00\x00\x00")
 
 func _20181202133704_add_operationsUpSqlBytes() ([]byte, error) {
 	return bindataRead(
@@ -201,12 +273,12 @@ func _20181202133704_add_operationsUpSql() (*asset, error) {
 		return nil, err
 	}
 
-	info := bindataFileInfo{name: "20181202133704_add_operations.up.sql", size: 50, mode: os.FileMode(420), modTime: time.Unix(1552671827, 0)}
+	info := bindataFileInfo{name: "20181202133704_add_operations.up.sql", size: 50, mode: os.FileMode(420), modTime: time.Unix(1562258594, 0)}
 	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xe3, 0x81, 0x8c, 0xf7, 0x44, 0xa5, 0x90, 0xca, 0x30, 0x21, 0xb8, 0x6a, 0x65, 0xb6, 0x9, 0x89, 0x3f, 0x34, 0x99, 0x2b, 0xc5, 0x3a, 0xd3, 0x82, 0x2c, 0xae, 0xce, 0xb3, 0xf5, 0x28, 0x88, 0x16}}
 	return a, nil
 }
 
-var __20190306164350_remove_broker_colDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x72\xf4\x09\x71\x0d\x52\x08\x71\x74\xf2\x71\x55\x48\x49\x2d\xcb\x4c\x4e\x2d\x56\x70\x74\x71\x51\x70\xf6\xf7\x09\xf5\xf5\x53\x48\x2a\xca\xcf\x4e\x2d\x52\x08\x71\x8d\x08\xb1\x06\x04\x00\x00\xff\xff\xb8\xa4\xe3\x27\x2b\x00\x00\x00")
+var __20190306164350_remove_broker_colDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x2b\x00\xd4\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x64\x65\x76\x69\x63\x65\x73\x20\x41\x44\x44\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x62\x72\x6f\x6b\x65\x72\x20\x54\x45\x58\x54\x3b\x03\x00\xb8\xa4\xe3\x27\x2b\x00\x00\x00")
 
 func _20190306164350_remove_broker_colDownSqlBytes() ([]byte, error) {
 	return bindataRead(
@@ -221,12 +293,12 @@ func _20190306164350_remove_broker_colDownSql() (*asset, error) {
 		return nil, err
 	}
 
-	info := bindataFileInfo{name: "20190306164350_remove_broker_col.down.sql", size: 43, mode: os.FileMode(420), modTime: time.Unix(1552671827, 0)}
+	info := bindataFileInfo{name: "20190306164350_remove_broker_col.down.sql", size: 43, mode: os.FileMode(420), modTime: time.Unix(1562258594, 0)}
 	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x73, 0x12, 0x9a, 0xd9, 0xd2, 0x13, 0x16, 0x44, 0x33, 0x66, 0x1d, 0xa6, 0xc6, 0x3c, 0xf2, 0x1c, 0x8d, 0xda, 0x6a, 0xa5, 0x22, 0x83, 0x0, 0xeb, 0xd0, 0x94, 0x7d, 0xef, 0xb4, 0x40, 0xfb, 0x20}}
 	return a, nil
 }
 
-var __20190306164350_remove_broker_colUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x72\xf4\x09\x71\x0d\x52\x08\x71\x74\xf2\x71\x55\x48\x49\x2d\xcb\x4c\x4e\x2d\x56\x70\x09\xf2\x0f\x50\x70\xf6\xf7\x09\xf5\xf5\x53\x48\x2a\xca\xcf\x4e\x2d\xb2\x06\x04\x00\x00\xff\xff\x42\x2d\xf7\x17\x27\x00\x00\x00")
+var __20190306164350_remove_broker_colUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x27\x00\xd8\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x64\x65\x76\x69\x63\x65\x73\x20\x44\x52\x4f\x50\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x62\x72\x6f\x6b\x65\x72\x3b\x03\x00\x42\x2d\xf7\x17\x27\x00\x00\x00")
 
 func _20190306164350_remove_broker_colUpSqlBytes() ([]byte, error) {
 	return bindataRead(
@@ -241,12 +313,12 @@ func _20190306164350_remove_broker_colUpSql() (*asset, error) {
 		return nil, err
 	}
 
-	info := bindataFileInfo{name: "20190306164350_remove_broker_col.up.sql", size: 39, mode: os.FileMode(420), modTime: time.Unix(1552671827, 0)}
+	info := bindataFileInfo{name: "20190306164350_remove_broker_col.up.sql", size: 39, mode: os.FileMode(420), modTime: time.Unix(1562258594, 0)}
 	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x27, 0xcf, 0x6e, 0x61, 0xcd, 0x94, 0xbb, 0x70, 0xd3, 0x57, 0xe, 0x83, 0xf3, 0xc2, 0xec, 0x4d, 0xc8, 0xd5, 0x19, 0x54, 0xfa, 0xa4, 0x57, 0x95, 0x8c, 0x59, 0xcf, 0x8d, 0xba, 0x43, 0xa8, 0x5a}}
 	return a, nil
 }
 
-var __20190306170548_add_certificate_tableDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x72\x09\xf2\x0f\x50\x08\x71\x74\xf2\x71\x55\xf0\x74\x53\x70\x8d\xf0\x0c\x0e\x09\x56\x48\x4e\x2d\x2a\xc9\x4c\xcb\x4c\x4e\x2c\x49\x2d\xb6\x06\x04\x00\x00\xff\xff\x9b\x6a\xf7\x60\x22\x00\x00\x00")
+var __20190306170548_add_certificate_tableDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x22\x00\xdd\xff\x44\x52\x4f\x50\x20\x54\x41\x42\x4c\x45\x20\x49\x46\x20\x45\x58\x49\x53\x54\x53\x20\x63\x65\x72\x74\x69\x66\x69\x63\x61\x74\x65\x73\x3b\x03\x00\x9b\x6a\xf7\x60\x22\x00\x00\x00")
 
 func _20190306170548_add_certificate_tableDownSqlBytes() ([]byte, error) {
 	return bindataRead(
@@ -261,12 +333,12 @@ func _20190306170548_add_certificate_tableDownSql() (*asset, error) {
 		return nil, err
 	}
 
-	info := bindataFileInfo{name: "20190306170548_add_certificate_table.down.sql", size: 34, mode: os.FileMode(420), modTime: time.Unix(1552671827, 0)}
+	info := bindataFileInfo{name: "20190306170548_add_certificate_table.down.sql", size: 34, mode: os.FileMode(420), modTime: time.Unix(1562258594, 0)}
 	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x3d, 0x85, 0xef, 0x15, 0xa1, 0x51, 0x74, 0x22, 0x6b, 0x2f, 0xde, 0x28, 0x99, 0xb5, 0x60, 0xd6, 0xe8, 0x10, 0x23, 0xa7, 0x48, 0x63, 0xf2, 0xc4, 0x3c, 0xca, 0x83, 0x1f, 0xb4, 0x65, 0xad, 0x98}}
 	return a, nil
 }
 
-var __20190306170548_add_certificate_tableUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x72\x0e\x72\x75\x0c\x71\x55\x08\x71\x74\xf2\x71\x55\xf0\x74\x53\xf0\xf3\x0f\x51\x70\x8d\xf0\x0c\x0e\x09\x56\x48\x4e\x2d\x2a\xc9\x4c\xcb\x4c\x4e\x2c\x49\x2d\x56\xd0\xe0\x52\x50\xc8\x4e\xad\x54\x08\x71\x8d\x08\x01\x2b\xf2\x0b\xf5\xf1\x51\x08\x08\xf2\xf4\x75\x0c\x8a\x54\xf0\x76\x8d\xd4\xe1\x52\x40\xd6\xa1\xe0\x14\x19\xe2\xea\x08\x57\xc9\xa5\x69\x0d\x08\x00\x00\xff\xff\x2d\x4d\xb2\x71\x6a\x00\x00\x00")
+var __20190306170548_add_certificate_tableUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x6a\x00\x95\xff\x43\x52\x45\x41\x54\x45\x20\x54\x41\x42\x4c\x45\x20\x49\x46\x20\x4e\x4f\x54\x20\x45\x58\x49\x53\x54\x53\x20\x63\x65\x72\x74\x69\x66\x69\x63\x61\x74\x65\x73\x20\x28\x0a\x20\x20\x6b\x65\x79\x20\x54\x45\x58\x54\x20\x4e\x4f\x54\x20\x4e\x55\x4c\x4c\x20\x50\x52\x49\x4d\x41\x52\x59\x20\x4b\x45\x59\x2c\x0a\x20\x20\x63\x65\x72\x74\x69\x66\x69\x63\x61\x74\x65\x20\x42\x59\x54\x45\x41\x20\x4e\x4f\x54\x20\x4e\x55\x4c\x4c\x0a\x29\x3b\x03\x00\x2d\x4d\xb2\x71\x6a\x00\x00\x00")
 
 func _20190306170548_add_certificate_tableUpSqlBytes() ([]byte, error) {
 	return bindataRead(
@@ -281,12 +353,12 @@ func _20190306170548_add_certificate_tableUpSql() (*asset, error) {
 		return nil, err
 	}
 
-	info := bindataFileInfo{name: "20190306170548_add_certificate_table.up.sql", size: 106, mode: os.FileMode(420), modTime: time.Unix(1552671827, 0)}
+	info := bindataFileInfo{name: "20190306170548_add_certificate_table.up.sql", size: 106, mode: os.FileMode(420), modTime: time.Unix(1562258594, 0)}
 	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x66, 0x3c, 0x3, 0x6a, 0x8c, 0x5a, 0x0, 0xe2, 0xca, 0x24, 0x4b, 0xf0, 0x4b, 0x55, 0xb2, 0xc4, 0x3f, 0x19, 0x75, 0x20, 0x4f, 0xd3, 0x4d, 0xc6, 0xa6, 0x9b, 0xbb, 0xc1, 0x94, 0x70, 0xbc, 0x38}}
 	return a, nil
 }
 
-var __20190308144957_rename_policy_idDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x72\xf4\x09\x71\x0d\x52\x08\x71\x74\xf2\x71\x55\x28\x2e\x29\x4a\x4d\xcc\x2d\xe6\x52\x50\x08\x72\xf5\x73\xf4\x75\x55\x70\xf6\xf7\x09\xf5\xf5\x53\x48\xce\xcf\xcd\x2d\xcd\xcb\x2c\xa9\x8c\xcf\x4c\x51\x08\xf1\x57\x28\xc8\xcf\xc9\x4c\x06\x71\xac\x01\x01\x00\x00\xff\xff\xe7\x3c\x58\x88\x3e\x00\x00\x00")
+var __20190308144957_rename_policy_idDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x3e\x00\xc1\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x73\x74\x72\x65\x61\x6d\x73\x0a\x20\x20\x52\x45\x4e\x41\x4d\x45\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x63\x6f\x6d\x6d\x75\x6e\x69\x74\x79\x5f\x69\x64\x20\x54\x4f\x20\x70\x6f\x6c\x69\x63\x79\x5f\x69\x64\x3b\x03\x00\xe7\x3c\x58\x88\x3e\x00\x00\x00")
 
 func _20190308144957_rename_policy_idDownSqlBytes() ([]byte, error) {
 	return bindataRead(
@@ -301,12 +373,12 @@ func _20190308144957_rename_policy_idDownSql() (*asset, error) {
 		return nil, err
 	}
 
-	info := bindataFileInfo{name: "20190308144957_rename_policy_id.down.sql", size: 62, mode: os.FileMode(420), modTime: time.Unix(1552671827, 0)}
+	info := bindataFileInfo{name: "20190308144957_rename_policy_id.down.sql", size: 62, mode: os.FileMode(420), modTime: time.Unix(1562258594, 0)}
 	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x41, 0x9f, 0xd1, 0x62, 0xa4, 0x15, 0x9e, 0x20, 0x98, 0xca, 0x4f, 0x1c, 0xd9, 0xe4, 0xe7, 0xe3, 0x30, 0xc1, 0xc6, 0xc8, 0x9f, 0xd7, 0x6d, 0xce, 0x36, 0xfe, 0xa7, 0xa5, 0xa7, 0x59, 0xf7, 0x6f}}
 	return a, nil
 }
 
-var __20190308144957_rename_policy_idUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x72\xf4\x09\x71\x0d\x52\x08\x71\x74\xf2\x71\x55\x28\x2e\x29\x4a\x4d\xcc\x2d\xe6\x52\x50\x08\x72\xf5\x73\xf4\x75\x55\x70\xf6\xf7\x09\xf5\xf5\x53\x28\xc8\xcf\xc9\x4c\xae\x8c\xcf\x4c\x51\x08\xf1\x57\x48\xce\xcf\xcd\x2d\xcd\xcb\x2c\x01\xf1\xad\x01\x01\x00\x00\xff\xff\x69\x65\xa3\xeb\x3e\x00\x00\x00")
+var __20190308144957_rename_policy_idUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x3e\x00\xc1\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x73\x74\x72\x65\x61\x6d\x73\x0a\x20\x20\x52\x45\x4e\x41\x4d\x45\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x70\x6f\x6c\x69\x63\x79\x5f\x69\x64\x20\x54\x4f\x20\x63\x6f\x6d\x6d\x75\x6e\x69\x74\x79\x5f\x69\x64\x3b\x03\x00\x69\x65\xa3\xeb\x3e\x00\x00\x00")
 
 func _20190308144957_rename_policy_idUpSqlBytes() ([]byte, error) {
 	return bindataRead(
@@ -321,12 +393,12 @@ func _20190308144957_rename_policy_idUpSql() (*asset, error) {
 		return nil, err
 	}
 
-	info := bindataFileInfo{name: "20190308144957_rename_policy_id.up.sql", size: 62, mode: os.FileMode(420), modTime: time.Unix(1552671827, 0)}
+	info := bindataFileInfo{name: "20190308144957_rename_policy_id.up.sql", size: 62, mode: os.FileMode(420), modTime: time.Unix(1562258594, 0)}
 	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xcc, 0x5b, 0x7d, 0xd, 0x38, 0x77, 0xf, 0xcd, 0x16, 0x40, 0xd8, 0x41, 0xc2, 0x4b, 0x7b, 0x81, 0x98, 0xd3, 0xb6, 0x5c, 0x1d, 0x87, 0xdb, 0x42, 0x76, 0x2b, 0xe6, 0x7c, 0xc8, 0x39, 0x2, 0x85}}
 	return a, nil
 }
 
-var __20190315170620_add_uuid_column_to_streamDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x72\xf4\x09\x71\x0d\x52\x08\x71\x74\xf2\x71\x55\x28\x2e\x29\x4a\x4d\xcc\x2d\xe6\x52\x50\x70\x09\xf2\x0f\x50\x70\xf6\xf7\x09\xf5\xf5\x53\x28\x2d\xcd\x4c\xb1\x06\x04\x00\x00\xff\xff\x98\x01\x3c\xa4\x27\x00\x00\x00")
+var __20190315170620_add_uuid_column_to_streamDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x27\x00\xd8\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x73\x74\x72\x65\x61\x6d\x73\x0a\x20\x20\x44\x52\x4f\x50\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x75\x75\x69\x64\x3b\x03\x00\x98\x01\x3c\xa4\x27\x00\x00\x00")
 
 func _20190315170620_add_uuid_column_to_streamDownSqlBytes() ([]byte, error) {
 	return bindataRead(
@@ -341,12 +413,12 @@ func _20190315170620_add_uuid_column_to_streamDownSql() (*asset, error) {
 		return nil, err
 	}
 
-	info := bindataFileInfo{name: "20190315170620_add_uuid_column_to_stream.down.sql", size: 39, mode: os.FileMode(420), modTime: time.Unix(1552671827, 0)}
+	info := bindataFileInfo{name: "20190315170620_add_uuid_column_to_stream.down.sql", size: 39, mode: os.FileMode(420), modTime: time.Unix(1562258594, 0)}
 	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xee, 0x5c, 0x86, 0x4a, 0x1b, 0x17, 0xf9, 0xa1, 0x4, 0xc7, 0x18, 0x12, 0xf7, 0x4f, 0xc3, 0x45, 0x6f, 0xc3, 0x64, 0xd3, 0x16, 0x1c, 0x9a, 0x61, 0x66, 0xd3, 0x64, 0x55, 0xbb, 0xc, 0xdc, 0xf1}}
 	return a, nil
 }
 
-var __20190315170620_add_uuid_column_to_streamUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x34\xcc\xb1\x0a\xc2\x50\x0c\x85\xe1\xfd\x3e\xc5\x19\xf5\x19\x3a\xc5\x26\x42\x20\xe6\x62\x9b\x40\xb7\x22\xd4\xa1\x83\x8b\xb5\xe0\xe3\x4b\x85\xbb\x7e\x9c\xf3\x93\x85\x0c\x08\xba\x98\x60\xfb\xbc\x9f\x8f\xd7\x56\x00\x62\x46\x5f\x2d\x6f\x8e\x7d\x5f\x17\x64\x2a\xc3\x6b\xc0\xd3\xac\x2b\xa5\x1f\x84\x42\x90\xae\xf7\x14\xa8\xb3\x4c\xd0\xeb\x7f\x21\x93\x8e\x31\xb6\xd6\x7c\xdc\xe7\x75\xf9\x16\xa0\x7a\x53\x9c\x0e\x3e\x77\xbf\x00\x00\x00\xff\xff\x8e\x65\xf6\x21\x7d\x00\x00\x00")
+var __20190315170620_add_uuid_column_to_streamUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x7d\x00\x82\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x73\x74\x72\x65\x61\x6d\x73\x0a\x20\x20\x41\x44\x44\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x75\x75\x69\x64\x20\x55\x55\x49\x44\x20\x4e\x4f\x54\x20\x4e\x55\x4c\x4c\x3b\x0a\x0a\x43\x52\x45\x41\x54\x45\x20\x55\x4e\x49\x51\x55\x45\x20\x49\x4e\x44\x45\x58\x20\x49\x46\x20\x4e\x4f\x54\x20\x45\x58\x49\x53\x54\x53\x20\x73\x74\x72\x65\x61\x6d\x73\x5f\x75\x75\x69\x64\x5f\x69\x64\x78\x0a\x20\x20\x4f\x4e\x20\x73\x74\x72\x65\x61\x6d\x73\x20\x28\x75\x75\x69\x64\x29\x3b\x03\x00\x8e\x65\xf6\x21\x7d\x00\x00\x00")
 
 func _20190315170620_add_uuid_column_to_streamUpSqlBytes() ([]byte, error) {
 	return bindataRead(
@@ -361,12 +433,12 @@ func _20190315170620_add_uuid_column_to_streamUpSql() (*asset, error) {
 		return nil, err
 	}
 
-	info := bindataFileInfo{name: "20190315170620_add_uuid_column_to_stream.up.sql", size: 125, mode: os.FileMode(420), modTime: time.Unix(1552671827, 0)}
+	info := bindataFileInfo{name: "20190315170620_add_uuid_column_to_stream.up.sql", size: 125, mode: os.FileMode(420), modTime: time.Unix(1562258594, 0)}
 	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x63, 0xe8, 0xa6, 0xbe, 0x10, 0xc0, 0x22, 0x47, 0x38, 0x51, 0x1a, 0x88, 0x1e, 0x34, 0x16, 0xa7, 0xf, 0x0, 0x7f, 0xb0, 0xd1, 0x7f, 0xc5, 0x90, 0xec, 0x9f, 0x38, 0xd4, 0x9b, 0xfa, 0xf8, 0x37}}
 	return a, nil
 }
 
-var __20190315225536_change_stream_unique_indexDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x72\x09\xf2\x0f\x50\xf0\xf4\x73\x71\x8d\x50\xf0\x74\x53\x70\x8d\xf0\x0c\x0e\x09\x56\x28\x2e\x29\x4a\x4d\xcc\x2d\x8e\x4f\x49\x2d\xcb\x4c\x4e\x8d\xcf\x4c\x89\x4f\xce\xcf\xcd\x2d\xcd\xcb\x2c\xa9\x04\x71\x32\x53\x2a\xac\xb9\xb8\x9c\x83\x5c\x1d\x43\x5c\x15\x42\xfd\x3c\x03\x43\x5d\x11\x46\xf8\xf9\x87\xe0\x36\xa6\xa0\x34\x29\x27\x33\x39\x3e\x3b\x15\x64\x4e\x05\x97\x82\x82\xbf\x1f\x4c\x95\x06\x5c\x95\x8e\x02\x42\x99\xa6\x35\x20\x00\x00\xff\xff\xbf\xf2\x66\xc2\xa1\x00\x00\x00")
+var __20190315225536_change_stream_unique_indexDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x72\x09\xf2\x0f\x50\xf0\xf4\x73\x71\x8d\x50\xf0\x74\x53\x70\x8d\xf0\x0c\x0e\x09\x56\x28\x2e\x29\x4a\x4d\xcc\x2d\x8e\x4f\x49\x2d\xcb\x4c\x4e\x8d\xcf\x4c\x89\x4f\xce\xcf\xcd\x2d\xcd\xcb\x2c\xa9\x04\x71\x32\x53\x2a\xac\xb9\xb8\x9c\x83\x5c\x1d\x43\x5c\x15\x42\xfd\x3c\x03\x43\x5d\x11\x46\xf8\xf9\x87\xe0\x36\xa6\xa0\x34\x29\x27\x33\x39\x3e\x3b\x15\x64\x4e\x05\x97\x82\x82\xbf\x1f\x4c\x95\x06\x5c\x95\x8e\x02\x42\x99\xa6\x35\x60\x00\xbf\xf2\x66\xc2\xa1\x00\x00\x00")
 
 func _20190315225536_change_stream_unique_indexDownSqlBytes() ([]byte, error) {
 	return bindataRead(
@@ -381,12 +453,12 @@ func _20190315225536_change_stream_unique_indexDownSql() (*asset, error) {
 		return nil, err
 	}
 
-	info := bindataFileInfo{name: "20190315225536_change_stream_unique_index.down.sql", size: 161, mode: os.FileMode(420), modTime: time.Unix(1552691070, 0)}
+	info := bindataFileInfo{name: "20190315225536_change_stream_unique_index.down.sql", size: 161, mode: os.FileMode(420), modTime: time.Unix(1562258594, 0)}
 	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x61, 0xed, 0xd4, 0x8a, 0xa3, 0x5d, 0xcc, 0x12, 0x7f, 0x4, 0xe0, 0x25, 0x98, 0xb, 0x9a, 0x9f, 0x1d, 0xe2, 0xb, 0x43, 0x18, 0x9f, 0x92, 0xc1, 0xb8, 0x2c, 0x34, 0x1d, 0xa7, 0x51, 0xef, 0xb}}
 	return a, nil
 }
 
-var __20190315225536_change_stream_unique_indexUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x72\x09\xf2\x0f\x50\xf0\xf4\x73\x71\x8d\x50\xf0\x74\x53\x70\x8d\xf0\x0c\x0e\x09\x56\x28\x2e\x29\x4a\x4d\xcc\x2d\x8e\x4f\x49\x2d\xcb\x4c\x4e\x8d\xcf\x4c\x89\x2f\x28\x4d\xca\xc9\x4c\x8e\xcf\x4e\xad\x8c\xcf\x4c\xa9\xb0\xe6\xe2\x72\x0e\x72\x75\x0c\x71\x55\x08\xf5\xf3\x0c\x0c\x75\x45\x18\xe0\xe7\x1f\x82\xdb\x90\xe4\xfc\xdc\xdc\xd2\xbc\xcc\x12\x90\x19\x20\x63\xb8\x14\x14\xfc\xfd\x60\xea\x34\xe0\xea\x74\x14\x90\x15\x6a\x5a\x03\x02\x00\x00\xff\xff\x7c\xaf\x0f\xbc\xa3\x00\x00\x00")
+var __20190315225536_change_stream_unique_indexUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x72\x09\xf2\x0f\x50\xf0\xf4\x73\x71\x8d\x50\xf0\x74\x53\x70\x8d\xf0\x0c\x0e\x09\x56\x28\x2e\x29\x4a\x4d\xcc\x2d\x8e\x4f\x49\x2d\xcb\x4c\x4e\x8d\xcf\x4c\x89\x2f\x28\x4d\xca\xc9\x4c\x8e\xcf\x4e\xad\x8c\xcf\x4c\xa9\xb0\xe6\xe2\x72\x0e\x72\x75\x0c\x71\x55\x08\xf5\xf3\x0c\x0c\x75\x45\x18\xe0\xe7\x1f\x82\xdb\x90\xe4\xfc\xdc\xdc\xd2\xbc\xcc\x12\x90\x19\x20\x63\xb8\x14\x14\xfc\xfd\x60\xea\x34\xe0\xea\x74\x14\x90\x15\x6a\x5a\x03\x06\x00\x7c\xaf\x0f\xbc\xa3\x00\x00\x00")
 
 func _20190315225536_change_stream_unique_indexUpSqlBytes() ([]byte, error) {
 	return bindataRead(
@@ -401,12 +473,12 @@ func _20190315225536_change_stream_unique_indexUpSql() (*asset, error) {
 		return nil, err
 	}
 
-	info := bindataFileInfo{name: "20190315225536_change_stream_unique_index.up.sql", size: 163, mode: os.FileMode(420), modTime: time.Unix(1552691070, 0)}
+	info := bindataFileInfo{name: "20190315225536_change_stream_unique_index.up.sql", size: 163, mode: os.FileMode(420), modTime: time.Unix(1562258594, 0)}
 	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xb2, 0x17, 0x4d, 0xb5, 0x68, 0x88, 0x65, 0x74, 0x3f, 0x57, 0xaf, 0xc4, 0x5a, 0x8, 0x2b, 0x43, 0x14, 0xb3, 0xfc, 0x4e, 0x22, 0xc, 0xb9, 0x77, 0x2, 0x2d, 0x54, 0x47, 0xfd, 0x96, 0x3e, 0xe1}}
 	return a, nil
 }
 
-var __20190512204433_add_device_labelDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x72\xf4\x09\x71\x0d\x52\x08\x71\x74\xf2\x71\x55\x48\x49\x2d\xcb\x4c\x4e\x2d\xe6\x52\x50\x70\x09\xf2\x0f\x50\x70\xf6\xf7\x09\xf5\xf5\x83\x8a\xc6\xe7\x24\x26\xa5\xe6\x58\x03\x02\x00\x00\xff\xff\x8c\xd1\x34\xbd\x2f\x00\x00\x00")
+var __20190512204433_add_device_labelDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x2f\x00\xd0\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x64\x65\x76\x69\x63\x65\x73\x0a\x20\x20\x44\x52\x4f\x50\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x64\x65\x76\x69\x63\x65\x5f\x6c\x61\x62\x65\x6c\x3b\x03\x00\x8c\xd1\x34\xbd\x2f\x00\x00\x00")
 
 func _20190512204433_add_device_labelDownSqlBytes() ([]byte, error) {
 	return bindataRead(
@@ -421,12 +493,12 @@ func _20190512204433_add_device_labelDownSql() (*asset, error) {
 		return nil, err
 	}
 
-	info := bindataFileInfo{name: "20190512204433_add_device_label.down.sql", size: 47, mode: os.FileMode(420), modTime: time.Unix(1557703783, 0)}
+	info := bindataFileInfo{name: "20190512204433_add_device_label.down.sql", size: 47, mode: os.FileMode(420), modTime: time.Unix(1562258594, 0)}
 	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x81, 0x81, 0x1e, 0x1e, 0x3, 0xd6, 0x5a, 0xed, 0x46, 0xa4, 0xd, 0xdf, 0x7, 0x1e, 0xd9, 0xf9, 0x34, 0xfb, 0x13, 0x79, 0x53, 0x55, 0x41, 0x6f, 0xdc, 0x7b, 0xd3, 0x8e, 0xe0, 0xc2, 0xc7, 0x53}}
 	return a, nil
 }
 
-var __20190512204433_add_device_labelUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x72\xf4\x09\x71\x0d\x52\x08\x71\x74\xf2\x71\x55\x48\x49\x2d\xcb\x4c\x4e\x2d\xe6\x52\x50\x70\x74\x71\x51\x70\xf6\xf7\x09\xf5\xf5\x83\x0a\xc6\xe7\x24\x26\xa5\xe6\x28\x84\xb8\x46\x84\x28\xf8\xf9\x87\x28\xf8\x85\xfa\xf8\x28\xb8\xb8\xba\x39\x86\xfa\x84\x28\xa8\xab\x5b\x03\x02\x00\x00\xff\xff\x04\xb5\x14\x14\x47\x00\x00\x00")
+var __20190512204433_add_device_labelUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x47\x00\xb8\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x64\x65\x76\x69\x63\x65\x73\x0a\x20\x20\x41\x44\x44\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x64\x65\x76\x69\x63\x65\x5f\x6c\x61\x62\x65\x6c\x20\x54\x45\x58\x54\x20\x4e\x4f\x54\x20\x4e\x55\x4c\x4c\x20\x44\x45\x46\x41\x55\x4c\x54\x20\x27\x27\x3b\x03\x00\x04\xb5\x14\x14\x47\x00\x00\x00")
 
 func _20190512204433_add_device_labelUpSqlBytes() ([]byte, error) {
 	return bindataRead(
@@ -441,11 +513,1451 @@ func _20190512204433_add_device_labelUpSql() (*asset, error) {
 		return nil, err
 	}
 
-	info := bindataFileInfo{name: "20190512204433_add_device_label.up.sql", size: 71, mode: os.FileMode(420), modTime: time.Unix(1557703783, 0)}
+	info := bindataFileInfo{name: "20190512204433_add_device_label.up.sql", size: 71, mode: os.FileMode(420), modTime: time.Unix(1562258594, 0)}
 	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xef, 0x4, 0x75, 0x44, 0x35, 0xa, 0x4b, 0x83, 0x71, 0x8e, 0xc7, 0x72, 0x20, 0x59, 0x4, 0x67, 0x22, 0x44, 0x11, 0xce, 0xf, 0x52, 0xb2, 0x40, 0xf6, 0x93, 0xc6, 0xe, 0x90, 0xdd, 0x9e, 0x5d}}
 	return a, nil
 }
 
+var __20261015100000_add_stream_stats_tableDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x22\x00\xdd\xff\x44\x52\x4f\x50\x20\x54\x41\x42\x4c\x45\x20\x49\x46\x20\x45\x58\x49\x53\x54\x53\x20\x73\x74\x72\x65\x61\x6d\x5f\x73\x74\x61\x74\x73\x3b\x03\x00\xa1\xdd\xd5\x77\x22\x00\x00\x00")
+
+func _20261015100000_add_stream_stats_tableDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261015100000_add_stream_stats_tableDownSql,
+		"20261015100000_add_stream_stats_table.down.sql",
+	)
+}
+
+func _20261015100000_add_stream_stats_tableDownSql() (*asset, error) {
+	bytes, err := _20261015100000_add_stream_stats_tableDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261015100000_add_stream_stats_table.down.sql", size: 34, mode: os.FileMode(420), modTime: time.Unix(1792065246, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x84, 0x8f, 0x5e, 0x9e, 0x98, 0x9a, 0x6c, 0x22, 0x39, 0xd3, 0x58, 0x47, 0x40, 0xff, 0x61, 0x76, 0xe4, 0x34, 0xf9, 0x20, 0x56, 0xd7, 0x4d, 0xa3, 0xcb, 0x46, 0x89, 0xdd, 0xa, 0x26, 0x83, 0xee}}
+	return a, nil
+}
+
+var __20261015100000_add_stream_stats_tableUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x8c\xcf\x31\x6f\x83\x30\x10\x05\xe0\x9d\x5f\x71\x63\x90\x3a\x74\xef\xe4\xc0\xd1\x5a\x05\x13\x81\x51\x9a\x2e\x96\x6b\xae\x91\xa5\x40\x10\x67\x5a\xe5\xdf\x57\xa0\xa6\xed\x44\x3a\xda\xfa\xde\xd3\xbb\xa4\x42\xa1\x11\xb4\xd8\xe6\x08\x32\x03\x55\x6a\xc0\x17\x59\xeb\x1a\x38\x8c\x64\x3b\xc3\xc1\x06\x86\x4d\x04\xd7\x8f\x69\xf2\x2d\x34\x8d\x4c\x17\xac\x9a\x3c\x87\x5d\x25\x0b\x51\x1d\xe0\x19\x0f\x50\x61\x86\x15\xaa\x04\xaf\x0d\xbc\x99\x13\x31\x94\x0a\x52\xcc\x51\x23\x24\xa2\x4e\x44\x8a\x77\x11\x40\x47\xcc\xf6\x48\x6c\x46\x72\xe4\x3f\xa8\x85\xad\x7c\x94\x4a\xff\x76\xa7\x98\x89\x26\xd7\x70\x3f\xf3\xb7\x4b\x20\x36\xd4\xbb\xf1\x32\x84\x5b\xf8\x73\xf4\xb3\xe6\xc9\x39\xa2\xf6\x9f\xfa\xdd\xfa\xd3\x2d\x7a\xb2\x1c\xcc\xf7\x72\x63\x03\x68\x59\x60\xad\x45\xb1\x83\xbd\xd4\x4f\xcb\x13\x5e\x4b\xb5\x1c\x38\x8c\x67\x47\xcc\xbe\x3f\x1a\x77\x9e\xfa\xb0\xde\xfc\x47\x07\xdf\x91\xe9\x79\xdd\x4f\x43\x6b\x03\xb5\x6b\x23\x7e\x02\xaa\xdc\x6f\xe2\x28\x7e\x88\xbe\x06\x00\x6d\x71\x67\xf9\xf4\x01\x00\x00")
+
+func _20261015100000_add_stream_stats_tableUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261015100000_add_stream_stats_tableUpSql,
+		"20261015100000_add_stream_stats_table.up.sql",
+	)
+}
+
+func _20261015100000_add_stream_stats_tableUpSql() (*asset, error) {
+	bytes, err := _20261015100000_add_stream_stats_tableUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261015100000_add_stream_stats_table.up.sql", size: 500, mode: os.FileMode(420), modTime: time.Unix(1792065246, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x5b, 0x73, 0xbe, 0x8a, 0xae, 0xfa, 0x6f, 0x94, 0x96, 0xba, 0x45, 0x59, 0xec, 0x31, 0x2, 0x76, 0x72, 0x41, 0xb3, 0xd, 0xe9, 0xe7, 0x74, 0x14, 0x1c, 0xad, 0x60, 0xea, 0x93, 0xff, 0x15, 0xd8}}
+	return a, nil
+}
+
+var __20261015110000_add_raw_payloads_tableDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x22\x00\xdd\xff\x44\x52\x4f\x50\x20\x54\x41\x42\x4c\x45\x20\x49\x46\x20\x45\x58\x49\x53\x54\x53\x20\x72\x61\x77\x5f\x70\x61\x79\x6c\x6f\x61\x64\x73\x3b\x03\x00\x0d\xda\x91\x89\x22\x00\x00\x00")
+
+func _20261015110000_add_raw_payloads_tableDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261015110000_add_raw_payloads_tableDownSql,
+		"20261015110000_add_raw_payloads_table.down.sql",
+	)
+}
+
+func _20261015110000_add_raw_payloads_tableDownSql() (*asset, error) {
+	bytes, err := _20261015110000_add_raw_payloads_tableDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261015110000_add_raw_payloads_table.down.sql", size: 34, mode: os.FileMode(420), modTime: time.Unix(1792065518, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x68, 0x10, 0x75, 0xd4, 0xe9, 0x7f, 0xa5, 0x0, 0x8d, 0x35, 0xaf, 0x86, 0xea, 0xc6, 0x91, 0xd1, 0xdb, 0x4b, 0x1, 0xd6, 0x29, 0x8b, 0x99, 0x22, 0x59, 0xee, 0x6c, 0xf9, 0xaa, 0x93, 0x15, 0xd8}}
+	return a, nil
+}
+
+var __20261015110000_add_raw_payloads_tableUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x7c\xcf\xc1\x6a\xc3\x30\x10\x04\xd0\xbb\xbe\x62\x8e\x31\xe4\x0f\x72\x92\x9b\x4d\xbb\x54\x96\x83\xb4\x21\x76\x2f\x42\x44\x3a\x88\x96\xa6\xa4\x21\x6d\xff\xbe\x38\x18\x23\x7a\xe8\x51\x68\x66\x78\xfb\xe0\x48\x0b\x41\x74\x6b\x08\xbc\x83\xed\x05\x34\xb0\x17\x8f\x4b\xfc\x0a\x1f\xf1\xe7\xed\x1c\xd3\x27\x56\x0a\x28\x09\x2d\x3f\x7a\x72\xac\x0d\xf6\x8e\x3b\xed\x46\x3c\xd3\xb8\x56\x40\xca\xb7\x72\xca\xe1\x7a\x7e\xcd\xef\x10\x1a\xe4\xbe\x64\x0f\xc6\x4c\xbf\x97\x7c\xca\xe5\x96\x53\x88\x57\x08\x77\xe4\x45\x77\x7b\x1c\x59\x9e\xee\x4f\xbc\xf4\x96\x96\x02\xb6\xb4\xd3\x07\x33\x2d\x1c\x57\xcd\x54\x9f\x15\x68\x47\x21\xbd\xe4\x54\xb3\x51\x6a\xf6\xb3\xdd\xd2\xf0\x8f\x3f\xd4\xbe\x50\x71\x42\x49\xdf\x0a\xe8\xed\x9f\x73\xeb\xfc\xba\xf6\x37\x1b\xf5\x3b\x00\x61\x2b\x68\x0b\x34\x01\x00\x00")
+
+func _20261015110000_add_raw_payloads_tableUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261015110000_add_raw_payloads_tableUpSql,
+		"20261015110000_add_raw_payloads_table.up.sql",
+	)
+}
+
+func _20261015110000_add_raw_payloads_tableUpSql() (*asset, error) {
+	bytes, err := _20261015110000_add_raw_payloads_tableUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261015110000_add_raw_payloads_table.up.sql", size: 308, mode: os.FileMode(420), modTime: time.Unix(1792065518, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xe9, 0x8c, 0xad, 0x30, 0x93, 0xe8, 0xf5, 0x8e, 0x40, 0x5e, 0x65, 0x3d, 0xf0, 0x66, 0xae, 0xbf, 0xe6, 0xd1, 0xd7, 0xca, 0x38, 0xf0, 0xc1, 0x87, 0x28, 0xb9, 0x7b, 0x6c, 0xe8, 0x48, 0x3, 0x9a}}
+	return a, nil
+}
+
+var __20261015120000_add_partition_tablesDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x4c\x00\xb3\xff\x44\x52\x4f\x50\x20\x54\x41\x42\x4c\x45\x20\x49\x46\x20\x45\x58\x49\x53\x54\x53\x20\x64\x65\x76\x69\x63\x65\x5f\x6c\x65\x61\x73\x65\x73\x3b\x0a\x0a\x44\x52\x4f\x50\x20\x54\x41\x42\x4c\x45\x20\x49\x46\x20\x45\x58\x49\x53\x54\x53\x20\x65\x6e\x63\x6f\x64\x65\x72\x5f\x69\x6e\x73\x74\x61\x6e\x63\x65\x73\x3b\x03\x00\xea\x75\x83\x0c\x4c\x00\x00\x00")
+
+func _20261015120000_add_partition_tablesDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261015120000_add_partition_tablesDownSql,
+		"20261015120000_add_partition_tables.down.sql",
+	)
+}
+
+func _20261015120000_add_partition_tablesDownSql() (*asset, error) {
+	bytes, err := _20261015120000_add_partition_tablesDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261015120000_add_partition_tables.down.sql", size: 76, mode: os.FileMode(420), modTime: time.Unix(1792068863, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x50, 0x9, 0x3, 0x76, 0x9d, 0x2d, 0xee, 0x4a, 0xee, 0xd, 0x62, 0xd9, 0xa0, 0xc2, 0xba, 0x3c, 0x75, 0x37, 0x14, 0x3d, 0xc9, 0x6b, 0x5, 0x8f, 0xfc, 0xf9, 0x6a, 0x57, 0x3b, 0xff, 0x24, 0xcc}}
+	return a, nil
+}
+
+var __20261015120000_add_partition_tablesUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\xa4\x90\xb1\x6a\xc3\x30\x18\x84\x77\x3d\xc5\x8d\x36\xe4\x0d\x32\xa9\xf6\xa5\x15\xb5\xe5\x20\xff\xa5\x4e\x17\x61\x2c\x0d\x82\xe2\x96\x28\x94\x3c\x7e\x69\x48\x4c\x0b\xc5\x4b\xc6\x13\x77\xe2\xfb\xfe\xca\x51\x0b\x21\xfa\xa1\x21\xcc\x0e\xb6\x13\x70\x30\xbd\xf4\x88\xf3\xf4\x11\xe2\xd1\xa7\x39\x9f\xc6\x79\x8a\x19\x85\x02\x6e\xc9\xa7\x00\xe1\x20\x97\x85\x7d\x69\x1a\xec\x9d\x69\xb5\x3b\xe0\x99\x87\x8d\x02\xe2\xf9\x33\x1d\x63\xf6\xe3\x09\x62\x5a\xf6\xa2\xdb\x3d\x5e\x8d\x3c\x5d\x22\xde\x3a\xcb\x65\xab\xca\xad\x52\x2b\x28\x21\x7e\xa5\x29\xfa\xf7\x38\xe6\x2b\xc6\xf5\x25\x05\x18\x2b\x7c\xa4\xfb\x97\x03\x8e\x3b\x3a\xda\x8a\xb7\x3f\x72\x91\x42\x89\xce\xa2\x66\x43\x21\x2a\xdd\x57\xba\xe6\x66\xcd\xec\x1e\x1b\x63\x6b\x0e\x6b\x36\xcb\x79\x7d\x0a\x3e\x85\xb3\xc2\x0f\xdd\x9f\x0a\x8a\x5f\x9d\x72\xab\xbe\x07\x00\xa9\xec\x95\x96\xb4\x01\x00\x00")
+
+func _20261015120000_add_partition_tablesUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261015120000_add_partition_tablesUpSql,
+		"20261015120000_add_partition_tables.up.sql",
+	)
+}
+
+func _20261015120000_add_partition_tablesUpSql() (*asset, error) {
+	bytes, err := _20261015120000_add_partition_tablesUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261015120000_add_partition_tables.up.sql", size: 436, mode: os.FileMode(420), modTime: time.Unix(1792068863, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x31, 0x4a, 0xeb, 0x43, 0x8e, 0x3f, 0xd6, 0xac, 0x57, 0x1a, 0x22, 0x1, 0x4a, 0x20, 0xba, 0x2e, 0xba, 0x70, 0x65, 0x9b, 0xf, 0xa7, 0x9f, 0xb5, 0x79, 0x86, 0xda, 0x39, 0xd5, 0xa3, 0xde, 0x6d}}
+	return a, nil
+}
+
+var __20261015130000_add_stream_datastore_addrDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x3a\x00\xc5\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x73\x74\x72\x65\x61\x6d\x73\x20\x44\x52\x4f\x50\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x49\x46\x20\x45\x58\x49\x53\x54\x53\x20\x64\x61\x74\x61\x73\x74\x6f\x72\x65\x5f\x61\x64\x64\x72\x3b\x0a\x03\x00\xf5\x45\x74\x94\x3a\x00\x00\x00")
+
+func _20261015130000_add_stream_datastore_addrDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261015130000_add_stream_datastore_addrDownSql,
+		"20261015130000_add_stream_datastore_addr.down.sql",
+	)
+}
+
+func _20261015130000_add_stream_datastore_addrDownSql() (*asset, error) {
+	bytes, err := _20261015130000_add_stream_datastore_addrDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261015130000_add_stream_datastore_addr.down.sql", size: 58, mode: os.FileMode(420), modTime: time.Unix(1792069894, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xa3, 0xce, 0xae, 0xf7, 0xef, 0x93, 0xa6, 0xd9, 0xb1, 0x12, 0xde, 0xa3, 0x4f, 0x1f, 0xf1, 0x9c, 0x68, 0x41, 0xec, 0xa8, 0x6e, 0x94, 0x8b, 0x98, 0xc5, 0x58, 0x50, 0xff, 0x31, 0x50, 0x54, 0x51}}
+	return a, nil
+}
+
+var __20261015130000_add_stream_datastore_addrUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x56\x00\xa9\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x73\x74\x72\x65\x61\x6d\x73\x20\x41\x44\x44\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x49\x46\x20\x4e\x4f\x54\x20\x45\x58\x49\x53\x54\x53\x20\x64\x61\x74\x61\x73\x74\x6f\x72\x65\x5f\x61\x64\x64\x72\x20\x54\x45\x58\x54\x20\x4e\x4f\x54\x20\x4e\x55\x4c\x4c\x20\x44\x45\x46\x41\x55\x4c\x54\x20\x27\x27\x3b\x0a\x03\x00\x83\x41\x0f\x15\x56\x00\x00\x00")
+
+func _20261015130000_add_stream_datastore_addrUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261015130000_add_stream_datastore_addrUpSql,
+		"20261015130000_add_stream_datastore_addr.up.sql",
+	)
+}
+
+func _20261015130000_add_stream_datastore_addrUpSql() (*asset, error) {
+	bytes, err := _20261015130000_add_stream_datastore_addrUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261015130000_add_stream_datastore_addr.up.sql", size: 86, mode: os.FileMode(420), modTime: time.Unix(1792069894, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xf9, 0x69, 0x6a, 0x0, 0x55, 0x8f, 0x4, 0x55, 0xab, 0x6e, 0x54, 0xf2, 0x89, 0xcd, 0x82, 0x53, 0x55, 0x2, 0x9, 0x6d, 0x4d, 0xca, 0x3e, 0x21, 0x64, 0x58, 0xba, 0xc0, 0xe1, 0xc0, 0xaf, 0xd5}}
+	return a, nil
+}
+
+var __20261015140000_add_downsample_checkpoints_tableDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x2d\x00\xd2\xff\x44\x52\x4f\x50\x20\x54\x41\x42\x4c\x45\x20\x49\x46\x20\x45\x58\x49\x53\x54\x53\x20\x64\x6f\x77\x6e\x73\x61\x6d\x70\x6c\x65\x5f\x63\x68\x65\x63\x6b\x70\x6f\x69\x6e\x74\x73\x3b\x0a\x03\x00\xdc\x73\x85\xdb\x2d\x00\x00\x00")
+
+func _20261015140000_add_downsample_checkpoints_tableDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261015140000_add_downsample_checkpoints_tableDownSql,
+		"20261015140000_add_downsample_checkpoints_table.down.sql",
+	)
+}
+
+func _20261015140000_add_downsample_checkpoints_tableDownSql() (*asset, error) {
+	bytes, err := _20261015140000_add_downsample_checkpoints_tableDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261015140000_add_downsample_checkpoints_table.down.sql", size: 45, mode: os.FileMode(420), modTime: time.Unix(1792070019, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x49, 0xb9, 0x95, 0x8f, 0x45, 0xa8, 0x99, 0x47, 0x16, 0x2e, 0xf2, 0x41, 0xa, 0x4f, 0x2, 0x36, 0x28, 0x1b, 0xe3, 0x6d, 0xb7, 0x13, 0x19, 0x81, 0x2e, 0xb1, 0x26, 0x44, 0x7c, 0x97, 0x3, 0x8}}
+	return a, nil
+}
+
+var __20261015140000_add_downsample_checkpoints_tableUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x84\x8f\x4d\x6e\x83\x30\x10\x85\xf7\x9c\xe2\x2d\x41\xca\x0d\xba\x72\x61\x68\xad\x82\x89\xec\x41\x69\xba\xb1\xac\xd8\x55\x51\x13\x40\xd8\x28\xd7\xaf\x88\xaa\xfe\xac\xb2\x1c\xcd\xf7\xbe\x99\x57\x6a\x12\x4c\x60\xf1\xd8\x10\x64\x0d\xd5\x31\xe8\x55\x1a\x36\xf0\xd3\x75\x8c\xee\x32\x9f\x83\x3d\x7d\x84\xd3\xe7\x3c\x0d\x63\x8a\xc8\x33\x20\xa6\x25\xb8\x8b\x5d\xd7\xc1\xa3\xef\x65\x75\x8b\xa9\xbe\x69\xa0\xa9\x26\x4d\xaa\x24\xf3\x0d\xc5\x7c\xa3\x0a\x74\x0a\x15\x35\xc4\x84\x52\x98\x52\x54\xb4\xdb\x3c\x61\x8c\xd3\x62\x07\x0f\xa9\x98\x9e\x48\xff\x88\xb6\xed\xd9\xc5\x64\xdf\xa7\xe5\xea\x16\x1f\xbc\x75\x09\x2c\x5b\x32\x2c\xda\x3d\x0e\x92\x9f\x6f\x23\xde\x3a\x45\xff\x62\xeb\xec\x5d\xba\xc3\x57\x54\x8b\xbe\x61\xa8\xee\x90\x17\xdb\xad\xbd\x96\xad\xd0\x47\xbc\xd0\x11\xf9\x9f\x7a\xbb\xdf\x1f\x8b\xac\x78\xc8\xbe\x06\x00\x3e\x5b\x60\x09\x30\x01\x00\x00")
+
+func _20261015140000_add_downsample_checkpoints_tableUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261015140000_add_downsample_checkpoints_tableUpSql,
+		"20261015140000_add_downsample_checkpoints_table.up.sql",
+	)
+}
+
+func _20261015140000_add_downsample_checkpoints_tableUpSql() (*asset, error) {
+	bytes, err := _20261015140000_add_downsample_checkpoints_tableUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261015140000_add_downsample_checkpoints_table.up.sql", size: 304, mode: os.FileMode(420), modTime: time.Unix(1792070019, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x8b, 0x58, 0x56, 0x4d, 0x7b, 0x32, 0x76, 0xcd, 0x1, 0xbd, 0x7d, 0x9, 0x35, 0xbf, 0xcf, 0xe5, 0x86, 0x6e, 0x7e, 0x6b, 0x7, 0xb6, 0xa, 0x28, 0xa6, 0x73, 0xa9, 0xbf, 0xa4, 0x71, 0x8b, 0x85}}
+	return a, nil
+}
+
+var __20261015150000_add_stream_conversionsDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x37\x00\xc8\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x73\x74\x72\x65\x61\x6d\x73\x20\x44\x52\x4f\x50\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x49\x46\x20\x45\x58\x49\x53\x54\x53\x20\x63\x6f\x6e\x76\x65\x72\x73\x69\x6f\x6e\x73\x3b\x0a\x03\x00\xd3\x0d\xd3\xc1\x37\x00\x00\x00")
+
+func _20261015150000_add_stream_conversionsDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261015150000_add_stream_conversionsDownSql,
+		"20261015150000_add_stream_conversions.down.sql",
+	)
+}
+
+func _20261015150000_add_stream_conversionsDownSql() (*asset, error) {
+	bytes, err := _20261015150000_add_stream_conversionsDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261015150000_add_stream_conversions.down.sql", size: 55, mode: os.FileMode(420), modTime: time.Unix(1792070501, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x85, 0x55, 0xa1, 0xbe, 0x52, 0xee, 0xc3, 0x8f, 0x7f, 0xd0, 0x93, 0x1f, 0xea, 0x4a, 0x5a, 0xb, 0xc9, 0xa1, 0x49, 0xec, 0x27, 0x61, 0x82, 0x33, 0xe2, 0x11, 0x78, 0x89, 0x87, 0xff, 0xea, 0xa0}}
+	return a, nil
+}
+
+var __20261015150000_add_stream_conversionsUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x56\x00\xa9\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x73\x74\x72\x65\x61\x6d\x73\x20\x41\x44\x44\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x49\x46\x20\x4e\x4f\x54\x20\x45\x58\x49\x53\x54\x53\x20\x63\x6f\x6e\x76\x65\x72\x73\x69\x6f\x6e\x73\x20\x4a\x53\x4f\x4e\x42\x20\x4e\x4f\x54\x20\x4e\x55\x4c\x4c\x20\x44\x45\x46\x41\x55\x4c\x54\x20\x27\x7b\x7d\x27\x3b\x0a\x03\x00\x8c\x80\x51\x95\x56\x00\x00\x00")
+
+func _20261015150000_add_stream_conversionsUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261015150000_add_stream_conversionsUpSql,
+		"20261015150000_add_stream_conversions.up.sql",
+	)
+}
+
+func _20261015150000_add_stream_conversionsUpSql() (*asset, error) {
+	bytes, err := _20261015150000_add_stream_conversionsUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261015150000_add_stream_conversions.up.sql", size: 86, mode: os.FileMode(420), modTime: time.Unix(1792070501, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xac, 0x5a, 0xf9, 0x9c, 0xac, 0x91, 0x2a, 0x81, 0x23, 0x8a, 0x19, 0xc5, 0x93, 0x16, 0x56, 0xdc, 0xb4, 0x45, 0x20, 0xda, 0x15, 0x7c, 0x57, 0x8d, 0xd1, 0x96, 0x77, 0x2c, 0xa5, 0xca, 0x53, 0x99}}
+	return a, nil
+}
+
+var __20261015160000_add_stream_ingest_secretDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x39\x00\xc6\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x73\x74\x72\x65\x61\x6d\x73\x20\x44\x52\x4f\x50\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x49\x46\x20\x45\x58\x49\x53\x54\x53\x20\x69\x6e\x67\x65\x73\x74\x5f\x73\x65\x63\x72\x65\x74\x3b\x0a\x03\x00\x9f\xec\xd9\xdd\x39\x00\x00\x00")
+
+func _20261015160000_add_stream_ingest_secretDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261015160000_add_stream_ingest_secretDownSql,
+		"20261015160000_add_stream_ingest_secret.down.sql",
+	)
+}
+
+func _20261015160000_add_stream_ingest_secretDownSql() (*asset, error) {
+	bytes, err := _20261015160000_add_stream_ingest_secretDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261015160000_add_stream_ingest_secret.down.sql", size: 57, mode: os.FileMode(420), modTime: time.Unix(1792070946, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xe5, 0x18, 0xd7, 0x35, 0xe1, 0xbc, 0x37, 0xb8, 0x9, 0x8f, 0xd1, 0x25, 0x6e, 0xa2, 0x8d, 0x57, 0xa5, 0xfe, 0xb2, 0x46, 0xef, 0xa7, 0xab, 0x81, 0x1, 0x15, 0xda, 0xe7, 0x9b, 0x52, 0x65, 0x15}}
+	return a, nil
+}
+
+var __20261015160000_add_stream_ingest_secretUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x42\x00\xbd\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x73\x74\x72\x65\x61\x6d\x73\x20\x41\x44\x44\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x49\x46\x20\x4e\x4f\x54\x20\x45\x58\x49\x53\x54\x53\x20\x69\x6e\x67\x65\x73\x74\x5f\x73\x65\x63\x72\x65\x74\x20\x42\x59\x54\x45\x41\x3b\x0a\x03\x00\x67\xd7\x3f\xcc\x42\x00\x00\x00")
+
+func _20261015160000_add_stream_ingest_secretUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261015160000_add_stream_ingest_secretUpSql,
+		"20261015160000_add_stream_ingest_secret.up.sql",
+	)
+}
+
+func _20261015160000_add_stream_ingest_secretUpSql() (*asset, error) {
+	bytes, err := _20261015160000_add_stream_ingest_secretUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261015160000_add_stream_ingest_secret.up.sql", size: 66, mode: os.FileMode(420), modTime: time.Unix(1792070946, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xa0, 0xb7, 0x46, 0xde, 0xa2, 0x12, 0x5d, 0x4d, 0xa, 0xd2, 0xc8, 0x66, 0x2, 0x51, 0x66, 0xa1, 0x9e, 0x30, 0x15, 0x62, 0x4d, 0x88, 0x91, 0xc4, 0xc5, 0xde, 0x4b, 0xc1, 0x95, 0x50, 0xf3, 0xcd}}
+	return a, nil
+}
+
+var __20261015170000_add_stream_sourceDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x32\x00\xcd\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x73\x74\x72\x65\x61\x6d\x73\x20\x44\x52\x4f\x50\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x49\x46\x20\x45\x58\x49\x53\x54\x53\x20\x73\x6f\x75\x72\x63\x65\x3b\x0a\x03\x00\xe1\x8c\xab\x1c\x32\x00\x00\x00")
+
+func _20261015170000_add_stream_sourceDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261015170000_add_stream_sourceDownSql,
+		"20261015170000_add_stream_source.down.sql",
+	)
+}
+
+func _20261015170000_add_stream_sourceDownSql() (*asset, error) {
+	bytes, err := _20261015170000_add_stream_sourceDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261015170000_add_stream_source.down.sql", size: 50, mode: os.FileMode(420), modTime: time.Unix(1792071814, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x4, 0x7b, 0xdf, 0x66, 0x93, 0xe4, 0xaf, 0xa, 0x98, 0xd4, 0xd8, 0xe9, 0x16, 0xb5, 0x9d, 0xb3, 0x15, 0xdf, 0x6b, 0xaf, 0x43, 0x1b, 0x4b, 0x56, 0x54, 0x9e, 0x49, 0xe4, 0x7d, 0x62, 0x39, 0xc5}}
+	return a, nil
+}
+
+var __20261015170000_add_stream_sourceUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x4e\x00\xb1\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x73\x74\x72\x65\x61\x6d\x73\x20\x41\x44\x44\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x49\x46\x20\x4e\x4f\x54\x20\x45\x58\x49\x53\x54\x53\x20\x73\x6f\x75\x72\x63\x65\x20\x54\x45\x58\x54\x20\x4e\x4f\x54\x20\x4e\x55\x4c\x4c\x20\x44\x45\x46\x41\x55\x4c\x54\x20\x27\x27\x3b\x0a\x03\x00\x97\x86\x8c\x85\x4e\x00\x00\x00")
+
+func _20261015170000_add_stream_sourceUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261015170000_add_stream_sourceUpSql,
+		"20261015170000_add_stream_source.up.sql",
+	)
+}
+
+func _20261015170000_add_stream_sourceUpSql() (*asset, error) {
+	bytes, err := _20261015170000_add_stream_sourceUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261015170000_add_stream_source.up.sql", size: 78, mode: os.FileMode(420), modTime: time.Unix(1792071814, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x9a, 0xf1, 0x6a, 0xa5, 0xdb, 0x72, 0xe8, 0x88, 0x47, 0x11, 0xbc, 0xd0, 0x7c, 0x29, 0xa1, 0x8e, 0xd0, 0x17, 0xf0, 0xd9, 0xe8, 0x2c, 0x2, 0x3a, 0x27, 0x86, 0x6a, 0x76, 0x35, 0x60, 0x54, 0xab}}
+	return a, nil
+}
+
+var __20261015180000_add_device_status_tableDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x23\x00\xdc\xff\x44\x52\x4f\x50\x20\x54\x41\x42\x4c\x45\x20\x49\x46\x20\x45\x58\x49\x53\x54\x53\x20\x64\x65\x76\x69\x63\x65\x5f\x73\x74\x61\x74\x75\x73\x3b\x03\x00\xdb\xcb\x5d\x22\x23\x00\x00\x00")
+
+func _20261015180000_add_device_status_tableDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261015180000_add_device_status_tableDownSql,
+		"20261015180000_add_device_status_table.down.sql",
+	)
+}
+
+func _20261015180000_add_device_status_tableDownSql() (*asset, error) {
+	bytes, err := _20261015180000_add_device_status_tableDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261015180000_add_device_status_table.down.sql", size: 35, mode: os.FileMode(420), modTime: time.Unix(1792072666, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x73, 0xa, 0xa9, 0xea, 0x10, 0x46, 0x54, 0x7, 0xdf, 0xe9, 0xc7, 0xdd, 0x34, 0x2b, 0x66, 0x4e, 0xd0, 0x16, 0xd8, 0x2e, 0x21, 0xca, 0x98, 0x7c, 0xe6, 0xd3, 0x6b, 0x6e, 0x8d, 0xd7, 0xa6, 0x77}}
+	return a, nil
+}
+
+var __20261015180000_add_device_status_tableUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x84\x8e\xc1\x4e\xf3\x30\x10\x84\xef\x79\x8a\xb9\x35\x91\xfe\x37\xf8\x4f\x6e\xb2\x11\x06\xc7\xae\xec\xad\xda\x72\x89\xac\x66\x85\x10\x90\xa0\xd8\xe1\x82\x78\x77\x44\xa4\x16\x4e\x70\x9c\xd5\xb7\x33\x5f\xed\x49\x31\x81\xd5\xd6\x10\x74\x0b\xeb\x18\x74\xd4\x81\x03\x06\x79\x7b\x3c\x4b\x9f\x72\xcc\x4b\x42\x59\xe0\x72\xc9\xd3\x93\x8c\x60\x3a\xf2\x8a\xdb\xbd\x31\xd8\x79\xdd\x29\x7f\xc2\x1d\x9d\xe0\xa9\x25\x4f\xb6\xa6\x4b\x47\x2a\x7f\x7e\x56\x70\x16\x0d\x19\x62\x42\xad\x42\xad\x1a\xfa\x57\x00\xcf\x31\xe5\x3e\x89\x8c\x7d\xcc\x60\xdd\x51\x60\xd5\xed\x70\xd0\x7c\xb3\x46\xdc\x3b\xbb\x82\x2f\x92\x52\x7c\x90\xfe\x3c\x2d\x63\x4e\xb8\x0d\xce\x6e\xbf\x45\x1a\x6a\xd5\xde\x30\x36\xef\x1f\x9b\x2f\x5a\xe6\x79\x9a\x57\xd9\x6b\xfa\x6b\x60\x79\x1d\x62\x96\xe1\x37\xec\x3a\x63\xdd\xa1\xac\x8a\xea\xff\xe7\x00\xc1\x81\x28\x56\x48\x01\x00\x00")
+
+func _20261015180000_add_device_status_tableUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261015180000_add_device_status_tableUpSql,
+		"20261015180000_add_device_status_table.up.sql",
+	)
+}
+
+func _20261015180000_add_device_status_tableUpSql() (*asset, error) {
+	bytes, err := _20261015180000_add_device_status_tableUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261015180000_add_device_status_table.up.sql", size: 328, mode: os.FileMode(420), modTime: time.Unix(1792072666, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xad, 0xc3, 0x42, 0xb7, 0xd, 0xfb, 0xca, 0x8e, 0x6b, 0x37, 0x59, 0x3e, 0x6d, 0x8b, 0x70, 0x6c, 0xca, 0xea, 0x8c, 0x98, 0x1e, 0x89, 0xb3, 0xe6, 0x79, 0xc, 0x93, 0xa7, 0x8b, 0x70, 0x1f, 0x9b}}
+	return a, nil
+}
+
+var __20261015190000_add_stream_deleted_atDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x84\x8e\x41\x4b\xc4\x30\x10\x46\xef\xf9\x15\xdf\x71\x05\x59\xf0\x5c\x3c\xd4\xee\x2c\x06\xb2\x89\xa6\x29\xee\x2d\x94\x9d\x39\x04\xb6\x0a\x36\x8a\xfe\x7b\xa9\x6e\x6a\x3c\x79\x1c\xf8\xde\x9b\xb7\x23\x43\x81\xb0\xf7\xee\x80\x39\xbf\xca\x38\xcd\x78\xba\x27\x4f\x60\x39\x4b\x16\x8e\x63\x86\xee\x61\x5d\x80\x1d\x8c\x69\x94\xaa\x11\x96\xf7\x74\x92\x19\xac\x70\xc1\x96\x21\x1d\x75\x1f\x7a\x6c\x7a\x32\xd4\x05\xdc\xfc\xd5\x97\x07\xf3\xf6\x87\x8e\x89\x71\x0b\xde\x26\xbe\x5a\xec\xde\x3d\x40\xdb\x1d\x1d\xa1\xf7\xc5\x74\x41\xe3\x0a\xc4\xd3\xcb\x34\xbd\x3d\xa7\xfc\xb9\x1c\x89\x3f\x1a\xa5\x3a\x4f\x6d\x20\x0c\x56\x3f\x0e\xf4\xab\xa8\x82\xfe\xd7\x28\xc0\xd9\xf2\x6e\xb3\xee\xae\x51\x0f\x97\xcc\xd6\x04\xf2\x08\xed\x9d\xa1\x32\xc7\x77\x7a\xe7\xcc\x70\xb0\x55\x3b\xcb\x59\xb2\x70\x1c\x73\xf3\x35\x00\x9f\x34\x2b\x10\x6d\x01\x00\x00")
+
+func _20261015190000_add_stream_deleted_atDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261015190000_add_stream_deleted_atDownSql,
+		"20261015190000_add_stream_deleted_at.down.sql",
+	)
+}
+
+func _20261015190000_add_stream_deleted_atDownSql() (*asset, error) {
+	bytes, err := _20261015190000_add_stream_deleted_atDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261015190000_add_stream_deleted_at.down.sql", size: 365, mode: os.FileMode(420), modTime: time.Unix(1792072836, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x69, 0xc3, 0x23, 0x1d, 0x91, 0xf2, 0xcd, 0x50, 0x14, 0xd, 0x62, 0x1f, 0xc6, 0x7f, 0x8e, 0x4, 0x3d, 0x80, 0xda, 0xc2, 0xa2, 0x62, 0x43, 0xaa, 0xb7, 0xc9, 0xe4, 0xc, 0x83, 0xdc, 0x1d, 0x8f}}
+	return a, nil
+}
+
+var __20261015190000_add_stream_deleted_atUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x84\x8f\xc1\x4a\xc4\x30\x18\x84\xef\x79\x8a\x39\x2a\xf8\x06\x3d\xc5\xcd\x2f\x1b\x48\x93\x35\xf9\x43\x8b\x97\x50\x9a\x1c\x02\x56\xc1\x46\xd1\xb7\x97\x4a\xd5\xde\xf6\x38\x30\xf3\x31\x9f\x34\x4c\x1e\x2c\xef\x0d\x61\x6d\x6f\x65\x5a\x56\x48\xa5\x70\x72\x26\xf6\x16\xfa\x01\xd6\x31\x68\xd4\x81\x03\x72\x79\x2e\xad\xe4\x34\x35\xb0\xee\x29\xb0\xec\x2f\x18\x34\x9f\x7f\x22\x9e\x9c\xa5\x4e\x08\xe5\xdd\x05\xda\x2a\x1a\xb7\xf9\x3e\xdd\xd9\x29\x97\x8f\x3a\x97\x54\x73\x9a\x5f\x97\xe5\xfd\xa5\xb6\xaf\x2d\xd4\xfc\xd9\x09\x71\xf2\x24\x99\x10\xad\x7e\x8c\xf4\x8f\x38\x3c\xb8\x8e\x11\x80\xb3\xbf\x2a\x37\x7f\xbd\x3b\x1c\x8b\xb7\x02\x18\xce\xe4\xe9\xa8\xa4\x03\x6c\x34\xa6\xfb\x1e\x00\xac\x28\x57\xaf\x13\x01\x00\x00")
+
+func _20261015190000_add_stream_deleted_atUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261015190000_add_stream_deleted_atUpSql,
+		"20261015190000_add_stream_deleted_at.up.sql",
+	)
+}
+
+func _20261015190000_add_stream_deleted_atUpSql() (*asset, error) {
+	bytes, err := _20261015190000_add_stream_deleted_atUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261015190000_add_stream_deleted_at.up.sql", size: 275, mode: os.FileMode(420), modTime: time.Unix(1792072836, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xd2, 0x5b, 0x4d, 0x68, 0x4a, 0x18, 0x67, 0xb5, 0xee, 0x8d, 0xf2, 0xb7, 0xcb, 0x56, 0x99, 0x90, 0x56, 0x4, 0x46, 0x65, 0xcb, 0xee, 0x5c, 0x6, 0xed, 0xae, 0xc2, 0x39, 0x80, 0x6c, 0x3e, 0x64}}
+	return a, nil
+}
+
+var __20261015200000_add_audit_log_tableDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x72\x09\xf2\x0f\x50\x08\x09\xf2\x74\x77\x77\x0d\x52\xf0\x74\x53\x70\x8d\xf0\x0c\x0e\x09\x56\x48\x2c\x4d\xc9\x2c\x89\xcf\xc9\x4f\x8f\xcf\xcc\xcd\x2d\x2d\x49\x4c\xca\x49\x55\xf0\xf7\x43\x08\x5b\x73\x71\x81\x75\xba\x85\xfa\x39\x87\x78\xfa\xfb\xe1\xd7\xaa\xa1\x09\x53\x1f\xe2\xe8\xe4\xe3\x8a\x4d\xb1\x35\x17\x60\x00\x00\xe9\x82\xd9\x8a\x00\x00\x00")
+
+func _20261015200000_add_audit_log_tableDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261015200000_add_audit_log_tableDownSql,
+		"20261015200000_add_audit_log_table.down.sql",
+	)
+}
+
+func _20261015200000_add_audit_log_tableDownSql() (*asset, error) {
+	bytes, err := _20261015200000_add_audit_log_tableDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261015200000_add_audit_log_table.down.sql", size: 138, mode: os.FileMode(420), modTime: time.Unix(1792073183, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x94, 0x77, 0x76, 0x83, 0x25, 0x1f, 0x9f, 0x91, 0x87, 0x82, 0xf6, 0x97, 0x3a, 0x7b, 0x62, 0xdb, 0xef, 0x4d, 0x66, 0x88, 0x28, 0x64, 0xed, 0x20, 0x4b, 0x56, 0xb5, 0x4a, 0x98, 0x29, 0xd1, 0xcc}}
+	return a, nil
+}
+
+var __20261015200000_add_audit_log_tableUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x74\x90\x41\x6f\x9c\x30\x14\x84\xef\xfe\x15\x73\x58\x69\x77\xa5\xfc\x83\x3d\x19\x78\x10\xb7\xac\x8d\x8c\xd1\x92\x5e\x10\x8d\xad\x14\xc9\x04\x62\x40\x6a\x55\xf5\xbf\x57\x4b\xd3\x86\x6a\x93\xa3\xfd\xe6\x7d\x33\x6f\x62\x4d\xdc\x10\x0c\x8f\x72\x82\x48\x21\x95\x01\xd5\xa2\x34\x25\xda\xc5\x76\x73\xe3\x87\x27\x1c\x18\xd0\x59\x44\x22\x2b\x49\x0b\x9e\xa3\xd0\xe2\xcc\xf5\x03\x3e\xd3\xc3\x1d\x03\x82\x7b\x1c\x82\x75\xb6\x69\x67\x18\x71\xa6\xd2\xf0\x73\x81\x8b\x30\xf7\xeb\x13\x5f\x94\xa4\x95\x2c\xab\x3c\x47\x42\x29\xaf\x72\x03\xa9\x2e\x87\xe3\x75\xfd\xb1\xf5\xde\x05\x18\xaa\xcd\x3f\xd5\xf5\x3f\xb8\x97\xc5\x4d\x73\xd3\xd9\x75\x76\xfd\xea\xdd\xfc\x6d\xb0\xb7\xd2\x69\x0e\xae\xed\x9b\x65\xd9\x68\xc7\x36\xb4\xfd\x84\x4f\xa5\x92\xd1\xad\xfb\xfe\xe7\xaf\xfd\x95\x18\xdc\xb4\xf8\xf9\x96\xe8\x42\x18\xfe\x64\x62\xc7\x13\x63\xaf\x3d\x09\x99\x50\xfd\x51\x4f\xcd\x26\x45\xd3\xd9\xef\x0c\x50\x72\x5b\xe3\x66\x7e\x87\xce\x6e\xb8\x4a\x43\x53\x91\xf3\x98\x90\x56\x32\x36\x62\xbb\xd8\x74\x7d\xbf\xcc\xed\x57\xef\x0e\x47\x68\x32\x95\x96\x25\x8c\x16\x59\x46\x1a\xbc\xc4\x6e\xc7\x22\xca\x84\x64\x80\xe6\xa2\x24\x50\x1d\x53\xb1\x32\xf6\x6f\xee\xdd\x84\x76\x1c\xdd\xb3\xc5\xf0\xec\x7f\xec\x4f\x8c\x64\x72\x62\xbb\x1d\x72\x2e\xb3\x8a\x67\x84\xd1\x8f\x4f\xd3\x8b\x7f\x4b\xf5\xd7\xe3\x9d\x24\x0c\x88\x28\x55\x9a\x50\x15\xc9\xeb\x05\x09\xe5\x64\xe8\xbf\x9b\x19\x90\x2a\x0d\xe2\xf1\x3d\xb4\xba\x80\x6a\x8a\x2b\x43\x28\xb4\x8a\x29\xa9\x34\xbd\xc7\x3e\x1c\x4f\xec\xf7\x00\x9c\xcf\x83\x50\x98\x02\x00\x00")
+
+func _20261015200000_add_audit_log_tableUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261015200000_add_audit_log_tableUpSql,
+		"20261015200000_add_audit_log_table.up.sql",
+	)
+}
+
+func _20261015200000_add_audit_log_tableUpSql() (*asset, error) {
+	bytes, err := _20261015200000_add_audit_log_tableUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261015200000_add_audit_log_table.up.sql", size: 664, mode: os.FileMode(420), modTime: time.Unix(1792073183, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xbf, 0x86, 0x1f, 0xd6, 0xe6, 0xcc, 0xaa, 0x41, 0x23, 0xd9, 0x4c, 0xcf, 0xc5, 0xd, 0x43, 0x42, 0x19, 0x5, 0x1d, 0x20, 0x94, 0xc4, 0xc0, 0x4e, 0xb3, 0x6b, 0x71, 0x28, 0x43, 0xd8, 0x20, 0x94}}
+	return a, nil
+}
+
+var __20261015210000_add_stream_compressionDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x36\x00\xc9\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x73\x74\x72\x65\x61\x6d\x73\x20\x44\x52\x4f\x50\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x49\x46\x20\x45\x58\x49\x53\x54\x53\x20\x63\x6f\x6d\x70\x72\x65\x73\x73\x69\x6f\x6e\x3b\x03\x00\x3d\x86\xc5\x00\x36\x00\x00\x00")
+
+func _20261015210000_add_stream_compressionDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261015210000_add_stream_compressionDownSql,
+		"20261015210000_add_stream_compression.down.sql",
+	)
+}
+
+func _20261015210000_add_stream_compressionDownSql() (*asset, error) {
+	bytes, err := _20261015210000_add_stream_compressionDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261015210000_add_stream_compression.down.sql", size: 54, mode: os.FileMode(420), modTime: time.Unix(1792073552, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xba, 0x68, 0xde, 0x5e, 0xc5, 0xcb, 0x69, 0xd3, 0x31, 0x7a, 0xee, 0x7c, 0x89, 0x29, 0x79, 0x3d, 0xe7, 0x9e, 0x93, 0xea, 0x0, 0xe4, 0xb, 0x83, 0xc3, 0xd5, 0x5c, 0x57, 0xe8, 0x8d, 0x6, 0x3a}}
+	return a, nil
+}
+
+var __20261015210000_add_stream_compressionUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x52\x00\xad\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x73\x74\x72\x65\x61\x6d\x73\x20\x41\x44\x44\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x49\x46\x20\x4e\x4f\x54\x20\x45\x58\x49\x53\x54\x53\x20\x63\x6f\x6d\x70\x72\x65\x73\x73\x69\x6f\x6e\x20\x54\x45\x58\x54\x20\x4e\x4f\x54\x20\x4e\x55\x4c\x4c\x20\x44\x45\x46\x41\x55\x4c\x54\x20\x27\x27\x3b\x03\x00\xe0\xe1\x9d\x9c\x52\x00\x00\x00")
+
+func _20261015210000_add_stream_compressionUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261015210000_add_stream_compressionUpSql,
+		"20261015210000_add_stream_compression.up.sql",
+	)
+}
+
+func _20261015210000_add_stream_compressionUpSql() (*asset, error) {
+	bytes, err := _20261015210000_add_stream_compressionUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261015210000_add_stream_compression.up.sql", size: 82, mode: os.FileMode(420), modTime: time.Unix(1792073552, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x4f, 0xa6, 0x83, 0xba, 0x5f, 0x55, 0x9d, 0x15, 0x3a, 0x14, 0x94, 0xff, 0x7a, 0x25, 0x5e, 0xb7, 0xe4, 0x3f, 0xb2, 0x80, 0x60, 0x7c, 0x4c, 0x4f, 0x62, 0xd8, 0x6, 0x44, 0x5a, 0x55, 0x76, 0x6}}
+	return a, nil
+}
+
+var __20261015220000_add_message_keys_tableDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x23\x00\xdc\xff\x44\x52\x4f\x50\x20\x54\x41\x42\x4c\x45\x20\x49\x46\x20\x45\x58\x49\x53\x54\x53\x20\x6d\x65\x73\x73\x61\x67\x65\x5f\x6b\x65\x79\x73\x3b\x0a\x03\x00\xf4\x4c\x7b\xf6\x23\x00\x00\x00")
+
+func _20261015220000_add_message_keys_tableDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261015220000_add_message_keys_tableDownSql,
+		"20261015220000_add_message_keys_table.down.sql",
+	)
+}
+
+func _20261015220000_add_message_keys_tableDownSql() (*asset, error) {
+	bytes, err := _20261015220000_add_message_keys_tableDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261015220000_add_message_keys_table.down.sql", size: 35, mode: os.FileMode(420), modTime: time.Unix(1792075569, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xa2, 0x9b, 0xbb, 0x89, 0x8c, 0x72, 0x4, 0xec, 0x9c, 0x62, 0x13, 0x8d, 0x39, 0xc9, 0xcd, 0xcc, 0xe0, 0x8a, 0xf3, 0x6b, 0x4a, 0x98, 0xa6, 0x9b, 0xf3, 0xd5, 0x58, 0x72, 0xd1, 0xae, 0xed, 0xcb}}
+	return a, nil
+}
+
+var __20261015220000_add_message_keys_tableUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x7c\xce\xb1\xaa\xc2\x40\x10\x85\xe1\x7e\x9e\xe2\x94\x37\x70\xdf\x20\xd5\xaa\x23\x0e\x26\x9b\x90\x8c\xb8\xb1\x59\x16\x1c\x44\x82\x69\x36\x85\x79\x7b\x21\xa4\xb2\xb0\x3c\xcd\xff\x9d\x7d\xc7\x4e\x19\xea\x76\x15\x43\x8e\xf0\x8d\x82\x83\xf4\xda\xe3\x65\x39\xa7\x87\xc5\xd1\x96\x8c\x3f\x02\x46\x5b\xa0\x1c\x14\x6d\x27\xb5\xeb\x06\x9c\x79\xf8\x27\x20\x9b\x4d\x31\xcd\x50\xa9\xb9\x57\x57\xb7\xb8\x8a\x9e\xd6\x89\x5b\xe3\x79\x8d\xfa\x4b\x55\x51\x51\x12\x6d\xa2\xf8\x03\x87\x1f\x62\xdc\xaa\xf1\x79\x7f\xa3\xf1\x5f\x6f\xb2\xd9\x14\xd3\x5c\x94\xf4\x19\x00\x9a\x26\x86\x3d\xc1\x00\x00\x00")
+
+func _20261015220000_add_message_keys_tableUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261015220000_add_message_keys_tableUpSql,
+		"20261015220000_add_message_keys_table.up.sql",
+	)
+}
+
+func _20261015220000_add_message_keys_tableUpSql() (*asset, error) {
+	bytes, err := _20261015220000_add_message_keys_tableUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261015220000_add_message_keys_table.up.sql", size: 193, mode: os.FileMode(420), modTime: time.Unix(1792075569, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xb8, 0x15, 0xa2, 0x19, 0xe0, 0xc4, 0x8, 0x9d, 0x71, 0xb7, 0x30, 0xa4, 0xc1, 0x46, 0x59, 0x78, 0x8a, 0xed, 0x67, 0x56, 0x50, 0x40, 0x38, 0xeb, 0x42, 0xab, 0xd2, 0x5e, 0x51, 0xc3, 0xcd, 0xc1}}
+	return a, nil
+}
+
+var __20261015230000_add_streams_device_id_indexDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x2c\x00\xd3\xff\x44\x52\x4f\x50\x20\x49\x4e\x44\x45\x58\x20\x49\x46\x20\x45\x58\x49\x53\x54\x53\x20\x73\x74\x72\x65\x61\x6d\x73\x5f\x64\x65\x76\x69\x63\x65\x5f\x69\x64\x5f\x69\x64\x78\x3b\x0a\x03\x00\x92\x53\x6e\xf3\x2c\x00\x00\x00")
+
+func _20261015230000_add_streams_device_id_indexDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261015230000_add_streams_device_id_indexDownSql,
+		"20261015230000_add_streams_device_id_index.down.sql",
+	)
+}
+
+func _20261015230000_add_streams_device_id_indexDownSql() (*asset, error) {
+	bytes, err := _20261015230000_add_streams_device_id_indexDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261015230000_add_streams_device_id_index.down.sql", size: 44, mode: os.FileMode(420), modTime: time.Unix(1792075712, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x79, 0x3, 0xb1, 0x40, 0x0, 0xa9, 0x92, 0xf8, 0xd3, 0x62, 0x8c, 0xb1, 0x14, 0xa8, 0x8e, 0x29, 0x5a, 0x14, 0x2e, 0x23, 0xce, 0xa, 0xa9, 0x60, 0xfb, 0xb6, 0x50, 0x25, 0x64, 0xa5, 0x3b, 0xa0}}
+	return a, nil
+}
+
+var __20261015230000_add_streams_device_id_indexUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x4b\x00\xb4\xff\x43\x52\x45\x41\x54\x45\x20\x49\x4e\x44\x45\x58\x20\x49\x46\x20\x4e\x4f\x54\x20\x45\x58\x49\x53\x54\x53\x20\x73\x74\x72\x65\x61\x6d\x73\x5f\x64\x65\x76\x69\x63\x65\x5f\x69\x64\x5f\x69\x64\x78\x0a\x20\x20\x4f\x4e\x20\x73\x74\x72\x65\x61\x6d\x73\x20\x28\x64\x65\x76\x69\x63\x65\x5f\x69\x64\x29\x3b\x0a\x03\x00\x23\x2c\x99\xdc\x4b\x00\x00\x00")
+
+func _20261015230000_add_streams_device_id_indexUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261015230000_add_streams_device_id_indexUpSql,
+		"20261015230000_add_streams_device_id_index.up.sql",
+	)
+}
+
+func _20261015230000_add_streams_device_id_indexUpSql() (*asset, error) {
+	bytes, err := _20261015230000_add_streams_device_id_indexUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261015230000_add_streams_device_id_index.up.sql", size: 75, mode: os.FileMode(420), modTime: time.Unix(1792075712, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xaf, 0x25, 0x46, 0xc7, 0x9b, 0x54, 0x65, 0xf3, 0x71, 0xff, 0x9f, 0x10, 0xe7, 0x8f, 0x4a, 0xb, 0xa, 0xc2, 0x60, 0x90, 0x4f, 0x26, 0x16, 0x4d, 0x54, 0x3e, 0xf2, 0xc, 0xd4, 0xdd, 0x9e, 0x11}}
+	return a, nil
+}
+
+var __20261016000000_add_stream_timestamp_policyDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x3b\x00\xc4\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x73\x74\x72\x65\x61\x6d\x73\x20\x44\x52\x4f\x50\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x49\x46\x20\x45\x58\x49\x53\x54\x53\x20\x74\x69\x6d\x65\x73\x74\x61\x6d\x70\x5f\x70\x6f\x6c\x69\x63\x79\x3b\x03\x00\xf0\x2a\x8b\xf9\x3b\x00\x00\x00")
+
+func _20261016000000_add_stream_timestamp_policyDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261016000000_add_stream_timestamp_policyDownSql,
+		"20261016000000_add_stream_timestamp_policy.down.sql",
+	)
+}
+
+func _20261016000000_add_stream_timestamp_policyDownSql() (*asset, error) {
+	bytes, err := _20261016000000_add_stream_timestamp_policyDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261016000000_add_stream_timestamp_policy.down.sql", size: 59, mode: os.FileMode(420), modTime: time.Unix(1792079184, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xf9, 0x83, 0x6b, 0x8b, 0xa1, 0x7a, 0x6e, 0xd7, 0xc, 0x6b, 0x9, 0x80, 0xb7, 0xb2, 0x3, 0x30, 0x17, 0xfd, 0xfc, 0x8e, 0xae, 0xaa, 0x30, 0xda, 0xa5, 0xa2, 0x5a, 0x42, 0x97, 0x3f, 0xe8, 0xe8}}
+	return a, nil
+}
+
+var __20261016000000_add_stream_timestamp_policyUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x57\x00\xa8\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x73\x74\x72\x65\x61\x6d\x73\x20\x41\x44\x44\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x49\x46\x20\x4e\x4f\x54\x20\x45\x58\x49\x53\x54\x53\x20\x74\x69\x6d\x65\x73\x74\x61\x6d\x70\x5f\x70\x6f\x6c\x69\x63\x79\x20\x54\x45\x58\x54\x20\x4e\x4f\x54\x20\x4e\x55\x4c\x4c\x20\x44\x45\x46\x41\x55\x4c\x54\x20\x27\x27\x3b\x03\x00\xc4\x28\xdd\x84\x57\x00\x00\x00")
+
+func _20261016000000_add_stream_timestamp_policyUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261016000000_add_stream_timestamp_policyUpSql,
+		"20261016000000_add_stream_timestamp_policy.up.sql",
+	)
+}
+
+func _20261016000000_add_stream_timestamp_policyUpSql() (*asset, error) {
+	bytes, err := _20261016000000_add_stream_timestamp_policyUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261016000000_add_stream_timestamp_policy.up.sql", size: 87, mode: os.FileMode(420), modTime: time.Unix(1792079184, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xaf, 0x3e, 0xf4, 0x36, 0x79, 0xdf, 0xe6, 0x60, 0x40, 0x77, 0x89, 0x2, 0x88, 0x37, 0x0, 0x81, 0xc9, 0x67, 0xda, 0xdb, 0xf3, 0xfc, 0x57, 0xb6, 0x8e, 0x1e, 0x42, 0x80, 0xd, 0x81, 0x1f, 0x75}}
+	return a, nil
+}
+
+var __20261017000000_add_stream_policy_idDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x35\x00\xca\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x73\x74\x72\x65\x61\x6d\x73\x20\x44\x52\x4f\x50\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x49\x46\x20\x45\x58\x49\x53\x54\x53\x20\x70\x6f\x6c\x69\x63\x79\x5f\x69\x64\x3b\x0a\x03\x00\xb2\x5f\x8f\x9b\x35\x00\x00\x00")
+
+func _20261017000000_add_stream_policy_idDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261017000000_add_stream_policy_idDownSql,
+		"20261017000000_add_stream_policy_id.down.sql",
+	)
+}
+
+func _20261017000000_add_stream_policy_idDownSql() (*asset, error) {
+	bytes, err := _20261017000000_add_stream_policy_idDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261017000000_add_stream_policy_id.down.sql", size: 53, mode: os.FileMode(420), modTime: time.Unix(1792081978, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x3a, 0xd, 0xf1, 0x71, 0x8, 0x84, 0xa6, 0x47, 0x92, 0x87, 0x42, 0x1c, 0xeb, 0x35, 0x62, 0x0, 0xe0, 0x1f, 0xb0, 0xbd, 0xe5, 0x75, 0xbc, 0x29, 0xb9, 0x5d, 0xd0, 0x19, 0x77, 0x91, 0xc2, 0x1}}
+	return a, nil
+}
+
+var __20261017000000_add_stream_policy_idUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x51\x00\xae\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x73\x74\x72\x65\x61\x6d\x73\x20\x41\x44\x44\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x49\x46\x20\x4e\x4f\x54\x20\x45\x58\x49\x53\x54\x53\x20\x70\x6f\x6c\x69\x63\x79\x5f\x69\x64\x20\x54\x45\x58\x54\x20\x4e\x4f\x54\x20\x4e\x55\x4c\x4c\x20\x44\x45\x46\x41\x55\x4c\x54\x20\x27\x27\x3b\x0a\x03\x00\xcb\x1f\xdb\xbd\x51\x00\x00\x00")
+
+func _20261017000000_add_stream_policy_idUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261017000000_add_stream_policy_idUpSql,
+		"20261017000000_add_stream_policy_id.up.sql",
+	)
+}
+
+func _20261017000000_add_stream_policy_idUpSql() (*asset, error) {
+	bytes, err := _20261017000000_add_stream_policy_idUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261017000000_add_stream_policy_id.up.sql", size: 81, mode: os.FileMode(420), modTime: time.Unix(1792081978, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xba, 0x17, 0x0, 0x68, 0x5a, 0x55, 0x51, 0x17, 0x2f, 0x77, 0xa5, 0xdb, 0x7b, 0x86, 0xb4, 0xd4, 0xbf, 0x87, 0x19, 0x76, 0x57, 0x2, 0x3c, 0x98, 0x7e, 0x2d, 0xf2, 0x48, 0xeb, 0xba, 0x4a, 0x5b}}
+	return a, nil
+}
+
+var __20261018000000_add_stream_labelsDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x32\x00\xcd\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x73\x74\x72\x65\x61\x6d\x73\x20\x44\x52\x4f\x50\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x49\x46\x20\x45\x58\x49\x53\x54\x53\x20\x6c\x61\x62\x65\x6c\x73\x3b\x0a\x03\x00\x1e\x62\xd4\x65\x32\x00\x00\x00")
+
+func _20261018000000_add_stream_labelsDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261018000000_add_stream_labelsDownSql,
+		"20261018000000_add_stream_labels.down.sql",
+	)
+}
+
+func _20261018000000_add_stream_labelsDownSql() (*asset, error) {
+	bytes, err := _20261018000000_add_stream_labelsDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261018000000_add_stream_labels.down.sql", size: 50, mode: os.FileMode(420), modTime: time.Unix(1792082826, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x7f, 0xaa, 0x2c, 0xae, 0xfe, 0x41, 0xe8, 0x1c, 0xc1, 0xd5, 0x9a, 0xda, 0xad, 0xdf, 0xe4, 0x67, 0x34, 0xdd, 0x8b, 0xb4, 0x9e, 0xc5, 0x14, 0x58, 0x2e, 0xa6, 0x49, 0xb1, 0xf5, 0xf1, 0x99, 0xfd}}
+	return a, nil
+}
+
+var __20261018000000_add_stream_labelsUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x51\x00\xae\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x73\x74\x72\x65\x61\x6d\x73\x20\x41\x44\x44\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x49\x46\x20\x4e\x4f\x54\x20\x45\x58\x49\x53\x54\x53\x20\x6c\x61\x62\x65\x6c\x73\x20\x4a\x53\x4f\x4e\x42\x20\x4e\x4f\x54\x20\x4e\x55\x4c\x4c\x20\x44\x45\x46\x41\x55\x4c\x54\x20\x27\x7b\x7d\x27\x3b\x0a\x03\x00\x86\xfe\x81\x16\x51\x00\x00\x00")
+
+func _20261018000000_add_stream_labelsUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261018000000_add_stream_labelsUpSql,
+		"20261018000000_add_stream_labels.up.sql",
+	)
+}
+
+func _20261018000000_add_stream_labelsUpSql() (*asset, error) {
+	bytes, err := _20261018000000_add_stream_labelsUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261018000000_add_stream_labels.up.sql", size: 81, mode: os.FileMode(420), modTime: time.Unix(1792082826, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x11, 0xff, 0xac, 0x45, 0xdc, 0x6a, 0xee, 0x27, 0xcf, 0xff, 0xd, 0xa1, 0xc6, 0xb5, 0x2b, 0x90, 0xd9, 0x99, 0x33, 0xd1, 0xc2, 0x14, 0x77, 0xfd, 0x52, 0xfd, 0x8b, 0x3, 0x4, 0x5e, 0xc2, 0x6b}}
+	return a, nil
+}
+
+var __20261019000000_add_stream_datastore_timeoutDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x3d\x00\xc2\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x73\x74\x72\x65\x61\x6d\x73\x20\x44\x52\x4f\x50\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x49\x46\x20\x45\x58\x49\x53\x54\x53\x20\x64\x61\x74\x61\x73\x74\x6f\x72\x65\x5f\x74\x69\x6d\x65\x6f\x75\x74\x3b\x0a\x03\x00\xac\x1c\x61\x11\x3d\x00\x00\x00")
+
+func _20261019000000_add_stream_datastore_timeoutDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261019000000_add_stream_datastore_timeoutDownSql,
+		"20261019000000_add_stream_datastore_timeout.down.sql",
+	)
+}
+
+func _20261019000000_add_stream_datastore_timeoutDownSql() (*asset, error) {
+	bytes, err := _20261019000000_add_stream_datastore_timeoutDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261019000000_add_stream_datastore_timeout.down.sql", size: 61, mode: os.FileMode(420), modTime: time.Unix(1792083006, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xfe, 0xb8, 0x74, 0x76, 0xca, 0xd4, 0xc5, 0x39, 0xb7, 0xf1, 0x5c, 0xd2, 0xbe, 0x11, 0xbb, 0x13, 0x67, 0x3a, 0x1f, 0x83, 0x5, 0xb7, 0x19, 0xea, 0xed, 0xd, 0xbc, 0xd6, 0x86, 0xe, 0x97, 0x61}}
+	return a, nil
+}
+
+var __20261019000000_add_stream_datastore_timeoutUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x59\x00\xa6\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x73\x74\x72\x65\x61\x6d\x73\x20\x41\x44\x44\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x49\x46\x20\x4e\x4f\x54\x20\x45\x58\x49\x53\x54\x53\x20\x64\x61\x74\x61\x73\x74\x6f\x72\x65\x5f\x74\x69\x6d\x65\x6f\x75\x74\x20\x54\x45\x58\x54\x20\x4e\x4f\x54\x20\x4e\x55\x4c\x4c\x20\x44\x45\x46\x41\x55\x4c\x54\x20\x27\x27\x3b\x0a\x03\x00\x0e\xa7\x58\xb3\x59\x00\x00\x00")
+
+func _20261019000000_add_stream_datastore_timeoutUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261019000000_add_stream_datastore_timeoutUpSql,
+		"20261019000000_add_stream_datastore_timeout.up.sql",
+	)
+}
+
+func _20261019000000_add_stream_datastore_timeoutUpSql() (*asset, error) {
+	bytes, err := _20261019000000_add_stream_datastore_timeoutUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261019000000_add_stream_datastore_timeout.up.sql", size: 89, mode: os.FileMode(420), modTime: time.Unix(1792083006, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xd9, 0x6a, 0x3e, 0x62, 0x6d, 0x18, 0x68, 0x2f, 0x43, 0xe5, 0x2a, 0x3c, 0xb8, 0x86, 0xeb, 0x88, 0x24, 0xa9, 0xd5, 0x43, 0xa5, 0xf5, 0x6b, 0x7, 0xb7, 0xd, 0x32, 0x21, 0x6d, 0xab, 0x94, 0x70}}
+	return a, nil
+}
+
+var __20261020000000_create_payload_schemasDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x61\x00\x9e\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x73\x74\x72\x65\x61\x6d\x73\x20\x44\x52\x4f\x50\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x49\x46\x20\x45\x58\x49\x53\x54\x53\x20\x70\x61\x79\x6c\x6f\x61\x64\x5f\x73\x63\x68\x65\x6d\x61\x3b\x0a\x0a\x44\x52\x4f\x50\x20\x54\x41\x42\x4c\x45\x20\x49\x46\x20\x45\x58\x49\x53\x54\x53\x20\x70\x61\x79\x6c\x6f\x61\x64\x5f\x73\x63\x68\x65\x6d\x61\x73\x3b\x0a\x03\x00\x17\xbf\xff\x52\x61\x00\x00\x00")
+
+func _20261020000000_create_payload_schemasDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261020000000_create_payload_schemasDownSql,
+		"20261020000000_create_payload_schemas.down.sql",
+	)
+}
+
+func _20261020000000_create_payload_schemasDownSql() (*asset, error) {
+	bytes, err := _20261020000000_create_payload_schemasDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261020000000_create_payload_schemas.down.sql", size: 97, mode: os.FileMode(420), modTime: time.Unix(1792083619, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xcb, 0x79, 0x24, 0xc0, 0xce, 0x30, 0x39, 0x71, 0x7e, 0xf, 0x41, 0x0, 0xc2, 0xab, 0x69, 0x91, 0x12, 0x32, 0x12, 0xa9, 0x83, 0x28, 0x14, 0x49, 0x5b, 0xa5, 0x89, 0x31, 0x1d, 0x7e, 0x3f, 0xba}}
+	return a, nil
+}
+
+var __20261020000000_create_payload_schemasUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x7c\x8f\xcd\x6a\xc3\x30\x10\x84\xef\x7a\x8a\xb9\xd9\x86\xbc\x41\x4e\x4a\xbc\x69\xd5\xca\x52\x90\xd6\x24\x69\x29\x41\xd8\x82\x84\x26\x4a\xb1\x4c\xa1\x6f\x5f\x1c\xfa\x43\x28\xf4\xb8\xcc\xce\xf0\x7d\x4b\x47\x92\x09\x2c\x17\x9a\xa0\x56\x30\x96\x41\x5b\xe5\xd9\xe3\x2d\x7c\x9c\x2e\xa1\xdf\xe7\xee\x10\xcf\x21\xa3\x14\xc0\xeb\x31\xf5\x60\xda\xf2\xf5\xd1\xb4\x5a\xcf\x04\xf0\x1e\x87\x7c\xbc\x24\x28\xc3\x74\x47\xee\x26\xeb\x0e\x21\xa5\x78\xca\x78\xf0\xd6\x2c\x7e\x22\xd4\xb4\x92\xad\x66\x14\xcf\x2f\xc5\xb4\xd1\x0d\x31\x8c\xb1\xdf\x87\x11\xac\x1a\xf2\x2c\x9b\x35\x36\x8a\xef\xaf\x27\x9e\xac\xa1\xbf\x65\x63\x37\x65\x35\xb5\xd7\x4e\x35\xd2\xed\xf0\x48\x3b\x94\x13\xe4\xec\x1b\xaa\x12\xd5\x5c\x08\xa9\x99\xdc\x97\x65\x1e\x87\x18\xce\x19\xb2\xae\xb1\xb4\xba\x6d\xcc\xbf\xe2\xb7\xba\xbf\xdc\xc5\x5c\x7c\x0e\x00\x77\x85\x93\x3f\x3d\x01\x00\x00")
+
+func _20261020000000_create_payload_schemasUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261020000000_create_payload_schemasUpSql,
+		"20261020000000_create_payload_schemas.up.sql",
+	)
+}
+
+func _20261020000000_create_payload_schemasUpSql() (*asset, error) {
+	bytes, err := _20261020000000_create_payload_schemasUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261020000000_create_payload_schemas.up.sql", size: 317, mode: os.FileMode(420), modTime: time.Unix(1792083619, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xa5, 0x43, 0x45, 0xf4, 0x5f, 0xe5, 0x3f, 0xb9, 0xcb, 0xc6, 0x6e, 0x96, 0xaf, 0x85, 0x49, 0x97, 0x40, 0x54, 0x79, 0x57, 0xe0, 0xd, 0x14, 0x68, 0xc6, 0xc0, 0x45, 0xc9, 0xb4, 0x4, 0x36, 0x4c}}
+	return a, nil
+}
+
+var __20261021000000_add_stream_dispositionsDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x38\x00\xc7\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x73\x74\x72\x65\x61\x6d\x73\x20\x44\x52\x4f\x50\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x49\x46\x20\x45\x58\x49\x53\x54\x53\x20\x64\x69\x73\x70\x6f\x73\x69\x74\x69\x6f\x6e\x73\x3b\x0a\x03\x00\xfe\xcf\x53\x88\x38\x00\x00\x00")
+
+func _20261021000000_add_stream_dispositionsDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261021000000_add_stream_dispositionsDownSql,
+		"20261021000000_add_stream_dispositions.down.sql",
+	)
+}
+
+func _20261021000000_add_stream_dispositionsDownSql() (*asset, error) {
+	bytes, err := _20261021000000_add_stream_dispositionsDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261021000000_add_stream_dispositions.down.sql", size: 56, mode: os.FileMode(420), modTime: time.Unix(1792083965, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x4b, 0x4b, 0x9f, 0x58, 0xe3, 0x63, 0xdc, 0x4a, 0x2f, 0xa4, 0x27, 0xb6, 0x98, 0x48, 0x53, 0x23, 0x10, 0x3c, 0x25, 0x8a, 0xbf, 0x6e, 0x4d, 0xfd, 0xe5, 0xa9, 0x17, 0x1c, 0x99, 0x17, 0x10, 0x36}}
+	return a, nil
+}
+
+var __20261021000000_add_stream_dispositionsUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x57\x00\xa8\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x73\x74\x72\x65\x61\x6d\x73\x20\x41\x44\x44\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x49\x46\x20\x4e\x4f\x54\x20\x45\x58\x49\x53\x54\x53\x20\x64\x69\x73\x70\x6f\x73\x69\x74\x69\x6f\x6e\x73\x20\x4a\x53\x4f\x4e\x42\x20\x4e\x4f\x54\x20\x4e\x55\x4c\x4c\x20\x44\x45\x46\x41\x55\x4c\x54\x20\x27\x7b\x7d\x27\x3b\x0a\x03\x00\x68\x8d\x67\x1e\x57\x00\x00\x00")
+
+func _20261021000000_add_stream_dispositionsUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261021000000_add_stream_dispositionsUpSql,
+		"20261021000000_add_stream_dispositions.up.sql",
+	)
+}
+
+func _20261021000000_add_stream_dispositionsUpSql() (*asset, error) {
+	bytes, err := _20261021000000_add_stream_dispositionsUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261021000000_add_stream_dispositions.up.sql", size: 87, mode: os.FileMode(420), modTime: time.Unix(1792083965, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xe3, 0x33, 0x5f, 0xfd, 0xea, 0xe1, 0x64, 0x8f, 0x5b, 0xd7, 0xd2, 0xdb, 0xa, 0x5c, 0xe1, 0x65, 0xf6, 0x76, 0xb0, 0x89, 0x35, 0x1e, 0x58, 0xd1, 0x4c, 0x2, 0xa5, 0x8c, 0x15, 0x97, 0x8d, 0xdb}}
+	return a, nil
+}
+
+var __20261022000000_add_stream_privacyDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x5b\x00\xa4\xff\x44\x52\x4f\x50\x20\x54\x41\x42\x4c\x45\x20\x49\x46\x20\x45\x58\x49\x53\x54\x53\x20\x70\x72\x69\x76\x61\x63\x79\x5f\x6c\x65\x64\x67\x65\x72\x3b\x0a\x0a\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x73\x74\x72\x65\x61\x6d\x73\x0a\x20\x20\x44\x52\x4f\x50\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x49\x46\x20\x45\x58\x49\x53\x54\x53\x20\x70\x72\x69\x76\x61\x63\x79\x3b\x0a\x03\x00\x64\x30\xd9\x3c\x5b\x00\x00\x00")
+
+func _20261022000000_add_stream_privacyDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261022000000_add_stream_privacyDownSql,
+		"20261022000000_add_stream_privacy.down.sql",
+	)
+}
+
+func _20261022000000_add_stream_privacyDownSql() (*asset, error) {
+	bytes, err := _20261022000000_add_stream_privacyDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261022000000_add_stream_privacy.down.sql", size: 91, mode: os.FileMode(420), modTime: time.Unix(1792084389, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xaa, 0x6f, 0x8c, 0xea, 0x60, 0x40, 0xf5, 0x25, 0x61, 0x21, 0xd3, 0x40, 0x35, 0x28, 0x89, 0xd, 0xdb, 0xd5, 0x1f, 0x39, 0x3c, 0x37, 0xed, 0x0, 0xa1, 0x64, 0xc, 0xec, 0xf5, 0xf5, 0xf6, 0x44}}
+	return a, nil
+}
+
+var __20261022000000_add_stream_privacyUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x7c\x8f\xcb\x6a\xc3\x30\x14\x44\xf7\xfa\x8a\xd9\xc5\x86\xfc\x41\x56\xaa\x74\x43\x45\x65\xc9\x48\x57\x24\xe9\xc6\x98\x5a\x14\x43\x1f\xc6\x8f\x42\xff\xbe\x38\x24\x6d\x03\xa5\x4b\x89\x99\x73\xe7\x48\xcb\x14\xc0\xf2\xce\x12\xa6\x79\xcc\xed\xeb\x24\x00\xa9\x35\x94\xb7\xa9\x72\x30\x7b\x38\xcf\xa0\xa3\x89\x1c\x31\x8c\xfd\x47\xfb\xf4\x09\xa6\x23\x9f\xff\x5d\xb2\x16\x9a\xf6\x32\x59\xc6\x66\xb3\x13\x42\x05\x92\x4c\x17\xe4\x9f\xed\xe6\x25\x77\xcf\x79\x44\x21\x70\xb9\xd9\x2c\x4b\xdf\x21\x25\xa3\x7f\xa0\x81\xf6\x14\xc8\x29\x8a\xd7\x61\xc5\x9a\x2a\xe1\x1d\x34\x59\x62\x82\x92\x51\x49\x4d\x5b\x01\x0c\x79\xec\xdf\xbb\x66\x9a\xdb\x71\x06\x9b\x8a\x22\xcb\xaa\xc6\xc1\xf0\xfd\xf9\x89\x47\xef\xe8\x1b\xbe\x36\xa6\x21\xbf\xcd\xd0\x3e\xad\xea\x75\x20\x65\xa2\xf1\xee\x26\xb2\x0c\x5d\x3b\xe7\xae\x69\xff\x41\x5e\xdd\x9d\x3f\x14\xe5\xca\xad\x83\xa9\x64\x38\xe1\x81\x4e\x28\x7e\xe9\x6d\x6f\x36\x96\xa2\xdc\x89\xaf\x01\x00\x23\x6b\xe3\x81\x7d\x01\x00\x00")
+
+func _20261022000000_add_stream_privacyUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261022000000_add_stream_privacyUpSql,
+		"20261022000000_add_stream_privacy.up.sql",
+	)
+}
+
+func _20261022000000_add_stream_privacyUpSql() (*asset, error) {
+	bytes, err := _20261022000000_add_stream_privacyUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261022000000_add_stream_privacy.up.sql", size: 381, mode: os.FileMode(420), modTime: time.Unix(1792084389, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x26, 0x46, 0x6f, 0x2a, 0x95, 0xf8, 0xc3, 0xe0, 0x5d, 0xc1, 0xf7, 0x26, 0x8f, 0xeb, 0xf9, 0x5b, 0x5e, 0x8, 0xaa, 0x9, 0x48, 0xfd, 0xf9, 0xe3, 0xdb, 0x33, 0x47, 0xd1, 0xd, 0x4e, 0xa6, 0xfa}}
+	return a, nil
+}
+
+var __20261023000000_add_stream_geo_privacyDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x37\x00\xc8\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x73\x74\x72\x65\x61\x6d\x73\x20\x44\x52\x4f\x50\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x49\x46\x20\x45\x58\x49\x53\x54\x53\x20\x67\x65\x6f\x5f\x70\x72\x69\x76\x61\x63\x79\x3b\x0a\x03\x00\x64\xef\x2f\x0e\x37\x00\x00\x00")
+
+func _20261023000000_add_stream_geo_privacyDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261023000000_add_stream_geo_privacyDownSql,
+		"20261023000000_add_stream_geo_privacy.down.sql",
+	)
+}
+
+func _20261023000000_add_stream_geo_privacyDownSql() (*asset, error) {
+	bytes, err := _20261023000000_add_stream_geo_privacyDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261023000000_add_stream_geo_privacy.down.sql", size: 55, mode: os.FileMode(420), modTime: time.Unix(1792084691, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x17, 0xfd, 0xd8, 0x99, 0x2d, 0x67, 0x38, 0x66, 0x14, 0xf1, 0x56, 0x6a, 0xb, 0xe6, 0x53, 0xb6, 0x29, 0x55, 0x6b, 0x1a, 0x13, 0x8c, 0xdb, 0x66, 0x50, 0x61, 0x26, 0x51, 0x6f, 0x36, 0x4b, 0x22}}
+	return a, nil
+}
+
+var __20261023000000_add_stream_geo_privacyUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x53\x00\xac\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x73\x74\x72\x65\x61\x6d\x73\x20\x41\x44\x44\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x49\x46\x20\x4e\x4f\x54\x20\x45\x58\x49\x53\x54\x53\x20\x67\x65\x6f\x5f\x70\x72\x69\x76\x61\x63\x79\x20\x54\x45\x58\x54\x20\x4e\x4f\x54\x20\x4e\x55\x4c\x4c\x20\x44\x45\x46\x41\x55\x4c\x54\x20\x27\x27\x3b\x0a\x03\x00\xc8\x3e\xde\x55\x53\x00\x00\x00")
+
+func _20261023000000_add_stream_geo_privacyUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261023000000_add_stream_geo_privacyUpSql,
+		"20261023000000_add_stream_geo_privacy.up.sql",
+	)
+}
+
+func _20261023000000_add_stream_geo_privacyUpSql() (*asset, error) {
+	bytes, err := _20261023000000_add_stream_geo_privacyUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261023000000_add_stream_geo_privacy.up.sql", size: 83, mode: os.FileMode(420), modTime: time.Unix(1792084691, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xf7, 0xa1, 0x89, 0x3d, 0xba, 0xa7, 0xd0, 0x5b, 0x32, 0xdc, 0xf1, 0xe0, 0x92, 0x1c, 0xd6, 0x4a, 0xed, 0x94, 0x99, 0x1a, 0xba, 0x2d, 0x52, 0x57, 0x8a, 0x9f, 0x94, 0x8d, 0xa9, 0x77, 0xd2, 0x79}}
+	return a, nil
+}
+
+var __20261024000000_notify_stream_changesDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x72\x09\xf2\x0f\x50\x08\x09\xf2\x74\x77\x77\x0d\x52\xf0\x74\x53\x70\x8d\xf0\x0c\x0e\x09\x56\x48\x49\x2d\xcb\x4c\x4e\x2d\x8e\xcf\xcb\x2f\xc9\x4c\xab\x8c\x4f\xce\x48\xcc\x4b\x4f\x55\xf0\xf7\x83\x49\x58\x73\x71\xe1\xd0\x59\x5c\x52\x94\x9a\x98\x8b\x45\x27\x54\x02\xa6\xd3\x2d\xd4\xcf\x39\xc4\xd3\xdf\x0f\x49\x2b\x54\x0b\x44\x61\x7c\x72\x46\x62\x5e\x7a\xaa\x86\xa6\x35\x17\x60\x00\x94\x8f\xbe\x79\xa4\x00\x00\x00")
+
+func _20261024000000_notify_stream_changesDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261024000000_notify_stream_changesDownSql,
+		"20261024000000_notify_stream_changes.down.sql",
+	)
+}
+
+func _20261024000000_notify_stream_changesDownSql() (*asset, error) {
+	bytes, err := _20261024000000_notify_stream_changesDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261024000000_notify_stream_changes.down.sql", size: 164, mode: os.FileMode(420), modTime: time.Unix(1792086115, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x36, 0x14, 0xa4, 0xac, 0x78, 0x15, 0xba, 0xbe, 0x14, 0x1a, 0x5, 0xa9, 0x5c, 0xa1, 0x42, 0x6c, 0xdc, 0x21, 0xfa, 0x62, 0xfc, 0x99, 0x8a, 0x1c, 0x5c, 0xcf, 0xe0, 0x7, 0x36, 0x79, 0x37, 0xef}}
+	return a, nil
+}
+
+var __20261024000000_notify_stream_changesUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\xac\x92\xd1\x6e\xa2\x40\x14\x86\xef\xe7\x29\xfe\x0b\x12\x34\xd9\xf8\x00\xb2\xbb\xc9\xc8\x1c\x90\x04\x67\xc8\x61\x88\xde\x11\xa3\xac\x6b\xe2\xba\xb6\x90\xa6\x7d\xfb\x66\x0a\x12\x8c\xda\xb4\x4d\xef\x80\xc3\xf9\xbf\xef\xcc\x9c\x90\x49\x5a\x82\x61\x30\x65\xa9\x0c\x09\x51\xa1\x43\x9b\x18\x8d\xe3\xff\x66\xff\xe7\xa5\xac\x9b\xc7\x6a\xfd\xaf\xdc\xfc\x5d\x1f\x77\xd5\x68\x0c\x26\x5b\xb0\xce\x61\x39\x89\x63\x62\xc8\x1c\x9e\x27\x66\x14\x27\x5a\x00\x49\x04\x1b\x97\x56\xce\x52\x2a\xb5\x5c\x10\x7e\xc1\xdf\x56\x4f\xfb\x4d\x55\xfb\xb0\x73\x72\xff\x00\x19\x71\x64\x78\x81\xd3\xae\x6c\x29\x23\xff\x02\x53\xfb\x3f\xa0\x69\x39\xd9\x6f\xa7\xd3\xa6\x7a\x6e\xc6\xc1\x5b\x5b\x8b\x76\x15\xf7\x4e\x5a\x21\x89\x02\xd1\x53\x4d\xe6\x68\x8a\x52\xb2\xf4\x49\x98\x49\xd5\xa4\xd5\x2c\x6f\x33\x4d\xaa\xde\x61\x16\x99\x92\x8e\x29\xb5\xba\x8c\xc2\xcf\xdf\x4e\x77\xf0\xe1\x5b\xb4\x06\x1a\x1f\x89\xb9\x30\x18\xc4\x0c\xcf\x93\xb4\x0a\x84\xe7\x21\x95\x3a\x2e\x64\x4c\x38\x1d\x4e\xbb\xfa\xe1\x10\x08\xa1\xd8\x64\xfd\x75\x27\x11\x68\x95\xe4\x36\x47\x6b\x5b\x77\xd8\x0e\x07\xa3\xcf\x85\x40\x88\x6e\xbb\xce\xbd\x37\x3b\x04\x20\x23\xeb\x92\x75\x4e\x6c\xdd\x2a\xb6\xc7\xe9\x9e\xda\xcb\x1c\x84\x0a\x20\x32\x0c\x92\xe1\x1c\x6c\x96\xa0\x15\x85\x85\x25\x64\x6c\x42\x52\x05\xd3\x9d\xb5\xbd\x3f\x46\xb7\x9d\xd7\x63\x74\x85\xeb\x31\x6e\x76\xf4\x63\x9c\xe5\xfb\x80\xaf\x2b\xbf\x0e\x00\x69\xa5\x5a\xf5\x9d\x03\x00\x00")
+
+func _20261024000000_notify_stream_changesUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261024000000_notify_stream_changesUpSql,
+		"20261024000000_notify_stream_changes.up.sql",
+	)
+}
+
+func _20261024000000_notify_stream_changesUpSql() (*asset, error) {
+	bytes, err := _20261024000000_notify_stream_changesUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261024000000_notify_stream_changes.up.sql", size: 925, mode: os.FileMode(420), modTime: time.Unix(1792086115, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x55, 0xe4, 0x5c, 0x1a, 0x94, 0x1, 0xe4, 0x28, 0x83, 0xa9, 0x9b, 0xf, 0x2a, 0x42, 0x67, 0x22, 0xcf, 0x4e, 0x1f, 0x19, 0xbd, 0xc8, 0xef, 0x57, 0x26, 0x69, 0x7e, 0xa1, 0x56, 0xbf, 0xf9, 0x83}}
+	return a, nil
+}
+
+var __20261025000000_add_outboxDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x1d\x00\xe2\xff\x44\x52\x4f\x50\x20\x54\x41\x42\x4c\x45\x20\x49\x46\x20\x45\x58\x49\x53\x54\x53\x20\x6f\x75\x74\x62\x6f\x78\x3b\x0a\x03\x00\x1b\xd5\x27\x88\x1d\x00\x00\x00")
+
+func _20261025000000_add_outboxDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261025000000_add_outboxDownSql,
+		"20261025000000_add_outbox.down.sql",
+	)
+}
+
+func _20261025000000_add_outboxDownSql() (*asset, error) {
+	bytes, err := _20261025000000_add_outboxDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261025000000_add_outbox.down.sql", size: 29, mode: os.FileMode(420), modTime: time.Unix(1792086530, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xe9, 0x16, 0x6d, 0x87, 0x3d, 0x83, 0xe6, 0x7a, 0x5d, 0x80, 0x16, 0x57, 0x84, 0x8a, 0xa1, 0x15, 0x64, 0x15, 0xca, 0x96, 0x70, 0x96, 0x33, 0x2a, 0x27, 0x8d, 0x59, 0xf2, 0x68, 0xf6, 0xa3, 0xd1}}
+	return a, nil
+}
+
+var __20261025000000_add_outboxUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x9c\x91\xcf\x6e\xf2\x30\x10\xc4\xef\x7e\x8a\xb9\x01\x12\x87\xef\xce\xc9\x7c\x2c\x60\xd5\x38\x28\x31\x02\x7a\xb1\x52\xbc\xaa\xac\x36\x09\x0d\x0e\xa2\x6f\x5f\x05\x21\x04\x85\xfe\x51\x8f\xab\xf9\xed\xcc\x48\xf3\x3f\x25\x69\x09\x56\x0e\x35\x41\x8d\x61\x12\x0b\x5a\xa9\xcc\x66\xa8\x9a\xf8\x54\x1d\xd0\x15\x40\xf0\x18\xaa\x49\x46\xa9\x92\x1a\xf3\x54\xcd\x64\xba\xc6\x03\xad\xfb\x02\xd8\xc5\x9a\xf3\xc2\x35\xc1\xc3\xd2\xca\x1e\x1d\xcc\x42\xeb\x56\xdb\x54\x45\xd1\x94\x21\xbe\xbb\x7b\xaa\xe7\x7d\xd8\xb0\x8b\xd5\x0b\x97\x77\xd4\x3c\xe6\xbb\x58\xd5\xec\x72\xef\xeb\x6b\x1d\x23\x1a\xcb\x85\xb6\xe8\x74\xae\xd1\x18\x0a\xae\x9a\xf8\x23\x8d\xe1\xda\x92\x3c\x13\xad\x09\x97\x6f\x0d\x37\xec\x5d\x1e\x61\xd5\x8c\x32\x2b\x67\x73\x2c\x95\x9d\x1e\x4f\x3c\x26\x86\x6e\x2d\x4d\xb2\xec\xf6\xda\xf7\x3c\x46\x2e\xb6\x71\x07\x65\x2c\x4d\x28\xbd\x45\xff\xb5\x58\xc9\x87\xe8\x4e\xec\x1f\x93\x3c\xbf\x86\x3d\xd7\xdf\x37\x15\xbd\x81\x10\xa7\x71\x95\x19\xd1\xea\xee\xb8\x6e\xcb\xa5\x0f\xe5\xb3\x0b\xfe\x20\x80\xc4\x9c\x47\xff\xd4\xb3\x8f\xe0\x7b\x58\x4e\x29\xa5\xeb\x7c\x95\x1d\x5b\xfe\x2a\xec\xf2\xf1\x36\xf1\x52\xfd\x32\x2a\xb1\x30\x0b\xad\x07\xe2\x63\x00\x60\xb2\x1f\xdc\xb8\x02\x00\x00")
+
+func _20261025000000_add_outboxUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261025000000_add_outboxUpSql,
+		"20261025000000_add_outbox.up.sql",
+	)
+}
+
+func _20261025000000_add_outboxUpSql() (*asset, error) {
+	bytes, err := _20261025000000_add_outboxUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261025000000_add_outbox.up.sql", size: 696, mode: os.FileMode(420), modTime: time.Unix(1792086530, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x47, 0x42, 0x56, 0x39, 0xe, 0xbf, 0x8c, 0x89, 0x88, 0x9d, 0x5b, 0xaa, 0x17, 0xa3, 0x19, 0x64, 0x8c, 0x4b, 0xc5, 0x2a, 0x40, 0x5d, 0x91, 0xe7, 0xa3, 0x73, 0xb0, 0xd, 0x70, 0x80, 0xbb, 0x17}}
+	return a, nil
+}
+
+var __20261026000000_add_stream_rate_limitDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x36\x00\xc9\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x73\x74\x72\x65\x61\x6d\x73\x20\x44\x52\x4f\x50\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x49\x46\x20\x45\x58\x49\x53\x54\x53\x20\x72\x61\x74\x65\x5f\x6c\x69\x6d\x69\x74\x3b\x0a\x03\x00\x44\x7e\xf5\xf4\x36\x00\x00\x00")
+
+func _20261026000000_add_stream_rate_limitDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261026000000_add_stream_rate_limitDownSql,
+		"20261026000000_add_stream_rate_limit.down.sql",
+	)
+}
+
+func _20261026000000_add_stream_rate_limitDownSql() (*asset, error) {
+	bytes, err := _20261026000000_add_stream_rate_limitDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261026000000_add_stream_rate_limit.down.sql", size: 54, mode: os.FileMode(420), modTime: time.Unix(1792087039, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xe2, 0x74, 0xda, 0xc, 0xbe, 0xa4, 0x69, 0x1, 0xb6, 0x72, 0xf7, 0xcc, 0xdc, 0x5, 0xc1, 0x17, 0xa9, 0x5e, 0x87, 0x8a, 0xfc, 0x69, 0x92, 0xab, 0xa2, 0x3f, 0x61, 0x6d, 0xc9, 0x9b, 0x9, 0xe2}}
+	return a, nil
+}
+
+var __20261026000000_add_stream_rate_limitUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x52\x00\xad\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x73\x74\x72\x65\x61\x6d\x73\x20\x41\x44\x44\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x49\x46\x20\x4e\x4f\x54\x20\x45\x58\x49\x53\x54\x53\x20\x72\x61\x74\x65\x5f\x6c\x69\x6d\x69\x74\x20\x54\x45\x58\x54\x20\x4e\x4f\x54\x20\x4e\x55\x4c\x4c\x20\x44\x45\x46\x41\x55\x4c\x54\x20\x27\x27\x3b\x0a\x03\x00\x68\x75\xa9\x8c\x52\x00\x00\x00")
+
+func _20261026000000_add_stream_rate_limitUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261026000000_add_stream_rate_limitUpSql,
+		"20261026000000_add_stream_rate_limit.up.sql",
+	)
+}
+
+func _20261026000000_add_stream_rate_limitUpSql() (*asset, error) {
+	bytes, err := _20261026000000_add_stream_rate_limitUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261026000000_add_stream_rate_limit.up.sql", size: 82, mode: os.FileMode(420), modTime: time.Unix(1792087039, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xef, 0x5, 0x74, 0xe6, 0x7f, 0x15, 0xb9, 0x24, 0xcf, 0x24, 0xc7, 0x8b, 0x1, 0x6d, 0x48, 0x3a, 0x44, 0xa7, 0x37, 0x26, 0x75, 0x67, 0xc5, 0xaa, 0x31, 0xb4, 0x6a, 0x2e, 0x38, 0xca, 0xe, 0xfa}}
+	return a, nil
+}
+
+var __20261026100000_add_stream_stats_throttled_droppedDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x72\xf4\x09\x71\x0d\x52\x08\x71\x74\xf2\x71\x55\x28\x2e\x29\x4a\x4d\xcc\x8d\x2f\x2e\x49\x2c\x29\x56\x70\x09\xf2\x0f\x50\x70\xf6\xf7\x09\xf5\xf5\x53\xf0\x74\x53\x70\x8d\xf0\x0c\x0e\x09\x56\xc8\x4d\x2d\x2e\x4e\x4c\x4f\x2d\x8e\x4f\x29\xca\x2f\x28\x48\x4d\xb1\xe6\x22\xd7\x80\x92\x8c\xa2\xfc\x92\x92\x9c\xd4\x14\x6b\x2e\xc0\x00\xc5\x94\x40\x15\x84\x00\x00\x00")
+
+func _20261026100000_add_stream_stats_throttled_droppedDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261026100000_add_stream_stats_throttled_droppedDownSql,
+		"20261026100000_add_stream_stats_throttled_dropped.down.sql",
+	)
+}
+
+func _20261026100000_add_stream_stats_throttled_droppedDownSql() (*asset, error) {
+	bytes, err := _20261026100000_add_stream_stats_throttled_droppedDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261026100000_add_stream_stats_throttled_dropped.down.sql", size: 132, mode: os.FileMode(420), modTime: time.Unix(1792087039, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x28, 0x67, 0xf9, 0x3, 0xe9, 0x4b, 0x25, 0xfd, 0x4a, 0x93, 0x9f, 0xab, 0x67, 0x4, 0x3b, 0x5a, 0xc1, 0x87, 0x28, 0x2e, 0x2d, 0x34, 0xef, 0x23, 0xa3, 0x6d, 0x5d, 0x9, 0xd2, 0xd7, 0x5e, 0x58}}
+	return a, nil
+}
+
+var __20261026100000_add_stream_stats_throttled_droppedUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\xa4\xcc\xb1\x0a\xc2\x30\x10\x06\xe0\xdd\xa7\xf8\x1f\xc1\xdd\x29\x35\xa9\x04\xce\x2b\xd8\x0b\xb8\x95\x40\x0f\x1d\x2c\x0d\xb9\x7b\x7f\x04\x1f\xc0\xa5\xfb\xc7\x17\x48\xd2\x03\x12\x06\x4a\x30\xef\x5a\xb7\xc5\xbc\xba\x21\xc4\x88\xeb\x44\xe5\xce\xc8\x23\x78\x12\xa4\x67\x9e\x65\xc6\xa6\x66\xf5\xa5\xb6\xf8\xbb\xef\xee\x1f\x5d\x31\xe4\x5b\x66\xf9\x21\x2e\x44\x88\x69\x0c\x85\x04\xe7\xcb\xe9\xc0\xbf\xf6\xbd\xb5\xff\xfb\x77\x00\x55\x14\x74\x85\xbe\x00\x00\x00")
+
+func _20261026100000_add_stream_stats_throttled_droppedUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261026100000_add_stream_stats_throttled_droppedUpSql,
+		"20261026100000_add_stream_stats_throttled_dropped.up.sql",
+	)
+}
+
+func _20261026100000_add_stream_stats_throttled_droppedUpSql() (*asset, error) {
+	bytes, err := _20261026100000_add_stream_stats_throttled_droppedUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261026100000_add_stream_stats_throttled_dropped.up.sql", size: 190, mode: os.FileMode(420), modTime: time.Unix(1792087039, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xeb, 0xb6, 0x43, 0xf, 0xce, 0xf1, 0x4, 0x61, 0xc5, 0xd3, 0xe0, 0xe9, 0x74, 0x25, 0xd4, 0x9a, 0xe8, 0x28, 0x41, 0xf7, 0x28, 0xba, 0xff, 0x1d, 0xb2, 0x93, 0xb1, 0x42, 0x5c, 0x9e, 0x43, 0xe2}}
+	return a, nil
+}
+
+var __20261027000000_add_stream_samplingDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x34\x00\xcb\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x73\x74\x72\x65\x61\x6d\x73\x20\x44\x52\x4f\x50\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x49\x46\x20\x45\x58\x49\x53\x54\x53\x20\x73\x61\x6d\x70\x6c\x69\x6e\x67\x3b\x0a\x03\x00\xc6\x1d\xac\x46\x34\x00\x00\x00")
+
+func _20261027000000_add_stream_samplingDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261027000000_add_stream_samplingDownSql,
+		"20261027000000_add_stream_sampling.down.sql",
+	)
+}
+
+func _20261027000000_add_stream_samplingDownSql() (*asset, error) {
+	bytes, err := _20261027000000_add_stream_samplingDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261027000000_add_stream_sampling.down.sql", size: 52, mode: os.FileMode(420), modTime: time.Unix(1792087277, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x20, 0xe, 0x84, 0x9b, 0xc6, 0xcf, 0xff, 0x6, 0x3a, 0x80, 0x2a, 0xa5, 0x4c, 0xbf, 0x62, 0x32, 0x1e, 0x8a, 0x7, 0xfd, 0xbb, 0xa6, 0xb8, 0x6b, 0xfa, 0x36, 0x5e, 0x7f, 0x5f, 0x5e, 0xae, 0x46}}
+	return a, nil
+}
+
+var __20261027000000_add_stream_samplingUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x50\x00\xaf\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x73\x74\x72\x65\x61\x6d\x73\x20\x41\x44\x44\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x49\x46\x20\x4e\x4f\x54\x20\x45\x58\x49\x53\x54\x53\x20\x73\x61\x6d\x70\x6c\x69\x6e\x67\x20\x54\x45\x58\x54\x20\x4e\x4f\x54\x20\x4e\x55\x4c\x4c\x20\x44\x45\x46\x41\x55\x4c\x54\x20\x27\x27\x3b\x0a\x03\x00\x05\xd8\x95\xcc\x50\x00\x00\x00")
+
+func _20261027000000_add_stream_samplingUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261027000000_add_stream_samplingUpSql,
+		"20261027000000_add_stream_sampling.up.sql",
+	)
+}
+
+func _20261027000000_add_stream_samplingUpSql() (*asset, error) {
+	bytes, err := _20261027000000_add_stream_samplingUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261027000000_add_stream_sampling.up.sql", size: 80, mode: os.FileMode(420), modTime: time.Unix(1792087277, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xc, 0x19, 0xa7, 0x8b, 0x4e, 0xc0, 0x42, 0x53, 0x8d, 0x9e, 0xf6, 0x34, 0x37, 0xeb, 0xa, 0xd5, 0xfe, 0x3c, 0x9c, 0x69, 0xdb, 0xcd, 0x66, 0x99, 0x43, 0x57, 0x57, 0xd5, 0xf0, 0x7a, 0xa4, 0xe6}}
+	return a, nil
+}
+
+var __20261028000000_add_stream_scheduleDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x34\x00\xcb\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x73\x74\x72\x65\x61\x6d\x73\x20\x44\x52\x4f\x50\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x49\x46\x20\x45\x58\x49\x53\x54\x53\x20\x73\x63\x68\x65\x64\x75\x6c\x65\x3b\x0a\x03\x00\x46\x6c\x92\xf5\x34\x00\x00\x00")
+
+func _20261028000000_add_stream_scheduleDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261028000000_add_stream_scheduleDownSql,
+		"20261028000000_add_stream_schedule.down.sql",
+	)
+}
+
+func _20261028000000_add_stream_scheduleDownSql() (*asset, error) {
+	bytes, err := _20261028000000_add_stream_scheduleDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261028000000_add_stream_schedule.down.sql", size: 52, mode: os.FileMode(420), modTime: time.Unix(1792087837, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xc8, 0xe6, 0xb9, 0xfc, 0x39, 0xcf, 0x5c, 0xe8, 0x78, 0xa8, 0x17, 0x31, 0x2c, 0xcc, 0xa3, 0x24, 0x24, 0xa7, 0x77, 0xda, 0xc4, 0x72, 0xb8, 0x9b, 0x95, 0x71, 0x9, 0xf5, 0xf5, 0x22, 0x14, 0x6e}}
+	return a, nil
+}
+
+var __20261028000000_add_stream_scheduleUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x50\x00\xaf\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x73\x74\x72\x65\x61\x6d\x73\x20\x41\x44\x44\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x49\x46\x20\x4e\x4f\x54\x20\x45\x58\x49\x53\x54\x53\x20\x73\x63\x68\x65\x64\x75\x6c\x65\x20\x54\x45\x58\x54\x20\x4e\x4f\x54\x20\x4e\x55\x4c\x4c\x20\x44\x45\x46\x41\x55\x4c\x54\x20\x27\x27\x3b\x0a\x03\x00\xe6\x78\xe2\xfe\x50\x00\x00\x00")
+
+func _20261028000000_add_stream_scheduleUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261028000000_add_stream_scheduleUpSql,
+		"20261028000000_add_stream_schedule.up.sql",
+	)
+}
+
+func _20261028000000_add_stream_scheduleUpSql() (*asset, error) {
+	bytes, err := _20261028000000_add_stream_scheduleUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261028000000_add_stream_schedule.up.sql", size: 80, mode: os.FileMode(420), modTime: time.Unix(1792087837, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xfc, 0xaa, 0x4f, 0x69, 0xe0, 0x7f, 0xa4, 0xcb, 0x57, 0xc7, 0xed, 0xdc, 0x26, 0xdd, 0x45, 0x27, 0x59, 0xba, 0xce, 0xe7, 0x7f, 0x10, 0xa4, 0xa1, 0xa1, 0xa6, 0xc2, 0x8a, 0x8, 0xa5, 0xed, 0x4}}
+	return a, nil
+}
+
+var __20261028100000_add_outbox_deliver_afterDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x38\x00\xc7\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x6f\x75\x74\x62\x6f\x78\x20\x44\x52\x4f\x50\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x49\x46\x20\x45\x58\x49\x53\x54\x53\x20\x64\x65\x6c\x69\x76\x65\x72\x5f\x61\x66\x74\x65\x72\x3b\x0a\x03\x00\x5f\x12\x30\x75\x38\x00\x00\x00")
+
+func _20261028100000_add_outbox_deliver_afterDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261028100000_add_outbox_deliver_afterDownSql,
+		"20261028100000_add_outbox_deliver_after.down.sql",
+	)
+}
+
+func _20261028100000_add_outbox_deliver_afterDownSql() (*asset, error) {
+	bytes, err := _20261028100000_add_outbox_deliver_afterDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261028100000_add_outbox_deliver_after.down.sql", size: 56, mode: os.FileMode(420), modTime: time.Unix(1792087837, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x51, 0x2a, 0x72, 0x78, 0x24, 0xf3, 0x9d, 0xd2, 0xc2, 0xe7, 0x9c, 0xab, 0x10, 0x94, 0x3b, 0xf9, 0x18, 0x5d, 0x4f, 0xf4, 0x71, 0x28, 0x3e, 0xda, 0x91, 0xc, 0xb4, 0xe2, 0xac, 0x8d, 0x15, 0xf7}}
+	return a, nil
+}
+
+var __20261028100000_add_outbox_deliver_afterUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x54\x00\xab\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x6f\x75\x74\x62\x6f\x78\x20\x41\x44\x44\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x49\x46\x20\x4e\x4f\x54\x20\x45\x58\x49\x53\x54\x53\x20\x64\x65\x6c\x69\x76\x65\x72\x5f\x61\x66\x74\x65\x72\x20\x54\x49\x4d\x45\x53\x54\x41\x4d\x50\x20\x57\x49\x54\x48\x20\x54\x49\x4d\x45\x20\x5a\x4f\x4e\x45\x3b\x0a\x03\x00\xd3\x21\x51\x6d\x54\x00\x00\x00")
+
+func _20261028100000_add_outbox_deliver_afterUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261028100000_add_outbox_deliver_afterUpSql,
+		"20261028100000_add_outbox_deliver_after.up.sql",
+	)
+}
+
+func _20261028100000_add_outbox_deliver_afterUpSql() (*asset, error) {
+	bytes, err := _20261028100000_add_outbox_deliver_afterUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261028100000_add_outbox_deliver_after.up.sql", size: 84, mode: os.FileMode(420), modTime: time.Unix(1792087837, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x64, 0xb0, 0x68, 0x51, 0xe9, 0xe6, 0xc3, 0x6, 0x2e, 0xd6, 0x7b, 0xd2, 0x61, 0x5f, 0xd3, 0xfc, 0x3b, 0x16, 0x6, 0xdf, 0xa0, 0x82, 0xb7, 0x3b, 0x11, 0xb3, 0xb, 0x7e, 0xee, 0x6, 0x43, 0x1b}}
+	return a, nil
+}
+
+var __20261029000000_add_stream_geofenceDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x34\x00\xcb\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x73\x74\x72\x65\x61\x6d\x73\x20\x44\x52\x4f\x50\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x49\x46\x20\x45\x58\x49\x53\x54\x53\x20\x67\x65\x6f\x66\x65\x6e\x63\x65\x3b\x0a\x03\x00\xba\x42\xef\x17\x34\x00\x00\x00")
+
+func _20261029000000_add_stream_geofenceDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261029000000_add_stream_geofenceDownSql,
+		"20261029000000_add_stream_geofence.down.sql",
+	)
+}
+
+func _20261029000000_add_stream_geofenceDownSql() (*asset, error) {
+	bytes, err := _20261029000000_add_stream_geofenceDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261029000000_add_stream_geofence.down.sql", size: 52, mode: os.FileMode(420), modTime: time.Unix(1792088008, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x8a, 0xa, 0x55, 0x11, 0x62, 0xfd, 0xe9, 0x78, 0x27, 0x7c, 0x72, 0xba, 0xaf, 0x97, 0xb8, 0xc5, 0x14, 0xaa, 0x42, 0xd5, 0x99, 0x64, 0x48, 0x31, 0x6b, 0x94, 0x7b, 0x84, 0x63, 0xea, 0xdd, 0xfb}}
+	return a, nil
+}
+
+var __20261029000000_add_stream_geofenceUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x50\x00\xaf\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x73\x74\x72\x65\x61\x6d\x73\x20\x41\x44\x44\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x49\x46\x20\x4e\x4f\x54\x20\x45\x58\x49\x53\x54\x53\x20\x67\x65\x6f\x66\x65\x6e\x63\x65\x20\x54\x45\x58\x54\x20\x4e\x4f\x54\x20\x4e\x55\x4c\x4c\x20\x44\x45\x46\x41\x55\x4c\x54\x20\x27\x27\x3b\x0a\x03\x00\x08\x50\x2b\xf5\x50\x00\x00\x00")
+
+func _20261029000000_add_stream_geofenceUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261029000000_add_stream_geofenceUpSql,
+		"20261029000000_add_stream_geofence.up.sql",
+	)
+}
+
+func _20261029000000_add_stream_geofenceUpSql() (*asset, error) {
+	bytes, err := _20261029000000_add_stream_geofenceUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261029000000_add_stream_geofence.up.sql", size: 80, mode: os.FileMode(420), modTime: time.Unix(1792088008, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xdc, 0x8c, 0x95, 0x3f, 0x39, 0xa1, 0x1c, 0xcb, 0xf5, 0xc1, 0x10, 0x2c, 0x16, 0x2d, 0x34, 0x53, 0x69, 0x42, 0x97, 0xcc, 0xdf, 0x6f, 0x26, 0xab, 0x7d, 0xe5, 0x90, 0x3d, 0x4b, 0xf7, 0x1d, 0xef}}
+	return a, nil
+}
+
+var __20261030000000_add_stream_public_key_fingerprintDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x42\x00\xbd\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x73\x74\x72\x65\x61\x6d\x73\x20\x44\x52\x4f\x50\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x49\x46\x20\x45\x58\x49\x53\x54\x53\x20\x70\x75\x62\x6c\x69\x63\x5f\x6b\x65\x79\x5f\x66\x69\x6e\x67\x65\x72\x70\x72\x69\x6e\x74\x3b\x0a\x03\x00\x8b\xc3\xba\xcb\x42\x00\x00\x00")
+
+func _20261030000000_add_stream_public_key_fingerprintDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261030000000_add_stream_public_key_fingerprintDownSql,
+		"20261030000000_add_stream_public_key_fingerprint.down.sql",
+	)
+}
+
+func _20261030000000_add_stream_public_key_fingerprintDownSql() (*asset, error) {
+	bytes, err := _20261030000000_add_stream_public_key_fingerprintDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261030000000_add_stream_public_key_fingerprint.down.sql", size: 66, mode: os.FileMode(420), modTime: time.Unix(1792088198, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xa, 0x26, 0x43, 0x64, 0x6c, 0x2, 0xe3, 0x7e, 0x9b, 0x50, 0x6e, 0x69, 0xde, 0x50, 0x96, 0x95, 0xad, 0xdd, 0x88, 0x7a, 0x67, 0x50, 0x63, 0xf9, 0xe, 0x6e, 0x37, 0x5e, 0xe5, 0xa4, 0x62, 0x10}}
+	return a, nil
+}
+
+var __20261030000000_add_stream_public_key_fingerprintUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x5e\x00\xa1\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x73\x74\x72\x65\x61\x6d\x73\x20\x41\x44\x44\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x49\x46\x20\x4e\x4f\x54\x20\x45\x58\x49\x53\x54\x53\x20\x70\x75\x62\x6c\x69\x63\x5f\x6b\x65\x79\x5f\x66\x69\x6e\x67\x65\x72\x70\x72\x69\x6e\x74\x20\x54\x45\x58\x54\x20\x4e\x4f\x54\x20\x4e\x55\x4c\x4c\x20\x44\x45\x46\x41\x55\x4c\x54\x20\x27\x27\x3b\x0a\x03\x00\x8d\x08\x62\x3e\x5e\x00\x00\x00")
+
+func _20261030000000_add_stream_public_key_fingerprintUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261030000000_add_stream_public_key_fingerprintUpSql,
+		"20261030000000_add_stream_public_key_fingerprint.up.sql",
+	)
+}
+
+func _20261030000000_add_stream_public_key_fingerprintUpSql() (*asset, error) {
+	bytes, err := _20261030000000_add_stream_public_key_fingerprintUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261030000000_add_stream_public_key_fingerprint.up.sql", size: 94, mode: os.FileMode(420), modTime: time.Unix(1792088198, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x65, 0xe7, 0x17, 0x96, 0x38, 0x8e, 0x51, 0xe6, 0xdf, 0x7b, 0xed, 0xcb, 0xee, 0xc3, 0xa6, 0x50, 0xfe, 0x50, 0xc4, 0xce, 0x75, 0xf0, 0xf9, 0x8b, 0x57, 0xa4, 0x4b, 0x43, 0x69, 0x86, 0x5e, 0xc}}
+	return a, nil
+}
+
+var __20261031000000_add_stream_archiveDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x33\x00\xcc\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x73\x74\x72\x65\x61\x6d\x73\x20\x44\x52\x4f\x50\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x49\x46\x20\x45\x58\x49\x53\x54\x53\x20\x61\x72\x63\x68\x69\x76\x65\x3b\x0a\x03\x00\x2d\x5d\x6e\x8e\x33\x00\x00\x00")
+
+func _20261031000000_add_stream_archiveDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261031000000_add_stream_archiveDownSql,
+		"20261031000000_add_stream_archive.down.sql",
+	)
+}
+
+func _20261031000000_add_stream_archiveDownSql() (*asset, error) {
+	bytes, err := _20261031000000_add_stream_archiveDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261031000000_add_stream_archive.down.sql", size: 51, mode: os.FileMode(420), modTime: time.Unix(1792096841, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x4d, 0xaf, 0xa8, 0xb1, 0xe0, 0xbc, 0xdb, 0x7a, 0xba, 0x62, 0x66, 0xf3, 0xe9, 0x62, 0x4f, 0xae, 0xcf, 0x2c, 0x25, 0x6a, 0xee, 0x72, 0xd, 0x11, 0xe4, 0xb2, 0xfc, 0xed, 0x39, 0x7c, 0xbb, 0x39}}
+	return a, nil
+}
+
+var __20261031000000_add_stream_archiveUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x55\x00\xaa\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x73\x74\x72\x65\x61\x6d\x73\x20\x41\x44\x44\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x49\x46\x20\x4e\x4f\x54\x20\x45\x58\x49\x53\x54\x53\x20\x61\x72\x63\x68\x69\x76\x65\x20\x42\x4f\x4f\x4c\x45\x41\x4e\x20\x4e\x4f\x54\x20\x4e\x55\x4c\x4c\x20\x44\x45\x46\x41\x55\x4c\x54\x20\x46\x41\x4c\x53\x45\x3b\x0a\x03\x00\x07\x8c\x44\x5b\x55\x00\x00\x00")
+
+func _20261031000000_add_stream_archiveUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261031000000_add_stream_archiveUpSql,
+		"20261031000000_add_stream_archive.up.sql",
+	)
+}
+
+func _20261031000000_add_stream_archiveUpSql() (*asset, error) {
+	bytes, err := _20261031000000_add_stream_archiveUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261031000000_add_stream_archive.up.sql", size: 85, mode: os.FileMode(420), modTime: time.Unix(1792096841, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x60, 0xed, 0xc7, 0x65, 0xb3, 0xa2, 0x3b, 0x8f, 0x92, 0x39, 0xb6, 0xb3, 0x3f, 0xcc, 0x27, 0x73, 0x90, 0x7, 0x4d, 0xa0, 0xde, 0x13, 0x4b, 0xd8, 0xd8, 0x50, 0xb1, 0xd8, 0x16, 0xf5, 0x20, 0x47}}
+	return a, nil
+}
+
+var __20261101000000_add_stream_priorityDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x34\x00\xcb\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x73\x74\x72\x65\x61\x6d\x73\x20\x44\x52\x4f\x50\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x49\x46\x20\x45\x58\x49\x53\x54\x53\x20\x70\x72\x69\x6f\x72\x69\x74\x79\x3b\x0a\x03\x00\xc6\x60\xca\x3d\x34\x00\x00\x00")
+
+func _20261101000000_add_stream_priorityDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261101000000_add_stream_priorityDownSql,
+		"20261101000000_add_stream_priority.down.sql",
+	)
+}
+
+func _20261101000000_add_stream_priorityDownSql() (*asset, error) {
+	bytes, err := _20261101000000_add_stream_priorityDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261101000000_add_stream_priority.down.sql", size: 52, mode: os.FileMode(420), modTime: time.Unix(1792098009, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x86, 0x77, 0xe2, 0x59, 0x6d, 0xb4, 0x50, 0xa8, 0x53, 0xb6, 0x5a, 0x8f, 0x6c, 0xa5, 0x2c, 0x56, 0x71, 0x11, 0x4a, 0x91, 0x0, 0x44, 0x17, 0x70, 0xde, 0xdb, 0xe2, 0xff, 0xfd, 0x72, 0x44, 0x4e}}
+	return a, nil
+}
+
+var __20261101000000_add_stream_priorityUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x50\x00\xaf\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x73\x74\x72\x65\x61\x6d\x73\x20\x41\x44\x44\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x49\x46\x20\x4e\x4f\x54\x20\x45\x58\x49\x53\x54\x53\x20\x70\x72\x69\x6f\x72\x69\x74\x79\x20\x54\x45\x58\x54\x20\x4e\x4f\x54\x20\x4e\x55\x4c\x4c\x20\x44\x45\x46\x41\x55\x4c\x54\x20\x27\x27\x3b\x0a\x03\x00\xe6\x73\x22\xd0\x50\x00\x00\x00")
+
+func _20261101000000_add_stream_priorityUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261101000000_add_stream_priorityUpSql,
+		"20261101000000_add_stream_priority.up.sql",
+	)
+}
+
+func _20261101000000_add_stream_priorityUpSql() (*asset, error) {
+	bytes, err := _20261101000000_add_stream_priorityUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261101000000_add_stream_priority.up.sql", size: 80, mode: os.FileMode(420), modTime: time.Unix(1792098009, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xe4, 0xdf, 0x95, 0x84, 0xaa, 0xbe, 0x6d, 0x5f, 0x2d, 0xe7, 0x42, 0xab, 0xdb, 0xb6, 0x82, 0x40, 0x51, 0x7d, 0xad, 0x28, 0x69, 0x60, 0xa7, 0xa9, 0x90, 0x6a, 0x96, 0xbe, 0x18, 0xca, 0x40, 0xbd}}
+	return a, nil
+}
+
+var __20261102000000_add_stream_topicDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x31\x00\xce\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x73\x74\x72\x65\x61\x6d\x73\x20\x44\x52\x4f\x50\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x49\x46\x20\x45\x58\x49\x53\x54\x53\x20\x74\x6f\x70\x69\x63\x3b\x0a\x03\x00\xfd\xe2\x3e\x37\x31\x00\x00\x00")
+
+func _20261102000000_add_stream_topicDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261102000000_add_stream_topicDownSql,
+		"20261102000000_add_stream_topic.down.sql",
+	)
+}
+
+func _20261102000000_add_stream_topicDownSql() (*asset, error) {
+	bytes, err := _20261102000000_add_stream_topicDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261102000000_add_stream_topic.down.sql", size: 49, mode: os.FileMode(420), modTime: time.Unix(1792098293, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x67, 0x8, 0x16, 0xfe, 0x66, 0xfe, 0x3b, 0x37, 0x6b, 0x78, 0xc7, 0xa5, 0x8b, 0x79, 0xc1, 0x2e, 0x9e, 0x97, 0x10, 0xb6, 0x76, 0xb0, 0x49, 0x21, 0xcd, 0x58, 0xc3, 0xed, 0x54, 0x78, 0xe6, 0x85}}
+	return a, nil
+}
+
+var __20261102000000_add_stream_topicUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x4d\x00\xb2\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x73\x74\x72\x65\x61\x6d\x73\x20\x41\x44\x44\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x49\x46\x20\x4e\x4f\x54\x20\x45\x58\x49\x53\x54\x53\x20\x74\x6f\x70\x69\x63\x20\x54\x45\x58\x54\x20\x4e\x4f\x54\x20\x4e\x55\x4c\x4c\x20\x44\x45\x46\x41\x55\x4c\x54\x20\x27\x27\x3b\x0a\x03\x00\x59\xd3\xa8\x57\x4d\x00\x00\x00")
+
+func _20261102000000_add_stream_topicUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261102000000_add_stream_topicUpSql,
+		"20261102000000_add_stream_topic.up.sql",
+	)
+}
+
+func _20261102000000_add_stream_topicUpSql() (*asset, error) {
+	bytes, err := _20261102000000_add_stream_topicUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261102000000_add_stream_topic.up.sql", size: 77, mode: os.FileMode(420), modTime: time.Unix(1792098293, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x2c, 0x77, 0xf3, 0xd6, 0xa, 0x29, 0x21, 0x55, 0x93, 0x1, 0x52, 0x88, 0x22, 0x9c, 0xd2, 0x3c, 0xcf, 0xf3, 0x75, 0xe3, 0x4e, 0x95, 0xce, 0xdc, 0x8d, 0x99, 0xdf, 0x92, 0x84, 0x23, 0x52, 0xa4}}
+	return a, nil
+}
+
+var __20261103000000_add_stream_qosDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x2f\x00\xd0\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x73\x74\x72\x65\x61\x6d\x73\x20\x44\x52\x4f\x50\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x49\x46\x20\x45\x58\x49\x53\x54\x53\x20\x71\x6f\x73\x3b\x0a\x03\x00\x18\x55\xf7\xa9\x2f\x00\x00\x00")
+
+func _20261103000000_add_stream_qosDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261103000000_add_stream_qosDownSql,
+		"20261103000000_add_stream_qos.down.sql",
+	)
+}
+
+func _20261103000000_add_stream_qosDownSql() (*asset, error) {
+	bytes, err := _20261103000000_add_stream_qosDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261103000000_add_stream_qos.down.sql", size: 47, mode: os.FileMode(420), modTime: time.Unix(1792135479, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xdd, 0x7c, 0x3e, 0x5d, 0xa0, 0xb9, 0x5b, 0xcf, 0xde, 0xd4, 0x3d, 0x75, 0xf3, 0x4a, 0x35, 0xb4, 0x3f, 0x40, 0xeb, 0xb6, 0xd, 0xe4, 0x7f, 0x38, 0xe5, 0x3, 0x5d, 0xc5, 0xda, 0xbe, 0x4a, 0x33}}
+	return a, nil
+}
+
+var __20261103000000_add_stream_qosUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x4e\x00\xb1\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x73\x74\x72\x65\x61\x6d\x73\x20\x41\x44\x44\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x49\x46\x20\x4e\x4f\x54\x20\x45\x58\x49\x53\x54\x53\x20\x71\x6f\x73\x20\x53\x4d\x41\x4c\x4c\x49\x4e\x54\x20\x4e\x4f\x54\x20\x4e\x55\x4c\x4c\x20\x44\x45\x46\x41\x55\x4c\x54\x20\x30\x3b\x0a\x03\x00\x18\xea\x86\x98\x4e\x00\x00\x00")
+
+func _20261103000000_add_stream_qosUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261103000000_add_stream_qosUpSql,
+		"20261103000000_add_stream_qos.up.sql",
+	)
+}
+
+func _20261103000000_add_stream_qosUpSql() (*asset, error) {
+	bytes, err := _20261103000000_add_stream_qosUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261103000000_add_stream_qos.up.sql", size: 78, mode: os.FileMode(420), modTime: time.Unix(1792135479, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x6c, 0x35, 0xd1, 0xbe, 0x8, 0x86, 0x3c, 0x33, 0xb6, 0x51, 0x85, 0x66, 0xfc, 0x38, 0xea, 0x85, 0xe, 0xad, 0x65, 0x38, 0xda, 0x19, 0xaa, 0x5, 0x84, 0xa4, 0xff, 0x38, 0xf6, 0xfb, 0x1a, 0x25}}
+	return a, nil
+}
+
+var __20261104000000_add_outbox_dead_atUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x4e\x00\xb1\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x6f\x75\x74\x62\x6f\x78\x20\x41\x44\x44\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x49\x46\x20\x4e\x4f\x54\x20\x45\x58\x49\x53\x54\x53\x20\x64\x65\x61\x64\x5f\x61\x74\x20\x54\x49\x4d\x45\x53\x54\x41\x4d\x50\x20\x57\x49\x54\x48\x20\x54\x49\x4d\x45\x20\x5a\x4f\x4e\x45\x3b\x0a\x03\x00\xc9\x2c\x2c\xd9\x4e\x00\x00\x00")
+
+func _20261104000000_add_outbox_dead_atUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261104000000_add_outbox_dead_atUpSql,
+		"20261104000000_add_outbox_dead_at.up.sql",
+	)
+}
+
+func _20261104000000_add_outbox_dead_atUpSql() (*asset, error) {
+	bytes, err := _20261104000000_add_outbox_dead_atUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261104000000_add_outbox_dead_at.up.sql", size: 78, mode: os.FileMode(420), modTime: time.Unix(1792136099, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x66, 0x23, 0x8a, 0x4a, 0x84, 0x6d, 0x37, 0x2a, 0x44, 0x5f, 0x44, 0x2c, 0x62, 0x3a, 0x9c, 0x49, 0xe6, 0xf2, 0x22, 0x47, 0x3, 0x64, 0x6, 0xc2, 0x60, 0xec, 0xc1, 0xd0, 0xa3, 0xd4, 0x98, 0x78}}
+	return a, nil
+}
+
+var __20261104000000_add_outbox_dead_atDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x32\x00\xcd\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x6f\x75\x74\x62\x6f\x78\x20\x44\x52\x4f\x50\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x49\x46\x20\x45\x58\x49\x53\x54\x53\x20\x64\x65\x61\x64\x5f\x61\x74\x3b\x0a\x03\x00\xcc\x34\x80\xbd\x32\x00\x00\x00")
+
+func _20261104000000_add_outbox_dead_atDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261104000000_add_outbox_dead_atDownSql,
+		"20261104000000_add_outbox_dead_at.down.sql",
+	)
+}
+
+func _20261104000000_add_outbox_dead_atDownSql() (*asset, error) {
+	bytes, err := _20261104000000_add_outbox_dead_atDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261104000000_add_outbox_dead_at.down.sql", size: 50, mode: os.FileMode(420), modTime: time.Unix(1792136099, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x97, 0x83, 0x52, 0x4a, 0x7b, 0x7a, 0xe0, 0x38, 0x7, 0xbc, 0x4b, 0xe2, 0xcb, 0x7c, 0xf0, 0xa0, 0x90, 0x12, 0xaa, 0xfc, 0x6d, 0x8, 0x3f, 0xd5, 0x8e, 0x77, 0x66, 0xb5, 0xfe, 0x79, 0x48, 0xf8}}
+	return a, nil
+}
+
 // Asset loads and returns the asset for the given name.
 // It returns an error if the asset could not be found or
 // could not be loaded.
@@ -572,6 +2084,150 @@ var _bindata = map[string]func() (*asset, error){
 	"20190512204433_add_device_label.down.sql": _20190512204433_add_device_labelDownSql,
 
 	"20190512204433_add_device_label.up.sql": _20190512204433_add_device_labelUpSql,
+
+	"20261015100000_add_stream_stats_table.down.sql": _20261015100000_add_stream_stats_tableDownSql,
+
+	"20261015100000_add_stream_stats_table.up.sql": _20261015100000_add_stream_stats_tableUpSql,
+
+	"20261015110000_add_raw_payloads_table.down.sql": _20261015110000_add_raw_payloads_tableDownSql,
+
+	"20261015110000_add_raw_payloads_table.up.sql": _20261015110000_add_raw_payloads_tableUpSql,
+
+	"20261015120000_add_partition_tables.down.sql": _20261015120000_add_partition_tablesDownSql,
+
+	"20261015120000_add_partition_tables.up.sql": _20261015120000_add_partition_tablesUpSql,
+
+	"20261015130000_add_stream_datastore_addr.down.sql": _20261015130000_add_stream_datastore_addrDownSql,
+
+	"20261015130000_add_stream_datastore_addr.up.sql": _20261015130000_add_stream_datastore_addrUpSql,
+
+	"20261015140000_add_downsample_checkpoints_table.down.sql": _20261015140000_add_downsample_checkpoints_tableDownSql,
+
+	"20261015140000_add_downsample_checkpoints_table.up.sql": _20261015140000_add_downsample_checkpoints_tableUpSql,
+
+	"20261015150000_add_stream_conversions.down.sql": _20261015150000_add_stream_conversionsDownSql,
+
+	"20261015150000_add_stream_conversions.up.sql": _20261015150000_add_stream_conversionsUpSql,
+
+	"20261015160000_add_stream_ingest_secret.down.sql": _20261015160000_add_stream_ingest_secretDownSql,
+
+	"20261015160000_add_stream_ingest_secret.up.sql": _20261015160000_add_stream_ingest_secretUpSql,
+
+	"20261015170000_add_stream_source.down.sql": _20261015170000_add_stream_sourceDownSql,
+
+	"20261015170000_add_stream_source.up.sql": _20261015170000_add_stream_sourceUpSql,
+
+	"20261015180000_add_device_status_table.down.sql": _20261015180000_add_device_status_tableDownSql,
+
+	"20261015180000_add_device_status_table.up.sql": _20261015180000_add_device_status_tableUpSql,
+
+	"20261015190000_add_stream_deleted_at.down.sql": _20261015190000_add_stream_deleted_atDownSql,
+
+	"20261015190000_add_stream_deleted_at.up.sql": _20261015190000_add_stream_deleted_atUpSql,
+
+	"20261015200000_add_audit_log_table.down.sql": _20261015200000_add_audit_log_tableDownSql,
+
+	"20261015200000_add_audit_log_table.up.sql": _20261015200000_add_audit_log_tableUpSql,
+
+	"20261015210000_add_stream_compression.down.sql": _20261015210000_add_stream_compressionDownSql,
+
+	"20261015210000_add_stream_compression.up.sql": _20261015210000_add_stream_compressionUpSql,
+
+	"20261015220000_add_message_keys_table.down.sql": _20261015220000_add_message_keys_tableDownSql,
+
+	"20261015220000_add_message_keys_table.up.sql": _20261015220000_add_message_keys_tableUpSql,
+
+	"20261015230000_add_streams_device_id_index.down.sql": _20261015230000_add_streams_device_id_indexDownSql,
+
+	"20261015230000_add_streams_device_id_index.up.sql": _20261015230000_add_streams_device_id_indexUpSql,
+
+	"20261016000000_add_stream_timestamp_policy.down.sql": _20261016000000_add_stream_timestamp_policyDownSql,
+
+	"20261016000000_add_stream_timestamp_policy.up.sql": _20261016000000_add_stream_timestamp_policyUpSql,
+
+	"20261017000000_add_stream_policy_id.down.sql": _20261017000000_add_stream_policy_idDownSql,
+
+	"20261017000000_add_stream_policy_id.up.sql": _20261017000000_add_stream_policy_idUpSql,
+
+	"20261018000000_add_stream_labels.down.sql": _20261018000000_add_stream_labelsDownSql,
+
+	"20261018000000_add_stream_labels.up.sql": _20261018000000_add_stream_labelsUpSql,
+
+	"20261019000000_add_stream_datastore_timeout.down.sql": _20261019000000_add_stream_datastore_timeoutDownSql,
+
+	"20261019000000_add_stream_datastore_timeout.up.sql": _20261019000000_add_stream_datastore_timeoutUpSql,
+
+	"20261020000000_create_payload_schemas.down.sql": _20261020000000_create_payload_schemasDownSql,
+
+	"20261020000000_create_payload_schemas.up.sql": _20261020000000_create_payload_schemasUpSql,
+
+	"20261021000000_add_stream_dispositions.down.sql": _20261021000000_add_stream_dispositionsDownSql,
+
+	"20261021000000_add_stream_dispositions.up.sql": _20261021000000_add_stream_dispositionsUpSql,
+
+	"20261022000000_add_stream_privacy.down.sql": _20261022000000_add_stream_privacyDownSql,
+
+	"20261022000000_add_stream_privacy.up.sql": _20261022000000_add_stream_privacyUpSql,
+
+	"20261023000000_add_stream_geo_privacy.down.sql": _20261023000000_add_stream_geo_privacyDownSql,
+
+	"20261023000000_add_stream_geo_privacy.up.sql": _20261023000000_add_stream_geo_privacyUpSql,
+
+	"20261024000000_notify_stream_changes.down.sql": _20261024000000_notify_stream_changesDownSql,
+
+	"20261024000000_notify_stream_changes.up.sql": _20261024000000_notify_stream_changesUpSql,
+
+	"20261025000000_add_outbox.down.sql": _20261025000000_add_outboxDownSql,
+
+	"20261025000000_add_outbox.up.sql": _20261025000000_add_outboxUpSql,
+
+	"20261026000000_add_stream_rate_limit.down.sql": _20261026000000_add_stream_rate_limitDownSql,
+
+	"20261026000000_add_stream_rate_limit.up.sql": _20261026000000_add_stream_rate_limitUpSql,
+
+	"20261026100000_add_stream_stats_throttled_dropped.down.sql": _20261026100000_add_stream_stats_throttled_droppedDownSql,
+
+	"20261026100000_add_stream_stats_throttled_dropped.up.sql": _20261026100000_add_stream_stats_throttled_droppedUpSql,
+
+	"20261027000000_add_stream_sampling.down.sql": _20261027000000_add_stream_samplingDownSql,
+
+	"20261027000000_add_stream_sampling.up.sql": _20261027000000_add_stream_samplingUpSql,
+
+	"20261028000000_add_stream_schedule.down.sql": _20261028000000_add_stream_scheduleDownSql,
+
+	"20261028000000_add_stream_schedule.up.sql": _20261028000000_add_stream_scheduleUpSql,
+
+	"20261028100000_add_outbox_deliver_after.down.sql": _20261028100000_add_outbox_deliver_afterDownSql,
+
+	"20261028100000_add_outbox_deliver_after.up.sql": _20261028100000_add_outbox_deliver_afterUpSql,
+
+	"20261029000000_add_stream_geofence.down.sql": _20261029000000_add_stream_geofenceDownSql,
+
+	"20261029000000_add_stream_geofence.up.sql": _20261029000000_add_stream_geofenceUpSql,
+
+	"20261030000000_add_stream_public_key_fingerprint.down.sql": _20261030000000_add_stream_public_key_fingerprintDownSql,
+
+	"20261030000000_add_stream_public_key_fingerprint.up.sql": _20261030000000_add_stream_public_key_fingerprintUpSql,
+
+	"20261031000000_add_stream_archive.down.sql": _20261031000000_add_stream_archiveDownSql,
+
+	"20261031000000_add_stream_archive.up.sql": _20261031000000_add_stream_archiveUpSql,
+
+	"20261101000000_add_stream_priority.down.sql": _20261101000000_add_stream_priorityDownSql,
+
+	"20261101000000_add_stream_priority.up.sql": _20261101000000_add_stream_priorityUpSql,
+
+	"20261102000000_add_stream_topic.down.sql": _20261102000000_add_stream_topicDownSql,
+
+	"20261102000000_add_stream_topic.up.sql": _20261102000000_add_stream_topicUpSql,
+
+	"20261103000000_add_stream_qos.down.sql": _20261103000000_add_stream_qosDownSql,
+
+	"20261103000000_add_stream_qos.up.sql": _20261103000000_add_stream_qosUpSql,
+
+	"20261104000000_add_outbox_dead_at.up.sql": _20261104000000_add_outbox_dead_atUpSql,
+
+	"20261104000000_add_outbox_dead_at.down.sql": _20261104000000_add_outbox_dead_atDownSql,
 }
 
 // AssetDir returns the file names below a certain
@@ -615,24 +2271,96 @@ type bintree struct {
 }
 
 var _bintree = &bintree{nil, map[string]*bintree{
-	"20180525115614_create_device_table.down.sql":        &bintree{_20180525115614_create_device_tableDownSql, map[string]*bintree{}},
-	"20180525115614_create_device_table.up.sql":          &bintree{_20180525115614_create_device_tableUpSql, map[string]*bintree{}},
-	"20180526232618_add_streams_table.down.sql":          &bintree{_20180526232618_add_streams_tableDownSql, map[string]*bintree{}},
-	"20180526232618_add_streams_table.up.sql":            &bintree{_20180526232618_add_streams_tableUpSql, map[string]*bintree{}},
-	"20181202133704_add_operations.down.sql":             &bintree{_20181202133704_add_operationsDownSql, map[string]*bintree{}},
-	"20181202133704_add_operations.up.sql":               &bintree{_20181202133704_add_operationsUpSql, map[string]*bintree{}},
-	"20190306164350_remove_broker_col.down.sql":          &bintree{_20190306164350_remove_broker_colDownSql, map[string]*bintree{}},
-	"20190306164350_remove_broker_col.up.sql":            &bintree{_20190306164350_remove_broker_colUpSql, map[string]*bintree{}},
-	"20190306170548_add_certificate_table.down.sql":      &bintree{_20190306170548_add_certificate_tableDownSql, map[string]*bintree{}},
-	"20190306170548_add_certificate_table.up.sql":        &bintree{_20190306170548_add_certificate_tableUpSql, map[string]*bintree{}},
-	"20190308144957_rename_policy_id.down.sql":           &bintree{_20190308144957_rename_policy_idDownSql, map[string]*bintree{}},
-	"20190308144957_rename_policy_id.up.sql":             &bintree{_20190308144957_rename_policy_idUpSql, map[string]*bintree{}},
-	"20190315170620_add_uuid_column_to_stream.down.sql":  &bintree{_20190315170620_add_uuid_column_to_streamDownSql, map[string]*bintree{}},
-	"20190315170620_add_uuid_column_to_stream.up.sql":    &bintree{_20190315170620_add_uuid_column_to_streamUpSql, map[string]*bintree{}},
-	"20190315225536_change_stream_unique_index.down.sql": &bintree{_20190315225536_change_stream_unique_indexDownSql, map[string]*bintree{}},
-	"20190315225536_change_stream_unique_index.up.sql":   &bintree{_20190315225536_change_stream_unique_indexUpSql, map[string]*bintree{}},
-	"20190512204433_add_device_label.down.sql":           &bintree{_20190512204433_add_device_labelDownSql, map[string]*bintree{}},
-	"20190512204433_add_device_label.up.sql":             &bintree{_20190512204433_add_device_labelUpSql, map[string]*bintree{}},
+	"20180525115614_create_device_table.down.sql":                &bintree{_20180525115614_create_device_tableDownSql, map[string]*bintree{}},
+	"20180525115614_create_device_table.up.sql":                  &bintree{_20180525115614_create_device_tableUpSql, map[string]*bintree{}},
+	"20180526232618_add_streams_table.down.sql":                  &bintree{_20180526232618_add_streams_tableDownSql, map[string]*bintree{}},
+	"20180526232618_add_streams_table.up.sql":                    &bintree{_20180526232618_add_streams_tableUpSql, map[string]*bintree{}},
+	"20181202133704_add_operations.down.sql":                     &bintree{_20181202133704_add_operationsDownSql, map[string]*bintree{}},
+	"20181202133704_add_operations.up.sql":                       &bintree{_20181202133704_add_operationsUpSql, map[string]*bintree{}},
+	"20190306164350_remove_broker_col.down.sql":                  &bintree{_20190306164350_remove_broker_colDownSql, map[string]*bintree{}},
+	"20190306164350_remove_broker_col.up.sql":                    &bintree{_20190306164350_remove_broker_colUpSql, map[string]*bintree{}},
+	"20190306170548_add_certificate_table.down.sql":              &bintree{_20190306170548_add_certificate_tableDownSql, map[string]*bintree{}},
+	"20190306170548_add_certificate_table.up.sql":                &bintree{_20190306170548_add_certificate_tableUpSql, map[string]*bintree{}},
+	"20190308144957_rename_policy_id.down.sql":                   &bintree{_20190308144957_rename_policy_idDownSql, map[string]*bintree{}},
+	"20190308144957_rename_policy_id.up.sql":                     &bintree{_20190308144957_rename_policy_idUpSql, map[string]*bintree{}},
+	"20190315170620_add_uuid_column_to_stream.down.sql":          &bintree{_20190315170620_add_uuid_column_to_streamDownSql, map[string]*bintree{}},
+	"20190315170620_add_uuid_column_to_stream.up.sql":            &bintree{_20190315170620_add_uuid_column_to_streamUpSql, map[string]*bintree{}},
+	"20190315225536_change_stream_unique_index.down.sql":         &bintree{_20190315225536_change_stream_unique_indexDownSql, map[string]*bintree{}},
+	"20190315225536_change_stream_unique_index.up.sql":           &bintree{_20190315225536_change_stream_unique_indexUpSql, map[string]*bintree{}},
+	"20190512204433_add_device_label.down.sql":                   &bintree{_20190512204433_add_device_labelDownSql, map[string]*bintree{}},
+	"20190512204433_add_device_label.up.sql":                     &bintree{_20190512204433_add_device_labelUpSql, map[string]*bintree{}},
+	"20261015100000_add_stream_stats_table.down.sql":             &bintree{_20261015100000_add_stream_stats_tableDownSql, map[string]*bintree{}},
+	"20261015100000_add_stream_stats_table.up.sql":               &bintree{_20261015100000_add_stream_stats_tableUpSql, map[string]*bintree{}},
+	"20261015110000_add_raw_payloads_table.down.sql":             &bintree{_20261015110000_add_raw_payloads_tableDownSql, map[string]*bintree{}},
+	"20261015110000_add_raw_payloads_table.up.sql":               &bintree{_20261015110000_add_raw_payloads_tableUpSql, map[string]*bintree{}},
+	"20261015120000_add_partition_tables.down.sql":               &bintree{_20261015120000_add_partition_tablesDownSql, map[string]*bintree{}},
+	"20261015120000_add_partition_tables.up.sql":                 &bintree{_20261015120000_add_partition_tablesUpSql, map[string]*bintree{}},
+	"20261015130000_add_stream_datastore_addr.down.sql":          &bintree{_20261015130000_add_stream_datastore_addrDownSql, map[string]*bintree{}},
+	"20261015130000_add_stream_datastore_addr.up.sql":            &bintree{_20261015130000_add_stream_datastore_addrUpSql, map[string]*bintree{}},
+	"20261015140000_add_downsample_checkpoints_table.down.sql":   &bintree{_20261015140000_add_downsample_checkpoints_tableDownSql, map[string]*bintree{}},
+	"20261015140000_add_downsample_checkpoints_table.up.sql":     &bintree{_20261015140000_add_downsample_checkpoints_tableUpSql, map[string]*bintree{}},
+	"20261015150000_add_stream_conversions.down.sql":             &bintree{_20261015150000_add_stream_conversionsDownSql, map[string]*bintree{}},
+	"20261015150000_add_stream_conversions.up.sql":               &bintree{_20261015150000_add_stream_conversionsUpSql, map[string]*bintree{}},
+	"20261015160000_add_stream_ingest_secret.down.sql":           &bintree{_20261015160000_add_stream_ingest_secretDownSql, map[string]*bintree{}},
+	"20261015160000_add_stream_ingest_secret.up.sql":             &bintree{_20261015160000_add_stream_ingest_secretUpSql, map[string]*bintree{}},
+	"20261015170000_add_stream_source.down.sql":                  &bintree{_20261015170000_add_stream_sourceDownSql, map[string]*bintree{}},
+	"20261015170000_add_stream_source.up.sql":                    &bintree{_20261015170000_add_stream_sourceUpSql, map[string]*bintree{}},
+	"20261015180000_add_device_status_table.down.sql":            &bintree{_20261015180000_add_device_status_tableDownSql, map[string]*bintree{}},
+	"20261015180000_add_device_status_table.up.sql":              &bintree{_20261015180000_add_device_status_tableUpSql, map[string]*bintree{}},
+	"20261015190000_add_stream_deleted_at.down.sql":              &bintree{_20261015190000_add_stream_deleted_atDownSql, map[string]*bintree{}},
+	"20261015190000_add_stream_deleted_at.up.sql":                &bintree{_20261015190000_add_stream_deleted_atUpSql, map[string]*bintree{}},
+	"20261015200000_add_audit_log_table.down.sql":                &bintree{_20261015200000_add_audit_log_tableDownSql, map[string]*bintree{}},
+	"20261015200000_add_audit_log_table.up.sql":                  &bintree{_20261015200000_add_audit_log_tableUpSql, map[string]*bintree{}},
+	"20261015210000_add_stream_compression.down.sql":             &bintree{_20261015210000_add_stream_compressionDownSql, map[string]*bintree{}},
+	"20261015210000_add_stream_compression.up.sql":               &bintree{_20261015210000_add_stream_compressionUpSql, map[string]*bintree{}},
+	"20261015220000_add_message_keys_table.down.sql":             &bintree{_20261015220000_add_message_keys_tableDownSql, map[string]*bintree{}},
+	"20261015220000_add_message_keys_table.up.sql":               &bintree{_20261015220000_add_message_keys_tableUpSql, map[string]*bintree{}},
+	"20261015230000_add_streams_device_id_index.down.sql":        &bintree{_20261015230000_add_streams_device_id_indexDownSql, map[string]*bintree{}},
+	"20261015230000_add_streams_device_id_index.up.sql":          &bintree{_20261015230000_add_streams_device_id_indexUpSql, map[string]*bintree{}},
+	"20261016000000_add_stream_timestamp_policy.down.sql":        &bintree{_20261016000000_add_stream_timestamp_policyDownSql, map[string]*bintree{}},
+	"20261016000000_add_stream_timestamp_policy.up.sql":          &bintree{_20261016000000_add_stream_timestamp_policyUpSql, map[string]*bintree{}},
+	"20261017000000_add_stream_policy_id.down.sql":               &bintree{_20261017000000_add_stream_policy_idDownSql, map[string]*bintree{}},
+	"20261017000000_add_stream_policy_id.up.sql":                 &bintree{_20261017000000_add_stream_policy_idUpSql, map[string]*bintree{}},
+	"20261018000000_add_stream_labels.down.sql":                  &bintree{_20261018000000_add_stream_labelsDownSql, map[string]*bintree{}},
+	"20261018000000_add_stream_labels.up.sql":                    &bintree{_20261018000000_add_stream_labelsUpSql, map[string]*bintree{}},
+	"20261019000000_add_stream_datastore_timeout.down.sql":       &bintree{_20261019000000_add_stream_datastore_timeoutDownSql, map[string]*bintree{}},
+	"20261019000000_add_stream_datastore_timeout.up.sql":         &bintree{_20261019000000_add_stream_datastore_timeoutUpSql, map[string]*bintree{}},
+	"20261020000000_create_payload_schemas.down.sql":             &bintree{_20261020000000_create_payload_schemasDownSql, map[string]*bintree{}},
+	"20261020000000_create_payload_schemas.up.sql":               &bintree{_20261020000000_create_payload_schemasUpSql, map[string]*bintree{}},
+	"20261021000000_add_stream_dispositions.down.sql":            &bintree{_20261021000000_add_stream_dispositionsDownSql, map[string]*bintree{}},
+	"20261021000000_add_stream_dispositions.up.sql":              &bintree{_20261021000000_add_stream_dispositionsUpSql, map[string]*bintree{}},
+	"20261022000000_add_stream_privacy.down.sql":                 &bintree{_20261022000000_add_stream_privacyDownSql, map[string]*bintree{}},
+	"20261022000000_add_stream_privacy.up.sql":                   &bintree{_20261022000000_add_stream_privacyUpSql, map[string]*bintree{}},
+	"20261023000000_add_stream_geo_privacy.down.sql":             &bintree{_20261023000000_add_stream_geo_privacyDownSql, map[string]*bintree{}},
+	"20261023000000_add_stream_geo_privacy.up.sql":               &bintree{_20261023000000_add_stream_geo_privacyUpSql, map[string]*bintree{}},
+	"20261024000000_notify_stream_changes.down.sql":              &bintree{_20261024000000_notify_stream_changesDownSql, map[string]*bintree{}},
+	"20261024000000_notify_stream_changes.up.sql":                &bintree{_20261024000000_notify_stream_changesUpSql, map[string]*bintree{}},
+	"20261025000000_add_outbox.down.sql":                         &bintree{_20261025000000_add_outboxDownSql, map[string]*bintree{}},
+	"20261025000000_add_outbox.up.sql":                           &bintree{_20261025000000_add_outboxUpSql, map[string]*bintree{}},
+	"20261026000000_add_stream_rate_limit.down.sql":              &bintree{_20261026000000_add_stream_rate_limitDownSql, map[string]*bintree{}},
+	"20261026000000_add_stream_rate_limit.up.sql":                &bintree{_20261026000000_add_stream_rate_limitUpSql, map[string]*bintree{}},
+	"20261026100000_add_stream_stats_throttled_dropped.down.sql": &bintree{_20261026100000_add_stream_stats_throttled_droppedDownSql, map[string]*bintree{}},
+	"20261026100000_add_stream_stats_throttled_dropped.up.sql":   &bintree{_20261026100000_add_stream_stats_throttled_droppedUpSql, map[string]*bintree{}},
+	"20261027000000_add_stream_sampling.down.sql":                &bintree{_20261027000000_add_stream_samplingDownSql, map[string]*bintree{}},
+	"20261027000000_add_stream_sampling.up.sql":                  &bintree{_20261027000000_add_stream_samplingUpSql, map[string]*bintree{}},
+	"20261028000000_add_stream_schedule.down.sql":                &bintree{_20261028000000_add_stream_scheduleDownSql, map[string]*bintree{}},
+	"20261028000000_add_stream_schedule.up.sql":                  &bintree{_20261028000000_add_stream_scheduleUpSql, map[string]*bintree{}},
+	"20261028100000_add_outbox_deliver_after.down.sql":           &bintree{_20261028100000_add_outbox_deliver_afterDownSql, map[string]*bintree{}},
+	"20261028100000_add_outbox_deliver_after.up.sql":             &bintree{_20261028100000_add_outbox_deliver_afterUpSql, map[string]*bintree{}},
+	"20261029000000_add_stream_geofence.down.sql":                &bintree{_20261029000000_add_stream_geofenceDownSql, map[string]*bintree{}},
+	"20261029000000_add_stream_geofence.up.sql":                  &bintree{_20261029000000_add_stream_geofenceUpSql, map[string]*bintree{}},
+	"20261030000000_add_stream_public_key_fingerprint.down.sql":  &bintree{_20261030000000_add_stream_public_key_fingerprintDownSql, map[string]*bintree{}},
+	"20261030000000_add_stream_public_key_fingerprint.up.sql":    &bintree{_20261030000000_add_stream_public_key_fingerprintUpSql, map[string]*bintree{}},
+	"20261031000000_add_stream_archive.down.sql":                 &bintree{_20261031000000_add_stream_archiveDownSql, map[string]*bintree{}},
+	"20261031000000_add_stream_archive.up.sql":                   &bintree{_20261031000000_add_stream_archiveUpSql, map[string]*bintree{}},
+	"20261101000000_add_stream_priority.down.sql":                &bintree{_20261101000000_add_stream_priorityDownSql, map[string]*bintree{}},
+	"20261101000000_add_stream_priority.up.sql":                  &bintree{_20261101000000_add_stream_priorityUpSql, map[string]*bintree{}},
+	"20261102000000_add_stream_topic.down.sql":                   &bintree{_20261102000000_add_stream_topicDownSql, map[string]*bintree{}},
+	"20261102000000_add_stream_topic.up.sql":                     &bintree{_20261102000000_add_stream_topicUpSql, map[string]*bintree{}},
+	"20261103000000_add_stream_qos.down.sql":                     &bintree{_20261103000000_add_stream_qosDownSql, map[string]*bintree{}},
+	"20261103000000_add_stream_qos.up.sql":                       &bintree{_20261103000000_add_stream_qosUpSql, map[string]*bintree{}},
+	"20261104000000_add_outbox_dead_at.up.sql":                   &bintree{_20261104000000_add_outbox_dead_atUpSql, map[string]*bintree{}},
+	"20261104000000_add_outbox_dead_at.down.sql":                 &bintree{_20261104000000_add_outbox_dead_atDownSql, map[string]*bintree{}},
 }}
 
 // RestoreAsset restores an asset under the given directory.
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS stream_stats;
\ No newline at end of file
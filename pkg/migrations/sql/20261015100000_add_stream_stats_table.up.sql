@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS stream_stats (
+  stream_uuid UUID NOT NULL PRIMARY KEY REFERENCES streams(uuid) ON DELETE CASCADE,
+  messages_received BIGINT NOT NULL DEFAULT 0,
+  bytes_encrypted BIGINT NOT NULL DEFAULT 0,
+  writes_succeeded BIGINT NOT NULL DEFAULT 0,
+  writes_failed BIGINT NOT NULL DEFAULT 0,
+  last_message_at TIMESTAMP WITH TIME ZONE,
+  processing_count BIGINT NOT NULL DEFAULT 0,
+  processing_time_ns BIGINT NOT NULL DEFAULT 0,
+  updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
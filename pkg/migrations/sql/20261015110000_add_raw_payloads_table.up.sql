@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS raw_payloads (
+  id BIGSERIAL PRIMARY KEY,
+  device_token TEXT NOT NULL,
+  received_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  payload BYTEA NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS raw_payloads_device_token_received_at_idx
+  ON raw_payloads (device_token, received_at);
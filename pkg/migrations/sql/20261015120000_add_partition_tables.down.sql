@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS device_leases;
+
+DROP TABLE IF EXISTS encoder_instances;
\ No newline at end of file
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS encoder_instances (
+  instance_id TEXT NOT NULL PRIMARY KEY,
+  expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS device_leases (
+  device_id INTEGER NOT NULL PRIMARY KEY REFERENCES devices(id) ON DELETE CASCADE,
+  instance_id TEXT NOT NULL,
+  expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS device_leases_instance_id_idx
+  ON device_leases (instance_id);
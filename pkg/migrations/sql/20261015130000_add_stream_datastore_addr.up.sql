@@ -0,0 +1 @@
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS datastore_addr TEXT NOT NULL DEFAULT '';
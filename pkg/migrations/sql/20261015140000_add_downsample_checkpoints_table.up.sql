@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS downsample_checkpoints (
+  stream_uuid UUID NOT NULL REFERENCES streams(uuid) ON DELETE CASCADE,
+  sensor_id INTEGER NOT NULL,
+  last_forwarded_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+  PRIMARY KEY (stream_uuid, sensor_id)
+);
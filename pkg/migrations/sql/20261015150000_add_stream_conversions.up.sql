@@ -0,0 +1 @@
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS conversions JSONB NOT NULL DEFAULT '{}';
@@ -0,0 +1 @@
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS source TEXT NOT NULL DEFAULT '';
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS device_status;
\ No newline at end of file
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS device_status (
+  device_token TEXT NOT NULL PRIMARY KEY REFERENCES devices(device_token) ON DELETE CASCADE,
+  last_seen_at TIMESTAMP WITH TIME ZONE,
+  message_counts JSONB NOT NULL DEFAULT '{}',
+  error TEXT,
+  error_at TIMESTAMP WITH TIME ZONE,
+  updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
\ No newline at end of file
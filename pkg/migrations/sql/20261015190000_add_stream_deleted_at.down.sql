@@ -0,0 +1,11 @@
+DELETE FROM streams WHERE deleted_at IS NOT NULL;
+
+DELETE FROM devices d
+  WHERE NOT EXISTS (SELECT 1 FROM streams s WHERE s.device_id = d.id);
+
+DROP INDEX IF EXISTS streams_device_id_community_id_idx;
+
+CREATE UNIQUE INDEX IF NOT EXISTS streams_device_id_community_id_idx
+  ON streams(device_id, community_id);
+
+ALTER TABLE streams DROP COLUMN IF EXISTS deleted_at;
\ No newline at end of file
@@ -0,0 +1,7 @@
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE;
+
+DROP INDEX IF EXISTS streams_device_id_community_id_idx;
+
+CREATE UNIQUE INDEX IF NOT EXISTS streams_device_id_community_id_idx
+  ON streams(device_id, community_id)
+  WHERE deleted_at IS NULL;
\ No newline at end of file
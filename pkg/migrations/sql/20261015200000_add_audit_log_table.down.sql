@@ -0,0 +1,5 @@
+DROP TRIGGER IF EXISTS audit_log_immutable ON audit_log;
+
+DROP FUNCTION IF EXISTS audit_log_immutable();
+
+DROP TABLE IF EXISTS audit_log;
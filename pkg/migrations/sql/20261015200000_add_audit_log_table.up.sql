@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS audit_log (
+  id BIGSERIAL PRIMARY KEY,
+  recorded_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  caller TEXT NOT NULL,
+  request_id TEXT,
+  method TEXT NOT NULL,
+  stream_uuid TEXT,
+  params JSONB NOT NULL DEFAULT '{}',
+  result TEXT NOT NULL,
+  error TEXT
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_stream_uuid_idx
+  ON audit_log (stream_uuid, id);
+
+CREATE OR REPLACE FUNCTION audit_log_immutable() RETURNS TRIGGER AS $$
+BEGIN
+  RAISE EXCEPTION 'audit_log is append only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_log_immutable
+  BEFORE UPDATE OR DELETE ON audit_log
+  FOR EACH ROW EXECUTE PROCEDURE audit_log_immutable();
@@ -0,0 +1 @@
+ALTER TABLE streams DROP COLUMN IF EXISTS compression;
\ No newline at end of file
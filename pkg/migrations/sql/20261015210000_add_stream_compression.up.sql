@@ -0,0 +1 @@
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS compression TEXT NOT NULL DEFAULT '';
\ No newline at end of file
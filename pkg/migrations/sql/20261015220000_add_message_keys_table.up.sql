@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS message_keys (
+  key TEXT PRIMARY KEY,
+  seen_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS message_keys_seen_at_idx ON message_keys (seen_at);
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS streams_device_id_idx;
@@ -0,0 +1,2 @@
+CREATE INDEX IF NOT EXISTS streams_device_id_idx
+  ON streams (device_id);
@@ -0,0 +1 @@
+ALTER TABLE streams DROP COLUMN IF EXISTS timestamp_policy;
\ No newline at end of file
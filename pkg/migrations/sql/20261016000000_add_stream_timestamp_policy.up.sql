@@ -0,0 +1 @@
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS timestamp_policy TEXT NOT NULL DEFAULT '';
\ No newline at end of file
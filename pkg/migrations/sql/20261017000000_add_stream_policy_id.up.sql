@@ -0,0 +1 @@
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS policy_id TEXT NOT NULL DEFAULT '';
@@ -0,0 +1 @@
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS labels JSONB NOT NULL DEFAULT '{}';
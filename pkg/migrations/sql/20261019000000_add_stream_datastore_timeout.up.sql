@@ -0,0 +1 @@
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS datastore_timeout TEXT NOT NULL DEFAULT '';
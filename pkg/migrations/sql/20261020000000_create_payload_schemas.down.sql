@@ -0,0 +1,3 @@
+ALTER TABLE streams DROP COLUMN IF EXISTS payload_schema;
+
+DROP TABLE IF EXISTS payload_schemas;
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS payload_schemas (
+  kind TEXT NOT NULL,
+  version INTEGER NOT NULL,
+  channels JSONB NOT NULL DEFAULT '[]',
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (kind, version)
+);
+
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS payload_schema TEXT NOT NULL DEFAULT '';
@@ -0,0 +1 @@
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS dispositions JSONB NOT NULL DEFAULT '{}';
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS privacy_ledger;
+
+ALTER TABLE streams
+  DROP COLUMN IF EXISTS privacy;
@@ -0,0 +1,10 @@
+ALTER TABLE streams
+  ADD COLUMN IF NOT EXISTS privacy TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS privacy_ledger (
+  stream_uuid UUID NOT NULL REFERENCES streams(uuid) ON DELETE CASCADE,
+  period_start TIMESTAMP WITH TIME ZONE NOT NULL,
+  spent DOUBLE PRECISION NOT NULL,
+  updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+  PRIMARY KEY (stream_uuid, period_start)
+);
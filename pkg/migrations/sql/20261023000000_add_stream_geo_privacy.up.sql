@@ -0,0 +1 @@
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS geo_privacy TEXT NOT NULL DEFAULT '';
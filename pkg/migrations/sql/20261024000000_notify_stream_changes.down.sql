@@ -0,0 +1,5 @@
+DROP TRIGGER IF EXISTS devices_notify_change ON devices;
+
+DROP TRIGGER IF EXISTS streams_notify_change ON streams;
+
+DROP FUNCTION IF EXISTS notify_stream_change();
@@ -0,0 +1,32 @@
+CREATE OR REPLACE FUNCTION notify_stream_change() RETURNS TRIGGER AS $$
+BEGIN
+  IF TG_TABLE_NAME = 'devices' THEN
+    PERFORM pg_notify('stream_changes', NEW.id::text);
+    RETURN NEW;
+  END IF;
+
+  IF TG_OP = 'DELETE' THEN
+    PERFORM pg_notify('stream_changes', OLD.device_id::text);
+    RETURN OLD;
+  END IF;
+
+  IF TG_OP = 'UPDATE' AND OLD.device_id <> NEW.device_id THEN
+    PERFORM pg_notify('stream_changes', OLD.device_id::text);
+  END IF;
+
+  PERFORM pg_notify('stream_changes', NEW.device_id::text);
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS streams_notify_change ON streams;
+
+CREATE TRIGGER streams_notify_change
+  AFTER INSERT OR UPDATE OR DELETE ON streams
+  FOR EACH ROW EXECUTE PROCEDURE notify_stream_change();
+
+DROP TRIGGER IF EXISTS devices_notify_change ON devices;
+
+CREATE TRIGGER devices_notify_change
+  AFTER UPDATE ON devices
+  FOR EACH ROW EXECUTE PROCEDURE notify_stream_change();
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS outbox (
+  id BIGSERIAL PRIMARY KEY,
+  stream_uid TEXT NOT NULL,
+  community_id TEXT NOT NULL,
+  device_token TEXT NOT NULL,
+  datastore_addr TEXT NOT NULL DEFAULT '',
+  datastore_timeout TEXT NOT NULL DEFAULT '',
+  data BYTEA NOT NULL,
+  enqueued_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  attempts INTEGER NOT NULL DEFAULT 0,
+  next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  delivered_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS outbox_pending_idx
+  ON outbox (next_attempt_at, id) WHERE delivered_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS outbox_delivered_at_idx
+  ON outbox (delivered_at) WHERE delivered_at IS NOT NULL;
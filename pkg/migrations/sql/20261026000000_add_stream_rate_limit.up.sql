@@ -0,0 +1 @@
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS rate_limit TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+ALTER TABLE stream_stats DROP COLUMN IF EXISTS messages_dropped;
+ALTER TABLE stream_stats DROP COLUMN IF EXISTS messages_throttled;
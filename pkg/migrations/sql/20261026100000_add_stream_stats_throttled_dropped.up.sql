@@ -0,0 +1,2 @@
+ALTER TABLE stream_stats ADD COLUMN IF NOT EXISTS messages_throttled BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE stream_stats ADD COLUMN IF NOT EXISTS messages_dropped BIGINT NOT NULL DEFAULT 0;
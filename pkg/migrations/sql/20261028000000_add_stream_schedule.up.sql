@@ -0,0 +1 @@
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS schedule TEXT NOT NULL DEFAULT '';
@@ -0,0 +1 @@
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS deliver_after TIMESTAMP WITH TIME ZONE;
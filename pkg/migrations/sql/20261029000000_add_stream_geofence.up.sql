@@ -0,0 +1 @@
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS geofence TEXT NOT NULL DEFAULT '';
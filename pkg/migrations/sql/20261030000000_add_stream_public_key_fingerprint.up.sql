@@ -0,0 +1 @@
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS public_key_fingerprint TEXT NOT NULL DEFAULT '';
@@ -0,0 +1 @@
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS archive BOOLEAN NOT NULL DEFAULT FALSE;
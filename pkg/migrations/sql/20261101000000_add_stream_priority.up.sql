@@ -0,0 +1 @@
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS priority TEXT NOT NULL DEFAULT '';
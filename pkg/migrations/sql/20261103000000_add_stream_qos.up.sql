@@ -0,0 +1 @@
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS qos SMALLINT NOT NULL DEFAULT 0;
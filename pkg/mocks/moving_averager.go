@@ -1,6 +1,8 @@
 package mocks
 
 import (
+	"context"
+
 	"github.com/stretchr/testify/mock"
 )
 
@@ -8,7 +10,7 @@ type MovingAverager struct {
 	mock.Mock
 }
 
-func (m *MovingAverager) MovingAverage(value float64, deviceToken string, sensorID int, interval uint32) (float64, error) {
-	args := m.Called(value, deviceToken, sensorID, interval)
+func (m *MovingAverager) MovingAverage(ctx context.Context, value float64, deviceToken string, sensorID int, interval uint32) (float64, error) {
+	args := m.Called(ctx, value, deviceToken, sensorID, interval)
 	return args.Get(0).(float64), args.Error(1)
 }
@@ -5,6 +5,7 @@ import (
 	"sync"
 
 	"github.com/DECODEproject/iotencoder/pkg/mqtt"
+	"github.com/DECODEproject/iotencoder/pkg/secret"
 )
 
 // MQTTClient is a mock type that implements our mqtt interface. Internally it
@@ -29,7 +30,7 @@ func NewMQTTClient(err error) *MQTTClient {
 // Subscribe is the public interface method. In the mock we add the given broker
 // and topic to an internal data structure where it can be retrieved for test
 // verification.
-func (m *MQTTClient) Subscribe(broker, username, deviceToken string, cb mqtt.Callback) error {
+func (m *MQTTClient) Subscribe(broker, username string, deviceToken secret.Secret, cb mqtt.Callback) error {
 	if m.err != nil {
 		return m.err
 	}
@@ -43,12 +44,12 @@ func (m *MQTTClient) Subscribe(broker, username, deviceToken string, cb mqtt.Cal
 		m.Subscriptions[key] = make(map[string]bool)
 	}
 
-	m.Subscriptions[key][deviceToken] = true
+	m.Subscriptions[key][deviceToken.Reveal()] = true
 
 	return nil
 }
 
-func (m *MQTTClient) Unsubscribe(broker, username, deviceToken string) error {
+func (m *MQTTClient) Unsubscribe(broker, username string, deviceToken secret.Secret) error {
 	if m.err != nil {
 		return m.err
 	}
@@ -59,8 +60,8 @@ func (m *MQTTClient) Unsubscribe(broker, username, deviceToken string) error {
 	defer m.Unlock()
 
 	if _, ok := m.Subscriptions[key]; ok {
-		if _, ok := m.Subscriptions[key][deviceToken]; ok {
-			delete(m.Subscriptions[key], deviceToken)
+		if _, ok := m.Subscriptions[key][deviceToken.Reveal()]; ok {
+			delete(m.Subscriptions[key], deviceToken.Reveal())
 			if len(m.Subscriptions[key]) == 0 {
 				delete(m.Subscriptions, key)
 			}
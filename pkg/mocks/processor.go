@@ -1,6 +1,8 @@
 package mocks
 
 import (
+	"context"
+
 	"github.com/DECODEproject/iotencoder/pkg/postgres"
 )
 
@@ -10,6 +12,10 @@ func NewProcessor() *Processor {
 	return &Processor{}
 }
 
-func (p *Processor) Process(device *postgres.Device, payload []byte) error {
+func (p *Processor) Process(ctx context.Context, device *postgres.Device, payload []byte) error {
+	return nil
+}
+
+func (p *Processor) DryRun(ctx context.Context, stream *postgres.Stream) error {
 	return nil
 }
@@ -0,0 +1,329 @@
+package mqtt
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/DECODEproject/iotencoder/pkg/events"
+	"github.com/DECODEproject/iotencoder/pkg/logger/level"
+)
+
+// failoverRetryInterval is how long we wait after failing to connect to any of
+// our brokers before trying them all again.
+const failoverRetryInterval = 5 * time.Second
+
+var (
+	// FailoverCounter is a prometheus counter vec recording the number of times
+	// we have switched broker, labelled by the broker we switched from and to.
+	FailoverCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "mqtt_failovers",
+			Help:      "Count of switches between MQTT brokers on failover or failback",
+		},
+		[]string{"from", "to"},
+	)
+
+	// ActiveBrokerGauge is a prometheus gauge vec which is 1 for the broker to
+	// which we are connected, and 0 for its standbys.
+	ActiveBrokerGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "mqtt_active_broker",
+			Help:      "Whether each MQTT broker is the one to which we are connected",
+		},
+		[]string{"broker"},
+	)
+)
+
+// failoverConn is a connection to the first reachable broker of an ordered
+// list, the primary followed by its standbys. If the connection is lost we
+// fail over to the next reachable broker, subscribing again to every topic,
+// and while connected to a standby we periodically probe the brokers
+// preferred to it so that we fail back once they recover. Messages from every
+// broker are passed to the deliverer for the primary's client key, so our
+// routes are unaffected.
+type failoverConn struct {
+	client   *client
+	brokers  []string
+	username string
+	key      string
+	failback time.Duration
+
+	// switching serialises failing over and failing back
+	switching sync.Mutex
+
+	sync.Mutex
+	active     conn
+	index      int
+	generation int
+	filters    map[string]byte
+	closed     bool
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+// connectFailover connects to the first reachable of the given broker and our
+// failover brokers.
+func (c *client) connectFailover(broker, username, key string) (conn, error) {
+	f := &failoverConn{
+		client:   c,
+		brokers:  append([]string{broker}, c.failover...),
+		username: username,
+		key:      key,
+		failback: c.failback,
+		filters:  make(map[string]byte),
+		quit:     make(chan struct{}),
+	}
+
+	err := f.connectFrom(0, len(f.brokers))
+	if err != nil {
+		return nil, err
+	}
+
+	if f.failback > 0 {
+		f.wg.Add(1)
+		go f.probe()
+	}
+
+	return f, nil
+}
+
+func (f *failoverConn) subscribe(filter string, qos byte) error {
+	f.Lock()
+	defer f.Unlock()
+
+	f.filters[filter] = qos
+
+	if f.active == nil {
+		return errors.New("not connected to any broker")
+	}
+
+	return f.active.subscribe(filter, qos)
+}
+
+func (f *failoverConn) unsubscribe(filter string) error {
+	f.Lock()
+	defer f.Unlock()
+
+	delete(f.filters, filter)
+
+	if f.active == nil {
+		return nil
+	}
+
+	return f.active.unsubscribe(filter)
+}
+
+func (f *failoverConn) connected() bool {
+	f.Lock()
+	defer f.Unlock()
+
+	return f.active != nil && f.active.connected()
+}
+
+func (f *failoverConn) disconnect() {
+	f.Lock()
+	if f.closed {
+		f.Unlock()
+		return
+	}
+	f.closed = true
+	active := f.active
+	f.active = nil
+	f.Unlock()
+
+	close(f.quit)
+	f.wg.Wait()
+
+	if active != nil {
+		active.disconnect()
+	}
+}
+
+// connectFrom tries each of n brokers in order starting from the given index,
+// wrapping around the list, and switches to the first to which we connect.
+func (f *failoverConn) connectFrom(start, n int) error {
+	var err error
+
+	for i := 0; i < n; i++ {
+		index := (start + i) % len(f.brokers)
+
+		err = f.switchTo(index)
+		if err == nil {
+			return nil
+		}
+
+		level.Warn(f.client.logger).Log("msg", "failed to connect to broker", "broker", f.brokers[index], "err", err)
+	}
+
+	return err
+}
+
+// switchTo connects to the broker with the given index, subscribes to every
+// topic to which we are subscribed, and makes it our active connection,
+// disconnecting any previous connection.
+func (f *failoverConn) switchTo(index int) error {
+	f.switching.Lock()
+	defer f.switching.Unlock()
+
+	f.Lock()
+	if f.active != nil && f.index == index {
+		f.Unlock()
+		return nil
+	}
+	generation := f.generation + 1
+	f.Unlock()
+
+	next, err := f.client.open(f.brokers[index], f.username, f.key, func(err error) {
+		f.lost(generation, err)
+	})
+	if err != nil {
+		return err
+	}
+
+	f.Lock()
+
+	if f.closed {
+		f.Unlock()
+		next.disconnect()
+		return errors.New("connection closed")
+	}
+
+	for filter, qos := range f.filters {
+		err = next.subscribe(filter, qos)
+		if err != nil {
+			f.Unlock()
+			next.disconnect()
+			return errors.Wrap(err, "failed to subscribe again")
+		}
+	}
+
+	// the connection may have been lost before it became our active connection,
+	// in which case its loss was ignored
+	if !next.connected() {
+		f.Unlock()
+		next.disconnect()
+		return errors.New("connection lost while subscribing")
+	}
+
+	previous, from := f.active, f.index
+
+	f.active = next
+	f.index = index
+	f.generation = generation
+
+	for i, broker := range f.brokers {
+		if i == index {
+			ActiveBrokerGauge.WithLabelValues(broker).Set(1)
+		} else {
+			ActiveBrokerGauge.WithLabelValues(broker).Set(0)
+		}
+	}
+
+	if previous != nil || from != index {
+		FailoverCounter.WithLabelValues(f.brokers[from], f.brokers[index]).Inc()
+
+		f.client.logger.Log("msg", "switched broker", "from", f.brokers[from], "to", f.brokers[index], "subscriptions", len(f.filters))
+	}
+
+	// a connection made while we have none is the restoration of our
+	// subscriptions following the loss of a previous connection
+	if previous == nil && generation > 1 {
+		f.client.events.Publish(&events.Event{
+			Type:   events.SubscriptionRestored,
+			Broker: f.brokers[index],
+		})
+	}
+
+	f.Unlock()
+
+	if previous != nil {
+		previous.disconnect()
+	}
+
+	return nil
+}
+
+// lost is called when the connection of the given generation is lost. If it
+// is still our active connection we fail over, trying every broker in turn
+// starting from the next, until one can be connected to.
+func (f *failoverConn) lost(generation int, err error) {
+	f.Lock()
+	if f.closed || generation != f.generation {
+		f.Unlock()
+		return
+	}
+	f.active = nil
+	index := f.index
+	f.wg.Add(1)
+	f.Unlock()
+
+	level.Error(f.client.logger).Log("msg", "connection lost, failing over", "broker", f.brokers[index], "err", err)
+
+	event := &events.Event{
+		Type:   events.SubscriptionLost,
+		Broker: f.brokers[index],
+	}
+	if err != nil {
+		event.Err = err.Error()
+	}
+	f.client.events.Publish(event)
+
+	go func() {
+		defer f.wg.Done()
+
+		for {
+			err := f.connectFrom(index+1, len(f.brokers))
+			if err == nil {
+				return
+			}
+
+			level.Error(f.client.logger).Log("msg", "failed to connect to any broker", "err", err)
+
+			select {
+			case <-time.After(failoverRetryInterval):
+			case <-f.quit:
+				return
+			}
+		}
+	}()
+}
+
+// probe is run in a goroutine and on each tick of our failback interval, if
+// we are connected to a standby, tries to connect to the brokers preferred to
+// it, failing back to the first which is reachable.
+func (f *failoverConn) probe() {
+	defer f.wg.Done()
+
+	ticker := time.NewTicker(f.failback)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			f.Lock()
+			index, connected := f.index, f.active != nil
+			f.Unlock()
+
+			// while disconnected we are already trying every broker
+			if !connected || index == 0 {
+				continue
+			}
+
+			for i := 0; i < index; i++ {
+				if f.switchTo(i) == nil {
+					break
+				}
+			}
+		case <-f.quit:
+			return
+		}
+	}
+}
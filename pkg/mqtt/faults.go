@@ -0,0 +1,111 @@
+package mqtt
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/DECODEproject/iotencoder/pkg/logger/level"
+)
+
+// ErrInjectedDisconnect is the error with which a connection whose loss was
+// simulated is reported as lost.
+var ErrInjectedDisconnect = errors.New("connection loss injected")
+
+// Faults is the interface we use to decide whether to simulate the loss of our
+// connection to a broker as a message is received on it. It is satisfied by
+// the chaos.Injector type.
+type Faults interface {
+	DropConnection() bool
+}
+
+// faultyConn is a connection which may be dropped as messages are received on
+// it, as if the connection to the broker had been lost. The message on which
+// the connection is dropped, and any received after it, are not delivered.
+type faultyConn struct {
+	conn
+	faults Faults
+	lost   func(err error)
+
+	sync.Mutex
+	dropped bool
+}
+
+// openFaulty opens a connection to the given broker which may be dropped as
+// each message is received.
+func (c *client) openFaulty(broker, username string, deliver func(topic string, payload []byte, properties map[string]string, ack func()), lost func(err error)) (conn, error) {
+	f := &faultyConn{
+		faults: c.faults,
+		lost:   lost,
+	}
+
+	inner, err := c.openConn(broker, username, func(topic string, payload []byte, properties map[string]string, ack func()) {
+		if f.drop() {
+			level.Warn(c.logger).Log("msg", "dropping connection", "broker", broker, "err", ErrInjectedDisconnect)
+
+			if ack != nil {
+				ack()
+			}
+			return
+		}
+
+		deliver(topic, payload, properties, ack)
+	}, f.connectionLost)
+	if err != nil {
+		return nil, err
+	}
+
+	f.Lock()
+	f.conn = inner
+	f.Unlock()
+
+	return f, nil
+}
+
+// drop returns true if the message being received should not be delivered, as
+// the connection has been dropped. The connection is dropped if a fault is
+// injected, in which case it is disconnected and reported as lost. Messages
+// received before we have finished connecting are always delivered.
+func (f *faultyConn) drop() bool {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.dropped {
+		return true
+	}
+
+	if f.conn == nil || !f.faults.DropConnection() {
+		return false
+	}
+
+	f.dropped = true
+
+	// we are called from the client library's message handler, from which it
+	// cannot be disconnected
+	go func() {
+		f.conn.disconnect()
+		f.lost(ErrInjectedDisconnect)
+	}()
+
+	return true
+}
+
+// connectionLost is called when the connection is really lost, which is
+// reported unless it was already reported as dropped.
+func (f *faultyConn) connectionLost(err error) {
+	f.Lock()
+	dropped := f.dropped
+	f.dropped = true
+	f.Unlock()
+
+	if !dropped {
+		f.lost(err)
+	}
+}
+
+func (f *faultyConn) connected() bool {
+	f.Lock()
+	defer f.Unlock()
+
+	return !f.dropped && f.conn.connected()
+}
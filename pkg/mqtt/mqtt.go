@@ -1,22 +1,56 @@
 package mqtt
 
 import (
+	"crypto/sha256"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 	kitlog "github.com/go-kit/kit/log"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 
+	"github.com/DECODEproject/iotencoder/pkg/events"
+	"github.com/DECODEproject/iotencoder/pkg/logger"
+	"github.com/DECODEproject/iotencoder/pkg/logger/level"
+	"github.com/DECODEproject/iotencoder/pkg/secret"
 	"github.com/DECODEproject/iotencoder/pkg/version"
 )
 
+const (
+	// maxPending is the maximum number of messages held for topics to which we
+	// have not yet subscribed when using a persistent session.
+	maxPending = 10000
+
+	// maxQueued is the maximum number of messages queued while our in-flight
+	// window is full. Messages are only acknowledged once they are done with,
+	// so the broker's own limit on unacknowledged messages ordinarily stops it
+	// delivering this many.
+	maxQueued = 10000
+
+	// V3 is the default protocol version, MQTT 3.1.1.
+	V3 = 3
+
+	// V5 is the MQTT 5 protocol version, with which messages may carry user
+	// properties.
+	V5 = 5
+
+	// RetainedProperty is the key added to the properties of a message with
+	// the value "true" if the broker held it as the retained message of its
+	// topic, and delivered it because we subscribed rather than because the
+	// device published it. It is removed from any user properties the message
+	// carries, so may not be set by a device.
+	RetainedProperty = "mqtt-retained"
+)
+
 var (
-	// mqttClientID holds a reference to the application ID we send to a broker
-	// when connecting
-	mqttClientID = fmt.Sprintf("%s-DECODE", version.BinaryName)
+	// DefaultClientIDPrefix is the client ID we send to a broker when connecting
+	// unless configured otherwise
+	DefaultClientIDPrefix = fmt.Sprintf("%s-DECODE", version.BinaryName)
 
 	// MessageCounter is a prometheus counter vec recording the number of received
 	// messages, labelled by topic
@@ -29,10 +63,26 @@ var (
 		},
 		[]string{"broker"},
 	)
+
+	// InflightGauge is a prometheus gauge recording the number of messages
+	// delivered to our callbacks but not yet processed, which is bounded by the
+	// configured in-flight window.
+	InflightGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "mqtt_messages_inflight",
+			Help:      "Count of MQTT messages received but not yet processed",
+		},
+	)
 )
 
-// Callback is a function we pass in to subscribe to a feed.
-type Callback func(topic string, payload []byte)
+// Callback is a function we pass in to subscribe to a feed. The user properties
+// of the message are passed if we speak MQTT 5 and it has any, along with
+// RetainedProperty if the message was retained, otherwise they are nil. done must be called once the message has been processed, which
+// frees its place in the in-flight window, and need not be called if the
+// message is abandoned, e.g. as we are stopping.
+type Callback func(topic string, payload []byte, properties map[string]string, done func())
 
 // Client is the main interface for our MQTT module. It exposes a single method
 // Subscribe which attempts to subscribe to the given topic on the specified
@@ -43,36 +93,175 @@ type Client interface {
 	// function is called the client will have set up a subscription for the given
 	// details with received events being written to the datastore. Returns an
 	// error if we were unable to subscribe for any reason.
-	Subscribe(broker, username, deviceToken string, callback Callback) error
+	Subscribe(broker, username string, deviceToken secret.Secret, callback Callback) error
 
 	// Unsubscribe takes a broker and a device token, and attempts to remove the
 	// subscription from the specified broker.
-	Unsubscribe(broker, username, deviceToken string) error
+	Unsubscribe(broker, username string, deviceToken secret.Secret) error
+}
+
+// TopicSubscriber is an optional interface of a Client able to subscribe to
+// topics other than the default topic of a device, for devices whose firmware
+// publishes under a different topic scheme.
+type TopicSubscriber interface {
+	// SubscribeTopic subscribes to the given topic on the specified broker, as
+	// Subscribe does to the default topic of a device.
+	SubscribeTopic(broker, username, topic string, callback Callback) error
+
+	// UnsubscribeTopic removes the subscription to the given topic from the
+	// specified broker.
+	UnsubscribeTopic(broker, username, topic string) error
+}
+
+// Config is a struct used to pass in configuration when creating the client.
+// If PersistentSession is set the broker retains our subscriptions and queues
+// QoS 1 messages while we are disconnected, and if StorePath is set messages in
+// flight are persisted to disk so they survive a restart. If SharedGroup is
+// set we subscribe to topics as a member of that shared subscription group, so
+// that the broker delivers each message to only one of the group's members.
+// ProtocolVersion selects V3 or V5, defaulting to V3. If Inflight is set at
+// most that many messages are delivered but not yet done with at once, and we
+// subscribe at QoS 1 and acknowledge messages only once they are done with, so
+// that the broker holds further messages for us rather than us buffering them.
+// KeepAlive is the interval at which we ping a broker while the connection is
+// otherwise idle, and for MQTT 3.1.1 how long we wait for its response before
+// treating the connection as lost, defaulting to 30 seconds. If Failover is set it lists standby brokers, in order
+// of preference, to which connections fail over if the broker we subscribe to
+// is unreachable, and while connected to a standby those preferred to it are
+// probed every FailbackInterval so that we fail back once they recover. Faults
+// is optional, and if set is consulted as each message is received to decide
+// whether to simulate the loss of the connection on which it was received.
+// Events is optional, and if set the loss of a connection to a broker, and the
+// restoration of our subscriptions on failover, are published to it.
+type Config struct {
+	ClientIDPrefix    string
+	PersistentSession bool
+	StorePath         string
+	SharedGroup       string
+	ProtocolVersion   int
+	Inflight          int
+	KeepAlive         time.Duration
+	Failover          []string
+	FailbackInterval  time.Duration
+	Faults            Faults
+	Events            *events.Bus
+	Verbose           bool
+}
+
+// conn is a connection to a single broker, over whichever version of the
+// protocol we speak. Messages received are passed to the deliver function the
+// connection was created with, rather than per subscription.
+type conn interface {
+	subscribe(filter string, qos byte) error
+	unsubscribe(filter string) error
+	connected() bool
+	disconnect()
+}
+
+// message is a message held until we subscribe to its topic.
+type message struct {
+	topic      string
+	payload    []byte
+	properties map[string]string
+}
+
+// delivery is a message received on a topic to which we are subscribed, queued
+// until there is a place for it in the in-flight window. ack is nil unless the
+// message is acknowledged manually.
+type delivery struct {
+	message
+	cb  Callback
+	ack func()
 }
 
 // client abstracts our connection to one or more MQTT brokers, it allows new
 // subscriptions to be made to topics, and somehow emits received events to be
 // written on to the datastore.
 type client struct {
-	logger  kitlog.Logger
-	verbose bool
+	logger            kitlog.Logger
+	verbose           bool
+	clientIDPrefix    string
+	persistentSession bool
+	storePath         string
+	sharedGroup       string
+	protocolVersion   int
+	inflight          int
+	keepAlive         time.Duration
+	failover          []string
+	failback          time.Duration
+	faults            Faults
+	events            *events.Bus
+
+	// window holds a value for each message in flight, if their number is
+	// limited, in which case messages wait in queue for a place in it until we
+	// stop
+	window   chan struct{}
+	queue    chan *delivery
+	stop     chan struct{}
+	stopOnce sync.Once
 
 	sync.RWMutex
-	clients map[string]mqtt.Client
+	clients map[string]conn
+
+	// callbacks for the topics to which we are subscribed, and for persistent
+	// sessions pending messages, keyed by the client key and topic, as the
+	// broker may deliver messages queued while we were disconnected before we
+	// have subscribed again
+	routesMu  sync.Mutex
+	callbacks map[string]Callback
+	pending   map[string][]*message
+	npending  int
 }
 
 // NewClient creates a new client that is intended to support connections to
-// multiple brokers if required. Takes as input our logger.
-func NewClient(logger kitlog.Logger, verbose bool) Client {
+// multiple brokers if required. Takes as input our configuration and logger.
+func NewClient(config *Config, logger kitlog.Logger) Client {
 	logger = kitlog.With(logger, "module", "mqtt")
 
-	logger.Log("msg", "creating mqtt client instance")
+	protocolVersion := config.ProtocolVersion
+	if protocolVersion == 0 {
+		protocolVersion = V3
+	}
+
+	logger.Log("msg", "creating mqtt client instance", "protocol_version", protocolVersion, "persistent_session", config.PersistentSession)
+
+	clientIDPrefix := config.ClientIDPrefix
+	if clientIDPrefix == "" {
+		clientIDPrefix = DefaultClientIDPrefix
+	}
 
-	return &client{
-		logger:  logger,
-		verbose: verbose,
-		clients: make(map[string]mqtt.Client),
+	var window chan struct{}
+	if config.Inflight > 0 {
+		window = make(chan struct{}, config.Inflight)
 	}
+
+	c := &client{
+		logger:            logger,
+		verbose:           config.Verbose,
+		clientIDPrefix:    clientIDPrefix,
+		persistentSession: config.PersistentSession,
+		storePath:         config.StorePath,
+		sharedGroup:       config.SharedGroup,
+		protocolVersion:   protocolVersion,
+		inflight:          config.Inflight,
+		keepAlive:         config.KeepAlive,
+		failover:          config.Failover,
+		failback:          config.FailbackInterval,
+		faults:            config.Faults,
+		events:            config.Events,
+		window:            window,
+		queue:             make(chan *delivery, maxQueued),
+		stop:              make(chan struct{}),
+		clients:           make(map[string]conn),
+		callbacks:         make(map[string]Callback),
+		pending:           make(map[string][]*message),
+	}
+
+	if window != nil {
+		go c.pump()
+	}
+
+	return c
 }
 
 // Stop disconnects all currently connected clients, and clears the map of
@@ -84,36 +273,67 @@ func (c *client) Stop() error {
 	defer c.Unlock()
 
 	for broker, client := range c.clients {
-		client.Disconnect(500)
+		client.disconnect()
 		delete(c.clients, broker)
 	}
 
+	// messages still queued are abandoned unacknowledged, so are delivered again
+	// by a broker holding a persistent session for us
+	c.stopOnce.Do(func() {
+		close(c.stop)
+	})
+
+	return nil
+}
+
+// Check returns an error if the connection to any broker to which we have
+// connected is currently lost. Lost connections are reestablished
+// automatically.
+func (c *client) Check() error {
+	c.RLock()
+	defer c.RUnlock()
+
+	for key, client := range c.clients {
+		if !client.connected() {
+			return errors.Errorf("not connected to broker %s", strings.SplitN(key, ":", 2)[0])
+		}
+	}
+
 	return nil
 }
 
 // Subscribe attempts to create a subscription for the given topic, on the given
 // broker. This method will create a new connection to particular broker if one
 // does not already exist, but will reuse an existing connection.
-func (c *client) Subscribe(broker, username, deviceToken string, cb Callback) error {
+func (c *client) Subscribe(broker, username string, deviceToken secret.Secret, cb Callback) error {
 	if c.verbose {
-		c.logger.Log("deviceToken", deviceToken, "broker", broker, "msg", "subscribing")
+		level.Debug(c.logger).Log("device_hash", logger.HashToken(deviceToken), "broker", broker, "msg", "subscribing")
 	}
 
-	var handler mqtt.MessageHandler = func(client mqtt.Client, message mqtt.Message) {
-		MessageCounter.With(prometheus.Labels{"broker": broker}).Inc()
-
-		cb(message.Topic(), message.Payload())
-	}
+	return c.SubscribeTopic(broker, username, Topic(deviceToken), cb)
+}
 
+// SubscribeTopic attempts to create a subscription for the given topic on the
+// given broker, creating a connection to the broker if one does not already
+// exist.
+func (c *client) SubscribeTopic(broker, username, topic string, cb Callback) error {
 	client, err := c.getClient(broker, username)
 	if err != nil {
 		return errors.Wrap(err, "failed to get client")
 	}
 
-	topic := buildTopic(deviceToken)
+	key := clientKey(broker, username)
 
-	if token := client.Subscribe(topic, 0, handler); token.Wait() && token.Error() != nil {
-		return token.Error()
+	c.route(key, topic, func(topic string, payload []byte, properties map[string]string, done func()) {
+		MessageCounter.With(prometheus.Labels{"broker": broker}).Inc()
+
+		cb(topic, payload, properties, done)
+	})
+
+	err = client.subscribe(c.subscription(topic), c.QoS())
+	if err != nil {
+		c.unroute(key, topic)
+		return err
 	}
 
 	return nil
@@ -122,35 +342,272 @@ func (c *client) Subscribe(broker, username, deviceToken string, cb Callback) er
 // Unsubscribe attempts to unsubscribe to the given topic published on the
 // specified broker. We should only unsubscribe when no streams remain for a
 // device. Returns any error that occurs while trying to unsubscribe.
-func (c *client) Unsubscribe(broker, username, deviceToken string) error {
+func (c *client) Unsubscribe(broker, username string, deviceToken secret.Secret) error {
 	if c.verbose {
-		c.logger.Log("broker", broker, "deviceToken", deviceToken, "msg", "unsubscribing")
+		level.Debug(c.logger).Log("broker", broker, "device_hash", logger.HashToken(deviceToken), "msg", "unsubscribing")
 	}
 
+	return c.UnsubscribeTopic(broker, username, Topic(deviceToken))
+}
+
+// UnsubscribeTopic attempts to unsubscribe from the given topic on the given
+// broker.
+func (c *client) UnsubscribeTopic(broker, username, topic string) error {
 	client, err := c.getClient(broker, username)
 	if err != nil {
 		return errors.Wrap(err, "failed to get client")
 	}
 
-	topic := buildTopic(deviceToken)
+	c.unroute(clientKey(broker, username), topic)
 
-	if token := client.Unsubscribe(topic); token.Wait() && token.Error() != nil {
-		return token.Error()
+	return client.unsubscribe(c.subscription(topic))
+}
+
+// QoS returns the quality of service at which we subscribe to device topics.
+func (c *client) QoS() byte {
+	if c.persistentSession || c.inflight > 0 {
+		// messages are only queued for us while disconnected, or while our
+		// in-flight window is full, at QoS 1
+		return 1
 	}
 
-	return nil
+	return 0
+}
+
+// subscription returns the topic filter with which we subscribe to the given
+// topic, which if we are a member of a shared subscription group is prefixed by
+// the group. Messages are still received on the topic itself.
+func (c *client) subscription(topic string) string {
+	if c.sharedGroup == "" {
+		return topic
+	}
+
+	return SharedTopic(c.sharedGroup, topic)
+}
+
+// route records the callback for messages received on the given topic from
+// the client with the given key, and passes it any messages held for the
+// topic. Held messages were acknowledged when they were received, so take no
+// place in the in-flight window.
+func (c *client) route(key, topic string, cb Callback) {
+	c.routesMu.Lock()
+	c.callbacks[key+topic] = cb
+	held := c.pending[key+topic]
+	delete(c.pending, key+topic)
+	c.npending -= len(held)
+	c.routesMu.Unlock()
+
+	for _, m := range held {
+		cb(m.topic, m.payload, m.properties, func() {})
+	}
+}
+
+// unroute removes the callback for the given topic from the client with the
+// given key.
+func (c *client) unroute(key, topic string) {
+	c.routesMu.Lock()
+	defer c.routesMu.Unlock()
+
+	delete(c.callbacks, key+topic)
+}
+
+// deliverer returns the function to which the client with the given key
+// passes the messages it receives, which calls the callback for the message's
+// topic. With a persistent session messages for topics to which we are not
+// subscribed were queued by the broker while we were disconnected, and are
+// received before we have subscribed again, so they are held until we do.
+//
+// If the connection acknowledges messages manually it passes an ack function,
+// which is called once the callback is done with the message. Messages which
+// are held or dropped are acknowledged straight away, as an unacknowledged
+// message holds back the acknowledgement of those received after it.
+//
+// The function is called by the client library as it reads from the broker, so
+// must not block: with an in-flight window messages are queued for pump rather
+// than waiting here for a place in the window, as that would stop the library
+// reading the responses to its keep alive pings, and so lose the connection.
+func (c *client) deliverer(key string) func(topic string, payload []byte, properties map[string]string, ack func()) {
+	return func(topic string, payload []byte, properties map[string]string, ack func()) {
+		c.routesMu.Lock()
+
+		cb, ok := c.callbacks[key+topic]
+		if !ok && c.persistentSession {
+			if c.npending < maxPending {
+				c.pending[key+topic] = append(c.pending[key+topic], &message{
+					topic:      topic,
+					payload:    payload,
+					properties: properties,
+				})
+				c.npending++
+			} else {
+				level.Warn(c.logger).Log("msg", "dropping message received before subscribing", "topic", topic)
+			}
+		}
+
+		c.routesMu.Unlock()
+
+		if !ok {
+			if ack != nil {
+				ack()
+			}
+			return
+		}
+
+		if c.window == nil {
+			InflightGauge.Inc()
+			cb(topic, payload, properties, c.release(ack))
+			return
+		}
+
+		select {
+		case c.queue <- &delivery{
+			message: message{
+				topic:      topic,
+				payload:    payload,
+				properties: properties,
+			},
+			cb:  cb,
+			ack: ack,
+		}:
+		default:
+			level.Warn(c.logger).Log("msg", "in-flight queue full, dropping message", "topic", topic)
+			if ack != nil {
+				ack()
+			}
+		}
+	}
+}
+
+// pump is run in a goroutine if the number of messages in flight is limited,
+// and passes queued messages to their callbacks in the order they were
+// received as places in the window free up, until we stop.
+func (c *client) pump() {
+	for {
+		select {
+		case d := <-c.queue:
+			if !c.acquire() {
+				return
+			}
+
+			d.cb(d.topic, d.payload, d.properties, c.release(d.ack))
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// acquire takes a place in the in-flight window for a message, waiting while
+// the window is full. Returns false if we stop while waiting.
+func (c *client) acquire() bool {
+	select {
+	case c.window <- struct{}{}:
+	case <-c.stop:
+		return false
+	}
+
+	InflightGauge.Inc()
+
+	return true
+}
+
+// release returns the function called once a message is done with, which frees
+// its place in the in-flight window and acknowledges it if ack is not nil. The
+// returned function may safely be called more than once.
+func (c *client) release(ack func()) func() {
+	var once sync.Once
+
+	return func() {
+		once.Do(func() {
+			if c.window != nil {
+				<-c.window
+			}
+
+			InflightGauge.Dec()
+
+			if ack != nil {
+				ack()
+			}
+		})
+	}
 }
 
-// connect is a helper function that creates a new mqtt.Client instance that is
-// connected to the passed in broker.
-func connect(broker, username string, logger kitlog.Logger, verbose bool) (mqtt.Client, error) {
-	opts, err := createClientOptions(broker, username, logger, verbose)
+// connect is a helper function that creates a new connection to the passed in
+// broker, speaking the configured protocol version. If failover brokers are
+// configured the connection fails over between them, otherwise losing the
+// connection exits the process so that we are restarted.
+func (c *client) connect(broker, username string) (conn, error) {
+	key := clientKey(broker, username)
+
+	if len(c.failover) > 0 {
+		return c.connectFailover(broker, username, key)
+	}
+
+	return c.open(broker, username, key, c.exit)
+}
+
+// exit is called when a connection without failover is lost.
+func (c *client) exit(err error) {
+	level.Error(c.logger).Log(
+		"msg", "connection lost",
+		"err", err,
+	)
+	os.Exit(1)
+}
+
+// open creates a new connection to the given broker, speaking the configured
+// protocol version, which passes the messages it receives to the deliverer for
+// the given client key. lost is called if the connection is later lost. If we
+// inject faults the connection may be dropped as messages are received.
+func (c *client) open(broker, username, key string, lost func(err error)) (conn, error) {
+	deliver := c.deliverer(key)
+
+	if c.faults != nil {
+		return c.openFaulty(broker, username, deliver, lost)
+	}
+
+	return c.openConn(broker, username, deliver, lost)
+}
+
+// openConn creates a new connection to the given broker, speaking the
+// configured protocol version, which passes the messages it receives to the
+// given deliver function.
+func (c *client) openConn(broker, username string, deliver func(topic string, payload []byte, properties map[string]string, ack func()), lost func(err error)) (conn, error) {
+	if c.protocolVersion == V5 {
+		return c.connectV5(broker, username, deliver, lost)
+	}
+
+	logger, verbose := c.logger, c.verbose
+
+	opts, err := createClientOptions(broker, username, logger, verbose, lost)
 	if err != nil {
 		return nil, err
 	}
 
+	opts.SetClientID(clientID(c.clientIDPrefix, username))
+	opts.SetCleanSession(!c.persistentSession)
+
+	if c.keepAlive > 0 {
+		opts.SetKeepAlive(c.keepAlive)
+		opts.SetPingTimeout(c.keepAlive)
+	}
+
+	// with an in-flight window we acknowledge messages only once they have been
+	// processed, so while the window is full the broker's own in-flight limit
+	// stops it delivering further messages
+	manual := c.inflight > 0
+	opts.SetAutoAckDisabled(manual)
+
+	v := &v3Conn{deliver: deliver, manual: manual}
+	opts.SetDefaultPublishHandler(v.handle)
+
+	if c.storePath != "" {
+		// each connection has its own session, so needs its own store
+		store := clientKey(broker, username)
+		opts.SetStore(mqtt.NewFileStore(filepath.Join(c.storePath, fmt.Sprintf("%x", sha256.Sum256([]byte(store))))))
+	}
+
 	if verbose {
-		logger.Log("broker", broker, "msg", "creating client")
+		level.Debug(logger).Log("broker", broker, "msg", "creating client")
 	}
 
 	client := mqtt.NewClient(opts)
@@ -159,24 +616,68 @@ func connect(broker, username string, logger kitlog.Logger, verbose bool) (mqtt.
 	}
 
 	if verbose {
-		logger.Log("broker", broker, "msg", "mqtt connected")
+		level.Debug(logger).Log("broker", broker, "msg", "mqtt connected")
 	}
 
-	return client, nil
+	v.client = client
+
+	return v, nil
+}
+
+// v3Conn is a connection to a broker speaking MQTT 3.1.1.
+type v3Conn struct {
+	client  mqtt.Client
+	deliver func(topic string, payload []byte, properties map[string]string, ack func())
+	manual  bool
+}
+
+// handle passes a message received from the broker to our deliver function,
+// along with the function acknowledging it if we do so manually.
+func (v *v3Conn) handle(client mqtt.Client, m mqtt.Message) {
+	var ack func()
+	if v.manual {
+		ack = m.Ack
+	}
+
+	v.deliver(m.Topic(), m.Payload(), retained(nil, m.Retained()), ack)
+}
+
+func (v *v3Conn) subscribe(filter string, qos byte) error {
+	if token := v.client.Subscribe(filter, qos, v.handle); token.Wait() && token.Error() != nil {
+		return token.Error()
+	}
+
+	return nil
+}
+
+func (v *v3Conn) unsubscribe(filter string) error {
+	if token := v.client.Unsubscribe(filter); token.Wait() && token.Error() != nil {
+		return token.Error()
+	}
+
+	return nil
+}
+
+func (v *v3Conn) connected() bool {
+	return v.client.IsConnectionOpen()
+}
+
+func (v *v3Conn) disconnect() {
+	v.client.Disconnect(500)
 }
 
 // createClientOptions initializes a set of ClientOptions for connecting to an
-// MQTT broker.
-func createClientOptions(broker, username string, logger kitlog.Logger, verbose bool) (*mqtt.ClientOptions, error) {
+// MQTT broker, calling lost if the connection is lost.
+func createClientOptions(broker, username string, logger kitlog.Logger, verbose bool, lost func(err error)) (*mqtt.ClientOptions, error) {
 	if verbose {
-		logger.Log("broker", broker, "msg", "configuring client", "username", username)
+		level.Debug(logger).Log("broker", broker, "msg", "configuring client", "username", username)
 	}
 
 	opts := mqtt.NewClientOptions()
 	opts.AddBroker(broker)
 	opts.SetUsername(username)
-	opts.SetClientID(mqttClientID)
-	opts.SetAutoReconnect(true)
+	// lost connections are handled by lost, which either exits or fails over
+	opts.SetAutoReconnect(false)
 
 	var onConnectHandler mqtt.OnConnectHandler = func(client mqtt.Client) {
 		logger.Log(
@@ -188,11 +689,7 @@ func createClientOptions(broker, username string, logger kitlog.Logger, verbose
 	opts.SetOnConnectHandler(onConnectHandler)
 
 	var onConnectionLostHandler mqtt.ConnectionLostHandler = func(client mqtt.Client, err error) {
-		logger.Log(
-			"msg", "connection lost",
-			"err", err,
-		)
-		os.Exit(1)
+		lost(err)
 	}
 
 	opts.SetConnectionLostHandler(onConnectionLostHandler)
@@ -204,11 +701,11 @@ func createClientOptions(broker, username string, logger kitlog.Logger, verbose
 // to return a client from the in memory process, but if one does not exist we
 // use `connect` in order to make a new connection. Once a connnection is made
 // it will be stored in memory for use for other subscriptions.
-func (c *client) getClient(broker, username string) (mqtt.Client, error) {
-	var client mqtt.Client
+func (c *client) getClient(broker, username string) (conn, error) {
+	var client conn
 	var err error
 
-	key := fmt.Sprintf("%s:%s", broker, username)
+	key := clientKey(broker, username)
 
 	// attempt to get client, note the use of RLock here which takes a read only
 	// lock on the map containing clients.
@@ -216,26 +713,76 @@ func (c *client) getClient(broker, username string) (mqtt.Client, error) {
 	client, ok := c.clients[key]
 	c.RUnlock()
 
-	if !ok {
-		client, err = connect(broker, username, c.logger, c.verbose)
-		if err != nil {
-			return nil, errors.Wrap(err, "failed to connect to broker")
-		}
+	if ok {
+		return client, nil
+	}
 
-		if c.verbose {
-			c.logger.Log("broker", broker, "msg", "storing client")
-		}
+	// subscriptions may be created concurrently, so check again while holding
+	// the write lock to avoid connecting to the same broker twice
+	c.Lock()
+	defer c.Unlock()
+
+	client, ok = c.clients[key]
+	if ok {
+		return client, nil
+	}
 
-		c.Lock()
-		c.clients[key] = client
-		c.Unlock()
+	client, err = c.connect(broker, username)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to connect to broker")
 	}
 
+	if c.verbose {
+		level.Debug(c.logger).Log("broker", broker, "msg", "storing client")
+	}
+
+	c.clients[key] = client
+
 	return client, nil
 }
 
-// buildTopic is a helper function that returns a topic string for the given
-// deviceToken.
-func buildTopic(deviceToken string) string {
-	return fmt.Sprintf("device/sck/%s/readings", deviceToken)
+// SharedTopic returns the topic filter used to subscribe to the given topic as
+// a member of the given shared subscription group.
+func SharedTopic(group, topic string) string {
+	return fmt.Sprintf("$share/%s/%s", group, topic)
+}
+
+// clientKey returns the key under which we hold the client connected to the
+// given broker with the given username.
+func clientKey(broker, username string) string {
+	return fmt.Sprintf("%s:%s", broker, username)
+}
+
+// clientID returns the client ID sent to a broker when connecting with the
+// given username. It must be stable across restarts for a persistent session
+// to be resumed, and unique for each username so that connections to the same
+// broker don't replace one another.
+func clientID(prefix, username string) string {
+	if username == "" {
+		return prefix
+	}
+
+	return fmt.Sprintf("%s-%s", prefix, username)
+}
+
+// Topic returns the topic string on which readings for the given deviceToken
+// are published.
+func Topic(deviceToken secret.Secret) string {
+	return fmt.Sprintf("device/sck/%s/readings", deviceToken.Reveal())
+}
+
+// retained returns the given properties with RetainedProperty added if the
+// message carrying them was retained, creating them if nil.
+func retained(properties map[string]string, isRetained bool) map[string]string {
+	if !isRetained {
+		return properties
+	}
+
+	if properties == nil {
+		properties = make(map[string]string, 1)
+	}
+
+	properties[RetainedProperty] = "true"
+
+	return properties
 }
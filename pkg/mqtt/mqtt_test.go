@@ -0,0 +1,173 @@
+package mqtt_test
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/DECODEproject/iotencoder/pkg/mqtt"
+)
+
+// broker is a minimal MQTT 3.1.1 broker accepting a single connection, which
+// publishes the given payloads at QoS 1 once the client subscribes, answers
+// pings, and records the ids of the messages the client acknowledges.
+type broker struct {
+	listener net.Listener
+	payloads []string
+
+	mu    sync.Mutex
+	acked []uint16
+	pings int
+}
+
+func newBroker(t *testing.T, payloads ...string) *broker {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+
+	b := &broker{listener: listener, payloads: payloads}
+	go b.serve()
+
+	return b
+}
+
+func (b *broker) addr() string {
+	return "tcp://" + b.listener.Addr().String()
+}
+
+func (b *broker) close() {
+	b.listener.Close()
+}
+
+func (b *broker) serve() {
+	conn, err := b.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	for {
+		cp, err := packets.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+
+		switch p := cp.(type) {
+		case *packets.ConnectPacket:
+			packets.NewControlPacket(packets.Connack).Write(conn)
+		case *packets.SubscribePacket:
+			suback := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+			suback.MessageID = p.MessageID
+			suback.ReturnCodes = p.Qoss
+			suback.Write(conn)
+
+			for i, payload := range b.payloads {
+				publish := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+				publish.TopicName = p.Topics[0]
+				publish.Qos = 1
+				publish.MessageID = uint16(i + 1)
+				publish.Payload = []byte(payload)
+				publish.Write(conn)
+			}
+		case *packets.PingreqPacket:
+			b.mu.Lock()
+			b.pings++
+			b.mu.Unlock()
+			packets.NewControlPacket(packets.Pingresp).Write(conn)
+		case *packets.PubackPacket:
+			b.mu.Lock()
+			b.acked = append(b.acked, p.MessageID)
+			b.mu.Unlock()
+		case *packets.DisconnectPacket:
+			return
+		}
+	}
+}
+
+func (b *broker) state() ([]uint16, int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]uint16(nil), b.acked...), b.pings
+}
+
+func TestInflightWindowFullPastKeepAlive(t *testing.T) {
+	// enough messages to fill the client library's own buffers were it to stop
+	// reading from the broker
+	payloads := []string{}
+	for i := 0; i < 10; i++ {
+		payloads = append(payloads, fmt.Sprintf("message %d", i))
+	}
+
+	b := newBroker(t, payloads...)
+	defer b.close()
+
+	keepAlive := 2 * time.Second
+
+	client := mqtt.NewClient(&mqtt.Config{
+		Inflight:  1,
+		KeepAlive: keepAlive,
+	}, kitlog.NewNopLogger())
+	defer client.(interface{ Stop() error }).Stop()
+
+	received := make(chan string, len(payloads))
+	dones := make(chan func(), len(payloads))
+
+	err := client.(mqtt.TopicSubscriber).SubscribeTopic(b.addr(), "", "readings", func(topic string, payload []byte, properties map[string]string, done func()) {
+		received <- string(payload)
+		dones <- done
+	})
+	require.Nil(t, err)
+
+	select {
+	case payload := <-received:
+		assert.Equal(t, payloads[0], payload)
+	case <-time.After(5 * time.Second):
+		t.Fatal("first message not received")
+	}
+
+	// hold the only place in the window for several keep alive periods, during
+	// which the client must keep answering pings and hold back the messages
+	// after the first
+	time.Sleep(3 * keepAlive)
+
+	acked, pings := b.state()
+	assert.Empty(t, acked)
+	assert.True(t, pings > 0)
+	assert.Nil(t, client.(interface{ Check() error }).Check())
+	assert.Len(t, received, 0)
+
+	for _, expected := range payloads[1:] {
+		(<-dones)()
+
+		select {
+		case payload := <-received:
+			assert.Equal(t, expected, payload)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s message not received", expected)
+		}
+	}
+
+	(<-dones)()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(acked) < len(payloads) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		acked, _ = b.state()
+	}
+
+	expected := []uint16{}
+	for i := range payloads {
+		expected = append(expected, uint16(i+1))
+	}
+
+	assert.Equal(t, expected, acked)
+}
@@ -0,0 +1,143 @@
+// Package mqtttest provides an in-memory implementation of mqtt.Client which
+// records subscriptions rather than connecting to a broker, and allows tests to
+// simulate the broker delivering messages to subscribers.
+package mqtttest
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/DECODEproject/iotencoder/pkg/mqtt"
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+)
+
+// Client is an in-memory fake of mqtt.Client. Callbacks registered via
+// Subscribe or SubscribeTopic are stored keyed by topic, and are invoked
+// synchronously by Deliver or DeliverTopic.
+type Client struct {
+	sync.RWMutex
+	callbacks map[string]mqtt.Callback
+}
+
+// NewClient returns a new in-memory client with no subscriptions.
+func NewClient() *Client {
+	return &Client{
+		callbacks: make(map[string]mqtt.Callback),
+	}
+}
+
+// Subscribe records the callback for the given device token, replacing any
+// existing subscription as a broker would.
+func (c *Client) Subscribe(broker, username string, deviceToken secret.Secret, callback mqtt.Callback) error {
+	return c.SubscribeTopic(broker, username, mqtt.Topic(deviceToken), callback)
+}
+
+// Unsubscribe removes the subscription for the given device token.
+func (c *Client) Unsubscribe(broker, username string, deviceToken secret.Secret) error {
+	return c.UnsubscribeTopic(broker, username, mqtt.Topic(deviceToken))
+}
+
+// SubscribeTopic records the callback for the given topic, replacing any
+// existing subscription as a broker would.
+func (c *Client) SubscribeTopic(broker, username, topic string, callback mqtt.Callback) error {
+	c.Lock()
+	defer c.Unlock()
+
+	c.callbacks[topic] = callback
+
+	return nil
+}
+
+// UnsubscribeTopic removes the subscription for the given topic.
+func (c *Client) UnsubscribeTopic(broker, username, topic string) error {
+	c.Lock()
+	defer c.Unlock()
+
+	delete(c.callbacks, topic)
+
+	return nil
+}
+
+// Stop removes all subscriptions.
+func (c *Client) Stop() error {
+	c.Lock()
+	defer c.Unlock()
+
+	c.callbacks = make(map[string]mqtt.Callback)
+
+	return nil
+}
+
+// Subscribed returns true if there is currently a subscription for the given
+// device token.
+func (c *Client) Subscribed(deviceToken string) bool {
+	return c.SubscribedTopic(mqtt.Topic(secret.Secret(deviceToken)))
+}
+
+// SubscribedTopic returns true if there is currently a subscription for the
+// given topic.
+func (c *Client) SubscribedTopic(topic string) bool {
+	c.RLock()
+	defer c.RUnlock()
+
+	_, ok := c.callbacks[topic]
+	return ok
+}
+
+// Subscriptions returns the number of current subscriptions.
+func (c *Client) Subscriptions() int {
+	c.RLock()
+	defer c.RUnlock()
+
+	return len(c.callbacks)
+}
+
+// Deliver simulates the broker publishing the payload on the readings topic
+// of the given device, invoking the subscribed callback before returning.
+// Returns an error if there is no subscription for the device.
+func (c *Client) Deliver(deviceToken string, payload []byte) error {
+	return c.DeliverWithProperties(deviceToken, payload, nil)
+}
+
+// DeliverWithProperties simulates the broker publishing the payload with the
+// given MQTT 5 user properties, as Deliver.
+func (c *Client) DeliverWithProperties(deviceToken string, payload []byte, properties map[string]string) error {
+	topic := mqtt.Topic(secret.Secret(deviceToken))
+
+	c.RLock()
+	callback, ok := c.callbacks[topic]
+	c.RUnlock()
+
+	if !ok {
+		return errors.Errorf("no subscription for device: %s", deviceToken)
+	}
+
+	callback(topic, payload, properties, func() {})
+
+	return nil
+}
+
+// DeliverTopic simulates the broker publishing the payload on the given topic,
+// invoking the subscribed callback before returning. Returns an error if there
+// is no subscription for the topic.
+func (c *Client) DeliverTopic(topic string, payload []byte) error {
+	c.RLock()
+	callback, ok := c.callbacks[topic]
+	c.RUnlock()
+
+	if !ok {
+		return errors.Errorf("no subscription for topic: %s", topic)
+	}
+
+	callback(topic, payload, nil, func() {})
+
+	return nil
+}
+
+// DeliverRetained simulates the broker delivering the retained message of the
+// readings topic of the given device, as it does when a client subscribes to
+// the topic, invoking the subscribed callback before returning.
+func (c *Client) DeliverRetained(deviceToken string, payload []byte) error {
+	return c.DeliverWithProperties(deviceToken, payload, map[string]string{mqtt.RetainedProperty: "true"})
+}
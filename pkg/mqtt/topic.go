@@ -0,0 +1,56 @@
+package mqtt
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+)
+
+// TokenPlaceholder is the placeholder in a topic template which is replaced by
+// the device token of the device publishing on the topic.
+const TokenPlaceholder = "{token}"
+
+// maxTopicLength is the maximum length in bytes of an MQTT topic name.
+const maxTopicLength = 65535
+
+// ValidateTopicTemplate returns an error if the given template can't be used as
+// the topic on which a device publishes its readings. A template is a topic
+// name, which may contain TokenPlaceholder in place of the device token, but
+// not the wildcards used in topic filters or any other placeholder, and may not
+// begin with $ as topics reserved by brokers do.
+func ValidateTopicTemplate(template string) error {
+	if template == "" {
+		return errors.New("topic must not be empty")
+	}
+
+	if strings.HasPrefix(template, "$") {
+		return errors.New("topic must not begin with $")
+	}
+
+	if strings.ContainsAny(template, "+#\x00") {
+		return errors.New("topic must not contain wildcards or null characters")
+	}
+
+	if strings.ContainsAny(strings.Replace(template, TokenPlaceholder, "", -1), "{}") {
+		return errors.Errorf("topic may contain no placeholder other than %s", TokenPlaceholder)
+	}
+
+	if len(template) > maxTopicLength {
+		return errors.New("topic is too long")
+	}
+
+	return nil
+}
+
+// ExpandTopic returns the topic on which the device with the given token
+// publishes its readings according to the given template, or the default topic
+// returned by Topic if the template is empty.
+func ExpandTopic(template string, deviceToken secret.Secret) string {
+	if template == "" {
+		return Topic(deviceToken)
+	}
+
+	return strings.Replace(template, TokenPlaceholder, deviceToken.Reveal(), -1)
+}
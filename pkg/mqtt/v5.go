@@ -0,0 +1,237 @@
+package mqtt
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"github.com/eclipse/paho.golang/paho"
+	"github.com/pkg/errors"
+
+	"github.com/DECODEproject/iotencoder/pkg/logger/level"
+)
+
+const (
+	// v5Timeout is how long we wait for the broker to respond to a connect,
+	// subscribe or unsubscribe packet.
+	v5Timeout = 30 * time.Second
+
+	// v5KeepAlive is the keep alive interval in seconds sent to the broker.
+	v5KeepAlive = 30
+
+	// v5SessionExpiry asks the broker to keep a persistent session for as long
+	// as it is able to.
+	v5SessionExpiry = 0xFFFFFFFF
+)
+
+// connectV5 creates a new connection to the passed in broker speaking MQTT 5.
+// Unlike MQTT 3.1.1 the session is resumed by asking for no clean start and a
+// session expiry interval, and any user properties of received messages are
+// passed to our callbacks.
+func (c *client) connectV5(broker, username string, deliver func(topic string, payload []byte, properties map[string]string, ack func()), lost func(err error)) (conn, error) {
+	logger, verbose := c.logger, c.verbose
+
+	if verbose {
+		level.Debug(logger).Log("broker", broker, "msg", "configuring client", "username", username, "protocol_version", V5)
+	}
+
+	netConn, err := dial(broker)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to connect to broker")
+	}
+
+	v := &v5Conn{}
+
+	// with an in-flight window we acknowledge messages only once they have been
+	// processed, and ask the broker to send us no more unacknowledged messages
+	// than fit in the window
+	manual := c.inflight > 0
+
+	v.client = paho.NewClient(paho.ClientConfig{
+		Conn: netConn,
+		Router: paho.NewSingleHandlerRouter(func(p *paho.Publish) {
+			var ack func()
+			if manual {
+				ack = func() { v.client.Ack(p) }
+			}
+
+			deliver(p.Topic, p.Payload, retained(userProperties(p), p.Retain), ack)
+		}),
+		EnableManualAcknowledgment: manual,
+		OnClientError: func(err error) {
+			// reading fails once we have disconnected ourselves
+			if !atomic.CompareAndSwapInt32(&v.closed, 0, 1) {
+				return
+			}
+			lost(err)
+		},
+		OnServerDisconnect: func(d *paho.Disconnect) {
+			if !atomic.CompareAndSwapInt32(&v.closed, 0, 1) {
+				return
+			}
+			lost(errors.Errorf("broker disconnected with reason code %d", d.ReasonCode))
+		},
+	})
+
+	connect := &paho.Connect{
+		ClientID:     clientID(c.clientIDPrefix, username),
+		Username:     username,
+		UsernameFlag: username != "",
+		CleanStart:   !c.persistentSession,
+		KeepAlive:    v5KeepAlive,
+	}
+
+	if c.keepAlive > 0 {
+		connect.KeepAlive = uint16(c.keepAlive / time.Second)
+	}
+
+	if c.persistentSession || manual {
+		connect.Properties = &paho.ConnectProperties{}
+	}
+
+	if c.persistentSession {
+		expiry := uint32(v5SessionExpiry)
+		connect.Properties.SessionExpiryInterval = &expiry
+	}
+
+	if manual {
+		receiveMaximum := uint16(c.inflight)
+		connect.Properties.ReceiveMaximum = &receiveMaximum
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), v5Timeout)
+	defer cancel()
+
+	connack, err := v.client.Connect(ctx, connect)
+	if err != nil {
+		netConn.Close()
+		return nil, errors.Wrap(err, "failed to connect to broker")
+	}
+
+	if connack.ReasonCode != 0 {
+		netConn.Close()
+		return nil, errors.Errorf("broker refused connection with reason code %d", connack.ReasonCode)
+	}
+
+	logger.Log(
+		"msg", "client connected",
+		"broker", broker,
+	)
+
+	if verbose {
+		level.Debug(logger).Log("broker", broker, "msg", "mqtt connected")
+	}
+
+	return v, nil
+}
+
+// v5Conn is a connection to a broker speaking MQTT 5.
+type v5Conn struct {
+	client *paho.Client
+	closed int32
+}
+
+func (v *v5Conn) subscribe(filter string, qos byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), v5Timeout)
+	defer cancel()
+
+	suback, err := v.client.Subscribe(ctx, &paho.Subscribe{
+		Subscriptions: map[string]paho.SubscribeOptions{
+			filter: {QoS: qos},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, reason := range suback.Reasons {
+		if reason >= 0x80 {
+			return errors.Errorf("broker refused subscription with reason code %d", reason)
+		}
+	}
+
+	return nil
+}
+
+func (v *v5Conn) unsubscribe(filter string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), v5Timeout)
+	defer cancel()
+
+	_, err := v.client.Unsubscribe(ctx, &paho.Unsubscribe{
+		Topics: []string{filter},
+	})
+
+	return err
+}
+
+func (v *v5Conn) connected() bool {
+	return atomic.LoadInt32(&v.closed) == 0
+}
+
+func (v *v5Conn) disconnect() {
+	atomic.StoreInt32(&v.closed, 1)
+	v.client.Disconnect(&paho.Disconnect{ReasonCode: 0})
+}
+
+// dial opens a network connection to the broker at the given URL, over TLS for
+// the tls, ssl and mqtts schemes.
+func dial(broker string) (net.Conn, error) {
+	u, err := url.Parse(broker)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse broker address")
+	}
+
+	host := u.Host
+	secure := false
+
+	switch u.Scheme {
+	case "tcp", "mqtt":
+	case "tls", "ssl", "tcps", "mqtts":
+		secure = true
+	default:
+		return nil, errors.Errorf("unsupported broker scheme: %s", u.Scheme)
+	}
+
+	if u.Port() == "" {
+		if secure {
+			host = net.JoinHostPort(u.Hostname(), "8883")
+		} else {
+			host = net.JoinHostPort(u.Hostname(), "1883")
+		}
+	}
+
+	if secure {
+		return tls.DialWithDialer(&net.Dialer{Timeout: v5Timeout}, "tcp", host, &tls.Config{})
+	}
+
+	return net.DialTimeout("tcp", host, v5Timeout)
+}
+
+// userProperties returns the user properties of the given message, or nil if
+// it has none. If a key is repeated only its first value is kept.
+func userProperties(p *paho.Publish) map[string]string {
+	if p.Properties == nil || len(p.Properties.User) == 0 {
+		return nil
+	}
+
+	properties := make(map[string]string, len(p.Properties.User))
+
+	for _, property := range p.Properties.User {
+		if property.Key == RetainedProperty {
+			continue
+		}
+
+		if _, ok := properties[property.Key]; !ok {
+			properties[property.Key] = property.Value
+		}
+	}
+
+	if len(properties) == 0 {
+		return nil
+	}
+
+	return properties
+}
@@ -0,0 +1,323 @@
+// Package outbox delivers encrypted payloads queued in the outbox to their
+// datastores. When the outbox is enabled the pipeline writes each payload to a
+// table in Postgres once it has been encrypted, rather than to the datastore,
+// and a Dispatcher delivers the queued payloads and marks them as done, so
+// that payloads are retried rather than lost should the datastore be
+// unavailable or the encoder crash. A payload which is still undelivered after
+// a maximum number of attempts, such as one whose datastore is gone, is marked
+// as dead and kept in the outbox for inspection rather than retried forever.
+package outbox
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/logger"
+	"github.com/DECODEproject/iotencoder/pkg/logger/level"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+)
+
+// DeliveredTTL is the duration for which delivered entries are kept in the
+// outbox before being pruned, so that recent deliveries may be inspected.
+const DeliveredTTL = time.Hour
+
+var (
+	// LagGauge is a prometheus gauge recording the age in seconds of the oldest
+	// payload in the outbox yet to be delivered, which is zero when the outbox
+	// is empty.
+	LagGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "outbox_lag_seconds",
+			Help:      "Age in seconds of the oldest payload in the outbox yet to be delivered to the datastore",
+		},
+	)
+
+	// PendingGauge is a prometheus gauge recording the number of payloads in
+	// the outbox yet to be delivered.
+	PendingGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "outbox_pending",
+			Help:      "Number of payloads in the outbox yet to be delivered to the datastore",
+		},
+	)
+
+	// DeadGauge is a prometheus gauge recording the number of payloads in the
+	// outbox abandoned after exhausting their delivery attempts.
+	DeadGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "outbox_dead",
+			Help:      "Number of payloads in the outbox abandoned after exhausting their delivery attempts",
+		},
+	)
+
+	// DeliveriesCounter is a prometheus counter vec recording a count of
+	// attempts to deliver payloads from the outbox, labelled by whether they
+	// were delivered or failed.
+	DeliveriesCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "outbox_deliveries",
+			Help:      "Count of attempts to deliver payloads from the outbox, labelled by result",
+		},
+		[]string{"result"},
+	)
+)
+
+// Store is the interface to the outbox from which payloads are delivered. It
+// is satisfied by the postgres.DB type.
+type Store interface {
+	ClaimOutbox(now, retryAt time.Time, limit, maxAttempts int) ([]*postgres.OutboxEntry, error)
+	MarkOutboxDelivered(id int64, deliveredAt time.Time) error
+	GetOutboxStatus() (*postgres.OutboxStatus, error)
+	PruneOutbox(before time.Time) (int64, error)
+}
+
+// Deliverer is the interface we call to write a payload to its datastore. It
+// is satisfied by the pipeline.Processor type.
+type Deliverer interface {
+	Deliver(ctx context.Context, entry *postgres.OutboxEntry) error
+}
+
+// Config is used to pass in dependencies and configuration when creating a
+// Dispatcher. The outbox is checked for payloads every Interval, and up to
+// BatchSize payloads are claimed at a time. A payload which fails to be
+// delivered is retried after RetryInterval, until it has been attempted
+// MaxAttempts times, or indefinitely if MaxAttempts is zero.
+type Config struct {
+	Store         Store
+	Deliverer     Deliverer
+	Interval      time.Duration
+	BatchSize     int
+	RetryInterval time.Duration
+	MaxAttempts   int
+	Clock         clock.Clock
+}
+
+// Dispatcher is a component that delivers the payloads queued in the outbox to
+// their datastores. Dispatchers of every instance sharing the database claim
+// payloads from the same outbox, each payload being claimed by one of them at
+// a time.
+type Dispatcher struct {
+	store     Store
+	deliverer Deliverer
+	interval  time.Duration
+	batchSize int64
+	retry     time.Duration
+	attempts  int
+	clock     clock.Clock
+	logger    kitlog.Logger
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+}
+
+// NewDispatcher returns a new Dispatcher configured with the given config.
+func NewDispatcher(config *Config, logger kitlog.Logger) *Dispatcher {
+	logger = kitlog.With(logger, "module", "outbox")
+
+	return &Dispatcher{
+		store:     config.Store,
+		deliverer: config.Deliverer,
+		interval:  config.Interval,
+		batchSize: int64(config.BatchSize),
+		retry:     config.RetryInterval,
+		attempts:  config.MaxAttempts,
+		clock:     config.Clock,
+		logger:    logger,
+	}
+}
+
+// Start starts a goroutine which delivers the payloads in the outbox on each
+// tick of our interval.
+func (d *Dispatcher) Start() error {
+	d.logger.Log("msg", "starting outbox dispatcher", "interval", d.interval, "batchSize", d.batch(), "retry", d.retry, "maxAttempts", d.attempts)
+
+	if d.interval <= 0 {
+		return errors.New("outbox interval must be positive")
+	}
+
+	if d.batch() <= 0 {
+		return errors.New("outbox batch size must be positive")
+	}
+
+	if d.retry <= 0 {
+		return errors.New("outbox retry interval must be positive")
+	}
+
+	if d.attempts < 0 {
+		return errors.New("outbox max attempts must not be negative")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	d.cancel = cancel
+
+	d.wg.Add(1)
+
+	go d.loop(ctx)
+
+	return nil
+}
+
+// Stop stops the dispatcher, abandoning any delivery in progress. Payloads
+// claimed but not delivered are retried once their retry interval expires.
+func (d *Dispatcher) Stop() error {
+	if d.cancel == nil {
+		return nil
+	}
+
+	d.logger.Log("msg", "stopping outbox dispatcher")
+
+	d.cancel()
+	d.wg.Wait()
+
+	return nil
+}
+
+// SetBatchSize changes the number of payloads claimed from the outbox at a
+// time, taking effect from the next batch claimed.
+func (d *Dispatcher) SetBatchSize(n int) {
+	atomic.StoreInt64(&d.batchSize, int64(n))
+}
+
+// batch returns the number of payloads claimed from the outbox at a time.
+func (d *Dispatcher) batch() int {
+	return int(atomic.LoadInt64(&d.batchSize))
+}
+
+// Dispatch claims a batch of the payloads due to be delivered, and delivers
+// them in the order in which they were queued. It returns the number of
+// payloads claimed, so that the caller may dispatch again if the batch was
+// full. A payload which fails to be delivered doesn't prevent the rest being
+// delivered, and is marked as dead by the claim following its last attempt.
+func (d *Dispatcher) Dispatch(ctx context.Context) (int, error) {
+	now := d.clock.Now()
+
+	entries, err := d.store.ClaimOutbox(now, now.Add(d.retry), d.batch(), d.attempts)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to claim outbox entries")
+	}
+
+	for _, entry := range entries {
+		err = d.deliverer.Deliver(ctx, entry)
+		if err != nil {
+			if ctx.Err() != nil {
+				return len(entries), ctx.Err()
+			}
+
+			DeliveriesCounter.WithLabelValues("failed").Inc()
+			level.Error(d.logger).Log(
+				"err", err,
+				"msg", "failed to deliver payload",
+				"stream_uid", entry.StreamID,
+				"device_hash", logger.HashToken(entry.DeviceToken),
+				"attempts", entry.Attempts,
+			)
+
+			if d.attempts > 0 && entry.Attempts >= d.attempts {
+				level.Warn(d.logger).Log(
+					"msg", "abandoning payload after its last delivery attempt",
+					"stream_uid", entry.StreamID,
+					"device_hash", logger.HashToken(entry.DeviceToken),
+				)
+			}
+			continue
+		}
+
+		err = d.store.MarkOutboxDelivered(entry.ID, d.clock.Now())
+		if err != nil {
+			// the payload will be delivered again once its retry interval
+			// expires
+			return len(entries), errors.Wrap(err, "failed to mark outbox entry delivered")
+		}
+
+		DeliveriesCounter.WithLabelValues("delivered").Inc()
+	}
+
+	return len(entries), nil
+}
+
+// Update records the number of payloads yet to be delivered, the age of the
+// oldest of them, and the number of dead payloads in our metrics.
+func (d *Dispatcher) Update() error {
+	status, err := d.store.GetOutboxStatus()
+	if err != nil {
+		return errors.Wrap(err, "failed to read outbox status")
+	}
+
+	PendingGauge.Set(float64(status.Pending))
+	DeadGauge.Set(float64(status.Dead))
+
+	if !status.OldestEnqueuedAt.Valid {
+		LagGauge.Set(0)
+		return nil
+	}
+
+	LagGauge.Set(d.clock.Now().Sub(status.OldestEnqueuedAt.Time).Seconds())
+
+	return nil
+}
+
+// loop is run in a goroutine and on each tick of our interval dispatches
+// payloads until the outbox holds no more which are due, then updates our
+// metrics and prunes delivered payloads.
+func (d *Dispatcher) loop(ctx context.Context) {
+	defer d.wg.Done()
+
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			d.tick(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// tick dispatches payloads until a batch is not full, and then updates our
+// metrics and prunes delivered payloads.
+func (d *Dispatcher) tick(ctx context.Context) {
+	for ctx.Err() == nil {
+		n, err := d.Dispatch(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				level.Error(d.logger).Log("err", err, "msg", "failed to dispatch outbox")
+			}
+			break
+		}
+
+		if n < d.batch() {
+			break
+		}
+	}
+
+	err := d.Update()
+	if err != nil {
+		level.Error(d.logger).Log("err", err, "msg", "failed to update outbox metrics")
+	}
+
+	deleted, err := d.store.PruneOutbox(d.clock.Now().Add(-DeliveredTTL))
+	if err != nil {
+		level.Error(d.logger).Log("err", err, "msg", "failed to prune outbox")
+		return
+	}
+
+	if deleted > 0 {
+		d.logger.Log("msg", "pruned delivered payloads", "deleted", deleted)
+	}
+}
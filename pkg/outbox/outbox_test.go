@@ -0,0 +1,277 @@
+package outbox_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/guregu/null.v3"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/outbox"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+)
+
+// fakeStore holds outbox entries in memory, claiming them as the postgres
+// outbox does.
+type fakeStore struct {
+	sync.Mutex
+	entries   []*postgres.OutboxEntry
+	due       map[int64]time.Time
+	delivered map[int64]time.Time
+	dead      map[int64]bool
+}
+
+func newFakeStore(entries ...*postgres.OutboxEntry) *fakeStore {
+	return &fakeStore{
+		entries:   entries,
+		due:       make(map[int64]time.Time),
+		delivered: make(map[int64]time.Time),
+		dead:      make(map[int64]bool),
+	}
+}
+
+func (f *fakeStore) ClaimOutbox(now, retryAt time.Time, limit, maxAttempts int) ([]*postgres.OutboxEntry, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	claimed := []*postgres.OutboxEntry{}
+
+	for _, e := range f.entries {
+		if len(claimed) == limit {
+			break
+		}
+
+		if _, ok := f.delivered[e.ID]; ok {
+			continue
+		}
+
+		if due, ok := f.due[e.ID]; f.dead[e.ID] || ok && due.After(now) {
+			continue
+		}
+
+		if maxAttempts > 0 && e.Attempts >= maxAttempts {
+			f.dead[e.ID] = true
+			continue
+		}
+
+		e.Attempts++
+		f.due[e.ID] = retryAt
+
+		claimed = append(claimed, e)
+	}
+
+	return claimed, nil
+}
+
+func (f *fakeStore) MarkOutboxDelivered(id int64, deliveredAt time.Time) error {
+	f.Lock()
+	defer f.Unlock()
+
+	f.delivered[id] = deliveredAt
+
+	return nil
+}
+
+func (f *fakeStore) GetOutboxStatus() (*postgres.OutboxStatus, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	status := &postgres.OutboxStatus{}
+
+	for _, e := range f.entries {
+		if _, ok := f.delivered[e.ID]; ok {
+			continue
+		}
+
+		if f.dead[e.ID] {
+			status.Dead++
+			continue
+		}
+
+		status.Pending++
+
+		if !status.OldestEnqueuedAt.Valid || e.EnqueuedAt.Before(status.OldestEnqueuedAt.Time) {
+			status.OldestEnqueuedAt = null.TimeFrom(e.EnqueuedAt)
+		}
+	}
+
+	return status, nil
+}
+
+func (f *fakeStore) PruneOutbox(before time.Time) (int64, error) {
+	return 0, nil
+}
+
+// fakeDeliverer records the ids of the entries delivered, failing those in
+// its set of failures.
+type fakeDeliverer struct {
+	sync.Mutex
+	delivered []int64
+	failures  map[int64]bool
+}
+
+func (f *fakeDeliverer) Deliver(ctx context.Context, entry *postgres.OutboxEntry) error {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.failures[entry.ID] {
+		return errors.New("datastore unavailable")
+	}
+
+	f.delivered = append(f.delivered, entry.ID)
+
+	return nil
+}
+
+func TestDispatch(t *testing.T) {
+	start := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	mock := clock.NewMock(start)
+
+	store := newFakeStore(
+		&postgres.OutboxEntry{ID: 1, StreamID: "a", EnqueuedAt: start.Add(-time.Minute)},
+		&postgres.OutboxEntry{ID: 2, StreamID: "a", EnqueuedAt: start.Add(-30 * time.Second)},
+		&postgres.OutboxEntry{ID: 3, StreamID: "b", EnqueuedAt: start.Add(-10 * time.Second)},
+	)
+
+	deliverer := &fakeDeliverer{failures: map[int64]bool{2: true}}
+
+	dispatcher := outbox.NewDispatcher(&outbox.Config{
+		Store:         store,
+		Deliverer:     deliverer,
+		Interval:      time.Second,
+		BatchSize:     2,
+		RetryInterval: time.Minute,
+		Clock:         mock,
+	}, kitlog.NewNopLogger())
+
+	// a full batch is claimed, and failing to deliver one payload doesn't
+	// prevent the others being delivered
+	n, err := dispatcher.Dispatch(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+
+	n, err = dispatcher.Dispatch(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+
+	assert.Equal(t, []int64{1, 3}, deliverer.delivered)
+	assert.Equal(t, start, store.delivered[1])
+
+	// the failed payload is not retried until its retry interval expires
+	n, err = dispatcher.Dispatch(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+
+	err = dispatcher.Update()
+	assert.Nil(t, err)
+
+	status, _ := store.GetOutboxStatus()
+	assert.Equal(t, 1, status.Pending)
+
+	mock.Add(time.Minute)
+	deliverer.failures = nil
+
+	n, err = dispatcher.Dispatch(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+
+	assert.Equal(t, []int64{1, 3, 2}, deliverer.delivered)
+	assert.Equal(t, 2, store.entries[1].Attempts)
+}
+
+func TestDispatchMaxAttempts(t *testing.T) {
+	start := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	mock := clock.NewMock(start)
+
+	store := newFakeStore(
+		&postgres.OutboxEntry{ID: 1, StreamID: "a", EnqueuedAt: start},
+	)
+
+	deliverer := &fakeDeliverer{failures: map[int64]bool{1: true}}
+
+	dispatcher := outbox.NewDispatcher(&outbox.Config{
+		Store:         store,
+		Deliverer:     deliverer,
+		Interval:      time.Second,
+		BatchSize:     10,
+		RetryInterval: time.Minute,
+		MaxAttempts:   2,
+		Clock:         mock,
+	}, kitlog.NewNopLogger())
+
+	for i := 0; i < 2; i++ {
+		n, err := dispatcher.Dispatch(context.Background())
+		assert.Nil(t, err)
+		assert.Equal(t, 1, n)
+
+		mock.Add(time.Minute)
+	}
+
+	// having exhausted its attempts the payload is dead rather than retried
+	n, err := dispatcher.Dispatch(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+
+	err = dispatcher.Update()
+	assert.Nil(t, err)
+
+	status, _ := store.GetOutboxStatus()
+	assert.Equal(t, 0, status.Pending)
+	assert.Equal(t, 1, status.Dead)
+	assert.Equal(t, 2, store.entries[0].Attempts)
+}
+
+func TestDispatcherStartStop(t *testing.T) {
+	store := newFakeStore(
+		&postgres.OutboxEntry{ID: 1, EnqueuedAt: time.Now()},
+	)
+
+	deliverer := &fakeDeliverer{}
+
+	dispatcher := outbox.NewDispatcher(&outbox.Config{
+		Store:         store,
+		Deliverer:     deliverer,
+		Interval:      10 * time.Millisecond,
+		BatchSize:     10,
+		RetryInterval: time.Minute,
+		Clock:         clock.New(),
+	}, kitlog.NewNopLogger())
+
+	err := dispatcher.Start()
+	assert.Nil(t, err)
+
+	delivered := func() int {
+		deliverer.Lock()
+		defer deliverer.Unlock()
+		return len(deliverer.delivered)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for delivered() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	assert.Equal(t, 1, delivered())
+
+	err = dispatcher.Stop()
+	assert.Nil(t, err)
+}
+
+func TestDispatcherInvalidConfig(t *testing.T) {
+	dispatcher := outbox.NewDispatcher(&outbox.Config{
+		Store:         newFakeStore(),
+		Deliverer:     &fakeDeliverer{},
+		Interval:      time.Second,
+		RetryInterval: time.Minute,
+		Clock:         clock.New(),
+	}, kitlog.NewNopLogger())
+
+	err := dispatcher.Start()
+	assert.NotNil(t, err)
+	assert.Equal(t, "outbox batch size must be positive", err.Error())
+}
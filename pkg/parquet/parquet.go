@@ -0,0 +1,384 @@
+// Package parquet writes tables as Apache Parquet files, so that they may be
+// analysed with the tools of a data platform without access to our database.
+// Only as much of the format as we need is implemented: every column is
+// flat, each file holds a single row group, and values are PLAIN encoded and
+// uncompressed. Files are small enough for this to matter little, and
+// they are readable by any Parquet implementation.
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Type is the type of the values of a column.
+type Type int
+
+const (
+	// Boolean is a column of bool values.
+	Boolean Type = iota
+
+	// Int64 is a column of int64 values.
+	Int64
+
+	// Double is a column of float64 values.
+	Double
+
+	// String is a column of UTF-8 string values.
+	String
+
+	// Timestamp is a column of time.Time values, stored as milliseconds since
+	// the Unix epoch.
+	Timestamp
+
+	// Date is a column of time.Time values of which only the date in UTC is
+	// stored, as days since the Unix epoch.
+	Date
+)
+
+// CreatedBy identifies our writer in the metadata of the files we write.
+const CreatedBy = "iotencoder parquet writer"
+
+// magic is written at the start and end of every Parquet file.
+var magic = []byte("PAR1")
+
+// physical types, converted types and enum values of the Parquet format
+const (
+	physicalBoolean   = 0
+	physicalInt32     = 1
+	physicalInt64     = 2
+	physicalDouble    = 5
+	physicalByteArray = 6
+
+	convertedUTF8            = 0
+	convertedDate            = 6
+	convertedTimestampMillis = 9
+
+	repetitionRequired = 0
+	repetitionOptional = 1
+	encodingPlain      = 0
+	encodingRLE        = 3
+	codecUncompressed  = 0
+	pageTypeData       = 0
+)
+
+// Column describes a column of a table. The values of an Optional column may
+// be nil, while every row must have a value for a required column.
+type Column struct {
+	Name     string
+	Type     Type
+	Optional bool
+}
+
+// repetition returns the repetition type of the column.
+func (c Column) repetition() int32 {
+	if c.Optional {
+		return repetitionOptional
+	}
+
+	return repetitionRequired
+}
+
+// physical returns the physical type in which the values of the column are
+// stored.
+func (c Column) physical() int32 {
+	switch c.Type {
+	case Boolean:
+		return physicalBoolean
+	case Date:
+		return physicalInt32
+	case Int64, Timestamp:
+		return physicalInt64
+	case Double:
+		return physicalDouble
+	default:
+		return physicalByteArray
+	}
+}
+
+// converted returns the converted type annotating the physical type of the
+// column, or -1 if it has none.
+func (c Column) converted() int32 {
+	switch c.Type {
+	case String:
+		return convertedUTF8
+	case Date:
+		return convertedDate
+	case Timestamp:
+		return convertedTimestampMillis
+	default:
+		return -1
+	}
+}
+
+// Write writes a Parquet file to w holding the given rows, each of which must
+// have a value of the right type for each of the given columns. An error is
+// returned if any value does not match its column.
+func Write(w io.Writer, columns []Column, rows [][]interface{}) error {
+	if len(columns) == 0 {
+		return errors.New("a parquet file must have at least one column")
+	}
+
+	// encode the values of each column before writing anything, so that an
+	// invalid value leaves w untouched
+	pages := make([][]byte, len(columns))
+
+	for i, col := range columns {
+		page, err := encodeColumn(col, i, rows)
+		if err != nil {
+			return err
+		}
+
+		pages[i] = page
+	}
+
+	var file bytes.Buffer
+	file.Write(magic)
+
+	chunks := make([]chunk, len(columns))
+
+	for i, page := range pages {
+		header := encodePageHeader(len(rows), len(page))
+
+		chunks[i] = chunk{
+			offset: int64(file.Len()),
+			size:   int64(len(header) + len(page)),
+		}
+
+		file.Write(header)
+		file.Write(page)
+	}
+
+	footer := encodeFileMetadata(columns, chunks, len(rows))
+
+	file.Write(footer)
+
+	err := binary.Write(&file, binary.LittleEndian, uint32(len(footer)))
+	if err != nil {
+		return errors.Wrap(err, "failed to write parquet footer")
+	}
+
+	file.Write(magic)
+
+	_, err = file.WriteTo(w)
+	if err != nil {
+		return errors.Wrap(err, "failed to write parquet file")
+	}
+
+	return nil
+}
+
+// chunk records the position in the file of the data of a column.
+type chunk struct {
+	offset int64
+	size   int64
+}
+
+// encodeColumn returns the data page of the column at the given index of each
+// row, i.e. the definition levels of an optional column followed by the PLAIN
+// encoding of its values.
+func encodeColumn(col Column, index int, rows [][]interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+
+	var bits, defined []bool
+
+	for n, row := range rows {
+		if index >= len(row) {
+			return nil, errors.Errorf("row %d has no value for column %s", n, col.Name)
+		}
+
+		v := row[index]
+
+		if col.Optional {
+			defined = append(defined, v != nil)
+			if v == nil {
+				continue
+			}
+		}
+
+		var ok bool
+
+		switch col.Type {
+		case Boolean:
+			var b bool
+			b, ok = v.(bool)
+			bits = append(bits, b)
+		case Int64:
+			var i int64
+			i, ok = toInt64(v)
+			binary.Write(&buf, binary.LittleEndian, i)
+		case Double:
+			var f float64
+			f, ok = v.(float64)
+			binary.Write(&buf, binary.LittleEndian, math.Float64bits(f))
+		case String:
+			var s string
+			s, ok = v.(string)
+			binary.Write(&buf, binary.LittleEndian, uint32(len(s)))
+			buf.WriteString(s)
+		case Timestamp:
+			var t time.Time
+			t, ok = v.(time.Time)
+			binary.Write(&buf, binary.LittleEndian, t.UnixNano()/int64(time.Millisecond))
+		case Date:
+			var t time.Time
+			t, ok = v.(time.Time)
+			binary.Write(&buf, binary.LittleEndian, int32(daysSinceEpoch(t)))
+		}
+
+		if !ok {
+			return nil, errors.Errorf("invalid value for column %s in row %d: %v", col.Name, n, v)
+		}
+	}
+
+	// booleans are bit packed
+	if col.Type == Boolean {
+		buf.Write(bitPack(bits))
+	}
+
+	if !col.Optional {
+		return buf.Bytes(), nil
+	}
+
+	// the definition levels of an optional column are a single bit packed run
+	// of the RLE hybrid encoding, prefixed with its length
+	levels := bitPack(defined)
+
+	var page bytes.Buffer
+
+	header := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(header, uint64(len(levels))<<1|1)
+
+	binary.Write(&page, binary.LittleEndian, uint32(n+len(levels)))
+	page.Write(header[:n])
+	page.Write(levels)
+	buf.WriteTo(&page)
+
+	return page.Bytes(), nil
+}
+
+// bitPack packs the given bits into bytes, least significant bit first.
+func bitPack(bits []bool) []byte {
+	packed := make([]byte, (len(bits)+7)/8)
+	for i, b := range bits {
+		if b {
+			packed[i/8] |= 1 << uint(i%8)
+		}
+	}
+
+	return packed
+}
+
+// toInt64 converts the integer types we accept for Int64 columns to int64.
+func toInt64(v interface{}) (int64, bool) {
+	switch i := v.(type) {
+	case int64:
+		return i, true
+	case int:
+		return int64(i), true
+	case uint64:
+		return int64(i), i <= math.MaxInt64
+	default:
+		return 0, false
+	}
+}
+
+// daysSinceEpoch returns the number of days between the Unix epoch and the
+// date of t in UTC.
+func daysSinceEpoch(t time.Time) int64 {
+	t = t.UTC()
+	date := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+
+	return date.Unix() / (24 * 60 * 60)
+}
+
+// encodePageHeader returns the header of a data page holding the given number
+// of values encoded in size bytes.
+func encodePageHeader(values, size int) []byte {
+	w := newCompactWriter()
+
+	w.i32(1, pageTypeData)
+	w.i32(2, int32(size))
+	w.i32(3, int32(size))
+
+	w.beginStructField(5)
+	w.i32(1, int32(values))
+	w.i32(2, encodingPlain)
+	w.i32(3, encodingRLE)
+	w.i32(4, encodingRLE)
+	w.endStruct()
+
+	return w.bytes()
+}
+
+// encodeFileMetadata returns the footer of a file holding the given columns,
+// whose data was written to the given chunks.
+func encodeFileMetadata(columns []Column, chunks []chunk, rows int) []byte {
+	w := newCompactWriter()
+
+	w.i32(1, 1)
+
+	// the schema is flattened depth first, starting with its root
+	w.listField(2, typeStruct, len(columns)+1)
+
+	w.beginStruct()
+	w.binary(4, []byte("schema"))
+	w.i32(5, int32(len(columns)))
+	w.endStruct()
+
+	for _, col := range columns {
+		w.beginStruct()
+		w.i32(1, col.physical())
+		w.i32(3, col.repetition())
+		w.binary(4, []byte(col.Name))
+		if converted := col.converted(); converted >= 0 {
+			w.i32(6, converted)
+		}
+		w.endStruct()
+	}
+
+	w.i64(3, int64(rows))
+
+	w.listField(4, typeStruct, 1)
+	w.beginStruct()
+
+	var total int64
+
+	w.listField(1, typeStruct, len(columns))
+	for i, col := range columns {
+		c := chunks[i]
+		total += c.size
+
+		w.beginStruct()
+		w.i64(2, c.offset)
+
+		w.beginStructField(3)
+		w.i32(1, col.physical())
+		w.listField(2, typeI32, 2)
+		w.rawI32(encodingPlain)
+		w.rawI32(encodingRLE)
+		w.listField(3, typeBinary, 1)
+		w.rawBinary([]byte(col.Name))
+		w.i32(4, codecUncompressed)
+		w.i64(5, int64(rows))
+		w.i64(6, c.size)
+		w.i64(7, c.size)
+		w.i64(9, c.offset)
+		w.endStruct()
+
+		w.endStruct()
+	}
+
+	w.i64(2, total)
+	w.i64(3, int64(rows))
+	w.endStruct()
+
+	w.binary(6, []byte(CreatedBy))
+
+	return w.bytes()
+}
@@ -0,0 +1,420 @@
+package parquet_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/parquet"
+)
+
+func TestWrite(t *testing.T) {
+	columns := []parquet.Column{
+		{Name: "stream_uid", Type: parquet.String},
+		{Name: "messages", Type: parquet.Int64},
+		{Name: "latency_ms", Type: parquet.Double},
+		{Name: "archive", Type: parquet.Boolean},
+		{Name: "day", Type: parquet.Date},
+		{Name: "last_message_at", Type: parquet.Timestamp, Optional: true},
+	}
+
+	now := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+
+	rows := [][]interface{}{
+		{"abc123", uint64(12), 1.5, true, now, now},
+		{"def456", 0, 0.0, false, now, nil},
+	}
+
+	var buf bytes.Buffer
+
+	err := parquet.Write(&buf, columns, rows)
+	assert.Nil(t, err)
+
+	file := buf.Bytes()
+
+	assert.Equal(t, []byte("PAR1"), file[:4])
+	assert.Equal(t, []byte("PAR1"), file[len(file)-4:])
+
+	footerLength := binary.LittleEndian.Uint32(file[len(file)-8 : len(file)-4])
+	footer := file[len(file)-8-int(footerLength) : len(file)-8]
+
+	// the footer holds the names of our columns and our name
+	for _, col := range columns {
+		assert.Contains(t, string(footer), col.Name)
+	}
+	assert.Contains(t, string(footer), parquet.CreatedBy)
+
+	// string values are PLAIN encoded with their length
+	assert.Contains(t, string(file), "\x06\x00\x00\x00abc123")
+}
+
+func TestWriteRoundTrip(t *testing.T) {
+	columns := []parquet.Column{
+		{Name: "stream_uid", Type: parquet.String},
+		{Name: "messages", Type: parquet.Int64},
+		{Name: "latency_ms", Type: parquet.Double},
+		{Name: "archive", Type: parquet.Boolean},
+		{Name: "day", Type: parquet.Date},
+		{Name: "last_message_at", Type: parquet.Timestamp, Optional: true},
+	}
+
+	now := time.Date(2026, 10, 15, 12, 30, 0, 0, time.UTC)
+
+	rows := [][]interface{}{
+		{"abc123", uint64(12), 1.5, true, now, now},
+		{"def456", 0, 0.0, false, now, nil},
+		{"", int64(-3), -2.25, true, now.AddDate(0, 0, 1), now.Add(time.Second)},
+	}
+
+	var buf bytes.Buffer
+
+	err := parquet.Write(&buf, columns, rows)
+	assert.Nil(t, err)
+
+	file := buf.Bytes()
+
+	footerLength := binary.LittleEndian.Uint32(file[len(file)-8 : len(file)-4])
+	footerOffset := len(file) - 8 - int(footerLength)
+
+	r := &compactReader{buf: file[footerOffset : len(file)-8]}
+	meta := r.readStruct()
+	assert.Nil(t, r.err)
+	assert.Equal(t, len(file)-8, footerOffset+r.pos)
+
+	assert.Equal(t, int32(1), meta[1])
+	assert.Equal(t, int64(len(rows)), meta[3])
+	assert.Equal(t, []byte(parquet.CreatedBy), meta[6])
+
+	// the schema is the root followed by each column
+	schema := meta[2].([]interface{})
+	assert.Len(t, schema, len(columns)+1)
+	assert.Equal(t, []byte("schema"), schema[0].(thriftStruct)[4])
+	assert.Equal(t, int32(len(columns)), schema[0].(thriftStruct)[5])
+
+	expectedTypes := []struct {
+		physical   int32
+		converted  interface{}
+		repetition int32
+	}{
+		{6, int32(0), 0},
+		{2, nil, 0},
+		{5, nil, 0},
+		{0, nil, 0},
+		{1, int32(6), 0},
+		{2, int32(9), 1},
+	}
+
+	for i, col := range columns {
+		element := schema[i+1].(thriftStruct)
+		assert.Equal(t, []byte(col.Name), element[4])
+		assert.Equal(t, expectedTypes[i].physical, element[1])
+		assert.Equal(t, expectedTypes[i].repetition, element[3])
+		assert.Equal(t, expectedTypes[i].converted, element[6])
+	}
+
+	rowGroups := meta[4].([]interface{})
+	assert.Len(t, rowGroups, 1)
+
+	rowGroup := rowGroups[0].(thriftStruct)
+	assert.Equal(t, int64(len(rows)), rowGroup[3])
+
+	chunks := rowGroup[1].([]interface{})
+	assert.Len(t, chunks, len(columns))
+
+	// the column chunks follow the leading magic back to back up to the footer
+	offset := int64(4)
+	var total int64
+
+	for i, col := range columns {
+		chunk := chunks[i].(thriftStruct)
+		chunkMeta := chunk[3].(thriftStruct)
+
+		assert.Equal(t, offset, chunk[2])
+		assert.Equal(t, offset, chunkMeta[9])
+		assert.Equal(t, expectedTypes[i].physical, chunkMeta[1])
+		assert.Equal(t, []interface{}{[]byte(col.Name)}, chunkMeta[3])
+		assert.Equal(t, int32(0), chunkMeta[4])
+		assert.Equal(t, int64(len(rows)), chunkMeta[5])
+
+		size := chunkMeta[7].(int64)
+		assert.Equal(t, size, chunkMeta[6])
+
+		r := &compactReader{buf: file[offset : offset+size]}
+		header := r.readStruct()
+		assert.Nil(t, r.err)
+
+		assert.Equal(t, int32(0), header[1])
+		assert.Equal(t, header[2], header[3])
+		assert.Equal(t, int(size), r.pos+int(header[3].(int32)))
+
+		dataPage := header[5].(thriftStruct)
+		assert.Equal(t, int32(len(rows)), dataPage[1])
+		assert.Equal(t, int32(0), dataPage[2])
+
+		values := decodeColumn(t, col, len(rows), r.buf[r.pos:])
+
+		for n, row := range rows {
+			expected := row[i]
+
+			switch col.Type {
+			case parquet.Int64:
+				switch v := expected.(type) {
+				case int:
+					expected = int64(v)
+				case uint64:
+					expected = int64(v)
+				}
+			case parquet.Timestamp:
+				if expected != nil {
+					expected = expected.(time.Time).Truncate(time.Millisecond)
+				}
+			case parquet.Date:
+				t := expected.(time.Time)
+				expected = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+			}
+
+			assert.Equal(t, expected, values[n], "column %s, row %d", col.Name, n)
+		}
+
+		offset += size
+		total += size
+	}
+
+	assert.Equal(t, int64(footerOffset), offset)
+	assert.Equal(t, total, rowGroup[2])
+}
+
+// decodeColumn decodes the values of a data page written for the given column,
+// returning nil for undefined values of an optional column.
+func decodeColumn(t *testing.T, col parquet.Column, count int, page []byte) []interface{} {
+	t.Helper()
+
+	defined := make([]bool, count)
+	for i := range defined {
+		defined[i] = true
+	}
+
+	if col.Optional {
+		// the definition levels are a single bit packed run of the RLE hybrid
+		// encoding, prefixed with its length
+		length := binary.LittleEndian.Uint32(page)
+		levels := page[4 : 4+length]
+		page = page[4+length:]
+
+		header, n := binary.Uvarint(levels)
+		assert.Equal(t, uint64(1), header&1)
+		assert.Equal(t, int(header>>1), len(levels)-n)
+
+		for i := range defined {
+			defined[i] = levels[n+i/8]&(1<<uint(i%8)) != 0
+		}
+	}
+
+	values := make([]interface{}, count)
+	var bit int
+
+	for i := range values {
+		if !defined[i] {
+			continue
+		}
+
+		switch col.Type {
+		case parquet.Boolean:
+			values[i] = page[bit/8]&(1<<uint(bit%8)) != 0
+			bit++
+		case parquet.Int64:
+			values[i] = int64(binary.LittleEndian.Uint64(page))
+			page = page[8:]
+		case parquet.Double:
+			values[i] = math.Float64frombits(binary.LittleEndian.Uint64(page))
+			page = page[8:]
+		case parquet.String:
+			length := binary.LittleEndian.Uint32(page)
+			values[i] = string(page[4 : 4+length])
+			page = page[4+length:]
+		case parquet.Timestamp:
+			ms := int64(binary.LittleEndian.Uint64(page))
+			values[i] = time.Unix(0, ms*int64(time.Millisecond)).UTC()
+			page = page[8:]
+		case parquet.Date:
+			days := int32(binary.LittleEndian.Uint32(page))
+			values[i] = time.Unix(int64(days)*24*60*60, 0).UTC()
+			page = page[4:]
+		}
+	}
+
+	if col.Type == parquet.Boolean {
+		page = page[(bit+7)/8:]
+	}
+
+	assert.Len(t, page, 0, "column %s has trailing bytes", col.Name)
+
+	return values
+}
+
+// thriftStruct is a decoded Thrift struct, holding the value of each field by
+// its id.
+type thriftStruct map[int16]interface{}
+
+// compactReader decodes Thrift structs encoded with the compact protocol,
+// independently of the writer under test.
+type compactReader struct {
+	buf []byte
+	pos int
+	err error
+}
+
+func (r *compactReader) readByte() byte {
+	if r.pos >= len(r.buf) {
+		if r.err == nil {
+			r.err = io.ErrUnexpectedEOF
+		}
+		return 0
+	}
+
+	b := r.buf[r.pos]
+	r.pos++
+
+	return b
+}
+
+func (r *compactReader) uvarint() uint64 {
+	var v uint64
+
+	for shift := uint(0); shift < 64; shift += 7 {
+		b := r.readByte()
+		v |= uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			break
+		}
+	}
+
+	return v
+}
+
+func (r *compactReader) varint() int64 {
+	v := r.uvarint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *compactReader) readStruct() thriftStruct {
+	s := thriftStruct{}
+
+	var last int16
+
+	for r.err == nil {
+		header := r.readByte()
+		if header == 0 {
+			break
+		}
+
+		typ := header & 0x0f
+
+		id := last + int16(header>>4)
+		if header>>4 == 0 {
+			id = int16(r.varint())
+		}
+		last = id
+
+		switch typ {
+		case 1:
+			s[id] = true
+		case 2:
+			s[id] = false
+		default:
+			s[id] = r.readValue(typ)
+		}
+	}
+
+	return s
+}
+
+func (r *compactReader) readValue(typ byte) interface{} {
+	switch typ {
+	case 1, 2:
+		return r.readByte() == 1
+	case 3:
+		return int8(r.readByte())
+	case 4:
+		return int16(r.varint())
+	case 5:
+		return int32(r.varint())
+	case 6:
+		return r.varint()
+	case 7:
+		var b [8]byte
+		for i := range b {
+			b[i] = r.readByte()
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(b[:]))
+	case 8:
+		length := int(r.uvarint())
+		if r.pos+length > len(r.buf) {
+			r.err = io.ErrUnexpectedEOF
+			return nil
+		}
+		b := r.buf[r.pos : r.pos+length]
+		r.pos += length
+		return b
+	case 9, 10:
+		header := r.readByte()
+		size := int(header >> 4)
+		if size == 15 {
+			size = int(r.uvarint())
+		}
+		list := make([]interface{}, 0, size)
+		for i := 0; i < size && r.err == nil; i++ {
+			list = append(list, r.readValue(header&0x0f))
+		}
+		return list
+	case 12:
+		return r.readStruct()
+	default:
+		r.err = fmt.Errorf("unsupported thrift type %d", typ)
+		return nil
+	}
+}
+
+func TestWriteInvalid(t *testing.T) {
+	testcases := []struct {
+		label   string
+		columns []parquet.Column
+		rows    [][]interface{}
+	}{
+		{
+			label: "no columns",
+			rows:  [][]interface{}{},
+		},
+		{
+			label:   "wrong type",
+			columns: []parquet.Column{{Name: "messages", Type: parquet.Int64}},
+			rows:    [][]interface{}{{"12"}},
+		},
+		{
+			label:   "missing value",
+			columns: []parquet.Column{{Name: "messages", Type: parquet.Int64}, {Name: "bytes", Type: parquet.Int64}},
+			rows:    [][]interface{}{{12}},
+		},
+		{
+			label:   "required value nil",
+			columns: []parquet.Column{{Name: "last_message_at", Type: parquet.Timestamp}},
+			rows:    [][]interface{}{{nil}},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			var buf bytes.Buffer
+
+			err := parquet.Write(&buf, tc.columns, tc.rows)
+			assert.NotNil(t, err)
+			assert.Equal(t, 0, buf.Len())
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// the types of the Thrift compact protocol, in which Parquet metadata is
+// encoded
+const (
+	typeI32    = 5
+	typeI64    = 6
+	typeBinary = 8
+	typeList   = 9
+	typeStruct = 12
+)
+
+// compactWriter encodes Thrift structs with the compact protocol. Only the
+// types used by Parquet metadata are supported.
+type compactWriter struct {
+	buf bytes.Buffer
+
+	// last holds the id of the last field written to each open struct, as
+	// field ids are written as a delta from the previous field
+	last []int16
+}
+
+// newCompactWriter returns a writer with the top level struct open.
+func newCompactWriter() *compactWriter {
+	return &compactWriter{
+		last: []int16{0},
+	}
+}
+
+// bytes closes the top level struct and returns the encoding.
+func (w *compactWriter) bytes() []byte {
+	w.endStruct()
+	return w.buf.Bytes()
+}
+
+// field writes the header of a field of the open struct.
+func (w *compactWriter) field(id int16, typ byte) {
+	top := len(w.last) - 1
+	delta := id - w.last[top]
+
+	if delta > 0 && delta <= 15 {
+		w.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		w.buf.WriteByte(typ)
+		w.varint(zigzag(int64(id)))
+	}
+
+	w.last[top] = id
+}
+
+// i32 writes an i32 field.
+func (w *compactWriter) i32(id int16, v int32) {
+	w.field(id, typeI32)
+	w.rawI32(v)
+}
+
+// i64 writes an i64 field.
+func (w *compactWriter) i64(id int16, v int64) {
+	w.field(id, typeI64)
+	w.varint(zigzag(v))
+}
+
+// binary writes a binary or string field.
+func (w *compactWriter) binary(id int16, b []byte) {
+	w.field(id, typeBinary)
+	w.rawBinary(b)
+}
+
+// listField writes the header of a list field with the given number of
+// elements of the given type, which must then be written without field
+// headers.
+func (w *compactWriter) listField(id int16, elemType byte, size int) {
+	w.field(id, typeList)
+
+	if size < 15 {
+		w.buf.WriteByte(byte(size)<<4 | elemType)
+	} else {
+		w.buf.WriteByte(0xf0 | elemType)
+		w.varint(uint64(size))
+	}
+}
+
+// beginStructField writes the header of a struct field and opens the struct.
+func (w *compactWriter) beginStructField(id int16) {
+	w.field(id, typeStruct)
+	w.beginStruct()
+}
+
+// beginStruct opens a struct, such as an element of a list.
+func (w *compactWriter) beginStruct() {
+	w.last = append(w.last, 0)
+}
+
+// endStruct closes the open struct.
+func (w *compactWriter) endStruct() {
+	w.buf.WriteByte(0)
+	w.last = w.last[:len(w.last)-1]
+}
+
+// rawI32 writes an i32 value without a field header.
+func (w *compactWriter) rawI32(v int32) {
+	w.varint(zigzag(int64(v)))
+}
+
+// rawBinary writes a binary value without a field header.
+func (w *compactWriter) rawBinary(b []byte) {
+	w.varint(uint64(len(b)))
+	w.buf.Write(b)
+}
+
+// varint writes an unsigned varint.
+func (w *compactWriter) varint(v uint64) {
+	buf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(buf, v)
+	w.buf.Write(buf[:n])
+}
+
+// zigzag returns the zigzag encoding of a signed integer.
+func zigzag(v int64) uint64 {
+	return uint64((v << 1) ^ (v >> 63))
+}
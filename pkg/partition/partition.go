@@ -0,0 +1,383 @@
+// Package partition coordinates the ownership of devices between multiple
+// encoder instances sharing a database, so that each device is subscribed to
+// by exactly one instance. Ownership is recorded as leases held in Postgres,
+// which are renewed periodically by the owning instance. Should an instance
+// die its leases expire and are acquired by the surviving instances.
+package partition
+
+import (
+	"sync"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/DECODEproject/iotencoder/pkg/logger"
+	"github.com/DECODEproject/iotencoder/pkg/logger/level"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+)
+
+var (
+	// OwnedDevicesGauge is a gauge of the number of devices owned by this
+	// instance. Summed across instances it equals the number of devices.
+	OwnedDevicesGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "partition_owned_devices",
+			Help:      "Count of devices owned by this instance",
+		},
+	)
+
+	// LiveInstancesGauge is a gauge of the number of live instances seen by
+	// this instance at its last rebalance.
+	LiveInstancesGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "partition_live_instances",
+			Help:      "Count of live encoder instances sharing the database",
+		},
+	)
+
+	// LeaseChangesCounter is a counter of changes to the leases held by this
+	// instance, labelled by whether a lease was acquired, released or lost.
+	LeaseChangesCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "partition_lease_changes",
+			Help:      "Count of device leases acquired, released or lost by this instance",
+		},
+		[]string{"change"},
+	)
+)
+
+// Store is the interface to the lease storage used by the coordinator. It is
+// satisfied by the postgres.DB type, and by the in-memory postgrestest.DB for
+// tests.
+type Store interface {
+	Heartbeat(instanceID string, ttl time.Duration) (int, error)
+	RemoveInstance(instanceID string) error
+	CountDevices() (int, error)
+	RenewLeases(instanceID string, ttl time.Duration) ([]*postgres.Device, error)
+	AcquireLeases(instanceID string, ttl time.Duration, limit int) ([]*postgres.Device, error)
+	AcquireLease(instanceID string, deviceToken secret.Secret, ttl time.Duration) (bool, error)
+	ReleaseLease(instanceID string, deviceToken secret.Secret) error
+}
+
+// Owner is the interface of the component which acts on the devices owned by
+// this instance. Own is called when a lease on a device is acquired, and
+// Disown when the lease is released or lost.
+type Owner interface {
+	Own(device *postgres.Device) error
+	Disown(device *postgres.Device) error
+}
+
+// Config is used to pass in configuration when creating a Coordinator.
+type Config struct {
+	// Store holds the leases and instance heartbeats.
+	Store Store
+
+	// InstanceID uniquely identifies this instance.
+	InstanceID string
+
+	// TTL is the duration for which leases and heartbeats are held without
+	// being renewed. An instance that dies has its devices taken over within
+	// TTL plus Interval.
+	TTL time.Duration
+
+	// Interval is the interval at which leases are renewed and devices are
+	// rebalanced between instances. It must be shorter than TTL.
+	Interval time.Duration
+}
+
+// Coordinator is a component that partitions devices between encoder
+// instances. On each tick of its interval it renews the leases it holds, then
+// acquires or releases leases so that it owns its fair share of the devices,
+// that is the number of devices divided by the number of live instances
+// rounded up.
+type Coordinator struct {
+	store      Store
+	instanceID string
+	ttl        time.Duration
+	interval   time.Duration
+	logger     kitlog.Logger
+
+	sync.Mutex
+	owner Owner
+	owned map[secret.Secret]*postgres.Device
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewCoordinator returns a new Coordinator configured with the given Config.
+func NewCoordinator(config *Config, logger kitlog.Logger) *Coordinator {
+	logger = kitlog.With(logger, "module", "partition", "instance", config.InstanceID)
+
+	return &Coordinator{
+		store:      config.Store,
+		instanceID: config.InstanceID,
+		ttl:        config.TTL,
+		interval:   config.Interval,
+		logger:     logger,
+		owned:      make(map[secret.Secret]*postgres.Device),
+	}
+}
+
+// Start performs an initial rebalance, calling Own on the given owner for each
+// device acquired, and then starts a goroutine which rebalances on each tick
+// of our interval.
+func (c *Coordinator) Start(owner Owner) error {
+	c.logger.Log("msg", "starting partition coordinator", "ttl", c.ttl, "interval", c.interval)
+
+	if c.instanceID == "" {
+		return errors.New("partition instance id must not be empty")
+	}
+
+	if c.interval <= 0 || c.interval >= c.ttl {
+		return errors.New("partition interval must be positive and shorter than the lease ttl")
+	}
+
+	c.Lock()
+	c.owner = owner
+	c.Unlock()
+
+	err := c.Rebalance()
+	if err != nil {
+		return err
+	}
+
+	c.quit = make(chan struct{})
+	c.wg.Add(1)
+
+	go c.loop()
+
+	return nil
+}
+
+// Stop stops the rebalance goroutine and gives up all leases held by this
+// instance, so that other instances take over its devices at their next
+// rebalance.
+func (c *Coordinator) Stop() error {
+	if c.quit == nil {
+		return nil
+	}
+
+	c.logger.Log("msg", "stopping partition coordinator")
+
+	close(c.quit)
+	c.wg.Wait()
+
+	c.Lock()
+	defer c.Unlock()
+
+	for token, device := range c.owned {
+		c.disown(device)
+		delete(c.owned, token)
+	}
+
+	OwnedDevicesGauge.Set(0)
+
+	return errors.Wrap(c.store.RemoveInstance(c.instanceID), "failed to remove instance")
+}
+
+// Claim attempts to take ownership of a newly created device, returning true
+// if this instance now owns it. This allows a new device to be subscribed to
+// immediately rather than at the next rebalance, after which it may be handed
+// over to another instance.
+func (c *Coordinator) Claim(device *postgres.Device) (bool, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	if _, ok := c.owned[device.DeviceToken]; ok {
+		return true, nil
+	}
+
+	acquired, err := c.store.AcquireLease(c.instanceID, device.DeviceToken, c.ttl)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to claim device")
+	}
+
+	if !acquired {
+		return false, nil
+	}
+
+	LeaseChangesCounter.WithLabelValues("acquired").Inc()
+
+	return c.own(device), nil
+}
+
+// Release gives up ownership of a device which has been deleted. If the device
+// is not owned by this instance this is a no-op.
+func (c *Coordinator) Release(device *postgres.Device) error {
+	c.Lock()
+	defer c.Unlock()
+
+	owned, ok := c.owned[device.DeviceToken]
+	if !ok {
+		return nil
+	}
+
+	c.disown(owned)
+	delete(c.owned, device.DeviceToken)
+
+	OwnedDevicesGauge.Set(float64(len(c.owned)))
+	LeaseChangesCounter.WithLabelValues("released").Inc()
+
+	return errors.Wrap(c.store.ReleaseLease(c.instanceID, device.DeviceToken), "failed to release device")
+}
+
+// Rebalance renews the leases held by this instance, disowning any devices
+// whose lease has been lost, and then acquires or releases leases so that
+// this instance owns its fair share of the devices.
+func (c *Coordinator) Rebalance() error {
+	c.Lock()
+	defer c.Unlock()
+
+	live, err := c.store.Heartbeat(c.instanceID, c.ttl)
+	if err != nil {
+		return errors.Wrap(err, "failed to record heartbeat")
+	}
+
+	total, err := c.store.CountDevices()
+	if err != nil {
+		return errors.Wrap(err, "failed to count devices")
+	}
+
+	renewed, err := c.store.RenewLeases(c.instanceID, c.ttl)
+	if err != nil {
+		return errors.Wrap(err, "failed to renew leases")
+	}
+
+	held := make(map[secret.Secret]bool, len(renewed))
+	for _, device := range renewed {
+		held[device.DeviceToken] = true
+	}
+
+	// devices we owned but no longer hold a lease for have been deleted or taken
+	// over by another instance
+	for token, device := range c.owned {
+		if !held[token] {
+			level.Warn(c.logger).Log("msg", "lease lost", "device_hash", logger.HashToken(token))
+			c.disown(device)
+			delete(c.owned, token)
+			LeaseChangesCounter.WithLabelValues("lost").Inc()
+		}
+	}
+
+	// leases renewed for devices we don't yet own, for example having been
+	// acquired by a previous run of this instance, are taken up
+	for _, device := range renewed {
+		if _, ok := c.owned[device.DeviceToken]; !ok {
+			c.own(device)
+		}
+	}
+
+	share := fairShare(total, live)
+
+	if len(renewed) > share {
+		// renewed is ordered by device id, so we release the newest devices
+		for _, device := range renewed[share:] {
+			if owned, ok := c.owned[device.DeviceToken]; ok {
+				c.disown(owned)
+				delete(c.owned, device.DeviceToken)
+			}
+
+			err = c.store.ReleaseLease(c.instanceID, device.DeviceToken)
+			if err != nil {
+				return errors.Wrap(err, "failed to release lease")
+			}
+
+			LeaseChangesCounter.WithLabelValues("released").Inc()
+		}
+	} else if len(renewed) < share {
+		acquired, err := c.store.AcquireLeases(c.instanceID, c.ttl, share-len(renewed))
+		if err != nil {
+			return errors.Wrap(err, "failed to acquire leases")
+		}
+
+		for _, device := range acquired {
+			LeaseChangesCounter.WithLabelValues("acquired").Inc()
+			c.own(device)
+		}
+	}
+
+	OwnedDevicesGauge.Set(float64(len(c.owned)))
+	LiveInstancesGauge.Set(float64(live))
+
+	return nil
+}
+
+// Owned returns the number of devices currently owned by this instance.
+func (c *Coordinator) Owned() int {
+	c.Lock()
+	defer c.Unlock()
+
+	return len(c.owned)
+}
+
+// own calls Own on our owner for the device, recording the device as owned if
+// successful. If Own fails the lease is released so that another instance may
+// take the device. Must be called with the lock held.
+func (c *Coordinator) own(device *postgres.Device) bool {
+	err := c.owner.Own(device)
+	if err != nil {
+		level.Error(c.logger).Log("msg", "failed to own device", "err", err, "device_hash", logger.HashToken(device.DeviceToken))
+
+		err = c.store.ReleaseLease(c.instanceID, device.DeviceToken)
+		if err != nil {
+			level.Error(c.logger).Log("msg", "failed to release lease", "err", err, "device_hash", logger.HashToken(device.DeviceToken))
+		}
+
+		return false
+	}
+
+	c.owned[device.DeviceToken] = device
+	OwnedDevicesGauge.Set(float64(len(c.owned)))
+
+	return true
+}
+
+// disown calls Disown on our owner for the device, logging any error. Must be
+// called with the lock held.
+func (c *Coordinator) disown(device *postgres.Device) {
+	err := c.owner.Disown(device)
+	if err != nil {
+		level.Error(c.logger).Log("msg", "failed to disown device", "err", err, "device_hash", logger.HashToken(device.DeviceToken))
+	}
+}
+
+// loop is run in a goroutine and rebalances on each tick of our interval until
+// the coordinator is stopped.
+func (c *Coordinator) loop() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := c.Rebalance()
+			if err != nil {
+				level.Error(c.logger).Log("msg", "failed to rebalance devices", "err", err)
+			}
+		case <-c.quit:
+			return
+		}
+	}
+}
+
+// fairShare returns the number of devices each of the live instances should
+// own, rounding up so that every device is owned.
+func fairShare(total, live int) int {
+	if live < 1 {
+		live = 1
+	}
+
+	return (total + live - 1) / live
+}
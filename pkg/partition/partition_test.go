@@ -0,0 +1,228 @@
+package partition_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/partition"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/postgres/postgrestest"
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+)
+
+// owner records the devices it has been asked to own.
+type owner struct {
+	sync.Mutex
+	devices map[string]bool
+}
+
+func newOwner() *owner {
+	return &owner{devices: make(map[string]bool)}
+}
+
+func (o *owner) Own(device *postgres.Device) error {
+	o.Lock()
+	defer o.Unlock()
+
+	o.devices[device.DeviceToken.Reveal()] = true
+	return nil
+}
+
+func (o *owner) Disown(device *postgres.Device) error {
+	o.Lock()
+	defer o.Unlock()
+
+	delete(o.devices, device.DeviceToken.Reveal())
+	return nil
+}
+
+func (o *owner) count() int {
+	o.Lock()
+	defer o.Unlock()
+
+	return len(o.devices)
+}
+
+func (o *owner) owns(token string) bool {
+	o.Lock()
+	defer o.Unlock()
+
+	return o.devices[token]
+}
+
+func newDB(t *testing.T, tokens ...string) *postgrestest.DB {
+	db := postgrestest.NewDB()
+
+	for _, token := range tokens {
+		_, err := db.CreateStream(&postgres.Stream{
+			CommunityID: "policy-id",
+			Device:      &postgres.Device{DeviceToken: secret.Secret(token)},
+		})
+		assert.Nil(t, err)
+	}
+
+	return db
+}
+
+func newCoordinator(db *postgrestest.DB, instanceID string) *partition.Coordinator {
+	// the interval is long enough that rebalancing is only triggered by tests
+	return partition.NewCoordinator(&partition.Config{
+		Store:      db,
+		InstanceID: instanceID,
+		TTL:        2 * time.Hour,
+		Interval:   time.Hour,
+	}, kitlog.NewNopLogger())
+}
+
+func TestPartitioning(t *testing.T) {
+	db := newDB(t, "a", "b", "c", "d", "e")
+	ownerA, ownerB := newOwner(), newOwner()
+
+	coordA := newCoordinator(db, "instance-a")
+	err := coordA.Start(ownerA)
+	assert.Nil(t, err)
+	defer coordA.Stop()
+
+	// a lone instance owns every device
+	assert.Equal(t, 5, ownerA.count())
+
+	coordB := newCoordinator(db, "instance-b")
+	err = coordB.Start(ownerB)
+	assert.Nil(t, err)
+	defer coordB.Stop()
+
+	// all devices are leased, so b must wait for a to release its excess
+	assert.Equal(t, 0, ownerB.count())
+
+	err = coordA.Rebalance()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, ownerA.count())
+
+	err = coordB.Rebalance()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, ownerB.count())
+
+	// every device is owned by exactly one instance
+	for _, token := range []string{"a", "b", "c", "d", "e"} {
+		assert.True(t, ownerA.owns(token) != ownerB.owns(token), token)
+	}
+}
+
+func TestTakeover(t *testing.T) {
+	db := newDB(t, "a", "b", "c", "d")
+	mockClock := clock.NewMock(time.Now())
+	db.SetClock(mockClock)
+
+	ownerA, ownerB := newOwner(), newOwner()
+
+	coordA := newCoordinator(db, "instance-a")
+	err := coordA.Start(ownerA)
+	assert.Nil(t, err)
+
+	coordB := newCoordinator(db, "instance-b")
+	err = coordB.Start(ownerB)
+	assert.Nil(t, err)
+	defer coordB.Stop()
+
+	err = coordA.Rebalance()
+	assert.Nil(t, err)
+
+	err = coordB.Rebalance()
+	assert.Nil(t, err)
+
+	assert.Equal(t, 2, ownerA.count())
+	assert.Equal(t, 2, ownerB.count())
+
+	// instance a dies without giving up its leases, which b takes over once
+	// they expire
+	mockClock.Add(time.Hour)
+
+	err = coordB.Rebalance()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, ownerB.count())
+
+	mockClock.Add(90 * time.Minute)
+
+	err = coordB.Rebalance()
+	assert.Nil(t, err)
+	assert.Equal(t, 4, ownerB.count())
+
+	// if a recovers it finds its leases lost
+	err = coordA.Rebalance()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, ownerA.count())
+}
+
+func TestStopReleasesLeases(t *testing.T) {
+	db := newDB(t, "a", "b")
+	ownerA, ownerB := newOwner(), newOwner()
+
+	coordA := newCoordinator(db, "instance-a")
+	err := coordA.Start(ownerA)
+	assert.Nil(t, err)
+
+	coordB := newCoordinator(db, "instance-b")
+	err = coordB.Start(ownerB)
+	assert.Nil(t, err)
+	defer coordB.Stop()
+
+	err = coordA.Stop()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, ownerA.count())
+	assert.Equal(t, "", db.LeaseHolder("a"))
+
+	err = coordB.Rebalance()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, ownerB.count())
+}
+
+func TestClaimAndRelease(t *testing.T) {
+	db := newDB(t)
+	o := newOwner()
+
+	coord := newCoordinator(db, "instance-a")
+	err := coord.Start(o)
+	assert.Nil(t, err)
+	defer coord.Stop()
+
+	_, err = db.CreateStream(&postgres.Stream{
+		CommunityID: "policy-id",
+		Device:      &postgres.Device{DeviceToken: "a"},
+	})
+	assert.Nil(t, err)
+
+	claimed, err := coord.Claim(&postgres.Device{DeviceToken: "a"})
+	assert.Nil(t, err)
+	assert.True(t, claimed)
+	assert.True(t, o.owns("a"))
+	assert.Equal(t, "instance-a", db.LeaseHolder("a"))
+
+	// a device leased by another instance cannot be claimed
+	other := newCoordinator(db, "instance-b")
+	claimed, err = other.Claim(&postgres.Device{DeviceToken: "a"})
+	assert.Nil(t, err)
+	assert.False(t, claimed)
+
+	err = coord.Release(&postgres.Device{DeviceToken: "a"})
+	assert.Nil(t, err)
+	assert.False(t, o.owns("a"))
+	assert.Equal(t, "", db.LeaseHolder("a"))
+	assert.Equal(t, 0, coord.Owned())
+}
+
+func TestInvalidConfig(t *testing.T) {
+	coord := partition.NewCoordinator(&partition.Config{
+		Store:      postgrestest.NewDB(),
+		InstanceID: "instance-a",
+		TTL:        time.Second,
+		Interval:   time.Minute,
+	}, kitlog.NewNopLogger())
+
+	err := coord.Start(newOwner())
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,51 @@
+package pipeline
+
+import (
+	"context"
+
+	"github.com/DECODEproject/iotencoder/pkg/archive"
+	"github.com/DECODEproject/iotencoder/pkg/logger"
+	"github.com/DECODEproject/iotencoder/pkg/logger/level"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/requestid"
+)
+
+// Archiver is the interface we use to write the encrypted payloads of streams
+// to object storage for long-term retention. It is satisfied by the
+// archive.Archiver type.
+type Archiver interface {
+	Archive(ctx context.Context, obj *archive.Object) error
+}
+
+// archiveStage returns a stage writing the encrypted payloads of the message to
+// our archive, if the stream is archived. Archival is best effort, so a
+// payload which can't be archived is logged and still written to the
+// datastore. Replayed payloads were archived when first received, so are not
+// archived again.
+func (p *Processor) archiveStage(stream *postgres.Stream) Stage {
+	if !stream.Archive || p.archiver == nil {
+		return nil
+	}
+
+	return StageFunc(func(ctx context.Context, msg *Message) (*Message, error) {
+		if isReplay(ctx) {
+			return msg, nil
+		}
+
+		for _, encodedPayload := range msg.Encrypted {
+			err := p.archiver.Archive(ctx, &archive.Object{
+				StreamID:    stream.StreamID,
+				CommunityID: stream.CommunityID,
+				DeviceHash:  logger.HashToken(msg.Device.DeviceToken),
+				RequestID:   requestid.FromContext(ctx),
+				ReceivedAt:  msg.ReceivedAt,
+				Data:        encodedPayload,
+			})
+			if err != nil {
+				level.Warn(msg.Logger).Log("err", err, "msg", "failed to archive data")
+			}
+		}
+
+		return msg, nil
+	})
+}
@@ -0,0 +1,132 @@
+package pipeline_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	datastore "github.com/thingful/twirp-datastore-go"
+
+	"github.com/DECODEproject/iotencoder/pkg/archive"
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/logger"
+	"github.com/DECODEproject/iotencoder/pkg/lua"
+	"github.com/DECODEproject/iotencoder/pkg/mocks"
+	"github.com/DECODEproject/iotencoder/pkg/pipeline"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/stats"
+)
+
+// fakeArchiver records the objects it is asked to archive, failing with err
+// if set.
+type fakeArchiver struct {
+	objects []*archive.Object
+	err     error
+}
+
+func (f *fakeArchiver) Archive(ctx context.Context, obj *archive.Object) error {
+	f.objects = append(f.objects, obj)
+	return f.err
+}
+
+func TestProcessWithArchive(t *testing.T) {
+	testcases := []struct {
+		label   string
+		err     error
+		archive bool
+		ctx     context.Context
+		objects int
+	}{
+		{
+			label:   "archived stream",
+			archive: true,
+			ctx:     context.Background(),
+			objects: 1,
+		},
+		{
+			label:   "unarchived stream",
+			archive: false,
+			ctx:     context.Background(),
+			objects: 0,
+		},
+		{
+			label:   "replayed payload",
+			archive: true,
+			ctx:     pipeline.WithReplay(context.Background()),
+			objects: 0,
+		},
+		{
+			label:   "archive failure",
+			archive: true,
+			err:     errors.New("unavailable"),
+			ctx:     context.Background(),
+			objects: 1,
+		},
+	}
+
+	passthroughExec := func(script, keys, data []byte) ([]byte, error) {
+		return json.Marshal(string(data))
+	}
+
+	payload := []byte(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":13,"value":51.00}]}]}`)
+	deviceHash := logger.HashToken("foo")
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			logger := kitlog.NewNopLogger()
+			ds := mocks.Datastore{}
+
+			ds.On(
+				"WriteData",
+				mock.Anything,
+				mock.Anything,
+			).Return(
+				&datastore.WriteResponse{},
+				nil,
+			)
+
+			archiver := &fakeArchiver{err: tc.err}
+
+			processor := pipeline.NewProcessor(&pipeline.Config{
+				Datastore: datastore.Datastore(&ds),
+				Stats:     stats.NewStore(nil, time.Minute, clock.New(), logger),
+				Scripts:   lua.NewScripts(&lua.Config{}, logger),
+				Zenroom:   pipeline.NewZenroomPool(1, time.Second, passthroughExec),
+				Archiver:  archiver,
+			}, logger)
+
+			device := &postgres.Device{
+				DeviceToken: "foo",
+				Streams: []*postgres.Stream{
+					{
+						StreamID:    "abc123",
+						CommunityID: "smartcitizen",
+						PublicKey:   `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+						Archive:     tc.archive,
+					},
+				},
+			}
+
+			err := processor.Process(tc.ctx, device, payload)
+			assert.Nil(t, err)
+
+			// payloads are written to the datastore whether or not they were archived
+			ds.AssertNumberOfCalls(t, "WriteData", 1)
+
+			assert.Len(t, archiver.objects, tc.objects)
+
+			if tc.objects > 0 {
+				obj := archiver.objects[0]
+				assert.Equal(t, "abc123", obj.StreamID)
+				assert.Equal(t, "smartcitizen", obj.CommunityID)
+				assert.Equal(t, deviceHash, obj.DeviceHash)
+				assert.Equal(t, ds.Calls[0].Arguments[1].(*datastore.WriteRequest).Data, obj.Data)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package pipeline_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	datastore "github.com/thingful/twirp-datastore-go"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/lua"
+	"github.com/DECODEproject/iotencoder/pkg/mocks"
+	"github.com/DECODEproject/iotencoder/pkg/pipeline"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/stats"
+)
+
+const benchmarkPublicKey = `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`
+
+var benchmarkPayload = []byte(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":13, "value":51.00},{"id":14, "value":426.42},{"id":12, "value":12.58},{"id":29, "value":79.35},{"id":53, "value":51.00},{"id":58, "value":101.56},{"id":89, "value":4.00},{"id":87, "value":7.00},{"id":88, "value":7.00}]}]}`)
+
+// nopDatastore discards writes, so that benchmarks measure only our own work
+// rather than the overhead of a mock.
+type nopDatastore struct{}
+
+func (nopDatastore) WriteData(ctx context.Context, req *datastore.WriteRequest) (*datastore.WriteResponse, error) {
+	return &datastore.WriteResponse{}, nil
+}
+
+func (nopDatastore) ReadData(ctx context.Context, req *datastore.ReadRequest) (*datastore.ReadResponse, error) {
+	return &datastore.ReadResponse{}, nil
+}
+
+func BenchmarkEncrypt(b *testing.B) {
+	script, err := lua.Asset("encrypt.lua")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	keys := []byte(fmt.Sprintf(`{"device_token":"foo","community_id":"smartcitizen","community_pubkey":"%s"}`, benchmarkPublicKey))
+	pool := pipeline.NewZenroomPool(1, time.Minute, pipeline.ZenroomExec)
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_, err := pool.Exec(context.Background(), script, keys, benchmarkPayload)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkProcess(b *testing.B) {
+	for _, streams := range []int{1, 4} {
+		b.Run(fmt.Sprintf("streams=%d", streams), func(b *testing.B) {
+			logger := kitlog.NewNopLogger()
+
+			processor := pipeline.NewProcessor(&pipeline.Config{
+				Datastore:      nopDatastore{},
+				MovingAverager: &mocks.MovingAverager{},
+				Stats:          stats.NewStore(nil, time.Minute, clock.New(), logger),
+				Scripts:        lua.NewScripts(&lua.Config{}, logger),
+				Zenroom:        pipeline.NewZenroomPool(1, time.Minute, pipeline.ZenroomExec),
+			}, logger)
+
+			device := &postgres.Device{
+				DeviceToken: "foo",
+				Longitude:   2.234,
+				Latitude:    45.23,
+				Exposure:    "indoor",
+			}
+
+			for i := 0; i < streams; i++ {
+				device.Streams = append(device.Streams, &postgres.Stream{
+					StreamID:    fmt.Sprintf("stream-%d", i),
+					CommunityID: "smartcitizen",
+					PublicKey:   benchmarkPublicKey,
+				})
+			}
+
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				err := processor.Process(context.Background(), device, benchmarkPayload)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,267 @@
+package pipeline
+
+import (
+	"context"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/smartcitizen"
+)
+
+var (
+	// StageDurationSummary is a prometheus summary vec recording the time spent
+	// in the stages of each phase while processing a payload for a stream,
+	// labelled by the phase, so that when processing slows down the phase
+	// responsible is apparent.
+	StageDurationSummary = prometheus.NewSummaryVec(
+		prometheus.SummaryOpts{
+			Namespace:  "decode",
+			Subsystem:  "encoder",
+			Name:       "pipeline_stage_seconds",
+			Help:       "Time spent in each phase of processing a payload for a stream",
+			Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+		},
+		[]string{"phase"},
+	)
+
+	// StageErrorsCounter is a prometheus counter vec recording the number of
+	// errors returned by the stages of each phase, labelled by the phase.
+	StageErrorsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "pipeline_stage_errors",
+			Help:      "Count of errors returned by the stages of each phase of processing",
+		},
+		[]string{"phase"},
+	)
+)
+
+// Phase orders the stages of a chain. Stages run in the order of their phase,
+// and within a phase in the order in which they were added to the Builder.
+type Phase int
+
+const (
+	// Decode stages parse the payload into readings.
+	Decode Phase = iota
+
+	// Validate stages reject payloads or readings the stream won't accept.
+	Validate
+
+	// Filter stages remove readings which are not to be encrypted.
+	Filter
+
+	// Transform stages alter the readings or their metadata, such as by
+	// converting them into the units the stream expects.
+	Transform
+
+	// Aggregate stages apply the stream's operations to the readings.
+	Aggregate
+
+	// Encrypt stages encode the readings for the stream's recipient.
+	Encrypt
+
+	// Sink stages write the encrypted payloads to the stream's datastore.
+	Sink
+
+	numPhases
+)
+
+// String returns the name of the phase, as used in log lines.
+func (p Phase) String() string {
+	switch p {
+	case Decode:
+		return "decode"
+	case Validate:
+		return "validate"
+	case Filter:
+		return "filter"
+	case Transform:
+		return "transform"
+	case Aggregate:
+		return "aggregate"
+	case Encrypt:
+		return "encrypt"
+	case Sink:
+		return "sink"
+	default:
+		return "unknown"
+	}
+}
+
+// Message is the state carried through a chain while processing a payload for
+// one stream. Stages must not modify the Reading they are passed, as it may be
+// shared with the chains of the device's other streams, and should instead
+// replace it with a modified copy.
+type Message struct {
+	// Device is the device which sent the payload, and Stream the stream for
+	// which it is being processed.
+	Device *postgres.Device
+	Stream *postgres.Stream
+
+	// Payload is the payload as received, which is parsed into Reading by the
+	// Decode phase. Reading then holds the readings of the payload as
+	// processed so far, while Plaintext holds any to be written unencrypted.
+	Payload   *Payload
+	Reading   *smartcitizen.Device
+	Plaintext *Plaintext
+
+	// Dropped counts the readings deliberately not written, such as by
+	// downsampling. If any were dropped and none remain there is nothing to
+	// encrypt.
+	Dropped int
+
+	// SamplePercent is the percentage of the stream's messages sampled, if
+	// the message was selected by sampling.
+	SamplePercent float64
+
+	// DeliverAfter is the time before which the message's encrypted payloads
+	// may not be written, if it was received outside the stream's schedule
+	// and is being buffered.
+	DeliverAfter time.Time
+
+	// Encrypted holds the encrypted payloads to be written, of which there
+	// is more than one if the payload was chunked.
+	Encrypted [][]byte
+
+	// ReceivedAt is the time at which processing began, and Logger the logger
+	// of the stream.
+	ReceivedAt time.Time
+	Logger     kitlog.Logger
+}
+
+// Payload is a payload received from a device, which is parsed only once
+// however many of the device's streams it is processed for.
+type Payload struct {
+	Data []byte
+
+	parsed bool
+	device *smartcitizen.Device
+	err    error
+}
+
+// NewPayload returns a new Payload holding the given data.
+func NewPayload(data []byte) *Payload {
+	return &Payload{Data: data}
+}
+
+// empty returns true if every reading of the message was dropped, so that
+// there is nothing to write.
+func (m *Message) empty() bool {
+	return m.Dropped > 0 && len(m.Reading.Sensors) == 0 && m.Plaintext == nil
+}
+
+// Stage is the interface implemented by each step of processing a payload for
+// a stream. Process returns the message to pass to the next stage, or nil if
+// processing should stop without writing anything, such as when the stream
+// rejects the payload.
+type Stage interface {
+	Process(ctx context.Context, msg *Message) (*Message, error)
+}
+
+// StageFunc is an adapter allowing an ordinary function to be used as a Stage.
+type StageFunc func(ctx context.Context, msg *Message) (*Message, error)
+
+// Process calls f(ctx, msg).
+func (f StageFunc) Process(ctx context.Context, msg *Message) (*Message, error) {
+	return f(ctx, msg)
+}
+
+// StageFactory returns the stage to apply for the given stream, or nil if the
+// stream requires no such processing.
+type StageFactory func(stream *postgres.Stream) Stage
+
+// Builder assembles the chain of stages applied to each stream from the
+// factories added for each phase. Factories must be added before the Builder
+// is used to build chains.
+type Builder struct {
+	factories [numPhases][]StageFactory
+}
+
+// NewBuilder returns a new Builder without any factories.
+func NewBuilder() *Builder {
+	return &Builder{}
+}
+
+// Use adds a factory of stages to run in the given phase, after any already
+// added for that phase. It returns the builder so calls may be chained.
+func (b *Builder) Use(phase Phase, factory StageFactory) *Builder {
+	b.factories[phase] = append(b.factories[phase], factory)
+	return b
+}
+
+// Build returns the chain of stages to apply for the given stream.
+func (b *Builder) Build(stream *postgres.Stream) Chain {
+	chain := Chain{}
+
+	for phase, factories := range b.factories {
+		for _, factory := range factories {
+			if stage := factory(stream); stage != nil {
+				chain = append(chain, link{phase: Phase(phase), stage: stage})
+			}
+		}
+	}
+
+	return chain
+}
+
+// link is a stage of a chain along with the phase in which it runs.
+type link struct {
+	phase Phase
+	stage Stage
+}
+
+// Chain is a sequence of stages built for a stream.
+type Chain []link
+
+// Process passes the message through each stage of the chain in turn,
+// returning the message produced by the last. If any stage returns an error,
+// or returns nil to stop processing, the remaining stages are skipped. The
+// time spent in the stages of each phase that ran is recorded in
+// StageDurationSummary, and errors in StageErrorsCounter.
+func (c Chain) Process(ctx context.Context, msg *Message) (*Message, error) {
+	var (
+		durations [numPhases]time.Duration
+		ran       [numPhases]bool
+	)
+
+	defer func() {
+		for phase, duration := range durations {
+			if ran[phase] {
+				StageDurationSummary.WithLabelValues(Phase(phase).String()).Observe(duration.Seconds())
+			}
+		}
+	}()
+
+	for _, l := range c {
+		var err error
+
+		start := time.Now()
+		msg, err = l.stage.Process(ctx, msg)
+		durations[l.phase] += time.Since(start)
+		ran[l.phase] = true
+
+		if err != nil {
+			StageErrorsCounter.WithLabelValues(l.phase.String()).Inc()
+			return nil, err
+		}
+
+		if msg == nil {
+			return nil, nil
+		}
+	}
+
+	return msg, nil
+}
+
+// Phases returns the phase of each stage of the chain, in order.
+func (c Chain) Phases() []Phase {
+	phases := make([]Phase, 0, len(c))
+	for _, l := range c {
+		phases = append(phases, l.phase)
+	}
+	return phases
+}
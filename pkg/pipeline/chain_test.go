@@ -0,0 +1,238 @@
+package pipeline_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	datastore "github.com/thingful/twirp-datastore-go"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/lua"
+	"github.com/DECODEproject/iotencoder/pkg/mocks"
+	"github.com/DECODEproject/iotencoder/pkg/pipeline"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/smartcitizen"
+	"github.com/DECODEproject/iotencoder/pkg/stats"
+)
+
+// recordingStage returns a stage factory whose stages append the given name
+// to the label of the readings they are passed.
+func recordingStage(name string) pipeline.StageFactory {
+	return func(stream *postgres.Stream) pipeline.Stage {
+		return pipeline.StageFunc(func(ctx context.Context, msg *pipeline.Message) (*pipeline.Message, error) {
+			reading := *msg.Reading
+			reading.Label += name
+
+			msg.Reading = &reading
+
+			return msg, nil
+		})
+	}
+}
+
+func TestBuilder(t *testing.T) {
+	builder := pipeline.NewBuilder().
+		Use(pipeline.Sink, recordingStage("e")).
+		Use(pipeline.Decode, recordingStage("a")).
+		Use(pipeline.Transform, recordingStage("c")).
+		Use(pipeline.Validate, recordingStage("b")).
+		Use(pipeline.Transform, recordingStage("d")).
+		Use(pipeline.Filter, func(stream *postgres.Stream) pipeline.Stage {
+			return nil
+		})
+
+	chain := builder.Build(&postgres.Stream{})
+
+	// stages run in the order of their phase, and within a phase in the order
+	// in which they were added, while factories returning nil are skipped
+	assert.Equal(t, []pipeline.Phase{pipeline.Decode, pipeline.Validate, pipeline.Transform, pipeline.Transform, pipeline.Sink}, chain.Phases())
+
+	reading := &smartcitizen.Device{}
+
+	msg, err := chain.Process(context.Background(), &pipeline.Message{Reading: reading})
+	assert.Nil(t, err)
+	assert.Equal(t, "abcde", msg.Reading.Label)
+
+	// the reading passed in is not modified
+	assert.Equal(t, "", reading.Label)
+}
+
+func TestChainStops(t *testing.T) {
+	stop := func(stream *postgres.Stream) pipeline.Stage {
+		return pipeline.StageFunc(func(ctx context.Context, msg *pipeline.Message) (*pipeline.Message, error) {
+			return nil, nil
+		})
+	}
+
+	fail := func(stream *postgres.Stream) pipeline.Stage {
+		return pipeline.StageFunc(func(ctx context.Context, msg *pipeline.Message) (*pipeline.Message, error) {
+			return nil, errors.New("failed")
+		})
+	}
+
+	msg, err := pipeline.NewBuilder().
+		Use(pipeline.Filter, stop).
+		Use(pipeline.Sink, fail).
+		Build(&postgres.Stream{}).
+		Process(context.Background(), &pipeline.Message{Reading: &smartcitizen.Device{}})
+	assert.Nil(t, err)
+	assert.Nil(t, msg)
+
+	msg, err = pipeline.NewBuilder().
+		Use(pipeline.Filter, fail).
+		Use(pipeline.Sink, recordingStage("a")).
+		Build(&postgres.Stream{}).
+		Process(context.Background(), &pipeline.Message{Reading: &smartcitizen.Device{}})
+	assert.Equal(t, "failed", err.Error())
+	assert.Nil(t, msg)
+}
+
+func TestChainMetrics(t *testing.T) {
+	stageCount := func(phase pipeline.Phase) uint64 {
+		var metric dto.Metric
+		err := pipeline.StageDurationSummary.WithLabelValues(phase.String()).Write(&metric)
+		assert.Nil(t, err)
+		return metric.GetSummary().GetSampleCount()
+	}
+
+	errorCount := func(phase pipeline.Phase) float64 {
+		var metric dto.Metric
+		err := pipeline.StageErrorsCounter.WithLabelValues(phase.String()).Write(&metric)
+		assert.Nil(t, err)
+		return metric.GetCounter().GetValue()
+	}
+
+	fail := func(stream *postgres.Stream) pipeline.Stage {
+		return pipeline.StageFunc(func(ctx context.Context, msg *pipeline.Message) (*pipeline.Message, error) {
+			return nil, errors.New("failed")
+		})
+	}
+
+	decode, aggregate, encrypt, sink := stageCount(pipeline.Decode), stageCount(pipeline.Aggregate), stageCount(pipeline.Encrypt), stageCount(pipeline.Sink)
+	encryptErrors := errorCount(pipeline.Encrypt)
+
+	_, err := pipeline.NewBuilder().
+		Use(pipeline.Decode, recordingStage("a")).
+		Use(pipeline.Aggregate, recordingStage("b")).
+		Use(pipeline.Aggregate, recordingStage("c")).
+		Use(pipeline.Encrypt, fail).
+		Use(pipeline.Sink, recordingStage("d")).
+		Build(&postgres.Stream{}).
+		Process(context.Background(), &pipeline.Message{Reading: &smartcitizen.Device{}})
+	assert.NotNil(t, err)
+
+	// each phase that ran is observed once however many stages it has, while
+	// phases skipped after an error are not observed at all
+	assert.Equal(t, decode+1, stageCount(pipeline.Decode))
+	assert.Equal(t, aggregate+1, stageCount(pipeline.Aggregate))
+	assert.Equal(t, encrypt+1, stageCount(pipeline.Encrypt))
+	assert.Equal(t, sink, stageCount(pipeline.Sink))
+	assert.Equal(t, encryptErrors+1, errorCount(pipeline.Encrypt))
+}
+
+func TestProcessorUse(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	ds := mocks.Datastore{}
+
+	ds.On(
+		"WriteData",
+		mock.Anything,
+		mock.Anything,
+	).Return(
+		&datastore.WriteResponse{},
+		nil,
+	)
+
+	passthroughExec := func(script, keys, data []byte) ([]byte, error) {
+		return json.Marshal(string(data))
+	}
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore: datastore.Datastore(&ds),
+		Stats:     stats.NewStore(nil, time.Minute, clock.New(), logger),
+		Scripts:   lua.NewScripts(&lua.Config{}, logger),
+		Zenroom:   pipeline.NewZenroomPool(1, time.Second, passthroughExec),
+	}, logger)
+
+	// a new processing type, applied only to streams which share a single
+	// sensor, doubling its readings
+	processor.Use(pipeline.Transform, func(stream *postgres.Stream) pipeline.Stage {
+		if len(stream.Operations) != 1 {
+			return nil
+		}
+
+		return pipeline.StageFunc(func(ctx context.Context, msg *pipeline.Message) (*pipeline.Message, error) {
+			reading := *msg.Reading
+			reading.Sensors = nil
+
+			for _, sensor := range msg.Reading.Sensors {
+				doubled := *sensor
+				value := *sensor.Value
+				value.Float64 *= 2
+				doubled.Value = &value
+
+				reading.Sensors = append(reading.Sensors, &doubled)
+			}
+
+			msg.Reading = &reading
+
+			return msg, nil
+		})
+	})
+
+	payload := []byte(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":13,"value":51.00},{"id":29,"value":64.5}]}]}`)
+
+	device := &postgres.Device{
+		DeviceToken: "foo",
+		Streams: []*postgres.Stream{
+			{
+				StreamID:    "e8a1f33a-6a1c-4c43-a2ab-4a4a8c5a5b1f",
+				CommunityID: "smartcitizen",
+				PublicKey:   `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+				Operations: postgres.Operations{
+					{SensorID: 13, Action: postgres.Share},
+				},
+			},
+			{
+				StreamID:    "5d9bc79d-a1ea-4f8a-b1f4-1e5a1f0b38d4",
+				CommunityID: "smartcitizen",
+				PublicKey:   `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+			},
+		},
+	}
+
+	err := processor.Process(context.Background(), device, payload)
+	assert.Nil(t, err)
+
+	assert.Len(t, ds.Calls, 2)
+
+	decrypt := func(call mock.Call) *smartcitizen.Device {
+		var encoded string
+		err := json.Unmarshal(call.Arguments[1].(*datastore.WriteRequest).Data, &encoded)
+		assert.Nil(t, err)
+
+		var decryptedDevice smartcitizen.Device
+		err = json.Unmarshal([]byte(encoded), &decryptedDevice)
+		assert.Nil(t, err)
+
+		return &decryptedDevice
+	}
+
+	// the stage runs before the stream's operations, which then share only
+	// the doubled reading of their sensor
+	doubled := decrypt(ds.Calls[0])
+	assert.Equal(t, []int{13}, sensorIDs(doubled.Sensors))
+	assert.Equal(t, 102.0, doubled.Sensors[0].Value.Float64)
+
+	// while other streams are unaffected
+	unchanged := decrypt(ds.Calls[1])
+	assert.Equal(t, []int{13, 29}, sensorIDs(unchanged.Sensors))
+	assert.Equal(t, 51.0, unchanged.Sensors[0].Value.Float64)
+}
@@ -0,0 +1,51 @@
+package pipeline
+
+import (
+	"fmt"
+	"math"
+	"sync"
+)
+
+// ChangeDetector is an interface for a type that decides whether a reading for
+// a change-only sensor should be forwarded, based on the value last forwarded
+// for the same stream and sensor.
+type ChangeDetector interface {
+	Changed(streamID string, sensorID int, value, threshold float64) bool
+}
+
+// NewChangeDetector returns an instance of our ChangeDetector interface. This
+// is a simple in-memory implementation, so after a restart the first reading
+// for each sensor is always forwarded.
+func NewChangeDetector() ChangeDetector {
+	return &changeDetector{
+		values: make(map[string]float64),
+	}
+}
+
+// changeDetector is our type that implements the ChangeDetector interface. It
+// holds a map keyed by stream id and sensor id whose values are the value last
+// forwarded for that sensor.
+type changeDetector struct {
+	sync.Mutex
+	values map[string]float64
+}
+
+// Changed returns true if the given value differs from the value last
+// forwarded for the stream and sensor by more than threshold, or if no value
+// has yet been forwarded. When true is returned the given value is recorded as
+// the last forwarded value.
+func (c *changeDetector) Changed(streamID string, sensorID int, value, threshold float64) bool {
+	key := fmt.Sprintf("%s:%v", streamID, sensorID)
+
+	c.Lock()
+	defer c.Unlock()
+
+	last, ok := c.values[key]
+	if ok && math.Abs(value-last) <= threshold {
+		return false
+	}
+
+	c.values[key] = value
+
+	return true
+}
@@ -0,0 +1,32 @@
+package pipeline_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/pipeline"
+)
+
+func TestChangeDetector(t *testing.T) {
+	cd := pipeline.NewChangeDetector()
+
+	// the first reading is always forwarded
+	assert.True(t, cd.Changed("abc", 55, 21.0, 0.5))
+
+	assert.False(t, cd.Changed("abc", 55, 21.3, 0.5))
+	assert.False(t, cd.Changed("abc", 55, 20.5, 0.5))
+	assert.True(t, cd.Changed("abc", 55, 21.6, 0.5))
+
+	// compared against the last forwarded value, not the last received
+	assert.False(t, cd.Changed("abc", 55, 22.0, 0.5))
+	assert.True(t, cd.Changed("abc", 55, 22.2, 0.5))
+
+	// other sensors and streams are tracked independently
+	assert.True(t, cd.Changed("abc", 56, 21.0, 0.5))
+	assert.True(t, cd.Changed("def", 55, 21.0, 0.5))
+
+	// a zero threshold forwards any change
+	assert.False(t, cd.Changed("def", 55, 21.0, 0))
+	assert.True(t, cd.Changed("def", 55, 21.1, 0))
+}
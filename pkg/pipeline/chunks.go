@@ -0,0 +1,206 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/DECODEproject/iotencoder/pkg/compress"
+	"github.com/DECODEproject/iotencoder/pkg/signing"
+)
+
+var (
+	// ChunkedPayloadsCounter is a prometheus counter recording a count of
+	// payloads which exceeded the chunk size, and so were split into chunks
+	// before being encrypted.
+	ChunkedPayloadsCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "chunked_payloads",
+			Help:      "Count of payloads split into chunks for encryption",
+		},
+	)
+)
+
+// Chunk is the envelope in which each encrypted chunk of an oversized payload
+// is written to the datastore. Consumers collect all chunks sharing an ID,
+// decrypt the data of each, and concatenate the decrypted data in index order
+// to reassemble the original payload. If the payload was compressed the
+// content encoding is given, and the reassembled payload must be base64
+// decoded and then decompressed. Payloads within the chunk size are written
+// without a Chunk envelope. If enabled each chunk carries the serialized
+// Metadata of the payload, which JSON encodes as base64, and each carries any
+// readings of the payload written in plain. If signing is enabled each chunk
+// carries the Provenance of its own data.
+type Chunk struct {
+	ID              string              `json:"chunk_id"`
+	Index           int                 `json:"chunk_index"`
+	Count           int                 `json:"chunk_count"`
+	ContentEncoding string              `json:"content_encoding,omitempty"`
+	Metadata        []byte              `json:"metadata,omitempty"`
+	Plaintext       *Plaintext          `json:"plaintext,omitempty"`
+	Provenance      *signing.Provenance `json:"provenance,omitempty"`
+	Data            json.RawMessage     `json:"data"`
+}
+
+// Envelope is the envelope in which an encrypted payload within the chunk size
+// is written to the datastore if it was compressed before encryption, or if
+// metadata is enabled, in which case it carries the serialized Metadata of the
+// payload, or if any readings are written in plain, in which case they are
+// carried unencrypted as Plaintext, or if signing is enabled, in which case it
+// carries the Provenance of the encrypted data and metadata. If a content
+// encoding is given the decrypted data must be base64 decoded and then
+// decompressed using it. Other payloads are written without an envelope.
+type Envelope struct {
+	ContentEncoding string              `json:"content_encoding,omitempty"`
+	Metadata        []byte              `json:"metadata,omitempty"`
+	Plaintext       *Plaintext          `json:"plaintext,omitempty"`
+	Provenance      *signing.Provenance `json:"provenance,omitempty"`
+	Data            json.RawMessage     `json:"data"`
+}
+
+// encrypt encrypts the given payload using the given script and keys,
+// returning the data to be written to the datastore. If a content encoding is
+// given the payload is first compressed, and as zenroom reads its data as
+// text the compressed payload is base64 encoded. If the payload then exceeds
+// our chunk size it is split into chunks which are encrypted separately, so
+// that no single zenroom execution need hold the whole payload in memory, and
+// each is returned wrapped in a Chunk envelope. Any metadata or plaintext
+// given is added to the envelope of each part, and if we have a signer each
+// part is signed along with the metadata.
+func (p *Processor) encrypt(ctx context.Context, script, keys, payload []byte, encoding string, metadata []byte, plaintext *Plaintext) ([][]byte, error) {
+	if encoding != "" {
+		compressed, err := compress.Compress(encoding, payload)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to compress payload")
+		}
+
+		payload = []byte(base64.StdEncoding.EncodeToString(compressed))
+	}
+
+	parts := splitPayload(payload, p.chunkSize)
+
+	if len(parts) == 1 {
+		encoded, err := p.zenroom.Exec(ctx, script, keys, terminate(payload))
+		if err != nil {
+			return nil, err
+		}
+
+		if encoding == "" && metadata == nil && plaintext == nil && p.signer == nil {
+			return [][]byte{encoded}, nil
+		}
+
+		provenance, err := p.sign(encoded, metadata)
+		if err != nil {
+			return nil, err
+		}
+
+		envelope, err := json.Marshal(&Envelope{
+			ContentEncoding: encoding,
+			Metadata:        metadata,
+			Plaintext:       plaintext,
+			Provenance:      provenance,
+			Data:            json.RawMessage(encoded),
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to marshal envelope")
+		}
+
+		return [][]byte{envelope}, nil
+	}
+
+	ChunkedPayloadsCounter.Inc()
+
+	id := uuid.New().String()
+	chunks := make([][]byte, 0, len(parts))
+
+	for i, part := range parts {
+		encoded, err := p.zenroom.Exec(ctx, script, keys, terminate(part))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to encrypt chunk %d of %d", i+1, len(parts))
+		}
+
+		provenance, err := p.sign(encoded, metadata)
+		if err != nil {
+			return nil, err
+		}
+
+		chunk, err := json.Marshal(&Chunk{
+			ID:              id,
+			Index:           i,
+			Count:           len(parts),
+			ContentEncoding: encoding,
+			Metadata:        metadata,
+			Plaintext:       plaintext,
+			Provenance:      provenance,
+			Data:            json.RawMessage(encoded),
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to marshal chunk")
+		}
+
+		chunks = append(chunks, chunk)
+	}
+
+	return chunks, nil
+}
+
+// sign returns the provenance of the given encrypted data and metadata, or nil
+// if we have no signer.
+func (p *Processor) sign(data, metadata []byte) (*signing.Provenance, error) {
+	if p.signer == nil {
+		return nil, nil
+	}
+
+	return p.signer.Sign(data, metadata)
+}
+
+// terminate returns a copy of the given part followed by a NUL byte which is
+// not included in the returned slice's length. Zenroom reads its data as a C
+// string, so without this a part sliced from the middle of a payload would be
+// read through to the end of the payload, and any other payload may be read
+// beyond its end.
+func terminate(part []byte) []byte {
+	terminated := make([]byte, len(part)+1)
+	copy(terminated, part)
+	return terminated[:len(part)]
+}
+
+// splitPayload splits the given payload into parts of at most size bytes. Parts
+// are only split on UTF-8 character boundaries so that each remains valid text.
+// If size is not positive, or the payload is within size, the payload is
+// returned whole.
+func splitPayload(payload []byte, size int) [][]byte {
+	if size <= 0 || len(payload) <= size {
+		return [][]byte{payload}
+	}
+
+	parts := [][]byte{}
+
+	for len(payload) > size {
+		end := size
+		for end > 0 && !utf8.RuneStart(payload[end]) {
+			end--
+		}
+
+		// a chunk size smaller than a single character can't be honoured
+		if end == 0 {
+			end = size
+		}
+
+		parts = append(parts, payload[:end])
+		payload = payload[end:]
+	}
+
+	if len(payload) > 0 {
+		parts = append(parts, payload)
+	}
+
+	return parts
+}
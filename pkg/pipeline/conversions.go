@@ -0,0 +1,68 @@
+package pipeline
+
+import (
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/guregu/null.v3"
+
+	"github.com/DECODEproject/iotencoder/pkg/conversion"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/smartcitizen"
+)
+
+var (
+	// ConversionFailuresCounter is a prometheus counter recording a count of
+	// sensor readings dropped because their conversion expression did not
+	// produce a finite number, labelled by sensor id.
+	ConversionFailuresCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "conversion_failures",
+			Help:      "Count of sensor readings dropped as their conversion failed",
+		},
+		[]string{"sensor"},
+	)
+)
+
+// convertDevice returns a copy of the device with the stream's conversion
+// expressions applied to the readings of the matching sensors. The passed in
+// device is shared between all streams of the device so is never modified. If
+// the stream has no conversions the device is returned as is.
+func convertDevice(device *smartcitizen.Device, conversions postgres.Conversions) (*smartcitizen.Device, error) {
+	if len(conversions) == 0 {
+		return device, nil
+	}
+
+	converted := *device
+	converted.Sensors = make([]*smartcitizen.Sensor, 0, len(device.Sensors))
+
+	for _, sensor := range device.Sensors {
+		expr, ok := conversions[uint32(sensor.ID)]
+		if !ok || sensor.Value == nil || !sensor.Value.Valid {
+			converted.Sensors = append(converted.Sensors, sensor)
+			continue
+		}
+
+		e, err := conversion.Parse(expr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse conversion for sensor %v", sensor.ID)
+		}
+
+		value, ok := e.Eval(sensor.Value.Float64)
+		if !ok {
+			ConversionFailuresCounter.WithLabelValues(strconv.Itoa(sensor.ID)).Inc()
+			continue
+		}
+
+		s := *sensor
+		v := null.FloatFrom(value)
+		s.Value = &v
+
+		converted.Sensors = append(converted.Sensors, &s)
+	}
+
+	return &converted, nil
+}
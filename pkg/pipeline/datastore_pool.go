@@ -0,0 +1,170 @@
+package pipeline
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	datastore "github.com/thingful/twirp-datastore-go"
+	"github.com/twitchtv/twirp"
+)
+
+var (
+	// DatastoreClientsGauge is a prometheus gauge recording the number of clients
+	// held in the pool for streams which write to their own datastore.
+	DatastoreClientsGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "datastore_clients",
+			Help:      "Number of cached clients for per-stream datastores",
+		},
+	)
+
+	// ErrDatastoreNotAllowed is returned by Check for an address whose host is
+	// not one of the datastore hosts allowed by the operator.
+	ErrDatastoreNotAllowed = errors.New("datastore host is not allowed")
+)
+
+// DatastoreFactory is the signature of a function able to create a datastore
+// client for the given address.
+type DatastoreFactory func(addr string) Datastore
+
+// NewDatastoreFactory returns the default DatastoreFactory which creates
+// protobuf clients sharing the given http client.
+func NewDatastoreFactory(client *http.Client) DatastoreFactory {
+	return func(addr string) Datastore {
+		return datastore.NewDatastoreProtobufClient(addr, client)
+	}
+}
+
+// DatastorePool holds the datastore clients used to write data. Streams written
+// to the encoder's default datastore use the fallback client, while streams
+// which specify their own datastore address use a client created on first use
+// and cached by address, so that all streams writing to the same datastore
+// share a single client. Streams may only specify datastores on hosts allowed
+// by the operator, so that creating a stream cannot be used to send requests
+// to arbitrary addresses reachable from the encoder.
+type DatastorePool struct {
+	fallback Datastore
+	factory  DatastoreFactory
+	hosts    map[string]bool
+
+	sync.RWMutex
+	clients map[string]Datastore
+}
+
+// NewDatastorePool returns a new pool which returns the given fallback client
+// for an empty address, and creates clients for any other address using the
+// given factory. Hosts lists the hosts, each optionally with a port, of the
+// datastores which Check accepts, and if empty every address other than the
+// empty address is refused.
+func NewDatastorePool(fallback Datastore, factory DatastoreFactory, hosts []string) *DatastorePool {
+	allowed := make(map[string]bool, len(hosts))
+	for _, host := range hosts {
+		allowed[strings.ToLower(host)] = true
+	}
+
+	return &DatastorePool{
+		fallback: fallback,
+		factory:  factory,
+		hosts:    allowed,
+		clients:  make(map[string]Datastore),
+	}
+}
+
+// Get returns the client for the datastore at the given address, creating and
+// caching it if this is the first request for the address. An empty address
+// returns the fallback client. Addresses are not checked against our allowed
+// hosts here, as those of streams are checked by Check as they are created.
+func (d *DatastorePool) Get(addr string) Datastore {
+	if addr == "" {
+		return d.fallback
+	}
+
+	d.RLock()
+	client, ok := d.clients[addr]
+	d.RUnlock()
+
+	if ok {
+		return client
+	}
+
+	return d.add(addr, d.factory(addr))
+}
+
+// add caches the given client for the given address, unless a client was
+// cached for the address concurrently, and returns the cached client.
+func (d *DatastorePool) add(addr string, client Datastore) Datastore {
+	d.Lock()
+	defer d.Unlock()
+
+	if cached, ok := d.clients[addr]; ok {
+		return cached
+	}
+
+	d.clients[addr] = client
+	DatastoreClientsGauge.Set(float64(len(d.clients)))
+
+	return client
+}
+
+// Allowed returns true if the given address is empty, or its host is one of
+// our allowed datastore hosts. Hosts allowed without a port match the host on
+// any port.
+func (d *DatastorePool) Allowed(addr string) bool {
+	if addr == "" {
+		return true
+	}
+
+	u, err := url.Parse(addr)
+	if err != nil || u.Host == "" {
+		return false
+	}
+
+	return d.hosts[strings.ToLower(u.Host)] || d.hosts[strings.ToLower(u.Hostname())]
+}
+
+// Check verifies that the datastore at the given address is allowed and can be
+// reached. Addresses on hosts which are not allowed are refused with
+// ErrDatastoreNotAllowed before any request is sent. As the datastore has no
+// dedicated health method we send an empty read request, and treat either
+// success or the datastore rejecting the request as invalid as proof that a
+// datastore is listening at the address. Transport errors, timeouts, and
+// requests routed to something other than a datastore are returned as errors.
+// The client used is only cached once the check has passed, so that checks of
+// failing addresses do not grow the pool. An empty address is always valid.
+func (d *DatastorePool) Check(ctx context.Context, addr string) error {
+	if addr == "" {
+		return nil
+	}
+
+	if !d.Allowed(addr) {
+		return ErrDatastoreNotAllowed
+	}
+
+	d.RLock()
+	client, cached := d.clients[addr]
+	d.RUnlock()
+
+	if !cached {
+		client = d.factory(addr)
+	}
+
+	_, err := client.ReadData(ctx, &datastore.ReadRequest{})
+	if err != nil {
+		if twerr, ok := err.(twirp.Error); !ok || twerr.Code() != twirp.InvalidArgument {
+			return errors.Wrap(err, "failed to connect to datastore")
+		}
+	}
+
+	if !cached {
+		d.add(addr, client)
+	}
+
+	return nil
+}
@@ -0,0 +1,98 @@
+package pipeline_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	datastore "github.com/thingful/twirp-datastore-go"
+	"github.com/twitchtv/twirp"
+
+	"github.com/DECODEproject/iotencoder/pkg/mocks"
+	"github.com/DECODEproject/iotencoder/pkg/pipeline"
+)
+
+// validatingDatastore is a datastore which rejects every request as invalid,
+// as a real datastore does for the empty requests sent by Check.
+type validatingDatastore struct{}
+
+func (v *validatingDatastore) WriteData(ctx context.Context, req *datastore.WriteRequest) (*datastore.WriteResponse, error) {
+	return nil, twirp.RequiredArgumentError("public_key")
+}
+
+func (v *validatingDatastore) ReadData(ctx context.Context, req *datastore.ReadRequest) (*datastore.ReadResponse, error) {
+	return nil, twirp.RequiredArgumentError("public_key")
+}
+
+func TestDatastorePoolGet(t *testing.T) {
+	fallback := &mocks.Datastore{}
+	created := []string{}
+
+	pool := pipeline.NewDatastorePool(fallback, func(addr string) pipeline.Datastore {
+		created = append(created, addr)
+		return &mocks.Datastore{}
+	}, nil)
+
+	assert.Equal(t, fallback, pool.Get(""))
+
+	a := pool.Get("http://a.local")
+	b := pool.Get("http://b.local")
+
+	assert.True(t, a != b)
+	assert.True(t, a == pool.Get("http://a.local"))
+	assert.Equal(t, []string{"http://a.local", "http://b.local"}, created)
+}
+
+func TestDatastorePoolCheck(t *testing.T) {
+	ts := httptest.NewServer(datastore.NewDatastoreServer(&validatingDatastore{}, nil))
+	defer ts.Close()
+
+	notFound := httptest.NewServer(http.NotFoundHandler())
+	defer notFound.Close()
+
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	pool := pipeline.NewDatastorePool(&mocks.Datastore{}, pipeline.NewDatastoreFactory(&http.Client{}), []string{"127.0.0.1"})
+
+	assert.Nil(t, pool.Check(context.Background(), ""))
+	assert.Nil(t, pool.Check(context.Background(), ts.URL))
+	assert.NotNil(t, pool.Check(context.Background(), notFound.URL))
+	assert.NotNil(t, pool.Check(context.Background(), closed.URL))
+}
+
+func TestDatastorePoolCheckAllowed(t *testing.T) {
+	created := []string{}
+
+	pool := pipeline.NewDatastorePool(&mocks.Datastore{}, func(addr string) pipeline.Datastore {
+		created = append(created, addr)
+		if addr == "http://down.pilot:8080" {
+			return pipeline.NewDatastoreFactory(&http.Client{})("http://127.0.0.1:1")
+		}
+		return &validatingDatastore{}
+	}, []string{"datastore.pilot", "Down.Pilot:8080"})
+
+	assert.True(t, pool.Allowed(""))
+	assert.True(t, pool.Allowed("http://datastore.pilot:9000"))
+	assert.True(t, pool.Allowed("http://down.pilot:8080"))
+	assert.False(t, pool.Allowed("http://down.pilot:9000"))
+	assert.False(t, pool.Allowed("http://169.254.169.254/latest"))
+	assert.False(t, pool.Allowed("datastore.pilot"))
+
+	// hosts not allowed are refused before any client is created
+	err := pool.Check(context.Background(), "http://169.254.169.254/latest")
+	assert.Equal(t, pipeline.ErrDatastoreNotAllowed, err)
+	assert.Empty(t, created)
+
+	// clients are cached only once a check has passed
+	assert.NotNil(t, pool.Check(context.Background(), "http://down.pilot:8080"))
+	assert.NotNil(t, pool.Check(context.Background(), "http://down.pilot:8080"))
+	assert.Equal(t, []string{"http://down.pilot:8080", "http://down.pilot:8080"}, created)
+
+	assert.Nil(t, pool.Check(context.Background(), "http://datastore.pilot:8080"))
+	assert.Nil(t, pool.Check(context.Background(), "http://datastore.pilot:8080"))
+	pool.Get("http://datastore.pilot:8080")
+	assert.Equal(t, []string{"http://down.pilot:8080", "http://down.pilot:8080", "http://datastore.pilot:8080"}, created)
+}
@@ -0,0 +1,75 @@
+package pipeline
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/smartcitizen"
+)
+
+var (
+	// DispositionsCounter is a prometheus counter vec recording a count of
+	// readings which were not encrypted because of the disposition of their
+	// sensor, labelled by the disposition, either drop or plain.
+	DispositionsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "unencrypted_readings",
+			Help:      "Count of readings dropped or written in plain by the disposition of their sensor",
+		},
+		[]string{"disposition"},
+	)
+)
+
+// Plaintext holds the readings of a payload written unencrypted alongside the
+// encrypted data, as the stream gives their sensors the plain disposition.
+type Plaintext struct {
+	RecordedAt time.Time              `json:"recorded_at"`
+	Sensors    []*smartcitizen.Sensor `json:"sensors"`
+}
+
+// applyDispositions returns a copy of the device holding only those readings
+// which are to be encrypted, along with the readings to be written in plain if
+// there are any. Readings of dropped sensors are discarded. Unless plaintext
+// channels are enabled, readings of plain sensors are encrypted as any other,
+// so that a stream saved while they were enabled can never leak data once
+// they are disabled. The given device is not modified.
+func (p *Processor) applyDispositions(device *smartcitizen.Device, stream *postgres.Stream) (*smartcitizen.Device, *Plaintext) {
+	if len(stream.Dispositions) == 0 {
+		return device, nil
+	}
+
+	encrypted := *device
+	encrypted.Sensors = make([]*smartcitizen.Sensor, 0, len(device.Sensors))
+
+	var plain []*smartcitizen.Sensor
+
+	for _, sensor := range device.Sensors {
+		switch stream.Dispositions.Of(sensor.ID) {
+		case postgres.Drop:
+			DispositionsCounter.WithLabelValues(string(postgres.Drop)).Inc()
+		case postgres.Plain:
+			if !p.allowPlaintext {
+				encrypted.Sensors = append(encrypted.Sensors, sensor)
+				continue
+			}
+
+			DispositionsCounter.WithLabelValues(string(postgres.Plain)).Inc()
+			plain = append(plain, sensor)
+		default:
+			encrypted.Sensors = append(encrypted.Sensors, sensor)
+		}
+	}
+
+	if len(plain) == 0 {
+		return &encrypted, nil
+	}
+
+	return &encrypted, &Plaintext{
+		RecordedAt: device.RecordedAt,
+		Sensors:    plain,
+	}
+}
@@ -0,0 +1,52 @@
+package pipeline
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/DECODEproject/iotencoder/pkg/geoprivacy"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/smartcitizen"
+)
+
+// CellCounter is the interface we call to count the distinct devices which
+// have reported from a location cell within a window. It is satisfied by the
+// geoprivacy.Tracker type.
+type CellCounter interface {
+	Observe(cell, device string, window time.Duration) int
+}
+
+// applyGeoPrivacy returns a copy of the device with its location snapped to
+// the centre of its grid cell if the stream has a geo-privacy spec. If the
+// spec requires k-anonymity and fewer than k devices have reported from the
+// cell within the window, or we have no counter, the location is suppressed
+// entirely. The given device is not modified.
+func (p *Processor) applyGeoPrivacy(device *smartcitizen.Device, stream *postgres.Stream) (*smartcitizen.Device, error) {
+	if stream.GeoPrivacy == "" {
+		return device, nil
+	}
+
+	spec, err := geoprivacy.Parse(stream.GeoPrivacy)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid stream geo-privacy spec")
+	}
+
+	protected := *device
+
+	lat, lon, cell := spec.Snap(device.Latitude, device.Longitude)
+
+	protected.Latitude = lat
+	protected.Longitude = lon
+	protected.LocationGrid = spec.Grid
+
+	if spec.K > 1 && (p.cells == nil || p.cells.Observe(cell, device.Token, spec.Window) < spec.K) {
+		protected.Latitude = 0
+		protected.Longitude = 0
+		protected.LocationSuppressed = true
+
+		geoprivacy.SuppressedCounter.Inc()
+	}
+
+	return &protected, nil
+}
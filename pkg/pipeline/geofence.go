@@ -0,0 +1,32 @@
+package pipeline
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/DECODEproject/iotencoder/pkg/geofence"
+	"github.com/DECODEproject/iotencoder/pkg/logger/level"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+)
+
+// applyGeofence returns the message if its readings were reported from within
+// the stream's fence, else nil as the message is dropped. The location checked
+// is the one reported in the payload if any, else the device's registered
+// location.
+func (p *Processor) applyGeofence(msg *Message, stream *postgres.Stream) (*Message, error) {
+	spec, err := geofence.Parse(stream.Geofence)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse stream geofence")
+	}
+
+	if spec.Contains(msg.Reading.Latitude, msg.Reading.Longitude) {
+		return msg, nil
+	}
+
+	geofence.OutsideCounter.Inc()
+
+	if p.verbose {
+		level.Debug(msg.Logger).Log("geofence", stream.Geofence, "msg", "dropped message reported outside geofence")
+	}
+
+	return nil, nil
+}
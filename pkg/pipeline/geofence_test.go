@@ -0,0 +1,75 @@
+package pipeline_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	datastore "github.com/thingful/twirp-datastore-go"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/lua"
+	"github.com/DECODEproject/iotencoder/pkg/mocks"
+	"github.com/DECODEproject/iotencoder/pkg/pipeline"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/stats"
+)
+
+func TestProcessWithGeofence(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	ds := mocks.Datastore{}
+
+	ds.On(
+		"WriteData",
+		mock.Anything,
+		mock.Anything,
+	).Return(
+		&datastore.WriteResponse{},
+		nil,
+	)
+
+	passthroughExec := func(script, keys, data []byte) ([]byte, error) {
+		return json.Marshal(string(data))
+	}
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore: datastore.Datastore(&ds),
+		Stats:     stats.NewStore(nil, time.Minute, clock.New(), logger),
+		Scripts:   lua.NewScripts(&lua.Config{}, logger),
+		Zenroom:   pipeline.NewZenroomPool(1, time.Second, passthroughExec),
+	}, logger)
+
+	device := &postgres.Device{
+		DeviceToken: "foo",
+		Latitude:    40.4168,
+		Longitude:   -3.7038,
+		Streams: []*postgres.Stream{
+			{
+				StreamID:    "fenced",
+				CommunityID: "smartcitizen",
+				PublicKey:   `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+				Geofence:    "circle=41.3851,2.1734,2km",
+			},
+		},
+	}
+
+	// readings reported from within the fence are written
+	err := processor.Process(context.Background(), device, []byte(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","latitude":41.39,"longitude":2.17,"sensors":[{"id":13,"value":51.00}]}]}`))
+	assert.Nil(t, err)
+	assert.Len(t, ds.Calls, 1)
+
+	// while those reported from outside it are dropped
+	err = processor.Process(context.Background(), device, []byte(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","latitude":41.5,"longitude":2.17,"sensors":[{"id":13,"value":51.00}]}]}`))
+	assert.Nil(t, err)
+	assert.Len(t, ds.Calls, 1)
+
+	// as are those without a location when the device is registered outside
+	// it
+	err = processor.Process(context.Background(), device, []byte(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":13,"value":51.00}]}]}`))
+	assert.Nil(t, err)
+	assert.Len(t, ds.Calls, 1)
+}
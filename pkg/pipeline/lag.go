@@ -0,0 +1,134 @@
+package pipeline
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/smartcitizen"
+)
+
+// the sources of the timestamps against which ingestion lag is measured
+const (
+	lagSourceDevice = "device"
+	lagSourceBroker = "broker"
+)
+
+var (
+	// IngestionLagHistogram is a prometheus histogram recording the time in
+	// seconds between the timestamp a payload carries and its processing for a
+	// stream, labelled by the source of the timestamp, either the device's own
+	// recorded time or the time at which the broker received the message, and
+	// by the values of the stream labels configured via SetStreamLabels. A lag
+	// from the device which the broker's lag doesn't share points to the
+	// device's clock, and one shared by both to a backlog. Buckets may be
+	// configured via SetBuckets.
+	IngestionLagHistogram = newIngestionLagHistogram(nil, nil)
+
+	// ClockAheadCounter is a prometheus counter vec recording a count of
+	// payloads whose timestamp was later than the time at which they were
+	// processed, labelled by the source of the timestamp, which for the device
+	// is a sign of a fast clock.
+	ClockAheadCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "ingestion_clock_ahead",
+			Help:      "Count of payloads timestamped later than they were processed, by timestamp source",
+		},
+		[]string{"source"},
+	)
+
+	// ingestionBuckets are the buckets of IngestionLagHistogram, kept so that
+	// it may be relabelled by SetStreamLabels.
+	ingestionBuckets []float64
+)
+
+// newIngestionLagHistogram returns the ingestion lag histogram with the given
+// buckets, labelled by the given stream label keys.
+func newIngestionLagHistogram(buckets []float64, keys []string) *prometheus.HistogramVec {
+	labelNames := []string{"source"}
+	for _, key := range keys {
+		labelNames = append(labelNames, streamLabelPrefix+key)
+	}
+
+	return prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "ingestion_lag_seconds",
+			Help:      "Time between the timestamp of a payload and its processing for a stream, by timestamp source and stream label",
+			Buckets:   buckets,
+		},
+		labelNames,
+	)
+}
+
+// timestamps holds the times against which the ingestion lag of a payload is
+// measured, either of which may be zero if unknown.
+type timestamps struct {
+	device time.Time
+	broker time.Time
+}
+
+// payloadTimestamps returns the timestamps of the parsed payload, the broker's
+// being read from the metadata received with it under the given property if
+// we were configured with one. Replayed payloads have none, as their lag
+// measures only how long ago they were first received.
+func payloadTimestamps(ctx context.Context, device *smartcitizen.Device, brokerProperty string) timestamps {
+	if isReplay(ctx) {
+		return timestamps{}
+	}
+
+	ts := timestamps{device: device.RecordedAt}
+
+	if brokerProperty != "" {
+		if value, ok := contextMetadata(ctx)[brokerProperty]; ok {
+			ts.broker, _ = parseBrokerTimestamp(value)
+		}
+	}
+
+	return ts
+}
+
+// parseBrokerTimestamp parses the timestamp a broker added to a message, given
+// either in milliseconds since the Unix epoch, as brokers such as EMQX do, or
+// in RFC 3339 format.
+func parseBrokerTimestamp(value string) (time.Time, error) {
+	if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(0, ms*int64(time.Millisecond)), nil
+	}
+
+	return time.Parse(time.RFC3339Nano, value)
+}
+
+// recordIngestionLag records the lag between each of the payload's timestamps
+// and the time at which it was processed for the given stream.
+func recordIngestionLag(stream *postgres.Stream, ts timestamps, processedAt time.Time) {
+	observeLag(stream, lagSourceDevice, ts.device, processedAt)
+	observeLag(stream, lagSourceBroker, ts.broker, processedAt)
+}
+
+// observeLag records the lag of a single timestamp from the given source, if
+// it is known.
+func observeLag(stream *postgres.Stream, source string, timestamp, processedAt time.Time) {
+	if timestamp.IsZero() {
+		return
+	}
+
+	lag := processedAt.Sub(timestamp)
+	if lag < 0 {
+		ClockAheadCounter.WithLabelValues(source).Inc()
+		lag = 0
+	}
+
+	values := []string{source}
+	for _, key := range streamLabelKeys {
+		values = append(values, stream.Labels[key])
+	}
+
+	IngestionLagHistogram.WithLabelValues(values...).Observe(lag.Seconds())
+}
@@ -0,0 +1,145 @@
+package pipeline_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	datastore "github.com/thingful/twirp-datastore-go"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/lua"
+	"github.com/DECODEproject/iotencoder/pkg/mocks"
+	"github.com/DECODEproject/iotencoder/pkg/pipeline"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/stats"
+)
+
+func TestProcessRecordsIngestionLag(t *testing.T) {
+	lagCount := func(source string) uint64 {
+		var metric dto.Metric
+		err := pipeline.IngestionLagHistogram.WithLabelValues(source).Write(&metric)
+		assert.Nil(t, err)
+		return metric.GetHistogram().GetSampleCount()
+	}
+
+	aheadCount := func(source string) float64 {
+		var metric dto.Metric
+		err := pipeline.ClockAheadCounter.WithLabelValues(source).Write(&metric)
+		assert.Nil(t, err)
+		return metric.GetCounter().GetValue()
+	}
+
+	now := time.Now().UTC()
+
+	testcases := []struct {
+		label    string
+		ctx      context.Context
+		recorded time.Time
+		device   uint64
+		broker   uint64
+		ahead    float64
+		property string
+	}{
+		{
+			label:    "device timestamp only",
+			ctx:      context.Background(),
+			recorded: now.Add(-time.Minute),
+			device:   1,
+		},
+		{
+			label:    "broker timestamp in milliseconds",
+			ctx:      pipeline.WithMetadata(context.Background(), map[string]string{"timestamp": strconv.FormatInt(now.UnixNano()/int64(time.Millisecond), 10)}),
+			recorded: now.Add(-time.Minute),
+			device:   1,
+			broker:   1,
+			property: "timestamp",
+		},
+		{
+			label:    "broker timestamp in RFC 3339",
+			ctx:      pipeline.WithMetadata(context.Background(), map[string]string{"received": now.Format(time.RFC3339Nano)}),
+			recorded: now.Add(-time.Minute),
+			device:   1,
+			broker:   1,
+			property: "received",
+		},
+		{
+			label:    "unparseable broker timestamp",
+			ctx:      pipeline.WithMetadata(context.Background(), map[string]string{"timestamp": "yesterday"}),
+			recorded: now.Add(-time.Minute),
+			device:   1,
+			property: "timestamp",
+		},
+		{
+			label:    "device clock ahead",
+			ctx:      context.Background(),
+			recorded: now.Add(time.Hour),
+			device:   1,
+			ahead:    1,
+		},
+		{
+			label:    "replayed payload",
+			ctx:      pipeline.WithReplay(pipeline.WithMetadata(context.Background(), map[string]string{"timestamp": "0"})),
+			recorded: now.Add(-time.Minute),
+			property: "timestamp",
+		},
+	}
+
+	passthroughExec := func(script, keys, data []byte) ([]byte, error) {
+		return json.Marshal(string(data))
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			logger := kitlog.NewNopLogger()
+			ds := mocks.Datastore{}
+
+			ds.On(
+				"WriteData",
+				mock.Anything,
+				mock.Anything,
+			).Return(
+				&datastore.WriteResponse{},
+				nil,
+			)
+
+			processor := pipeline.NewProcessor(&pipeline.Config{
+				Datastore: datastore.Datastore(&ds),
+				Stats:     stats.NewStore(nil, time.Minute, clock.New(), logger),
+				Scripts:   lua.NewScripts(&lua.Config{}, logger),
+				Zenroom:   pipeline.NewZenroomPool(1, time.Second, passthroughExec),
+
+				BrokerTimestampProperty: tc.property,
+			}, logger)
+
+			device := &postgres.Device{
+				DeviceToken: "foo",
+				Streams: []*postgres.Stream{
+					{
+						StreamID:    "abc123",
+						CommunityID: "smartcitizen",
+						PublicKey:   `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+					},
+				},
+			}
+
+			payload := []byte(fmt.Sprintf(`{"data":[{"recorded_at":"%s","sensors":[{"id":13,"value":51.00}]}]}`, tc.recorded.Format(time.RFC3339)))
+
+			device0, broker0, ahead0 := lagCount("device"), lagCount("broker"), aheadCount("device")
+
+			err := processor.Process(tc.ctx, device, payload)
+			assert.Nil(t, err)
+
+			assert.Equal(t, device0+tc.device, lagCount("device"))
+			assert.Equal(t, broker0+tc.broker, lagCount("broker"))
+			assert.Equal(t, ahead0+tc.ahead, aheadCount("device"))
+		})
+	}
+}
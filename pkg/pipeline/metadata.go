@@ -0,0 +1,70 @@
+package pipeline
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/DECODEproject/iotencoder/pkg/logger"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+)
+
+// SchemaVersion is the version of the schema of the device data we encrypt,
+// recorded in the Metadata of each datastore entry. It must be incremented
+// whenever that schema changes in a way consumers need to know about.
+const SchemaVersion = 1
+
+// Metadata describes an encrypted payload without revealing its contents, so
+// that consumers and auditors can interpret a datastore entry without
+// decrypting it. When enabled, or if the stream references a payload schema or
+// is sampled, it is serialized as protobuf, matching metadata.proto, and
+// written in the Envelope or Chunk of each entry.
+//
+// DeviceHash is the hash of the device token as it appears in our logs,
+// IngestedAt the unix time in milliseconds at which the payload was processed,
+// KeyVersion a fingerprint of the recipient public key, which changes whenever
+// the community's keys are rotated, and Schema the reference to the payload
+// schema of the stream if it has one. SamplePercent is the percentage of the
+// stream's messages of which the payload was one selected to be written, if the
+// stream is sampled.
+type Metadata struct {
+	DeviceHash     string  `protobuf:"bytes,1,opt,name=device_hash,json=deviceHash,proto3" json:"device_hash,omitempty"`
+	IngestedAt     int64   `protobuf:"varint,2,opt,name=ingested_at,json=ingestedAt,proto3" json:"ingested_at,omitempty"`
+	ProcessingType string  `protobuf:"bytes,3,opt,name=processing_type,json=processingType,proto3" json:"processing_type,omitempty"`
+	SchemaVersion  uint32  `protobuf:"varint,4,opt,name=schema_version,json=schemaVersion,proto3" json:"schema_version,omitempty"`
+	KeyVersion     string  `protobuf:"bytes,5,opt,name=key_version,json=keyVersion,proto3" json:"key_version,omitempty"`
+	Schema         string  `protobuf:"bytes,6,opt,name=schema,proto3" json:"schema,omitempty"`
+	SamplePercent  float64 `protobuf:"fixed64,7,opt,name=sample_percent,json=samplePercent,proto3" json:"sample_percent,omitempty"`
+}
+
+// Reset implements proto.Message.
+func (m *Metadata) Reset() { *m = Metadata{} }
+
+// String implements proto.Message.
+func (m *Metadata) String() string { return proto.CompactTextString(m) }
+
+// ProtoMessage implements proto.Message.
+func (*Metadata) ProtoMessage() {}
+
+// buildMetadata returns the serialized metadata of a payload of the given
+// stream processed at the given time, selected by sampling the given
+// percentage of the stream's messages if positive.
+func buildMetadata(device *postgres.Device, stream *postgres.Stream, ingestedAt time.Time, samplePercent float64) ([]byte, error) {
+	return proto.Marshal(&Metadata{
+		DeviceHash:     logger.HashToken(device.DeviceToken),
+		IngestedAt:     ingestedAt.UnixNano() / int64(time.Millisecond),
+		ProcessingType: ProcessingType(stream),
+		SchemaVersion:  SchemaVersion,
+		KeyVersion:     keyVersion(stream.PublicKey),
+		Schema:         stream.Schema,
+		SamplePercent:  samplePercent,
+	})
+}
+
+// keyVersion returns a short fingerprint of the given public key.
+func keyVersion(publicKey string) string {
+	sum := sha256.Sum256([]byte(publicKey))
+	return hex.EncodeToString(sum[:])[:12]
+}
@@ -1,18 +1,37 @@
 package pipeline
 
 import (
+	"context"
 	"fmt"
 	"sync"
 	"time"
 
-	"github.com/DECODEproject/iotencoder/pkg/clock"
 	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/logger/level"
 )
 
 // MovingAverager is an interface for a type that can return a moving average
 // for the given device/sensor/interval
 type MovingAverager interface {
-	MovingAverage(value float64, deviceToken string, sensorID int, interval uint32) (float64, error)
+	MovingAverage(ctx context.Context, value float64, deviceToken string, sensorID int, interval uint32) (float64, error)
+}
+
+// StateStore is the interface of a type holding the windows of values over
+// which moving averages are calculated. Window adds the value received at the
+// given time to the window with the given key, removing any values received
+// more than the window's duration before it, and returns the values remaining,
+// including the one added. Values returns the values of the window which would
+// remain were a value added at the given time, without adding one. Our
+// in-memory implementation is the default, while
+// replicas sharing a subscription must share a store, such as that of our
+// redis package, so that each window holds every reading of a device whichever
+// replica received it.
+type StateStore interface {
+	Window(key string, value float64, at time.Time, window time.Duration) ([]float64, error)
+	Values(key string, at time.Time, window time.Duration) ([]float64, error)
 }
 
 // entry is a type we use to store incoming values which we then calculate a
@@ -22,95 +41,148 @@ type entry struct {
 	Value     float64
 }
 
-// NewMovingAverager returns an instance of our MovingAverager interface. This
-// is a simple in-memory implementation.
-func NewMovingAverager(verbose bool, cl clock.Clock, logger kitlog.Logger) MovingAverager {
+// NewMovingAverager returns an instance of our MovingAverager interface, which
+// keeps its windows in the given StateStore, or in memory if nil.
+func NewMovingAverager(store StateStore, verbose bool, cl clock.Clock, logger kitlog.Logger) MovingAverager {
+	if store == nil {
+		store = NewMemoryStateStore()
+	}
+
 	return &movingAverager{
-		entries: make(map[string][]entry),
+		store:   store,
 		verbose: verbose,
 		logger:  logger,
 		clock:   cl,
 	}
 }
 
-// movingAverage is our type that implements the MovingAverager interface using
-// a simple in memory store. The store is a map with a key based on the device
-// token, sensor id and moving average duration, and values being slices of the
-// `entry` type shown above. When a value is received the algorithm is to read
-// the slice for the appropriate key, iterate through it removing any entries
-// that should no longer be included in the average, and counting and totalling
-// the ones that should. The allows us to calculate the average. We then write a
-// new slice back into the map.
+// movingAverager is our type that implements the MovingAverager interface. When
+// a value is received it is added to the window for the device, sensor and
+// moving average interval, and the average returned is that of the values
+// remaining in the window.
 type movingAverager struct {
-	sync.RWMutex
-	entries map[string][]entry
+	store   StateStore
 	verbose bool
 	logger  kitlog.Logger
 	clock   clock.Clock
 }
 
 // MovingAverage is our implementation of the MovingAverager interface method.
-func (m *movingAverager) MovingAverage(value float64, deviceToken string, sensorID int, interval uint32) (float64, error) {
+// When the payloads of the message being processed are queued in the outbox,
+// the value is only added to its window once they are queued, so that the
+// value of a message which is not processed is not averaged.
+func (m *movingAverager) MovingAverage(ctx context.Context, value float64, deviceToken string, sensorID int, interval uint32) (float64, error) {
 	// build our key for the device/sensor/interval
 	key := fmt.Sprintf("%s:%v:%v", deviceToken, sensorID, interval)
 
-	now := m.clock.Now()
-	intervalDuration := time.Second * time.Duration(-int(interval))
-	previousTime := now.Add(intervalDuration)
-
-	// look in the map for the slice of entries for this combination
-	m.RLock()
-	entries, ok := m.entries[key]
-	m.RUnlock()
-
-	if !ok {
-		// no value exists at the moment, so we need to create a new slice, and insert into the map
-		entries = []entry{
-			entry{
-				Timestamp: now.Unix(),
-				Value:     value,
-			},
+	at := m.clock.Now()
+	window := time.Second * time.Duration(interval)
+
+	var values []float64
+
+	if batch := contextOutboxBatch(ctx); batch != nil {
+		previous, err := m.store.Values(key, at, window)
+		if err != nil {
+			return 0, errors.Wrap(err, "failed to read moving average window")
 		}
 
-		m.Lock()
-		m.entries[key] = entries
-		m.Unlock()
+		values = append(previous, value)
+
+		batch.afterEnqueue(func() {
+			_, err := m.store.Window(key, value, at, window)
+			if err != nil {
+				level.Error(m.logger).Log("err", err, "msg", "failed to update moving average window")
+			}
+		})
+	} else {
+		var err error
 
-		// as this is the first value the average value is just the received value
+		values, err = m.store.Window(key, value, at, window)
+		if err != nil {
+			return 0, errors.Wrap(err, "failed to update moving average window")
+		}
+	}
+
+	if len(values) == 0 {
 		return value, nil
 	}
 
-	newEntries := []entry{}
-	counter := 0
 	accumulator := 0.0
+	for _, v := range values {
+		accumulator = accumulator + v
+	}
 
-	// we got some values, so we need to iterate over our entries (including the
-	// new value), excluding any that are older than we care about
-	for _, e := range entries {
-		// if older than our interval we ignore
+	return accumulator / float64(len(values)), nil
+}
+
+// NewMemoryStateStore returns a StateStore holding its windows in memory, so
+// they are lost on restart and private to the encoder instance.
+func NewMemoryStateStore() StateStore {
+	return &memoryStateStore{
+		entries: make(map[string][]entry),
+	}
+}
+
+// memoryStateStore is our in-memory StateStore. The store is a map with the key
+// of each window, and values being slices of the `entry` type shown above. When
+// a value is received the algorithm is to read the slice for the key, iterate
+// through it removing any entries that should no longer be included in the
+// window, append the new value, and write the new slice back into the map.
+type memoryStateStore struct {
+	sync.Mutex
+	entries map[string][]entry
+}
+
+// Values is our implementation of the StateStore interface method.
+func (m *memoryStateStore) Values(key string, at time.Time, window time.Duration) ([]float64, error) {
+	previousTime := at.Add(-window)
+
+	m.Lock()
+	defer m.Unlock()
+
+	values := []float64{}
+
+	for _, e := range m.entries[key] {
 		if e.Timestamp < previousTime.Unix() {
 			continue
 		}
 
-		counter = counter + 1
-		accumulator = accumulator + e.Value
+		values = append(values, e.Value)
+	}
+
+	return values, nil
+}
+
+// Window is our implementation of the StateStore interface method.
+func (m *memoryStateStore) Window(key string, value float64, at time.Time, window time.Duration) ([]float64, error) {
+	previousTime := at.Add(-window)
+
+	m.Lock()
+	defer m.Unlock()
+
+	newEntries := []entry{}
+	values := []float64{}
+
+	// iterate over our entries, excluding any that are older than we care about
+	for _, e := range m.entries[key] {
+		// if older than our interval we ignore
+		if e.Timestamp < previousTime.Unix() {
+			continue
+		}
 
 		newEntries = append(newEntries, e)
+		values = append(values, e.Value)
 	}
 
 	// include the latest entry
-	counter = counter + 1
-	accumulator = accumulator + value
-
 	newEntries = append(newEntries, entry{
-		Timestamp: now.Unix(),
+		Timestamp: at.Unix(),
 		Value:     value,
 	})
+	values = append(values, value)
 
 	// save to the map
-	m.Lock()
 	m.entries[key] = newEntries
-	m.Unlock()
 
-	return accumulator / float64(counter), nil
+	return values, nil
 }
@@ -1,10 +1,12 @@
 package pipeline_test
 
 import (
+	"context"
 	"testing"
 	"time"
 
 	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 
 	"github.com/DECODEproject/iotencoder/pkg/clock"
@@ -15,35 +17,71 @@ func TestMovingAverager(t *testing.T) {
 	logger := kitlog.NewNopLogger()
 
 	cl := clock.NewMock(time.Now())
-	mv := pipeline.NewMovingAverager(false, cl, logger)
+	mv := pipeline.NewMovingAverager(nil, false, cl, logger)
 	assert.NotNil(t, mv)
 
-	avg, err := mv.MovingAverage(4.5, "abc123", 55, uint32(900))
+	avg, err := mv.MovingAverage(context.Background(), 4.5, "abc123", 55, uint32(900))
 	assert.Nil(t, err)
 	assert.Equal(t, 4.5, avg)
 
 	cl.Add(5 * time.Minute)
-	avg, err = mv.MovingAverage(5.5, "abc123", 55, uint32(900))
+	avg, err = mv.MovingAverage(context.Background(), 5.5, "abc123", 55, uint32(900))
 	assert.Nil(t, err)
 	assert.Equal(t, 5.0, avg)
 
 	// spam another series so we can test it doesn't affect
-	avg, err = mv.MovingAverage(2.2, "abc123", 12, uint32(900))
+	avg, err = mv.MovingAverage(context.Background(), 2.2, "abc123", 12, uint32(900))
 	assert.Nil(t, err)
 	assert.Equal(t, 2.2, avg)
 
 	cl.Add(5 * time.Minute)
-	avg, err = mv.MovingAverage(6.5, "abc123", 55, uint32(900))
+	avg, err = mv.MovingAverage(context.Background(), 6.5, "abc123", 55, uint32(900))
 	assert.Nil(t, err)
 	assert.Equal(t, 5.5, avg)
 
 	cl.Add(5 * time.Minute)
-	avg, err = mv.MovingAverage(5.5, "abc123", 55, uint32(900))
+	avg, err = mv.MovingAverage(context.Background(), 5.5, "abc123", 55, uint32(900))
 	assert.Nil(t, err)
 	assert.Equal(t, 5.5, avg)
 
 	cl.Add(5 * time.Minute)
-	avg, err = mv.MovingAverage(1.2, "abc123", 55, uint32(900))
+	avg, err = mv.MovingAverage(context.Background(), 1.2, "abc123", 55, uint32(900))
 	assert.Nil(t, err)
 	assert.Equal(t, 4.675, avg)
 }
+
+// fakeStateStore returns the values it holds for every window, recording the
+// windows it is asked to update.
+type fakeStateStore struct {
+	values  []float64
+	err     error
+	windows []time.Duration
+}
+
+func (f *fakeStateStore) Window(key string, value float64, at time.Time, window time.Duration) ([]float64, error) {
+	f.windows = append(f.windows, window)
+	return f.values, f.err
+}
+
+func (f *fakeStateStore) Values(key string, at time.Time, window time.Duration) ([]float64, error) {
+	return f.values, f.err
+}
+
+func TestMovingAveragerWithStore(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+
+	store := &fakeStateStore{values: []float64{1.5, 2.5, 5}}
+
+	mv := pipeline.NewMovingAverager(store, false, clock.New(), logger)
+
+	avg, err := mv.MovingAverage(context.Background(), 5, "abc123", 55, uint32(900))
+	assert.Nil(t, err)
+	assert.Equal(t, 3.0, avg)
+	assert.Equal(t, []time.Duration{15 * time.Minute}, store.windows)
+
+	store.err = errors.New("connection refused")
+
+	_, err = mv.MovingAverage(context.Background(), 5, "abc123", 55, uint32(900))
+	assert.NotNil(t, err)
+	assert.Equal(t, "failed to update moving average window: connection refused", err.Error())
+}
@@ -0,0 +1,138 @@
+package pipeline
+
+import (
+	"context"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"gopkg.in/guregu/null.v3"
+
+	"github.com/DECODEproject/iotencoder/pkg/logger/level"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+)
+
+// Outbox is the interface we use to queue encrypted payloads for delivery to
+// the datastore by a separate dispatcher, so that a payload which has been
+// processed survives a crash before it is written. It is satisfied by the
+// postgres.DB type.
+type Outbox interface {
+	EnqueueOutbox(batch *postgres.OutboxBatch) error
+}
+
+// messageKeyKey is the context key under which the key of a message is stored.
+const messageKeyKey = contextKey("message_key")
+
+// WithMessageKey returns a copy of the context carrying the key by which a
+// redelivery of the message processed with it is recognised as a duplicate.
+// When payloads are queued in the outbox the key is saved along with them, so
+// that a redelivery after a crash is dropped only if they were queued.
+func WithMessageKey(ctx context.Context, key *postgres.MessageKey) context.Context {
+	return context.WithValue(ctx, messageKeyKey, key)
+}
+
+// contextMessageKey returns the message key the context carries, or nil if it
+// has none.
+func contextMessageKey(ctx context.Context) *postgres.MessageKey {
+	key, _ := ctx.Value(messageKeyKey).(*postgres.MessageKey)
+	return key
+}
+
+// outboxBatchKey is the context key under which the outbox batch of the
+// message being processed is stored.
+const outboxBatchKey = contextKey("outbox_batch")
+
+// outboxBatch collects the payloads and bookkeeping of a message as each of
+// its streams is processed, so that they are written to the outbox together,
+// along with the updates to state held outside the database which are to be
+// made only once they have been written.
+type outboxBatch struct {
+	postgres.OutboxBatch
+	enqueued []func()
+}
+
+// afterEnqueue registers a function to be called once the batch has been
+// written to the outbox.
+func (b *outboxBatch) afterEnqueue(fn func()) {
+	b.enqueued = append(b.enqueued, fn)
+}
+
+// withOutboxBatch returns a copy of the context carrying the given batch.
+func withOutboxBatch(ctx context.Context, batch *outboxBatch) context.Context {
+	return context.WithValue(ctx, outboxBatchKey, batch)
+}
+
+// contextOutboxBatch returns the outbox batch the context carries, or nil if
+// payloads are not being queued in the outbox.
+func contextOutboxBatch(ctx context.Context) *outboxBatch {
+	batch, _ := ctx.Value(outboxBatchKey).(*outboxBatch)
+	return batch
+}
+
+// enqueue writes the batch collected while processing a message to the outbox
+// in a single transaction, then makes the updates that were waiting for it. A
+// batch to which no payloads were added is not written, the key of its
+// message being saved by our deduplicator as usual.
+func (p *Processor) enqueue(batch *outboxBatch, log kitlog.Logger) error {
+	if len(batch.Entries) == 0 {
+		return nil
+	}
+
+	err := p.outbox.EnqueueOutbox(&batch.OutboxBatch)
+	if err != nil {
+		level.Error(log).Log("err", err, "msg", "failed to enqueue data")
+		return err
+	}
+
+	for _, fn := range batch.enqueued {
+		fn()
+	}
+
+	return nil
+}
+
+// outboxStage returns a stage adding the encrypted payloads of the message to
+// the batch of the message, so that they are queued in the outbox once every
+// stream has been processed. The chunks of a payload are queued together, so
+// either all are delivered or, if queueing fails, none are.
+func (p *Processor) outboxStage(stream *postgres.Stream) Stage {
+	return StageFunc(func(ctx context.Context, msg *Message) (*Message, error) {
+		batch := contextOutboxBatch(ctx)
+		enqueuedAt := time.Now()
+
+		for _, encodedPayload := range msg.Encrypted {
+			n := len(encodedPayload)
+
+			batch.Encrypted[stream.StreamID] += n
+			batch.afterEnqueue(func() {
+				p.stats.RecordEncrypted(stream.StreamID, n)
+			})
+
+			batch.Entries = append(batch.Entries, &postgres.OutboxEntry{
+				StreamID:         stream.StreamID,
+				CommunityID:      stream.CommunityID,
+				DeviceToken:      msg.Device.DeviceToken,
+				DatastoreAddr:    stream.DatastoreAddr,
+				DatastoreTimeout: stream.DatastoreTimeout,
+				Data:             encodedPayload,
+				EnqueuedAt:       enqueuedAt,
+				DeliverAfter:     null.NewTime(msg.DeliverAfter, !msg.DeliverAfter.IsZero()),
+			})
+		}
+
+		return msg, nil
+	})
+}
+
+// Deliver writes an entry of the outbox to the datastore of its stream within
+// the stream's write timeout, recording the write as if it had been made as
+// the payload was processed.
+func (p *Processor) Deliver(ctx context.Context, entry *postgres.OutboxEntry) error {
+	stream := &postgres.Stream{
+		StreamID:         entry.StreamID,
+		CommunityID:      entry.CommunityID,
+		DatastoreAddr:    entry.DatastoreAddr,
+		DatastoreTimeout: entry.DatastoreTimeout,
+	}
+
+	return p.writePayload(ctx, stream, entry.DeviceToken, entry.Data)
+}
@@ -0,0 +1,217 @@
+package pipeline_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	datastore "github.com/thingful/twirp-datastore-go"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/lua"
+	"github.com/DECODEproject/iotencoder/pkg/mocks"
+	"github.com/DECODEproject/iotencoder/pkg/pipeline"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/stats"
+)
+
+// fakeOutbox records the batches enqueued, failing if err is set.
+type fakeOutbox struct {
+	batches []*postgres.OutboxBatch
+	err     error
+}
+
+func (f *fakeOutbox) EnqueueOutbox(batch *postgres.OutboxBatch) error {
+	if f.err != nil {
+		return f.err
+	}
+
+	f.batches = append(f.batches, batch)
+
+	return nil
+}
+
+func TestProcessWithOutbox(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	ds := mocks.Datastore{}
+	ob := &fakeOutbox{}
+
+	ds.On(
+		"WriteData",
+		mock.Anything,
+		mock.Anything,
+	).Return(
+		&datastore.WriteResponse{},
+		nil,
+	)
+
+	passthroughExec := func(script, keys, data []byte) ([]byte, error) {
+		return json.Marshal(string(data))
+	}
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore: datastore.Datastore(&ds),
+		Stats:     stats.NewStore(nil, time.Minute, clock.New(), logger),
+		Scripts:   lua.NewScripts(&lua.Config{}, logger),
+		Zenroom:   pipeline.NewZenroomPool(1, time.Second, passthroughExec),
+		Outbox:    ob,
+		ChunkSize: 64,
+	}, logger)
+
+	payload := []byte(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":13,"value":51.00},{"id":29,"value":64.5}]}]}`)
+
+	device := &postgres.Device{
+		DeviceToken: "foo",
+		Streams: []*postgres.Stream{
+			{
+				StreamID:         "e8a1f33a-6a1c-4c43-a2ab-4a4a8c5a5b1f",
+				CommunityID:      "smartcitizen",
+				PublicKey:        `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+				DatastoreAddr:    "http://datastore.local:8080",
+				DatastoreTimeout: "2s",
+			},
+		},
+	}
+
+	err := processor.Process(context.Background(), device, payload)
+	assert.Nil(t, err)
+
+	// nothing is written directly, while every chunk of the payload is
+	// enqueued together
+	assert.Len(t, ds.Calls, 0)
+	assert.Len(t, ob.batches, 1)
+
+	batch := ob.batches[0]
+	assert.True(t, len(batch.Entries) > 1)
+
+	encrypted := 0
+	for _, entry := range batch.Entries {
+		encrypted += len(entry.Data)
+	}
+	assert.Equal(t, map[string]int{"e8a1f33a-6a1c-4c43-a2ab-4a4a8c5a5b1f": encrypted}, batch.Encrypted)
+
+	for _, entry := range batch.Entries {
+		assert.Equal(t, "e8a1f33a-6a1c-4c43-a2ab-4a4a8c5a5b1f", entry.StreamID)
+		assert.Equal(t, "smartcitizen", entry.CommunityID)
+		assert.Equal(t, "foo", entry.DeviceToken.Reveal())
+		assert.Equal(t, "http://datastore.local:8080", entry.DatastoreAddr)
+		assert.Equal(t, "2s", entry.DatastoreTimeout)
+		assert.NotEmpty(t, entry.Data)
+		assert.False(t, entry.EnqueuedAt.IsZero())
+	}
+
+	// the payload is lost if it can't be enqueued
+	ob.err = errors.New("outbox unavailable")
+
+	err = processor.Process(context.Background(), device, payload)
+	assert.NotNil(t, err)
+	assert.Len(t, ds.Calls, 0)
+}
+
+func TestProcessWithOutboxBatch(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	ob := &fakeOutbox{}
+
+	passthroughExec := func(script, keys, data []byte) ([]byte, error) {
+		return json.Marshal(string(data))
+	}
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore:      datastore.Datastore(&mocks.Datastore{}),
+		Stats:          stats.NewStore(nil, time.Minute, clock.New(), logger),
+		Scripts:        lua.NewScripts(&lua.Config{}, logger),
+		Zenroom:        pipeline.NewZenroomPool(1, time.Second, passthroughExec),
+		MovingAverager: pipeline.NewMovingAverager(nil, false, clock.New(), logger),
+		Outbox:         ob,
+	}, logger)
+
+	device := &postgres.Device{
+		DeviceToken: "foo",
+		Streams: []*postgres.Stream{
+			{
+				StreamID:    "e8a1f33a-6a1c-4c43-a2ab-4a4a8c5a5b1f",
+				CommunityID: "smartcitizen",
+				PublicKey:   "abc123",
+				Operations: postgres.Operations{
+					{SensorID: 13, Action: postgres.MovingAverage, Interval: 900},
+				},
+			},
+			{
+				StreamID:    "3c3aa0d6-7e7a-4bc8-b2a5-7d5f0c8b4f55",
+				CommunityID: "smartcitizen",
+				PublicKey:   "def456",
+			},
+		},
+	}
+
+	key := &postgres.MessageKey{Key: "abc", SeenAt: time.Now()}
+	ctx := pipeline.WithMessageKey(context.Background(), key)
+
+	payload := func(value int) []byte {
+		return []byte(fmt.Sprintf(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":13,"value":%d}]}]}`, value))
+	}
+
+	// a reading whose payloads can't be queued is not added to the moving
+	// average
+	ob.err = errors.New("outbox unavailable")
+
+	err := processor.Process(ctx, device, payload(10))
+	assert.NotNil(t, err)
+
+	ob.err = nil
+
+	err = processor.Process(ctx, device, payload(20))
+	assert.Nil(t, err)
+
+	// the payloads of both streams are queued together with the message key
+	assert.Len(t, ob.batches, 1)
+
+	batch := ob.batches[0]
+	assert.Len(t, batch.Entries, 2)
+	assert.Equal(t, key, batch.MessageKey)
+	assert.Len(t, batch.Encrypted, 2)
+
+	assert.Equal(t, "e8a1f33a-6a1c-4c43-a2ab-4a4a8c5a5b1f", batch.Entries[0].StreamID)
+	assert.Contains(t, string(batch.Entries[0].Data), `\"value\":20`)
+	assert.Equal(t, "3c3aa0d6-7e7a-4bc8-b2a5-7d5f0c8b4f55", batch.Entries[1].StreamID)
+}
+
+func TestDeliver(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	ds := mocks.Datastore{}
+
+	ds.On(
+		"WriteData",
+		mock.Anything,
+		&datastore.WriteRequest{
+			CommunityId: "smartcitizen",
+			DeviceToken: "foo",
+			Data:        []byte("encrypted"),
+		},
+	).Return(
+		&datastore.WriteResponse{},
+		nil,
+	)
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore: datastore.Datastore(&ds),
+		Stats:     stats.NewStore(nil, time.Minute, clock.New(), logger),
+	}, logger)
+
+	err := processor.Deliver(context.Background(), &postgres.OutboxEntry{
+		ID:          1,
+		StreamID:    "e8a1f33a-6a1c-4c43-a2ab-4a4a8c5a5b1f",
+		CommunityID: "smartcitizen",
+		DeviceToken: "foo",
+		Data:        []byte("encrypted"),
+	})
+	assert.Nil(t, err)
+
+	ds.AssertExpectations(t)
+}
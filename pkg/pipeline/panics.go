@@ -0,0 +1,77 @@
+package pipeline
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"runtime/debug"
+
+	raven "github.com/getsentry/raven-go"
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/DECODEproject/iotencoder/pkg/logger/level"
+)
+
+var (
+	// PanicCounter is a prometheus counter recording a count of panics
+	// recovered while processing messages.
+	PanicCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "processing_panics",
+			Help:      "Count of panics recovered while processing messages",
+		},
+	)
+)
+
+// panicError is the error returned in place of a panic recovered while
+// processing a message, holding the stack of the goroutine which panicked.
+type panicError struct {
+	value interface{}
+	stack []byte
+}
+
+// Error returns a message including the value with which we panicked.
+func (e *panicError) Error() string {
+	return fmt.Sprintf("panic while processing message: %v", e.value)
+}
+
+// recoverPanic must be deferred directly. If the deferring function is
+// panicking it recovers, writes a crash report to the given logger including
+// the stack and a hash of the payload being processed, and sets err to a
+// panicError. The payload itself is not logged as it may contain personal data.
+func (p *Processor) recoverPanic(log kitlog.Logger, payload []byte, err *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	PanicCounter.Inc()
+
+	// panics within zenroom executions are raised again in the goroutine which
+	// requested the execution, already carrying their original stack
+	perr, ok := r.(*panicError)
+	if !ok {
+		perr = &panicError{value: r, stack: debug.Stack()}
+	}
+
+	level.Error(log).Log(
+		"msg", "recovered from panic while processing message",
+		"panic", fmt.Sprint(perr.value),
+		"payload_hash", hashPayload(payload),
+		"stack", string(perr.stack),
+	)
+
+	raven.CaptureError(perr, map[string]string{"operation": "process"})
+
+	*err = perr
+}
+
+// hashPayload returns the hex encoded SHA-256 digest of the given payload, by
+// which a crash report may be matched to a retained payload.
+func hashPayload(payload []byte) string {
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
@@ -2,20 +2,24 @@ package pipeline
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"time"
 
-	zenroom "github.com/DECODEproject/zenroom-go"
 	kitlog "github.com/go-kit/kit/log"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	datastore "github.com/thingful/twirp-datastore-go"
 	"gopkg.in/guregu/null.v3"
 
-	"github.com/DECODEproject/iotencoder/pkg/lua"
+	"github.com/DECODEproject/iotencoder/pkg/logger"
+	"github.com/DECODEproject/iotencoder/pkg/logger/level"
 	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/privacy"
+	"github.com/DECODEproject/iotencoder/pkg/requestid"
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+	"github.com/DECODEproject/iotencoder/pkg/signing"
 	"github.com/DECODEproject/iotencoder/pkg/smartcitizen"
+	"github.com/DECODEproject/iotencoder/pkg/verify"
 )
 
 var (
@@ -30,6 +34,22 @@ var (
 		},
 	)
 
+	// DatastoreTimeoutCounter is a prometheus counter recording a count of
+	// writes to the datastore abandoned because they exceeded their write
+	// timeout. These are not counted by DatastoreErrorCounter.
+	DatastoreTimeoutCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "datastore_timeouts",
+			Help:      "Count of datastore writes abandoned due to their write timeout",
+		},
+	)
+
+	// ErrDatastoreTimeout is returned when a write to the datastore does not
+	// complete within the stream's write timeout.
+	ErrDatastoreTimeout = errors.New("datastore write timed out")
+
 	// ZenroomErrorCounter is a prometheus counter recording a count of any errors
 	// that occur when invoking zenroom.
 	ZenroomErrorCounter = prometheus.NewCounter(
@@ -42,15 +62,8 @@ var (
 	)
 
 	// DatastoreWriteHistogram is a prometheus histogram recording successful
-	// writes to the datastore. We use the default bucket distributions.
-	DatastoreWriteHistogram = prometheus.NewHistogram(
-		prometheus.HistogramOpts{
-			Namespace: "decode",
-			Subsystem: "encoder",
-			Name:      "datastore_writes",
-			Help:      "Datastore writes duration distribution",
-		},
-	)
+	// writes to the datastore. Buckets may be configured via SetBuckets.
+	DatastoreWriteHistogram = newDatastoreWriteHistogram(nil)
 
 	// ProcessHistogram is a prometheus histogram recording duration of processing
 	// a device for a stream.
@@ -64,140 +77,769 @@ var (
 		[]string{"operation"},
 	)
 
+	// DeadlineExceededCounter is a prometheus counter recording a count of
+	// messages whose processing deadline expired, labelled by the stage of the
+	// pipeline at which the deadline was detected.
+	DeadlineExceededCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "message_deadline_exceeded",
+			Help:      "Count of messages abandoned because their processing deadline expired",
+		},
+		[]string{"stage"},
+	)
+
+	// DownsampledCounter is a prometheus counter recording a count of sensor
+	// readings dropped because they arrived within the interval of a downsampled
+	// stream.
+	DownsampledCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "downsampled_readings",
+			Help:      "Count of sensor readings dropped by downsampling",
+		},
+	)
+
+	// UnchangedCounter is a prometheus counter recording a count of sensor
+	// readings dropped because they did not differ sufficiently from the last
+	// reading forwarded for a change-only sensor.
+	UnchangedCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "unchanged_readings",
+			Help:      "Count of sensor readings dropped by change-only forwarding",
+		},
+	)
+
+	// SampledOutCounter is a prometheus counter recording a count of messages
+	// discarded because they were not selected by the sampling of their
+	// stream.
+	SampledOutCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "sampled_out_messages",
+			Help:      "Count of messages discarded by stream sampling",
+		},
+	)
+
+	// OutsideScheduleCounter is a prometheus counter recording a count of
+	// messages received outside the schedule of their stream, labelled by
+	// whether they were dropped or buffered.
+	OutsideScheduleCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "outside_schedule_messages",
+			Help:      "Count of messages received outside their stream's schedule",
+		},
+		[]string{"action"},
+	)
+
 	// ZenroomHistogram is a prometheus histogram recording execution times of
-	// calls to zenroom to exec some script.
-	ZenroomHistogram = prometheus.NewHistogram(
+	// calls to zenroom to exec some script, i.e. the time taken to encrypt data.
+	// Buckets may be configured via SetBuckets.
+	ZenroomHistogram = newZenroomHistogram(nil)
+)
+
+// Buckets holds the upper bounds in seconds of the buckets of our latency
+// histograms. A nil slice means the prometheus default buckets are used.
+type Buckets struct {
+	Datastore  []float64
+	Encryption []float64
+	Ingestion  []float64
+}
+
+// SetBuckets replaces DatastoreWriteHistogram, ZenroomHistogram and
+// IngestionLagHistogram with histograms using the given buckets. It must be
+// called before the histograms are registered and before any messages are
+// processed.
+func SetBuckets(buckets *Buckets) {
+	DatastoreWriteHistogram = newDatastoreWriteHistogram(buckets.Datastore)
+	ZenroomHistogram = newZenroomHistogram(buckets.Encryption)
+	IngestionLagHistogram = newIngestionLagHistogram(buckets.Ingestion, streamLabelKeys)
+	ingestionBuckets = buckets.Ingestion
+}
+
+// newDatastoreWriteHistogram returns the datastore write histogram with the
+// given buckets.
+func newDatastoreWriteHistogram(buckets []float64) prometheus.Histogram {
+	return prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "datastore_writes",
+			Help:      "Datastore writes duration distribution",
+			Buckets:   buckets,
+		},
+	)
+}
+
+// newZenroomHistogram returns the zenroom execution histogram with the given
+// buckets.
+func newZenroomHistogram(buckets []float64) prometheus.Histogram {
+	return prometheus.NewHistogram(
 		prometheus.HistogramOpts{
 			Namespace: "decode",
 			Subsystem: "encoder",
 			Name:      "zenroom_exec",
 			Help:      "Execution time of zenroom scripts",
+			Buckets:   buckets,
 		},
 	)
+}
+
+// StatsRecorder is the interface we use to record per stream counters and per
+// device statuses as messages are processed. We define it here where we need
+// it, and it is satisfied by the stats.Store type.
+type StatsRecorder interface {
+	RecordMessage(streamID string)
+	RecordEncrypted(streamID string, n int)
+	RecordWrite(streamID string, err error)
+	RecordLatency(streamID string, d time.Duration)
+	RecordThrottled(streamID string)
+	RecordDropped(streamID string, n int)
+	RecordDeviceMessage(deviceToken secret.Secret)
+	RecordDeviceResult(deviceToken secret.Secret, err error)
+}
+
+// contextKey is the type of the keys under which we store values in a context.
+type contextKey string
+
+// replayKey is the context key marking payloads being replayed.
+const replayKey = contextKey("replay")
+
+// WithReplay returns a copy of the context marking the payloads processed with
+// it as replayed from retention, rather than newly received from the device.
+// Replayed payloads are not recorded in the status of the device.
+func WithReplay(ctx context.Context) context.Context {
+	return context.WithValue(ctx, replayKey, true)
+}
+
+// isReplay returns true if the context was marked by WithReplay.
+func isReplay(ctx context.Context) bool {
+	replay, _ := ctx.Value(replayKey).(bool)
+	return replay
+}
+
+// metadataKey is the context key under which message metadata is stored.
+const metadataKey = contextKey("metadata")
+
+// WithMetadata returns a copy of the context carrying metadata received with
+// the payloads processed with it, such as MQTT 5 user properties. The metadata
+// is added to the device data written to each stream's datastore.
+func WithMetadata(ctx context.Context, metadata map[string]string) context.Context {
+	return context.WithValue(ctx, metadataKey, metadata)
+}
+
+// contextMetadata returns the metadata the context carries, or nil if it has none.
+func contextMetadata(ctx context.Context) map[string]string {
+	m, _ := ctx.Value(metadataKey).(map[string]string)
+	return m
+}
+
+// Downsampler is the interface we use to decide whether a reading for a
+// downsampled sensor should be forwarded. It is satisfied by the
+// downsample.Store type.
+type Downsampler interface {
+	Allow(streamID string, sensorID int, interval uint32) bool
+}
+
+// ScriptSelector is the interface we use to obtain the zenroom script used to
+// encrypt data for a given processing type. It is satisfied by the lua.Scripts
+// type.
+type ScriptSelector interface {
+	ScriptFor(processingType string) ([]byte, error)
+}
+
+// Datastore is the interface we use to write encrypted data, and to read from
+// a datastore to check it is reachable. We define it here where we need it,
+// and it is satisfied by the twirp datastore client as well as any alternative
+// sink or stub which callers wish to inject.
+type Datastore interface {
+	WriteData(ctx context.Context, req *datastore.WriteRequest) (*datastore.WriteResponse, error)
+	ReadData(ctx context.Context, req *datastore.ReadRequest) (*datastore.ReadResponse, error)
+}
+
+// WriteSampler is the interface we call with each successful write to the
+// datastore, so that a sample of writes may be read back and verified. It is
+// satisfied by the verify.Verifier type.
+type WriteSampler interface {
+	Sample(ds verify.Datastore, streamID, communityID string, data []byte)
+}
+
+const (
+	// Passthrough is the processing type of a stream that shares sensor values
+	// without any transformation.
+	Passthrough = "passthrough"
+
+	// Average is the processing type of a stream that includes at least one
+	// moving average operation.
+	Average = "average"
+
+	// Binned is the processing type of a stream that includes at least one
+	// binning operation, but no moving averages.
+	Binned = "bin"
+
+	// ChangeOnly is the processing type of a stream that includes at least one
+	// change-only operation, but no moving averages or binning.
+	ChangeOnly = "delta"
+
+	// Downsampled is the processing type of a stream that includes at least one
+	// downsampling operation, but no other processing.
+	Downsampled = "downsample"
+
+	// Noised is the processing type of a stream with a differential privacy
+	// spec, which injects noise into its moving averages.
+	Noised = "noise"
 )
 
+// ProcessingType returns the processing type of the given stream, which is used
+// to select the zenroom script used to encrypt its data. Where a stream mixes
+// operations the type is that of the most heavily processed operation, while a
+// stream which injects noise has that type whatever its operations. Sampling
+// selects which messages are written without changing how they are processed,
+// so doesn't affect the type.
+func ProcessingType(stream *postgres.Stream) string {
+	if stream.Privacy != "" {
+		return Noised
+	}
+
+	processingType := Passthrough
+
+	for _, op := range stream.Operations {
+		switch op.Action {
+		case postgres.MovingAverage:
+			return Average
+		case postgres.Bin:
+			processingType = Binned
+		case postgres.Delta:
+			if processingType != Binned {
+				processingType = ChangeOnly
+			}
+		case postgres.Downsample:
+			if processingType == Passthrough {
+				processingType = Downsampled
+			}
+		}
+	}
+
+	return processingType
+}
+
+// Config is used to pass in dependencies and configuration when creating a
+// Processor. Datastores is optional, and if set is used to obtain clients for
+// streams which write to their own datastore rather than to Datastore.
+// Downsampler and ChangeDetector are optional, and if nil downsampled and
+// change-only sensors are shared in full. Ranges is optional, and if set
+// implausible readings are dropped or clamped before any other processing. If
+// ChunkSize is greater than zero, payloads larger than this many bytes are
+// split into chunks which are encrypted and written separately. If
+// DatastoreTimeout is greater than zero it is the deadline applied to each
+// datastore write of streams which don't set their own. Schemas is optional,
+// and if set readings of streams which reference a payload schema are
+// validated against it. AllowPlaintext must be set for the readings of sensors
+// with the plain disposition to be written unencrypted. Ledger is optional, and
+// if nil the privacy budgets of streams are not enforced, while Noise is the
+// source from which differential privacy noise is drawn, and if nil is
+// privacy.CryptoSource. Cells counts the devices reporting from each location
+// cell, and if nil the locations of streams requiring k-anonymity are always
+// suppressed. Outbox is optional, and if set encrypted payloads are queued
+// there for delivery rather than written to the datastore directly. Limiter is
+// optional, and if nil the rate limits of streams are not enforced. Sampler is
+// optional, and if nil every message of a sampled stream is written. Signer is
+// optional, and if set every encrypted payload is signed before being written.
+// Quotas is optional, and if nil the message and byte quotas of communities are
+// not enforced. Verifier is optional, and if set successful writes are passed
+// to it so that a sample may be read back from the datastore. Archiver is
+// optional, and if set the encrypted payloads of archived streams are also
+// written to it. Shedder is optional, and if nil no messages are shed when the
+// encoder is saturated.
+type Config struct {
+	Datastore        Datastore
+	Datastores       *DatastorePool
+	MovingAverager   MovingAverager
+	Downsampler      Downsampler
+	ChangeDetector   ChangeDetector
+	Ranges           *RangeFilter
+	Stats            StatsRecorder
+	Scripts          ScriptSelector
+	Zenroom          *ZenroomPool
+	Schemas          SchemaResolver
+	Ledger           PrivacyLedger
+	Noise            privacy.Source
+	Cells            CellCounter
+	Outbox           Outbox
+	Limiter          RateLimiter
+	Quotas           QuotaEnforcer
+	Verifier         WriteSampler
+	Archiver         Archiver
+	Sampler          Sampler
+	Shedder          Shedder
+	Signer           *signing.Signer
+	ChunkSize        int
+	DatastoreTimeout time.Duration
+	Metadata         bool
+	AllowPlaintext   bool
+	Verbose          bool
+
+	// BrokerTimestampProperty is optional, and if set is the metadata property
+	// in which the broker records the time at which it received each message,
+	// against which the ingestion lag of the message is measured.
+	BrokerTimestampProperty string
+}
+
 // Processor is a type that encapsulates processing incoming events received
 // from smartcitizen, and is responsible for enriching the data, applying any
 // transformations to the data and then encrypting it using zenroom before
 // writing it to the datastore.
 type Processor struct {
-	datastore datastore.Datastore
-	logger    kitlog.Logger
-	verbose   bool
-	sensors   *smartcitizen.Smartcitizen
-	movingAvg MovingAverager
+	datastore  Datastore
+	datastores *DatastorePool
+	logger     kitlog.Logger
+	verbose    bool
+	sensors    *smartcitizen.Smartcitizen
+	movingAvg  MovingAverager
+	downsample Downsampler
+	changes    ChangeDetector
+	ranges     *RangeFilter
+	stats      StatsRecorder
+	scripts    ScriptSelector
+	zenroom    *ZenroomPool
+	schemas    SchemaResolver
+	ledger     PrivacyLedger
+	noise      privacy.Source
+	cells      CellCounter
+	outbox     Outbox
+	limiter    RateLimiter
+	quotas     QuotaEnforcer
+	verifier   WriteSampler
+	archiver   Archiver
+	sampler    Sampler
+	shedder    Shedder
+	signer     *signing.Signer
+	builder    *Builder
+	chunkSize  int
+	timeout    time.Duration
+	metadata   bool
+
+	// brokerProperty is the metadata property holding the broker's timestamp
+	brokerProperty string
+
+	// allowPlaintext is true if readings may be written unencrypted
+	allowPlaintext bool
 }
 
-// NewProcessor is a constructor function that takes as input an instantiated
-// datastore client, and a logger. It returns the instantiated processor which
-// is ready for use. Note we pass in the datastore instance so that we can
+// NewProcessor is a constructor function that takes as input a Config object
+// containing an instantiated datastore client, moving averager, stats recorder,
+// script selector and zenroom pool, and a logger. It returns the instantiated processor
+// which is ready for use. Note we pass in the datastore instance so that we can
 // supply a mock for testing.
-func NewProcessor(ds datastore.Datastore, movingAvg MovingAverager, verbose bool, logger kitlog.Logger) *Processor {
+func NewProcessor(config *Config, logger kitlog.Logger) *Processor {
 	logger = kitlog.With(logger, "module", "pipeline")
 
-	return &Processor{
-		datastore: ds,
-		logger:    logger,
-		verbose:   verbose,
-		sensors:   &smartcitizen.Smartcitizen{},
-		movingAvg: movingAvg,
+	noise := config.Noise
+	if noise == nil {
+		noise = privacy.CryptoSource{}
+	}
+
+	p := &Processor{
+		datastore:  config.Datastore,
+		datastores: config.Datastores,
+		logger:     logger,
+		verbose:    config.Verbose,
+		sensors:    &smartcitizen.Smartcitizen{},
+		movingAvg:  config.MovingAverager,
+		downsample: config.Downsampler,
+		changes:    config.ChangeDetector,
+		ranges:     config.Ranges,
+		stats:      config.Stats,
+		scripts:    config.Scripts,
+		zenroom:    config.Zenroom,
+		schemas:    config.Schemas,
+		ledger:     config.Ledger,
+		noise:      noise,
+		cells:      config.Cells,
+		outbox:     config.Outbox,
+		limiter:    config.Limiter,
+		quotas:     config.Quotas,
+		verifier:   config.Verifier,
+		archiver:   config.Archiver,
+		sampler:    config.Sampler,
+		shedder:    config.Shedder,
+		signer:     config.Signer,
+		chunkSize:  config.ChunkSize,
+		timeout:    config.DatastoreTimeout,
+		metadata:   config.Metadata,
+
+		brokerProperty: config.BrokerTimestampProperty,
+		allowPlaintext: config.AllowPlaintext,
+	}
+
+	p.builder = p.newBuilder()
+
+	return p
+}
+
+// Use adds a factory of stages to run in the given phase of the chain applied
+// to each stream, after our own stages for that phase. This allows new
+// processing types to be added without changing how payloads are received.
+// It must be called before the processor is used.
+func (p *Processor) Use(phase Phase, factory StageFactory) {
+	p.builder.Use(phase, factory)
+}
+
+// DryRun validates that data for the given stream can be encrypted by
+// executing the zenroom script selected for the stream against an empty
+// payload. This allows us to reject streams with invalid keys or scripts at
+// creation time rather than when the first message arrives.
+func (p *Processor) DryRun(ctx context.Context, stream *postgres.Stream) error {
+	script, err := p.scripts.ScriptFor(ProcessingType(stream))
+	if err != nil {
+		return errors.Wrap(err, "failed to read zenroom script")
+	}
+
+	_, err = p.zenroom.Exec(
+		ctx,
+		script,
+		buildKeys(stream.Device.DeviceToken, stream),
+		[]byte(`{}`),
+	)
+	if err != nil {
+		return errors.Wrap(err, "dry-run execution of zenroom script failed")
 	}
+
+	return nil
 }
 
 // Process is the function that actually does the work of dispatching the
 // received data to all destination streams after applying whatever processing
-// the stream specifies. Currently we do the simplest thing of just writing the
-// data directly to the datastore.
-func (p *Processor) Process(device *postgres.Device, payload []byte) error {
+// the stream specifies. The passed in context bounds the encryption and
+// datastore writes for all streams, and if it is cancelled or its deadline
+// expires we return without processing any remaining streams. If the context
+// carries no request id one is generated, by which the payload is traced in our
+// logs and in its writes to the datastore.
+func (p *Processor) Process(ctx context.Context, device *postgres.Device, payload []byte) (err error) {
+	ctx = requestid.Ensure(ctx)
+
+	if !isReplay(ctx) {
+		p.stats.RecordDeviceMessage(device.DeviceToken)
+
+		defer func() {
+			p.stats.RecordDeviceResult(device.DeviceToken, err)
+		}()
+	}
+
+	log := kitlog.With(requestid.Logger(ctx, p.logger), "device_hash", logger.HashToken(device.DeviceToken))
+
+	// panics outside the processing of a single stream, such as while parsing
+	// the payload, are also recovered so that they don't take down the encoder
+	defer p.recoverPanic(log, payload, &err)
+
 	// check payload
 	if payload == nil {
 		return errors.New("empty payload received")
 	}
 
-	parsedDevice, err := p.sensors.ParseData(device, payload)
-	if err != nil {
-		return errors.Wrap(err, "failed to parse SmartCitizen data")
-	}
+	// the payload is parsed by the decode stage of the first stream's chain,
+	// and its readings shared with the chains of the others
+	decoded := NewPayload(payload)
+
+	// the payloads of every stream are queued in the outbox together once all
+	// have been processed. Those of streams processed before a failure are
+	// still queued, as the message is not processed again.
+	if p.outbox != nil {
+		batch := &outboxBatch{
+			OutboxBatch: postgres.OutboxBatch{
+				MessageKey: contextMessageKey(ctx),
+				Encrypted:  map[string]int{},
+			},
+		}
 
-	// pull encryption script from go-bindata asset
-	script, err := lua.Asset("encrypt.lua")
-	if err != nil {
-		return errors.Wrap(err, "failed to read zenroom script")
+		ctx = withOutboxBatch(ctx, batch)
+
+		defer func() {
+			if eerr := p.enqueue(batch, log); err == nil {
+				err = eerr
+			}
+		}()
 	}
 
+	panicked := 0
+
 	// iterate over the configured streams for the device
 	for _, stream := range device.Streams {
-		if p.verbose {
-			p.logger.Log("public_key", stream.PublicKey, "device_token", device.DeviceToken, "msg", "writing data")
+		if ctx.Err() != nil {
+			recordDeadline(ctx, "process")
+			return errors.Wrap(ctx.Err(), "message processing abandoned")
 		}
 
-		keyString := fmt.Sprintf(
-			`{"device_token":"%s","community_id":"%s","community_pubkey":"%s"}`,
-			device.DeviceToken,
-			stream.CommunityID,
-			stream.PublicKey,
-		)
-
-		payloadBytes, err := p.processDevice(parsedDevice, stream)
+		err = p.processStream(ctx, device, stream, decoded)
 		if err != nil {
+			// a panic is isolated to the stream whose processing caused it, so
+			// we carry on with the remaining streams
+			if _, ok := err.(*panicError); ok {
+				panicked++
+				continue
+			}
 			return err
 		}
+	}
 
-		if p.verbose {
-			p.logger.Log("full_payload", string(payloadBytes))
-		}
+	if panicked > 0 {
+		return errors.Errorf("processing panicked for %d of %d streams", panicked, len(device.Streams))
+	}
 
-		start := time.Now()
+	return nil
+}
 
-		encodedPayload, err := zenroom.Exec(
-			script,
-			zenroom.WithKeys([]byte(keyString)),
-			zenroom.WithData(payloadBytes),
-			zenroom.WithVerbosity(1),
-		)
+// decode returns the readings of the given payload of the device, parsing it
+// the first time it is decoded for any of the device's streams. Metadata
+// received with the payload is attached to its readings, and implausible
+// readings dropped or clamped.
+func (p *Processor) decode(ctx context.Context, device *postgres.Device, payload *Payload) (*smartcitizen.Device, error) {
+	if payload.parsed {
+		return payload.device, payload.err
+	}
 
-		duration := time.Since(start)
+	payload.parsed = true
 
-		if err != nil {
-			ZenroomErrorCounter.Inc()
-			return err
-		}
+	parsedDevice, err := p.sensors.ParseData(device, payload.Data)
+	if err != nil {
+		payload.err = errors.Wrap(err, "failed to parse SmartCitizen data")
+		return nil, payload.err
+	}
+
+	if m := contextMetadata(ctx); len(m) > 0 {
+		parsedDevice.Metadata = m
+	}
+
+	if p.ranges != nil {
+		p.ranges.Apply(parsedDevice)
+	}
+
+	payload.device = parsedDevice
 
-		ZenroomHistogram.Observe(duration.Seconds())
+	return parsedDevice, nil
+}
 
-		start = time.Now()
+// processStream passes the payload through the chain of stages built for the
+// given stream, which decode it, apply the processing the stream specifies then
+// encrypt and write the result to the stream's datastore. If processing panics
+// the panic is recovered, and returned as an error after logging a crash
+// report.
+func (p *Processor) processStream(ctx context.Context, device *postgres.Device, stream *postgres.Stream, payload *Payload) (err error) {
+	processStart := time.Now()
 
-		_, err = p.datastore.WriteData(context.Background(), &datastore.WriteRequest{
-			CommunityId: stream.CommunityID,
-			DeviceToken: device.DeviceToken,
-			Data:        []byte(encodedPayload),
-		})
+	log := p.streamLogger(ctx, device, stream)
 
-		duration = time.Since(start)
+	// deferred before recovering from any panic, so a panic is recorded as a
+	// failure
+	written, throttled, shed := false, false, false
+	defer func() {
+		recordStreamMessage(stream, streamResult(written, throttled, shed, err))
+	}()
 
-		if err != nil {
-			DatastoreErrorCounter.Inc()
+	defer p.recoverPanic(log, payload.Data, &err)
+
+	p.stats.RecordMessage(stream.StreamID)
+
+	// replayed payloads are not throttled or shed, as they were accepted when
+	// first received
+	if !isReplay(ctx) {
+		throttled, err = p.throttle(stream, log)
+		if err != nil || throttled {
 			return err
 		}
 
-		DatastoreWriteHistogram.Observe(duration.Seconds())
+		throttled = p.exceedsQuota(stream, len(payload.Data), log)
+		if throttled {
+			return nil
+		}
+
+		shed, err = p.shed(stream, log)
+		if err != nil || shed {
+			return err
+		}
+	}
+
+	if p.verbose {
+		level.Debug(log).Log("public_key", stream.PublicKey, "msg", "writing data")
+	}
+
+	msg, err := p.builder.Build(stream).Process(ctx, &Message{
+		Device:     device,
+		Stream:     stream,
+		Payload:    payload,
+		ReceivedAt: processStart,
+		Logger:     log,
+	})
+	if err != nil {
+		return err
 	}
 
+	// the payload was rejected, or every reading for the stream was dropped
+	// by downsampling or change-only forwarding, so nothing was written
+	if msg == nil {
+		return nil
+	}
+
+	p.stats.RecordLatency(stream.StreamID, time.Since(processStart))
+
+	written = true
+
 	return nil
 }
 
-func (p *Processor) processDevice(device *smartcitizen.Device, stream *postgres.Stream) ([]byte, error) {
-	// if no operations just return the whole object
-	if len(stream.Operations) == 0 {
-		b, err := json.Marshal(device)
-		if err != nil {
-			return nil, errors.Wrap(err, "failed to marshal complete device")
+// writePayload writes an encrypted payload of the given device to the stream's
+// datastore, recording the write in the stream's stats and in our metrics.
+// Writes abandoned because the stream's write timeout expired are counted by
+// DatastoreTimeoutCounter rather than DatastoreErrorCounter.
+func (p *Processor) writePayload(ctx context.Context, stream *postgres.Stream, deviceToken secret.Secret, data []byte) error {
+	start := time.Now()
+
+	err := p.write(ctx, stream, &datastore.WriteRequest{
+		CommunityId: stream.CommunityID,
+		DeviceToken: deviceToken.Reveal(),
+		Data:        data,
+	})
+
+	duration := time.Since(start)
+
+	p.stats.RecordWrite(stream.StreamID, err)
+
+	if err == ErrDatastoreTimeout {
+		return err
+	}
+
+	if err != nil {
+		DatastoreErrorCounter.Inc()
+		return err
+	}
+
+	DatastoreWriteHistogram.Observe(duration.Seconds())
+
+	return nil
+}
+
+// write writes the given request to the stream's datastore within the stream's
+// write timeout, sending the request id carried by the context as a header. If
+// the write is abandoned because the timeout expired we return
+// ErrDatastoreTimeout, while if the passed in context expires first its error
+// is returned as for any other failed write.
+func (p *Processor) write(ctx context.Context, stream *postgres.Stream, req *datastore.WriteRequest) error {
+	ctx = requestid.Outgoing(ctx)
+
+	timeout := p.writeTimeout(stream)
+	if timeout <= 0 {
+		_, err := p.datastoreFor(stream).WriteData(ctx, req)
+		p.sampleWrite(stream, req, err)
+		return err
+	}
+
+	writeCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	_, err := p.datastoreFor(stream).WriteData(writeCtx, req)
+	if err != nil && writeCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		DatastoreTimeoutCounter.Inc()
+		return ErrDatastoreTimeout
+	}
+
+	p.sampleWrite(stream, req, err)
+
+	return err
+}
+
+// sampleWrite passes a successful write to our verifier, if we have one, which
+// may read it back later to check it was stored as written.
+func (p *Processor) sampleWrite(stream *postgres.Stream, req *datastore.WriteRequest, err error) {
+	if err != nil || p.verifier == nil {
+		return
+	}
+
+	p.verifier.Sample(p.datastoreFor(stream), stream.StreamID, req.CommunityId, req.Data)
+}
+
+// writeTimeout returns the timeout applied to writes of the given stream's
+// data, which is the stream's own timeout if it has one, else our default.
+func (p *Processor) writeTimeout(stream *postgres.Stream) time.Duration {
+	if stream.DatastoreTimeout != "" {
+		timeout, err := ParseDatastoreTimeout(stream.DatastoreTimeout)
+		if err == nil {
+			return timeout
 		}
-		return b, nil
 	}
 
+	return p.timeout
+}
+
+// ParseDatastoreTimeout parses the datastore write timeout of a stream, which
+// must be a positive duration, e.g. "2s".
+func ParseDatastoreTimeout(s string) (time.Duration, error) {
+	timeout, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, errors.Wrap(err, "invalid datastore timeout")
+	}
+
+	if timeout <= 0 {
+		return 0, errors.Errorf("datastore timeout must be positive: %s", s)
+	}
+
+	return timeout, nil
+}
+
+// datastoreFor returns the datastore client to which data for the given stream
+// should be written.
+func (p *Processor) datastoreFor(stream *postgres.Stream) Datastore {
+	if stream.DatastoreAddr == "" || p.datastores == nil {
+		return p.datastore
+	}
+
+	return p.datastores.Get(stream.DatastoreAddr)
+}
+
+// streamLogger returns a logger which attaches the identifiers of the stream
+// being processed, and the request id carried by the context, to every line.
+// The device token is hashed as it is a secret.
+func (p *Processor) streamLogger(ctx context.Context, device *postgres.Device, stream *postgres.Stream) kitlog.Logger {
+	return kitlog.With(requestid.Logger(ctx, p.logger),
+		"stream_uid", stream.StreamID,
+		"device_hash", logger.HashToken(device.DeviceToken),
+		"community_id", stream.CommunityID,
+	)
+}
+
+// recordDeadline increments the deadline exceeded counter for the given stage
+// if the context's deadline has expired. Timeouts of individual zenroom
+// executions are recorded separately by the zenroom pool.
+func recordDeadline(ctx context.Context, stage string) {
+	if ctx.Err() == context.DeadlineExceeded {
+		DeadlineExceededCounter.WithLabelValues(stage).Inc()
+	}
+}
+
+// buildKeys returns the keys document passed to zenroom when encrypting data
+// for a stream.
+func buildKeys(deviceToken secret.Secret, stream *postgres.Stream) []byte {
+	return []byte(fmt.Sprintf(
+		`{"device_token":"%s","community_id":"%s","community_pubkey":"%s"}`,
+		deviceToken.Reveal(),
+		stream.CommunityID,
+		stream.PublicKey,
+	))
+}
+
+// applyOperations applies the stream's operations to the readings of the
+// device, returning the processed readings along with the number dropped by
+// downsampling or change-only forwarding. The given device is not modified.
+func (p *Processor) applyOperations(ctx context.Context, device *smartcitizen.Device, stream *postgres.Stream) ([]*smartcitizen.Sensor, int, error) {
 	// create empty slice for processed sensors
 	processedSensors := []*smartcitizen.Sensor{}
+	dropped := 0
 
 	for _, operation := range stream.Operations {
 		// get the sensor from the parsed slice
@@ -240,17 +882,49 @@ func (p *Processor) processDevice(device *smartcitizen.Device, stream *postgres.
 				ProcessHistogram.WithLabelValues(string(postgres.Bin)).Observe(duration.Seconds() * 1e3)
 
 				processedSensors = append(processedSensors, processedSensor)
+			case postgres.Downsample:
+				if p.downsample != nil && !p.downsample.Allow(stream.StreamID, sensor.ID, operation.Interval) {
+					DownsampledCounter.Inc()
+					dropped++
+					continue
+				}
+
+				processedSensors = append(processedSensors, &smartcitizen.Sensor{
+					ID:          sensor.ID,
+					Name:        sensor.Name,
+					Description: sensor.Description,
+					Unit:        sensor.Unit,
+					Action:      operation.Action,
+					Value:       sensor.Value,
+				})
+			case postgres.Delta:
+				if p.changes != nil && sensor.Value != nil && sensor.Value.Valid &&
+					!p.changes.Changed(stream.StreamID, sensor.ID, sensor.Value.Float64, operation.Threshold) {
+					UnchangedCounter.Inc()
+					dropped++
+					continue
+				}
+
+				processedSensors = append(processedSensors, &smartcitizen.Sensor{
+					ID:          sensor.ID,
+					Name:        sensor.Name,
+					Description: sensor.Description,
+					Unit:        sensor.Unit,
+					Action:      operation.Action,
+					Value:       sensor.Value,
+				})
 			case postgres.MovingAverage:
 				start := time.Now()
 
 				avgVal, err := p.movingAvg.MovingAverage(
+					ctx,
 					sensor.Value.Float64,
 					device.Token,
 					sensor.ID,
 					operation.Interval,
 				)
 				if err != nil {
-					return nil, errors.Wrap(err, "failed to calculate moving average")
+					return nil, 0, errors.Wrap(err, "failed to calculate moving average")
 				}
 
 				interval := null.IntFrom(int64(operation.Interval))
@@ -277,14 +951,7 @@ func (p *Processor) processDevice(device *smartcitizen.Device, stream *postgres.
 		}
 	}
 
-	device.Sensors = processedSensors
-
-	b, err := json.Marshal(device)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to marshal processed device")
-	}
-
-	return b, nil
+	return processedSensors, dropped, nil
 }
 
 // BinValue is a function that tuns a value and a slice containing bin
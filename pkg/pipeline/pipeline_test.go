@@ -2,24 +2,46 @@ package pipeline_test
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"math"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/DECODEproject/zenroom-go"
 	kitlog "github.com/go-kit/kit/log"
+	"github.com/golang/protobuf/proto"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	datastore "github.com/thingful/twirp-datastore-go"
+	"github.com/twitchtv/twirp"
 
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/compress"
+	"github.com/DECODEproject/iotencoder/pkg/downsample"
+	"github.com/DECODEproject/iotencoder/pkg/geoprivacy"
 	"github.com/DECODEproject/iotencoder/pkg/lua"
 	"github.com/DECODEproject/iotencoder/pkg/mocks"
 	"github.com/DECODEproject/iotencoder/pkg/pipeline"
 	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/postgres/postgrestest"
+	"github.com/DECODEproject/iotencoder/pkg/requestid"
+	"github.com/DECODEproject/iotencoder/pkg/schema"
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+	"github.com/DECODEproject/iotencoder/pkg/signing"
 	"github.com/DECODEproject/iotencoder/pkg/smartcitizen"
+	"github.com/DECODEproject/iotencoder/pkg/stats"
 )
 
+// cString returns a copy of b with a trailing NUL byte, as zenroom reads its
+// inputs as C strings and would otherwise read beyond the end of b.
+func cString(b []byte) []byte {
+	return append(append([]byte{}, b...), 0)
+}
+
 func decryptData(t *testing.T, call mock.Call, secKey string) (*smartcitizen.Device, error) {
 	t.Helper()
 	req := call.Arguments[1].(*datastore.WriteRequest)
@@ -31,8 +53,8 @@ func decryptData(t *testing.T, call mock.Call, secKey string) (*smartcitizen.Dev
 
 	output, err := zenroom.Exec(
 		decryptScript,
-		zenroom.WithKeys(decryptKeys),
-		zenroom.WithData(req.Data),
+		zenroom.WithKeys(cString(decryptKeys)),
+		zenroom.WithData(cString(req.Data)),
 		zenroom.WithVerbosity(1),
 	)
 	assert.Nil(t, err)
@@ -56,7 +78,7 @@ func TestProcess(t *testing.T) {
 	// set up a mock response
 	ds.On(
 		"WriteData",
-		context.Background(),
+		mock.Anything,
 		mock.Anything,
 	).Return(
 		&datastore.WriteResponse{},
@@ -66,6 +88,7 @@ func TestProcess(t *testing.T) {
 	mv := mocks.MovingAverager{}
 	mv.On(
 		"MovingAverage",
+		mock.Anything,
 		12.58,
 		"foo",
 		12,
@@ -75,14 +98,24 @@ func TestProcess(t *testing.T) {
 		nil,
 	)
 
+	st := stats.NewStore(nil, time.Minute, clock.New(), logger)
+
 	payload := []byte(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":13, "value":51.00},{"id":14, "value":426.42},{"id":12, "value":12.58},{"id":29, "value":79.35},{"id":53, "value":51.00},{"id":58, "value":101.56},{"id":89, "value":4.00},{"id":87, "value":7.00},{"id":88, "value":7.00}]}]}`)
 
-	processor := pipeline.NewProcessor(datastore.Datastore(&ds), &mv, true, logger)
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore:      datastore.Datastore(&ds),
+		MovingAverager: &mv,
+		Stats:          st,
+		Scripts:        lua.NewScripts(&lua.Config{}, logger),
+		Zenroom:        pipeline.NewZenroomPool(1, time.Second, pipeline.ZenroomExec),
+		Verbose:        true,
+	}, logger)
 
 	device := &postgres.Device{
 		DeviceToken: "foo",
 		Streams: []*postgres.Stream{
 			{
+				StreamID:    "e8a1f33a-6a1c-4c43-a2ab-4a4a8c5a5b1f",
 				CommunityID: "smartcitizen",
 				PublicKey:   `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
 				Operations: postgres.Operations{
@@ -109,7 +142,7 @@ func TestProcess(t *testing.T) {
 		},
 	}
 
-	err := processor.Process(device, payload)
+	err := processor.Process(context.Background(), device, payload)
 	assert.Nil(t, err)
 
 	ds.AssertExpectations(t)
@@ -122,84 +155,1433 @@ func TestProcess(t *testing.T) {
 
 	assert.NotNil(t, decryptedDevice)
 	assert.Len(t, decryptedDevice.Sensors, 4)
+
+	streamStats := st.Get("e8a1f33a-6a1c-4c43-a2ab-4a4a8c5a5b1f")
+	assert.NotNil(t, streamStats)
+	assert.Equal(t, uint64(1), streamStats.MessagesReceived)
+	assert.Equal(t, uint64(1), streamStats.WritesSucceeded)
+	assert.Equal(t, uint64(0), streamStats.WritesFailed)
+	assert.True(t, streamStats.BytesEncrypted > 0)
+	assert.True(t, streamStats.LastMessageAt.Valid)
+
+	deviceStatus := st.GetDevice("foo")
+	assert.NotNil(t, deviceStatus)
+	assert.Equal(t, uint64(1), deviceStatus.MessageCounts.Total())
+	assert.False(t, deviceStatus.Error.Valid)
 }
 
-func TestProcessWithNoOperations(t *testing.T) {
+func TestProcessReplayNotRecorded(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	st := stats.NewStore(nil, time.Minute, clock.New(), logger)
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Stats:   st,
+		Scripts: lua.NewScripts(&lua.Config{}, logger),
+		Zenroom: pipeline.NewZenroomPool(1, time.Second, pipeline.ZenroomExec),
+	}, logger)
+
+	device := &postgres.Device{DeviceToken: "foo"}
+	payload := []byte(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":13, "value":51.00}]}]}`)
+
+	err := processor.Process(pipeline.WithReplay(context.Background()), device, payload)
+	assert.Nil(t, err)
+	assert.Nil(t, st.GetDevice("foo"))
+
+	err = processor.Process(context.Background(), device, payload)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), st.GetDevice("foo").MessageCounts.Total())
+}
+
+func TestProcessChunked(t *testing.T) {
 	logger := kitlog.NewNopLogger()
 	ds := mocks.Datastore{}
 
-	// set up a mock response
 	ds.On(
 		"WriteData",
-		context.Background(),
+		mock.Anything,
 		mock.Anything,
 	).Return(
 		&datastore.WriteResponse{},
 		nil,
 	)
 
-	mv := mocks.MovingAverager{}
+	st := stats.NewStore(nil, time.Minute, clock.New(), logger)
 
-	payload := []byte(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":13, "value":51.00},{"id":14, "value":426.42},{"id":12, "value":12.58},{"id":29, "value":79.35},{"id":53, "value":51.00},{"id":58, "value":101.56},{"id":89, "value":4.00},{"id":87, "value":7.00},{"id":88, "value":7.00}]}]}`)
+	payload := []byte(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":13, "value":51.00},{"id":14, "value":426.42},{"id":53, "value":51.00},{"id":58, "value":101.56}]}]}`)
 
-	processor := pipeline.NewProcessor(datastore.Datastore(&ds), &mv, true, logger)
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore: datastore.Datastore(&ds),
+		Stats:     st,
+		Scripts:   lua.NewScripts(&lua.Config{}, logger),
+		Zenroom:   pipeline.NewZenroomPool(1, time.Second, pipeline.ZenroomExec),
+		ChunkSize: 100,
+	}, logger)
 
 	device := &postgres.Device{
 		DeviceToken: "foo",
 		Streams: []*postgres.Stream{
 			{
+				StreamID:    "e8a1f33a-6a1c-4c43-a2ab-4a4a8c5a5b1f",
 				CommunityID: "smartcitizen",
 				PublicKey:   `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
-				Operations:  postgres.Operations{},
 			},
 		},
 	}
 
-	err := processor.Process(device, payload)
+	err := processor.Process(context.Background(), device, payload)
 	assert.Nil(t, err)
 
-	ds.AssertExpectations(t)
-	mv.AssertExpectations(t)
+	assert.True(t, len(ds.Calls) > 1)
+
+	decryptKeys := []byte(`{"community_seckey":"D19GsDTGjLBX23J281SNpXWUdu+oL6hdAJ0Zh6IrRHA="}`)
+
+	decryptScript, err := lua.Asset("decrypt.lua")
+	assert.Nil(t, err)
+
+	var (
+		chunkID   string
+		assembled string
+	)
+
+	for i, call := range ds.Calls {
+		var chunk pipeline.Chunk
+
+		err = json.Unmarshal(call.Arguments[1].(*datastore.WriteRequest).Data, &chunk)
+		assert.Nil(t, err)
+
+		if i == 0 {
+			chunkID = chunk.ID
+		}
+
+		assert.Equal(t, chunkID, chunk.ID)
+		assert.Equal(t, i, chunk.Index)
+		assert.Equal(t, len(ds.Calls), chunk.Count)
+
+		output, err := zenroom.Exec(
+			decryptScript,
+			zenroom.WithKeys(cString(decryptKeys)),
+			zenroom.WithData(cString(chunk.Data)),
+			zenroom.WithVerbosity(1),
+		)
+		assert.Nil(t, err)
+
+		var decrypted map[string]interface{}
+		err = json.Unmarshal(output, &decrypted)
+		assert.Nil(t, err)
+
+		assembled += decrypted["data"].(string)
+	}
+
+	var decryptedDevice smartcitizen.Device
+	err = json.Unmarshal([]byte(assembled), &decryptedDevice)
+	assert.Nil(t, err)
+	assert.Len(t, decryptedDevice.Sensors, 4)
+
+	streamStats := st.Get("e8a1f33a-6a1c-4c43-a2ab-4a4a8c5a5b1f")
+	assert.Equal(t, uint64(1), streamStats.MessagesReceived)
+	assert.Equal(t, uint64(len(ds.Calls)), streamStats.WritesSucceeded)
+}
+
+func TestProcessCompressed(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	ds := mocks.Datastore{}
+
+	ds.On(
+		"WriteData",
+		mock.Anything,
+		mock.Anything,
+	).Return(
+		&datastore.WriteResponse{},
+		nil,
+	)
+
+	// rather than encrypting, write the data zenroom is given as a JSON string
+	// so we can check exactly what would have been encrypted
+	passthroughExec := func(script, keys, data []byte) ([]byte, error) {
+		return json.Marshal(string(data))
+	}
+
+	payload := []byte(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":13, "value":51.00},{"id":14, "value":426.42},{"id":53, "value":51.00},{"id":58, "value":101.56}]}]}`)
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore: datastore.Datastore(&ds),
+		Stats:     stats.NewStore(nil, time.Minute, clock.New(), logger),
+		Scripts:   lua.NewScripts(&lua.Config{}, logger),
+		Zenroom:   pipeline.NewZenroomPool(1, time.Second, passthroughExec),
+	}, logger)
+
+	device := &postgres.Device{
+		DeviceToken: "foo",
+		Streams: []*postgres.Stream{
+			{
+				StreamID:    "e8a1f33a-6a1c-4c43-a2ab-4a4a8c5a5b1f",
+				CommunityID: "smartcitizen",
+				PublicKey:   `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+				Compression: compress.Zstd,
+			},
+		},
+	}
 
+	err := processor.Process(context.Background(), device, payload)
+	assert.Nil(t, err)
 	assert.Len(t, ds.Calls, 1)
 
-	decryptedDevice, err := decryptData(t, ds.Calls[0], "D19GsDTGjLBX23J281SNpXWUdu+oL6hdAJ0Zh6IrRHA=")
+	var envelope pipeline.Envelope
+	err = json.Unmarshal(ds.Calls[0].Arguments[1].(*datastore.WriteRequest).Data, &envelope)
 	assert.Nil(t, err)
+	assert.Equal(t, compress.Zstd, envelope.ContentEncoding)
 
-	assert.Len(t, decryptedDevice.Sensors, 9)
+	var encoded string
+	err = json.Unmarshal(envelope.Data, &encoded)
+	assert.Nil(t, err)
+
+	compressed, err := base64.StdEncoding.DecodeString(encoded)
+	assert.Nil(t, err)
+
+	decompressed, err := compress.Decompress(envelope.ContentEncoding, compressed)
+	assert.Nil(t, err)
+
+	var decryptedDevice smartcitizen.Device
+	err = json.Unmarshal(decompressed, &decryptedDevice)
+	assert.Nil(t, err)
+	assert.Len(t, decryptedDevice.Sensors, 4)
 }
 
-func TestProcessWithDatastoreError(t *testing.T) {
+func TestProcessEntryMetadata(t *testing.T) {
 	logger := kitlog.NewNopLogger()
 	ds := mocks.Datastore{}
 
 	ds.On(
 		"WriteData",
-		context.Background(),
+		mock.Anything,
 		mock.Anything,
 	).Return(
 		&datastore.WriteResponse{},
-		errors.New("error"),
+		nil,
 	)
 
-	mv := mocks.MovingAverager{}
+	passthroughExec := func(script, keys, data []byte) ([]byte, error) {
+		return json.Marshal(string(data))
+	}
 
-	payload := []byte(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":13, "value":51.00},{"id":14, "value":426.42},{"id":12, "value":12.58},{"id":29, "value":79.35},{"id":53, "value":51.00},{"id":58, "value":101.56},{"id":89, "value":4.00},{"id":87, "value":7.00},{"id":88, "value":7.00}]}]}`)
+	payload := []byte(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":13, "value":51.00}]}]}`)
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore: datastore.Datastore(&ds),
+		Stats:     stats.NewStore(nil, time.Minute, clock.New(), logger),
+		Scripts:   lua.NewScripts(&lua.Config{}, logger),
+		Zenroom:   pipeline.NewZenroomPool(1, time.Second, passthroughExec),
+		Metadata:  true,
+	}, logger)
 
-	processor := pipeline.NewProcessor(&ds, &mv, true, logger)
 	device := &postgres.Device{
 		DeviceToken: "foo",
 		Streams: []*postgres.Stream{
 			{
+				StreamID:    "e8a1f33a-6a1c-4c43-a2ab-4a4a8c5a5b1f",
 				CommunityID: "smartcitizen",
 				PublicKey:   `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
 			},
 		},
 	}
 
-	err := processor.Process(device, payload)
-	assert.NotNil(t, err)
-	assert.Equal(t, "error", err.Error())
+	before := time.Now()
 
-	ds.AssertExpectations(t)
+	err := processor.Process(context.Background(), device, payload)
+	assert.Nil(t, err)
+	assert.Len(t, ds.Calls, 1)
+
+	var envelope pipeline.Envelope
+	err = json.Unmarshal(ds.Calls[0].Arguments[1].(*datastore.WriteRequest).Data, &envelope)
+	assert.Nil(t, err)
+	assert.Equal(t, "", envelope.ContentEncoding)
+
+	var metadata pipeline.Metadata
+	err = proto.Unmarshal(envelope.Metadata, &metadata)
+	assert.Nil(t, err)
+
+	assert.Equal(t, "2c26b46b68ff", metadata.DeviceHash)
+	assert.Equal(t, pipeline.Passthrough, metadata.ProcessingType)
+	assert.Equal(t, uint32(pipeline.SchemaVersion), metadata.SchemaVersion)
+	assert.Len(t, metadata.KeyVersion, 12)
+	assert.True(t, metadata.IngestedAt >= before.UnixNano()/int64(time.Millisecond))
+
+	var encoded string
+	err = json.Unmarshal(envelope.Data, &encoded)
+	assert.Nil(t, err)
+
+	var decryptedDevice smartcitizen.Device
+	err = json.Unmarshal([]byte(encoded), &decryptedDevice)
+	assert.Nil(t, err)
+	assert.Len(t, decryptedDevice.Sensors, 1)
+}
+
+func TestProcessSigned(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	ds := mocks.Datastore{}
+
+	ds.On(
+		"WriteData",
+		mock.Anything,
+		mock.Anything,
+	).Return(
+		&datastore.WriteResponse{},
+		nil,
+	)
+
+	passthroughExec := func(script, keys, data []byte) ([]byte, error) {
+		return json.Marshal(string(data))
+	}
+
+	key, err := signing.GenerateKey()
+	assert.Nil(t, err)
+
+	payload := []byte(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":13, "value":51.00}]}]}`)
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore: datastore.Datastore(&ds),
+		Stats:     stats.NewStore(nil, time.Minute, clock.New(), logger),
+		Scripts:   lua.NewScripts(&lua.Config{}, logger),
+		Zenroom:   pipeline.NewZenroomPool(1, time.Second, passthroughExec),
+		Signer:    signing.NewSigner(key, "encoder-1"),
+	}, logger)
+
+	device := &postgres.Device{
+		DeviceToken: "foo",
+		Streams: []*postgres.Stream{
+			{
+				StreamID:    "e8a1f33a-6a1c-4c43-a2ab-4a4a8c5a5b1f",
+				CommunityID: "smartcitizen",
+				PublicKey:   `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+			},
+		},
+	}
+
+	err = processor.Process(context.Background(), device, payload)
+	assert.Nil(t, err)
+	assert.Len(t, ds.Calls, 1)
+
+	var envelope pipeline.Envelope
+	err = json.Unmarshal(ds.Calls[0].Arguments[1].(*datastore.WriteRequest).Data, &envelope)
+	assert.Nil(t, err)
+	assert.Nil(t, envelope.Metadata)
+
+	if assert.NotNil(t, envelope.Provenance) {
+		assert.Equal(t, "encoder-1", envelope.Provenance.Instance)
+		assert.Equal(t, signing.KeyID(&key.PublicKey), envelope.Provenance.KeyID)
+		assert.Nil(t, signing.Verify(&key.PublicKey, envelope.Data, envelope.Metadata, envelope.Provenance))
+		assert.NotNil(t, signing.Verify(&key.PublicKey, []byte(`"tampered"`), envelope.Metadata, envelope.Provenance))
+	}
+}
+
+func TestProcessSendsRequestID(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	ds := mocks.Datastore{}
+
+	ds.On(
+		"WriteData",
+		mock.Anything,
+		mock.Anything,
+	).Return(
+		&datastore.WriteResponse{},
+		nil,
+	)
+
+	passthroughExec := func(script, keys, data []byte) ([]byte, error) {
+		return json.Marshal(string(data))
+	}
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore: datastore.Datastore(&ds),
+		Stats:     stats.NewStore(nil, time.Minute, clock.New(), logger),
+		Scripts:   lua.NewScripts(&lua.Config{}, logger),
+		Zenroom:   pipeline.NewZenroomPool(1, time.Second, passthroughExec),
+	}, logger)
+
+	device := &postgres.Device{
+		DeviceToken: "foo",
+		Streams: []*postgres.Stream{
+			{
+				StreamID:    "e8a1f33a-6a1c-4c43-a2ab-4a4a8c5a5b1f",
+				CommunityID: "smartcitizen",
+				PublicKey:   `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+			},
+		},
+	}
+
+	payload := []byte(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":13, "value":51.00}]}]}`)
+
+	err := processor.Process(requestid.With(context.Background(), "request-id"), device, payload)
+	assert.Nil(t, err)
+	assert.Len(t, ds.Calls, 1)
+
+	header, ok := twirp.HTTPRequestHeaders(ds.Calls[0].Arguments[0].(context.Context))
+	assert.True(t, ok)
+	assert.Equal(t, "request-id", header.Get(requestid.Header))
+
+	// a request id is generated for payloads received without one
+	err = processor.Process(context.Background(), device, payload)
+	assert.Nil(t, err)
+	assert.Len(t, ds.Calls, 2)
+
+	header, ok = twirp.HTTPRequestHeaders(ds.Calls[1].Arguments[0].(context.Context))
+	assert.True(t, ok)
+	assert.NotEqual(t, "", header.Get(requestid.Header))
+	assert.NotEqual(t, "request-id", header.Get(requestid.Header))
+}
+
+func TestProcessWithSchema(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	ds := mocks.Datastore{}
+
+	ds.On(
+		"WriteData",
+		mock.Anything,
+		mock.Anything,
+	).Return(
+		&datastore.WriteResponse{},
+		nil,
+	)
+
+	passthroughExec := func(script, keys, data []byte) ([]byte, error) {
+		return json.Marshal(string(data))
+	}
+
+	registry := schema.NewRegistry(&schema.Config{Store: postgrestest.NewDB()}, logger)
+
+	_, err := registry.Register("noise", postgres.Channels{
+		{SensorID: 13, Name: "humidity", Unit: "%", Type: postgres.NumberType},
+		{SensorID: 14, Name: "light", Unit: "lux", Type: postgres.IntegerType},
+	})
+	assert.Nil(t, err)
+
+	payload := []byte(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":13,"value":51.00},{"id":14,"value":10.5},{"id":29,"value":64.5}]}]}`)
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore: datastore.Datastore(&ds),
+		Stats:     stats.NewStore(nil, time.Minute, clock.New(), logger),
+		Scripts:   lua.NewScripts(&lua.Config{}, logger),
+		Zenroom:   pipeline.NewZenroomPool(1, time.Second, passthroughExec),
+		Schemas:   registry,
+	}, logger)
+
+	device := &postgres.Device{
+		DeviceToken: "foo",
+		Streams: []*postgres.Stream{
+			{
+				StreamID:    "e8a1f33a-6a1c-4c43-a2ab-4a4a8c5a5b1f",
+				CommunityID: "smartcitizen",
+				PublicKey:   `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+				Schema:      "noise@1",
+			},
+		},
+	}
+
+	err = processor.Process(context.Background(), device, payload)
+	assert.Nil(t, err)
+	assert.Len(t, ds.Calls, 1)
+
+	var envelope pipeline.Envelope
+	err = json.Unmarshal(ds.Calls[0].Arguments[1].(*datastore.WriteRequest).Data, &envelope)
+	assert.Nil(t, err)
+
+	// metadata carrying the schema reference is written even though entry
+	// metadata is not enabled
+	var metadata pipeline.Metadata
+	err = proto.Unmarshal(envelope.Metadata, &metadata)
+	assert.Nil(t, err)
+	assert.Equal(t, "noise@1", metadata.Schema)
+
+	var encoded string
+	err = json.Unmarshal(envelope.Data, &encoded)
+	assert.Nil(t, err)
+
+	// the undeclared sensor and the non integer light reading are dropped
+	var decryptedDevice smartcitizen.Device
+	err = json.Unmarshal([]byte(encoded), &decryptedDevice)
+	assert.Nil(t, err)
+	assert.Len(t, decryptedDevice.Sensors, 1)
+	assert.Equal(t, 13, decryptedDevice.Sensors[0].ID)
+}
+
+func TestProcessWithDispositions(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+
+	passthroughExec := func(script, keys, data []byte) ([]byte, error) {
+		return json.Marshal(string(data))
+	}
+
+	payload := []byte(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":13,"value":51.00},{"id":14,"value":10.5},{"id":29,"value":64.5}]}]}`)
+
+	testcases := []struct {
+		label             string
+		allowPlaintext    bool
+		expectedEncrypted []int
+		expectedPlain     []int
+	}{
+		{
+			label:             "plaintext enabled",
+			allowPlaintext:    true,
+			expectedEncrypted: []int{29},
+			expectedPlain:     []int{13},
+		},
+		{
+			label:             "plaintext not enabled",
+			allowPlaintext:    false,
+			expectedEncrypted: []int{13, 29},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			ds := mocks.Datastore{}
+
+			ds.On(
+				"WriteData",
+				mock.Anything,
+				mock.Anything,
+			).Return(
+				&datastore.WriteResponse{},
+				nil,
+			)
+
+			processor := pipeline.NewProcessor(&pipeline.Config{
+				Datastore:      datastore.Datastore(&ds),
+				Stats:          stats.NewStore(nil, time.Minute, clock.New(), logger),
+				Scripts:        lua.NewScripts(&lua.Config{}, logger),
+				Zenroom:        pipeline.NewZenroomPool(1, time.Second, passthroughExec),
+				AllowPlaintext: tc.allowPlaintext,
+			}, logger)
+
+			device := &postgres.Device{
+				DeviceToken: "foo",
+				Streams: []*postgres.Stream{
+					{
+						StreamID:     "e8a1f33a-6a1c-4c43-a2ab-4a4a8c5a5b1f",
+						CommunityID:  "smartcitizen",
+						PublicKey:    `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+						Dispositions: postgres.Dispositions{13: postgres.Plain, 14: postgres.Drop},
+						Conversions:  postgres.Conversions{13: "x * 2"},
+					},
+				},
+			}
+
+			err := processor.Process(context.Background(), device, payload)
+			assert.Nil(t, err)
+			assert.Len(t, ds.Calls, 1)
+
+			data := ds.Calls[0].Arguments[1].(*datastore.WriteRequest).Data
+
+			var encoded string
+
+			if tc.expectedPlain == nil {
+				// with no readings written in plain there is no envelope
+				err = json.Unmarshal(data, &encoded)
+				assert.Nil(t, err)
+			} else {
+				var envelope pipeline.Envelope
+				err = json.Unmarshal(data, &envelope)
+				assert.Nil(t, err)
+
+				err = json.Unmarshal(envelope.Data, &encoded)
+				assert.Nil(t, err)
+
+				assert.NotNil(t, envelope.Plaintext)
+				assert.Equal(t, time.Date(2018, 12, 11, 14, 46, 44, 0, time.UTC), envelope.Plaintext.RecordedAt)
+				assert.Equal(t, tc.expectedPlain, sensorIDs(envelope.Plaintext.Sensors))
+
+				// readings written in plain are converted as any other
+				assert.Equal(t, 102.0, envelope.Plaintext.Sensors[0].Value.Float64)
+			}
+
+			var decryptedDevice smartcitizen.Device
+			err = json.Unmarshal([]byte(encoded), &decryptedDevice)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expectedEncrypted, sensorIDs(decryptedDevice.Sensors))
+
+			if tc.expectedPlain == nil {
+				assert.Equal(t, 102.0, decryptedDevice.Sensors[0].Value.Float64)
+			}
+		})
+	}
+}
+
+// constantSource is a privacy.Source always returning the same number.
+type constantSource float64
+
+func (s constantSource) Float64() float64 {
+	return float64(s)
+}
+
+// fakeLedger is a pipeline.PrivacyLedger holding the total spent by every
+// stream in every period.
+type fakeLedger struct {
+	spent float64
+}
+
+func (l *fakeLedger) SpendPrivacyBudget(streamID string, periodStart time.Time, cost, budget float64) (bool, error) {
+	if l.spent+cost > budget {
+		return false, nil
+	}
+
+	l.spent += cost
+
+	return true, nil
+}
+
+func TestProcessWithPrivacy(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	ds := mocks.Datastore{}
+
+	ds.On(
+		"WriteData",
+		mock.Anything,
+		mock.Anything,
+	).Return(
+		&datastore.WriteResponse{},
+		nil,
+	)
+
+	mv := mocks.MovingAverager{}
+
+	mv.On(
+		"MovingAverage",
+		mock.Anything,
+		mock.Anything,
+		mock.Anything,
+		13,
+		uint32(900),
+	).Return(
+		50.0,
+		nil,
+	)
+
+	passthroughExec := func(script, keys, data []byte) ([]byte, error) {
+		return json.Marshal(string(data))
+	}
+
+	ledger := &fakeLedger{}
+
+	// with a uniform draw of 0.75 laplace noise is b * ln(2), where b is
+	// sensitivity / epsilon = 2
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore:      datastore.Datastore(&ds),
+		MovingAverager: &mv,
+		Stats:          stats.NewStore(nil, time.Minute, clock.New(), logger),
+		Scripts:        lua.NewScripts(&lua.Config{}, logger),
+		Zenroom:        pipeline.NewZenroomPool(1, time.Second, passthroughExec),
+		Ledger:         ledger,
+		Noise:          constantSource(0.75),
+	}, logger)
+
+	payload := []byte(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":13,"value":51.00},{"id":29,"value":64.5}]}]}`)
+
+	device := &postgres.Device{
+		DeviceToken: "foo",
+		Streams: []*postgres.Stream{
+			{
+				StreamID:    "e8a1f33a-6a1c-4c43-a2ab-4a4a8c5a5b1f",
+				CommunityID: "smartcitizen",
+				PublicKey:   `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+				Operations: postgres.Operations{
+					{SensorID: 13, Action: postgres.MovingAverage, Interval: 900},
+					{SensorID: 29, Action: postgres.Share},
+				},
+				Privacy: "laplace:epsilon=0.5,budget=1",
+			},
+		},
+	}
+
+	decrypt := func(call mock.Call) *smartcitizen.Device {
+		var encoded string
+		err := json.Unmarshal(call.Arguments[1].(*datastore.WriteRequest).Data, &encoded)
+		assert.Nil(t, err)
+
+		var decryptedDevice smartcitizen.Device
+		err = json.Unmarshal([]byte(encoded), &decryptedDevice)
+		assert.Nil(t, err)
+
+		return &decryptedDevice
+	}
+
+	for i := 0; i < 3; i++ {
+		err := processor.Process(context.Background(), device, payload)
+		assert.Nil(t, err)
+	}
+
+	assert.Len(t, ds.Calls, 3)
+	assert.Equal(t, 1.0, ledger.spent)
+
+	// the budget covers two noised moving averages
+	for _, call := range ds.Calls[:2] {
+		decryptedDevice := decrypt(call)
+		assert.Equal(t, []int{13, 29}, sensorIDs(decryptedDevice.Sensors))
+		assert.InDelta(t, 50+2*math.Ln2, decryptedDevice.Sensors[0].Value.Float64, 1e-9)
+		assert.Equal(t, 64.5, decryptedDevice.Sensors[1].Value.Float64)
+	}
+
+	// after which they are withheld
+	assert.Equal(t, []int{29}, sensorIDs(decrypt(ds.Calls[2]).Sensors))
+}
+
+func TestProcessWithGeoPrivacy(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	ds := mocks.Datastore{}
+
+	ds.On(
+		"WriteData",
+		mock.Anything,
+		mock.Anything,
+	).Return(
+		&datastore.WriteResponse{},
+		nil,
+	)
+
+	passthroughExec := func(script, keys, data []byte) ([]byte, error) {
+		return json.Marshal(string(data))
+	}
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore: datastore.Datastore(&ds),
+		Stats:     stats.NewStore(nil, time.Minute, clock.New(), logger),
+		Scripts:   lua.NewScripts(&lua.Config{}, logger),
+		Zenroom:   pipeline.NewZenroomPool(1, time.Second, passthroughExec),
+		Cells:     geoprivacy.NewTracker(clock.New()),
+	}, logger)
+
+	payload := []byte(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":13,"value":51.00}]}]}`)
+
+	newDevice := func(token string, latitude, longitude float64) *postgres.Device {
+		return &postgres.Device{
+			DeviceToken: secret.Secret(token),
+			Latitude:    latitude,
+			Longitude:   longitude,
+			Streams: []*postgres.Stream{
+				{
+					StreamID:    "e8a1f33a-6a1c-4c43-a2ab-4a4a8c5a5b1f",
+					CommunityID: "smartcitizen",
+					PublicKey:   `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+					GeoPrivacy:  "grid=500m,k=2,window=1h",
+				},
+			},
+		}
+	}
+
+	decrypt := func(call mock.Call) *smartcitizen.Device {
+		var encoded string
+		err := json.Unmarshal(call.Arguments[1].(*datastore.WriteRequest).Data, &encoded)
+		assert.Nil(t, err)
+
+		var decryptedDevice smartcitizen.Device
+		err = json.Unmarshal([]byte(encoded), &decryptedDevice)
+		assert.Nil(t, err)
+
+		return &decryptedDevice
+	}
+
+	// the first device to report from a cell has its location suppressed
+	err := processor.Process(context.Background(), newDevice("foo", 41.3851, 2.1734), payload)
+	assert.Nil(t, err)
+
+	// while the second to report from it has its location snapped to its
+	// centre
+	err = processor.Process(context.Background(), newDevice("bar", 41.3852, 2.1735), payload)
+	assert.Nil(t, err)
+
+	assert.Len(t, ds.Calls, 2)
+
+	suppressed := decrypt(ds.Calls[0])
+	assert.True(t, suppressed.LocationSuppressed)
+	assert.Equal(t, 0.0, suppressed.Latitude)
+	assert.Equal(t, 0.0, suppressed.Longitude)
+	assert.Equal(t, []int{13}, sensorIDs(suppressed.Sensors))
+
+	snapped := decrypt(ds.Calls[1])
+	assert.False(t, snapped.LocationSuppressed)
+	assert.Equal(t, 500.0, snapped.LocationGrid)
+	assert.NotEqual(t, 41.3852, snapped.Latitude)
+	assert.InDelta(t, 41.3852, snapped.Latitude, 250.0/111320)
+	assert.InDelta(t, 2.1735, snapped.Longitude, 250.0/(111320*0.75))
+}
+
+func sensorIDs(sensors []*smartcitizen.Sensor) []int {
+	ids := []int{}
+	for _, sensor := range sensors {
+		ids = append(ids, sensor.ID)
+	}
+	return ids
+}
+
+func TestProcessTimestampPolicy(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	ds := mocks.Datastore{}
+
+	ds.On(
+		"WriteData",
+		mock.Anything,
+		mock.Anything,
+	).Return(
+		&datastore.WriteResponse{},
+		nil,
+	)
+
+	passthroughExec := func(script, keys, data []byte) ([]byte, error) {
+		return json.Marshal(string(data))
+	}
+
+	payload := []byte(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":13, "value":51.00}]}]}`)
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore: datastore.Datastore(&ds),
+		Stats:     stats.NewStore(nil, time.Minute, clock.New(), logger),
+		Scripts:   lua.NewScripts(&lua.Config{}, logger),
+		Zenroom:   pipeline.NewZenroomPool(1, time.Second, passthroughExec),
+	}, logger)
+
+	device := &postgres.Device{
+		DeviceToken: "foo",
+		Streams: []*postgres.Stream{
+			{
+				StreamID:        "server",
+				CommunityID:     "community-1",
+				PublicKey:       `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+				TimestampPolicy: "server",
+			},
+			{
+				StreamID:        "reject",
+				CommunityID:     "community-2",
+				PublicKey:       `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+				TimestampPolicy: "reject:10m",
+			},
+		},
+	}
+
+	receivedAt := time.Date(2018, 12, 11, 16, 0, 0, 0, time.UTC)
+
+	err := processor.Process(pipeline.WithReceivedAt(context.Background(), receivedAt), device, payload)
+	assert.Nil(t, err)
+
+	// the reading is over an hour out, so only the stream overriding the
+	// timestamp is written
+	assert.Len(t, ds.Calls, 1)
+
+	req := ds.Calls[0].Arguments[1].(*datastore.WriteRequest)
+	assert.Equal(t, "community-1", req.CommunityId)
+
+	var encoded string
+	err = json.Unmarshal(req.Data, &encoded)
+	assert.Nil(t, err)
+
+	var decryptedDevice smartcitizen.Device
+	err = json.Unmarshal([]byte(encoded), &decryptedDevice)
+	assert.Nil(t, err)
+	assert.True(t, receivedAt.Equal(decryptedDevice.RecordedAt))
+}
+
+func TestProcessPanicIsolated(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	ds := mocks.Datastore{}
+
+	ds.On(
+		"WriteData",
+		mock.Anything,
+		mock.Anything,
+	).Return(
+		&datastore.WriteResponse{},
+		nil,
+	)
+
+	// panic when encrypting for the first stream, as identified by its key
+	panickingExec := func(script, keys, data []byte) ([]byte, error) {
+		if strings.Contains(string(keys), "panicking-key") {
+			panic("zenroom exploded")
+		}
+		return json.Marshal(string(data))
+	}
+
+	st := stats.NewStore(nil, time.Minute, clock.New(), logger)
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore: datastore.Datastore(&ds),
+		Stats:     st,
+		Scripts:   lua.NewScripts(&lua.Config{}, logger),
+		Zenroom:   pipeline.NewZenroomPool(1, time.Second, panickingExec),
+	}, logger)
+
+	device := &postgres.Device{
+		DeviceToken: "foo",
+		Streams: []*postgres.Stream{
+			{
+				StreamID:    "stream-1",
+				CommunityID: "smartcitizen",
+				PublicKey:   "panicking-key",
+			},
+			{
+				StreamID:    "stream-2",
+				CommunityID: "smartcitizen",
+				PublicKey:   "valid-key",
+			},
+		},
+	}
+
+	err := processor.Process(context.Background(), device, []byte(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":13, "value":51.00}]}]}`))
+	assert.NotNil(t, err)
+	assert.Equal(t, "processing panicked for 1 of 2 streams", err.Error())
+
+	// the second stream was still written
+	assert.Len(t, ds.Calls, 1)
+	assert.Equal(t, uint64(1), st.Get("stream-2").WritesSucceeded)
+}
+
+func TestProcessWithNoOperations(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	ds := mocks.Datastore{}
+
+	// set up a mock response
+	ds.On(
+		"WriteData",
+		mock.Anything,
+		mock.Anything,
+	).Return(
+		&datastore.WriteResponse{},
+		nil,
+	)
+
+	mv := mocks.MovingAverager{}
+
+	st := stats.NewStore(nil, time.Minute, clock.New(), logger)
+
+	payload := []byte(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":13, "value":51.00},{"id":14, "value":426.42},{"id":12, "value":12.58},{"id":29, "value":79.35},{"id":53, "value":51.00},{"id":58, "value":101.56},{"id":89, "value":4.00},{"id":87, "value":7.00},{"id":88, "value":7.00}]}]}`)
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore:      datastore.Datastore(&ds),
+		MovingAverager: &mv,
+		Stats:          st,
+		Scripts:        lua.NewScripts(&lua.Config{}, logger),
+		Zenroom:        pipeline.NewZenroomPool(1, time.Second, pipeline.ZenroomExec),
+		Verbose:        true,
+	}, logger)
+
+	device := &postgres.Device{
+		DeviceToken: "foo",
+		Streams: []*postgres.Stream{
+			{
+				CommunityID: "smartcitizen",
+				PublicKey:   `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+				Operations:  postgres.Operations{},
+			},
+		},
+	}
+
+	err := processor.Process(context.Background(), device, payload)
+	assert.Nil(t, err)
+
+	ds.AssertExpectations(t)
+	mv.AssertExpectations(t)
+
+	assert.Len(t, ds.Calls, 1)
+
+	decryptedDevice, err := decryptData(t, ds.Calls[0], "D19GsDTGjLBX23J281SNpXWUdu+oL6hdAJ0Zh6IrRHA=")
+	assert.Nil(t, err)
+
+	assert.Len(t, decryptedDevice.Sensors, 9)
+}
+
+func TestProcessWithMetadata(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	ds := mocks.Datastore{}
+
+	ds.On(
+		"WriteData",
+		mock.Anything,
+		mock.Anything,
+	).Return(
+		&datastore.WriteResponse{},
+		nil,
+	)
+
+	st := stats.NewStore(nil, time.Minute, clock.New(), logger)
+
+	payload := []byte(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":13, "value":51.00}]}]}`)
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore:      datastore.Datastore(&ds),
+		MovingAverager: &mocks.MovingAverager{},
+		Stats:          st,
+		Scripts:        lua.NewScripts(&lua.Config{}, logger),
+		Zenroom:        pipeline.NewZenroomPool(1, time.Second, pipeline.ZenroomExec),
+	}, logger)
+
+	device := &postgres.Device{
+		DeviceToken: "foo",
+		Streams: []*postgres.Stream{
+			{
+				CommunityID: "smartcitizen",
+				PublicKey:   `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+				Operations:  postgres.Operations{},
+			},
+		},
+	}
+
+	ctx := pipeline.WithMetadata(context.Background(), map[string]string{"firmware": "1.2.3"})
+
+	err := processor.Process(ctx, device, payload)
+	assert.Nil(t, err)
+
+	assert.Len(t, ds.Calls, 1)
+
+	decryptedDevice, err := decryptData(t, ds.Calls[0], "D19GsDTGjLBX23J281SNpXWUdu+oL6hdAJ0Zh6IrRHA=")
+	assert.Nil(t, err)
+
+	assert.Equal(t, map[string]string{"firmware": "1.2.3"}, decryptedDevice.Metadata)
+}
+
+func TestProcessWithDownsampling(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	ds := mocks.Datastore{}
+
+	ds.On(
+		"WriteData",
+		mock.Anything,
+		mock.Anything,
+	).Return(
+		&datastore.WriteResponse{},
+		nil,
+	)
+
+	cl := clock.NewMock(time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC))
+
+	payload := []byte(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":13, "value":51.00},{"id":14, "value":426.42}]}]}`)
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore:      datastore.Datastore(&ds),
+		MovingAverager: &mocks.MovingAverager{},
+		Downsampler:    downsample.NewStore(nil, time.Minute, cl, logger),
+		Stats:          stats.NewStore(nil, time.Minute, clock.New(), logger),
+		Scripts:        lua.NewScripts(&lua.Config{}, logger),
+		Zenroom:        pipeline.NewZenroomPool(1, time.Second, pipeline.ZenroomExec),
+	}, logger)
+
+	device := &postgres.Device{
+		DeviceToken: "foo",
+		Streams: []*postgres.Stream{
+			{
+				StreamID:    "e8a1f33a-6a1c-4c43-a2ab-4a4a8c5a5b1f",
+				CommunityID: "smartcitizen",
+				PublicKey:   `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+				Operations: postgres.Operations{
+					&postgres.Operation{
+						SensorID: 13,
+						Action:   postgres.Downsample,
+						Interval: 300,
+					},
+				},
+			},
+		},
+	}
+
+	// the first reading is forwarded, the second within the interval dropped
+	err := processor.Process(context.Background(), device, payload)
+	assert.Nil(t, err)
+
+	err = processor.Process(context.Background(), device, payload)
+	assert.Nil(t, err)
+
+	assert.Len(t, ds.Calls, 1)
+
+	cl.Add(5 * time.Minute)
+
+	err = processor.Process(context.Background(), device, payload)
+	assert.Nil(t, err)
+
+	assert.Len(t, ds.Calls, 2)
+
+	decryptedDevice, err := decryptData(t, ds.Calls[1], "D19GsDTGjLBX23J281SNpXWUdu+oL6hdAJ0Zh6IrRHA=")
+	assert.Nil(t, err)
+
+	assert.Len(t, decryptedDevice.Sensors, 1)
+	assert.Equal(t, 13, decryptedDevice.Sensors[0].ID)
+}
+
+func TestProcessWithConversions(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	ds := mocks.Datastore{}
+
+	ds.On(
+		"WriteData",
+		mock.Anything,
+		mock.Anything,
+	).Return(
+		&datastore.WriteResponse{},
+		nil,
+	)
+
+	payload := []byte(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":13, "value":51.00},{"id":14, "value":426.42}]}]}`)
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore:      datastore.Datastore(&ds),
+		MovingAverager: &mocks.MovingAverager{},
+		Stats:          stats.NewStore(nil, time.Minute, clock.New(), logger),
+		Scripts:        lua.NewScripts(&lua.Config{}, logger),
+		Zenroom:        pipeline.NewZenroomPool(1, time.Second, pipeline.ZenroomExec),
+	}, logger)
+
+	publicKey := `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`
+
+	device := &postgres.Device{
+		DeviceToken: "foo",
+		Streams: []*postgres.Stream{
+			{
+				StreamID:    "e8a1f33a-6a1c-4c43-a2ab-4a4a8c5a5b1f",
+				CommunityID: "smartcitizen",
+				PublicKey:   publicKey,
+				Conversions: postgres.Conversions{
+					13: "x * 2 - 2",
+					14: "1 / (x - 426.42)",
+				},
+			},
+			{
+				StreamID:    "0c4e8d9a-33d1-4b55-9d7e-2f3a8b6c1d20",
+				CommunityID: "smartcitizen",
+				PublicKey:   publicKey,
+			},
+		},
+	}
+
+	err := processor.Process(context.Background(), device, payload)
+	assert.Nil(t, err)
+
+	assert.Len(t, ds.Calls, 2)
+
+	// the conversion for sensor 14 divides by zero so the reading is dropped
+	converted, err := decryptData(t, ds.Calls[0], "D19GsDTGjLBX23J281SNpXWUdu+oL6hdAJ0Zh6IrRHA=")
+	assert.Nil(t, err)
+
+	assert.Len(t, converted.Sensors, 1)
+	assert.Equal(t, 13, converted.Sensors[0].ID)
+	assert.Equal(t, 100.0, converted.Sensors[0].Value.Float64)
+
+	// the second stream has no conversions so sees the raw readings
+	raw, err := decryptData(t, ds.Calls[1], "D19GsDTGjLBX23J281SNpXWUdu+oL6hdAJ0Zh6IrRHA=")
+	assert.Nil(t, err)
+
+	assert.Len(t, raw.Sensors, 2)
+	assert.Equal(t, 51.0, raw.Sensors[0].Value.Float64)
+}
+
+func TestProcessWithDatastoreError(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	ds := mocks.Datastore{}
+
+	ds.On(
+		"WriteData",
+		mock.Anything,
+		mock.Anything,
+	).Return(
+		&datastore.WriteResponse{},
+		errors.New("error"),
+	)
+
+	mv := mocks.MovingAverager{}
+
+	st := stats.NewStore(nil, time.Minute, clock.New(), logger)
+
+	payload := []byte(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":13, "value":51.00},{"id":14, "value":426.42},{"id":12, "value":12.58},{"id":29, "value":79.35},{"id":53, "value":51.00},{"id":58, "value":101.56},{"id":89, "value":4.00},{"id":87, "value":7.00},{"id":88, "value":7.00}]}]}`)
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore:      &ds,
+		MovingAverager: &mv,
+		Stats:          st,
+		Scripts:        lua.NewScripts(&lua.Config{}, logger),
+		Zenroom:        pipeline.NewZenroomPool(1, time.Second, pipeline.ZenroomExec),
+		Verbose:        true,
+	}, logger)
+	device := &postgres.Device{
+		DeviceToken: "foo",
+		Streams: []*postgres.Stream{
+			{
+				StreamID:    "e8a1f33a-6a1c-4c43-a2ab-4a4a8c5a5b1f",
+				CommunityID: "smartcitizen",
+				PublicKey:   `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+			},
+		},
+	}
+
+	err := processor.Process(context.Background(), device, payload)
+	assert.NotNil(t, err)
+	assert.Equal(t, "error", err.Error())
+
+	ds.AssertExpectations(t)
+
+	streamStats := st.Get("e8a1f33a-6a1c-4c43-a2ab-4a4a8c5a5b1f")
+	assert.NotNil(t, streamStats)
+	assert.Equal(t, uint64(1), streamStats.MessagesReceived)
+	assert.Equal(t, uint64(0), streamStats.WritesSucceeded)
+	assert.Equal(t, uint64(1), streamStats.WritesFailed)
+}
+
+func TestProcessWithDatastoreTimeout(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	ds := mocks.Datastore{}
+
+	// a datastore which only returns once the write's deadline expires
+	ds.On(
+		"WriteData",
+		mock.Anything,
+		mock.Anything,
+	).Run(func(args mock.Arguments) {
+		<-args.Get(0).(context.Context).Done()
+	}).Return(
+		&datastore.WriteResponse{},
+		context.DeadlineExceeded,
+	)
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore:        &ds,
+		MovingAverager:   &mocks.MovingAverager{},
+		Stats:            stats.NewStore(nil, time.Minute, clock.New(), logger),
+		Scripts:          lua.NewScripts(&lua.Config{}, logger),
+		Zenroom:          pipeline.NewZenroomPool(1, time.Second, pipeline.ZenroomExec),
+		DatastoreTimeout: time.Hour,
+	}, logger)
+
+	device := &postgres.Device{
+		DeviceToken: "foo",
+		Streams: []*postgres.Stream{
+			{
+				CommunityID:      "smartcitizen",
+				PublicKey:        `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+				DatastoreTimeout: "20ms",
+			},
+		},
+	}
+
+	payload := []byte(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":13, "value":51.00}]}]}`)
+
+	start := time.Now()
+
+	// the stream's own timeout applies in place of the default
+	err := processor.Process(context.Background(), device, payload)
+	assert.Equal(t, pipeline.ErrDatastoreTimeout, err)
+	assert.True(t, time.Since(start) < time.Minute)
+
+	// if the message's deadline expires first its error is returned instead
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+
+	device.Streams[0].DatastoreTimeout = "1h"
+
+	err = processor.Process(ctx, device, payload)
+	assert.NotNil(t, err)
+	assert.NotEqual(t, pipeline.ErrDatastoreTimeout, err)
+}
+
+func TestParseDatastoreTimeout(t *testing.T) {
+	timeout, err := pipeline.ParseDatastoreTimeout("2s")
+	assert.Nil(t, err)
+	assert.Equal(t, 2*time.Second, timeout)
+
+	_, err = pipeline.ParseDatastoreTimeout("0s")
+	assert.NotNil(t, err)
+
+	_, err = pipeline.ParseDatastoreTimeout("soon")
+	assert.NotNil(t, err)
+}
+
+func TestProcessDeadlineExceeded(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	ds := mocks.Datastore{}
+
+	// a slow zenroom execution which outlives the message deadline
+	slowExec := func(script, keys, data []byte) ([]byte, error) {
+		time.Sleep(200 * time.Millisecond)
+		return []byte(`{}`), nil
+	}
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore:      datastore.Datastore(&ds),
+		MovingAverager: &mocks.MovingAverager{},
+		Stats:          stats.NewStore(nil, time.Minute, clock.New(), logger),
+		Scripts:        lua.NewScripts(&lua.Config{}, logger),
+		Zenroom:        pipeline.NewZenroomPool(1, time.Second, slowExec),
+	}, logger)
+
+	device := &postgres.Device{
+		DeviceToken: "foo",
+		Streams: []*postgres.Stream{
+			{
+				CommunityID: "smartcitizen",
+				PublicKey:   "pubkey",
+			},
+		},
+	}
+
+	payload := []byte(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":13, "value":51.00}]}]}`)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	err := processor.Process(ctx, device, payload)
+	assert.Equal(t, pipeline.ErrZenroomTimeout, err)
+
+	// an already cancelled context abandons processing before encryption
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+
+	err = processor.Process(ctx, device, payload)
+	assert.NotNil(t, err)
+	assert.Equal(t, context.Canceled, errors.Cause(err))
+
+	ds.AssertNotCalled(t, "WriteData", mock.Anything, mock.Anything)
+}
+
+func TestProcessingType(t *testing.T) {
+	testcases := []struct {
+		label      string
+		operations postgres.Operations
+		expected   string
+	}{
+		{
+			label:      "no operations",
+			operations: postgres.Operations{},
+			expected:   pipeline.Passthrough,
+		},
+		{
+			label: "share only",
+			operations: postgres.Operations{
+				&postgres.Operation{SensorID: 13, Action: postgres.Share},
+			},
+			expected: pipeline.Passthrough,
+		},
+		{
+			label: "share and bin",
+			operations: postgres.Operations{
+				&postgres.Operation{SensorID: 13, Action: postgres.Share},
+				&postgres.Operation{SensorID: 14, Action: postgres.Bin},
+			},
+			expected: pipeline.Binned,
+		},
+		{
+			label: "share and downsample",
+			operations: postgres.Operations{
+				&postgres.Operation{SensorID: 13, Action: postgres.Share},
+				&postgres.Operation{SensorID: 14, Action: postgres.Downsample, Interval: 300},
+			},
+			expected: pipeline.Downsampled,
+		},
+		{
+			label: "downsample and delta",
+			operations: postgres.Operations{
+				&postgres.Operation{SensorID: 13, Action: postgres.Delta, Threshold: 0.5},
+				&postgres.Operation{SensorID: 14, Action: postgres.Downsample, Interval: 300},
+			},
+			expected: pipeline.ChangeOnly,
+		},
+		{
+			label: "downsample and bin",
+			operations: postgres.Operations{
+				&postgres.Operation{SensorID: 14, Action: postgres.Downsample, Interval: 300},
+				&postgres.Operation{SensorID: 13, Action: postgres.Bin},
+			},
+			expected: pipeline.Binned,
+		},
+		{
+			label: "bin and moving average",
+			operations: postgres.Operations{
+				&postgres.Operation{SensorID: 14, Action: postgres.Bin},
+				&postgres.Operation{SensorID: 12, Action: postgres.MovingAverage},
+			},
+			expected: pipeline.Average,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			stream := &postgres.Stream{Operations: tc.operations}
+			assert.Equal(t, tc.expected, pipeline.ProcessingType(stream))
+		})
+	}
+
+	noised := &postgres.Stream{
+		Operations: postgres.Operations{
+			&postgres.Operation{SensorID: 12, Action: postgres.MovingAverage},
+		},
+		Privacy: "laplace:epsilon=0.5",
+	}
+	assert.Equal(t, pipeline.Noised, pipeline.ProcessingType(noised))
+
+	sampled := &postgres.Stream{
+		Operations: postgres.Operations{
+			&postgres.Operation{SensorID: 12, Action: postgres.Bin, Bins: []float64{10}},
+		},
+		Sampling: "10%",
+	}
+	assert.Equal(t, pipeline.Binned, pipeline.ProcessingType(sampled))
+}
+
+func TestBinValue(t *testing.T) {
+	bins := []float64{40, 80}
+
+	testcases := []struct {
+		label    string
+		value    float64
+		expected []int
+	}{
+		{
+			label:    "below first bound",
+			value:    39.9,
+			expected: []int{1, 0, 0},
+		},
+		{
+			label:    "on first bound",
+			value:    40,
+			expected: []int{0, 1, 0},
+		},
+		{
+			label:    "on last bound",
+			value:    80,
+			expected: []int{0, 0, 1},
+		},
+		{
+			label:    "above last bound",
+			value:    120,
+			expected: []int{0, 0, 1},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			assert.Equal(t, tc.expected, pipeline.BinValue(tc.value, bins))
+		})
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore:      &mocks.Datastore{},
+		MovingAverager: &mocks.MovingAverager{},
+		Stats:          stats.NewStore(nil, time.Minute, clock.New(), logger),
+		Scripts:        lua.NewScripts(&lua.Config{}, logger),
+		Zenroom:        pipeline.NewZenroomPool(1, time.Second, pipeline.ZenroomExec),
+	}, logger)
+
+	err := processor.DryRun(context.Background(), &postgres.Stream{
+		CommunityID: "smartcitizen",
+		PublicKey:   `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+		Device: &postgres.Device{
+			DeviceToken: "foo",
+		},
+	})
+	assert.Nil(t, err)
+
+	err = processor.DryRun(context.Background(), &postgres.Stream{
+		CommunityID: "smartcitizen",
+		PublicKey:   "invalid",
+		Device: &postgres.Device{
+			DeviceToken: "foo",
+		},
+	})
+	assert.NotNil(t, err)
 }
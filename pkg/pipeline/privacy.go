@@ -0,0 +1,111 @@
+package pipeline
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/guregu/null.v3"
+
+	"github.com/DECODEproject/iotencoder/pkg/logger/level"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/privacy"
+	"github.com/DECODEproject/iotencoder/pkg/smartcitizen"
+)
+
+var (
+	// NoisedValuesCounter is a prometheus counter recording a count of
+	// aggregated values into which differential privacy noise was injected.
+	NoisedValuesCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "noised_values",
+			Help:      "Count of aggregated values released with differential privacy noise",
+		},
+	)
+
+	// PrivacyBudgetExhaustedCounter is a prometheus counter recording a count
+	// of aggregated values withheld as releasing them would have exceeded
+	// their stream's privacy budget.
+	PrivacyBudgetExhaustedCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "privacy_budget_exhausted",
+			Help:      "Count of aggregated values withheld as their stream's privacy budget was exhausted",
+		},
+	)
+)
+
+// PrivacyLedger is the interface we call to spend the privacy budget of a
+// stream. It is satisfied by our postgres.DB and boltdb.DB types.
+type PrivacyLedger interface {
+	SpendPrivacyBudget(streamID string, periodStart time.Time, cost, budget float64) (bool, error)
+}
+
+// applyPrivacy injects noise into the moving averages of the given processed
+// sensors if the stream has a differential privacy spec, having first spent
+// the cost of releasing them from the stream's privacy budget. If the budget
+// does not cover the cost the moving averages are withheld, and the remaining
+// sensors returned, along with the number withheld. Any error spending the
+// budget is returned, so that nothing is released unaccounted for.
+func (p *Processor) applyPrivacy(stream *postgres.Stream, sensors []*smartcitizen.Sensor, recordedAt time.Time) ([]*smartcitizen.Sensor, int, error) {
+	if stream.Privacy == "" {
+		return sensors, 0, nil
+	}
+
+	spec, err := privacy.Parse(stream.Privacy)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "invalid stream privacy spec")
+	}
+
+	noised := 0
+	for _, sensor := range sensors {
+		if sensor.Action == postgres.MovingAverage {
+			noised++
+		}
+	}
+
+	if noised == 0 {
+		return sensors, 0, nil
+	}
+
+	if spec.Budget > 0 && p.ledger != nil {
+		spent, err := p.ledger.SpendPrivacyBudget(stream.StreamID, spec.PeriodStart(recordedAt), spec.Cost(noised), spec.Budget)
+		if err != nil {
+			return nil, 0, errors.Wrap(err, "failed to spend privacy budget")
+		}
+
+		if !spent {
+			PrivacyBudgetExhaustedCounter.Add(float64(noised))
+			level.Warn(p.logger).Log(
+				"stream_uid", stream.StreamID,
+				"msg", "privacy budget exhausted, withholding moving averages",
+				"period_start", spec.PeriodStart(recordedAt),
+			)
+
+			kept := make([]*smartcitizen.Sensor, 0, len(sensors)-noised)
+			for _, sensor := range sensors {
+				if sensor.Action != postgres.MovingAverage {
+					kept = append(kept, sensor)
+				}
+			}
+
+			return kept, noised, nil
+		}
+	}
+
+	for _, sensor := range sensors {
+		if sensor.Action != postgres.MovingAverage || sensor.Value == nil || !sensor.Value.Valid {
+			continue
+		}
+
+		value := null.FloatFrom(sensor.Value.Float64 + spec.Noise(p.noise))
+		sensor.Value = &value
+	}
+
+	NoisedValuesCounter.Add(float64(noised))
+
+	return sensors, 0, nil
+}
@@ -0,0 +1,90 @@
+package pipeline
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/guregu/null.v3"
+
+	"github.com/DECODEproject/iotencoder/pkg/smartcitizen"
+)
+
+var (
+	// RejectedReadingsCounter is a prometheus counter recording a count of sensor
+	// readings outside their plausible range, labelled by sensor id and by
+	// whether the reading was dropped or clamped.
+	RejectedReadingsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "rejected_readings",
+			Help:      "Count of sensor readings outside their plausible range",
+		},
+		[]string{"sensor", "action"},
+	)
+)
+
+// Range is the plausible range of readings for a sensor. Readings outside the
+// range are dropped, or if Clamp is true replaced by the nearest bound.
+type Range struct {
+	Min   float64
+	Max   float64
+	Clamp bool
+}
+
+// RangeFilter rejects implausible readings, such as the -999 or 65535 emitted
+// by some devices on a sensor fault, before they are aggregated or encrypted.
+// Only sensors with a configured range are filtered.
+type RangeFilter struct {
+	ranges map[int]Range
+}
+
+// NewRangeFilter returns a new filter applying the given ranges, keyed by
+// sensor id.
+func NewRangeFilter(ranges map[int]Range) *RangeFilter {
+	return &RangeFilter{
+		ranges: ranges,
+	}
+}
+
+// Apply drops or clamps any readings of the device which lie outside the range
+// configured for their sensor. The device is modified in place.
+func (f *RangeFilter) Apply(device *smartcitizen.Device) {
+	sensors := device.Sensors[:0]
+
+	for _, sensor := range device.Sensors {
+		r, ok := f.ranges[sensor.ID]
+		if !ok || sensor.Value == nil || !sensor.Value.Valid {
+			sensors = append(sensors, sensor)
+			continue
+		}
+
+		value := sensor.Value.Float64
+		if value >= r.Min && value <= r.Max {
+			sensors = append(sensors, sensor)
+			continue
+		}
+
+		label := strconv.Itoa(sensor.ID)
+
+		if !r.Clamp {
+			RejectedReadingsCounter.WithLabelValues(label, "dropped").Inc()
+			continue
+		}
+
+		RejectedReadingsCounter.WithLabelValues(label, "clamped").Inc()
+
+		if value < r.Min {
+			value = r.Min
+		} else {
+			value = r.Max
+		}
+
+		clamped := null.FloatFrom(value)
+		sensor.Value = &clamped
+
+		sensors = append(sensors, sensor)
+	}
+
+	device.Sensors = sensors
+}
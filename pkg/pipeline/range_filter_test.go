@@ -0,0 +1,48 @@
+package pipeline_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/guregu/null.v3"
+
+	"github.com/DECODEproject/iotencoder/pkg/pipeline"
+	"github.com/DECODEproject/iotencoder/pkg/smartcitizen"
+)
+
+func newSensor(id int, value float64) *smartcitizen.Sensor {
+	v := null.FloatFrom(value)
+	return &smartcitizen.Sensor{ID: id, Value: &v}
+}
+
+func TestRangeFilter(t *testing.T) {
+	filter := pipeline.NewRangeFilter(map[int]pipeline.Range{
+		12: {Min: -40, Max: 85},
+		14: {Min: 0, Max: 100, Clamp: true},
+	})
+
+	device := &smartcitizen.Device{
+		Sensors: []*smartcitizen.Sensor{
+			newSensor(12, 21.5),
+			newSensor(12, -999),
+			newSensor(14, 65535),
+			newSensor(14, -3),
+			newSensor(29, 65535),
+			{ID: 12},
+		},
+	}
+
+	filter.Apply(device)
+
+	assert.Len(t, device.Sensors, 5)
+
+	// in range readings, unfiltered sensors and missing values are untouched
+	assert.Equal(t, 21.5, device.Sensors[0].Value.Float64)
+	assert.Equal(t, 65535.0, device.Sensors[3].Value.Float64)
+	assert.Nil(t, device.Sensors[4].Value)
+
+	// out of range readings are clamped to the nearest bound
+	assert.Equal(t, 14, device.Sensors[1].ID)
+	assert.Equal(t, 100.0, device.Sensors[1].Value.Float64)
+	assert.Equal(t, 0.0, device.Sensors[2].Value.Float64)
+}
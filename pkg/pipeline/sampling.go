@@ -0,0 +1,33 @@
+package pipeline
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/sampling"
+)
+
+// Sampler is the interface we call to decide whether a message of a sampled
+// stream should be written. It is satisfied by the sampling.Sampler type.
+type Sampler interface {
+	Sample(streamID string, spec *sampling.Spec) bool
+}
+
+// applySampling returns the message if it is selected by the stream's sampling
+// spec, recording the percentage sampled so that it is written in the entry's
+// metadata, or nil if it should be discarded.
+func (p *Processor) applySampling(msg *Message, stream *postgres.Stream) (*Message, error) {
+	spec, err := sampling.Parse(stream.Sampling)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse stream sampling")
+	}
+
+	if !p.sampler.Sample(stream.StreamID, spec) {
+		SampledOutCounter.Inc()
+		return nil, nil
+	}
+
+	msg.SamplePercent = spec.Percent
+
+	return msg, nil
+}
@@ -0,0 +1,84 @@
+package pipeline_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	datastore "github.com/thingful/twirp-datastore-go"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/lua"
+	"github.com/DECODEproject/iotencoder/pkg/mocks"
+	"github.com/DECODEproject/iotencoder/pkg/pipeline"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/sampling"
+	"github.com/DECODEproject/iotencoder/pkg/stats"
+)
+
+func TestProcessWithSampling(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	ds := mocks.Datastore{}
+
+	ds.On(
+		"WriteData",
+		mock.Anything,
+		mock.Anything,
+	).Return(
+		&datastore.WriteResponse{},
+		nil,
+	)
+
+	passthroughExec := func(script, keys, data []byte) ([]byte, error) {
+		return json.Marshal(string(data))
+	}
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore: datastore.Datastore(&ds),
+		Stats:     stats.NewStore(nil, time.Minute, clock.New(), logger),
+		Scripts:   lua.NewScripts(&lua.Config{}, logger),
+		Zenroom:   pipeline.NewZenroomPool(1, time.Second, passthroughExec),
+		Sampler:   sampling.NewSampler(),
+	}, logger)
+
+	payload := []byte(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":13,"value":51.00}]}]}`)
+
+	device := &postgres.Device{
+		DeviceToken: "foo",
+		Streams: []*postgres.Stream{
+			{
+				StreamID:    "sampled",
+				CommunityID: "smartcitizen",
+				PublicKey:   `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+				Sampling:    "50%",
+			},
+		},
+	}
+
+	// only some of the messages of the stream are written
+	for i := 0; i < 20; i++ {
+		err := processor.Process(context.Background(), device, payload)
+		assert.Nil(t, err)
+	}
+
+	assert.True(t, len(ds.Calls) > 0)
+	assert.True(t, len(ds.Calls) < 20)
+
+	// the percentage sampled is recorded in the metadata of every entry written
+	var envelope pipeline.Envelope
+	err := json.Unmarshal(ds.Calls[0].Arguments[1].(*datastore.WriteRequest).Data, &envelope)
+	assert.Nil(t, err)
+
+	var metadata pipeline.Metadata
+	err = proto.Unmarshal(envelope.Metadata, &metadata)
+	assert.Nil(t, err)
+
+	// sampling doesn't change how the messages written are processed
+	assert.Equal(t, pipeline.Passthrough, metadata.ProcessingType)
+	assert.Equal(t, float64(50), metadata.SamplePercent)
+}
@@ -0,0 +1,39 @@
+package pipeline
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/DECODEproject/iotencoder/pkg/logger/level"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/schedule"
+)
+
+// applySchedule returns the message if it was received within one of the
+// windows of the stream's schedule. Otherwise, if the stream buffers messages
+// outside its windows and we have an outbox in which to hold them, it returns
+// the message marked to be delivered when the next window opens, else nil as
+// the message is dropped.
+func (p *Processor) applySchedule(msg *Message, stream *postgres.Stream) (*Message, error) {
+	spec, err := schedule.Parse(stream.Schedule)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse stream schedule")
+	}
+
+	if spec.Open(msg.ReceivedAt) {
+		return msg, nil
+	}
+
+	if spec.Outside == schedule.Buffer {
+		if p.outbox != nil {
+			msg.DeliverAfter = spec.NextOpen(msg.ReceivedAt)
+			OutsideScheduleCounter.WithLabelValues(schedule.Buffer).Inc()
+			return msg, nil
+		}
+
+		level.Warn(msg.Logger).Log("schedule", stream.Schedule, "msg", "dropping message outside schedule as buffering requires an outbox")
+	}
+
+	OutsideScheduleCounter.WithLabelValues(schedule.Drop).Inc()
+
+	return nil, nil
+}
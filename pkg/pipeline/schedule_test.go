@@ -0,0 +1,104 @@
+package pipeline_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	datastore "github.com/thingful/twirp-datastore-go"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/lua"
+	"github.com/DECODEproject/iotencoder/pkg/mocks"
+	"github.com/DECODEproject/iotencoder/pkg/pipeline"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/stats"
+)
+
+// window returns a schedule window in UTC from the given offsets from now.
+func window(from, to time.Duration) string {
+	now := time.Now().UTC()
+	return fmt.Sprintf("%s-%s", now.Add(from).Format("15:04"), now.Add(to).Format("15:04"))
+}
+
+func TestProcessWithSchedule(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	ds := mocks.Datastore{}
+	ob := &fakeOutbox{}
+
+	ds.On(
+		"WriteData",
+		mock.Anything,
+		mock.Anything,
+	).Return(
+		&datastore.WriteResponse{},
+		nil,
+	)
+
+	passthroughExec := func(script, keys, data []byte) ([]byte, error) {
+		return json.Marshal(string(data))
+	}
+
+	newProcessor := func(outbox pipeline.Outbox) *pipeline.Processor {
+		return pipeline.NewProcessor(&pipeline.Config{
+			Datastore: datastore.Datastore(&ds),
+			Stats:     stats.NewStore(nil, time.Minute, clock.New(), logger),
+			Scripts:   lua.NewScripts(&lua.Config{}, logger),
+			Zenroom:   pipeline.NewZenroomPool(1, time.Second, passthroughExec),
+			Outbox:    outbox,
+		}, logger)
+	}
+
+	payload := []byte(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":13,"value":51.00}]}]}`)
+
+	newDevice := func(schedule string) *postgres.Device {
+		return &postgres.Device{
+			DeviceToken: "foo",
+			Streams: []*postgres.Stream{
+				{
+					StreamID:    "scheduled",
+					CommunityID: "smartcitizen",
+					PublicKey:   `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+					Schedule:    schedule,
+				},
+			},
+		}
+	}
+
+	// messages within a window are written, and outside of one dropped
+	processor := newProcessor(nil)
+
+	err := processor.Process(context.Background(), newDevice(window(-time.Hour, time.Hour)), payload)
+	assert.Nil(t, err)
+	assert.Len(t, ds.Calls, 1)
+
+	err = processor.Process(context.Background(), newDevice(window(time.Hour, 2*time.Hour)), payload)
+	assert.Nil(t, err)
+	assert.Len(t, ds.Calls, 1)
+
+	// without an outbox buffered messages are dropped too
+	err = processor.Process(context.Background(), newDevice(window(time.Hour, 2*time.Hour)+",outside=buffer"), payload)
+	assert.Nil(t, err)
+	assert.Len(t, ds.Calls, 1)
+
+	// with one they are held until the next window opens
+	processor = newProcessor(ob)
+
+	err = processor.Process(context.Background(), newDevice(window(time.Hour, 2*time.Hour)+",outside=buffer"), payload)
+	assert.Nil(t, err)
+	assert.Len(t, ob.batches, 1)
+
+	deliverAfter := ob.batches[0].Entries[0].DeliverAfter
+	assert.True(t, deliverAfter.Valid)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), deliverAfter.Time, time.Minute)
+
+	err = processor.Process(context.Background(), newDevice(window(-time.Hour, time.Hour)+",outside=buffer"), payload)
+	assert.Nil(t, err)
+	assert.Len(t, ob.batches, 2)
+	assert.False(t, ob.batches[1].Entries[0].DeliverAfter.Valid)
+}
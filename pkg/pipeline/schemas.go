@@ -0,0 +1,32 @@
+package pipeline
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/schema"
+	"github.com/DECODEproject/iotencoder/pkg/smartcitizen"
+)
+
+// SchemaResolver is the interface we call to resolve the payload schema
+// referenced by a stream. It is satisfied by the schema.Registry type.
+type SchemaResolver interface {
+	Resolve(ref string) (*postgres.Schema, error)
+}
+
+// applySchema returns a copy of the device holding only those readings which
+// conform to the payload schema referenced by the stream. If the stream
+// references no schema, or we have no resolver, the device is returned
+// unchanged.
+func (p *Processor) applySchema(device *smartcitizen.Device, stream *postgres.Stream) (*smartcitizen.Device, error) {
+	if stream.Schema == "" || p.schemas == nil {
+		return device, nil
+	}
+
+	s, err := p.schemas.Resolve(stream.Schema)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to resolve stream schema")
+	}
+
+	return schema.Apply(s, device), nil
+}
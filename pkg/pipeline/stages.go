@@ -0,0 +1,346 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+
+	"github.com/DECODEproject/iotencoder/pkg/logger/level"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/smartcitizen"
+	"github.com/DECODEproject/iotencoder/pkg/timestamp"
+)
+
+// newBuilder returns a Builder holding the stages of our own processing types,
+// to which any others are added by Use.
+func (p *Processor) newBuilder() *Builder {
+	return NewBuilder().
+		Use(Decode, p.decodeStage).
+		Use(Validate, p.timestampStage).
+		Use(Validate, p.schemaStage).
+		Use(Filter, p.scheduleStage).
+		Use(Filter, p.geofenceStage).
+		Use(Filter, p.samplingStage).
+		Use(Filter, p.dispositionStage).
+		Use(Transform, p.conversionStage).
+		Use(Transform, p.geoPrivacyStage).
+		Use(Aggregate, p.operationStage).
+		Use(Aggregate, p.privacyStage).
+		Use(Encrypt, p.encryptStage).
+		Use(Sink, p.archiveStage).
+		Use(Sink, p.sinkStage)
+}
+
+// readingStage returns a stage applying the given function to the message,
+// logging any error. It is used by the stages which process the readings of a
+// payload, before they are encrypted.
+func readingStage(fn func(ctx context.Context, msg *Message) (*Message, error)) Stage {
+	return StageFunc(func(ctx context.Context, msg *Message) (*Message, error) {
+		log := msg.Logger
+
+		msg, err := fn(ctx, msg)
+		if err != nil {
+			level.Error(log).Log("err", err, "msg", "failed to process device data")
+		}
+
+		return msg, err
+	})
+}
+
+// decodeStage returns a stage parsing the message's payload into its readings,
+// which are shared by the chains of the device's other streams, and recording
+// the lag between the payload's timestamps and its processing for the stream.
+func (p *Processor) decodeStage(stream *postgres.Stream) Stage {
+	return readingStage(func(ctx context.Context, msg *Message) (*Message, error) {
+		reading, err := p.decode(ctx, msg.Device, msg.Payload)
+		if err != nil {
+			return nil, err
+		}
+
+		recordIngestionLag(stream, payloadTimestamps(ctx, reading, p.brokerProperty), msg.ReceivedAt)
+
+		msg.Reading = reading
+
+		return msg, nil
+	})
+}
+
+// conversionStage returns a stage applying the stream's conversions.
+func (p *Processor) conversionStage(stream *postgres.Stream) Stage {
+	if len(stream.Conversions) == 0 {
+		return nil
+	}
+
+	return readingStage(func(ctx context.Context, msg *Message) (*Message, error) {
+		reading, err := convertDevice(msg.Reading, stream.Conversions)
+		if err != nil {
+			return nil, err
+		}
+
+		msg.Reading = reading
+
+		// readings written in plain were split from the others as they were
+		// filtered, and are converted alike
+		if msg.Plaintext != nil {
+			plain, err := convertDevice(&smartcitizen.Device{Sensors: msg.Plaintext.Sensors}, stream.Conversions)
+			if err != nil {
+				return nil, err
+			}
+
+			msg.Plaintext = &Plaintext{
+				RecordedAt: msg.Plaintext.RecordedAt,
+				Sensors:    plain.Sensors,
+			}
+		}
+
+		return msg, nil
+	})
+}
+
+// timestampStage returns a stage applying the stream's timestamp policy,
+// stopping processing if the policy rejects the payload.
+func (p *Processor) timestampStage(stream *postgres.Stream) Stage {
+	if stream.TimestampPolicy == "" || stream.TimestampPolicy == timestamp.Device {
+		return nil
+	}
+
+	return readingStage(func(ctx context.Context, msg *Message) (*Message, error) {
+		reading, err := applyTimestampPolicy(ctx, msg.Reading, stream)
+		if err != nil {
+			return nil, err
+		}
+
+		// the device's clock is too far out for the stream to accept the
+		// payload
+		if reading == nil {
+			level.Warn(msg.Logger).Log("timestamp_policy", stream.TimestampPolicy, "msg", "rejected payload with skewed timestamp")
+			return nil, nil
+		}
+
+		msg.Reading = reading
+
+		return msg, nil
+	})
+}
+
+// schemaStage returns a stage dropping readings which don't conform to the
+// payload schema referenced by the stream.
+func (p *Processor) schemaStage(stream *postgres.Stream) Stage {
+	if stream.Schema == "" || p.schemas == nil {
+		return nil
+	}
+
+	return readingStage(func(ctx context.Context, msg *Message) (*Message, error) {
+		reading, err := p.applySchema(msg.Reading, stream)
+		if err != nil {
+			return nil, err
+		}
+
+		msg.Reading = reading
+
+		return msg, nil
+	})
+}
+
+// scheduleStage returns a stage dropping or buffering messages of the stream
+// received outside its schedule.
+func (p *Processor) scheduleStage(stream *postgres.Stream) Stage {
+	if stream.Schedule == "" {
+		return nil
+	}
+
+	return readingStage(func(ctx context.Context, msg *Message) (*Message, error) {
+		return p.applySchedule(msg, stream)
+	})
+}
+
+// geofenceStage returns a stage dropping messages of the stream reported from
+// outside its fence.
+func (p *Processor) geofenceStage(stream *postgres.Stream) Stage {
+	if stream.Geofence == "" {
+		return nil
+	}
+
+	return readingStage(func(ctx context.Context, msg *Message) (*Message, error) {
+		return p.applyGeofence(msg, stream)
+	})
+}
+
+// samplingStage returns a stage discarding the messages of a sampled stream
+// which are not selected by its sampling spec.
+func (p *Processor) samplingStage(stream *postgres.Stream) Stage {
+	if stream.Sampling == "" || p.sampler == nil {
+		return nil
+	}
+
+	return readingStage(func(ctx context.Context, msg *Message) (*Message, error) {
+		return p.applySampling(msg, stream)
+	})
+}
+
+// dispositionStage returns a stage applying the dispositions of the stream's
+// sensors.
+func (p *Processor) dispositionStage(stream *postgres.Stream) Stage {
+	if len(stream.Dispositions) == 0 {
+		return nil
+	}
+
+	return StageFunc(func(ctx context.Context, msg *Message) (*Message, error) {
+		msg.Reading, msg.Plaintext = p.applyDispositions(msg.Reading, stream)
+		return msg, nil
+	})
+}
+
+// geoPrivacyStage returns a stage protecting the location of the device
+// according to the stream's geo-privacy spec.
+func (p *Processor) geoPrivacyStage(stream *postgres.Stream) Stage {
+	if stream.GeoPrivacy == "" {
+		return nil
+	}
+
+	return readingStage(func(ctx context.Context, msg *Message) (*Message, error) {
+		reading, err := p.applyGeoPrivacy(msg.Reading, stream)
+		if err != nil {
+			return nil, err
+		}
+
+		msg.Reading = reading
+
+		return msg, nil
+	})
+}
+
+// operationStage returns a stage applying the stream's operations. If the
+// stream has no operations every reading is written as is.
+func (p *Processor) operationStage(stream *postgres.Stream) Stage {
+	if len(stream.Operations) == 0 {
+		return nil
+	}
+
+	return readingStage(func(ctx context.Context, msg *Message) (*Message, error) {
+		sensors, dropped, err := p.applyOperations(ctx, msg.Reading, stream)
+		if err != nil {
+			return nil, err
+		}
+
+		reading := *msg.Reading
+		reading.Sensors = sensors
+
+		msg.Reading = &reading
+		msg.Dropped += dropped
+
+		return msg, nil
+	})
+}
+
+// privacyStage returns a stage injecting differential privacy noise into the
+// stream's moving averages.
+func (p *Processor) privacyStage(stream *postgres.Stream) Stage {
+	if stream.Privacy == "" || len(stream.Operations) == 0 {
+		return nil
+	}
+
+	return readingStage(func(ctx context.Context, msg *Message) (*Message, error) {
+		sensors, withheld, err := p.applyPrivacy(stream, msg.Reading.Sensors, msg.Reading.RecordedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		reading := *msg.Reading
+		reading.Sensors = sensors
+
+		msg.Reading = &reading
+		msg.Dropped += withheld
+
+		return msg, nil
+	})
+}
+
+// encryptStage returns a stage marshalling the readings of the message and
+// encrypting them with the zenroom script for the stream's processing type.
+// If every reading was dropped processing stops, as there is nothing to write.
+func (p *Processor) encryptStage(stream *postgres.Stream) Stage {
+	return StageFunc(func(ctx context.Context, msg *Message) (*Message, error) {
+		// readings written in plain are never downsampled, so are still
+		// written
+		if msg.empty() {
+			return nil, nil
+		}
+
+		script, err := p.scripts.ScriptFor(ProcessingType(stream))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read zenroom script")
+		}
+
+		payload, err := json.Marshal(msg.Reading)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to marshal device")
+		}
+
+		if p.verbose {
+			level.Debug(msg.Logger).Log("full_payload", string(payload))
+		}
+
+		var metadata []byte
+
+		// consumers of streams which reference a schema need its reference
+		// to interpret the plaintext, and those of sampled streams the
+		// percentage sampled to weight their statistics, so their metadata is
+		// always written
+		if p.metadata || stream.Schema != "" || msg.SamplePercent > 0 {
+			metadata, err = buildMetadata(msg.Device, stream, msg.ReceivedAt, msg.SamplePercent)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to marshal metadata")
+			}
+		}
+
+		msg.Encrypted, err = p.encrypt(
+			ctx,
+			script,
+			buildKeys(msg.Device.DeviceToken, stream),
+			payload,
+			stream.Compression,
+			metadata,
+			msg.Plaintext,
+		)
+		if err != nil {
+			recordDeadline(ctx, "encrypt")
+			level.Error(msg.Logger).Log("err", err, "msg", "failed to encrypt data")
+			return nil, err
+		}
+
+		return msg, nil
+	})
+}
+
+// sinkStage returns a stage writing the encrypted payloads of the message to
+// the stream's datastore. Oversized payloads are written as a sequence of
+// chunks, each of which is a separate write. If we have an outbox the payloads
+// are instead queued there, to be delivered by its dispatcher.
+func (p *Processor) sinkStage(stream *postgres.Stream) Stage {
+	if p.outbox != nil {
+		return p.outboxStage(stream)
+	}
+
+	return StageFunc(func(ctx context.Context, msg *Message) (*Message, error) {
+		for _, encodedPayload := range msg.Encrypted {
+			p.stats.RecordEncrypted(stream.StreamID, len(encodedPayload))
+
+			err := p.writePayload(ctx, stream, msg.Device.DeviceToken, encodedPayload)
+
+			if err == ErrDatastoreTimeout {
+				level.Error(msg.Logger).Log("err", err, "msg", "failed to write data", "timeout", p.writeTimeout(stream))
+				return nil, err
+			}
+
+			if err != nil {
+				recordDeadline(ctx, "write")
+				level.Error(msg.Logger).Log("err", err, "msg", "failed to write data")
+				return nil, err
+			}
+		}
+
+		return msg, nil
+	})
+}
@@ -0,0 +1,101 @@
+package pipeline
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+)
+
+// the results with which StreamMessagesCounter is labelled
+const (
+	resultWritten   = "written"
+	resultSkipped   = "skipped"
+	resultFailed    = "failed"
+	resultThrottled = "throttled"
+	resultDropped   = "dropped"
+	resultShed      = "shed"
+)
+
+// streamLabelPrefix is prepended to the keys of stream labels promoted onto
+// StreamMessagesCounter, so they cannot clash with its own labels.
+const streamLabelPrefix = "label_"
+
+var (
+	// StreamMessagesCounter is a prometheus counter recording a count of
+	// messages processed for each stream, labelled by their result and the
+	// values of the stream labels configured via SetStreamLabels.
+	StreamMessagesCounter = newStreamMessagesCounter(nil)
+
+	// streamLabelKeys are the keys of the stream labels promoted onto
+	// StreamMessagesCounter.
+	streamLabelKeys []string
+)
+
+// SetStreamLabels replaces StreamMessagesCounter and IngestionLagHistogram with
+// metrics labelled by the values of the given stream label keys, with a label_
+// prefix, so that messages may be sliced by e.g. pilot or sensor kind without a
+// time series per stream. Streams without one of the labels have an empty
+// value. As with SetBuckets it must be called before the metrics are registered
+// and before any messages are processed.
+func SetStreamLabels(keys []string) {
+	StreamMessagesCounter = newStreamMessagesCounter(keys)
+	IngestionLagHistogram = newIngestionLagHistogram(ingestionBuckets, keys)
+	streamLabelKeys = keys
+}
+
+// newStreamMessagesCounter returns the stream messages counter labelled by the
+// given stream label keys.
+func newStreamMessagesCounter(keys []string) *prometheus.CounterVec {
+	labelNames := []string{"result"}
+	for _, key := range keys {
+		labelNames = append(labelNames, streamLabelPrefix+key)
+	}
+
+	return prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "stream_messages",
+			Help:      "Count of messages processed for streams, by result and stream label",
+		},
+		labelNames,
+	)
+}
+
+// streamResult returns the result with which a message processed for a stream
+// is recorded. A message is failed if processing returned an error, throttled
+// if it arrived faster than the stream's rate limit, shed if it was skipped to
+// relieve the encoder while saturated, and skipped if nothing was written for
+// any other reason, e.g. because it was downsampled.
+func streamResult(written, throttled, shed bool, err error) string {
+	switch {
+	case err != nil:
+		return resultFailed
+	case throttled:
+		return resultThrottled
+	case shed:
+		return resultShed
+	case written:
+		return resultWritten
+	}
+
+	return resultSkipped
+}
+
+// recordStreamMessage increments StreamMessagesCounter for a message for the
+// given stream with the given result.
+func recordStreamMessage(stream *postgres.Stream, result string) {
+	recordStreamMessages(stream, result, 1)
+}
+
+// recordStreamMessages adds n messages for the given stream with the given
+// result to StreamMessagesCounter.
+func recordStreamMessages(stream *postgres.Stream, result string, n int) {
+	values := []string{result}
+
+	for _, key := range streamLabelKeys {
+		values = append(values, stream.Labels[key])
+	}
+
+	StreamMessagesCounter.WithLabelValues(values...).Add(float64(n))
+}
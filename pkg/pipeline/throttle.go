@@ -0,0 +1,120 @@
+package pipeline
+
+import (
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+
+	"github.com/DECODEproject/iotencoder/pkg/logger/level"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/shedding"
+	"github.com/DECODEproject/iotencoder/pkg/throttle"
+)
+
+// RateLimiter is the interface we call to decide whether a message for a stream
+// with a rate limit may be processed now. It is satisfied by the
+// throttle.Limiter type.
+type RateLimiter interface {
+	Allow(key string, spec *throttle.Spec) bool
+}
+
+// throttle returns true if a message for the given stream should be skipped as
+// it arrived faster than the stream's rate limit, recording it in the stream's
+// stats if so. Streams without a rate limit are never throttled, nor are any if
+// we have no limiter.
+func (p *Processor) throttle(stream *postgres.Stream, log kitlog.Logger) (bool, error) {
+	if stream.RateLimit == "" || p.limiter == nil {
+		return false, nil
+	}
+
+	spec, err := throttle.Parse(stream.RateLimit)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to parse stream rate limit")
+	}
+
+	if p.limiter.Allow(stream.StreamID, spec) {
+		return false, nil
+	}
+
+	p.stats.RecordThrottled(stream.StreamID)
+
+	if p.verbose {
+		level.Debug(log).Log("rate_limit", stream.RateLimit, "msg", "throttled message")
+	}
+
+	return true, nil
+}
+
+// QuotaEnforcer is the interface we call to decide whether a message for a
+// stream may be processed within the quotas of the stream's community. It is
+// satisfied by the quota.Quotas type.
+type QuotaEnforcer interface {
+	AllowMessage(community string, size int) (bool, string)
+}
+
+// exceedsQuota returns true if a message of the given size for the given stream
+// should be skipped as the stream's community has exceeded its quota of
+// messages or bytes, recording it in the stream's stats as throttled if so. No
+// stream exceeds a quota if we have no quotas.
+func (p *Processor) exceedsQuota(stream *postgres.Stream, size int, log kitlog.Logger) bool {
+	if p.quotas == nil {
+		return false
+	}
+
+	allowed, exceeded := p.quotas.AllowMessage(stream.CommunityID, size)
+	if allowed {
+		return false
+	}
+
+	p.stats.RecordThrottled(stream.StreamID)
+
+	if p.verbose {
+		level.Debug(log).Log("quota", exceeded, "msg", "community quota exceeded")
+	}
+
+	return true
+}
+
+// Shedder is the interface we call to decide whether a message for a stream of
+// a given priority may be processed while the encoder is saturated. It is
+// satisfied by the shedding.Shedder type.
+type Shedder interface {
+	Allow(priority shedding.Priority) bool
+}
+
+// shed returns true if a message for the given stream should be skipped to
+// relieve the encoder, as it is saturated and the stream's priority is being
+// shed, recording it in the stream's stats as dropped if so. No stream is shed
+// if we have no shedder.
+func (p *Processor) shed(stream *postgres.Stream, log kitlog.Logger) (bool, error) {
+	if p.shedder == nil {
+		return false, nil
+	}
+
+	priority, err := shedding.Parse(stream.Priority)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to parse stream priority")
+	}
+
+	if p.shedder.Allow(priority) {
+		return false, nil
+	}
+
+	p.stats.RecordDropped(stream.StreamID, 1)
+
+	if p.verbose {
+		level.Debug(log).Log("priority", priority, "msg", "shed message")
+	}
+
+	return true, nil
+}
+
+// RecordDropped records that the given number of messages from the device were
+// dropped before being processed, as too many of its messages were waiting.
+// The dropped messages are counted for each of the device's streams, as each
+// stream would have processed them.
+func (p *Processor) RecordDropped(device *postgres.Device, n int) {
+	for _, stream := range device.Streams {
+		p.stats.RecordDropped(stream.StreamID, n)
+		recordStreamMessages(stream, resultDropped, n)
+	}
+}
@@ -0,0 +1,266 @@
+package pipeline_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	datastore "github.com/thingful/twirp-datastore-go"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/lua"
+	"github.com/DECODEproject/iotencoder/pkg/mocks"
+	"github.com/DECODEproject/iotencoder/pkg/pipeline"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/quota"
+	"github.com/DECODEproject/iotencoder/pkg/shedding"
+	"github.com/DECODEproject/iotencoder/pkg/stats"
+	"github.com/DECODEproject/iotencoder/pkg/throttle"
+)
+
+func TestProcessWithRateLimit(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	ds := mocks.Datastore{}
+
+	ds.On(
+		"WriteData",
+		mock.Anything,
+		mock.Anything,
+	).Return(
+		&datastore.WriteResponse{},
+		nil,
+	)
+
+	passthroughExec := func(script, keys, data []byte) ([]byte, error) {
+		return json.Marshal(string(data))
+	}
+
+	cl := clock.NewMock(time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC))
+	store := stats.NewStore(nil, time.Minute, cl, logger)
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore: datastore.Datastore(&ds),
+		Stats:     store,
+		Scripts:   lua.NewScripts(&lua.Config{}, logger),
+		Zenroom:   pipeline.NewZenroomPool(1, time.Second, passthroughExec),
+		Limiter:   throttle.NewLimiter(cl),
+	}, logger)
+
+	payload := []byte(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":13,"value":51.00}]}]}`)
+
+	device := &postgres.Device{
+		DeviceToken: "foo",
+		Streams: []*postgres.Stream{
+			{
+				StreamID:    "limited",
+				CommunityID: "smartcitizen",
+				PublicKey:   `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+				RateLimit:   "1/m",
+			},
+			{
+				StreamID:    "unlimited",
+				CommunityID: "smartcitizen",
+				PublicKey:   `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+			},
+		},
+	}
+
+	// the second message within a minute is throttled for the limited stream
+	// only
+	err := processor.Process(context.Background(), device, payload)
+	assert.Nil(t, err)
+
+	err = processor.Process(context.Background(), device, payload)
+	assert.Nil(t, err)
+
+	assert.Len(t, ds.Calls, 3)
+	assert.Equal(t, uint64(1), store.Get("limited").Throttled)
+	assert.Equal(t, uint64(1), store.Get("limited").WritesSucceeded)
+	assert.Equal(t, uint64(0), store.Get("unlimited").Throttled)
+
+	// replayed payloads are never throttled
+	err = processor.Process(pipeline.WithReplay(context.Background()), device, payload)
+	assert.Nil(t, err)
+
+	assert.Len(t, ds.Calls, 5)
+
+	cl.Add(time.Minute)
+
+	err = processor.Process(context.Background(), device, payload)
+	assert.Nil(t, err)
+
+	assert.Len(t, ds.Calls, 7)
+	assert.Equal(t, uint64(1), store.Get("limited").Throttled)
+}
+
+func TestProcessWithCommunityQuota(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	ds := mocks.Datastore{}
+
+	ds.On(
+		"WriteData",
+		mock.Anything,
+		mock.Anything,
+	).Return(
+		&datastore.WriteResponse{},
+		nil,
+	)
+
+	passthroughExec := func(script, keys, data []byte) ([]byte, error) {
+		return json.Marshal(string(data))
+	}
+
+	cl := clock.NewMock(time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC))
+	store := stats.NewStore(nil, time.Minute, cl, logger)
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore: datastore.Datastore(&ds),
+		Stats:     store,
+		Scripts:   lua.NewScripts(&lua.Config{}, logger),
+		Zenroom:   pipeline.NewZenroomPool(1, time.Second, passthroughExec),
+		Quotas: quota.NewQuotas(map[string]*quota.Limits{
+			"smartcitizen": {MessagesPerMinute: 1},
+		}, cl),
+	}, logger)
+
+	payload := []byte(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":13,"value":51.00}]}]}`)
+
+	device := &postgres.Device{
+		DeviceToken: "foo",
+		Streams: []*postgres.Stream{
+			{
+				StreamID:    "limited",
+				CommunityID: "smartcitizen",
+				PublicKey:   `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+			},
+			{
+				StreamID:    "unlimited",
+				CommunityID: "other",
+				PublicKey:   `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+			},
+		},
+	}
+
+	// the second message within a minute exceeds the quota of the limited
+	// stream's community only
+	err := processor.Process(context.Background(), device, payload)
+	assert.Nil(t, err)
+
+	err = processor.Process(context.Background(), device, payload)
+	assert.Nil(t, err)
+
+	assert.Len(t, ds.Calls, 3)
+	assert.Equal(t, uint64(1), store.Get("limited").Throttled)
+	assert.Equal(t, uint64(0), store.Get("unlimited").Throttled)
+
+	cl.Add(time.Minute)
+
+	err = processor.Process(context.Background(), device, payload)
+	assert.Nil(t, err)
+
+	assert.Len(t, ds.Calls, 5)
+}
+
+// fakeShedder sheds the messages of streams with a priority below level.
+type fakeShedder struct {
+	level shedding.Priority
+}
+
+func (f *fakeShedder) Allow(priority shedding.Priority) bool {
+	return priority >= f.level
+}
+
+func TestProcessWithShedding(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	ds := mocks.Datastore{}
+
+	ds.On(
+		"WriteData",
+		mock.Anything,
+		mock.Anything,
+	).Return(
+		&datastore.WriteResponse{},
+		nil,
+	)
+
+	passthroughExec := func(script, keys, data []byte) ([]byte, error) {
+		return json.Marshal(string(data))
+	}
+
+	store := stats.NewStore(nil, time.Minute, clock.New(), logger)
+	shedder := &fakeShedder{level: shedding.Normal}
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore: datastore.Datastore(&ds),
+		Stats:     store,
+		Scripts:   lua.NewScripts(&lua.Config{}, logger),
+		Zenroom:   pipeline.NewZenroomPool(1, time.Second, passthroughExec),
+		Shedder:   shedder,
+	}, logger)
+
+	payload := []byte(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":13,"value":51.00}]}]}`)
+
+	device := &postgres.Device{
+		DeviceToken: "foo",
+		Streams: []*postgres.Stream{
+			{
+				StreamID:    "low",
+				CommunityID: "smartcitizen",
+				PublicKey:   `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+				Priority:    "low",
+			},
+			{
+				StreamID:    "normal",
+				CommunityID: "smartcitizen",
+				PublicKey:   `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+			},
+		},
+	}
+
+	// only the low priority stream is shed
+	err := processor.Process(context.Background(), device, payload)
+	assert.Nil(t, err)
+
+	assert.Len(t, ds.Calls, 1)
+	assert.Equal(t, uint64(1), store.Get("low").Dropped)
+	assert.Equal(t, uint64(0), store.Get("normal").Dropped)
+
+	// replayed payloads are never shed
+	err = processor.Process(pipeline.WithReplay(context.Background()), device, payload)
+	assert.Nil(t, err)
+
+	assert.Len(t, ds.Calls, 3)
+
+	// once shedding stops every stream is written again
+	shedder.level = shedding.Low
+
+	err = processor.Process(context.Background(), device, payload)
+	assert.Nil(t, err)
+
+	assert.Len(t, ds.Calls, 5)
+	assert.Equal(t, uint64(1), store.Get("low").Dropped)
+}
+
+func TestRecordDropped(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	store := stats.NewStore(nil, time.Minute, clock.New(), logger)
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Stats: store,
+	}, logger)
+
+	processor.RecordDropped(&postgres.Device{
+		DeviceToken: "foo",
+		Streams: []*postgres.Stream{
+			{StreamID: "abc"},
+			{StreamID: "def"},
+		},
+	}, 3)
+
+	assert.Equal(t, uint64(3), store.Get("abc").Dropped)
+	assert.Equal(t, uint64(3), store.Get("def").Dropped)
+}
@@ -0,0 +1,78 @@
+package pipeline
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/smartcitizen"
+	"github.com/DECODEproject/iotencoder/pkg/timestamp"
+)
+
+var (
+	// TimestampPolicyCounter is a prometheus counter recording a count of
+	// payloads whose timestamp was adjusted or which were rejected by the
+	// timestamp policy of a stream, labelled by action.
+	TimestampPolicyCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "timestamp_policy_actions",
+			Help:      "Count of payloads adjusted or rejected by stream timestamp policies",
+		},
+		[]string{"action"},
+	)
+)
+
+// receivedAtKey is the context key under which the time at which the payloads
+// processed with the context were received is stored.
+const receivedAtKey = contextKey("received_at")
+
+// WithReceivedAt returns a copy of the context recording the time at which the
+// payloads processed with it were received, against which stream timestamp
+// policies are applied. Without it the time of processing is used.
+func WithReceivedAt(ctx context.Context, receivedAt time.Time) context.Context {
+	return context.WithValue(ctx, receivedAtKey, receivedAt)
+}
+
+// receivedAt returns the time recorded by WithReceivedAt, or the current time.
+func receivedAt(ctx context.Context) time.Time {
+	if t, ok := ctx.Value(receivedAtKey).(time.Time); ok {
+		return t
+	}
+	return time.Now()
+}
+
+// applyTimestampPolicy returns the device with its timestamp handled according
+// to the stream's timestamp policy, or nil if the policy rejects the payload.
+// As with convertDevice the passed in device is never modified.
+func applyTimestampPolicy(ctx context.Context, device *smartcitizen.Device, stream *postgres.Stream) (*smartcitizen.Device, error) {
+	if stream.TimestampPolicy == "" || stream.TimestampPolicy == timestamp.Device {
+		return device, nil
+	}
+
+	policy, err := timestamp.Parse(stream.TimestampPolicy)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse timestamp policy")
+	}
+
+	recordedAt, err := policy.Apply(device.RecordedAt, receivedAt(ctx))
+	if err == timestamp.ErrSkewed {
+		TimestampPolicyCounter.WithLabelValues("rejected").Inc()
+		return nil, nil
+	}
+
+	if recordedAt.Equal(device.RecordedAt) {
+		return device, nil
+	}
+
+	TimestampPolicyCounter.WithLabelValues("adjusted").Inc()
+
+	adjusted := *device
+	adjusted.RecordedAt = recordedAt
+
+	return &adjusted, nil
+}
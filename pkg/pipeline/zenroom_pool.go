@@ -0,0 +1,158 @@
+package pipeline
+
+import (
+	"context"
+	"runtime/debug"
+	"time"
+
+	zenroom "github.com/DECODEproject/zenroom-go"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// ZenroomTimeoutCounter is a prometheus counter recording a count of calls to
+	// zenroom that were abandoned because they exceeded their timeout or were
+	// cancelled.
+	ZenroomTimeoutCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "zenroom_timeouts",
+			Help:      "Count of zenroom executions abandoned due to timeout or cancellation",
+		},
+	)
+
+	// ZenroomInflightGauge is a prometheus gauge recording the number of zenroom
+	// executions currently holding a slot in the pool.
+	ZenroomInflightGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "zenroom_inflight",
+			Help:      "Number of zenroom executions currently in progress",
+		},
+	)
+
+	// ErrZenroomTimeout is returned when a zenroom execution does not complete
+	// within the configured timeout.
+	ErrZenroomTimeout = errors.New("zenroom execution timed out")
+)
+
+// ExecFunc is the signature of a function able to execute a zenroom script
+// with the given keys and data.
+type ExecFunc func(script, keys, data []byte) ([]byte, error)
+
+// ZenroomExec is the default ExecFunc which invokes zenroom directly.
+func ZenroomExec(script, keys, data []byte) ([]byte, error) {
+	return zenroom.Exec(
+		script,
+		zenroom.WithKeys(keys),
+		zenroom.WithData(data),
+		zenroom.WithVerbosity(1),
+	)
+}
+
+// ZenroomPool bounds the number of concurrent zenroom executions, and applies a
+// timeout to each call so that a hung execution cannot stall message
+// processing indefinitely. As zenroom executes within cgo we are unable to
+// interrupt a running script, so an abandoned execution continues to hold its
+// slot in the pool until it actually returns.
+type ZenroomPool struct {
+	slots   chan struct{}
+	timeout time.Duration
+	exec    ExecFunc
+}
+
+// NewZenroomPool returns a new pool allowing at most size concurrent
+// executions, each of which will be abandoned after timeout. The given ExecFunc
+// is used to actually run scripts.
+func NewZenroomPool(size int, timeout time.Duration, exec ExecFunc) *ZenroomPool {
+	if size < 1 {
+		size = 1
+	}
+
+	return &ZenroomPool{
+		slots:   make(chan struct{}, size),
+		timeout: timeout,
+		exec:    exec,
+	}
+}
+
+// Exec executes the given script within the pool. It blocks until a slot is
+// available, and returns ErrZenroomTimeout if either acquiring a slot or the
+// execution itself exceeds the pool's timeout. If the passed in context is
+// cancelled first, the context's error is returned. If the execution panics,
+// the panic is raised again in the calling goroutine.
+func (z *ZenroomPool) Exec(ctx context.Context, script, keys, data []byte) ([]byte, error) {
+	if z.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, z.timeout)
+		defer cancel()
+	}
+
+	select {
+	case z.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, z.abandoned(ctx)
+	}
+
+	ZenroomInflightGauge.Inc()
+
+	type result struct {
+		output []byte
+		err    error
+		panic  *panicError
+	}
+
+	done := make(chan result, 1)
+	start := time.Now()
+
+	go func() {
+		defer func() {
+			ZenroomInflightGauge.Dec()
+			<-z.slots
+		}()
+
+		// a panic here can't be recovered by the caller, so we capture it with
+		// its stack and raise it again from Exec below
+		defer func() {
+			if r := recover(); r != nil {
+				done <- result{panic: &panicError{value: r, stack: debug.Stack()}}
+			}
+		}()
+
+		output, err := z.exec(script, keys, data)
+		done <- result{output: output, err: err}
+	}()
+
+	select {
+	case res := <-done:
+		if res.panic != nil {
+			panic(res.panic)
+		}
+
+		if res.err != nil {
+			ZenroomErrorCounter.Inc()
+			return nil, res.err
+		}
+
+		ZenroomHistogram.Observe(time.Since(start).Seconds())
+
+		return res.output, nil
+	case <-ctx.Done():
+		return nil, z.abandoned(ctx)
+	}
+}
+
+// abandoned records that an execution was abandoned, returning the appropriate
+// error to the caller.
+func (z *ZenroomPool) abandoned(ctx context.Context) error {
+	ZenroomTimeoutCounter.Inc()
+
+	if ctx.Err() == context.DeadlineExceeded {
+		return ErrZenroomTimeout
+	}
+
+	return ctx.Err()
+}
@@ -0,0 +1,120 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/pipeline"
+)
+
+func TestZenroomPoolExec(t *testing.T) {
+	pool := pipeline.NewZenroomPool(2, time.Second, func(script, keys, data []byte) ([]byte, error) {
+		return append(script, data...), nil
+	})
+
+	output, err := pool.Exec(context.Background(), []byte("foo"), nil, []byte("bar"))
+	assert.Nil(t, err)
+	assert.Equal(t, "foobar", string(output))
+}
+
+func TestZenroomPoolError(t *testing.T) {
+	pool := pipeline.NewZenroomPool(2, time.Second, func(script, keys, data []byte) ([]byte, error) {
+		return nil, errors.New("failed")
+	})
+
+	_, err := pool.Exec(context.Background(), nil, nil, nil)
+	assert.NotNil(t, err)
+	assert.Equal(t, "failed", err.Error())
+}
+
+func TestZenroomPoolPanic(t *testing.T) {
+	pool := pipeline.NewZenroomPool(1, time.Second, func(script, keys, data []byte) ([]byte, error) {
+		panic("exploded")
+	})
+
+	assert.Panics(t, func() {
+		pool.Exec(context.Background(), nil, nil, nil)
+	})
+
+	// the slot was released, so the pool remains usable
+	assert.Panics(t, func() {
+		pool.Exec(context.Background(), nil, nil, nil)
+	})
+}
+
+func TestZenroomPoolTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	pool := pipeline.NewZenroomPool(1, 20*time.Millisecond, func(script, keys, data []byte) ([]byte, error) {
+		<-release
+		return nil, nil
+	})
+
+	_, err := pool.Exec(context.Background(), nil, nil, nil)
+	assert.Equal(t, pipeline.ErrZenroomTimeout, err)
+
+	// the hung execution still holds the only slot, so a second call times out
+	// waiting for it
+	_, err = pool.Exec(context.Background(), nil, nil, nil)
+	assert.Equal(t, pipeline.ErrZenroomTimeout, err)
+}
+
+func TestZenroomPoolCancel(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	pool := pipeline.NewZenroomPool(1, time.Second, func(script, keys, data []byte) ([]byte, error) {
+		<-release
+		return nil, nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := pool.Exec(ctx, nil, nil, nil)
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestZenroomPoolBounded(t *testing.T) {
+	var (
+		current int32
+		max     int32
+	)
+
+	pool := pipeline.NewZenroomPool(3, time.Second, func(script, keys, data []byte) ([]byte, error) {
+		n := atomic.AddInt32(&current, 1)
+		defer atomic.AddInt32(&current, -1)
+
+		for {
+			m := atomic.LoadInt32(&max)
+			if n <= m || atomic.CompareAndSwapInt32(&max, m, n) {
+				break
+			}
+		}
+
+		time.Sleep(5 * time.Millisecond)
+		return nil, nil
+	})
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := pool.Exec(context.Background(), nil, nil, nil)
+			assert.Nil(t, err)
+		}()
+	}
+
+	wg.Wait()
+
+	assert.True(t, atomic.LoadInt32(&max) <= 3)
+}
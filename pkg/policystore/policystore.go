@@ -0,0 +1,146 @@
+package policystore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+)
+
+// listPoliciesPath is the path of the twirp method of the DECODE policy store
+// which returns all entitlement policies, relative to the store's address.
+const listPoliciesPath = "/twirp/decode.iot.policystore.PolicyStore/ListEntitlementPolicies"
+
+// ErrUnknownPolicy is returned when a policy is not known to the policy store.
+var ErrUnknownPolicy = errors.New("unknown policy")
+
+// Policy is an entitlement policy registered with the policy store. Policies
+// are identified by their community id, and carry the public key with which
+// data shared with the community must be encrypted.
+type Policy struct {
+	CommunityID string `json:"community_id"`
+	PublicKey   string `json:"public_key"`
+	Label       string `json:"label"`
+}
+
+// Config is used to pass in configuration when creating a Client.
+type Config struct {
+	// Addr is the base address of the policy store, e.g. http://policystore:8082.
+	Addr string
+
+	// TTL is the duration for which policies loaded from the policy store are
+	// cached. If zero policies are loaded on every call.
+	TTL time.Duration
+
+	// Clock is used to expire cached policies.
+	Clock clock.Clock
+
+	// HTTPClient is the client used to call the policy store.
+	HTTPClient *http.Client
+}
+
+// Client resolves the public keys of policies against the DECODE policy store.
+// As the number of policies is small, all policies are loaded at once and
+// cached for our TTL.
+type Client struct {
+	addr   string
+	ttl    time.Duration
+	clock  clock.Clock
+	client *http.Client
+	logger kitlog.Logger
+
+	sync.Mutex
+	policies  map[string]*Policy
+	expiresAt time.Time
+}
+
+// NewClient returns a new Client configured with the given Config.
+func NewClient(config *Config, logger kitlog.Logger) *Client {
+	logger = kitlog.With(logger, "module", "policystore")
+
+	logger.Log("msg", "creating policy store client", "addr", config.Addr, "ttl", config.TTL)
+
+	return &Client{
+		addr:   strings.TrimSuffix(config.Addr, "/"),
+		ttl:    config.TTL,
+		clock:  config.Clock,
+		client: config.HTTPClient,
+		logger: logger,
+	}
+}
+
+// PublicKey returns the public key of the policy with the given id, from the
+// cache if policies were loaded within our TTL, or otherwise from the policy
+// store. If the store has no such policy ErrUnknownPolicy is returned.
+func (c *Client) PublicKey(ctx context.Context, policyID string) (string, error) {
+	c.Lock()
+	policies := c.policies
+	fresh := c.clock.Now().Before(c.expiresAt)
+	c.Unlock()
+
+	if !fresh {
+		var err error
+
+		policies, err = c.Policies(ctx)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	policy, ok := policies[policyID]
+	if !ok {
+		return "", ErrUnknownPolicy
+	}
+
+	return policy.PublicKey, nil
+}
+
+// Policies loads all policies from the policy store keyed by id, replacing
+// those we have cached.
+func (c *Client) Policies(ctx context.Context) (map[string]*Policy, error) {
+	req, err := http.NewRequest(http.MethodPost, c.addr+listPoliciesPath, bytes.NewBufferString("{}"))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create policy store request")
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to call policy store")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("policy store returned status %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Policies []*Policy `json:"policies"`
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode policy store response")
+	}
+
+	policies := make(map[string]*Policy, len(body.Policies))
+	for _, p := range body.Policies {
+		policies[p.CommunityID] = p
+	}
+
+	c.Lock()
+	c.policies = policies
+	c.expiresAt = c.clock.Now().Add(c.ttl)
+	c.Unlock()
+
+	return policies, nil
+}
@@ -0,0 +1,131 @@
+package policystore_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/policystore"
+)
+
+// policyStore returns a test server acting as the policy store, which returns
+// the policy with the key returned by publicKey, and counts calls made to it.
+func policyStore(t *testing.T, publicKey func() string, calls *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(calls, 1)
+
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/twirp/decode.iot.policystore.PolicyStore/ListEntitlementPolicies", r.URL.Path)
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"policies":[{"community_id":"policy-1","public_key":"%s","label":"Policy 1"}]}`, publicKey())
+	}))
+}
+
+func TestClientPublicKey(t *testing.T) {
+	var calls int32
+
+	ts := policyStore(t, func() string { return "key-1" }, &calls)
+	defer ts.Close()
+
+	now := time.Now()
+
+	client := policystore.NewClient(&policystore.Config{
+		Addr:       ts.URL + "/",
+		TTL:        time.Minute,
+		Clock:      clock.NewMock(now),
+		HTTPClient: ts.Client(),
+	}, kitlog.NewNopLogger())
+
+	key, err := client.PublicKey(context.Background(), "policy-1")
+	assert.Nil(t, err)
+	assert.Equal(t, "key-1", key)
+
+	_, err = client.PublicKey(context.Background(), "policy-2")
+	assert.Equal(t, policystore.ErrUnknownPolicy, err)
+
+	// both lookups are answered by a single call while policies are cached
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestClientPolicyStoreError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	client := policystore.NewClient(&policystore.Config{
+		Addr:       ts.URL,
+		Clock:      clock.New(),
+		HTTPClient: ts.Client(),
+	}, kitlog.NewNopLogger())
+
+	_, err := client.PublicKey(context.Background(), "policy-1")
+	assert.NotNil(t, err)
+	assert.NotEqual(t, policystore.ErrUnknownPolicy, err)
+}
+
+type store struct {
+	keys map[string]string
+}
+
+func (s *store) SetPolicyPublicKey(policyID, publicKey string) (int64, error) {
+	if s.keys[policyID] == publicKey {
+		return 0, nil
+	}
+
+	s.keys[policyID] = publicKey
+
+	return 1, nil
+}
+
+func TestRefresherRefresh(t *testing.T) {
+	var (
+		calls int32
+		key   atomic.Value
+	)
+
+	key.Store("key-1")
+
+	ts := policyStore(t, func() string { return key.Load().(string) }, &calls)
+	defer ts.Close()
+
+	client := policystore.NewClient(&policystore.Config{
+		Addr:       ts.URL,
+		TTL:        time.Hour,
+		Clock:      clock.New(),
+		HTTPClient: ts.Client(),
+	}, kitlog.NewNopLogger())
+
+	s := &store{keys: map[string]string{}}
+
+	refresher := policystore.NewRefresher(&policystore.RefresherConfig{
+		Client:   client,
+		Store:    s,
+		Interval: time.Hour,
+	}, kitlog.NewNopLogger())
+
+	err := refresher.Refresh()
+	assert.Nil(t, err)
+	assert.Equal(t, "key-1", s.keys["policy-1"])
+
+	key.Store("key-2")
+
+	err = refresher.Refresh()
+	assert.Nil(t, err)
+	assert.Equal(t, "key-2", s.keys["policy-1"])
+
+	// refreshing also replaces the cached policies
+	resolved, err := client.PublicKey(context.Background(), "policy-1")
+	assert.Nil(t, err)
+	assert.Equal(t, "key-2", resolved)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
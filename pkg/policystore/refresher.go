@@ -0,0 +1,133 @@
+package policystore
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+
+	"github.com/DECODEproject/iotencoder/pkg/logger/level"
+)
+
+// Store is the interface we require of a type able to update the public keys
+// of streams created from a policy. It is satisfied by our postgres.DB type,
+// and by the cache.Cache type which also invalidates affected devices.
+type Store interface {
+	// SetPolicyPublicKey replaces the public key of every live stream created
+	// from the policy whose key differs, returning the number of streams
+	// updated.
+	SetPolicyPublicKey(policyID, publicKey string) (int64, error)
+}
+
+// RefresherConfig is used to pass in configuration when creating a Refresher.
+type RefresherConfig struct {
+	// Client is the client from which policies are loaded.
+	Client *Client
+
+	// Store is the store whose streams are updated with rotated keys.
+	Store Store
+
+	// Interval is the interval at which policies are re-resolved.
+	Interval time.Duration
+}
+
+// Refresher is a component that periodically re-resolves policies against the
+// policy store, so that keys rotated in the store are applied to the streams
+// created from them.
+type Refresher struct {
+	client   *Client
+	store    Store
+	interval time.Duration
+	logger   kitlog.Logger
+	quit     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewRefresher returns a new Refresher configured with the given config.
+func NewRefresher(config *RefresherConfig, logger kitlog.Logger) *Refresher {
+	logger = kitlog.With(logger, "module", "policystore")
+
+	return &Refresher{
+		client:   config.Client,
+		store:    config.Store,
+		interval: config.Interval,
+		logger:   logger,
+	}
+}
+
+// Start starts a goroutine which refreshes policies on each tick of our
+// interval.
+func (r *Refresher) Start() error {
+	r.logger.Log("msg", "starting policy refresher", "interval", r.interval)
+
+	if r.interval <= 0 {
+		return errors.New("policy refresh interval must be positive")
+	}
+
+	r.quit = make(chan struct{})
+	r.wg.Add(1)
+
+	go r.loop()
+
+	return nil
+}
+
+// Stop stops the refresh goroutine.
+func (r *Refresher) Stop() error {
+	if r.quit == nil {
+		return nil
+	}
+
+	r.logger.Log("msg", "stopping policy refresher")
+
+	close(r.quit)
+	r.wg.Wait()
+
+	return nil
+}
+
+// Refresh loads all policies from the policy store and applies their current
+// public keys to the streams created from them. Streams whose policy has been
+// removed from the store keep their last known key.
+func (r *Refresher) Refresh() error {
+	policies, err := r.client.Policies(context.Background())
+	if err != nil {
+		return errors.Wrap(err, "failed to load policies")
+	}
+
+	for id, policy := range policies {
+		updated, err := r.store.SetPolicyPublicKey(id, policy.PublicKey)
+		if err != nil {
+			return errors.Wrap(err, "failed to update policy public key")
+		}
+
+		if updated > 0 {
+			r.logger.Log("msg", "applied rotated policy key", "policyID", id, "streams", updated)
+		}
+	}
+
+	return nil
+}
+
+// loop is run in a goroutine and refreshes policies on each tick of our
+// interval until the refresher is stopped.
+func (r *Refresher) loop() {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := r.Refresh()
+			if err != nil {
+				level.Error(r.logger).Log("msg", "failed to refresh policies", "err", err)
+			}
+		case <-r.quit:
+			return
+		}
+	}
+}
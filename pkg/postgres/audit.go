@@ -0,0 +1,156 @@
+package postgres
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"gopkg.in/guregu/null.v3"
+)
+
+// AuditEntry is a type used to hold a single record of the append only audit
+// log, describing a call which mutated a stream. Entries are never updated or
+// deleted once written.
+type AuditEntry struct {
+	ID         int64       `db:"id"`
+	RecordedAt time.Time   `db:"recorded_at"`
+	Caller     string      `db:"caller"`
+	RequestID  null.String `db:"request_id"`
+	Method     string      `db:"method"`
+	StreamID   null.String `db:"stream_uuid"`
+	Params     AuditParams `db:"params"`
+	Result     string      `db:"result"`
+	Error      null.String `db:"error"`
+}
+
+// AuditParams is a type used to hold the parameters of an audited request,
+// with any secrets already redacted. It is stored as JSON.
+type AuditParams map[string]interface{}
+
+// Value is our implementation of the driver.Valuer interface which converts the
+// params into a JSON object for storage.
+func (p AuditParams) Value() (driver.Value, error) {
+	if p == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(p)
+}
+
+// Scan is our implementation of the sql.Scanner interface which takes the value
+// read from the database, and converts it back into an instance of the type.
+func (p *AuditParams) Scan(src interface{}) error {
+	if p == nil {
+		return nil
+	}
+
+	source, ok := src.([]byte)
+	if !ok {
+		return errors.New("Value read from database cannot be typecast to a byte slice")
+	}
+
+	err := json.Unmarshal(source, p)
+	if err != nil {
+		return errors.Wrap(err, "failed to unmarshal bytes into AuditParams")
+	}
+
+	return nil
+}
+
+// SaveAuditEntry appends the given entry to the audit log, setting its ID.
+func (d *DB) SaveAuditEntry(entry *AuditEntry) (err error) {
+	sql := `INSERT INTO audit_log
+		(recorded_at, caller, request_id, method, stream_uuid, params, result, error)
+	VALUES (:recorded_at, :caller, :request_id, :method, :stream_uuid, :params, :result, :error)
+	RETURNING id`
+
+	mapArgs := map[string]interface{}{
+		"recorded_at": entry.RecordedAt,
+		"caller":      entry.Caller,
+		"request_id":  entry.RequestID,
+		"method":      entry.Method,
+		"stream_uuid": entry.StreamID,
+		"params":      entry.Params,
+		"result":      entry.Result,
+		"error":       entry.Error,
+	}
+
+	tx, err := BeginTX(d.DB, "save_audit_entry")
+	if err != nil {
+		return errors.Wrap(err, "failed to start transaction when saving audit entry")
+	}
+
+	defer func() {
+		if cerr := tx.CommitOrRollback(); err == nil && cerr != nil {
+			err = cerr
+		}
+	}()
+
+	err = tx.Get(&entry.ID, sql, mapArgs)
+	if err != nil {
+		return errors.Wrap(err, "failed to save audit entry")
+	}
+
+	return nil
+}
+
+// GetAuditEntries returns a page of audit entries recorded within the given
+// time interval, ordered by insertion. If streamID is not empty only entries
+// for that stream are returned. Pages are requested by passing the ID of the
+// last entry of the previous page (or zero for the first page), and the
+// maximum number of entries to return.
+func (d *DB) GetAuditEntries(streamID string, start, end time.Time, afterID int64, limit int) (_ []*AuditEntry, err error) {
+	sql := `SELECT id, recorded_at, caller, request_id, method, stream_uuid,
+		params, result, error
+	FROM audit_log
+	WHERE (:stream_uuid = '' OR stream_uuid = :stream_uuid)
+	AND recorded_at >= :start_time
+	AND recorded_at < :end_time
+	AND id > :after_id
+	ORDER BY id
+	LIMIT :limit`
+
+	mapArgs := map[string]interface{}{
+		"stream_uuid": streamID,
+		"start_time":  start,
+		"end_time":    end,
+		"after_id":    afterID,
+		"limit":       limit,
+	}
+
+	tx, err := BeginTX(d.DB, "get_audit_entries")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to begin transaction")
+	}
+
+	defer func() {
+		if cerr := tx.CommitOrRollback(); err == nil && cerr != nil {
+			err = cerr
+		}
+	}()
+
+	entries := []*AuditEntry{}
+
+	mapper := func(rows *sqlx.Rows) error {
+		for rows.Next() {
+			var e AuditEntry
+
+			err = rows.StructScan(&e)
+			if err != nil {
+				return errors.Wrap(err, "failed to scan row into AuditEntry struct")
+			}
+
+			entries = append(entries, &e)
+		}
+
+		return nil
+	}
+
+	err = tx.Map(sql, mapArgs, mapper)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to select audit entries from database")
+	}
+
+	return entries, nil
+}
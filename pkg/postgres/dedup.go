@@ -0,0 +1,136 @@
+package postgres
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// MessageKey records when a message identified by key was first seen. Keys are
+// maintained in memory by the dedup package, and periodically written back to
+// the DB so that messages redelivered across a restart are still recognised.
+type MessageKey struct {
+	Key    string    `db:"key"`
+	SeenAt time.Time `db:"seen_at"`
+}
+
+// SaveMessageKeys writes the given message keys to the database, keeping the
+// earliest time at which a key was seen should it already exist.
+func (d *DB) SaveMessageKeys(keys []*MessageKey) (err error) {
+	sql := `INSERT INTO message_keys (key, seen_at)
+	VALUES (:key, :seen_at)
+	ON CONFLICT (key) DO UPDATE
+	SET seen_at = LEAST(message_keys.seen_at, EXCLUDED.seen_at)`
+
+	stmt, err := d.prepare(sql)
+	if err != nil {
+		return errors.Wrap(err, "failed to save message keys")
+	}
+
+	tx, err := BeginTX(d.DB, "save_message_keys")
+	if err != nil {
+		return errors.Wrap(err, "failed to start transaction when saving message keys")
+	}
+
+	defer func() {
+		if cerr := tx.CommitOrRollback(); err == nil && cerr != nil {
+			err = cerr
+		}
+	}()
+
+	for _, k := range keys {
+		mapArgs := map[string]interface{}{
+			"key":     k.Key,
+			"seen_at": k.SeenAt,
+		}
+
+		err = tx.ExecStmt(stmt, mapArgs)
+		if err != nil {
+			return errors.Wrap(err, "failed to save message key")
+		}
+	}
+
+	return nil
+}
+
+// GetMessageKeys returns all persisted message keys seen at or after the given
+// time. This is used to seed the in memory dedup cache when the application
+// starts.
+func (d *DB) GetMessageKeys(since time.Time) (_ []*MessageKey, err error) {
+	sql := `SELECT key, seen_at
+	FROM message_keys
+	WHERE seen_at >= :since
+	ORDER BY seen_at`
+
+	mapArgs := map[string]interface{}{
+		"since": since,
+	}
+
+	tx, err := BeginTX(d.DB, "get_message_keys")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to begin transaction")
+	}
+
+	defer func() {
+		if cerr := tx.CommitOrRollback(); err == nil && cerr != nil {
+			err = cerr
+		}
+	}()
+
+	keys := []*MessageKey{}
+
+	mapper := func(rows *sqlx.Rows) error {
+		for rows.Next() {
+			var k MessageKey
+
+			err = rows.StructScan(&k)
+			if err != nil {
+				return errors.Wrap(err, "failed to scan row into MessageKey struct")
+			}
+
+			keys = append(keys, &k)
+		}
+
+		return nil
+	}
+
+	err = tx.Map(sql, mapArgs, mapper)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to select message keys from database")
+	}
+
+	return keys, nil
+}
+
+// PruneMessageKeys deletes all message keys seen before the given time,
+// returning the number of keys deleted.
+func (d *DB) PruneMessageKeys(before time.Time) (_ int64, err error) {
+	sql := `WITH deleted AS (
+		DELETE FROM message_keys WHERE seen_at < :before RETURNING key
+	) SELECT COUNT(*) FROM deleted`
+
+	mapArgs := map[string]interface{}{
+		"before": before,
+	}
+
+	tx, err := BeginTX(d.DB, "prune_message_keys")
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to begin transaction")
+	}
+
+	defer func() {
+		if cerr := tx.CommitOrRollback(); err == nil && cerr != nil {
+			err = cerr
+		}
+	}()
+
+	var deleted int64
+
+	err = tx.Get(&deleted, sql, mapArgs)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to delete expired message keys")
+	}
+
+	return deleted, nil
+}
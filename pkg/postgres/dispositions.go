@@ -0,0 +1,178 @@
+package postgres
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Disposition is what happens to the readings of a sensor of a stream.
+type Disposition string
+
+const (
+	// Encrypt is the default disposition, with readings encrypted for the
+	// stream's community.
+	Encrypt Disposition = "encrypt"
+
+	// Drop discards readings, so they are neither encrypted nor written.
+	Drop Disposition = "drop"
+
+	// Plain writes readings unencrypted alongside the encrypted data. It must
+	// be explicitly enabled for the encoder.
+	Plain Disposition = "plain"
+)
+
+// Dispositions maps sensor ids to the disposition of readings of that sensor,
+// sensors without one being encrypted. As with Conversions we implement
+// sql.Valuer and sql.Scanner so the map is stored as JSON.
+type Dispositions map[uint32]Disposition
+
+// ParseDispositions parses dispositions from their string form, a comma
+// separated list of SENSOR_ID=DISPOSITION pairs, e.g. "12=plain,14=drop". An
+// empty string is parsed as no dispositions.
+func ParseDispositions(s string) (Dispositions, error) {
+	dispositions := Dispositions{}
+
+	if strings.TrimSpace(s) == "" {
+		return dispositions, nil
+	}
+
+	for _, pair := range strings.Split(s, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, errors.Errorf("invalid disposition %q, must be sensor_id=disposition", pair)
+		}
+
+		sensorID, err := strconv.ParseUint(strings.TrimSpace(parts[0]), 10, 32)
+		if err != nil || sensorID == 0 {
+			return nil, errors.Errorf("invalid disposition %q, sensor id must be a positive integer", pair)
+		}
+
+		if _, ok := dispositions[uint32(sensorID)]; ok {
+			return nil, errors.Errorf("duplicate disposition for sensor %d", sensorID)
+		}
+
+		dispositions[uint32(sensorID)] = Disposition(strings.TrimSpace(parts[1]))
+	}
+
+	return dispositions, dispositions.validate()
+}
+
+// Format returns the string form of the dispositions parsed by
+// ParseDispositions, with sensors in ascending order.
+func (d Dispositions) Format() string {
+	ids := make([]int, 0, len(d))
+	for id := range d {
+		ids = append(ids, int(id))
+	}
+
+	sort.Ints(ids)
+
+	pairs := make([]string, 0, len(ids))
+	for _, id := range ids {
+		pairs = append(pairs, fmt.Sprintf("%d=%s", id, d[uint32(id)]))
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+// Of returns the disposition of readings of the given sensor.
+func (d Dispositions) Of(sensorID int) Disposition {
+	if disposition, ok := d[uint32(sensorID)]; ok {
+		return disposition
+	}
+
+	return Encrypt
+}
+
+// HasPlain returns true if the readings of any sensor are written unencrypted.
+func (d Dispositions) HasPlain() bool {
+	for _, disposition := range d {
+		if disposition == Plain {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Validate returns an error if the dispositions may not be applied to a stream
+// with the given operations. As readings written in plain are not processed by
+// any operation, a stream with operations must share each of its plain sensors
+// unprocessed, so that no more is revealed in plain than the stream shares.
+func (d Dispositions) Validate(operations Operations) error {
+	err := d.validate()
+	if err != nil {
+		return err
+	}
+
+	if len(operations) == 0 {
+		return nil
+	}
+
+	for id, disposition := range d {
+		if disposition != Plain {
+			continue
+		}
+
+		shared := false
+		for _, op := range operations {
+			if op.SensorID == id && op.Action == Share {
+				shared = true
+			}
+		}
+
+		if !shared {
+			return errors.Errorf("sensor %d must be shared without processing to be written in plain", id)
+		}
+	}
+
+	return nil
+}
+
+// validate returns an error if any disposition is not one we know.
+func (d Dispositions) validate() error {
+	for id, disposition := range d {
+		switch disposition {
+		case Encrypt, Drop, Plain:
+		default:
+			return errors.Errorf("unknown disposition %q for sensor %d, must be encrypt, drop or plain", disposition, id)
+		}
+	}
+
+	return nil
+}
+
+// Value is our implementation of the sql.Valuer interface which converts the
+// instance into a value that can be written to the database.
+func (d Dispositions) Value() (driver.Value, error) {
+	if d == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(d)
+}
+
+// Scan is our implementation of the sql.Scanner interface which takes the value
+// read from the database, and converts it back into an instance of the type.
+func (d *Dispositions) Scan(src interface{}) error {
+	if d == nil {
+		return nil
+	}
+
+	source, ok := src.([]byte)
+	if !ok {
+		return errors.New("Value read from database cannot be typecast to a byte slice")
+	}
+
+	err := json.Unmarshal(source, d)
+	if err != nil {
+		return errors.Wrap(err, "failed to unmarshal bytes into Dispositions")
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package postgres_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+)
+
+func TestParseDispositions(t *testing.T) {
+	dispositions, err := postgres.ParseDispositions("14=drop, 12=plain,13=encrypt")
+	assert.Nil(t, err)
+	assert.Equal(t, postgres.Dispositions{12: postgres.Plain, 13: postgres.Encrypt, 14: postgres.Drop}, dispositions)
+	assert.Equal(t, "12=plain,13=encrypt,14=drop", dispositions.Format())
+
+	assert.Equal(t, postgres.Plain, dispositions.Of(12))
+	assert.Equal(t, postgres.Encrypt, dispositions.Of(29))
+	assert.True(t, dispositions.HasPlain())
+
+	dispositions, err = postgres.ParseDispositions("")
+	assert.Nil(t, err)
+	assert.Len(t, dispositions, 0)
+
+	testcases := []struct {
+		label         string
+		input         string
+		expectedError string
+	}{
+		{
+			label:         "missing disposition",
+			input:         "12",
+			expectedError: `invalid disposition "12", must be sensor_id=disposition`,
+		},
+		{
+			label:         "invalid sensor",
+			input:         "temp=plain",
+			expectedError: `invalid disposition "temp=plain", sensor id must be a positive integer`,
+		},
+		{
+			label:         "duplicate sensor",
+			input:         "12=plain,12=drop",
+			expectedError: "duplicate disposition for sensor 12",
+		},
+		{
+			label:         "unknown disposition",
+			input:         "12=hash",
+			expectedError: `unknown disposition "hash" for sensor 12, must be encrypt, drop or plain`,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			_, err := postgres.ParseDispositions(tc.input)
+			assert.NotNil(t, err)
+			assert.Equal(t, tc.expectedError, err.Error())
+		})
+	}
+}
+
+func TestDispositionsValidate(t *testing.T) {
+	dispositions := postgres.Dispositions{12: postgres.Plain, 14: postgres.Drop}
+
+	assert.Nil(t, dispositions.Validate(nil))
+
+	assert.Nil(t, dispositions.Validate(postgres.Operations{
+		{SensorID: 12, Action: postgres.Share},
+		{SensorID: 13, Action: postgres.MovingAverage, Interval: 900},
+	}))
+
+	err := dispositions.Validate(postgres.Operations{
+		{SensorID: 12, Action: postgres.Bin, Bins: []float64{40, 80}},
+	})
+	assert.NotNil(t, err)
+	assert.Equal(t, "sensor 12 must be shared without processing to be written in plain", err.Error())
+}
+
+func TestDispositionsScan(t *testing.T) {
+	var dispositions postgres.Dispositions
+
+	err := dispositions.Scan([]byte(`{"12":"plain","14":"drop"}`))
+	assert.Nil(t, err)
+	assert.Equal(t, postgres.Dispositions{12: postgres.Plain, 14: postgres.Drop}, dispositions)
+
+	value, err := dispositions.Value()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte(`{"12":"plain","14":"drop"}`), value)
+}
@@ -0,0 +1,105 @@
+package postgres
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// DownsampleCheckpoint records when a reading was last forwarded for a single
+// sensor of a downsampled stream. Checkpoints are maintained in memory by the
+// downsample package, and periodically written back to the DB so that a
+// restart does not cause readings to be forwarded early.
+type DownsampleCheckpoint struct {
+	StreamID        string    `db:"stream_uuid"`
+	SensorID        int       `db:"sensor_id"`
+	LastForwardedAt time.Time `db:"last_forwarded_at"`
+}
+
+// SaveDownsampleCheckpoints writes the given checkpoints to the database,
+// upserting any existing rows. Checkpoints for streams that no longer exist
+// are silently skipped.
+func (d *DB) SaveDownsampleCheckpoints(checkpoints []*DownsampleCheckpoint) (err error) {
+	sql := `INSERT INTO downsample_checkpoints
+		(stream_uuid, sensor_id, last_forwarded_at)
+	SELECT uuid, :sensor_id, :last_forwarded_at
+	FROM streams
+	WHERE uuid = :stream_uuid
+	ON CONFLICT (stream_uuid, sensor_id) DO UPDATE
+	SET last_forwarded_at = EXCLUDED.last_forwarded_at,
+			updated_at = NOW()`
+
+	stmt, err := d.prepare(sql)
+	if err != nil {
+		return errors.Wrap(err, "failed to save downsample checkpoints")
+	}
+
+	tx, err := BeginTX(d.DB, "save_downsample_checkpoints")
+	if err != nil {
+		return errors.Wrap(err, "failed to start transaction when saving downsample checkpoints")
+	}
+
+	defer func() {
+		if cerr := tx.CommitOrRollback(); err == nil && cerr != nil {
+			err = cerr
+		}
+	}()
+
+	for _, c := range checkpoints {
+		mapArgs := map[string]interface{}{
+			"stream_uuid":       c.StreamID,
+			"sensor_id":         c.SensorID,
+			"last_forwarded_at": c.LastForwardedAt,
+		}
+
+		err = tx.ExecStmt(stmt, mapArgs)
+		if err != nil {
+			return errors.Wrap(err, "failed to save downsample checkpoint")
+		}
+	}
+
+	return nil
+}
+
+// GetDownsampleCheckpoints returns all persisted downsample checkpoints. This
+// is used to seed the in memory downsampler when the application starts.
+func (d *DB) GetDownsampleCheckpoints() (_ []*DownsampleCheckpoint, err error) {
+	sql := `SELECT stream_uuid, sensor_id, last_forwarded_at
+	FROM downsample_checkpoints`
+
+	tx, err := BeginTX(d.DB, "get_downsample_checkpoints")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to begin transaction")
+	}
+
+	defer func() {
+		if cerr := tx.CommitOrRollback(); err == nil && cerr != nil {
+			err = cerr
+		}
+	}()
+
+	checkpoints := []*DownsampleCheckpoint{}
+
+	mapper := func(rows *sqlx.Rows) error {
+		for rows.Next() {
+			var c DownsampleCheckpoint
+
+			err = rows.StructScan(&c)
+			if err != nil {
+				return errors.Wrap(err, "failed to scan row into DownsampleCheckpoint struct")
+			}
+
+			checkpoints = append(checkpoints, &c)
+		}
+
+		return nil
+	}
+
+	err = tx.Map(sql, []interface{}{}, mapper)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to select downsample checkpoints from database")
+	}
+
+	return checkpoints, nil
+}
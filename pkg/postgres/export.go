@@ -0,0 +1,182 @@
+package postgres
+
+import (
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+)
+
+// ErrStreamExists is returned when importing a stream whose id is already
+// registered.
+var ErrStreamExists = errors.New("stream already exists")
+
+// streamsUUIDIndex is the name of the unique index on the uuid of streams,
+// which allows us to distinguish an imported stream which already exists from
+// one whose device is already registered within its community.
+const streamsUUIDIndex = "streams_uuid_idx"
+
+// exportRow extends streamRow with the decrypted secrets of the stream, which
+// are only ever read when exporting streams.
+type exportRow struct {
+	streamRow
+	Token        secret.Secret `db:"token"`
+	IngestSecret secret.Secret `db:"ingest_secret"`
+}
+
+// ExportStreams calls fn with every live stream in creation order, along with
+// the device feeding it. Unlike ListStreams the stream's token and ingest
+// secret are decrypted and returned, so that the stream may be recreated
+// exactly by ImportStream. Rows are passed to fn as they are read, so the
+// number of streams is not limited by memory, but the transaction is held open
+// until fn has been called for every stream.
+func (d *DB) ExportStreams(fn func(stream *Stream) error) (err error) {
+	query := `SELECT ` + qualifiedStreamColumns + `,
+		pgp_sym_decrypt(s.token, :encryption_password) AS token,
+		COALESCE(pgp_sym_decrypt(s.ingest_secret, :encryption_password), '') AS ingest_secret,
+		d.id, d.device_token, d.longitude, d.latitude, d.exposure, d.device_label
+	FROM streams s
+	JOIN devices d ON d.id = s.device_id
+	WHERE s.deleted_at IS NULL
+	ORDER BY s.id`
+
+	mapArgs := map[string]interface{}{
+		"encryption_password": d.encryptionKey(),
+	}
+
+	tx, err := BeginTX(d.DB, "export_streams")
+	if err != nil {
+		return errors.Wrap(err, "failed to begin transaction")
+	}
+
+	defer func() {
+		if cerr := tx.CommitOrRollback(); err == nil && cerr != nil {
+			err = cerr
+		}
+	}()
+
+	mapper := func(rows *sqlx.Rows) error {
+		for rows.Next() {
+			var row exportRow
+
+			err := rows.StructScan(&row)
+			if err != nil {
+				return errors.Wrap(err, "failed to scan row into stream struct")
+			}
+
+			stream := row.toStream()
+			stream.Token = row.Token
+			stream.IngestSecret = row.IngestSecret
+
+			err = fn(stream)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	err = tx.Map(query, mapArgs, mapper)
+	if err != nil {
+		return errors.Wrap(err, "failed to export streams")
+	}
+
+	return nil
+}
+
+// ImportStream inserts a stream previously returned by ExportStreams, keeping
+// its id, token and ingest secret, and upserting its device. Returns
+// ErrStreamExists if a stream with the same id is already registered, or
+// ErrStreamConflict if the device is already registered within the stream's
+// community.
+func (d *DB) ImportStream(stream *Stream) (_ *Stream, err error) {
+	sql := `INSERT INTO devices
+		(device_token, longitude, latitude, exposure, device_label)
+	VALUES (:device_token, :longitude, :latitude, :exposure, :device_label)
+	ON CONFLICT (device_token) DO UPDATE
+	SET longitude = EXCLUDED.longitude,
+			latitude = EXCLUDED.latitude,
+			exposure = EXCLUDED.exposure,
+			device_label = EXCLUDED.device_label
+	RETURNING id`
+
+	mapArgs := map[string]interface{}{
+		"device_token": stream.Device.DeviceToken,
+		"longitude":    stream.Device.Longitude,
+		"latitude":     stream.Device.Latitude,
+		"exposure":     stream.Device.Exposure,
+		"device_label": stream.Device.Label,
+	}
+
+	tx, err := BeginTX(d.DB, "import_stream")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to start transaction when importing stream")
+	}
+
+	defer func() {
+		if cerr := tx.CommitOrRollback(); err == nil && cerr != nil {
+			err = cerr
+		}
+	}()
+
+	var deviceID int
+
+	err = tx.Get(&deviceID, sql, mapArgs)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to save device")
+	}
+
+	sql = `INSERT INTO streams
+	(device_id, community_id, public_key, token, operations, uuid, ` + streamSettingColumns + `, ingest_secret)
+	VALUES (:device_id, :community_id, :public_key, pgp_sym_encrypt(:token, :encryption_password), :operations, :uuid, ` + streamSettingParams + `,
+		CASE WHEN :ingest_secret = '' THEN NULL ELSE pgp_sym_encrypt(:ingest_secret, :encryption_password) END)`
+
+	mapArgs = map[string]interface{}{
+		"device_id":              deviceID,
+		"community_id":           stream.CommunityID,
+		"public_key":             stream.PublicKey,
+		"token":                  stream.Token,
+		"encryption_password":    d.encryptionKey(),
+		"operations":             stream.Operations,
+		"uuid":                   stream.StreamID,
+		"datastore_addr":         stream.DatastoreAddr,
+		"conversions":            stream.Conversions,
+		"source":                 stream.Source,
+		"compression":            stream.Compression,
+		"timestamp_policy":       stream.TimestampPolicy,
+		"policy_id":              stream.PolicyID,
+		"labels":                 stream.Labels,
+		"datastore_timeout":      stream.DatastoreTimeout,
+		"payload_schema":         stream.Schema,
+		"dispositions":           stream.Dispositions,
+		"privacy":                stream.Privacy,
+		"geo_privacy":            stream.GeoPrivacy,
+		"rate_limit":             stream.RateLimit,
+		"sampling":               stream.Sampling,
+		"schedule":               stream.Schedule,
+		"geofence":               stream.Geofence,
+		"public_key_fingerprint": stream.PublicKeyFingerprint,
+		"archive":                stream.Archive,
+		"priority":               stream.Priority,
+		"topic":                  stream.Topic,
+		"qos":                    stream.QoS,
+		"ingest_secret":          stream.IngestSecret,
+	}
+
+	err = tx.Exec(sql, mapArgs)
+	if err != nil {
+		if pqErr, ok := errors.Cause(err).(*pq.Error); ok && pqErr.Code == pqUniqueViolation {
+			if pqErr.Constraint == streamsUUIDIndex {
+				return nil, ErrStreamExists
+			}
+			return nil, ErrStreamConflict
+		}
+		return nil, errors.Wrap(err, "failed to import stream")
+	}
+
+	stream.Device.ID = deviceID
+
+	return stream, nil
+}
@@ -0,0 +1,292 @@
+package postgres
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+)
+
+// Heartbeat records that the given encoder instance is alive for the next ttl,
+// deleting the records of any instances whose heartbeat has expired. Returns
+// the number of instances currently alive, including this one. All times are
+// taken from the database server so that instances need not agree on the
+// time.
+func (d *DB) Heartbeat(instanceID string, ttl time.Duration) (_ int, err error) {
+	sql := `INSERT INTO encoder_instances (instance_id, expires_at)
+	VALUES (:instance_id, NOW() + make_interval(secs => :ttl))
+	ON CONFLICT (instance_id) DO UPDATE
+	SET expires_at = EXCLUDED.expires_at`
+
+	mapArgs := map[string]interface{}{
+		"instance_id": instanceID,
+		"ttl":         ttl.Seconds(),
+	}
+
+	tx, err := BeginTX(d.DB, "heartbeat")
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to start transaction when recording heartbeat")
+	}
+
+	defer func() {
+		if cerr := tx.CommitOrRollback(); err == nil && cerr != nil {
+			err = cerr
+		}
+	}()
+
+	err = tx.Exec(sql, mapArgs)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to record heartbeat")
+	}
+
+	err = tx.Exec(`DELETE FROM encoder_instances WHERE expires_at < NOW()`, map[string]interface{}{})
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to delete expired instances")
+	}
+
+	var count int
+
+	err = tx.Get(&count, `SELECT COUNT(*) FROM encoder_instances`, map[string]interface{}{})
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to count instances")
+	}
+
+	return count, nil
+}
+
+// RemoveInstance deletes the heartbeat record of the given instance along with
+// all leases it holds, so that other instances may take over its devices
+// without waiting for the leases to expire.
+func (d *DB) RemoveInstance(instanceID string) (err error) {
+	mapArgs := map[string]interface{}{
+		"instance_id": instanceID,
+	}
+
+	tx, err := BeginTX(d.DB, "remove_instance")
+	if err != nil {
+		return errors.Wrap(err, "failed to start transaction when removing instance")
+	}
+
+	defer func() {
+		if cerr := tx.CommitOrRollback(); err == nil && cerr != nil {
+			err = cerr
+		}
+	}()
+
+	err = tx.Exec(`DELETE FROM device_leases WHERE instance_id = :instance_id`, mapArgs)
+	if err != nil {
+		return errors.Wrap(err, "failed to delete leases")
+	}
+
+	err = tx.Exec(`DELETE FROM encoder_instances WHERE instance_id = :instance_id`, mapArgs)
+	if err != nil {
+		return errors.Wrap(err, "failed to delete instance")
+	}
+
+	return nil
+}
+
+// CountDevices returns the number of registered devices with at least one live
+// stream.
+func (d *DB) CountDevices() (_ int, err error) {
+	tx, err := BeginTX(d.DB, "count_devices")
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to begin transaction")
+	}
+
+	defer func() {
+		if cerr := tx.CommitOrRollback(); err == nil && cerr != nil {
+			err = cerr
+		}
+	}()
+
+	var count int
+
+	err = tx.Get(&count, `SELECT COUNT(*) FROM devices d
+	WHERE EXISTS (SELECT 1 FROM streams s WHERE s.device_id = d.id AND s.deleted_at IS NULL)`, map[string]interface{}{})
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to count devices")
+	}
+
+	return count, nil
+}
+
+// RenewLeases extends all leases held by the given instance by ttl, returning
+// the devices for which a lease is still held. A lease which expired and was
+// taken over by another instance is no longer held, so its device is not
+// returned.
+func (d *DB) RenewLeases(instanceID string, ttl time.Duration) ([]*Device, error) {
+	sql := `WITH renewed AS (
+		UPDATE device_leases
+		SET expires_at = NOW() + make_interval(secs => :ttl)
+		WHERE instance_id = :instance_id
+		RETURNING device_id
+	)
+	SELECT d.id, d.device_token
+	FROM devices d
+	JOIN renewed r ON r.device_id = d.id
+	ORDER BY d.id`
+
+	mapArgs := map[string]interface{}{
+		"instance_id": instanceID,
+		"ttl":         ttl.Seconds(),
+	}
+
+	devices, err := d.leaseDevices("renew_leases", sql, mapArgs)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to renew leases")
+	}
+
+	return devices, nil
+}
+
+// AcquireLeases acquires leases for up to limit devices which either have no
+// lease, or whose lease has expired, returning the devices acquired. Rows
+// locked by a concurrent acquisition are skipped, so two instances never
+// acquire the same device.
+func (d *DB) AcquireLeases(instanceID string, ttl time.Duration, limit int) ([]*Device, error) {
+	sql := `WITH candidates AS (
+		SELECT d.id
+		FROM devices d
+		LEFT JOIN device_leases l ON l.device_id = d.id
+		WHERE (l.device_id IS NULL OR l.expires_at < NOW())
+		AND EXISTS (SELECT 1 FROM streams s WHERE s.device_id = d.id AND s.deleted_at IS NULL)
+		ORDER BY d.id
+		LIMIT :limit
+		FOR UPDATE OF d SKIP LOCKED
+	), acquired AS (
+		INSERT INTO device_leases (device_id, instance_id, expires_at)
+		SELECT id, :instance_id, NOW() + make_interval(secs => :ttl)
+		FROM candidates
+		ON CONFLICT (device_id) DO UPDATE
+		SET instance_id = EXCLUDED.instance_id,
+				expires_at = EXCLUDED.expires_at
+		WHERE device_leases.expires_at < NOW()
+		RETURNING device_id
+	)
+	SELECT d.id, d.device_token
+	FROM devices d
+	JOIN acquired a ON a.device_id = d.id
+	ORDER BY d.id`
+
+	mapArgs := map[string]interface{}{
+		"instance_id": instanceID,
+		"ttl":         ttl.Seconds(),
+		"limit":       limit,
+	}
+
+	devices, err := d.leaseDevices("acquire_leases", sql, mapArgs)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to acquire leases")
+	}
+
+	return devices, nil
+}
+
+// AcquireLease attempts to acquire the lease for a single device, returning
+// true if the lease is now held by the given instance. A lease already held by
+// the instance is renewed.
+func (d *DB) AcquireLease(instanceID string, deviceToken secret.Secret, ttl time.Duration) (bool, error) {
+	sql := `WITH acquired AS (
+		INSERT INTO device_leases (device_id, instance_id, expires_at)
+		SELECT id, :instance_id, NOW() + make_interval(secs => :ttl)
+		FROM devices
+		WHERE device_token = :device_token
+		ON CONFLICT (device_id) DO UPDATE
+		SET instance_id = EXCLUDED.instance_id,
+				expires_at = EXCLUDED.expires_at
+		WHERE device_leases.expires_at < NOW()
+		OR device_leases.instance_id = EXCLUDED.instance_id
+		RETURNING device_id
+	)
+	SELECT d.id, d.device_token
+	FROM devices d
+	JOIN acquired a ON a.device_id = d.id`
+
+	mapArgs := map[string]interface{}{
+		"instance_id":  instanceID,
+		"device_token": deviceToken,
+		"ttl":          ttl.Seconds(),
+	}
+
+	devices, err := d.leaseDevices("acquire_lease", sql, mapArgs)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to acquire lease")
+	}
+
+	return len(devices) == 1, nil
+}
+
+// ReleaseLease releases the lease on the given device if it is held by the
+// given instance.
+func (d *DB) ReleaseLease(instanceID string, deviceToken secret.Secret) (err error) {
+	sql := `DELETE FROM device_leases l
+	USING devices d
+	WHERE l.device_id = d.id
+	AND d.device_token = :device_token
+	AND l.instance_id = :instance_id`
+
+	mapArgs := map[string]interface{}{
+		"instance_id":  instanceID,
+		"device_token": deviceToken,
+	}
+
+	tx, err := BeginTX(d.DB, "release_lease")
+	if err != nil {
+		return errors.Wrap(err, "failed to start transaction when releasing lease")
+	}
+
+	defer func() {
+		if cerr := tx.CommitOrRollback(); err == nil && cerr != nil {
+			err = cerr
+		}
+	}()
+
+	err = tx.Exec(sql, mapArgs)
+	if err != nil {
+		return errors.Wrap(err, "failed to release lease")
+	}
+
+	return nil
+}
+
+// leaseDevices runs the given lease query within a transaction recorded under
+// name, returning the devices selected by the query.
+func (d *DB) leaseDevices(name, sql string, mapArgs map[string]interface{}) (_ []*Device, err error) {
+	tx, err := BeginTX(d.DB, name)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to begin transaction")
+	}
+
+	defer func() {
+		if cerr := tx.CommitOrRollback(); err == nil && cerr != nil {
+			err = cerr
+		}
+	}()
+
+	devices := []*Device{}
+
+	mapper := func(rows *sqlx.Rows) error {
+		for rows.Next() {
+			var d Device
+
+			err = rows.StructScan(&d)
+			if err != nil {
+				return errors.Wrap(err, "failed to scan row into Device struct")
+			}
+
+			devices = append(devices, &d)
+		}
+
+		return nil
+	}
+
+	err = tx.Map(sql, mapArgs, mapper)
+	if err != nil {
+		return nil, err
+	}
+
+	return devices, nil
+}
@@ -0,0 +1,92 @@
+package postgres
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// QueryHistogram is a prometheus histogram vector recording the duration of
+	// each database operation, labelled by the name of the query. Operations made
+	// within a transaction are timed from its start until it is committed or
+	// rolled back.
+	QueryHistogram = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "db_query_duration_seconds",
+			Help:      "Duration of database queries in seconds, labelled by query",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"query"},
+	)
+
+	// QueryErrorsCounter is a prometheus counter vector recording a count of
+	// failed database operations, labelled by the name of the query. Queries
+	// which simply find no rows are not counted.
+	QueryErrorsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "db_query_errors",
+			Help:      "Count of failed database queries, labelled by query",
+		},
+		[]string{"query"},
+	)
+
+	// ConnectionsGauge is a prometheus gauge vector recording the number of
+	// connections in our pool, labelled by whether they are in use or idle.
+	ConnectionsGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "db_connections",
+			Help:      "Number of database connections, labelled by state",
+		},
+		[]string{"state"},
+	)
+
+	// WaitCountGauge is a prometheus gauge recording the total number of times
+	// we have waited for a connection from the pool.
+	WaitCountGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "db_wait_count",
+			Help:      "Total number of connections waited for",
+		},
+	)
+
+	// WaitDurationGauge is a prometheus gauge recording the total time we have
+	// spent waiting for connections from the pool.
+	WaitDurationGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "db_wait_duration_seconds",
+			Help:      "Total time in seconds spent waiting for database connections",
+		},
+	)
+)
+
+// observeQuery records the duration of the named query which started at the
+// given time, and counts it as failed if err is not nil.
+func observeQuery(name string, start time.Time, err error) {
+	QueryHistogram.WithLabelValues(name).Observe(time.Since(start).Seconds())
+
+	if err != nil && errors.Cause(err) != sql.ErrNoRows {
+		QueryErrorsCounter.WithLabelValues(name).Inc()
+	}
+}
+
+// recordPoolStats records the statistics of our connection pool.
+func recordPoolStats(stats sql.DBStats) {
+	ConnectionsGauge.WithLabelValues("open").Set(float64(stats.OpenConnections))
+	ConnectionsGauge.WithLabelValues("in_use").Set(float64(stats.InUse))
+	ConnectionsGauge.WithLabelValues("idle").Set(float64(stats.Idle))
+	WaitCountGauge.Set(float64(stats.WaitCount))
+	WaitDurationGauge.Set(stats.WaitDuration.Seconds())
+}
@@ -11,6 +11,7 @@ import (
 	kitlog "github.com/go-kit/kit/log"
 	"github.com/golang-migrate/migrate"
 	"github.com/golang-migrate/migrate/database/postgres"
+	"github.com/golang-migrate/migrate/source"
 	bindata "github.com/golang-migrate/migrate/source/go-bindata"
 	"github.com/pkg/errors"
 	"github.com/serenize/snaker"
@@ -71,6 +72,92 @@ func MigrateDownAll(db *sql.DB, logger kitlog.Logger) error {
 	return nil
 }
 
+// MigrateTo attempts to migrate Postgres to the given version, running up or
+// down migrations as required. It takes as parameters an sql.DB instance, the
+// target version, and a logger instance.
+func MigrateTo(db *sql.DB, version uint, logger kitlog.Logger) error {
+	logger.Log("msg", "migrating DB to version", "version", version)
+
+	m, err := getMigrator(db, logger)
+	if err != nil {
+		return errors.Wrap(err, "failed to create migrator")
+	}
+
+	err = m.Migrate(version)
+	if err != migrate.ErrNoChange {
+		return err
+	}
+
+	return nil
+}
+
+// MigrationVersion describes a single migration compiled into the binary, and
+// whether or not it has been applied to the database.
+type MigrationVersion struct {
+	Version uint
+	Name    string
+	Applied bool
+}
+
+// MigrationStatus describes the current migration state of the database.
+type MigrationStatus struct {
+	// Version is the current version of the database, or zero if no
+	// migrations have been applied.
+	Version uint
+
+	// Dirty is true if the last migration failed part way through and must be
+	// fixed manually.
+	Dirty bool
+
+	// Migrations lists all migrations compiled into the binary.
+	Migrations []*MigrationVersion
+}
+
+// GetMigrationStatus returns the current migration status of the database,
+// listing all known migrations and which of them have been applied.
+func GetMigrationStatus(db *sql.DB, logger kitlog.Logger) (*MigrationStatus, error) {
+	m, err := getMigrator(db, logger)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create migrator")
+	}
+
+	status := &MigrationStatus{
+		Migrations: []*MigrationVersion{},
+	}
+
+	status.Version, status.Dirty, err = m.Version()
+	if err != nil && err != migrate.ErrNilVersion {
+		return nil, errors.Wrap(err, "failed to read current migration version")
+	}
+
+	sourceDriver, err := getSourceDriver()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create migration source")
+	}
+
+	version, err := sourceDriver.First()
+	for err == nil {
+		r, name, rerr := sourceDriver.ReadUp(version)
+		if rerr == nil {
+			r.Close()
+		}
+
+		status.Migrations = append(status.Migrations, &MigrationVersion{
+			Version: version,
+			Name:    name,
+			Applied: version <= status.Version,
+		})
+
+		version, err = sourceDriver.Next(version)
+	}
+
+	if !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "failed to read migrations")
+	}
+
+	return status, nil
+}
+
 // NewMigration creates a new pair of files into which an SQL migration should
 // be written. All this is doing is ensuring files created are correctly named.
 func NewMigration(dirName, migrationName string, logger kitlog.Logger) error {
@@ -119,13 +206,7 @@ func getMigrator(db *sql.DB, logger kitlog.Logger) (*migrate.Migrate, error) {
 		return nil, err
 	}
 
-	source := bindata.Resource(migrations.AssetNames(),
-		func(name string) ([]byte, error) {
-			return migrations.Asset(name)
-		},
-	)
-
-	sourceDriver, err := bindata.WithInstance(source)
+	sourceDriver, err := getSourceDriver()
 	if err != nil {
 		return nil, err
 	}
@@ -145,6 +226,18 @@ func getMigrator(db *sql.DB, logger kitlog.Logger) (*migrate.Migrate, error) {
 	return migrator, nil
 }
 
+// getSourceDriver returns a source driver that reads migrations from our bindata
+// generated module.
+func getSourceDriver() (source.Driver, error) {
+	resource := bindata.Resource(migrations.AssetNames(),
+		func(name string) ([]byte, error) {
+			return migrations.Asset(name)
+		},
+	)
+
+	return bindata.WithInstance(resource)
+}
+
 // newLogAdapter simply wraps our gokit logger into our logAdapter type which
 // allows it to be used by go-migrate.
 func newLogAdapter(logger kitlog.Logger, verbose bool) migrate.Logger {
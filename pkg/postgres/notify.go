@@ -0,0 +1,124 @@
+package postgres
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+)
+
+const (
+	// StreamChangesChannel is the channel on which a notification carrying the
+	// id of a device is sent by a trigger whenever the device or its streams
+	// are changed, whether by an encoder or directly in the database.
+	StreamChangesChannel = "stream_changes"
+
+	// listenerPingInterval is the interval after which an idle listener checks
+	// that its connection is still alive, so that a lost connection is noticed
+	// and re-established.
+	listenerPingInterval = time.Minute
+)
+
+// StreamChange is a change made to a device or its streams. If Missed is true
+// the connection on which changes are received was lost and re-established,
+// so any change made in between may have been missed, and DeviceID is zero.
+type StreamChange struct {
+	DeviceID int
+	Missed   bool
+}
+
+// ListenStreamChanges listens on its own connection for changes made to
+// devices and their streams, sending each on the returned channel. The
+// connection is re-established if lost. Listening stops and the channel is
+// closed once stop is closed.
+func (d *DB) ListenStreamChanges(stop <-chan struct{}) (<-chan StreamChange, error) {
+	listener := pq.NewListener(d.connStr, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			d.logger.Log("err", err, "msg", "stream change listener connection failed")
+		}
+	})
+
+	err := listener.Listen(StreamChangesChannel)
+	if err != nil {
+		listener.Close()
+		return nil, errors.Wrap(err, "failed to listen for stream changes")
+	}
+
+	changes := make(chan StreamChange)
+
+	go func() {
+		defer close(changes)
+		defer listener.Close()
+
+		ticker := time.NewTicker(listenerPingInterval)
+		defer ticker.Stop()
+
+		for {
+			var change StreamChange
+
+			select {
+			case n := <-listener.Notify:
+				// a nil notification is sent after the connection is re-established
+				if n == nil {
+					change.Missed = true
+					break
+				}
+
+				id, err := strconv.Atoi(n.Extra)
+				if err != nil {
+					d.logger.Log("err", err, "msg", "invalid stream change notification")
+					continue
+				}
+
+				change.DeviceID = id
+			case <-ticker.C:
+				go listener.Ping()
+				continue
+			case <-stop:
+				return
+			}
+
+			select {
+			case changes <- change:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return changes, nil
+}
+
+// DeviceToken returns the token of the device with the given id, whether or
+// not it has any live streams. If no such device exists sql.ErrNoRows is
+// returned.
+func (d *DB) DeviceToken(deviceID int) (_ secret.Secret, err error) {
+	sql := `SELECT device_token FROM devices WHERE id = :id`
+
+	mapArgs := map[string]interface{}{
+		"id": deviceID,
+	}
+
+	tx, err := BeginTX(d.DB, "device_token")
+	if err != nil {
+		return "", errors.Wrap(err, "failed to start transaction when loading device token")
+	}
+
+	defer func() {
+		if cerr := tx.CommitOrRollback(); err == nil && cerr != nil {
+			err = cerr
+		}
+	}()
+
+	var deviceToken secret.Secret
+
+	err = tx.Get(&deviceToken, sql, mapArgs)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to load device token")
+	}
+
+	return deviceToken, nil
+}
@@ -0,0 +1,207 @@
+package postgres
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// OperationsVersion is the version of the document in which we currently store
+// the operations of a stream. Documents written with an earlier version are
+// upgraded as they are read, so that streams created before a change to the
+// format keep working, while all writes use the current version.
+//
+// Version 1 is a bare JSON array of operations. Version 2 wraps the array in
+// an object carrying the version, so the format may evolve from here.
+const OperationsVersion = 2
+
+// operationsDocument is the document in which operations are stored from
+// version 2.
+type operationsDocument struct {
+	Version    int          `json:"version"`
+	Operations []*Operation `json:"operations"`
+}
+
+// operationsUpgrades holds the functions which upgrade a stored document of
+// each version to the following version. Adding a version means incrementing
+// OperationsVersion and adding an upgrade from the previous version here.
+var operationsUpgrades = map[int]func(doc []byte) ([]byte, error){
+	1: upgradeOperationsV1,
+}
+
+// Validate returns an error if any operation is not valid for its action. All
+// operations are validated before being written, while those already stored
+// are read as they are.
+func (o Operations) Validate() error {
+	for i, op := range o {
+		if op == nil {
+			return errors.Errorf("operation %d is empty", i)
+		}
+
+		err := op.validate()
+		if err != nil {
+			return errors.Wrapf(err, "operation %d for sensor %d", i, op.SensorID)
+		}
+	}
+
+	return nil
+}
+
+// validate returns an error if the operation's fields are not those required
+// by its action.
+func (o *Operation) validate() error {
+	if o.SensorID == 0 {
+		return errors.New("requires a non-zero sensor id")
+	}
+
+	switch o.Action {
+	case Share:
+		if len(o.Bins) > 0 || o.Interval != 0 || o.Threshold != 0 {
+			return errors.New("sharing takes no bins, interval or threshold")
+		}
+	case Bin:
+		if len(o.Bins) == 0 {
+			return errors.New("binning requires a non-empty list of bins")
+		}
+	case MovingAverage:
+		if o.Interval == 0 {
+			return errors.New("moving average requires a non-zero interval")
+		}
+	case Downsample:
+		if o.Interval == 0 {
+			return errors.New("downsampling requires a non-zero interval")
+		}
+	case Delta:
+		if o.Threshold < 0 {
+			return errors.New("change-only threshold must not be negative")
+		}
+		if len(o.Bins) > 0 || o.Interval != 0 {
+			return errors.New("change-only sharing takes no bins or interval")
+		}
+	default:
+		return errors.Errorf("unknown action %q", o.Action)
+	}
+
+	return nil
+}
+
+// Value is our implementation of the sql.Valuer interface which converts the
+// instance into a value that can be written to the database. Operations are
+// validated, and written as a document of the current version.
+func (o Operations) Value() (driver.Value, error) {
+	err := o.Validate()
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid operations")
+	}
+
+	if o == nil {
+		o = Operations{}
+	}
+
+	return json.Marshal(&operationsDocument{
+		Version:    OperationsVersion,
+		Operations: o,
+	})
+}
+
+// Scan is our implementation of the sql.Scanner interface which takes the value
+// read from the database, and converts it back into an instance of the type.
+// Documents of earlier versions are upgraded to the current version, while
+// documents of a later version, written by a newer encoder, are an error.
+func (o *Operations) Scan(src interface{}) error {
+	if o == nil {
+		return nil
+	}
+
+	// streams created before operations were introduced have none
+	if src == nil {
+		*o = Operations{}
+		return nil
+	}
+
+	source, ok := src.([]byte)
+	if !ok {
+		return errors.New("Value read from database cannot be typecast to a byte slice")
+	}
+
+	ops, err := decodeOperations(source)
+	if err != nil {
+		return errors.Wrap(err, "failed to unmarshal bytes into Operations")
+	}
+
+	*o = ops
+
+	return nil
+}
+
+// decodeOperations returns the operations held in the given stored document,
+// upgrading it to the current version if necessary.
+func decodeOperations(doc []byte) (Operations, error) {
+	version, err := operationsDocumentVersion(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	if version > OperationsVersion {
+		return nil, errors.Errorf("operations version %d is newer than supported version %d", version, OperationsVersion)
+	}
+
+	for ; version < OperationsVersion; version++ {
+		doc, err = operationsUpgrades[version](doc)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to upgrade operations from version %d", version)
+		}
+	}
+
+	var d operationsDocument
+
+	err = json.Unmarshal(doc, &d)
+	if err != nil {
+		return nil, err
+	}
+
+	return Operations(d.Operations), nil
+}
+
+// operationsDocumentVersion returns the version of the given stored document.
+// Version 1 documents are arrays, or null, rather than objects so carry no
+// version of their own.
+func operationsDocumentVersion(doc []byte) (int, error) {
+	trimmed := bytes.TrimSpace(doc)
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return 1, nil
+	}
+
+	var d struct {
+		Version int `json:"version"`
+	}
+
+	err := json.Unmarshal(trimmed, &d)
+	if err != nil {
+		return 0, err
+	}
+
+	if d.Version < 2 {
+		return 0, errors.Errorf("invalid operations version %d", d.Version)
+	}
+
+	return d.Version, nil
+}
+
+// upgradeOperationsV1 upgrades a version 1 document, a bare array of
+// operations, to version 2 by wrapping the array.
+func upgradeOperationsV1(doc []byte) ([]byte, error) {
+	var ops []*Operation
+
+	err := json.Unmarshal(doc, &ops)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(&operationsDocument{
+		Version:    2,
+		Operations: ops,
+	})
+}
@@ -0,0 +1,127 @@
+package postgres_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+)
+
+func TestOperationsScan(t *testing.T) {
+	expected := postgres.Operations{
+		{SensorID: 12, Action: postgres.Share},
+		{SensorID: 14, Action: postgres.Bin, Bins: []float64{40, 80}},
+	}
+
+	testcases := []struct {
+		label string
+		src   interface{}
+	}{
+		{
+			label: "version 1",
+			src:   []byte(`[{"sensorId":12,"action":"SHARE"},{"sensorId":14,"action":"BIN","bins":[40,80]}]`),
+		},
+		{
+			label: "version 2",
+			src:   []byte(`{"version":2,"operations":[{"sensorId":12,"action":"SHARE"},{"sensorId":14,"action":"BIN","bins":[40,80]}]}`),
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			var ops postgres.Operations
+
+			err := ops.Scan(tc.src)
+			assert.Nil(t, err)
+			assert.Equal(t, expected, ops)
+		})
+	}
+
+	var ops postgres.Operations
+
+	err := ops.Scan(nil)
+	assert.Nil(t, err)
+	assert.Len(t, ops, 0)
+
+	err = ops.Scan([]byte(`{"version":3,"operations":[]}`))
+	assert.NotNil(t, err)
+	assert.Equal(t, "failed to unmarshal bytes into Operations: operations version 3 is newer than supported version 2", err.Error())
+}
+
+func TestOperationsValue(t *testing.T) {
+	ops := postgres.Operations{
+		{SensorID: 12, Action: postgres.MovingAverage, Interval: 300},
+	}
+
+	value, err := ops.Value()
+	assert.Nil(t, err)
+	assert.Equal(t, `{"version":2,"operations":[{"sensorId":12,"action":"MOVING_AVG","bins":null,"interval":300}]}`, string(value.([]byte)))
+
+	var scanned postgres.Operations
+
+	err = scanned.Scan(value)
+	assert.Nil(t, err)
+	assert.Equal(t, ops, scanned)
+}
+
+func TestOperationsValidate(t *testing.T) {
+	testcases := []struct {
+		label    string
+		ops      postgres.Operations
+		expected string
+	}{
+		{
+			label: "valid",
+			ops: postgres.Operations{
+				{SensorID: 12, Action: postgres.Share},
+				{SensorID: 13, Action: postgres.Bin, Bins: []float64{1}},
+				{SensorID: 14, Action: postgres.MovingAverage, Interval: 60},
+				{SensorID: 15, Action: postgres.Downsample, Interval: 60},
+				{SensorID: 16, Action: postgres.Delta, Threshold: 0.5},
+			},
+		},
+		{
+			label:    "missing sensor",
+			ops:      postgres.Operations{{Action: postgres.Share}},
+			expected: "operation 0 for sensor 0: requires a non-zero sensor id",
+		},
+		{
+			label:    "unknown action",
+			ops:      postgres.Operations{{SensorID: 12, Action: "MEDIAN"}},
+			expected: `operation 0 for sensor 12: unknown action "MEDIAN"`,
+		},
+		{
+			label:    "missing bins",
+			ops:      postgres.Operations{{SensorID: 12, Action: postgres.Bin}},
+			expected: "operation 0 for sensor 12: binning requires a non-empty list of bins",
+		},
+		{
+			label:    "missing interval",
+			ops:      postgres.Operations{{SensorID: 12, Action: postgres.Downsample}},
+			expected: "operation 0 for sensor 12: downsampling requires a non-zero interval",
+		},
+		{
+			label:    "unexpected fields",
+			ops:      postgres.Operations{{SensorID: 12, Action: postgres.Share, Interval: 60}},
+			expected: "operation 0 for sensor 12: sharing takes no bins, interval or threshold",
+		},
+		{
+			label:    "negative threshold",
+			ops:      postgres.Operations{{SensorID: 12, Action: postgres.Delta, Threshold: -1}},
+			expected: "operation 0 for sensor 12: change-only threshold must not be negative",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			err := tc.ops.Validate()
+			if tc.expected == "" {
+				assert.Nil(t, err)
+			} else {
+				assert.NotNil(t, err)
+				assert.Equal(t, tc.expected, err.Error())
+			}
+		})
+	}
+}
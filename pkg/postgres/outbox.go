@@ -0,0 +1,310 @@
+package postgres
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"gopkg.in/guregu/null.v3"
+
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+)
+
+// OutboxEntry is an encrypted payload waiting in the outbox to be written to
+// the datastore of its stream. Along with the payload it carries those fields
+// of the stream required to write it, so that it may be delivered even if the
+// stream is deleted in the meantime. DeliverAfter is null unless the payload is
+// being held until a time at which the stream shares its data.
+type OutboxEntry struct {
+	ID               int64         `db:"id"`
+	StreamID         string        `db:"stream_uid"`
+	CommunityID      string        `db:"community_id"`
+	DeviceToken      secret.Secret `db:"device_token"`
+	DatastoreAddr    string        `db:"datastore_addr"`
+	DatastoreTimeout string        `db:"datastore_timeout"`
+	Data             []byte        `db:"data"`
+	EnqueuedAt       time.Time     `db:"enqueued_at"`
+	DeliverAfter     null.Time     `db:"deliver_after"`
+	Attempts         int           `db:"attempts"`
+}
+
+// OutboxStatus summarises the entries of the outbox which are due to be
+// delivered but have yet to be. OldestEnqueuedAt is the time at which the
+// longest waiting of them became due, and is null if there are none. Dead is
+// the number of entries abandoned after exhausting their delivery attempts.
+type OutboxStatus struct {
+	Pending          int       `db:"pending"`
+	OldestEnqueuedAt null.Time `db:"oldest_enqueued_at"`
+	Dead             int       `db:"dead"`
+}
+
+// OutboxBatch is the outbox entries produced by processing a message, along
+// with the bookkeeping of that processing which must be written in the same
+// transaction, so that a crash either loses all of it or none. MessageKey is
+// optional, and if set is the key by which a redelivery of the message is
+// recognised as a duplicate once its payloads are queued. Encrypted holds the
+// number of bytes encrypted for each stream, which are added to its stats.
+type OutboxBatch struct {
+	Entries    []*OutboxEntry
+	MessageKey *MessageKey
+	Encrypted  map[string]int
+}
+
+// EnqueueOutbox writes the entries of the given batch to the outbox along with
+// its message key and stats within a single transaction, so that either all of
+// the payloads produced by processing a message are queued for delivery and
+// the message recorded as processed, or none are. Entries with a DeliverAfter
+// time are not attempted before then.
+func (d *DB) EnqueueOutbox(batch *OutboxBatch) (err error) {
+	sql := `INSERT INTO outbox
+		(stream_uid, community_id, device_token, datastore_addr, datastore_timeout, data, enqueued_at, deliver_after, next_attempt_at)
+	VALUES (:stream_uid, :community_id, :device_token, :datastore_addr, :datastore_timeout, :data, :enqueued_at, :deliver_after, COALESCE(:deliver_after, :enqueued_at))`
+
+	stmt, err := d.prepare(sql)
+	if err != nil {
+		return errors.Wrap(err, "failed to enqueue outbox entries")
+	}
+
+	tx, err := BeginTX(d.DB, "enqueue_outbox")
+	if err != nil {
+		return errors.Wrap(err, "failed to start transaction when enqueuing outbox entries")
+	}
+
+	defer func() {
+		if cerr := tx.CommitOrRollback(); err == nil && cerr != nil {
+			err = cerr
+		}
+	}()
+
+	for _, e := range batch.Entries {
+		mapArgs := map[string]interface{}{
+			"stream_uid":        e.StreamID,
+			"community_id":      e.CommunityID,
+			"device_token":      e.DeviceToken,
+			"datastore_addr":    e.DatastoreAddr,
+			"datastore_timeout": e.DatastoreTimeout,
+			"data":              e.Data,
+			"enqueued_at":       e.EnqueuedAt,
+			"deliver_after":     e.DeliverAfter,
+		}
+
+		err = tx.ExecStmt(stmt, mapArgs)
+		if err != nil {
+			return errors.Wrap(err, "failed to enqueue outbox entry")
+		}
+	}
+
+	// stats are otherwise saved as totals, to which the bytes encrypted for a
+	// stream are added here so that they match the payloads queued
+	sql = `INSERT INTO stream_stats (stream_uuid, bytes_encrypted)
+	SELECT uuid, :bytes_encrypted
+	FROM streams
+	WHERE uuid = :stream_uuid
+	ON CONFLICT (stream_uuid) DO UPDATE
+	SET bytes_encrypted = stream_stats.bytes_encrypted + EXCLUDED.bytes_encrypted,
+			updated_at = NOW()`
+
+	for streamID, n := range batch.Encrypted {
+		mapArgs := map[string]interface{}{
+			"stream_uuid":     streamID,
+			"bytes_encrypted": n,
+		}
+
+		err = tx.Exec(sql, mapArgs)
+		if err != nil {
+			return errors.Wrap(err, "failed to add to stream stats")
+		}
+	}
+
+	if batch.MessageKey != nil {
+		sql = `INSERT INTO message_keys (key, seen_at)
+		VALUES (:key, :seen_at)
+		ON CONFLICT (key) DO UPDATE
+		SET seen_at = LEAST(message_keys.seen_at, EXCLUDED.seen_at)`
+
+		mapArgs := map[string]interface{}{
+			"key":     batch.MessageKey.Key,
+			"seen_at": batch.MessageKey.SeenAt,
+		}
+
+		err = tx.Exec(sql, mapArgs)
+		if err != nil {
+			return errors.Wrap(err, "failed to save message key")
+		}
+	}
+
+	return nil
+}
+
+// ClaimOutbox returns up to limit undelivered entries which are due to be
+// attempted at the given time, oldest first. Each entry claimed has its
+// attempts incremented and is not due again until retryAt, so that it is
+// retried then should it not be marked as delivered, while other dispatchers
+// sharing the database skip it in the meantime. If maxAttempts is greater than
+// zero, entries which are due again having already been attempted that many
+// times are instead marked as dead, and are kept but never attempted again.
+func (d *DB) ClaimOutbox(now, retryAt time.Time, limit, maxAttempts int) (_ []*OutboxEntry, err error) {
+	deadSQL := `UPDATE outbox
+	SET dead_at = :now
+	WHERE delivered_at IS NULL
+	AND dead_at IS NULL
+	AND attempts >= :max_attempts
+	AND next_attempt_at <= :now`
+
+	sql := `UPDATE outbox
+	SET attempts = attempts + 1, next_attempt_at = :retry_at
+	WHERE id IN (
+		SELECT id FROM outbox
+		WHERE delivered_at IS NULL
+		AND dead_at IS NULL
+		AND next_attempt_at <= :now
+		ORDER BY id
+		LIMIT :limit
+		FOR UPDATE SKIP LOCKED
+	)
+	RETURNING id, stream_uid, community_id, device_token, datastore_addr,
+		datastore_timeout, data, enqueued_at, attempts`
+
+	mapArgs := map[string]interface{}{
+		"now":          now,
+		"retry_at":     retryAt,
+		"limit":        limit,
+		"max_attempts": maxAttempts,
+	}
+
+	tx, err := BeginTX(d.DB, "claim_outbox")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to begin transaction")
+	}
+
+	defer func() {
+		if cerr := tx.CommitOrRollback(); err == nil && cerr != nil {
+			err = cerr
+		}
+	}()
+
+	if maxAttempts > 0 {
+		err = tx.Exec(deadSQL, mapArgs)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to mark exhausted outbox entries dead")
+		}
+	}
+
+	entries := []*OutboxEntry{}
+
+	mapper := func(rows *sqlx.Rows) error {
+		for rows.Next() {
+			var e OutboxEntry
+
+			err = rows.StructScan(&e)
+			if err != nil {
+				return errors.Wrap(err, "failed to scan row into OutboxEntry struct")
+			}
+
+			entries = append(entries, &e)
+		}
+
+		return nil
+	}
+
+	err = tx.Map(sql, mapArgs, mapper)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to claim outbox entries")
+	}
+
+	return entries, nil
+}
+
+// MarkOutboxDelivered marks the outbox entry with the given id as delivered at
+// the given time, so that it is not attempted again.
+func (d *DB) MarkOutboxDelivered(id int64, deliveredAt time.Time) (err error) {
+	sql := `UPDATE outbox SET delivered_at = :delivered_at WHERE id = :id`
+
+	mapArgs := map[string]interface{}{
+		"id":           id,
+		"delivered_at": deliveredAt,
+	}
+
+	tx, err := BeginTX(d.DB, "mark_outbox_delivered")
+	if err != nil {
+		return errors.Wrap(err, "failed to start transaction when marking outbox entry delivered")
+	}
+
+	defer func() {
+		if cerr := tx.CommitOrRollback(); err == nil && cerr != nil {
+			err = cerr
+		}
+	}()
+
+	err = tx.Exec(sql, mapArgs)
+	if err != nil {
+		return errors.Wrap(err, "failed to mark outbox entry delivered")
+	}
+
+	return nil
+}
+
+// GetOutboxStatus returns the number of undelivered entries in the outbox which
+// are due to be delivered, when the oldest of them became due, and the number
+// of dead entries. Entries held until a later time are not counted until then.
+func (d *DB) GetOutboxStatus() (_ *OutboxStatus, err error) {
+	sql := `SELECT
+		COUNT(*) FILTER (WHERE dead_at IS NULL) AS pending,
+		MIN(COALESCE(deliver_after, enqueued_at)) FILTER (WHERE dead_at IS NULL) AS oldest_enqueued_at,
+		COUNT(*) FILTER (WHERE dead_at IS NOT NULL) AS dead
+	FROM outbox
+	WHERE delivered_at IS NULL
+	AND (deliver_after IS NULL OR deliver_after <= NOW())`
+
+	tx, err := BeginTX(d.DB, "get_outbox_status")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to begin transaction")
+	}
+
+	defer func() {
+		if cerr := tx.CommitOrRollback(); err == nil && cerr != nil {
+			err = cerr
+		}
+	}()
+
+	var status OutboxStatus
+
+	err = tx.Get(&status, sql, map[string]interface{}{})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read outbox status")
+	}
+
+	return &status, nil
+}
+
+// PruneOutbox deletes all outbox entries delivered before the given time,
+// returning the number of entries deleted.
+func (d *DB) PruneOutbox(before time.Time) (_ int64, err error) {
+	sql := `WITH deleted AS (
+		DELETE FROM outbox WHERE delivered_at < :before RETURNING id
+	) SELECT COUNT(*) FROM deleted`
+
+	mapArgs := map[string]interface{}{
+		"before": before,
+	}
+
+	tx, err := BeginTX(d.DB, "prune_outbox")
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to begin transaction")
+	}
+
+	defer func() {
+		if cerr := tx.CommitOrRollback(); err == nil && cerr != nil {
+			err = cerr
+		}
+	}()
+
+	var deleted int64
+
+	err = tx.Get(&deleted, sql, mapArgs)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to delete delivered outbox entries")
+	}
+
+	return deleted, nil
+}
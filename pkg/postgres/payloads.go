@@ -0,0 +1,211 @@
+package postgres
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// RawPayload is a type used when reading retained device payloads back from the
+// DB. Payloads are stored exactly as received from the broker, but encrypted at
+// rest using our encryption password.
+type RawPayload struct {
+	ID          int64     `db:"id"`
+	DeviceToken string    `db:"device_token"`
+	ReceivedAt  time.Time `db:"received_at"`
+	Payload     []byte    `db:"payload"`
+}
+
+// SaveRawPayload writes a raw device payload to the retention table. The
+// payload is symmetrically encrypted before being written.
+func (d *DB) SaveRawPayload(deviceToken string, receivedAt time.Time, payload []byte) (err error) {
+	sql := `INSERT INTO raw_payloads
+		(device_token, received_at, payload)
+	VALUES (:device_token, :received_at, pgp_sym_encrypt_bytea(:payload, :encryption_password))`
+
+	mapArgs := map[string]interface{}{
+		"device_token":        deviceToken,
+		"received_at":         receivedAt,
+		"payload":             payload,
+		"encryption_password": d.encryptionKey(),
+	}
+
+	stmt, err := d.prepare(sql)
+	if err != nil {
+		return errors.Wrap(err, "failed to save raw payload")
+	}
+
+	tx, err := BeginTX(d.DB, "save_raw_payload")
+	if err != nil {
+		return errors.Wrap(err, "failed to start transaction when saving raw payload")
+	}
+
+	defer func() {
+		if cerr := tx.CommitOrRollback(); err == nil && cerr != nil {
+			err = cerr
+		}
+	}()
+
+	err = tx.ExecStmt(stmt, mapArgs)
+	if err != nil {
+		return errors.Wrap(err, "failed to save raw payload")
+	}
+
+	return nil
+}
+
+// CountRawPayloads returns the number of retained payloads for the given device
+// received within the given time interval.
+func (d *DB) CountRawPayloads(deviceToken string, start, end time.Time) (_ int, err error) {
+	sql := `SELECT COUNT(*) FROM raw_payloads
+	WHERE device_token = :device_token
+	AND received_at >= :start_time
+	AND received_at < :end_time`
+
+	mapArgs := map[string]interface{}{
+		"device_token": deviceToken,
+		"start_time":   start,
+		"end_time":     end,
+	}
+
+	tx, err := BeginTX(d.DB, "count_raw_payloads")
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to begin transaction")
+	}
+
+	defer func() {
+		if cerr := tx.CommitOrRollback(); err == nil && cerr != nil {
+			err = cerr
+		}
+	}()
+
+	var count int
+
+	err = tx.Get(&count, sql, mapArgs)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to count raw payloads")
+	}
+
+	return count, nil
+}
+
+// GetRawPayloads returns a page of retained payloads for the given device
+// received within the given time interval, ordered by insertion. Pages are
+// requested by passing the ID of the last payload of the previous page (or
+// zero for the first page), and the maximum number of payloads to return.
+func (d *DB) GetRawPayloads(deviceToken string, start, end time.Time, afterID int64, limit int) (_ []*RawPayload, err error) {
+	sql := `SELECT id, device_token, received_at,
+		pgp_sym_decrypt_bytea(payload, :encryption_password) AS payload
+	FROM raw_payloads
+	WHERE device_token = :device_token
+	AND received_at >= :start_time
+	AND received_at < :end_time
+	AND id > :after_id
+	ORDER BY id
+	LIMIT :limit`
+
+	mapArgs := map[string]interface{}{
+		"device_token":        deviceToken,
+		"start_time":          start,
+		"end_time":            end,
+		"after_id":            afterID,
+		"limit":               limit,
+		"encryption_password": d.encryptionKey(),
+	}
+
+	tx, err := BeginTX(d.DB, "get_raw_payloads")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to begin transaction")
+	}
+
+	defer func() {
+		if cerr := tx.CommitOrRollback(); err == nil && cerr != nil {
+			err = cerr
+		}
+	}()
+
+	payloads := []*RawPayload{}
+
+	mapper := func(rows *sqlx.Rows) error {
+		for rows.Next() {
+			var p RawPayload
+
+			err = rows.StructScan(&p)
+			if err != nil {
+				return errors.Wrap(err, "failed to scan row into RawPayload struct")
+			}
+
+			payloads = append(payloads, &p)
+		}
+
+		return nil
+	}
+
+	err = tx.Map(sql, mapArgs, mapper)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to select raw payloads from database")
+	}
+
+	return payloads, nil
+}
+
+// PruneRawPayloads deletes all retained payloads received before the given
+// time. If maxSize is greater than zero, we then delete the oldest remaining
+// payloads until the total size of retained payloads is no more than maxSize
+// bytes. Returns the number of payloads deleted.
+func (d *DB) PruneRawPayloads(before time.Time, maxSize int64) (_ int64, err error) {
+	sql := `WITH deleted AS (
+		DELETE FROM raw_payloads WHERE received_at < :before RETURNING id
+	) SELECT COUNT(*) FROM deleted`
+
+	mapArgs := map[string]interface{}{
+		"before": before,
+	}
+
+	tx, err := BeginTX(d.DB, "prune_raw_payloads")
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to begin transaction")
+	}
+
+	defer func() {
+		if cerr := tx.CommitOrRollback(); err == nil && cerr != nil {
+			err = cerr
+		}
+	}()
+
+	var expired int64
+
+	err = tx.Get(&expired, sql, mapArgs)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to delete expired raw payloads")
+	}
+
+	if maxSize <= 0 {
+		return expired, nil
+	}
+
+	// keep the newest payloads whose cumulative size fits within maxSize
+	sql = `WITH deleted AS (
+		DELETE FROM raw_payloads WHERE id IN (
+			SELECT id FROM (
+				SELECT id, SUM(octet_length(payload)) OVER (ORDER BY id DESC) AS total
+				FROM raw_payloads
+			) sized
+			WHERE total > :max_size
+		) RETURNING id
+	) SELECT COUNT(*) FROM deleted`
+
+	mapArgs = map[string]interface{}{
+		"max_size": maxSize,
+	}
+
+	var evicted int64
+
+	err = tx.Get(&evicted, sql, mapArgs)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to delete oversize raw payloads")
+	}
+
+	return expired + evicted, nil
+}
@@ -5,6 +5,8 @@ import (
 	"database/sql"
 	"database/sql/driver"
 	"encoding/json"
+	"strings"
+	"sync"
 	"time"
 
 	kitlog "github.com/go-kit/kit/log"
@@ -14,6 +16,9 @@ import (
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"golang.org/x/crypto/acme/autocert"
+
+	"github.com/DECODEproject/iotencoder/pkg/pubkey"
+	"github.com/DECODEproject/iotencoder/pkg/secret"
 )
 
 var (
@@ -41,6 +46,15 @@ const (
 	// MovingAverage defines an action of sharing a moving average for a sensor
 	MovingAverage Action = "MOVING_AVG"
 
+	// Downsample defines an action of sharing a sensor without processing, but
+	// forwarding at most one reading per interval
+	Downsample Action = "DOWNSAMPLE"
+
+	// Delta defines an action of sharing a sensor without processing, but only
+	// forwarding readings which differ from the last forwarded reading by more
+	// than a threshold
+	Delta Action = "DELTA"
+
 	// TokenLength is a constant which controls the length in bytes of the security
 	// tokens we generate for streams.
 	TokenLength = 24
@@ -50,16 +64,26 @@ const (
 	pqUniqueViolation = "23505"
 )
 
+var (
+	// ErrStreamNotFound is returned when a requested stream does not exist, or
+	// the supplied token does not match the stream.
+	ErrStreamNotFound = errors.New("stream not found")
+
+	// ErrStreamConflict is returned when a deleted stream cannot be restored as
+	// its device has since been registered again within the same community.
+	ErrStreamConflict = errors.New("device already registered within community")
+)
+
 // Device is a type used when reading data back from the DB. A single Device may
 // feed data to multiple streams, hence the separation here with the associated
 // Stream type.
 type Device struct {
-	ID          int     `db:"id"`
-	DeviceToken string  `db:"device_token"`
-	Label       string  `db:"device_label"`
-	Longitude   float64 `db:"longitude"`
-	Latitude    float64 `db:"latitude"`
-	Exposure    string  `db:"exposure"`
+	ID          int           `db:"id"`
+	DeviceToken secret.Secret `db:"device_token"`
+	Label       string        `db:"device_label"`
+	Longitude   float64       `db:"longitude"`
+	Latitude    float64       `db:"latitude"`
+	Exposure    string        `db:"exposure"`
 
 	Streams []*Stream
 }
@@ -72,8 +96,113 @@ type Stream struct {
 	PublicKey   string     `db:"public_key"`
 	Operations  Operations `db:"operations"`
 
-	StreamID string
-	Token    string
+	// PublicKeyFingerprint is the fingerprint of PublicKey computed by
+	// pubkey.Fingerprint, with which operators may confirm the key to which
+	// the stream's data is encrypted.
+	PublicKeyFingerprint string `db:"public_key_fingerprint"`
+
+	// DatastoreAddr is the address of the datastore to which data for this
+	// stream is written. If empty the encoder's default datastore is used.
+	DatastoreAddr string `db:"datastore_addr"`
+
+	// Conversions holds the expressions used to convert raw readings of this
+	// stream's sensors into engineering units, keyed by sensor id.
+	Conversions Conversions `db:"conversions"`
+
+	// Source is the name of the source from which readings for this stream are
+	// received. If empty the encoder's default source is used.
+	Source string `db:"source"`
+
+	// Compression is the content encoding with which the stream's payloads are
+	// compressed before encryption. If empty payloads are not compressed.
+	Compression string `db:"compression"`
+
+	// TimestampPolicy is how the timestamps of the stream's readings are
+	// handled, in the form parsed by timestamp.Parse. If empty the timestamp
+	// sent by the device is trusted.
+	TimestampPolicy string `db:"timestamp_policy"`
+
+	// PolicyID is the identifier of the policy in the DECODE policy store from
+	// which the stream's public key was resolved. If empty the public key was
+	// supplied directly when the stream was created.
+	PolicyID string `db:"policy_id"`
+
+	// Labels are arbitrary key/value pairs given when the stream was created,
+	// by which streams may be selected when listed and metrics sliced.
+	Labels Labels `db:"labels"`
+
+	// DatastoreTimeout is the deadline applied to each write of the stream's
+	// data to its datastore, as a duration parsed by time.ParseDuration. If
+	// empty the encoder's default timeout is used.
+	DatastoreTimeout string `db:"datastore_timeout"`
+
+	// Schema is the reference, written as kind@version, to the payload schema
+	// describing the plaintext of the stream's data, against which readings
+	// are validated before being encrypted. It is empty if the stream
+	// references no schema.
+	Schema string `db:"payload_schema"`
+
+	// Dispositions holds what happens to the readings of each sensor, which
+	// are either encrypted, dropped, or written in plain alongside the
+	// encrypted data. Sensors without a disposition are encrypted.
+	Dispositions Dispositions `db:"dispositions"`
+
+	// Privacy is the differential privacy configuration of the stream, in the
+	// form parsed by privacy.Parse, with which noise is injected into the
+	// stream's moving averages. If empty no noise is injected.
+	Privacy string `db:"privacy"`
+
+	// GeoPrivacy is the geo-privacy configuration of the stream, in the form
+	// parsed by geoprivacy.Parse, with which the device's location is snapped
+	// to a grid or suppressed. If empty the location is written as given.
+	GeoPrivacy string `db:"geo_privacy"`
+
+	// RateLimit is the maximum rate at which messages are processed for the
+	// stream, in the form parsed by throttle.Parse. Messages arriving faster
+	// are skipped. If empty the stream is not throttled.
+	RateLimit string `db:"rate_limit"`
+
+	// Sampling is the percentage of the stream's messages which are written,
+	// in the form parsed by sampling.Parse, the rest being discarded. If empty
+	// every message is written.
+	Sampling string `db:"sampling"`
+
+	// Schedule restricts the times of day at which the stream's data is
+	// shared, in the form parsed by schedule.Parse. If empty data is shared at
+	// any time.
+	Schedule string `db:"schedule"`
+
+	// Geofence is the area within which the readings of a mobile device must
+	// be reported for the stream to share them, in the form parsed by
+	// geofence.Parse. If empty readings are shared wherever they are reported.
+	Geofence string `db:"geofence"`
+
+	// Archive is true if the stream's encrypted payloads are written to our
+	// archival object store, if we have one, as well as to the datastore.
+	Archive bool `db:"archive"`
+
+	// Priority is the priority of the stream when the encoder is saturated and
+	// sheds load, one of the names accepted by shedding.Parse. If empty the
+	// stream has normal priority.
+	Priority string `db:"priority"`
+
+	// Topic is the template of the MQTT topic on which the stream's device
+	// publishes, which may contain mqtt.TokenPlaceholder in place of the device
+	// token. If empty the device publishes on its default topic.
+	Topic string `db:"topic"`
+
+	// QoS is the MQTT quality of service at which we subscribed to the stream's
+	// device when the stream was created. It is zero for streams whose source
+	// has no topics.
+	QoS int `db:"qos"`
+
+	// IngestSecret is the shared secret used to verify the signature of
+	// payloads pushed over HTTP for this stream. It is only loaded by GetDevice,
+	// and is empty if HTTP push is not enabled for the stream.
+	IngestSecret secret.Secret `db:"ingest_secret"`
+
+	StreamID string `db:"uuid"`
+	Token    secret.Secret
 
 	Device *Device
 }
@@ -81,27 +210,36 @@ type Stream struct {
 // Operation is a type used to capture the data around the operations to be
 // applied to a Stream.
 type Operation struct {
-	SensorID uint32    `json:"sensorId"`
-	Action   Action    `json:"action"`
-	Bins     []float64 `json:"bins"`
-	Interval uint32    `json:"interval"`
+	SensorID  uint32    `json:"sensorId"`
+	Action    Action    `json:"action"`
+	Bins      []float64 `json:"bins"`
+	Interval  uint32    `json:"interval"`
+	Threshold float64   `json:"threshold,omitempty"`
 }
 
 // Operations is a type alias for a slice of Operation instance. We add as a
 // separate type as we implement sql.Valuer and sql.Scanner interfaces to read
-// and write back from the DB.
+// and write back from the DB, storing them as a versioned document.
 type Operations []*Operation
 
+// Conversions maps sensor ids to the conversion expression applied to readings
+// of that sensor before any operations are applied. As with Operations we
+// implement sql.Valuer and sql.Scanner so the map is stored as JSON.
+type Conversions map[uint32]string
+
 // Value is our implementation of the sql.Valuer interface which converts the
 // instance into a value that can be written to the database.
-func (o Operations) Value() (driver.Value, error) {
-	return json.Marshal(o)
+func (c Conversions) Value() (driver.Value, error) {
+	if c == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(c)
 }
 
 // Scan is our implementation of the sql.Scanner interface which takes the value
 // read from the database, and converts it back into an instance of the type.
-func (o *Operations) Scan(src interface{}) error {
-	if o == nil {
+func (c *Conversions) Scan(src interface{}) error {
+	if c == nil {
 		return nil
 	}
 
@@ -110,9 +248,43 @@ func (o *Operations) Scan(src interface{}) error {
 		return errors.New("Value read from database cannot be typecast to a byte slice")
 	}
 
-	err := json.Unmarshal(source, &o)
+	err := json.Unmarshal(source, c)
 	if err != nil {
-		return errors.Wrap(err, "failed to unmarshal bytes into Operations")
+		return errors.Wrap(err, "failed to unmarshal bytes into Conversions")
+	}
+
+	return nil
+}
+
+// Labels maps the keys of a stream's labels to their values. As with
+// Conversions we implement sql.Valuer and sql.Scanner so the map is stored as
+// JSON.
+type Labels map[string]string
+
+// Value is our implementation of the sql.Valuer interface which converts the
+// instance into a value that can be written to the database.
+func (l Labels) Value() (driver.Value, error) {
+	if l == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(l)
+}
+
+// Scan is our implementation of the sql.Scanner interface which takes the value
+// read from the database, and converts it back into an instance of the type.
+func (l *Labels) Scan(src interface{}) error {
+	if l == nil {
+		return nil
+	}
+
+	source, ok := src.([]byte)
+	if !ok {
+		return errors.New("Value read from database cannot be typecast to a byte slice")
+	}
+
+	err := json.Unmarshal(source, l)
+	if err != nil {
+		return errors.Wrap(err, "failed to unmarshal bytes into Labels")
 	}
 
 	return nil
@@ -129,15 +301,18 @@ func Open(connStr string) (*sqlx.DB, error) {
 // data access functions we require.
 type DB struct {
 	connStr            string
-	encryptionPassword []byte
+	encryptionPassword secret.Secret
 	DB                 *sqlx.DB
 	logger             kitlog.Logger
+
+	mu    sync.Mutex
+	stmts map[string]*sqlx.NamedStmt
 }
 
 // Config is used to carry package local configuration for Postgres DB module.
 type Config struct {
 	ConnStr            string
-	EncryptionPassword string
+	EncryptionPassword secret.Secret
 }
 
 // NewDB creates a new DB instance with the given connection string. We also
@@ -147,11 +322,18 @@ func NewDB(config *Config, logger kitlog.Logger) *DB {
 
 	return &DB{
 		connStr:            config.ConnStr,
-		encryptionPassword: []byte(config.EncryptionPassword),
+		encryptionPassword: config.EncryptionPassword,
 		logger:             logger,
+		stmts:              make(map[string]*sqlx.NamedStmt),
 	}
 }
 
+// encryptionKey returns the encryption password as it is passed to pgcrypto to
+// encrypt and decrypt stream tokens and ingest secrets.
+func (d *DB) encryptionKey() []byte {
+	return []byte(d.encryptionPassword.Reveal())
+}
+
 // Start creates our DB connection pool running returning an error if any
 // failure occurs.
 func (d *DB) Start() error {
@@ -173,9 +355,38 @@ func (d *DB) Start() error {
 func (d *DB) Stop() error {
 	d.logger.Log("msg", "stopping postgres client")
 
+	d.mu.Lock()
+	for query, stmt := range d.stmts {
+		stmt.Close()
+		delete(d.stmts, query)
+	}
+	d.mu.Unlock()
+
 	return d.DB.Close()
 }
 
+// prepare returns a prepared statement for the given named query, which is
+// prepared the first time it is requested and then reused. Statements are
+// prepared lazily as the tables they refer to may not exist until migrations
+// have run. We use these for the queries executed for every message or flush.
+func (d *DB) prepare(query string) (*sqlx.NamedStmt, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if stmt, ok := d.stmts[query]; ok {
+		return stmt, nil
+	}
+
+	stmt, err := d.DB.PrepareNamed(query)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to prepare statement")
+	}
+
+	d.stmts[query] = stmt
+
+	return stmt, nil
+}
+
 // CreateStream attempts to insert records into the database for the given
 // Stream object. Returns a string containing the ID of the created stream if
 // successful or an error if any data constraint is violated, or any other error
@@ -199,7 +410,7 @@ func (d *DB) CreateStream(stream *Stream) (_ *Stream, err error) {
 		"device_label": stream.Device.Label,
 	}
 
-	tx, err := BeginTX(d.DB)
+	tx, err := BeginTX(d.DB, "create_stream")
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to start transaction when inserting device")
 	}
@@ -225,8 +436,8 @@ func (d *DB) CreateStream(stream *Stream) (_ *Stream, err error) {
 
 	// streams insert sql
 	sql = `INSERT INTO streams
-	(device_id, community_id, public_key, token, operations, uuid)
-	VALUES (:device_id, :community_id, :public_key, pgp_sym_encrypt(:token, :encryption_password), :operations, :uuid)`
+	(device_id, community_id, public_key, token, operations, uuid, ` + streamSettingColumns + `)
+	VALUES (:device_id, :community_id, :public_key, pgp_sym_encrypt(:token, :encryption_password), :operations, :uuid, ` + streamSettingParams + `)`
 
 	token, err := GenerateToken(TokenLength)
 	if err != nil {
@@ -234,13 +445,34 @@ func (d *DB) CreateStream(stream *Stream) (_ *Stream, err error) {
 	}
 
 	mapArgs = map[string]interface{}{
-		"device_id":           deviceID,
-		"community_id":        stream.CommunityID,
-		"public_key":          stream.PublicKey,
-		"token":               token,
-		"encryption_password": d.encryptionPassword,
-		"operations":          stream.Operations,
-		"uuid":                streamID.String(),
+		"device_id":              deviceID,
+		"community_id":           stream.CommunityID,
+		"public_key":             stream.PublicKey,
+		"token":                  token,
+		"encryption_password":    d.encryptionKey(),
+		"operations":             stream.Operations,
+		"uuid":                   streamID.String(),
+		"datastore_addr":         stream.DatastoreAddr,
+		"conversions":            stream.Conversions,
+		"source":                 stream.Source,
+		"compression":            stream.Compression,
+		"timestamp_policy":       stream.TimestampPolicy,
+		"policy_id":              stream.PolicyID,
+		"labels":                 stream.Labels,
+		"datastore_timeout":      stream.DatastoreTimeout,
+		"payload_schema":         stream.Schema,
+		"dispositions":           stream.Dispositions,
+		"privacy":                stream.Privacy,
+		"geo_privacy":            stream.GeoPrivacy,
+		"rate_limit":             stream.RateLimit,
+		"sampling":               stream.Sampling,
+		"schedule":               stream.Schedule,
+		"geofence":               stream.Geofence,
+		"public_key_fingerprint": stream.PublicKeyFingerprint,
+		"archive":                stream.Archive,
+		"priority":               stream.Priority,
+		"topic":                  stream.Topic,
+		"qos":                    stream.QoS,
 	}
 
 	err = tx.Exec(sql, mapArgs)
@@ -254,28 +486,32 @@ func (d *DB) CreateStream(stream *Stream) (_ *Stream, err error) {
 	}
 
 	stream.StreamID = streamID.String()
-	stream.Token = token
+	stream.Token = secret.Secret(token)
 
 	return stream, err
 }
 
-// DeleteStream deletes a stream identified by the given id string. If this
-// stream is the last one associated with a device, then the device record is
-// also deleted. We return a Device object purely so we can pass back out the
-// token allowing us to unsubscribe.
+// DeleteStream soft deletes a stream identified by the given id string. The
+// stream is marked as deleted rather than removed, so that it may be restored
+// with RestoreStream until it is purged by PurgeDeletedStreams. If this stream
+// is the last live one associated with a device we return the device, purely
+// so we can pass back out the token allowing us to unsubscribe. The device
+// record itself is kept until its streams are purged.
 func (d *DB) DeleteStream(stream *Stream) (_ *Device, err error) {
-	sql := `DELETE FROM streams
+	sql := `UPDATE streams
+	SET deleted_at = NOW()
 	WHERE uuid = :uuid
 	AND pgp_sym_decrypt(token, :encryption_password) = :token
+	AND deleted_at IS NULL
 	RETURNING device_id`
 
 	mapArgs := map[string]interface{}{
 		"uuid":                stream.StreamID,
-		"encryption_password": d.encryptionPassword,
+		"encryption_password": d.encryptionKey(),
 		"token":               stream.Token,
 	}
 
-	tx, err := BeginTX(d.DB)
+	tx, err := BeginTX(d.DB, "delete_stream")
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to start transaction when deleting stream")
 	}
@@ -288,15 +524,15 @@ func (d *DB) DeleteStream(stream *Stream) (_ *Device, err error) {
 
 	var deviceID int
 
-	// again use a Get to run the delete so we get back the device's id
+	// again use a Get to run the update so we get back the device's id
 	err = tx.Get(&deviceID, sql, mapArgs)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to delete stream")
 	}
 
-	// now we count streams for that device id, and if no more we should also
-	// delete the device and unsubscribe from its topic
-	sql = `SELECT COUNT(*) FROM streams WHERE device_id = :device_id`
+	// now we count live streams for that device id, and if no more we should
+	// unsubscribe from its topic
+	sql = `SELECT COUNT(*) FROM streams WHERE device_id = :device_id AND deleted_at IS NULL`
 
 	mapArgs = map[string]interface{}{
 		"device_id": deviceID,
@@ -311,8 +547,7 @@ func (d *DB) DeleteStream(stream *Stream) (_ *Device, err error) {
 	}
 
 	if streamCount == 0 {
-		// delete the device too
-		sql = `DELETE FROM devices WHERE id = :id RETURNING device_token`
+		sql = `SELECT id, device_token FROM devices WHERE id = :id`
 
 		mapArgs = map[string]interface{}{
 			"id": deviceID,
@@ -322,7 +557,15 @@ func (d *DB) DeleteStream(stream *Stream) (_ *Device, err error) {
 
 		err = tx.Get(&device, sql, mapArgs)
 		if err != nil {
-			return nil, errors.Wrap(err, "failed to delete device")
+			return nil, errors.Wrap(err, "failed to load device")
+		}
+
+		// release any lease on the device so no instance keeps subscribing to it
+		sql = `DELETE FROM device_leases WHERE device_id = :id`
+
+		err = tx.Exec(sql, mapArgs)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to release device lease")
 		}
 
 		return &device, nil
@@ -331,13 +574,117 @@ func (d *DB) DeleteStream(stream *Stream) (_ *Device, err error) {
 	return nil, nil
 }
 
+// RestoreStream restores a stream previously soft deleted by DeleteStream,
+// returning the restored stream along with its device. As with GetStream the
+// stream's token must be supplied, and if it does not match, or the stream is
+// not deleted, we return ErrStreamNotFound. If the device has since been
+// registered again within the stream's community we return ErrStreamConflict.
+func (d *DB) RestoreStream(streamID, token string) (_ *Stream, err error) {
+	query := `UPDATE streams
+	SET deleted_at = NULL
+	WHERE uuid = :uuid
+	AND pgp_sym_decrypt(token, :encryption_password) = :token
+	AND deleted_at IS NOT NULL
+	RETURNING uuid`
+
+	mapArgs := map[string]interface{}{
+		"uuid":                streamID,
+		"token":               token,
+		"encryption_password": d.encryptionKey(),
+	}
+
+	tx, err := BeginTX(d.DB, "restore_stream")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to begin transaction")
+	}
+
+	defer func() {
+		if cerr := tx.CommitOrRollback(); err == nil && cerr != nil {
+			err = cerr
+		}
+	}()
+
+	var id string
+
+	err = tx.Get(&id, query, mapArgs)
+	if err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return nil, ErrStreamNotFound
+		}
+		if pqErr, ok := errors.Cause(err).(*pq.Error); ok && pqErr.Code == pqUniqueViolation {
+			return nil, ErrStreamConflict
+		}
+		return nil, errors.Wrap(err, "failed to restore stream")
+	}
+
+	query = `SELECT ` + qualifiedStreamColumns + `,
+		d.id, d.device_token, d.longitude, d.latitude, d.exposure, d.device_label
+	FROM streams s
+	JOIN devices d ON d.id = s.device_id
+	WHERE s.uuid = :uuid`
+
+	var row streamRow
+
+	err = tx.Get(&row, query, mapArgs)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load restored stream")
+	}
+
+	stream := row.toStream()
+	stream.Token = secret.Secret(token)
+
+	return stream, nil
+}
+
+// PurgeDeletedStreams permanently deletes all streams soft deleted before the
+// given time, along with any devices left without streams, returning the
+// number of streams deleted.
+func (d *DB) PurgeDeletedStreams(before time.Time) (_ int64, err error) {
+	tx, err := BeginTX(d.DB, "purge_deleted_streams")
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to start transaction when purging streams")
+	}
+
+	defer func() {
+		if cerr := tx.CommitOrRollback(); err == nil && cerr != nil {
+			err = cerr
+		}
+	}()
+
+	sql := `WITH purged AS (
+		DELETE FROM streams
+		WHERE deleted_at < :before
+		RETURNING id
+	)
+	SELECT COUNT(*) FROM purged`
+
+	var deleted int64
+
+	err = tx.Get(&deleted, sql, map[string]interface{}{"before": before})
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to purge deleted streams")
+	}
+
+	sql = `DELETE FROM devices d
+	WHERE NOT EXISTS (SELECT 1 FROM streams s WHERE s.device_id = d.id)`
+
+	err = tx.Exec(sql, map[string]interface{}{})
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to purge devices without streams")
+	}
+
+	return deleted, nil
+}
+
 // GetDevices returns a slice of pointers to Device instances. We don't worry
 // about pagination here as we have a maximum number of devices of approximately
-// 25 to 50. Note we do not load all streams for these devices.
+// 25 to 50. Note we do not load all streams for these devices, and devices
+// whose streams have all been deleted are not returned.
 func (d *DB) GetDevices() ([]*Device, error) {
-	sql := `SELECT id, device_token FROM devices`
+	sql := `SELECT d.id, d.device_token FROM devices d
+	WHERE EXISTS (SELECT 1 FROM streams s WHERE s.device_id = d.id AND s.deleted_at IS NULL)`
 
-	tx, err := BeginTX(d.DB)
+	tx, err := BeginTX(d.DB, "get_devices")
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to begin transaction")
 	}
@@ -373,19 +720,37 @@ func (d *DB) GetDevices() ([]*Device, error) {
 	return devices, nil
 }
 
+// deviceStreamsSQL is the query used by GetDevice to load the live streams of a
+// device.
+const deviceStreamsSQL = `SELECT ` + streamColumns + `,
+		COALESCE(pgp_sym_decrypt(ingest_secret, :encryption_password), '') AS ingest_secret
+	FROM streams WHERE device_id = :device_id AND deleted_at IS NULL`
+
 // GetDevice returns a single device identified by device_token, including all streams
 // for that device. This is used to set up subscriptions for existing records on
-// application start.
-func (d *DB) GetDevice(deviceToken string) (_ *Device, err error) {
-	sql := `SELECT id, device_token, longitude, latitude, exposure, device_label
-		FROM devices
-		WHERE device_token = :device_token`
+// application start. Deleted streams are not loaded, and a device whose streams
+// have all been deleted is treated as if it did not exist.
+func (d *DB) GetDevice(deviceToken secret.Secret) (_ *Device, err error) {
+	sql := `SELECT d.id, d.device_token, d.longitude, d.latitude, d.exposure, d.device_label
+		FROM devices d
+		WHERE d.device_token = :device_token
+		AND EXISTS (SELECT 1 FROM streams s WHERE s.device_id = d.id AND s.deleted_at IS NULL)`
 
 	mapArgs := map[string]interface{}{
 		"device_token": deviceToken,
 	}
 
-	tx, err := BeginTX(d.DB)
+	deviceStmt, err := d.prepare(sql)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load device")
+	}
+
+	streamsStmt, err := d.prepare(deviceStreamsSQL)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load device")
+	}
+
+	tx, err := BeginTX(d.DB, "get_device")
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to begin transaction")
 	}
@@ -397,16 +762,15 @@ func (d *DB) GetDevice(deviceToken string) (_ *Device, err error) {
 	}()
 
 	var device Device
-	err = tx.Get(&device, sql, mapArgs)
+	err = tx.GetStmt(&device, deviceStmt, mapArgs)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to load device")
 	}
 
 	// now load streams
-	sql = `SELECT community_id, public_key, operations FROM streams WHERE device_id = :device_id`
-
 	mapArgs = map[string]interface{}{
-		"device_id": device.ID,
+		"device_id":           device.ID,
+		"encryption_password": d.encryptionKey(),
 	}
 
 	streams := []*Stream{}
@@ -427,7 +791,7 @@ func (d *DB) GetDevice(deviceToken string) (_ *Device, err error) {
 		return nil
 	}
 
-	err = tx.Map(sql, mapArgs, mapper)
+	err = tx.MapStmt(streamsStmt, mapArgs, mapper)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to execute row mapper")
 	}
@@ -437,30 +801,445 @@ func (d *DB) GetDevice(deviceToken string) (_ *Device, err error) {
 	return &device, nil
 }
 
+// ListStreams returns all registered streams along with the device feeding
+// each stream. Stream tokens are not returned. As with GetDevices we don't
+// worry about pagination as the number of streams is small.
+func (d *DB) ListStreams() ([]*Stream, error) {
+	return d.selectStreams("list_streams", "", map[string]interface{}{})
+}
+
+// CountCommunityStreams returns the number of live streams registered within
+// the given community, against which the community's stream quota is checked.
+func (d *DB) CountCommunityStreams(communityID string) (_ int, err error) {
+	tx, err := BeginTX(d.DB, "count_community_streams")
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to begin transaction")
+	}
+
+	defer func() {
+		if cerr := tx.CommitOrRollback(); err == nil && cerr != nil {
+			err = cerr
+		}
+	}()
+
+	var count int
+
+	err = tx.Get(&count, `SELECT COUNT(*) FROM streams
+	WHERE community_id = :community_id AND deleted_at IS NULL`, map[string]interface{}{
+		"community_id": communityID,
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to count community streams")
+	}
+
+	return count, nil
+}
+
+// ListDeviceStreams returns all registered streams fed by the device with the
+// given token, along with the device. Stream tokens are not returned. The
+// device is found by the unique index on its token, and its streams by the
+// index on their device id, so this doesn't scan either table.
+func (d *DB) ListDeviceStreams(deviceToken secret.Secret) ([]*Stream, error) {
+	return d.selectStreams("list_device_streams", "AND d.device_token = :device_token", map[string]interface{}{
+		"device_token": deviceToken,
+	})
+}
+
+// selectStreams returns the registered streams which also match the given
+// filter, a condition appended to our WHERE clause whose named parameters are
+// supplied in mapArgs. The query is recorded under the given name.
+func (d *DB) selectStreams(name, filter string, mapArgs map[string]interface{}) (_ []*Stream, err error) {
+	query := `SELECT ` + qualifiedStreamColumns + `,
+		d.id, d.device_token, d.longitude, d.latitude, d.exposure, d.device_label
+	FROM streams s
+	JOIN devices d ON d.id = s.device_id
+	WHERE s.deleted_at IS NULL ` + filter + `
+	ORDER BY s.id`
+
+	tx, err := BeginTX(d.DB, name)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to begin transaction")
+	}
+
+	defer func() {
+		if cerr := tx.CommitOrRollback(); err == nil && cerr != nil {
+			err = cerr
+		}
+	}()
+
+	streams := []*Stream{}
+
+	mapper := func(rows *sqlx.Rows) error {
+		for rows.Next() {
+			var row streamRow
+
+			err = rows.StructScan(&row)
+			if err != nil {
+				return errors.Wrap(err, "failed to scan row into stream struct")
+			}
+
+			streams = append(streams, row.toStream())
+		}
+
+		return nil
+	}
+
+	err = tx.Map(query, mapArgs, mapper)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to select stream rows from database")
+	}
+
+	return streams, nil
+}
+
+// GetStream returns a single stream identified by its uuid, along with the
+// device that feeds it. The stream's token must also be supplied, and if it
+// does not match we return ErrStreamNotFound exactly as if the stream did not
+// exist.
+func (d *DB) GetStream(streamID, token string) (_ *Stream, err error) {
+	query := `SELECT ` + qualifiedStreamColumns + `,
+		d.id, d.device_token, d.longitude, d.latitude, d.exposure, d.device_label
+	FROM streams s
+	JOIN devices d ON d.id = s.device_id
+	WHERE s.uuid = :uuid
+	AND pgp_sym_decrypt(s.token, :encryption_password) = :token
+	AND s.deleted_at IS NULL`
+
+	mapArgs := map[string]interface{}{
+		"uuid":                streamID,
+		"token":               token,
+		"encryption_password": d.encryptionKey(),
+	}
+
+	tx, err := BeginTX(d.DB, "get_stream")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to begin transaction")
+	}
+
+	defer func() {
+		if cerr := tx.CommitOrRollback(); err == nil && cerr != nil {
+			err = cerr
+		}
+	}()
+
+	var row streamRow
+
+	err = tx.Get(&row, query, mapArgs)
+	if err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return nil, ErrStreamNotFound
+		}
+		return nil, errors.Wrap(err, "failed to load stream")
+	}
+
+	stream := row.toStream()
+	stream.Token = secret.Secret(token)
+
+	return stream, nil
+}
+
+// SetConversions replaces the conversion expressions of the stream identified
+// by its uuid. As with GetStream the stream's token must be supplied, and if it
+// does not match we return ErrStreamNotFound. Expressions are not validated
+// here, callers are expected to have parsed them first.
+func (d *DB) SetConversions(streamID, token string, conversions Conversions) (err error) {
+	query := `UPDATE streams
+	SET conversions = :conversions
+	WHERE uuid = :uuid
+	AND pgp_sym_decrypt(token, :encryption_password) = :token
+	AND deleted_at IS NULL
+	RETURNING uuid`
+
+	mapArgs := map[string]interface{}{
+		"uuid":                streamID,
+		"token":               token,
+		"encryption_password": d.encryptionKey(),
+		"conversions":         conversions,
+	}
+
+	tx, err := BeginTX(d.DB, "set_conversions")
+	if err != nil {
+		return errors.Wrap(err, "failed to begin transaction")
+	}
+
+	defer func() {
+		if cerr := tx.CommitOrRollback(); err == nil && cerr != nil {
+			err = cerr
+		}
+	}()
+
+	var id string
+
+	err = tx.Get(&id, query, mapArgs)
+	if err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return ErrStreamNotFound
+		}
+		return errors.Wrap(err, "failed to update stream conversions")
+	}
+
+	return nil
+}
+
+// SetIngestSecret replaces the shared secret used to verify payloads pushed
+// over HTTP for the stream identified by its uuid. The secret is encrypted at
+// rest in the same way as the stream's token. As with GetStream the stream's
+// token must be supplied, and if it does not match we return
+// ErrStreamNotFound.
+func (d *DB) SetIngestSecret(streamID, token string, ingestSecret secret.Secret) (err error) {
+	query := `UPDATE streams
+	SET ingest_secret = pgp_sym_encrypt(:ingest_secret, :encryption_password)
+	WHERE uuid = :uuid
+	AND pgp_sym_decrypt(token, :encryption_password) = :token
+	AND deleted_at IS NULL
+	RETURNING uuid`
+
+	mapArgs := map[string]interface{}{
+		"uuid":                streamID,
+		"token":               token,
+		"ingest_secret":       ingestSecret,
+		"encryption_password": d.encryptionKey(),
+	}
+
+	tx, err := BeginTX(d.DB, "set_ingest_secret")
+	if err != nil {
+		return errors.Wrap(err, "failed to begin transaction")
+	}
+
+	defer func() {
+		if cerr := tx.CommitOrRollback(); err == nil && cerr != nil {
+			err = cerr
+		}
+	}()
+
+	var id string
+
+	err = tx.Get(&id, query, mapArgs)
+	if err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return ErrStreamNotFound
+		}
+		return errors.Wrap(err, "failed to update stream ingest secret")
+	}
+
+	return nil
+}
+
+// SetPolicyPublicKey replaces the public key of every live stream created from
+// the policy with the given id whose key differs, returning the number of
+// streams updated. It is used to apply keys rotated in the policy store.
+func (d *DB) SetPolicyPublicKey(policyID, publicKey string) (_ int64, err error) {
+	query := `WITH updated AS (
+		UPDATE streams
+		SET public_key = :public_key, public_key_fingerprint = :public_key_fingerprint
+		WHERE policy_id = :policy_id
+		AND public_key <> :public_key
+		AND deleted_at IS NULL
+		RETURNING id
+	)
+	SELECT COUNT(*) FROM updated`
+
+	mapArgs := map[string]interface{}{
+		"policy_id":              policyID,
+		"public_key":             publicKey,
+		"public_key_fingerprint": pubkey.Fingerprint(publicKey),
+	}
+
+	tx, err := BeginTX(d.DB, "set_policy_public_key")
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to begin transaction")
+	}
+
+	defer func() {
+		if cerr := tx.CommitOrRollback(); err == nil && cerr != nil {
+			err = cerr
+		}
+	}()
+
+	var updated int64
+
+	err = tx.Get(&updated, query, mapArgs)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to update policy public key")
+	}
+
+	return updated, nil
+}
+
+// streamSettingColumns lists the columns of the streams table holding the
+// settings with which each stream is configured. It is shared by the queries
+// which insert and select streams, so that a new setting is added to all of
+// them here, along with a field of streamRow.
+const streamSettingColumns = `datastore_addr, conversions, source, compression,
+	timestamp_policy, policy_id, labels, datastore_timeout, payload_schema,
+	dispositions, privacy, geo_privacy, rate_limit, sampling, schedule, geofence,
+	public_key_fingerprint, archive, priority, topic, qos`
+
+// streamColumns lists the columns of the streams table scanned into a
+// streamRow.
+const streamColumns = `uuid, community_id, public_key, operations, ` + streamSettingColumns
+
+var (
+	// qualifiedStreamColumns is streamColumns qualified by the alias s, for the
+	// queries joining streams with their devices.
+	qualifiedStreamColumns = prefixColumns("s.", streamColumns)
+
+	// streamSettingParams lists the named parameters of streamSettingColumns,
+	// for the queries inserting streams.
+	streamSettingParams = prefixColumns(":", streamSettingColumns)
+)
+
+// prefixColumns returns the given comma separated list of columns with the
+// given prefix added to each of them.
+func prefixColumns(prefix, columns string) string {
+	names := strings.Split(columns, ",")
+	for i, name := range names {
+		names[i] = prefix + strings.TrimSpace(name)
+	}
+
+	return strings.Join(names, ", ")
+}
+
+// streamRow is a flat struct into which we scan rows joining a stream with its
+// device.
+type streamRow struct {
+	StreamID             string        `db:"uuid"`
+	CommunityID          string        `db:"community_id"`
+	PublicKey            string        `db:"public_key"`
+	Operations           Operations    `db:"operations"`
+	DatastoreAddr        string        `db:"datastore_addr"`
+	Conversions          Conversions   `db:"conversions"`
+	Source               string        `db:"source"`
+	Compression          string        `db:"compression"`
+	TimestampPolicy      string        `db:"timestamp_policy"`
+	PolicyID             string        `db:"policy_id"`
+	Labels               Labels        `db:"labels"`
+	DatastoreTimeout     string        `db:"datastore_timeout"`
+	Schema               string        `db:"payload_schema"`
+	Dispositions         Dispositions  `db:"dispositions"`
+	Privacy              string        `db:"privacy"`
+	GeoPrivacy           string        `db:"geo_privacy"`
+	RateLimit            string        `db:"rate_limit"`
+	Sampling             string        `db:"sampling"`
+	Schedule             string        `db:"schedule"`
+	Geofence             string        `db:"geofence"`
+	PublicKeyFingerprint string        `db:"public_key_fingerprint"`
+	Archive              bool          `db:"archive"`
+	Priority             string        `db:"priority"`
+	Topic                string        `db:"topic"`
+	QoS                  int           `db:"qos"`
+	DeviceID             int           `db:"id"`
+	DeviceToken          secret.Secret `db:"device_token"`
+	Longitude            float64       `db:"longitude"`
+	Latitude             float64       `db:"latitude"`
+	Exposure             string        `db:"exposure"`
+	Label                string        `db:"device_label"`
+}
+
+// toStream converts the row into a Stream with an associated Device.
+func (r *streamRow) toStream() *Stream {
+	stream := &Stream{
+		StreamID:             r.StreamID,
+		CommunityID:          r.CommunityID,
+		PublicKey:            r.PublicKey,
+		Operations:           r.Operations,
+		DatastoreAddr:        r.DatastoreAddr,
+		Conversions:          r.Conversions,
+		Source:               r.Source,
+		Compression:          r.Compression,
+		TimestampPolicy:      r.TimestampPolicy,
+		PolicyID:             r.PolicyID,
+		Labels:               r.Labels,
+		DatastoreTimeout:     r.DatastoreTimeout,
+		Schema:               r.Schema,
+		Dispositions:         r.Dispositions,
+		Privacy:              r.Privacy,
+		GeoPrivacy:           r.GeoPrivacy,
+		RateLimit:            r.RateLimit,
+		Sampling:             r.Sampling,
+		Schedule:             r.Schedule,
+		Geofence:             r.Geofence,
+		PublicKeyFingerprint: r.PublicKeyFingerprint,
+		Archive:              r.Archive,
+		Priority:             r.Priority,
+		Topic:                r.Topic,
+		QoS:                  r.QoS,
+		Device: &Device{
+			ID:          r.DeviceID,
+			DeviceToken: r.DeviceToken,
+			Longitude:   r.Longitude,
+			Latitude:    r.Latitude,
+			Exposure:    r.Exposure,
+			Label:       r.Label,
+		},
+	}
+
+	stream.Device.Streams = []*Stream{stream}
+
+	return stream
+}
+
 // MigrateUp is a convenience function to run all up migrations in the context
 // of an instantiated DB instance.
 func (d *DB) MigrateUp() error {
 	return MigrateUp(d.DB.DB, d.logger)
 }
 
+// MigrationStatus is a convenience function to return the migration status of
+// the database in the context of an instantiated DB instance.
+func (d *DB) MigrationStatus() (*MigrationStatus, error) {
+	return GetMigrationStatus(d.DB.DB, d.logger)
+}
+
 // Ping attempts to verify the database connection is still alive by executing a
 // simple select query on the database server. We don't use the built in
 // DB.Ping() function here as this may not go to the database if there existing
 // connections in the pool.
 func (d *DB) Ping() error {
+	start := time.Now()
+
 	_, err := d.DB.Exec("SELECT 1")
+	observeQuery("ping", start, err)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// Check returns an error if the database cannot be reached, or if a migration
+// failed leaving the schema dirty. It reads the migrations table directly, as
+// creating a migrator for each check would hold a connection from the pool.
+func (d *DB) Check() error {
+	var dirty bool
+
+	start := time.Now()
+
+	err := d.DB.Get(&dirty, `SELECT dirty FROM schema_migrations LIMIT 1`)
+	observeQuery("check", start, err)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return errors.New("database has not been migrated")
+		}
+		return errors.Wrap(err, "failed to read migration status")
+	}
+
+	if dirty {
+		return errors.New("database is dirty")
+	}
+
+	return nil
+}
+
 // Get is an implementation of the Get method of the autocert.Cache interface.
 func (d *DB) Get(ctx context.Context, key string) ([]byte, error) {
 	query := `SELECT certificate FROM certificates WHERE key = $1`
 
 	var cert []byte
+
+	start := time.Now()
+
 	err := d.DB.Get(&cert, query, key)
+	observeQuery("get_certificate", start, err)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, autocert.ErrCacheMiss
@@ -473,7 +1252,11 @@ func (d *DB) Get(ctx context.Context, key string) ([]byte, error) {
 
 // Put is an implementation of the Put method of the autocert.Cache interface
 // for saving certificates
-func (d *DB) Put(ctx context.Context, key string, cert []byte) error {
+func (d *DB) Put(ctx context.Context, key string, cert []byte) (err error) {
+	defer func(start time.Time) {
+		observeQuery("put_certificate", start, err)
+	}(time.Now())
+
 	query := `INSERT INTO certificates (key, certificate)
 		VALUES (:key, :certificate)
 	ON CONFLICT (key)
@@ -506,7 +1289,11 @@ func (d *DB) Put(ctx context.Context, key string, cert []byte) error {
 
 // Delete is an implementation of the Delete method of the autocert.Cache
 // interface method for deleting certificates.
-func (d *DB) Delete(ctx context.Context, key string) error {
+func (d *DB) Delete(ctx context.Context, key string) (err error) {
+	defer func(start time.Time) {
+		observeQuery("delete_certificate", start, err)
+	}(time.Now())
+
 	query := `DELETE FROM certificates WHERE key = $1`
 
 	tx, err := d.DB.Beginx()
@@ -524,13 +1311,19 @@ func (d *DB) Delete(ctx context.Context, key string) error {
 }
 
 // recordMetrics starts a ticker to collect some gauge related metrics from the
-// DB on a 30 second interval
+// DB on a 30 second interval, including the statistics of our connection pool.
 func (d *DB) recordMetrics() {
 	ticker := time.NewTicker(time.Second * time.Duration(30))
 
 	for range ticker.C {
+		recordPoolStats(d.DB.Stats())
+
 		var streamCount float64
-		err := d.DB.Get(&streamCount, `SELECT COUNT(*) FROM streams`)
+
+		start := time.Now()
+
+		err := d.DB.Get(&streamCount, `SELECT COUNT(*) FROM streams WHERE deleted_at IS NULL`)
+		observeQuery("count_streams", start, err)
 		if err != nil {
 			d.logger.Log(
 				"msg", "error counting streams",
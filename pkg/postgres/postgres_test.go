@@ -4,14 +4,17 @@ import (
 	"context"
 	"os"
 	"testing"
+	"time"
 
 	kitlog "github.com/go-kit/kit/log"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 	"golang.org/x/crypto/acme/autocert"
+	"gopkg.in/guregu/null.v3"
 
 	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/secret"
 )
 
 type PostgresSuite struct {
@@ -72,7 +75,7 @@ func (s *PostgresSuite) TestRoundTrip() {
 
 	assert.Nil(s.T(), err)
 	assert.NotEqual(s.T(), "", stream1.StreamID)
-	assert.NotEqual(s.T(), "", stream1.Token)
+	assert.NotEqual(s.T(), secret.Secret(""), stream1.Token)
 
 	stream2, err := s.db.CreateStream(&postgres.Stream{
 		CommunityID: "policy-id",
@@ -87,21 +90,21 @@ func (s *PostgresSuite) TestRoundTrip() {
 
 	assert.Nil(s.T(), err)
 	assert.NotEqual(s.T(), "", stream2.StreamID)
-	assert.NotEqual(s.T(), "", stream2.Token)
+	assert.NotEqual(s.T(), secret.Secret(""), stream2.Token)
 
 	devices, err := s.db.GetDevices()
 	assert.Nil(s.T(), err)
 	assert.Len(s.T(), devices, 2)
 
-	assert.Equal(s.T(), "123", devices[0].DeviceToken)
+	assert.Equal(s.T(), secret.Secret("123"), devices[0].DeviceToken)
 
-	assert.Equal(s.T(), "124", devices[1].DeviceToken)
+	assert.Equal(s.T(), secret.Secret("124"), devices[1].DeviceToken)
 
 	device, err := s.db.GetDevice("123")
 	assert.Nil(s.T(), err)
 	assert.NotNil(s.T(), device)
 
-	assert.Equal(s.T(), "123", device.DeviceToken)
+	assert.Equal(s.T(), secret.Secret("123"), device.DeviceToken)
 	assert.Equal(s.T(), 45.2, device.Longitude)
 	assert.Equal(s.T(), 23.2, device.Latitude)
 	assert.Equal(s.T(), "indoor", device.Exposure)
@@ -111,13 +114,66 @@ func (s *PostgresSuite) TestRoundTrip() {
 
 	device, err = s.db.DeleteStream(stream1)
 	assert.Nil(s.T(), err)
-	assert.Equal(s.T(), "123", device.DeviceToken)
+	assert.Equal(s.T(), secret.Secret("123"), device.DeviceToken)
 
 	devices, err = s.db.GetDevices()
 	assert.Nil(s.T(), err)
 	assert.Len(s.T(), devices, 1)
 }
 
+func (s *PostgresSuite) TestRestoreAndPurgeStream() {
+	stream, err := s.db.CreateStream(&postgres.Stream{
+		CommunityID: "policy-id",
+		PublicKey:   "public",
+		Device: &postgres.Device{
+			DeviceToken: "123",
+			Longitude:   45.2,
+			Latitude:    23.2,
+			Exposure:    "indoor",
+		},
+	})
+	assert.Nil(s.T(), err)
+
+	_, err = s.db.RestoreStream(stream.StreamID, stream.Token.Reveal())
+	assert.Equal(s.T(), postgres.ErrStreamNotFound, err)
+
+	_, err = s.db.DeleteStream(stream)
+	assert.Nil(s.T(), err)
+
+	devices, err := s.db.GetDevices()
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), devices, 0)
+
+	restored, err := s.db.RestoreStream(stream.StreamID, stream.Token.Reveal())
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), secret.Secret("123"), restored.Device.DeviceToken)
+
+	devices, err = s.db.GetDevices()
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), devices, 1)
+
+	_, err = s.db.DeleteStream(stream)
+	assert.Nil(s.T(), err)
+
+	// the device may be registered again within the community while deleted
+	_, err = s.db.CreateStream(&postgres.Stream{
+		CommunityID: "policy-id",
+		PublicKey:   "public",
+		Device:      &postgres.Device{DeviceToken: "123"},
+	})
+	assert.Nil(s.T(), err)
+
+	_, err = s.db.RestoreStream(stream.StreamID, stream.Token.Reveal())
+	assert.Equal(s.T(), postgres.ErrStreamConflict, err)
+
+	purged, err := s.db.PurgeDeletedStreams(time.Now().Add(time.Minute))
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), int64(1), purged)
+
+	_, err = s.db.RestoreStream(stream.StreamID, stream.Token.Reveal())
+	assert.Equal(s.T(), postgres.ErrStreamNotFound, err)
+}
+
 func (s *PostgresSuite) TestRoundTripWithOperations() {
 	stream, err := s.db.CreateStream(&postgres.Stream{
 		CommunityID: "policy-id",
@@ -286,6 +342,880 @@ func (s *PostgresSuite) TestCertificates() {
 	assert.Equal(s.T(), autocert.ErrCacheMiss, err)
 }
 
+func (s *PostgresSuite) TestStreamStats() {
+	stream, err := s.db.CreateStream(&postgres.Stream{
+		CommunityID: "policy-id",
+		PublicKey:   "public",
+		Device: &postgres.Device{
+			DeviceToken: "123",
+			Longitude:   45.2,
+			Latitude:    23.2,
+			Exposure:    "indoor",
+		},
+	})
+	assert.Nil(s.T(), err)
+
+	err = s.db.SaveStreamStats([]*postgres.StreamStats{
+		{
+			StreamID:         stream.StreamID,
+			MessagesReceived: 12,
+			BytesEncrypted:   1024,
+			WritesSucceeded:  11,
+			WritesFailed:     1,
+			Throttled:        4,
+			Dropped:          2,
+		},
+		{
+			// unknown streams are skipped
+			StreamID:         uuid.New().String(),
+			MessagesReceived: 3,
+		},
+	})
+	assert.Nil(s.T(), err)
+
+	stats, err := s.db.GetStreamStats()
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), stats, 1)
+	assert.Equal(s.T(), stream.StreamID, stats[0].StreamID)
+	assert.Equal(s.T(), uint64(12), stats[0].MessagesReceived)
+	assert.Equal(s.T(), uint64(1024), stats[0].BytesEncrypted)
+	assert.Equal(s.T(), uint64(4), stats[0].Throttled)
+	assert.Equal(s.T(), uint64(2), stats[0].Dropped)
+
+	_, err = s.db.DeleteStream(stream)
+	assert.Nil(s.T(), err)
+
+	// deleted streams are kept until purged
+	_, err = s.db.PurgeDeletedStreams(time.Now().Add(time.Minute))
+	assert.Nil(s.T(), err)
+
+	stats, err = s.db.GetStreamStats()
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), stats, 0)
+}
+
+func (s *PostgresSuite) TestDeviceStatuses() {
+	stream, err := s.db.CreateStream(&postgres.Stream{
+		CommunityID: "policy-id",
+		PublicKey:   "public",
+		Device: &postgres.Device{
+			DeviceToken: "123",
+			Longitude:   45.2,
+			Latitude:    23.2,
+			Exposure:    "indoor",
+		},
+	})
+	assert.Nil(s.T(), err)
+
+	seenAt := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	err = s.db.SaveDeviceStatuses([]*postgres.DeviceStatus{
+		{
+			DeviceToken:   "123",
+			LastSeenAt:    null.TimeFrom(seenAt),
+			MessageCounts: postgres.MessageCounts{seenAt.Unix(): 3},
+			Error:         null.StringFrom("failed to write data"),
+			ErrorAt:       null.TimeFrom(seenAt),
+		},
+		{
+			// unknown devices are skipped
+			DeviceToken: "456",
+			LastSeenAt:  null.TimeFrom(seenAt),
+		},
+	})
+	assert.Nil(s.T(), err)
+
+	statuses, err := s.db.GetDeviceStatuses()
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), statuses, 1)
+	assert.Equal(s.T(), "123", statuses[0].DeviceToken.Reveal())
+	assert.True(s.T(), seenAt.Equal(statuses[0].LastSeenAt.Time))
+	assert.Equal(s.T(), uint64(3), statuses[0].MessageCounts.Total())
+	assert.Equal(s.T(), "failed to write data", statuses[0].Error.String)
+
+	_, err = s.db.DeleteStream(stream)
+	assert.Nil(s.T(), err)
+
+	// deleted streams are kept until purged
+	_, err = s.db.PurgeDeletedStreams(time.Now().Add(time.Minute))
+	assert.Nil(s.T(), err)
+
+	statuses, err = s.db.GetDeviceStatuses()
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), statuses, 0)
+}
+
+func (s *PostgresSuite) TestAuditEntries() {
+	recordedAt := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	entry := &postgres.AuditEntry{
+		RecordedAt: recordedAt,
+		Caller:     "10.0.0.1",
+		RequestID:  null.StringFrom("request-id"),
+		Method:     "DeleteStream",
+		StreamID:   null.StringFrom("stream-id"),
+		Params:     postgres.AuditParams{"stream_uid": "stream-id", "token": "[redacted]"},
+		Result:     "not_found",
+		Error:      null.StringFrom("stream not found"),
+	}
+
+	err := s.db.SaveAuditEntry(entry)
+	assert.Nil(s.T(), err)
+	assert.NotEqual(s.T(), int64(0), entry.ID)
+
+	err = s.db.SaveAuditEntry(&postgres.AuditEntry{
+		RecordedAt: recordedAt,
+		Caller:     "10.0.0.1",
+		Method:     "CreateStream",
+		Result:     "ok",
+	})
+	assert.Nil(s.T(), err)
+
+	entries, err := s.db.GetAuditEntries("stream-id", recordedAt, recordedAt.Add(time.Second), 0, 10)
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), entries, 1)
+	assert.Equal(s.T(), "DeleteStream", entries[0].Method)
+	assert.Equal(s.T(), "[redacted]", entries[0].Params["token"])
+	assert.Equal(s.T(), "stream not found", entries[0].Error.String)
+
+	entries, err = s.db.GetAuditEntries("", recordedAt, recordedAt.Add(time.Second), entry.ID, 10)
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), entries, 1)
+	assert.Equal(s.T(), "CreateStream", entries[0].Method)
+
+	// the audit log is append only
+	_, err = s.db.DB.Exec(`DELETE FROM audit_log`)
+	assert.NotNil(s.T(), err)
+}
+
+func (s *PostgresSuite) TestSchemas() {
+	_, err := s.db.GetSchema("noise", 0)
+	assert.Equal(s.T(), postgres.ErrSchemaNotFound, err)
+
+	channels := postgres.Channels{
+		{SensorID: 12, Name: "temperature", Unit: "celsius", Type: postgres.NumberType},
+	}
+
+	for _, kind := range []string{"noise", "air", "noise"} {
+		_, err = s.db.CreateSchema(kind, channels)
+		assert.Nil(s.T(), err)
+	}
+
+	schema, err := s.db.GetSchema("noise", 0)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), "noise@2", schema.Ref())
+	assert.Equal(s.T(), "celsius", schema.Channels[0].Unit)
+
+	schema, err = s.db.GetSchema("noise", 1)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), "noise@1", schema.Ref())
+
+	schemas, err := s.db.ListSchemas()
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), schemas, 3)
+	assert.Equal(s.T(), "air@1", schemas[0].Ref())
+}
+
+func (s *PostgresSuite) TestSpendPrivacyBudget() {
+	stream, err := s.db.CreateStream(&postgres.Stream{
+		CommunityID: "policy-id",
+		PublicKey:   "public",
+		Operations: postgres.Operations{
+			{SensorID: 12, Action: postgres.MovingAverage, Interval: 900},
+		},
+		Privacy: "laplace:epsilon=0.5,budget=2.5,period=24h",
+		Device: &postgres.Device{
+			DeviceToken: "123",
+			Longitude:   45.2,
+			Latitude:    23.2,
+			Exposure:    "indoor",
+		},
+	})
+	assert.Nil(s.T(), err)
+
+	device, err := s.db.GetDevice("123")
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), "laplace:epsilon=0.5,budget=2.5,period=24h", device.Streams[0].Privacy)
+
+	today := time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC)
+
+	for _, cost := range []float64{1, 1, 0.5} {
+		spent, err := s.db.SpendPrivacyBudget(stream.StreamID, today, cost, 2.5)
+		assert.Nil(s.T(), err)
+		assert.True(s.T(), spent)
+	}
+
+	spent, err := s.db.SpendPrivacyBudget(stream.StreamID, today, 0.5, 2.5)
+	assert.Nil(s.T(), err)
+	assert.False(s.T(), spent)
+
+	spent, err = s.db.SpendPrivacyBudget(stream.StreamID, today.Add(24*time.Hour), 0.5, 2.5)
+	assert.Nil(s.T(), err)
+	assert.True(s.T(), spent)
+}
+
+func (s *PostgresSuite) TestDeviceToken() {
+	stream, err := s.db.CreateStream(&postgres.Stream{
+		CommunityID: "policy-id",
+		PublicKey:   "public",
+		Device: &postgres.Device{
+			DeviceToken: "123",
+			Longitude:   45.2,
+			Latitude:    23.2,
+			Exposure:    "indoor",
+		},
+	})
+	assert.Nil(s.T(), err)
+
+	device, err := s.db.GetDevice("123")
+	assert.Nil(s.T(), err)
+
+	_, err = s.db.DeleteStream(stream)
+	assert.Nil(s.T(), err)
+
+	// the token is returned even once the device has no live streams
+	token, err := s.db.DeviceToken(device.ID)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), secret.Secret("123"), token)
+
+	_, err = s.db.DeviceToken(device.ID + 1)
+	assert.NotNil(s.T(), err)
+}
+
+func (s *PostgresSuite) TestLegacyOperations() {
+	stream, err := s.db.CreateStream(&postgres.Stream{
+		CommunityID: "policy-id",
+		PublicKey:   "public",
+		Device: &postgres.Device{
+			DeviceToken: "123",
+			Longitude:   45.2,
+			Latitude:    23.2,
+			Exposure:    "indoor",
+		},
+	})
+	assert.Nil(s.T(), err)
+
+	// streams created before operations were versioned hold a bare array
+	_, err = s.db.DB.Exec(`UPDATE streams SET operations = '[{"sensorId":12,"action":"SHARE"}]' WHERE uuid = $1`, stream.StreamID)
+	assert.Nil(s.T(), err)
+
+	device, err := s.db.GetDevice("123")
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), postgres.Operations{{SensorID: 12, Action: postgres.Share}}, device.Streams[0].Operations)
+
+	_, err = s.db.CreateStream(&postgres.Stream{
+		CommunityID: "other-policy-id",
+		PublicKey:   "public",
+		Operations:  postgres.Operations{{SensorID: 12, Action: postgres.Bin}},
+		Device: &postgres.Device{
+			DeviceToken: "123",
+			Longitude:   45.2,
+			Latitude:    23.2,
+			Exposure:    "indoor",
+		},
+	})
+	assert.NotNil(s.T(), err)
+}
+
+func (s *PostgresSuite) TestDownsampleCheckpoints() {
+	stream, err := s.db.CreateStream(&postgres.Stream{
+		CommunityID: "policy-id",
+		PublicKey:   "public",
+		Operations: postgres.Operations{
+			{SensorID: 12, Action: postgres.Downsample, Interval: 300},
+		},
+		Device: &postgres.Device{
+			DeviceToken: "123",
+			Longitude:   45.2,
+			Latitude:    23.2,
+			Exposure:    "indoor",
+		},
+	})
+	assert.Nil(s.T(), err)
+
+	forwardedAt := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	err = s.db.SaveDownsampleCheckpoints([]*postgres.DownsampleCheckpoint{
+		{
+			StreamID:        stream.StreamID,
+			SensorID:        12,
+			LastForwardedAt: forwardedAt,
+		},
+		{
+			// unknown streams are skipped
+			StreamID:        uuid.New().String(),
+			SensorID:        12,
+			LastForwardedAt: forwardedAt,
+		},
+	})
+	assert.Nil(s.T(), err)
+
+	checkpoints, err := s.db.GetDownsampleCheckpoints()
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), checkpoints, 1)
+	assert.Equal(s.T(), stream.StreamID, checkpoints[0].StreamID)
+	assert.Equal(s.T(), 12, checkpoints[0].SensorID)
+	assert.True(s.T(), forwardedAt.Equal(checkpoints[0].LastForwardedAt))
+
+	_, err = s.db.DeleteStream(stream)
+	assert.Nil(s.T(), err)
+
+	// deleted streams are kept until purged
+	_, err = s.db.PurgeDeletedStreams(time.Now().Add(time.Minute))
+	assert.Nil(s.T(), err)
+
+	checkpoints, err = s.db.GetDownsampleCheckpoints()
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), checkpoints, 0)
+}
+
+func (s *PostgresSuite) TestMessageKeys() {
+	seenAt := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	err := s.db.SaveMessageKeys([]*postgres.MessageKey{
+		{Key: "old", SeenAt: seenAt.Add(-time.Hour)},
+		{Key: "new", SeenAt: seenAt},
+	})
+	assert.Nil(s.T(), err)
+
+	// saving a key again keeps the time it was first seen
+	err = s.db.SaveMessageKeys([]*postgres.MessageKey{
+		{Key: "new", SeenAt: seenAt.Add(time.Minute)},
+	})
+	assert.Nil(s.T(), err)
+
+	keys, err := s.db.GetMessageKeys(seenAt.Add(-time.Minute))
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), keys, 1)
+	assert.Equal(s.T(), "new", keys[0].Key)
+	assert.True(s.T(), seenAt.Equal(keys[0].SeenAt))
+
+	deleted, err := s.db.PruneMessageKeys(seenAt)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), int64(1), deleted)
+
+	keys, err = s.db.GetMessageKeys(time.Time{})
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), keys, 1)
+}
+
+func (s *PostgresSuite) TestSetConversions() {
+	stream, err := s.db.CreateStream(&postgres.Stream{
+		CommunityID: "policy-id",
+		PublicKey:   "public",
+		Device: &postgres.Device{
+			DeviceToken: "123",
+			Label:       "device",
+			Longitude:   45.2,
+			Latitude:    23.2,
+			Exposure:    "indoor",
+		},
+	})
+	assert.Nil(s.T(), err)
+
+	got, err := s.db.GetStream(stream.StreamID, stream.Token.Reveal())
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), got.Conversions, 0)
+
+	err = s.db.SetConversions(stream.StreamID, stream.Token.Reveal(), postgres.Conversions{12: "x * 0.0625 - 40"})
+	assert.Nil(s.T(), err)
+
+	got, err = s.db.GetStream(stream.StreamID, stream.Token.Reveal())
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), postgres.Conversions{12: "x * 0.0625 - 40"}, got.Conversions)
+
+	device, err := s.db.GetDevice("123")
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), postgres.Conversions{12: "x * 0.0625 - 40"}, device.Streams[0].Conversions)
+
+	err = s.db.SetConversions(stream.StreamID, "invalid", postgres.Conversions{})
+	assert.Equal(s.T(), postgres.ErrStreamNotFound, err)
+}
+
+func (s *PostgresSuite) TestSetIngestSecret() {
+	stream, err := s.db.CreateStream(&postgres.Stream{
+		CommunityID: "policy-id",
+		PublicKey:   "public",
+		Device: &postgres.Device{
+			DeviceToken: "123",
+			Label:       "device",
+			Longitude:   45.2,
+			Latitude:    23.2,
+			Exposure:    "indoor",
+		},
+	})
+	assert.Nil(s.T(), err)
+
+	device, err := s.db.GetDevice("123")
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), secret.Secret(""), device.Streams[0].IngestSecret)
+
+	err = s.db.SetIngestSecret(stream.StreamID, stream.Token.Reveal(), "shared")
+	assert.Nil(s.T(), err)
+
+	device, err = s.db.GetDevice("123")
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), secret.Secret("shared"), device.Streams[0].IngestSecret)
+
+	err = s.db.SetIngestSecret(stream.StreamID, "invalid", "other")
+	assert.Equal(s.T(), postgres.ErrStreamNotFound, err)
+}
+
+func (s *PostgresSuite) TestExportImportStreams() {
+	stream, err := s.db.CreateStream(&postgres.Stream{
+		CommunityID: "policy-id",
+		PublicKey:   "public",
+		Operations:  postgres.Operations{{SensorID: 12, Action: postgres.Share}},
+		Device: &postgres.Device{
+			DeviceToken: "123",
+			Label:       "device",
+			Longitude:   45.2,
+			Latitude:    23.2,
+			Exposure:    "indoor",
+		},
+	})
+	assert.Nil(s.T(), err)
+
+	err = s.db.SetIngestSecret(stream.StreamID, stream.Token.Reveal(), "shared")
+	assert.Nil(s.T(), err)
+
+	exported := []*postgres.Stream{}
+
+	err = s.db.ExportStreams(func(stream *postgres.Stream) error {
+		exported = append(exported, stream)
+		return nil
+	})
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), exported, 1)
+	assert.Equal(s.T(), stream.Token, exported[0].Token)
+	assert.Equal(s.T(), secret.Secret("shared"), exported[0].IngestSecret)
+	assert.Equal(s.T(), secret.Secret("123"), exported[0].Device.DeviceToken)
+
+	_, err = s.db.ImportStream(exported[0])
+	assert.Equal(s.T(), postgres.ErrStreamExists, err)
+
+	_, err = s.db.DeleteStream(stream)
+	assert.Nil(s.T(), err)
+
+	_, err = s.db.PurgeDeletedStreams(time.Now().Add(time.Minute))
+	assert.Nil(s.T(), err)
+
+	_, err = s.db.ImportStream(exported[0])
+	assert.Nil(s.T(), err)
+
+	got, err := s.db.GetStream(stream.StreamID, stream.Token.Reveal())
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), "policy-id", got.CommunityID)
+
+	device, err := s.db.GetDevice("123")
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), secret.Secret("shared"), device.Streams[0].IngestSecret)
+
+	imported := *exported[0]
+	imported.StreamID = "e2a1c3b4-5d6e-4f70-8a9b-0c1d2e3f4a5b"
+
+	_, err = s.db.ImportStream(&imported)
+	assert.Equal(s.T(), postgres.ErrStreamConflict, err)
+}
+
+func (s *PostgresSuite) TestGetStream() {
+	stream, err := s.db.CreateStream(&postgres.Stream{
+		CommunityID:     "policy-id",
+		PublicKey:       "public",
+		Source:          "amqp",
+		Compression:     "zstd",
+		TimestampPolicy: "server",
+		Device: &postgres.Device{
+			DeviceToken: "123",
+			Label:       "device",
+			Longitude:   45.2,
+			Latitude:    23.2,
+			Exposure:    "indoor",
+		},
+	})
+	assert.Nil(s.T(), err)
+
+	got, err := s.db.GetStream(stream.StreamID, stream.Token.Reveal())
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), stream.StreamID, got.StreamID)
+	assert.Equal(s.T(), "public", got.PublicKey)
+	assert.Equal(s.T(), secret.Secret("123"), got.Device.DeviceToken)
+	assert.Equal(s.T(), "device", got.Device.Label)
+	assert.Equal(s.T(), "amqp", got.Source)
+	assert.Equal(s.T(), "zstd", got.Compression)
+	assert.Equal(s.T(), "server", got.TimestampPolicy)
+	assert.Len(s.T(), got.Device.Streams, 1)
+
+	device, err := s.db.GetDevice("123")
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), "amqp", device.Streams[0].Source)
+	assert.Equal(s.T(), "zstd", device.Streams[0].Compression)
+	assert.Equal(s.T(), "server", device.Streams[0].TimestampPolicy)
+
+	_, err = s.db.GetStream(stream.StreamID, "invalid")
+	assert.Equal(s.T(), postgres.ErrStreamNotFound, err)
+
+	streams, err := s.db.ListStreams()
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), streams, 1)
+	assert.Equal(s.T(), stream.StreamID, streams[0].StreamID)
+	assert.Equal(s.T(), secret.Secret("123"), streams[0].Device.DeviceToken)
+	assert.Equal(s.T(), secret.Secret(""), streams[0].Token)
+}
+
+func (s *PostgresSuite) TestListDeviceStreams() {
+	for _, token := range []string{"123", "123", "456"} {
+		_, err := s.db.CreateStream(&postgres.Stream{
+			CommunityID: uuid.New().String(),
+			PublicKey:   "public",
+			Device: &postgres.Device{
+				DeviceToken: secret.Secret(token),
+				Longitude:   45.2,
+				Latitude:    23.2,
+				Exposure:    "indoor",
+			},
+		})
+		assert.Nil(s.T(), err)
+	}
+
+	streams, err := s.db.ListDeviceStreams("123")
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), streams, 2)
+
+	for _, stream := range streams {
+		assert.Equal(s.T(), secret.Secret("123"), stream.Device.DeviceToken)
+		assert.Equal(s.T(), secret.Secret(""), stream.Token)
+	}
+
+	streams, err = s.db.ListDeviceStreams("unknown")
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), streams, 0)
+}
+
+func (s *PostgresSuite) TestRawPayloads() {
+	now := time.Now()
+
+	for _, payload := range []string{"one", "two", "three"} {
+		err := s.db.SaveRawPayload("123", now, []byte(payload))
+		assert.Nil(s.T(), err)
+	}
+
+	err := s.db.SaveRawPayload("456", now, []byte("other"))
+	assert.Nil(s.T(), err)
+
+	start := now.Add(-time.Minute)
+	end := now.Add(time.Minute)
+
+	count, err := s.db.CountRawPayloads("123", start, end)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 3, count)
+
+	payloads, err := s.db.GetRawPayloads("123", start, end, 0, 2)
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), payloads, 2)
+	assert.Equal(s.T(), []byte("one"), payloads[0].Payload)
+	assert.Equal(s.T(), []byte("two"), payloads[1].Payload)
+
+	payloads, err = s.db.GetRawPayloads("123", start, end, payloads[1].ID, 2)
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), payloads, 1)
+	assert.Equal(s.T(), []byte("three"), payloads[0].Payload)
+
+	count, err = s.db.CountRawPayloads("123", end, end.Add(time.Minute))
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 0, count)
+}
+
+func (s *PostgresSuite) TestPruneRawPayloads() {
+	now := time.Now()
+
+	err := s.db.SaveRawPayload("123", now.Add(-2*time.Hour), []byte("old"))
+	assert.Nil(s.T(), err)
+
+	for _, payload := range []string{"aaaa", "bbbb", "cccc"} {
+		err = s.db.SaveRawPayload("123", now, []byte(payload))
+		assert.Nil(s.T(), err)
+	}
+
+	deleted, err := s.db.PruneRawPayloads(now.Add(-time.Hour), 0)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), int64(1), deleted)
+
+	count, err := s.db.CountRawPayloads("123", now.Add(-3*time.Hour), now.Add(time.Minute))
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 3, count)
+
+	// a cap smaller than any single payload evicts everything
+	deleted, err = s.db.PruneRawPayloads(now.Add(-time.Hour), 1)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), int64(3), deleted)
+}
+
+func (s *PostgresSuite) TestOutbox() {
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	err := s.db.EnqueueOutbox(&postgres.OutboxBatch{
+		Entries: []*postgres.OutboxEntry{
+			{StreamID: "abc", CommunityID: "smartcitizen", DeviceToken: "123", Data: []byte("chunk1"), EnqueuedAt: now.Add(-time.Minute)},
+			{StreamID: "abc", CommunityID: "smartcitizen", DeviceToken: "123", Data: []byte("chunk2"), EnqueuedAt: now.Add(-time.Minute)},
+			{StreamID: "def", CommunityID: "smartcitizen", DeviceToken: "456", DatastoreAddr: "http://datastore.local", DatastoreTimeout: "2s", Data: []byte("data"), EnqueuedAt: now},
+		},
+	})
+	assert.Nil(s.T(), err)
+
+	status, err := s.db.GetOutboxStatus()
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 3, status.Pending)
+	assert.True(s.T(), now.Add(-time.Minute).Equal(status.OldestEnqueuedAt.Time))
+
+	entries, err := s.db.ClaimOutbox(now, now.Add(time.Minute), 2, 0)
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), entries, 2)
+	assert.Equal(s.T(), []byte("chunk1"), entries[0].Data)
+	assert.Equal(s.T(), "123", entries[0].DeviceToken.Reveal())
+	assert.Equal(s.T(), 1, entries[0].Attempts)
+
+	// claimed entries are not claimed again until they are due to be retried
+	retried, err := s.db.ClaimOutbox(now, now.Add(time.Minute), 10, 0)
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), retried, 1)
+	assert.Equal(s.T(), "http://datastore.local", retried[0].DatastoreAddr)
+	assert.Equal(s.T(), "2s", retried[0].DatastoreTimeout)
+
+	err = s.db.MarkOutboxDelivered(entries[0].ID, now)
+	assert.Nil(s.T(), err)
+
+	retried, err = s.db.ClaimOutbox(now.Add(time.Minute), now.Add(2*time.Minute), 10, 0)
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), retried, 2)
+	assert.Equal(s.T(), 2, retried[0].Attempts)
+
+	status, err = s.db.GetOutboxStatus()
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 2, status.Pending)
+
+	deleted, err := s.db.PruneOutbox(now.Add(time.Second))
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), int64(1), deleted)
+}
+
+func (s *PostgresSuite) TestOutboxDeliverAfter() {
+	now := time.Now().UTC().Truncate(time.Second)
+
+	err := s.db.EnqueueOutbox(&postgres.OutboxBatch{
+		Entries: []*postgres.OutboxEntry{
+			{StreamID: "abc", CommunityID: "smartcitizen", DeviceToken: "123", Data: []byte("data"), EnqueuedAt: now, DeliverAfter: null.TimeFrom(now.Add(time.Hour))},
+		},
+	})
+	assert.Nil(s.T(), err)
+
+	// held entries are neither claimed nor counted as pending before they are
+	// due
+	status, err := s.db.GetOutboxStatus()
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 0, status.Pending)
+
+	entries, err := s.db.ClaimOutbox(now, now.Add(time.Minute), 10, 0)
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), entries, 0)
+
+	entries, err = s.db.ClaimOutbox(now.Add(time.Hour), now.Add(time.Hour+time.Minute), 10, 0)
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), entries, 1)
+}
+
+func (s *PostgresSuite) TestOutboxBookkeeping() {
+	stream, err := s.db.CreateStream(&postgres.Stream{
+		CommunityID: "policy-id",
+		PublicKey:   "public",
+		Device: &postgres.Device{
+			DeviceToken: "123",
+			Longitude:   45.2,
+			Latitude:    23.2,
+			Exposure:    "indoor",
+		},
+	})
+	assert.Nil(s.T(), err)
+
+	err = s.db.SaveStreamStats([]*postgres.StreamStats{
+		{StreamID: stream.StreamID, MessagesReceived: 2, BytesEncrypted: 100},
+	})
+	assert.Nil(s.T(), err)
+
+	seenAt := time.Now().UTC().Truncate(time.Second)
+
+	err = s.db.EnqueueOutbox(&postgres.OutboxBatch{
+		Entries: []*postgres.OutboxEntry{
+			{StreamID: stream.StreamID, CommunityID: "policy-id", DeviceToken: "123", Data: []byte("data"), EnqueuedAt: seenAt},
+		},
+		MessageKey: &postgres.MessageKey{Key: "abc", SeenAt: seenAt},
+		Encrypted:  map[string]int{stream.StreamID: 24},
+	})
+	assert.Nil(s.T(), err)
+
+	// the bytes encrypted are added to the stream's stats, and the message's
+	// key saved, with its payloads
+	stats, err := s.db.GetStreamStats()
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), stats, 1)
+	assert.Equal(s.T(), uint64(2), stats[0].MessagesReceived)
+	assert.Equal(s.T(), uint64(124), stats[0].BytesEncrypted)
+
+	keys, err := s.db.GetMessageKeys(seenAt.Add(-time.Minute))
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), keys, 1)
+	assert.Equal(s.T(), "abc", keys[0].Key)
+
+	status, err := s.db.GetOutboxStatus()
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 1, status.Pending)
+}
+
+func (s *PostgresSuite) TestOutboxDeadLetter() {
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	err := s.db.EnqueueOutbox(&postgres.OutboxBatch{
+		Entries: []*postgres.OutboxEntry{
+			{StreamID: "abc", CommunityID: "smartcitizen", DeviceToken: "123", Data: []byte("data"), EnqueuedAt: now},
+		},
+	})
+	assert.Nil(s.T(), err)
+
+	entries, err := s.db.ClaimOutbox(now, now.Add(time.Minute), 10, 2)
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), entries, 1)
+
+	entries, err = s.db.ClaimOutbox(now.Add(time.Minute), now.Add(2*time.Minute), 10, 2)
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), entries, 1)
+	assert.Equal(s.T(), 2, entries[0].Attempts)
+
+	// once its attempts are exhausted the entry is dead, and kept but not
+	// claimed or counted as pending
+	entries, err = s.db.ClaimOutbox(now.Add(2*time.Minute), now.Add(3*time.Minute), 10, 2)
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), entries, 0)
+
+	status, err := s.db.GetOutboxStatus()
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 0, status.Pending)
+	assert.Equal(s.T(), 1, status.Dead)
+
+	deleted, err := s.db.PruneOutbox(now.Add(time.Hour))
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), int64(0), deleted)
+}
+
+func (s *PostgresSuite) TestLeases() {
+	for _, token := range []string{"123", "124", "125"} {
+		_, err := s.db.CreateStream(&postgres.Stream{
+			CommunityID: "policy-id",
+			PublicKey:   "public",
+			Device: &postgres.Device{
+				DeviceToken: secret.Secret(token),
+				Longitude:   45.2,
+				Latitude:    23.2,
+				Exposure:    "indoor",
+			},
+		})
+		assert.Nil(s.T(), err)
+	}
+
+	live, err := s.db.Heartbeat("a", time.Minute)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 1, live)
+
+	live, err = s.db.Heartbeat("b", time.Minute)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 2, live)
+
+	count, err := s.db.CountDevices()
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 3, count)
+
+	devices, err := s.db.AcquireLeases("a", time.Minute, 2)
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), devices, 2)
+	assert.Equal(s.T(), secret.Secret("123"), devices[0].DeviceToken)
+	assert.Equal(s.T(), secret.Secret("124"), devices[1].DeviceToken)
+
+	devices, err = s.db.AcquireLeases("b", time.Minute, 2)
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), devices, 1)
+	assert.Equal(s.T(), secret.Secret("125"), devices[0].DeviceToken)
+
+	acquired, err := s.db.AcquireLease("b", "123", time.Minute)
+	assert.Nil(s.T(), err)
+	assert.False(s.T(), acquired)
+
+	err = s.db.ReleaseLease("a", "123")
+	assert.Nil(s.T(), err)
+
+	acquired, err = s.db.AcquireLease("b", "123", time.Minute)
+	assert.Nil(s.T(), err)
+	assert.True(s.T(), acquired)
+
+	devices, err = s.db.RenewLeases("a", time.Minute)
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), devices, 1)
+	assert.Equal(s.T(), secret.Secret("124"), devices[0].DeviceToken)
+
+	// expired leases may be acquired by another instance
+	devices, err = s.db.RenewLeases("b", -time.Minute)
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), devices, 2)
+
+	devices, err = s.db.AcquireLeases("a", time.Minute, 5)
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), devices, 2)
+
+	err = s.db.RemoveInstance("a")
+	assert.Nil(s.T(), err)
+
+	devices, err = s.db.RenewLeases("a", time.Minute)
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), devices, 0)
+}
+
+func (s *PostgresSuite) TestMigrationStatus() {
+	status, err := s.db.MigrationStatus()
+	assert.Nil(s.T(), err)
+	assert.False(s.T(), status.Dirty)
+	assert.NotEmpty(s.T(), status.Migrations)
+
+	latest := status.Migrations[len(status.Migrations)-1]
+	assert.Equal(s.T(), latest.Version, status.Version)
+
+	for _, m := range status.Migrations {
+		assert.True(s.T(), m.Applied)
+	}
+
+	first := status.Migrations[0]
+
+	err = postgres.MigrateTo(s.db.DB.DB, first.Version, kitlog.NewNopLogger())
+	assert.Nil(s.T(), err)
+
+	status, err = s.db.MigrationStatus()
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), first.Version, status.Version)
+	assert.True(s.T(), status.Migrations[0].Applied)
+	assert.False(s.T(), status.Migrations[1].Applied)
+
+	err = s.db.MigrateUp()
+	assert.Nil(s.T(), err)
+}
+
+func (s *PostgresSuite) TestCheck() {
+	err := s.db.Check()
+	assert.Nil(s.T(), err)
+
+	err = postgres.MigrateDownAll(s.db.DB.DB, kitlog.NewNopLogger())
+	assert.Nil(s.T(), err)
+
+	err = s.db.Check()
+	assert.NotNil(s.T(), err)
+}
+
 func TestRunPostgresSuite(t *testing.T) {
 	suite.Run(t, new(PostgresSuite))
 }
@@ -0,0 +1,1118 @@
+// Package postgrestest provides an in-memory implementation of the storage
+// methods of postgres.DB, intended for use in tests of consumers of the
+// encoder that would otherwise require a running Postgres instance.
+package postgrestest
+
+import (
+	"database/sql"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/pubkey"
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+)
+
+// DB is an in-memory fake of postgres.DB. It implements the same methods used
+// by the rpc, admin, audit, stats, replay and retention packages, mirroring the
+// behaviour of the real implementation including token verification, soft
+// deletion of streams, and device cleanup when deleted streams are purged. Values passed
+// in and returned are copies, so callers may not mutate the fake's state.
+type DB struct {
+	sync.RWMutex
+
+	nextDeviceID int
+	nextPayload  int64
+	nextAudit    int64
+	devices      map[secret.Secret]*postgres.Device
+	streams      []*postgres.Stream
+	deleted      []*deletedStream
+	stats        map[string]*postgres.StreamStats
+	statuses     map[secret.Secret]*postgres.DeviceStatus
+	payloads     []*postgres.RawPayload
+	audit        []*postgres.AuditEntry
+	schemas      []*postgres.Schema
+	ledger       map[ledgerKey]float64
+	instances    map[string]time.Time
+	leases       map[secret.Secret]*lease
+	clock        clock.Clock
+}
+
+// deletedStream records a soft deleted stream and when it was deleted.
+type deletedStream struct {
+	stream    *postgres.Stream
+	deletedAt time.Time
+}
+
+// ledgerKey identifies the privacy budget of a stream for a single period.
+type ledgerKey struct {
+	streamID    string
+	periodStart int64
+}
+
+// lease records the instance holding a device lease and when it expires.
+type lease struct {
+	instanceID string
+	expiresAt  time.Time
+}
+
+// NewDB returns a new empty in-memory DB.
+func NewDB() *DB {
+	return &DB{
+		devices:   make(map[secret.Secret]*postgres.Device),
+		stats:     make(map[string]*postgres.StreamStats),
+		statuses:  make(map[secret.Secret]*postgres.DeviceStatus),
+		ledger:    make(map[ledgerKey]float64),
+		instances: make(map[string]time.Time),
+		leases:    make(map[secret.Secret]*lease),
+		clock:     clock.New(),
+	}
+}
+
+// SetClock replaces the clock used to expire leases and instance heartbeats,
+// allowing tests to simulate the passing of time.
+func (d *DB) SetClock(c clock.Clock) {
+	d.Lock()
+	defer d.Unlock()
+
+	d.clock = c
+}
+
+// CreateStream stores the given stream, upserting its device. As in Postgres
+// a device may only be registered once within a community, and operations
+// must be valid.
+func (d *DB) CreateStream(stream *postgres.Stream) (*postgres.Stream, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	err := stream.Operations.Validate()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create stream")
+	}
+
+	for _, s := range d.streams {
+		if s.Device.DeviceToken == stream.Device.DeviceToken && s.CommunityID == stream.CommunityID {
+			return nil, errors.New("failed to create stream: device already registered within community")
+		}
+	}
+
+	device := d.upsertDevice(stream.Device)
+
+	token, err := postgres.GenerateToken(postgres.TokenLength)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate random token")
+	}
+
+	stored := &postgres.Stream{
+		StreamID:             uuid.New().String(),
+		Token:                secret.Secret(token),
+		CommunityID:          stream.CommunityID,
+		PublicKey:            stream.PublicKey,
+		Operations:           stream.Operations,
+		DatastoreAddr:        stream.DatastoreAddr,
+		Conversions:          copyConversions(stream.Conversions),
+		Source:               stream.Source,
+		Compression:          stream.Compression,
+		TimestampPolicy:      stream.TimestampPolicy,
+		PolicyID:             stream.PolicyID,
+		Labels:               stream.Labels,
+		DatastoreTimeout:     stream.DatastoreTimeout,
+		Schema:               stream.Schema,
+		Dispositions:         stream.Dispositions,
+		Privacy:              stream.Privacy,
+		GeoPrivacy:           stream.GeoPrivacy,
+		RateLimit:            stream.RateLimit,
+		Sampling:             stream.Sampling,
+		Schedule:             stream.Schedule,
+		Geofence:             stream.Geofence,
+		PublicKeyFingerprint: stream.PublicKeyFingerprint,
+		Archive:              stream.Archive,
+		Priority:             stream.Priority,
+		Topic:                stream.Topic,
+		QoS:                  stream.QoS,
+		Device:               device,
+	}
+
+	d.streams = append(d.streams, stored)
+
+	stream.StreamID = stored.StreamID
+	stream.Token = stored.Token
+
+	return stream, nil
+}
+
+// DeleteStream soft deletes the stream matching the given stream's id and
+// token. If this was the last live stream for the device, the device is
+// returned.
+func (d *DB) DeleteStream(stream *postgres.Stream) (*postgres.Device, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	idx := d.find(stream.StreamID, stream.Token)
+	if idx == -1 {
+		return nil, errors.New("failed to delete stream: sql: no rows in result set")
+	}
+
+	deleted := d.streams[idx]
+	deviceToken := deleted.Device.DeviceToken
+
+	d.streams = append(d.streams[:idx], d.streams[idx+1:]...)
+	d.deleted = append(d.deleted, &deletedStream{stream: deleted, deletedAt: d.clock.Now()})
+
+	if d.live(deviceToken) {
+		return nil, nil
+	}
+
+	delete(d.leases, deviceToken)
+
+	return &postgres.Device{ID: deleted.Device.ID, DeviceToken: deviceToken}, nil
+}
+
+// RestoreStream restores the soft deleted stream with the given id and token,
+// returning postgres.ErrStreamNotFound if there is no such deleted stream, or
+// postgres.ErrStreamConflict if the device has since been registered again
+// within the stream's community.
+func (d *DB) RestoreStream(streamID, token string) (*postgres.Stream, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	for i, ds := range d.deleted {
+		if ds.stream.StreamID != streamID || ds.stream.Token != secret.Secret(token) {
+			continue
+		}
+
+		for _, s := range d.streams {
+			if s.Device.DeviceToken == ds.stream.Device.DeviceToken && s.CommunityID == ds.stream.CommunityID {
+				return nil, postgres.ErrStreamConflict
+			}
+		}
+
+		d.deleted = append(d.deleted[:i], d.deleted[i+1:]...)
+		d.streams = append(d.streams, ds.stream)
+
+		stream := copyStream(ds.stream)
+		stream.Token = ds.stream.Token
+
+		return stream, nil
+	}
+
+	return nil, postgres.ErrStreamNotFound
+}
+
+// PurgeDeletedStreams permanently deletes streams soft deleted before the
+// given time, along with any devices left without streams.
+func (d *DB) PurgeDeletedStreams(before time.Time) (int64, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	var purged int64
+
+	kept := []*deletedStream{}
+	for _, ds := range d.deleted {
+		if ds.deletedAt.Before(before) {
+			delete(d.stats, ds.stream.StreamID)
+			for key := range d.ledger {
+				if key.streamID == ds.stream.StreamID {
+					delete(d.ledger, key)
+				}
+			}
+			purged++
+		} else {
+			kept = append(kept, ds)
+		}
+	}
+	d.deleted = kept
+
+	for token := range d.devices {
+		if d.live(token) {
+			continue
+		}
+
+		retained := false
+		for _, ds := range d.deleted {
+			if ds.stream.Device.DeviceToken == token {
+				retained = true
+			}
+		}
+
+		if !retained {
+			delete(d.devices, token)
+			delete(d.leases, token)
+			delete(d.statuses, token)
+		}
+	}
+
+	return purged, nil
+}
+
+// GetDevices returns all devices with live streams, without their streams.
+func (d *DB) GetDevices() ([]*postgres.Device, error) {
+	d.RLock()
+	defer d.RUnlock()
+
+	devices := []*postgres.Device{}
+	for _, device := range d.devices {
+		if !d.live(device.DeviceToken) {
+			continue
+		}
+
+		devices = append(devices, &postgres.Device{
+			ID:          device.ID,
+			DeviceToken: device.DeviceToken,
+		})
+	}
+
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].ID < devices[j].ID
+	})
+
+	return devices, nil
+}
+
+// GetDevice returns the device with the given token along with all of its
+// live streams. Stream tokens are not returned, and a device without live
+// streams is not found.
+func (d *DB) GetDevice(deviceToken secret.Secret) (*postgres.Device, error) {
+	d.RLock()
+	defer d.RUnlock()
+
+	device, ok := d.devices[deviceToken]
+	if !ok || !d.live(deviceToken) {
+		return nil, errors.Wrap(sql.ErrNoRows, "failed to load device")
+	}
+
+	c := copyDevice(device)
+	c.Streams = []*postgres.Stream{}
+
+	for _, s := range d.streams {
+		if s.Device.DeviceToken == deviceToken {
+			c.Streams = append(c.Streams, &postgres.Stream{
+				StreamID:             s.StreamID,
+				CommunityID:          s.CommunityID,
+				PublicKey:            s.PublicKey,
+				Operations:           s.Operations,
+				DatastoreAddr:        s.DatastoreAddr,
+				Conversions:          s.Conversions,
+				Source:               s.Source,
+				Compression:          s.Compression,
+				TimestampPolicy:      s.TimestampPolicy,
+				PolicyID:             s.PolicyID,
+				Labels:               s.Labels,
+				DatastoreTimeout:     s.DatastoreTimeout,
+				Schema:               s.Schema,
+				Dispositions:         s.Dispositions,
+				Privacy:              s.Privacy,
+				GeoPrivacy:           s.GeoPrivacy,
+				RateLimit:            s.RateLimit,
+				Sampling:             s.Sampling,
+				Schedule:             s.Schedule,
+				Geofence:             s.Geofence,
+				PublicKeyFingerprint: s.PublicKeyFingerprint,
+				Archive:              s.Archive,
+				Priority:             s.Priority,
+				Topic:                s.Topic,
+				QoS:                  s.QoS,
+				IngestSecret:         s.IngestSecret,
+			})
+		}
+	}
+
+	return c, nil
+}
+
+// GetStream returns the stream with the given id and token, or
+// postgres.ErrStreamNotFound.
+func (d *DB) GetStream(streamID, token string) (*postgres.Stream, error) {
+	d.RLock()
+	defer d.RUnlock()
+
+	idx := d.find(streamID, secret.Secret(token))
+	if idx == -1 {
+		return nil, postgres.ErrStreamNotFound
+	}
+
+	stream := copyStream(d.streams[idx])
+	stream.Token = secret.Secret(token)
+
+	return stream, nil
+}
+
+// SetConversions replaces the conversions of the stream with the given id and
+// token, or returns postgres.ErrStreamNotFound.
+func (d *DB) SetConversions(streamID, token string, conversions postgres.Conversions) error {
+	d.Lock()
+	defer d.Unlock()
+
+	idx := d.find(streamID, secret.Secret(token))
+	if idx == -1 {
+		return postgres.ErrStreamNotFound
+	}
+
+	d.streams[idx].Conversions = copyConversions(conversions)
+
+	return nil
+}
+
+// SetIngestSecret replaces the ingest secret of the stream with the given id
+// and token, or returns postgres.ErrStreamNotFound.
+func (d *DB) SetIngestSecret(streamID, token string, ingestSecret secret.Secret) error {
+	d.Lock()
+	defer d.Unlock()
+
+	idx := d.find(streamID, secret.Secret(token))
+	if idx == -1 {
+		return postgres.ErrStreamNotFound
+	}
+
+	d.streams[idx].IngestSecret = ingestSecret
+
+	return nil
+}
+
+// SetPolicyPublicKey replaces the public key of every live stream with the
+// given policy id whose key differs, returning the number of streams updated.
+func (d *DB) SetPolicyPublicKey(policyID, publicKey string) (int64, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	var updated int64
+
+	for _, s := range d.streams {
+		if s.PolicyID == policyID && s.PublicKey != publicKey {
+			s.PublicKey = publicKey
+			s.PublicKeyFingerprint = pubkey.Fingerprint(publicKey)
+			updated++
+		}
+	}
+
+	return updated, nil
+}
+
+// ListStreams returns all streams in creation order, without their tokens.
+func (d *DB) ListStreams() ([]*postgres.Stream, error) {
+	d.RLock()
+	defer d.RUnlock()
+
+	streams := []*postgres.Stream{}
+	for _, s := range d.streams {
+		streams = append(streams, copyStream(s))
+	}
+
+	return streams, nil
+}
+
+// CountCommunityStreams returns the number of live streams within the given
+// community.
+func (d *DB) CountCommunityStreams(communityID string) (int, error) {
+	d.RLock()
+	defer d.RUnlock()
+
+	count := 0
+	for _, s := range d.streams {
+		if s.CommunityID == communityID {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// ListDeviceStreams returns the streams of the device with the given token in
+// creation order, without their tokens.
+func (d *DB) ListDeviceStreams(deviceToken secret.Secret) ([]*postgres.Stream, error) {
+	d.RLock()
+	defer d.RUnlock()
+
+	streams := []*postgres.Stream{}
+	for _, s := range d.streams {
+		if s.Device.DeviceToken == deviceToken {
+			streams = append(streams, copyStream(s))
+		}
+	}
+
+	return streams, nil
+}
+
+// ExportStreams calls fn with a copy of every live stream in creation order,
+// including its token and ingest secret.
+func (d *DB) ExportStreams(fn func(stream *postgres.Stream) error) error {
+	d.RLock()
+	defer d.RUnlock()
+
+	for _, s := range d.streams {
+		stream := copyStream(s)
+		stream.Token = s.Token
+		stream.IngestSecret = s.IngestSecret
+
+		err := fn(stream)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ImportStream stores the given stream keeping its id, token and ingest
+// secret, and upserting its device. Returns postgres.ErrStreamExists if a
+// stream with the same id exists, or postgres.ErrStreamConflict if the device
+// is already registered within the stream's community.
+func (d *DB) ImportStream(stream *postgres.Stream) (*postgres.Stream, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	err := stream.Operations.Validate()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to import stream")
+	}
+
+	if d.exists(stream.StreamID) {
+		return nil, postgres.ErrStreamExists
+	}
+
+	for _, s := range d.streams {
+		if s.Device.DeviceToken == stream.Device.DeviceToken && s.CommunityID == stream.CommunityID {
+			return nil, postgres.ErrStreamConflict
+		}
+	}
+
+	stored := copyStream(stream)
+	stored.Token = stream.Token
+	stored.IngestSecret = stream.IngestSecret
+	stored.Conversions = copyConversions(stream.Conversions)
+	stored.Device = d.upsertDevice(stream.Device)
+
+	d.streams = append(d.streams, stored)
+
+	stream.Device.ID = stored.Device.ID
+
+	return stream, nil
+}
+
+// SaveStreamStats upserts the given stats, skipping unknown streams.
+func (d *DB) SaveStreamStats(stats []*postgres.StreamStats) error {
+	d.Lock()
+	defer d.Unlock()
+
+	for _, st := range stats {
+		if d.exists(st.StreamID) {
+			c := *st
+			d.stats[st.StreamID] = &c
+		}
+	}
+
+	return nil
+}
+
+// GetStreamStats returns all saved stats.
+func (d *DB) GetStreamStats() ([]*postgres.StreamStats, error) {
+	d.RLock()
+	defer d.RUnlock()
+
+	stats := []*postgres.StreamStats{}
+	for _, st := range d.stats {
+		c := *st
+		stats = append(stats, &c)
+	}
+
+	return stats, nil
+}
+
+// SaveDeviceStatuses upserts the given statuses, skipping unknown devices.
+func (d *DB) SaveDeviceStatuses(statuses []*postgres.DeviceStatus) error {
+	d.Lock()
+	defer d.Unlock()
+
+	for _, st := range statuses {
+		if _, ok := d.devices[st.DeviceToken]; ok {
+			c := *st
+			c.MessageCounts = copyCounts(st.MessageCounts)
+			d.statuses[st.DeviceToken] = &c
+		}
+	}
+
+	return nil
+}
+
+// GetDeviceStatuses returns all saved device statuses.
+func (d *DB) GetDeviceStatuses() ([]*postgres.DeviceStatus, error) {
+	d.RLock()
+	defer d.RUnlock()
+
+	statuses := []*postgres.DeviceStatus{}
+	for _, st := range d.statuses {
+		c := *st
+		c.MessageCounts = copyCounts(st.MessageCounts)
+		statuses = append(statuses, &c)
+	}
+
+	return statuses, nil
+}
+
+// copyCounts returns a copy of the given message counts.
+func copyCounts(counts postgres.MessageCounts) postgres.MessageCounts {
+	c := make(postgres.MessageCounts, len(counts))
+	for k, v := range counts {
+		c[k] = v
+	}
+	return c
+}
+
+// SaveRawPayload retains the given payload.
+func (d *DB) SaveRawPayload(deviceToken string, receivedAt time.Time, payload []byte) error {
+	d.Lock()
+	defer d.Unlock()
+
+	d.nextPayload++
+
+	d.payloads = append(d.payloads, &postgres.RawPayload{
+		ID:          d.nextPayload,
+		DeviceToken: deviceToken,
+		ReceivedAt:  receivedAt,
+		Payload:     append([]byte(nil), payload...),
+	})
+
+	return nil
+}
+
+// CountRawPayloads returns the number of payloads retained for the device
+// within the given interval.
+func (d *DB) CountRawPayloads(deviceToken string, start, end time.Time) (int, error) {
+	d.RLock()
+	defer d.RUnlock()
+
+	count := 0
+
+	for _, p := range d.payloads {
+		if p.DeviceToken == deviceToken && !p.ReceivedAt.Before(start) && p.ReceivedAt.Before(end) {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// GetRawPayloads returns a page of payloads retained for the device within the
+// given interval, ordered by id.
+func (d *DB) GetRawPayloads(deviceToken string, start, end time.Time, afterID int64, limit int) ([]*postgres.RawPayload, error) {
+	d.RLock()
+	defer d.RUnlock()
+
+	payloads := []*postgres.RawPayload{}
+
+	for _, p := range d.payloads {
+		if len(payloads) >= limit {
+			break
+		}
+
+		if p.DeviceToken == deviceToken && p.ID > afterID && !p.ReceivedAt.Before(start) && p.ReceivedAt.Before(end) {
+			c := *p
+			payloads = append(payloads, &c)
+		}
+	}
+
+	return payloads, nil
+}
+
+// PruneRawPayloads deletes payloads received before the given time, and then
+// the oldest payloads until the total payload size is within maxSize.
+func (d *DB) PruneRawPayloads(before time.Time, maxSize int64) (int64, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	var (
+		total int64
+		kept  []*postgres.RawPayload
+	)
+
+	// walk newest first so we keep the most recent payloads
+	for i := len(d.payloads) - 1; i >= 0; i-- {
+		p := d.payloads[i]
+
+		if p.ReceivedAt.Before(before) {
+			continue
+		}
+
+		total += int64(len(p.Payload))
+
+		if maxSize > 0 && total > maxSize {
+			continue
+		}
+
+		kept = append([]*postgres.RawPayload{p}, kept...)
+	}
+
+	deleted := int64(len(d.payloads) - len(kept))
+	d.payloads = kept
+
+	return deleted, nil
+}
+
+// SaveAuditEntry appends the given entry to the audit log, setting its ID.
+func (d *DB) SaveAuditEntry(entry *postgres.AuditEntry) error {
+	d.Lock()
+	defer d.Unlock()
+
+	d.nextAudit++
+	entry.ID = d.nextAudit
+
+	c := *entry
+	c.Params = copyParams(entry.Params)
+	d.audit = append(d.audit, &c)
+
+	return nil
+}
+
+// GetAuditEntries returns a page of audit entries recorded within the given
+// interval, optionally only those for the given stream, ordered by id.
+func (d *DB) GetAuditEntries(streamID string, start, end time.Time, afterID int64, limit int) ([]*postgres.AuditEntry, error) {
+	d.RLock()
+	defer d.RUnlock()
+
+	entries := []*postgres.AuditEntry{}
+
+	for _, e := range d.audit {
+		if len(entries) >= limit {
+			break
+		}
+
+		if streamID != "" && e.StreamID.String != streamID {
+			continue
+		}
+
+		if e.ID > afterID && !e.RecordedAt.Before(start) && e.RecordedAt.Before(end) {
+			c := *e
+			c.Params = copyParams(e.Params)
+			entries = append(entries, &c)
+		}
+	}
+
+	return entries, nil
+}
+
+// copyParams returns a shallow copy of the given audit params.
+func copyParams(params postgres.AuditParams) postgres.AuditParams {
+	c := make(postgres.AuditParams, len(params))
+	for k, v := range params {
+		c[k] = v
+	}
+	return c
+}
+
+// CreateSchema registers a new version of the schema of the given kind, one
+// greater than its latest existing version.
+func (d *DB) CreateSchema(kind string, channels postgres.Channels) (*postgres.Schema, error) {
+	err := postgres.ValidateKind(kind)
+	if err != nil {
+		return nil, err
+	}
+
+	err = channels.Validate()
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid channels")
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	schema := &postgres.Schema{
+		Kind:      kind,
+		Version:   1,
+		Channels:  copyChannels(channels),
+		CreatedAt: d.clock.Now(),
+	}
+
+	for _, s := range d.schemas {
+		if s.Kind == kind && s.Version >= schema.Version {
+			schema.Version = s.Version + 1
+		}
+	}
+
+	d.schemas = append(d.schemas, schema)
+
+	return copySchema(schema), nil
+}
+
+// GetSchema returns the given version of the schema of the given kind, or its
+// latest version if version is zero.
+func (d *DB) GetSchema(kind string, version int) (*postgres.Schema, error) {
+	d.RLock()
+	defer d.RUnlock()
+
+	var found *postgres.Schema
+
+	for _, s := range d.schemas {
+		if s.Kind != kind {
+			continue
+		}
+
+		if s.Version == version || (version == 0 && (found == nil || s.Version > found.Version)) {
+			found = s
+		}
+	}
+
+	if found == nil {
+		return nil, postgres.ErrSchemaNotFound
+	}
+
+	return copySchema(found), nil
+}
+
+// ListSchemas returns every version of every registered schema, ordered by
+// kind and version.
+func (d *DB) ListSchemas() ([]*postgres.Schema, error) {
+	d.RLock()
+	defer d.RUnlock()
+
+	schemas := make([]*postgres.Schema, 0, len(d.schemas))
+	for _, s := range d.schemas {
+		schemas = append(schemas, copySchema(s))
+	}
+
+	sort.Slice(schemas, func(i, j int) bool {
+		if schemas[i].Kind != schemas[j].Kind {
+			return schemas[i].Kind < schemas[j].Kind
+		}
+		return schemas[i].Version < schemas[j].Version
+	})
+
+	return schemas, nil
+}
+
+// copySchema returns a deep copy of the given schema.
+func copySchema(schema *postgres.Schema) *postgres.Schema {
+	c := *schema
+	c.Channels = copyChannels(schema.Channels)
+	return &c
+}
+
+// copyChannels returns a deep copy of the given channels.
+func copyChannels(channels postgres.Channels) postgres.Channels {
+	c := make(postgres.Channels, 0, len(channels))
+	for _, channel := range channels {
+		ch := *channel
+		c = append(c, &ch)
+	}
+	return c
+}
+
+// Heartbeat records that the instance is alive for the next ttl, returning the
+// number of live instances.
+func (d *DB) Heartbeat(instanceID string, ttl time.Duration) (int, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	now := d.clock.Now()
+
+	d.instances[instanceID] = now.Add(ttl)
+
+	for id, expiresAt := range d.instances {
+		if expiresAt.Before(now) {
+			delete(d.instances, id)
+		}
+	}
+
+	return len(d.instances), nil
+}
+
+// RemoveInstance deletes the instance and all leases it holds.
+func (d *DB) RemoveInstance(instanceID string) error {
+	d.Lock()
+	defer d.Unlock()
+
+	delete(d.instances, instanceID)
+
+	for token, l := range d.leases {
+		if l.instanceID == instanceID {
+			delete(d.leases, token)
+		}
+	}
+
+	return nil
+}
+
+// CountDevices returns the number of devices.
+func (d *DB) CountDevices() (int, error) {
+	d.RLock()
+	defer d.RUnlock()
+
+	count := 0
+	for token := range d.devices {
+		if d.live(token) {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// RenewLeases extends the leases held by the instance, returning their devices
+// ordered by id.
+func (d *DB) RenewLeases(instanceID string, ttl time.Duration) ([]*postgres.Device, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	devices := []*postgres.Device{}
+
+	for token, l := range d.leases {
+		if l.instanceID == instanceID {
+			l.expiresAt = d.clock.Now().Add(ttl)
+			devices = append(devices, d.leaseDevice(token))
+		}
+	}
+
+	sortDevices(devices)
+
+	return devices, nil
+}
+
+// AcquireLeases acquires up to limit unleased or expired devices in id order.
+func (d *DB) AcquireLeases(instanceID string, ttl time.Duration, limit int) ([]*postgres.Device, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	candidates := []*postgres.Device{}
+
+	for token := range d.devices {
+		if d.live(token) && d.leasable(token, "") {
+			candidates = append(candidates, d.leaseDevice(token))
+		}
+	}
+
+	sortDevices(candidates)
+
+	if len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+
+	for _, device := range candidates {
+		d.leases[device.DeviceToken] = &lease{instanceID: instanceID, expiresAt: d.clock.Now().Add(ttl)}
+	}
+
+	return candidates, nil
+}
+
+// AcquireLease acquires the lease on a single device if it is unleased,
+// expired, or already held by the instance.
+func (d *DB) AcquireLease(instanceID string, deviceToken secret.Secret, ttl time.Duration) (bool, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	if _, ok := d.devices[deviceToken]; !ok || !d.leasable(deviceToken, instanceID) {
+		return false, nil
+	}
+
+	d.leases[deviceToken] = &lease{instanceID: instanceID, expiresAt: d.clock.Now().Add(ttl)}
+
+	return true, nil
+}
+
+// ReleaseLease releases the lease on the device if held by the instance.
+func (d *DB) ReleaseLease(instanceID string, deviceToken secret.Secret) error {
+	d.Lock()
+	defer d.Unlock()
+
+	if l, ok := d.leases[deviceToken]; ok && l.instanceID == instanceID {
+		delete(d.leases, deviceToken)
+	}
+
+	return nil
+}
+
+// LeaseHolder returns the id of the instance holding an unexpired lease on the
+// device, or an empty string if there is none.
+func (d *DB) LeaseHolder(deviceToken string) string {
+	d.RLock()
+	defer d.RUnlock()
+
+	if d.leasable(secret.Secret(deviceToken), "") {
+		return ""
+	}
+
+	return d.leases[secret.Secret(deviceToken)].instanceID
+}
+
+// leasable returns true if the device has no lease, its lease has expired, or
+// its lease is held by the given instance. Must be called with the lock held.
+func (d *DB) leasable(deviceToken secret.Secret, instanceID string) bool {
+	l, ok := d.leases[deviceToken]
+	if !ok {
+		return true
+	}
+
+	return l.expiresAt.Before(d.clock.Now()) || (instanceID != "" && l.instanceID == instanceID)
+}
+
+// leaseDevice returns the id and token of the device, as returned by the lease
+// methods of postgres.DB. Must be called with the lock held.
+func (d *DB) leaseDevice(deviceToken secret.Secret) *postgres.Device {
+	device := d.devices[deviceToken]
+
+	return &postgres.Device{
+		ID:          device.ID,
+		DeviceToken: device.DeviceToken,
+	}
+}
+
+// sortDevices sorts devices by id.
+func sortDevices(devices []*postgres.Device) {
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].ID < devices[j].ID
+	})
+}
+
+// find returns the index of the stream matching the id and token, or -1.
+func (d *DB) find(streamID string, token secret.Secret) int {
+	for i, s := range d.streams {
+		if s.StreamID == streamID && s.Token == token {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// live returns true if the device with the given token has any live streams.
+func (d *DB) live(deviceToken secret.Secret) bool {
+	for _, s := range d.streams {
+		if s.Device.DeviceToken == deviceToken {
+			return true
+		}
+	}
+
+	return false
+}
+
+// upsertDevice stores the given device, keeping the id of any existing device
+// with the same token, and returns the stored device.
+func (d *DB) upsertDevice(device *postgres.Device) *postgres.Device {
+	stored, ok := d.devices[device.DeviceToken]
+	if !ok {
+		d.nextDeviceID++
+		stored = &postgres.Device{
+			ID:          d.nextDeviceID,
+			DeviceToken: device.DeviceToken,
+		}
+		d.devices[stored.DeviceToken] = stored
+	}
+
+	stored.Label = device.Label
+	stored.Longitude = device.Longitude
+	stored.Latitude = device.Latitude
+	stored.Exposure = device.Exposure
+
+	return stored
+}
+
+// exists returns true if a stream with the given id exists, including streams
+// which have been soft deleted but not yet purged.
+func (d *DB) exists(streamID string) bool {
+	for _, ds := range d.deleted {
+		if ds.stream.StreamID == streamID {
+			return true
+		}
+	}
+
+	for _, s := range d.streams {
+		if s.StreamID == streamID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// copyDevice returns a copy of the device without its streams.
+func copyDevice(device *postgres.Device) *postgres.Device {
+	return &postgres.Device{
+		ID:          device.ID,
+		DeviceToken: device.DeviceToken,
+		Label:       device.Label,
+		Longitude:   device.Longitude,
+		Latitude:    device.Latitude,
+		Exposure:    device.Exposure,
+	}
+}
+
+// copyStream returns a copy of the stream, without its token, with a copy of
+// its device as returned by postgres.DB.GetStream.
+func copyStream(s *postgres.Stream) *postgres.Stream {
+	stream := &postgres.Stream{
+		StreamID:             s.StreamID,
+		CommunityID:          s.CommunityID,
+		PublicKey:            s.PublicKey,
+		Operations:           s.Operations,
+		DatastoreAddr:        s.DatastoreAddr,
+		Conversions:          s.Conversions,
+		Source:               s.Source,
+		Compression:          s.Compression,
+		TimestampPolicy:      s.TimestampPolicy,
+		PolicyID:             s.PolicyID,
+		Labels:               s.Labels,
+		DatastoreTimeout:     s.DatastoreTimeout,
+		Schema:               s.Schema,
+		Dispositions:         s.Dispositions,
+		Privacy:              s.Privacy,
+		GeoPrivacy:           s.GeoPrivacy,
+		RateLimit:            s.RateLimit,
+		Sampling:             s.Sampling,
+		Schedule:             s.Schedule,
+		Geofence:             s.Geofence,
+		PublicKeyFingerprint: s.PublicKeyFingerprint,
+		Archive:              s.Archive,
+		Priority:             s.Priority,
+		Topic:                s.Topic,
+		QoS:                  s.QoS,
+		Device:               copyDevice(s.Device),
+	}
+
+	stream.Device.Streams = []*postgres.Stream{stream}
+
+	return stream
+}
+
+// copyConversions returns a copy of the given conversions. Stored conversions
+// are replaced rather than modified, so copies may then be shared with callers.
+func copyConversions(conversions postgres.Conversions) postgres.Conversions {
+	c := postgres.Conversions{}
+	for k, v := range conversions {
+		c[k] = v
+	}
+
+	return c
+}
+
+// SpendPrivacyBudget records the spending of the given cost from the privacy
+// budget of the stream for the period starting at periodStart, returning true
+// if it was spent, or false if it would take the total spent in the period
+// over the budget or the stream does not exist.
+func (d *DB) SpendPrivacyBudget(streamID string, periodStart time.Time, cost, budget float64) (bool, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	exists := false
+	for _, s := range d.streams {
+		if s.StreamID == streamID {
+			exists = true
+		}
+	}
+
+	if !exists {
+		return false, nil
+	}
+
+	key := ledgerKey{streamID: streamID, periodStart: periodStart.Unix()}
+
+	if d.ledger[key]+cost > budget {
+		return false, nil
+	}
+
+	d.ledger[key] += cost
+
+	return true, nil
+}
@@ -0,0 +1,58 @@
+package postgres
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SpendPrivacyBudget records the spending of the given cost from the privacy
+// budget of the stream for the period starting at periodStart, returning true
+// if it was spent. If spending the cost would take the total spent in the
+// period over the budget nothing is recorded and we return false, as we do if
+// the stream does not exist. Spending is a single statement so that instances
+// sharing the database can't together overspend a budget.
+func (d *DB) SpendPrivacyBudget(streamID string, periodStart time.Time, cost, budget float64) (_ bool, err error) {
+	query := `INSERT INTO privacy_ledger
+		(stream_uuid, period_start, spent)
+	SELECT uuid, :period_start, :cost
+	FROM streams
+	WHERE uuid = :stream_uuid
+	AND CAST(:cost AS DOUBLE PRECISION) <= CAST(:budget AS DOUBLE PRECISION)
+	ON CONFLICT (stream_uuid, period_start) DO UPDATE
+	SET spent = privacy_ledger.spent + EXCLUDED.spent,
+			updated_at = NOW()
+	WHERE privacy_ledger.spent + EXCLUDED.spent <= :budget
+	RETURNING spent`
+
+	mapArgs := map[string]interface{}{
+		"stream_uuid":  streamID,
+		"period_start": periodStart,
+		"cost":         cost,
+		"budget":       budget,
+	}
+
+	tx, err := BeginTX(d.DB, "spend_privacy_budget")
+	if err != nil {
+		return false, errors.Wrap(err, "failed to start transaction when spending privacy budget")
+	}
+
+	defer func() {
+		if cerr := tx.CommitOrRollback(); err == nil && cerr != nil {
+			err = cerr
+		}
+	}()
+
+	var spent float64
+
+	err = tx.Get(&spent, query, mapArgs)
+	if err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, errors.Wrap(err, "failed to spend privacy budget")
+	}
+
+	return true, nil
+}
@@ -0,0 +1,307 @@
+package postgres
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// ErrSchemaNotFound is returned when a requested payload schema does not exist.
+var ErrSchemaNotFound = errors.New("schema not found")
+
+// ChannelType is the type of the values of a channel of a payload schema.
+type ChannelType string
+
+const (
+	// NumberType is a channel whose values may be any number.
+	NumberType ChannelType = "number"
+
+	// IntegerType is a channel whose values must be whole numbers.
+	IntegerType ChannelType = "integer"
+
+	// BooleanType is a channel whose values must be 0 or 1.
+	BooleanType ChannelType = "boolean"
+)
+
+// kindPattern is the pattern the kind of a payload schema must match.
+var kindPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{0,62}$`)
+
+// Channel describes a single sensor reading of a decoded payload: the id of
+// the sensor, a name and the unit in which its value is expressed once any
+// conversions are applied, and the type of the value.
+type Channel struct {
+	SensorID uint32      `json:"sensorId"`
+	Name     string      `json:"name"`
+	Unit     string      `json:"unit,omitempty"`
+	Type     ChannelType `json:"type"`
+}
+
+// Channels is the list of channels of a payload schema. It is stored as JSON.
+type Channels []*Channel
+
+// Find returns the channel for the given sensor id, or nil if the schema does
+// not declare the sensor.
+func (c Channels) Find(sensorID int) *Channel {
+	for _, channel := range c {
+		if int(channel.SensorID) == sensorID {
+			return channel
+		}
+	}
+
+	return nil
+}
+
+// Validate returns an error if the channels are not a valid schema, which
+// requires at least one channel, each sensor declared at most once with a
+// name, and every type to be one we know.
+func (c Channels) Validate() error {
+	if len(c) == 0 {
+		return errors.New("a schema must declare at least one channel")
+	}
+
+	seen := map[uint32]bool{}
+
+	for i, channel := range c {
+		if channel == nil {
+			return errors.Errorf("channel %d is empty", i)
+		}
+
+		if channel.SensorID == 0 {
+			return errors.Errorf("channel %d requires a non-zero sensor id", i)
+		}
+
+		if seen[channel.SensorID] {
+			return errors.Errorf("sensor %d is declared more than once", channel.SensorID)
+		}
+		seen[channel.SensorID] = true
+
+		if strings.TrimSpace(channel.Name) == "" {
+			return errors.Errorf("channel for sensor %d requires a name", channel.SensorID)
+		}
+
+		switch channel.Type {
+		case NumberType, IntegerType, BooleanType:
+		default:
+			return errors.Errorf("channel for sensor %d has unknown type %q", channel.SensorID, channel.Type)
+		}
+	}
+
+	return nil
+}
+
+// Value is our implementation of the sql.Valuer interface which converts the
+// instance into a value that can be written to the database.
+func (c Channels) Value() (driver.Value, error) {
+	if c == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(c)
+}
+
+// Scan is our implementation of the sql.Scanner interface which takes the value
+// read from the database, and converts it back into an instance of the type.
+func (c *Channels) Scan(src interface{}) error {
+	if c == nil {
+		return nil
+	}
+
+	source, ok := src.([]byte)
+	if !ok {
+		return errors.New("Value read from database cannot be typecast to a byte slice")
+	}
+
+	err := json.Unmarshal(source, c)
+	if err != nil {
+		return errors.Wrap(err, "failed to unmarshal bytes into Channels")
+	}
+
+	return nil
+}
+
+// Schema is a version of the payload schema of a kind of device, describing
+// the shape of the plaintext we encrypt for streams which reference it.
+// Versions are immutable once registered, so a reference to one always
+// describes the same shape.
+type Schema struct {
+	Kind      string    `db:"kind" json:"kind"`
+	Version   int       `db:"version" json:"version"`
+	Channels  Channels  `db:"channels" json:"channels"`
+	CreatedAt time.Time `db:"created_at" json:"createdAt"`
+}
+
+// Ref returns the reference to this version of the schema, written as
+// kind@version.
+func (s *Schema) Ref() string {
+	return fmt.Sprintf("%s@%d", s.Kind, s.Version)
+}
+
+// ValidateKind returns an error if the given string may not be used as the
+// kind of a schema.
+func ValidateKind(kind string) error {
+	if !kindPattern.MatchString(kind) {
+		return errors.Errorf("invalid schema kind %q, must be lower case letters, digits, dots, dashes and underscores", kind)
+	}
+
+	return nil
+}
+
+// ParseSchemaRef parses a schema reference, either kind@version or just kind
+// which refers to the latest version of the kind, returned as version zero.
+func ParseSchemaRef(ref string) (string, int, error) {
+	kind, version := ref, 0
+
+	if i := strings.LastIndex(ref, "@"); i >= 0 {
+		v, err := strconv.Atoi(ref[i+1:])
+		if err != nil || v < 1 {
+			return "", 0, errors.Errorf("invalid schema reference %q, version must be a positive integer", ref)
+		}
+
+		kind, version = ref[:i], v
+	}
+
+	err := ValidateKind(kind)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return kind, version, nil
+}
+
+// CreateSchema registers a new version of the schema of the given kind, one
+// greater than its latest existing version, returning the created schema.
+func (d *DB) CreateSchema(kind string, channels Channels) (_ *Schema, err error) {
+	err = ValidateKind(kind)
+	if err != nil {
+		return nil, err
+	}
+
+	err = channels.Validate()
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid channels")
+	}
+
+	sql := `INSERT INTO payload_schemas (kind, version, channels)
+	SELECT :kind, COALESCE(MAX(version), 0) + 1, :channels
+	FROM payload_schemas
+	WHERE kind = :kind
+	RETURNING version, created_at`
+
+	mapArgs := map[string]interface{}{
+		"kind":     kind,
+		"channels": channels,
+	}
+
+	tx, err := BeginTX(d.DB, "create_schema")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to start transaction when creating schema")
+	}
+
+	defer func() {
+		if cerr := tx.CommitOrRollback(); err == nil && cerr != nil {
+			err = cerr
+		}
+	}()
+
+	schema := &Schema{
+		Kind:     kind,
+		Channels: channels,
+	}
+
+	err = tx.Get(schema, sql, mapArgs)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to insert schema")
+	}
+
+	return schema, nil
+}
+
+// GetSchema returns the given version of the schema of the given kind, or its
+// latest version if version is zero. If no such schema exists we return
+// ErrSchemaNotFound.
+func (d *DB) GetSchema(kind string, version int) (_ *Schema, err error) {
+	query := `SELECT kind, version, channels, created_at
+	FROM payload_schemas
+	WHERE kind = :kind
+	AND (:version = 0 OR version = :version)
+	ORDER BY version DESC
+	LIMIT 1`
+
+	mapArgs := map[string]interface{}{
+		"kind":    kind,
+		"version": version,
+	}
+
+	tx, err := BeginTX(d.DB, "get_schema")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to begin transaction")
+	}
+
+	defer func() {
+		if cerr := tx.CommitOrRollback(); err == nil && cerr != nil {
+			err = cerr
+		}
+	}()
+
+	var schema Schema
+
+	err = tx.Get(&schema, query, mapArgs)
+	if err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return nil, ErrSchemaNotFound
+		}
+		return nil, errors.Wrap(err, "failed to select schema")
+	}
+
+	return &schema, nil
+}
+
+// ListSchemas returns every version of every registered schema, ordered by
+// kind and version.
+func (d *DB) ListSchemas() (_ []*Schema, err error) {
+	sql := `SELECT kind, version, channels, created_at
+	FROM payload_schemas
+	ORDER BY kind, version`
+
+	tx, err := BeginTX(d.DB, "list_schemas")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to begin transaction")
+	}
+
+	defer func() {
+		if cerr := tx.CommitOrRollback(); err == nil && cerr != nil {
+			err = cerr
+		}
+	}()
+
+	schemas := []*Schema{}
+
+	mapper := func(rows *sqlx.Rows) error {
+		for rows.Next() {
+			var s Schema
+
+			err = rows.StructScan(&s)
+			if err != nil {
+				return errors.Wrap(err, "failed to scan row into Schema struct")
+			}
+
+			schemas = append(schemas, &s)
+		}
+
+		return nil
+	}
+
+	err = tx.Map(sql, map[string]interface{}{}, mapper)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to select schemas from database")
+	}
+
+	return schemas, nil
+}
@@ -0,0 +1,296 @@
+package postgres
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"gopkg.in/guregu/null.v3"
+
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+)
+
+// StreamStats is a type used to hold the operational counters we record for a
+// single stream. Instances are maintained in memory by the stats package, and
+// periodically written back to the DB so that they approximately survive a
+// restart.
+type StreamStats struct {
+	StreamID         string    `db:"stream_uuid" json:"stream_uid"`
+	MessagesReceived uint64    `db:"messages_received" json:"messages_received"`
+	BytesEncrypted   uint64    `db:"bytes_encrypted" json:"bytes_encrypted"`
+	WritesSucceeded  uint64    `db:"writes_succeeded" json:"writes_succeeded"`
+	WritesFailed     uint64    `db:"writes_failed" json:"writes_failed"`
+	Throttled        uint64    `db:"messages_throttled" json:"messages_throttled"`
+	Dropped          uint64    `db:"messages_dropped" json:"messages_dropped"`
+	LastMessageAt    null.Time `db:"last_message_at" json:"last_message_at"`
+	ProcessingCount  uint64    `db:"processing_count" json:"-"`
+	ProcessingTimeNs uint64    `db:"processing_time_ns" json:"-"`
+}
+
+// AverageLatency returns the mean time taken to process a message for the
+// stream, or zero if we have not yet processed any messages.
+func (s *StreamStats) AverageLatency() time.Duration {
+	if s.ProcessingCount == 0 {
+		return 0
+	}
+
+	return time.Duration(s.ProcessingTimeNs / s.ProcessingCount)
+}
+
+// SaveStreamStats writes the given slice of stats to the database, upserting
+// any existing rows. Stats for streams that no longer exist are silently
+// skipped.
+func (d *DB) SaveStreamStats(stats []*StreamStats) (err error) {
+	sql := `INSERT INTO stream_stats
+		(stream_uuid, messages_received, bytes_encrypted, writes_succeeded,
+		 writes_failed, messages_throttled, messages_dropped, last_message_at,
+		 processing_count, processing_time_ns)
+	SELECT uuid, :messages_received, :bytes_encrypted, :writes_succeeded,
+		:writes_failed, :messages_throttled, :messages_dropped, :last_message_at,
+		:processing_count, :processing_time_ns
+	FROM streams
+	WHERE uuid = :stream_uuid
+	ON CONFLICT (stream_uuid) DO UPDATE
+	SET messages_received = EXCLUDED.messages_received,
+			bytes_encrypted = EXCLUDED.bytes_encrypted,
+			writes_succeeded = EXCLUDED.writes_succeeded,
+			writes_failed = EXCLUDED.writes_failed,
+			messages_throttled = EXCLUDED.messages_throttled,
+			messages_dropped = EXCLUDED.messages_dropped,
+			last_message_at = EXCLUDED.last_message_at,
+			processing_count = EXCLUDED.processing_count,
+			processing_time_ns = EXCLUDED.processing_time_ns,
+			updated_at = NOW()`
+
+	stmt, err := d.prepare(sql)
+	if err != nil {
+		return errors.Wrap(err, "failed to save stream stats")
+	}
+
+	tx, err := BeginTX(d.DB, "save_stream_stats")
+	if err != nil {
+		return errors.Wrap(err, "failed to start transaction when saving stream stats")
+	}
+
+	defer func() {
+		if cerr := tx.CommitOrRollback(); err == nil && cerr != nil {
+			err = cerr
+		}
+	}()
+
+	for _, s := range stats {
+		mapArgs := map[string]interface{}{
+			"stream_uuid":        s.StreamID,
+			"messages_received":  s.MessagesReceived,
+			"bytes_encrypted":    s.BytesEncrypted,
+			"writes_succeeded":   s.WritesSucceeded,
+			"writes_failed":      s.WritesFailed,
+			"messages_throttled": s.Throttled,
+			"messages_dropped":   s.Dropped,
+			"last_message_at":    s.LastMessageAt,
+			"processing_count":   s.ProcessingCount,
+			"processing_time_ns": s.ProcessingTimeNs,
+		}
+
+		err = tx.ExecStmt(stmt, mapArgs)
+		if err != nil {
+			return errors.Wrap(err, "failed to save stream stats")
+		}
+	}
+
+	return nil
+}
+
+// GetStreamStats returns all persisted stream stats. This is used to seed the
+// in memory stats store when the application starts.
+func (d *DB) GetStreamStats() (_ []*StreamStats, err error) {
+	sql := `SELECT stream_uuid, messages_received, bytes_encrypted,
+		writes_succeeded, writes_failed, messages_throttled, messages_dropped,
+		last_message_at, processing_count, processing_time_ns
+	FROM stream_stats`
+
+	tx, err := BeginTX(d.DB, "get_stream_stats")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to begin transaction")
+	}
+
+	defer func() {
+		if cerr := tx.CommitOrRollback(); err == nil && cerr != nil {
+			err = cerr
+		}
+	}()
+
+	stats := []*StreamStats{}
+
+	mapper := func(rows *sqlx.Rows) error {
+		for rows.Next() {
+			var s StreamStats
+
+			err = rows.StructScan(&s)
+			if err != nil {
+				return errors.Wrap(err, "failed to scan row into StreamStats struct")
+			}
+
+			stats = append(stats, &s)
+		}
+
+		return nil
+	}
+
+	err = tx.Map(sql, []interface{}{}, mapper)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to select stream stats from database")
+	}
+
+	return stats, nil
+}
+
+// MessageCounts is a type used to hold the number of messages received from a
+// device in each recent minute, keyed by the unix time at which the minute
+// started. It is stored as JSON.
+type MessageCounts map[int64]uint64
+
+// Value is our implementation of the driver.Valuer interface which converts the
+// counts into a JSON object for storage.
+func (m MessageCounts) Value() (driver.Value, error) {
+	if m == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(m)
+}
+
+// Scan is our implementation of the sql.Scanner interface which takes the value
+// read from the database, and converts it back into an instance of the type.
+func (m *MessageCounts) Scan(src interface{}) error {
+	if m == nil {
+		return nil
+	}
+
+	source, ok := src.([]byte)
+	if !ok {
+		return errors.New("Value read from database cannot be typecast to a byte slice")
+	}
+
+	err := json.Unmarshal(source, m)
+	if err != nil {
+		return errors.Wrap(err, "failed to unmarshal bytes into MessageCounts")
+	}
+
+	return nil
+}
+
+// Total returns the sum of all counts.
+func (m MessageCounts) Total() uint64 {
+	var total uint64
+	for _, n := range m {
+		total += n
+	}
+	return total
+}
+
+// DeviceStatus is a type used to hold the freshness of a single device: when we
+// last received a message from it, how many messages we received in each recent
+// minute, and the error with which processing its last message failed, if it
+// did. As with StreamStats instances are maintained in memory by the stats
+// package and periodically written back to the DB.
+type DeviceStatus struct {
+	DeviceToken   secret.Secret `db:"device_token"`
+	LastSeenAt    null.Time     `db:"last_seen_at"`
+	MessageCounts MessageCounts `db:"message_counts"`
+	Error         null.String   `db:"error"`
+	ErrorAt       null.Time     `db:"error_at"`
+}
+
+// SaveDeviceStatuses writes the given slice of device statuses to the database,
+// upserting any existing rows. Statuses for devices that no longer exist are
+// silently skipped.
+func (d *DB) SaveDeviceStatuses(statuses []*DeviceStatus) (err error) {
+	sql := `INSERT INTO device_status
+		(device_token, last_seen_at, message_counts, error, error_at)
+	SELECT device_token, :last_seen_at, :message_counts, :error, :error_at
+	FROM devices
+	WHERE device_token = :device_token
+	ON CONFLICT (device_token) DO UPDATE
+	SET last_seen_at = EXCLUDED.last_seen_at,
+			message_counts = EXCLUDED.message_counts,
+			error = EXCLUDED.error,
+			error_at = EXCLUDED.error_at,
+			updated_at = NOW()`
+
+	stmt, err := d.prepare(sql)
+	if err != nil {
+		return errors.Wrap(err, "failed to save device statuses")
+	}
+
+	tx, err := BeginTX(d.DB, "save_device_statuses")
+	if err != nil {
+		return errors.Wrap(err, "failed to start transaction when saving device statuses")
+	}
+
+	defer func() {
+		if cerr := tx.CommitOrRollback(); err == nil && cerr != nil {
+			err = cerr
+		}
+	}()
+
+	for _, s := range statuses {
+		mapArgs := map[string]interface{}{
+			"device_token":   s.DeviceToken,
+			"last_seen_at":   s.LastSeenAt,
+			"message_counts": s.MessageCounts,
+			"error":          s.Error,
+			"error_at":       s.ErrorAt,
+		}
+
+		err = tx.ExecStmt(stmt, mapArgs)
+		if err != nil {
+			return errors.Wrap(err, "failed to save device status")
+		}
+	}
+
+	return nil
+}
+
+// GetDeviceStatuses returns all persisted device statuses. This is used to seed
+// the in memory stats store when the application starts.
+func (d *DB) GetDeviceStatuses() (_ []*DeviceStatus, err error) {
+	sql := `SELECT device_token, last_seen_at, message_counts, error, error_at
+	FROM device_status`
+
+	tx, err := BeginTX(d.DB, "get_device_statuses")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to begin transaction")
+	}
+
+	defer func() {
+		if cerr := tx.CommitOrRollback(); err == nil && cerr != nil {
+			err = cerr
+		}
+	}()
+
+	statuses := []*DeviceStatus{}
+
+	mapper := func(rows *sqlx.Rows) error {
+		for rows.Next() {
+			var s DeviceStatus
+
+			err = rows.StructScan(&s)
+			if err != nil {
+				return errors.Wrap(err, "failed to scan row into DeviceStatus struct")
+			}
+
+			statuses = append(statuses, &s)
+		}
+
+		return nil
+	}
+
+	err = tx.Map(sql, []interface{}{}, mapper)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to select device statuses from database")
+	}
+
+	return statuses, nil
+}
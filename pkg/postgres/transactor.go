@@ -2,6 +2,7 @@ package postgres
 
 import (
 	"sync"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
@@ -14,7 +15,8 @@ type RowMapper func(*sqlx.Rows) error
 
 // Transactor is a wrapper interface for the underlying sqlx.Tx, which adds some
 // machinery for converting named queries into the form Postgres actually wants,
-// and handling error rollbacks or commits.
+// handling error rollbacks or commits, and recording the duration and outcome
+// of the transaction under its query name.
 type Transactor interface {
 	// CommitOrRollback will finalize a transaction. They will be rolled back on
 	// first error in which case this is a noop. If the transaction has not been
@@ -31,11 +33,24 @@ type Transactor interface {
 
 	// Exec executes the given sql statement and args
 	Exec(sql string, args interface{}) error
+
+	// GetStmt executes the given prepared statement within the transaction,
+	// scanning the result into the given destination.
+	GetStmt(dest interface{}, stmt *sqlx.NamedStmt, args interface{}) error
+
+	// MapStmt executes the given prepared statement within the transaction, and
+	// pushes the results row by row to the given RowMapper function.
+	MapStmt(stmt *sqlx.NamedStmt, args interface{}, rm RowMapper) error
+
+	// ExecStmt executes the given prepared statement within the transaction.
+	ExecStmt(stmt *sqlx.NamedStmt, args interface{}) error
 }
 
 // transactor is the private type that implements the Transactor interface
 type transactor struct {
-	tx *sqlx.Tx
+	tx    *sqlx.Tx
+	name  string
+	start time.Time
 
 	sync.Mutex
 	finalised    bool
@@ -43,16 +58,22 @@ type transactor struct {
 }
 
 // BeginTX is a constructor function that returns a new Transactor ready for
-// use. It takes in an sqlx.DB instance and returns either the Transactor or an
-// error.
-func BeginTX(db *sqlx.DB) (Transactor, error) {
+// use. It takes in an sqlx.DB instance and the name under which the duration
+// and outcome of the transaction are recorded, and returns either the
+// Transactor or an error.
+func BeginTX(db *sqlx.DB, name string) (Transactor, error) {
+	start := time.Now()
+
 	tx, err := db.Beginx()
 	if err != nil {
+		observeQuery(name, start, err)
 		return nil, errors.Wrap(err, "failed to start transaction for Transactor")
 	}
 
 	return &transactor{
 		tx:           tx,
+		name:         name,
+		start:        start,
 		finalised:    false,
 		finalisedErr: nil,
 	}, nil
@@ -71,6 +92,9 @@ func (t *transactor) CommitOrRollback() error {
 
 	t.finalised = true
 	t.finalisedErr = t.tx.Commit()
+
+	observeQuery(t.name, t.start, t.finalisedErr)
+
 	return t.finalisedErr
 }
 
@@ -111,6 +135,50 @@ func (t *transactor) Exec(sql string, args interface{}) error {
 	return nil
 }
 
+// GetStmt wraps NamedStmt.Get for the given prepared statement bound to our
+// transaction. Unlike Get the args are always bound by name.
+func (t *transactor) GetStmt(dest interface{}, stmt *sqlx.NamedStmt, args interface{}) error {
+	err := t.tx.NamedStmt(stmt).Get(dest, args)
+	if err != nil {
+		return t.rollback(err)
+	}
+
+	return nil
+}
+
+// ExecStmt wraps NamedStmt.Exec for the given prepared statement bound to our
+// transaction.
+func (t *transactor) ExecStmt(stmt *sqlx.NamedStmt, args interface{}) error {
+	_, err := t.tx.NamedStmt(stmt).Exec(args)
+	if err != nil {
+		return t.rollback(err)
+	}
+
+	return nil
+}
+
+// MapStmt wraps NamedStmt.Queryx for the given prepared statement bound to our
+// transaction, passing the resulting rows to the given RowMapper as with Map.
+func (t *transactor) MapStmt(stmt *sqlx.NamedStmt, args interface{}, rm RowMapper) (err error) {
+	rows, err := t.tx.NamedStmt(stmt).Queryx(args)
+	if err != nil {
+		return t.rollback(err)
+	}
+
+	defer func() {
+		if cerr := rows.Close(); err == nil && cerr != nil {
+			err = cerr
+		}
+	}()
+
+	err = rm(rows)
+	if err != nil {
+		return t.rollback(err)
+	}
+
+	return err
+}
+
 // Map is a slightly more complicated function that takes in an sql string and
 // args, which are handled as with Exec or Get, but it also takes in a RowMapper
 // function which is responsible for iterating through a result set and
@@ -181,6 +249,8 @@ func (t *transactor) rollback(origErr error) error {
 	t.finalised = true
 	rollbackErr := t.tx.Rollback()
 
+	observeQuery(t.name, t.start, origErr)
+
 	if rollbackErr == nil {
 		return origErr
 	}
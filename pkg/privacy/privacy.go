@@ -0,0 +1,206 @@
+// Package privacy implements differential privacy for the aggregated values a
+// stream shares, by injecting noise calibrated to a privacy parameter epsilon
+// into each value before it is encrypted. Each noised value spends epsilon
+// from the stream's privacy budget, and once the budget of a period is spent
+// no further values are released until the next period.
+package privacy
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// Laplace is the mechanism which adds noise drawn from the Laplace
+	// distribution, giving pure epsilon-differential privacy.
+	Laplace = "laplace"
+
+	// Gaussian is the mechanism which adds noise drawn from the normal
+	// distribution, giving (epsilon, delta)-differential privacy.
+	Gaussian = "gaussian"
+)
+
+// Spec is the differential privacy configuration of a stream. Sensitivity is
+// the most a single reading can change an aggregated value, to which the noise
+// is calibrated. If Budget is positive it is the total epsilon which may be
+// spent per Period, or over the lifetime of the stream if Period is zero.
+type Spec struct {
+	Mechanism   string
+	Epsilon     float64
+	Delta       float64
+	Sensitivity float64
+	Budget      float64
+	Period      time.Duration
+}
+
+// Parse parses a spec from its string form, the mechanism followed by a comma
+// separated list of parameters, e.g.
+// "laplace:epsilon=0.5,sensitivity=2,budget=100,period=24h". Epsilon is
+// required, as is delta for the gaussian mechanism, while sensitivity defaults
+// to 1, and without a budget spending is unlimited.
+func Parse(s string) (*Spec, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return nil, errors.Errorf("invalid privacy spec %q, must be mechanism:parameters", s)
+	}
+
+	spec := &Spec{
+		Mechanism:   parts[0],
+		Sensitivity: 1,
+	}
+
+	for _, param := range strings.Split(parts[1], ",") {
+		kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(kv) != 2 {
+			return nil, errors.Errorf("invalid privacy parameter %q, must be name=value", param)
+		}
+
+		if kv[0] == "period" {
+			period, err := time.ParseDuration(kv[1])
+			if err != nil || period <= 0 {
+				return nil, errors.Errorf("invalid privacy budget period: %s", kv[1])
+			}
+			spec.Period = period
+			continue
+		}
+
+		value, err := strconv.ParseFloat(kv[1], 64)
+		if err != nil || math.IsNaN(value) || math.IsInf(value, 0) || value <= 0 {
+			return nil, errors.Errorf("invalid privacy parameter %q, must be a positive number", param)
+		}
+
+		switch kv[0] {
+		case "epsilon":
+			spec.Epsilon = value
+		case "delta":
+			spec.Delta = value
+		case "sensitivity":
+			spec.Sensitivity = value
+		case "budget":
+			spec.Budget = value
+		default:
+			return nil, errors.Errorf("unknown privacy parameter: %s", kv[0])
+		}
+	}
+
+	return spec, spec.validate()
+}
+
+// Valid returns true if the given string is a spec Parse accepts.
+func Valid(s string) bool {
+	_, err := Parse(s)
+	return err == nil
+}
+
+// validate returns an error if the spec's parameters are not those required
+// by its mechanism.
+func (s *Spec) validate() error {
+	if s.Epsilon == 0 {
+		return errors.New("privacy spec requires epsilon")
+	}
+
+	switch s.Mechanism {
+	case Laplace:
+		if s.Delta != 0 {
+			return errors.New("the laplace mechanism takes no delta")
+		}
+	case Gaussian:
+		if s.Delta == 0 || s.Delta >= 1 {
+			return errors.New("the gaussian mechanism requires a delta between 0 and 1")
+		}
+		if s.Epsilon >= 1 {
+			return errors.New("the gaussian mechanism requires an epsilon less than 1")
+		}
+	default:
+		return errors.Errorf("unknown privacy mechanism %q, must be laplace or gaussian", s.Mechanism)
+	}
+
+	if s.Period != 0 && s.Budget == 0 {
+		return errors.New("a privacy budget period requires a budget")
+	}
+
+	if s.Budget != 0 && s.Budget < s.Epsilon {
+		return errors.New("privacy budget must be at least epsilon")
+	}
+
+	return nil
+}
+
+// Scale returns the scale of the noise distribution, which is the scale
+// parameter b of the Laplace distribution, or the standard deviation of the
+// normal distribution.
+func (s *Spec) Scale() float64 {
+	if s.Mechanism == Gaussian {
+		return s.Sensitivity * math.Sqrt(2*math.Log(1.25/s.Delta)) / s.Epsilon
+	}
+
+	return s.Sensitivity / s.Epsilon
+}
+
+// Cost returns the epsilon spent by releasing the given number of noised
+// values.
+func (s *Spec) Cost(n int) float64 {
+	return s.Epsilon * float64(n)
+}
+
+// PeriodStart returns the start of the budget period containing the given
+// time. If the spec has no period the budget is never replenished, so the
+// start of the single period is the Unix epoch.
+func (s *Spec) PeriodStart(t time.Time) time.Time {
+	if s.Period == 0 {
+		return time.Unix(0, 0).UTC()
+	}
+
+	return t.UTC().Truncate(s.Period)
+}
+
+// Noise returns noise drawn from the spec's distribution using the given
+// source.
+func (s *Spec) Noise(source Source) float64 {
+	scale := s.Scale()
+
+	if s.Mechanism == Gaussian {
+		// Box-Muller transform, where u1 must be non-zero
+		u1 := 1 - source.Float64()
+		u2 := source.Float64()
+		return scale * math.Sqrt(-2*math.Log(u1)) * math.Cos(2*math.Pi*u2)
+	}
+
+	// inverse transform sampling, where u is in [-0.5, 0.5)
+	u := source.Float64() - 0.5
+	if u < 0 {
+		return scale * math.Log(1+2*u)
+	}
+	return -scale * math.Log(1-2*u)
+}
+
+// Source is the interface of a source of uniformly distributed random numbers
+// in [0, 1) from which noise is drawn.
+type Source interface {
+	Float64() float64
+}
+
+// CryptoSource is a Source reading from crypto/rand. Noise must not be
+// predictable, or it could be subtracted from released values, so it is drawn
+// from a cryptographically secure source rather than math/rand.
+type CryptoSource struct{}
+
+// Float64 returns a random number in [0, 1) with 53 bits of precision.
+func (CryptoSource) Float64() float64 {
+	var b [8]byte
+
+	_, err := rand.Read(b[:])
+	if err != nil {
+		// crypto/rand only fails if the system's source of randomness does,
+		// in which case no noise can be trusted
+		panic(errors.Wrap(err, "failed to read random bytes"))
+	}
+
+	return float64(binary.BigEndian.Uint64(b[:])>>11) / (1 << 53)
+}
@@ -0,0 +1,109 @@
+package privacy_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/privacy"
+)
+
+func TestParse(t *testing.T) {
+	testcases := []struct {
+		input    string
+		expected *privacy.Spec
+	}{
+		{
+			input:    "laplace:epsilon=0.5",
+			expected: &privacy.Spec{Mechanism: privacy.Laplace, Epsilon: 0.5, Sensitivity: 1},
+		},
+		{
+			input:    "laplace:epsilon=0.5,sensitivity=2,budget=10,period=24h",
+			expected: &privacy.Spec{Mechanism: privacy.Laplace, Epsilon: 0.5, Sensitivity: 2, Budget: 10, Period: 24 * time.Hour},
+		},
+		{
+			input:    "gaussian:epsilon=0.5,delta=1e-5",
+			expected: &privacy.Spec{Mechanism: privacy.Gaussian, Epsilon: 0.5, Delta: 1e-5, Sensitivity: 1},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.input, func(t *testing.T) {
+			spec, err := privacy.Parse(tc.input)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, spec)
+		})
+	}
+
+	for _, input := range []string{
+		"",
+		"laplace",
+		"laplace:",
+		"laplace:sensitivity=2",
+		"laplace:epsilon=0",
+		"laplace:epsilon=-1",
+		"laplace:epsilon=NaN",
+		"laplace:epsilon=0.5,delta=1e-5",
+		"laplace:epsilon=0.5,period=24h",
+		"laplace:epsilon=0.5,budget=0.1",
+		"laplace:epsilon=0.5,noise=2",
+		"gaussian:epsilon=0.5",
+		"gaussian:epsilon=2,delta=1e-5",
+		"exponential:epsilon=0.5",
+	} {
+		assert.False(t, privacy.Valid(input), input)
+	}
+}
+
+func TestPeriodStart(t *testing.T) {
+	now := time.Date(2026, 10, 15, 13, 24, 0, 0, time.UTC)
+
+	daily, _ := privacy.Parse("laplace:epsilon=1,budget=10,period=24h")
+	assert.Equal(t, time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC), daily.PeriodStart(now))
+
+	lifetime, _ := privacy.Parse("laplace:epsilon=1,budget=10")
+	assert.Equal(t, time.Unix(0, 0).UTC(), lifetime.PeriodStart(now))
+
+	assert.Equal(t, 3.0, daily.Cost(3))
+}
+
+func TestNoise(t *testing.T) {
+	testcases := []struct {
+		spec     string
+		expected float64
+	}{
+		{
+			// the variance of the Laplace distribution is 2b^2
+			spec:     "laplace:epsilon=0.5,sensitivity=2",
+			expected: 2 * 4 * 4,
+		},
+		{
+			spec:     "gaussian:epsilon=0.5,delta=1e-5",
+			expected: math.Pow(math.Sqrt(2*math.Log(1.25/1e-5))/0.5, 2),
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.spec, func(t *testing.T) {
+			spec, err := privacy.Parse(tc.spec)
+			assert.Nil(t, err)
+
+			n := 100000
+			sum, sumSquares := 0.0, 0.0
+
+			for i := 0; i < n; i++ {
+				noise := spec.Noise(privacy.CryptoSource{})
+				sum += noise
+				sumSquares += noise * noise
+			}
+
+			mean := sum / float64(n)
+			variance := sumSquares/float64(n) - mean*mean
+
+			assert.InDelta(t, 0, mean, 0.05*math.Sqrt(tc.expected))
+			assert.InEpsilon(t, tc.expected, variance, 0.05)
+		})
+	}
+}
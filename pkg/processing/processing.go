@@ -0,0 +1,153 @@
+// Package processing validates the processing a CreateStreamRequest applies to
+// each of a device's sensors. Each operation of the request carries its
+// processing as one of the typed messages of its processing oneof, so that
+// clients construct it with compile-time types. Clients written before those
+// messages were added instead send an action alongside bins and an interval
+// whose meaning depends on the action, with downsampling and change-only
+// sharing overloaded onto share operations before version 2 of the API added
+// actions for them. Typed converts such an operation into the typed processing
+// it describes, so that the encoder handles typed processing only.
+package processing
+
+import (
+	"github.com/pkg/errors"
+	encoder "github.com/thingful/twirp-encoder-go"
+)
+
+// Typed returns the operation with its processing given by one of the typed
+// messages, converting the action, bins and interval of an operation sent
+// without them, or an error if the operation is not valid. Fields which have
+// no meaning for the operation's action are rejected rather than ignored, so a
+// mistyped request fails instead of creating a stream processing readings
+// other than as intended. The given operation is never modified.
+func Typed(op *encoder.CreateStreamRequest_Operation) (*encoder.CreateStreamRequest_Operation, error) {
+	if op.SensorId == 0 {
+		return nil, errors.New("require a non-zero sensor id")
+	}
+
+	typed := &encoder.CreateStreamRequest_Operation{
+		SensorId:   op.SensorId,
+		Processing: op.Processing,
+	}
+
+	if op.Processing != nil {
+		if op.Action != encoder.CreateStreamRequest_Operation_UNKNOWN || len(op.Bins) > 0 || op.Interval != 0 {
+			return nil, errors.New("typed processing takes no action, bins or interval")
+		}
+	} else {
+		switch op.Action {
+		case encoder.CreateStreamRequest_Operation_SHARE:
+			switch {
+			case len(op.Bins) > 0:
+				if len(op.Bins) != 1 || op.Interval != 0 {
+					return nil, errors.New("change-only sharing requires a single threshold and no interval")
+				}
+				typed.Processing = &encoder.CreateStreamRequest_Operation_Delta{
+					Delta: &encoder.Delta{Threshold: op.Bins[0]},
+				}
+			case op.Interval != 0:
+				typed.Processing = &encoder.CreateStreamRequest_Operation_Downsample{
+					Downsample: &encoder.Downsample{Interval: op.Interval},
+				}
+			default:
+				typed.Processing = &encoder.CreateStreamRequest_Operation_Passthrough{
+					Passthrough: &encoder.Passthrough{},
+				}
+			}
+		case encoder.CreateStreamRequest_Operation_DOWNSAMPLE:
+			if op.Interval == 0 || len(op.Bins) > 0 {
+				return nil, errors.New("downsampling requires an interval and takes no bins")
+			}
+			typed.Processing = &encoder.CreateStreamRequest_Operation_Downsample{
+				Downsample: &encoder.Downsample{Interval: op.Interval},
+			}
+		case encoder.CreateStreamRequest_Operation_DELTA:
+			if len(op.Bins) != 1 || op.Interval != 0 {
+				return nil, errors.New("change-only sharing requires a single threshold and no interval")
+			}
+			typed.Processing = &encoder.CreateStreamRequest_Operation_Delta{
+				Delta: &encoder.Delta{Threshold: op.Bins[0]},
+			}
+		case encoder.CreateStreamRequest_Operation_BIN:
+			if op.Interval != 0 {
+				return nil, errors.New("binning takes no interval")
+			}
+			typed.Processing = &encoder.CreateStreamRequest_Operation_Bin{
+				Bin: &encoder.Bin{Bins: op.Bins},
+			}
+		case encoder.CreateStreamRequest_Operation_MOVING_AVG:
+			if len(op.Bins) > 0 {
+				return nil, errors.New("moving average takes no bins")
+			}
+			typed.Processing = &encoder.CreateStreamRequest_Operation_MovingAverage{
+				MovingAverage: &encoder.MovingAverage{Interval: op.Interval},
+			}
+		default:
+			return nil, errors.Errorf("unknown action %s", op.Action)
+		}
+	}
+
+	err := Validate(typed)
+	if err != nil {
+		return nil, err
+	}
+
+	return typed, nil
+}
+
+// Validate returns an error if the operation has no sensor id or typed
+// processing, or if its processing is not valid.
+func Validate(op *encoder.CreateStreamRequest_Operation) error {
+	if op.SensorId == 0 {
+		return errors.New("require a non-zero sensor id")
+	}
+
+	switch p := op.Processing.(type) {
+	case *encoder.CreateStreamRequest_Operation_Passthrough:
+	case *encoder.CreateStreamRequest_Operation_MovingAverage:
+		if p.MovingAverage.GetInterval() == 0 {
+			return errors.New("moving average requires a non-zero interval")
+		}
+	case *encoder.CreateStreamRequest_Operation_Bin:
+		bins := p.Bin.GetBins()
+		if len(bins) == 0 {
+			return errors.New("binning requires a non-empty list of bins")
+		}
+
+		for i := 1; i < len(bins); i++ {
+			if bins[i] <= bins[i-1] {
+				return errors.New("bins must be in strictly increasing order")
+			}
+		}
+	case *encoder.CreateStreamRequest_Operation_Downsample:
+		if p.Downsample.GetInterval() == 0 {
+			return errors.New("downsampling requires a non-zero interval")
+		}
+	case *encoder.CreateStreamRequest_Operation_Delta:
+		if p.Delta.GetThreshold() < 0 {
+			return errors.New("change-only threshold must not be negative")
+		}
+	case nil:
+		return errors.Errorf("sensor %d requires processing", op.SensorId)
+	default:
+		return errors.Errorf("unknown processing %T", p)
+	}
+
+	return nil
+}
+
+// Limits returns the interval and the bins of the operation's processing,
+// whether given by a typed message or by the operation's own fields, so that
+// they may be bounded before the operation is converted.
+func Limits(op *encoder.CreateStreamRequest_Operation) (uint32, []float64) {
+	switch p := op.Processing.(type) {
+	case *encoder.CreateStreamRequest_Operation_MovingAverage:
+		return p.MovingAverage.GetInterval(), nil
+	case *encoder.CreateStreamRequest_Operation_Bin:
+		return 0, p.Bin.GetBins()
+	case *encoder.CreateStreamRequest_Operation_Downsample:
+		return p.Downsample.GetInterval(), nil
+	}
+
+	return op.Interval, op.Bins
+}
@@ -0,0 +1,177 @@
+package processing_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	encoder "github.com/thingful/twirp-encoder-go"
+
+	"github.com/DECODEproject/iotencoder/pkg/processing"
+)
+
+func TestTyped(t *testing.T) {
+	testcases := []struct {
+		label    string
+		op       *encoder.CreateStreamRequest_Operation
+		expected *encoder.CreateStreamRequest_Operation
+	}{
+		{
+			label: "share",
+			op:    &encoder.CreateStreamRequest_Operation{SensorId: 12, Action: encoder.CreateStreamRequest_Operation_SHARE},
+			expected: &encoder.CreateStreamRequest_Operation{
+				SensorId:   12,
+				Processing: &encoder.CreateStreamRequest_Operation_Passthrough{Passthrough: &encoder.Passthrough{}},
+			},
+		},
+		{
+			label: "moving average",
+			op:    &encoder.CreateStreamRequest_Operation{SensorId: 13, Action: encoder.CreateStreamRequest_Operation_MOVING_AVG, Interval: 900},
+			expected: &encoder.CreateStreamRequest_Operation{
+				SensorId:   13,
+				Processing: &encoder.CreateStreamRequest_Operation_MovingAverage{MovingAverage: &encoder.MovingAverage{Interval: 900}},
+			},
+		},
+		{
+			label: "bin",
+			op:    &encoder.CreateStreamRequest_Operation{SensorId: 14, Action: encoder.CreateStreamRequest_Operation_BIN, Bins: []float64{40, 80}},
+			expected: &encoder.CreateStreamRequest_Operation{
+				SensorId:   14,
+				Processing: &encoder.CreateStreamRequest_Operation_Bin{Bin: &encoder.Bin{Bins: []float64{40, 80}}},
+			},
+		},
+		{
+			label: "share with interval",
+			op:    &encoder.CreateStreamRequest_Operation{SensorId: 15, Action: encoder.CreateStreamRequest_Operation_SHARE, Interval: 300},
+			expected: &encoder.CreateStreamRequest_Operation{
+				SensorId:   15,
+				Processing: &encoder.CreateStreamRequest_Operation_Downsample{Downsample: &encoder.Downsample{Interval: 300}},
+			},
+		},
+		{
+			label: "share with threshold",
+			op:    &encoder.CreateStreamRequest_Operation{SensorId: 16, Action: encoder.CreateStreamRequest_Operation_SHARE, Bins: []float64{0.5}},
+			expected: &encoder.CreateStreamRequest_Operation{
+				SensorId:   16,
+				Processing: &encoder.CreateStreamRequest_Operation_Delta{Delta: &encoder.Delta{Threshold: 0.5}},
+			},
+		},
+		{
+			label: "typed",
+			op: &encoder.CreateStreamRequest_Operation{
+				SensorId:   17,
+				Processing: &encoder.CreateStreamRequest_Operation_Delta{Delta: &encoder.Delta{Threshold: 2}},
+			},
+			expected: &encoder.CreateStreamRequest_Operation{
+				SensorId:   17,
+				Processing: &encoder.CreateStreamRequest_Operation_Delta{Delta: &encoder.Delta{Threshold: 2}},
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			typed, err := processing.Typed(tc.op)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, typed)
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	testcases := []struct {
+		label         string
+		op            *encoder.CreateStreamRequest_Operation
+		expectedError string
+	}{
+		{
+			label: "missing sensor id",
+			op: &encoder.CreateStreamRequest_Operation{
+				Processing: &encoder.CreateStreamRequest_Operation_Passthrough{Passthrough: &encoder.Passthrough{}},
+			},
+			expectedError: "require a non-zero sensor id",
+		},
+		{
+			label:         "missing processing",
+			op:            &encoder.CreateStreamRequest_Operation{SensorId: 12},
+			expectedError: "sensor 12 requires processing",
+		},
+		{
+			label: "moving average without interval",
+			op: &encoder.CreateStreamRequest_Operation{
+				SensorId:   12,
+				Processing: &encoder.CreateStreamRequest_Operation_MovingAverage{},
+			},
+			expectedError: "moving average requires a non-zero interval",
+		},
+		{
+			label: "bins out of order",
+			op: &encoder.CreateStreamRequest_Operation{
+				SensorId:   12,
+				Processing: &encoder.CreateStreamRequest_Operation_Bin{Bin: &encoder.Bin{Bins: []float64{40, 40}}},
+			},
+			expectedError: "bins must be in strictly increasing order",
+		},
+		{
+			label: "negative threshold",
+			op: &encoder.CreateStreamRequest_Operation{
+				SensorId:   12,
+				Processing: &encoder.CreateStreamRequest_Operation_Delta{Delta: &encoder.Delta{Threshold: -1}},
+			},
+			expectedError: "change-only threshold must not be negative",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			err := processing.Validate(tc.op)
+			assert.NotNil(t, err)
+			assert.Equal(t, tc.expectedError, err.Error())
+		})
+	}
+}
+
+func TestTypedRejectsUnusedFields(t *testing.T) {
+	testcases := []struct {
+		label         string
+		op            *encoder.CreateStreamRequest_Operation
+		expectedError string
+	}{
+		{
+			label:         "unknown action",
+			op:            &encoder.CreateStreamRequest_Operation{SensorId: 12},
+			expectedError: "unknown action UNKNOWN",
+		},
+		{
+			label:         "bin with interval",
+			op:            &encoder.CreateStreamRequest_Operation{SensorId: 12, Action: encoder.CreateStreamRequest_Operation_BIN, Bins: []float64{1}, Interval: 60},
+			expectedError: "binning takes no interval",
+		},
+		{
+			label:         "moving average with bins",
+			op:            &encoder.CreateStreamRequest_Operation{SensorId: 12, Action: encoder.CreateStreamRequest_Operation_MOVING_AVG, Bins: []float64{1}, Interval: 60},
+			expectedError: "moving average takes no bins",
+		},
+		{
+			label:         "share with threshold and interval",
+			op:            &encoder.CreateStreamRequest_Operation{SensorId: 12, Action: encoder.CreateStreamRequest_Operation_SHARE, Bins: []float64{1}, Interval: 60},
+			expectedError: "change-only sharing requires a single threshold and no interval",
+		},
+		{
+			label: "typed with action",
+			op: &encoder.CreateStreamRequest_Operation{
+				SensorId:   12,
+				Action:     encoder.CreateStreamRequest_Operation_MOVING_AVG,
+				Processing: &encoder.CreateStreamRequest_Operation_MovingAverage{MovingAverage: &encoder.MovingAverage{Interval: 60}},
+			},
+			expectedError: "typed processing takes no action, bins or interval",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			_, err := processing.Typed(tc.op)
+			assert.NotNil(t, err)
+			assert.Equal(t, tc.expectedError, err.Error())
+		})
+	}
+}
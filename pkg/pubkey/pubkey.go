@@ -0,0 +1,104 @@
+// Package pubkey validates and fingerprints the community public keys to which
+// the data of streams is encrypted. Keys are the base64 encoding of an
+// uncompressed point on the ed25519 curve used by our zenroom scripts, as
+// produced by zenroom, which may be given with the forward slashes escaped as
+// they appear in JSON.
+package pubkey
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"math/big"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// coordinateSize is the size in bytes of each coordinate of a point.
+	coordinateSize = 32
+
+	// uncompressed is the prefix of a point given by both of its coordinates.
+	uncompressed = 0x04
+)
+
+var (
+	// p is the prime order of the field over which ed25519 is defined.
+	p = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(19))
+
+	// d is the constant of the twisted Edwards form of ed25519,
+	// -x^2 + y^2 = 1 + d*x^2*y^2, which is -121665/121666 modulo p.
+	d = new(big.Int).Mod(
+		new(big.Int).Mul(big.NewInt(-121665), new(big.Int).ModInverse(big.NewInt(121666), p)),
+		p,
+	)
+)
+
+// Decode returns the bytes of the given public key, returning an error if it
+// is not the encoding of a point on the curve.
+func Decode(s string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.Replace(s, `\/`, "/", -1))
+	if err != nil {
+		return nil, errors.New("invalid public key, must be base64 encoded")
+	}
+
+	if len(key) != 1+2*coordinateSize {
+		return nil, errors.Errorf("invalid public key length, must be %d bytes: got %d", 1+2*coordinateSize, len(key))
+	}
+
+	if key[0] != uncompressed {
+		return nil, errors.New("invalid public key, must be an uncompressed point")
+	}
+
+	x := new(big.Int).SetBytes(key[1 : 1+coordinateSize])
+	y := new(big.Int).SetBytes(key[1+coordinateSize:])
+
+	if !onCurve(x, y) {
+		return nil, errors.New("invalid public key, must be a point on the ed25519 curve")
+	}
+
+	return key, nil
+}
+
+// Validate returns an error if the given public key is not the encoding of a
+// point on the curve.
+func Validate(s string) error {
+	_, err := Decode(s)
+	return err
+}
+
+// Fingerprint returns the fingerprint of the given public key, the hex encoded
+// SHA-256 digest of its bytes, so that the same key has the same fingerprint
+// however its slashes were escaped. An invalid key has no fingerprint.
+func Fingerprint(s string) string {
+	key, err := Decode(s)
+	if err != nil {
+		return ""
+	}
+
+	sum := sha256.Sum256(key)
+
+	return hex.EncodeToString(sum[:])
+}
+
+// onCurve returns true if the given coordinates are those of a point on the
+// curve.
+func onCurve(x, y *big.Int) bool {
+	if x.Cmp(p) >= 0 || y.Cmp(p) >= 0 {
+		return false
+	}
+
+	x2 := new(big.Int).Mul(x, x)
+	y2 := new(big.Int).Mul(y, y)
+
+	lhs := new(big.Int).Sub(y2, x2)
+	lhs.Mod(lhs, p)
+
+	rhs := new(big.Int).Mul(d, x2)
+	rhs.Mul(rhs, y2)
+	rhs.Add(rhs, big.NewInt(1))
+	rhs.Mod(rhs, p)
+
+	return lhs.Cmp(rhs) == 0
+}
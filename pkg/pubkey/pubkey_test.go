@@ -0,0 +1,45 @@
+package pubkey_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/pubkey"
+)
+
+const key = `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`
+
+func TestValidate(t *testing.T) {
+	assert.Nil(t, pubkey.Validate(key))
+	assert.Nil(t, pubkey.Validate(`BBLewg4VqLR38b38daE7Fj/uhr543uGrEpyoPFgmFZK6EZ9g2XdK/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9/ifjE=`))
+
+	testcases := []struct {
+		label    string
+		input    string
+		expected string
+	}{
+		{"not base64", "pub_key", "invalid public key, must be base64 encoded"},
+		{"too short", "BBLewg4VqLR38b38daE7Fj==", "invalid public key length, must be 65 bytes: got 16"},
+		{"compressed", `AhLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`, "invalid public key, must be an uncompressed point"},
+		{"off curve", `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjA=`, "invalid public key, must be a point on the ed25519 curve"},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			err := pubkey.Validate(tc.input)
+			assert.NotNil(t, err)
+			assert.Equal(t, tc.expected, err.Error())
+		})
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	fingerprint := pubkey.Fingerprint(key)
+	assert.Equal(t, "438f592e355e39d9f6ba89fcbbd7eea154662742413a690b20a0c15c4480a65b", fingerprint)
+
+	// escaping the slashes of a key doesn't change its fingerprint
+	assert.Equal(t, fingerprint, pubkey.Fingerprint(`BBLewg4VqLR38b38daE7Fj/uhr543uGrEpyoPFgmFZK6EZ9g2XdK/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9/ifjE=`))
+
+	assert.Equal(t, "", pubkey.Fingerprint("pub_key"))
+}
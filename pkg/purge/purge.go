@@ -0,0 +1,133 @@
+package purge
+
+import (
+	"sync"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/logger/level"
+)
+
+// Store is the interface we require of a type able to permanently delete soft
+// deleted streams. It is satisfied by our postgres.DB type.
+type Store interface {
+	// PurgeDeletedStreams permanently deletes streams deleted before the given
+	// time, returning the number of streams deleted.
+	PurgeDeletedStreams(before time.Time) (int64, error)
+}
+
+// Config is used to pass in configuration when creating a Janitor.
+type Config struct {
+	// Store is the store from which deleted streams are purged.
+	Store Store
+
+	// GracePeriod is the duration for which deleted streams may be restored
+	// before they are purged. If zero, deleted streams are purged on the next
+	// tick of the janitor.
+	GracePeriod time.Duration
+
+	// Interval is the interval at which the janitor purges the store.
+	Interval time.Duration
+
+	// Clock is used to determine which deleted streams have expired.
+	Clock clock.Clock
+}
+
+// Janitor is a component that periodically purges deleted streams whose grace
+// period has expired, permanently destroying their keys and configuration.
+type Janitor struct {
+	store       Store
+	gracePeriod time.Duration
+	interval    time.Duration
+	clock       clock.Clock
+	logger      kitlog.Logger
+	quit        chan struct{}
+	wg          sync.WaitGroup
+}
+
+// NewJanitor returns a new Janitor configured with the given Config.
+func NewJanitor(config *Config, logger kitlog.Logger) *Janitor {
+	logger = kitlog.With(logger, "module", "purge")
+
+	return &Janitor{
+		store:       config.Store,
+		gracePeriod: config.GracePeriod,
+		interval:    config.Interval,
+		clock:       config.Clock,
+		logger:      logger,
+	}
+}
+
+// Start starts a goroutine which purges the store on each tick of our
+// interval.
+func (j *Janitor) Start() error {
+	j.logger.Log("msg", "starting purge janitor", "gracePeriod", j.gracePeriod, "interval", j.interval)
+
+	if j.gracePeriod < 0 {
+		return errors.New("deleted stream grace period must not be negative")
+	}
+
+	if j.interval <= 0 {
+		return errors.New("purge interval must be positive")
+	}
+
+	j.quit = make(chan struct{})
+	j.wg.Add(1)
+
+	go j.loop()
+
+	return nil
+}
+
+// Stop stops the purge goroutine.
+func (j *Janitor) Stop() error {
+	if j.quit == nil {
+		return nil
+	}
+
+	j.logger.Log("msg", "stopping purge janitor")
+
+	close(j.quit)
+	j.wg.Wait()
+
+	return nil
+}
+
+// Purge permanently deletes all streams deleted more than the grace period
+// ago.
+func (j *Janitor) Purge() error {
+	deleted, err := j.store.PurgeDeletedStreams(j.clock.Now().Add(-j.gracePeriod))
+	if err != nil {
+		return errors.Wrap(err, "failed to purge deleted streams")
+	}
+
+	if deleted > 0 {
+		j.logger.Log("msg", "purged deleted streams", "deleted", deleted)
+	}
+
+	return nil
+}
+
+// loop is run in a goroutine and purges the store on each tick of our interval
+// until the janitor is stopped.
+func (j *Janitor) loop() {
+	defer j.wg.Done()
+
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := j.Purge()
+			if err != nil {
+				level.Error(j.logger).Log("msg", "failed to purge deleted streams", "err", err)
+			}
+		case <-j.quit:
+			return
+		}
+	}
+}
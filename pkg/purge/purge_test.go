@@ -0,0 +1,69 @@
+package purge_test
+
+import (
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/postgres/postgrestest"
+	"github.com/DECODEproject/iotencoder/pkg/purge"
+)
+
+func TestJanitorPurge(t *testing.T) {
+	now := time.Now()
+	cl := clock.NewMock(now)
+
+	db := postgrestest.NewDB()
+	db.SetClock(cl)
+
+	stream, err := db.CreateStream(&postgres.Stream{
+		CommunityID: "community",
+		PublicKey:   "public",
+		Device:      &postgres.Device{DeviceToken: "abc123"},
+	})
+	assert.Nil(t, err)
+
+	_, err = db.DeleteStream(stream)
+	assert.Nil(t, err)
+
+	janitor := purge.NewJanitor(&purge.Config{
+		Store:       db,
+		GracePeriod: 24 * time.Hour,
+		Interval:    time.Minute,
+		Clock:       cl,
+	}, kitlog.NewNopLogger())
+
+	// within the grace period the stream may still be restored
+	err = janitor.Purge()
+	assert.Nil(t, err)
+
+	restored, err := db.RestoreStream(stream.StreamID, stream.Token.Reveal())
+	assert.Nil(t, err)
+	assert.Equal(t, "abc123", restored.Device.DeviceToken.Reveal())
+
+	_, err = db.DeleteStream(stream)
+	assert.Nil(t, err)
+
+	cl.Add(25 * time.Hour)
+
+	err = janitor.Purge()
+	assert.Nil(t, err)
+
+	_, err = db.RestoreStream(stream.StreamID, stream.Token.Reveal())
+	assert.Equal(t, postgres.ErrStreamNotFound, err)
+}
+
+func TestJanitorInvalidConfig(t *testing.T) {
+	janitor := purge.NewJanitor(&purge.Config{
+		Store:       postgrestest.NewDB(),
+		GracePeriod: time.Hour,
+		Clock:       clock.New(),
+	}, kitlog.NewNopLogger())
+
+	err := janitor.Start()
+	assert.NotNil(t, err)
+}
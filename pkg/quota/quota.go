@@ -0,0 +1,262 @@
+// Package quota enforces soft quotas on the streams of each community, so that
+// a single pilot can't consume the whole deployment. A community may be
+// limited in the number of live streams it registers, the number of messages
+// processed for its streams each minute, and the number of bytes of payload
+// processed for its streams each day. Streams beyond the limit are refused
+// when created, while messages beyond the limits are throttled rather than
+// failed, as with the rate limits of individual streams.
+package quota
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+)
+
+const (
+	// Streams is the quota on the number of live streams of a community.
+	Streams = "streams"
+
+	// Messages is the quota on the number of messages processed for the streams
+	// of a community each minute.
+	Messages = "messages"
+
+	// Bytes is the quota on the number of bytes of payload processed for the
+	// streams of a community each day.
+	Bytes = "bytes"
+
+	// AnyCommunity is the community whose limits apply to every community
+	// without limits of its own.
+	AnyCommunity = "*"
+)
+
+var (
+	// ExceededCounter is a prometheus counter vec recording the number of
+	// streams refused and messages throttled as a community exceeded a quota,
+	// labelled by the community and the quota.
+	ExceededCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "quota_exceeded",
+			Help:      "Count of streams refused and messages throttled as a community exceeded its quota",
+		},
+		[]string{"community", "quota"},
+	)
+)
+
+// Limits are the quotas of a community. A limit of zero is no limit.
+type Limits struct {
+	Streams           int
+	MessagesPerMinute int
+	BytesPerDay       int64
+}
+
+// Parse parses limits from their string form, a comma separated list of
+// quota=limit pairs, e.g. "streams=100,messages=600,bytes=104857600". Quotas
+// which aren't given are not limited.
+func Parse(s string) (*Limits, error) {
+	limits := &Limits{}
+
+	for _, param := range strings.Split(s, ",") {
+		kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(kv) != 2 {
+			return nil, errors.Errorf("invalid quota %q, must be name=limit", param)
+		}
+
+		limit, err := strconv.ParseInt(kv[1], 10, 64)
+		if err != nil || limit < 0 {
+			return nil, errors.Errorf("invalid %s quota, must be a non-negative integer: %s", kv[0], kv[1])
+		}
+
+		switch kv[0] {
+		case Streams:
+			limits.Streams = int(limit)
+		case Messages:
+			limits.MessagesPerMinute = int(limit)
+		case Bytes:
+			limits.BytesPerDay = limit
+		default:
+			return nil, errors.Errorf("unknown quota: %s", kv[0])
+		}
+	}
+
+	return limits, nil
+}
+
+// String returns the limits in the form accepted by Parse, omitting quotas
+// which aren't limited.
+func (l *Limits) String() string {
+	var params []string
+
+	if l.Streams > 0 {
+		params = append(params, Streams+"="+strconv.Itoa(l.Streams))
+	}
+
+	if l.MessagesPerMinute > 0 {
+		params = append(params, Messages+"="+strconv.Itoa(l.MessagesPerMinute))
+	}
+
+	if l.BytesPerDay > 0 {
+		params = append(params, Bytes+"="+strconv.FormatInt(l.BytesPerDay, 10))
+	}
+
+	return strings.Join(params, ",")
+}
+
+// ParseCommunities parses the limits of each community from a slice of
+// strings of the form COMMUNITY:LIMITS, where the limits of the community *
+// apply to every community not given its own.
+func ParseCommunities(entries []string) (map[string]*Limits, error) {
+	communities := make(map[string]*Limits)
+
+	for _, entry := range entries {
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, errors.Errorf("invalid community quota, expected COMMUNITY:LIMITS: %s", entry)
+		}
+
+		if _, ok := communities[parts[0]]; ok {
+			return nil, errors.Errorf("duplicate quota for community: %s", parts[0])
+		}
+
+		limits, err := Parse(parts[1])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid quota for community %s", parts[0])
+		}
+
+		communities[parts[0]] = limits
+	}
+
+	return communities, nil
+}
+
+// ErrStreamsExceeded is returned when a community would exceed its quota of
+// streams.
+var ErrStreamsExceeded = errors.New("community stream quota exceeded")
+
+// usage is the number of messages and bytes processed for the streams of a
+// community within the current minute and day.
+type usage struct {
+	minute   time.Time
+	messages int
+	day      time.Time
+	bytes    int64
+}
+
+// Quotas holds the limits of each community, and their usage within the
+// current minute and day. Usage is held in memory, so is reset by a restart,
+// and where devices are partitioned between instances each limits only the
+// messages it processes.
+type Quotas struct {
+	sync.Mutex
+
+	clock       clock.Clock
+	communities map[string]*Limits
+	usage       map[string]*usage
+}
+
+// NewQuotas returns a new Quotas enforcing the given limits, keyed by
+// community, using the given clock.
+func NewQuotas(communities map[string]*Limits, cl clock.Clock) *Quotas {
+	return &Quotas{
+		clock:       cl,
+		communities: communities,
+		usage:       make(map[string]*usage),
+	}
+}
+
+// Limits returns the limits of the given community, or nil if it is not
+// limited.
+func (q *Quotas) Limits(community string) *Limits {
+	q.Lock()
+	defer q.Unlock()
+
+	if limits, ok := q.communities[community]; ok {
+		return limits
+	}
+
+	return q.communities[AnyCommunity]
+}
+
+// SetLimits replaces the limits of every community, keyed by community. Usage
+// within the current minute and day is kept, so counts against the new limits.
+func (q *Quotas) SetLimits(communities map[string]*Limits) {
+	q.Lock()
+	defer q.Unlock()
+
+	q.communities = communities
+}
+
+// AllowStream returns ErrStreamsExceeded, counting the refusal, if the given
+// community may not register another stream as it already has the given
+// number of live streams.
+func (q *Quotas) AllowStream(community string, streams int) error {
+	limits := q.Limits(community)
+	if limits == nil || limits.Streams == 0 || streams < limits.Streams {
+		return nil
+	}
+
+	ExceededCounter.WithLabelValues(community, Streams).Inc()
+
+	return ErrStreamsExceeded
+}
+
+// AllowMessage returns true if a message of the given size may be processed
+// for a stream of the given community, counting it against the community's
+// usage if so. If not we return the quota which was exceeded, counting the
+// throttled message.
+func (q *Quotas) AllowMessage(community string, size int) (bool, string) {
+	limits := q.Limits(community)
+	if limits == nil || (limits.MessagesPerMinute == 0 && limits.BytesPerDay == 0) {
+		return true, ""
+	}
+
+	q.Lock()
+	defer q.Unlock()
+
+	now := q.clock.Now().UTC()
+	minute := now.Truncate(time.Minute)
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	u, ok := q.usage[community]
+	if !ok {
+		u = &usage{}
+		q.usage[community] = u
+	}
+
+	if !u.minute.Equal(minute) {
+		u.minute = minute
+		u.messages = 0
+	}
+
+	if !u.day.Equal(day) {
+		u.day = day
+		u.bytes = 0
+	}
+
+	exceeded := ""
+
+	switch {
+	case limits.MessagesPerMinute > 0 && u.messages >= limits.MessagesPerMinute:
+		exceeded = Messages
+	case limits.BytesPerDay > 0 && u.bytes+int64(size) > limits.BytesPerDay:
+		exceeded = Bytes
+	}
+
+	if exceeded != "" {
+		ExceededCounter.WithLabelValues(community, exceeded).Inc()
+		return false, exceeded
+	}
+
+	u.messages++
+	u.bytes += int64(size)
+
+	return true, ""
+}
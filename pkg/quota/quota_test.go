@@ -0,0 +1,116 @@
+package quota_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/quota"
+)
+
+func TestParse(t *testing.T) {
+	limits, err := quota.Parse("streams=100, messages=600,bytes=1048576")
+	assert.Nil(t, err)
+	assert.Equal(t, &quota.Limits{Streams: 100, MessagesPerMinute: 600, BytesPerDay: 1048576}, limits)
+
+	limits, err = quota.Parse("messages=10")
+	assert.Nil(t, err)
+	assert.Equal(t, &quota.Limits{MessagesPerMinute: 10}, limits)
+	assert.Equal(t, "messages=10", limits.String())
+
+	for _, input := range []string{
+		"",
+		"streams",
+		"streams=-1",
+		"streams=x",
+		"devices=10",
+	} {
+		t.Run(input, func(t *testing.T) {
+			_, err := quota.Parse(input)
+			assert.NotNil(t, err)
+		})
+	}
+}
+
+func TestParseCommunities(t *testing.T) {
+	communities, err := quota.ParseCommunities([]string{"smartcitizen:streams=10", "*:messages=60"})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]*quota.Limits{
+		"smartcitizen": {Streams: 10},
+		"*":            {MessagesPerMinute: 60},
+	}, communities)
+
+	for _, input := range [][]string{
+		{"streams=10"},
+		{":streams=10"},
+		{"smartcitizen:streams=10", "smartcitizen:messages=10"},
+	} {
+		_, err := quota.ParseCommunities(input)
+		assert.NotNil(t, err)
+	}
+}
+
+func TestAllowStream(t *testing.T) {
+	quotas := quota.NewQuotas(map[string]*quota.Limits{
+		"smartcitizen": {Streams: 2},
+		"*":            {Streams: 1},
+	}, clock.New())
+
+	assert.Nil(t, quotas.AllowStream("smartcitizen", 1))
+	assert.Equal(t, quota.ErrStreamsExceeded, quotas.AllowStream("smartcitizen", 2))
+
+	// communities without limits of their own have the default limits
+	assert.Nil(t, quotas.AllowStream("other", 0))
+	assert.Equal(t, quota.ErrStreamsExceeded, quotas.AllowStream("other", 1))
+
+	// without default limits other communities are unlimited
+	quotas = quota.NewQuotas(map[string]*quota.Limits{}, clock.New())
+	assert.Nil(t, quotas.AllowStream("other", 1000))
+}
+
+func TestAllowMessage(t *testing.T) {
+	mock := clock.NewMock(time.Date(2026, 10, 15, 23, 58, 30, 0, time.UTC))
+
+	quotas := quota.NewQuotas(map[string]*quota.Limits{
+		"smartcitizen": {MessagesPerMinute: 2, BytesPerDay: 250},
+	}, mock)
+
+	allowed, _ := quotas.AllowMessage("smartcitizen", 100)
+	assert.True(t, allowed)
+	allowed, _ = quotas.AllowMessage("smartcitizen", 100)
+	assert.True(t, allowed)
+
+	allowed, exceeded := quotas.AllowMessage("smartcitizen", 10)
+	assert.False(t, allowed)
+	assert.Equal(t, quota.Messages, exceeded)
+
+	// other communities are unlimited
+	allowed, _ = quotas.AllowMessage("other", 1000)
+	assert.True(t, allowed)
+
+	// the message quota is reset each minute, while the byte quota is not
+	mock.Add(time.Minute)
+	allowed, exceeded = quotas.AllowMessage("smartcitizen", 100)
+	assert.False(t, allowed)
+	assert.Equal(t, quota.Bytes, exceeded)
+
+	allowed, _ = quotas.AllowMessage("smartcitizen", 50)
+	assert.True(t, allowed)
+
+	// until the next day
+	mock.Add(time.Minute)
+	allowed, _ = quotas.AllowMessage("smartcitizen", 100)
+	assert.True(t, allowed)
+}
+
+func TestSetLimits(t *testing.T) {
+	quotas := quota.NewQuotas(map[string]*quota.Limits{}, clock.New())
+	assert.Nil(t, quotas.AllowStream("smartcitizen", 1))
+
+	quotas.SetLimits(map[string]*quota.Limits{
+		"smartcitizen": {Streams: 1},
+	})
+	assert.Equal(t, quota.ErrStreamsExceeded, quotas.AllowStream("smartcitizen", 1))
+}
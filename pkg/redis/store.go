@@ -0,0 +1,160 @@
+// Package redis provides a StateStore holding the windows over which moving
+// averages are calculated in Redis, so that replicas sharing a subscription
+// each calculate them over every reading of a device, whichever replica
+// received it.
+package redis
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+
+	redigo "github.com/garyburd/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+// DefaultPrefix is the prefix of the keys under which windows are stored if
+// none is configured.
+const DefaultPrefix = "iotencoder:window:"
+
+// windowScript atomically adds a value to the sorted set holding a window,
+// scored by the second at which it was received, removes any received before
+// the window's start, refreshes the expiry of the key so that the windows of
+// devices which stop publishing are forgotten, and returns the members that
+// remain. Each member is the value prefixed with a random id, as equal values
+// received within the same second would otherwise be stored only once. The
+// script is sent by its digest, and in full only if the server doesn't have it
+// cached.
+var windowScript = redigo.NewScript(1, `
+redis.call('ZADD', KEYS[1], ARGV[1], ARGV[3])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', '(' .. ARGV[2])
+redis.call('EXPIRE', KEYS[1], ARGV[4])
+return redis.call('ZRANGE', KEYS[1], 0, -1)
+`)
+
+// StateStore holds the windows over which moving averages are calculated in
+// Redis, and satisfies the pipeline.StateStore interface. As the keys of
+// windows contain device tokens they are hashed before being sent to the
+// server.
+type StateStore struct {
+	pool   *redigo.Pool
+	prefix string
+}
+
+// NewStateStore returns a new StateStore keeping its windows in Redis via
+// connections from the given pool, under keys with the given prefix, or
+// DefaultPrefix if empty.
+func NewStateStore(pool *redigo.Pool, prefix string) *StateStore {
+	if prefix == "" {
+		prefix = DefaultPrefix
+	}
+
+	return &StateStore{
+		pool:   pool,
+		prefix: prefix,
+	}
+}
+
+// Check pings the server, returning an error if it can't be reached. It
+// satisfies the system.Checkable interface so that the server's health may be
+// reported by our readiness endpoint.
+func (s *StateStore) Check() error {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("PING")
+	if err != nil {
+		return errors.Wrap(err, "failed to ping redis")
+	}
+
+	return nil
+}
+
+// Stop closes the connections of the store's pool, after which any connection
+// in use is closed as its command completes.
+func (s *StateStore) Stop() error {
+	return s.pool.Close()
+}
+
+// Window adds the value received at the given time to the window with the
+// given key, removing values received more than the window's duration before
+// it, and returns the values remaining, including the one added. The window is
+// updated atomically by a script, so replicas may update the same window
+// concurrently.
+func (s *StateStore) Window(key string, value float64, at time.Time, window time.Duration) ([]float64, error) {
+	id, err := memberID()
+	if err != nil {
+		return nil, err
+	}
+
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	members, err := redigo.Strings(windowScript.Do(
+		conn,
+		s.key(key),
+		at.Unix(),
+		at.Add(-window).Unix(),
+		id+":"+strconv.FormatFloat(value, 'g', -1, 64),
+		int64(window/time.Second)+1,
+	))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to update window in redis")
+	}
+
+	return parseMembers(members)
+}
+
+// Values returns the values of the window with the given key which were
+// received within the window's duration before the given time, without
+// adding a value or removing any.
+func (s *StateStore) Values(key string, at time.Time, window time.Duration) ([]float64, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	members, err := redigo.Strings(conn.Do("ZRANGEBYSCORE", s.key(key), at.Add(-window).Unix(), "+inf"))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read window from redis")
+	}
+
+	return parseMembers(members)
+}
+
+// key returns the key in redis of the window with the given key.
+func (s *StateStore) key(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return s.prefix + hex.EncodeToString(sum[:])
+}
+
+// parseMembers returns the values of the given members of a window.
+func parseMembers(members []string) ([]float64, error) {
+	values := make([]float64, 0, len(members))
+	for _, member := range members {
+		i := strings.LastIndexByte(member, ':')
+
+		v, err := strconv.ParseFloat(member[i+1:], 64)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse window member in redis")
+		}
+
+		values = append(values, v)
+	}
+
+	return values, nil
+}
+
+// memberID returns a random id by which a value is distinguished from equal
+// values in a window.
+func memberID() (string, error) {
+	b := make([]byte, 8)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to generate window member id")
+	}
+
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,233 @@
+package redis_test
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	redigo "github.com/garyburd/redigo/redis"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/redis"
+)
+
+// fakeServer is a Redis server which records the commands it receives and
+// answers each with the raw reply returned by its handler.
+type fakeServer struct {
+	listener net.Listener
+	handler  func(args []string) string
+
+	sync.Mutex
+	commands [][]string
+}
+
+func newFakeServer(t *testing.T, handler func(args []string) string) *fakeServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	s := &fakeServer{listener: listener, handler: handler}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go s.serve(conn)
+		}
+	}()
+
+	return s
+}
+
+func (s *fakeServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+
+		s.Lock()
+		s.commands = append(s.commands, args)
+		s.Unlock()
+
+		io.WriteString(conn, s.handler(args))
+	}
+}
+
+// pool returns a pool of connections to the server.
+func (s *fakeServer) pool() *redigo.Pool {
+	return &redigo.Pool{
+		Dial: func() (redigo.Conn, error) {
+			return redigo.DialURL("redis://" + s.listener.Addr().String())
+		},
+	}
+}
+
+func (s *fakeServer) received() [][]string {
+	s.Lock()
+	defer s.Unlock()
+	return s.commands
+}
+
+func (s *fakeServer) Close() {
+	s.listener.Close()
+}
+
+// readCommand reads a command sent as an array of bulk strings.
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, n)
+	for i := range args {
+		line, err = r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		size, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+
+		buf := make([]byte, size+2)
+		_, err = io.ReadFull(r, buf)
+		if err != nil {
+			return nil, err
+		}
+
+		args[i] = string(buf[:size])
+	}
+
+	return args, nil
+}
+
+func TestStateStoreWindow(t *testing.T) {
+	var members []string
+
+	// the server doesn't have our script cached until it is first evaluated,
+	// after which it returns the members it was sent
+	server := newFakeServer(t, func(args []string) string {
+		if args[0] == "EVALSHA" && len(members) == 0 {
+			return "-NOSCRIPT No matching script. Please use EVAL.\r\n"
+		}
+
+		members = append(members, args[6])
+
+		reply := fmt.Sprintf("*%d\r\n", len(members))
+		for _, m := range members {
+			reply += fmt.Sprintf("$%d\r\n%s\r\n", len(m), m)
+		}
+		return reply
+	})
+	defer server.Close()
+
+	store := redis.NewStateStore(server.pool(), "")
+	defer store.Stop()
+
+	at := time.Unix(1544539604, 0)
+
+	values, err := store.Window("abc123:55:900", 4.5, at, 15*time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, []float64{4.5}, values)
+
+	values, err = store.Window("abc123:55:900", 5.5, at.Add(time.Minute), 15*time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, []float64{4.5, 5.5}, values)
+
+	commands := server.received()
+	assert.Len(t, commands, 3)
+
+	assert.Equal(t, "EVALSHA", commands[0][0])
+	assert.Equal(t, "EVAL", commands[1][0])
+	assert.Equal(t, "EVALSHA", commands[2][0])
+
+	// the key is hashed so that device tokens aren't stored in redis, and the
+	// window is expired a second after its duration
+	eval := commands[1]
+	assert.Equal(t, "1", eval[2])
+	assert.True(t, strings.HasPrefix(eval[3], redis.DefaultPrefix))
+	assert.NotContains(t, eval[3], "abc123")
+	assert.Equal(t, "1544539604", eval[4])
+	assert.Equal(t, "1544538704", eval[5])
+	assert.True(t, strings.HasSuffix(eval[6], ":4.5"))
+	assert.Equal(t, "901", eval[7])
+
+	// each value is stored as a distinct member
+	assert.NotEqual(t, eval[6], commands[2][6])
+	assert.Equal(t, eval[3], commands[2][3])
+}
+
+func TestStateStoreWindowError(t *testing.T) {
+	server := newFakeServer(t, func(args []string) string {
+		return "-ERR Error running script\r\n"
+	})
+	defer server.Close()
+
+	store := redis.NewStateStore(server.pool(), "test:")
+	defer store.Stop()
+
+	_, err := store.Window("abc123:55:900", 4.5, time.Now(), 15*time.Minute)
+	assert.NotNil(t, err)
+	assert.Equal(t, "failed to update window in redis: ERR Error running script", err.Error())
+}
+
+func TestStateStoreValues(t *testing.T) {
+	server := newFakeServer(t, func(args []string) string {
+		return "*2\r\n$6\r\nab:4.5\r\n$6\r\ncd:5.5\r\n"
+	})
+	defer server.Close()
+
+	store := redis.NewStateStore(server.pool(), "")
+	defer store.Stop()
+
+	at := time.Unix(1544539604, 0)
+
+	values, err := store.Values("abc123:55:900", at, 15*time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, []float64{4.5, 5.5}, values)
+
+	// the window is read from its start without being modified
+	commands := server.received()
+	assert.Len(t, commands, 1)
+	assert.Equal(t, "ZRANGEBYSCORE", commands[0][0])
+	assert.True(t, strings.HasPrefix(commands[0][1], redis.DefaultPrefix))
+	assert.Equal(t, "1544538704", commands[0][2])
+	assert.Equal(t, "+inf", commands[0][3])
+}
+
+func TestStateStoreCheck(t *testing.T) {
+	server := newFakeServer(t, func(args []string) string {
+		return "+PONG\r\n"
+	})
+
+	store := redis.NewStateStore(server.pool(), "")
+	defer store.Stop()
+
+	assert.Nil(t, store.Check())
+	assert.Equal(t, [][]string{{"PING"}}, server.received())
+
+	// once the server is unreachable the check fails
+	server.Close()
+
+	assert.NotNil(t, store.Check())
+}
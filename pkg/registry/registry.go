@@ -0,0 +1,96 @@
+package registry
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+)
+
+// TokenPlaceholder is replaced with the device token in the URL with which a
+// Verifier looks up devices.
+const TokenPlaceholder = "{token}"
+
+// ErrUnknownDevice is returned when a device is not known to the registry.
+var ErrUnknownDevice = errors.New("unknown device")
+
+// Config is used to pass in configuration when creating a Verifier.
+type Config struct {
+	// URL is the URL at which the registry returns a device, containing
+	// TokenPlaceholder in place of the device token, e.g.
+	// https://registry.local/devices/{token}.
+	URL string
+
+	// AuthToken is optional, and if set is sent to the registry as a bearer
+	// token.
+	AuthToken secret.Secret
+
+	// HTTPClient is the client used to call the registry.
+	HTTPClient *http.Client
+}
+
+// Verifier verifies device tokens against an external device registry over
+// HTTP, such as the SmartCitizen platform. A device is known if looking it up
+// succeeds, and unknown if the registry responds 404 or 410.
+type Verifier struct {
+	url       string
+	authToken secret.Secret
+	client    *http.Client
+	logger    kitlog.Logger
+}
+
+// NewVerifier returns a new Verifier configured with the given Config.
+func NewVerifier(config *Config, logger kitlog.Logger) *Verifier {
+	logger = kitlog.With(logger, "module", "registry")
+
+	logger.Log("msg", "creating device registry verifier", "url", config.URL)
+
+	return &Verifier{
+		url:       config.URL,
+		authToken: config.AuthToken,
+		client:    config.HTTPClient,
+		logger:    logger,
+	}
+}
+
+// Verify returns nil if the device with the given token is known to the
+// registry, ErrUnknownDevice if not, or any other error if the registry could
+// not be asked. Errors never include the device token.
+func (v *Verifier) Verify(ctx context.Context, deviceToken secret.Secret) error {
+	u := strings.Replace(v.url, TokenPlaceholder, url.PathEscape(deviceToken.Reveal()), -1)
+
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return errors.New("failed to create device registry request")
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	if v.authToken != "" {
+		req.Header.Set("Authorization", "Bearer "+v.authToken.Reveal())
+	}
+
+	resp, err := v.client.Do(req.WithContext(ctx))
+	if err != nil {
+		// the error of a failed request includes its URL, and so the token
+		if uerr, ok := err.(*url.Error); ok {
+			err = uerr.Err
+		}
+		return errors.Wrap(err, "failed to call device registry")
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound, resp.StatusCode == http.StatusGone:
+		return ErrUnknownDevice
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	default:
+		return errors.Errorf("device registry returned status %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,53 @@
+package registry_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/registry"
+)
+
+func TestVerify(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer s3cr3t", r.Header.Get("Authorization"))
+
+		switch r.URL.Path {
+		case "/devices/known":
+			w.Write([]byte(`{"id":1}`))
+		case "/devices/broken":
+			http.Error(w, "oops", http.StatusBadGateway)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	verifier := registry.NewVerifier(&registry.Config{
+		URL:        ts.URL + "/devices/" + registry.TokenPlaceholder,
+		AuthToken:  "s3cr3t",
+		HTTPClient: ts.Client(),
+	}, kitlog.NewNopLogger())
+
+	assert.Nil(t, verifier.Verify(context.Background(), "known"))
+	assert.Equal(t, registry.ErrUnknownDevice, verifier.Verify(context.Background(), "unknown"))
+
+	err := verifier.Verify(context.Background(), "broken")
+	assert.NotNil(t, err)
+	assert.Equal(t, "device registry returned status 502", err.Error())
+}
+
+func TestVerifyErrorOmitsToken(t *testing.T) {
+	verifier := registry.NewVerifier(&registry.Config{
+		URL:        "http://127.0.0.1:0/devices/" + registry.TokenPlaceholder,
+		HTTPClient: &http.Client{},
+	}, kitlog.NewNopLogger())
+
+	err := verifier.Verify(context.Background(), "abc123")
+	assert.NotNil(t, err)
+	assert.NotContains(t, err.Error(), "abc123")
+}
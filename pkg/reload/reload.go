@@ -0,0 +1,186 @@
+// Package reload applies changes made to streams other than through the
+// encoder, such as by an operations script editing the database directly. The
+// database notifies us of each device whose streams have changed, whereupon
+// the device is dropped from the device cache so that the pipeline loads its
+// current configuration, and the encoder's subscriptions to it are updated.
+package reload
+
+import (
+	"database/sql"
+	"sync"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/DECODEproject/iotencoder/pkg/logger"
+	"github.com/DECODEproject/iotencoder/pkg/logger/level"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+)
+
+var (
+	// ChangesCounter is a prometheus counter vec recording a count of stream
+	// configuration changes received from the database, labelled by whether
+	// they were applied or failed.
+	ChangesCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "config_changes",
+			Help:      "Count of stream configuration changes received from the database, labelled by result",
+		},
+		[]string{"result"},
+	)
+)
+
+// Store is the interface to the database notifying us of changes. It is
+// satisfied by the postgres.DB type.
+type Store interface {
+	ListenStreamChanges(stop <-chan struct{}) (<-chan postgres.StreamChange, error)
+	DeviceToken(deviceID int) (secret.Secret, error)
+	GetDevices() ([]*postgres.Device, error)
+}
+
+// Invalidator is the interface we call to drop changed devices from the device
+// cache. It is satisfied by the cache.Cache type.
+type Invalidator interface {
+	Invalidate(deviceToken secret.Secret)
+	InvalidateAll()
+}
+
+// Reloader is the interface we call to update the subscriptions to a changed
+// device. It is satisfied by the encoder returned by rpc.NewEncoder.
+type Reloader interface {
+	Reload(deviceToken secret.Secret) error
+}
+
+// Config is used to pass in dependencies when creating a Watcher. Cache is
+// optional, and should be set if devices are cached.
+type Config struct {
+	Store   Store
+	Cache   Invalidator
+	Encoder Reloader
+}
+
+// Watcher is a component that listens for changes made to streams in the
+// database and applies them to the running encoder.
+type Watcher struct {
+	store   Store
+	cache   Invalidator
+	encoder Reloader
+	logger  kitlog.Logger
+	quit    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewWatcher returns a new Watcher configured with the given config.
+func NewWatcher(config *Config, logger kitlog.Logger) *Watcher {
+	logger = kitlog.With(logger, "module", "reload")
+
+	return &Watcher{
+		store:   config.Store,
+		cache:   config.Cache,
+		encoder: config.Encoder,
+		logger:  logger,
+	}
+}
+
+// Start starts listening for changes, applying each as it is received.
+func (w *Watcher) Start() error {
+	w.logger.Log("msg", "starting stream change watcher")
+
+	w.quit = make(chan struct{})
+
+	changes, err := w.store.ListenStreamChanges(w.quit)
+	if err != nil {
+		return errors.Wrap(err, "failed to start stream change watcher")
+	}
+
+	w.wg.Add(1)
+
+	go w.loop(changes)
+
+	return nil
+}
+
+// Stop stops listening for changes, waiting for any change being applied.
+func (w *Watcher) Stop() error {
+	if w.quit == nil {
+		return nil
+	}
+
+	w.logger.Log("msg", "stopping stream change watcher")
+
+	close(w.quit)
+	w.wg.Wait()
+
+	return nil
+}
+
+// Apply applies the given change. If the change may have been missed every
+// cached device is dropped and every device with streams is reloaded, though
+// a device whose streams were all deleted in the meantime can't be found, so
+// remains subscribed to until the encoder is restarted.
+func (w *Watcher) Apply(change postgres.StreamChange) error {
+	if change.Missed {
+		level.Warn(w.logger).Log("msg", "stream changes may have been missed, reloading all devices")
+
+		if w.cache != nil {
+			w.cache.InvalidateAll()
+		}
+
+		devices, err := w.store.GetDevices()
+		if err != nil {
+			return errors.Wrap(err, "failed to load devices")
+		}
+
+		for _, device := range devices {
+			err = w.encoder.Reload(device.DeviceToken)
+			if err != nil {
+				return errors.Wrapf(err, "failed to reload device %s", logger.HashToken(device.DeviceToken))
+			}
+		}
+
+		return nil
+	}
+
+	deviceToken, err := w.store.DeviceToken(change.DeviceID)
+	if errors.Cause(err) == sql.ErrNoRows {
+		// the device was purged along with its deleted streams, of which we
+		// were notified as they were deleted
+		return nil
+	}
+
+	if err != nil {
+		return errors.Wrap(err, "failed to load changed device")
+	}
+
+	if w.cache != nil {
+		w.cache.Invalidate(deviceToken)
+	}
+
+	err = w.encoder.Reload(deviceToken)
+	if err != nil {
+		return errors.Wrapf(err, "failed to reload device %s", logger.HashToken(deviceToken))
+	}
+
+	return nil
+}
+
+// loop is run in a goroutine and applies each change received until the
+// watcher is stopped.
+func (w *Watcher) loop(changes <-chan postgres.StreamChange) {
+	defer w.wg.Done()
+
+	for change := range changes {
+		err := w.Apply(change)
+		if err != nil {
+			ChangesCounter.WithLabelValues("failed").Inc()
+			level.Error(w.logger).Log("err", err, "msg", "failed to apply stream change")
+			continue
+		}
+
+		ChangesCounter.WithLabelValues("applied").Inc()
+	}
+}
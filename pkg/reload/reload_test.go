@@ -0,0 +1,137 @@
+package reload_test
+
+import (
+	"database/sql"
+	"testing"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/reload"
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+)
+
+// fakeStore holds the tokens of devices keyed by id, sending the changes
+// written to its channel.
+type fakeStore struct {
+	tokens  map[int]secret.Secret
+	changes chan postgres.StreamChange
+}
+
+func (f *fakeStore) ListenStreamChanges(stop <-chan struct{}) (<-chan postgres.StreamChange, error) {
+	out := make(chan postgres.StreamChange)
+
+	go func() {
+		defer close(out)
+
+		for {
+			select {
+			case change := <-f.changes:
+				select {
+				case out <- change:
+				case <-stop:
+					return
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+func (f *fakeStore) DeviceToken(deviceID int) (secret.Secret, error) {
+	token, ok := f.tokens[deviceID]
+	if !ok {
+		return "", errors.Wrap(sql.ErrNoRows, "failed to load device token")
+	}
+	return token, nil
+}
+
+func (f *fakeStore) GetDevices() ([]*postgres.Device, error) {
+	devices := []*postgres.Device{}
+	for id, token := range f.tokens {
+		devices = append(devices, &postgres.Device{ID: id, DeviceToken: token})
+	}
+	return devices, nil
+}
+
+// recorder records the devices invalidated and reloaded.
+type recorder struct {
+	invalidated []secret.Secret
+	all         int
+	reloaded    []secret.Secret
+	reloads     chan secret.Secret
+}
+
+func (r *recorder) Invalidate(deviceToken secret.Secret) {
+	r.invalidated = append(r.invalidated, deviceToken)
+}
+
+func (r *recorder) InvalidateAll() {
+	r.all++
+}
+
+func (r *recorder) Reload(deviceToken secret.Secret) error {
+	r.reloaded = append(r.reloaded, deviceToken)
+
+	if r.reloads != nil {
+		r.reloads <- deviceToken
+	}
+
+	return nil
+}
+
+func TestApply(t *testing.T) {
+	store := &fakeStore{tokens: map[int]secret.Secret{1: "foo", 2: "bar"}}
+	r := &recorder{}
+
+	watcher := reload.NewWatcher(&reload.Config{
+		Store:   store,
+		Cache:   r,
+		Encoder: r,
+	}, kitlog.NewNopLogger())
+
+	err := watcher.Apply(postgres.StreamChange{DeviceID: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, []secret.Secret{"foo"}, r.invalidated)
+	assert.Equal(t, []secret.Secret{"foo"}, r.reloaded)
+
+	// a purged device is ignored
+	err = watcher.Apply(postgres.StreamChange{DeviceID: 3})
+	assert.Nil(t, err)
+	assert.Len(t, r.reloaded, 1)
+
+	// while after missing changes every device is reloaded
+	err = watcher.Apply(postgres.StreamChange{Missed: true})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, r.all)
+	assert.ElementsMatch(t, []secret.Secret{"foo", "foo", "bar"}, r.reloaded)
+}
+
+func TestWatcher(t *testing.T) {
+	store := &fakeStore{
+		tokens:  map[int]secret.Secret{1: "foo"},
+		changes: make(chan postgres.StreamChange),
+	}
+
+	r := &recorder{reloads: make(chan secret.Secret, 1)}
+
+	// without a cache changes are still applied to the encoder
+	watcher := reload.NewWatcher(&reload.Config{
+		Store:   store,
+		Encoder: r,
+	}, kitlog.NewNopLogger())
+
+	err := watcher.Start()
+	assert.Nil(t, err)
+
+	store.changes <- postgres.StreamChange{DeviceID: 1}
+	assert.Equal(t, secret.Secret("foo"), <-r.reloads)
+
+	err = watcher.Stop()
+	assert.Nil(t, err)
+}
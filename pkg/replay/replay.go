@@ -0,0 +1,307 @@
+package replay
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/logger/level"
+	"github.com/DECODEproject/iotencoder/pkg/pipeline"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+)
+
+const (
+	// DefaultBatchSize is the number of retained payloads we read per page if no
+	// batch size is configured.
+	DefaultBatchSize = 100
+
+	// DefaultJobTTL is the time for which a finished job is kept so that its
+	// outcome may be read if no TTL is configured.
+	DefaultJobTTL = 24 * time.Hour
+
+	// Running is the state of a job that is still replaying payloads.
+	Running = "running"
+
+	// Completed is the state of a job that has replayed all matching payloads.
+	Completed = "completed"
+
+	// Failed is the state of a job that was unable to read retained payloads.
+	Failed = "failed"
+
+	// Cancelled is the state of a job that was stopped before completing.
+	Cancelled = "cancelled"
+)
+
+// StreamSource is the interface we require of a type able to return a stream
+// along with its device. We define it here where we need it, and it is
+// satisfied by our postgres.DB type.
+type StreamSource interface {
+	GetStream(streamID, token string) (*postgres.Stream, error)
+}
+
+// PayloadSource is the interface we require of a type able to return the raw
+// payloads retained for a device. It is satisfied by any retention.Store.
+type PayloadSource interface {
+	CountRawPayloads(deviceToken string, start, end time.Time) (int, error)
+	GetRawPayloads(deviceToken string, start, end time.Time, afterID int64, limit int) ([]*postgres.RawPayload, error)
+}
+
+// Processor is the interface we call to re-process a retained payload. It is
+// satisfied by the pipeline.Processor type.
+type Processor interface {
+	Process(ctx context.Context, device *postgres.Device, payload []byte) error
+}
+
+// Job is a type used to report the progress of a single replay.
+type Job struct {
+	ID         string
+	StreamID   string
+	State      string
+	Total      int
+	Processed  int
+	Failed     int
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Err        string
+}
+
+// Config is used to pass in configuration when creating a Replayer. If
+// MessageTimeout is non-zero it bounds the processing of each replayed payload.
+// JobTTL is the time for which finished jobs are kept, DefaultJobTTL if zero.
+type Config struct {
+	Streams        StreamSource
+	Payloads       PayloadSource
+	Processor      Processor
+	Clock          clock.Clock
+	BatchSize      int
+	MessageTimeout time.Duration
+	JobTTL         time.Duration
+}
+
+// Replayer re-runs retained raw payloads for a single stream back through the
+// processing pipeline, encrypting them with the stream's current keys and
+// writing the results to the datastore. Replays run asynchronously and their
+// progress is held in memory, so job history is lost on restart, and finished
+// jobs are forgotten once their TTL has passed.
+type Replayer struct {
+	streams   StreamSource
+	payloads  PayloadSource
+	processor Processor
+	clock     clock.Clock
+	batchSize int
+	timeout   time.Duration
+	jobTTL    time.Duration
+	logger    kitlog.Logger
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	sync.Mutex
+	jobs map[string]*Job
+}
+
+// NewReplayer returns a new Replayer configured with the given Config.
+func NewReplayer(config *Config, logger kitlog.Logger) *Replayer {
+	logger = kitlog.With(logger, "module", "replay")
+
+	batchSize := config.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+
+	jobTTL := config.JobTTL
+	if jobTTL <= 0 {
+		jobTTL = DefaultJobTTL
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Replayer{
+		streams:   config.Streams,
+		payloads:  config.Payloads,
+		processor: config.Processor,
+		clock:     config.Clock,
+		batchSize: batchSize,
+		timeout:   config.MessageTimeout,
+		jobTTL:    jobTTL,
+		logger:    logger,
+		ctx:       ctx,
+		cancel:    cancel,
+		jobs:      make(map[string]*Job),
+	}
+}
+
+// Stop cancels any running replays and waits for them to exit.
+func (r *Replayer) Stop() error {
+	r.logger.Log("msg", "stopping replayer")
+
+	r.cancel()
+	r.wg.Wait()
+
+	return nil
+}
+
+// Replay starts replaying all payloads retained for the given stream's device
+// that were received within the given interval. The stream's token must be
+// supplied. We return a copy of the newly created job which can be used to
+// poll for progress via Status.
+func (r *Replayer) Replay(streamID, token string, start, end time.Time) (*Job, error) {
+	if !end.After(start) {
+		return nil, errors.New("end time must be after start time")
+	}
+
+	stream, err := r.streams.GetStream(streamID, token)
+	if err != nil {
+		return nil, err
+	}
+
+	total, err := r.payloads.CountRawPayloads(stream.Device.DeviceToken.Reveal(), start, end)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to count retained payloads")
+	}
+
+	jobID, err := uuid.NewRandom()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate job id")
+	}
+
+	job := &Job{
+		ID:        jobID.String(),
+		StreamID:  stream.StreamID,
+		State:     Running,
+		Total:     total,
+		StartedAt: r.clock.Now(),
+	}
+
+	r.Lock()
+	r.prune()
+	r.jobs[job.ID] = job
+	r.Unlock()
+
+	r.logger.Log("msg", "starting replay", "job_id", job.ID, "stream_uid", stream.StreamID, "total", total)
+
+	r.wg.Add(1)
+	go r.run(job.ID, stream, start, end)
+
+	return r.Status(job.ID), nil
+}
+
+// Status returns a copy of the job identified by the given id, or nil if no
+// such job exists or it finished longer ago than the job TTL.
+func (r *Replayer) Status(jobID string) *Job {
+	r.Lock()
+	defer r.Unlock()
+
+	r.prune()
+
+	job, ok := r.jobs[jobID]
+	if !ok {
+		return nil
+	}
+
+	j := *job
+	return &j
+}
+
+// prune deletes the jobs which finished longer ago than the job TTL. It must be
+// called with the lock held.
+func (r *Replayer) prune() {
+	now := r.clock.Now()
+
+	for id, job := range r.jobs {
+		if !job.FinishedAt.IsZero() && now.Sub(job.FinishedAt) > r.jobTTL {
+			delete(r.jobs, id)
+		}
+	}
+}
+
+// run is executed in a goroutine and pages through the retained payloads for a
+// job, passing each to the processor. Failures to process individual payloads
+// are counted but do not stop the replay.
+func (r *Replayer) run(jobID string, stream *postgres.Stream, start, end time.Time) {
+	defer r.wg.Done()
+
+	// process against the target stream only so other streams fed by the same
+	// device don't receive duplicate data
+	device := *stream.Device
+	device.Streams = []*postgres.Stream{stream}
+
+	var afterID int64
+
+	for {
+		select {
+		case <-r.ctx.Done():
+			r.finish(jobID, Cancelled, nil)
+			return
+		default:
+		}
+
+		payloads, err := r.payloads.GetRawPayloads(device.DeviceToken.Reveal(), start, end, afterID, r.batchSize)
+		if err != nil {
+			r.finish(jobID, Failed, err)
+			return
+		}
+
+		if len(payloads) == 0 {
+			r.finish(jobID, Completed, nil)
+			return
+		}
+
+		for _, p := range payloads {
+			err = r.process(&device, p)
+
+			r.Lock()
+			job := r.jobs[jobID]
+			job.Processed++
+			if err != nil {
+				job.Failed++
+			}
+			r.Unlock()
+
+			if err != nil {
+				level.Error(r.logger).Log("err", err, "msg", "failed to replay payload", "job_id", jobID, "payload_id", p.ID)
+			}
+
+			afterID = p.ID
+		}
+	}
+}
+
+// process passes a single payload to the processor, bounded by the configured
+// message timeout and cancelled if the replayer is stopped. Timestamp policies
+// are applied against the time the payload was originally received.
+func (r *Replayer) process(device *postgres.Device, payload *postgres.RawPayload) error {
+	ctx := pipeline.WithReceivedAt(pipeline.WithReplay(r.ctx), payload.ReceivedAt)
+
+	if r.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+	}
+
+	return r.processor.Process(ctx, device, payload.Payload)
+}
+
+// finish records the final state of a job.
+func (r *Replayer) finish(jobID, state string, err error) {
+	r.Lock()
+	defer r.Unlock()
+
+	job := r.jobs[jobID]
+	job.State = state
+	job.FinishedAt = r.clock.Now()
+
+	if err != nil {
+		job.Err = err.Error()
+		level.Error(r.logger).Log("err", err, "msg", "replay failed", "job_id", jobID)
+		return
+	}
+
+	r.logger.Log("msg", "replay finished", "job_id", jobID, "state", state, "processed", job.Processed, "failed", job.Failed)
+}
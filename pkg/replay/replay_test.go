@@ -0,0 +1,202 @@
+package replay_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/replay"
+)
+
+type source struct {
+	stream   *postgres.Stream
+	payloads []*postgres.RawPayload
+	err      error
+}
+
+func (s *source) GetStream(streamID, token string) (*postgres.Stream, error) {
+	if s.stream == nil || s.stream.StreamID != streamID || s.stream.Token.Reveal() != token {
+		return nil, postgres.ErrStreamNotFound
+	}
+	return s.stream, nil
+}
+
+func (s *source) CountRawPayloads(deviceToken string, start, end time.Time) (int, error) {
+	return len(s.payloads), nil
+}
+
+func (s *source) GetRawPayloads(deviceToken string, start, end time.Time, afterID int64, limit int) ([]*postgres.RawPayload, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	page := []*postgres.RawPayload{}
+	for _, p := range s.payloads {
+		if p.ID > afterID && len(page) < limit {
+			page = append(page, p)
+		}
+	}
+	return page, nil
+}
+
+type processor struct {
+	sync.Mutex
+	payloads []string
+	streams  int
+}
+
+func (p *processor) Process(ctx context.Context, device *postgres.Device, payload []byte) error {
+	p.Lock()
+	defer p.Unlock()
+
+	p.payloads = append(p.payloads, string(payload))
+	p.streams = len(device.Streams)
+
+	if string(payload) == "bad" {
+		return errors.New("failed")
+	}
+	return nil
+}
+
+// waitFor polls the replayer until the job leaves the running state.
+func waitFor(t *testing.T, r *replay.Replayer, jobID string) *replay.Job {
+	deadline := time.Now().Add(time.Second)
+
+	for time.Now().Before(deadline) {
+		job := r.Status(jobID)
+		if job.State != replay.Running {
+			return job
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	t.Fatal("timed out waiting for replay to finish")
+	return nil
+}
+
+func newStream() *postgres.Stream {
+	device := &postgres.Device{DeviceToken: "abc123"}
+	stream := &postgres.Stream{StreamID: "stream-1", Token: "secret", Device: device}
+	device.Streams = []*postgres.Stream{stream, {StreamID: "stream-2"}}
+	return stream
+}
+
+func TestReplay(t *testing.T) {
+	src := &source{
+		stream: newStream(),
+		payloads: []*postgres.RawPayload{
+			{ID: 1, Payload: []byte("one")},
+			{ID: 2, Payload: []byte("bad")},
+			{ID: 3, Payload: []byte("three")},
+		},
+	}
+	proc := &processor{}
+
+	r := replay.NewReplayer(&replay.Config{
+		Streams:   src,
+		Payloads:  src,
+		Processor: proc,
+		Clock:     clock.New(),
+		BatchSize: 2,
+	}, kitlog.NewNopLogger())
+	defer r.Stop()
+
+	now := time.Now()
+
+	job, err := r.Replay("stream-1", "secret", now.Add(-time.Hour), now)
+	assert.Nil(t, err)
+	assert.Equal(t, "stream-1", job.StreamID)
+	assert.Equal(t, 3, job.Total)
+
+	job = waitFor(t, r, job.ID)
+	assert.Equal(t, replay.Completed, job.State)
+	assert.Equal(t, 3, job.Processed)
+	assert.Equal(t, 1, job.Failed)
+	assert.False(t, job.FinishedAt.IsZero())
+
+	assert.Equal(t, []string{"one", "bad", "three"}, proc.payloads)
+	assert.Equal(t, 1, proc.streams)
+}
+
+func TestReplayFailure(t *testing.T) {
+	src := &source{
+		stream: newStream(),
+		err:    errors.New("boom"),
+	}
+
+	r := replay.NewReplayer(&replay.Config{
+		Streams:   src,
+		Payloads:  src,
+		Processor: &processor{},
+		Clock:     clock.New(),
+	}, kitlog.NewNopLogger())
+	defer r.Stop()
+
+	now := time.Now()
+
+	job, err := r.Replay("stream-1", "secret", now.Add(-time.Hour), now)
+	assert.Nil(t, err)
+
+	job = waitFor(t, r, job.ID)
+	assert.Equal(t, replay.Failed, job.State)
+	assert.Equal(t, "boom", job.Err)
+}
+
+func TestReplayInvalid(t *testing.T) {
+	r := replay.NewReplayer(&replay.Config{
+		Streams:   &source{stream: newStream()},
+		Payloads:  &source{},
+		Processor: &processor{},
+		Clock:     clock.New(),
+	}, kitlog.NewNopLogger())
+	defer r.Stop()
+
+	now := time.Now()
+
+	_, err := r.Replay("stream-1", "wrong", now.Add(-time.Hour), now)
+	assert.Equal(t, postgres.ErrStreamNotFound, err)
+
+	_, err = r.Replay("stream-1", "secret", now, now.Add(-time.Hour))
+	assert.NotNil(t, err)
+
+	assert.Nil(t, r.Status("unknown"))
+}
+
+func TestReplayJobTTL(t *testing.T) {
+	src := &source{
+		stream:   newStream(),
+		payloads: []*postgres.RawPayload{{ID: 1, Payload: []byte("one")}},
+	}
+
+	now := time.Now()
+	c := clock.NewMock(now)
+
+	r := replay.NewReplayer(&replay.Config{
+		Streams:   src,
+		Payloads:  src,
+		Processor: &processor{},
+		Clock:     c,
+		JobTTL:    time.Hour,
+	}, kitlog.NewNopLogger())
+	defer r.Stop()
+
+	job, err := r.Replay("stream-1", "secret", now.Add(-time.Hour), now)
+	assert.Nil(t, err)
+
+	job = waitFor(t, r, job.ID)
+	assert.Equal(t, replay.Completed, job.State)
+
+	// a finished job is kept for its TTL, then forgotten
+	c.Add(time.Hour)
+	assert.NotNil(t, r.Status(job.ID))
+
+	c.Add(time.Minute)
+	assert.Nil(t, r.Status(job.ID))
+}
@@ -0,0 +1,101 @@
+// Package requestid generates and propagates the ids by which a single request,
+// or a single message received from a device, is traced through our logs and
+// across the services we call. Ids are carried in the context under the same
+// key as those assigned to incoming HTTP requests by the iotcommon request id
+// middleware, so that Twirp calls and device messages are traced alike.
+package requestid
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/DECODEproject/iotcommon/middleware"
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/google/uuid"
+	"github.com/twitchtv/twirp"
+)
+
+// Header is the HTTP header in which request ids are received and sent.
+const Header = middleware.RequestIDHeader
+
+// New returns a new random request id.
+func New() string {
+	return uuid.New().String()
+}
+
+// With returns a copy of the context carrying the given request id.
+func With(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, middleware.RequestCtxKey, id)
+}
+
+// FromContext returns the request id carried by the context, or an empty
+// string if it has none.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(middleware.RequestCtxKey).(string)
+	return id
+}
+
+// Ensure returns the context unchanged if it carries a request id, and
+// otherwise a copy carrying a new one.
+func Ensure(ctx context.Context) context.Context {
+	if FromContext(ctx) != "" {
+		return ctx
+	}
+
+	return With(ctx, New())
+}
+
+// Logger returns a logger which adds the request id carried by the context to
+// every line, or the given logger if the context carries none.
+func Logger(ctx context.Context, logger kitlog.Logger) kitlog.Logger {
+	id := FromContext(ctx)
+	if id == "" {
+		return logger
+	}
+
+	return kitlog.With(logger, "request_id", id)
+}
+
+// Outgoing returns a copy of the context with which Twirp clients send the
+// request id it carries in the Header of their requests, along with any other
+// headers already set for them. If the context carries no request id it is
+// returned unchanged.
+func Outgoing(ctx context.Context) context.Context {
+	id := FromContext(ctx)
+	if id == "" {
+		return ctx
+	}
+
+	header := http.Header{}
+	if existing, ok := twirp.HTTPRequestHeaders(ctx); ok {
+		for k, v := range existing {
+			header[k] = v
+		}
+	}
+
+	header.Set(Header, id)
+
+	outgoing, err := twirp.WithHTTPRequestHeaders(ctx, header)
+	if err != nil {
+		return ctx
+	}
+
+	return outgoing
+}
+
+// WithMeta returns the given error with the request id carried by the context
+// added to its metadata if it is a Twirp error, so that callers can quote it
+// when reporting a failure. Other errors are returned unchanged.
+func WithMeta(ctx context.Context, err error) error {
+	id := FromContext(ctx)
+	if id == "" {
+		return err
+	}
+
+	twerr, ok := err.(twirp.Error)
+	if !ok {
+		return err
+	}
+
+	return twerr.WithMeta("request_id", id)
+}
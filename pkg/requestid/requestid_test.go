@@ -0,0 +1,49 @@
+package requestid_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/twitchtv/twirp"
+
+	"github.com/DECODEproject/iotencoder/pkg/requestid"
+)
+
+func TestEnsure(t *testing.T) {
+	ctx := requestid.Ensure(context.Background())
+	id := requestid.FromContext(ctx)
+	assert.Len(t, id, 36)
+
+	assert.Equal(t, id, requestid.FromContext(requestid.Ensure(ctx)))
+	assert.Equal(t, "", requestid.FromContext(context.Background()))
+}
+
+func TestOutgoing(t *testing.T) {
+	ctx, err := twirp.WithHTTPRequestHeaders(context.Background(), http.Header{"Authorization": []string{"Bearer token"}})
+	assert.Nil(t, err)
+
+	ctx = requestid.Outgoing(requestid.With(ctx, "request-id"))
+
+	header, ok := twirp.HTTPRequestHeaders(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, "request-id", header.Get(requestid.Header))
+	assert.Equal(t, "Bearer token", header.Get("Authorization"))
+
+	_, ok = twirp.HTTPRequestHeaders(requestid.Outgoing(context.Background()))
+	assert.False(t, ok)
+}
+
+func TestWithMeta(t *testing.T) {
+	ctx := requestid.With(context.Background(), "request-id")
+
+	err := requestid.WithMeta(ctx, twirp.RequiredArgumentError("stream_uid"))
+	assert.Equal(t, "request-id", err.(twirp.Error).Meta("request_id"))
+	assert.Equal(t, "stream_uid", err.(twirp.Error).Meta("argument"))
+
+	plain := errors.New("plain")
+	assert.Equal(t, plain, requestid.WithMeta(ctx, plain))
+	assert.Nil(t, requestid.WithMeta(ctx, nil))
+}
@@ -0,0 +1,517 @@
+package retention
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/scrypt"
+
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+)
+
+const (
+	// payloadExt is the file extension of retained payload files.
+	payloadExt = ".payload"
+
+	// saltFile is the name of the file within the retention directory holding
+	// the salt from which, with the encryption password, the key encrypting
+	// payloads is derived, and newSaltFile that of a salt generated but not
+	// yet adopted.
+	saltFile    = ".key_salt"
+	newSaltFile = ".key_salt.new"
+
+	// saltLength is the length of the random salt generated for a directory.
+	saltLength = 16
+
+	// scryptN, scryptR and scryptP are the scrypt cost parameters with which
+	// the key encrypting payloads is derived.
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// DiskStore is a retention Store that writes each payload to a separate file
+// within a per device subdirectory of a local directory. Files are named using
+// the payload's id and received time so that payloads can be filtered without
+// reading them. If an encryption password is configured, payloads are
+// encrypted using AES-GCM with a key derived by scrypt from the password and a
+// random salt kept in the directory.
+type DiskStore struct {
+	dir                string
+	encryptionPassword secret.Secret
+	aead               cipher.AEAD
+
+	sync.Mutex
+	lastID int64
+}
+
+// payloadFile holds the attributes of a retained payload parsed from its path.
+type payloadFile struct {
+	path       string
+	id         int64
+	receivedAt time.Time
+	size       int64
+}
+
+// NewDiskStore returns a new DiskStore which retains payloads within dir. If
+// encryptionPassword is empty payloads are written unencrypted. The store must
+// be started before use.
+func NewDiskStore(dir string, encryptionPassword secret.Secret) *DiskStore {
+	return &DiskStore{
+		dir:                dir,
+		encryptionPassword: encryptionPassword,
+	}
+}
+
+// Start creates the retention directory if required, and finds the id of the
+// most recently retained payload so that new ids continue to increase.
+func (d *DiskStore) Start() error {
+	err := os.MkdirAll(d.dir, 0700)
+	if err != nil {
+		return errors.Wrap(err, "failed to create retention directory")
+	}
+
+	files, err := d.files("")
+	if err != nil {
+		return err
+	}
+
+	if d.encryptionPassword != "" {
+		d.aead, err = d.deriveKey(files)
+		if err != nil {
+			return err
+		}
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	for _, f := range files {
+		if f.id > d.lastID {
+			d.lastID = f.id
+		}
+	}
+
+	return nil
+}
+
+// SaveRawPayload writes the payload to a new file.
+func (d *DiskStore) SaveRawPayload(deviceToken string, receivedAt time.Time, payload []byte) error {
+	deviceDir, err := d.deviceDir(deviceToken)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(deviceDir, 0700)
+	if err != nil {
+		return errors.Wrap(err, "failed to create device directory")
+	}
+
+	data, err := d.seal(payload)
+	if err != nil {
+		return err
+	}
+
+	d.Lock()
+	d.lastID++
+	id := d.lastID
+	d.Unlock()
+
+	name := fmt.Sprintf("%020d-%d%s", id, receivedAt.UnixNano(), payloadExt)
+
+	// write to a temporary file first so readers never see partial payloads
+	tmp := filepath.Join(deviceDir, "."+name)
+
+	err = ioutil.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return errors.Wrap(err, "failed to write payload")
+	}
+
+	err = os.Rename(tmp, filepath.Join(deviceDir, name))
+	if err != nil {
+		return errors.Wrap(err, "failed to rename payload")
+	}
+
+	return nil
+}
+
+// CountRawPayloads returns the number of payloads retained for the device
+// within the given interval.
+func (d *DiskStore) CountRawPayloads(deviceToken string, start, end time.Time) (int, error) {
+	files, err := d.deviceFiles(deviceToken, start, end, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(files), nil
+}
+
+// GetRawPayloads returns a page of payloads retained for the device within the
+// given interval, ordered by id.
+func (d *DiskStore) GetRawPayloads(deviceToken string, start, end time.Time, afterID int64, limit int) ([]*postgres.RawPayload, error) {
+	files, err := d.deviceFiles(deviceToken, start, end, afterID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(files) > limit {
+		files = files[:limit]
+	}
+
+	payloads := []*postgres.RawPayload{}
+
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f.path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				// pruned since we listed the directory
+				continue
+			}
+			return nil, errors.Wrap(err, "failed to read payload")
+		}
+
+		payload, err := d.open(data)
+		if err != nil {
+			return nil, err
+		}
+
+		payloads = append(payloads, &postgres.RawPayload{
+			ID:          f.id,
+			DeviceToken: deviceToken,
+			ReceivedAt:  f.receivedAt,
+			Payload:     payload,
+		})
+	}
+
+	return payloads, nil
+}
+
+// PruneRawPayloads deletes payloads received before the given time, and then
+// the oldest payloads until the total size of retained files is within
+// maxSize.
+func (d *DiskStore) PruneRawPayloads(before time.Time, maxSize int64) (int64, error) {
+	files, err := d.files("")
+	if err != nil {
+		return 0, err
+	}
+
+	// newest first, so we keep the most recent payloads within the size cap
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].id > files[j].id
+	})
+
+	var (
+		deleted int64
+		total   int64
+	)
+
+	for _, f := range files {
+		total += f.size
+
+		if f.receivedAt.Before(before) || (maxSize > 0 && total > maxSize) {
+			err = os.Remove(f.path)
+			if err != nil && !os.IsNotExist(err) {
+				return deleted, errors.Wrap(err, "failed to delete payload")
+			}
+
+			deleted++
+			total -= f.size
+		}
+	}
+
+	return deleted, nil
+}
+
+// deviceDir returns the directory in which payloads for the given device are
+// written.
+func (d *DiskStore) deviceDir(deviceToken string) (string, error) {
+	if deviceToken == "" || strings.ContainsAny(deviceToken, `/\.`) {
+		return "", errors.New("invalid device token")
+	}
+
+	return filepath.Join(d.dir, deviceToken), nil
+}
+
+// deviceFiles returns the payload files for a device within the given interval
+// with an id greater than afterID, ordered by id.
+func (d *DiskStore) deviceFiles(deviceToken string, start, end time.Time, afterID int64) ([]*payloadFile, error) {
+	deviceDir, err := d.deviceDir(deviceToken)
+	if err != nil {
+		return nil, err
+	}
+
+	all, err := d.files(deviceDir)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []*payloadFile{}
+
+	for _, f := range all {
+		if f.id > afterID && !f.receivedAt.Before(start) && f.receivedAt.Before(end) {
+			files = append(files, f)
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].id < files[j].id
+	})
+
+	return files, nil
+}
+
+// files walks the given directory (or the whole store if empty) returning all
+// payload files found.
+func (d *DiskStore) files(root string) ([]*payloadFile, error) {
+	if root == "" {
+		root = d.dir
+	}
+
+	files := []*payloadFile{}
+
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		f, ok := parsePayloadFile(path)
+		if !ok {
+			return nil
+		}
+
+		f.size = info.Size()
+		files = append(files, f)
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list retained payloads")
+	}
+
+	return files, nil
+}
+
+// deriveKey returns the cipher with which payloads are encrypted, keyed with
+// the key derived by scrypt from the encryption password and the salt of the
+// directory, which is generated when payloads are first encrypted in it. The
+// given payloads, if retained before keys were derived by scrypt and so
+// encrypted with the SHA-256 hash of the password, are encrypted again with the
+// derived key before its salt is adopted, so that an upgrade interrupted by a
+// crash is resumed when the store is next started.
+func (d *DiskStore) deriveKey(files []*payloadFile) (cipher.AEAD, error) {
+	salt, err := ioutil.ReadFile(filepath.Join(d.dir, saltFile))
+	if err == nil {
+		return newAEAD(d.encryptionPassword.Reveal(), salt)
+	}
+
+	if !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "failed to read salt")
+	}
+
+	newPath := filepath.Join(d.dir, newSaltFile)
+
+	salt, err = ioutil.ReadFile(newPath)
+	if os.IsNotExist(err) {
+		salt = make([]byte, saltLength)
+
+		_, err = io.ReadFull(rand.Reader, salt)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to generate salt")
+		}
+
+		err = ioutil.WriteFile(newPath, salt, 0600)
+	}
+
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to save salt")
+	}
+
+	aead, err := newAEAD(d.encryptionPassword.Reveal(), salt)
+	if err != nil {
+		return nil, err
+	}
+
+	legacyKey := sha256.Sum256([]byte(d.encryptionPassword.Reveal()))
+
+	legacy, err := newCipher(legacyKey[:])
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range files {
+		err = reseal(f.path, legacy, aead)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = os.Rename(newPath, filepath.Join(d.dir, saltFile))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to save salt")
+	}
+
+	return aead, nil
+}
+
+// reseal encrypts the payload in the file at the given path with one cipher,
+// if it is encrypted with another. Files already encrypted with the first, or
+// which neither can decrypt, are left as they are.
+func reseal(path string, from, to cipher.AEAD) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.Wrap(err, "failed to read retained payload")
+	}
+
+	if _, err = open(to, data); err == nil {
+		return nil
+	}
+
+	payload, err := open(from, data)
+	if err != nil {
+		return nil
+	}
+
+	data, err = seal(to, payload)
+	if err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path))
+
+	err = ioutil.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return errors.Wrap(err, "failed to write retained payload")
+	}
+
+	err = os.Rename(tmp, path)
+	if err != nil {
+		return errors.Wrap(err, "failed to write retained payload")
+	}
+
+	return nil
+}
+
+// newAEAD returns an AES-GCM cipher keyed with the key derived by scrypt from
+// the given password and salt.
+func newAEAD(password string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(password), salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to derive key")
+	}
+
+	return newCipher(key)
+}
+
+// newCipher returns an AES-GCM cipher keyed with the given key.
+func newCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create cipher")
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create AEAD")
+	}
+
+	return aead, nil
+}
+
+// seal encrypts the payload if we have a key configured.
+func (d *DiskStore) seal(payload []byte) ([]byte, error) {
+	if d.aead == nil {
+		return payload, nil
+	}
+
+	return seal(d.aead, payload)
+}
+
+// open decrypts the payload if we have a key configured.
+func (d *DiskStore) open(data []byte) ([]byte, error) {
+	if d.aead == nil {
+		return data, nil
+	}
+
+	return open(d.aead, data)
+}
+
+// seal encrypts the payload with the given cipher, prefixing the random nonce.
+func seal(aead cipher.AEAD, payload []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+
+	_, err := io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate nonce")
+	}
+
+	return aead.Seal(nonce, nonce, payload, nil), nil
+}
+
+// open decrypts a payload encrypted by seal with the given cipher.
+func open(aead cipher.AEAD, data []byte) ([]byte, error) {
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("retained payload is too short")
+	}
+
+	nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+
+	payload, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decrypt payload")
+	}
+
+	return payload, nil
+}
+
+// parsePayloadFile parses the id and received time from a payload's file name,
+// which has the form <id>-<received unix nanos>.payload.
+func parsePayloadFile(path string) (*payloadFile, bool) {
+	name := filepath.Base(path)
+
+	if strings.HasPrefix(name, ".") || !strings.HasSuffix(name, payloadExt) {
+		return nil, false
+	}
+
+	parts := strings.SplitN(strings.TrimSuffix(name, payloadExt), "-", 2)
+	if len(parts) != 2 {
+		return nil, false
+	}
+
+	id, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, false
+	}
+
+	nanos, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return nil, false
+	}
+
+	return &payloadFile{
+		path:       path,
+		id:         id,
+		receivedAt: time.Unix(0, nanos),
+	}, true
+}
@@ -0,0 +1,189 @@
+package retention_test
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/retention"
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+)
+
+func newDiskStore(t *testing.T, password secret.Secret) (*retention.DiskStore, string) {
+	dir, err := ioutil.TempDir("", "retention")
+	assert.Nil(t, err)
+
+	store := retention.NewDiskStore(dir, password)
+
+	err = store.Start()
+	assert.Nil(t, err)
+
+	return store, dir
+}
+
+func TestDiskStoreRoundTrip(t *testing.T) {
+	store, dir := newDiskStore(t, "password")
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+
+	for _, p := range []string{"one", "two", "three"} {
+		err := store.SaveRawPayload("abc123", now, []byte(p))
+		assert.Nil(t, err)
+	}
+
+	err := store.SaveRawPayload("def456", now, []byte("other"))
+	assert.Nil(t, err)
+
+	start := now.Add(-time.Minute)
+	end := now.Add(time.Minute)
+
+	count, err := store.CountRawPayloads("abc123", start, end)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, count)
+
+	payloads, err := store.GetRawPayloads("abc123", start, end, 0, 2)
+	assert.Nil(t, err)
+	assert.Len(t, payloads, 2)
+	assert.Equal(t, "one", string(payloads[0].Payload))
+	assert.Equal(t, "two", string(payloads[1].Payload))
+
+	payloads, err = store.GetRawPayloads("abc123", start, end, payloads[1].ID, 2)
+	assert.Nil(t, err)
+	assert.Len(t, payloads, 1)
+	assert.Equal(t, "three", string(payloads[0].Payload))
+
+	count, err = store.CountRawPayloads("abc123", end, end.Add(time.Minute))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, count)
+
+	// payloads are encrypted at rest
+	files, err := filepath.Glob(filepath.Join(dir, "abc123", "*"))
+	assert.Nil(t, err)
+	assert.Len(t, files, 3)
+
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f)
+		assert.Nil(t, err)
+		assert.False(t, strings.Contains(string(b), "one"))
+	}
+
+	// ids continue to increase after reopening
+	reopened := retention.NewDiskStore(dir, "password")
+	err = reopened.Start()
+	assert.Nil(t, err)
+
+	err = reopened.SaveRawPayload("abc123", now, []byte("four"))
+	assert.Nil(t, err)
+
+	payloads, err = reopened.GetRawPayloads("abc123", start, end, 0, 10)
+	assert.Nil(t, err)
+	assert.Len(t, payloads, 4)
+	assert.Equal(t, "four", string(payloads[3].Payload))
+}
+
+func TestDiskStorePrune(t *testing.T) {
+	store, dir := newDiskStore(t, "")
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+
+	err := store.SaveRawPayload("abc123", now.Add(-2*time.Hour), []byte("old"))
+	assert.Nil(t, err)
+
+	for _, p := range []string{"aaaa", "bbbb", "cccc"} {
+		err = store.SaveRawPayload("abc123", now, []byte(p))
+		assert.Nil(t, err)
+	}
+
+	deleted, err := store.PruneRawPayloads(now.Add(-time.Hour), 8)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), deleted)
+
+	payloads, err := store.GetRawPayloads("abc123", now.Add(-3*time.Hour), now.Add(time.Minute), 0, 10)
+	assert.Nil(t, err)
+	assert.Len(t, payloads, 2)
+	assert.Equal(t, "bbbb", string(payloads[0].Payload))
+	assert.Equal(t, "cccc", string(payloads[1].Payload))
+}
+
+func TestDiskStoreInvalidToken(t *testing.T) {
+	store, dir := newDiskStore(t, "")
+	defer os.RemoveAll(dir)
+
+	err := store.SaveRawPayload("../foo", time.Now(), []byte("bar"))
+	assert.NotNil(t, err)
+}
+
+func TestDiskStoreEncryptionKey(t *testing.T) {
+	store, dir := newDiskStore(t, "password")
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+
+	err := store.SaveRawPayload("abc123", now, []byte("placeholder"))
+	assert.Nil(t, err)
+
+	// rewrite the directory as it was left before keys were derived by scrypt,
+	// with no salt and payloads encrypted with the hash of the password
+	err = os.Remove(filepath.Join(dir, ".key_salt"))
+	assert.Nil(t, err)
+
+	key := sha256.Sum256([]byte("password"))
+	block, err := aes.NewCipher(key[:])
+	assert.Nil(t, err)
+	aead, err := cipher.NewGCM(block)
+	assert.Nil(t, err)
+
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	assert.Nil(t, err)
+	legacy := aead.Seal(nonce, nonce, []byte("legacy"), nil)
+
+	paths := []string{}
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if strings.HasSuffix(path, ".payload") {
+			paths = append(paths, path)
+		}
+		return err
+	})
+	assert.Nil(t, err)
+	assert.Len(t, paths, 1)
+
+	err = ioutil.WriteFile(paths[0], legacy, 0600)
+	assert.Nil(t, err)
+
+	store = retention.NewDiskStore(dir, "password")
+	err = store.Start()
+	assert.Nil(t, err)
+
+	salt, err := ioutil.ReadFile(filepath.Join(dir, ".key_salt"))
+	assert.Nil(t, err)
+	assert.Len(t, salt, 16)
+
+	data, err := ioutil.ReadFile(paths[0])
+	assert.Nil(t, err)
+	assert.NotEqual(t, legacy, data)
+
+	payloads, err := store.GetRawPayloads("abc123", now.Add(-time.Minute), now.Add(time.Minute), 0, 10)
+	assert.Nil(t, err)
+	assert.Len(t, payloads, 1)
+	assert.Equal(t, "legacy", string(payloads[0].Payload))
+
+	// a different password cannot read payloads encrypted with the derived key
+	store = retention.NewDiskStore(dir, "other")
+	err = store.Start()
+	assert.Nil(t, err)
+
+	_, err = store.GetRawPayloads("abc123", now.Add(-time.Minute), now.Add(time.Minute), 0, 10)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,169 @@
+package retention
+
+import (
+	"sync"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/logger/level"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/system"
+)
+
+const (
+	// Postgres is the name of the backend that retains payloads in Postgres.
+	Postgres = "postgres"
+
+	// Disk is the name of the backend that retains payloads as files within a
+	// local directory.
+	Disk = "disk"
+)
+
+// Store is the interface implemented by a retention backend. Retained payloads
+// are identified by an increasing integer id, which is used to page through
+// payloads in the order in which they were received. It is satisfied by our
+// postgres.DB type and by DiskStore.
+type Store interface {
+	// SaveRawPayload retains a raw payload received from a device.
+	SaveRawPayload(deviceToken string, receivedAt time.Time, payload []byte) error
+
+	// CountRawPayloads returns the number of payloads retained for a device
+	// within the given time interval.
+	CountRawPayloads(deviceToken string, start, end time.Time) (int, error)
+
+	// GetRawPayloads returns up to limit payloads retained for a device within
+	// the given time interval whose id is greater than afterID.
+	GetRawPayloads(deviceToken string, start, end time.Time, afterID int64, limit int) ([]*postgres.RawPayload, error)
+
+	// PruneRawPayloads deletes payloads received before the given time, and
+	// then the oldest payloads until the total retained size is within maxSize
+	// bytes (if positive). Returns the number of payloads deleted.
+	PruneRawPayloads(before time.Time, maxSize int64) (int64, error)
+}
+
+// Config is used to pass in configuration when creating a Janitor.
+type Config struct {
+	// Store is the retention backend to be pruned.
+	Store Store
+
+	// TTL is the duration for which payloads are retained.
+	TTL time.Duration
+
+	// MaxSize is an optional cap on the total size in bytes of retained
+	// payloads. If zero, no cap is applied.
+	MaxSize int64
+
+	// Interval is the interval at which the janitor prunes the store.
+	Interval time.Duration
+
+	// Clock is used to determine which payloads have expired.
+	Clock clock.Clock
+}
+
+// Janitor is a component that periodically prunes expired payloads from a
+// retention Store, and enforces the configured size cap.
+type Janitor struct {
+	store    Store
+	ttl      time.Duration
+	maxSize  int64
+	interval time.Duration
+	clock    clock.Clock
+	logger   kitlog.Logger
+	quit     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewJanitor returns a new Janitor configured with the given Config.
+func NewJanitor(config *Config, logger kitlog.Logger) *Janitor {
+	logger = kitlog.With(logger, "module", "retention")
+
+	return &Janitor{
+		store:    config.Store,
+		ttl:      config.TTL,
+		maxSize:  config.MaxSize,
+		interval: config.Interval,
+		clock:    config.Clock,
+		logger:   logger,
+	}
+}
+
+// Start starts the underlying store if it requires starting, and then starts a
+// goroutine which prunes the store on each tick of our interval.
+func (j *Janitor) Start() error {
+	j.logger.Log("msg", "starting retention janitor", "ttl", j.ttl, "maxSize", j.maxSize, "interval", j.interval)
+
+	if j.ttl <= 0 {
+		return errors.New("retention ttl must be positive")
+	}
+
+	if j.interval <= 0 {
+		return errors.New("retention prune interval must be positive")
+	}
+
+	if s, ok := j.store.(system.Startable); ok {
+		err := s.Start()
+		if err != nil {
+			return errors.Wrap(err, "failed to start retention store")
+		}
+	}
+
+	j.quit = make(chan struct{})
+	j.wg.Add(1)
+
+	go j.loop()
+
+	return nil
+}
+
+// Stop stops the prune goroutine.
+func (j *Janitor) Stop() error {
+	if j.quit == nil {
+		return nil
+	}
+
+	j.logger.Log("msg", "stopping retention janitor")
+
+	close(j.quit)
+	j.wg.Wait()
+
+	return nil
+}
+
+// Prune deletes all expired payloads from the store, and enforces the size
+// cap.
+func (j *Janitor) Prune() error {
+	deleted, err := j.store.PruneRawPayloads(j.clock.Now().Add(-j.ttl), j.maxSize)
+	if err != nil {
+		return errors.Wrap(err, "failed to prune retained payloads")
+	}
+
+	if deleted > 0 {
+		j.logger.Log("msg", "pruned retained payloads", "deleted", deleted)
+	}
+
+	return nil
+}
+
+// loop is run in a goroutine and prunes the store on each tick of our interval
+// until the janitor is stopped.
+func (j *Janitor) loop() {
+	defer j.wg.Done()
+
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := j.Prune()
+			if err != nil {
+				level.Error(j.logger).Log("msg", "failed to prune retained payloads", "err", err)
+			}
+		case <-j.quit:
+			return
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package retention_test
+
+import (
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/retention"
+)
+
+type store struct {
+	before  time.Time
+	maxSize int64
+}
+
+func (s *store) SaveRawPayload(deviceToken string, receivedAt time.Time, payload []byte) error {
+	return nil
+}
+
+func (s *store) CountRawPayloads(deviceToken string, start, end time.Time) (int, error) {
+	return 0, nil
+}
+
+func (s *store) GetRawPayloads(deviceToken string, start, end time.Time, afterID int64, limit int) ([]*postgres.RawPayload, error) {
+	return nil, nil
+}
+
+func (s *store) PruneRawPayloads(before time.Time, maxSize int64) (int64, error) {
+	s.before = before
+	s.maxSize = maxSize
+	return 0, nil
+}
+
+func TestJanitorPrune(t *testing.T) {
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	s := &store{}
+
+	janitor := retention.NewJanitor(&retention.Config{
+		Store:    s,
+		TTL:      24 * time.Hour,
+		MaxSize:  1024,
+		Interval: time.Minute,
+		Clock:    clock.NewMock(now),
+	}, kitlog.NewNopLogger())
+
+	err := janitor.Prune()
+	assert.Nil(t, err)
+	assert.Equal(t, now.Add(-24*time.Hour), s.before)
+	assert.Equal(t, int64(1024), s.maxSize)
+}
+
+func TestJanitorInvalidConfig(t *testing.T) {
+	janitor := retention.NewJanitor(&retention.Config{
+		Store:    &store{},
+		Interval: time.Minute,
+		Clock:    clock.New(),
+	}, kitlog.NewNopLogger())
+
+	err := janitor.Start()
+	assert.NotNil(t, err)
+
+	// stopping a janitor that never started is a noop
+	assert.Nil(t, janitor.Stop())
+}
@@ -0,0 +1,48 @@
+package rpc
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/twitchtv/twirp"
+)
+
+// ArchiveHeader is the HTTP header with which a client creating a stream may
+// have its encrypted payloads archived to object storage as well as written to
+// the datastore, by setting it to "true".
+const ArchiveHeader = "Archive"
+
+// WithArchive returns a copy of the context carrying the given archive
+// setting, which is validated by CreateStream and saved with the new stream.
+func WithArchive(ctx context.Context, archive string) context.Context {
+	opts := StreamOptionsFrom(ctx)
+	opts.Archive = archive
+
+	return WithStreamOptions(ctx, opts)
+}
+
+// Archive returns the archive setting carried by the context, or an empty
+// string if none was set.
+func Archive(ctx context.Context) string {
+	return StreamOptionsFrom(ctx).Archive
+}
+
+// parseArchive parses the requested archive setting, returning an error if it
+// is not a boolean, or if archival was requested but we have no archive. An
+// empty setting is false.
+func (e *encoderImpl) parseArchive(s string) (bool, error) {
+	if s == "" {
+		return false, nil
+	}
+
+	archive, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, twirp.InvalidArgumentError("archive", "must be true or false")
+	}
+
+	if archive && !e.archiving {
+		return false, twirp.InvalidArgumentError("archive", "archival is not configured")
+	}
+
+	return archive, nil
+}
@@ -0,0 +1,23 @@
+package rpc
+
+import "context"
+
+// CompressionHeader is the HTTP header with which a client creating a stream
+// may request that the stream's payloads are compressed before encryption,
+// naming the content encoding to use (gzip or zstd).
+const CompressionHeader = "Compression"
+
+// WithCompression returns a copy of the context carrying the given content
+// encoding, which is used by CreateStream to compress the stream's payloads.
+func WithCompression(ctx context.Context, encoding string) context.Context {
+	opts := StreamOptionsFrom(ctx)
+	opts.Compression = encoding
+
+	return WithStreamOptions(ctx, opts)
+}
+
+// Compression returns the content encoding carried by the context, or an empty
+// string if none was set.
+func Compression(ctx context.Context) string {
+	return StreamOptionsFrom(ctx).Compression
+}
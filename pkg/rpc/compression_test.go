@@ -0,0 +1,52 @@
+package rpc_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/compress"
+	"github.com/DECODEproject/iotencoder/pkg/mqtt/mqtttest"
+	"github.com/DECODEproject/iotencoder/pkg/postgres/postgrestest"
+	"github.com/DECODEproject/iotencoder/pkg/rpc"
+)
+
+func TestStreamCompression(t *testing.T) {
+	db := postgrestest.NewDB()
+
+	enc := rpc.NewEncoder(&rpc.Config{
+		DB:         db,
+		MQTTClient: mqtttest.NewClient(),
+		Processor:  &recordingProcessor{processed: make(map[string][][]byte)},
+	}, kitlog.NewNopLogger())
+
+	resp, err := enc.CreateStream(rpc.WithCompression(context.Background(), compress.Zstd), newStreamRequest("community-1"))
+	assert.Nil(t, err)
+
+	stream, err := db.GetStream(resp.StreamUid, resp.Token)
+	assert.Nil(t, err)
+	assert.Equal(t, compress.Zstd, stream.Compression)
+
+	_, err = enc.CreateStream(rpc.WithCompression(context.Background(), "brotli"), newStreamRequest("community-2"))
+	assert.NotNil(t, err)
+	assert.Equal(t, "twirp error invalid_argument: compression must be gzip or zstd", err.Error())
+}
+
+func TestCompressionHeader(t *testing.T) {
+	var encoding string
+
+	h := rpc.StreamOptionsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding = rpc.Compression(r.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Set(rpc.CompressionHeader, compress.Gzip)
+
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(t, compress.Gzip, encoding)
+}
@@ -0,0 +1,91 @@
+package rpc
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/twitchtv/twirp"
+
+	"github.com/DECODEproject/iotencoder/pkg/pipeline"
+)
+
+// DatastoreAddrHeader is the HTTP header with which a client creating a stream
+// may specify the address of the datastore to which the stream's data should
+// be written.
+const DatastoreAddrHeader = "Datastore-Addr"
+
+// DatastoreTimeoutHeader is the HTTP header with which a client creating a
+// stream may override the deadline applied to each write of the stream's data
+// to its datastore, given as a duration, e.g. "2s".
+const DatastoreTimeoutHeader = "Datastore-Timeout"
+
+// DatastoreChecker is the interface we call to verify that a per-stream
+// datastore is allowed and can be reached before the stream is created,
+// returning pipeline.ErrDatastoreNotAllowed for a datastore on a host which is
+// not allowed. It is satisfied by the pipeline.DatastorePool type.
+type DatastoreChecker interface {
+	Check(ctx context.Context, addr string) error
+}
+
+// WithDatastoreAddr returns a copy of the context carrying the given datastore
+// address, which is used by CreateStream in place of the default datastore.
+func WithDatastoreAddr(ctx context.Context, addr string) context.Context {
+	opts := StreamOptionsFrom(ctx)
+	opts.DatastoreAddr = addr
+
+	return WithStreamOptions(ctx, opts)
+}
+
+// DatastoreAddr returns the datastore address carried by the context, or an
+// empty string if none was set.
+func DatastoreAddr(ctx context.Context) string {
+	return StreamOptionsFrom(ctx).DatastoreAddr
+}
+
+// WithDatastoreTimeout returns a copy of the context carrying the given
+// datastore write timeout, which is used by CreateStream in place of the
+// encoder's default.
+func WithDatastoreTimeout(ctx context.Context, timeout string) context.Context {
+	opts := StreamOptionsFrom(ctx)
+	opts.DatastoreTimeout = timeout
+
+	return WithStreamOptions(ctx, opts)
+}
+
+// DatastoreTimeout returns the datastore write timeout carried by the context,
+// or an empty string if none was set.
+func DatastoreTimeout(ctx context.Context) string {
+	return StreamOptionsFrom(ctx).DatastoreTimeout
+}
+
+// validateDatastoreAddr returns a twirp error if the given datastore address is
+// not an absolute http or https URL. An empty address is valid and means the
+// default datastore is used.
+func validateDatastoreAddr(addr string) error {
+	if addr == "" {
+		return nil
+	}
+
+	u, err := url.Parse(addr)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return twirp.InvalidArgumentError("datastore_addr", "must be an http or https URL")
+	}
+
+	return nil
+}
+
+// validateDatastoreTimeout returns a twirp error if the given datastore write
+// timeout is not a positive duration. An empty timeout is valid and means the
+// encoder's default is used.
+func validateDatastoreTimeout(timeout string) error {
+	if timeout == "" {
+		return nil
+	}
+
+	_, err := pipeline.ParseDatastoreTimeout(timeout)
+	if err != nil {
+		return twirp.InvalidArgumentError("datastore_timeout", "must be a positive duration")
+	}
+
+	return nil
+}
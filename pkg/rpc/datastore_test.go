@@ -0,0 +1,60 @@
+package rpc_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/mqtt/mqtttest"
+	"github.com/DECODEproject/iotencoder/pkg/postgres/postgrestest"
+	"github.com/DECODEproject/iotencoder/pkg/rpc"
+)
+
+func TestDatastoreAddrHeader(t *testing.T) {
+	var addr string
+
+	handler := rpc.StreamOptionsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addr = rpc.DatastoreAddr(r.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/twirp/decode.iot.encoder.Encoder/CreateStream", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, "", addr)
+
+	req.Header.Set(rpc.DatastoreAddrHeader, "http://datastore.pilot:8080")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, "http://datastore.pilot:8080", addr)
+}
+
+func TestDatastoreTimeout(t *testing.T) {
+	db := postgrestest.NewDB()
+
+	enc := rpc.NewEncoder(&rpc.Config{
+		DB:         db,
+		MQTTClient: mqtttest.NewClient(),
+		Processor:  &recordingProcessor{processed: make(map[string][][]byte)},
+	}, kitlog.NewNopLogger())
+
+	resp, err := enc.CreateStream(rpc.WithDatastoreTimeout(context.Background(), "10s"), newStreamRequest("community-1"))
+	assert.Nil(t, err)
+
+	stream, err := db.GetStream(resp.StreamUid, resp.Token)
+	assert.Nil(t, err)
+	assert.Equal(t, "10s", stream.DatastoreTimeout)
+
+	_, err = enc.CreateStream(rpc.WithDatastoreTimeout(context.Background(), "-1s"), newStreamRequest("community-2"))
+	assert.NotNil(t, err)
+	assert.Equal(t, "twirp error invalid_argument: datastore_timeout must be a positive duration", err.Error())
+
+	handler := rpc.StreamOptionsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "10s", rpc.DatastoreTimeout(r.Context()))
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/twirp/decode.iot.encoder.Encoder/CreateStream", nil)
+	req.Header.Set(rpc.DatastoreTimeoutHeader, "10s")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+}
@@ -0,0 +1,114 @@
+package rpc
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/twitchtv/twirp"
+
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+)
+
+// DeleteDataHeader is the HTTP header with which a client deleting a stream may
+// also ask the stream's datastore to delete the data written for its device
+// within its community, by setting it to "true".
+const DeleteDataHeader = "Delete-Data"
+
+// DataDeletionHeader is the HTTP response header with which DeleteStream
+// reports the result of a requested data deletion, either "deleted" or
+// "failed".
+const DataDeletionHeader = "Data-Deletion"
+
+// DataDeletedHeader is the HTTP response header with which DeleteStream reports
+// the number of events the datastore deleted.
+const DataDeletedHeader = "Data-Deleted"
+
+// DataDeletionErrorHeader is the HTTP response header with which DeleteStream
+// reports why a requested data deletion failed.
+const DataDeletionErrorHeader = "Data-Deletion-Error"
+
+// DataDeleter is the interface we call to ask a datastore to delete the data
+// written for a device within a community. It is satisfied by the
+// erasure.Client type.
+type DataDeleter interface {
+	DeleteData(ctx context.Context, addr, communityID string, deviceToken secret.Secret) (int64, error)
+}
+
+// WithDeleteData returns a copy of the context carrying the given data deletion
+// setting, which is validated by DeleteStream.
+func WithDeleteData(ctx context.Context, deleteData string) context.Context {
+	opts := StreamOptionsFrom(ctx)
+	opts.DeleteData = deleteData
+
+	return WithStreamOptions(ctx, opts)
+}
+
+// DeleteData returns the data deletion setting carried by the context, or an
+// empty string if none was set.
+func DeleteData(ctx context.Context) string {
+	return StreamOptionsFrom(ctx).DeleteData
+}
+
+// parseDeleteData parses the requested data deletion setting, returning an
+// error if it is not a boolean, or if deletion was requested but we are unable
+// to ask datastores to delete data. An empty setting is false.
+func (e *encoderImpl) parseDeleteData(s string) (bool, error) {
+	if s == "" {
+		return false, nil
+	}
+
+	deleteData, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, twirp.InvalidArgumentError("delete_data", "must be true or false")
+	}
+
+	if deleteData && e.dataDeleter == nil {
+		return false, twirp.InvalidArgumentError("delete_data", "datastore deletion is not configured")
+	}
+
+	return deleteData, nil
+}
+
+// deleteData asks the datastore of the given deleted stream to delete the data
+// written for its device within its community, reporting the result in our
+// response headers and recording it in the audit log. The stream has already
+// been deleted, so a failure is reported rather than returned, and deletion may
+// be requested again by restoring and deleting the stream once more.
+func (e *encoderImpl) deleteData(ctx context.Context, stream *postgres.Stream) {
+	deleted, err := e.dataDeleter.DeleteData(ctx, stream.DatastoreAddr, stream.CommunityID, stream.Device.DeviceToken)
+
+	e.audit(ctx, "DeleteStreamData", stream.StreamID, &deleteDataParams{
+		CommunityID:   stream.CommunityID,
+		DatastoreAddr: stream.DatastoreAddr,
+		Deleted:       deleted,
+	}, err)
+
+	if err != nil {
+		twirp.SetHTTPResponseHeader(ctx, DataDeletionHeader, "failed")
+		twirp.SetHTTPResponseHeader(ctx, DataDeletionErrorHeader, errorMessage(err))
+		return
+	}
+
+	twirp.SetHTTPResponseHeader(ctx, DataDeletionHeader, "deleted")
+	twirp.SetHTTPResponseHeader(ctx, DataDeletedHeader, strconv.FormatInt(deleted, 10))
+}
+
+// deleteDataParams are the parameters recorded in the audit log for a data
+// deletion. The device is identified by the stream, so its token isn't
+// recorded.
+type deleteDataParams struct {
+	CommunityID   string `json:"community_id"`
+	DatastoreAddr string `json:"datastore_addr,omitempty"`
+	Deleted       int64  `json:"deleted"`
+}
+
+// errorMessage returns the message of a twirp error, or the error's string for
+// any other error.
+func errorMessage(err error) string {
+	if twerr, ok := err.(twirp.Error); ok {
+		return twerr.Msg()
+	}
+
+	return err.Error()
+}
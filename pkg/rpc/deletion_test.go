@@ -0,0 +1,155 @@
+package rpc_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	encoder "github.com/thingful/twirp-encoder-go"
+	"github.com/twitchtv/twirp"
+
+	"github.com/DECODEproject/iotencoder/pkg/audit"
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/mqtt/mqtttest"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/postgres/postgrestest"
+	"github.com/DECODEproject/iotencoder/pkg/rpc"
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+)
+
+// fakeDataDeleter records the devices whose data it is asked to delete,
+// failing with err if set.
+type fakeDataDeleter struct {
+	requests []string
+	err      error
+}
+
+func (f *fakeDataDeleter) DeleteData(ctx context.Context, addr, communityID string, deviceToken secret.Secret) (int64, error) {
+	f.requests = append(f.requests, communityID+"/"+deviceToken.Reveal())
+	if f.err != nil {
+		return 0, f.err
+	}
+
+	return 42, nil
+}
+
+func TestDeleteStreamWithData(t *testing.T) {
+	testcases := []struct {
+		label  string
+		err    error
+		result string
+	}{
+		{
+			label:  "deleted",
+			result: audit.ResultOK,
+		},
+		{
+			label:  "failed",
+			err:    twirp.NewError(twirp.Unimplemented, "datastore does not support deletion"),
+			result: "unimplemented",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			db := postgrestest.NewDB()
+			deleter := &fakeDataDeleter{err: tc.err}
+
+			enc := rpc.NewEncoder(&rpc.Config{
+				DB:          db,
+				MQTTClient:  mqtttest.NewClient(),
+				Processor:   &recordingProcessor{},
+				DataDeleter: deleter,
+				Auditor: audit.NewRecorder(&audit.Config{
+					Store: db,
+					Clock: clock.New(),
+				}, kitlog.NewNopLogger()),
+			}, kitlog.NewNopLogger())
+
+			resp, err := enc.CreateStream(context.Background(), &encoder.CreateStreamRequest{
+				DeviceToken:        "abc123",
+				DeviceLabel:        "my sensor",
+				RecipientPublicKey: publicKey,
+				CommunityId:        "smartcitizen",
+				Location: &encoder.CreateStreamRequest_Location{
+					Longitude: -0.024,
+					Latitude:  54.24,
+				},
+			})
+			assert.Nil(t, err)
+
+			// the stream is deleted whether or not its data could be
+			_, err = enc.DeleteStream(rpc.WithDeleteData(context.Background(), "true"), &encoder.DeleteStreamRequest{
+				StreamUid: resp.StreamUid,
+				Token:     resp.Token,
+			})
+			assert.Nil(t, err)
+
+			assert.Equal(t, []string{"smartcitizen/abc123"}, deleter.requests)
+
+			entries, err := db.GetAuditEntries(resp.StreamUid, time.Time{}, time.Now().Add(time.Minute), 0, 10)
+			assert.Nil(t, err)
+
+			var entry *postgres.AuditEntry
+			for _, e := range entries {
+				if e.Method == "DeleteStreamData" {
+					entry = e
+				}
+			}
+
+			if assert.NotNil(t, entry) {
+				assert.Equal(t, tc.result, entry.Result)
+				assert.Equal(t, "smartcitizen", entry.Params["community_id"])
+				assert.NotContains(t, entry.Params, "device_token")
+			}
+		})
+	}
+}
+
+func TestDeleteStreamWithDataInvalid(t *testing.T) {
+	enc := rpc.NewEncoder(&rpc.Config{
+		DB:         postgrestest.NewDB(),
+		MQTTClient: mqtttest.NewClient(),
+		Processor:  &recordingProcessor{},
+	}, kitlog.NewNopLogger())
+
+	resp, err := enc.CreateStream(context.Background(), &encoder.CreateStreamRequest{
+		DeviceToken:        "abc123",
+		DeviceLabel:        "my sensor",
+		RecipientPublicKey: publicKey,
+		CommunityId:        "smartcitizen",
+		Location: &encoder.CreateStreamRequest_Location{
+			Longitude: -0.024,
+			Latitude:  54.24,
+		},
+	})
+	assert.Nil(t, err)
+
+	req := &encoder.DeleteStreamRequest{
+		StreamUid: resp.StreamUid,
+		Token:     resp.Token,
+	}
+
+	_, err = enc.DeleteStream(rpc.WithDeleteData(context.Background(), "maybe"), req)
+	assert.NotNil(t, err)
+
+	// without a deleter data can't be deleted, so the stream is kept
+	_, err = enc.DeleteStream(rpc.WithDeleteData(context.Background(), "true"), req)
+	assert.NotNil(t, err)
+
+	// an unknown stream is not found rather than deleted with its data
+	enc = rpc.NewEncoder(&rpc.Config{
+		DB:          postgrestest.NewDB(),
+		MQTTClient:  mqtttest.NewClient(),
+		Processor:   &recordingProcessor{},
+		DataDeleter: &fakeDataDeleter{err: errors.New("unexpected")},
+	}, kitlog.NewNopLogger())
+
+	_, err = enc.DeleteStream(rpc.WithDeleteData(context.Background(), "true"), req)
+	if assert.NotNil(t, err) {
+		assert.Equal(t, twirp.NotFound, err.(twirp.Error).Code())
+	}
+}
@@ -0,0 +1,202 @@
+package rpc
+
+import (
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// workerQueueSize is the number of messages which may wait for each worker
+// before dispatching further messages to it blocks.
+const workerQueueSize = 64
+
+var (
+	// errDispatcherStopped is returned when dispatching a message after the
+	// dispatcher has been stopped.
+	errDispatcherStopped = errors.New("dispatcher stopped")
+
+	// errBacklogFull is returned when dispatching a message for a device which
+	// already has as many messages waiting as the dispatcher's limit allows.
+	errBacklogFull = errors.New("device backlog full")
+)
+
+// QueueDepthGauge is a prometheus gauge recording the number of messages
+// waiting in the queues of our workers.
+var QueueDepthGauge = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Namespace: "decode",
+		Subsystem: "encoder",
+		Name:      "worker_queue_depth",
+		Help:      "Number of messages waiting to be processed by workers",
+	},
+)
+
+// dispatcher processes incoming messages on a fixed number of workers. Every
+// message for a device is dispatched to the same worker, chosen by hashing the
+// device token, and each worker processes its messages one at a time in the
+// order they were dispatched. This preserves the order in which messages from
+// a device were received, on which moving averages rely, while messages from
+// different devices are processed concurrently. If limit is positive no more
+// than limit messages from a device may wait to be processed, so that a single
+// noisy device can't fill the queue of a worker it shares with others.
+type dispatcher struct {
+	queues []chan func()
+	limit  int
+	wg     sync.WaitGroup
+
+	// waiting is the number of messages waiting in our queues, read
+	// atomically
+	waiting int64
+
+	sync.RWMutex
+	stopped bool
+
+	// backlogs holds the backlog of each device with messages waiting, and
+	// is only used if limit is positive
+	backlogMu sync.Mutex
+	backlogs  map[string]*backlog
+}
+
+// backlog is the number of messages from a device waiting to be processed, and
+// the number dropped since one of its messages was last processed.
+type backlog struct {
+	waiting int
+	dropped int
+}
+
+// newDispatcher returns a new dispatcher with the given number of workers, each
+// of which is started immediately, limiting the messages waiting for each
+// device to limit if positive.
+func newDispatcher(workers, limit int) *dispatcher {
+	d := &dispatcher{
+		queues:   make([]chan func(), workers),
+		limit:    limit,
+		backlogs: make(map[string]*backlog),
+	}
+
+	for i := range d.queues {
+		d.queues[i] = make(chan func(), workerQueueSize)
+
+		d.wg.Add(1)
+		go d.work(d.queues[i])
+	}
+
+	return d
+}
+
+// dispatch queues fn to be called by the worker for the given key, blocking
+// while that worker's queue is full. fn is passed the number of messages for
+// the key dropped since the last was processed. Returns errDispatcherStopped
+// without queueing fn if the dispatcher has been stopped, and errBacklogFull if
+// the key already has as many messages waiting as our limit allows.
+func (d *dispatcher) dispatch(key string, fn func(dropped int)) error {
+	d.RLock()
+	defer d.RUnlock()
+
+	if d.stopped {
+		return errDispatcherStopped
+	}
+
+	if !d.reserve(key) {
+		return errBacklogFull
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(key))
+
+	atomic.AddInt64(&d.waiting, 1)
+	QueueDepthGauge.Inc()
+	d.queues[h.Sum32()%uint32(len(d.queues))] <- func() {
+		fn(d.release(key))
+	}
+
+	return nil
+}
+
+// reserve records that a message for the given key is waiting, returning false
+// and counting the message as dropped if the key already has as many messages
+// waiting as our limit allows.
+func (d *dispatcher) reserve(key string) bool {
+	if d.limit <= 0 {
+		return true
+	}
+
+	d.backlogMu.Lock()
+	defer d.backlogMu.Unlock()
+
+	b, ok := d.backlogs[key]
+	if !ok {
+		b = &backlog{}
+		d.backlogs[key] = b
+	}
+
+	if b.waiting >= d.limit {
+		b.dropped++
+		return false
+	}
+
+	b.waiting++
+
+	return true
+}
+
+// release records that a message for the given key is being processed, and
+// returns the number of messages for the key dropped since the last was
+// processed. Messages are only dropped while others are waiting, so every
+// drop is returned by the release of a later message.
+func (d *dispatcher) release(key string) int {
+	if d.limit <= 0 {
+		return 0
+	}
+
+	d.backlogMu.Lock()
+	defer d.backlogMu.Unlock()
+
+	b := d.backlogs[key]
+
+	dropped := b.dropped
+	b.dropped = 0
+	b.waiting--
+
+	if b.waiting == 0 {
+		delete(d.backlogs, key)
+	}
+
+	return dropped
+}
+
+// depth returns the number of messages waiting in the queues of our workers.
+func (d *dispatcher) depth() int {
+	return int(atomic.LoadInt64(&d.waiting))
+}
+
+// stop prevents any further messages being dispatched, and waits for the
+// workers to finish processing those already queued.
+func (d *dispatcher) stop() {
+	d.Lock()
+	if !d.stopped {
+		d.stopped = true
+
+		for _, q := range d.queues {
+			close(q)
+		}
+	}
+	d.Unlock()
+
+	d.wg.Wait()
+}
+
+// work calls each function received on the given queue in turn until the
+// queue is closed.
+func (d *dispatcher) work(queue chan func()) {
+	defer d.wg.Done()
+
+	for fn := range queue {
+		atomic.AddInt64(&d.waiting, -1)
+		QueueDepthGauge.Dec()
+		fn()
+	}
+}
@@ -0,0 +1,195 @@
+package rpc_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/postgres/postgrestest"
+	"github.com/DECODEproject/iotencoder/pkg/rpc"
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+	"github.com/DECODEproject/iotencoder/pkg/system"
+)
+
+func TestWorkersPreserveDeviceOrder(t *testing.T) {
+	db := postgrestest.NewDB()
+	source := &fakeSource{callbacks: map[string]func([]byte, map[string]string, func()){}}
+	processor := &recordingProcessor{processed: make(map[string][][]byte)}
+
+	devices := []string{"device-1", "device-2", "device-3", "device-4", "device-5"}
+
+	for _, token := range devices {
+		_, err := db.CreateStream(&postgres.Stream{
+			PublicKey:   "abc123",
+			CommunityID: "policy-id",
+			Source:      "fake",
+			Device: &postgres.Device{
+				DeviceToken: secret.Secret(token),
+				Longitude:   23,
+				Latitude:    23.2,
+				Exposure:    "indoor",
+			},
+		})
+		assert.Nil(t, err)
+	}
+
+	enc := rpc.NewEncoder(&rpc.Config{
+		DB:            db,
+		Processor:     processor,
+		Sources:       map[string]rpc.Source{"fake": source},
+		DefaultSource: "fake",
+		Workers:       3,
+	}, kitlog.NewNopLogger())
+
+	err := enc.(system.Startable).Start()
+	assert.Nil(t, err)
+
+	expected := [][]byte{}
+	for i := 0; i < 100; i++ {
+		expected = append(expected, []byte(fmt.Sprintf(`{"id":%d}`, i)))
+	}
+
+	var wg sync.WaitGroup
+
+	for _, token := range devices {
+		wg.Add(1)
+
+		go func(callback func([]byte, map[string]string, func())) {
+			defer wg.Done()
+
+			for _, payload := range expected {
+				callback(payload, nil, func() {})
+			}
+		}(source.callbacks[token])
+	}
+
+	wg.Wait()
+
+	// messages are processed asynchronously, so wait for the workers to catch up
+	deadline := time.Now().Add(5 * time.Second)
+	for processedCount(processor) < len(devices)*len(expected) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	err = enc.(system.Stoppable).Stop()
+	assert.Nil(t, err)
+
+	for _, token := range devices {
+		assert.Equal(t, expected, processor.processed[token])
+	}
+}
+
+// processedCount returns the total number of payloads processed by the given
+// processor.
+func processedCount(processor *recordingProcessor) int {
+	processor.Lock()
+	defer processor.Unlock()
+
+	n := 0
+	for _, payloads := range processor.processed {
+		n += len(payloads)
+	}
+
+	return n
+}
+
+// blockingProcessor records the payloads processed and the messages dropped for
+// each device, blocking every call to Process until release is closed.
+type blockingProcessor struct {
+	recordingProcessor
+	started chan struct{}
+	release chan struct{}
+	dropped map[string]int
+}
+
+func (b *blockingProcessor) Process(ctx context.Context, device *postgres.Device, payload []byte) error {
+	b.started <- struct{}{}
+	<-b.release
+
+	return b.recordingProcessor.Process(ctx, device, payload)
+}
+
+func (b *blockingProcessor) RecordDropped(device *postgres.Device, n int) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.dropped[device.DeviceToken.Reveal()] += n
+}
+
+func TestDeviceQueueLimit(t *testing.T) {
+	db := postgrestest.NewDB()
+	source := &fakeSource{callbacks: map[string]func([]byte, map[string]string, func()){}}
+	processor := &blockingProcessor{
+		recordingProcessor: recordingProcessor{processed: make(map[string][][]byte)},
+		started:            make(chan struct{}, 100),
+		release:            make(chan struct{}),
+		dropped:            make(map[string]int),
+	}
+
+	for _, token := range []string{"noisy", "quiet"} {
+		_, err := db.CreateStream(&postgres.Stream{
+			PublicKey:   "abc123",
+			CommunityID: "policy-id",
+			Source:      "fake",
+			Device: &postgres.Device{
+				DeviceToken: secret.Secret(token),
+				Longitude:   23,
+				Latitude:    23.2,
+				Exposure:    "indoor",
+			},
+		})
+		assert.Nil(t, err)
+	}
+
+	enc := rpc.NewEncoder(&rpc.Config{
+		DB:               db,
+		Processor:        processor,
+		Sources:          map[string]rpc.Source{"fake": source},
+		DefaultSource:    "fake",
+		Workers:          1,
+		DeviceQueueLimit: 2,
+	}, kitlog.NewNopLogger())
+
+	err := enc.(system.Startable).Start()
+	assert.Nil(t, err)
+
+	acked := 0
+	done := func() { acked++ }
+
+	// the first message is being processed, so no messages are waiting
+	source.callbacks["noisy"]([]byte(`{"id":0}`), nil, done)
+	<-processor.started
+
+	// two further messages may wait, while the rest are dropped and
+	// acknowledged immediately
+	for i := 1; i < 6; i++ {
+		source.callbacks["noisy"]([]byte(fmt.Sprintf(`{"id":%d}`, i)), nil, done)
+	}
+
+	assert.Equal(t, 3, acked)
+
+	// another device sharing the worker may still queue its messages
+	source.callbacks["quiet"]([]byte(`{"id":0}`), nil, done)
+
+	close(processor.release)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for processedCount(&processor.recordingProcessor) < 4 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	err = enc.(system.Stoppable).Stop()
+	assert.Nil(t, err)
+
+	assert.Equal(t, [][]byte{[]byte(`{"id":0}`), []byte(`{"id":1}`), []byte(`{"id":2}`)}, processor.processed["noisy"])
+	assert.Equal(t, [][]byte{[]byte(`{"id":0}`)}, processor.processed["quiet"])
+
+	// the drops are recorded for the device once its next message is handled
+	assert.Equal(t, map[string]int{"noisy": 3}, processor.dropped)
+}
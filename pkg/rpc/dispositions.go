@@ -0,0 +1,47 @@
+package rpc
+
+import (
+	"context"
+
+	"github.com/twitchtv/twirp"
+
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+)
+
+// DispositionsHeader is the HTTP header with which a client creating a stream
+// may choose what happens to the readings of individual sensors, as a comma
+// separated list of SENSOR_ID=DISPOSITION pairs where the disposition is one
+// of encrypt, drop or plain. Sensors not listed are encrypted.
+const DispositionsHeader = "Channel-Dispositions"
+
+// WithDispositions returns a copy of the context carrying the given
+// dispositions, which are parsed by CreateStream and saved with the new
+// stream.
+func WithDispositions(ctx context.Context, dispositions string) context.Context {
+	opts := StreamOptionsFrom(ctx)
+	opts.Dispositions = dispositions
+
+	return WithStreamOptions(ctx, opts)
+}
+
+// Dispositions returns the dispositions carried by the context, or an empty
+// string if none were set.
+func Dispositions(ctx context.Context) string {
+	return StreamOptionsFrom(ctx).Dispositions
+}
+
+// validateDispositions returns an error if the dispositions may not be applied
+// to a stream with the given operations, or if readings would be written in
+// plain without plaintext channels having been enabled.
+func (e *encoderImpl) validateDispositions(dispositions postgres.Dispositions, operations postgres.Operations) error {
+	err := dispositions.Validate(operations)
+	if err != nil {
+		return twirp.InvalidArgumentError("channel_dispositions", err.Error())
+	}
+
+	if dispositions.HasPlain() && !e.allowPlaintext {
+		return twirp.InvalidArgumentError("channel_dispositions", "plaintext channels are not enabled")
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package rpc_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	encoder "github.com/thingful/twirp-encoder-go"
+
+	"github.com/DECODEproject/iotencoder/pkg/mqtt/mqtttest"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/postgres/postgrestest"
+	"github.com/DECODEproject/iotencoder/pkg/rpc"
+)
+
+func TestStreamDispositions(t *testing.T) {
+	db := postgrestest.NewDB()
+
+	enc := rpc.NewEncoder(&rpc.Config{
+		DB:             db,
+		MQTTClient:     mqtttest.NewClient(),
+		Processor:      &recordingProcessor{processed: make(map[string][][]byte)},
+		AllowPlaintext: true,
+	}, kitlog.NewNopLogger())
+
+	resp, err := enc.CreateStream(rpc.WithDispositions(context.Background(), "12=plain,14=drop"), newStreamRequest("community-1"))
+	assert.Nil(t, err)
+
+	stream, err := db.GetStream(resp.StreamUid, resp.Token)
+	assert.Nil(t, err)
+	assert.Equal(t, postgres.Dispositions{12: postgres.Plain, 14: postgres.Drop}, stream.Dispositions)
+
+	testcases := []struct {
+		label         string
+		dispositions  string
+		operations    []*encoder.CreateStreamRequest_Operation
+		expectedError string
+	}{
+		{
+			label:         "unknown disposition",
+			dispositions:  "12=hash",
+			expectedError: `twirp error invalid_argument: channel_dispositions unknown disposition "hash" for sensor 12, must be encrypt, drop or plain`,
+		},
+		{
+			label:        "plain sensor processed",
+			dispositions: "12=plain",
+			operations: []*encoder.CreateStreamRequest_Operation{
+				{
+					SensorId: 12,
+					Action:   encoder.CreateStreamRequest_Operation_BIN,
+					Bins:     []float64{40, 80},
+				},
+			},
+			expectedError: "twirp error invalid_argument: channel_dispositions sensor 12 must be shared without processing to be written in plain",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			req := newStreamRequest("community-2")
+			req.Operations = tc.operations
+
+			_, err := enc.CreateStream(rpc.WithDispositions(context.Background(), tc.dispositions), req)
+			assert.NotNil(t, err)
+			assert.Equal(t, tc.expectedError, err.Error())
+		})
+	}
+}
+
+func TestStreamDispositionsPlaintextNotEnabled(t *testing.T) {
+	enc := rpc.NewEncoder(&rpc.Config{
+		DB:         postgrestest.NewDB(),
+		MQTTClient: mqtttest.NewClient(),
+		Processor:  &recordingProcessor{processed: make(map[string][][]byte)},
+	}, kitlog.NewNopLogger())
+
+	_, err := enc.CreateStream(rpc.WithDispositions(context.Background(), "12=plain"), newStreamRequest("community-1"))
+	assert.NotNil(t, err)
+	assert.Equal(t, "twirp error invalid_argument: channel_dispositions plaintext channels are not enabled", err.Error())
+
+	// dropping readings needs no opt in
+	_, err = enc.CreateStream(rpc.WithDispositions(context.Background(), "12=drop"), newStreamRequest("community-1"))
+	assert.Nil(t, err)
+}
+
+func TestDispositionsHeader(t *testing.T) {
+	var dispositions string
+
+	h := rpc.StreamOptionsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dispositions = rpc.Dispositions(r.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Set(rpc.DispositionsHeader, "12=plain")
+
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(t, "12=plain", dispositions)
+}
@@ -1,48 +1,231 @@
+// Package rpc implements the encoder's twirp API. Its request and response
+// types are generated from the DECODE encoder protocol, which is maintained
+// outside this repository, so the options a client may set when creating or
+// deleting a stream beyond those of the protocol are sent in HTTP headers
+// alongside the request. StreamOptionsMiddleware parses them into the request
+// context, from which the handlers read them.
 package rpc
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
-	"regexp"
 	"strings"
+	"sync"
+	"time"
 
 	raven "github.com/getsentry/raven-go"
 	kitlog "github.com/go-kit/kit/log"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
 	encoder "github.com/thingful/twirp-encoder-go"
 	"github.com/twitchtv/twirp"
 
+	"github.com/DECODEproject/iotencoder/pkg/compress"
+	"github.com/DECODEproject/iotencoder/pkg/dedup"
+	"github.com/DECODEproject/iotencoder/pkg/events"
+	"github.com/DECODEproject/iotencoder/pkg/labels"
+	"github.com/DECODEproject/iotencoder/pkg/logger"
+	"github.com/DECODEproject/iotencoder/pkg/logger/level"
 	"github.com/DECODEproject/iotencoder/pkg/mqtt"
+	"github.com/DECODEproject/iotencoder/pkg/partition"
+	"github.com/DECODEproject/iotencoder/pkg/pipeline"
 	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/processing"
+	"github.com/DECODEproject/iotencoder/pkg/pubkey"
+	"github.com/DECODEproject/iotencoder/pkg/quota"
+	"github.com/DECODEproject/iotencoder/pkg/requestid"
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+	"github.com/DECODEproject/iotencoder/pkg/timestamp"
+)
+
+// MessagesCounter is a prometheus counter recording a count of incoming
+// messages handled, labelled by whether they were processed, failed, or were
+// dropped as duplicates or as too many messages from their device were waiting.
+var MessagesCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "decode",
+		Subsystem: "encoder",
+		Name:      "messages_handled",
+		Help:      "Count of incoming messages handled, by result",
+	},
+	[]string{"result"},
 )
 
 // Processor is the interface we want to call to process incoming events. We
 // define it in this package where we need it.
 type Processor interface {
-	Process(device *postgres.Device, payload []byte) error
+	Process(ctx context.Context, device *postgres.Device, payload []byte) error
+
+	// DryRun verifies that data for the given stream can be encrypted, returning
+	// an error if not.
+	DryRun(ctx context.Context, stream *postgres.Stream) error
+}
+
+// DropRecorder is an optional interface of a Processor which records messages
+// dropped before reaching it, as too many messages from their device were
+// waiting to be processed. It is satisfied by the pipeline.Processor type.
+type DropRecorder interface {
+	RecordDropped(device *postgres.Device, n int)
+}
+
+// DB is the interface to the storage used by the encoder to persist streams
+// and load devices. It is satisfied by the postgres.DB type, and by the
+// in-memory postgrestest.DB for tests.
+type DB interface {
+	CreateStream(stream *postgres.Stream) (*postgres.Stream, error)
+	DeleteStream(stream *postgres.Stream) (*postgres.Device, error)
+	GetDevices() ([]*postgres.Device, error)
+	GetDevice(deviceToken secret.Secret) (*postgres.Device, error)
+	GetStream(streamID, token string) (*postgres.Stream, error)
+	RestoreStream(streamID, token string) (*postgres.Stream, error)
+	ImportStream(stream *postgres.Stream) (*postgres.Stream, error)
+}
+
+// Retainer is the interface we call to retain raw incoming payloads so that
+// they may later be replayed. It is satisfied by the postgres.DB type.
+type Retainer interface {
+	SaveRawPayload(deviceToken string, receivedAt time.Time, payload []byte) error
+}
+
+// Deduplicator is the interface we call to detect messages which have been
+// delivered more than once. A message not seen before is committed once it has
+// been processed, or forgotten if it could not be. It is satisfied by the
+// dedup.Store type.
+type Deduplicator interface {
+	Seen(deviceToken secret.Secret, payload []byte) bool
+	Commit(deviceToken secret.Secret, payload []byte)
+	Forget(deviceToken secret.Secret, payload []byte)
+}
+
+// Auditor is the interface we call to record calls which mutate streams in the
+// audit log. It is satisfied by the audit.Recorder type.
+type Auditor interface {
+	Record(ctx context.Context, method, streamID string, params interface{}, err error)
+}
+
+// Partitioner is the interface we call when devices are partitioned between
+// multiple encoder instances. When configured, devices are only subscribed to
+// by the instance which owns them. It is satisfied by partition.Coordinator.
+type Partitioner interface {
+	Start(owner partition.Owner) error
+	Stop() error
+	Claim(device *postgres.Device) (bool, error)
+	Release(device *postgres.Device) error
 }
 
 // encoderImpl is our implementation of the generated twirp interface for the
 // stream encoder.
 type encoderImpl struct {
 	logger         kitlog.Logger
-	db             *postgres.DB
-	mqtt           mqtt.Client
-	brokerAddr     string
-	brokerUsername string
+	db             DB
+	sources        map[string]Source
+	defaultSource  string
 	processor      Processor
+	retainer       Retainer
 	verbose        bool
-	topicPattern   *regexp.Regexp
+	messageTimeout time.Duration
+	maintenance    *Maintenance
+	partitioner    Partitioner
+	datastores     DatastoreChecker
+	auditor        Auditor
+	readiness      *Readiness
+	deduplicator   Deduplicator
+	policies       PolicyResolver
+	schemas        SchemaResolver
+	verifier       DeviceVerifier
+	quotas         *quota.Quotas
+	events         *events.Bus
+	dataDeleter    DataDeleter
+
+	// initial records the streams awaiting the retained message of their
+	// device's topic as their first reading
+	initial initialReadings
+
+	// allowPlaintext is true if streams may write the readings of some sensors
+	// unencrypted
+	allowPlaintext bool
+
+	// archiving is true if we have an archive to which streams may have their
+	// encrypted payloads written
+	archiving bool
+
+	// dispatcher is nil if messages are processed as they are received from
+	// their source, rather than by a pool of workers
+	dispatcher *dispatcher
+
+	// restoreConcurrency is the maximum number of devices subscribed to
+	// concurrently when restoring subscriptions on startup
+	restoreConcurrency int
+
+	// ctx is the parent of the contexts used to process incoming messages, and
+	// is cancelled when the encoder is stopped
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	sync.Mutex
+	stopped  bool
+	inflight sync.WaitGroup
 }
 
-// Config is a struct used to pass in configuration when creating the encoder
+// Config is a struct used to pass in configuration when creating the encoder.
+// Retainer is optional, and if nil raw payloads are not retained. If
+// MessageTimeout is non-zero it is the deadline applied to the processing of
+// each incoming message. Maintenance is optional, and if set streams cannot be
+// created or deleted while it is enabled. Partitioner is optional, and if set
+// only devices owned by this instance are subscribed to. Datastores is
+// optional, and if nil streams cannot specify their own datastore. Sources is
+// optional and holds the sources available in addition to MQTT keyed by name,
+// while DefaultSource names the source used by streams which don't select one,
+// and if empty is MQTTSource. Auditor is optional, and if set every call which
+// mutates a stream is recorded. RestoreConcurrency is the maximum number of
+// devices subscribed to concurrently on startup, and if not positive devices
+// are subscribed to one at a time. Readiness is optional, and if set records
+// the progress of restoring subscriptions on startup. Workers is the number of
+// workers processing incoming messages concurrently, where the messages of each
+// device are always processed in the order received by the same worker, and if
+// not positive messages are processed as they are received from their source.
+// If DeviceQueueLimit is positive, messages from a device which already has
+// that many messages waiting for a worker are dropped.
+// Deduplicator is optional, and if set messages delivered more than once are
+// dropped. Policies is optional, and if set streams may reference a policy
+// whose public key is resolved in place of passing the key. Verifier is
+// optional, and if set streams are only created for devices known to it.
+// Schemas is optional, and if set streams may reference a payload schema.
+// AllowPlaintext must be set for streams to write readings in plain. Quotas is
+// optional, and if set streams beyond the stream quota of their community are
+// refused. Events is optional, and if set changes to our streams and
+// subscriptions are published to it. Archiving must be set for streams to have
+// their encrypted payloads archived to object storage. DataDeleter is optional,
+// and if set streams may be deleted along with the data written for them.
 type Config struct {
-	DB             *postgres.DB
-	MQTTClient     mqtt.Client
-	Processor      Processor
-	Verbose        bool
-	BrokerAddr     string
-	BrokerUsername string
+	DB                 DB
+	MQTTClient         mqtt.Client
+	Processor          Processor
+	Retainer           Retainer
+	Verbose            bool
+	BrokerAddr         string
+	BrokerUsername     string
+	MessageTimeout     time.Duration
+	Maintenance        *Maintenance
+	Partitioner        Partitioner
+	Datastores         DatastoreChecker
+	Sources            map[string]Source
+	DefaultSource      string
+	Auditor            Auditor
+	RestoreConcurrency int
+	Readiness          *Readiness
+	Workers            int
+	DeviceQueueLimit   int
+	Deduplicator       Deduplicator
+	Policies           PolicyResolver
+	Schemas            SchemaResolver
+	Verifier           DeviceVerifier
+	AllowPlaintext     bool
+	Archiving          bool
+	Quotas             *quota.Quotas
+	Events             *events.Bus
+	DataDeleter        DataDeleter
 }
 
 // NewEncoder returns a newly instantiated Encoder instance. It takes as
@@ -53,21 +236,81 @@ func NewEncoder(config *Config, logger kitlog.Logger) encoder.Encoder {
 
 	logger.Log("msg", "creating encoder")
 
+	ctx, cancel := context.WithCancel(context.Background())
+
+	sources := map[string]Source{}
+	for name, source := range config.Sources {
+		sources[name] = source
+	}
+
+	if config.MQTTClient != nil {
+		sources[MQTTSource] = &mqttSource{
+			client:   config.MQTTClient,
+			broker:   config.BrokerAddr,
+			username: config.BrokerUsername,
+		}
+	}
+
+	defaultSource := config.DefaultSource
+	if defaultSource == "" {
+		defaultSource = MQTTSource
+	}
+
+	var d *dispatcher
+	if config.Workers > 0 {
+		d = newDispatcher(config.Workers, config.DeviceQueueLimit)
+	}
+
 	return &encoderImpl{
 		logger:         logger,
 		db:             config.DB,
-		mqtt:           config.MQTTClient,
+		sources:        sources,
+		defaultSource:  defaultSource,
 		processor:      config.Processor,
+		retainer:       config.Retainer,
 		verbose:        config.Verbose,
-		brokerAddr:     config.BrokerAddr,
-		brokerUsername: config.BrokerUsername,
-		topicPattern:   regexp.MustCompile(`device/sck/(\w+)/readings`),
+		messageTimeout: config.MessageTimeout,
+		maintenance:    config.Maintenance,
+		partitioner:    config.Partitioner,
+		datastores:     config.Datastores,
+		auditor:        config.Auditor,
+		readiness:      config.Readiness,
+		dispatcher:     d,
+		deduplicator:   config.Deduplicator,
+		policies:       config.Policies,
+		schemas:        config.Schemas,
+		verifier:       config.Verifier,
+		quotas:         config.Quotas,
+		events:         config.Events,
+		dataDeleter:    config.DataDeleter,
+		allowPlaintext: config.AllowPlaintext,
+		archiving:      config.Archiving,
+		ctx:            ctx,
+		cancel:         cancel,
+
+		restoreConcurrency: config.RestoreConcurrency,
 	}
 }
 
-// Start the encoder. Here we create MQTT subscriptions for all records stored
-// in the DB.
+// Start the encoder. Here we create subscriptions for all records stored in
+// the DB, or if devices are partitioned between instances for those devices
+// owned by this instance.
 func (e *encoderImpl) Start() error {
+	if e.partitioner != nil {
+		e.logger.Log("msg", "creating subscriptions for owned devices")
+
+		err := e.partitioner.Start(e)
+		if err != nil {
+			return errors.Wrap(err, "failed to start partitioner")
+		}
+
+		// owned devices are subscribed to as their leases are acquired, so there
+		// is nothing to wait for
+		e.readiness.begin(0)
+
+		return nil
+	}
+
 	e.logger.Log("msg", "creating existing subscriptions")
 
 	devices, err := e.db.GetDevices()
@@ -75,88 +318,409 @@ func (e *encoderImpl) Start() error {
 		return errors.Wrap(err, "failed to load devices")
 	}
 
+	err = e.restoreDevices(devices)
+	if err != nil {
+		raven.CaptureError(err, map[string]string{"operation": "restoreDevices"})
+		level.Error(e.logger).Log("err", err, "msg", "failed to restore subscriptions")
+	}
+
+	return nil
+}
+
+// restoreDevices subscribes to each of the given devices, subscribing to at
+// most restoreConcurrency devices at a time and recording progress in our
+// Readiness. A device which can't be subscribed to doesn't prevent the others
+// being restored, instead an error describing every failure is returned once
+// all devices have been attempted.
+func (e *encoderImpl) restoreDevices(devices []*postgres.Device) error {
+	concurrency := e.restoreConcurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	e.readiness.begin(len(devices))
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed restoreErrors
+	)
+
+	sem := make(chan struct{}, concurrency)
+
 	for _, d := range devices {
-		e.logger.Log(
-			"broker", e.brokerAddr,
-			"device_token", d.DeviceToken,
-			"msg", "creating subscription",
-		)
+		sem <- struct{}{}
+		wg.Add(1)
 
-		err = e.mqtt.Subscribe(
-			e.brokerAddr,
-			e.brokerUsername,
-			d.DeviceToken,
-			func(topic string, payload []byte) {
-				e.handleCallback(topic, payload)
-			})
+		go func(d *postgres.Device) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
 
-		if err != nil {
-			e.logger.Log("err", err, "msg", "failed to subscribe to topic")
-		}
+			err := e.subscribeDevice(d)
+			if err != nil {
+				level.Error(e.logger).Log("err", err, "msg", "failed to subscribe to topic", "device_hash", logger.HashToken(d.DeviceToken))
+
+				mu.Lock()
+				failed = append(failed, errors.Wrapf(err, "device %s", logger.HashToken(d.DeviceToken)))
+				mu.Unlock()
+
+				return
+			}
+
+			e.readiness.add()
+		}(d)
+	}
+
+	wg.Wait()
+
+	if len(failed) > 0 {
+		return failed
 	}
 
 	return nil
 }
 
-// Stop stops the encoder. Currently this is a NOOP, but keeping the function
-// for now.
+// restoreErrors aggregates the errors encountered while restoring
+// subscriptions.
+type restoreErrors []error
+
+// Error returns a message counting the failures, followed by each error.
+func (r restoreErrors) Error() string {
+	msgs := make([]string, 0, len(r))
+	for _, err := range r {
+		msgs = append(msgs, err.Error())
+	}
+
+	return fmt.Sprintf("failed to subscribe to %d devices: %s", len(r), strings.Join(msgs, "; "))
+}
+
+// Stop stops the encoder. Any messages received after this point, or still
+// queued for a worker, are dropped, and messages currently being processed are
+// cancelled. We wait for in-flight messages to return before returning.
 func (e *encoderImpl) Stop() error {
 	e.logger.Log("msg", "stopping encoder")
 
+	if e.partitioner != nil {
+		err := e.partitioner.Stop()
+		if err != nil {
+			level.Error(e.logger).Log("err", err, "msg", "failed to stop partitioner")
+		}
+	}
+
+	e.Lock()
+	e.stopped = true
+	e.Unlock()
+
+	e.cancel()
+
+	if e.dispatcher != nil {
+		e.dispatcher.stop()
+	}
+
+	e.inflight.Wait()
+
 	return nil
 }
 
 // CreateStream is our implementation of the protocol buffer interface. It takes
-// the incoming request, validates it and if valid we write some data to the
-// database, and set up a subscription with the specified MQTT broker.
-func (e *encoderImpl) CreateStream(ctx context.Context, req *encoder.CreateStreamRequest) (*encoder.CreateStreamResponse, error) {
-	err := validateCreateRequest(req)
+// the incoming request, already checked by the validator, and if its processing
+// is valid we write some data to the database, and set up a subscription with
+// the stream's source.
+func (e *encoderImpl) CreateStream(ctx context.Context, req *encoder.CreateStreamRequest) (resp *encoder.CreateStreamResponse, err error) {
+	defer func() {
+		var streamID string
+		if resp != nil {
+			streamID = resp.StreamUid
+		}
+
+		e.audit(ctx, "CreateStream", streamID, &createParams{
+			CreateStreamRequest: req,
+			DatastoreAddr:       DatastoreAddr(ctx),
+			Source:              SourceName(ctx),
+			Compression:         Compression(ctx),
+			TimestampPolicy:     TimestampPolicy(ctx),
+			PolicyID:            PolicyID(ctx),
+			Labels:              Labels(ctx),
+			DatastoreTimeout:    DatastoreTimeout(ctx),
+			Schema:              Schema(ctx),
+			Dispositions:        Dispositions(ctx),
+			Privacy:             Privacy(ctx),
+			GeoPrivacy:          GeoPrivacy(ctx),
+			RateLimit:           RateLimit(ctx),
+			Sampling:            Sampling(ctx),
+			Schedule:            Schedule(ctx),
+			Geofence:            Geofence(ctx),
+			Archive:             Archive(ctx),
+			Priority:            Priority(ctx),
+			TopicTemplate:       TopicTemplate(ctx),
+			InitialReading:      InitialReading(ctx),
+		}, err)
+	}()
+
+	// callers quote the id of a failed request when reporting the failure
+	defer func() {
+		err = requestid.WithMeta(ctx, err)
+	}()
+
+	err = checkMaintenance(e.maintenance)
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := requestVersion(ctx, req.ApiVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	deprecations, err := checkCreateRequest(version, req)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err = e.resolvePolicy(ctx, req, PolicyID(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := createStream(req)
+	if err != nil {
+		return nil, err
+	}
+
+	stream.PolicyID = PolicyID(ctx)
+	stream.DatastoreAddr = DatastoreAddr(ctx)
+	stream.DatastoreTimeout = DatastoreTimeout(ctx)
+	stream.Source = SourceName(ctx)
+	stream.Compression = Compression(ctx)
+	stream.TimestampPolicy = TimestampPolicy(ctx)
+
+	streamLabels, err := labels.Parse(Labels(ctx))
+	if err != nil {
+		return nil, twirp.InvalidArgumentError("labels", err.Error())
+	}
+
+	if len(streamLabels) > 0 {
+		stream.Labels = postgres.Labels(streamLabels)
+	}
+
+	if _, ok := e.sources[stream.Source]; stream.Source != "" && !ok {
+		return nil, twirp.InvalidArgumentError("source", "is not a configured source")
+	}
+
+	if !compress.Valid(stream.Compression) {
+		return nil, twirp.InvalidArgumentError("compression", "must be gzip or zstd")
+	}
+
+	if !timestamp.Valid(stream.TimestampPolicy) {
+		return nil, twirp.InvalidArgumentError("timestamp_policy", "must be device, server or reject:<duration>")
+	}
+
+	err = e.checkDatastore(ctx, stream.DatastoreAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateDatastoreTimeout(stream.DatastoreTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	stream.Schema, err = e.resolveSchema(Schema(ctx), stream.Operations)
+	if err != nil {
+		return nil, err
+	}
+
+	dispositions, err := postgres.ParseDispositions(Dispositions(ctx))
+	if err != nil {
+		return nil, twirp.InvalidArgumentError("channel_dispositions", err.Error())
+	}
+
+	err = e.validateDispositions(dispositions, stream.Operations)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(dispositions) > 0 {
+		stream.Dispositions = dispositions
+	}
+
+	err = validatePrivacy(Privacy(ctx), stream.Operations)
+	if err != nil {
+		return nil, err
+	}
+
+	stream.Privacy = Privacy(ctx)
+
+	err = validateGeoPrivacy(GeoPrivacy(ctx))
 	if err != nil {
 		return nil, err
 	}
 
-	stream, err := createStream(req, e.brokerAddr)
+	stream.GeoPrivacy = GeoPrivacy(ctx)
+
+	err = validateRateLimit(RateLimit(ctx))
 	if err != nil {
 		return nil, err
 	}
 
+	stream.RateLimit = RateLimit(ctx)
+
+	err = validateSampling(Sampling(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	stream.Sampling = Sampling(ctx)
+
+	err = validateSchedule(Schedule(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	stream.Schedule = Schedule(ctx)
+
+	err = validateGeofence(Geofence(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	stream.Geofence = Geofence(ctx)
+
+	stream.Archive, err = e.parseArchive(Archive(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	stream.Priority, err = parsePriority(Priority(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	stream.Topic, err = e.resolveTopicTemplate(TopicTemplate(ctx), stream)
+	if err != nil {
+		return nil, err
+	}
+
+	initial, err := e.parseInitialReading(InitialReading(ctx), stream)
+	if err != nil {
+		return nil, err
+	}
+
+	err = e.verifyDevice(ctx, stream.Device.DeviceToken)
+	if err != nil {
+		return nil, err
+	}
+
+	err = e.checkStreamQuota(stream.CommunityID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = e.processor.DryRun(ctx, stream)
+	if err != nil {
+		level.Warn(requestid.Logger(ctx, e.logger)).Log("err", err, "msg", "stream failed dry-run validation")
+		return nil, twirp.InvalidArgumentError("recipient_public_key", "could not be used to encrypt data")
+	}
+
+	if s, ok := e.sources[e.sourceFor(stream)].(subscriber); ok {
+		_, qos := s.Subscription(stream.Device.DeviceToken)
+		stream.QoS = int(qos)
+	}
+
 	stream, err = e.db.CreateStream(stream)
 	if err != nil {
 		raven.CaptureError(err, map[string]string{"operation": "createStream"})
 		return nil, twirp.InternalErrorWith(err)
 	}
 
-	err = e.mqtt.Subscribe(
-		e.brokerAddr,
-		e.brokerUsername,
-		req.DeviceToken,
-		func(topic string, payload []byte) {
-			e.handleCallback(topic, payload)
-		})
+	if initial {
+		// subscribing has the broker deliver the retained message of the
+		// device's topic, which must be expected before it arrives
+		e.initial.expect(stream.Device.DeviceToken, stream.StreamID)
+	}
+
+	if e.partitioner != nil {
+		// if another instance owns the device it is already subscribed, else the
+		// device is picked up at the next rebalance
+		_, err = e.partitioner.Claim(stream.Device)
+	} else {
+		err = e.subscribe(e.sourceFor(stream), stream.Device.DeviceToken, streamTopic(stream))
+	}
 
 	if err != nil {
 		raven.CaptureError(err, map[string]string{"operation": "createStream"})
 		return nil, twirp.InternalErrorWith(err)
 	}
 
-	return &encoder.CreateStreamResponse{
-		StreamUid: stream.StreamID,
-		Token:     stream.Token,
-	}, nil
+	e.publish(events.StreamCreated, stream)
+
+	resp = e.createStreamResponse(stream)
+	resp.ApiVersion = uint32(version)
+	resp.Deprecations = reportDeprecations(deprecations)
+
+	return resp, nil
 }
 
-// DeleteStream is the method we provide for deleting a stream. It validates the
-// request, then deletes specified records from the database, and removes any
-// subscriptions.
-func (e *encoderImpl) DeleteStream(ctx context.Context, req *encoder.DeleteStreamRequest) (*encoder.DeleteStreamResponse, error) {
-	err := validateDeleteRequest(req)
+// DeleteStream is the method we provide for deleting a stream. It takes the
+// request, already checked by the validator, then deletes specified records
+// from the database, and removes any subscriptions.
+func (e *encoderImpl) DeleteStream(ctx context.Context, req *encoder.DeleteStreamRequest) (_ *encoder.DeleteStreamResponse, err error) {
+	defer func() {
+		e.audit(ctx, "DeleteStream", req.StreamUid, req, err)
+	}()
+
+	defer func() {
+		err = requestid.WithMeta(ctx, err)
+	}()
+
+	err = checkMaintenance(e.maintenance)
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := requestVersion(ctx, req.ApiVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	deleteData, err := e.parseDeleteData(DeleteData(ctx))
 	if err != nil {
 		return nil, err
 	}
 
 	stream := &postgres.Stream{
 		StreamID: req.StreamUid,
-		Token:    req.Token,
+		Token:    secret.Secret(req.Token),
+	}
+
+	// the community, device and datastore of the stream are needed to ask its
+	// datastore to delete its data once the stream is deleted
+	var existing *postgres.Stream
+
+	if deleteData {
+		existing, err = e.db.GetStream(req.StreamUid, req.Token)
+		if err != nil {
+			if err == postgres.ErrStreamNotFound {
+				return nil, twirp.NotFoundError("stream not found")
+			}
+
+			raven.CaptureError(err, map[string]string{"operation": "deleteStream"})
+			return nil, twirp.InternalErrorWith(err)
+		}
+	}
+
+	// with several sources the device may keep other streams but no longer
+	// need the source of this one, so we must learn which device it belongs to
+	// before deleting it
+	var deviceToken secret.Secret
+
+	if e.partitioner == nil && len(e.sources) > 1 {
+		existing, err := e.db.GetStream(req.StreamUid, req.Token)
+		if err == nil {
+			deviceToken = existing.Device.DeviceToken
+		}
 	}
 
 	device, err := e.db.DeleteStream(stream)
@@ -167,92 +731,643 @@ func (e *encoderImpl) DeleteStream(ctx context.Context, req *encoder.DeleteStrea
 
 	if device != nil {
 		// we should unsubscribe for this device
-		err = e.mqtt.Unsubscribe(e.brokerAddr, e.brokerUsername, device.DeviceToken)
+		if e.partitioner != nil {
+			err = e.partitioner.Release(device)
+		} else {
+			err = e.unsubscribeDevice(device.DeviceToken, nil)
+		}
+
+		if err != nil {
+			raven.CaptureError(err, map[string]string{"operation": "deleteStream"})
+			return nil, twirp.InternalErrorWith(err)
+		}
+	} else if deviceToken != "" {
+		err = e.pruneSubscriptions(deviceToken)
 		if err != nil {
 			raven.CaptureError(err, map[string]string{"operation": "deleteStream"})
 			return nil, twirp.InternalErrorWith(err)
 		}
 	}
 
-	return &encoder.DeleteStreamResponse{}, nil
+	e.publish(events.StreamDeleted, stream)
+
+	if deleteData {
+		e.deleteData(ctx, existing)
+	}
+
+	return &encoder.DeleteStreamResponse{
+		ApiVersion: uint32(version),
+	}, nil
 }
 
-// handleCallback is our internal function that receives incoming data from the
-// MQTT client. It loads the correct device from Postgres and then dispatches
-// processing to the pipeline module which is responsible for manipulating the
-// data and then writing to the datastore.
-func (e *encoderImpl) handleCallback(topic string, payload []byte) {
-	token, err := e.extractToken(topic)
+// RestoreStream restores a stream previously deleted by DeleteStream which has
+// not yet been purged, and subscribes to its device once again. It is not part
+// of the Encoder protocol buffer definition, so is exposed via the admin API.
+// The returned errors are twirp errors.
+func (e *encoderImpl) RestoreStream(ctx context.Context, streamID, token string) (_ *postgres.Stream, err error) {
+	defer func() {
+		e.audit(ctx, "RestoreStream", streamID, map[string]string{"stream_uid": streamID, "token": token}, err)
+	}()
+
+	err = checkMaintenance(e.maintenance)
 	if err != nil {
-		e.logger.Log("err", err, "msg", "failed to extract device token", "topic", topic)
-		return
+		return nil, err
 	}
 
-	device, err := e.db.GetDevice(token)
+	if streamID == "" {
+		return nil, twirp.RequiredArgumentError("stream_uid")
+	}
+
+	if token == "" {
+		return nil, twirp.RequiredArgumentError("token")
+	}
+
+	stream, err := e.db.RestoreStream(streamID, token)
+	if err != nil {
+		switch err {
+		case postgres.ErrStreamNotFound:
+			return nil, twirp.NotFoundError("deleted stream not found")
+		case postgres.ErrStreamConflict:
+			return nil, twirp.NewError(twirp.AlreadyExists, err.Error())
+		}
+
+		raven.CaptureError(err, map[string]string{"operation": "restoreStream"})
+		return nil, twirp.InternalErrorWith(err)
+	}
+
+	if e.partitioner != nil {
+		_, err = e.partitioner.Claim(stream.Device)
+	} else {
+		err = e.subscribe(e.sourceFor(stream), stream.Device.DeviceToken, streamTopic(stream))
+	}
+
+	if err != nil {
+		raven.CaptureError(err, map[string]string{"operation": "restoreStream"})
+		return nil, twirp.InternalErrorWith(err)
+	}
+
+	e.publish(events.StreamRestored, stream)
+
+	return stream, nil
+}
+
+// ImportStream registers a stream exported from another encoder, keeping its
+// id, token and ingest secret, and subscribes to its device. It is not part of
+// the Encoder protocol buffer definition, so is exposed via the admin API. The
+// returned errors are twirp errors.
+func (e *encoderImpl) ImportStream(ctx context.Context, stream *postgres.Stream) (_ *postgres.Stream, err error) {
+	defer func() {
+		e.audit(ctx, "ImportStream", stream.StreamID, map[string]string{"stream_uid": stream.StreamID}, err)
+	}()
+
+	err = checkMaintenance(e.maintenance)
+	if err != nil {
+		return nil, err
+	}
+
+	if stream.StreamID == "" {
+		return nil, twirp.RequiredArgumentError("stream_uid")
+	}
+
+	if stream.Token == "" {
+		return nil, twirp.RequiredArgumentError("token")
+	}
+
+	if stream.Device == nil || stream.Device.DeviceToken == "" {
+		return nil, twirp.RequiredArgumentError("device_token")
+	}
+
+	err = stream.Operations.Validate()
+	if err != nil {
+		return nil, twirp.InvalidArgumentError("operations", err.Error())
+	}
+
+	err = e.validateDispositions(stream.Dispositions, stream.Operations)
 	if err != nil {
-		raven.CaptureError(err, map[string]string{"operation": "handleCallback"})
-		e.logger.Log("err", err, "msg", "failed to get device", "token", token)
+		return nil, err
+	}
+
+	err = validatePrivacy(stream.Privacy, stream.Operations)
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateGeoPrivacy(stream.GeoPrivacy)
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateRateLimit(stream.RateLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateSampling(stream.Sampling)
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateSchedule(stream.Schedule)
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateGeofence(stream.Geofence)
+	if err != nil {
+		return nil, err
+	}
+
+	if stream.Topic != "" {
+		err = mqtt.ValidateTopicTemplate(stream.Topic)
+		if err != nil {
+			return nil, twirp.InvalidArgumentError("topic_template", err.Error())
+		}
+	}
+
+	stream.PublicKeyFingerprint = pubkey.Fingerprint(stream.PublicKey)
+
+	imported, err := e.db.ImportStream(stream)
+	if err != nil {
+		switch err {
+		case postgres.ErrStreamExists, postgres.ErrStreamConflict:
+			return nil, twirp.NewError(twirp.AlreadyExists, err.Error())
+		}
+
+		raven.CaptureError(err, map[string]string{"operation": "importStream"})
+		return nil, twirp.InternalErrorWith(err)
+	}
+
+	if e.partitioner != nil {
+		_, err = e.partitioner.Claim(imported.Device)
+	} else {
+		err = e.subscribe(e.sourceFor(imported), imported.Device.DeviceToken, streamTopic(imported))
+	}
+
+	if err != nil {
+		raven.CaptureError(err, map[string]string{"operation": "importStream"})
+		return nil, twirp.InternalErrorWith(err)
+	}
+
+	e.publish(events.StreamImported, imported)
+
+	return imported, nil
+}
+
+// createParams are the audited parameters of a call to CreateStream, which
+// include the values carried alongside the request in HTTP headers.
+type createParams struct {
+	*encoder.CreateStreamRequest
+	DatastoreAddr    string `json:"datastore_addr,omitempty"`
+	Source           string `json:"source,omitempty"`
+	Compression      string `json:"compression,omitempty"`
+	TimestampPolicy  string `json:"timestamp_policy,omitempty"`
+	PolicyID         string `json:"policy_id,omitempty"`
+	Labels           string `json:"labels,omitempty"`
+	DatastoreTimeout string `json:"datastore_timeout,omitempty"`
+	Schema           string `json:"schema,omitempty"`
+	Dispositions     string `json:"channel_dispositions,omitempty"`
+	Privacy          string `json:"privacy,omitempty"`
+	GeoPrivacy       string `json:"geo_privacy,omitempty"`
+	RateLimit        string `json:"rate_limit,omitempty"`
+	Sampling         string `json:"sampling,omitempty"`
+	Schedule         string `json:"schedule,omitempty"`
+	Geofence         string `json:"geofence,omitempty"`
+	Archive          string `json:"archive,omitempty"`
+	Priority         string `json:"priority,omitempty"`
+	TopicTemplate    string `json:"topic_template,omitempty"`
+	InitialReading   string `json:"initial_reading,omitempty"`
+}
+
+// audit records a call which mutated a stream if we have an auditor.
+func (e *encoderImpl) audit(ctx context.Context, method, streamID string, params interface{}, err error) {
+	if e.auditor == nil {
 		return
 	}
 
-	if e.verbose {
-		e.logger.Log("topic", topic, "payload", string(payload), "msg", "received data")
+	e.auditor.Record(ctx, method, streamID, params, err)
+}
+
+// publish publishes an event of the given type for the given stream on our
+// bus, if we have one.
+func (e *encoderImpl) publish(typ events.Type, stream *postgres.Stream) {
+	e.events.Publish(&events.Event{
+		Type:        typ,
+		StreamID:    stream.StreamID,
+		CommunityID: stream.CommunityID,
+	})
+}
+
+// checkDatastore validates the datastore address requested for a new stream,
+// checks that its host is one the operator allows, and verifies that the
+// datastore can be reached so that a misconfigured stream is rejected rather
+// than failing on every write.
+func (e *encoderImpl) checkDatastore(ctx context.Context, addr string) error {
+	if addr == "" {
+		return nil
+	}
+
+	err := validateDatastoreAddr(addr)
+	if err != nil {
+		return err
+	}
+
+	if e.datastores == nil {
+		return twirp.InvalidArgumentError("datastore_addr", "per-stream datastores are not supported")
+	}
+
+	err = e.datastores.Check(ctx, addr)
+	if err == pipeline.ErrDatastoreNotAllowed {
+		return twirp.InvalidArgumentError("datastore_addr", "is not on an allowed datastore host")
 	}
 
-	err = e.processor.Process(device, payload)
 	if err != nil {
-		raven.CaptureError(err, map[string]string{"operation": "handleCallback"})
-		e.logger.Log("err", err, "msg", "failed to process payload")
+		level.Warn(requestid.Logger(ctx, e.logger)).Log("err", err, "msg", "stream datastore failed connectivity check", "datastore", addr)
+		return twirp.InvalidArgumentError("datastore_addr", "could not connect to datastore")
+	}
+
+	return nil
+}
+
+// Own is called by our Partitioner when this instance acquires ownership of a
+// device, and subscribes to the device with the sources of its streams.
+func (e *encoderImpl) Own(device *postgres.Device) error {
+	return e.subscribeDevice(device)
+}
+
+// Disown is called by our Partitioner when this instance gives up ownership of
+// a device, and unsubscribes from the device.
+func (e *encoderImpl) Disown(device *postgres.Device) error {
+	return e.unsubscribeDevice(device.DeviceToken, nil)
+}
+
+// QueueDepth returns the number of messages waiting to be processed by our
+// workers, or zero if messages are processed as they are received.
+func (e *encoderImpl) QueueDepth() int {
+	if e.dispatcher == nil {
+		return 0
+	}
+
+	return e.dispatcher.depth()
+}
+
+// Reload brings our subscriptions to the given device into line with its
+// streams as currently stored, after they were changed other than through this
+// encoder, e.g. directly in the database. A device without any remaining
+// streams is unsubscribed from, while otherwise the device is subscribed to
+// with the sources of its streams and unsubscribed from any others. When
+// devices are partitioned only a device this instance owns, or is able to
+// claim, is subscribed to.
+func (e *encoderImpl) Reload(deviceToken secret.Secret) error {
+	device, err := e.db.GetDevice(deviceToken)
+	if errors.Cause(err) == sql.ErrNoRows {
+		if e.partitioner != nil {
+			return e.partitioner.Release(&postgres.Device{DeviceToken: deviceToken})
+		}
+
+		return e.unsubscribeDevice(deviceToken, nil)
+	}
+
+	if err != nil {
+		return errors.Wrap(err, "failed to load device")
+	}
+
+	if e.partitioner != nil {
+		owned, err := e.partitioner.Claim(device)
+		if err != nil || !owned {
+			return err
+		}
+	}
+
+	names, err := e.deviceSources(device)
+	if err != nil {
+		return err
+	}
+
+	topic, err := e.deviceTopic(device)
+	if err != nil {
+		return err
+	}
+
+	for name := range names {
+		err = e.subscribe(name, deviceToken, topic)
+		if err != nil {
+			return err
+		}
 	}
+
+	return e.unsubscribeDevice(deviceToken, names)
 }
 
-// validateCreateRequest is a slightly verbose method that takes as input an
-// incoming CreateStreamRequest, and returns a twirp error should any required
-// fields are missing, or nil if the request is valid.
-func validateCreateRequest(req *encoder.CreateStreamRequest) error {
-	if req.DeviceToken == "" {
-		return twirp.RequiredArgumentError("device_token")
+// sourceFor returns the name of the source from which readings for the given
+// stream are received. Streams which didn't select a source, or whose source
+// is no longer configured, use the default source.
+func (e *encoderImpl) sourceFor(stream *postgres.Stream) string {
+	if _, ok := e.sources[stream.Source]; ok {
+		return stream.Source
 	}
 
-	if req.DeviceLabel == "" {
-		return twirp.RequiredArgumentError("device_label")
+	if stream.Source != "" {
+		level.Warn(e.logger).Log("msg", "stream source not configured, using default", "stream_uid", stream.StreamID, "source", stream.Source)
 	}
 
-	if req.CommunityId == "" {
-		return twirp.RequiredArgumentError("community_id")
+	return e.defaultSource
+}
+
+// deviceSources returns the names of the sources used by the streams of the
+// given device. With a single source there is nothing to look up, otherwise
+// the device's streams are loaded if not already present.
+func (e *encoderImpl) deviceSources(device *postgres.Device) (map[string]bool, error) {
+	if len(e.sources) <= 1 {
+		return map[string]bool{e.defaultSource: true}, nil
 	}
 
-	if req.RecipientPublicKey == "" {
-		return twirp.RequiredArgumentError("recipient_public_key")
+	streams := device.Streams
+
+	if len(streams) == 0 {
+		d, err := e.db.GetDevice(device.DeviceToken)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to load device streams")
+		}
+		streams = d.Streams
 	}
 
-	if req.Location == nil {
-		return twirp.RequiredArgumentError("location")
+	names := map[string]bool{}
+	for _, stream := range streams {
+		names[e.sourceFor(stream)] = true
 	}
 
-	if req.Location.Longitude == 0 {
-		return twirp.RequiredArgumentError("longitude")
+	return names, nil
+}
+
+// subscribeDevice subscribes to the given device with every source used by its
+// streams.
+func (e *encoderImpl) subscribeDevice(device *postgres.Device) error {
+	names, err := e.deviceSources(device)
+	if err != nil {
+		return err
 	}
 
-	if req.Location.Longitude < -180 || req.Location.Longitude > 180 {
-		return twirp.InvalidArgumentError("longitude", "must be between -180 and 180")
+	topic, err := e.deviceTopic(device)
+	if err != nil {
+		return err
 	}
 
-	if req.Location.Latitude == 0 {
-		return twirp.RequiredArgumentError("latitude")
+	for name := range names {
+		err = e.subscribe(name, device.DeviceToken, topic)
+		if err != nil {
+			return err
+		}
 	}
 
-	if req.Location.Latitude < -90 || req.Location.Latitude > 90 {
-		return twirp.InvalidArgumentError("latitude", "must be between -90 and 90")
+	return nil
+}
+
+// unsubscribeDevice unsubscribes from the given device with every source other
+// than those in keep.
+func (e *encoderImpl) unsubscribeDevice(deviceToken secret.Secret, keep map[string]bool) error {
+	for name, source := range e.sources {
+		if keep[name] {
+			continue
+		}
+
+		e.logger.Log(
+			"source", name,
+			"device_hash", logger.HashToken(deviceToken),
+			"msg", "removing subscription",
+		)
+
+		err := source.Unsubscribe(deviceToken)
+		if err != nil {
+			return errors.Wrapf(err, "failed to unsubscribe from %s source", name)
+		}
 	}
 
 	return nil
 }
 
+// pruneSubscriptions removes the subscriptions of a device which still has
+// streams from any source no longer used by those streams.
+func (e *encoderImpl) pruneSubscriptions(deviceToken secret.Secret) error {
+	device, err := e.db.GetDevice(deviceToken)
+	if err != nil {
+		return errors.Wrap(err, "failed to load device streams")
+	}
+
+	names, err := e.deviceSources(device)
+	if err != nil {
+		return err
+	}
+
+	return e.unsubscribeDevice(deviceToken, names)
+}
+
+// subscribe creates a subscription to the given device with the named source,
+// routing incoming messages to handleMessage. If topic is set the device is
+// subscribed to on that topic rather than its default topic, by sources which
+// have topics.
+func (e *encoderImpl) subscribe(name string, deviceToken secret.Secret, topic string) error {
+	source, ok := e.sources[name]
+	if !ok {
+		return errors.Errorf("source %s is not configured", name)
+	}
+
+	e.logger.Log(
+		"source", name,
+		"device_hash", logger.HashToken(deviceToken),
+		"msg", "creating subscription",
+	)
+
+	callback := func(payload []byte, metadata map[string]string, done func()) {
+		e.dispatch(deviceToken, payload, metadata, done)
+	}
+
+	var err error
+
+	if s, ok := source.(topicSource); ok && topic != "" {
+		err = s.SubscribeTopic(deviceToken, topic, callback)
+	} else {
+		err = source.Subscribe(deviceToken, callback)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	e.events.Publish(&events.Event{
+		Type:       events.SubscriptionCreated,
+		DeviceHash: logger.HashToken(deviceToken),
+		Source:     name,
+	})
+
+	return nil
+}
+
+// dispatch passes an incoming message to handleMessage, via our dispatcher if
+// messages are processed by a pool of workers so that messages from the same
+// device are processed in order. The time of receipt is taken, and the request
+// id by which the message is traced generated, before the message is queued.
+// done is called once the message has been handled, or dropped as too many
+// messages from the device are waiting, but not if it is dropped as we are
+// stopping, so that a broker holding a persistent session for us delivers it
+// again after we restart.
+func (e *encoderImpl) dispatch(token secret.Secret, payload []byte, metadata map[string]string, done func()) {
+	receivedAt := time.Now()
+	requestID := requestid.New()
+
+	if e.dispatcher == nil {
+		if e.handleMessage(token, payload, metadata, receivedAt, requestID, 0) {
+			done()
+		}
+		return
+	}
+
+	err := e.dispatcher.dispatch(token.Reveal(), func(dropped int) {
+		if e.handleMessage(token, payload, metadata, receivedAt, requestID, dropped) {
+			done()
+		}
+	})
+
+	switch err {
+	case errDispatcherStopped:
+		level.Warn(e.logger).Log("request_id", requestID, "msg", "encoder stopped, dropping message")
+	case errBacklogFull:
+		if e.verbose {
+			level.Debug(e.logger).Log("device_hash", logger.HashToken(token), "request_id", requestID, "msg", "device backlog full, dropping message")
+		}
+		MessagesCounter.WithLabelValues("dropped").Inc()
+		done()
+	}
+}
+
+// handleMessage is our internal function that receives incoming data from our
+// sources. It loads the correct device from Postgres and then dispatches
+// processing to the pipeline module which is responsible for manipulating the
+// data and then writing to the datastore, along with any metadata received with
+// the payload, the time it was received and the id by which it is traced, which
+// is added to every line we log about it. dropped is the number of messages
+// from the device dropped since the last was handled, which are recorded for
+// the device's streams once it is loaded. It returns false if the message was
+// dropped as we are stopping, and true once it has been handled, whether or not
+// processing succeeded.
+func (e *encoderImpl) handleMessage(token secret.Secret, payload []byte, metadata map[string]string, receivedAt time.Time, requestID string, dropped int) bool {
+	e.Lock()
+	if e.stopped {
+		e.Unlock()
+		level.Warn(e.logger).Log("request_id", requestID, "msg", "encoder stopped, dropping message")
+		return false
+	}
+	e.inflight.Add(1)
+	e.Unlock()
+
+	defer e.inflight.Done()
+
+	ctx := requestid.With(e.ctx, requestID)
+
+	if e.messageTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.messageTimeout)
+		defer cancel()
+	}
+
+	log := kitlog.With(e.logger, "device_hash", logger.HashToken(token), "request_id", requestID)
+
+	// a message retained by the broker is delivered each time we subscribe to
+	// the device, so is only processed for streams awaiting it as their first
+	// reading
+	metadata, retained := isRetained(metadata)
+	initial := e.initial.take(token)
+
+	if retained && len(initial) == 0 {
+		if e.verbose {
+			level.Debug(log).Log("msg", "ignoring retained message")
+		}
+		MessagesCounter.WithLabelValues("retained").Inc()
+		return true
+	}
+
+	// the retained message may repeat the device's last reading, which the new
+	// streams have not yet had
+	processed := false
+
+	if !retained && e.deduplicator != nil {
+		if e.deduplicator.Seen(token, payload) {
+			if e.verbose {
+				level.Debug(log).Log("msg", "dropping duplicate message")
+			}
+			MessagesCounter.WithLabelValues("duplicate").Inc()
+			return true
+		}
+
+		// a message we fail to process is processed again if redelivered
+		defer func() {
+			if processed {
+				e.deduplicator.Commit(token, payload)
+			} else {
+				e.deduplicator.Forget(token, payload)
+			}
+		}()
+
+		ctx = pipeline.WithMessageKey(ctx, &postgres.MessageKey{Key: dedup.Key(token, payload), SeenAt: receivedAt})
+	}
+
+	device, err := e.db.GetDevice(token)
+	if err != nil {
+		raven.CaptureError(err, map[string]string{"operation": "handleMessage"})
+		level.Error(log).Log("err", err, "msg", "failed to get device")
+		MessagesCounter.WithLabelValues("failed").Inc()
+		return true
+	}
+
+	if retained {
+		device = initialStreams(device, initial)
+		if device == nil {
+			MessagesCounter.WithLabelValues("retained").Inc()
+			return true
+		}
+	}
+
+	if dropped > 0 {
+		if r, ok := e.processor.(DropRecorder); ok {
+			r.RecordDropped(device, dropped)
+		}
+	}
+
+	if e.verbose {
+		level.Debug(log).Log("payload", string(payload), "msg", "received data")
+	}
+
+	if e.retainer != nil && !retained {
+		err = e.retainer.SaveRawPayload(token.Reveal(), receivedAt, payload)
+		if err != nil {
+			raven.CaptureError(err, map[string]string{"operation": "handleMessage"})
+			level.Error(log).Log("err", err, "msg", "failed to retain payload")
+		}
+	}
+
+	if len(metadata) > 0 {
+		ctx = pipeline.WithMetadata(ctx, metadata)
+	}
+
+	ctx = pipeline.WithReceivedAt(ctx, receivedAt)
+
+	err = e.processor.Process(ctx, device, payload)
+	if err != nil {
+		raven.CaptureError(err, map[string]string{"operation": "handleMessage"})
+		level.Error(log).Log("err", err, "msg", "failed to process payload")
+		MessagesCounter.WithLabelValues("failed").Inc()
+		return true
+	}
+
+	MessagesCounter.WithLabelValues("processed").Inc()
+	processed = true
+
+	return true
+}
+
 // createStream is a simple helper method that converts the incoming
 // CreateStreamRequest object into a *postgres.Stream instance ready to be
 // persisted to the DB.
-func createStream(req *encoder.CreateStreamRequest, brokerAddr string) (*postgres.Stream, error) {
+func createStream(req *encoder.CreateStreamRequest) (*postgres.Stream, error) {
 	operations := []*postgres.Operation{}
 
 	for _, o := range req.Operations {
@@ -265,11 +1380,12 @@ func createStream(req *encoder.CreateStreamRequest, brokerAddr string) (*postgre
 	}
 
 	return &postgres.Stream{
-		CommunityID: req.CommunityId,
-		PublicKey:   req.RecipientPublicKey,
-		Operations:  operations,
+		CommunityID:          req.CommunityId,
+		PublicKey:            req.RecipientPublicKey,
+		PublicKeyFingerprint: pubkey.Fingerprint(req.RecipientPublicKey),
+		Operations:           operations,
 		Device: &postgres.Device{
-			DeviceToken: req.DeviceToken,
+			DeviceToken: secret.Secret(req.DeviceToken),
 			Label:       req.DeviceLabel,
 			Longitude:   req.Location.Longitude,
 			Latitude:    req.Location.Latitude,
@@ -278,60 +1394,68 @@ func createStream(req *encoder.CreateStreamRequest, brokerAddr string) (*postgre
 	}, nil
 }
 
+// createOperation converts an operation received in a CreateStreamRequest into
+// the operation stored with the stream, via its typed processing so that an
+// operation sent without it is validated as a typed one would be.
 func createOperation(op *encoder.CreateStreamRequest_Operation) (*postgres.Operation, error) {
-	if op.SensorId == 0 {
-		return nil, twirp.InvalidArgumentError("operations", "require a non-zero sensor id")
-	}
-
-	switch op.Action {
-	case encoder.CreateStreamRequest_Operation_SHARE:
-		return &postgres.Operation{
-			SensorID: op.SensorId,
-			Action:   postgres.Action(op.Action.String()),
-		}, nil
-	case encoder.CreateStreamRequest_Operation_BIN:
-		if len(op.Bins) == 0 {
-			return nil, twirp.InvalidArgumentError("operations", "binning requires a non-empty list of bins")
-		}
-		return &postgres.Operation{
-			SensorID: op.SensorId,
-			Action:   postgres.Action(op.Action.String()),
-			Bins:     op.Bins,
-		}, nil
-	case encoder.CreateStreamRequest_Operation_MOVING_AVG:
-		if op.Interval == 0 {
-			return nil, twirp.InvalidArgumentError("operations", "moving average requires a non-zero interval")
-		}
-		return &postgres.Operation{
-			SensorID: op.SensorId,
-			Action:   postgres.Action(op.Action.String()),
-			Interval: op.Interval,
-		}, nil
-	default:
-		return nil, twirp.InvalidArgumentError("operations", "foo")
+	typed, err := processing.Typed(op)
+	if err != nil {
+		return nil, twirp.InvalidArgumentError("operations", err.Error())
 	}
-}
 
-// validateDeleteRequest validates incoming deletion requests (we just check for
-// a stream uid)
-func validateDeleteRequest(req *encoder.DeleteStreamRequest) error {
-	if req.StreamUid == "" {
-		return twirp.RequiredArgumentError("stream_uid")
+	operation := &postgres.Operation{
+		SensorID: typed.SensorId,
 	}
 
-	if req.Token == "" {
-		return twirp.RequiredArgumentError("token")
+	switch p := typed.Processing.(type) {
+	case *encoder.CreateStreamRequest_Operation_Passthrough:
+		operation.Action = postgres.Share
+	case *encoder.CreateStreamRequest_Operation_MovingAverage:
+		operation.Action = postgres.MovingAverage
+		operation.Interval = p.MovingAverage.Interval
+	case *encoder.CreateStreamRequest_Operation_Bin:
+		operation.Action = postgres.Bin
+		operation.Bins = p.Bin.Bins
+	case *encoder.CreateStreamRequest_Operation_Downsample:
+		operation.Action = postgres.Downsample
+		operation.Interval = p.Downsample.Interval
+	case *encoder.CreateStreamRequest_Operation_Delta:
+		operation.Action = postgres.Delta
+		operation.Threshold = p.Delta.Threshold
 	}
 
-	return nil
+	return operation, nil
 }
 
-func (e *encoderImpl) extractToken(topic string) (string, error) {
-	matches := e.topicPattern.FindStringSubmatch(topic)
+// streamOperation converts an operation stored with a stream back into an
+// operation with typed processing.
+func streamOperation(op *postgres.Operation) *encoder.CreateStreamRequest_Operation {
+	operation := &encoder.CreateStreamRequest_Operation{
+		SensorId: op.SensorID,
+	}
 
-	if matches == nil {
-		return "", fmt.Errorf("Unable to extract device token from: %s", topic)
+	switch op.Action {
+	case postgres.MovingAverage:
+		operation.Processing = &encoder.CreateStreamRequest_Operation_MovingAverage{
+			MovingAverage: &encoder.MovingAverage{Interval: op.Interval},
+		}
+	case postgres.Bin:
+		operation.Processing = &encoder.CreateStreamRequest_Operation_Bin{
+			Bin: &encoder.Bin{Bins: op.Bins},
+		}
+	case postgres.Downsample:
+		operation.Processing = &encoder.CreateStreamRequest_Operation_Downsample{
+			Downsample: &encoder.Downsample{Interval: op.Interval},
+		}
+	case postgres.Delta:
+		operation.Processing = &encoder.CreateStreamRequest_Operation_Delta{
+			Delta: &encoder.Delta{Threshold: op.Threshold},
+		}
+	default:
+		operation.Processing = &encoder.CreateStreamRequest_Operation_Passthrough{
+			Passthrough: &encoder.Passthrough{},
+		}
 	}
 
-	return matches[1], nil
+	return operation
 }
@@ -86,7 +86,7 @@ func (e *EncoderTestSuite) TestStreamLifecycle() {
 	resp, err := enc.CreateStream(context.Background(), &encoder.CreateStreamRequest{
 		DeviceToken:        "abc123",
 		DeviceLabel:        "my sensor",
-		RecipientPublicKey: "pub_key",
+		RecipientPublicKey: publicKey,
 		CommunityId:        "policy-id",
 		Location: &encoder.CreateStreamRequest_Location{
 			Longitude: -0.024,
@@ -138,7 +138,7 @@ func (e *EncoderTestSuite) TestStreamWithOperationsLifecycle() {
 	resp, err := enc.CreateStream(context.Background(), &encoder.CreateStreamRequest{
 		DeviceToken:        "abc123",
 		DeviceLabel:        "my sensor",
-		RecipientPublicKey: "pub_key",
+		RecipientPublicKey: publicKey,
 		CommunityId:        "policy-id",
 		Location: &encoder.CreateStreamRequest_Location{
 			Longitude: -0.024,
@@ -269,7 +269,7 @@ func (e *EncoderTestSuite) TestCreateStreamInvalid() {
 			label: "missing device token",
 			request: &encoder.CreateStreamRequest{
 				DeviceLabel:        "my sensor",
-				RecipientPublicKey: "pubkey",
+				RecipientPublicKey: publicKey,
 				Location: &encoder.CreateStreamRequest_Location{
 					Longitude: 32,
 					Latitude:  23,
@@ -282,7 +282,7 @@ func (e *EncoderTestSuite) TestCreateStreamInvalid() {
 			label: "missing device label",
 			request: &encoder.CreateStreamRequest{
 				DeviceToken:        "foobar",
-				RecipientPublicKey: "pubkey",
+				RecipientPublicKey: publicKey,
 				Location: &encoder.CreateStreamRequest_Location{
 					Longitude: 32,
 					Latitude:  23,
@@ -296,7 +296,7 @@ func (e *EncoderTestSuite) TestCreateStreamInvalid() {
 			request: &encoder.CreateStreamRequest{
 				DeviceToken:        "foo",
 				DeviceLabel:        "my sensor",
-				RecipientPublicKey: "pubkey",
+				RecipientPublicKey: publicKey,
 				Location: &encoder.CreateStreamRequest_Location{
 					Longitude: 32,
 					Latitude:  23,
@@ -325,7 +325,7 @@ func (e *EncoderTestSuite) TestCreateStreamInvalid() {
 				DeviceToken:        "foo",
 				DeviceLabel:        "my sensor",
 				CommunityId:        "policy-id",
-				RecipientPublicKey: "pubkey",
+				RecipientPublicKey: publicKey,
 				Exposure:           encoder.CreateStreamRequest_INDOOR,
 			},
 			expectedErr: "twirp error invalid_argument: location is required",
@@ -336,7 +336,7 @@ func (e *EncoderTestSuite) TestCreateStreamInvalid() {
 				DeviceToken:        "foo",
 				DeviceLabel:        "my sensor",
 				CommunityId:        "policy-id",
-				RecipientPublicKey: "pubkey",
+				RecipientPublicKey: publicKey,
 				Location: &encoder.CreateStreamRequest_Location{
 					Latitude: 23,
 				},
@@ -350,7 +350,7 @@ func (e *EncoderTestSuite) TestCreateStreamInvalid() {
 				DeviceToken:        "foo",
 				DeviceLabel:        "my sensor",
 				CommunityId:        "policy-id",
-				RecipientPublicKey: "pubkey",
+				RecipientPublicKey: publicKey,
 				Location: &encoder.CreateStreamRequest_Location{
 					Longitude: 45,
 				},
@@ -363,7 +363,7 @@ func (e *EncoderTestSuite) TestCreateStreamInvalid() {
 			request: &encoder.CreateStreamRequest{
 				DeviceToken:        "abc123",
 				DeviceLabel:        "my sensor",
-				RecipientPublicKey: "pub_key",
+				RecipientPublicKey: publicKey,
 				CommunityId:        "policy-id",
 				Location: &encoder.CreateStreamRequest_Location{
 					Longitude: -0.024,
@@ -383,7 +383,7 @@ func (e *EncoderTestSuite) TestCreateStreamInvalid() {
 			request: &encoder.CreateStreamRequest{
 				DeviceToken:        "abc123",
 				DeviceLabel:        "my sensor",
-				RecipientPublicKey: "pub_key",
+				RecipientPublicKey: publicKey,
 				CommunityId:        "policy-id",
 				Location: &encoder.CreateStreamRequest_Location{
 					Longitude: -0.024,
@@ -404,7 +404,7 @@ func (e *EncoderTestSuite) TestCreateStreamInvalid() {
 			request: &encoder.CreateStreamRequest{
 				DeviceToken:        "abc123",
 				DeviceLabel:        "my sensor",
-				RecipientPublicKey: "pub_key",
+				RecipientPublicKey: publicKey,
 				CommunityId:        "policy-id",
 				Location: &encoder.CreateStreamRequest_Location{
 					Longitude: -0.024,
@@ -420,11 +420,26 @@ func (e *EncoderTestSuite) TestCreateStreamInvalid() {
 			},
 			expectedErr: "twirp error invalid_argument: operations moving average requires a non-zero interval",
 		},
+		{
+			label: "invalid public key",
+			request: &encoder.CreateStreamRequest{
+				DeviceToken:        "abc123",
+				DeviceLabel:        "my sensor",
+				RecipientPublicKey: "pub_key",
+				CommunityId:        "policy-id",
+				Location: &encoder.CreateStreamRequest_Location{
+					Longitude: -0.024,
+					Latitude:  54.24,
+				},
+				Exposure: encoder.CreateStreamRequest_INDOOR,
+			},
+			expectedErr: "twirp error invalid_argument: recipient_public_key invalid public key, must be base64 encoded",
+		},
 	}
 
 	for _, tc := range testcases {
 		e.T().Run(tc.label, func(t *testing.T) {
-			_, err := enc.CreateStream(context.Background(), tc.request)
+			_, err := rpc.NewValidator(enc).CreateStream(context.Background(), tc.request)
 			assert.NotNil(t, err)
 			assert.Equal(t, tc.expectedErr, err.Error())
 		})
@@ -474,7 +489,7 @@ func (e *EncoderTestSuite) TestDeleteStreamInvalid() {
 
 	for _, tc := range testcases {
 		e.T().Run(tc.label, func(t *testing.T) {
-			_, err := enc.DeleteStream(context.Background(), tc.request)
+			_, err := rpc.NewValidator(enc).DeleteStream(context.Background(), tc.request)
 			assert.NotNil(t, err)
 			assert.Equal(t, tc.expectedErr, err.Error())
 		})
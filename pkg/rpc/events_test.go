@@ -0,0 +1,62 @@
+package rpc_test
+
+import (
+	"context"
+	"testing"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	encoder "github.com/thingful/twirp-encoder-go"
+
+	"github.com/DECODEproject/iotencoder/pkg/events"
+	"github.com/DECODEproject/iotencoder/pkg/mqtt/mqtttest"
+	"github.com/DECODEproject/iotencoder/pkg/postgres/postgrestest"
+	"github.com/DECODEproject/iotencoder/pkg/rpc"
+)
+
+func TestPublishesEvents(t *testing.T) {
+	bus := events.NewBus(10, kitlog.NewNopLogger())
+	recorder := events.NewRecorder(10)
+	bus.Subscribe("recorder", recorder.Record)
+
+	enc := rpc.NewEncoder(&rpc.Config{
+		DB:         postgrestest.NewDB(),
+		MQTTClient: mqtttest.NewClient(),
+		Processor:  &recordingProcessor{},
+		Events:     bus,
+	}, kitlog.NewNopLogger())
+
+	resp, err := enc.CreateStream(context.Background(), &encoder.CreateStreamRequest{
+		DeviceToken:        "abc123",
+		DeviceLabel:        "my sensor",
+		RecipientPublicKey: publicKey,
+		CommunityId:        "smartcitizen",
+		Location: &encoder.CreateStreamRequest_Location{
+			Longitude: -0.024,
+			Latitude:  54.24,
+		},
+	})
+	assert.Nil(t, err)
+
+	_, err = enc.DeleteStream(context.Background(), &encoder.DeleteStreamRequest{
+		StreamUid: resp.StreamUid,
+		Token:     resp.Token,
+	})
+	assert.Nil(t, err)
+
+	// stopping the bus delivers every event published
+	assert.Nil(t, bus.Stop())
+
+	recent := recorder.Recent("", 0)
+	if assert.Len(t, recent, 3) {
+		assert.Equal(t, events.StreamDeleted, recent[0].Type)
+		assert.Equal(t, resp.StreamUid, recent[0].StreamID)
+
+		assert.Equal(t, events.StreamCreated, recent[1].Type)
+		assert.Equal(t, resp.StreamUid, recent[1].StreamID)
+		assert.Equal(t, "smartcitizen", recent[1].CommunityID)
+
+		assert.Equal(t, events.SubscriptionCreated, recent[2].Type)
+		assert.Equal(t, rpc.MQTTSource, recent[2].Source)
+	}
+}
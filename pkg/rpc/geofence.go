@@ -0,0 +1,44 @@
+package rpc
+
+import (
+	"context"
+
+	"github.com/twitchtv/twirp"
+
+	"github.com/DECODEproject/iotencoder/pkg/geofence"
+)
+
+// GeofenceHeader is the HTTP header with which a client creating a stream for
+// a mobile device may have readings reported from outside a fence dropped, e.g.
+// "circle=41.3851,2.1734,2km".
+const GeofenceHeader = "Geofence"
+
+// WithGeofence returns a copy of the context carrying the given geofence, which
+// is validated by CreateStream and saved with the new stream.
+func WithGeofence(ctx context.Context, spec string) context.Context {
+	opts := StreamOptionsFrom(ctx)
+	opts.Geofence = spec
+
+	return WithStreamOptions(ctx, opts)
+}
+
+// Geofence returns the geofence carried by the context, or an empty string if
+// none was set.
+func Geofence(ctx context.Context) string {
+	return StreamOptionsFrom(ctx).Geofence
+}
+
+// validateGeofence returns an error if the given geofence is invalid. An empty
+// geofence is valid.
+func validateGeofence(spec string) error {
+	if spec == "" {
+		return nil
+	}
+
+	_, err := geofence.Parse(spec)
+	if err != nil {
+		return twirp.InvalidArgumentError("geofence", err.Error())
+	}
+
+	return nil
+}
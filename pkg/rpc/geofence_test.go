@@ -0,0 +1,51 @@
+package rpc_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/mqtt/mqtttest"
+	"github.com/DECODEproject/iotencoder/pkg/postgres/postgrestest"
+	"github.com/DECODEproject/iotencoder/pkg/rpc"
+)
+
+func TestStreamGeofence(t *testing.T) {
+	db := postgrestest.NewDB()
+
+	enc := rpc.NewEncoder(&rpc.Config{
+		DB:         db,
+		MQTTClient: mqtttest.NewClient(),
+		Processor:  &recordingProcessor{processed: make(map[string][][]byte)},
+	}, kitlog.NewNopLogger())
+
+	resp, err := enc.CreateStream(rpc.WithGeofence(context.Background(), "circle=41.3851,2.1734,2km"), newStreamRequest("community-1"))
+	assert.Nil(t, err)
+
+	stream, err := db.GetStream(resp.StreamUid, resp.Token)
+	assert.Nil(t, err)
+	assert.Equal(t, "circle=41.3851,2.1734,2km", stream.Geofence)
+
+	_, err = enc.CreateStream(rpc.WithGeofence(context.Background(), "square=41.3851,2.1734,2km"), newStreamRequest("community-2"))
+	assert.NotNil(t, err)
+	assert.Equal(t, "twirp error invalid_argument: geofence unknown geofence shape: square", err.Error())
+}
+
+func TestGeofenceHeader(t *testing.T) {
+	var spec string
+
+	h := rpc.StreamOptionsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		spec = rpc.Geofence(r.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Set(rpc.GeofenceHeader, "polygon=41.38,2.16,41.39,2.16,41.39,2.18")
+
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(t, "polygon=41.38,2.16,41.39,2.16,41.39,2.18", spec)
+}
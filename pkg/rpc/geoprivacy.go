@@ -0,0 +1,46 @@
+package rpc
+
+import (
+	"context"
+
+	"github.com/twitchtv/twirp"
+
+	"github.com/DECODEproject/iotencoder/pkg/geoprivacy"
+)
+
+// GeoPrivacyHeader is the HTTP header with which a client creating a stream
+// for a device in a sensitive deployment may protect the device's location,
+// snapping it to a grid of the given size and optionally suppressing it unless
+// k devices report from the same cell within a window, e.g.
+// "grid=500m,k=5,window=1h".
+const GeoPrivacyHeader = "Geo-Privacy"
+
+// WithGeoPrivacy returns a copy of the context carrying the given geo-privacy
+// spec, which is validated by CreateStream and saved with the new stream.
+func WithGeoPrivacy(ctx context.Context, spec string) context.Context {
+	opts := StreamOptionsFrom(ctx)
+	opts.GeoPrivacy = spec
+
+	return WithStreamOptions(ctx, opts)
+}
+
+// GeoPrivacy returns the geo-privacy spec carried by the context, or an empty
+// string if none was set.
+func GeoPrivacy(ctx context.Context) string {
+	return StreamOptionsFrom(ctx).GeoPrivacy
+}
+
+// validateGeoPrivacy returns an error if the given geo-privacy spec is
+// invalid. An empty spec is valid.
+func validateGeoPrivacy(spec string) error {
+	if spec == "" {
+		return nil
+	}
+
+	_, err := geoprivacy.Parse(spec)
+	if err != nil {
+		return twirp.InvalidArgumentError("geo_privacy", err.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,51 @@
+package rpc_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/mqtt/mqtttest"
+	"github.com/DECODEproject/iotencoder/pkg/postgres/postgrestest"
+	"github.com/DECODEproject/iotencoder/pkg/rpc"
+)
+
+func TestStreamGeoPrivacy(t *testing.T) {
+	db := postgrestest.NewDB()
+
+	enc := rpc.NewEncoder(&rpc.Config{
+		DB:         db,
+		MQTTClient: mqtttest.NewClient(),
+		Processor:  &recordingProcessor{processed: make(map[string][][]byte)},
+	}, kitlog.NewNopLogger())
+
+	resp, err := enc.CreateStream(rpc.WithGeoPrivacy(context.Background(), "grid=500m,k=5,window=1h"), newStreamRequest("community-1"))
+	assert.Nil(t, err)
+
+	stream, err := db.GetStream(resp.StreamUid, resp.Token)
+	assert.Nil(t, err)
+	assert.Equal(t, "grid=500m,k=5,window=1h", stream.GeoPrivacy)
+
+	_, err = enc.CreateStream(rpc.WithGeoPrivacy(context.Background(), "grid=500m,k=5"), newStreamRequest("community-2"))
+	assert.NotNil(t, err)
+	assert.Equal(t, "twirp error invalid_argument: geo_privacy geo-privacy k requires a window", err.Error())
+}
+
+func TestGeoPrivacyHeader(t *testing.T) {
+	var spec string
+
+	h := rpc.StreamOptionsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		spec = rpc.GeoPrivacy(r.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Set(rpc.GeoPrivacyHeader, "grid=1km")
+
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(t, "grid=1km", spec)
+}
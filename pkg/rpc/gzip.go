@@ -0,0 +1,163 @@
+package rpc
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// gzipMinSize is the size in bytes below which responses whose length is
+	// known are sent uncompressed, as compressing them gains little.
+	gzipMinSize = 1024
+
+	// gzipMaxRequestSize is the maximum size in bytes to which a gzip encoded
+	// request body may decompress, so that a small request cannot exhaust our
+	// memory.
+	gzipMaxRequestSize = 32 << 20
+)
+
+// gzipWriters is a pool of gzip writers reused between responses.
+var gzipWriters = sync.Pool{
+	New: func() interface{} {
+		return gzip.NewWriter(nil)
+	},
+}
+
+// GzipMiddleware is HTTP middleware which transparently decompresses request
+// bodies sent with a gzip Content-Encoding, and compresses responses with gzip
+// for clients which accept it. Responses which already have a Content-Encoding,
+// or whose Content-Length is below gzipMinSize, are sent unchanged.
+func GzipMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.EqualFold(r.Header.Get("Content-Encoding"), "gzip") {
+			body, err := gzip.NewReader(r.Body)
+			if err != nil {
+				http.Error(w, "invalid gzip request body", http.StatusBadRequest)
+				return
+			}
+			defer body.Close()
+
+			r.Body = http.MaxBytesReader(w, body, gzipMaxRequestSize)
+			r.Header.Del("Content-Encoding")
+			r.Header.Del("Content-Length")
+			r.ContentLength = -1
+		}
+
+		if !acceptsGzip(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.close()
+
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// acceptsGzip returns true if the request's Accept-Encoding header includes
+// gzip.
+func acceptsGzip(r *http.Request) bool {
+	for _, encoding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		// we ignore quality values other than an explicit refusal
+		parts := strings.Split(strings.TrimSpace(encoding), ";")
+		if !strings.EqualFold(strings.TrimSpace(parts[0]), "gzip") {
+			continue
+		}
+
+		if len(parts) > 1 && strings.Replace(parts[1], " ", "", -1) == "q=0" {
+			return false
+		}
+
+		return true
+	}
+
+	return false
+}
+
+// gzipResponseWriter is an http.ResponseWriter which compresses the response
+// body with gzip, deciding whether to do so when the header is written.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	wroteHeader bool
+}
+
+// WriteHeader decides whether to compress the response from its headers, then
+// writes the header.
+func (g *gzipResponseWriter) WriteHeader(code int) {
+	if g.wroteHeader {
+		return
+	}
+	g.wroteHeader = true
+
+	header := g.Header()
+	header.Add("Vary", "Accept-Encoding")
+
+	if g.compressible(code) {
+		header.Set("Content-Encoding", "gzip")
+		header.Del("Content-Length")
+
+		g.gz = gzipWriters.Get().(*gzip.Writer)
+		g.gz.Reset(g.ResponseWriter)
+	}
+
+	g.ResponseWriter.WriteHeader(code)
+}
+
+// Write writes the response body, compressing it if we decided to.
+func (g *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !g.wroteHeader {
+		g.WriteHeader(http.StatusOK)
+	}
+
+	if g.gz == nil {
+		return g.ResponseWriter.Write(b)
+	}
+
+	return g.gz.Write(b)
+}
+
+// Flush flushes any buffered compressed output to the client, so that
+// streamed responses such as exports are still received as they are written.
+func (g *gzipResponseWriter) Flush() {
+	if g.gz != nil {
+		g.gz.Flush()
+	}
+
+	if f, ok := g.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// compressible returns true if a response with the given status code and our
+// headers should be compressed.
+func (g *gzipResponseWriter) compressible(code int) bool {
+	header := g.Header()
+
+	if code == http.StatusNoContent || code == http.StatusNotModified || header.Get("Content-Encoding") != "" {
+		return false
+	}
+
+	if length, err := strconv.Atoi(header.Get("Content-Length")); err == nil && length < gzipMinSize {
+		return false
+	}
+
+	return true
+}
+
+// close flushes any compressed output, returning the gzip writer to our pool.
+func (g *gzipResponseWriter) close() {
+	if g.gz == nil {
+		return
+	}
+
+	g.gz.Close()
+	g.gz.Reset(ioutil.Discard)
+	gzipWriters.Put(g.gz)
+	g.gz = nil
+}
@@ -0,0 +1,99 @@
+package rpc_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/rpc"
+)
+
+func TestGzipMiddlewareResponse(t *testing.T) {
+	large := strings.Repeat("stream ", 1000)
+
+	h := rpc.GzipMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := large
+		if r.URL.Path == "/small" {
+			body = "ok"
+		}
+
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(body))
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/large", nil)
+	req.Header.Set("Accept-Encoding", "gzip, deflate")
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	assert.Equal(t, "gzip", rec.Header().Get("Content-Encoding"))
+	assert.Equal(t, "", rec.Header().Get("Content-Length"))
+	assert.True(t, rec.Body.Len() < len(large))
+
+	gz, err := gzip.NewReader(rec.Body)
+	assert.Nil(t, err)
+
+	b, err := ioutil.ReadAll(gz)
+	assert.Nil(t, err)
+	assert.Equal(t, large, string(b))
+
+	// small responses are sent uncompressed
+	req = httptest.NewRequest(http.MethodPost, "/small", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	assert.Equal(t, "", rec.Header().Get("Content-Encoding"))
+	assert.Equal(t, "ok", rec.Body.String())
+
+	// as are responses to clients which don't accept gzip
+	req = httptest.NewRequest(http.MethodPost, "/large", nil)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	assert.Equal(t, "", rec.Header().Get("Content-Encoding"))
+	assert.Equal(t, large, rec.Body.String())
+}
+
+func TestGzipMiddlewareRequest(t *testing.T) {
+	var received string
+
+	h := rpc.GzipMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		received = string(b)
+	}))
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(`{"device_token":"abc123"}`))
+	gz.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/", &buf)
+	req.Header.Set("Content-Encoding", "gzip")
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, `{"device_token":"abc123"}`, received)
+
+	// bodies which aren't valid gzip are rejected
+	req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader("not gzip"))
+	req.Header.Set("Content-Encoding", "gzip")
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
@@ -0,0 +1,50 @@
+package rpc_test
+
+import (
+	"context"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/twitchtv/twirp/ctxsetters"
+
+	"github.com/DECODEproject/iotencoder/pkg/rpc"
+)
+
+func TestServerHooks(t *testing.T) {
+	rpc.SetBuckets([]float64{0.01, 0.1, 1})
+	defer rpc.SetBuckets(nil)
+
+	hooks := rpc.NewServerHooks()
+
+	ctx, err := hooks.RequestReceived(context.Background())
+	assert.Nil(t, err)
+
+	ctx = ctxsetters.WithMethodName(ctx, "CreateStream")
+
+	ctx, err = hooks.RequestRouted(ctx)
+	assert.Nil(t, err)
+
+	ctx = ctxsetters.WithStatusCode(ctx, 200)
+
+	hooks.ResponseSent(ctx)
+
+	var metric dto.Metric
+
+	err = rpc.RequestsCounter.WithLabelValues("CreateStream").Write(&metric)
+	assert.Nil(t, err)
+	assert.Equal(t, float64(1), metric.GetCounter().GetValue())
+
+	err = rpc.ResponsesCounter.WithLabelValues("CreateStream", "200").Write(&metric)
+	assert.Nil(t, err)
+	assert.Equal(t, float64(1), metric.GetCounter().GetValue())
+
+	err = rpc.DurationHistogram.WithLabelValues("CreateStream", "200").Write(&metric)
+	assert.Nil(t, err)
+
+	histogram := metric.GetHistogram()
+	assert.Equal(t, uint64(1), histogram.GetSampleCount())
+	assert.Len(t, histogram.GetBucket(), 3)
+	assert.Equal(t, 0.01, histogram.GetBucket()[0].GetUpperBound())
+	assert.Equal(t, uint64(1), histogram.GetBucket()[0].GetCumulativeCount())
+}
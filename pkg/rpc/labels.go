@@ -0,0 +1,24 @@
+package rpc
+
+import "context"
+
+// LabelsHeader is the HTTP header with which a client creating a stream may
+// attach labels to it, as a comma separated list of key=value pairs, e.g.
+// "pilot=barcelona,sensor_kind=noise".
+const LabelsHeader = "Labels"
+
+// WithLabels returns a copy of the context carrying the given labels, in the
+// form parsed by labels.Parse, which are attached by CreateStream to the new
+// stream.
+func WithLabels(ctx context.Context, labels string) context.Context {
+	opts := StreamOptionsFrom(ctx)
+	opts.Labels = labels
+
+	return WithStreamOptions(ctx, opts)
+}
+
+// Labels returns the labels carried by the context, or an empty string if none
+// were set.
+func Labels(ctx context.Context) string {
+	return StreamOptionsFrom(ctx).Labels
+}
@@ -0,0 +1,52 @@
+package rpc_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/mqtt/mqtttest"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/postgres/postgrestest"
+	"github.com/DECODEproject/iotencoder/pkg/rpc"
+)
+
+func TestStreamLabels(t *testing.T) {
+	db := postgrestest.NewDB()
+
+	enc := rpc.NewEncoder(&rpc.Config{
+		DB:         db,
+		MQTTClient: mqtttest.NewClient(),
+		Processor:  &recordingProcessor{processed: make(map[string][][]byte)},
+	}, kitlog.NewNopLogger())
+
+	resp, err := enc.CreateStream(rpc.WithLabels(context.Background(), "pilot=barcelona,sensor_kind=noise"), newStreamRequest("community-1"))
+	assert.Nil(t, err)
+
+	stream, err := db.GetStream(resp.StreamUid, resp.Token)
+	assert.Nil(t, err)
+	assert.Equal(t, postgres.Labels{"pilot": "barcelona", "sensor_kind": "noise"}, stream.Labels)
+
+	_, err = enc.CreateStream(rpc.WithLabels(context.Background(), "sensor-kind=noise"), newStreamRequest("community-2"))
+	assert.NotNil(t, err)
+	assert.Equal(t, `twirp error invalid_argument: labels invalid label key "sensor-kind", must be letters, digits and underscores, not starting with a digit`, err.Error())
+}
+
+func TestLabelsHeader(t *testing.T) {
+	var labels string
+
+	h := rpc.StreamOptionsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		labels = rpc.Labels(r.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Set(rpc.LabelsHeader, "pilot=barcelona")
+
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(t, "pilot=barcelona", labels)
+}
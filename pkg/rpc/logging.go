@@ -0,0 +1,107 @@
+package rpc
+
+import (
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/DECODEproject/iotcommon/middleware"
+	kitlog "github.com/go-kit/kit/log"
+
+	"github.com/DECODEproject/iotencoder/pkg/audit"
+	"github.com/DECODEproject/iotencoder/pkg/logger/level"
+)
+
+// DefaultRequestLogSampleRate is the fraction of successful requests logged if
+// no sample rate is configured.
+const DefaultRequestLogSampleRate = 0.01
+
+// RequestLogger is HTTP middleware which logs the method, path, status,
+// duration and caller of requests. Every request which fails is logged, while
+// only a sample of those which succeed are, so that failures are always visible
+// without successful traffic flooding the logs.
+type RequestLogger struct {
+	logger     kitlog.Logger
+	sampleRate float64
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// NewRequestLogger returns a new RequestLogger logging the given fraction of
+// successful requests, between 0 for none and 1 for all of them.
+func NewRequestLogger(sampleRate float64, logger kitlog.Logger) *RequestLogger {
+	return &RequestLogger{
+		logger:     kitlog.With(logger, "module", "requests"),
+		sampleRate: sampleRate,
+		rand:       rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Middleware wraps the given handler, logging the requests it serves. The
+// caller is read from the context, so audit.CallerMiddleware must run first.
+func (l *RequestLogger) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(sw, r)
+
+		if sw.status < http.StatusBadRequest && !l.sample() {
+			return
+		}
+
+		logger := l.logger
+		switch {
+		case sw.status >= http.StatusInternalServerError:
+			logger = level.Error(logger)
+		case sw.status >= http.StatusBadRequest:
+			logger = level.Warn(logger)
+		default:
+			logger = level.Info(logger)
+		}
+
+		keyvals := []interface{}{
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", sw.status,
+			"duration", time.Since(start),
+			"caller", audit.Caller(r.Context()),
+		}
+
+		if rid, ok := r.Context().Value(middleware.RequestCtxKey).(string); ok {
+			keyvals = append(keyvals, "request_id", rid)
+		}
+
+		logger.Log(append(keyvals, "msg", "handled request")...)
+	})
+}
+
+// sample returns true if a successful request should be logged.
+func (l *RequestLogger) sample() bool {
+	if l.sampleRate <= 0 {
+		return false
+	}
+
+	if l.sampleRate >= 1 {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.rand.Float64() < l.sampleRate
+}
+
+// statusWriter wraps an http.ResponseWriter to record the status code written.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader records the status code before writing it.
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
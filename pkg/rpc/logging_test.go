@@ -0,0 +1,53 @@
+package rpc_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/audit"
+	"github.com/DECODEproject/iotencoder/pkg/rpc"
+)
+
+func TestRequestLogger(t *testing.T) {
+	testcases := []struct {
+		label      string
+		sampleRate float64
+		status     int
+		expected   string
+	}{
+		{"unsampled success", 0, http.StatusOK, ""},
+		{"sampled success", 1, http.StatusOK, "level=info"},
+		{"client error", 0, http.StatusBadRequest, "level=warn"},
+		{"server error", 0, http.StatusInternalServerError, "level=error"},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			logger := rpc.NewRequestLogger(tc.sampleRate, kitlog.NewLogfmtLogger(buf))
+
+			h := audit.CallerMiddleware(logger.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.status)
+			})))
+
+			req := httptest.NewRequest(http.MethodPost, "/twirp/decode.iot.encoder.Encoder/CreateStream", nil)
+			req.RemoteAddr = "10.0.0.1:1234"
+
+			h.ServeHTTP(httptest.NewRecorder(), req)
+
+			if tc.expected == "" {
+				assert.Empty(t, buf.String())
+				return
+			}
+
+			assert.Contains(t, buf.String(), tc.expected)
+			assert.Contains(t, buf.String(), "method=POST path=/twirp/decode.iot.encoder.Encoder/CreateStream")
+			assert.Contains(t, buf.String(), "caller=10.0.0.1")
+		})
+	}
+}
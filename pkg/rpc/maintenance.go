@@ -0,0 +1,49 @@
+package rpc
+
+import (
+	"sync/atomic"
+
+	"github.com/twitchtv/twirp"
+)
+
+// MaintenanceMessage is the message returned to clients attempting to create
+// or delete streams while maintenance mode is enabled.
+const MaintenanceMessage = "the encoder is in maintenance mode, streams cannot currently be created or deleted"
+
+// Maintenance holds whether the encoder is in maintenance mode. While enabled
+// requests to create or delete streams are rejected, but existing subscriptions
+// continue to be processed. It is safe for concurrent use, so maintenance mode
+// may be toggled while the service is running.
+type Maintenance struct {
+	enabled int32
+}
+
+// NewMaintenance returns a new Maintenance initially set to the given state.
+func NewMaintenance(enabled bool) *Maintenance {
+	m := &Maintenance{}
+	m.SetEnabled(enabled)
+	return m
+}
+
+// Enabled returns true if maintenance mode is currently enabled.
+func (m *Maintenance) Enabled() bool {
+	return atomic.LoadInt32(&m.enabled) == 1
+}
+
+// SetEnabled enables or disables maintenance mode.
+func (m *Maintenance) SetEnabled(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&m.enabled, v)
+}
+
+// checkMaintenance returns a twirp Unavailable error if maintenance mode is
+// enabled, or nil otherwise. A nil Maintenance is never enabled.
+func checkMaintenance(m *Maintenance) error {
+	if m != nil && m.Enabled() {
+		return twirp.NewError(twirp.Unavailable, MaintenanceMessage)
+	}
+	return nil
+}
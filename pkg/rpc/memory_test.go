@@ -0,0 +1,640 @@
+package rpc_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	encoder "github.com/thingful/twirp-encoder-go"
+
+	"github.com/DECODEproject/iotencoder/pkg/audit"
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/dedup"
+	"github.com/DECODEproject/iotencoder/pkg/logger"
+	"github.com/DECODEproject/iotencoder/pkg/logger/level"
+	"github.com/DECODEproject/iotencoder/pkg/mqtt/mqtttest"
+	"github.com/DECODEproject/iotencoder/pkg/partition"
+	"github.com/DECODEproject/iotencoder/pkg/pipeline"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/postgres/postgrestest"
+	"github.com/DECODEproject/iotencoder/pkg/rpc"
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+	"github.com/DECODEproject/iotencoder/pkg/system"
+)
+
+// recordingProcessor is a processor that records each processed payload.
+// If failures is set that many payloads fail to be processed before any is
+// recorded.
+type recordingProcessor struct {
+	sync.Mutex
+	processed map[string][][]byte
+	devices   []*postgres.Device
+	deadlines []time.Time
+	failures  int
+}
+
+func (r *recordingProcessor) Process(ctx context.Context, device *postgres.Device, payload []byte) error {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.failures > 0 {
+		r.failures--
+		return errors.New("processing failed")
+	}
+
+	r.processed[device.DeviceToken.Reveal()] = append(r.processed[device.DeviceToken.Reveal()], payload)
+	r.devices = append(r.devices, device)
+
+	if deadline, ok := ctx.Deadline(); ok {
+		r.deadlines = append(r.deadlines, deadline)
+	}
+
+	return nil
+}
+
+func (r *recordingProcessor) DryRun(ctx context.Context, stream *postgres.Stream) error {
+	return nil
+}
+
+func newInMemoryEncoder(messageTimeout time.Duration) (encoder.Encoder, *postgrestest.DB, *mqtttest.Client, *recordingProcessor) {
+	db := postgrestest.NewDB()
+	mqttClient := mqtttest.NewClient()
+	processor := &recordingProcessor{processed: make(map[string][][]byte)}
+
+	enc := rpc.NewEncoder(&rpc.Config{
+		DB:             db,
+		MQTTClient:     mqttClient,
+		Processor:      processor,
+		Retainer:       db,
+		BrokerAddr:     "tcp://mqtt.local:1883",
+		BrokerUsername: "decode",
+		MessageTimeout: messageTimeout,
+	}, kitlog.NewNopLogger())
+
+	return enc, db, mqttClient, processor
+}
+
+func TestInMemoryStreamLifecycle(t *testing.T) {
+	enc, db, mqttClient, processor := newInMemoryEncoder(0)
+
+	err := enc.(system.Startable).Start()
+	assert.Nil(t, err)
+	defer enc.(system.Stoppable).Stop()
+
+	resp, err := enc.CreateStream(context.Background(), &encoder.CreateStreamRequest{
+		DeviceToken:        "abc123",
+		DeviceLabel:        "my sensor",
+		RecipientPublicKey: publicKey,
+		CommunityId:        "policy-id",
+		Location: &encoder.CreateStreamRequest_Location{
+			Longitude: -0.024,
+			Latitude:  54.24,
+		},
+		Exposure: encoder.CreateStreamRequest_INDOOR,
+	})
+	assert.Nil(t, err)
+	assert.True(t, mqttClient.Subscribed("abc123"))
+
+	err = mqttClient.Deliver("abc123", []byte(`{"data":[]}`))
+	assert.Nil(t, err)
+
+	assert.Equal(t, [][]byte{[]byte(`{"data":[]}`)}, processor.processed["abc123"])
+
+	payloads, err := db.GetRawPayloads("abc123", time.Time{}, time.Now().Add(time.Minute), 0, 10)
+	assert.Nil(t, err)
+	assert.Len(t, payloads, 1)
+
+	_, err = enc.DeleteStream(context.Background(), &encoder.DeleteStreamRequest{
+		StreamUid: resp.StreamUid,
+		Token:     resp.Token,
+	})
+	assert.Nil(t, err)
+
+	assert.False(t, mqttClient.Subscribed("abc123"))
+
+	err = mqttClient.Deliver("abc123", []byte(`{"data":[]}`))
+	assert.NotNil(t, err)
+
+	_, err = db.GetDevice("abc123")
+	assert.NotNil(t, err)
+}
+
+func TestInMemorySubscriptionsCreatedOnStart(t *testing.T) {
+	enc, db, mqttClient, _ := newInMemoryEncoder(0)
+
+	for _, token := range []string{"foo", "bar"} {
+		_, err := db.CreateStream(&postgres.Stream{
+			PublicKey:   "abc123",
+			CommunityID: "policy-id",
+			Device: &postgres.Device{
+				DeviceToken: secret.Secret(token),
+				Longitude:   23,
+				Latitude:    23.2,
+				Exposure:    "indoor",
+			},
+		})
+		assert.Nil(t, err)
+	}
+
+	err := enc.(system.Startable).Start()
+	assert.Nil(t, err)
+	defer enc.(system.Stoppable).Stop()
+
+	assert.Equal(t, 2, mqttClient.Subscriptions())
+	assert.True(t, mqttClient.Subscribed("foo"))
+	assert.True(t, mqttClient.Subscribed("bar"))
+}
+
+func TestInMemoryReload(t *testing.T) {
+	enc, db, mqttClient, _ := newInMemoryEncoder(0)
+
+	err := enc.(system.Startable).Start()
+	assert.Nil(t, err)
+	defer enc.(system.Stoppable).Stop()
+
+	reloader := enc.(interface {
+		Reload(deviceToken secret.Secret) error
+	})
+
+	// a stream created other than through the encoder is only subscribed to
+	// once its device is reloaded
+	stream, err := db.CreateStream(&postgres.Stream{
+		PublicKey:   "abc123",
+		CommunityID: "policy-id",
+		Device: &postgres.Device{
+			DeviceToken: "foo",
+			Longitude:   23,
+			Latitude:    23.2,
+			Exposure:    "indoor",
+		},
+	})
+	assert.Nil(t, err)
+	assert.False(t, mqttClient.Subscribed("foo"))
+
+	err = reloader.Reload("foo")
+	assert.Nil(t, err)
+	assert.True(t, mqttClient.Subscribed("foo"))
+
+	// reloading is idempotent
+	err = reloader.Reload("foo")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, mqttClient.Subscriptions())
+
+	// as is deleting its last stream
+	_, err = db.DeleteStream(stream)
+	assert.Nil(t, err)
+	assert.True(t, mqttClient.Subscribed("foo"))
+
+	err = reloader.Reload("foo")
+	assert.Nil(t, err)
+	assert.False(t, mqttClient.Subscribed("foo"))
+}
+
+func TestInMemoryAudit(t *testing.T) {
+	db := postgrestest.NewDB()
+	mqttClient := mqtttest.NewClient()
+
+	enc := rpc.NewEncoder(&rpc.Config{
+		DB:         db,
+		MQTTClient: mqttClient,
+		Processor:  &recordingProcessor{processed: make(map[string][][]byte)},
+		Auditor: audit.NewRecorder(&audit.Config{
+			Store: db,
+			Clock: clock.New(),
+		}, kitlog.NewNopLogger()),
+	}, kitlog.NewNopLogger())
+
+	ctx := audit.WithCaller(context.Background(), "10.0.0.1")
+
+	resp, err := enc.CreateStream(ctx, &encoder.CreateStreamRequest{
+		DeviceToken:        "abc123",
+		DeviceLabel:        "my sensor",
+		RecipientPublicKey: publicKey,
+		CommunityId:        "policy-id",
+		Location: &encoder.CreateStreamRequest_Location{
+			Longitude: -0.024,
+			Latitude:  54.24,
+		},
+	})
+	assert.Nil(t, err)
+
+	_, err = enc.DeleteStream(ctx, &encoder.DeleteStreamRequest{
+		StreamUid: resp.StreamUid,
+		Token:     "wrong",
+	})
+	assert.NotNil(t, err)
+
+	entries, err := db.GetAuditEntries(resp.StreamUid, time.Time{}, time.Now().Add(time.Minute), 0, 10)
+	assert.Nil(t, err)
+	assert.Len(t, entries, 2)
+
+	assert.Equal(t, "CreateStream", entries[0].Method)
+	assert.Equal(t, "10.0.0.1", entries[0].Caller)
+	assert.Equal(t, audit.ResultOK, entries[0].Result)
+	assert.Equal(t, audit.Redacted, entries[0].Params["device_token"])
+	assert.Equal(t, "policy-id", entries[0].Params["community_id"])
+
+	assert.Equal(t, "DeleteStream", entries[1].Method)
+	assert.Equal(t, "internal", entries[1].Result)
+	assert.Equal(t, audit.Redacted, entries[1].Params["token"])
+	assert.True(t, entries[1].Error.Valid)
+}
+
+func TestInMemoryDeleteStreamInvalidToken(t *testing.T) {
+	enc, _, mqttClient, _ := newInMemoryEncoder(0)
+
+	resp, err := enc.CreateStream(context.Background(), &encoder.CreateStreamRequest{
+		DeviceToken:        "abc123",
+		DeviceLabel:        "my sensor",
+		RecipientPublicKey: publicKey,
+		CommunityId:        "policy-id",
+		Location: &encoder.CreateStreamRequest_Location{
+			Longitude: -0.024,
+			Latitude:  54.24,
+		},
+	})
+	assert.Nil(t, err)
+
+	_, err = enc.DeleteStream(context.Background(), &encoder.DeleteStreamRequest{
+		StreamUid: resp.StreamUid,
+		Token:     "wrong",
+	})
+	assert.NotNil(t, err)
+	assert.Equal(t, "twirp error internal: failed to delete stream: sql: no rows in result set", err.Error())
+	assert.True(t, mqttClient.Subscribed("abc123"))
+}
+
+func TestInMemoryMessageDeadline(t *testing.T) {
+	enc, db, mqttClient, processor := newInMemoryEncoder(time.Minute)
+
+	_, err := db.CreateStream(&postgres.Stream{
+		PublicKey:   "abc123",
+		CommunityID: "policy-id",
+		Device: &postgres.Device{
+			DeviceToken: "foo",
+			Longitude:   23,
+			Latitude:    23.2,
+			Exposure:    "indoor",
+		},
+	})
+	assert.Nil(t, err)
+
+	err = enc.(system.Startable).Start()
+	assert.Nil(t, err)
+
+	err = mqttClient.Deliver("foo", []byte(`{"data":[]}`))
+	assert.Nil(t, err)
+
+	assert.Len(t, processor.deadlines, 1)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), processor.deadlines[0], time.Second)
+
+	// messages received after the encoder is stopped are dropped
+	err = enc.(system.Stoppable).Stop()
+	assert.Nil(t, err)
+
+	err = mqttClient.Deliver("foo", []byte(`{"data":[]}`))
+	assert.Nil(t, err)
+
+	assert.Len(t, processor.processed["foo"], 1)
+}
+
+func TestInMemoryLogsRedactSecrets(t *testing.T) {
+	var buf bytes.Buffer
+
+	l, _, err := logger.New(&logger.Config{
+		Format: logger.FormatLogfmt,
+		Level:  level.DebugLevel,
+		Output: &buf,
+	})
+	assert.Nil(t, err)
+
+	db := postgrestest.NewDB()
+	mqttClient := mqtttest.NewClient()
+	processor := &recordingProcessor{processed: make(map[string][][]byte)}
+
+	enc := rpc.NewEncoder(&rpc.Config{
+		DB:             db,
+		MQTTClient:     mqttClient,
+		Processor:      processor,
+		Retainer:       db,
+		BrokerAddr:     "tcp://mqtt.local:1883",
+		BrokerUsername: "decode",
+		Verbose:        true,
+	}, l)
+
+	err = enc.(system.Startable).Start()
+	assert.Nil(t, err)
+
+	resp, err := enc.CreateStream(context.Background(), &encoder.CreateStreamRequest{
+		DeviceToken:        "abc123",
+		DeviceLabel:        "my sensor",
+		RecipientPublicKey: publicKey,
+		CommunityId:        "policy-id",
+		Location: &encoder.CreateStreamRequest_Location{
+			Longitude: -0.024,
+			Latitude:  54.24,
+		},
+	})
+	assert.Nil(t, err)
+
+	err = mqttClient.Deliver("abc123", []byte(`{"data":[]}`))
+	assert.Nil(t, err)
+
+	_, err = enc.DeleteStream(context.Background(), &encoder.DeleteStreamRequest{
+		StreamUid: resp.StreamUid,
+		Token:     resp.Token,
+	})
+	assert.Nil(t, err)
+
+	err = enc.(system.Stoppable).Stop()
+	assert.Nil(t, err)
+
+	assert.NotEmpty(t, buf.String())
+	assert.Contains(t, buf.String(), logger.HashToken("abc123"))
+	assert.NotContains(t, buf.String(), "abc123")
+	assert.NotContains(t, buf.String(), resp.Token)
+}
+
+func TestInMemoryMaintenance(t *testing.T) {
+	db := postgrestest.NewDB()
+	mqttClient := mqtttest.NewClient()
+	processor := &recordingProcessor{processed: make(map[string][][]byte)}
+	maintenance := rpc.NewMaintenance(false)
+
+	enc := rpc.NewEncoder(&rpc.Config{
+		DB:             db,
+		MQTTClient:     mqttClient,
+		Processor:      processor,
+		BrokerAddr:     "tcp://mqtt.local:1883",
+		BrokerUsername: "decode",
+		Maintenance:    maintenance,
+	}, kitlog.NewNopLogger())
+
+	req := &encoder.CreateStreamRequest{
+		DeviceToken:        "abc123",
+		DeviceLabel:        "my sensor",
+		RecipientPublicKey: publicKey,
+		CommunityId:        "policy-id",
+		Location: &encoder.CreateStreamRequest_Location{
+			Longitude: -0.024,
+			Latitude:  54.24,
+		},
+	}
+
+	resp, err := enc.CreateStream(context.Background(), req)
+	assert.Nil(t, err)
+
+	maintenance.SetEnabled(true)
+
+	_, err = enc.CreateStream(context.Background(), req)
+	assert.NotNil(t, err)
+	assert.Equal(t, "twirp error unavailable: "+rpc.MaintenanceMessage, err.Error())
+
+	_, err = enc.DeleteStream(context.Background(), &encoder.DeleteStreamRequest{
+		StreamUid: resp.StreamUid,
+		Token:     resp.Token,
+	})
+	assert.NotNil(t, err)
+	assert.Equal(t, "twirp error unavailable: "+rpc.MaintenanceMessage, err.Error())
+
+	// existing subscriptions continue to be processed
+	err = mqttClient.Deliver("abc123", []byte(`{"data":[]}`))
+	assert.Nil(t, err)
+	assert.Len(t, processor.processed["abc123"], 1)
+
+	maintenance.SetEnabled(false)
+
+	_, err = enc.DeleteStream(context.Background(), &encoder.DeleteStreamRequest{
+		StreamUid: resp.StreamUid,
+		Token:     resp.Token,
+	})
+	assert.Nil(t, err)
+	assert.False(t, mqttClient.Subscribed("abc123"))
+}
+
+func TestInMemoryPartitioned(t *testing.T) {
+	db := postgrestest.NewDB()
+
+	newPartitionedEncoder := func(instanceID string) (encoder.Encoder, *mqtttest.Client) {
+		mqttClient := mqtttest.NewClient()
+
+		coordinator := partition.NewCoordinator(&partition.Config{
+			Store:      db,
+			InstanceID: instanceID,
+			TTL:        2 * time.Hour,
+			Interval:   time.Hour,
+		}, kitlog.NewNopLogger())
+
+		enc := rpc.NewEncoder(&rpc.Config{
+			DB:             db,
+			MQTTClient:     mqttClient,
+			Processor:      &recordingProcessor{processed: make(map[string][][]byte)},
+			BrokerAddr:     "tcp://mqtt.local:1883",
+			BrokerUsername: "decode",
+			Partitioner:    coordinator,
+		}, kitlog.NewNopLogger())
+
+		return enc, mqttClient
+	}
+
+	encA, mqttA := newPartitionedEncoder("instance-a")
+	err := encA.(system.Startable).Start()
+	assert.Nil(t, err)
+	defer encA.(system.Stoppable).Stop()
+
+	encB, mqttB := newPartitionedEncoder("instance-b")
+	err = encB.(system.Startable).Start()
+	assert.Nil(t, err)
+	defer encB.(system.Stoppable).Stop()
+
+	resp, err := encA.CreateStream(context.Background(), &encoder.CreateStreamRequest{
+		DeviceToken:        "abc123",
+		DeviceLabel:        "my sensor",
+		RecipientPublicKey: publicKey,
+		CommunityId:        "policy-id",
+		Location: &encoder.CreateStreamRequest_Location{
+			Longitude: -0.024,
+			Latitude:  54.24,
+		},
+	})
+	assert.Nil(t, err)
+
+	// only the instance which claimed the device subscribes
+	assert.True(t, mqttA.Subscribed("abc123"))
+	assert.False(t, mqttB.Subscribed("abc123"))
+	assert.Equal(t, "instance-a", db.LeaseHolder("abc123"))
+
+	// deleting via another instance releases the device
+	_, err = encB.DeleteStream(context.Background(), &encoder.DeleteStreamRequest{
+		StreamUid: resp.StreamUid,
+		Token:     resp.Token,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "", db.LeaseHolder("abc123"))
+}
+
+// stubDatastores is a DatastoreChecker which can reach only the given address,
+// and allows only addresses on the pilot domain.
+type stubDatastores struct {
+	reachable string
+}
+
+func (s *stubDatastores) Check(ctx context.Context, addr string) error {
+	if !strings.Contains(addr, ".pilot") {
+		return pipeline.ErrDatastoreNotAllowed
+	}
+	if addr != s.reachable {
+		return errors.New("connection refused")
+	}
+	return nil
+}
+
+func TestInMemoryStreamDatastore(t *testing.T) {
+	db := postgrestest.NewDB()
+
+	enc := rpc.NewEncoder(&rpc.Config{
+		DB:             db,
+		MQTTClient:     mqtttest.NewClient(),
+		Processor:      &recordingProcessor{processed: make(map[string][][]byte)},
+		BrokerAddr:     "tcp://mqtt.local:1883",
+		BrokerUsername: "decode",
+		Datastores:     &stubDatastores{reachable: "http://datastore.pilot:8080"},
+	}, kitlog.NewNopLogger())
+
+	req := &encoder.CreateStreamRequest{
+		DeviceToken:        "abc123",
+		DeviceLabel:        "my sensor",
+		RecipientPublicKey: publicKey,
+		CommunityId:        "policy-id",
+		Location: &encoder.CreateStreamRequest_Location{
+			Longitude: -0.024,
+			Latitude:  54.24,
+		},
+	}
+
+	_, err := enc.CreateStream(rpc.WithDatastoreAddr(context.Background(), "datastore.pilot"), req)
+	assert.NotNil(t, err)
+	assert.Equal(t, "twirp error invalid_argument: datastore_addr must be an http or https URL", err.Error())
+
+	_, err = enc.CreateStream(rpc.WithDatastoreAddr(context.Background(), "http://169.254.169.254/latest"), req)
+	assert.NotNil(t, err)
+	assert.Equal(t, "twirp error invalid_argument: datastore_addr is not on an allowed datastore host", err.Error())
+
+	_, err = enc.CreateStream(rpc.WithDatastoreAddr(context.Background(), "http://unreachable.pilot:8080"), req)
+	assert.NotNil(t, err)
+	assert.Equal(t, "twirp error invalid_argument: datastore_addr could not connect to datastore", err.Error())
+
+	resp, err := enc.CreateStream(rpc.WithDatastoreAddr(context.Background(), "http://datastore.pilot:8080"), req)
+	assert.Nil(t, err)
+
+	stream, err := db.GetStream(resp.StreamUid, resp.Token)
+	assert.Nil(t, err)
+	assert.Equal(t, "http://datastore.pilot:8080", stream.DatastoreAddr)
+
+	device, err := db.GetDevice(secret.Secret("abc123"))
+	assert.Nil(t, err)
+	assert.Len(t, device.Streams, 1)
+	assert.Equal(t, "http://datastore.pilot:8080", device.Streams[0].DatastoreAddr)
+}
+
+func TestInMemoryShareOperations(t *testing.T) {
+	enc, db, _, _ := newInMemoryEncoder(0)
+
+	resp, err := enc.CreateStream(context.Background(), &encoder.CreateStreamRequest{
+		DeviceToken:        "abc123",
+		DeviceLabel:        "my sensor",
+		RecipientPublicKey: publicKey,
+		CommunityId:        "policy-id",
+		Location: &encoder.CreateStreamRequest_Location{
+			Longitude: -0.024,
+			Latitude:  54.24,
+		},
+		Operations: []*encoder.CreateStreamRequest_Operation{
+			{SensorId: 12, Action: encoder.CreateStreamRequest_Operation_SHARE},
+			{SensorId: 14, Action: encoder.CreateStreamRequest_Operation_SHARE, Interval: 300},
+			{SensorId: 29, Action: encoder.CreateStreamRequest_Operation_SHARE, Bins: []float64{0.5}},
+		},
+	})
+	assert.Nil(t, err)
+
+	stream, err := db.GetStream(resp.StreamUid, resp.Token)
+	assert.Nil(t, err)
+	assert.Equal(t, postgres.Operations{
+		{SensorID: 12, Action: postgres.Share},
+		{SensorID: 14, Action: postgres.Downsample, Interval: 300},
+		{SensorID: 29, Action: postgres.Delta, Threshold: 0.5},
+	}, stream.Operations)
+}
+
+func TestInMemoryDuplicatesDropped(t *testing.T) {
+	db := postgrestest.NewDB()
+	mqttClient := mqtttest.NewClient()
+	processor := &recordingProcessor{processed: make(map[string][][]byte)}
+
+	enc := rpc.NewEncoder(&rpc.Config{
+		DB:         db,
+		MQTTClient: mqttClient,
+		Processor:  processor,
+		Deduplicator: dedup.NewStore(&dedup.Config{
+			TTL:   time.Minute,
+			Clock: clock.New(),
+		}, kitlog.NewNopLogger()),
+	}, kitlog.NewNopLogger())
+
+	err := enc.(system.Startable).Start()
+	assert.Nil(t, err)
+	defer enc.(system.Stoppable).Stop()
+
+	_, err = enc.CreateStream(context.Background(), newStreamRequest("policy-id"))
+	assert.Nil(t, err)
+
+	first := []byte(`{"data":[{"recorded_at":"2019-06-01T12:00:00Z","sensors":[]}]}`)
+	second := []byte(`{"data":[{"recorded_at":"2019-06-01T12:01:00Z","sensors":[]}]}`)
+
+	for _, payload := range [][]byte{first, first, second, first} {
+		err = mqttClient.Deliver("abc123", payload)
+		assert.Nil(t, err)
+	}
+
+	assert.Equal(t, [][]byte{first, second}, processor.processed["abc123"])
+}
+
+func TestInMemoryFailedMessageRedelivered(t *testing.T) {
+	db := postgrestest.NewDB()
+	mqttClient := mqtttest.NewClient()
+	processor := &recordingProcessor{processed: make(map[string][][]byte), failures: 1}
+
+	enc := rpc.NewEncoder(&rpc.Config{
+		DB:         db,
+		MQTTClient: mqttClient,
+		Processor:  processor,
+		Deduplicator: dedup.NewStore(&dedup.Config{
+			TTL:   time.Minute,
+			Clock: clock.New(),
+		}, kitlog.NewNopLogger()),
+	}, kitlog.NewNopLogger())
+
+	err := enc.(system.Startable).Start()
+	assert.Nil(t, err)
+	defer enc.(system.Stoppable).Stop()
+
+	_, err = enc.CreateStream(context.Background(), newStreamRequest("policy-id"))
+	assert.Nil(t, err)
+
+	payload := []byte(`{"data":[{"recorded_at":"2019-06-01T12:00:00Z","sensors":[]}]}`)
+
+	// the first delivery fails, so the redelivery is processed rather than
+	// dropped as a duplicate, after which further redeliveries are dropped
+	for i := 0; i < 3; i++ {
+		err = mqttClient.Deliver("abc123", payload)
+		assert.Nil(t, err)
+	}
+
+	assert.Equal(t, [][]byte{payload}, processor.processed["abc123"])
+}
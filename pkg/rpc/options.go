@@ -0,0 +1,89 @@
+package rpc
+
+import (
+	"context"
+	"net/http"
+)
+
+// StreamOptions holds the options with which a client configures a stream it
+// creates or deletes, sent in HTTP headers alongside the request. Each field
+// holds the unparsed value of one header, empty if the header was not sent.
+// The options are read from the context by the accessor for each, e.g. Labels.
+type StreamOptions struct {
+	DatastoreAddr    string
+	DatastoreTimeout string
+	Source           string
+	Compression      string
+	TimestampPolicy  string
+	PolicyID         string
+	Labels           string
+	Schema           string
+	Dispositions     string
+	Privacy          string
+	GeoPrivacy       string
+	RateLimit        string
+	Sampling         string
+	Schedule         string
+	Geofence         string
+	Archive          string
+	Priority         string
+	TopicTemplate    string
+	InitialReading   string
+	DeleteData       string
+}
+
+// streamOptionsKey is the context key under which the stream options of a
+// request are stored.
+const streamOptionsKey = contextKey("streamOptions")
+
+// WithStreamOptions returns a copy of the context carrying the given stream
+// options, replacing any it already carried.
+func WithStreamOptions(ctx context.Context, opts StreamOptions) context.Context {
+	return context.WithValue(ctx, streamOptionsKey, opts)
+}
+
+// StreamOptionsFrom returns the stream options carried by the context, or empty
+// options if none were set.
+func StreamOptionsFrom(ctx context.Context) StreamOptions {
+	opts, _ := ctx.Value(streamOptionsKey).(StreamOptions)
+	return opts
+}
+
+// parseStreamOptions returns the stream options sent in the given headers.
+func parseStreamOptions(h http.Header) StreamOptions {
+	return StreamOptions{
+		DatastoreAddr:    h.Get(DatastoreAddrHeader),
+		DatastoreTimeout: h.Get(DatastoreTimeoutHeader),
+		Source:           h.Get(SourceHeader),
+		Compression:      h.Get(CompressionHeader),
+		TimestampPolicy:  h.Get(TimestampPolicyHeader),
+		PolicyID:         h.Get(PolicyIDHeader),
+		Labels:           h.Get(LabelsHeader),
+		Schema:           h.Get(SchemaHeader),
+		Dispositions:     h.Get(DispositionsHeader),
+		Privacy:          h.Get(PrivacyHeader),
+		GeoPrivacy:       h.Get(GeoPrivacyHeader),
+		RateLimit:        h.Get(RateLimitHeader),
+		Sampling:         h.Get(SamplingHeader),
+		Schedule:         h.Get(ScheduleHeader),
+		Geofence:         h.Get(GeofenceHeader),
+		Archive:          h.Get(ArchiveHeader),
+		Priority:         h.Get(PriorityHeader),
+		TopicTemplate:    h.Get(TopicTemplateHeader),
+		InitialReading:   h.Get(InitialReadingHeader),
+		DeleteData:       h.Get(DeleteDataHeader),
+	}
+}
+
+// StreamOptionsMiddleware is HTTP middleware which parses the stream option
+// headers of incoming requests into the request context, where they may be
+// read by CreateStream and DeleteStream.
+func StreamOptionsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if opts := parseStreamOptions(r.Header); opts != (StreamOptions{}) {
+			r = r.WithContext(WithStreamOptions(r.Context(), opts))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,47 @@
+package rpc_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/rpc"
+)
+
+func TestStreamOptionsMiddleware(t *testing.T) {
+	var opts rpc.StreamOptions
+
+	h := rpc.StreamOptionsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		opts = rpc.StreamOptionsFrom(r.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Set(rpc.DatastoreAddrHeader, "http://datastore.pilot:8080")
+	req.Header.Set(rpc.LabelsHeader, "pilot=barcelona")
+
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(t, rpc.StreamOptions{
+		DatastoreAddr: "http://datastore.pilot:8080",
+		Labels:        "pilot=barcelona",
+	}, opts)
+}
+
+func TestStreamOptionsAccessors(t *testing.T) {
+	ctx := rpc.WithLabels(context.Background(), "pilot=barcelona")
+	ctx = rpc.WithSource(ctx, "ttn")
+
+	assert.Equal(t, "pilot=barcelona", rpc.Labels(ctx))
+	assert.Equal(t, "ttn", rpc.SourceName(ctx))
+	assert.Equal(t, "", rpc.Schema(ctx))
+
+	// setting an option leaves the options of the parent context unchanged
+	child := rpc.WithSchema(ctx, "air-quality@1")
+
+	assert.Equal(t, "air-quality@1", rpc.Schema(child))
+	assert.Equal(t, "pilot=barcelona", rpc.Labels(child))
+	assert.Equal(t, "", rpc.Schema(ctx))
+}
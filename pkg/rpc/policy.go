@@ -0,0 +1,80 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	encoder "github.com/thingful/twirp-encoder-go"
+	"github.com/twitchtv/twirp"
+
+	"github.com/DECODEproject/iotencoder/pkg/logger/level"
+	"github.com/DECODEproject/iotencoder/pkg/policystore"
+	"github.com/DECODEproject/iotencoder/pkg/pubkey"
+)
+
+// PolicyIDHeader is the HTTP header with which a client creating a stream may
+// reference a policy registered with the DECODE policy store in place of
+// passing the recipient public key, which is then resolved by the encoder.
+const PolicyIDHeader = "Policy-Id"
+
+// PolicyResolver is the interface we call to resolve the public key of a
+// policy. It is satisfied by the policystore.Client type.
+type PolicyResolver interface {
+	PublicKey(ctx context.Context, policyID string) (string, error)
+}
+
+// WithPolicyID returns a copy of the context carrying the given policy id, the
+// public key of which is used by CreateStream to encrypt the stream's data.
+func WithPolicyID(ctx context.Context, policyID string) context.Context {
+	opts := StreamOptionsFrom(ctx)
+	opts.PolicyID = policyID
+
+	return WithStreamOptions(ctx, opts)
+}
+
+// PolicyID returns the policy id carried by the context, or an empty string if
+// none was set.
+func PolicyID(ctx context.Context) string {
+	return StreamOptionsFrom(ctx).PolicyID
+}
+
+// resolvePolicy returns a copy of the request with the recipient public key of
+// the given policy, and the policy's id as the community id if none was given.
+// If no policy is referenced the request is returned unchanged.
+func (e *encoderImpl) resolvePolicy(ctx context.Context, req *encoder.CreateStreamRequest, policyID string) (*encoder.CreateStreamRequest, error) {
+	if policyID == "" {
+		return req, nil
+	}
+
+	if e.policies == nil {
+		return nil, twirp.InvalidArgumentError("policy_id", "policies are not supported")
+	}
+
+	if req.RecipientPublicKey != "" {
+		return nil, twirp.InvalidArgumentError("policy_id", "cannot be combined with recipient_public_key")
+	}
+
+	publicKey, err := e.policies.PublicKey(ctx, policyID)
+	if err != nil {
+		if errors.Cause(err) == policystore.ErrUnknownPolicy {
+			return nil, twirp.InvalidArgumentError("policy_id", "is not a registered policy")
+		}
+		level.Warn(e.logger).Log("err", err, "msg", "failed to resolve policy", "policyID", policyID)
+		return nil, twirp.InternalErrorWith(err)
+	}
+
+	err = pubkey.Validate(publicKey)
+	if err != nil {
+		return nil, twirp.InvalidArgumentError("policy_id", fmt.Sprintf("has an invalid public key: %s", err))
+	}
+
+	resolved := *req
+	resolved.RecipientPublicKey = publicKey
+
+	if resolved.CommunityId == "" {
+		resolved.CommunityId = policyID
+	}
+
+	return &resolved, nil
+}
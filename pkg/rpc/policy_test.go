@@ -0,0 +1,66 @@
+package rpc_test
+
+import (
+	"context"
+	"testing"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/mqtt/mqtttest"
+	"github.com/DECODEproject/iotencoder/pkg/policystore"
+	"github.com/DECODEproject/iotencoder/pkg/postgres/postgrestest"
+	"github.com/DECODEproject/iotencoder/pkg/rpc"
+)
+
+type policyResolver map[string]string
+
+func (p policyResolver) PublicKey(ctx context.Context, policyID string) (string, error) {
+	key, ok := p[policyID]
+	if !ok {
+		return "", policystore.ErrUnknownPolicy
+	}
+
+	return key, nil
+}
+
+func TestStreamPolicy(t *testing.T) {
+	db := postgrestest.NewDB()
+
+	enc := rpc.NewEncoder(&rpc.Config{
+		DB:         db,
+		MQTTClient: mqtttest.NewClient(),
+		Processor:  &recordingProcessor{processed: make(map[string][][]byte)},
+		Policies:   policyResolver{"policy-1": publicKey},
+	}, kitlog.NewNopLogger())
+
+	req := newStreamRequest("")
+	req.RecipientPublicKey = ""
+
+	resp, err := enc.CreateStream(rpc.WithPolicyID(context.Background(), "policy-1"), req)
+	assert.Nil(t, err)
+
+	stream, err := db.GetStream(resp.StreamUid, resp.Token)
+	assert.Nil(t, err)
+	assert.Equal(t, "policy-1", stream.PolicyID)
+	assert.Equal(t, "policy-1", stream.CommunityID)
+	assert.Equal(t, publicKey, stream.PublicKey)
+	assert.Equal(t, "438f592e355e39d9f6ba89fcbbd7eea154662742413a690b20a0c15c4480a65b", stream.PublicKeyFingerprint)
+
+	updated, err := db.SetPolicyPublicKey("policy-1", "rotated_key")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), updated)
+
+	stream, err = db.GetStream(resp.StreamUid, resp.Token)
+	assert.Nil(t, err)
+	assert.Equal(t, "rotated_key", stream.PublicKey)
+	assert.Equal(t, "", stream.PublicKeyFingerprint)
+
+	_, err = enc.CreateStream(rpc.WithPolicyID(context.Background(), "policy-2"), req)
+	assert.NotNil(t, err)
+	assert.Equal(t, "twirp error invalid_argument: policy_id is not a registered policy", err.Error())
+
+	_, err = enc.CreateStream(rpc.WithPolicyID(context.Background(), "policy-1"), newStreamRequest("community-1"))
+	assert.NotNil(t, err)
+	assert.Equal(t, "twirp error invalid_argument: policy_id cannot be combined with recipient_public_key", err.Error())
+}
@@ -0,0 +1,45 @@
+package rpc
+
+import (
+	"context"
+
+	"github.com/twitchtv/twirp"
+
+	"github.com/DECODEproject/iotencoder/pkg/shedding"
+)
+
+// PriorityHeader is the HTTP header with which a client creating a stream may
+// set its priority when the encoder is saturated and sheds load, one of low,
+// normal or high.
+const PriorityHeader = "Priority"
+
+// WithPriority returns a copy of the context carrying the given priority,
+// which is validated by CreateStream and saved with the new stream.
+func WithPriority(ctx context.Context, priority string) context.Context {
+	opts := StreamOptionsFrom(ctx)
+	opts.Priority = priority
+
+	return WithStreamOptions(ctx, opts)
+}
+
+// Priority returns the priority carried by the context, or an empty string if
+// none was set.
+func Priority(ctx context.Context) string {
+	return StreamOptionsFrom(ctx).Priority
+}
+
+// parsePriority validates the requested priority, returning the name under
+// which it is saved. An empty priority is saved as empty, so the stream has
+// normal priority.
+func parsePriority(s string) (string, error) {
+	if s == "" {
+		return "", nil
+	}
+
+	priority, err := shedding.Parse(s)
+	if err != nil {
+		return "", twirp.InvalidArgumentError("priority", err.Error())
+	}
+
+	return priority.String(), nil
+}
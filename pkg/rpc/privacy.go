@@ -0,0 +1,53 @@
+package rpc
+
+import (
+	"context"
+
+	"github.com/twitchtv/twirp"
+
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/privacy"
+)
+
+// PrivacyHeader is the HTTP header with which a client creating a stream may
+// inject differential privacy noise into the stream's moving averages, giving
+// the mechanism and its parameters, e.g.
+// "laplace:epsilon=0.5,budget=100,period=24h".
+const PrivacyHeader = "Privacy"
+
+// WithPrivacy returns a copy of the context carrying the given privacy spec,
+// which is validated by CreateStream and saved with the new stream.
+func WithPrivacy(ctx context.Context, spec string) context.Context {
+	opts := StreamOptionsFrom(ctx)
+	opts.Privacy = spec
+
+	return WithStreamOptions(ctx, opts)
+}
+
+// Privacy returns the privacy spec carried by the context, or an empty string
+// if none was set.
+func Privacy(ctx context.Context) string {
+	return StreamOptionsFrom(ctx).Privacy
+}
+
+// validatePrivacy returns an error if the given privacy spec is invalid, or
+// if the stream has no moving average into which noise may be injected. An
+// empty spec is valid.
+func validatePrivacy(spec string, operations postgres.Operations) error {
+	if spec == "" {
+		return nil
+	}
+
+	_, err := privacy.Parse(spec)
+	if err != nil {
+		return twirp.InvalidArgumentError("privacy", err.Error())
+	}
+
+	for _, op := range operations {
+		if op.Action == postgres.MovingAverage {
+			return nil
+		}
+	}
+
+	return twirp.InvalidArgumentError("privacy", "requires at least one moving average operation")
+}
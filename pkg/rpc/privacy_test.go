@@ -0,0 +1,89 @@
+package rpc_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	encoder "github.com/thingful/twirp-encoder-go"
+
+	"github.com/DECODEproject/iotencoder/pkg/mqtt/mqtttest"
+	"github.com/DECODEproject/iotencoder/pkg/postgres/postgrestest"
+	"github.com/DECODEproject/iotencoder/pkg/rpc"
+)
+
+func TestStreamPrivacy(t *testing.T) {
+	db := postgrestest.NewDB()
+
+	enc := rpc.NewEncoder(&rpc.Config{
+		DB:         db,
+		MQTTClient: mqtttest.NewClient(),
+		Processor:  &recordingProcessor{processed: make(map[string][][]byte)},
+	}, kitlog.NewNopLogger())
+
+	movingAverage := []*encoder.CreateStreamRequest_Operation{
+		{
+			SensorId: 12,
+			Action:   encoder.CreateStreamRequest_Operation_MOVING_AVG,
+			Interval: 900,
+		},
+	}
+
+	req := newStreamRequest("community-1")
+	req.Operations = movingAverage
+
+	resp, err := enc.CreateStream(rpc.WithPrivacy(context.Background(), "laplace:epsilon=0.5,budget=100,period=24h"), req)
+	assert.Nil(t, err)
+
+	stream, err := db.GetStream(resp.StreamUid, resp.Token)
+	assert.Nil(t, err)
+	assert.Equal(t, "laplace:epsilon=0.5,budget=100,period=24h", stream.Privacy)
+
+	testcases := []struct {
+		label         string
+		spec          string
+		operations    []*encoder.CreateStreamRequest_Operation
+		expectedError string
+	}{
+		{
+			label:         "invalid spec",
+			spec:          "laplace:epsilon=0",
+			operations:    movingAverage,
+			expectedError: `twirp error invalid_argument: privacy invalid privacy parameter "epsilon=0", must be a positive number`,
+		},
+		{
+			label:         "no moving average",
+			spec:          "laplace:epsilon=0.5",
+			expectedError: "twirp error invalid_argument: privacy requires at least one moving average operation",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			req := newStreamRequest("community-2")
+			req.Operations = tc.operations
+
+			_, err := enc.CreateStream(rpc.WithPrivacy(context.Background(), tc.spec), req)
+			assert.NotNil(t, err)
+			assert.Equal(t, tc.expectedError, err.Error())
+		})
+	}
+}
+
+func TestPrivacyHeader(t *testing.T) {
+	var spec string
+
+	h := rpc.StreamOptionsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		spec = rpc.Privacy(r.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Set(rpc.PrivacyHeader, "laplace:epsilon=0.5")
+
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(t, "laplace:epsilon=0.5", spec)
+}
@@ -0,0 +1,45 @@
+package rpc
+
+import (
+	"github.com/pkg/errors"
+	"github.com/twitchtv/twirp"
+)
+
+// StreamCounter is the interface of a DB which counts the live streams of a
+// community, against which the community's stream quota is checked. It is
+// satisfied by the postgres.DB type, and by the in-memory postgrestest.DB.
+type StreamCounter interface {
+	CountCommunityStreams(communityID string) (int, error)
+}
+
+// checkStreamQuota returns a twirp ResourceExhausted error if the given
+// community already has as many live streams as its quota allows. Quotas are
+// soft, as the streams counted may change before the new stream is saved, so
+// concurrent requests may take a community slightly over its quota.
+func (e *encoderImpl) checkStreamQuota(communityID string) error {
+	if e.quotas == nil {
+		return nil
+	}
+
+	limits := e.quotas.Limits(communityID)
+	if limits == nil || limits.Streams == 0 {
+		return nil
+	}
+
+	counter, ok := e.db.(StreamCounter)
+	if !ok {
+		return nil
+	}
+
+	streams, err := counter.CountCommunityStreams(communityID)
+	if err != nil {
+		return twirp.InternalErrorWith(errors.Wrap(err, "failed to check stream quota"))
+	}
+
+	err = e.quotas.AllowStream(communityID, streams)
+	if err != nil {
+		return twirp.NewError(twirp.ResourceExhausted, err.Error()).WithMeta("community_id", communityID)
+	}
+
+	return nil
+}
@@ -0,0 +1,54 @@
+package rpc_test
+
+import (
+	"context"
+	"testing"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	encoder "github.com/thingful/twirp-encoder-go"
+	"github.com/twitchtv/twirp"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/mqtt/mqtttest"
+	"github.com/DECODEproject/iotencoder/pkg/postgres/postgrestest"
+	"github.com/DECODEproject/iotencoder/pkg/quota"
+	"github.com/DECODEproject/iotencoder/pkg/rpc"
+)
+
+func TestStreamQuota(t *testing.T) {
+	enc := rpc.NewEncoder(&rpc.Config{
+		DB:         postgrestest.NewDB(),
+		MQTTClient: mqtttest.NewClient(),
+		Processor:  &recordingProcessor{},
+		Quotas: quota.NewQuotas(map[string]*quota.Limits{
+			"smartcitizen": {Streams: 1},
+		}, clock.New()),
+	}, kitlog.NewNopLogger())
+
+	create := func(deviceToken, communityID string) error {
+		_, err := enc.CreateStream(context.Background(), &encoder.CreateStreamRequest{
+			DeviceToken:        deviceToken,
+			DeviceLabel:        "my sensor",
+			RecipientPublicKey: publicKey,
+			CommunityId:        communityID,
+			Location: &encoder.CreateStreamRequest_Location{
+				Longitude: -0.024,
+				Latitude:  54.24,
+			},
+		})
+		return err
+	}
+
+	assert.Nil(t, create("abc123", "smartcitizen"))
+
+	err := create("def456", "smartcitizen")
+	if assert.NotNil(t, err) {
+		twerr := err.(twirp.Error)
+		assert.Equal(t, twirp.ResourceExhausted, twerr.Code())
+		assert.Equal(t, "smartcitizen", twerr.Meta("community_id"))
+	}
+
+	// other communities have no quota
+	assert.Nil(t, create("def456", "other"))
+}
@@ -0,0 +1,44 @@
+package rpc
+
+import (
+	"context"
+
+	"github.com/twitchtv/twirp"
+
+	"github.com/DECODEproject/iotencoder/pkg/throttle"
+)
+
+// RateLimitHeader is the HTTP header with which a client creating a stream for
+// a chatty device may limit the rate at which the stream's messages are
+// processed, e.g. "1/s" or "30/m,burst=5".
+const RateLimitHeader = "Rate-Limit"
+
+// WithRateLimit returns a copy of the context carrying the given rate limit,
+// which is validated by CreateStream and saved with the new stream.
+func WithRateLimit(ctx context.Context, spec string) context.Context {
+	opts := StreamOptionsFrom(ctx)
+	opts.RateLimit = spec
+
+	return WithStreamOptions(ctx, opts)
+}
+
+// RateLimit returns the rate limit carried by the context, or an empty string
+// if none was set.
+func RateLimit(ctx context.Context) string {
+	return StreamOptionsFrom(ctx).RateLimit
+}
+
+// validateRateLimit returns an error if the given rate limit is invalid. An
+// empty rate limit is valid.
+func validateRateLimit(spec string) error {
+	if spec == "" {
+		return nil
+	}
+
+	_, err := throttle.Parse(spec)
+	if err != nil {
+		return twirp.InvalidArgumentError("rate_limit", err.Error())
+	}
+
+	return nil
+}
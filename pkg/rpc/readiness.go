@@ -0,0 +1,80 @@
+package rpc
+
+import (
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// Readiness tracks the restoration of subscriptions to the devices of existing
+// streams as the encoder starts, and reports the encoder as ready once the
+// given fraction of those devices have been restored. It is safe for
+// concurrent use, so may be read by a health check while devices are being
+// restored.
+type Readiness struct {
+	threshold float64
+
+	started  int32
+	total    int64
+	restored int64
+}
+
+// NewReadiness returns a new Readiness which reports ready once the given
+// fraction, between 0 and 1, of devices have been restored.
+func NewReadiness(threshold float64) *Readiness {
+	return &Readiness{
+		threshold: threshold,
+	}
+}
+
+// Ready returns true once restoration has begun and at least the threshold
+// fraction of devices have been restored.
+func (r *Readiness) Ready() bool {
+	if atomic.LoadInt32(&r.started) == 0 {
+		return false
+	}
+
+	restored, total := r.Progress()
+	if total == 0 {
+		return true
+	}
+
+	return float64(restored) >= r.threshold*float64(total)
+}
+
+// Check returns an error describing the progress of restoration if the encoder
+// is not yet ready, so that a Readiness may be used as a system.Checkable.
+func (r *Readiness) Check() error {
+	if r.Ready() {
+		return nil
+	}
+
+	restored, total := r.Progress()
+	return errors.Errorf("restored %d of %d devices", restored, total)
+}
+
+// Progress returns the number of devices restored so far, and the total number
+// of devices to be restored.
+func (r *Readiness) Progress() (restored, total int) {
+	return int(atomic.LoadInt64(&r.restored)), int(atomic.LoadInt64(&r.total))
+}
+
+// begin records that restoration of the given number of devices has begun. A
+// nil Readiness is ignored.
+func (r *Readiness) begin(total int) {
+	if r == nil {
+		return
+	}
+
+	atomic.StoreInt64(&r.total, int64(total))
+	atomic.StoreInt32(&r.started, 1)
+}
+
+// add records that a device has been restored. A nil Readiness is ignored.
+func (r *Readiness) add() {
+	if r == nil {
+		return
+	}
+
+	atomic.AddInt64(&r.restored, 1)
+}
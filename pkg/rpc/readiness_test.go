@@ -0,0 +1,114 @@
+package rpc_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/mqtt/mqtttest"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/postgres/postgrestest"
+	"github.com/DECODEproject/iotencoder/pkg/rpc"
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+	"github.com/DECODEproject/iotencoder/pkg/system"
+)
+
+// failingSource is a Source to which every subscription fails.
+type failingSource struct{}
+
+func (f failingSource) Subscribe(deviceToken secret.Secret, callback func(payload []byte, metadata map[string]string, done func())) error {
+	return errors.New("subscription failed")
+}
+
+func (f failingSource) Unsubscribe(deviceToken secret.Secret) error {
+	return nil
+}
+
+func TestRestoreReadiness(t *testing.T) {
+	testcases := []struct {
+		label     string
+		threshold float64
+		ready     bool
+	}{
+		{
+			label:     "below threshold",
+			threshold: 1,
+			ready:     false,
+		},
+		{
+			label:     "above threshold",
+			threshold: 0.9,
+			ready:     true,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			db := postgrestest.NewDB()
+			mqttClient := mqtttest.NewClient()
+			readiness := rpc.NewReadiness(tc.threshold)
+
+			for i := 0; i < 20; i++ {
+				source := rpc.MQTTSource
+				if i == 0 {
+					source = "broken"
+				}
+
+				_, err := db.CreateStream(&postgres.Stream{
+					PublicKey:   "abc123",
+					CommunityID: "policy-id",
+					Source:      source,
+					Device: &postgres.Device{
+						DeviceToken: secret.Secret(fmt.Sprintf("device-%d", i)),
+						Longitude:   23,
+						Latitude:    23.2,
+						Exposure:    "indoor",
+					},
+				})
+				assert.Nil(t, err)
+			}
+
+			enc := rpc.NewEncoder(&rpc.Config{
+				DB:                 db,
+				MQTTClient:         mqttClient,
+				Processor:          &recordingProcessor{processed: make(map[string][][]byte)},
+				Sources:            map[string]rpc.Source{"broken": failingSource{}},
+				RestoreConcurrency: 4,
+				Readiness:          readiness,
+			}, kitlog.NewNopLogger())
+
+			assert.False(t, readiness.Ready())
+
+			err := enc.(system.Startable).Start()
+			assert.Nil(t, err)
+			defer enc.(system.Stoppable).Stop()
+
+			assert.Equal(t, 19, mqttClient.Subscriptions())
+
+			restored, total := readiness.Progress()
+			assert.Equal(t, 19, restored)
+			assert.Equal(t, 20, total)
+			assert.Equal(t, tc.ready, readiness.Ready())
+		})
+	}
+}
+
+func TestReadinessWithNoStreams(t *testing.T) {
+	readiness := rpc.NewReadiness(1)
+
+	enc := rpc.NewEncoder(&rpc.Config{
+		DB:         postgrestest.NewDB(),
+		MQTTClient: mqtttest.NewClient(),
+		Processor:  &recordingProcessor{processed: make(map[string][][]byte)},
+		Readiness:  readiness,
+	}, kitlog.NewNopLogger())
+
+	err := enc.(system.Startable).Start()
+	assert.Nil(t, err)
+	defer enc.(system.Stoppable).Stop()
+
+	assert.True(t, readiness.Ready())
+}
@@ -0,0 +1,40 @@
+package rpc
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/twitchtv/twirp"
+
+	"github.com/DECODEproject/iotencoder/pkg/logger/level"
+	"github.com/DECODEproject/iotencoder/pkg/registry"
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+)
+
+// DeviceVerifier is the interface we call to verify that a device is known to
+// an external device registry before a stream is created for it. Verify must
+// return registry.ErrUnknownDevice, or an error whose cause it is, for devices
+// the registry doesn't know. It is satisfied by the registry.Verifier type,
+// and may be implemented for other registries.
+type DeviceVerifier interface {
+	Verify(ctx context.Context, deviceToken secret.Secret) error
+}
+
+// verifyDevice returns a twirp error if we have a verifier and the device with
+// the given token is unknown to it, or it could not be asked.
+func (e *encoderImpl) verifyDevice(ctx context.Context, deviceToken secret.Secret) error {
+	if e.verifier == nil {
+		return nil
+	}
+
+	err := e.verifier.Verify(ctx, deviceToken)
+	if err != nil {
+		if errors.Cause(err) == registry.ErrUnknownDevice {
+			return twirp.NotFoundError("device_token is not registered")
+		}
+		level.Warn(e.logger).Log("err", err, "msg", "failed to verify device")
+		return twirp.InternalErrorWith(err)
+	}
+
+	return nil
+}
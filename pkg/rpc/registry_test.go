@@ -0,0 +1,57 @@
+package rpc_test
+
+import (
+	"context"
+	"testing"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/mqtt/mqtttest"
+	"github.com/DECODEproject/iotencoder/pkg/postgres/postgrestest"
+	"github.com/DECODEproject/iotencoder/pkg/registry"
+	"github.com/DECODEproject/iotencoder/pkg/rpc"
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+)
+
+type deviceVerifier map[secret.Secret]error
+
+func (d deviceVerifier) Verify(ctx context.Context, deviceToken secret.Secret) error {
+	return d[deviceToken]
+}
+
+func TestCreateStreamVerifiesDevice(t *testing.T) {
+	db := postgrestest.NewDB()
+	mqttClient := mqtttest.NewClient()
+
+	enc := rpc.NewEncoder(&rpc.Config{
+		DB:         db,
+		MQTTClient: mqttClient,
+		Processor:  &recordingProcessor{processed: make(map[string][][]byte)},
+		Verifier: deviceVerifier{
+			"unknown": errors.Wrap(registry.ErrUnknownDevice, "lookup failed"),
+			"broken":  errors.New("registry unavailable"),
+		},
+	}, kitlog.NewNopLogger())
+
+	_, err := enc.CreateStream(context.Background(), newStreamRequest("community-1"))
+	assert.Nil(t, err)
+
+	req := newStreamRequest("community-1")
+	req.DeviceToken = "unknown"
+
+	_, err = enc.CreateStream(context.Background(), req)
+	assert.NotNil(t, err)
+	assert.Equal(t, "twirp error not_found: device_token is not registered", err.Error())
+
+	req.DeviceToken = "broken"
+
+	_, err = enc.CreateStream(context.Background(), req)
+	assert.NotNil(t, err)
+	assert.Equal(t, "twirp error internal: registry unavailable", err.Error())
+
+	streams, err := db.ListStreams()
+	assert.Nil(t, err)
+	assert.Len(t, streams, 1)
+}
@@ -0,0 +1,135 @@
+package rpc
+
+import (
+	"context"
+	"strconv"
+	"sync"
+
+	"github.com/twitchtv/twirp"
+
+	"github.com/DECODEproject/iotencoder/pkg/mqtt"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+)
+
+// InitialReadingHeader is the HTTP header with which a client creating a
+// stream received over MQTT may have the message the broker retained for the
+// device's topic processed as the stream's first reading, by setting it to
+// "true", so that a current value is written to the datastore straight away
+// rather than once the device next publishes.
+const InitialReadingHeader = "Initial-Reading"
+
+// WithInitialReading returns a copy of the context carrying the given initial
+// reading setting, which is validated by CreateStream.
+func WithInitialReading(ctx context.Context, initial string) context.Context {
+	opts := StreamOptionsFrom(ctx)
+	opts.InitialReading = initial
+
+	return WithStreamOptions(ctx, opts)
+}
+
+// InitialReading returns the initial reading setting carried by the context,
+// or an empty string if none was set.
+func InitialReading(ctx context.Context) string {
+	return StreamOptionsFrom(ctx).InitialReading
+}
+
+// parseInitialReading parses the requested initial reading setting for the
+// given stream, returning an error if it is not a boolean, or if it was
+// requested for a stream not received over MQTT, as only MQTT brokers retain
+// messages. An empty setting is false.
+func (e *encoderImpl) parseInitialReading(s string, stream *postgres.Stream) (bool, error) {
+	if s == "" {
+		return false, nil
+	}
+
+	initial, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, twirp.InvalidArgumentError("initial_reading", "must be true or false")
+	}
+
+	if initial && e.sourceFor(stream) != MQTTSource {
+		return false, twirp.InvalidArgumentError("initial_reading", "may only be set for streams received over MQTT")
+	}
+
+	return initial, nil
+}
+
+// initialReadings records the streams awaiting the retained message of their
+// device's topic as their first reading, keyed by device token. Brokers
+// deliver the retained message again each time we subscribe to a device, so
+// only the streams which asked for it when created are given it, and only
+// once.
+type initialReadings struct {
+	sync.Mutex
+	byDevice map[string][]string
+}
+
+// expect records that the stream with the given id awaits the retained message
+// of the device with the given token.
+func (i *initialReadings) expect(deviceToken secret.Secret, streamID string) {
+	i.Lock()
+	defer i.Unlock()
+
+	if i.byDevice == nil {
+		i.byDevice = make(map[string][]string)
+	}
+
+	i.byDevice[deviceToken.Reveal()] = append(i.byDevice[deviceToken.Reveal()], streamID)
+}
+
+// take returns the ids of the streams awaiting the retained message of the
+// device with the given token, forgetting them. It is called for every message
+// from the device, as once the device has published its streams have a
+// current reading whether or not a retained message is delivered.
+func (i *initialReadings) take(deviceToken secret.Secret) []string {
+	i.Lock()
+	defer i.Unlock()
+
+	streamIDs := i.byDevice[deviceToken.Reveal()]
+	delete(i.byDevice, deviceToken.Reveal())
+
+	return streamIDs
+}
+
+// isRetained returns true if the metadata received with a message marks it as
+// the message retained by an MQTT broker, along with the metadata without the
+// marker.
+func isRetained(metadata map[string]string) (map[string]string, bool) {
+	if _, ok := metadata[mqtt.RetainedProperty]; !ok {
+		return metadata, false
+	}
+
+	rest := make(map[string]string, len(metadata)-1)
+	for k, v := range metadata {
+		if k != mqtt.RetainedProperty {
+			rest[k] = v
+		}
+	}
+
+	return rest, true
+}
+
+// initialStreams returns a copy of the device with only those of its streams
+// with the given ids, or nil if it has none of them.
+func initialStreams(device *postgres.Device, streamIDs []string) *postgres.Device {
+	var streams []*postgres.Stream
+
+	for _, stream := range device.Streams {
+		for _, id := range streamIDs {
+			if stream.StreamID == id {
+				streams = append(streams, stream)
+				break
+			}
+		}
+	}
+
+	if len(streams) == 0 {
+		return nil
+	}
+
+	d := *device
+	d.Streams = streams
+
+	return &d
+}
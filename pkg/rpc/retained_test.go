@@ -0,0 +1,51 @@
+package rpc_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/twitchtv/twirp"
+
+	"github.com/DECODEproject/iotencoder/pkg/rpc"
+)
+
+func TestInitialReading(t *testing.T) {
+	enc, _, mqttClient, processor := newInMemoryEncoder(0)
+
+	_, err := enc.CreateStream(context.Background(), newStreamRequest("community-1"))
+	assert.Nil(t, err)
+
+	// the retained message is ignored by streams which didn't ask for it
+	err = mqttClient.DeliverRetained("abc123", []byte("retained"))
+	assert.Nil(t, err)
+	assert.Len(t, processor.processed["abc123"], 0)
+
+	resp, err := enc.CreateStream(rpc.WithInitialReading(context.Background(), "true"), newStreamRequest("community-2"))
+	assert.Nil(t, err)
+
+	// and processed for those that did, once
+	err = mqttClient.DeliverRetained("abc123", []byte("retained"))
+	assert.Nil(t, err)
+	assert.Len(t, processor.processed["abc123"], 1)
+	assert.Len(t, processor.devices[0].Streams, 1)
+	assert.Equal(t, resp.StreamUid, processor.devices[0].Streams[0].StreamID)
+
+	err = mqttClient.DeliverRetained("abc123", []byte("retained"))
+	assert.Nil(t, err)
+	assert.Len(t, processor.processed["abc123"], 1)
+
+	// while messages the device publishes are processed for every stream
+	err = mqttClient.Deliver("abc123", []byte("reading"))
+	assert.Nil(t, err)
+	assert.Len(t, processor.processed["abc123"], 2)
+	assert.Len(t, processor.devices[1].Streams, 2)
+}
+
+func TestInitialReadingInvalid(t *testing.T) {
+	enc, _, _, _ := newInMemoryEncoder(0)
+
+	_, err := enc.CreateStream(rpc.WithInitialReading(context.Background(), "maybe"), newStreamRequest("community-1"))
+	assert.NotNil(t, err)
+	assert.Equal(t, twirp.InvalidArgument, err.(twirp.Error).Code())
+}
@@ -0,0 +1,44 @@
+package rpc
+
+import (
+	"context"
+
+	"github.com/twitchtv/twirp"
+
+	"github.com/DECODEproject/iotencoder/pkg/sampling"
+)
+
+// SamplingHeader is the HTTP header with which a client creating a stream for
+// a very high frequency device may have only a random percentage of its
+// messages written, e.g. "10%".
+const SamplingHeader = "Sampling"
+
+// WithSampling returns a copy of the context carrying the given sampling
+// percentage, which is validated by CreateStream and saved with the new stream.
+func WithSampling(ctx context.Context, spec string) context.Context {
+	opts := StreamOptionsFrom(ctx)
+	opts.Sampling = spec
+
+	return WithStreamOptions(ctx, opts)
+}
+
+// Sampling returns the sampling percentage carried by the context, or an empty
+// string if none was set.
+func Sampling(ctx context.Context) string {
+	return StreamOptionsFrom(ctx).Sampling
+}
+
+// validateSampling returns an error if the given sampling percentage is
+// invalid. An empty percentage is valid.
+func validateSampling(spec string) error {
+	if spec == "" {
+		return nil
+	}
+
+	_, err := sampling.Parse(spec)
+	if err != nil {
+		return twirp.InvalidArgumentError("sampling", err.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,44 @@
+package rpc
+
+import (
+	"context"
+
+	"github.com/twitchtv/twirp"
+
+	"github.com/DECODEproject/iotencoder/pkg/schedule"
+)
+
+// ScheduleHeader is the HTTP header with which a client creating a stream may
+// restrict the times of day at which its data is shared, e.g.
+// "08:00-22:00,tz=Europe/Madrid".
+const ScheduleHeader = "Schedule"
+
+// WithSchedule returns a copy of the context carrying the given schedule, which
+// is validated by CreateStream and saved with the new stream.
+func WithSchedule(ctx context.Context, spec string) context.Context {
+	opts := StreamOptionsFrom(ctx)
+	opts.Schedule = spec
+
+	return WithStreamOptions(ctx, opts)
+}
+
+// Schedule returns the schedule carried by the context, or an empty string if
+// none was set.
+func Schedule(ctx context.Context) string {
+	return StreamOptionsFrom(ctx).Schedule
+}
+
+// validateSchedule returns an error if the given schedule is invalid. An empty
+// schedule is valid.
+func validateSchedule(spec string) error {
+	if spec == "" {
+		return nil
+	}
+
+	_, err := schedule.Parse(spec)
+	if err != nil {
+		return twirp.InvalidArgumentError("schedule", err.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,74 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/twitchtv/twirp"
+
+	"github.com/DECODEproject/iotencoder/pkg/logger/level"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+)
+
+// SchemaHeader is the HTTP header with which a client creating a stream may
+// reference the payload schema describing the stream's data, either as
+// kind@version or just kind for the latest version of the kind.
+const SchemaHeader = "Schema"
+
+// SchemaResolver is the interface we call to resolve a reference to a payload
+// schema. It is satisfied by the schema.Registry type.
+type SchemaResolver interface {
+	Resolve(ref string) (*postgres.Schema, error)
+}
+
+// WithSchema returns a copy of the context carrying the given schema
+// reference, which is resolved by CreateStream and pinned to the new stream.
+func WithSchema(ctx context.Context, ref string) context.Context {
+	opts := StreamOptionsFrom(ctx)
+	opts.Schema = ref
+
+	return WithStreamOptions(ctx, opts)
+}
+
+// Schema returns the schema reference carried by the context, or an empty
+// string if none was set.
+func Schema(ctx context.Context) string {
+	return StreamOptionsFrom(ctx).Schema
+}
+
+// resolveSchema resolves the given schema reference, returning the reference
+// to the exact version resolved so that the stream keeps its schema when later
+// versions are registered. Each sensor shared by the stream must be declared
+// by the schema. If no schema is referenced an empty string is returned.
+func (e *encoderImpl) resolveSchema(ref string, operations postgres.Operations) (string, error) {
+	if ref == "" {
+		return "", nil
+	}
+
+	if e.schemas == nil {
+		return "", twirp.InvalidArgumentError("schema", "schemas are not supported")
+	}
+
+	_, _, err := postgres.ParseSchemaRef(ref)
+	if err != nil {
+		return "", twirp.InvalidArgumentError("schema", err.Error())
+	}
+
+	schema, err := e.schemas.Resolve(ref)
+	if err != nil {
+		if errors.Cause(err) == postgres.ErrSchemaNotFound {
+			return "", twirp.InvalidArgumentError("schema", "is not a registered schema")
+		}
+		level.Warn(e.logger).Log("err", err, "msg", "failed to resolve schema", "schema", ref)
+		return "", twirp.InternalErrorWith(err)
+	}
+
+	for _, operation := range operations {
+		if schema.Channels.Find(int(operation.SensorID)) == nil {
+			return "", twirp.InvalidArgumentError("operations", fmt.Sprintf("sensor %d is not declared by schema %s", operation.SensorID, schema.Ref()))
+		}
+	}
+
+	return schema.Ref(), nil
+}
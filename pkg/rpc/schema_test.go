@@ -0,0 +1,111 @@
+package rpc_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	encoder "github.com/thingful/twirp-encoder-go"
+
+	"github.com/DECODEproject/iotencoder/pkg/mqtt/mqtttest"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/postgres/postgrestest"
+	"github.com/DECODEproject/iotencoder/pkg/rpc"
+	"github.com/DECODEproject/iotencoder/pkg/schema"
+)
+
+func TestStreamSchema(t *testing.T) {
+	db := postgrestest.NewDB()
+
+	registry := schema.NewRegistry(&schema.Config{Store: db}, kitlog.NewNopLogger())
+
+	_, err := registry.Register("noise", postgres.Channels{
+		{SensorID: 13, Name: "humidity", Unit: "%", Type: postgres.NumberType},
+	})
+	assert.Nil(t, err)
+
+	enc := rpc.NewEncoder(&rpc.Config{
+		DB:         db,
+		MQTTClient: mqtttest.NewClient(),
+		Processor:  &recordingProcessor{processed: make(map[string][][]byte)},
+		Schemas:    registry,
+	}, kitlog.NewNopLogger())
+
+	// an unversioned reference is pinned to the latest version
+	resp, err := enc.CreateStream(rpc.WithSchema(context.Background(), "noise"), newStreamRequest("community-1"))
+	assert.Nil(t, err)
+
+	stream, err := db.GetStream(resp.StreamUid, resp.Token)
+	assert.Nil(t, err)
+	assert.Equal(t, "noise@1", stream.Schema)
+
+	testcases := []struct {
+		label         string
+		ref           string
+		operations    []*encoder.CreateStreamRequest_Operation
+		expectedError string
+	}{
+		{
+			label:         "unknown schema",
+			ref:           "noise@2",
+			expectedError: "twirp error invalid_argument: schema is not a registered schema",
+		},
+		{
+			label:         "invalid reference",
+			ref:           "noise@latest",
+			expectedError: `twirp error invalid_argument: schema invalid schema reference "noise@latest", version must be a positive integer`,
+		},
+		{
+			label: "undeclared sensor",
+			ref:   "noise@1",
+			operations: []*encoder.CreateStreamRequest_Operation{
+				{
+					SensorId: 14,
+					Action:   encoder.CreateStreamRequest_Operation_SHARE,
+				},
+			},
+			expectedError: "twirp error invalid_argument: operations sensor 14 is not declared by schema noise@1",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			req := newStreamRequest("community-2")
+			req.Operations = tc.operations
+
+			_, err := enc.CreateStream(rpc.WithSchema(context.Background(), tc.ref), req)
+			assert.NotNil(t, err)
+			assert.Equal(t, tc.expectedError, err.Error())
+		})
+	}
+}
+
+func TestStreamSchemaNotSupported(t *testing.T) {
+	enc := rpc.NewEncoder(&rpc.Config{
+		DB:         postgrestest.NewDB(),
+		MQTTClient: mqtttest.NewClient(),
+		Processor:  &recordingProcessor{processed: make(map[string][][]byte)},
+	}, kitlog.NewNopLogger())
+
+	_, err := enc.CreateStream(rpc.WithSchema(context.Background(), "noise"), newStreamRequest("community-1"))
+	assert.NotNil(t, err)
+	assert.Equal(t, "twirp error invalid_argument: schema schemas are not supported", err.Error())
+}
+
+func TestSchemaHeader(t *testing.T) {
+	var ref string
+
+	h := rpc.StreamOptionsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ref = rpc.Schema(r.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Set(rpc.SchemaHeader, "noise@2")
+
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(t, "noise@2", ref)
+}
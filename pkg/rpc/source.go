@@ -0,0 +1,71 @@
+package rpc
+
+import (
+	"context"
+
+	"github.com/DECODEproject/iotencoder/pkg/mqtt"
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+)
+
+// MQTTSource is the name of the source which receives readings by subscribing
+// to each device's topic on the configured MQTT broker. It is always available,
+// and is the default source unless another is configured.
+const MQTTSource = "mqtt"
+
+// AMQPSource is the name of the source which receives readings from an AMQP
+// broker, available if one is configured.
+const AMQPSource = "amqp"
+
+// KafkaSource is the name of the source which receives readings from Kafka
+// topics, available if brokers are configured.
+const KafkaSource = "kafka"
+
+// SourceHeader is the HTTP header with which a client creating a stream may
+// select the source from which the stream's readings are received.
+const SourceHeader = "Source"
+
+// Source is the interface of a transport from which device readings are
+// received. Subscriptions are made per device, and once subscribed the
+// callback is invoked with every payload received for the device until the
+// device is unsubscribed, along with any metadata the transport carried with
+// it, such as MQTT 5 user properties, and a function to call once the payload
+// has been processed, which lets transports which support it hold back further
+// payloads while too many are in flight. The MQTT client is wrapped to satisfy this
+// interface, and the amqp.Consumer and kafka.Consumer types satisfy it directly.
+type Source interface {
+	Subscribe(deviceToken secret.Secret, callback func(payload []byte, metadata map[string]string, done func())) error
+	Unsubscribe(deviceToken secret.Secret) error
+}
+
+// mqttSource adapts an mqtt.Client connected to a single broker into a Source.
+type mqttSource struct {
+	client   mqtt.Client
+	broker   string
+	username string
+	topics   topics
+}
+
+// Subscribe subscribes to the device's default readings topic on our broker.
+func (m *mqttSource) Subscribe(deviceToken secret.Secret, callback func(payload []byte, metadata map[string]string, done func())) error {
+	return m.SubscribeTopic(deviceToken, mqtt.Topic(deviceToken), callback)
+}
+
+// Unsubscribe removes the subscription to the device's readings topic.
+func (m *mqttSource) Unsubscribe(deviceToken secret.Secret) error {
+	return m.unsubscribeTopic(deviceToken, m.topics.remove(deviceToken))
+}
+
+// WithSource returns a copy of the context carrying the given source name,
+// which is used by CreateStream in place of the default source.
+func WithSource(ctx context.Context, source string) context.Context {
+	opts := StreamOptionsFrom(ctx)
+	opts.Source = source
+
+	return WithStreamOptions(ctx, opts)
+}
+
+// SourceName returns the source name carried by the context, or an empty string
+// if none was set.
+func SourceName(ctx context.Context) string {
+	return StreamOptionsFrom(ctx).Source
+}
@@ -0,0 +1,155 @@
+package rpc_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	encoder "github.com/thingful/twirp-encoder-go"
+
+	"github.com/DECODEproject/iotencoder/pkg/mqtt/mqtttest"
+	"github.com/DECODEproject/iotencoder/pkg/postgres/postgrestest"
+	"github.com/DECODEproject/iotencoder/pkg/rpc"
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+	"github.com/DECODEproject/iotencoder/pkg/system"
+)
+
+// fakeSource is an in-memory Source recording subscriptions by device token.
+type fakeSource struct {
+	sync.Mutex
+	callbacks map[string]func([]byte, map[string]string, func())
+}
+
+func (f *fakeSource) Subscribe(deviceToken secret.Secret, callback func(payload []byte, metadata map[string]string, done func())) error {
+	f.Lock()
+	defer f.Unlock()
+
+	f.callbacks[deviceToken.Reveal()] = callback
+	return nil
+}
+
+func (f *fakeSource) Unsubscribe(deviceToken secret.Secret) error {
+	f.Lock()
+	defer f.Unlock()
+
+	delete(f.callbacks, deviceToken.Reveal())
+	return nil
+}
+
+func (f *fakeSource) subscribed(deviceToken string) bool {
+	f.Lock()
+	defer f.Unlock()
+
+	_, ok := f.callbacks[deviceToken]
+	return ok
+}
+
+// publicKey is a valid community public key with which streams are created.
+const publicKey = `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`
+
+func newStreamRequest(communityID string) *encoder.CreateStreamRequest {
+	return &encoder.CreateStreamRequest{
+		DeviceToken:        "abc123",
+		DeviceLabel:        "my sensor",
+		RecipientPublicKey: publicKey,
+		CommunityId:        communityID,
+		Location: &encoder.CreateStreamRequest_Location{
+			Longitude: -0.024,
+			Latitude:  54.24,
+		},
+		Exposure: encoder.CreateStreamRequest_INDOOR,
+	}
+}
+
+func TestStreamSources(t *testing.T) {
+	db := postgrestest.NewDB()
+	mqttClient := mqtttest.NewClient()
+	amqp := &fakeSource{callbacks: map[string]func([]byte, map[string]string, func()){}}
+	processor := &recordingProcessor{processed: make(map[string][][]byte)}
+
+	enc := rpc.NewEncoder(&rpc.Config{
+		DB:         db,
+		MQTTClient: mqttClient,
+		Processor:  processor,
+		BrokerAddr: "tcp://mqtt.local:1883",
+		Sources:    map[string]rpc.Source{"amqp": amqp},
+	}, kitlog.NewNopLogger())
+
+	err := enc.(system.Startable).Start()
+	assert.Nil(t, err)
+	defer enc.(system.Stoppable).Stop()
+
+	amqpStream, err := enc.CreateStream(rpc.WithSource(context.Background(), "amqp"), newStreamRequest("community-1"))
+	assert.Nil(t, err)
+	assert.True(t, amqp.subscribed("abc123"))
+	assert.False(t, mqttClient.Subscribed("abc123"))
+
+	mqttStream, err := enc.CreateStream(context.Background(), newStreamRequest("community-2"))
+	assert.Nil(t, err)
+	assert.True(t, mqttClient.Subscribed("abc123"))
+
+	amqp.callbacks["abc123"]([]byte(`{"data":[]}`), nil, func() {})
+	assert.Len(t, processor.processed["abc123"], 1)
+
+	// deleting the only mqtt stream of the device leaves the amqp subscription
+	_, err = enc.DeleteStream(context.Background(), &encoder.DeleteStreamRequest{
+		StreamUid: mqttStream.StreamUid,
+		Token:     mqttStream.Token,
+	})
+	assert.Nil(t, err)
+	assert.False(t, mqttClient.Subscribed("abc123"))
+	assert.True(t, amqp.subscribed("abc123"))
+
+	_, err = enc.DeleteStream(context.Background(), &encoder.DeleteStreamRequest{
+		StreamUid: amqpStream.StreamUid,
+		Token:     amqpStream.Token,
+	})
+	assert.Nil(t, err)
+	assert.False(t, amqp.subscribed("abc123"))
+
+	_, err = enc.CreateStream(rpc.WithSource(context.Background(), "kafka"), newStreamRequest("community-3"))
+	assert.NotNil(t, err)
+	assert.Equal(t, "twirp error invalid_argument: source is not a configured source", err.Error())
+}
+
+func TestDefaultSource(t *testing.T) {
+	db := postgrestest.NewDB()
+	mqttClient := mqtttest.NewClient()
+	amqp := &fakeSource{callbacks: map[string]func([]byte, map[string]string, func()){}}
+
+	enc := rpc.NewEncoder(&rpc.Config{
+		DB:            db,
+		MQTTClient:    mqttClient,
+		Processor:     &recordingProcessor{processed: make(map[string][][]byte)},
+		Sources:       map[string]rpc.Source{"amqp": amqp},
+		DefaultSource: "amqp",
+	}, kitlog.NewNopLogger())
+
+	_, err := enc.CreateStream(context.Background(), newStreamRequest("community-4"))
+	assert.Nil(t, err)
+	assert.True(t, amqp.subscribed("abc123"))
+	assert.False(t, mqttClient.Subscribed("abc123"))
+
+	_, err = enc.CreateStream(rpc.WithSource(context.Background(), rpc.MQTTSource), newStreamRequest("community-5"))
+	assert.Nil(t, err)
+	assert.True(t, mqttClient.Subscribed("abc123"))
+}
+
+func TestSourceHeader(t *testing.T) {
+	var source string
+
+	h := rpc.StreamOptionsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		source = rpc.SourceName(r.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Set(rpc.SourceHeader, "amqp")
+
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(t, "amqp", source)
+}
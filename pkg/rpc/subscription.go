@@ -0,0 +1,144 @@
+package rpc
+
+import (
+	encoder "github.com/thingful/twirp-encoder-go"
+
+	"github.com/DECODEproject/iotencoder/pkg/labels"
+	"github.com/DECODEproject/iotencoder/pkg/mqtt"
+	"github.com/DECODEproject/iotencoder/pkg/pipeline"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+	"github.com/DECODEproject/iotencoder/pkg/timestamp"
+)
+
+// subscriber is the interface of sources able to describe the subscription they
+// make for a device. It is satisfied by the source wrapping the MQTT client.
+type subscriber interface {
+	Subscription(deviceToken secret.Secret) (topic string, qos byte)
+}
+
+// qosReporter is the interface of MQTT clients able to report the quality of
+// service at which they subscribe.
+type qosReporter interface {
+	QoS() byte
+}
+
+// Subscription returns the topic to which the device publishes its readings,
+// and the quality of service at which we subscribe to it.
+func (m *mqttSource) Subscription(deviceToken secret.Secret) (string, byte) {
+	var qos byte
+
+	if q, ok := m.client.(qosReporter); ok {
+		qos = q.QoS()
+	}
+
+	return mqtt.Topic(deviceToken), qos
+}
+
+// createStreamResponse returns the response to a request creating the given
+// stream, which holds the subscription details and the effective configuration
+// of the stream. The options set via request headers are returned keyed by the
+// header with which each is set, and those the caller didn't set are returned
+// with the values we use in their place.
+func (e *encoderImpl) createStreamResponse(stream *postgres.Stream) *encoder.CreateStreamResponse {
+	source := e.sourceFor(stream)
+
+	options := map[string]string{
+		TimestampPolicyHeader: stream.TimestampPolicy,
+	}
+
+	if stream.TimestampPolicy == "" {
+		options[TimestampPolicyHeader] = timestamp.Device
+	}
+
+	if stream.DatastoreAddr != "" {
+		options[DatastoreAddrHeader] = stream.DatastoreAddr
+	}
+
+	if stream.Compression != "" {
+		options[CompressionHeader] = stream.Compression
+	}
+
+	if stream.DatastoreTimeout != "" {
+		options[DatastoreTimeoutHeader] = stream.DatastoreTimeout
+	}
+
+	if stream.PolicyID != "" {
+		options[PolicyIDHeader] = stream.PolicyID
+	}
+
+	if len(stream.Labels) > 0 {
+		options[LabelsHeader] = labels.Format(stream.Labels)
+	}
+
+	if stream.Schema != "" {
+		options[SchemaHeader] = stream.Schema
+	}
+
+	if len(stream.Dispositions) > 0 {
+		options[DispositionsHeader] = stream.Dispositions.Format()
+	}
+
+	if stream.Privacy != "" {
+		options[PrivacyHeader] = stream.Privacy
+	}
+
+	if stream.GeoPrivacy != "" {
+		options[GeoPrivacyHeader] = stream.GeoPrivacy
+	}
+
+	if stream.RateLimit != "" {
+		options[RateLimitHeader] = stream.RateLimit
+	}
+
+	if stream.Sampling != "" {
+		options[SamplingHeader] = stream.Sampling
+	}
+
+	if stream.Schedule != "" {
+		options[ScheduleHeader] = stream.Schedule
+	}
+
+	if stream.Geofence != "" {
+		options[GeofenceHeader] = stream.Geofence
+	}
+
+	if stream.Archive {
+		options[ArchiveHeader] = "true"
+	}
+
+	if stream.Priority != "" {
+		options[PriorityHeader] = stream.Priority
+	}
+
+	if stream.Topic != "" {
+		options[TopicTemplateHeader] = stream.Topic
+	}
+
+	operations := []*encoder.CreateStreamRequest_Operation{}
+	for _, o := range stream.Operations {
+		operations = append(operations, streamOperation(o))
+	}
+
+	resp := &encoder.CreateStreamResponse{
+		StreamUid: stream.StreamID,
+		Token:     stream.Token.Reveal(),
+		Qos:       uint32(stream.QoS),
+		Config: &encoder.StreamConfig{
+			Source:               source,
+			ProcessingType:       pipeline.ProcessingType(stream),
+			PublicKeyFingerprint: stream.PublicKeyFingerprint,
+			Operations:           operations,
+			Options:              options,
+		},
+	}
+
+	if s, ok := e.sources[source].(subscriber); ok {
+		resp.Topic, _ = s.Subscription(stream.Device.DeviceToken)
+		if stream.Topic != "" {
+			resp.Topic = streamTopic(stream)
+		}
+	}
+
+	return resp
+}
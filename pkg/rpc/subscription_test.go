@@ -0,0 +1,80 @@
+package rpc_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	encoder "github.com/thingful/twirp-encoder-go"
+	"github.com/twitchtv/twirp"
+
+	"github.com/DECODEproject/iotencoder/pkg/mqtt/mqtttest"
+	"github.com/DECODEproject/iotencoder/pkg/postgres/postgrestest"
+	"github.com/DECODEproject/iotencoder/pkg/rpc"
+)
+
+func TestCreateStreamSubscription(t *testing.T) {
+	enc := rpc.NewEncoder(&rpc.Config{
+		DB:         postgrestest.NewDB(),
+		MQTTClient: mqtttest.NewClient(),
+		Processor:  &recordingProcessor{processed: make(map[string][][]byte)},
+		BrokerAddr: "tcp://mqtt.local:1883",
+	}, kitlog.NewNopLogger())
+
+	srv := httptest.NewServer(rpc.StreamOptionsMiddleware(encoder.NewEncoderServer(enc, nil)))
+	defer srv.Close()
+
+	client := encoder.NewEncoderJSONClient(srv.URL, http.DefaultClient)
+
+	header := http.Header{}
+	header.Set(rpc.CompressionHeader, "gzip")
+
+	ctx, err := twirp.WithHTTPRequestHeaders(context.Background(), header)
+	assert.Nil(t, err)
+
+	resp, err := client.CreateStream(ctx, &encoder.CreateStreamRequest{
+		DeviceToken:        "abc123",
+		DeviceLabel:        "my sensor",
+		RecipientPublicKey: "BBLewg4VqLR38b38daE7Fj/uhr543uGrEpyoPFgmFZK6EZ9g2XdK/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9/ifjE=",
+		CommunityId:        "community-1",
+		Location: &encoder.CreateStreamRequest_Location{
+			Longitude: -0.024,
+			Latitude:  54.24,
+		},
+		Exposure: encoder.CreateStreamRequest_INDOOR,
+		Operations: []*encoder.CreateStreamRequest_Operation{
+			{
+				SensorId: 12,
+				Processing: &encoder.CreateStreamRequest_Operation_Bin{
+					Bin: &encoder.Bin{Bins: []float64{10, 20}},
+				},
+			},
+		},
+	})
+	assert.Nil(t, err)
+
+	assert.NotEqual(t, "", resp.StreamUid)
+	assert.NotEqual(t, "", resp.Token)
+	assert.Equal(t, "device/sck/abc123/readings", resp.Topic)
+	assert.Equal(t, uint32(0), resp.Qos)
+	assert.Equal(t, &encoder.StreamConfig{
+		Source:               rpc.MQTTSource,
+		ProcessingType:       "bin",
+		PublicKeyFingerprint: "438f592e355e39d9f6ba89fcbbd7eea154662742413a690b20a0c15c4480a65b",
+		Operations: []*encoder.CreateStreamRequest_Operation{
+			{
+				SensorId: 12,
+				Processing: &encoder.CreateStreamRequest_Operation_Bin{
+					Bin: &encoder.Bin{Bins: []float64{10, 20}},
+				},
+			},
+		},
+		Options: map[string]string{
+			rpc.CompressionHeader:     "gzip",
+			rpc.TimestampPolicyHeader: "device",
+		},
+	}, resp.Config)
+}
@@ -0,0 +1,26 @@
+package rpc
+
+import "context"
+
+// TimestampPolicyHeader is the HTTP header with which a client creating a
+// stream may choose how the timestamps of the stream's readings are handled:
+// "device" to trust the device's timestamp, "server" to replace it with the
+// time the reading was received, or "reject:<duration>" to drop readings whose
+// timestamp differs from the time they were received by more than the given
+// duration.
+const TimestampPolicyHeader = "Timestamp-Policy"
+
+// WithTimestampPolicy returns a copy of the context carrying the given
+// timestamp policy, which is used by CreateStream for the stream's readings.
+func WithTimestampPolicy(ctx context.Context, policy string) context.Context {
+	opts := StreamOptionsFrom(ctx)
+	opts.TimestampPolicy = policy
+
+	return WithStreamOptions(ctx, opts)
+}
+
+// TimestampPolicy returns the timestamp policy carried by the context, or an
+// empty string if none was set.
+func TimestampPolicy(ctx context.Context) string {
+	return StreamOptionsFrom(ctx).TimestampPolicy
+}
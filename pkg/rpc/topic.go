@@ -0,0 +1,242 @@
+package rpc
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/twitchtv/twirp"
+
+	"github.com/DECODEproject/iotencoder/pkg/mqtt"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+)
+
+// TopicTemplateHeader is the HTTP header with which a client creating a stream
+// received over MQTT may set the topic on which its device publishes, for
+// firmware which doesn't publish on the default topic. The topic may contain
+// mqtt.TokenPlaceholder in place of the device token.
+const TopicTemplateHeader = "Topic-Template"
+
+// WithTopicTemplate returns a copy of the context carrying the given topic
+// template, which is validated by CreateStream and saved with the new stream.
+func WithTopicTemplate(ctx context.Context, template string) context.Context {
+	opts := StreamOptionsFrom(ctx)
+	opts.TopicTemplate = template
+
+	return WithStreamOptions(ctx, opts)
+}
+
+// TopicTemplate returns the topic template carried by the context, or an empty
+// string if none was set.
+func TopicTemplate(ctx context.Context) string {
+	return StreamOptionsFrom(ctx).TopicTemplate
+}
+
+// topicSource is the interface of sources able to subscribe to a device on a
+// topic other than its default topic. It is satisfied by the source wrapping
+// the MQTT client.
+type topicSource interface {
+	SubscribeTopic(deviceToken secret.Secret, topic string, callback func(payload []byte, metadata map[string]string, done func())) error
+}
+
+// topics records the topic to which each device is subscribed by the MQTT
+// source, so that it may be unsubscribed from the same topic, and the device
+// subscribed to each topic, so that no two devices share a topic.
+type topics struct {
+	sync.Mutex
+	byDevice map[string]string
+	byTopic  map[string]string
+}
+
+// set records that the device with the given token is subscribed to the given
+// topic, returning the topic to which it was previously subscribed, if any.
+func (t *topics) set(deviceToken secret.Secret, topic string) string {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.byDevice == nil {
+		t.byDevice = make(map[string]string)
+		t.byTopic = make(map[string]string)
+	}
+
+	previous := t.byDevice[deviceToken.Reveal()]
+	if previous != "" {
+		delete(t.byTopic, previous)
+	}
+
+	t.byDevice[deviceToken.Reveal()] = topic
+	t.byTopic[topic] = deviceToken.Reveal()
+
+	return previous
+}
+
+// remove forgets the subscription of the device with the given token,
+// returning the topic to which it was subscribed, or its default topic if we
+// have no record of it.
+func (t *topics) remove(deviceToken secret.Secret) string {
+	t.Lock()
+	defer t.Unlock()
+
+	topic, ok := t.byDevice[deviceToken.Reveal()]
+	if !ok {
+		return mqtt.Topic(deviceToken)
+	}
+
+	delete(t.byDevice, deviceToken.Reveal())
+	delete(t.byTopic, topic)
+
+	return topic
+}
+
+// owner returns the token of the device subscribed to the given topic, and
+// false if no device is.
+func (t *topics) owner(topic string) (secret.Secret, bool) {
+	t.Lock()
+	defer t.Unlock()
+
+	deviceToken, ok := t.byTopic[topic]
+	return secret.Secret(deviceToken), ok
+}
+
+// SubscribeTopic subscribes to the device's readings on the given topic on our
+// broker, replacing any subscription to the device on another topic.
+func (m *mqttSource) SubscribeTopic(deviceToken secret.Secret, topic string, callback func(payload []byte, metadata map[string]string, done func())) error {
+	cb := func(topic string, payload []byte, properties map[string]string, done func()) {
+		callback(payload, properties, done)
+	}
+
+	var err error
+
+	if topic == mqtt.Topic(deviceToken) {
+		err = m.client.Subscribe(m.broker, m.username, deviceToken, cb)
+	} else if s, ok := m.client.(mqtt.TopicSubscriber); ok {
+		err = s.SubscribeTopic(m.broker, m.username, topic, cb)
+	} else {
+		return errors.New("MQTT client does not support topic templates")
+	}
+
+	if err != nil {
+		return err
+	}
+
+	previous := m.topics.set(deviceToken, topic)
+	if previous == "" || previous == topic {
+		return nil
+	}
+
+	return m.unsubscribeTopic(deviceToken, previous)
+}
+
+// unsubscribeTopic removes the subscription to the device's readings on the
+// given topic.
+func (m *mqttSource) unsubscribeTopic(deviceToken secret.Secret, topic string) error {
+	if topic == mqtt.Topic(deviceToken) {
+		return m.client.Unsubscribe(m.broker, m.username, deviceToken)
+	}
+
+	s, ok := m.client.(mqtt.TopicSubscriber)
+	if !ok {
+		return errors.New("MQTT client does not support topic templates")
+	}
+
+	return s.UnsubscribeTopic(m.broker, m.username, topic)
+}
+
+// resolveTopicTemplate validates the topic template requested for a new
+// stream, returning the template saved with the stream. As we subscribe to
+// each device once, every stream of a device received over MQTT must share the
+// same topic, so a stream created without a template takes that of the
+// device's other streams, and one with a different template is refused, as is
+// a topic to which another device is subscribed. A template may only be set
+// for streams received over MQTT.
+func (e *encoderImpl) resolveTopicTemplate(template string, stream *postgres.Stream) (string, error) {
+	if template != "" {
+		err := mqtt.ValidateTopicTemplate(template)
+		if err != nil {
+			return "", twirp.InvalidArgumentError("topic_template", err.Error())
+		}
+
+		if e.sourceFor(stream) != MQTTSource {
+			return "", twirp.InvalidArgumentError("topic_template", "may only be set for streams received over MQTT")
+		}
+	}
+
+	if e.sourceFor(stream) != MQTTSource {
+		return "", nil
+	}
+
+	deviceToken := stream.Device.DeviceToken
+
+	device, err := e.db.GetDevice(deviceToken)
+	if err != nil && errors.Cause(err) != sql.ErrNoRows {
+		return "", twirp.InternalErrorWith(err)
+	}
+
+	if device != nil {
+		for _, s := range device.Streams {
+			if e.sourceFor(s) != MQTTSource {
+				continue
+			}
+
+			if template == "" {
+				return s.Topic, nil
+			}
+
+			if mqtt.ExpandTopic(s.Topic, deviceToken) != mqtt.ExpandTopic(template, deviceToken) {
+				return "", twirp.NewError(twirp.FailedPrecondition, "device already publishes to a different topic for its other streams")
+			}
+
+			return template, nil
+		}
+	}
+
+	if template == "" {
+		return "", nil
+	}
+
+	if source, ok := e.sources[MQTTSource].(*mqttSource); ok {
+		owner, ok := source.topics.owner(mqtt.ExpandTopic(template, deviceToken))
+		if ok && owner != deviceToken {
+			return "", twirp.NewError(twirp.AlreadyExists, "topic is already used by another device")
+		}
+	}
+
+	return template, nil
+}
+
+// deviceTopic returns the topic to which the given device publishes the
+// readings of its streams received over MQTT, or an empty string if it
+// publishes to its default topic. The device's streams are loaded if not
+// already present.
+func (e *encoderImpl) deviceTopic(device *postgres.Device) (string, error) {
+	streams := device.Streams
+
+	if len(streams) == 0 {
+		d, err := e.db.GetDevice(device.DeviceToken)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to load device streams")
+		}
+		streams = d.Streams
+	}
+
+	for _, stream := range streams {
+		if stream.Topic != "" && e.sourceFor(stream) == MQTTSource {
+			return mqtt.ExpandTopic(stream.Topic, device.DeviceToken), nil
+		}
+	}
+
+	return "", nil
+}
+
+// streamTopic returns the topic to which the device of the given stream
+// publishes its readings, or an empty string if it publishes to its default
+// topic.
+func streamTopic(stream *postgres.Stream) string {
+	if stream.Topic == "" {
+		return ""
+	}
+
+	return mqtt.ExpandTopic(stream.Topic, stream.Device.DeviceToken)
+}
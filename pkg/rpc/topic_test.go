@@ -0,0 +1,87 @@
+package rpc_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	encoder "github.com/thingful/twirp-encoder-go"
+	"github.com/twitchtv/twirp"
+
+	"github.com/DECODEproject/iotencoder/pkg/rpc"
+)
+
+func TestStreamTopicTemplate(t *testing.T) {
+	enc, db, mqttClient, processor := newInMemoryEncoder(0)
+
+	ctx := rpc.WithTopicTemplate(context.Background(), "sensors/{token}/up")
+
+	resp, err := enc.CreateStream(ctx, newStreamRequest("community-1"))
+	assert.Nil(t, err)
+
+	stream, err := db.GetStream(resp.StreamUid, resp.Token)
+	assert.Nil(t, err)
+	assert.Equal(t, "sensors/{token}/up", stream.Topic)
+
+	// the device is subscribed to on its own topic rather than the default
+	assert.True(t, mqttClient.SubscribedTopic("sensors/abc123/up"))
+	assert.False(t, mqttClient.Subscribed("abc123"))
+
+	err = mqttClient.DeliverTopic("sensors/abc123/up", []byte("reading"))
+	assert.Nil(t, err)
+	assert.Len(t, processor.processed["abc123"], 1)
+
+	// a further stream of the device takes the device's topic
+	resp2, err := enc.CreateStream(context.Background(), newStreamRequest("community-2"))
+	assert.Nil(t, err)
+
+	stream, err = db.GetStream(resp2.StreamUid, resp2.Token)
+	assert.Nil(t, err)
+	assert.Equal(t, "sensors/{token}/up", stream.Topic)
+
+	// but may not publish to a different one
+	_, err = enc.CreateStream(rpc.WithTopicTemplate(context.Background(), "other/{token}"), newStreamRequest("community-3"))
+	assert.NotNil(t, err)
+	assert.Equal(t, twirp.FailedPrecondition, err.(twirp.Error).Code())
+
+	// nor may another device publish to a topic already in use
+	req := newStreamRequest("community-1")
+	req.DeviceToken = "def456"
+
+	_, err = enc.CreateStream(rpc.WithTopicTemplate(context.Background(), "sensors/abc123/up"), req)
+	assert.NotNil(t, err)
+	assert.Equal(t, twirp.AlreadyExists, err.(twirp.Error).Code())
+
+	// once every stream is deleted the device is unsubscribed from its topic
+	for _, r := range []*encoder.CreateStreamResponse{resp, resp2} {
+		_, err = enc.DeleteStream(context.Background(), &encoder.DeleteStreamRequest{
+			StreamUid: r.StreamUid,
+			Token:     r.Token,
+		})
+		assert.Nil(t, err)
+	}
+
+	assert.False(t, mqttClient.SubscribedTopic("sensors/abc123/up"))
+}
+
+func TestStreamTopicTemplateInvalid(t *testing.T) {
+	enc, _, _, _ := newInMemoryEncoder(0)
+
+	testcases := []struct {
+		label    string
+		template string
+	}{
+		{"wildcard", "sensors/+/up"},
+		{"multi-level wildcard", "sensors/#"},
+		{"reserved", "$SYS/{token}"},
+		{"unknown placeholder", "sensors/{device}/up"},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			_, err := enc.CreateStream(rpc.WithTopicTemplate(context.Background(), tc.template), newStreamRequest("community-1"))
+			assert.NotNil(t, err)
+			assert.Equal(t, twirp.InvalidArgument, err.(twirp.Error).Code())
+		})
+	}
+}
@@ -0,0 +1,265 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	encoder "github.com/thingful/twirp-encoder-go"
+	"github.com/twitchtv/twirp"
+
+	"github.com/DECODEproject/iotencoder/pkg/processing"
+	"github.com/DECODEproject/iotencoder/pkg/pubkey"
+)
+
+const (
+	// maxDeviceTokenLength is the maximum length in bytes of a device token.
+	maxDeviceTokenLength = 255
+
+	// maxLabelLength is the maximum length in characters of a device label.
+	maxLabelLength = 255
+
+	// MaxWindow is the longest interval in seconds over which a moving average
+	// may be calculated, or to which readings may be downsampled, a week.
+	MaxWindow = 7 * 24 * 60 * 60
+
+	// MaxBins is the maximum number of bins into which a sensor's readings may
+	// be classified.
+	MaxBins = 100
+)
+
+// createRule is a rule which a CreateStreamRequest written against the given
+// API version must satisfy, returning an InvalidArgument twirp error naming
+// the offending field if it doesn't.
+type createRule func(ctx context.Context, version int, req *encoder.CreateStreamRequest) error
+
+// createRules are the rules checked for every CreateStreamRequest, in order.
+var createRules = []createRule{
+	validateDeviceToken,
+	validateDeviceLabel,
+	validateCommunityID,
+	validateRecipientPublicKey,
+	validateLocation,
+	validateOperations,
+}
+
+// apiVersionKey is the key under which the validator records the API version
+// of a request in its context, so that it is parsed only once.
+const apiVersionKey = contextKey("apiVersion")
+
+// validator wraps an encoder.Encoder, rejecting invalid requests with an
+// InvalidArgument twirp error before they reach it.
+type validator struct {
+	next encoder.Encoder
+}
+
+// NewValidator returns an encoder.Encoder which checks each request against
+// our validation rules before passing it to the given encoder, so that invalid
+// requests are rejected uniformly before any business logic runs, such as
+// checking for maintenance or resolving a policy. The validator is the only
+// place these rules are checked, so the encoder must only be served wrapped by
+// it.
+func NewValidator(next encoder.Encoder) encoder.Encoder {
+	return &validator{next: next}
+}
+
+// CreateStream validates the request, written against the API version it
+// declares, before passing it on.
+func (v *validator) CreateStream(ctx context.Context, req *encoder.CreateStreamRequest) (*encoder.CreateStreamResponse, error) {
+	version, err := parseAPIVersion(req.ApiVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateCreateRequest(ctx, version, req)
+	if err != nil {
+		return nil, err
+	}
+
+	return v.next.CreateStream(context.WithValue(ctx, apiVersionKey, version), req)
+}
+
+// DeleteStream validates the request before passing it on.
+func (v *validator) DeleteStream(ctx context.Context, req *encoder.DeleteStreamRequest) (*encoder.DeleteStreamResponse, error) {
+	version, err := parseAPIVersion(req.ApiVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateDeleteRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return v.next.DeleteStream(context.WithValue(ctx, apiVersionKey, version), req)
+}
+
+// requestVersion returns the API version of a request recorded by the
+// validator, or if the request did not pass through it the version parsed from
+// the one it declared.
+func requestVersion(ctx context.Context, v uint32) (int, error) {
+	if version, ok := ctx.Value(apiVersionKey).(int); ok {
+		return version, nil
+	}
+
+	return parseAPIVersion(v)
+}
+
+// validateCreateRequest checks the request against each of our rules,
+// returning the error of the first it fails, or nil if the request is valid.
+func validateCreateRequest(ctx context.Context, version int, req *encoder.CreateStreamRequest) error {
+	for _, rule := range createRules {
+		err := rule(ctx, version, req)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateDeleteRequest validates incoming deletion requests (we just check for
+// a stream uid and token)
+func validateDeleteRequest(req *encoder.DeleteStreamRequest) error {
+	if req.StreamUid == "" {
+		return twirp.RequiredArgumentError("stream_uid")
+	}
+
+	if req.Token == "" {
+		return twirp.RequiredArgumentError("token")
+	}
+
+	return nil
+}
+
+// validateDeviceToken requires a device token, which as it is interpolated
+// into the MQTT topic to which we subscribe may not contain the topic level
+// separator or wildcards, nor whitespace.
+func validateDeviceToken(ctx context.Context, version int, req *encoder.CreateStreamRequest) error {
+	if req.DeviceToken == "" {
+		return twirp.RequiredArgumentError("device_token")
+	}
+
+	if len(req.DeviceToken) > maxDeviceTokenLength {
+		return twirp.InvalidArgumentError("device_token", fmt.Sprintf("must be at most %d bytes", maxDeviceTokenLength))
+	}
+
+	if strings.ContainsAny(req.DeviceToken, "/+#{}") || strings.IndexFunc(req.DeviceToken, isSpaceOrControl) != -1 {
+		return twirp.InvalidArgumentError("device_token", "must not contain /, +, #, braces, whitespace or control characters")
+	}
+
+	return nil
+}
+
+// validateDeviceLabel requires a device label of bounded length.
+func validateDeviceLabel(ctx context.Context, version int, req *encoder.CreateStreamRequest) error {
+	if req.DeviceLabel == "" {
+		return twirp.RequiredArgumentError("device_label")
+	}
+
+	if utf8.RuneCountInString(req.DeviceLabel) > maxLabelLength {
+		return twirp.InvalidArgumentError("device_label", fmt.Sprintf("must be at most %d characters", maxLabelLength))
+	}
+
+	return nil
+}
+
+// validateCommunityID requires a community id, unless the request references
+// a policy whose id is then used in its place.
+func validateCommunityID(ctx context.Context, version int, req *encoder.CreateStreamRequest) error {
+	if req.CommunityId == "" && PolicyID(ctx) == "" {
+		return twirp.RequiredArgumentError("community_id")
+	}
+
+	return nil
+}
+
+// validateRecipientPublicKey requires a valid recipient public key, unless
+// the request references a policy whose key is then resolved in its place.
+func validateRecipientPublicKey(ctx context.Context, version int, req *encoder.CreateStreamRequest) error {
+	if req.RecipientPublicKey == "" {
+		if PolicyID(ctx) != "" {
+			return nil
+		}
+		return twirp.RequiredArgumentError("recipient_public_key")
+	}
+
+	err := pubkey.Validate(req.RecipientPublicKey)
+	if err != nil {
+		return twirp.InvalidArgumentError("recipient_public_key", err.Error())
+	}
+
+	return nil
+}
+
+// validateLocation requires a location with non-zero coordinates within
+// range.
+func validateLocation(ctx context.Context, version int, req *encoder.CreateStreamRequest) error {
+	if req.Location == nil {
+		return twirp.RequiredArgumentError("location")
+	}
+
+	if req.Location.Longitude == 0 {
+		return twirp.RequiredArgumentError("longitude")
+	}
+
+	if req.Location.Longitude < -180 || req.Location.Longitude > 180 {
+		return twirp.InvalidArgumentError("longitude", "must be between -180 and 180")
+	}
+
+	if req.Location.Latitude == 0 {
+		return twirp.RequiredArgumentError("latitude")
+	}
+
+	if req.Location.Latitude < -90 || req.Location.Latitude > 90 {
+		return twirp.InvalidArgumentError("latitude", "must be between -90 and 90")
+	}
+
+	return nil
+}
+
+// validateOperations requires each operation to name a sensor and either typed
+// processing or an action known in the API version, with an interval no longer
+// than MaxWindow and no more than MaxBins bins. Whether the interval and bins
+// suit the processing is checked as the operation is converted into typed
+// processing.
+func validateOperations(ctx context.Context, version int, req *encoder.CreateStreamRequest) error {
+	for _, op := range req.Operations {
+		if op.SensorId == 0 {
+			return twirp.InvalidArgumentError("operations", "require a non-zero sensor id")
+		}
+
+		switch op.Action {
+		case encoder.CreateStreamRequest_Operation_UNKNOWN:
+			if op.Processing == nil {
+				return twirp.InvalidArgumentError("operations", fmt.Sprintf("unknown action %s for sensor %d", op.Action, op.SensorId))
+			}
+		case encoder.CreateStreamRequest_Operation_SHARE, encoder.CreateStreamRequest_Operation_BIN, encoder.CreateStreamRequest_Operation_MOVING_AVG:
+		case encoder.CreateStreamRequest_Operation_DOWNSAMPLE, encoder.CreateStreamRequest_Operation_DELTA:
+			if version < APIVersion2 {
+				return twirp.InvalidArgumentError("operations", fmt.Sprintf("action %s requires api_version %d", op.Action, APIVersion2))
+			}
+		default:
+			return twirp.InvalidArgumentError("operations", fmt.Sprintf("unknown action %s for sensor %d", op.Action, op.SensorId))
+		}
+
+		interval, bins := processing.Limits(op)
+
+		if interval > MaxWindow {
+			return twirp.InvalidArgumentError("operations", fmt.Sprintf("interval for sensor %d must be at most %d seconds", op.SensorId, MaxWindow))
+		}
+
+		if len(bins) > MaxBins {
+			return twirp.InvalidArgumentError("operations", fmt.Sprintf("sensor %d may have at most %d bins", op.SensorId, MaxBins))
+		}
+	}
+
+	return nil
+}
+
+// isSpaceOrControl returns true for whitespace and control characters.
+func isSpaceOrControl(r rune) bool {
+	return unicode.IsSpace(r) || unicode.IsControl(r)
+}
@@ -0,0 +1,128 @@
+package rpc_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	encoder "github.com/thingful/twirp-encoder-go"
+	"github.com/twitchtv/twirp"
+
+	"github.com/DECODEproject/iotencoder/pkg/rpc"
+)
+
+// countingEncoder counts the requests which reach it.
+type countingEncoder struct {
+	calls int
+}
+
+func (c *countingEncoder) CreateStream(ctx context.Context, req *encoder.CreateStreamRequest) (*encoder.CreateStreamResponse, error) {
+	c.calls++
+	return &encoder.CreateStreamResponse{}, nil
+}
+
+func (c *countingEncoder) DeleteStream(ctx context.Context, req *encoder.DeleteStreamRequest) (*encoder.DeleteStreamResponse, error) {
+	c.calls++
+	return &encoder.DeleteStreamResponse{}, nil
+}
+
+func TestValidatorCreateStream(t *testing.T) {
+	testcases := []struct {
+		label    string
+		modify   func(req *encoder.CreateStreamRequest)
+		argument string
+	}{
+		{"missing device token", func(req *encoder.CreateStreamRequest) { req.DeviceToken = "" }, "device_token"},
+		{"long device token", func(req *encoder.CreateStreamRequest) { req.DeviceToken = strings.Repeat("a", 256) }, "device_token"},
+		{"wildcard device token", func(req *encoder.CreateStreamRequest) { req.DeviceToken = "abc/#" }, "device_token"},
+		{"whitespace device token", func(req *encoder.CreateStreamRequest) { req.DeviceToken = "abc 123" }, "device_token"},
+		{"missing device label", func(req *encoder.CreateStreamRequest) { req.DeviceLabel = "" }, "device_label"},
+		{"long device label", func(req *encoder.CreateStreamRequest) { req.DeviceLabel = strings.Repeat("é", 256) }, "device_label"},
+		{"missing community id", func(req *encoder.CreateStreamRequest) { req.CommunityId = "" }, "community_id"},
+		{"missing public key", func(req *encoder.CreateStreamRequest) { req.RecipientPublicKey = "" }, "recipient_public_key"},
+		{"malformed public key", func(req *encoder.CreateStreamRequest) { req.RecipientPublicKey = "not a key" }, "recipient_public_key"},
+		{"missing location", func(req *encoder.CreateStreamRequest) { req.Location = nil }, "location"},
+		{"missing longitude", func(req *encoder.CreateStreamRequest) { req.Location.Longitude = 0 }, "longitude"},
+		{"out of range longitude", func(req *encoder.CreateStreamRequest) { req.Location.Longitude = 181 }, "longitude"},
+		{"missing latitude", func(req *encoder.CreateStreamRequest) { req.Location.Latitude = 0 }, "latitude"},
+		{"out of range latitude", func(req *encoder.CreateStreamRequest) { req.Location.Latitude = -91 }, "latitude"},
+		{"missing sensor id", withOperation(&encoder.CreateStreamRequest_Operation{Action: encoder.CreateStreamRequest_Operation_SHARE}), "operations"},
+		{"unknown action", withOperation(&encoder.CreateStreamRequest_Operation{SensorId: 12, Action: 9}), "operations"},
+		{"action of later version", withOperation(&encoder.CreateStreamRequest_Operation{SensorId: 12, Action: encoder.CreateStreamRequest_Operation_DOWNSAMPLE, Interval: 300}), "operations"},
+		{"window too long", withOperation(&encoder.CreateStreamRequest_Operation{SensorId: 12, Action: encoder.CreateStreamRequest_Operation_MOVING_AVG, Interval: rpc.MaxWindow + 1}), "operations"},
+		{"downsampling window too long", func(req *encoder.CreateStreamRequest) {
+			req.ApiVersion = rpc.APIVersion2
+			req.Operations = []*encoder.CreateStreamRequest_Operation{{SensorId: 12, Action: encoder.CreateStreamRequest_Operation_DOWNSAMPLE, Interval: rpc.MaxWindow + 1}}
+		}, "operations"},
+		{"too many bins", withOperation(&encoder.CreateStreamRequest_Operation{SensorId: 12, Action: encoder.CreateStreamRequest_Operation_BIN, Bins: make([]float64, rpc.MaxBins+1)}), "operations"},
+		{"typed window too long", withOperation(&encoder.CreateStreamRequest_Operation{SensorId: 12, Processing: &encoder.CreateStreamRequest_Operation_MovingAverage{MovingAverage: &encoder.MovingAverage{Interval: rpc.MaxWindow + 1}}}), "operations"},
+		{"typed with too many bins", withOperation(&encoder.CreateStreamRequest_Operation{SensorId: 12, Processing: &encoder.CreateStreamRequest_Operation_Bin{Bin: &encoder.Bin{Bins: make([]float64, rpc.MaxBins+1)}}}), "operations"},
+		{"unknown api version", func(req *encoder.CreateStreamRequest) { req.ApiVersion = 9 }, "api_version"},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			next := &countingEncoder{}
+			v := rpc.NewValidator(next)
+
+			req := newStreamRequest("community-1")
+			tc.modify(req)
+
+			_, err := v.CreateStream(context.Background(), req)
+			assert.NotNil(t, err)
+			assert.Equal(t, twirp.InvalidArgument, err.(twirp.Error).Code())
+			assert.Equal(t, tc.argument, err.(twirp.Error).Meta("argument"))
+			assert.Equal(t, 0, next.calls)
+		})
+	}
+}
+
+func TestValidatorPassesValidRequests(t *testing.T) {
+	next := &countingEncoder{}
+	v := rpc.NewValidator(next)
+
+	_, err := v.CreateStream(context.Background(), newStreamRequest("community-1"))
+	assert.Nil(t, err)
+
+	// a referenced policy supplies the community and key
+	req := newStreamRequest("")
+	req.RecipientPublicKey = ""
+
+	_, err = v.CreateStream(rpc.WithPolicyID(context.Background(), "policy-1"), req)
+	assert.Nil(t, err)
+
+	_, err = v.DeleteStream(context.Background(), &encoder.DeleteStreamRequest{StreamUid: "stream-1", Token: "token-1"})
+	assert.Nil(t, err)
+
+	assert.Equal(t, 3, next.calls)
+}
+
+func TestValidatorDeleteStream(t *testing.T) {
+	testcases := []struct {
+		label    string
+		req      *encoder.DeleteStreamRequest
+		argument string
+	}{
+		{"missing stream uid", &encoder.DeleteStreamRequest{Token: "token-1"}, "stream_uid"},
+		{"missing token", &encoder.DeleteStreamRequest{StreamUid: "stream-1"}, "token"},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			next := &countingEncoder{}
+
+			_, err := rpc.NewValidator(next).DeleteStream(context.Background(), tc.req)
+			assert.NotNil(t, err)
+			assert.Equal(t, twirp.InvalidArgument, err.(twirp.Error).Code())
+			assert.Equal(t, tc.argument, err.(twirp.Error).Meta("argument"))
+			assert.Equal(t, 0, next.calls)
+		})
+	}
+}
+
+func withOperation(op *encoder.CreateStreamRequest_Operation) func(req *encoder.CreateStreamRequest) {
+	return func(req *encoder.CreateStreamRequest) {
+		req.Operations = []*encoder.CreateStreamRequest_Operation{op}
+	}
+}
@@ -0,0 +1,117 @@
+package rpc
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	encoder "github.com/thingful/twirp-encoder-go"
+	"github.com/twitchtv/twirp"
+)
+
+const (
+	// APIVersion1 is the original encoder API, in which a SHARE operation with
+	// an interval requests downsampling, and one with a single bin change-only
+	// sharing with the bin as threshold. Both of these are deprecated. Requests
+	// which don't set their api_version are served as APIVersion1, which
+	// existing clients such as the DECODE wallet speak.
+	APIVersion1 = 1
+
+	// APIVersion2 adds the DOWNSAMPLE and DELTA operation actions, in place of
+	// overloading SHARE operations, which may no longer carry an interval or
+	// bins.
+	APIVersion2 = 2
+
+	// CurrentAPIVersion is the latest version of the encoder API.
+	CurrentAPIVersion = APIVersion2
+)
+
+// DeprecatedCounter is a prometheus counter vec recording the number of
+// requests which used a deprecated feature, labelled by the feature, so that
+// we know when no client relies on it any longer.
+var DeprecatedCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "decode",
+		Subsystem: "encoder",
+		Name:      "deprecated_requests",
+		Help:      "Count of requests using a deprecated API feature, by feature",
+	},
+	[]string{"feature"},
+)
+
+var (
+	shareIntervalDeprecation = &encoder.Deprecation{
+		Feature: "share_interval",
+		Notice:  fmt.Sprintf("SHARE operations with an interval are deprecated, send api_version %d and use DOWNSAMPLE", APIVersion2),
+	}
+
+	shareThresholdDeprecation = &encoder.Deprecation{
+		Feature: "share_threshold",
+		Notice:  fmt.Sprintf("SHARE operations with bins are deprecated, send api_version %d and use DELTA", APIVersion2),
+	}
+)
+
+// parseAPIVersion returns the API version a request which declared the given
+// version is served as. A request which declared none is served as
+// APIVersion1.
+func parseAPIVersion(v uint32) (int, error) {
+	if v == 0 {
+		return APIVersion1, nil
+	}
+
+	if v > CurrentAPIVersion {
+		return 0, twirp.InvalidArgumentError("api_version", fmt.Sprintf("must be between %d and %d", APIVersion1, CurrentAPIVersion))
+	}
+
+	return int(v), nil
+}
+
+// checkCreateRequest returns the deprecated features used by a request written
+// against the given API version, or an error if it uses a feature which its
+// version no longer accepts. Operations of either version are converted alike
+// into typed processing as the stream is created, so streams are processed
+// alike whichever version created them.
+func checkCreateRequest(version int, req *encoder.CreateStreamRequest) ([]*encoder.Deprecation, error) {
+	var deprecations []*encoder.Deprecation
+
+	for _, op := range req.Operations {
+		if op.Action != encoder.CreateStreamRequest_Operation_SHARE || (op.Interval == 0 && len(op.Bins) == 0) {
+			continue
+		}
+
+		if version >= APIVersion2 {
+			return nil, twirp.InvalidArgumentError("operations", "sharing takes no interval or bins, use DOWNSAMPLE or DELTA")
+		}
+
+		if op.Interval != 0 {
+			deprecations = append(deprecations, shareIntervalDeprecation)
+		}
+
+		if len(op.Bins) > 0 {
+			deprecations = append(deprecations, shareThresholdDeprecation)
+		}
+	}
+
+	return deprecations, nil
+}
+
+// reportDeprecations counts each deprecated feature the request used, and
+// returns a single notice of each to be returned to the caller.
+func reportDeprecations(deprecations []*encoder.Deprecation) []*encoder.Deprecation {
+	var reported []*encoder.Deprecation
+	seen := map[string]bool{}
+
+	for _, d := range deprecations {
+		if seen[d.Feature] {
+			continue
+		}
+		seen[d.Feature] = true
+
+		DeprecatedCounter.WithLabelValues(d.Feature).Inc()
+		reported = append(reported, &encoder.Deprecation{
+			Feature: d.Feature,
+			Notice:  d.Notice,
+		})
+	}
+
+	return reported
+}
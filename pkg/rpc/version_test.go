@@ -0,0 +1,156 @@
+package rpc_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	encoder "github.com/thingful/twirp-encoder-go"
+	"github.com/twitchtv/twirp"
+
+	"github.com/DECODEproject/iotencoder/pkg/mqtt/mqtttest"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/postgres/postgrestest"
+	"github.com/DECODEproject/iotencoder/pkg/rpc"
+)
+
+func TestAPIVersionTranslation(t *testing.T) {
+	enc, db, _, _ := newInMemoryEncoder(0)
+
+	testcases := []struct {
+		label        string
+		version      uint32
+		operations   []*encoder.CreateStreamRequest_Operation
+		served       uint32
+		deprecations []string
+	}{
+		{
+			label:   "version 1",
+			version: 0,
+			operations: []*encoder.CreateStreamRequest_Operation{
+				{SensorId: 12, Action: encoder.CreateStreamRequest_Operation_SHARE},
+				{SensorId: 14, Action: encoder.CreateStreamRequest_Operation_SHARE, Interval: 300},
+				{SensorId: 29, Action: encoder.CreateStreamRequest_Operation_SHARE, Bins: []float64{0.5}},
+			},
+			served:       rpc.APIVersion1,
+			deprecations: []string{"share_interval", "share_threshold"},
+		},
+		{
+			label:   "version 2",
+			version: rpc.APIVersion2,
+			operations: []*encoder.CreateStreamRequest_Operation{
+				{SensorId: 12, Action: encoder.CreateStreamRequest_Operation_SHARE},
+				{SensorId: 14, Action: encoder.CreateStreamRequest_Operation_DOWNSAMPLE, Interval: 300},
+				{SensorId: 29, Action: encoder.CreateStreamRequest_Operation_DELTA, Bins: []float64{0.5}},
+			},
+			served: rpc.APIVersion2,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			req := newStreamRequest(tc.label)
+			req.ApiVersion = tc.version
+			req.Operations = tc.operations
+
+			resp, err := enc.CreateStream(context.Background(), req)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.served, resp.ApiVersion)
+
+			var deprecations []string
+			for _, d := range resp.Deprecations {
+				deprecations = append(deprecations, d.Feature)
+			}
+			assert.Equal(t, tc.deprecations, deprecations)
+
+			// the caller's request is left as it was sent
+			assert.Equal(t, tc.operations, req.Operations)
+
+			stream, err := db.GetStream(resp.StreamUid, resp.Token)
+			assert.Nil(t, err)
+			assert.Equal(t, postgres.Operations{
+				{SensorID: 12, Action: postgres.Share},
+				{SensorID: 14, Action: postgres.Downsample, Interval: 300},
+				{SensorID: 29, Action: postgres.Delta, Threshold: 0.5},
+			}, stream.Operations)
+		})
+	}
+}
+
+func TestAPIVersionInvalid(t *testing.T) {
+	enc, _, _, _ := newInMemoryEncoder(0)
+
+	testcases := []struct {
+		label     string
+		version   uint32
+		operation *encoder.CreateStreamRequest_Operation
+	}{
+		{"unknown version", 3, nil},
+		{"share with interval", 2, &encoder.CreateStreamRequest_Operation{SensorId: 12, Action: encoder.CreateStreamRequest_Operation_SHARE, Interval: 300}},
+		{"share with bins", 2, &encoder.CreateStreamRequest_Operation{SensorId: 12, Action: encoder.CreateStreamRequest_Operation_SHARE, Bins: []float64{0.5}}},
+		{"downsample without interval", 2, &encoder.CreateStreamRequest_Operation{SensorId: 12, Action: encoder.CreateStreamRequest_Operation_DOWNSAMPLE}},
+		{"delta without threshold", 2, &encoder.CreateStreamRequest_Operation{SensorId: 12, Action: encoder.CreateStreamRequest_Operation_DELTA}},
+		{"new action in version 1", 1, &encoder.CreateStreamRequest_Operation{SensorId: 12, Action: encoder.CreateStreamRequest_Operation_DOWNSAMPLE, Interval: 300}},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			req := newStreamRequest("community-1")
+			req.ApiVersion = tc.version
+			if tc.operation != nil {
+				req.Operations = []*encoder.CreateStreamRequest_Operation{tc.operation}
+			}
+
+			_, err := rpc.NewValidator(enc).CreateStream(context.Background(), req)
+			assert.NotNil(t, err)
+			assert.Equal(t, twirp.InvalidArgument, err.(twirp.Error).Code())
+		})
+	}
+}
+
+func TestAPIVersionJSON(t *testing.T) {
+	enc := rpc.NewEncoder(&rpc.Config{
+		DB:         postgrestest.NewDB(),
+		MQTTClient: mqtttest.NewClient(),
+		Processor:  &recordingProcessor{processed: make(map[string][][]byte)},
+	}, kitlog.NewNopLogger())
+
+	srv := httptest.NewServer(rpc.StreamOptionsMiddleware(encoder.NewEncoderServer(enc, nil)))
+	defer srv.Close()
+
+	testcases := []struct {
+		label     string
+		version   string
+		operation string
+		expected  string
+	}{
+		{"unversioned", "", `{"sensor_id":12,"action":"SHARE"}`, `"api_version":1`},
+		{"deprecated", `"api_version":1,`, `{"sensor_id":12,"action":"SHARE","interval":300}`, `"deprecations":[{"feature":"share_interval",`},
+		{"current", `"api_version":2,`, `{"sensor_id":12,"action":"DOWNSAMPLE","interval":300}`, `"api_version":2`},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			body := `{` + tc.version + `"device_token":"abc123","device_label":"my sensor","recipient_public_key":"BBLewg4VqLR38b38daE7Fj\\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\\/ifjE=","community_id":"` + tc.label + `","location":{"longitude":-0.024,"latitude":54.24},"exposure":"INDOOR","operations":[` + tc.operation + `]}`
+
+			req, err := http.NewRequest(http.MethodPost, srv.URL+encoder.EncoderPathPrefix+"CreateStream", strings.NewReader(body))
+			assert.Nil(t, err)
+			req.Header.Set("Content-Type", "application/json")
+
+			resp, err := http.DefaultClient.Do(req)
+			assert.Nil(t, err)
+			defer resp.Body.Close()
+
+			b, err := ioutil.ReadAll(resp.Body)
+			assert.Nil(t, err)
+
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Contains(t, string(b), tc.expected)
+		})
+	}
+}
@@ -0,0 +1,107 @@
+// Package sampling selects a random subset of the messages of a stream, for
+// exploratory datasets where statistical coverage is enough and writing every
+// message of a very high frequency device would be wasteful. Each stream draws
+// from its own pseudo-random sequence seeded from its id, so that the same
+// messages are selected whenever the stream's messages are processed in the
+// same order.
+package sampling
+
+import (
+	"hash/fnv"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Spec is the sampling configuration of a stream. Percent is the percentage of
+// messages which are kept, greater than zero and no more than 100.
+type Spec struct {
+	Percent float64
+}
+
+// Parse parses a spec from its string form, a percentage, e.g. "10%" or
+// "0.5%".
+func Parse(s string) (*Spec, error) {
+	number := strings.TrimSuffix(strings.TrimSpace(s), "%")
+	if number == strings.TrimSpace(s) {
+		return nil, errors.Errorf("invalid sampling percentage, must end with %%: %s", s)
+	}
+
+	percent, err := strconv.ParseFloat(number, 64)
+	if err != nil || math.IsNaN(percent) || percent <= 0 || percent > 100 {
+		return nil, errors.Errorf("invalid sampling percentage, must be greater than 0 and at most 100: %s", s)
+	}
+
+	return &Spec{Percent: percent}, nil
+}
+
+// Sampler holds the state of the pseudo-random sequence of each stream being
+// sampled. It is held in memory, so after a restart each stream's sequence
+// starts again from its seed.
+type Sampler struct {
+	sync.Mutex
+
+	states map[string]uint64
+}
+
+// NewSampler returns a new Sampler.
+func NewSampler() *Sampler {
+	return &Sampler{
+		states: make(map[string]uint64),
+	}
+}
+
+// Sample returns true if the next message of the stream with the given id
+// should be kept under the given spec.
+func (s *Sampler) Sample(streamID string, spec *Spec) bool {
+	if spec.Percent >= 100 {
+		return true
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	state, ok := s.states[streamID]
+	if !ok {
+		state = seed(streamID)
+	}
+
+	state, n := splitmix64(state)
+	s.states[streamID] = state
+
+	// the top 53 bits give a uniformly distributed float in [0, 1)
+	return float64(n>>11)/(1<<53)*100 < spec.Percent
+}
+
+// Forget discards the state of the stream with the given id, so that the
+// sequences of deleted streams are not held forever.
+func (s *Sampler) Forget(streamID string) {
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.states, streamID)
+}
+
+// seed returns the initial state of the sequence of the stream with the given
+// id.
+func seed(streamID string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(streamID))
+	return h.Sum64()
+}
+
+// splitmix64 advances the given state, returning the new state and the next
+// number of the sequence. Its state is a single word, so that a sequence per
+// stream costs almost nothing.
+func splitmix64(state uint64) (uint64, uint64) {
+	state += 0x9e3779b97f4a7c15
+
+	z := state
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+
+	return state, z ^ (z >> 31)
+}
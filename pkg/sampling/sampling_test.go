@@ -0,0 +1,99 @@
+package sampling_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/sampling"
+)
+
+func TestParse(t *testing.T) {
+	testcases := []struct {
+		input    string
+		expected *sampling.Spec
+	}{
+		{"10%", &sampling.Spec{Percent: 10}},
+		{"0.5%", &sampling.Spec{Percent: 0.5}},
+		{"100%", &sampling.Spec{Percent: 100}},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.input, func(t *testing.T) {
+			spec, err := sampling.Parse(tc.input)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, spec)
+		})
+	}
+
+	for _, input := range []string{
+		"",
+		"%",
+		"10",
+		"0%",
+		"-5%",
+		"101%",
+		"x%",
+	} {
+		t.Run(input, func(t *testing.T) {
+			_, err := sampling.Parse(input)
+			assert.NotNil(t, err)
+		})
+	}
+}
+
+func TestSampler(t *testing.T) {
+	spec := &sampling.Spec{Percent: 10}
+
+	decisions := func(sampler *sampling.Sampler, streamID string) []bool {
+		d := make([]bool, 10000)
+		for i := range d {
+			d[i] = sampler.Sample(streamID, spec)
+		}
+		return d
+	}
+
+	first := decisions(sampling.NewSampler(), "stream-a")
+
+	// roughly the given percentage of messages are kept
+	kept := 0
+	for _, keep := range first {
+		if keep {
+			kept++
+		}
+	}
+
+	assert.InDelta(t, 1000, kept, 100)
+
+	// the same stream makes the same decisions in a new sampler, while other
+	// streams make their own
+	assert.Equal(t, first, decisions(sampling.NewSampler(), "stream-a"))
+	assert.NotEqual(t, first, decisions(sampling.NewSampler(), "stream-b"))
+
+	// every message is kept at 100%
+	sampler := sampling.NewSampler()
+	for i := 0; i < 100; i++ {
+		assert.True(t, sampler.Sample("stream-a", &sampling.Spec{Percent: 100}))
+	}
+}
+
+func TestSamplerForget(t *testing.T) {
+	spec := &sampling.Spec{Percent: 50}
+
+	decisions := func(sampler *sampling.Sampler) []bool {
+		d := make([]bool, 100)
+		for i := range d {
+			d[i] = sampler.Sample("stream-a", spec)
+		}
+		return d
+	}
+
+	sampler := sampling.NewSampler()
+	first := decisions(sampler)
+
+	// a forgotten stream starts its sequence again from its seed
+	assert.NotEqual(t, first, decisions(sampler))
+
+	sampler.Forget("stream-a")
+	assert.Equal(t, first, decisions(sampler))
+}
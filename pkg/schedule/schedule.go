@@ -0,0 +1,188 @@
+// Package schedule restricts the times of day at which the data of a stream is
+// shared, for communities which only want data shared during certain hours.
+// A schedule is a list of daily windows in a timezone, outside of which
+// messages are either dropped or buffered until the next window opens.
+package schedule
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// Drop is the action taken outside of a stream's windows which discards
+	// messages. It is the default.
+	Drop = "drop"
+
+	// Buffer is the action taken outside of a stream's windows which holds
+	// messages until the next window opens.
+	Buffer = "buffer"
+)
+
+// minutesPerDay is the number of minutes in a day, ignoring changes of
+// daylight saving time.
+const minutesPerDay = 24 * 60
+
+// Window is a daily window during which a stream's data is shared, given as
+// minutes after midnight. A window whose end is before its start crosses
+// midnight, so that 22:00-06:00 covers the night. The end is exclusive.
+type Window struct {
+	Start int
+	End   int
+}
+
+// contains returns true if the window covers the given minute of the day.
+func (w Window) contains(minute int) bool {
+	if w.Start < w.End {
+		return minute >= w.Start && minute < w.End
+	}
+
+	return minute >= w.Start || minute < w.End
+}
+
+// Spec is the schedule of a stream. Windows are the daily windows during which
+// data is shared in Location, while Outside is the action taken on messages
+// received outside of them.
+type Spec struct {
+	Windows  []Window
+	Location *time.Location
+	Outside  string
+}
+
+// Parse parses a spec from its string form, a list of windows in 24 hour time
+// optionally followed by a timezone and the action taken outside the windows,
+// e.g. "08:00-22:00" or "08:00-12:00,14:00-22:00,tz=Europe/Madrid,outside=buffer".
+// If no timezone is given windows are in UTC, and if no action is given
+// messages outside the windows are dropped.
+func Parse(s string) (*Spec, error) {
+	spec := &Spec{
+		Location: time.UTC,
+		Outside:  Drop,
+	}
+
+	for _, param := range strings.Split(s, ",") {
+		param = strings.TrimSpace(param)
+
+		if !strings.Contains(param, "=") {
+			window, err := parseWindow(param)
+			if err != nil {
+				return nil, err
+			}
+
+			spec.Windows = append(spec.Windows, window)
+			continue
+		}
+
+		kv := strings.SplitN(param, "=", 2)
+
+		switch kv[0] {
+		case "tz":
+			loc, err := time.LoadLocation(kv[1])
+			if err != nil || kv[1] == "" {
+				return nil, errors.Errorf("invalid schedule timezone: %s", kv[1])
+			}
+			spec.Location = loc
+		case "outside":
+			if kv[1] != Drop && kv[1] != Buffer {
+				return nil, errors.Errorf("invalid schedule outside action, must be %s or %s: %s", Drop, Buffer, kv[1])
+			}
+			spec.Outside = kv[1]
+		default:
+			return nil, errors.Errorf("unknown schedule parameter: %s", kv[0])
+		}
+	}
+
+	if len(spec.Windows) == 0 {
+		return nil, errors.Errorf("invalid schedule %q, must have at least one window", s)
+	}
+
+	return spec, nil
+}
+
+// parseWindow parses a window from its string form, e.g. "08:00-22:00".
+func parseWindow(s string) (Window, error) {
+	parts := strings.SplitN(s, "-", 2)
+	if len(parts) != 2 {
+		return Window{}, errors.Errorf("invalid schedule window %q, must be HH:MM-HH:MM", s)
+	}
+
+	start, err := parseTime(parts[0])
+	if err != nil {
+		return Window{}, err
+	}
+
+	end, err := parseTime(parts[1])
+	if err != nil {
+		return Window{}, err
+	}
+
+	if start == end {
+		return Window{}, errors.Errorf("invalid schedule window %q, must not be empty", s)
+	}
+
+	return Window{Start: start, End: end}, nil
+}
+
+// parseTime parses a time of day in 24 hour time, returning the number of
+// minutes after midnight. 24:00 is accepted as the end of the day.
+func parseTime(s string) (int, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 || len(parts[0]) != 2 || len(parts[1]) != 2 {
+		return 0, errors.Errorf("invalid schedule time, must be HH:MM: %s", s)
+	}
+
+	hours, err := strconv.Atoi(parts[0])
+	if err != nil || hours < 0 || hours > 24 {
+		return 0, errors.Errorf("invalid schedule time, must be HH:MM: %s", s)
+	}
+
+	minutes, err := strconv.Atoi(parts[1])
+	if err != nil || minutes < 0 || minutes > 59 || (hours == 24 && minutes != 0) {
+		return 0, errors.Errorf("invalid schedule time, must be HH:MM: %s", s)
+	}
+
+	return (hours*60 + minutes) % minutesPerDay, nil
+}
+
+// Open returns true if the given time falls within one of the windows of the
+// spec.
+func (s *Spec) Open(t time.Time) bool {
+	local := t.In(s.Location)
+	minute := local.Hour()*60 + local.Minute()
+
+	for _, w := range s.Windows {
+		if w.contains(minute) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NextOpen returns the time at which the next window of the spec opens after
+// the given time, or the time itself if a window is open then.
+func (s *Spec) NextOpen(t time.Time) time.Time {
+	if s.Open(t) {
+		return t
+	}
+
+	local := t.In(s.Location)
+
+	var next time.Time
+
+	// every window opens at least once within the next day, and time.Date
+	// normalises a start skipped by daylight saving time to a valid time
+	for day := 0; day <= 1; day++ {
+		for _, w := range s.Windows {
+			start := time.Date(local.Year(), local.Month(), local.Day()+day, w.Start/60, w.Start%60, 0, 0, s.Location)
+			if start.After(t) && (next.IsZero() || start.Before(next)) {
+				next = start
+			}
+		}
+	}
+
+	return next
+}
@@ -0,0 +1,111 @@
+package schedule_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/schedule"
+)
+
+func TestParse(t *testing.T) {
+	madrid, err := time.LoadLocation("Europe/Madrid")
+	assert.Nil(t, err)
+
+	testcases := []struct {
+		input    string
+		expected *schedule.Spec
+	}{
+		{
+			"08:00-22:00",
+			&schedule.Spec{
+				Windows:  []schedule.Window{{Start: 480, End: 1320}},
+				Location: time.UTC,
+				Outside:  schedule.Drop,
+			},
+		},
+		{
+			"08:00-12:00,14:30-24:00,tz=Europe/Madrid,outside=buffer",
+			&schedule.Spec{
+				Windows:  []schedule.Window{{Start: 480, End: 720}, {Start: 870, End: 0}},
+				Location: madrid,
+				Outside:  schedule.Buffer,
+			},
+		},
+		{
+			"22:00-06:00,outside=drop",
+			&schedule.Spec{
+				Windows:  []schedule.Window{{Start: 1320, End: 360}},
+				Location: time.UTC,
+				Outside:  schedule.Drop,
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.input, func(t *testing.T) {
+			spec, err := schedule.Parse(tc.input)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, spec)
+		})
+	}
+
+	for _, input := range []string{
+		"",
+		"tz=UTC",
+		"08:00",
+		"8:00-22:00",
+		"08:00-25:00",
+		"08:60-22:00",
+		"08:00-08:00",
+		"08:00-22:00,tz=Mars/Olympus",
+		"08:00-22:00,tz=",
+		"08:00-22:00,outside=queue",
+		"08:00-22:00,days=mon",
+	} {
+		t.Run(input, func(t *testing.T) {
+			_, err := schedule.Parse(input)
+			assert.NotNil(t, err)
+		})
+	}
+}
+
+func TestOpen(t *testing.T) {
+	spec, err := schedule.Parse("08:00-22:00,tz=Europe/Madrid")
+	assert.Nil(t, err)
+
+	// Madrid is two hours ahead of UTC in summer
+	assert.False(t, spec.Open(time.Date(2026, 7, 1, 5, 59, 0, 0, time.UTC)))
+	assert.True(t, spec.Open(time.Date(2026, 7, 1, 6, 0, 0, 0, time.UTC)))
+	assert.True(t, spec.Open(time.Date(2026, 7, 1, 19, 59, 59, 0, time.UTC)))
+	assert.False(t, spec.Open(time.Date(2026, 7, 1, 20, 0, 0, 0, time.UTC)))
+
+	night, err := schedule.Parse("22:00-06:00")
+	assert.Nil(t, err)
+
+	assert.True(t, night.Open(time.Date(2026, 7, 1, 23, 0, 0, 0, time.UTC)))
+	assert.True(t, night.Open(time.Date(2026, 7, 1, 5, 0, 0, 0, time.UTC)))
+	assert.False(t, night.Open(time.Date(2026, 7, 1, 12, 0, 0, 0, time.UTC)))
+}
+
+func TestNextOpen(t *testing.T) {
+	spec, err := schedule.Parse("08:00-12:00,14:00-22:00")
+	assert.Nil(t, err)
+
+	// an open window is open now
+	now := time.Date(2026, 7, 1, 9, 0, 0, 0, time.UTC)
+	assert.Equal(t, now, spec.NextOpen(now))
+
+	// the next window opens later the same day
+	assert.Equal(t,
+		time.Date(2026, 7, 1, 14, 0, 0, 0, time.UTC),
+		spec.NextOpen(time.Date(2026, 7, 1, 12, 30, 0, 0, time.UTC)),
+	)
+
+	// or the first window opens the next day
+	assert.Equal(t,
+		time.Date(2026, 7, 2, 8, 0, 0, 0, time.UTC),
+		spec.NextOpen(time.Date(2026, 7, 1, 23, 0, 0, 0, time.UTC)),
+	)
+}
@@ -0,0 +1,163 @@
+// Package schema is a registry of the shape of decoded payloads per kind of
+// device: the channels a payload carries, their units and the types of their
+// values. Streams reference a version of a schema, against which readings are
+// validated before encryption, and the reference is written with each
+// datastore entry so that consumers know how to interpret the plaintext once
+// decrypted.
+package schema
+
+import (
+	"math"
+	"sync"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/smartcitizen"
+)
+
+var (
+	// ViolationsCounter is a prometheus counter vec recording a count of
+	// readings dropped as they did not conform to the schema of their stream,
+	// labelled by the reason, either undeclared for a sensor the schema does
+	// not declare, or type for a value not of the declared type.
+	ViolationsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "schema_violations",
+			Help:      "Count of readings dropped as they did not conform to their stream's schema",
+		},
+		[]string{"reason"},
+	)
+)
+
+// Store is the interface we require of a type able to save and load schemas.
+// We define it here where we need it, and it is satisfied by our postgres.DB
+// and boltdb.DB types.
+type Store interface {
+	CreateSchema(kind string, channels postgres.Channels) (*postgres.Schema, error)
+	GetSchema(kind string, version int) (*postgres.Schema, error)
+	ListSchemas() ([]*postgres.Schema, error)
+}
+
+// Config is used to pass in dependencies when creating a Registry.
+type Config struct {
+	Store Store
+}
+
+// Registry resolves references to schemas. As versions of a schema are
+// immutable, schemas resolved by a versioned reference are cached so that
+// resolving the schema of each message doesn't require a query.
+type Registry struct {
+	sync.RWMutex
+
+	store  Store
+	logger kitlog.Logger
+	cache  map[string]*postgres.Schema
+}
+
+// NewRegistry returns a new Registry reading schemas from the configured
+// store.
+func NewRegistry(config *Config, logger kitlog.Logger) *Registry {
+	logger = kitlog.With(logger, "module", "schema")
+
+	return &Registry{
+		store:  config.Store,
+		logger: logger,
+		cache:  make(map[string]*postgres.Schema),
+	}
+}
+
+// Register registers a new version of the schema of the given kind, returning
+// the created schema.
+func (r *Registry) Register(kind string, channels postgres.Channels) (*postgres.Schema, error) {
+	schema, err := r.store.CreateSchema(kind, channels)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to register schema")
+	}
+
+	r.logger.Log("msg", "registered schema", "schema", schema.Ref())
+
+	return schema, nil
+}
+
+// Resolve returns the schema referenced by the given reference, either
+// kind@version or just kind for the latest version of the kind. If the schema
+// does not exist the cause of the returned error is postgres.ErrSchemaNotFound.
+func (r *Registry) Resolve(ref string) (*postgres.Schema, error) {
+	r.RLock()
+	schema, ok := r.cache[ref]
+	r.RUnlock()
+
+	if ok {
+		return schema, nil
+	}
+
+	kind, version, err := postgres.ParseSchemaRef(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	schema, err = r.store.GetSchema(kind, version)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to resolve schema %s", ref)
+	}
+
+	// the latest version of a kind changes as versions are registered, so only
+	// the versioned reference may be cached
+	r.Lock()
+	r.cache[schema.Ref()] = schema
+	r.Unlock()
+
+	return schema, nil
+}
+
+// List returns every version of every registered schema.
+func (r *Registry) List() ([]*postgres.Schema, error) {
+	return r.store.ListSchemas()
+}
+
+// Apply returns a copy of the given device holding only those readings which
+// conform to the schema, counting those dropped in ViolationsCounter. A
+// reading conforms if the schema declares its sensor, and its value is either
+// absent or of the declared type. The given device is not modified.
+func Apply(schema *postgres.Schema, device *smartcitizen.Device) *smartcitizen.Device {
+	validated := *device
+	validated.Sensors = make([]*smartcitizen.Sensor, 0, len(device.Sensors))
+
+	for _, sensor := range device.Sensors {
+		channel := schema.Channels.Find(sensor.ID)
+		if channel == nil {
+			ViolationsCounter.WithLabelValues("undeclared").Inc()
+			continue
+		}
+
+		if sensor.Value != nil && sensor.Value.Valid && !conforms(channel.Type, sensor.Value.Float64) {
+			ViolationsCounter.WithLabelValues("type").Inc()
+			continue
+		}
+
+		validated.Sensors = append(validated.Sensors, sensor)
+	}
+
+	return &validated
+}
+
+// conforms returns true if the given value is of the given type.
+func conforms(t postgres.ChannelType, value float64) bool {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return false
+	}
+
+	switch t {
+	case postgres.IntegerType:
+		return value == math.Trunc(value)
+	case postgres.BooleanType:
+		return value == 0 || value == 1
+	default:
+		return true
+	}
+}
@@ -0,0 +1,153 @@
+package schema_test
+
+import (
+	"testing"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/guregu/null.v3"
+
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/postgres/postgrestest"
+	"github.com/DECODEproject/iotencoder/pkg/schema"
+	"github.com/DECODEproject/iotencoder/pkg/smartcitizen"
+)
+
+type countingStore struct {
+	*postgrestest.DB
+	gets int
+}
+
+func (s *countingStore) GetSchema(kind string, version int) (*postgres.Schema, error) {
+	s.gets++
+	return s.DB.GetSchema(kind, version)
+}
+
+func TestRegisterAndResolve(t *testing.T) {
+	store := &countingStore{DB: postgrestest.NewDB()}
+	registry := schema.NewRegistry(&schema.Config{Store: store}, kitlog.NewNopLogger())
+
+	v1, err := registry.Register("noise", postgres.Channels{
+		{SensorID: 12, Name: "temperature", Unit: "celsius", Type: postgres.NumberType},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "noise@1", v1.Ref())
+
+	v2, err := registry.Register("noise", postgres.Channels{
+		{SensorID: 12, Name: "temperature", Unit: "celsius", Type: postgres.NumberType},
+		{SensorID: 14, Name: "light", Unit: "lux", Type: postgres.IntegerType},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "noise@2", v2.Ref())
+
+	// an unversioned reference resolves the latest version
+	resolved, err := registry.Resolve("noise")
+	assert.Nil(t, err)
+	assert.Equal(t, "noise@2", resolved.Ref())
+
+	resolved, err = registry.Resolve("noise@1")
+	assert.Nil(t, err)
+	assert.Len(t, resolved.Channels, 1)
+
+	// versioned references are cached
+	gets := store.gets
+	_, err = registry.Resolve("noise@1")
+	assert.Nil(t, err)
+	assert.Equal(t, gets, store.gets)
+
+	_, err = registry.Resolve("noise@3")
+	assert.Equal(t, postgres.ErrSchemaNotFound, errors.Cause(err))
+
+	_, err = registry.Resolve("Noise@x")
+	assert.NotNil(t, err)
+
+	schemas, err := registry.List()
+	assert.Nil(t, err)
+	assert.Len(t, schemas, 2)
+}
+
+func TestRegisterInvalid(t *testing.T) {
+	registry := schema.NewRegistry(&schema.Config{Store: postgrestest.NewDB()}, kitlog.NewNopLogger())
+
+	testcases := []struct {
+		label    string
+		kind     string
+		channels postgres.Channels
+	}{
+		{
+			label:    "invalid kind",
+			kind:     "Noise Sensor",
+			channels: postgres.Channels{{SensorID: 12, Name: "temperature", Type: postgres.NumberType}},
+		},
+		{
+			label: "no channels",
+			kind:  "noise",
+		},
+		{
+			label: "duplicate sensor",
+			kind:  "noise",
+			channels: postgres.Channels{
+				{SensorID: 12, Name: "temperature", Type: postgres.NumberType},
+				{SensorID: 12, Name: "humidity", Type: postgres.NumberType},
+			},
+		},
+		{
+			label:    "unknown type",
+			kind:     "noise",
+			channels: postgres.Channels{{SensorID: 12, Name: "temperature", Type: "string"}},
+		},
+		{
+			label:    "missing name",
+			kind:     "noise",
+			channels: postgres.Channels{{SensorID: 12, Type: postgres.NumberType}},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			_, err := registry.Register(tc.kind, tc.channels)
+			assert.NotNil(t, err)
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	s := &postgres.Schema{
+		Kind:    "noise",
+		Version: 1,
+		Channels: postgres.Channels{
+			{SensorID: 12, Name: "temperature", Unit: "celsius", Type: postgres.NumberType},
+			{SensorID: 14, Name: "light", Unit: "lux", Type: postgres.IntegerType},
+			{SensorID: 16, Name: "door", Type: postgres.BooleanType},
+		},
+	}
+
+	value := func(v float64) *null.Float {
+		f := null.FloatFrom(v)
+		return &f
+	}
+
+	device := &smartcitizen.Device{
+		Token: "abc123",
+		Sensors: []*smartcitizen.Sensor{
+			{ID: 12, Value: value(21.5)},
+			{ID: 13, Value: value(40)},
+			{ID: 14, Value: value(300.5)},
+			{ID: 16, Value: value(1)},
+			{ID: 16, Value: value(2)},
+			{ID: 14},
+		},
+	}
+
+	validated := schema.Apply(s, device)
+
+	assert.Equal(t, "abc123", validated.Token)
+	assert.Len(t, validated.Sensors, 3)
+	assert.Equal(t, 12, validated.Sensors[0].ID)
+	assert.Equal(t, 16, validated.Sensors[1].ID)
+	assert.Equal(t, 14, validated.Sensors[2].ID)
+
+	// the device passed in is unchanged
+	assert.Len(t, device.Sensors, 6)
+}
@@ -0,0 +1,71 @@
+// Package secret provides a string type for sensitive values such as device
+// tokens, stream tokens and encryption passwords, which is redacted whenever it
+// is formatted, logged or marshalled so that secret material does not leak into
+// logs or error messages.
+package secret
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// Redacted is the value written in place of a secret.
+	Redacted = "[REDACTED]"
+)
+
+// Secret is a string holding sensitive data. It prints as Redacted using any
+// fmt verb, and when marshalled as text or JSON. The underlying value must be
+// explicitly obtained via Reveal where it is actually required.
+type Secret string
+
+// Reveal returns the underlying secret value.
+func (s Secret) Reveal() string {
+	return string(s)
+}
+
+// String implements fmt.Stringer.
+func (s Secret) String() string {
+	return Redacted
+}
+
+// GoString implements fmt.GoStringer, so %#v is also redacted.
+func (s Secret) GoString() string {
+	return Redacted
+}
+
+// Format implements fmt.Formatter, ensuring all verbs are redacted.
+func (s Secret) Format(f fmt.State, verb rune) {
+	io.WriteString(f, Redacted)
+}
+
+// MarshalText implements encoding.TextMarshaler, which is used by both the
+// logfmt and JSON loggers as well as encoding/json.
+func (s Secret) MarshalText() ([]byte, error) {
+	return []byte(Redacted), nil
+}
+
+// Value implements driver.Valuer, so secrets are written to the database
+// unredacted.
+func (s Secret) Value() (driver.Value, error) {
+	return string(s), nil
+}
+
+// Scan implements sql.Scanner.
+func (s *Secret) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case string:
+		*s = Secret(v)
+	case []byte:
+		*s = Secret(v)
+	case nil:
+		*s = ""
+	default:
+		return errors.Errorf("cannot scan %T into secret", src)
+	}
+
+	return nil
+}
@@ -0,0 +1,93 @@
+package secret_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+)
+
+func TestFormatting(t *testing.T) {
+	s := secret.Secret("abc123")
+
+	testcases := []struct {
+		label  string
+		format string
+	}{
+		{"string", "%s"},
+		{"value", "%v"},
+		{"plus value", "%+v"},
+		{"go syntax", "%#v"},
+		{"quoted", "%q"},
+		{"hex", "%x"},
+		{"padded", "%10s"},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			got := fmt.Sprintf(tc.format, s)
+			assert.NotContains(t, got, "abc123")
+			assert.Contains(t, got, secret.Redacted)
+		})
+	}
+
+	assert.Equal(t, "abc123", s.Reveal())
+}
+
+func TestErrors(t *testing.T) {
+	s := secret.Secret("abc123")
+
+	err := errors.Wrapf(errors.New("boom"), "failed to find device %s", s)
+	assert.Equal(t, "failed to find device [REDACTED]: boom", err.Error())
+
+	err = fmt.Errorf("failed to find device %v", s)
+	assert.Equal(t, "failed to find device [REDACTED]", err.Error())
+}
+
+func TestMarshalling(t *testing.T) {
+	s := secret.Secret("abc123")
+
+	b, err := json.Marshal(struct {
+		Token secret.Secret `json:"token"`
+	}{s})
+	assert.Nil(t, err)
+	assert.Equal(t, `{"token":"[REDACTED]"}`, string(b))
+
+	var buf bytes.Buffer
+
+	kitlog.NewLogfmtLogger(&buf).Log("token", s)
+	kitlog.NewJSONLogger(&buf).Log("token", s)
+
+	assert.NotContains(t, buf.String(), "abc123")
+}
+
+func TestDatabase(t *testing.T) {
+	s := secret.Secret("abc123")
+
+	v, err := s.Value()
+	assert.Nil(t, err)
+	assert.Equal(t, "abc123", v)
+
+	var scanned secret.Secret
+
+	err = scanned.Scan([]byte("foo"))
+	assert.Nil(t, err)
+	assert.Equal(t, "foo", scanned.Reveal())
+
+	err = scanned.Scan("bar")
+	assert.Nil(t, err)
+	assert.Equal(t, "bar", scanned.Reveal())
+
+	err = scanned.Scan(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "", scanned.Reveal())
+
+	err = scanned.Scan(12)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"net"
+
+	"github.com/twitchtv/twirp"
+
+	"github.com/DECODEproject/iotencoder/pkg/mqtt"
+	"github.com/DECODEproject/iotencoder/pkg/pipeline"
+)
+
+// Option customises a Server as it is constructed by NewServer, allowing
+// callers embedding this package to supply components which would otherwise be
+// created from the Config, e.g. mocks in tests.
+type Option func(*options)
+
+// options holds the components supplied via Options, any of which may be nil.
+type options struct {
+	mqttClient    mqtt.Client
+	datastore     pipeline.Datastore
+	hooks         []*twirp.ServerHooks
+	listener      net.Listener
+	adminListener net.Listener
+	stages        []stage
+}
+
+// stage is a factory of pipeline stages supplied via WithStage, along with the
+// phase in which its stages run.
+type stage struct {
+	phase   pipeline.Phase
+	factory pipeline.StageFactory
+}
+
+// WithMQTTClient returns an Option which sets the client used to subscribe to
+// devices via MQTT, in place of one connecting to the configured broker.
+func WithMQTTClient(client mqtt.Client) Option {
+	return func(o *options) {
+		o.mqttClient = client
+	}
+}
+
+// WithDatastore returns an Option which sets the default datastore, to which
+// streams without their own datastore are written, in place of a client
+// connecting to the configured datastore address. Any pipeline.Datastore may be
+// given, e.g. a stub or an alternative sink.
+func WithDatastore(ds pipeline.Datastore) Option {
+	return func(o *options) {
+		o.datastore = ds
+	}
+}
+
+// WithHooks returns an Option which adds twirp server hooks, chained after our
+// own and any given in the Config. It may be given more than once.
+func WithHooks(hooks ...*twirp.ServerHooks) Option {
+	return func(o *options) {
+		o.hooks = append(o.hooks, hooks...)
+	}
+}
+
+// WithListener returns an Option which sets the listener on which requests
+// are served, in place of listening on the configured address. This allows
+// e.g. tests to listen on an ephemeral port.
+func WithListener(listener net.Listener) Option {
+	return func(o *options) {
+		o.listener = listener
+	}
+}
+
+// WithAdminListener returns an Option which sets the listener on which the
+// admin API is served, in place of listening on the configured admin address.
+func WithAdminListener(listener net.Listener) Option {
+	return func(o *options) {
+		o.adminListener = listener
+	}
+}
+
+// WithStage returns an Option which adds a factory of stages to the chain the
+// pipeline applies to each stream, running in the given phase after our own
+// stages for that phase. This allows new processing types to be added without
+// modifying the encoder. It may be given more than once.
+func WithStage(phase pipeline.Phase, factory pipeline.StageFactory) Option {
+	return func(o *options) {
+		o.stages = append(o.stages, stage{phase: phase, factory: factory})
+	}
+}
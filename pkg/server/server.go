@@ -2,85 +2,346 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/json"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/DECODEproject/iotcommon/middleware"
+	redigo "github.com/garyburd/redigo/redis"
 	kitlog "github.com/go-kit/kit/log"
-	twrpprom "github.com/joneskoo/twirp-serverhook-prometheus"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	registry "github.com/thingful/retryable-registry-prometheus"
 	datastore "github.com/thingful/twirp-datastore-go"
 	encoder "github.com/thingful/twirp-encoder-go"
+	"github.com/twitchtv/twirp"
 	goji "goji.io"
 	"goji.io/pat"
+	"golang.org/x/crypto/acme"
 	"golang.org/x/crypto/acme/autocert"
 
+	"github.com/DECODEproject/iotencoder/pkg/admin"
+	"github.com/DECODEproject/iotencoder/pkg/amqp"
+	"github.com/DECODEproject/iotencoder/pkg/analytics"
+	"github.com/DECODEproject/iotencoder/pkg/archive"
+	"github.com/DECODEproject/iotencoder/pkg/audit"
+	"github.com/DECODEproject/iotencoder/pkg/cache"
+	"github.com/DECODEproject/iotencoder/pkg/chaos"
 	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/coap"
+	"github.com/DECODEproject/iotencoder/pkg/dedup"
+	"github.com/DECODEproject/iotencoder/pkg/downsample"
+	"github.com/DECODEproject/iotencoder/pkg/erasure"
+	"github.com/DECODEproject/iotencoder/pkg/events"
+	"github.com/DECODEproject/iotencoder/pkg/geofence"
+	"github.com/DECODEproject/iotencoder/pkg/geoprivacy"
+	"github.com/DECODEproject/iotencoder/pkg/ingest"
+	"github.com/DECODEproject/iotencoder/pkg/kafka"
+	"github.com/DECODEproject/iotencoder/pkg/logger"
+	"github.com/DECODEproject/iotencoder/pkg/logger/level"
+	"github.com/DECODEproject/iotencoder/pkg/lua"
 	"github.com/DECODEproject/iotencoder/pkg/mqtt"
+	"github.com/DECODEproject/iotencoder/pkg/outbox"
+	"github.com/DECODEproject/iotencoder/pkg/partition"
 	"github.com/DECODEproject/iotencoder/pkg/pipeline"
+	"github.com/DECODEproject/iotencoder/pkg/policystore"
 	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/purge"
+	"github.com/DECODEproject/iotencoder/pkg/quota"
+	"github.com/DECODEproject/iotencoder/pkg/redis"
+	deviceregistry "github.com/DECODEproject/iotencoder/pkg/registry"
+	"github.com/DECODEproject/iotencoder/pkg/reload"
+	"github.com/DECODEproject/iotencoder/pkg/replay"
+	"github.com/DECODEproject/iotencoder/pkg/retention"
 	"github.com/DECODEproject/iotencoder/pkg/rpc"
+	"github.com/DECODEproject/iotencoder/pkg/sampling"
+	"github.com/DECODEproject/iotencoder/pkg/schema"
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+	"github.com/DECODEproject/iotencoder/pkg/settings"
+	"github.com/DECODEproject/iotencoder/pkg/shedding"
+	"github.com/DECODEproject/iotencoder/pkg/signing"
+	"github.com/DECODEproject/iotencoder/pkg/sink"
+	"github.com/DECODEproject/iotencoder/pkg/snapshot"
+	"github.com/DECODEproject/iotencoder/pkg/stats"
 	"github.com/DECODEproject/iotencoder/pkg/system"
+	"github.com/DECODEproject/iotencoder/pkg/throttle"
+	"github.com/DECODEproject/iotencoder/pkg/ttn"
+	"github.com/DECODEproject/iotencoder/pkg/verify"
 	"github.com/DECODEproject/iotencoder/pkg/version"
 )
 
+const (
+	// verifyWriteInterval is the interval at which sampled writes which are due
+	// are read back from the datastore.
+	verifyWriteInterval = 10 * time.Second
+
+	// verifyWriteMaxPending is the maximum number of sampled writes waiting to be
+	// read back, beyond which further samples are skipped.
+	verifyWriteMaxPending = 1000
+
+	// DefaultShutdownTimeout is the time for which we wait for active HTTP
+	// connections to complete when shutting down, if not configured.
+	DefaultShutdownTimeout = 5 * time.Second
+)
+
+// ErrForcedShutdown is returned by Start and Stop when active HTTP connections
+// did not complete within the shutdown timeout, and so were closed.
+var ErrForcedShutdown = errors.New("shutdown forced before connections were drained")
+
 var (
-	buildInfo = prometheus.NewGaugeVec(
+	// versionInfo exposes the full details of the current build, allowing
+	// deployed versions to be audited across instances
+	versionInfo = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
-			Namespace: "decode",
-			Subsystem: "encoder",
+			Namespace: "iotencoder",
 			Name:      "build_info",
-			Help:      "Information about the current build of the service",
-		}, []string{"name", "version", "build_date"},
+			Help:      "Version, git commit, build date and Go version of the current build",
+		}, []string{"version", "commit", "build_date", "go_version"},
 	)
 )
 
 func init() {
-	registry.MustRegister(buildInfo)
+	registry.MustRegister(versionInfo)
 	registry.MustRegister(mqtt.MessageCounter)
+	registry.MustRegister(mqtt.InflightGauge)
+	registry.MustRegister(mqtt.FailoverCounter)
+	registry.MustRegister(mqtt.ActiveBrokerGauge)
 	registry.MustRegister(pipeline.DatastoreErrorCounter)
 	registry.MustRegister(pipeline.ZenroomErrorCounter)
-	registry.MustRegister(pipeline.DatastoreWriteHistogram)
 	registry.MustRegister(pipeline.ProcessHistogram)
-	registry.MustRegister(pipeline.ZenroomHistogram)
+	registry.MustRegister(pipeline.ZenroomTimeoutCounter)
+	registry.MustRegister(pipeline.ZenroomInflightGauge)
+	registry.MustRegister(pipeline.DeadlineExceededCounter)
+	registry.MustRegister(pipeline.DatastoreClientsGauge)
+	registry.MustRegister(pipeline.DatastoreTimeoutCounter)
+	registry.MustRegister(pipeline.DownsampledCounter)
+	registry.MustRegister(pipeline.SampledOutCounter)
+	registry.MustRegister(pipeline.OutsideScheduleCounter)
+	registry.MustRegister(pipeline.UnchangedCounter)
+	registry.MustRegister(pipeline.RejectedReadingsCounter)
+	registry.MustRegister(pipeline.ConversionFailuresCounter)
+	registry.MustRegister(pipeline.TimestampPolicyCounter)
+	registry.MustRegister(pipeline.ChunkedPayloadsCounter)
+	registry.MustRegister(pipeline.PanicCounter)
+	registry.MustRegister(pipeline.DispositionsCounter)
+	registry.MustRegister(pipeline.NoisedValuesCounter)
+	registry.MustRegister(pipeline.PrivacyBudgetExhaustedCounter)
+	registry.MustRegister(pipeline.StageDurationSummary)
+	registry.MustRegister(pipeline.StageErrorsCounter)
+	registry.MustRegister(pipeline.ClockAheadCounter)
+	registry.MustRegister(geoprivacy.SuppressedCounter)
+	registry.MustRegister(geofence.OutsideCounter)
+	registry.MustRegister(coap.RequestsCounter)
+	registry.MustRegister(ingest.PushRequestsCounter)
+	registry.MustRegister(ttn.UplinksCounter)
+	registry.MustRegister(amqp.MessageCounter)
+	registry.MustRegister(kafka.MessageCounter)
 	registry.MustRegister(postgres.StreamGauge)
+	registry.MustRegister(postgres.QueryHistogram)
+	registry.MustRegister(postgres.QueryErrorsCounter)
+	registry.MustRegister(postgres.ConnectionsGauge)
+	registry.MustRegister(postgres.WaitCountGauge)
+	registry.MustRegister(postgres.WaitDurationGauge)
+	registry.MustRegister(partition.OwnedDevicesGauge)
+	registry.MustRegister(partition.LiveInstancesGauge)
+	registry.MustRegister(partition.LeaseChangesCounter)
+	registry.MustRegister(system.ComponentDurationGauge)
+	registry.MustRegister(system.ComponentFailuresCounter)
+	registry.MustRegister(dedup.DuplicateCounter)
+	registry.MustRegister(cache.LookupsCounter)
+	registry.MustRegister(reload.ChangesCounter)
+	registry.MustRegister(settings.ReloadsCounter)
+	registry.MustRegister(outbox.LagGauge)
+	registry.MustRegister(outbox.PendingGauge)
+	registry.MustRegister(outbox.DeadGauge)
+	registry.MustRegister(outbox.DeliveriesCounter)
+	registry.MustRegister(rpc.MessagesCounter)
+	registry.MustRegister(rpc.QueueDepthGauge)
+	registry.MustRegister(rpc.DeprecatedCounter)
+	registry.MustRegister(schema.ViolationsCounter)
+	registry.MustRegister(chaos.FaultsCounter)
+	registry.MustRegister(sink.DryRunCounter)
+	registry.MustRegister(sink.DryRunBytesCounter)
+	registry.MustRegister(quota.ExceededCounter)
+	registry.MustRegister(verify.VerificationsCounter)
+	registry.MustRegister(events.EventsCounter)
+	registry.MustRegister(events.DroppedCounter)
+	registry.MustRegister(archive.ObjectsCounter)
+	registry.MustRegister(analytics.ExportsCounter)
+	registry.MustRegister(erasure.DeletionsCounter)
+	registry.MustRegister(shedding.ShedCounter)
+	registry.MustRegister(shedding.LevelGauge)
 }
 
 // Config is a top level config object. Populated by viper in the command setup,
-// we then pass down config to the right places.
+// we then pass down config to the right places. ServerHooks is not set from the
+// command line, but allows callers embedding this package to add their own
+// twirp hooks, e.g. for authentication or logging, which are chained after our
+// own. Chaos is optional, and if set configures the faults injected into calls
+// to our dependencies. CommunityQuotas holds the quotas of each community, and
+// if empty no community is limited. If VerifyWriteRate is greater than zero
+// that fraction of datastore writes are read back after VerifyWriteDelay, and
+// must have been recorded within VerifyWriteWindow of being written. Archiver
+// is optional, and if set the payloads of archived streams are written to it.
+// Streams are exported for analytics every AnalyticsInterval to AnalyticsDir
+// and/or AnalyticsUploader, if either is set. DataDeletion must be set for
+// streams to be deleted along with their data, which requires our datastores
+// to support deletion. SettingsLoader is optional, and if set the settings
+// which may be changed while we are running are reloaded from it on SIGHUP or
+// via the admin API. ShutdownTimeout bounds the time for which active HTTP
+// connections are drained when shutting down, DefaultShutdownTimeout if zero.
 type Config struct {
 	ListenAddr         string
+	AdminAddr          string
+	StorageBackend     string
+	StoragePath        string
 	ConnStr            string
-	EncryptionPassword string
+	EncryptionPassword secret.Secret
 	DatastoreAddr      string
+	DatastoreHosts     []string
+	Sink               string
 	Verbose            bool
+	Leveler            *logger.Leveler
 	BrokerAddr         string
 	BrokerUsername     string
+	MQTTClientIDPrefix string
+	MQTTPersistent     bool
+	MQTTStorePath      string
+	MQTTSharedGroup    string
+	MQTTVersion        int
+	MQTTInflight       int
+	MQTTFailover       []string
+	MQTTFailback       time.Duration
 	Domains            []string
+	AdminToken         string
+	ExportKey          string
+	CertFile           string
+	KeyFile            string
+	HTTP2              bool
+	ReadTimeout        time.Duration
+	WriteTimeout       time.Duration
+	IdleTimeout        time.Duration
+	StatsInterval      time.Duration
+	DownsampleInterval time.Duration
+	ScriptDir          string
+	Scripts            map[string]string
+	SensorRanges       map[int]pipeline.Range
+	ZenroomPoolSize    int
+	ZenroomTimeout     time.Duration
+	ChunkSize          int
+	EntryMetadata      bool
+	Signer             *signing.Signer
+	Archiver           *archive.Archiver
+	Redis              *redigo.Pool
+	AnalyticsDir       string
+	AnalyticsInterval  time.Duration
+	AnalyticsUploader  analytics.Uploader
+	DataDeletion       bool
+	Chaos              *chaos.Config
+	CommunityQuotas    map[string]*quota.Limits
+	VerifyWriteRate    float64
+	VerifyWriteDelay   time.Duration
+	VerifyWriteWindow  time.Duration
+	AllowPlaintext     bool
+	MessageTimeout     time.Duration
+	DatastoreTimeout   time.Duration
+	IngestBatchSize    int
+	PushMaxBodySize    int64
+	PushMaxSkew        time.Duration
+	CoAPAddr           string
+	TTNBroker          string
+	TTNUsername        string
+	TTNAPIKey          string
+	AMQPURL            string
+	AMQPExchange       string
+	AMQPQueue          string
+	AMQPPrefetch       int
+	KafkaBrokers       []string
+	KafkaTopics        []string
+	KafkaGroupID       string
+	DefaultSource      string
+	Maintenance        bool
+	Profiling          bool
+	RequestSampleRate  float64
+	Partitioned        bool
+	InstanceID         string
+	LeaseTTL           time.Duration
+	LeaseInterval      time.Duration
+	HotReload          bool
+	Outbox             bool
+	OutboxInterval     time.Duration
+	OutboxBatchSize    int
+	OutboxRetry        time.Duration
+	OutboxMaxAttempts  int
+	RPCBuckets         []float64
+	EncryptionBuckets  []float64
+	DatastoreBuckets   []float64
+	IngestionBuckets   []float64
+	BrokerTimestamp    string
+	MetricLabels       []string
+	AutoMigrate        bool
+	RetentionBackend   string
+	RetentionDir       string
+	RetentionTTL       time.Duration
+	RetentionMaxSize   int64
+	RetentionInterval  time.Duration
+	DeletedStreamTTL   time.Duration
+	PurgeInterval      time.Duration
+	RestoreConcurrency int
+	ReadyThreshold     float64
+	Workers            int
+	DeviceQueueLimit   int
+	SheddingThreshold  int
+	SheddingPeriod     time.Duration
+	DedupTTL           time.Duration
+	DedupSize          int
+	DedupPersist       bool
+	DedupInterval      time.Duration
+	DeviceCacheTTL     time.Duration
+	PolicyStoreAddr    string
+	PolicyInterval     time.Duration
+	RegistryURL        string
+	RegistryToken      string
+	StatsToken         string
+	SnapshotInterval   time.Duration
+	ShutdownTimeout    time.Duration
+	SettingsLoader     settings.Loader
+	ServerHooks        []*twirp.ServerHooks
 }
 
 // Server is our top level type, contains all other components, is responsible
 // for starting and stopping them in the correct order.
 type Server struct {
-	srv     *http.Server
-	encoder encoder.Encoder
-	db      *postgres.DB
-	mqtt    mqtt.Client
-	logger  kitlog.Logger
-	domains []string
+	srv       *http.Server
+	listener  net.Listener
+	db        Storage
+	lifecycle *system.Lifecycle
+	logger    kitlog.Logger
+	domains   []string
+
+	adminSrv      *http.Server
+	adminListener net.Listener
+
+	certFile string
+	keyFile  string
+
+	autoMigrate     bool
+	shutdownTimeout time.Duration
 }
 
 // PulseHandler is the simplest possible handler function - used to expose an
 // endpoint which a load balancer can ping to verify that a node is running and
 // accepting connections.
-func PulseHandler(db *postgres.DB) http.Handler {
+func PulseHandler(db Storage) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		err := db.Ping()
 		if err != nil {
@@ -91,40 +352,727 @@ func PulseHandler(db *postgres.DB) http.Handler {
 	})
 }
 
+// Check is a named component whose health is consulted by ReadyzHandler.
+type Check struct {
+	Name      string
+	Checkable system.Checkable
+}
+
+// LivezHandler returns a handler for liveness probes, which reports only that
+// the process is running and serving requests. It deliberately checks no
+// dependencies, so that an outage of one doesn't cause the encoder to be
+// restarted.
+func LivezHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "ok")
+	})
+}
+
+// ReadyzHandler returns a handler for readiness probes, which reports whether
+// the encoder is ready to serve traffic by consulting each of the given checks.
+// If any fail it responds with a 503 listing each failure.
+func ReadyzHandler(checks []Check) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		failures := []string{}
+
+		for _, c := range checks {
+			err := c.Checkable.Check()
+			if err != nil {
+				failures = append(failures, fmt.Sprintf("%s: %v", c.Name, err))
+			}
+		}
+
+		if len(failures) > 0 {
+			http.Error(w, strings.Join(failures, "\n"), http.StatusServiceUnavailable)
+			return
+		}
+
+		fmt.Fprintf(w, "ok")
+	})
+}
+
+// VersionHandler returns a handler which writes the details of the current
+// build as JSON.
+func VersionHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		err := json.NewEncoder(w).Encode(version.GetInfo())
+		if err != nil {
+			http.Error(w, "failed to encode version", http.StatusInternalServerError)
+		}
+	})
+}
+
+// Datastores returns the default datastore and the factory of clients for
+// streams which write to their own datastore, given those which write to real
+// datastores. If a dry-run sink is configured both discard payloads instead,
+// and faults from the given injector, which may be nil, are injected into
+// whichever are in use, so that injecting faults never bypasses the sink.
+func Datastores(config *Config, ds pipeline.Datastore, factory pipeline.DatastoreFactory, injector *chaos.Injector, logger kitlog.Logger) (pipeline.Datastore, pipeline.DatastoreFactory) {
+	if sink.DryRun(config.Sink) {
+		dryRun := sink.NewDryRunDatastore(config.Sink, logger)
+
+		ds = dryRun
+		factory = func(addr string) pipeline.Datastore {
+			return dryRun
+		}
+	}
+
+	if injector != nil {
+		ds = injector.Datastore(ds)
+
+		wrapped := factory
+		factory = func(addr string) pipeline.Datastore {
+			return injector.Datastore(wrapped(addr))
+		}
+	}
+
+	return ds, factory
+}
+
 // NewServer returns a new simple HTTP server. Is also responsible for
 // constructing all components, and injecting them into the right place. This
-// perhaps belongs elsewhere, but leaving here for now.
-func NewServer(config *Config, logger kitlog.Logger) *Server {
-	db := postgres.NewDB(&postgres.Config{
-		ConnStr:            config.ConnStr,
-		EncryptionPassword: config.EncryptionPassword,
+// perhaps belongs elsewhere, but leaving here for now. Any given Options may
+// supply components in place of those we would create from the Config.
+func NewServer(config *Config, logger kitlog.Logger, opts ...Option) *Server {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	// our latency histograms are created with the configured buckets before
+	// being registered, so they must be set up before any other component
+	pipeline.SetBuckets(&pipeline.Buckets{
+		Datastore:  config.DatastoreBuckets,
+		Encryption: config.EncryptionBuckets,
+		Ingestion:  config.IngestionBuckets,
+	})
+	rpc.SetBuckets(config.RPCBuckets)
+	pipeline.SetStreamLabels(config.MetricLabels)
+
+	registry.MustRegister(pipeline.DatastoreWriteHistogram)
+	registry.MustRegister(pipeline.ZenroomHistogram)
+	registry.MustRegister(rpc.RequestsCounter)
+	registry.MustRegister(rpc.ResponsesCounter)
+	registry.MustRegister(rpc.DurationHistogram)
+	registry.MustRegister(pipeline.StreamMessagesCounter)
+	registry.MustRegister(pipeline.IngestionLagHistogram)
+
+	// pg is nil unless streams are stored in Postgres
+	db, pg := newStorage(config, logger)
+
+	datastoreClient := &http.Client{
+		Timeout: time.Second * 10,
+	}
+
+	ds := o.datastore
+	if ds == nil {
+		ds = datastore.NewDatastoreProtobufClient(config.DatastoreAddr, datastoreClient)
+	}
+
+	datastoreFactory := pipeline.NewDatastoreFactory(datastoreClient)
+	zenroomExec := pipeline.ExecFunc(pipeline.ZenroomExec)
+
+	// payloads are validated and counted but written nowhere if a dry-run sink
+	// is configured, which should only be the case in staging
+	dryRun := sink.DryRun(config.Sink)
+	if dryRun {
+		level.Warn(logger).Log("msg", "discarding encrypted payloads rather than writing them", "sink", config.Sink)
+	}
+
+	// faults are only injected into calls to our dependencies if configured,
+	// which should only be the case in staging
+	var (
+		faults   mqtt.Faults
+		injector *chaos.Injector
+	)
+
+	if config.Chaos != nil && config.Chaos.Enabled() {
+		injector = chaos.NewInjector(config.Chaos)
+
+		level.Warn(logger).Log(
+			"msg", "injecting faults into calls to dependencies",
+			"datastore_error_rate", config.Chaos.DatastoreErrorRate,
+			"mqtt_disconnect_rate", config.Chaos.MQTTDisconnectRate,
+			"zenroom_delay_rate", config.Chaos.ZenroomDelayRate,
+			"zenroom_delay", config.Chaos.ZenroomDelay,
+		)
+
+		zenroomExec = pipeline.ExecFunc(injector.Exec(chaos.ExecFunc(zenroomExec)))
+
+		if config.Chaos.MQTTDisconnectRate > 0 {
+			faults = injector
+		}
+	}
+
+	ds, datastoreFactory = Datastores(config, ds, datastoreFactory, injector, logger)
+
+	// streams may write to their own datastore on one of the allowed hosts, for
+	// which clients are created on first use and shared by address
+	datastores := pipeline.NewDatastorePool(ds, datastoreFactory, config.DatastoreHosts)
+
+	// moving average windows are shared with other instances via redis if
+	// configured, so that each holds every reading of a device whichever
+	// instance received it
+	var (
+		stateStore pipeline.StateStore
+		redisStore *redis.StateStore
+	)
+
+	if config.Redis != nil {
+		redisStore = redis.NewStateStore(config.Redis, "")
+		stateStore = redisStore
+	}
+
+	mv := pipeline.NewMovingAverager(stateStore, config.Verbose, clock.New(), logger)
+
+	st := stats.NewStore(db, config.StatsInterval, clock.New(), logger)
+
+	samples := downsample.NewStore(db, config.DownsampleInterval, clock.New(), logger)
+
+	scripts := lua.NewScripts(&lua.Config{
+		Dir:     config.ScriptDir,
+		Mapping: config.Scripts,
 	}, logger)
 
-	ds := datastore.NewDatastoreProtobufClient(
-		config.DatastoreAddr,
-		&http.Client{
-			Timeout: time.Second * 10,
-		},
+	// streams may reference a payload schema against which their readings are
+	// validated, versions resolved by reference being cached
+	schemas := schema.NewRegistry(&schema.Config{
+		Store: db,
+	}, logger)
+
+	sampled := sampling.NewSampler()
+
+	pipelineConfig := &pipeline.Config{
+		Datastore:        ds,
+		Datastores:       datastores,
+		MovingAverager:   mv,
+		Downsampler:      samples,
+		ChangeDetector:   pipeline.NewChangeDetector(),
+		Stats:            st,
+		Scripts:          scripts,
+		Zenroom:          pipeline.NewZenroomPool(config.ZenroomPoolSize, config.ZenroomTimeout, zenroomExec),
+		Schemas:          schemas,
+		Ledger:           db,
+		Cells:            geoprivacy.NewTracker(clock.New()),
+		Limiter:          throttle.NewLimiter(clock.New()),
+		Sampler:          sampled,
+		ChunkSize:        config.ChunkSize,
+		DatastoreTimeout: config.DatastoreTimeout,
+		Metadata:         config.EntryMetadata,
+		Signer:           config.Signer,
+		AllowPlaintext:   config.AllowPlaintext,
+		Verbose:          config.Verbose,
+
+		BrokerTimestampProperty: config.BrokerTimestamp,
+	}
+
+	if len(config.SensorRanges) > 0 {
+		pipelineConfig.Ranges = pipeline.NewRangeFilter(config.SensorRanges)
+	}
+
+	// a sample of writes is read back from the datastore if configured, to
+	// catch writes silently lost, corrupted or misrouted by the datastore
+	var verifier *verify.Verifier
+
+	if config.VerifyWriteRate > 0 {
+		verifier = verify.NewVerifier(&verify.Config{
+			Rate:       config.VerifyWriteRate,
+			Delay:      config.VerifyWriteDelay,
+			Window:     config.VerifyWriteWindow,
+			Interval:   verifyWriteInterval,
+			MaxPending: verifyWriteMaxPending,
+			Clock:      clock.New(),
+		}, logger)
+		pipelineConfig.Verifier = verifier
+	}
+
+	// the encrypted payloads of archived streams are also written to object
+	// storage if an archive is configured, unless payloads are being discarded
+	if config.Archiver != nil {
+		if dryRun {
+			level.Warn(logger).Log("msg", "not archiving payloads as they are being discarded", "sink", config.Sink)
+		} else {
+			pipelineConfig.Archiver = config.Archiver
+		}
+	}
+
+	// communities are limited in the streams they create and the messages of
+	// their streams we process if quotas are configured, or may be configured
+	// when our settings are reloaded
+	var quotas *quota.Quotas
+
+	if len(config.CommunityQuotas) > 0 || config.SettingsLoader != nil {
+		quotas = quota.NewQuotas(config.CommunityQuotas, clock.New())
+		pipelineConfig.Quotas = quotas
+	}
+
+	// the messages of low priority streams are shed while too many messages are
+	// waiting for our workers if configured, the depth of their queues being
+	// read from the encoder once it has been created
+	var (
+		enc     encoder.Encoder
+		shedder *shedding.Shedder
 	)
 
-	mv := pipeline.NewMovingAverager(config.Verbose, clock.New(), logger)
+	if config.SheddingThreshold > 0 && config.Workers > 0 {
+		shedder = shedding.NewShedder(&shedding.Config{
+			Queue: shedding.QueueFunc(func() int {
+				return enc.(shedding.Queue).QueueDepth()
+			}),
+			Threshold: config.SheddingThreshold,
+			Period:    config.SheddingPeriod,
+			Clock:     clock.New(),
+		}, logger)
+		pipelineConfig.Shedder = shedder
+	}
+
+	// encrypted payloads are queued in an outbox in Postgres if configured,
+	// from which they are delivered to the datastore by a dispatcher
+	if config.Outbox && pg != nil {
+		pipelineConfig.Outbox = pg
+	}
+
+	processor := pipeline.NewProcessor(pipelineConfig, logger)
+	for _, s := range o.stages {
+		processor.Use(s.phase, s.factory)
+	}
+
+	var dispatcher *outbox.Dispatcher
+
+	if pipelineConfig.Outbox != nil {
+		dispatcher = outbox.NewDispatcher(&outbox.Config{
+			Store:         pg,
+			Deliverer:     processor,
+			Interval:      config.OutboxInterval,
+			BatchSize:     config.OutboxBatchSize,
+			RetryInterval: config.OutboxRetry,
+			MaxAttempts:   config.OutboxMaxAttempts,
+			Clock:         clock.New(),
+		}, logger)
+	}
+
+	// changes to our subscriptions and streams are published on the event bus,
+	// from which they are counted and held for the admin API
+	bus := events.NewBus(events.DefaultBufferSize, logger)
+	recorder := events.NewRecorder(events.DefaultRecorderSize)
+
+	bus.Subscribe("metrics", events.Count)
+	bus.Subscribe("admin", recorder.Record)
+
+	// the sampling sequence of a deleted stream is no longer needed
+	bus.Subscribe("sampling", func(event *events.Event) {
+		if event.Type == events.StreamDeleted {
+			sampled.Forget(event.StreamID)
+		}
+	})
+
+	mqttClient := o.mqttClient
+	if mqttClient == nil {
+		mqttClient = mqtt.NewClient(&mqtt.Config{
+			ClientIDPrefix:    config.MQTTClientIDPrefix,
+			PersistentSession: config.MQTTPersistent,
+			StorePath:         config.MQTTStorePath,
+			SharedGroup:       config.MQTTSharedGroup,
+			ProtocolVersion:   config.MQTTVersion,
+			Inflight:          config.MQTTInflight,
+			Failover:          config.MQTTFailover,
+			FailbackInterval:  config.MQTTFailback,
+			Faults:            faults,
+			Events:            bus,
+			Verbose:           config.Verbose,
+		}, logger)
+	}
 
-	processor := pipeline.NewProcessor(ds, mv, config.Verbose, logger)
+	maintenance := rpc.NewMaintenance(config.Maintenance)
 
-	mqttClient := mqtt.NewClient(logger, config.Verbose)
+	// readiness records the progress of restoring subscriptions on startup
+	readiness := rpc.NewReadiness(config.ReadyThreshold)
 
-	enc := rpc.NewEncoder(&rpc.Config{
-		DB:             db,
+	// every call which mutates a stream is recorded in an append only audit log
+	auditor := audit.NewRecorder(&audit.Config{
+		Store: db,
+		Clock: clock.New(),
+	}, logger)
+
+	// the device and streams loaded for each incoming message are cached if
+	// configured, every change to streams made through the cache invalidating
+	// the affected device
+	var devices cache.Store = db
+
+	if config.DeviceCacheTTL > 0 {
+		devices = cache.NewCache(&cache.Config{
+			Store: db,
+			TTL:   config.DeviceCacheTTL,
+			Clock: clock.New(),
+		}, logger)
+	}
+
+	// messages redelivered by their source are detected and dropped, across
+	// restarts if message keys are persisted
+	var dedupStore *dedup.Store
+
+	if config.DedupTTL > 0 {
+		dedupConfig := &dedup.Config{
+			TTL:      config.DedupTTL,
+			Size:     config.DedupSize,
+			Interval: config.DedupInterval,
+			Clock:    clock.New(),
+		}
+
+		if config.DedupPersist {
+			dedupConfig.Persister = db
+		}
+
+		dedupStore = dedup.NewStore(dedupConfig, logger)
+	}
+
+	rpcConfig := &rpc.Config{
+		DB:             devices,
 		MQTTClient:     mqttClient,
 		Processor:      processor,
 		Verbose:        config.Verbose,
 		BrokerAddr:     config.BrokerAddr,
 		BrokerUsername: config.BrokerUsername,
+		MessageTimeout: config.MessageTimeout,
+		Maintenance:    maintenance,
+		Datastores:     datastores,
+		DefaultSource:  config.DefaultSource,
+		Auditor:        auditor,
+		Readiness:      readiness,
+		Schemas:        schemas,
+		AllowPlaintext: config.AllowPlaintext,
+		Quotas:         quotas,
+		Events:         bus,
+		Archiving:      pipelineConfig.Archiver != nil,
+
+		RestoreConcurrency: config.RestoreConcurrency,
+		Workers:            config.Workers,
+		DeviceQueueLimit:   config.DeviceQueueLimit,
+	}
+
+	if dedupStore != nil {
+		rpcConfig.Deduplicator = dedupStore
+	}
+
+	// streams may be deleted along with their data only if our datastores
+	// support deletion, which must be enabled, and never when payloads are
+	// being discarded, as their data is then in no datastore
+	if config.DataDeletion && dryRun {
+		level.Warn(logger).Log("msg", "not deleting data of deleted streams as payloads are being discarded", "sink", config.Sink)
+	} else if config.DataDeletion {
+		rpcConfig.DataDeleter = erasure.NewClient(&erasure.Config{
+			DefaultAddr: config.DatastoreAddr,
+			HTTPClient:  datastoreClient,
+		}, logger)
+	}
+
+	// devices must be known to an external registry for streams to be created
+	// for them if a registry is configured
+	if config.RegistryURL != "" {
+		rpcConfig.Verifier = deviceregistry.NewVerifier(&deviceregistry.Config{
+			URL:       config.RegistryURL,
+			AuthToken: secret.Secret(config.RegistryToken),
+			HTTPClient: &http.Client{
+				Timeout: 10 * time.Second,
+			},
+		}, logger)
+	}
+
+	// streams may reference a policy registered with the policy store in place
+	// of a public key if a policy store is configured, in which case keys
+	// rotated in the store are periodically applied to their streams
+	var refresher *policystore.Refresher
+
+	if config.PolicyStoreAddr != "" {
+		policies := policystore.NewClient(&policystore.Config{
+			Addr:  config.PolicyStoreAddr,
+			TTL:   config.PolicyInterval,
+			Clock: clock.New(),
+			HTTPClient: &http.Client{
+				Timeout: 10 * time.Second,
+			},
+		}, logger)
+
+		rpcConfig.Policies = policies
+
+		refresher = policystore.NewRefresher(&policystore.RefresherConfig{
+			Client:   policies,
+			Store:    devices,
+			Interval: config.PolicyInterval,
+		}, logger)
+	}
+
+	// readings may also be consumed from an AMQP broker, selected per stream or
+	// as the default source, if a broker is configured
+	var amqpConsumer *amqp.Consumer
+
+	if config.AMQPURL != "" {
+		amqpConsumer = amqp.NewConsumer(&amqp.Config{
+			URL:      config.AMQPURL,
+			Exchange: config.AMQPExchange,
+			Queue:    config.AMQPQueue,
+			Prefetch: config.AMQPPrefetch,
+		}, logger)
+
+		rpcConfig.Sources = map[string]rpc.Source{
+			rpc.AMQPSource: amqpConsumer,
+		}
+	}
+
+	// or from Kafka topics if brokers are configured
+	var kafkaConsumer *kafka.Consumer
+
+	if len(config.KafkaBrokers) > 0 {
+		kafkaConsumer = kafka.NewConsumer(&kafka.Config{
+			Brokers: config.KafkaBrokers,
+			Topics:  config.KafkaTopics,
+			GroupID: config.KafkaGroupID,
+		}, logger)
+
+		if rpcConfig.Sources == nil {
+			rpcConfig.Sources = map[string]rpc.Source{}
+		}
+
+		rpcConfig.Sources[rpc.KafkaSource] = kafkaConsumer
+	}
+
+	// when partitioned, devices are shared between all instances using the same
+	// database rather than every instance subscribing to every device
+	if config.Partitioned {
+		rpcConfig.Partitioner = partition.NewCoordinator(&partition.Config{
+			Store:      pg,
+			InstanceID: config.InstanceID,
+			TTL:        config.LeaseTTL,
+			Interval:   config.LeaseInterval,
+		}, logger)
+	}
+
+	adminConfig := &admin.Config{
+		Token:       secret.Secret(config.AdminToken),
+		ExportKey:   secret.Secret(config.ExportKey),
+		Stats:       st,
+		Streams:     db,
+		Exporter:    db,
+		Conversions: devices,
+		Secrets:     devices,
+		Auditor:     auditor,
+		AuditLog:    db,
+		Maintenance: maintenance,
+		Schemas:     schemas,
+		Events:      recorder,
+		Profiling:   config.Profiling,
+	}
+
+	if config.Leveler != nil {
+		adminConfig.Levels = config.Leveler
+	}
+
+	// stream metadata and statistics are exported for analytics only if we
+	// have somewhere to write them
+	var analyticsExporter *analytics.Exporter
+
+	if config.AnalyticsDir != "" || config.AnalyticsUploader != nil {
+		analyticsExporter = analytics.NewExporter(&analytics.Config{
+			Streams:  db,
+			Stats:    st,
+			Dir:      config.AnalyticsDir,
+			Uploader: config.AnalyticsUploader,
+			Interval: config.AnalyticsInterval,
+			Clock:    clock.New(),
+		}, logger)
+
+		adminConfig.Analytics = analyticsExporter
+	}
+
+	var (
+		rp      *replay.Replayer
+		janitor *retention.Janitor
+	)
+
+	// raw payloads are only retained, and so replays are only possible, if a
+	// retention backend is configured
+	retentionStore := newRetentionStore(config, pg)
+	if retentionStore != nil {
+		rpcConfig.Retainer = retentionStore
+
+		janitor = retention.NewJanitor(&retention.Config{
+			Store:    retentionStore,
+			TTL:      config.RetentionTTL,
+			MaxSize:  config.RetentionMaxSize,
+			Interval: config.RetentionInterval,
+			Clock:    clock.New(),
+		}, logger)
+
+		rp = replay.NewReplayer(&replay.Config{
+			Streams:        db,
+			Payloads:       retentionStore,
+			Processor:      processor,
+			Clock:          clock.New(),
+			MessageTimeout: config.MessageTimeout,
+		}, logger)
+
+		adminConfig.Replay = rp
+	}
+
+	// batches of readings uploaded by devices which buffer offline are processed
+	// exactly as live messages, including being retained if configured
+	ingestConfig := &ingest.Config{
+		Streams:        db,
+		Processor:      processor,
+		Clock:          clock.New(),
+		MaxBatchSize:   config.IngestBatchSize,
+		MessageTimeout: config.MessageTimeout,
+	}
+
+	if retentionStore != nil {
+		ingestConfig.Retainer = retentionStore
+	}
+
+	ingester := ingest.NewIngester(ingestConfig, logger)
+	adminConfig.Ingester = ingester
+
+	// devices and gateways which can only make HTTP requests push signed
+	// payloads, accepted only for streams which have been given an ingest secret
+	pushConfig := &ingest.PushConfig{
+		Devices:        devices,
+		Processor:      processor,
+		Clock:          clock.New(),
+		MaxBodySize:    config.PushMaxBodySize,
+		MaxSkew:        config.PushMaxSkew,
+		MessageTimeout: config.MessageTimeout,
+	}
+
+	if retentionStore != nil {
+		pushConfig.Retainer = retentionStore
+	}
+
+	push := ingest.NewPushHandler(pushConfig, logger)
+
+	// constrained devices which speak CoAP rather than MQTT are only accepted if
+	// a listen address is configured
+	var coapServer *coap.Server
+
+	if config.CoAPAddr != "" {
+		coapConfig := &coap.Config{
+			Addr:           config.CoAPAddr,
+			Devices:        devices,
+			Processor:      processor,
+			Clock:          clock.New(),
+			MessageTimeout: config.MessageTimeout,
+		}
+
+		if retentionStore != nil {
+			coapConfig.Retainer = retentionStore
+		}
+
+		coapServer = coap.NewServer(coapConfig, logger)
+	}
+
+	// LoRaWAN devices are received from an application on The Things Network
+	// only if a TTN broker is configured
+	var ttnClient *ttn.Client
+
+	if config.TTNBroker != "" {
+		ttnConfig := &ttn.Config{
+			Broker:         config.TTNBroker,
+			Username:       config.TTNUsername,
+			Password:       secret.Secret(config.TTNAPIKey),
+			Devices:        devices,
+			Processor:      processor,
+			Clock:          clock.New(),
+			MessageTimeout: config.MessageTimeout,
+		}
+
+		if retentionStore != nil {
+			ttnConfig.Retainer = retentionStore
+		}
+
+		ttnClient = ttn.NewClient(ttnConfig, logger)
+	}
+
+	enc = rpc.NewEncoder(rpcConfig, logger)
+
+	// deleted streams are restored, and exported streams imported, via the admin
+	// API, as the encoder's protocol buffer definition only allows for creation
+	// and deletion
+	adminConfig.Restorer = enc.(admin.StreamRestorer)
+	adminConfig.Importer = enc.(admin.StreamImporter)
+
+	// streams migrated to another encoder are deleted via the encoder so that
+	// its device is unsubscribed from
+	adminConfig.Deleter = enc
+	adminConfig.HTTPClient = &http.Client{}
+
+	// changes made to streams directly in the database are applied as they
+	// are made if configured, which requires Postgres to notify us of them
+	var watcher *reload.Watcher
+
+	if config.HotReload && pg != nil {
+		reloadConfig := &reload.Config{
+			Store:   pg,
+			Encoder: enc.(reload.Reloader),
+		}
+
+		if c, ok := devices.(*cache.Cache); ok {
+			reloadConfig.Cache = c
+		}
+
+		watcher = reload.NewWatcher(reloadConfig, logger)
+	}
+
+	// settings which may be changed while we are running are reloaded on
+	// SIGHUP or via the admin API if we have somewhere to load them from
+	var reloader *settings.Reloader
+
+	if config.SettingsLoader != nil {
+		settingsConfig := &settings.Config{
+			Loader: config.SettingsLoader,
+			Current: &settings.Settings{
+				LogLevel:        level.InfoLevel,
+				CommunityQuotas: config.CommunityQuotas,
+				IngestBatchSize: config.IngestBatchSize,
+				OutboxBatchSize: config.OutboxBatchSize,
+			},
+			Quotas: quotas,
+			Ingest: ingester,
+		}
+
+		if config.Leveler != nil {
+			settingsConfig.Leveler = config.Leveler
+			settingsConfig.Current.LogLevel = config.Leveler.Level()
+		}
+
+		if dispatcher != nil {
+			settingsConfig.Outbox = dispatcher
+		}
+
+		reloader = settings.NewReloader(settingsConfig, logger)
+		adminConfig.Settings = reloader
+	}
+
+	adm := admin.NewAdmin(adminConfig, logger)
+
+	// deleted streams are retained for a grace period during which they may be
+	// restored, after which they are purged
+	purger := purge.NewJanitor(&purge.Config{
+		Store:       db,
+		GracePeriod: config.DeletedStreamTTL,
+		Interval:    config.PurgeInterval,
+		Clock:       clock.New(),
 	}, logger)
 
-	hooks := twrpprom.NewServerHooks(registry.DefaultRegisterer)
+	serverHooks := []*twirp.ServerHooks{
+		rpc.NewServerHooks(),
+	}
+	serverHooks = append(serverHooks, config.ServerHooks...)
+	serverHooks = append(serverHooks, o.hooks...)
+
+	hooks := twirp.ChainHooks(serverHooks...)
 
-	buildInfo.WithLabelValues(version.BinaryName, version.Version, version.BuildDate)
+	info := version.GetInfo()
+	versionInfo.WithLabelValues(info.Version, info.GitCommit, info.BuildDate, info.GoVersion).Set(1)
 
 	logger = kitlog.With(logger, "module", "server")
 	logger.Log(
@@ -135,123 +1083,472 @@ func NewServer(config *Config, logger kitlog.Logger) *Server {
 		"mqttUsername", config.BrokerAddr,
 	)
 
-	twirpHandler := encoder.NewEncoderServer(enc, hooks)
+	twirpHandler := encoder.NewEncoderServer(rpc.NewValidator(enc), hooks)
+
+	// the encoder is ready once the database is migrated, we are connected to
+	// our brokers, and enough subscriptions have been restored
+	checks := []Check{
+		{Name: "database", Checkable: db},
+	}
+
+	if c, ok := mqttClient.(system.Checkable); ok {
+		checks = append(checks, Check{Name: "mqtt", Checkable: c})
+	}
+
+	if redisStore != nil {
+		checks = append(checks, Check{Name: "redis", Checkable: redisStore})
+	}
+
+	checks = append(checks, Check{Name: "restore", Checkable: readiness})
 
 	// multiplex twirp handler into a mux with our other handlers
 	mux := goji.NewMux()
 
-	mux.Handle(pat.Post(encoder.EncoderPathPrefix+"*"), twirpHandler)
+	requestLogger := rpc.NewRequestLogger(config.RequestSampleRate, logger)
+
+	// the encoder API accepts gzip encoded requests, and compresses responses
+	// for clients which accept it
+	mux.Handle(pat.Post(encoder.EncoderPathPrefix+"*"), rpc.GzipMiddleware(requestLogger.Middleware(rpc.StreamOptionsMiddleware(twirpHandler))))
 	mux.Handle(pat.Get("/pulse"), PulseHandler(db))
+	mux.Handle(pat.Get("/livez"), LivezHandler())
+	mux.Handle(pat.Get("/readyz"), ReadyzHandler(checks))
+	mux.Handle(pat.Get("/version"), VersionHandler())
 	mux.Handle(pat.Get("/metrics"), promhttp.Handler())
 
-	mux.Use(middleware.RequestIDMiddleware)
+	// a JSON snapshot of key numbers is served to dashboards without a
+	// Prometheus server only if a token is configured with which to read it
+	var sampler *snapshot.Sampler
+
+	if config.StatsToken != "" {
+		sampler = snapshot.NewSampler(&snapshot.Config{
+			Gatherer: prometheus.DefaultGatherer,
+			Interval: config.SnapshotInterval,
+			Token:    secret.Secret(config.StatsToken),
+			Clock:    clock.New(),
+		}, logger)
+
+		mux.Handle(pat.Get(snapshot.Path), sampler)
+	}
 
 	metricsMiddleware := middleware.MetricsMiddleware("decode", "encoder", registry.DefaultRegisterer)
 	mux.Use(metricsMiddleware)
 
+	// pushed payloads are routed before our metrics middleware as their path
+	// contains the device token, which must not end up as a metric label
+	root := goji.NewMux()
+
+	root.Handle(pat.Post(ingest.PushPath), push)
+	root.Handle(pat.New("/*"), mux)
+
+	root.Use(middleware.RequestIDMiddleware)
+	root.Use(audit.CallerMiddleware)
+
+	// the admin API, along with the profiling handlers if enabled, is served
+	// only on its own listener, so that it may be bound to a private
+	// interface. It also refuses callers not presenting our admin token, so is
+	// unusable if none is configured
+	adminMux := goji.NewMux()
+
+	adminMux.Handle(pat.New(admin.PathPrefix+"*"), rpc.GzipMiddleware(adm.Handler()))
+
+	adminMux.Use(middleware.RequestIDMiddleware)
+	adminMux.Use(audit.CallerMiddleware)
+
 	// create our http.Server instance
 	srv := &http.Server{
-		Addr:    config.ListenAddr,
-		Handler: mux,
+		Addr:         config.ListenAddr,
+		Handler:      root,
+		ReadTimeout:  config.ReadTimeout,
+		WriteTimeout: config.WriteTimeout,
+		IdleTimeout:  config.IdleTimeout,
+		TLSConfig: &tls.Config{
+			MinVersion: tls.VersionTLS12,
+		},
 	}
 
-	// return the instantiated server
-	return &Server{
-		srv:     srv,
-		encoder: enc,
-		db:      db,
-		mqtt:    mqttClient,
-		logger:  kitlog.With(logger, "module", "server"),
-		domains: config.Domains,
+	adminSrv := &http.Server{
+		Addr:         config.AdminAddr,
+		Handler:      adminMux,
+		ReadTimeout:  config.ReadTimeout,
+		WriteTimeout: config.WriteTimeout,
+		IdleTimeout:  config.IdleTimeout,
+		TLSConfig: &tls.Config{
+			MinVersion: tls.VersionTLS12,
+		},
 	}
-}
 
-// Start starts the server running. This is responsible for starting components
-// in the correct order, and in addition we attempt to run all up migrations as
-// we start.
-//
-// We also create a channel listening for interrupt signals before gracefully
-// shutting down.
-func (s *Server) Start() error {
-	// start the postgres connection pool
-	err := s.db.Start()
-	if err != nil {
-		return errors.Wrap(err, "failed to start db")
+	// HTTP/2 is enabled by default when serving TLS, a non-nil empty map
+	// disables it
+	if !config.HTTP2 {
+		srv.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+		adminSrv.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
 	}
 
-	// migrate up the database
-	err = s.db.MigrateUp()
-	if err != nil {
-		return errors.Wrap(err, "failed to migrate the database")
+	shutdownTimeout := config.ShutdownTimeout
+	if shutdownTimeout <= 0 {
+		shutdownTimeout = DefaultShutdownTimeout
+	}
+
+	s := &Server{
+		srv:       srv,
+		listener:  o.listener,
+		db:        db,
+		lifecycle: system.NewLifecycle(logger),
+		logger:    kitlog.With(logger, "module", "server"),
+		domains:   config.Domains,
+
+		adminSrv:      adminSrv,
+		adminListener: o.adminListener,
+
+		certFile: config.CertFile,
+		keyFile:  config.KeyFile,
+
+		// the migrate commands only apply to Postgres, so other backends are
+		// always migrated as we start
+		autoMigrate: config.AutoMigrate || pg == nil,
+
+		shutdownTimeout: shutdownTimeout,
+	}
+
+	// register our components with the dependencies which determine the order
+	// in which they are started, and stopped in reverse
+	lc := s.lifecycle
+
+	lc.Register("db", db)
+
+	// migrate up the database, or if auto-migration is disabled check that the
+	// schema is up to date
+	lc.Register("migrations", system.Hooks{OnStart: s.migrate}, "db")
+
+	// the stats and downsample stores load previously persisted state
+	lc.Register("stats", st, "migrations")
+	lc.Register("samples", samples, "migrations")
+
+	// load zenroom scripts, watching the script directory if configured
+	lc.Register("scripts", scripts)
+
+	if janitor != nil {
+		lc.Register("retention", janitor, "migrations")
+	}
+
+	if dispatcher != nil {
+		lc.Register("outbox", dispatcher, "migrations", "stats")
+	}
+
+	lc.Register("purge", purger, "migrations")
+
+	if verifier != nil {
+		lc.Register("verify", verifier)
+	}
+
+	if refresher != nil {
+		lc.Register("policies", refresher, "migrations")
+	}
+
+	if sampler != nil {
+		lc.Register("snapshot", sampler)
+	}
+
+	if analyticsExporter != nil {
+		lc.Register("analytics", analyticsExporter, "migrations", "stats")
+	}
+
+	if rp != nil {
+		lc.Register("replay", rp, "migrations", "stats", "samples", "scripts")
+	}
+
+	// the event bus is stopped after every component which publishes to it
+	lc.Register("events", bus)
+
+	lc.Register("mqtt", mqttClient, "events")
+
+	encoderDeps := []string{"migrations", "stats", "samples", "scripts", "mqtt", "http", "events"}
+
+	// connections to redis are closed once the encoder has stopped processing
+	if redisStore != nil {
+		lc.Register("redis", redisStore)
+		encoderDeps = append(encoderDeps, "redis")
+	}
+
+	// persisted message keys are loaded before any messages are received
+	if dedupStore != nil {
+		lc.Register("dedup", dedupStore, "migrations")
+		encoderDeps = append(encoderDeps, "dedup")
+	}
+
+	// the AMQP broker must be connected to before the encoder subscribes to
+	// devices
+	if amqpConsumer != nil {
+		lc.Register("amqp", amqpConsumer)
+		encoderDeps = append(encoderDeps, "amqp")
+	}
+
+	// we start serving before the encoder restores subscriptions, so that the
+	// progress of restoration may be read from /readyz
+	lc.Register("http", system.Hooks{
+		OnStart: func() error {
+			go s.serve()
+			return nil
+		},
+		OnStop: s.shutdown,
+	}, "migrations", "stats", "samples", "scripts")
+
+	lc.Register("admin", system.Hooks{
+		OnStart: func() error {
+			go s.serveAdmin()
+			return nil
+		},
+		OnStop: s.shutdownAdmin,
+	}, "migrations", "stats", "samples", "scripts")
+
+	// the encoder creates all subscriptions
+	lc.Register("encoder", enc, encoderDeps...)
+
+	// Kafka consumption starts after the encoder has subscribed to devices, as
+	// messages for unknown devices are dropped, and stops before the encoder
+	// unsubscribes so that no messages are committed without being processed
+	if kafkaConsumer != nil {
+		lc.Register("kafka", kafkaConsumer, "encoder")
+	}
+
+	if coapServer != nil {
+		lc.Register("coap", coapServer, "encoder")
 	}
 
-	// start the encoder RPC component - this creates all mqtt subscriptions
-	err = s.encoder.(system.Startable).Start()
+	if ttnClient != nil {
+		lc.Register("ttn", ttnClient, "encoder")
+	}
+
+	// the depth of the encoder's queues is watched only while it is running
+	if shedder != nil {
+		lc.Register("shedding", shedder, "encoder")
+	}
+
+	// changes are applied once the encoder has restored its subscriptions
+	if watcher != nil {
+		lc.Register("reload", watcher, "encoder")
+	}
+
+	if reloader != nil {
+		lc.Register("settings", reloader)
+	}
+
+	return s
+}
+
+// Start starts the server running, starting all components in dependency
+// order, then waits for SIGINT or SIGTERM before gracefully shutting down. A
+// second signal received while shutting down terminates the process
+// immediately.
+func (s *Server) Start() error {
+	err := s.lifecycle.Start()
 	if err != nil {
-		return errors.Wrap(err, "failed to start encoder")
+		return err
 	}
 
 	// add signal handling stuff to shutdown gracefully
 	stopChan := make(chan os.Signal, 1)
-	signal.Notify(stopChan, os.Interrupt)
-
-	go func() {
-		s.logger.Log(
-			"listenAddr", s.srv.Addr,
-			"msg", "starting server",
-			"pathPrefix", encoder.EncoderPathPrefix,
-			"tlsEnabled", isTLSEnabled(s.domains),
-		)
+	signal.Notify(stopChan, os.Interrupt, syscall.SIGTERM)
 
-		if isTLSEnabled(s.domains) {
-			m := &autocert.Manager{
-				Cache:      s.db,
-				Prompt:     autocert.AcceptTOS,
-				HostPolicy: autocert.HostWhitelist(s.domains...),
-			}
+	sig := <-stopChan
+	signal.Stop(stopChan)
 
-			s.srv.TLSConfig = m.TLSConfig()
+	s.logger.Log("msg", "received signal, shutting down", "signal", sig, "timeout", s.shutdownTimeout)
 
-			if err := s.srv.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
-				log.Fatalf("ListenAndServeTLS(): %s", err)
-			}
-		} else {
-			if err := s.srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-				log.Fatalf("ListenAndServe(): %s", err)
-			}
-		}
-	}()
-
-	<-stopChan
 	return s.Stop()
 }
 
-// Stop the server and all child components
+// Stop the server and all child components, in the reverse of the order in
+// which they were started.
 func (s *Server) Stop() error {
 	s.logger.Log("msg", "stopping")
-	ctx, cancelFn := context.WithTimeout(context.Background(), 5*time.Second)
+
+	return s.lifecycle.Stop()
+}
+
+// migrate runs all up migrations if auto-migration is enabled, and otherwise
+// checks that the database is in a usable state.
+func (s *Server) migrate() error {
+	if s.autoMigrate {
+		err := s.db.MigrateUp()
+		if err != nil {
+			return errors.Wrap(err, "failed to migrate the database")
+		}
+		return nil
+	}
+
+	return s.checkMigrations()
+}
+
+// shutdown gracefully shuts down our HTTP server, waiting up to our shutdown
+// timeout for active connections to complete. Connections still active at the
+// timeout are closed, and ErrForcedShutdown returned.
+func (s *Server) shutdown() error {
+	return s.shutdownServer(s.srv)
+}
+
+// shutdownAdmin gracefully shuts down the HTTP server of our admin API in the
+// same way.
+func (s *Server) shutdownAdmin() error {
+	return s.shutdownServer(s.adminSrv)
+}
+
+// shutdownServer gracefully shuts down the given HTTP server, waiting up to our
+// shutdown timeout for active connections to complete.
+func (s *Server) shutdownServer(srv *http.Server) error {
+	ctx, cancelFn := context.WithTimeout(context.Background(), s.shutdownTimeout)
 	defer cancelFn()
 
-	err := s.encoder.(system.Stoppable).Stop()
-	if err != nil {
-		return err
+	err := srv.Shutdown(ctx)
+	if err == context.DeadlineExceeded {
+		level.Warn(s.logger).Log("msg", "connections still active at shutdown timeout, closing them", "timeout", s.shutdownTimeout)
+
+		srv.Close()
+		return ErrForcedShutdown
 	}
 
-	err = s.mqtt.(system.Stoppable).Stop()
-	if err != nil {
-		return err
+	return err
+}
+
+// serve listens for and serves HTTP requests until the server is shut down,
+// with TLS if configured.
+func (s *Server) serve() {
+	listenAddr := s.srv.Addr
+	if s.listener != nil {
+		listenAddr = s.listener.Addr().String()
 	}
 
-	err = s.db.Stop()
+	s.logger.Log(
+		"listenAddr", listenAddr,
+		"msg", "starting server",
+		"pathPrefix", encoder.EncoderPathPrefix,
+		"tlsEnabled", isTLSEnabled(s.certFile, s.domains),
+	)
+
+	switch {
+	case s.certFile != "":
+		if err := s.listenAndServeTLS(s.certFile, s.keyFile); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("ListenAndServeTLS(): %s", err)
+		}
+	case len(s.domains) > 0:
+		m := &autocert.Manager{
+			Cache:      s.db,
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(s.domains...),
+		}
+
+		s.srv.TLSConfig.GetCertificate = m.GetCertificate
+		s.srv.TLSConfig.NextProtos = append(s.srv.TLSConfig.NextProtos, acme.ALPNProto)
+
+		if err := s.listenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("ListenAndServeTLS(): %s", err)
+		}
+	default:
+		if err := s.listenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("ListenAndServe(): %s", err)
+		}
+	}
+}
+
+// serveAdmin listens for and serves requests to our admin API until the server
+// is shut down, with TLS if a certificate file is configured. Certificates
+// obtained from Let's Encrypt are only valid for our public domains, so are not
+// used here.
+func (s *Server) serveAdmin() {
+	listenAddr := s.adminSrv.Addr
+	if s.adminListener != nil {
+		listenAddr = s.adminListener.Addr().String()
+	}
+
+	s.logger.Log(
+		"listenAddr", listenAddr,
+		"msg", "starting admin server",
+		"pathPrefix", admin.PathPrefix,
+		"tlsEnabled", s.certFile != "",
+	)
+
+	var err error
+
+	switch {
+	case s.adminListener != nil && s.certFile != "":
+		err = s.adminSrv.ServeTLS(s.adminListener, s.certFile, s.keyFile)
+	case s.adminListener != nil:
+		err = s.adminSrv.Serve(s.adminListener)
+	case s.certFile != "":
+		err = s.adminSrv.ListenAndServeTLS(s.certFile, s.keyFile)
+	default:
+		err = s.adminSrv.ListenAndServe()
+	}
+
+	if err != nil && err != http.ErrServerClosed {
+		log.Fatalf("admin ListenAndServe(): %s", err)
+	}
+}
+
+// listenAndServe serves plain HTTP on our listener if one was supplied, or
+// otherwise listens on our configured address.
+func (s *Server) listenAndServe() error {
+	if s.listener != nil {
+		return s.srv.Serve(s.listener)
+	}
+	return s.srv.ListenAndServe()
+}
+
+// listenAndServeTLS serves HTTPS on our listener if one was supplied, or
+// otherwise listens on our configured address.
+func (s *Server) listenAndServeTLS(certFile, keyFile string) error {
+	if s.listener != nil {
+		return s.srv.ServeTLS(s.listener, certFile, keyFile)
+	}
+	return s.srv.ListenAndServeTLS(certFile, keyFile)
+}
+
+// checkMigrations is called when auto-migration is disabled, and returns an
+// error if the database is in a dirty state. Pending migrations are logged but
+// we continue to start as applying them is left to the operator.
+func (s *Server) checkMigrations() error {
+	status, err := s.db.MigrationStatus()
 	if err != nil {
-		return err
+		return errors.Wrap(err, "failed to read migration status")
 	}
 
-	return s.srv.Shutdown(ctx)
+	if status.Dirty {
+		return errors.Errorf("database is dirty at version %d, fix manually before starting", status.Version)
+	}
+
+	pending := 0
+	for _, m := range status.Migrations {
+		if !m.Applied {
+			pending++
+		}
+	}
+
+	if pending > 0 {
+		level.Warn(s.logger).Log("msg", "database has pending migrations, run migrate up to apply", "version", status.Version, "pending", pending)
+	}
+
+	return nil
+}
+
+// newRetentionStore returns the retention backend selected by the given config,
+// or nil if payload retention is not enabled. Payloads may only be retained in
+// the database if streams are stored in Postgres.
+func newRetentionStore(config *Config, db *postgres.DB) retention.Store {
+	switch config.RetentionBackend {
+	case retention.Postgres:
+		if db == nil {
+			return nil
+		}
+		return db
+	case retention.Disk:
+		return retention.NewDiskStore(config.RetentionDir, config.EncryptionPassword)
+	default:
+		return nil
+	}
 }
 
-// isTLSEnabled returns true if we have passed in paths for both cert and key
-// files
-func isTLSEnabled(domains []string) bool {
-	return len(domains) > 0
+// isTLSEnabled returns true if we have passed in a certificate file, or a list
+// of domains for which certificates are obtained via autocert.
+func isTLSEnabled(certFile string, domains []string) bool {
+	return certFile != "" || len(domains) > 0
 }
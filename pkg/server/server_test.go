@@ -1,6 +1,9 @@
 package server_test
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -8,9 +11,15 @@ import (
 
 	kitlog "github.com/go-kit/kit/log"
 	"github.com/stretchr/testify/assert"
+	datastore "github.com/thingful/twirp-datastore-go"
 
+	"github.com/DECODEproject/iotencoder/pkg/chaos"
+	"github.com/DECODEproject/iotencoder/pkg/pipeline"
 	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/rpc"
 	"github.com/DECODEproject/iotencoder/pkg/server"
+	"github.com/DECODEproject/iotencoder/pkg/sink"
+	"github.com/DECODEproject/iotencoder/pkg/version"
 )
 
 func TestPulseHandler(t *testing.T) {
@@ -41,3 +50,125 @@ func TestPulseHandler(t *testing.T) {
 
 	assert.Equal(t, http.StatusOK, rr.Code)
 }
+
+func TestVersionHandler(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "/version", nil)
+	assert.Nil(t, err)
+
+	rr := httptest.NewRecorder()
+	server.VersionHandler().ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+
+	var info version.Info
+	err = json.Unmarshal(rr.Body.Bytes(), &info)
+	assert.Nil(t, err)
+	assert.Equal(t, *version.GetInfo(), info)
+}
+
+// fakeCheckable is a system.Checkable returning a fixed error.
+type fakeCheckable struct {
+	err error
+}
+
+func (f fakeCheckable) Check() error {
+	return f.err
+}
+
+func TestLivezHandler(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "/livez", nil)
+	assert.Nil(t, err)
+
+	rr := httptest.NewRecorder()
+	server.LivezHandler().ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "ok", rr.Body.String())
+}
+
+func TestReadyzHandler(t *testing.T) {
+	testcases := []struct {
+		label        string
+		checks       []server.Check
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			label: "ready",
+			checks: []server.Check{
+				{Name: "database", Checkable: fakeCheckable{}},
+			},
+			expectedCode: http.StatusOK,
+			expectedBody: "ok",
+		},
+		{
+			label: "not ready",
+			checks: []server.Check{
+				{Name: "database", Checkable: fakeCheckable{}},
+				{Name: "mqtt", Checkable: fakeCheckable{err: errors.New("not connected to broker tcp://localhost")}},
+				{Name: "restore", Checkable: rpc.NewReadiness(1)},
+			},
+			expectedCode: http.StatusServiceUnavailable,
+			expectedBody: "mqtt: not connected to broker tcp://localhost\nrestore: restored 0 of 0 devices\n",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "/readyz", nil)
+			assert.Nil(t, err)
+
+			rr := httptest.NewRecorder()
+			server.ReadyzHandler(tc.checks).ServeHTTP(rr, req)
+
+			assert.Equal(t, tc.expectedCode, rr.Code)
+			assert.Equal(t, tc.expectedBody, rr.Body.String())
+		})
+	}
+}
+
+func TestDatastoresDryRunWithChaos(t *testing.T) {
+	injected := true
+
+	injector := chaos.NewInjector(&chaos.Config{
+		DatastoreErrorRate: 0.5,
+		Rand: func() float64 {
+			if injected {
+				return 0
+			}
+			return 1
+		},
+	})
+
+	realFactory := func(addr string) pipeline.Datastore {
+		t.Errorf("client created for real datastore %s", addr)
+		return nil
+	}
+
+	ds, factory := server.Datastores(
+		&server.Config{Sink: sink.Null},
+		datastore.NewDatastoreProtobufClient("http://datastore.local", http.DefaultClient),
+		realFactory,
+		injector,
+		kitlog.NewNopLogger(),
+	)
+
+	req := &datastore.WriteRequest{
+		CommunityId: "smartcitizen",
+		DeviceToken: "abc123",
+		Data:        []byte(`{}`),
+	}
+
+	// faults are injected into the dry-run sink, which otherwise discards
+	// payloads whichever datastore they are written to
+	for _, d := range []pipeline.Datastore{ds, factory("http://other.local")} {
+		injected = true
+		_, err := d.WriteData(context.Background(), req)
+		assert.NotNil(t, err)
+
+		injected = false
+		_, err = d.WriteData(context.Background(), req)
+		assert.Nil(t, err)
+	}
+}
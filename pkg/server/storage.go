@@ -0,0 +1,101 @@
+package server
+
+import (
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"golang.org/x/crypto/acme/autocert"
+
+	"github.com/DECODEproject/iotencoder/pkg/boltdb"
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+)
+
+const (
+	// PostgresStorage is the name of the storage backend which stores streams
+	// and the encoder's state in Postgres.
+	PostgresStorage = "postgres"
+
+	// BoltStorage is the name of the storage backend which stores streams and
+	// the encoder's state in a single embedded bbolt file, for single node
+	// deployments. It cannot be used by partitioned instances, nor to retain
+	// payloads.
+	BoltStorage = "bolt"
+)
+
+const (
+	// MemoryStateStore is the name of the default state store, which holds the
+	// windows of moving averages in memory, private to each instance.
+	MemoryStateStore = "memory"
+
+	// RedisStateStore is the name of the state store which holds the windows of
+	// moving averages in Redis, so that they are shared by every instance.
+	RedisStateStore = "redis"
+)
+
+// Storage is the interface to the database in which streams and the encoder's
+// state are stored, made up of the methods required by each of the components
+// to which we pass it. It is satisfied by both the postgres.DB and boltdb.DB
+// types.
+type Storage interface {
+	autocert.Cache
+
+	Start() error
+	Stop() error
+	Ping() error
+	Check() error
+	MigrateUp() error
+	MigrationStatus() (*postgres.MigrationStatus, error)
+
+	CreateStream(stream *postgres.Stream) (*postgres.Stream, error)
+	DeleteStream(stream *postgres.Stream) (*postgres.Device, error)
+	RestoreStream(streamID, token string) (*postgres.Stream, error)
+	ImportStream(stream *postgres.Stream) (*postgres.Stream, error)
+	ExportStreams(fn func(stream *postgres.Stream) error) error
+	PurgeDeletedStreams(before time.Time) (int64, error)
+	GetDevices() ([]*postgres.Device, error)
+	GetDevice(deviceToken secret.Secret) (*postgres.Device, error)
+	ListStreams() ([]*postgres.Stream, error)
+	ListDeviceStreams(deviceToken secret.Secret) ([]*postgres.Stream, error)
+	GetStream(streamID, token string) (*postgres.Stream, error)
+	SetConversions(streamID, token string, conversions postgres.Conversions) error
+	SetIngestSecret(streamID, token string, ingestSecret secret.Secret) error
+	SetPolicyPublicKey(policyID, publicKey string) (int64, error)
+
+	SaveStreamStats(stats []*postgres.StreamStats) error
+	GetStreamStats() ([]*postgres.StreamStats, error)
+	SaveDeviceStatuses(statuses []*postgres.DeviceStatus) error
+	GetDeviceStatuses() ([]*postgres.DeviceStatus, error)
+	SaveDownsampleCheckpoints(checkpoints []*postgres.DownsampleCheckpoint) error
+	GetDownsampleCheckpoints() ([]*postgres.DownsampleCheckpoint, error)
+	SaveAuditEntry(entry *postgres.AuditEntry) error
+	GetAuditEntries(streamID string, start, end time.Time, afterID int64, limit int) ([]*postgres.AuditEntry, error)
+	SaveMessageKeys(keys []*postgres.MessageKey) error
+	GetMessageKeys(since time.Time) ([]*postgres.MessageKey, error)
+	PruneMessageKeys(before time.Time) (int64, error)
+	CreateSchema(kind string, channels postgres.Channels) (*postgres.Schema, error)
+	GetSchema(kind string, version int) (*postgres.Schema, error)
+	ListSchemas() ([]*postgres.Schema, error)
+	SpendPrivacyBudget(streamID string, periodStart time.Time, cost, budget float64) (bool, error)
+}
+
+// newStorage returns the storage backend selected by the given config. If the
+// backend is Postgres the postgres.DB is also returned, as only it may be used
+// to coordinate partitioned instances and retain payloads, and otherwise nil.
+func newStorage(config *Config, logger kitlog.Logger) (Storage, *postgres.DB) {
+	if config.StorageBackend == BoltStorage {
+		return boltdb.NewDB(&boltdb.Config{
+			Path:               config.StoragePath,
+			EncryptionPassword: config.EncryptionPassword,
+			Clock:              clock.New(),
+		}, logger), nil
+	}
+
+	db := postgres.NewDB(&postgres.Config{
+		ConnStr:            config.ConnStr,
+		EncryptionPassword: config.EncryptionPassword,
+	}, logger)
+
+	return db, db
+}
@@ -0,0 +1,252 @@
+// Package settings reloads the settings of a running encoder which may be
+// changed without restarting it, and so without dropping its MQTT
+// subscriptions. Settings are reloaded when the process receives SIGHUP, or
+// when asked via the admin API. New settings are validated before any are
+// applied, and if invalid the current settings are kept.
+package settings
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/DECODEproject/iotencoder/pkg/logger/level"
+	"github.com/DECODEproject/iotencoder/pkg/quota"
+)
+
+var (
+	// ReloadsCounter is a prometheus counter vec recording the number of
+	// attempts to reload settings, labelled by whether the new settings were
+	// applied or rejected.
+	ReloadsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "settings_reloads",
+			Help:      "Count of attempts to reload settings, labelled by result",
+		},
+		[]string{"result"},
+	)
+)
+
+// Settings are the settings which may be changed while the encoder is
+// running: the minimum level of log lines written, the quotas of each
+// community, and the maximum sizes of uploaded batches of readings and of the
+// batches of payloads claimed from the outbox. An IngestBatchSize of zero is
+// the default size.
+type Settings struct {
+	LogLevel        level.Level
+	CommunityQuotas map[string]*quota.Limits
+	IngestBatchSize int
+	OutboxBatchSize int
+}
+
+// Validate returns an error if the settings may not be applied.
+func (s *Settings) Validate() error {
+	if s.LogLevel < level.DebugLevel || s.LogLevel > level.ErrorLevel {
+		return errors.Errorf("unknown log level: %d", s.LogLevel)
+	}
+
+	for community, limits := range s.CommunityQuotas {
+		if limits == nil || limits.Streams < 0 || limits.MessagesPerMinute < 0 || limits.BytesPerDay < 0 {
+			return errors.Errorf("invalid quota for community %s", community)
+		}
+	}
+
+	if s.IngestBatchSize < 0 {
+		return errors.New("ingest batch size must not be negative")
+	}
+
+	if s.OutboxBatchSize <= 0 {
+		return errors.New("outbox batch size must be positive")
+	}
+
+	return nil
+}
+
+// Loader is a function called to load the current settings, e.g. by reading
+// a configuration file again.
+type Loader func() (*Settings, error)
+
+// Leveler is the interface we call to change the log level. It is satisfied
+// by the logger.Leveler type.
+type Leveler interface {
+	SetLevel(lvl level.Level)
+}
+
+// QuotaSetter is the interface we call to change the quotas of communities.
+// It is satisfied by the quota.Quotas type.
+type QuotaSetter interface {
+	SetLimits(communities map[string]*quota.Limits)
+}
+
+// IngestBatchSizer is the interface we call to change the maximum size of
+// uploaded batches of readings. It is satisfied by the ingest.Ingester type.
+type IngestBatchSizer interface {
+	SetMaxBatchSize(n int)
+}
+
+// OutboxBatchSizer is the interface we call to change the number of payloads
+// claimed from the outbox at a time. It is satisfied by the outbox.Dispatcher
+// type.
+type OutboxBatchSizer interface {
+	SetBatchSize(n int)
+}
+
+// Config is used to pass in dependencies when creating a Reloader. Current
+// holds the settings with which the encoder was started. Each of the
+// components whose settings are changed is optional, and if nil that setting
+// is validated but not applied.
+type Config struct {
+	Loader  Loader
+	Current *Settings
+	Leveler Leveler
+	Quotas  QuotaSetter
+	Ingest  IngestBatchSizer
+	Outbox  OutboxBatchSizer
+}
+
+// Reloader is a component which reloads our settings on SIGHUP, or when
+// Reload is called.
+type Reloader struct {
+	sync.Mutex
+
+	loader  Loader
+	current *Settings
+	leveler Leveler
+	quotas  QuotaSetter
+	ingest  IngestBatchSizer
+	outbox  OutboxBatchSizer
+	logger  kitlog.Logger
+	signals chan os.Signal
+	quit    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewReloader returns a new Reloader configured with the given config.
+func NewReloader(config *Config, logger kitlog.Logger) *Reloader {
+	logger = kitlog.With(logger, "module", "settings")
+
+	return &Reloader{
+		loader:  config.Loader,
+		current: config.Current,
+		leveler: config.Leveler,
+		quotas:  config.Quotas,
+		ingest:  config.Ingest,
+		outbox:  config.Outbox,
+		logger:  logger,
+	}
+}
+
+// Start starts listening for SIGHUP, reloading our settings on each signal
+// received.
+func (r *Reloader) Start() error {
+	r.logger.Log("msg", "starting settings reloader")
+
+	r.signals = make(chan os.Signal, 1)
+	r.quit = make(chan struct{})
+
+	signal.Notify(r.signals, syscall.SIGHUP)
+
+	r.wg.Add(1)
+
+	go r.loop()
+
+	return nil
+}
+
+// Stop stops listening for SIGHUP, waiting for any reload in progress.
+func (r *Reloader) Stop() error {
+	if r.quit == nil {
+		return nil
+	}
+
+	r.logger.Log("msg", "stopping settings reloader")
+
+	signal.Stop(r.signals)
+	close(r.quit)
+	r.wg.Wait()
+
+	return nil
+}
+
+// Current returns the settings currently applied.
+func (r *Reloader) Current() *Settings {
+	r.Lock()
+	defer r.Unlock()
+
+	return r.current
+}
+
+// Reload loads and validates our settings, applying them if valid and
+// returning the settings applied. If the settings can't be loaded or are
+// invalid an error is returned and the current settings are kept.
+func (r *Reloader) Reload() (*Settings, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	settings, err := r.loader()
+	if err != nil {
+		ReloadsCounter.WithLabelValues("rejected").Inc()
+		return nil, errors.Wrap(err, "failed to load settings")
+	}
+
+	err = settings.Validate()
+	if err != nil {
+		ReloadsCounter.WithLabelValues("rejected").Inc()
+		return nil, errors.Wrap(err, "invalid settings")
+	}
+
+	if r.leveler != nil {
+		r.leveler.SetLevel(settings.LogLevel)
+	}
+
+	if r.quotas != nil {
+		r.quotas.SetLimits(settings.CommunityQuotas)
+	}
+
+	if r.ingest != nil {
+		r.ingest.SetMaxBatchSize(settings.IngestBatchSize)
+	}
+
+	if r.outbox != nil {
+		r.outbox.SetBatchSize(settings.OutboxBatchSize)
+	}
+
+	r.current = settings
+
+	ReloadsCounter.WithLabelValues("applied").Inc()
+
+	r.logger.Log(
+		"msg", "reloaded settings",
+		"logLevel", settings.LogLevel,
+		"communityQuotas", len(settings.CommunityQuotas),
+		"ingestBatchSize", settings.IngestBatchSize,
+		"outboxBatchSize", settings.OutboxBatchSize,
+	)
+
+	return settings, nil
+}
+
+// loop is run in a goroutine and reloads our settings on each signal received
+// until the reloader is stopped.
+func (r *Reloader) loop() {
+	defer r.wg.Done()
+
+	for {
+		select {
+		case <-r.signals:
+			_, err := r.Reload()
+			if err != nil {
+				level.Error(r.logger).Log("err", err, "msg", "failed to reload settings, keeping current settings")
+			}
+		case <-r.quit:
+			return
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package settings_test
+
+import (
+	"errors"
+	"testing"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/logger"
+	"github.com/DECODEproject/iotencoder/pkg/logger/level"
+	"github.com/DECODEproject/iotencoder/pkg/quota"
+	"github.com/DECODEproject/iotencoder/pkg/settings"
+)
+
+// batchSizes records the batch sizes set.
+type batchSizes struct {
+	ingest int
+	outbox int
+}
+
+func (b *batchSizes) SetMaxBatchSize(n int) {
+	b.ingest = n
+}
+
+func (b *batchSizes) SetBatchSize(n int) {
+	b.outbox = n
+}
+
+func TestReload(t *testing.T) {
+	current := &settings.Settings{
+		LogLevel:        level.InfoLevel,
+		IngestBatchSize: 1000,
+		OutboxBatchSize: 100,
+	}
+
+	loaded := &settings.Settings{
+		LogLevel:        level.DebugLevel,
+		CommunityQuotas: map[string]*quota.Limits{"smartcitizen": {Streams: 1}},
+		IngestBatchSize: 500,
+		OutboxBatchSize: 50,
+	}
+
+	var loadErr error
+
+	leveler := logger.NewLeveler(level.InfoLevel)
+	quotas := quota.NewQuotas(nil, clock.New())
+	sizes := &batchSizes{}
+
+	reloader := settings.NewReloader(&settings.Config{
+		Loader: func() (*settings.Settings, error) {
+			return loaded, loadErr
+		},
+		Current: current,
+		Leveler: leveler,
+		Quotas:  quotas,
+		Ingest:  sizes,
+		Outbox:  sizes,
+	}, kitlog.NewNopLogger())
+
+	applied, err := reloader.Reload()
+	assert.Nil(t, err)
+	assert.Equal(t, loaded, applied)
+	assert.Equal(t, loaded, reloader.Current())
+
+	assert.Equal(t, level.DebugLevel, leveler.Level())
+	assert.Equal(t, &quota.Limits{Streams: 1}, quotas.Limits("smartcitizen"))
+	assert.Equal(t, &batchSizes{ingest: 500, outbox: 50}, sizes)
+
+	// invalid settings are refused, keeping those applied
+	loaded = &settings.Settings{
+		LogLevel:        level.ErrorLevel,
+		OutboxBatchSize: 0,
+	}
+
+	_, err = reloader.Reload()
+	assert.NotNil(t, err)
+
+	// as are settings which fail to load
+	loadErr = errors.New("invalid config file")
+
+	_, err = reloader.Reload()
+	assert.NotNil(t, err)
+
+	assert.Equal(t, level.DebugLevel, leveler.Level())
+	assert.Equal(t, &batchSizes{ingest: 500, outbox: 50}, sizes)
+	assert.Equal(t, 50, reloader.Current().OutboxBatchSize)
+}
+
+func TestValidate(t *testing.T) {
+	testcases := []struct {
+		label    string
+		settings *settings.Settings
+	}{
+		{
+			label:    "unknown level",
+			settings: &settings.Settings{LogLevel: level.Level(7), OutboxBatchSize: 1},
+		},
+		{
+			label:    "negative quota",
+			settings: &settings.Settings{CommunityQuotas: map[string]*quota.Limits{"*": {Streams: -1}}, OutboxBatchSize: 1},
+		},
+		{
+			label:    "negative ingest batch",
+			settings: &settings.Settings{IngestBatchSize: -1, OutboxBatchSize: 1},
+		},
+		{
+			label:    "empty outbox batch",
+			settings: &settings.Settings{},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			assert.NotNil(t, tc.settings.Validate())
+		})
+	}
+}
@@ -0,0 +1,291 @@
+// Package shedding sheds load when the encoder is saturated, dropping the
+// messages of its least important streams so that the rest are still
+// processed, rather than every stream failing alike as its messages wait too
+// long. Each stream has a priority, and while the queue of messages waiting
+// for workers stays deeper than a threshold, successively higher priorities
+// are shed: first low priority streams, then those of normal priority. High
+// priority streams are never shed. Once the queue has stayed below the
+// threshold for as long, priorities are restored one at a time.
+package shedding
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/logger/level"
+)
+
+// Priority is the priority of a stream, determining the order in which the
+// messages of streams are shed.
+type Priority int
+
+const (
+	// Low priority streams are shed first.
+	Low Priority = iota
+
+	// Normal priority streams are shed if shedding low priority streams did
+	// not relieve the queue. It is the priority of streams not given one.
+	Normal
+
+	// High priority streams are never shed.
+	High
+)
+
+// DefaultInterval is the interval at which the depth of the queue is checked
+// if not configured.
+const DefaultInterval = time.Second
+
+var (
+	// ShedCounter is a prometheus counter vec recording the number of messages
+	// shed, labelled by the priority of their stream.
+	ShedCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "shed_messages",
+			Help:      "Count of messages shed while the encoder was saturated, by stream priority",
+		},
+		[]string{"priority"},
+	)
+
+	// LevelGauge is a prometheus gauge recording the number of priorities
+	// currently being shed, zero if we are not shedding load.
+	LevelGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "shedding_level",
+			Help:      "Number of stream priorities whose messages are being shed, 0 when not shedding",
+		},
+	)
+)
+
+// String returns the name of the priority.
+func (p Priority) String() string {
+	switch p {
+	case Low:
+		return "low"
+	case Normal:
+		return "normal"
+	case High:
+		return "high"
+	default:
+		return "unknown"
+	}
+}
+
+// Parse returns the Priority with the given name, an empty name being Normal.
+func Parse(name string) (Priority, error) {
+	switch strings.ToLower(name) {
+	case "low":
+		return Low, nil
+	case "normal", "":
+		return Normal, nil
+	case "high":
+		return High, nil
+	default:
+		return Normal, errors.Errorf("unknown priority %q, must be low, normal or high", name)
+	}
+}
+
+// Queue is the interface we call to read the number of messages waiting to be
+// processed. It is satisfied by the encoder returned by rpc.NewEncoder when
+// messages are processed by workers.
+type Queue interface {
+	QueueDepth() int
+}
+
+// QueueFunc is an adapter allowing a function to be used as a Queue.
+type QueueFunc func() int
+
+// QueueDepth calls f.
+func (f QueueFunc) QueueDepth() int {
+	return f()
+}
+
+// Config is used to pass in configuration when creating a Shedder. Load is
+// shed while more than Threshold messages are waiting, a further priority being
+// shed each Period the queue stays above the threshold, and restored each
+// Period it stays below. The queue is checked every Interval, DefaultInterval
+// if zero.
+type Config struct {
+	Queue     Queue
+	Threshold int
+	Period    time.Duration
+	Interval  time.Duration
+	Clock     clock.Clock
+}
+
+// Shedder is a component which watches the depth of our queue, and decides
+// which messages are shed.
+type Shedder struct {
+	queue     Queue
+	threshold int
+	period    time.Duration
+	interval  time.Duration
+	clock     clock.Clock
+	logger    kitlog.Logger
+	quit      chan struct{}
+	wg        sync.WaitGroup
+
+	// level is the number of priorities being shed, read atomically as each
+	// message is processed
+	level int32
+
+	// the times since which the queue has been above or below the threshold,
+	// or zero if it isn't, only used by Check
+	mu         sync.Mutex
+	overSince  time.Time
+	underSince time.Time
+}
+
+// NewShedder returns a new Shedder configured with the given Config.
+func NewShedder(config *Config, logger kitlog.Logger) *Shedder {
+	logger = kitlog.With(logger, "module", "shedding")
+
+	interval := config.Interval
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+
+	return &Shedder{
+		queue:     config.Queue,
+		threshold: config.Threshold,
+		period:    config.Period,
+		interval:  interval,
+		clock:     config.Clock,
+		logger:    logger,
+	}
+}
+
+// Start starts a goroutine which checks the depth of our queue on each tick of
+// our interval.
+func (s *Shedder) Start() error {
+	s.logger.Log("msg", "starting load shedder", "threshold", s.threshold, "period", s.period)
+
+	if s.threshold <= 0 {
+		return errors.New("load shedding threshold must be positive")
+	}
+
+	if s.period <= 0 {
+		return errors.New("load shedding period must be positive")
+	}
+
+	s.quit = make(chan struct{})
+	s.wg.Add(1)
+
+	go s.loop()
+
+	return nil
+}
+
+// Stop stops checking the depth of our queue, and stops shedding load.
+func (s *Shedder) Stop() error {
+	if s.quit == nil {
+		return nil
+	}
+
+	s.logger.Log("msg", "stopping load shedder")
+
+	close(s.quit)
+	s.wg.Wait()
+
+	s.setLevel(0)
+
+	return nil
+}
+
+// Allow returns true if a message for a stream of the given priority may be
+// processed, or false, counting the message as shed, if the priority is being
+// shed.
+func (s *Shedder) Allow(priority Priority) bool {
+	if priority >= Priority(atomic.LoadInt32(&s.level)) {
+		return true
+	}
+
+	ShedCounter.WithLabelValues(priority.String()).Inc()
+
+	return false
+}
+
+// Level returns the number of priorities currently being shed.
+func (s *Shedder) Level() int {
+	return int(atomic.LoadInt32(&s.level))
+}
+
+// Check reads the depth of our queue, shedding a further priority if it has
+// been above our threshold for our period, or restoring one if it has been at
+// or below the threshold for our period.
+func (s *Shedder) Check() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	depth := s.queue.QueueDepth()
+	now := s.clock.Now()
+	current := s.Level()
+
+	if depth > s.threshold {
+		s.underSince = time.Time{}
+
+		if s.overSince.IsZero() {
+			s.overSince = now
+		}
+
+		if now.Sub(s.overSince) >= s.period && current < int(High) {
+			s.setLevel(current + 1)
+			s.overSince = now
+
+			level.Warn(s.logger).Log("msg", "queue saturated, shedding load", "depth", depth, "shedding", Priority(current).String())
+		}
+
+		return
+	}
+
+	s.overSince = time.Time{}
+
+	if current == 0 {
+		return
+	}
+
+	if s.underSince.IsZero() {
+		s.underSince = now
+	}
+
+	if now.Sub(s.underSince) >= s.period {
+		s.setLevel(current - 1)
+		s.underSince = now
+
+		s.logger.Log("msg", "queue relieved, restoring load", "depth", depth, "restored", Priority(current-1).String())
+	}
+}
+
+// setLevel sets the number of priorities being shed.
+func (s *Shedder) setLevel(n int) {
+	atomic.StoreInt32(&s.level, int32(n))
+	LevelGauge.Set(float64(n))
+}
+
+// loop is run in a goroutine and checks the depth of our queue on each tick
+// until the shedder is stopped.
+func (s *Shedder) loop() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.Check()
+		case <-s.quit:
+			return
+		}
+	}
+}
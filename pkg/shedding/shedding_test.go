@@ -0,0 +1,112 @@
+package shedding_test
+
+import (
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/shedding"
+)
+
+// fakeQueue reports a fixed depth.
+type fakeQueue struct {
+	depth int
+}
+
+func (f *fakeQueue) QueueDepth() int {
+	return f.depth
+}
+
+func TestParse(t *testing.T) {
+	testcases := []struct {
+		label    string
+		input    string
+		expected shedding.Priority
+	}{
+		{"empty", "", shedding.Normal},
+		{"low", "low", shedding.Low},
+		{"normal", "normal", shedding.Normal},
+		{"high", "HIGH", shedding.High},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			got, err := shedding.Parse(tc.input)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, got)
+			assert.Equal(t, tc.expected, mustParse(t, got.String()))
+		})
+	}
+
+	_, err := shedding.Parse("urgent")
+	assert.NotNil(t, err)
+}
+
+func TestShedding(t *testing.T) {
+	cl := clock.NewMock(time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC))
+	queue := &fakeQueue{depth: 50}
+
+	shedder := shedding.NewShedder(&shedding.Config{
+		Queue:     queue,
+		Threshold: 10,
+		Period:    10 * time.Second,
+		Clock:     cl,
+	}, kitlog.NewNopLogger())
+
+	// saturated, but not for long enough
+	shedder.Check()
+	cl.Add(5 * time.Second)
+	shedder.Check()
+	assert.Equal(t, 0, shedder.Level())
+	assert.True(t, shedder.Allow(shedding.Low))
+
+	// low priority streams are shed first
+	cl.Add(5 * time.Second)
+	shedder.Check()
+	assert.Equal(t, 1, shedder.Level())
+	assert.False(t, shedder.Allow(shedding.Low))
+	assert.True(t, shedder.Allow(shedding.Normal))
+
+	// then those of normal priority, but never those of high priority
+	for i := 0; i < 4; i++ {
+		cl.Add(10 * time.Second)
+		shedder.Check()
+	}
+	assert.Equal(t, 2, shedder.Level())
+	assert.False(t, shedder.Allow(shedding.Normal))
+	assert.True(t, shedder.Allow(shedding.High))
+
+	// priorities are restored once the queue has been relieved for a period
+	queue.depth = 10
+	shedder.Check()
+	assert.Equal(t, 2, shedder.Level())
+
+	cl.Add(10 * time.Second)
+	shedder.Check()
+	assert.Equal(t, 1, shedder.Level())
+
+	cl.Add(10 * time.Second)
+	shedder.Check()
+	assert.Equal(t, 0, shedder.Level())
+	assert.True(t, shedder.Allow(shedding.Low))
+}
+
+func TestShedderInvalidConfig(t *testing.T) {
+	shedder := shedding.NewShedder(&shedding.Config{
+		Queue:  &fakeQueue{},
+		Period: time.Second,
+		Clock:  clock.New(),
+	}, kitlog.NewNopLogger())
+
+	err := shedder.Start()
+	assert.NotNil(t, err)
+}
+
+func mustParse(t *testing.T, name string) shedding.Priority {
+	p, err := shedding.Parse(name)
+	assert.Nil(t, err)
+	return p
+}
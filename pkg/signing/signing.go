@@ -0,0 +1,219 @@
+// Package signing signs the encrypted payloads we write to the datastore with
+// a key belonging to the encoder instance that produced them, so that
+// consumers can verify that an entry was written by one of our instances and
+// has not been altered since. Keys are ECDSA keys on the P-256 curve, stored
+// PEM encoded, and are identified by a short fingerprint of their public key so
+// that consumers can select the key with which to verify an entry after keys
+// have been rotated.
+package signing
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// privateKeyType is the type of the PEM block holding a private key.
+	privateKeyType = "EC PRIVATE KEY"
+
+	// publicKeyType is the type of the PEM block holding a public key.
+	publicKeyType = "PUBLIC KEY"
+)
+
+// Provenance is attached to each encrypted payload written to the datastore
+// when signing is enabled. Instance is the identifier of the encoder instance
+// which wrote the payload, KeyID the ID of the key with which it was signed,
+// and Signature the ASN.1 DER encoded ECDSA signature of the payload's Digest,
+// which JSON encodes as base64.
+type Provenance struct {
+	Instance  string `json:"instance"`
+	KeyID     string `json:"key_id"`
+	Signature []byte `json:"signature"`
+}
+
+// Signer signs encrypted payloads on behalf of an encoder instance.
+type Signer struct {
+	key      *ecdsa.PrivateKey
+	keyID    string
+	instance string
+}
+
+// NewSigner returns a Signer signing payloads with the given key on behalf of
+// the instance with the given identifier.
+func NewSigner(key *ecdsa.PrivateKey, instance string) *Signer {
+	return &Signer{
+		key:      key,
+		keyID:    KeyID(&key.PublicKey),
+		instance: instance,
+	}
+}
+
+// KeyID returns the ID of the key with which we sign.
+func (s *Signer) KeyID() string {
+	return s.keyID
+}
+
+// Sign returns the provenance of the given encrypted data, written with the
+// given metadata, which may be nil.
+func (s *Signer) Sign(data, metadata []byte) (*Provenance, error) {
+	digest := Digest(s.instance, s.keyID, data, metadata)
+
+	signature, err := s.key.Sign(rand.Reader, digest, crypto.SHA256)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to sign payload")
+	}
+
+	return &Provenance{
+		Instance:  s.instance,
+		KeyID:     s.keyID,
+		Signature: signature,
+	}, nil
+}
+
+// Verify returns an error unless the given provenance carries a valid
+// signature by the given key of the given encrypted data and metadata.
+func Verify(key *ecdsa.PublicKey, data, metadata []byte, provenance *Provenance) error {
+	if provenance.KeyID != KeyID(key) {
+		return errors.Errorf("payload signed by key %s, not %s", provenance.KeyID, KeyID(key))
+	}
+
+	var signature struct {
+		R, S *big.Int
+	}
+
+	rest, err := asn1.Unmarshal(provenance.Signature, &signature)
+	if err != nil || len(rest) > 0 {
+		return errors.New("invalid signature encoding")
+	}
+
+	digest := Digest(provenance.Instance, provenance.KeyID, data, metadata)
+
+	if !ecdsa.Verify(key, digest, signature.R, signature.S) {
+		return errors.New("invalid signature")
+	}
+
+	return nil
+}
+
+// Digest returns the SHA-256 digest which is signed to attest that the given
+// instance wrote the given encrypted data and metadata using the key with the
+// given ID. Each field is prefixed by its length as a big endian uint64, so
+// that no two sets of fields share a digest.
+func Digest(instance, keyID string, data, metadata []byte) []byte {
+	h := sha256.New()
+
+	for _, field := range [][]byte{[]byte(instance), []byte(keyID), metadata, data} {
+		var length [8]byte
+		binary.BigEndian.PutUint64(length[:], uint64(len(field)))
+
+		h.Write(length[:])
+		h.Write(field)
+	}
+
+	return h.Sum(nil)
+}
+
+// KeyID returns the ID of the given public key, the first 16 characters of the
+// hex encoded SHA-256 digest of its DER encoding.
+func KeyID(key *ecdsa.PublicKey) string {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return ""
+	}
+
+	sum := sha256.Sum256(der)
+
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// GenerateKey returns a new randomly generated signing key.
+func GenerateKey() (*ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate signing key")
+	}
+
+	return key, nil
+}
+
+// EncodeKey returns the PEM encoding of the given signing key.
+func EncodeKey(key *ecdsa.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal signing key")
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: privateKeyType, Bytes: der}), nil
+}
+
+// EncodePublicKey returns the PEM encoding of the given public key, in which
+// form it is distributed to consumers.
+func EncodePublicKey(key *ecdsa.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal public key")
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: publicKeyType, Bytes: der}), nil
+}
+
+// ParseKey parses a PEM encoded signing key.
+func ParseKey(data []byte) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != privateKeyType {
+		return nil, errors.Errorf("invalid signing key, expected a PEM encoded %s", privateKeyType)
+	}
+
+	key, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid signing key")
+	}
+
+	if key.Curve != elliptic.P256() {
+		return nil, errors.New("invalid signing key, must be on the P-256 curve")
+	}
+
+	return key, nil
+}
+
+// ParsePublicKey parses a PEM encoded public key.
+func ParsePublicKey(data []byte) (*ecdsa.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != publicKeyType {
+		return nil, errors.Errorf("invalid public key, expected a PEM encoded %s", publicKeyType)
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid public key")
+	}
+
+	ecKey, ok := key.(*ecdsa.PublicKey)
+	if !ok || ecKey.Curve != elliptic.P256() {
+		return nil, errors.New("invalid public key, must be on the P-256 curve")
+	}
+
+	return ecKey, nil
+}
+
+// LoadKey reads a PEM encoded signing key from the file at the given path.
+func LoadKey(path string) (*ecdsa.PrivateKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read signing key")
+	}
+
+	return ParseKey(data)
+}
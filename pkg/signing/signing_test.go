@@ -0,0 +1,67 @@
+package signing_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/signing"
+)
+
+func TestSignVerify(t *testing.T) {
+	key, err := signing.GenerateKey()
+	assert.Nil(t, err)
+
+	signer := signing.NewSigner(key, "encoder-1")
+
+	provenance, err := signer.Sign([]byte(`{"data":"secret"}`), []byte("metadata"))
+	assert.Nil(t, err)
+	assert.Equal(t, "encoder-1", provenance.Instance)
+	assert.Equal(t, signer.KeyID(), provenance.KeyID)
+	assert.Len(t, provenance.KeyID, 16)
+
+	assert.Nil(t, signing.Verify(&key.PublicKey, []byte(`{"data":"secret"}`), []byte("metadata"), provenance))
+
+	err = signing.Verify(&key.PublicKey, []byte(`{"data":"tampered"}`), []byte("metadata"), provenance)
+	assert.NotNil(t, err)
+	assert.Equal(t, "invalid signature", err.Error())
+
+	err = signing.Verify(&key.PublicKey, []byte(`{"data":"secret"}`), nil, provenance)
+	assert.NotNil(t, err)
+
+	forged := *provenance
+	forged.Instance = "encoder-2"
+	assert.NotNil(t, signing.Verify(&key.PublicKey, []byte(`{"data":"secret"}`), []byte("metadata"), &forged))
+
+	other, err := signing.GenerateKey()
+	assert.Nil(t, err)
+
+	err = signing.Verify(&other.PublicKey, []byte(`{"data":"secret"}`), []byte("metadata"), provenance)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "payload signed by key")
+}
+
+func TestEncodeParse(t *testing.T) {
+	key, err := signing.GenerateKey()
+	assert.Nil(t, err)
+
+	encoded, err := signing.EncodeKey(key)
+	assert.Nil(t, err)
+
+	parsed, err := signing.ParseKey(encoded)
+	assert.Nil(t, err)
+	assert.Equal(t, signing.KeyID(&key.PublicKey), signing.KeyID(&parsed.PublicKey))
+
+	encodedPublic, err := signing.EncodePublicKey(&key.PublicKey)
+	assert.Nil(t, err)
+
+	public, err := signing.ParsePublicKey(encodedPublic)
+	assert.Nil(t, err)
+	assert.Equal(t, signing.KeyID(&key.PublicKey), signing.KeyID(public))
+
+	_, err = signing.ParseKey(encodedPublic)
+	assert.NotNil(t, err)
+
+	_, err = signing.ParseKey([]byte("not a key"))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,156 @@
+// Package sink provides a dry-run datastore for staging environments, which
+// validates and counts the encrypted payloads written to it but discards them,
+// so that a staging cluster may mirror production traffic through the whole of
+// our pipeline without writing to a real datastore.
+package sink
+
+import (
+	"context"
+	"encoding/json"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	datastore "github.com/thingful/twirp-datastore-go"
+	"github.com/twitchtv/twirp"
+
+	"github.com/DECODEproject/iotencoder/pkg/logger"
+	"github.com/DECODEproject/iotencoder/pkg/logger/level"
+	"github.com/DECODEproject/iotencoder/pkg/requestid"
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+)
+
+const (
+	// Datastore is the name of the default sink, in which encrypted payloads
+	// are written to the datastore of their stream.
+	Datastore = "datastore"
+
+	// Null is the name of the dry-run sink in which encrypted payloads are
+	// validated and counted, then discarded.
+	Null = "null"
+
+	// Log is the name of the dry-run sink in which encrypted payloads are
+	// validated, counted and logged, without their data, then discarded.
+	Log = "log"
+)
+
+// the results with which DryRunCounter is labelled
+const (
+	resultValid   = "valid"
+	resultInvalid = "invalid"
+)
+
+var (
+	// DryRunCounter is a prometheus counter vec recording the number of
+	// encrypted payloads discarded by a dry-run sink, labelled by whether they
+	// were valid.
+	DryRunCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "dry_run_payloads",
+			Help:      "Count of encrypted payloads discarded by a dry-run sink, by validity",
+		},
+		[]string{"result"},
+	)
+
+	// DryRunBytesCounter is a prometheus counter recording the total size in
+	// bytes of the valid payloads discarded by a dry-run sink.
+	DryRunBytesCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "dry_run_bytes",
+			Help:      "Total size of valid encrypted payloads discarded by a dry-run sink",
+		},
+	)
+)
+
+// Valid returns true if the given name is a sink we support, or is empty
+// meaning the default datastore sink.
+func Valid(name string) bool {
+	switch name {
+	case "", Datastore, Null, Log:
+		return true
+	}
+	return false
+}
+
+// DryRun returns true if the named sink discards payloads rather than writing
+// them.
+func DryRun(name string) bool {
+	return name == Null || name == Log
+}
+
+// DryRunDatastore is a datastore which validates the write requests it
+// receives, but discards their payloads. It satisfies the pipeline.Datastore
+// interface, so may be used in place of a datastore client. Writes of invalid
+// payloads fail as the datastore would reject them, so that they are counted
+// as failures by our pipeline as they would be in production.
+type DryRunDatastore struct {
+	logger kitlog.Logger
+	log    bool
+}
+
+// NewDryRunDatastore returns a new DryRunDatastore for the named dry-run sink,
+// which logs each payload it discards if the sink is Log.
+func NewDryRunDatastore(name string, logger kitlog.Logger) *DryRunDatastore {
+	logger = kitlog.With(logger, "module", "sink")
+
+	return &DryRunDatastore{
+		logger: logger,
+		log:    name == Log,
+	}
+}
+
+// WriteData validates the request, counting and discarding its payload. It
+// returns an InvalidArgument twirp error if the request lacks a community id
+// or device token, or if its data is not the JSON our pipeline encrypts
+// payloads to.
+func (d *DryRunDatastore) WriteData(ctx context.Context, req *datastore.WriteRequest) (*datastore.WriteResponse, error) {
+	err := validate(req)
+	if err != nil {
+		DryRunCounter.WithLabelValues(resultInvalid).Inc()
+		return nil, err
+	}
+
+	DryRunCounter.WithLabelValues(resultValid).Inc()
+	DryRunBytesCounter.Add(float64(len(req.Data)))
+
+	if d.log {
+		level.Info(d.logger).Log(
+			"msg", "discarding encrypted payload",
+			"community_id", req.CommunityId,
+			"device_hash", logger.HashToken(secret.Secret(req.DeviceToken)),
+			"bytes", len(req.Data),
+			"request_id", requestid.FromContext(ctx),
+		)
+	}
+
+	return &datastore.WriteResponse{}, nil
+}
+
+// ReadData returns no data, as none is ever written.
+func (d *DryRunDatastore) ReadData(ctx context.Context, req *datastore.ReadRequest) (*datastore.ReadResponse, error) {
+	return &datastore.ReadResponse{}, nil
+}
+
+// validate checks a write request as the datastore would.
+func validate(req *datastore.WriteRequest) error {
+	if req.CommunityId == "" {
+		return twirp.RequiredArgumentError("community_id")
+	}
+
+	if req.DeviceToken == "" {
+		return twirp.RequiredArgumentError("device_token")
+	}
+
+	if len(req.Data) == 0 {
+		return twirp.RequiredArgumentError("data")
+	}
+
+	if !json.Valid(req.Data) {
+		return twirp.InvalidArgumentError("data", "must be JSON")
+	}
+
+	return nil
+}
@@ -0,0 +1,146 @@
+package sink_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	kitlog "github.com/go-kit/kit/log"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	datastore "github.com/thingful/twirp-datastore-go"
+
+	"github.com/DECODEproject/iotencoder/pkg/requestid"
+	"github.com/DECODEproject/iotencoder/pkg/sink"
+)
+
+func payloadCount(t *testing.T, result string) float64 {
+	var metric dto.Metric
+	err := sink.DryRunCounter.WithLabelValues(result).Write(&metric)
+	assert.Nil(t, err)
+	return metric.GetCounter().GetValue()
+}
+
+func TestValid(t *testing.T) {
+	assert.True(t, sink.Valid(""))
+	assert.True(t, sink.Valid(sink.Datastore))
+	assert.True(t, sink.Valid(sink.Null))
+	assert.True(t, sink.Valid(sink.Log))
+	assert.False(t, sink.Valid("s3"))
+
+	assert.False(t, sink.DryRun(""))
+	assert.False(t, sink.DryRun(sink.Datastore))
+	assert.True(t, sink.DryRun(sink.Null))
+	assert.True(t, sink.DryRun(sink.Log))
+}
+
+func TestDryRunDatastoreWriteData(t *testing.T) {
+	testcases := []struct {
+		label       string
+		req         *datastore.WriteRequest
+		expectedErr string
+	}{
+		{
+			label: "valid payload",
+			req: &datastore.WriteRequest{
+				CommunityId: "smartcitizen",
+				DeviceToken: "abc123",
+				Data:        []byte(`{"header":"foo","text":"bar"}`),
+			},
+		},
+		{
+			label: "missing community id",
+			req: &datastore.WriteRequest{
+				DeviceToken: "abc123",
+				Data:        []byte(`{}`),
+			},
+			expectedErr: "twirp error invalid_argument: community_id is required",
+		},
+		{
+			label: "missing device token",
+			req: &datastore.WriteRequest{
+				CommunityId: "smartcitizen",
+				Data:        []byte(`{}`),
+			},
+			expectedErr: "twirp error invalid_argument: device_token is required",
+		},
+		{
+			label: "missing data",
+			req: &datastore.WriteRequest{
+				CommunityId: "smartcitizen",
+				DeviceToken: "abc123",
+			},
+			expectedErr: "twirp error invalid_argument: data is required",
+		},
+		{
+			label: "data not JSON",
+			req: &datastore.WriteRequest{
+				CommunityId: "smartcitizen",
+				DeviceToken: "abc123",
+				Data:        []byte(`{"header":`),
+			},
+			expectedErr: "twirp error invalid_argument: data must be JSON",
+		},
+	}
+
+	ds := sink.NewDryRunDatastore(sink.Null, kitlog.NewNopLogger())
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			valid, invalid := payloadCount(t, "valid"), payloadCount(t, "invalid")
+
+			resp, err := ds.WriteData(context.Background(), tc.req)
+
+			if tc.expectedErr == "" {
+				assert.Nil(t, err)
+				assert.NotNil(t, resp)
+				assert.Equal(t, valid+1, payloadCount(t, "valid"))
+				assert.Equal(t, invalid, payloadCount(t, "invalid"))
+			} else {
+				assert.NotNil(t, err)
+				assert.Equal(t, tc.expectedErr, err.Error())
+				assert.Equal(t, valid, payloadCount(t, "valid"))
+				assert.Equal(t, invalid+1, payloadCount(t, "invalid"))
+			}
+		})
+	}
+}
+
+func TestDryRunDatastoreLogs(t *testing.T) {
+	buf := &bytes.Buffer{}
+
+	ds := sink.NewDryRunDatastore(sink.Log, kitlog.NewLogfmtLogger(buf))
+
+	_, err := ds.WriteData(requestid.With(context.Background(), "req-1"), &datastore.WriteRequest{
+		CommunityId: "smartcitizen",
+		DeviceToken: "abc123",
+		Data:        []byte(`{"header":"foo","text":"bar"}`),
+	})
+	assert.Nil(t, err)
+
+	// the payload is logged without its data or the device token
+	assert.Contains(t, buf.String(), "discarding encrypted payload")
+	assert.Contains(t, buf.String(), "community_id=smartcitizen")
+	assert.Contains(t, buf.String(), "bytes=29")
+	assert.Contains(t, buf.String(), "request_id=req-1")
+	assert.NotContains(t, buf.String(), "abc123")
+	assert.NotContains(t, buf.String(), "header")
+
+	// nothing is logged by the null sink
+	buf.Reset()
+
+	ds = sink.NewDryRunDatastore(sink.Null, kitlog.NewLogfmtLogger(buf))
+
+	_, err = ds.WriteData(context.Background(), &datastore.WriteRequest{
+		CommunityId: "smartcitizen",
+		DeviceToken: "abc123",
+		Data:        []byte(`{}`),
+	})
+	assert.Nil(t, err)
+	assert.Empty(t, buf.String())
+
+	// reads find nothing, as nothing is written
+	resp, err := ds.ReadData(context.Background(), &datastore.ReadRequest{CommunityId: "smartcitizen"})
+	assert.Nil(t, err)
+	assert.Empty(t, resp.Events)
+}
@@ -10,9 +10,12 @@ type RawSensor struct {
 }
 
 // SensorData is a type used when parsing the actual payload published by
-// Smartcitizen
+// Smartcitizen. Mobile devices may also report the location at which the
+// readings were taken, which is nil otherwise.
 type SensorData struct {
 	RecordedAt time.Time   `json:"recorded_at"`
+	Latitude   *float64    `json:"latitude,omitempty"`
+	Longitude  *float64    `json:"longitude,omitempty"`
 	Sensors    []RawSensor `json:"sensors"`
 }
 
@@ -26,15 +26,21 @@ type Sensor struct {
 }
 
 // Device is a type used when we marshal the enriched data to write to the
-// datastore.
+// datastore. Metadata holds any properties the transport carried with the
+// payload, such as the device's firmware version. LocationGrid is the size in
+// metres of the grid cell to which the location was snapped, if any, and
+// LocationSuppressed is true if the location was withheld entirely.
 type Device struct {
-	Token      string    `json:"token"`
-	Label      string    `json:"label"`
-	Longitude  float64   `json:"longitude"`
-	Latitude   float64   `json:"latitude"`
-	Exposure   string    `json:"exposure"`
-	RecordedAt time.Time `json:"recordedAt"`
-	Sensors    []*Sensor `json:"sensors"`
+	Token              string            `json:"token"`
+	Label              string            `json:"label"`
+	Longitude          float64           `json:"longitude"`
+	Latitude           float64           `json:"latitude"`
+	LocationGrid       float64           `json:"locationGrid,omitempty"`
+	LocationSuppressed bool              `json:"locationSuppressed,omitempty"`
+	Exposure           string            `json:"exposure"`
+	RecordedAt         time.Time         `json:"recordedAt"`
+	Sensors            []*Sensor         `json:"sensors"`
+	Metadata           map[string]string `json:"metadata,omitempty"`
 }
 
 // FindSensor is a helper function that either returns a sensor pointer from our
@@ -58,6 +64,9 @@ type Smartcitizen struct {
 // representation from our database and the bytes of the payload. It then parses
 // this payload into an internal representation, which we then enrich using the
 // metadata, before returning an object containing the additional richer data.
+// If the payload reports the location at which its readings were taken, as
+// mobile devices do, it replaces the location with which the device was
+// registered.
 func (s *Smartcitizen) ParseData(device *postgres.Device, payload []byte) (*Device, error) {
 	if s.sensorMetadata == nil {
 		sensorMetadata, err := ReadMetadata()
@@ -80,7 +89,7 @@ func (s *Smartcitizen) ParseData(device *postgres.Device, payload []byte) (*Devi
 	data := p.Data[0]
 
 	d := &Device{
-		Token:      device.DeviceToken,
+		Token:      device.DeviceToken.Reveal(),
 		Label:      device.Label,
 		Longitude:  device.Longitude,
 		Latitude:   device.Latitude,
@@ -89,6 +98,11 @@ func (s *Smartcitizen) ParseData(device *postgres.Device, payload []byte) (*Devi
 		Sensors:    []*Sensor{},
 	}
 
+	if data.Latitude != nil && data.Longitude != nil {
+		d.Latitude = *data.Latitude
+		d.Longitude = *data.Longitude
+	}
+
 	for _, rawSensor := range data.Sensors {
 		metadata, ok := s.sensorMetadata[rawSensor.ID]
 		if !ok {
@@ -64,6 +64,28 @@ func TestParseData(t *testing.T) {
 	assert.Equal(t, expected, got)
 }
 
+func TestParseDataWithLocation(t *testing.T) {
+	device := &postgres.Device{
+		DeviceToken: "abc123",
+		Longitude:   12,
+		Latitude:    12,
+	}
+
+	s := smartcitizen.Smartcitizen{}
+
+	// the location reported by a mobile device replaces its registered one
+	got, err := s.ParseData(device, []byte(`{"data":[{"recorded_at":"2018-12-01T10:00:00Z","latitude":41.3851,"longitude":2.1734,"sensors":[{"id":12,"value":12.3}]}]}`))
+	assert.Nil(t, err)
+	assert.Equal(t, 41.3851, got.Latitude)
+	assert.Equal(t, 2.1734, got.Longitude)
+
+	// unless only part of it is given
+	got, err = s.ParseData(device, []byte(`{"data":[{"recorded_at":"2018-12-01T10:00:00Z","latitude":41.3851,"sensors":[{"id":12,"value":12.3}]}]}`))
+	assert.Nil(t, err)
+	assert.Equal(t, float64(12), got.Latitude)
+	assert.Equal(t, float64(12), got.Longitude)
+}
+
 func TestMarshalling(t *testing.T) {
 	device := buildDevice(t)
 
@@ -0,0 +1,284 @@
+package snapshot
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/logger/level"
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+)
+
+// Path is the path at which the snapshot is served.
+const Path = "/stats"
+
+// the metrics from which snapshots are taken
+const (
+	streamsMetric         = "decode_encoder_stream_gauge"
+	messagesMetric        = "decode_encoder_messages_handled"
+	datastoreErrorsMetric = "decode_encoder_datastore_errors"
+	zenroomErrorsMetric   = "decode_encoder_zenroom_errors"
+	workerQueueMetric     = "decode_encoder_worker_queue_depth"
+	mqttInflightMetric    = "decode_encoder_mqtt_messages_inflight"
+	zenroomInflightMetric = "decode_encoder_zenroom_inflight"
+)
+
+// Snapshot is a summary of the key operational numbers of the encoder. Rates
+// are averaged over the interval between the two most recent samples, and are
+// zero until two samples have been taken.
+type Snapshot struct {
+	Time                     time.Time `json:"time"`
+	StreamsActive            float64   `json:"streams_active"`
+	MessagesTotal            float64   `json:"messages_total"`
+	MessagesPerSecond        float64   `json:"messages_per_second"`
+	FailedPerSecond          float64   `json:"failed_per_second"`
+	ErrorRate                float64   `json:"error_rate"`
+	DatastoreErrorsPerSecond float64   `json:"datastore_errors_per_second"`
+	ZenroomErrorsPerSecond   float64   `json:"zenroom_errors_per_second"`
+	Queues                   Queues    `json:"queues"`
+}
+
+// Queues holds the number of messages waiting or in flight at each stage of
+// processing.
+type Queues struct {
+	Workers      float64 `json:"workers"`
+	MQTTInflight float64 `json:"mqtt_inflight"`
+	Zenroom      float64 `json:"zenroom"`
+}
+
+// sample is the raw values of the counters read at a point in time.
+type sample struct {
+	at              time.Time
+	messages        float64
+	failed          float64
+	datastoreErrors float64
+	zenroomErrors   float64
+}
+
+// Config is used to pass in configuration when creating a Sampler.
+type Config struct {
+	// Gatherer is the source of the metrics from which snapshots are taken.
+	Gatherer prometheus.Gatherer
+
+	// Interval is the interval at which metrics are sampled, over which rates
+	// are averaged.
+	Interval time.Duration
+
+	// Token is the bearer token with which callers must authenticate to read
+	// the snapshot.
+	Token secret.Secret
+
+	// Clock is used to timestamp samples.
+	Clock clock.Clock
+}
+
+// Sampler is a component which periodically samples our Prometheus metrics,
+// keeping a snapshot of the key numbers which may be polled as JSON by
+// dashboards without a Prometheus server.
+type Sampler struct {
+	gatherer prometheus.Gatherer
+	interval time.Duration
+	token    secret.Secret
+	clock    clock.Clock
+	logger   kitlog.Logger
+	quit     chan struct{}
+	wg       sync.WaitGroup
+
+	sync.RWMutex
+	last     *sample
+	snapshot *Snapshot
+}
+
+// NewSampler returns a new Sampler configured with the given Config.
+func NewSampler(config *Config, logger kitlog.Logger) *Sampler {
+	logger = kitlog.With(logger, "module", "snapshot")
+
+	return &Sampler{
+		gatherer: config.Gatherer,
+		interval: config.Interval,
+		token:    config.Token,
+		clock:    config.Clock,
+		logger:   logger,
+	}
+}
+
+// Start takes an initial sample, then starts a goroutine which samples our
+// metrics on each tick of our interval.
+func (s *Sampler) Start() error {
+	s.logger.Log("msg", "starting stats sampler", "interval", s.interval)
+
+	if s.interval <= 0 {
+		return errors.New("stats snapshot interval must be positive")
+	}
+
+	err := s.Sample()
+	if err != nil {
+		return err
+	}
+
+	s.quit = make(chan struct{})
+	s.wg.Add(1)
+
+	go s.loop()
+
+	return nil
+}
+
+// Stop stops the sampling goroutine.
+func (s *Sampler) Stop() error {
+	if s.quit == nil {
+		return nil
+	}
+
+	s.logger.Log("msg", "stopping stats sampler")
+
+	close(s.quit)
+	s.wg.Wait()
+
+	return nil
+}
+
+// Sample reads our metrics and replaces the snapshot, computing rates since
+// the previous sample.
+func (s *Sampler) Sample() error {
+	families, err := s.gatherer.Gather()
+	if err != nil {
+		return errors.Wrap(err, "failed to gather metrics")
+	}
+
+	values := map[string][]*dto.Metric{}
+	for _, f := range families {
+		values[f.GetName()] = f.GetMetric()
+	}
+
+	current := &sample{
+		at:              s.clock.Now(),
+		messages:        sum(values[messagesMetric], ""),
+		failed:          sum(values[messagesMetric], "failed"),
+		datastoreErrors: sum(values[datastoreErrorsMetric], ""),
+		zenroomErrors:   sum(values[zenroomErrorsMetric], ""),
+	}
+
+	snapshot := &Snapshot{
+		Time:          current.at,
+		StreamsActive: sum(values[streamsMetric], ""),
+		MessagesTotal: current.messages,
+		Queues: Queues{
+			Workers:      sum(values[workerQueueMetric], ""),
+			MQTTInflight: sum(values[mqttInflightMetric], ""),
+			Zenroom:      sum(values[zenroomInflightMetric], ""),
+		},
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if last := s.last; last != nil {
+		if elapsed := current.at.Sub(last.at).Seconds(); elapsed > 0 {
+			snapshot.MessagesPerSecond = (current.messages - last.messages) / elapsed
+			snapshot.FailedPerSecond = (current.failed - last.failed) / elapsed
+			snapshot.DatastoreErrorsPerSecond = (current.datastoreErrors - last.datastoreErrors) / elapsed
+			snapshot.ZenroomErrorsPerSecond = (current.zenroomErrors - last.zenroomErrors) / elapsed
+		}
+
+		if handled := current.messages - last.messages; handled > 0 {
+			snapshot.ErrorRate = (current.failed - last.failed) / handled
+		}
+	}
+
+	s.last = current
+	s.snapshot = snapshot
+
+	return nil
+}
+
+// Snapshot returns the most recent snapshot, or nil if no sample has been
+// taken.
+func (s *Sampler) Snapshot() *Snapshot {
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.snapshot
+}
+
+// ServeHTTP writes the most recent snapshot as JSON to callers presenting our
+// token as a bearer token.
+func (s *Sampler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") || subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(s.token.Reveal())) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	snapshot := s.Snapshot()
+	if snapshot == nil {
+		http.Error(w, "no snapshot taken", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(snapshot)
+}
+
+// loop is run in a goroutine and samples our metrics on each tick of our
+// interval until the sampler is stopped.
+func (s *Sampler) loop() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := s.Sample()
+			if err != nil {
+				level.Error(s.logger).Log("msg", "failed to sample metrics", "err", err)
+			}
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+// sum returns the sum of the values of the given counters or gauges, limited to
+// those whose result label is the given result if it is not empty.
+func sum(metrics []*dto.Metric, result string) float64 {
+	var total float64
+
+	for _, m := range metrics {
+		if result != "" && !hasLabel(m, "result", result) {
+			continue
+		}
+
+		switch {
+		case m.Counter != nil:
+			total += m.Counter.GetValue()
+		case m.Gauge != nil:
+			total += m.Gauge.GetValue()
+		}
+	}
+
+	return total
+}
+
+// hasLabel returns true if the metric has the given label value.
+func hasLabel(m *dto.Metric, name, value string) bool {
+	for _, l := range m.GetLabel() {
+		if l.GetName() == name {
+			return l.GetValue() == value
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,83 @@
+package snapshot_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/snapshot"
+)
+
+func TestSampler(t *testing.T) {
+	reg := prometheus.NewRegistry()
+
+	streams := prometheus.NewGauge(prometheus.GaugeOpts{Namespace: "decode", Subsystem: "encoder", Name: "stream_gauge", Help: "streams"})
+	messages := prometheus.NewCounterVec(prometheus.CounterOpts{Namespace: "decode", Subsystem: "encoder", Name: "messages_handled", Help: "messages"}, []string{"result"})
+	queue := prometheus.NewGauge(prometheus.GaugeOpts{Namespace: "decode", Subsystem: "encoder", Name: "worker_queue_depth", Help: "queue"})
+
+	reg.MustRegister(streams, messages, queue)
+
+	now := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	c := clock.NewMock(now)
+
+	sampler := snapshot.NewSampler(&snapshot.Config{
+		Gatherer: reg,
+		Interval: time.Second,
+		Token:    "s3cr3t",
+		Clock:    c,
+	}, kitlog.NewNopLogger())
+
+	streams.Set(3)
+	messages.WithLabelValues("processed").Add(10)
+
+	err := sampler.Sample()
+	assert.Nil(t, err)
+
+	messages.WithLabelValues("processed").Add(15)
+	messages.WithLabelValues("failed").Add(5)
+	queue.Set(4)
+	c.Add(10 * time.Second)
+
+	err = sampler.Sample()
+	assert.Nil(t, err)
+
+	assert.Equal(t, &snapshot.Snapshot{
+		Time:              now.Add(10 * time.Second),
+		StreamsActive:     3,
+		MessagesTotal:     30,
+		MessagesPerSecond: 2,
+		FailedPerSecond:   0.5,
+		ErrorRate:         0.25,
+		Queues: snapshot.Queues{
+			Workers: 4,
+		},
+	}, sampler.Snapshot())
+
+	// the snapshot is only served to callers presenting our token
+	w := httptest.NewRecorder()
+	sampler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, snapshot.Path, nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	req := httptest.NewRequest(http.MethodGet, snapshot.Path, nil)
+	req.Header.Set("Authorization", "Bearer wrong")
+	w = httptest.NewRecorder()
+	sampler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	req.Header.Set("Authorization", "Bearer s3cr3t")
+	w = httptest.NewRecorder()
+	sampler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var served snapshot.Snapshot
+	err = json.Unmarshal(w.Body.Bytes(), &served)
+	assert.Nil(t, err)
+	assert.Equal(t, float64(2), served.MessagesPerSecond)
+}
@@ -0,0 +1,405 @@
+package stats
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"gopkg.in/guregu/null.v3"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/logger/level"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+)
+
+// RateWindow is the period over which we count the messages received from each
+// device, for reporting the rate at which a device is sending readings.
+const RateWindow = time.Hour
+
+// Persister is the interface we require of a type able to save and load stream
+// stats and device statuses. We define it here where we need it, and it is
+// satisfied by our postgres.DB type.
+type Persister interface {
+	GetStreamStats() ([]*postgres.StreamStats, error)
+	SaveStreamStats(stats []*postgres.StreamStats) error
+	GetDeviceStatuses() ([]*postgres.DeviceStatus, error)
+	SaveDeviceStatuses(statuses []*postgres.DeviceStatus) error
+}
+
+// Store is our in-memory store of per stream counters and per device statuses.
+// Both are updated as messages flow through the pipeline, and are periodically
+// flushed to the configured Persister so that they approximately survive
+// restarts.
+type Store struct {
+	persister Persister
+	interval  time.Duration
+	clock     clock.Clock
+	logger    kitlog.Logger
+	quit      chan struct{}
+	wg        sync.WaitGroup
+
+	sync.RWMutex
+	streams      map[string]*postgres.StreamStats
+	dirty        map[string]bool
+	devices      map[secret.Secret]*postgres.DeviceStatus
+	dirtyDevices map[secret.Secret]bool
+}
+
+// NewStore returns a new Store instance. It takes as parameters a Persister
+// (which may be nil in which case stats are held purely in memory), the
+// interval at which stats should be flushed, a clock and a logger.
+func NewStore(persister Persister, interval time.Duration, cl clock.Clock, logger kitlog.Logger) *Store {
+	logger = kitlog.With(logger, "module", "stats")
+
+	return &Store{
+		persister:    persister,
+		interval:     interval,
+		clock:        cl,
+		logger:       logger,
+		streams:      make(map[string]*postgres.StreamStats),
+		dirty:        make(map[string]bool),
+		devices:      make(map[secret.Secret]*postgres.DeviceStatus),
+		dirtyDevices: make(map[secret.Secret]bool),
+	}
+}
+
+// Start loads any previously persisted stats and statuses, and then starts a
+// goroutine which periodically writes them back to the Persister.
+func (s *Store) Start() error {
+	if s.persister == nil {
+		return nil
+	}
+
+	s.logger.Log("msg", "starting stats store", "interval", s.interval)
+
+	if s.interval <= 0 {
+		return errors.New("stats flush interval must be positive")
+	}
+
+	stats, err := s.persister.GetStreamStats()
+	if err != nil {
+		return errors.Wrap(err, "failed to load stream stats")
+	}
+
+	statuses, err := s.persister.GetDeviceStatuses()
+	if err != nil {
+		return errors.Wrap(err, "failed to load device statuses")
+	}
+
+	s.Lock()
+	for _, st := range stats {
+		s.streams[st.StreamID] = st
+	}
+	for _, st := range statuses {
+		if st.MessageCounts == nil {
+			st.MessageCounts = postgres.MessageCounts{}
+		}
+		s.devices[st.DeviceToken] = st
+	}
+	s.Unlock()
+
+	s.quit = make(chan struct{})
+	s.wg.Add(1)
+
+	go s.loop()
+
+	return nil
+}
+
+// Stop stops the flush goroutine, and writes any outstanding stats to the
+// Persister.
+func (s *Store) Stop() error {
+	if s.persister == nil || s.quit == nil {
+		return nil
+	}
+
+	s.logger.Log("msg", "stopping stats store")
+
+	close(s.quit)
+	s.wg.Wait()
+
+	return s.Flush()
+}
+
+// Flush writes all stats and statuses modified since the last flush to the
+// Persister. Stream stats and device statuses are written independently, so
+// that a failure to write one does not prevent writing the other, and any which
+// fail to be written remain marked as modified so that they are written by the
+// next flush. The errors of both writes are returned together.
+func (s *Store) Flush() error {
+	if s.persister == nil {
+		return nil
+	}
+
+	s.Lock()
+	stats := make([]*postgres.StreamStats, 0, len(s.dirty))
+	for streamID := range s.dirty {
+		if st, ok := s.streams[streamID]; ok {
+			c := *st
+			stats = append(stats, &c)
+		}
+	}
+	s.dirty = make(map[string]bool)
+
+	statuses := make([]*postgres.DeviceStatus, 0, len(s.dirtyDevices))
+	for deviceToken := range s.dirtyDevices {
+		if st, ok := s.devices[deviceToken]; ok {
+			statuses = append(statuses, s.copyStatus(st))
+		}
+	}
+	s.dirtyDevices = make(map[secret.Secret]bool)
+	s.Unlock()
+
+	var errs flushErrors
+
+	if len(stats) > 0 {
+		err := s.persister.SaveStreamStats(stats)
+		if err != nil {
+			s.restoreDirty(stats)
+			errs = append(errs, errors.Wrap(err, "failed to save stream stats"))
+		}
+	}
+
+	if len(statuses) > 0 {
+		err := s.persister.SaveDeviceStatuses(statuses)
+		if err != nil {
+			s.restoreDirtyDevices(statuses)
+			errs = append(errs, errors.Wrap(err, "failed to save device statuses"))
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// flushErrors aggregates the errors encountered while flushing stats and
+// statuses.
+type flushErrors []error
+
+// Error returns each error, separated by semicolons.
+func (f flushErrors) Error() string {
+	msgs := make([]string, 0, len(f))
+	for _, err := range f {
+		msgs = append(msgs, err.Error())
+	}
+
+	return strings.Join(msgs, "; ")
+}
+
+// restoreDirty marks the given stats as modified again after they failed to be
+// written, so that they are retried by the next flush.
+func (s *Store) restoreDirty(stats []*postgres.StreamStats) {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, st := range stats {
+		s.dirty[st.StreamID] = true
+	}
+}
+
+// restoreDirtyDevices marks the given statuses as modified again after they
+// failed to be written, so that they are retried by the next flush.
+func (s *Store) restoreDirtyDevices(statuses []*postgres.DeviceStatus) {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, st := range statuses {
+		s.dirtyDevices[st.DeviceToken] = true
+	}
+}
+
+// RecordMessage records that a message was received for the given stream.
+func (s *Store) RecordMessage(streamID string) {
+	s.update(streamID, func(st *postgres.StreamStats) {
+		st.MessagesReceived++
+		st.LastMessageAt = null.TimeFrom(s.clock.Now())
+	})
+}
+
+// RecordEncrypted records the number of bytes of ciphertext produced for the
+// given stream.
+func (s *Store) RecordEncrypted(streamID string, n int) {
+	s.update(streamID, func(st *postgres.StreamStats) {
+		st.BytesEncrypted += uint64(n)
+	})
+}
+
+// RecordWrite records the outcome of a datastore write for the given stream.
+func (s *Store) RecordWrite(streamID string, err error) {
+	s.update(streamID, func(st *postgres.StreamStats) {
+		if err != nil {
+			st.WritesFailed++
+		} else {
+			st.WritesSucceeded++
+		}
+	})
+}
+
+// RecordThrottled records that a message for the given stream was skipped as
+// it arrived faster than the stream's rate limit.
+func (s *Store) RecordThrottled(streamID string) {
+	s.update(streamID, func(st *postgres.StreamStats) {
+		st.Throttled++
+	})
+}
+
+// RecordDropped records that the given number of messages for the given stream
+// were dropped as too many messages from its device were waiting to be
+// processed.
+func (s *Store) RecordDropped(streamID string, n int) {
+	s.update(streamID, func(st *postgres.StreamStats) {
+		st.Dropped += uint64(n)
+	})
+}
+
+// RecordLatency records the time taken to process a single message for the
+// given stream.
+func (s *Store) RecordLatency(streamID string, d time.Duration) {
+	s.update(streamID, func(st *postgres.StreamStats) {
+		st.ProcessingCount++
+		st.ProcessingTimeNs += uint64(d)
+	})
+}
+
+// Get returns a copy of the current stats for the given stream, or nil if we
+// have no stats recorded for it.
+func (s *Store) Get(streamID string) *postgres.StreamStats {
+	s.RLock()
+	defer s.RUnlock()
+
+	st, ok := s.streams[streamID]
+	if !ok {
+		return nil
+	}
+
+	c := *st
+	return &c
+}
+
+// RecordDeviceMessage records that a message was received from the given
+// device.
+func (s *Store) RecordDeviceMessage(deviceToken secret.Secret) {
+	now := s.clock.Now()
+
+	s.updateDevice(deviceToken, func(st *postgres.DeviceStatus) {
+		st.LastSeenAt = null.TimeFrom(now)
+		st.MessageCounts[now.Truncate(time.Minute).Unix()]++
+	})
+}
+
+// RecordDeviceResult records the outcome of processing a message from the
+// given device. A failure sets the device's error state, which is cleared by
+// the next message to be processed successfully.
+func (s *Store) RecordDeviceResult(deviceToken secret.Secret, err error) {
+	s.updateDevice(deviceToken, func(st *postgres.DeviceStatus) {
+		if err != nil {
+			st.Error = null.StringFrom(err.Error())
+			st.ErrorAt = null.TimeFrom(s.clock.Now())
+		} else {
+			st.Error = null.String{}
+			st.ErrorAt = null.Time{}
+		}
+	})
+}
+
+// GetDevice returns a copy of the current status of the given device, or nil
+// if we have not received any messages from it. Only the message counts of the
+// last RateWindow are included.
+func (s *Store) GetDevice(deviceToken secret.Secret) *postgres.DeviceStatus {
+	s.RLock()
+	defer s.RUnlock()
+
+	st, ok := s.devices[deviceToken]
+	if !ok {
+		return nil
+	}
+
+	return s.copyStatus(st)
+}
+
+// copyStatus returns a copy of the given status, omitting message counts for
+// minutes outside the RateWindow. It must be called with the lock held.
+func (s *Store) copyStatus(st *postgres.DeviceStatus) *postgres.DeviceStatus {
+	since := s.clock.Now().Add(-RateWindow).Unix()
+
+	c := *st
+	c.MessageCounts = postgres.MessageCounts{}
+
+	for minute, n := range st.MessageCounts {
+		if minute > since {
+			c.MessageCounts[minute] = n
+		}
+	}
+
+	return &c
+}
+
+// updateDevice is a helper that takes the write lock, creating the status
+// record for the device if required, discarding message counts which have left
+// the RateWindow, and then applies the given function to the record.
+func (s *Store) updateDevice(deviceToken secret.Secret, fn func(st *postgres.DeviceStatus)) {
+	s.Lock()
+	defer s.Unlock()
+
+	st, ok := s.devices[deviceToken]
+	if !ok {
+		st = &postgres.DeviceStatus{
+			DeviceToken:   deviceToken,
+			MessageCounts: postgres.MessageCounts{},
+		}
+		s.devices[deviceToken] = st
+	}
+
+	since := s.clock.Now().Add(-RateWindow).Unix()
+	for minute := range st.MessageCounts {
+		if minute <= since {
+			delete(st.MessageCounts, minute)
+		}
+	}
+
+	fn(st)
+	s.dirtyDevices[deviceToken] = true
+}
+
+// update is a helper that takes the write lock, creating the stats record for
+// the stream if required, and then applies the given function to the record.
+func (s *Store) update(streamID string, fn func(st *postgres.StreamStats)) {
+	s.Lock()
+	defer s.Unlock()
+
+	st, ok := s.streams[streamID]
+	if !ok {
+		st = &postgres.StreamStats{StreamID: streamID}
+		s.streams[streamID] = st
+	}
+
+	fn(st)
+	s.dirty[streamID] = true
+}
+
+// loop is run in a goroutine and flushes stats on each tick of our interval
+// until the store is stopped.
+func (s *Store) loop() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := s.Flush()
+			if err != nil {
+				level.Error(s.logger).Log("msg", "failed to flush stream stats", "err", err)
+			}
+		case <-s.quit:
+			return
+		}
+	}
+}
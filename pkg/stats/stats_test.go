@@ -0,0 +1,220 @@
+package stats_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/guregu/null.v3"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/stats"
+)
+
+type persister struct {
+	loaded        []*postgres.StreamStats
+	saved         []*postgres.StreamStats
+	savedStatuses []*postgres.DeviceStatus
+	saveErr       error
+	statusesErr   error
+}
+
+func (p *persister) GetStreamStats() ([]*postgres.StreamStats, error) {
+	return p.loaded, nil
+}
+
+func (p *persister) SaveStreamStats(s []*postgres.StreamStats) error {
+	if p.saveErr != nil {
+		return p.saveErr
+	}
+	p.saved = append(p.saved, s...)
+	return nil
+}
+
+func (p *persister) GetDeviceStatuses() ([]*postgres.DeviceStatus, error) {
+	return []*postgres.DeviceStatus{}, nil
+}
+
+func (p *persister) SaveDeviceStatuses(s []*postgres.DeviceStatus) error {
+	if p.statusesErr != nil {
+		return p.statusesErr
+	}
+	p.savedStatuses = append(p.savedStatuses, s...)
+	return nil
+}
+
+func TestRecording(t *testing.T) {
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	cl := clock.NewMock(now)
+
+	store := stats.NewStore(nil, time.Minute, cl, kitlog.NewNopLogger())
+
+	assert.Nil(t, store.Get("abc"))
+
+	store.RecordMessage("abc")
+	store.RecordEncrypted("abc", 256)
+	store.RecordWrite("abc", nil)
+	store.RecordLatency("abc", 20*time.Millisecond)
+
+	store.RecordMessage("abc")
+	store.RecordEncrypted("abc", 128)
+	store.RecordWrite("abc", errors.New("failed"))
+	store.RecordLatency("abc", 40*time.Millisecond)
+	store.RecordThrottled("abc")
+	store.RecordDropped("abc", 3)
+
+	st := store.Get("abc")
+	assert.NotNil(t, st)
+	assert.Equal(t, "abc", st.StreamID)
+	assert.Equal(t, uint64(2), st.MessagesReceived)
+	assert.Equal(t, uint64(384), st.BytesEncrypted)
+	assert.Equal(t, uint64(1), st.WritesSucceeded)
+	assert.Equal(t, uint64(1), st.WritesFailed)
+	assert.Equal(t, uint64(1), st.Throttled)
+	assert.Equal(t, uint64(3), st.Dropped)
+	assert.Equal(t, now, st.LastMessageAt.Time)
+	assert.Equal(t, 30*time.Millisecond, st.AverageLatency())
+}
+
+func TestPersistence(t *testing.T) {
+	cl := clock.NewMock(time.Now())
+
+	p := &persister{
+		loaded: []*postgres.StreamStats{
+			{
+				StreamID:         "abc",
+				MessagesReceived: 10,
+				LastMessageAt:    null.TimeFrom(time.Now()),
+			},
+		},
+	}
+
+	store := stats.NewStore(p, time.Hour, cl, kitlog.NewNopLogger())
+
+	err := store.Start()
+	assert.Nil(t, err)
+
+	st := store.Get("abc")
+	assert.NotNil(t, st)
+	assert.Equal(t, uint64(10), st.MessagesReceived)
+
+	store.RecordMessage("abc")
+	store.RecordMessage("def")
+
+	err = store.Stop()
+	assert.Nil(t, err)
+
+	assert.Len(t, p.saved, 2)
+
+	// a further flush with no changes should write nothing
+	err = store.Flush()
+	assert.Nil(t, err)
+	assert.Len(t, p.saved, 2)
+}
+
+func TestFlushFailure(t *testing.T) {
+	p := &persister{saveErr: errors.New("connection refused")}
+
+	store := stats.NewStore(p, time.Hour, clock.NewMock(time.Now()), kitlog.NewNopLogger())
+
+	store.RecordMessage("stream-1")
+
+	err := store.Flush()
+	assert.NotNil(t, err)
+	assert.Len(t, p.saved, 0)
+
+	// the failed stats are written by the next flush once the persister recovers
+	p.saveErr = nil
+
+	err = store.Flush()
+	assert.Nil(t, err)
+	assert.Len(t, p.saved, 1)
+	assert.Equal(t, "stream-1", p.saved[0].StreamID)
+}
+
+func TestFlushPartialFailure(t *testing.T) {
+	p := &persister{saveErr: errors.New("connection refused")}
+
+	store := stats.NewStore(p, time.Hour, clock.NewMock(time.Now()), kitlog.NewNopLogger())
+
+	store.RecordMessage("stream-1")
+	store.RecordDeviceMessage("abc123")
+
+	// device statuses are saved even though the stream stats fail
+	err := store.Flush()
+	assert.Equal(t, "failed to save stream stats: connection refused", err.Error())
+	assert.Len(t, p.saved, 0)
+	assert.Len(t, p.savedStatuses, 1)
+
+	p.saveErr = nil
+	p.statusesErr = errors.New("deadlock detected")
+
+	store.RecordDeviceMessage("abc123")
+
+	err = store.Flush()
+	assert.Equal(t, "failed to save device statuses: deadlock detected", err.Error())
+	assert.Len(t, p.saved, 1)
+	assert.Len(t, p.savedStatuses, 1)
+
+	p.saveErr = errors.New("connection refused")
+
+	store.RecordMessage("stream-1")
+
+	err = store.Flush()
+	assert.Equal(t, "failed to save stream stats: connection refused; failed to save device statuses: deadlock detected", err.Error())
+
+	// both are written by the next flush once the persister recovers
+	p.saveErr = nil
+	p.statusesErr = nil
+
+	err = store.Flush()
+	assert.Nil(t, err)
+	assert.Len(t, p.saved, 2)
+	assert.Len(t, p.savedStatuses, 2)
+}
+
+func TestDeviceStatus(t *testing.T) {
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	cl := clock.NewMock(now)
+
+	p := &persister{}
+
+	store := stats.NewStore(p, time.Hour, cl, kitlog.NewNopLogger())
+
+	assert.Nil(t, store.GetDevice("abc123"))
+
+	store.RecordDeviceMessage("abc123")
+	store.RecordDeviceResult("abc123", nil)
+
+	cl.Add(30 * time.Minute)
+
+	store.RecordDeviceMessage("abc123")
+	store.RecordDeviceMessage("abc123")
+	store.RecordDeviceResult("abc123", errors.New("failed to write data"))
+
+	st := store.GetDevice("abc123")
+	assert.NotNil(t, st)
+	assert.Equal(t, now.Add(30*time.Minute), st.LastSeenAt.Time)
+	assert.Equal(t, uint64(3), st.MessageCounts.Total())
+	assert.Equal(t, "failed to write data", st.Error.String)
+	assert.Equal(t, now.Add(30*time.Minute), st.ErrorAt.Time)
+
+	// messages older than the rate window are no longer counted, and a
+	// successful message clears the error
+	cl.Add(45 * time.Minute)
+
+	store.RecordDeviceMessage("abc123")
+	store.RecordDeviceResult("abc123", nil)
+
+	st = store.GetDevice("abc123")
+	assert.Equal(t, uint64(3), st.MessageCounts.Total())
+	assert.False(t, st.Error.Valid)
+
+	err := store.Flush()
+	assert.Nil(t, err)
+	assert.Len(t, p.savedStatuses, 1)
+	assert.Equal(t, "abc123", p.savedStatuses[0].DeviceToken.Reveal())
+}
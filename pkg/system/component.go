@@ -14,3 +14,11 @@ type Stoppable interface {
 	// Stop stops the component, cleaning up any open resources.
 	Stop() error
 }
+
+// Checkable is a single method interface for a component that can report
+// whether it is currently able to do its work.
+type Checkable interface {
+	// Check returns an error describing why the component is not currently
+	// healthy, or nil if it is.
+	Check() error
+}
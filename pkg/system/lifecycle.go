@@ -0,0 +1,252 @@
+package system
+
+import (
+	"sync"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/DECODEproject/iotencoder/pkg/logger/level"
+)
+
+const (
+	// phaseStart labels metrics recorded when starting a component.
+	phaseStart = "start"
+
+	// phaseStop labels metrics recorded when stopping a component.
+	phaseStop = "stop"
+)
+
+var (
+	// ComponentDurationGauge is a prometheus gauge vector recording how long
+	// the most recent start or stop of each component took in seconds.
+	ComponentDurationGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "component_duration_seconds",
+			Help:      "Duration of the most recent start or stop of each component",
+		},
+		[]string{"component", "phase"},
+	)
+
+	// ComponentFailuresCounter is a prometheus counter vector recording a
+	// count of failures to start or stop each component.
+	ComponentFailuresCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "component_failures",
+			Help:      "Count of failures to start or stop each component",
+		},
+		[]string{"component", "phase"},
+	)
+)
+
+// Hooks adapts a pair of functions into a component which may be registered
+// with a Lifecycle, for work which isn't a component in its own right such as
+// running migrations. Either function may be nil.
+type Hooks struct {
+	OnStart func() error
+	OnStop  func() error
+}
+
+// Start calls OnStart if set.
+func (h Hooks) Start() error {
+	if h.OnStart == nil {
+		return nil
+	}
+	return h.OnStart()
+}
+
+// Stop calls OnStop if set.
+func (h Hooks) Stop() error {
+	if h.OnStop == nil {
+		return nil
+	}
+	return h.OnStop()
+}
+
+// component is a named component registered with a Lifecycle.
+type component struct {
+	name      string
+	value     interface{}
+	dependsOn []string
+}
+
+// Lifecycle starts and stops a set of named components, each of which may
+// declare the components it depends on. Components are started in dependency
+// order, so that a component is only started once everything it depends on has
+// started, and are stopped in the reverse order.
+type Lifecycle struct {
+	logger kitlog.Logger
+
+	sync.Mutex
+	components []*component
+	started    []*component
+}
+
+// NewLifecycle returns a new Lifecycle with no registered components.
+func NewLifecycle(logger kitlog.Logger) *Lifecycle {
+	return &Lifecycle{
+		logger: kitlog.With(logger, "module", "system"),
+	}
+}
+
+// Register adds a component to the lifecycle under the given name, to be
+// started after each of the named components it depends on. The component is
+// started if it is Startable, and stopped if it is Stoppable. Components which
+// depend on no others are started in the order in which they are registered.
+func (l *Lifecycle) Register(name string, value interface{}, dependsOn ...string) {
+	l.Lock()
+	defer l.Unlock()
+
+	l.components = append(l.components, &component{
+		name:      name,
+		value:     value,
+		dependsOn: dependsOn,
+	})
+}
+
+// Start starts every registered component in dependency order. If a component
+// fails to start, every component already started is stopped again before the
+// error is returned. An error is also returned without starting anything if a
+// component depends on one which isn't registered, or dependencies are cyclic.
+func (l *Lifecycle) Start() error {
+	l.Lock()
+	defer l.Unlock()
+
+	ordered, err := l.order()
+	if err != nil {
+		return err
+	}
+
+	for _, c := range ordered {
+		if s, ok := c.value.(Startable); ok {
+			begin := time.Now()
+			err = s.Start()
+			l.observe(c.name, phaseStart, time.Since(begin), err)
+
+			if err != nil {
+				l.stop()
+				return errors.Wrapf(err, "failed to start %s", c.name)
+			}
+		}
+
+		l.started = append(l.started, c)
+	}
+
+	return nil
+}
+
+// Stop stops every started component in the reverse of the order in which they
+// were started. A component which fails to stop doesn't prevent the rest being
+// stopped, and the first error is returned once all have been attempted.
+func (l *Lifecycle) Stop() error {
+	l.Lock()
+	defer l.Unlock()
+
+	return l.stop()
+}
+
+// stop stops every started component. Callers must hold the lock.
+func (l *Lifecycle) stop() error {
+	var first error
+
+	for i := len(l.started) - 1; i >= 0; i-- {
+		c := l.started[i]
+
+		s, ok := c.value.(Stoppable)
+		if !ok {
+			continue
+		}
+
+		begin := time.Now()
+		err := s.Stop()
+		l.observe(c.name, phaseStop, time.Since(begin), err)
+
+		if err != nil && first == nil {
+			first = errors.Wrapf(err, "failed to stop %s", c.name)
+		}
+	}
+
+	l.started = nil
+
+	return first
+}
+
+// observe logs and records metrics for the start or stop of a component.
+func (l *Lifecycle) observe(name, phase string, duration time.Duration, err error) {
+	labels := prometheus.Labels{"component": name, "phase": phase}
+
+	ComponentDurationGauge.With(labels).Set(duration.Seconds())
+
+	if err != nil {
+		ComponentFailuresCounter.With(labels).Inc()
+		level.Error(l.logger).Log("msg", "component failed to "+phase, "component", name, "duration", duration, "err", err)
+		return
+	}
+
+	l.logger.Log("msg", "component "+phase+" complete", "component", name, "duration", duration)
+}
+
+// order returns the registered components sorted so that every component
+// follows all of those it depends on, otherwise preserving the order of
+// registration. Callers must hold the lock.
+func (l *Lifecycle) order() ([]*component, error) {
+	byName := make(map[string]*component, len(l.components))
+	for _, c := range l.components {
+		if _, ok := byName[c.name]; ok {
+			return nil, errors.Errorf("component %s registered twice", c.name)
+		}
+		byName[c.name] = c
+	}
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+
+	state := map[string]int{}
+	ordered := make([]*component, 0, len(l.components))
+
+	var visit func(c *component) error
+	visit = func(c *component) error {
+		switch state[c.name] {
+		case visited:
+			return nil
+		case visiting:
+			return errors.Errorf("component %s has a cyclic dependency", c.name)
+		}
+
+		state[c.name] = visiting
+
+		for _, name := range c.dependsOn {
+			dep, ok := byName[name]
+			if !ok {
+				return errors.Errorf("component %s depends on unknown component %s", c.name, name)
+			}
+
+			err := visit(dep)
+			if err != nil {
+				return err
+			}
+		}
+
+		state[c.name] = visited
+		ordered = append(ordered, c)
+
+		return nil
+	}
+
+	for _, c := range l.components {
+		err := visit(c)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return ordered, nil
+}
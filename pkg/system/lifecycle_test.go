@@ -0,0 +1,115 @@
+package system_test
+
+import (
+	"errors"
+	"testing"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/system"
+)
+
+// recorder returns Hooks which append the name and phase of each call to
+// calls, failing to start if startErr is set.
+func recorder(calls *[]string, name string, startErr error) system.Hooks {
+	return system.Hooks{
+		OnStart: func() error {
+			*calls = append(*calls, "start "+name)
+			return startErr
+		},
+		OnStop: func() error {
+			*calls = append(*calls, "stop "+name)
+			return nil
+		},
+	}
+}
+
+func TestLifecycleOrder(t *testing.T) {
+	calls := []string{}
+
+	lc := system.NewLifecycle(kitlog.NewNopLogger())
+	lc.Register("encoder", recorder(&calls, "encoder", nil), "db", "mqtt")
+	lc.Register("kafka", recorder(&calls, "kafka", nil), "encoder")
+	lc.Register("db", recorder(&calls, "db", nil))
+	lc.Register("mqtt", recorder(&calls, "mqtt", nil))
+
+	err := lc.Start()
+	assert.Nil(t, err)
+
+	err = lc.Stop()
+	assert.Nil(t, err)
+
+	assert.Equal(t, []string{
+		"start db",
+		"start mqtt",
+		"start encoder",
+		"start kafka",
+		"stop kafka",
+		"stop encoder",
+		"stop mqtt",
+		"stop db",
+	}, calls)
+}
+
+func TestLifecycleStartFailure(t *testing.T) {
+	calls := []string{}
+
+	lc := system.NewLifecycle(kitlog.NewNopLogger())
+	lc.Register("db", recorder(&calls, "db", nil))
+	lc.Register("encoder", recorder(&calls, "encoder", errors.New("boom")), "db")
+	lc.Register("kafka", recorder(&calls, "kafka", nil), "encoder")
+
+	err := lc.Start()
+	assert.NotNil(t, err)
+	assert.Equal(t, "failed to start encoder: boom", err.Error())
+
+	assert.Equal(t, []string{
+		"start db",
+		"start encoder",
+		"stop db",
+	}, calls)
+}
+
+func TestLifecycleInvalidDependencies(t *testing.T) {
+	testcases := []struct {
+		label    string
+		register func(lc *system.Lifecycle)
+		expected string
+	}{
+		{
+			label: "unknown",
+			register: func(lc *system.Lifecycle) {
+				lc.Register("encoder", system.Hooks{}, "db")
+			},
+			expected: "component encoder depends on unknown component db",
+		},
+		{
+			label: "cyclic",
+			register: func(lc *system.Lifecycle) {
+				lc.Register("a", system.Hooks{}, "b")
+				lc.Register("b", system.Hooks{}, "a")
+			},
+			expected: "component a has a cyclic dependency",
+		},
+		{
+			label: "duplicate",
+			register: func(lc *system.Lifecycle) {
+				lc.Register("db", system.Hooks{})
+				lc.Register("db", system.Hooks{})
+			},
+			expected: "component db registered twice",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			lc := system.NewLifecycle(kitlog.NewNopLogger())
+			tc.register(lc)
+
+			err := lc.Start()
+			assert.NotNil(t, err)
+			assert.Equal(t, tc.expected, err.Error())
+		})
+	}
+}
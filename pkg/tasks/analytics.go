@@ -0,0 +1,36 @@
+package tasks
+
+import (
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/DECODEproject/iotencoder/pkg/admin"
+)
+
+func init() {
+	streamsCmd.AddCommand(streamsAnalyticsCmd)
+}
+
+var streamsAnalyticsCmd = &cobra.Command{
+	Use:   "analytics",
+	Short: "Export stream metadata and statistics for analytics now",
+	Long: `This command asks a running encoder to export the metadata and statistics of
+every live stream as Parquet files now, rather than waiting for its next
+scheduled export, replacing any export already written today. The encoder must
+be running with --analytics-dir or --analytics-bucket. The files written are
+printed.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancel, err := requestContext(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+
+		resp, err := adminClient(cmd).ExportAnalytics(ctx, &admin.ExportAnalyticsRequest{})
+		if err != nil {
+			return errors.Wrap(err, "failed to export analytics")
+		}
+
+		return writeJSON(cmd.OutOrStdout(), resp)
+	},
+}
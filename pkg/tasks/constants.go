@@ -1,7 +1,30 @@
 package tasks
 
+import "time"
+
 const (
 	// DatabaseURL is the environment variable which must hold the database URL to
 	// which we want to connect.
 	DatabaseURLKey = "IOTENCODER_DATABASE_URL"
+
+	// redisPoolSize is the number of idle connections kept open to the Redis
+	// server of the redis state store.
+	redisPoolSize = 10
+
+	// redisTimeout is the timeout of dialling the Redis server and of each
+	// command sent to it.
+	redisTimeout = 5 * time.Second
+)
+
+var (
+	// DefaultLatencyBuckets are the default upper bounds in seconds of the
+	// buckets of our latency histograms. Most RPCs, encryptions and datastore
+	// writes take between 5 and 50ms, so buckets are concentrated there.
+	DefaultLatencyBuckets = []string{"0.001", "0.0025", "0.005", "0.0075", "0.01", "0.015", "0.02", "0.03", "0.04", "0.05", "0.075", "0.1", "0.25", "0.5", "1", "2.5", "5"}
+
+	// DefaultLagBuckets are the default upper bounds in seconds of the buckets
+	// of the ingestion lag histogram. Payloads are usually processed within a
+	// second of being recorded, but device clocks drift and backlogs build up
+	// over minutes, so buckets reach out to an hour.
+	DefaultLagBuckets = []string{"0.1", "0.25", "0.5", "1", "2.5", "5", "10", "30", "60", "300", "900", "3600"}
 )
@@ -0,0 +1,270 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"text/tabwriter"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/DECODEproject/iotencoder/pkg/mqtt"
+	"github.com/DECODEproject/iotencoder/pkg/pipeline"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+	"github.com/DECODEproject/iotencoder/pkg/server"
+	"github.com/DECODEproject/iotencoder/pkg/system"
+	"github.com/DECODEproject/iotencoder/pkg/version"
+)
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+
+	doctorCmd.Flags().Duration("timeout", 10*time.Second, "Timeout after which each check is reported as failed")
+}
+
+// errSkipped is returned by a check which does not apply to the configuration
+// being checked.
+var errSkipped = errors.New("skipped")
+
+// doctorCheck is a single check of an external dependency. run returns a short
+// description of what was found, or an error if the dependency is unusable.
+type doctorCheck struct {
+	name string
+	run  func(ctx context.Context) (string, error)
+}
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check every external dependency of the encoder",
+	Long: fmt.Sprintf(`This command checks that each external dependency of the encoder can be used
+with the configuration the server would start with, read from the same
+IOTENCODER_ environment variables, and prints a report of the result of each
+check. It is intended for debugging broken deployments, and exits with an
+error if any check failed.
+
+Postgres is connected to and its migrations compared with those compiled into
+the binary without applying them, a connection is made to each MQTT broker and
+a test topic subscribed to, the datastore is sent an empty request, and a
+trivial zenroom script is executed to report the version of zenroom.
+
+For example:
+
+    $ IOTENCODER_DATABASE_URL=postgres://... IOTENCODER_DATASTORE=http://datastore:8080 \
+        IOTENCODER_BROKER_USERNAME=decode %s doctor`, version.BinaryName),
+	// the report shows which checks failed, so usage is not printed on failure
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		timeout, err := cmd.Flags().GetDuration("timeout")
+		if err != nil {
+			return err
+		}
+
+		checks := []doctorCheck{
+			{name: "postgres", run: checkPostgres},
+		}
+
+		brokers := append([]string{viper.GetString("broker-addr")}, viper.GetStringSlice("mqtt-failover")...)
+		for _, broker := range brokers {
+			checks = append(checks, doctorCheck{name: "mqtt " + broker, run: mqttCheck(broker)})
+		}
+
+		checks = append(checks,
+			doctorCheck{name: "datastore", run: checkDatastore},
+			doctorCheck{name: "zenroom", run: checkZenroom},
+		)
+
+		w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "CHECK\tSTATUS\tDETAIL")
+
+		failed := 0
+
+		for _, check := range checks {
+			detail, err := runCheck(check, timeout)
+
+			status := "ok"
+			switch {
+			case err == errSkipped:
+				status = "skipped"
+			case err != nil:
+				status = "failed"
+				detail = err.Error()
+				failed++
+			}
+
+			fmt.Fprintf(w, "%s\t%s\t%s\n", check.name, status, detail)
+		}
+
+		err = w.Flush()
+		if err != nil {
+			return err
+		}
+
+		if failed > 0 {
+			return fmt.Errorf("%d of %d checks failed", failed, len(checks))
+		}
+
+		return nil
+	},
+}
+
+// runCheck runs the given check, returning an error if it does not complete
+// within the timeout. Not every client we use can be cancelled, so a check
+// which times out is abandoned rather than stopped.
+func runCheck(check doctorCheck, timeout time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	type result struct {
+		detail string
+		err    error
+	}
+
+	done := make(chan result, 1)
+
+	go func() {
+		detail, err := check.run(ctx)
+		done <- result{detail: detail, err: err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.detail, r.err
+	case <-ctx.Done():
+		return "", errors.Errorf("timed out after %s", timeout)
+	}
+}
+
+// checkPostgres connects to Postgres and compares the migrations applied to it
+// with those compiled into the binary, without applying any.
+func checkPostgres(ctx context.Context) (string, error) {
+	if viper.GetString("storage-backend") == server.BoltStorage {
+		return "streams are stored in bolt", errSkipped
+	}
+
+	connStr := viper.GetString("database-url")
+	if connStr == "" {
+		return "", errors.New("no database URL configured")
+	}
+
+	db, err := postgres.Open(connStr)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to open database")
+	}
+	defer db.Close()
+
+	err = db.PingContext(ctx)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to connect to database")
+	}
+
+	status, err := postgres.GetMigrationStatus(db.DB, kitlog.NewNopLogger())
+	if err != nil {
+		return "", err
+	}
+
+	if status.Dirty {
+		return "", errors.Errorf("migration %d failed and must be resolved manually", status.Version)
+	}
+
+	pending := 0
+	for _, m := range status.Migrations {
+		if !m.Applied {
+			pending++
+		}
+	}
+
+	if n := len(status.Migrations); n > 0 && status.Version > status.Migrations[n-1].Version {
+		return "", errors.Errorf("database version %d is newer than any migration of this binary", status.Version)
+	}
+
+	if pending == 0 {
+		return fmt.Sprintf("version %d, up to date", status.Version), nil
+	}
+
+	if !viper.GetBool("auto-migrate") {
+		return "", errors.Errorf("version %d, %d pending migrations must be applied manually", status.Version, pending)
+	}
+
+	return fmt.Sprintf("version %d, %d pending migrations will be applied on startup", status.Version, pending), nil
+}
+
+// mqttCheck returns a check which connects to the given broker and subscribes
+// to a test topic. We connect with our own client ID, as connecting with that
+// of a running encoder would disconnect it.
+func mqttCheck(broker string) func(ctx context.Context) (string, error) {
+	return func(ctx context.Context) (string, error) {
+		if broker == "" {
+			return "", errors.New("no broker address configured")
+		}
+
+		username := viper.GetString("broker-username")
+		if username == "" {
+			return "", errors.New("no broker username configured")
+		}
+
+		client := mqtt.NewClient(&mqtt.Config{
+			ClientIDPrefix:  fmt.Sprintf("%s-doctor", viper.GetString("mqtt-client-prefix")),
+			ProtocolVersion: viper.GetInt("mqtt-version"),
+		}, kitlog.NewNopLogger())
+
+		if stoppable, ok := client.(system.Stoppable); ok {
+			defer stoppable.Stop()
+		}
+
+		token := secret.Secret(fmt.Sprintf("doctor-%s", uuid.New().String()[:8]))
+
+		err := client.Subscribe(broker, username, token, func(topic string, payload []byte, properties map[string]string, done func()) {})
+		if err != nil {
+			return "", err
+		}
+
+		err = client.Unsubscribe(broker, username, token)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to unsubscribe from test topic")
+		}
+
+		return fmt.Sprintf("subscribed to %s", mqtt.Topic(token)), nil
+	}
+}
+
+// checkDatastore sends an empty request to the datastore, in the same way the
+// encoder checks the datastores of streams as they are created.
+func checkDatastore(ctx context.Context) (string, error) {
+	addr := viper.GetString("datastore")
+	if addr == "" {
+		return "", errors.New("no datastore address configured")
+	}
+
+	u, err := url.Parse(addr)
+	if err != nil || u.Host == "" {
+		return "", errors.Errorf("invalid datastore address: %s", addr)
+	}
+
+	// the configured datastore is trusted, so its host is the one we allow
+	pool := pipeline.NewDatastorePool(nil, pipeline.NewDatastoreFactory(&http.Client{}), []string{u.Host})
+
+	err = pool.Check(ctx, addr)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("reachable at %s", addr), nil
+}
+
+// checkZenroom executes a trivial script, which reports the version of zenroom
+// linked into the binary.
+func checkZenroom(ctx context.Context) (string, error) {
+	out, err := pipeline.ZenroomExec([]byte(`print(VERSION)`), nil, nil)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to execute zenroom")
+	}
+
+	return fmt.Sprintf("version %s", out), nil
+}
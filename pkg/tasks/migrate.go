@@ -2,6 +2,8 @@ package tasks
 
 import (
 	"fmt"
+	"strconv"
+	"text/tabwriter"
 
 	"github.com/spf13/cobra"
 
@@ -15,6 +17,8 @@ func init() {
 	migrateCmd.AddCommand(migrateNewCmd)
 	migrateCmd.AddCommand(migrateDownCmd)
 	migrateCmd.AddCommand(migrateUpCmd)
+	migrateCmd.AddCommand(migrateToCmd)
+	migrateCmd.AddCommand(migrateStatusCmd)
 
 	migrateNewCmd.Flags().String("dir", "pkg/migrations/sql", "The directory into which new migrations should be created")
 	migrateDownCmd.Flags().IntP("steps", "s", 1, "Number of down migrations to run")
@@ -28,7 +32,8 @@ var migrateCmd = &cobra.Command{
 
 Up migrations are run automatically when the application boots, but here we
 also offer commands to create properly named migration files, and a command
-to run down migrations.`,
+to run down migrations, migrate to a specific version, and to report which
+migrations have been applied.`,
 }
 
 var migrateNewCmd = &cobra.Command{
@@ -117,3 +122,73 @@ boot.
 		return postgres.MigrateUp(db.DB, logger)
 	},
 }
+
+var migrateToCmd = &cobra.Command{
+	Use:   "to <version>",
+	Short: "Migrate Postgres to a specific version",
+	Long: fmt.Sprintf(`This command migrates Postgres to the specified version, running up or down
+migrations as required. Versions are the numeric prefix of the migration file
+names, which can be listed via the migrate status command.
+
+For example:
+
+    $ %s migrate to 20190512204433`, version.BinaryName),
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		target, err := strconv.ParseUint(args[0], 10, 64)
+		if err != nil {
+			return fmt.Errorf("Invalid migration version: %s", args[0])
+		}
+
+		connStr, err := GetFromEnv(DatabaseURLKey)
+		if err != nil {
+			return err
+		}
+
+		logger := logger.NewLogger()
+
+		db, err := postgres.Open(connStr)
+		if err != nil {
+			return err
+		}
+
+		return postgres.MigrateTo(db.DB, uint(target), logger)
+	},
+}
+
+var migrateStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the migration status of Postgres",
+	Long: `This command lists all migrations compiled into the binary, showing which
+have been applied to Postgres along with the current version of the database.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		connStr, err := GetFromEnv(DatabaseURLKey)
+		if err != nil {
+			return err
+		}
+
+		logger := logger.NewLogger()
+
+		db, err := postgres.Open(connStr)
+		if err != nil {
+			return err
+		}
+
+		status, err := postgres.GetMigrationStatus(db.DB, logger)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 8, 2, ' ', 0)
+
+		fmt.Fprintf(w, "Current version:\t%d\n", status.Version)
+		fmt.Fprintf(w, "Dirty:\t%t\n\n", status.Dirty)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+
+		for _, m := range status.Migrations {
+			fmt.Fprintf(w, "%d\t%s\t%t\n", m.Version, m.Name, m.Applied)
+		}
+
+		return w.Flush()
+	},
+}
@@ -0,0 +1,152 @@
+package tasks
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/DECODEproject/iotencoder/pkg/admin"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/version"
+)
+
+func init() {
+	streamsCmd.AddCommand(schemasCmd)
+	schemasCmd.AddCommand(schemasRegisterCmd)
+	schemasCmd.AddCommand(schemasListCmd)
+	schemasCmd.AddCommand(schemasGetCmd)
+
+	schemasRegisterCmd.Flags().String("kind", "", "Kind of device whose payloads the schema describes (e.g. noise-sensor)")
+	schemasRegisterCmd.Flags().StringArray("channel", []string{}, "Channel of the schema as SENSOR_ID:NAME:UNIT:TYPE, may be repeated (e.g. 12:temperature:celsius:number)")
+}
+
+var schemasCmd = &cobra.Command{
+	Use:   "schemas",
+	Short: "Manage the payload schemas streams may reference",
+	Long: `This task provides subcommands for registering and inspecting payload schemas
+via the admin API of a running encoder. A schema describes the shape of the
+decoded payloads of a kind of device, and streams referencing it only share
+readings which conform to it.`,
+}
+
+var schemasRegisterCmd = &cobra.Command{
+	Use:   "register",
+	Short: "Register a new version of a payload schema",
+	Long: fmt.Sprintf(`This command registers a new version of the payload schema of a kind of
+device, printing the registered schema. Channels are specified as
+SENSOR_ID:NAME:UNIT:TYPE, where the unit is that of readings once any
+conversions are applied and may be empty, and the type is one of number,
+integer or boolean. Readings of sensors the schema does not declare, or whose
+values are not of the declared type, are dropped.
+
+For example:
+
+    $ %s streams schemas register --kind noise-sensor \
+        --channel 12:temperature:celsius:number \
+        --channel 14:light:lux:integer \
+        --channel 16:door::boolean`, version.BinaryName),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		kind, err := cmd.Flags().GetString("kind")
+		if err != nil {
+			return err
+		}
+
+		entries, err := cmd.Flags().GetStringArray("channel")
+		if err != nil {
+			return err
+		}
+
+		channels, err := ParseChannels(entries)
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel, err := requestContext(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+
+		resp, err := adminClient(cmd).RegisterSchema(ctx, &admin.RegisterSchemaRequest{
+			Kind:     kind,
+			Channels: channels,
+		})
+		if err != nil {
+			return errors.Wrap(err, "failed to register schema")
+		}
+
+		return writeJSON(cmd.OutOrStdout(), resp)
+	},
+}
+
+var schemasListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List every version of every payload schema",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancel, err := requestContext(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+
+		resp, err := adminClient(cmd).ListSchemas(ctx, &admin.ListSchemasRequest{})
+		if err != nil {
+			return errors.Wrap(err, "failed to list schemas")
+		}
+
+		return writeJSON(cmd.OutOrStdout(), resp)
+	},
+}
+
+var schemasGetCmd = &cobra.Command{
+	Use:   "get <kind[@version]>",
+	Short: "Get a version of a payload schema, the latest if no version is given",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancel, err := requestContext(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+
+		resp, err := adminClient(cmd).GetSchema(ctx, &admin.GetSchemaRequest{
+			Ref: args[0],
+		})
+		if err != nil {
+			return errors.Wrap(err, "failed to get schema")
+		}
+
+		return writeJSON(cmd.OutOrStdout(), resp)
+	},
+}
+
+// ParseChannels converts a slice of strings of the form
+// SENSOR_ID:NAME:UNIT:TYPE into the channels of a payload schema. Channels are
+// validated by the encoder rather than here.
+func ParseChannels(entries []string) ([]*postgres.Channel, error) {
+	channels := make([]*postgres.Channel, 0, len(entries))
+
+	for _, entry := range entries {
+		parts := strings.Split(entry, ":")
+		if len(parts) != 4 {
+			return nil, fmt.Errorf("Invalid channel, must be SENSOR_ID:NAME:UNIT:TYPE: %s", entry)
+		}
+
+		sensorID, err := strconv.ParseUint(parts[0], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid channel sensor id: %s", parts[0])
+		}
+
+		channels = append(channels, &postgres.Channel{
+			SensorID: uint32(sensorID),
+			Name:     parts[1],
+			Unit:     parts[2],
+			Type:     postgres.ChannelType(parts[3]),
+		})
+	}
+
+	return channels, nil
+}
@@ -0,0 +1,79 @@
+package tasks
+
+import (
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/DECODEproject/iotencoder/pkg/logger/level"
+	"github.com/DECODEproject/iotencoder/pkg/quota"
+	"github.com/DECODEproject/iotencoder/pkg/settings"
+)
+
+// readConfigFile reads the server's config file if one was given, so that
+// values not given by flag or environment variable are taken from it.
+func readConfigFile() error {
+	configFile := viper.GetString("config-file")
+	if configFile == "" {
+		return nil
+	}
+
+	viper.SetConfigFile(configFile)
+
+	err := viper.ReadInConfig()
+	if err != nil {
+		return errors.Wrap(err, "failed to read config file")
+	}
+
+	return nil
+}
+
+// loadSettings returns the server settings which may be changed while it is
+// running, reading the config file again if one was given. It is called when
+// the server starts and whenever its settings are reloaded.
+func loadSettings(cmd *cobra.Command) (*settings.Settings, error) {
+	err := readConfigFile()
+	if err != nil {
+		return nil, err
+	}
+
+	logLevel, err := level.Parse(viper.GetString("log-level"))
+	if err != nil {
+		return nil, err
+	}
+
+	if viper.GetBool("verbose") {
+		logLevel = level.DebugLevel
+	}
+
+	// read from the flag rather than viper, which can't return a string array
+	// whose elements contain commas, unless the flag wasn't given in which case
+	// quotas may be listed in the config file
+	quotaEntries, err := cmd.Flags().GetStringArray("community-quota")
+	if err != nil {
+		return nil, err
+	}
+
+	if !cmd.Flags().Changed("community-quota") {
+		quotaEntries = viper.GetStringSlice("community-quota")
+	}
+
+	communityQuotas, err := quota.ParseCommunities(quotaEntries)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &settings.Settings{
+		LogLevel:        logLevel,
+		CommunityQuotas: communityQuotas,
+		IngestBatchSize: viper.GetInt("ingest-batch-size"),
+		OutboxBatchSize: viper.GetInt("outbox-batch-size"),
+	}
+
+	err = s.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
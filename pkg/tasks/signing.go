@@ -0,0 +1,186 @@
+package tasks
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/DECODEproject/iotencoder/pkg/signing"
+	"github.com/DECODEproject/iotencoder/pkg/version"
+)
+
+func init() {
+	rootCmd.AddCommand(signingKeyCmd)
+	signingKeyCmd.AddCommand(signingKeyGenerateCmd)
+	signingKeyCmd.AddCommand(signingKeyRotateCmd)
+	signingKeyCmd.AddCommand(signingKeyPublicCmd)
+
+	signingKeyGenerateCmd.Flags().Bool("force", false, "Overwrite the key file if it already exists")
+}
+
+// signingKeyInfo is written by the signing key commands to describe a key,
+// giving the public key consumers need to verify the payloads it signed.
+type signingKeyInfo struct {
+	KeyID     string `json:"key_id"`
+	PublicKey string `json:"public_key"`
+	Path      string `json:"path,omitempty"`
+}
+
+// signingKeyRotation is written by the rotate command, describing both the new
+// key and the key it replaced.
+type signingKeyRotation struct {
+	Current *signingKeyInfo `json:"current"`
+	Retired *signingKeyInfo `json:"retired"`
+}
+
+var signingKeyCmd = &cobra.Command{
+	Use:   "signing-key",
+	Short: "Manage the key with which the provenance of encrypted payloads is signed",
+	Long: `This task provides subcommands for generating, rotating and inspecting the key
+file given to the server by --signing-key-file. When configured, every payload
+written to the datastore carries a provenance giving the id of the instance and
+key which wrote it, and a signature of the encrypted data and metadata with the
+key. Consumers verify the signature with the public key printed by these
+commands, selected by the key id of the provenance.`,
+}
+
+var signingKeyGenerateCmd = &cobra.Command{
+	Use:   "generate <path>",
+	Short: "Generate a new signing key, writing it to the given path",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		force, err := cmd.Flags().GetBool("force")
+		if err != nil {
+			return err
+		}
+
+		path := args[0]
+
+		if !force {
+			_, err = os.Stat(path)
+			if err == nil {
+				return errors.Errorf("Signing key file already exists, use --force to overwrite it: %s", path)
+			}
+		}
+
+		key, err := writeSigningKey(path)
+		if err != nil {
+			return err
+		}
+
+		info, err := describeSigningKey(&key.PublicKey, path)
+		if err != nil {
+			return err
+		}
+
+		return writeJSON(cmd.OutOrStdout(), info)
+	},
+}
+
+var signingKeyRotateCmd = &cobra.Command{
+	Use:   "rotate <path>",
+	Short: "Replace the signing key at the given path with a new key",
+	Long: fmt.Sprintf(`This command replaces the signing key at the given path with a newly generated
+key, retiring the existing key by renaming it with its key id appended. Both
+keys are printed, and the retired public key should remain published to
+consumers for as long as they may verify payloads it signed. Servers use the
+new key once restarted.
+
+For example:
+
+    $ %s signing-key rotate /etc/iotencoder/signing.pem`, version.BinaryName),
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path := args[0]
+
+		retired, err := signing.LoadKey(path)
+		if err != nil {
+			return err
+		}
+
+		retiredPath := fmt.Sprintf("%s.%s", path, signing.KeyID(&retired.PublicKey))
+
+		err = os.Rename(path, retiredPath)
+		if err != nil {
+			return errors.Wrap(err, "failed to retire signing key")
+		}
+
+		key, err := writeSigningKey(path)
+		if err != nil {
+			return err
+		}
+
+		rotation := &signingKeyRotation{}
+
+		rotation.Current, err = describeSigningKey(&key.PublicKey, path)
+		if err != nil {
+			return err
+		}
+
+		rotation.Retired, err = describeSigningKey(&retired.PublicKey, retiredPath)
+		if err != nil {
+			return err
+		}
+
+		return writeJSON(cmd.OutOrStdout(), rotation)
+	},
+}
+
+var signingKeyPublicCmd = &cobra.Command{
+	Use:   "public <path>",
+	Short: "Print the id and public key of the signing key at the given path",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		key, err := signing.LoadKey(args[0])
+		if err != nil {
+			return err
+		}
+
+		info, err := describeSigningKey(&key.PublicKey, "")
+		if err != nil {
+			return err
+		}
+
+		return writeJSON(cmd.OutOrStdout(), info)
+	},
+}
+
+// writeSigningKey generates a new signing key and writes it to the given path,
+// readable only by its owner.
+func writeSigningKey(path string) (*ecdsa.PrivateKey, error) {
+	key, err := signing.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	encoded, err := signing.EncodeKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ioutil.WriteFile(path, encoded, 0600)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to write signing key")
+	}
+
+	return key, nil
+}
+
+// describeSigningKey returns the description of the given public key, read
+// from the given path if not empty.
+func describeSigningKey(key *ecdsa.PublicKey, path string) (*signingKeyInfo, error) {
+	encoded, err := signing.EncodePublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &signingKeyInfo{
+		KeyID:     signing.KeyID(key),
+		PublicKey: string(encoded),
+		Path:      path,
+	}, nil
+}
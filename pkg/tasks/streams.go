@@ -0,0 +1,1055 @@
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	encoder "github.com/thingful/twirp-encoder-go"
+	"github.com/twitchtv/twirp"
+
+	"github.com/DECODEproject/iotencoder/pkg/admin"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/processing"
+	"github.com/DECODEproject/iotencoder/pkg/rpc"
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+	"github.com/DECODEproject/iotencoder/pkg/smartcitizen"
+	"github.com/DECODEproject/iotencoder/pkg/version"
+)
+
+func init() {
+	rootCmd.AddCommand(streamsCmd)
+	streamsCmd.AddCommand(streamsListCmd)
+	streamsCmd.AddCommand(streamsGetCmd)
+	streamsCmd.AddCommand(streamsCreateCmd)
+	streamsCmd.AddCommand(streamsDeleteCmd)
+	streamsCmd.AddCommand(streamsConvertCmd)
+	streamsCmd.AddCommand(streamsBackfillCmd)
+	streamsCmd.AddCommand(streamsRotateSecretCmd)
+	streamsCmd.AddCommand(streamsRestoreCmd)
+	streamsCmd.AddCommand(streamsExportCmd)
+	streamsCmd.AddCommand(streamsImportCmd)
+	streamsCmd.AddCommand(streamsMigrateCmd)
+
+	streamsCmd.PersistentFlags().String("encoder-addr", "http://localhost:8081", "Address at which the encoder is listening")
+	streamsCmd.PersistentFlags().String("encoder-admin-addr", "http://localhost:8082", "Address at which the admin API of the encoder is listening")
+	streamsCmd.PersistentFlags().Duration("timeout", 10*time.Second, "Timeout for requests sent to the encoder")
+	streamsCmd.PersistentFlags().String("admin-token", "", "Bearer token presented to the admin API of the encoder")
+
+	streamsListCmd.Flags().String("device-token", "", "If given only the streams of this device are listed")
+	streamsListCmd.Flags().String("selector", "", "If given only the streams whose labels match this selector are listed (e.g. pilot=barcelona,sensor_kind!=noise)")
+	streamsGetCmd.Flags().String("token", "", "The token returned when the stream was created")
+	streamsDeleteCmd.Flags().String("token", "", "The token returned when the stream was created")
+	streamsDeleteCmd.Flags().Bool("delete-data", false, "Also ask the stream's datastore to delete the data written for its device within its community")
+	streamsConvertCmd.Flags().String("token", "", "The token returned when the stream was created")
+	streamsBackfillCmd.Flags().String("token", "", "The token returned when the stream was created")
+	streamsRotateSecretCmd.Flags().String("token", "", "The token returned when the stream was created")
+	streamsRestoreCmd.Flags().String("token", "", "The token returned when the stream was created")
+	streamsBackfillCmd.Flags().String("file", "-", "Path of a JSON file containing the readings to upload, or - to read from stdin")
+	streamsExportCmd.Flags().String("file", "-", "Path of the file to which the export is written, or - to write to stdout")
+	streamsExportCmd.Flags().String("export-key", "", "Key under which the secrets of exported streams are encrypted")
+	streamsImportCmd.Flags().String("file", "-", "Path of an export written by the export command, or - to read from stdin")
+	streamsImportCmd.Flags().String("export-key", "", "Key under which the secrets of exported streams were encrypted")
+	streamsMigrateCmd.Flags().String("target-addr", "", "Address of the admin API of the encoder to which streams are migrated")
+	streamsMigrateCmd.Flags().String("target-admin-token", "", "Bearer token presented to the admin API of the target encoder, the --admin-token if not given")
+	streamsMigrateCmd.Flags().Duration("verify-timeout", admin.DefaultVerifyTimeout, "How long to wait for the target to receive messages from each device")
+	streamsConvertCmd.Flags().StringArray("conversion", []string{}, "Conversion expression for a sensor, may be repeated (e.g. 12='x * 0.0625 - 40')")
+
+	streamsCreateCmd.Flags().String("device-token", "", "Token of the SmartCitizen device supplying data to the stream")
+	streamsCreateCmd.Flags().String("label", "", "Label of the device")
+	streamsCreateCmd.Flags().String("community-id", "", "Id of the community to which data is shared")
+	streamsCreateCmd.Flags().String("public-key", "", "Public key of the community used to encrypt data")
+	streamsCreateCmd.Flags().String("policy-id", "", "Id of a policy registered with the policy store whose public key is used to encrypt data, in place of --public-key")
+	streamsCreateCmd.Flags().Float64("longitude", 0, "Longitude of the device")
+	streamsCreateCmd.Flags().Float64("latitude", 0, "Latitude of the device")
+	streamsCreateCmd.Flags().String("exposure", "indoor", "Exposure of the device (indoor or outdoor)")
+	streamsCreateCmd.Flags().StringArray("operation", []string{}, "Operation to apply to a sensor, may be repeated (e.g. SHARE:12, MOVING_AVG:12:900, BIN:12:10,20,30, DOWNSAMPLE:12:300, DELTA:12:0.5)")
+	streamsCreateCmd.Flags().String("datastore-addr", "", "Address of the datastore to which the stream's data is written, if not the encoder's default")
+	streamsCreateCmd.Flags().String("datastore-timeout", "", "Deadline applied to each write of the stream's data to its datastore (e.g. 10s), if not the encoder's default")
+	streamsCreateCmd.Flags().String("source", "", "Source from which the stream's readings are received (mqtt, amqp or kafka), if not the encoder's default")
+	streamsCreateCmd.Flags().String("compression", "", "Compression applied to the stream's payloads before encryption (gzip or zstd), none if not given")
+	streamsCreateCmd.Flags().StringArray("stream-label", []string{}, "Label attached to the stream as key=value, may be repeated (e.g. pilot=barcelona)")
+	streamsCreateCmd.Flags().String("schema", "", "Payload schema the stream's readings must conform to, as kind@version or kind for the latest version")
+	streamsCreateCmd.Flags().StringArray("disposition", []string{}, "Disposition of a sensor's readings as sensor_id=encrypt|drop|plain, may be repeated (e.g. 12=plain)")
+	streamsCreateCmd.Flags().String("geofence", "", "Circles and polygons within which a mobile device's readings must be reported to be shared, separated by ; (e.g. circle=41.3851,2.1734,2km)")
+	streamsCreateCmd.Flags().Bool("archive", false, "Also write the stream's encrypted payloads to the encoder's archival object store")
+	streamsCreateCmd.Flags().String("topic-template", "", "MQTT topic on which the device publishes if not its default topic, {token} being replaced by the device token (e.g. sensors/{token}/up)")
+	streamsCreateCmd.Flags().String("priority", "", "Priority of the stream when the encoder is saturated and sheds load, one of low, normal or high (default normal)")
+	streamsCreateCmd.Flags().Bool("initial-reading", false, "Process the message the MQTT broker retained for the device's topic as the stream's first reading")
+	streamsCreateCmd.Flags().String("geo-privacy", "", "Grid to which the device's location is snapped, suppressing it unless k devices report from its cell (e.g. grid=500m,k=5,window=1h)")
+	streamsCreateCmd.Flags().String("rate-limit", "", "Maximum rate at which the stream's messages are processed, skipping any arriving faster (e.g. 1/s or 30/m,burst=5)")
+	streamsCreateCmd.Flags().String("sample", "", "Percentage of the stream's messages written, chosen at random with the rest discarded (e.g. 10%)")
+	streamsCreateCmd.Flags().String("schedule", "", "Daily windows during which the stream's data is shared, dropping or buffering messages outside them (e.g. 08:00-22:00,tz=Europe/Madrid,outside=buffer)")
+	streamsCreateCmd.Flags().String("privacy", "", "Differential privacy noise injected into the stream's moving averages (e.g. laplace:epsilon=0.5,budget=100,period=24h)")
+	streamsCreateCmd.Flags().String("timestamp-policy", "", "How the timestamps of the stream's readings are handled (device, server or reject:<duration>), device if not given")
+
+	viper.BindPFlag("encoder-addr", streamsCmd.PersistentFlags().Lookup("encoder-addr"))
+	viper.BindPFlag("encoder-admin-addr", streamsCmd.PersistentFlags().Lookup("encoder-admin-addr"))
+}
+
+var streamsCmd = &cobra.Command{
+	Use:   "streams",
+	Short: "Manage streams registered with a running encoder",
+	Long: `This task provides subcommands for creating, inspecting and deleting streams
+via the API of a running encoder. Streams are created and deleted using the
+Twirp Encoder API, while listing and inspecting streams uses the admin API.
+
+Responses are written to stdout as JSON. The addresses of the encoder and its
+admin API, and the token presented to the admin API, can also be supplied via
+the environment variables: $IOTENCODER_ENCODER_ADDR,
+$IOTENCODER_ENCODER_ADMIN_ADDR and $IOTENCODER_ADMIN_TOKEN`,
+}
+
+var streamsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List all streams, or those of a single device",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		deviceToken, err := cmd.Flags().GetString("device-token")
+		if err != nil {
+			return err
+		}
+
+		selector, err := cmd.Flags().GetString("selector")
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel, err := requestContext(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+
+		resp, err := adminClient(cmd).ListStreams(ctx, &admin.ListStreamsRequest{
+			DeviceToken: deviceToken,
+			Selector:    selector,
+		})
+		if err != nil {
+			return errors.Wrap(err, "failed to list streams")
+		}
+
+		return writeJSON(cmd.OutOrStdout(), resp)
+	},
+}
+
+var streamsGetCmd = &cobra.Command{
+	Use:   "get <stream-uid>",
+	Short: "Get a single stream",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		token, err := cmd.Flags().GetString("token")
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel, err := requestContext(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+
+		resp, err := adminClient(cmd).GetStream(ctx, &admin.GetStreamRequest{
+			StreamUid: args[0],
+			Token:     token,
+		})
+		if err != nil {
+			return errors.Wrap(err, "failed to get stream")
+		}
+
+		return writeJSON(cmd.OutOrStdout(), resp)
+	},
+}
+
+var streamsCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Create a new stream",
+	Long: fmt.Sprintf(`This command creates a new stream, printing the new stream's uid and token.
+Operations are specified as ACTION:SENSOR_ID with an additional argument for
+moving averages and downsampling (the interval in seconds), bins (the bin
+boundaries) and deltas (the threshold). A downsampled sensor is shared
+unprocessed, but at most one reading is forwarded per interval, while a delta
+sensor only forwards readings differing from the last forwarded reading by more
+than the threshold. If no operations are given all sensor data is shared.
+
+For example:
+
+    $ %s streams create --device-token abc123 --label "My Device" \
+        --community-id 123 --public-key BBLewg4VqLR38b38daE7Fj... \
+        --longitude 2.13 --latitude 41.4 \
+        --operation SHARE:12 --operation MOVING_AVG:14:900
+
+A stream's data is written to the encoder's default datastore unless
+--datastore-addr is given, in which case the encoder checks that the datastore
+is on one of the hosts it allows, and can be reached, before creating the
+stream. Each write is given a deadline of
+--datastore-timeout if set, in place of the encoder's default. Similarly
+readings are received from the encoder's default source unless --source is
+given, and payloads are only compressed before encryption if --compression is
+given.
+
+In place of --public-key a stream may be given the --policy-id of a policy
+registered with the DECODE policy store, in which case the encoder resolves the
+policy's public key, and applies any later rotation of the key to the stream.
+If --community-id is not given the policy id is used.
+
+A stream may be given labels with --stream-label, by which streams may be
+selected when listed with --selector, and messages counted when the encoder is
+run with --metric-labels. Note that --label is the label of the device.
+
+A stream may reference a payload schema registered with the schemas register
+command with --schema, in which case only readings conforming to the schema are
+shared, and the exact version of the schema is recorded in the metadata of each
+datastore entry so that consumers know how to interpret the decrypted data.
+
+By default the readings of every sensor are encrypted. With --disposition the
+readings of a sensor may instead be dropped, or written in plain alongside the
+encrypted data if the encoder was started with --allow-plaintext. If the stream
+has operations, a sensor written in plain must be shared without processing.
+
+A stream with moving averages may be given differential privacy with --privacy,
+in which case noise calibrated to epsilon is injected into each moving average
+before encryption, each spending epsilon from the stream's privacy budget.
+Once the budget of a period is spent, moving averages are withheld until the
+next period.
+
+The location of a device in a sensitive deployment may be protected with
+--geo-privacy, snapping it to the centre of a grid cell of the given size, and
+if k is given suppressing it unless at least k devices have reported from the
+same cell within the window.
+
+The readings of a mobile device may be restricted to an area with --geofence,
+given as circles (a centre and radius) and polygons (three or more latitude and
+longitude pairs) separated by semicolons. Payloads reporting a location outside
+all of them are dropped, while those without one are checked against the
+device's registered location.
+
+The throughput of a chatty device may be limited with --rate-limit, given as a
+number of messages per second, minute or hour. Messages arriving faster than
+this are skipped rather than encrypted and written.
+
+Where statistical coverage is enough, --sample writes only the given random
+percentage of the stream's messages, each stream making the same choices
+whenever its messages are processed in the same order.
+
+Data may be shared only during certain hours with --schedule, given as one or
+more daily windows in a timezone, UTC if not given. Messages received outside
+the windows are dropped, or with outside=buffer held until the next window
+opens, which requires the encoder to be running with --outbox.
+
+The encrypted payloads of a stream may additionally be kept in long term
+storage with --archive, which writes each of them to the object store the
+encoder is running with, see --archive-endpoint.
+
+Devices whose firmware doesn't publish on the default topic may be given their
+own topic with --topic-template, in which {token} is replaced by the device
+token. Every stream of a device received over MQTT shares its topic, so a
+stream created without a template takes that of the device's other streams.
+
+When the encoder is saturated and sheds load, the messages of streams created
+with --priority low are dropped first and those of --priority high never are.
+
+With --initial-reading the message the broker retained for the device's topic,
+if any, is processed as the stream's first reading, so a current value is
+written to the datastore without waiting for the device to next publish.
+
+Along with the stream's uid and token the topic to which the device must
+publish, the QoS at which the encoder subscribes to it, and the stream's
+effective configuration are printed.`, version.BinaryName),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		req, err := buildCreateRequest(cmd)
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel, err := requestContext(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+
+		headers := http.Header{}
+
+		datastoreAddr, _ := cmd.Flags().GetString("datastore-addr")
+		if datastoreAddr != "" {
+			headers.Set(rpc.DatastoreAddrHeader, datastoreAddr)
+		}
+
+		datastoreTimeout, _ := cmd.Flags().GetString("datastore-timeout")
+		if datastoreTimeout != "" {
+			headers.Set(rpc.DatastoreTimeoutHeader, datastoreTimeout)
+		}
+
+		source, _ := cmd.Flags().GetString("source")
+		if source != "" {
+			headers.Set(rpc.SourceHeader, source)
+		}
+
+		compression, _ := cmd.Flags().GetString("compression")
+		if compression != "" {
+			headers.Set(rpc.CompressionHeader, compression)
+		}
+
+		timestampPolicy, _ := cmd.Flags().GetString("timestamp-policy")
+		if timestampPolicy != "" {
+			headers.Set(rpc.TimestampPolicyHeader, timestampPolicy)
+		}
+
+		policyID, _ := cmd.Flags().GetString("policy-id")
+		if policyID != "" {
+			headers.Set(rpc.PolicyIDHeader, policyID)
+		}
+
+		streamLabels, _ := cmd.Flags().GetStringArray("stream-label")
+		if len(streamLabels) > 0 {
+			headers.Set(rpc.LabelsHeader, strings.Join(streamLabels, ","))
+		}
+
+		schema, _ := cmd.Flags().GetString("schema")
+		if schema != "" {
+			headers.Set(rpc.SchemaHeader, schema)
+		}
+
+		dispositions, _ := cmd.Flags().GetStringArray("disposition")
+		if len(dispositions) > 0 {
+			headers.Set(rpc.DispositionsHeader, strings.Join(dispositions, ","))
+		}
+
+		privacySpec, _ := cmd.Flags().GetString("privacy")
+		if privacySpec != "" {
+			headers.Set(rpc.PrivacyHeader, privacySpec)
+		}
+
+		geoPrivacy, _ := cmd.Flags().GetString("geo-privacy")
+		if geoPrivacy != "" {
+			headers.Set(rpc.GeoPrivacyHeader, geoPrivacy)
+		}
+
+		rateLimit, _ := cmd.Flags().GetString("rate-limit")
+		if rateLimit != "" {
+			headers.Set(rpc.RateLimitHeader, rateLimit)
+		}
+
+		sample, _ := cmd.Flags().GetString("sample")
+		if sample != "" {
+			headers.Set(rpc.SamplingHeader, sample)
+		}
+
+		sched, _ := cmd.Flags().GetString("schedule")
+		if sched != "" {
+			headers.Set(rpc.ScheduleHeader, sched)
+		}
+
+		fence, _ := cmd.Flags().GetString("geofence")
+		if fence != "" {
+			headers.Set(rpc.GeofenceHeader, fence)
+		}
+
+		archive, _ := cmd.Flags().GetBool("archive")
+		if archive {
+			headers.Set(rpc.ArchiveHeader, "true")
+		}
+
+		topicTemplate, _ := cmd.Flags().GetString("topic-template")
+		if topicTemplate != "" {
+			headers.Set(rpc.TopicTemplateHeader, topicTemplate)
+		}
+
+		priority, _ := cmd.Flags().GetString("priority")
+		if priority != "" {
+			headers.Set(rpc.PriorityHeader, priority)
+		}
+
+		initial, _ := cmd.Flags().GetBool("initial-reading")
+		if initial {
+			headers.Set(rpc.InitialReadingHeader, "true")
+		}
+
+		if len(headers) > 0 {
+			ctx, err = twirp.WithHTTPRequestHeaders(ctx, headers)
+			if err != nil {
+				return errors.Wrap(err, "failed to set request headers")
+			}
+		}
+
+		resp, err := encoderClient().CreateStream(ctx, req)
+		if err != nil {
+			return errors.Wrap(err, "failed to create stream")
+		}
+
+		return writeJSON(cmd.OutOrStdout(), resp)
+	},
+}
+
+// headerRecorder is an http.RoundTripper which records the headers of the last
+// response received, as our twirp clients don't expose them.
+type headerRecorder struct {
+	header http.Header
+}
+
+// RoundTrip sends the request with the default transport, recording the headers
+// of the response.
+func (h *headerRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err == nil {
+		h.header = resp.Header
+	}
+	return resp, err
+}
+
+var streamsDeleteCmd = &cobra.Command{
+	Use:   "delete <stream-uid>",
+	Short: "Delete a stream",
+	Long: `This command deletes a stream, unsubscribing from its device if it has no
+other streams. Deleted streams may be restored until they are purged.
+
+With --delete-data the stream's datastore is also asked to delete the data
+written for the stream's device within its community, which requires the
+encoder to be running with --datastore-deletion. The result of the deletion is
+printed, and recorded in the audit log. A failed deletion doesn't prevent the
+stream from being deleted, and may be retried by restoring the stream and
+deleting it again.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		token, err := cmd.Flags().GetString("token")
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel, err := requestContext(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+
+		deleteData, _ := cmd.Flags().GetBool("delete-data")
+		if deleteData {
+			ctx, err = twirp.WithHTTPRequestHeaders(ctx, http.Header{
+				rpc.DeleteDataHeader: []string{"true"},
+			})
+			if err != nil {
+				return errors.Wrap(err, "failed to set request headers")
+			}
+		}
+
+		recorder := &headerRecorder{}
+		client := encoder.NewEncoderProtobufClient(viper.GetString("encoder-addr"), &http.Client{Transport: recorder})
+
+		resp, err := client.DeleteStream(ctx, &encoder.DeleteStreamRequest{
+			StreamUid: args[0],
+			Token:     token,
+		})
+		if err != nil {
+			return errors.Wrap(err, "failed to delete stream")
+		}
+
+		return writeJSON(cmd.OutOrStdout(), newDeletedStream(resp, recorder.header))
+	},
+}
+
+// deletedStream is the output of the delete command, adding the result of any
+// data deletion reported in the response headers to the response.
+type deletedStream struct {
+	*encoder.DeleteStreamResponse
+	DataDeletion      string `json:"data_deletion,omitempty"`
+	DataDeleted       *int64 `json:"data_deleted,omitempty"`
+	DataDeletionError string `json:"data_deletion_error,omitempty"`
+}
+
+// newDeletedStream returns the output of the delete command for the given
+// response and response headers.
+func newDeletedStream(resp *encoder.DeleteStreamResponse, header http.Header) *deletedStream {
+	deleted := &deletedStream{
+		DeleteStreamResponse: resp,
+		DataDeletion:         header.Get(rpc.DataDeletionHeader),
+		DataDeletionError:    header.Get(rpc.DataDeletionErrorHeader),
+	}
+
+	if n, err := strconv.ParseInt(header.Get(rpc.DataDeletedHeader), 10, 64); err == nil {
+		deleted.DataDeleted = &n
+	}
+
+	return deleted
+}
+
+var streamsConvertCmd = &cobra.Command{
+	Use:   "convert <stream-uid>",
+	Short: "Set the unit conversions applied to a stream's sensors",
+	Long: fmt.Sprintf(`This command replaces the conversions applied to readings of a stream's
+sensors before any operations are applied and the data is encrypted, printing
+the updated stream. Conversions are specified as SENSOR_ID=EXPRESSION, where the
+expression is an arithmetic formula over the raw reading x using the operators
++ - * / and parentheses. Running the command without any conversions removes
+all conversions from the stream.
+
+For example, to convert a raw ADC reading into degrees Celsius:
+
+    $ %s streams convert 5c1ad56a-... --token abc123 \
+        --conversion '12=x * 0.0625 - 40'`, version.BinaryName),
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		token, err := cmd.Flags().GetString("token")
+		if err != nil {
+			return err
+		}
+
+		entries, err := cmd.Flags().GetStringArray("conversion")
+		if err != nil {
+			return err
+		}
+
+		conversions, err := ParseConversions(entries)
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel, err := requestContext(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+
+		resp, err := adminClient(cmd).SetConversions(ctx, &admin.SetConversionsRequest{
+			StreamUid:   args[0],
+			Token:       token,
+			Conversions: conversions,
+		})
+		if err != nil {
+			return errors.Wrap(err, "failed to set conversions")
+		}
+
+		return writeJSON(cmd.OutOrStdout(), resp)
+	},
+}
+
+var streamsRotateSecretCmd = &cobra.Command{
+	Use:   "rotate-secret <stream-uid>",
+	Short: "Generate a new secret for pushing a stream's readings over HTTP",
+	Long: fmt.Sprintf(`This command generates a new ingest secret for a stream, replacing any
+existing secret, and prints it. Devices which push readings over HTTP must sign
+each payload with this secret, so it should be recorded as it cannot be
+retrieved later. Rotating the secret of a stream immediately revokes the
+previous secret.
+
+    $ %s streams rotate-secret 5c1ad56a-... --token abc123`, version.BinaryName),
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		token, err := cmd.Flags().GetString("token")
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel, err := requestContext(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+
+		resp, err := adminClient(cmd).RotateIngestSecret(ctx, &admin.RotateIngestSecretRequest{
+			StreamUid: args[0],
+			Token:     token,
+		})
+		if err != nil {
+			return errors.Wrap(err, "failed to rotate ingest secret")
+		}
+
+		return writeJSON(cmd.OutOrStdout(), resp)
+	},
+}
+
+var streamsRestoreCmd = &cobra.Command{
+	Use:   "restore <stream-uid>",
+	Short: "Restore a deleted stream",
+	Long: fmt.Sprintf(`This command restores a stream which was deleted less than the encoder's
+--deleted-stream-ttl ago, after which the stream's readings are once again
+encrypted and written to its datastore. Readings received while the stream was
+deleted are not recovered.
+
+    $ %s streams restore 5c1ad56a-... --token abc123`, version.BinaryName),
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		token, err := cmd.Flags().GetString("token")
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel, err := requestContext(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+
+		resp, err := adminClient(cmd).RestoreStream(ctx, &admin.RestoreStreamRequest{
+			StreamUid: args[0],
+			Token:     token,
+		})
+		if err != nil {
+			return errors.Wrap(err, "failed to restore stream")
+		}
+
+		return writeJSON(cmd.OutOrStdout(), resp)
+	},
+}
+
+var streamsExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export the configuration of all streams",
+	Long: fmt.Sprintf(`This command exports the configuration of every stream registered with the
+encoder as newline delimited JSON, so that a replacement encoder can be rebuilt
+with the import command after data loss. Stream tokens, ingest secrets and
+device tokens are encrypted under the given export key, which must be at least
+16 characters and is required to import the streams. The key can also be
+supplied via the environment variable: $IOTENCODER_EXPORT_KEY
+
+    $ %s streams export --file streams.ndjson`, version.BinaryName),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path, err := cmd.Flags().GetString("file")
+		if err != nil {
+			return err
+		}
+
+		exportKey, err := exportKey(cmd)
+		if err != nil {
+			return err
+		}
+
+		var w io.Writer = cmd.OutOrStdout()
+
+		if path != "-" {
+			f, err := os.Create(path)
+			if err != nil {
+				return errors.Wrap(err, "failed to create export file")
+			}
+			defer f.Close()
+
+			w = f
+		}
+
+		ctx, cancel, err := requestContext(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+
+		err = adminClient(cmd).ExportStreams(ctx, &admin.ExportStreamsRequest{
+			ExportKey: exportKey,
+		}, w)
+		if err != nil {
+			return errors.Wrap(err, "failed to export streams")
+		}
+
+		return nil
+	},
+}
+
+var streamsImportCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Import streams exported from another encoder",
+	Long: fmt.Sprintf(`This command imports streams written by the export command, registering each
+with its original uid, token and ingest secret so that existing clients
+continue to work, and prints how many streams were imported. Streams which
+already exist are skipped, so an interrupted import may be repeated, while
+streams which cannot be imported are listed with the reason.
+
+    $ %s streams import --file streams.ndjson`, version.BinaryName),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path, err := cmd.Flags().GetString("file")
+		if err != nil {
+			return err
+		}
+
+		exportKey, err := exportKey(cmd)
+		if err != nil {
+			return err
+		}
+
+		var r io.Reader = os.Stdin
+
+		if path != "-" {
+			f, err := os.Open(path)
+			if err != nil {
+				return errors.Wrap(err, "failed to open export file")
+			}
+			defer f.Close()
+
+			r = f
+		}
+
+		ctx, cancel, err := requestContext(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+
+		resp, err := adminClient(cmd).ImportStreams(ctx, &admin.ImportStreamsRequest{
+			ExportKey: exportKey,
+		}, r)
+		if err != nil {
+			return errors.Wrap(err, "failed to import streams")
+		}
+
+		return writeJSON(cmd.OutOrStdout(), resp)
+	},
+}
+
+var streamsMigrateCmd = &cobra.Command{
+	Use:   "migrate <stream-uid>...",
+	Short: "Migrate streams to another encoder",
+	Long: fmt.Sprintf(`This command moves the given streams to the encoder at the target address,
+e.g. when replacing a cluster. Each stream is imported into the target with its
+existing uid, token and ingest secret, and is only deleted from this encoder
+once the target has received a message from the stream's device, so no
+readings are lost. Streams whose devices send no message to the target within
+the verify timeout are left in place on both encoders, and may be migrated
+again later. The request is allowed --timeout in addition to the verify
+timeout.
+
+    $ %s streams migrate 5c1ad56a-... --target-addr http://green:8082`, version.BinaryName),
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		targetAddr, err := cmd.Flags().GetString("target-addr")
+		if err != nil {
+			return err
+		}
+
+		targetToken, err := cmd.Flags().GetString("target-admin-token")
+		if err != nil {
+			return err
+		}
+
+		if targetToken == "" {
+			targetToken = adminToken(cmd)
+		}
+
+		verifyTimeout, err := cmd.Flags().GetDuration("verify-timeout")
+		if err != nil {
+			return err
+		}
+
+		timeout, err := cmd.Flags().GetDuration("timeout")
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout+verifyTimeout)
+		defer cancel()
+
+		resp, err := adminClient(cmd).MigrateStreams(ctx, &admin.MigrateStreamsRequest{
+			TargetAddr:    targetAddr,
+			TargetToken:   targetToken,
+			StreamUids:    args,
+			VerifyTimeout: verifyTimeout.String(),
+		})
+		if err != nil {
+			return errors.Wrap(err, "failed to migrate streams")
+		}
+
+		return writeJSON(cmd.OutOrStdout(), resp)
+	},
+}
+
+var streamsBackfillCmd = &cobra.Command{
+	Use:   "backfill <stream-uid>",
+	Short: "Upload a batch of historical readings for a stream",
+	Long: fmt.Sprintf(`This command uploads readings buffered by a device while offline, which are
+processed and encrypted exactly as if they had been received over MQTT, and
+prints how many readings were processed and how many failed. Readings are read
+as a JSON array in which each element has the same form as the data published
+by SmartCitizen devices:
+
+    [
+      {"recorded_at": "2019-06-01T12:00:00Z", "sensors": [{"id": 13, "value": 51.0}]},
+      {"recorded_at": "2019-06-01T12:01:00Z", "sensors": [{"id": 13, "value": 51.4}]}
+    ]
+
+For example:
+
+    $ %s streams backfill 5c1ad56a-... --token abc123 --file readings.json`, version.BinaryName),
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		token, err := cmd.Flags().GetString("token")
+		if err != nil {
+			return err
+		}
+
+		path, err := cmd.Flags().GetString("file")
+		if err != nil {
+			return err
+		}
+
+		readings, err := readReadings(path)
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel, err := requestContext(cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+
+		resp, err := adminClient(cmd).WriteReadings(ctx, &admin.WriteReadingsRequest{
+			StreamUid: args[0],
+			Token:     token,
+			Readings:  readings,
+		})
+		if err != nil {
+			return errors.Wrap(err, "failed to write readings")
+		}
+
+		return writeJSON(cmd.OutOrStdout(), resp)
+	},
+}
+
+// encoderClient returns a Twirp client for the configured encoder.
+func encoderClient() encoder.Encoder {
+	return encoder.NewEncoderProtobufClient(viper.GetString("encoder-addr"), &http.Client{})
+}
+
+// adminClient returns an admin API client for the configured encoder, which
+// presents the admin token given by the --admin-token flag or the environment.
+func adminClient(cmd *cobra.Command) *admin.Client {
+	return admin.NewClient(viper.GetString("encoder-admin-addr"), secret.Secret(adminToken(cmd)), &http.Client{})
+}
+
+// adminToken returns the admin token given by the --admin-token flag, or if
+// not given by the environment.
+func adminToken(cmd *cobra.Command) string {
+	if flag := cmd.Flags().Lookup("admin-token"); flag != nil && flag.Value.String() != "" {
+		return flag.Value.String()
+	}
+
+	return viper.GetString("admin-token")
+}
+
+// exportKey returns the export key given by the --export-key flag, or if not
+// given by the environment.
+func exportKey(cmd *cobra.Command) (string, error) {
+	key, err := cmd.Flags().GetString("export-key")
+	if err != nil {
+		return "", err
+	}
+
+	if key == "" {
+		key = viper.GetString("export-key")
+	}
+
+	if key == "" {
+		return "", errors.New("An export key is required")
+	}
+
+	return key, nil
+}
+
+// requestContext returns a context that expires after the configured timeout.
+func requestContext(cmd *cobra.Command) (context.Context, context.CancelFunc, error) {
+	timeout, err := cmd.Flags().GetDuration("timeout")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	return ctx, cancel, nil
+}
+
+// readReadings reads a JSON array of readings from the file at the given path,
+// or from stdin if the path is -.
+func readReadings(path string) ([]smartcitizen.SensorData, error) {
+	var r io.Reader = os.Stdin
+
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to open readings file")
+		}
+		defer f.Close()
+
+		r = f
+	}
+
+	var readings []smartcitizen.SensorData
+
+	err := json.NewDecoder(r).Decode(&readings)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode readings")
+	}
+
+	return readings, nil
+}
+
+// writeJSON writes the given value to w as indented JSON.
+func writeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// buildCreateRequest builds a CreateStreamRequest from the flags of the create
+// command.
+func buildCreateRequest(cmd *cobra.Command) (*encoder.CreateStreamRequest, error) {
+	flags := cmd.Flags()
+
+	deviceToken, _ := flags.GetString("device-token")
+	label, _ := flags.GetString("label")
+	communityID, _ := flags.GetString("community-id")
+	publicKey, _ := flags.GetString("public-key")
+	longitude, _ := flags.GetFloat64("longitude")
+	latitude, _ := flags.GetFloat64("latitude")
+	exposure, _ := flags.GetString("exposure")
+	ops, _ := flags.GetStringArray("operation")
+
+	exposureValue, ok := encoder.CreateStreamRequest_Exposure_value[strings.ToUpper(exposure)]
+	if !ok {
+		return nil, fmt.Errorf("Invalid exposure: %s", exposure)
+	}
+
+	operations, err := ParseOperations(ops)
+	if err != nil {
+		return nil, err
+	}
+
+	return &encoder.CreateStreamRequest{
+		DeviceToken:        deviceToken,
+		DeviceLabel:        label,
+		CommunityId:        communityID,
+		RecipientPublicKey: publicKey,
+		Location: &encoder.CreateStreamRequest_Location{
+			Longitude: longitude,
+			Latitude:  latitude,
+		},
+		Exposure:   encoder.CreateStreamRequest_Exposure(exposureValue),
+		Operations: operations,
+	}, nil
+}
+
+// ParseOperations converts a slice of operation strings of the form
+// ACTION:SENSOR_ID[:ARG] into operations for a CreateStreamRequest. The
+// argument is required for MOVING_AVG and DOWNSAMPLE (the interval in
+// seconds), BIN (a comma separated list of bin boundaries) and DELTA (the
+// threshold). Each is parsed into an operation with typed processing, which is
+// validated before it is returned.
+func ParseOperations(entries []string) ([]*encoder.CreateStreamRequest_Operation, error) {
+	operations := []*encoder.CreateStreamRequest_Operation{}
+
+	for _, entry := range entries {
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("Invalid operation, expected ACTION:SENSOR_ID: %s", entry)
+		}
+
+		sensorID, err := strconv.ParseUint(parts[1], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid operation sensor id: %s", parts[1])
+		}
+
+		op := &encoder.CreateStreamRequest_Operation{
+			SensorId: uint32(sensorID),
+		}
+
+		switch postgres.Action(strings.ToUpper(parts[0])) {
+		case postgres.Share:
+			op.Processing = &encoder.CreateStreamRequest_Operation_Passthrough{
+				Passthrough: &encoder.Passthrough{},
+			}
+		case postgres.Delta:
+			if len(parts) != 3 {
+				return nil, fmt.Errorf("Delta operation requires a threshold: %s", entry)
+			}
+
+			threshold, err := strconv.ParseFloat(parts[2], 64)
+			if err != nil || threshold < 0 {
+				return nil, fmt.Errorf("Invalid delta threshold: %s", parts[2])
+			}
+
+			op.Processing = &encoder.CreateStreamRequest_Operation_Delta{
+				Delta: &encoder.Delta{Threshold: threshold},
+			}
+		case postgres.Downsample:
+			if len(parts) != 3 {
+				return nil, fmt.Errorf("Downsample operation requires an interval: %s", entry)
+			}
+
+			interval, err := strconv.ParseUint(parts[2], 10, 32)
+			if err != nil || interval == 0 {
+				return nil, fmt.Errorf("Invalid downsample interval: %s", parts[2])
+			}
+
+			op.Processing = &encoder.CreateStreamRequest_Operation_Downsample{
+				Downsample: &encoder.Downsample{Interval: uint32(interval)},
+			}
+		case postgres.MovingAverage:
+			if len(parts) != 3 {
+				return nil, fmt.Errorf("Moving average operation requires an interval: %s", entry)
+			}
+
+			interval, err := strconv.ParseUint(parts[2], 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid moving average interval: %s", parts[2])
+			}
+
+			op.Processing = &encoder.CreateStreamRequest_Operation_MovingAverage{
+				MovingAverage: &encoder.MovingAverage{Interval: uint32(interval)},
+			}
+		case postgres.Bin:
+			if len(parts) != 3 {
+				return nil, fmt.Errorf("Bin operation requires a list of bins: %s", entry)
+			}
+
+			bins := []float64{}
+			for _, b := range strings.Split(parts[2], ",") {
+				bin, err := strconv.ParseFloat(strings.TrimSpace(b), 64)
+				if err != nil {
+					return nil, fmt.Errorf("Invalid bin value: %s", b)
+				}
+
+				bins = append(bins, bin)
+			}
+
+			op.Processing = &encoder.CreateStreamRequest_Operation_Bin{
+				Bin: &encoder.Bin{Bins: bins},
+			}
+		default:
+			return nil, fmt.Errorf("Invalid operation action: %s", parts[0])
+		}
+
+		err = processing.Validate(op)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid operation: %v", err)
+		}
+
+		operations = append(operations, op)
+	}
+
+	return operations, nil
+}
+
+// ParseConversions converts a slice of strings of the form SENSOR_ID=EXPRESSION
+// into conversions keyed by sensor id. Expressions are validated by the
+// encoder rather than here.
+func ParseConversions(entries []string) (map[uint32]string, error) {
+	mapping, err := ParseMapping(entries)
+	if err != nil {
+		return nil, err
+	}
+
+	conversions := make(map[uint32]string, len(mapping))
+
+	for id, expr := range mapping {
+		sensorID, err := strconv.ParseUint(id, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid conversion sensor id: %s", id)
+		}
+
+		conversions[uint32(sensorID)] = expr
+	}
+
+	return conversions, nil
+}
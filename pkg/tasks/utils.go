@@ -3,6 +3,12 @@ package tasks
 import (
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/DECODEproject/iotencoder/pkg/pipeline"
 )
 
 // GetFromEnv is a simple wrapper around os.Getenv that emits an error if a
@@ -15,3 +21,105 @@ func GetFromEnv(key string) (string, error) {
 
 	return val, nil
 }
+
+// ParseMapping converts a slice of strings of the form "key=value" into a map,
+// returning an error if any entry is malformed.
+func ParseMapping(entries []string) (map[string]string, error) {
+	mapping := make(map[string]string)
+
+	for _, entry := range entries {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("Invalid mapping entry, expected key=value: %s", entry)
+		}
+
+		mapping[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+
+	return mapping, nil
+}
+
+// DefaultInstanceID returns an identifier for this instance made up of the
+// hostname and a random suffix, so that instances restarted on the same host
+// are not mistaken for one another.
+func DefaultInstanceID() (string, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", err
+	}
+
+	suffix, err := uuid.NewRandom()
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s-%s", hostname, suffix.String()[:8]), nil
+}
+
+// ParseBuckets converts a slice of strings into histogram bucket upper bounds,
+// returning an error if any entry is not a number or the bounds are not
+// strictly increasing.
+func ParseBuckets(entries []string) ([]float64, error) {
+	buckets := make([]float64, 0, len(entries))
+
+	for _, entry := range entries {
+		bound, err := strconv.ParseFloat(strings.TrimSpace(entry), 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid bucket, expected a number of seconds: %s", entry)
+		}
+
+		if len(buckets) > 0 && bound <= buckets[len(buckets)-1] {
+			return nil, fmt.Errorf("Invalid buckets, bounds must be strictly increasing: %s", entry)
+		}
+
+		buckets = append(buckets, bound)
+	}
+
+	return buckets, nil
+}
+
+// ParseRanges converts a mapping of sensor id to range, where each range is of
+// the form "min:max" or "min:max:clamp", into the ranges applied by the
+// pipeline's range filter.
+func ParseRanges(mapping map[string]string) (map[int]pipeline.Range, error) {
+	ranges := make(map[int]pipeline.Range)
+
+	for id, value := range mapping {
+		sensorID, err := strconv.Atoi(id)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid sensor id: %s", id)
+		}
+
+		parts := strings.Split(value, ":")
+		if len(parts) < 2 || len(parts) > 3 {
+			return nil, fmt.Errorf("Invalid range, expected min:max or min:max:clamp: %s", value)
+		}
+
+		min, err := strconv.ParseFloat(parts[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid range minimum: %s", parts[0])
+		}
+
+		max, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid range maximum: %s", parts[1])
+		}
+
+		if min > max {
+			return nil, fmt.Errorf("Invalid range, minimum exceeds maximum: %s", value)
+		}
+
+		r := pipeline.Range{Min: min, Max: max}
+
+		if len(parts) == 3 {
+			if parts[2] != "clamp" {
+				return nil, fmt.Errorf("Invalid range mode, expected clamp: %s", parts[2])
+			}
+			r.Clamp = true
+		}
+
+		ranges[sensorID] = r
+	}
+
+	return ranges, nil
+}
@@ -0,0 +1,163 @@
+// Package throttle limits the rate at which the messages of each stream are
+// processed, so that a single chatty device can't exceed the throughput
+// configured for its streams. Each stream is given a token bucket, refilled at
+// the stream's rate up to its burst, from which each message processed takes a
+// token.
+package throttle
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+)
+
+// sweepInterval is the number of calls to Allow between sweeps of a Limiter
+// for buckets which have refilled, and so may be forgotten.
+const sweepInterval = 1000
+
+// units maps the units in which a rate may be given to their duration.
+var units = map[string]time.Duration{
+	"s": time.Second,
+	"m": time.Minute,
+	"h": time.Hour,
+}
+
+// Spec is the rate limit of a stream. Up to Rate messages per second are
+// allowed on average, while up to Burst messages may be allowed at once after
+// a quiet period.
+type Spec struct {
+	Rate  float64
+	Burst int
+}
+
+// Parse parses a spec from its string form, a number of messages per second,
+// minute or hour optionally followed by a burst, e.g. "2/s" or "30/m,burst=5".
+// If no burst is given it is one, so that messages are spaced evenly.
+func Parse(s string) (*Spec, error) {
+	params := strings.Split(s, ",")
+
+	parts := strings.SplitN(strings.TrimSpace(params[0]), "/", 2)
+	if len(parts) != 2 {
+		return nil, errors.Errorf("invalid rate limit %q, must be count/unit", params[0])
+	}
+
+	count, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil || math.IsNaN(count) || math.IsInf(count, 0) || count <= 0 {
+		return nil, errors.Errorf("invalid rate limit count, must be a positive number: %s", parts[0])
+	}
+
+	unit, ok := units[parts[1]]
+	if !ok {
+		return nil, errors.Errorf("invalid rate limit unit, must be s, m or h: %s", parts[1])
+	}
+
+	spec := &Spec{
+		Rate:  count / unit.Seconds(),
+		Burst: 1,
+	}
+
+	for _, param := range params[1:] {
+		kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(kv) != 2 {
+			return nil, errors.Errorf("invalid rate limit parameter %q, must be name=value", param)
+		}
+
+		switch kv[0] {
+		case "burst":
+			burst, err := strconv.Atoi(kv[1])
+			if err != nil || burst < 1 {
+				return nil, errors.Errorf("invalid rate limit burst, must be a positive integer: %s", kv[1])
+			}
+			spec.Burst = burst
+		default:
+			return nil, errors.Errorf("unknown rate limit parameter: %s", kv[0])
+		}
+	}
+
+	return spec, nil
+}
+
+// bucket is the token bucket of a single stream. tokens is the number of
+// tokens it held at last.
+type bucket struct {
+	spec   Spec
+	tokens float64
+	last   time.Time
+}
+
+// Limiter holds a token bucket for each stream being throttled. It is held in
+// memory, so after a restart each stream may again burst, and where devices are
+// partitioned between instances each limits only the streams it owns.
+type Limiter struct {
+	sync.Mutex
+
+	clock   clock.Clock
+	buckets map[string]*bucket
+	calls   int
+}
+
+// NewLimiter returns a new Limiter using the given clock.
+func NewLimiter(cl clock.Clock) *Limiter {
+	return &Limiter{
+		clock:   cl,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Allow returns true if a message for the stream with the given key may be
+// processed now under the given spec, taking a token from the stream's bucket
+// if so. A stream whose spec has changed starts again with a full bucket.
+func (l *Limiter) Allow(key string, spec *Spec) bool {
+	l.Lock()
+	defer l.Unlock()
+
+	now := l.clock.Now()
+
+	b, ok := l.buckets[key]
+	if !ok || b.spec != *spec {
+		b = &bucket{spec: *spec, tokens: float64(spec.Burst), last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = b.refilled(now)
+	b.last = now
+
+	l.calls++
+	if l.calls%sweepInterval == 0 {
+		l.sweep(now)
+	}
+
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+
+	return true
+}
+
+// refilled returns the number of tokens the bucket holds at the given time.
+func (b *bucket) refilled(now time.Time) float64 {
+	elapsed := now.Sub(b.last).Seconds()
+	if elapsed < 0 {
+		elapsed = 0
+	}
+
+	return math.Min(b.tokens+elapsed*b.spec.Rate, float64(b.spec.Burst))
+}
+
+// sweep deletes every bucket which has refilled, as it behaves exactly as a new
+// one would. It must be called with the lock held.
+func (l *Limiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if b.refilled(now) >= float64(b.spec.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package throttle_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/throttle"
+)
+
+func TestParse(t *testing.T) {
+	testcases := []struct {
+		input    string
+		expected *throttle.Spec
+	}{
+		{"2/s", &throttle.Spec{Rate: 2, Burst: 1}},
+		{"30/m", &throttle.Spec{Rate: 0.5, Burst: 1}},
+		{"0.5/s,burst=5", &throttle.Spec{Rate: 0.5, Burst: 5}},
+		{"3600/h,burst=10", &throttle.Spec{Rate: 1, Burst: 10}},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.input, func(t *testing.T) {
+			spec, err := throttle.Parse(tc.input)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, spec)
+		})
+	}
+
+	for _, input := range []string{
+		"",
+		"2",
+		"2/d",
+		"0/s",
+		"-1/s",
+		"x/s",
+		"2/s,burst=0",
+		"2/s,burst",
+		"2/s,size=2",
+	} {
+		t.Run(input, func(t *testing.T) {
+			_, err := throttle.Parse(input)
+			assert.NotNil(t, err)
+		})
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	mock := clock.NewMock(time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC))
+	limiter := throttle.NewLimiter(mock)
+
+	spec := &throttle.Spec{Rate: 1, Burst: 2}
+
+	// a full bucket allows a burst, after which messages are throttled until
+	// it refills
+	assert.True(t, limiter.Allow("a", spec))
+	assert.True(t, limiter.Allow("a", spec))
+	assert.False(t, limiter.Allow("a", spec))
+
+	// other streams have buckets of their own
+	assert.True(t, limiter.Allow("b", spec))
+
+	mock.Add(500 * time.Millisecond)
+	assert.False(t, limiter.Allow("a", spec))
+
+	mock.Add(500 * time.Millisecond)
+	assert.True(t, limiter.Allow("a", spec))
+	assert.False(t, limiter.Allow("a", spec))
+
+	// a bucket holds no more than its burst however long it is idle
+	mock.Add(time.Hour)
+	assert.True(t, limiter.Allow("a", spec))
+	assert.True(t, limiter.Allow("a", spec))
+	assert.False(t, limiter.Allow("a", spec))
+
+	// changing the spec of a stream starts again with a full bucket
+	assert.True(t, limiter.Allow("a", &throttle.Spec{Rate: 1, Burst: 1}))
+}
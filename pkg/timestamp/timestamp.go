@@ -0,0 +1,90 @@
+package timestamp
+
+import (
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// Device is the policy which trusts the timestamp sent by the device. It is
+	// the policy of streams created without one.
+	Device = "device"
+
+	// Server is the policy which replaces the timestamp sent by the device with
+	// the time at which the encoder received the message.
+	Server = "server"
+
+	// Reject is the policy which drops messages whose timestamp differs from the
+	// time at which the encoder received them by more than a maximum skew. It is
+	// written with the skew as a duration, e.g. "reject:10m".
+	Reject = "reject"
+)
+
+var (
+	// ErrSkewed is returned by Apply if a message is rejected because its
+	// timestamp differs too much from the time it was received.
+	ErrSkewed = errors.New("timestamp differs from receive time by more than the maximum skew")
+)
+
+// Policy is the policy with which the timestamps of a stream's readings are
+// handled, as devices' clocks are often wrong.
+type Policy struct {
+	Mode    string
+	MaxSkew time.Duration
+}
+
+// Parse parses a policy from its string form, which is "device", "server" or
+// "reject:<duration>". An empty string is parsed as the device policy.
+func Parse(s string) (*Policy, error) {
+	switch {
+	case s == "" || s == Device:
+		return &Policy{Mode: Device}, nil
+	case s == Server:
+		return &Policy{Mode: Server}, nil
+	case strings.HasPrefix(s, Reject+":"):
+		skew, err := time.ParseDuration(strings.TrimPrefix(s, Reject+":"))
+		if err != nil || skew <= 0 {
+			return nil, errors.Errorf("invalid maximum skew in timestamp policy: %s", s)
+		}
+		return &Policy{Mode: Reject, MaxSkew: skew}, nil
+	}
+
+	return nil, errors.Errorf("unknown timestamp policy: %s", s)
+}
+
+// Valid returns true if the given string is a policy Parse accepts.
+func Valid(s string) bool {
+	_, err := Parse(s)
+	return err == nil
+}
+
+// Apply returns the timestamp to record for a message sent by the device with
+// the given timestamp and received by us at the given time. It returns
+// ErrSkewed if the message should be rejected.
+func (p *Policy) Apply(recordedAt, receivedAt time.Time) (time.Time, error) {
+	switch p.Mode {
+	case Server:
+		return receivedAt, nil
+	case Reject:
+		skew := receivedAt.Sub(recordedAt)
+		if skew < 0 {
+			skew = -skew
+		}
+
+		if skew > p.MaxSkew {
+			return time.Time{}, ErrSkewed
+		}
+	}
+
+	return recordedAt, nil
+}
+
+// String returns the policy in the form accepted by Parse.
+func (p *Policy) String() string {
+	if p.Mode == Reject {
+		return Reject + ":" + p.MaxSkew.String()
+	}
+	return p.Mode
+}
@@ -0,0 +1,60 @@
+package timestamp_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/timestamp"
+)
+
+func TestParse(t *testing.T) {
+	testcases := []struct {
+		input    string
+		expected *timestamp.Policy
+	}{
+		{"", &timestamp.Policy{Mode: timestamp.Device}},
+		{"device", &timestamp.Policy{Mode: timestamp.Device}},
+		{"server", &timestamp.Policy{Mode: timestamp.Server}},
+		{"reject:10m", &timestamp.Policy{Mode: timestamp.Reject, MaxSkew: 10 * time.Minute}},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.input, func(t *testing.T) {
+			policy, err := timestamp.Parse(tc.input)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, policy)
+		})
+	}
+
+	for _, input := range []string{"client", "reject", "reject:", "reject:-1m", "reject:ten"} {
+		assert.False(t, timestamp.Valid(input), input)
+	}
+}
+
+func TestApply(t *testing.T) {
+	receivedAt := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	skewed := receivedAt.Add(-time.Hour)
+	near := receivedAt.Add(5 * time.Minute)
+
+	device, _ := timestamp.Parse("device")
+	recorded, err := device.Apply(skewed, receivedAt)
+	assert.Nil(t, err)
+	assert.Equal(t, skewed, recorded)
+
+	server, _ := timestamp.Parse("server")
+	recorded, err = server.Apply(skewed, receivedAt)
+	assert.Nil(t, err)
+	assert.Equal(t, receivedAt, recorded)
+
+	reject, _ := timestamp.Parse("reject:10m")
+	recorded, err = reject.Apply(near, receivedAt)
+	assert.Nil(t, err)
+	assert.Equal(t, near, recorded)
+
+	_, err = reject.Apply(skewed, receivedAt)
+	assert.Equal(t, timestamp.ErrSkewed, err)
+
+	assert.Equal(t, "reject:10m0s", reject.String())
+}
@@ -0,0 +1,268 @@
+package ttn
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/logger"
+	"github.com/DECODEproject/iotencoder/pkg/logger/level"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/secret"
+	"github.com/DECODEproject/iotencoder/pkg/version"
+)
+
+var (
+	// UplinksCounter is a prometheus counter recording a count of uplinks
+	// received from The Things Network, labelled by outcome.
+	UplinksCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "ttn_uplinks",
+			Help:      "Count of TTN uplinks received, labelled by outcome",
+		},
+		[]string{"outcome"},
+	)
+
+	// ErrUnknownDevice is returned when an uplink is received from a device for
+	// which no streams are registered.
+	ErrUnknownDevice = errors.New("unknown device")
+)
+
+// The outcomes with which we label uplinks.
+const (
+	outcomeProcessed = "processed"
+	outcomeInvalid   = "invalid"
+	outcomeUnknown   = "unknown_device"
+	outcomeFailed    = "failed"
+)
+
+// DeviceSource is the interface we require of a type able to load a device
+// along with its streams. It is satisfied by the postgres.DB type.
+type DeviceSource interface {
+	GetDevice(deviceToken secret.Secret) (*postgres.Device, error)
+}
+
+// Processor is the interface we call to process the readings of a device. It
+// is satisfied by the pipeline.Processor type.
+type Processor interface {
+	Process(ctx context.Context, device *postgres.Device, payload []byte) error
+}
+
+// Retainer is the interface we call to retain incoming payloads so that they
+// may later be replayed. It is satisfied by any retention.Store.
+type Retainer interface {
+	SaveRawPayload(deviceToken string, receivedAt time.Time, payload []byte) error
+}
+
+// Config is used to pass in configuration when creating a Client. Broker is
+// the address of the TTN MQTT server, Username the application id qualified
+// by its tenant (e.g. my-app@ttn) and Password an API key of the application.
+// Retainer is optional, and if nil payloads are not retained. If
+// MessageTimeout is non-zero it bounds the processing of each uplink.
+type Config struct {
+	Broker         string
+	Username       string
+	Password       secret.Secret
+	Devices        DeviceSource
+	Processor      Processor
+	Retainer       Retainer
+	Clock          clock.Clock
+	MessageTimeout time.Duration
+}
+
+// Client receives uplinks from LoRaWAN devices registered in an application on
+// The Things Network, so that they can be encoded without an external bridge.
+// It subscribes to the uplink topic of every device in the application, and
+// for each uplink extracts the readings from the application payload and
+// passes them through the processing pipeline exactly as if the device had
+// published them over MQTT. Devices are matched to their streams by EUI, so
+// streams for a LoRaWAN device are created with the device's EUI as the device
+// token.
+type Client struct {
+	broker    string
+	username  string
+	password  secret.Secret
+	devices   DeviceSource
+	processor Processor
+	retainer  Retainer
+	clock     clock.Clock
+	timeout   time.Duration
+	logger    kitlog.Logger
+
+	client mqtt.Client
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewClient returns a new Client configured with the given Config.
+func NewClient(config *Config, logger kitlog.Logger) *Client {
+	logger = kitlog.With(logger, "module", "ttn")
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Client{
+		broker:    config.Broker,
+		username:  config.Username,
+		password:  config.Password,
+		devices:   config.Devices,
+		processor: config.Processor,
+		retainer:  config.Retainer,
+		clock:     config.Clock,
+		timeout:   config.MessageTimeout,
+		logger:    logger,
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+}
+
+// Topic returns the topic on which uplinks from all devices in the application
+// identified by the given username are published.
+func Topic(username string) string {
+	return fmt.Sprintf("v3/%s/devices/+/up", username)
+}
+
+// Start connects to the TTN broker and subscribes to the application's uplink
+// topic. The subscription is renewed whenever the client reconnects.
+func (c *Client) Start() error {
+	c.logger.Log("msg", "starting ttn client", "broker", c.broker, "username", c.username)
+
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(c.broker)
+	opts.SetUsername(c.username)
+	opts.SetPassword(c.password.Reveal())
+	opts.SetClientID(fmt.Sprintf("%s-TTN", version.BinaryName))
+	opts.SetAutoReconnect(true)
+
+	opts.SetOnConnectHandler(func(client mqtt.Client) {
+		c.logger.Log("msg", "client connected", "broker", c.broker)
+
+		if token := client.Subscribe(Topic(c.username), 0, c.handleMessage); token.Wait() && token.Error() != nil {
+			level.Error(c.logger).Log("err", token.Error(), "msg", "failed to subscribe to uplinks")
+		}
+	})
+
+	opts.SetConnectionLostHandler(func(client mqtt.Client, err error) {
+		level.Error(c.logger).Log("err", err, "msg", "connection lost, reconnecting")
+	})
+
+	c.client = mqtt.NewClient(opts)
+
+	if token := c.client.Connect(); token.Wait() && token.Error() != nil {
+		return errors.Wrap(token.Error(), "failed to connect to ttn broker")
+	}
+
+	return nil
+}
+
+// Stop disconnects from the broker, and waits for any uplinks being processed
+// to finish.
+func (c *Client) Stop() error {
+	c.logger.Log("msg", "stopping ttn client")
+
+	c.cancel()
+
+	if c.client != nil {
+		c.client.Disconnect(500)
+	}
+
+	c.wg.Wait()
+
+	return nil
+}
+
+// handleMessage is the callback invoked by the MQTT client for every uplink.
+func (c *Client) handleMessage(client mqtt.Client, message mqtt.Message) {
+	if c.ctx.Err() != nil {
+		level.Warn(c.logger).Log("msg", "ttn client stopped, dropping uplink")
+		return
+	}
+
+	c.wg.Add(1)
+	defer c.wg.Done()
+
+	err := c.Handle(message.Payload())
+	if err != nil {
+		level.Error(c.logger).Log("err", err, "msg", "failed to handle uplink", "topic", message.Topic())
+	}
+}
+
+// Handle processes a single uplink message envelope, returning ErrUnknownDevice
+// if no streams are registered for the device which sent it.
+func (c *Client) Handle(data []byte) error {
+	uplink, err := ParseUplink(data)
+	if err != nil {
+		UplinksCounter.WithLabelValues(outcomeInvalid).Inc()
+		return err
+	}
+
+	recordedAt := uplink.RecordedAt()
+	if recordedAt.IsZero() {
+		recordedAt = c.clock.Now()
+	}
+
+	payload, err := uplink.Payload(recordedAt)
+	if err != nil {
+		UplinksCounter.WithLabelValues(outcomeInvalid).Inc()
+		return errors.Wrapf(err, "failed to decode uplink from %s", uplink.EndDeviceIDs.DeviceID)
+	}
+
+	token := secret.Secret(uplink.DeviceToken())
+
+	log := kitlog.With(c.logger, "device_hash", logger.HashToken(token))
+
+	device, err := c.devices.GetDevice(token)
+	if err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			UplinksCounter.WithLabelValues(outcomeUnknown).Inc()
+			return ErrUnknownDevice
+		}
+
+		UplinksCounter.WithLabelValues(outcomeFailed).Inc()
+		return errors.Wrap(err, "failed to get device")
+	}
+
+	// the pipeline and any replays consume payloads in the form published by
+	// SmartCitizen devices, so that is what we retain
+	b, err := json.Marshal(payload)
+	if err != nil {
+		UplinksCounter.WithLabelValues(outcomeFailed).Inc()
+		return errors.Wrap(err, "failed to marshal payload")
+	}
+
+	if c.retainer != nil {
+		err = c.retainer.SaveRawPayload(token.Reveal(), c.clock.Now(), b)
+		if err != nil {
+			level.Error(log).Log("err", err, "msg", "failed to retain payload")
+		}
+	}
+
+	ctx := c.ctx
+
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	err = c.processor.Process(ctx, device, b)
+	if err != nil {
+		UplinksCounter.WithLabelValues(outcomeFailed).Inc()
+		return errors.Wrap(err, "failed to process payload")
+	}
+
+	UplinksCounter.WithLabelValues(outcomeProcessed).Inc()
+
+	return nil
+}
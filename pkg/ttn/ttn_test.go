@@ -0,0 +1,85 @@
+package ttn_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/postgres/postgrestest"
+	"github.com/DECODEproject/iotencoder/pkg/ttn"
+)
+
+type processor struct {
+	payloads []string
+}
+
+func (p *processor) Process(ctx context.Context, device *postgres.Device, payload []byte) error {
+	p.payloads = append(p.payloads, device.DeviceToken.Reveal()+" "+string(payload))
+	return nil
+}
+
+type retainer struct {
+	payloads []string
+}
+
+func (r *retainer) SaveRawPayload(deviceToken string, receivedAt time.Time, payload []byte) error {
+	r.payloads = append(r.payloads, string(payload))
+	return nil
+}
+
+func TestHandle(t *testing.T) {
+	db := postgrestest.NewDB()
+
+	_, err := db.CreateStream(&postgres.Stream{
+		CommunityID: "community",
+		PublicKey:   "public",
+		Device: &postgres.Device{
+			DeviceToken: "70B3D57ED0000001",
+		},
+	})
+	assert.Nil(t, err)
+
+	p := &processor{}
+	r := &retainer{}
+
+	c := ttn.NewClient(&ttn.Config{
+		Devices:   db,
+		Processor: p,
+		Retainer:  r,
+		Clock:     clock.New(),
+	}, kitlog.NewNopLogger())
+
+	err = c.Handle([]byte(`{
+		"end_device_ids": {"device_id": "sensor-1", "dev_eui": "70b3d57ed0000001"},
+		"uplink_message": {
+			"frm_payload": "AAxBrAAA",
+			"received_at": "2019-06-01T12:00:00Z"
+		}
+	}`))
+	assert.Nil(t, err)
+
+	expected := `{"data":[{"recorded_at":"2019-06-01T12:00:00Z","sensors":[{"id":12,"value":21.5}]}]}`
+
+	assert.Equal(t, []string{"70B3D57ED0000001 " + expected}, p.payloads)
+	assert.Equal(t, []string{expected}, r.payloads)
+
+	err = c.Handle([]byte(`{
+		"end_device_ids": {"device_id": "sensor-2", "dev_eui": "70B3D57ED0000002"},
+		"uplink_message": {"frm_payload": "AAxBrAAA"}
+	}`))
+	assert.Equal(t, ttn.ErrUnknownDevice, err)
+
+	err = c.Handle([]byte(`not json`))
+	assert.NotNil(t, err)
+
+	assert.Len(t, p.payloads, 1)
+}
+
+func TestTopic(t *testing.T) {
+	assert.Equal(t, "v3/my-app@ttn/devices/+/up", ttn.Topic("my-app@ttn"))
+}
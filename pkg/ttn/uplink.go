@@ -0,0 +1,169 @@
+package ttn
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/DECODEproject/iotencoder/pkg/smartcitizen"
+)
+
+const (
+	// recordSize is the size in bytes of a single reading in a binary
+	// application payload: a big-endian uint16 sensor id followed by a
+	// big-endian IEEE 754 float32 value.
+	recordSize = 6
+)
+
+// Uplink is the subset of The Things Network's v3 uplink message envelope that
+// we require, as published to the application's MQTT topics.
+type Uplink struct {
+	EndDeviceIDs  EndDeviceIDs  `json:"end_device_ids"`
+	ReceivedAt    time.Time     `json:"received_at"`
+	UplinkMessage UplinkMessage `json:"uplink_message"`
+}
+
+// EndDeviceIDs identifies the device which sent an uplink.
+type EndDeviceIDs struct {
+	DeviceID string `json:"device_id"`
+	DevEUI   string `json:"dev_eui"`
+}
+
+// UplinkMessage contains the application payload of an uplink. FRMPayload is
+// the raw payload sent by the device, which the JSON envelope encodes as
+// base64, and DecodedPayload is the output of any payload formatter configured
+// in TTN.
+type UplinkMessage struct {
+	FPort          int                        `json:"f_port"`
+	FCnt           int                        `json:"f_cnt"`
+	FRMPayload     []byte                     `json:"frm_payload"`
+	DecodedPayload map[string]json.RawMessage `json:"decoded_payload"`
+	ReceivedAt     time.Time                  `json:"received_at"`
+}
+
+// ParseUplink parses an uplink message envelope, returning an error if it is
+// not valid JSON or does not identify the device by its EUI.
+func ParseUplink(data []byte) (*Uplink, error) {
+	var uplink Uplink
+
+	err := json.Unmarshal(data, &uplink)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal uplink")
+	}
+
+	if uplink.EndDeviceIDs.DevEUI == "" {
+		return nil, errors.New("uplink is missing dev_eui")
+	}
+
+	return &uplink, nil
+}
+
+// DeviceToken returns the token under which the device's streams are
+// registered, which is its EUI in the upper case hex form used by TTN.
+func (u *Uplink) DeviceToken() string {
+	return strings.ToUpper(u.EndDeviceIDs.DevEUI)
+}
+
+// RecordedAt returns the time at which the uplink was received by the network,
+// as LoRa devices rarely know the time themselves.
+func (u *Uplink) RecordedAt() time.Time {
+	if !u.UplinkMessage.ReceivedAt.IsZero() {
+		return u.UplinkMessage.ReceivedAt
+	}
+	return u.ReceivedAt
+}
+
+// Payload extracts the readings contained in the uplink, returning them in the
+// form published by SmartCitizen devices so they can be passed to the
+// processing pipeline. If a payload formatter has decoded the payload, each
+// field whose name is a sensor id is read as that sensor's value and other
+// fields are ignored. Otherwise the raw payload is decoded as a sequence of
+// six byte records, each a big-endian uint16 sensor id followed by a
+// big-endian float32 value.
+func (u *Uplink) Payload(recordedAt time.Time) (*smartcitizen.Payload, error) {
+	var (
+		sensors []smartcitizen.RawSensor
+		err     error
+	)
+
+	if len(u.UplinkMessage.DecodedPayload) > 0 {
+		sensors, err = decodeFields(u.UplinkMessage.DecodedPayload)
+	} else {
+		sensors, err = decodeRecords(u.UplinkMessage.FRMPayload)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sensors) == 0 {
+		return nil, errors.New("uplink contains no readings")
+	}
+
+	return &smartcitizen.Payload{
+		Data: []smartcitizen.SensorData{
+			{
+				RecordedAt: recordedAt,
+				Sensors:    sensors,
+			},
+		},
+	}, nil
+}
+
+// decodeFields reads sensor values from the fields of a decoded payload.
+func decodeFields(fields map[string]json.RawMessage) ([]smartcitizen.RawSensor, error) {
+	sensors := []smartcitizen.RawSensor{}
+
+	for name, raw := range fields {
+		id, err := strconv.ParseUint(name, 10, 16)
+		if err != nil {
+			continue
+		}
+
+		var value float64
+
+		err = json.Unmarshal(raw, &value)
+		if err != nil {
+			return nil, errors.Errorf("decoded payload field %s is not a number", name)
+		}
+
+		sensors = append(sensors, smartcitizen.RawSensor{ID: int(id), Value: value})
+	}
+
+	// fields are unordered so sort for consistent output
+	sort.Slice(sensors, func(i, j int) bool {
+		return sensors[i].ID < sensors[j].ID
+	})
+
+	return sensors, nil
+}
+
+// decodeRecords reads sensor values from a binary application payload.
+func decodeRecords(data []byte) ([]smartcitizen.RawSensor, error) {
+	if len(data)%recordSize != 0 {
+		return nil, errors.Errorf("payload length %d is not a multiple of %d", len(data), recordSize)
+	}
+
+	sensors := []smartcitizen.RawSensor{}
+
+	for i := 0; i < len(data); i += recordSize {
+		value := math.Float32frombits(binary.BigEndian.Uint32(data[i+2 : i+recordSize]))
+
+		if math.IsNaN(float64(value)) || math.IsInf(float64(value), 0) {
+			continue
+		}
+
+		sensors = append(sensors, smartcitizen.RawSensor{
+			ID:    int(binary.BigEndian.Uint16(data[i : i+2])),
+			Value: float64(value),
+		})
+	}
+
+	return sensors, nil
+}
@@ -0,0 +1,91 @@
+package ttn_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/smartcitizen"
+	"github.com/DECODEproject/iotencoder/pkg/ttn"
+)
+
+func TestParseUplink(t *testing.T) {
+	// frm_payload encodes sensor 12 = 21.5 and sensor 13 = 51.0
+	data := []byte(`{
+		"end_device_ids": {"device_id": "sensor-1", "dev_eui": "70b3d57ed0000001"},
+		"received_at": "2019-06-01T12:00:01Z",
+		"uplink_message": {
+			"f_port": 1,
+			"f_cnt": 42,
+			"frm_payload": "AAxBrAAAAA1CTAAA",
+			"received_at": "2019-06-01T12:00:00Z"
+		}
+	}`)
+
+	uplink, err := ttn.ParseUplink(data)
+	assert.Nil(t, err)
+	assert.Equal(t, "70B3D57ED0000001", uplink.DeviceToken())
+
+	recordedAt := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, recordedAt, uplink.RecordedAt())
+
+	payload, err := uplink.Payload(recordedAt)
+	assert.Nil(t, err)
+	assert.Equal(t, &smartcitizen.Payload{
+		Data: []smartcitizen.SensorData{
+			{
+				RecordedAt: recordedAt,
+				Sensors: []smartcitizen.RawSensor{
+					{ID: 12, Value: 21.5},
+					{ID: 13, Value: 51},
+				},
+			},
+		},
+	}, payload)
+}
+
+func TestUplinkDecodedPayload(t *testing.T) {
+	data := []byte(`{
+		"end_device_ids": {"device_id": "sensor-1", "dev_eui": "70B3D57ED0000001"},
+		"uplink_message": {
+			"frm_payload": "AAE=",
+			"decoded_payload": {"13": 51.4, "12": 21.5, "battery": "low"}
+		}
+	}`)
+
+	uplink, err := ttn.ParseUplink(data)
+	assert.Nil(t, err)
+
+	payload, err := uplink.Payload(time.Time{})
+	assert.Nil(t, err)
+	assert.Equal(t, []smartcitizen.RawSensor{
+		{ID: 12, Value: 21.5},
+		{ID: 13, Value: 51.4},
+	}, payload.Data[0].Sensors)
+}
+
+func TestUplinkInvalid(t *testing.T) {
+	testcases := []struct {
+		label string
+		data  string
+	}{
+		{"truncated record", `{"end_device_ids": {"dev_eui": "01"}, "uplink_message": {"frm_payload": "AAxBrAA="}}`},
+		{"empty payload", `{"end_device_ids": {"dev_eui": "01"}, "uplink_message": {}}`},
+		{"non numeric field", `{"end_device_ids": {"dev_eui": "01"}, "uplink_message": {"decoded_payload": {"12": "warm"}}}`},
+		{"no sensor fields", `{"end_device_ids": {"dev_eui": "01"}, "uplink_message": {"decoded_payload": {"battery": 3.3}}}`},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			uplink, err := ttn.ParseUplink([]byte(tc.data))
+			assert.Nil(t, err)
+
+			_, err = uplink.Payload(time.Time{})
+			assert.NotNil(t, err)
+		})
+	}
+
+	_, err := ttn.ParseUplink([]byte(`{"end_device_ids": {"device_id": "sensor-1"}}`))
+	assert.NotNil(t, err)
+}
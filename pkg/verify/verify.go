@@ -0,0 +1,301 @@
+// Package verify checks that what we write to the datastore can be read back
+// from it. A sample of successful writes is held for a while, after which the
+// datastore's read API is queried for the events of the write's community
+// around the time of the write, and the write is verified if one of them holds
+// exactly the ciphertext we wrote. A write which can't be found is reported as
+// a mismatch, which catches silent corruption by the datastore as well as data
+// written to the wrong community, e.g. by a misrouted policy.
+package verify
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	datastore "github.com/thingful/twirp-datastore-go"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/logger/level"
+)
+
+const (
+	// Verified is the result of a write which was read back unchanged.
+	Verified = "verified"
+
+	// Mismatch is the result of a write which could not be found in the
+	// datastore.
+	Mismatch = "mismatch"
+
+	// Failed is the result of a write which could not be verified as the
+	// datastore could not be read.
+	Failed = "failed"
+
+	// Skipped is the result of a sampled write which was not verified as too
+	// many writes were already waiting to be verified.
+	Skipped = "skipped"
+
+	// pageSize is the number of events read from the datastore at a time.
+	pageSize = 500
+
+	// maxPages is the maximum number of pages read while looking for a write,
+	// after which it is reported as a mismatch.
+	maxPages = 10
+
+	// readTimeout is the deadline applied to reading back each write.
+	readTimeout = 30 * time.Second
+)
+
+var (
+	// VerificationsCounter is a prometheus counter vec recording the number of
+	// sampled writes checked against the datastore, labelled by the result.
+	VerificationsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "write_verifications",
+			Help:      "Count of sampled datastore writes read back for verification, by result",
+		},
+		[]string{"result"},
+	)
+)
+
+// Datastore is the interface of the datastore clients from which we read back
+// writes. It is satisfied by the twirp datastore client.
+type Datastore interface {
+	ReadData(ctx context.Context, req *datastore.ReadRequest) (*datastore.ReadResponse, error)
+}
+
+// Config is used to pass in configuration when creating a Verifier. Rate is
+// the probability, between 0 and 1, with which each write is sampled. Delay is
+// the time after a write at which it is read back, allowing for replication
+// within the datastore, while Window is the tolerance either side of the time
+// of the write within which the datastore may have recorded it. Interval is
+// the interval at which writes which are due are verified, and MaxPending the
+// maximum number of sampled writes held for verification. Rand is optional,
+// and if nil writes are sampled using a source seeded from the current time.
+type Config struct {
+	Rate       float64
+	Delay      time.Duration
+	Window     time.Duration
+	Interval   time.Duration
+	MaxPending int
+	Clock      clock.Clock
+	Rand       func() float64
+}
+
+// write is a sampled write waiting to be verified.
+type write struct {
+	ds          Datastore
+	streamID    string
+	communityID string
+	data        []byte
+	writtenAt   time.Time
+}
+
+// Verifier samples writes to the datastore and periodically reads them back to
+// verify them. Sampled writes are held in memory, so those waiting to be
+// verified when the encoder is stopped are not verified.
+type Verifier struct {
+	config *Config
+	logger kitlog.Logger
+	quit   chan struct{}
+	wg     sync.WaitGroup
+
+	sync.Mutex
+	rand    func() float64
+	pending []*write
+}
+
+// NewVerifier returns a new Verifier configured with the given Config.
+func NewVerifier(config *Config, logger kitlog.Logger) *Verifier {
+	r := config.Rand
+	if r == nil {
+		r = rand.New(rand.NewSource(time.Now().UnixNano())).Float64
+	}
+
+	return &Verifier{
+		config: config,
+		logger: kitlog.With(logger, "module", "verify"),
+		rand:   r,
+	}
+}
+
+// Start starts a goroutine which verifies the writes which are due on each tick
+// of our interval.
+func (v *Verifier) Start() error {
+	v.logger.Log("msg", "starting write verifier", "rate", v.config.Rate, "delay", v.config.Delay, "interval", v.config.Interval)
+
+	if v.config.Rate < 0 || v.config.Rate > 1 {
+		return errors.New("write verification rate must be between 0 and 1")
+	}
+
+	if v.config.Interval <= 0 {
+		return errors.New("write verification interval must be positive")
+	}
+
+	v.quit = make(chan struct{})
+	v.wg.Add(1)
+
+	go v.loop()
+
+	return nil
+}
+
+// Stop stops the verification goroutine.
+func (v *Verifier) Stop() error {
+	if v.quit == nil {
+		return nil
+	}
+
+	v.logger.Log("msg", "stopping write verifier")
+
+	close(v.quit)
+	v.wg.Wait()
+
+	return nil
+}
+
+// Sample records a successful write of the given data for a stream of the given
+// community to the given datastore, holding it for verification if it is
+// sampled. A sampled write is skipped if too many are already waiting.
+func (v *Verifier) Sample(ds Datastore, streamID, communityID string, data []byte) {
+	if v.config.Rate <= 0 {
+		return
+	}
+
+	v.Lock()
+	defer v.Unlock()
+
+	if v.rand() >= v.config.Rate {
+		return
+	}
+
+	if v.config.MaxPending > 0 && len(v.pending) >= v.config.MaxPending {
+		VerificationsCounter.WithLabelValues(Skipped).Inc()
+		return
+	}
+
+	v.pending = append(v.pending, &write{
+		ds:          ds,
+		streamID:    streamID,
+		communityID: communityID,
+		data:        data,
+		writtenAt:   v.config.Clock.Now(),
+	})
+}
+
+// Verify reads back every sampled write which is due, returning the number of
+// writes which could not be found.
+func (v *Verifier) Verify(ctx context.Context) int {
+	due := v.due()
+
+	mismatches := 0
+
+	for _, w := range due {
+		log := kitlog.With(v.logger, "stream_uid", w.streamID, "community_id", w.communityID, "written_at", w.writtenAt)
+
+		found, err := v.find(ctx, w)
+		if err != nil {
+			VerificationsCounter.WithLabelValues(Failed).Inc()
+			level.Warn(log).Log("err", err, "msg", "failed to read back write")
+			continue
+		}
+
+		if !found {
+			mismatches++
+			VerificationsCounter.WithLabelValues(Mismatch).Inc()
+			level.Error(log).Log("bytes", len(w.data), "msg", "write not found in datastore")
+			continue
+		}
+
+		VerificationsCounter.WithLabelValues(Verified).Inc()
+	}
+
+	return mismatches
+}
+
+// due removes and returns the sampled writes which are due to be verified, in
+// the order written.
+func (v *Verifier) due() []*write {
+	v.Lock()
+	defer v.Unlock()
+
+	cutoff := v.config.Clock.Now().Add(-v.config.Delay)
+
+	i := 0
+	for i < len(v.pending) && !v.pending[i].writtenAt.After(cutoff) {
+		i++
+	}
+
+	due := v.pending[:i]
+	v.pending = append([]*write{}, v.pending[i:]...)
+
+	return due
+}
+
+// find returns true if an event holding exactly the data of the given write
+// was recorded for its community within our window either side of the write.
+func (v *Verifier) find(ctx context.Context, w *write) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, readTimeout)
+	defer cancel()
+
+	req := &datastore.ReadRequest{
+		CommunityId: w.communityID,
+		StartTime:   toTimestamp(w.writtenAt.Add(-v.config.Window)),
+		EndTime:     toTimestamp(w.writtenAt.Add(v.config.Window)),
+		PageSize:    pageSize,
+	}
+
+	for page := 0; page < maxPages; page++ {
+		resp, err := w.ds.ReadData(ctx, req)
+		if err != nil {
+			return false, err
+		}
+
+		for _, event := range resp.Events {
+			if bytes.Equal(event.Data, w.data) {
+				return true, nil
+			}
+		}
+
+		if resp.NextPageCursor == "" {
+			return false, nil
+		}
+
+		req.PageCursor = resp.NextPageCursor
+	}
+
+	return false, nil
+}
+
+// loop is run in a goroutine and verifies the writes which are due on each
+// tick of our interval until the verifier is stopped.
+func (v *Verifier) loop() {
+	defer v.wg.Done()
+
+	ticker := time.NewTicker(v.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			v.Verify(context.Background())
+		case <-v.quit:
+			return
+		}
+	}
+}
+
+// toTimestamp converts the given time into a protobuf timestamp.
+func toTimestamp(t time.Time) *timestamp.Timestamp {
+	return &timestamp.Timestamp{
+		Seconds: t.Unix(),
+		Nanos:   int32(t.Nanosecond()),
+	}
+}
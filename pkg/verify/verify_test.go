@@ -0,0 +1,101 @@
+package verify_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	datastore "github.com/thingful/twirp-datastore-go"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/mocks"
+	"github.com/DECODEproject/iotencoder/pkg/verify"
+)
+
+func newVerifier(cl clock.Clock, rate float64) *verify.Verifier {
+	return verify.NewVerifier(&verify.Config{
+		Rate:       rate,
+		Delay:      time.Minute,
+		Window:     5 * time.Minute,
+		Interval:   time.Second,
+		MaxPending: 2,
+		Clock:      cl,
+		Rand:       func() float64 { return 0.5 },
+	}, kitlog.NewNopLogger())
+}
+
+func TestVerify(t *testing.T) {
+	cl := clock.NewMock(time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC))
+
+	ds := &mocks.Datastore{}
+	ds.On("ReadData", mock.Anything, mock.MatchedBy(func(req *datastore.ReadRequest) bool {
+		return req.CommunityId == "smartcitizen" && req.PageCursor == ""
+	})).Return(&datastore.ReadResponse{
+		Events:         []*datastore.EncryptedEvent{{Data: []byte("other")}},
+		NextPageCursor: "next",
+	}, nil)
+	ds.On("ReadData", mock.Anything, mock.MatchedBy(func(req *datastore.ReadRequest) bool {
+		return req.CommunityId == "smartcitizen" && req.PageCursor == "next"
+	})).Return(&datastore.ReadResponse{
+		Events: []*datastore.EncryptedEvent{{Data: []byte("written")}},
+	}, nil)
+	ds.On("ReadData", mock.Anything, mock.MatchedBy(func(req *datastore.ReadRequest) bool {
+		return req.CommunityId == "misrouted"
+	})).Return(&datastore.ReadResponse{}, nil)
+
+	verifier := newVerifier(cl, 1)
+
+	verifier.Sample(ds, "abc", "smartcitizen", []byte("written"))
+	verifier.Sample(ds, "def", "misrouted", []byte("written"))
+
+	// the pending limit is reached, so this write is skipped
+	verifier.Sample(ds, "ghi", "smartcitizen", []byte("written"))
+
+	// nothing is read back until the delay has passed
+	assert.Equal(t, 0, verifier.Verify(context.Background()))
+	ds.AssertNotCalled(t, "ReadData", mock.Anything, mock.Anything)
+
+	cl.Add(time.Minute)
+
+	assert.Equal(t, 1, verifier.Verify(context.Background()))
+	ds.AssertNumberOfCalls(t, "ReadData", 3)
+
+	// verified writes are forgotten
+	assert.Equal(t, 0, verifier.Verify(context.Background()))
+	ds.AssertNumberOfCalls(t, "ReadData", 3)
+}
+
+func TestVerifyReadFailure(t *testing.T) {
+	cl := clock.NewMock(time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC))
+
+	ds := &mocks.Datastore{}
+	ds.On("ReadData", mock.Anything, mock.Anything).Return(&datastore.ReadResponse{}, errors.New("unavailable"))
+
+	verifier := newVerifier(cl, 1)
+	verifier.Sample(ds, "abc", "smartcitizen", []byte("written"))
+
+	cl.Add(time.Minute)
+
+	// a write which couldn't be read back is not a mismatch
+	assert.Equal(t, 0, verifier.Verify(context.Background()))
+	ds.AssertNumberOfCalls(t, "ReadData", 1)
+}
+
+func TestSampleRate(t *testing.T) {
+	cl := clock.NewMock(time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC))
+
+	ds := &mocks.Datastore{}
+
+	// writes are sampled with a probability below the rate only
+	verifier := newVerifier(cl, 0.5)
+	verifier.Sample(ds, "abc", "smartcitizen", []byte("written"))
+
+	cl.Add(time.Minute)
+
+	assert.Equal(t, 0, verifier.Verify(context.Background()))
+	ds.AssertNotCalled(t, "ReadData", mock.Anything, mock.Anything)
+}
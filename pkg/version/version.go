@@ -21,6 +21,32 @@ var Version = unknown
 // was built. This value should be substituted for a real value during build.
 var BuildDate = unknown
 
+// GitCommit is an exported variable containing the git commit from which the
+// binary was built. This value should be substituted for a real value during
+// build.
+var GitCommit = unknown
+
+// Info is a type describing the current build, used when exposing the build
+// via our version endpoint.
+type Info struct {
+	BinaryName string `json:"binary_name"`
+	Version    string `json:"version"`
+	GitCommit  string `json:"git_commit"`
+	BuildDate  string `json:"build_date"`
+	GoVersion  string `json:"go_version"`
+}
+
+// GetInfo returns an Info instance describing the current build.
+func GetInfo() *Info {
+	return &Info{
+		BinaryName: BinaryName,
+		Version:    Version,
+		GitCommit:  GitCommit,
+		BuildDate:  BuildDate,
+		GoVersion:  runtime.Version(),
+	}
+}
+
 // VersionString returns a formatted version string suitable for displaying to
 // the user. This is a verbose version string including build date.
 func VersionString() string {
@@ -1,6 +1,7 @@
 package version_test
 
 import (
+	"runtime"
 	"testing"
 
 	"github.com/DECODEproject/iotencoder/pkg/version"
@@ -14,3 +15,15 @@ func TestVersionString(t *testing.T) {
 		t.Errorf("Unexpected value, expected '%s', got '%s'", expected, got)
 	}
 }
+
+func TestGetInfo(t *testing.T) {
+	info := version.GetInfo()
+
+	if info.GitCommit != "UNKNOWN" {
+		t.Errorf("Unexpected git commit, expected 'UNKNOWN', got '%s'", info.GitCommit)
+	}
+
+	if info.GoVersion != runtime.Version() {
+		t.Errorf("Unexpected go version, expected '%s', got '%s'", runtime.Version(), info.GoVersion)
+	}
+}